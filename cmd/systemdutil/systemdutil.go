@@ -19,7 +19,6 @@ import (
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/rpc"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/rpc/events"
-	"github.com/aporeto-inc/trireme-lib/utils/portspec"
 )
 
 const (
@@ -131,7 +130,7 @@ func NewCustomRequestProcessor(linux, host string) *RequestProcessor {
 //
 // Run Client Options:
 // 	--service-name=<sname>              Service name for the executed command [default ].
-// 	--ports=<ports>                     Ports that the executed service is listening to [default ].
+// 	--ports=<ports>                     Ports or service names, like https or udp:53, that the executed service is listening to [default ].
 // 	--label=<keyvalue>                  Label (key/value pair) attached to the service [default ].
 // 	--networkonly                       Control traffic from the network only and not from applications [default false].
 // 	--hostpolicy                        Default control of the base namespace [default false].
@@ -361,18 +360,16 @@ func ParseServices(ports []string) ([]policy.Service, error) {
 		ports = append(ports, "0")
 	}
 
-	// Parse the ports and create the services. Cleanup any bad ports
+	// Parse the ports and create the services. Cleanup any bad ports. The
+	// services can be given by name, like https.
 	services := []policy.Service{}
 	for _, p := range ports {
-		s, err := portspec.NewPortSpecFromString(p, nil)
+		s, err := policy.ParseService(p)
 		if err != nil {
 			return nil, fmt.Errorf("Invalid port spec: %s ", err)
 		}
 
-		services = append(services, policy.Service{
-			Protocol: uint8(6),
-			Ports:    s,
-		})
+		services = append(services, s)
 	}
 
 	return services, nil
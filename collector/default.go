@@ -16,6 +16,24 @@ func (d *DefaultCollector) CollectFlowEvent(record *FlowRecord) {}
 // CollectContainerEvent is part of the EventCollector interface.
 func (d *DefaultCollector) CollectContainerEvent(record *ContainerRecord) {}
 
+// CollectConnectionMetrics is part of the ConnectionMetricsCollector interface.
+func (d *DefaultCollector) CollectConnectionMetrics(record *ConnectionMetricsRecord) {}
+
+// CollectUserEvent is part of the UserEventCollector interface.
+func (d *DefaultCollector) CollectUserEvent(record *UserRecord) {}
+
+// CollectPacketEvent is part of the PacketEventCollector interface.
+func (d *DefaultCollector) CollectPacketEvent(record *PacketRecord) {}
+
+// CollectQueueStats is part of the QueueStatsCollector interface.
+func (d *DefaultCollector) CollectQueueStats(record *QueueStatsRecord) {}
+
+// CollectCacheStats is part of the CacheStatsCollector interface.
+func (d *DefaultCollector) CollectCacheStats(record *CacheStatsRecord) {}
+
+// CollectEnforcerStats is part of the EnforcerStatsCollector interface.
+func (d *DefaultCollector) CollectEnforcerStats(record *EnforcerStatsRecord) {}
+
 // StatsFlowHash is a has function to hash flows
 func StatsFlowHash(r *FlowRecord) string {
 	return r.Source.ID + ":" + r.Destination.ID + ":" + strconv.Itoa(int(r.Destination.Port)) + ":" + r.Action.String() + ":" + r.DropReason
@@ -0,0 +1,200 @@
+package export
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Encoding is the encoding of the published events
+type Encoding int
+
+// Encodings
+const (
+	// JSON encodes the events as the JSON of collector.Event
+	JSON Encoding = iota
+	// Protobuf encodes the events as the Event message of flows.proto
+	Protobuf
+)
+
+// encode returns the encoding of an event
+func (e Encoding) encode(event *collector.Event) ([]byte, error) {
+
+	if e == Protobuf {
+		return encodeProtoEvent(event), nil
+	}
+
+	return json.Marshal(event)
+}
+
+// protoBuffer appends the fields of a protobuf message. The fields with the
+// default value of their type are omitted, as in proto3.
+type protoBuffer struct {
+	b []byte
+}
+
+func (p *protoBuffer) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	p.b = append(p.b, buf[:n]...)
+}
+
+func (p *protoBuffer) key(field int, wireType int) {
+	p.varint(uint64(field<<3 | wireType))
+}
+
+func (p *protoBuffer) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.key(field, 0)
+	p.varint(v)
+}
+
+func (p *protoBuffer) int(field int, v int64) {
+	p.uint(field, uint64(v))
+}
+
+func (p *protoBuffer) bytes(field int, v []byte) {
+	p.key(field, 2)
+	p.varint(uint64(len(v)))
+	p.b = append(p.b, v...)
+}
+
+func (p *protoBuffer) string(field int, v string) {
+	if v == "" {
+		return
+	}
+	p.bytes(field, []byte(v))
+}
+
+func (p *protoBuffer) strings(field int, v []string) {
+	for _, s := range v {
+		p.bytes(field, []byte(s))
+	}
+}
+
+func (p *protoBuffer) message(field int, m *protoBuffer) {
+	if m == nil {
+		return
+	}
+	p.bytes(field, m.b)
+}
+
+// stringMap appends a map field, sorted by key so that the encoding is stable
+func (p *protoBuffer) stringMap(field int, m map[string]string) {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		entry := &protoBuffer{}
+		entry.bytes(1, []byte(k))
+		entry.bytes(2, []byte(m[k]))
+		p.message(field, entry)
+	}
+}
+
+func protoEndPoint(e *collector.EndPoint) *protoBuffer {
+
+	if e == nil {
+		return nil
+	}
+
+	p := &protoBuffer{}
+	p.string(1, e.ID)
+	p.string(2, e.IP)
+	p.uint(3, uint64(e.Port))
+	p.uint(4, uint64(e.Type))
+	p.strings(5, protoTags(e.Tags))
+
+	return p
+}
+
+func protoTags(tags *policy.TagStore) []string {
+
+	if tags == nil {
+		return nil
+	}
+
+	return tags.GetSlice()
+}
+
+func protoFlowLatency(l *collector.FlowLatency) *protoBuffer {
+
+	if l == nil {
+		return nil
+	}
+
+	p := &protoBuffer{}
+	p.int(1, int64(l.Handshake/time.Microsecond))
+	p.int(2, int64(l.RoundTrip/time.Microsecond))
+	p.int(3, int64(l.Authorization/time.Microsecond))
+
+	return p
+}
+
+func protoFlowRecord(r *collector.FlowRecord) *protoBuffer {
+
+	if r == nil {
+		return nil
+	}
+
+	p := &protoBuffer{}
+	p.string(1, r.ContextID)
+	p.int(2, int64(r.Count))
+	p.message(3, protoEndPoint(r.Source))
+	p.message(4, protoEndPoint(r.Destination))
+	p.strings(5, protoTags(r.Tags))
+	p.uint(6, uint64(r.Action))
+	p.uint(7, uint64(r.ObservedAction))
+	p.string(8, r.DropReason)
+	p.string(9, r.PolicyID)
+	p.string(10, r.ObservedPolicyID)
+	p.stringMap(11, r.PeerClaims)
+	if r.Accounting != nil {
+		p.uint(12, r.Accounting.BytesSent)
+		p.uint(13, r.Accounting.PacketsSent)
+		p.uint(14, r.Accounting.BytesReceived)
+		p.uint(15, r.Accounting.PacketsReceived)
+	}
+	p.string(16, r.ServiceID)
+	p.message(17, protoFlowLatency(r.Latency))
+
+	return p
+}
+
+func protoContainerRecord(r *collector.ContainerRecord) *protoBuffer {
+
+	if r == nil {
+		return nil
+	}
+
+	p := &protoBuffer{}
+	p.string(1, r.ContextID)
+	p.stringMap(2, r.IPAddress)
+	p.strings(3, protoTags(r.Tags))
+	p.string(4, r.Event)
+	p.string(5, r.Backend)
+
+	return p
+}
+
+// encodeProtoEvent returns the Event message of an event
+func encodeProtoEvent(event *collector.Event) []byte {
+
+	p := &protoBuffer{}
+	p.string(1, event.Type)
+	p.int(2, event.Time.UnixNano()/int64(time.Millisecond))
+	p.message(3, protoFlowRecord(event.Flow))
+	p.message(4, protoContainerRecord(event.Container))
+
+	return p.b
+}
@@ -0,0 +1,349 @@
+package export
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakePublisher records the published messages, and fails the first failures
+// publications
+type fakePublisher struct {
+	failures  int
+	attempts  int
+	published map[string][][]Message
+	closed    bool
+	sync.Mutex
+}
+
+func (p *fakePublisher) Publish(topic string, messages []Message) error {
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.attempts++
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("unavailable")
+	}
+
+	p.published[topic] = append(p.published[topic], append([]Message{}, messages...))
+
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func testFlowRecord() *collector.FlowRecord {
+	return &collector.FlowRecord{
+		ContextID:   "pu1",
+		Count:       1,
+		Source:      &collector.EndPoint{ID: "A", IP: "1.1.1.1", Type: collector.Address},
+		Destination: &collector.EndPoint{ID: "pu1", IP: "2.2.2.2", Port: 80, Type: collector.PU},
+		Action:      policy.Accept,
+		PolicyID:    "p1",
+	}
+}
+
+func TestExporter(t *testing.T) {
+	Convey("Given an exporter with a publisher that fails once", t, func() {
+		publisher := &fakePublisher{failures: 1, published: map[string][][]Message{}}
+		e := NewExporter(publisher, OptionBatch(2, time.Hour), OptionRetry(1, time.Millisecond))
+
+		Convey("When I collect records, they should be published in batches to their topics", func() {
+			e.CollectFlowEvent(testFlowRecord())
+			e.CollectContainerEvent(&collector.ContainerRecord{ContextID: "pu1", Event: collector.ContainerStart})
+			e.CollectFlowEvent(testFlowRecord())
+			So(e.Close(), ShouldBeNil)
+			e.CollectFlowEvent(testFlowRecord())
+
+			So(publisher.closed, ShouldBeTrue)
+			So(publisher.attempts, ShouldEqual, 4)
+			So(e.Dropped(), ShouldEqual, 1)
+			So(len(publisher.published[DefaultFlowTopic]), ShouldEqual, 2)
+			So(len(publisher.published[DefaultContainerTopic]), ShouldEqual, 1)
+
+			m := publisher.published[DefaultFlowTopic][0][0]
+			So(string(m.Key), ShouldEqual, "pu1")
+			event := &collector.Event{}
+			So(json.Unmarshal(m.Value, event), ShouldBeNil)
+			So(event.Type, ShouldEqual, collector.EventTypeFlow)
+			So(event.Flow.PolicyID, ShouldEqual, "p1")
+		})
+	})
+
+	Convey("Given an exporter with a publisher that always fails", t, func() {
+		publisher := &fakePublisher{failures: 10, published: map[string][][]Message{}}
+		e := NewExporter(publisher, OptionRetry(2, time.Millisecond))
+
+		Convey("When I collect records, they should be dropped after the retries", func() {
+			e.CollectFlowEvent(testFlowRecord())
+			So(e.Close(), ShouldBeNil)
+
+			So(publisher.attempts, ShouldEqual, 3)
+			So(e.Dropped(), ShouldEqual, 1)
+		})
+	})
+}
+
+// protoFields returns the fields of a protobuf message by number. The varints
+// are returned as their decimal string.
+func protoFields(b []byte) map[int][]string {
+
+	fields := map[int][]string{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			b = b[n:]
+			fields[int(key>>3)] = append(fields[int(key>>3)], strconv.FormatUint(v, 10))
+		case 2:
+			l, n := binary.Uvarint(b)
+			b = b[n:]
+			fields[int(key>>3)] = append(fields[int(key>>3)], string(b[:l]))
+			b = b[l:]
+		default:
+			panic("unexpected wire type")
+		}
+	}
+
+	return fields
+}
+
+func TestProtobufEncoding(t *testing.T) {
+	Convey("Given a flow event", t, func() {
+		record := testFlowRecord()
+		record.PeerClaims = map[string]string{"b": "2", "a": "1"}
+		record.Accounting = &collector.FlowAccounting{BytesSent: 300}
+		event := &collector.Event{Type: collector.EventTypeFlow, Time: time.Unix(10, 0), Flow: record}
+
+		Convey("When I encode it in protobuf, it should be the Event message", func() {
+			b, err := Protobuf.encode(event)
+			So(err, ShouldBeNil)
+
+			fields := protoFields(b)
+			So(fields[1], ShouldResemble, []string{"flow"})
+			So(fields[2], ShouldResemble, []string{"10000"})
+			So(fields[4], ShouldBeNil)
+
+			flow := protoFields([]byte(fields[3][0]))
+			So(flow[1], ShouldResemble, []string{"pu1"})
+			So(flow[6], ShouldResemble, []string{strconv.Itoa(int(policy.Accept))})
+			So(flow[9], ShouldResemble, []string{"p1"})
+			So(flow[12], ShouldResemble, []string{"300"})
+			So(len(flow[11]), ShouldEqual, 2)
+			So(protoFields([]byte(flow[11][0])), ShouldResemble, map[int][]string{1: {"a"}, 2: {"1"}})
+
+			destination := protoFields([]byte(flow[4][0]))
+			So(destination[3], ShouldResemble, []string{"80"})
+			So(destination[4], ShouldResemble, []string{"1"})
+		})
+	})
+}
+
+// fakeNATSServer serves a NATS connection on listener, over TLS if config is
+// set, and sends the commands it receives
+func fakeNATSServer(listener net.Listener, config *tls.Config) chan string {
+
+	commands := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close() // nolint
+
+		if config == nil {
+			conn.Write([]byte("INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")) // nolint
+		} else {
+			conn.Write([]byte("INFO {\"server_id\":\"test\",\"max_payload\":1048576,\"tls_required\":true}\r\n")) // nolint
+			conn = tls.Server(conn, config)
+		}
+
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(commands)
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "PING":
+				conn.Write([]byte("PONG\r\n")) // nolint
+			case strings.HasPrefix(line, "PUB "):
+				payload, _ := reader.ReadString('\n') // nolint
+				commands <- line + " " + strings.TrimSpace(payload)
+			case strings.HasPrefix(line, "CONNECT "):
+				commands <- line
+			}
+		}
+	}()
+
+	return commands
+}
+
+// natsCertificate returns a self signed certificate for 127.0.0.1 and the
+// pool of certificate authorities that trusts it
+func natsCertificate() (tls.Certificate, *x509.CertPool, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool, nil
+}
+
+func TestNATSPublisher(t *testing.T) {
+	Convey("Given a NATS server", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close() // nolint
+
+		commands := fakeNATSServer(listener, nil)
+
+		Convey("When I publish messages, they should be sent to the subject", func() {
+			p, err := NewNATSPublisher(listener.Addr().String(), OptionNATSUser("user", "password"))
+			So(err, ShouldBeNil)
+
+			So(p.Publish("flows", []Message{{Value: []byte("a")}, {Value: []byte("bc")}}), ShouldBeNil)
+			So(p.Close(), ShouldBeNil)
+
+			connect := <-commands
+			So(connect, ShouldContainSubstring, `"user":"user"`)
+			So(connect, ShouldContainSubstring, `"pass":"password"`)
+			So(<-commands, ShouldEqual, "PUB flows 1 a")
+			So(<-commands, ShouldEqual, "PUB flows 2 bc")
+		})
+
+		Convey("When I require TLS, the connection should fail", func() {
+			_, err := NewNATSPublisher(listener.Addr().String(), OptionNATSTLS(&tls.Config{}))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a NATS server that requires TLS", t, func() {
+		cert, pool, err := natsCertificate()
+		So(err, ShouldBeNil)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close() // nolint
+
+		commands := fakeNATSServer(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+		Convey("When I publish messages over TLS, they should be sent to the subject", func() {
+			p, err := NewNATSPublisher(listener.Addr().String(), OptionNATSTLS(&tls.Config{RootCAs: pool}), OptionNATSToken("secret"))
+			So(err, ShouldBeNil)
+
+			So(p.Publish("flows", []Message{{Value: []byte("a")}}), ShouldBeNil)
+			So(p.Close(), ShouldBeNil)
+
+			connect := <-commands
+			So(connect, ShouldContainSubstring, `"auth_token":"secret"`)
+			So(connect, ShouldContainSubstring, `"tls_required":true`)
+			So(<-commands, ShouldEqual, "PUB flows 1 a")
+		})
+
+		Convey("When the certificate of the server is not trusted, the connection should fail", func() {
+			_, err := NewNATSPublisher(listener.Addr().String())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	Convey("When I create a Kafka publisher without brokers, I should get an error", t, func() {
+		_, err := NewKafkaPublisher(nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given a Kafka publisher with options", t, func() {
+		p, err := NewKafkaPublisher([]string{"127.0.0.1:9092"}, OptionKafkaClientID("enforcer"), OptionKafkaUser("user", "password"), OptionKafkaAcks(kafka.RequireOne))
+		So(err, ShouldBeNil)
+
+		writer := p.(*kafkaPublisher).writer
+		transport := writer.Transport.(*kafka.Transport)
+
+		Convey("Then the writer should be configured with them", func() {
+			So(writer.Addr.String(), ShouldEqual, "127.0.0.1:9092")
+			So(writer.RequiredAcks, ShouldEqual, kafka.RequireOne)
+			So(writer.MaxAttempts, ShouldEqual, 1)
+			So(transport.ClientID, ShouldEqual, "enforcer")
+			So(transport.SASL, ShouldResemble, plain.Mechanism{Username: "user", Password: "password"})
+			So(p.Close(), ShouldBeNil)
+		})
+
+		Convey("Then the messages should be published to their topic with their keys", func() {
+			msgs := kafkaMessages("flows", []Message{{Key: []byte("pu1"), Value: []byte("a")}})
+			So(len(msgs), ShouldEqual, 1)
+			So(msgs[0].Topic, ShouldEqual, "flows")
+			So(string(msgs[0].Key), ShouldEqual, "pu1")
+			So(string(msgs[0].Value), ShouldEqual, "a")
+		})
+	})
+
+	Convey("Given a Kafka publisher to a broker that is not running", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		address := listener.Addr().String()
+		listener.Close() // nolint
+
+		p, err := NewKafkaPublisher([]string{address})
+		So(err, ShouldBeNil)
+
+		Convey("When I publish messages, I should get an error", func() {
+			So(p.Publish("flows", []Message{{Value: []byte("a")}}), ShouldNotBeNil)
+			So(p.Close(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,285 @@
+// Package export publishes the flow and the container records of the
+// collectors to message brokers, like Kafka or NATS, so that the flow
+// telemetry can feed SIEM pipelines. Other brokers are supported by
+// implementing the Publisher interface.
+package export
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// Defaults of the exporter
+const (
+	DefaultFlowTopic      = "trireme.flows"
+	DefaultContainerTopic = "trireme.containers"
+	DefaultQueueSize      = 10000
+	DefaultBatchSize      = 500
+	DefaultFlushInterval  = time.Second
+	DefaultRetries        = 3
+	DefaultRetryBackoff   = 500 * time.Millisecond
+)
+
+// Message is a message published to a topic
+type Message struct {
+	// Key is the key of the message, the context ID of its PU. It selects the
+	// partition of the message if the broker has partitions.
+	Key   []byte
+	Value []byte
+}
+
+// Publisher publishes batches of messages to the topics of a broker
+type Publisher interface {
+	// Publish publishes messages to a topic. The messages are published when
+	// it returns without error.
+	Publish(topic string, messages []Message) error
+	// Close closes the connections to the broker
+	Close() error
+}
+
+// message is a queued message with its topic
+type message struct {
+	topic string
+	Message
+}
+
+// Exporter is a collector.EventCollector that publishes the flow and the
+// container records to the topics of a broker. The records are encoded when
+// they are collected, and are published in batches in the background, with
+// retries, so that the broker does not slow down the enforcer: the records are
+// dropped when the queue is full or when the retries are exhausted. The other
+// records are ignored.
+type Exporter struct {
+	publisher      Publisher
+	encoding       Encoding
+	flowTopic      string
+	containerTopic string
+	queue          chan *message
+	batchSize      int
+	flushInterval  time.Duration
+	retries        int
+	retryBackoff   time.Duration
+	dropped        uint64
+	stop           chan struct{}
+	done           chan struct{}
+}
+
+// Option is an option of an exporter
+type Option func(*Exporter)
+
+// OptionEncoding sets the encoding of the records, JSON by default
+func OptionEncoding(encoding Encoding) Option {
+	return func(e *Exporter) {
+		e.encoding = encoding
+	}
+}
+
+// OptionTopics sets the topics of the flow and the container records. The
+// records are not published if their topic is empty.
+func OptionTopics(flowTopic, containerTopic string) Option {
+	return func(e *Exporter) {
+		e.flowTopic = flowTopic
+		e.containerTopic = containerTopic
+	}
+}
+
+// OptionBatch sets the maximum number of records of a batch, and the interval
+// after which a batch is published even if it is not full
+func OptionBatch(size int, interval time.Duration) Option {
+	return func(e *Exporter) {
+		if size > 0 {
+			e.batchSize = size
+		}
+		if interval > 0 {
+			e.flushInterval = interval
+		}
+	}
+}
+
+// OptionRetry sets the number of retries of a batch that cannot be published,
+// and the backoff before the first retry, which doubles with every retry
+func OptionRetry(retries int, backoff time.Duration) Option {
+	return func(e *Exporter) {
+		if retries >= 0 {
+			e.retries = retries
+		}
+		if backoff > 0 {
+			e.retryBackoff = backoff
+		}
+	}
+}
+
+// OptionQueueSize sets the maximum number of records waiting to be published
+func OptionQueueSize(size int) Option {
+	return func(e *Exporter) {
+		if size > 0 {
+			e.queue = make(chan *message, size)
+		}
+	}
+}
+
+// NewExporter returns an exporter that publishes the records with publisher.
+// It must be closed to publish the last records.
+func NewExporter(publisher Publisher, opts ...Option) *Exporter {
+
+	e := &Exporter{
+		publisher:      publisher,
+		encoding:       JSON,
+		flowTopic:      DefaultFlowTopic,
+		containerTopic: DefaultContainerTopic,
+		queue:          make(chan *message, DefaultQueueSize),
+		batchSize:      DefaultBatchSize,
+		flushInterval:  DefaultFlushInterval,
+		retries:        DefaultRetries,
+		retryBackoff:   DefaultRetryBackoff,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	go e.run()
+
+	return e
+}
+
+// Dropped returns the number of records that were dropped
+func (e *Exporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Close publishes the queued records, stops the exporter and closes its
+// publisher. The records collected afterwards are dropped.
+func (e *Exporter) Close() error {
+
+	close(e.stop)
+	<-e.done
+
+	return e.publisher.Close()
+}
+
+// enqueue encodes an event and queues it, or drops it if the queue is full or
+// the exporter is closed
+func (e *Exporter) enqueue(topic, contextID string, event *collector.Event) {
+
+	if topic == "" {
+		return
+	}
+
+	select {
+	case <-e.stop:
+		atomic.AddUint64(&e.dropped, 1)
+		return
+	default:
+	}
+
+	value, err := e.encoding.encode(event)
+	if err != nil {
+		atomic.AddUint64(&e.dropped, 1)
+		zap.L().Warn("Unable to encode record", zap.String("contextID", contextID), zap.Error(err))
+		return
+	}
+
+	select {
+	case e.queue <- &message{topic: topic, Message: Message{Key: []byte(contextID), Value: value}}:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// run publishes the queued records in batches until the exporter is closed
+func (e *Exporter) run() {
+
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := []*message{}
+
+	for {
+		select {
+		case m := <-e.queue:
+			if batch = append(batch, m); len(batch) >= e.batchSize {
+				batch = e.publish(batch)
+			}
+		case <-ticker.C:
+			batch = e.publish(batch)
+		case <-e.stop:
+			for {
+				select {
+				case m := <-e.queue:
+					if batch = append(batch, m); len(batch) >= e.batchSize {
+						batch = e.publish(batch)
+					}
+				default:
+					e.publish(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// publish publishes a batch of records to their topics, and returns an empty
+// batch
+func (e *Exporter) publish(batch []*message) []*message {
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	topics := []string{}
+	messages := map[string][]Message{}
+	for _, m := range batch {
+		if _, ok := messages[m.topic]; !ok {
+			topics = append(topics, m.topic)
+		}
+		messages[m.topic] = append(messages[m.topic], m.Message)
+	}
+
+	for _, topic := range topics {
+		if err := e.publishTopic(topic, messages[topic]); err != nil {
+			atomic.AddUint64(&e.dropped, uint64(len(messages[topic])))
+			zap.L().Warn("Unable to publish records",
+				zap.String("topic", topic),
+				zap.Int("records", len(messages[topic])),
+				zap.Error(err),
+			)
+		}
+	}
+
+	return batch[:0]
+}
+
+// publishTopic publishes messages to a topic, with retries
+func (e *Exporter) publishTopic(topic string, messages []Message) error {
+
+	backoff := e.retryBackoff
+
+	err := e.publisher.Publish(topic, messages)
+	for retry := 0; err != nil && retry < e.retries; retry++ {
+		zap.L().Debug("Retrying to publish records", zap.String("topic", topic), zap.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+		err = e.publisher.Publish(topic, messages)
+	}
+
+	return err
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (e *Exporter) CollectFlowEvent(record *collector.FlowRecord) {
+	e.enqueue(e.flowTopic, record.ContextID, &collector.Event{Type: collector.EventTypeFlow, Time: time.Now(), Flow: record})
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (e *Exporter) CollectContainerEvent(record *collector.ContainerRecord) {
+	e.enqueue(e.containerTopic, record.ContextID, &collector.Event{Type: collector.EventTypeContainer, Time: time.Now(), Container: record})
+}
@@ -0,0 +1,127 @@
+package export
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	// kafkaTimeout is the timeout of the publication of a batch
+	kafkaTimeout = 10 * time.Second
+	// kafkaBatchTimeout is the time a partial batch of a partition waits for
+	// more messages. The exporter already batches the messages.
+	kafkaBatchTimeout = 10 * time.Millisecond
+)
+
+// kafkaPublisher is a Publisher that publishes the messages to the topics of
+// a Kafka cluster with the writer of kafka-go. The partition of a message is
+// selected by the hash of its key, so that the records of a PU stay ordered.
+// The writer does not retry, since the exporter retries the batches.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// KafkaOption is an option of a Kafka publisher
+type KafkaOption func(*kafka.Writer, *kafka.Transport)
+
+// OptionKafkaClientID sets the client ID of the publisher in the requests
+func OptionKafkaClientID(clientID string) KafkaOption {
+	return func(w *kafka.Writer, t *kafka.Transport) {
+		t.ClientID = clientID
+	}
+}
+
+// OptionKafkaTLS sets the TLS configuration of the connections to the brokers
+func OptionKafkaTLS(config *tls.Config) KafkaOption {
+	return func(w *kafka.Writer, t *kafka.Transport) {
+		t.TLS = config
+	}
+}
+
+// OptionKafkaUser authenticates the publisher with a user and a password, with
+// the SASL PLAIN mechanism. It should only be used over TLS.
+func OptionKafkaUser(user, password string) KafkaOption {
+	return func(w *kafka.Writer, t *kafka.Transport) {
+		t.SASL = plain.Mechanism{Username: user, Password: password}
+	}
+}
+
+// OptionKafkaAcks sets the acknowledgments of the brokers the publisher waits
+// for, all the in-sync replicas by default
+func OptionKafkaAcks(acks kafka.RequiredAcks) KafkaOption {
+	return func(w *kafka.Writer, t *kafka.Transport) {
+		w.RequiredAcks = acks
+	}
+}
+
+// NewKafkaPublisher returns a publisher to the Kafka cluster of the brokers,
+// like localhost:9092. The topics must exist.
+func NewKafkaPublisher(brokers []string, opts ...KafkaOption) (Publisher, error) {
+
+	if len(brokers) == 0 {
+		return nil, errors.New("kafka publisher requires at least one broker")
+	}
+
+	transport := &kafka.Transport{
+		ClientID: "trireme",
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		MaxAttempts:  1,
+		BatchSize:    DefaultBatchSize,
+		BatchTimeout: kafkaBatchTimeout,
+		WriteTimeout: kafkaTimeout,
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+	}
+
+	for _, opt := range opts {
+		opt(writer, transport)
+	}
+
+	return &kafkaPublisher{
+		writer: writer,
+	}, nil
+}
+
+// Publish is part of the Publisher interface.
+func (p *kafkaPublisher) Publish(topic string, messages []Message) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaTimeout)
+	defer cancel()
+
+	if err := p.writer.WriteMessages(ctx, kafkaMessages(topic, messages)...); err != nil {
+		return fmt.Errorf("unable to publish to kafka topic %s: %s", topic, err)
+	}
+
+	return nil
+}
+
+// Close is part of the Publisher interface.
+func (p *kafkaPublisher) Close() error {
+
+	return p.writer.Close()
+}
+
+// kafkaMessages returns the Kafka messages of the messages of a topic
+func kafkaMessages(topic string, messages []Message) []kafka.Message {
+
+	msgs := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		msgs[i] = kafka.Message{
+			Topic: topic,
+			Key:   m.Key,
+			Value: m.Value,
+		}
+	}
+
+	return msgs
+}
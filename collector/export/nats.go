@@ -0,0 +1,104 @@
+package export
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// natsTimeout is the timeout of the connection and of the acknowledgments of
+// the NATS server
+const natsTimeout = 10 * time.Second
+
+// natsPublisher is a Publisher that publishes the messages to the subjects of
+// a NATS server with the nats.go client. The messages are acknowledged with a
+// flush, as the server processes the messages of a connection in order. The
+// client reconnects to the server in the background, and fails the messages
+// published in the meantime, so that the exporter retries them.
+type natsPublisher struct {
+	conn *nats.Conn
+}
+
+// NATSOption is an option of a NATS publisher
+type NATSOption func(*[]nats.Option)
+
+// OptionNATSUser authenticates the publisher with a user and a password
+func OptionNATSUser(user, password string) NATSOption {
+	return func(opts *[]nats.Option) {
+		*opts = append(*opts, nats.UserInfo(user, password))
+	}
+}
+
+// OptionNATSToken authenticates the publisher with a token
+func OptionNATSToken(token string) NATSOption {
+	return func(opts *[]nats.Option) {
+		*opts = append(*opts, nats.Token(token))
+	}
+}
+
+// OptionNATSTLS sets the TLS configuration of the connections to the server,
+// like its certificate authorities or the certificate of the client. The
+// connections are upgraded to TLS when it is set or when the server requires
+// it, and fail when the server does not support TLS.
+func OptionNATSTLS(config *tls.Config) NATSOption {
+	return func(opts *[]nats.Option) {
+		*opts = append(*opts, nats.Secure(config))
+	}
+}
+
+// NewNATSPublisher returns a publisher to the NATS server at address, like
+// localhost:4222 or tls://nats.example.com:4222.
+func NewNATSPublisher(address string, opts ...NATSOption) (Publisher, error) {
+
+	options := []nats.Option{
+		nats.Name("trireme"),
+		nats.Timeout(natsTimeout),
+		nats.MaxReconnects(-1),
+		nats.ReconnectBufSize(-1),
+	}
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if !strings.Contains(address, "://") {
+		address = "nats://" + address
+	}
+
+	conn, err := nats.Connect(address, options...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to nats server: %s", err)
+	}
+
+	return &natsPublisher{
+		conn: conn,
+	}, nil
+}
+
+// Publish is part of the Publisher interface. The keys of the messages are
+// ignored.
+func (p *natsPublisher) Publish(topic string, messages []Message) error {
+
+	for _, m := range messages {
+		if err := p.conn.Publish(topic, m.Value); err != nil {
+			return fmt.Errorf("unable to publish to nats subject %s: %s", topic, err)
+		}
+	}
+
+	if err := p.conn.FlushTimeout(natsTimeout); err != nil {
+		return fmt.Errorf("unable to publish to nats subject %s: %s", topic, err)
+	}
+
+	return nil
+}
+
+// Close is part of the Publisher interface.
+func (p *natsPublisher) Close() error {
+
+	p.conn.Close()
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// FileCollector is an EventCollector that appends the events to a file, one
+// JSON event per line
+type FileCollector struct {
+	file    *os.File
+	encoder *json.Encoder
+	sync.Mutex
+}
+
+// NewFileCollector returns a collector that appends the events to the file at
+// path. The file is created if it does not exist.
+func NewFileCollector(path string) (*FileCollector, error) {
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open event file %s: %s", path, err)
+	}
+
+	return &FileCollector{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// write appends an event to the file
+func (c *FileCollector) write(event *Event) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.file == nil {
+		return
+	}
+
+	if err := c.encoder.Encode(event); err != nil {
+		zap.L().Warn("Unable to write event", zap.String("file", c.file.Name()), zap.Error(err))
+	}
+}
+
+// Close closes the file. The events collected afterwards are dropped.
+func (c *FileCollector) Close() error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+
+	err := c.file.Close()
+	c.file = nil
+
+	return err
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (c *FileCollector) CollectFlowEvent(record *FlowRecord) {
+	c.write(newFlowEvent(record))
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (c *FileCollector) CollectContainerEvent(record *ContainerRecord) {
+	c.write(newContainerEvent(record))
+}
+
+// CollectConnectionMetrics is part of the ConnectionMetricsCollector interface.
+func (c *FileCollector) CollectConnectionMetrics(record *ConnectionMetricsRecord) {
+	c.write(newConnectionMetricsEvent(record))
+}
+
+// CollectUserEvent is part of the UserEventCollector interface.
+func (c *FileCollector) CollectUserEvent(record *UserRecord) {
+	c.write(newUserEvent(record))
+}
+
+// CollectPacketEvent is part of the PacketEventCollector interface.
+func (c *FileCollector) CollectPacketEvent(record *PacketRecord) {
+	c.write(newPacketEvent(record))
+}
+
+// CollectQueueStats is part of the QueueStatsCollector interface.
+func (c *FileCollector) CollectQueueStats(record *QueueStatsRecord) {
+	c.write(newQueueStatsEvent(record))
+}
+
+// CollectCacheStats is part of the CacheStatsCollector interface.
+func (c *FileCollector) CollectCacheStats(record *CacheStatsRecord) {
+	c.write(newCacheStatsEvent(record))
+}
+
+// CollectEnforcerStats is part of the EnforcerStatsCollector interface.
+func (c *FileCollector) CollectEnforcerStats(record *EnforcerStatsRecord) {
+	c.write(newEnforcerStatsEvent(record))
+}
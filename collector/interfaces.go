@@ -2,6 +2,7 @@ package collector
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -16,6 +17,8 @@ const (
 	MissingToken = "missingtoken"
 	// InvalidToken indicates that the token was invalid
 	InvalidToken = "token"
+	// InvalidUserToken indicates that the user token was missing or invalid
+	InvalidUserToken = "usertoken"
 	// InvalidFormat indicates that the packet metadata were not correct
 	InvalidFormat = "format"
 	// InvalidContext indicates that there was no context in the metadata
@@ -28,6 +31,14 @@ const (
 	InvalidNonse = "nonse"
 	// PolicyDrop indicates that the flow is rejected because of the policy decision
 	PolicyDrop = "policy"
+	// EncryptionMismatch indicates that the policy requires encryption and the
+	// peer did not offer an ephemeral key
+	EncryptionMismatch = "encryption"
+	// InvalidChecksum indicates that the checksum of the packet was invalid
+	InvalidChecksum = "checksum"
+	// PacketTooLarge indicates that the datagram could not carry the identity
+	// token within the MTU
+	PacketTooLarge = "toolarge"
 )
 
 // Container event description
@@ -48,6 +59,26 @@ const (
 	ContainerIgnored = "ignore"
 	// ContainerDeleteUnknown indicates that policy for an unknown  container was deleted
 	ContainerDeleteUnknown = "unknowncontainer"
+	// ContainerRulesDrift indicates that rules of a container were missing and were programmed
+	// again. The context ID of the event is empty for the global rules.
+	ContainerRulesDrift = "drift"
+	// ContainerQuarantined indicates that all the traffic of a container is dropped
+	// because its policy could not be set
+	ContainerQuarantined = "quarantine"
+	// ContainerBackendDown indicates that a private backend of a proxied service of a
+	// container failed its health checks and was removed from its proxy sets
+	ContainerBackendDown = "backenddown"
+	// ContainerBackendUp indicates that a removed private backend of a proxied service of
+	// a container recovered and was added again to its proxy sets
+	ContainerBackendUp = "backendup"
+)
+
+// User event description
+const (
+	// UserLogin indicates that a user session started
+	UserLogin = "login"
+	// UserLogout indicates that a user session ended
+	UserLogout = "logout"
 )
 
 const (
@@ -67,6 +98,63 @@ type EventCollector interface {
 	CollectContainerEvent(record *ContainerRecord)
 }
 
+// PacketEventCollector is implemented by the event collectors that collect the
+// diagnostics of the packets dropped by the datapath. The enforcers only report
+// the packet records to the collectors that implement it.
+type PacketEventCollector interface {
+
+	// CollectPacketEvent collects the diagnostic of a packet dropped by the
+	// datapath
+	CollectPacketEvent(record *PacketRecord)
+}
+
+// ConnectionMetricsCollector is implemented by the event collectors that collect
+// the connection metrics of the PUs. The enforcers only report the metrics to
+// the collectors that implement it.
+type ConnectionMetricsCollector interface {
+
+	// CollectConnectionMetrics collects the connection metrics of a PU
+	CollectConnectionMetrics(record *ConnectionMetricsRecord)
+}
+
+// UserEventCollector is implemented by the event collectors that collect the
+// logins and logouts of the users. The monitors only report the user events
+// to the collectors that implement it.
+type UserEventCollector interface {
+
+	// CollectUserEvent collects a user login or logout event
+	CollectUserEvent(record *UserRecord)
+}
+
+// QueueStatsCollector is implemented by the event collectors that collect the
+// counters of the netfilter queues. The enforcers only report the queue
+// records to the collectors that implement it.
+type QueueStatsCollector interface {
+
+	// CollectQueueStats collects the counters of a netfilter queue of an
+	// enforcer or of the packets it dropped for a PU
+	CollectQueueStats(record *QueueStatsRecord)
+}
+
+// CacheStatsCollector is implemented by the event collectors that collect the
+// counters of the caches of the datapath. The enforcers only report the cache
+// records to the collectors that implement it.
+type CacheStatsCollector interface {
+
+	// CollectCacheStats collects the counters of a cache of the datapath of
+	// an enforcer
+	CollectCacheStats(record *CacheStatsRecord)
+}
+
+// EnforcerStatsCollector is implemented by the event collectors that collect
+// the resources used by the remote enforcers. The enforcer records are only
+// reported to the collectors that implement it.
+type EnforcerStatsCollector interface {
+
+	// CollectEnforcerStats collects the resources used by a remote enforcer
+	CollectEnforcerStats(record *EnforcerStatsRecord)
+}
+
 // EndPointType is the type of an endpoint (PU or an external IP address )
 type EndPointType byte
 
@@ -90,6 +178,10 @@ type EndPoint struct {
 	IP   string
 	Port uint16
 	Type EndPointType
+	// Tags are the tags of the PU of the endpoint: the ones of the local PU, or
+	// the ones received in the identity token of the remote PU. They are empty
+	// for the external addresses.
+	Tags *policy.TagStore `json:",omitempty"`
 }
 
 // FlowRecord describes a flow record for statistis
@@ -103,7 +195,73 @@ type FlowRecord struct {
 	ObservedAction   policy.ActionType
 	DropReason       string
 	PolicyID         string
+	ServiceID        string
 	ObservedPolicyID string
+	// PeerClaims are the claims of the application of the remote PU that
+	// were received in its identity token
+	PeerClaims map[string]string `json:",omitempty"`
+	// Accounting holds the traffic of the flow since its last record. The
+	// records of the accounting of a flow that was already reported have no
+	// count.
+	Accounting *FlowAccounting
+	// Latency is the latency of the TCP handshake of the flow. It is only set
+	// in the first record of the flows authorized with tokens.
+	Latency *FlowLatency `json:",omitempty"`
+}
+
+// FlowAccounting is the traffic of a flow. The sent bytes and packets are the
+// ones of the source of the flow, the received ones are the ones of its
+// destination.
+type FlowAccounting struct {
+	BytesSent       uint64
+	PacketsSent     uint64
+	BytesReceived   uint64
+	PacketsReceived uint64
+}
+
+// Add adds the traffic of another accounting to the accounting
+func (a *FlowAccounting) Add(other *FlowAccounting) {
+
+	if other == nil {
+		return
+	}
+
+	a.BytesSent += other.BytesSent
+	a.PacketsSent += other.PacketsSent
+	a.BytesReceived += other.BytesReceived
+	a.PacketsReceived += other.PacketsReceived
+}
+
+// FlowLatency is the latency of the TCP handshake of a flow, as seen by the
+// enforcer of the PU of the record
+type FlowLatency struct {
+	// Handshake is the time from the first Syn packet to the end of the
+	// handshake
+	Handshake time.Duration
+	// RoundTrip is the time from the last packet of the handshake sent by the
+	// PU to the reply of the remote
+	RoundTrip time.Duration
+	// Authorization is the time spent by the enforcer to create and validate
+	// the tokens of the handshake
+	Authorization time.Duration
+}
+
+// Max keeps the maximum of the latencies of another record
+func (l *FlowLatency) Max(other *FlowLatency) {
+
+	if other == nil {
+		return
+	}
+
+	if other.Handshake > l.Handshake {
+		l.Handshake = other.Handshake
+	}
+	if other.RoundTrip > l.RoundTrip {
+		l.RoundTrip = other.RoundTrip
+	}
+	if other.Authorization > l.Authorization {
+		l.Authorization = other.Authorization
+	}
 }
 
 func (f *FlowRecord) String() string {
@@ -126,4 +284,125 @@ type ContainerRecord struct {
 	IPAddress policy.ExtendedMap
 	Tags      *policy.TagStore
 	Event     string
+	// Backend is the ip,port pair of the private backend of the backend events
+	Backend string
+}
+
+// UserRecord is a record of a user session event
+type UserRecord struct {
+	ContextID string
+	UID       string
+	Username  string
+	// Ports are the ports of the services of the user PU
+	Ports []string
+	// SourceAddress is the address of the client of a remote session. It is
+	// empty for local sessions.
+	SourceAddress string
+	Event         string
+}
+
+// PacketRecord is the diagnostic of a packet dropped by the datapath, with the
+// reason of the drop and the identity of the remote PU, so that the rejected
+// connections can be explained without the debug logs
+type PacketRecord struct {
+	// ContextID is the context ID of the PU of the packet. It is empty if the
+	// packet is dropped because its PU is unknown.
+	ContextID   string
+	Source      *EndPoint
+	Destination *EndPoint
+	Protocol    uint8
+	// TCPFlags are the flags of the TCP packets, like SYN or SYN/ACK
+	TCPFlags string `json:",omitempty"`
+	// Reason is the reason of the drop, like InvalidToken or PolicyDrop
+	Reason string
+	// RemoteID is the context ID of the remote PU claimed by the packet, if it
+	// is known
+	RemoteID string `json:",omitempty"`
+	// PolicyID is the ID of the policy that rejected the packet
+	PolicyID string `json:",omitempty"`
+}
+
+// QueueStatsRecord holds the counters of a netfilter queue of an enforcer since
+// it was started. The records of the PUs only count the packets dropped for the
+// PU on all the queues of the enforcer.
+type QueueStatsRecord struct {
+	// ContextID is the context ID of the PU of the record. The queues of a
+	// remote enforcer have the context ID of its PU.
+	ContextID string `json:",omitempty"`
+	Queue     uint16
+	// Network is true for the queues of the packets from the network
+	Network bool
+	// Packets is the number of packets that got a verdict from the enforcer
+	Packets uint64
+	// Dropped is the number of packets dropped by the enforcer
+	Dropped uint64
+	// Overruns is the number of packets dropped by the kernel because the
+	// queue or the socket of the enforcer was full
+	Overruns uint64
+	// Backlog is the number of packets waiting for a verdict
+	Backlog uint32
+	// VerdictLatency is the average time spent to give a verdict to a packet
+	VerdictLatency time.Duration
+	// MaxVerdictLatency is the longest time spent to give a verdict to a packet
+	MaxVerdictLatency time.Duration
+}
+
+// CacheStatsRecord holds the counters of a cache of the datapath of an enforcer
+// since it was started. The external IP caches of the PUs are counted together.
+type CacheStatsRecord struct {
+	// ContextID is the context ID of the PU of a remote enforcer
+	ContextID string `json:",omitempty"`
+	// Cache is the name of the cache
+	Cache string
+	// Entries is the number of entries of the cache
+	Entries int
+	// MaxEntries is the maximum number of entries, or 0 if it is not limited
+	MaxEntries int `json:",omitempty"`
+	// Hits and Misses count the lookups of the entries
+	Hits   uint64
+	Misses uint64
+	// Evictions is the number of entries removed to make room for new ones
+	Evictions uint64
+	// Rejections is the number of new entries rejected because the cache was
+	// full
+	Rejections uint64
+	// Expirations is the number of entries removed at the end of their
+	// lifetime
+	Expirations uint64
+}
+
+// EnforcerStatsRecord holds the resources used by a remote enforcer since it
+// was started
+type EnforcerStatsRecord struct {
+	// ContextID is the context ID of the PU the remote enforcer was launched
+	// for
+	ContextID string
+	// PID is the process ID of the remote enforcer
+	PID int
+	// RSS is the memory used by the remote enforcer in bytes
+	RSS uint64
+	// CPUTime is the user and system time used by the remote enforcer
+	CPUTime time.Duration
+}
+
+// ConnectionMetricsRecord is a record of the connection metrics of a PU
+// over a reporting interval
+type ConnectionMetricsRecord struct {
+	ContextID string
+	// NewConnections is the number of connections authorized during the interval
+	NewConnections int
+	// ConcurrentConnections is the number of established connections of the
+	// conntrack table that were authorized, at the end of the interval
+	ConcurrentConnections int
+	Interval              time.Duration
+}
+
+// Rate returns the rate of new connections per second
+func (c *ConnectionMetricsRecord) Rate() float64 {
+
+	if c.Interval <= 0 {
+		return 0
+	}
+
+	return float64(c.NewConnections) / c.Interval.Seconds()
 }
@@ -0,0 +1,163 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is an event of a collector with its record, as kept by the in-memory
+// collector and written by the file and the remote collectors
+type Event struct {
+	Type              string                   `json:"type"`
+	Time              time.Time                `json:"time"`
+	Flow              *FlowRecord              `json:"flow,omitempty"`
+	Container         *ContainerRecord         `json:"container,omitempty"`
+	ConnectionMetrics *ConnectionMetricsRecord `json:"connectionMetrics,omitempty"`
+	User              *UserRecord              `json:"user,omitempty"`
+	Packet            *PacketRecord            `json:"packet,omitempty"`
+	QueueStats        *QueueStatsRecord        `json:"queueStats,omitempty"`
+	CacheStats        *CacheStatsRecord        `json:"cacheStats,omitempty"`
+	EnforcerStats     *EnforcerStatsRecord     `json:"enforcerStats,omitempty"`
+}
+
+// The types of the events
+const (
+	EventTypeFlow              = "flow"
+	EventTypeContainer         = "container"
+	EventTypeConnectionMetrics = "connectionmetrics"
+	EventTypeUser              = "user"
+	EventTypePacket            = "packet"
+	EventTypeQueueStats        = "queuestats"
+	EventTypeCacheStats        = "cachestats"
+	EventTypeEnforcerStats     = "enforcerstats"
+)
+
+// newFlowEvent and the other functions return the event of a record
+func newFlowEvent(record *FlowRecord) *Event {
+	return &Event{Type: EventTypeFlow, Time: time.Now(), Flow: record}
+}
+
+func newContainerEvent(record *ContainerRecord) *Event {
+	return &Event{Type: EventTypeContainer, Time: time.Now(), Container: record}
+}
+
+func newConnectionMetricsEvent(record *ConnectionMetricsRecord) *Event {
+	return &Event{Type: EventTypeConnectionMetrics, Time: time.Now(), ConnectionMetrics: record}
+}
+
+func newUserEvent(record *UserRecord) *Event {
+	return &Event{Type: EventTypeUser, Time: time.Now(), User: record}
+}
+
+func newPacketEvent(record *PacketRecord) *Event {
+	return &Event{Type: EventTypePacket, Time: time.Now(), Packet: record}
+}
+
+func newQueueStatsEvent(record *QueueStatsRecord) *Event {
+	return &Event{Type: EventTypeQueueStats, Time: time.Now(), QueueStats: record}
+}
+
+func newCacheStatsEvent(record *CacheStatsRecord) *Event {
+	return &Event{Type: EventTypeCacheStats, Time: time.Now(), CacheStats: record}
+}
+
+func newEnforcerStatsEvent(record *EnforcerStatsRecord) *Event {
+	return &Event{Type: EventTypeEnforcerStats, Time: time.Now(), EnforcerStats: record}
+}
+
+// MemoryCollector is an EventCollector that keeps the last events in memory,
+// so that they can be inspected by the embedding application or by tests
+type MemoryCollector struct {
+	events []*Event
+	size   int
+	// next is the position of the next event once the events wrapped around
+	next int
+	sync.Mutex
+}
+
+// NewMemoryCollector returns a collector that keeps the last size events
+func NewMemoryCollector(size int) *MemoryCollector {
+
+	if size <= 0 {
+		size = 1
+	}
+
+	return &MemoryCollector{
+		events: make([]*Event, 0, size),
+		size:   size,
+	}
+}
+
+// add keeps an event, in place of the oldest one if the collector is full
+func (c *MemoryCollector) add(event *Event) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.events) < c.size {
+		c.events = append(c.events, event)
+		return
+	}
+
+	c.events[c.next] = event
+	c.next = (c.next + 1) % c.size
+}
+
+// Events returns the events kept by the collector, oldest first
+func (c *MemoryCollector) Events() []*Event {
+
+	c.Lock()
+	defer c.Unlock()
+
+	return append(append([]*Event{}, c.events[c.next:]...), c.events[:c.next]...)
+}
+
+// Reset drops the events kept by the collector
+func (c *MemoryCollector) Reset() {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.events = c.events[:0]
+	c.next = 0
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (c *MemoryCollector) CollectFlowEvent(record *FlowRecord) {
+	c.add(newFlowEvent(record))
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (c *MemoryCollector) CollectContainerEvent(record *ContainerRecord) {
+	c.add(newContainerEvent(record))
+}
+
+// CollectConnectionMetrics is part of the ConnectionMetricsCollector interface.
+func (c *MemoryCollector) CollectConnectionMetrics(record *ConnectionMetricsRecord) {
+	c.add(newConnectionMetricsEvent(record))
+}
+
+// CollectUserEvent is part of the UserEventCollector interface.
+func (c *MemoryCollector) CollectUserEvent(record *UserRecord) {
+	c.add(newUserEvent(record))
+}
+
+// CollectPacketEvent is part of the PacketEventCollector interface.
+func (c *MemoryCollector) CollectPacketEvent(record *PacketRecord) {
+	c.add(newPacketEvent(record))
+}
+
+// CollectQueueStats is part of the QueueStatsCollector interface.
+func (c *MemoryCollector) CollectQueueStats(record *QueueStatsRecord) {
+	c.add(newQueueStatsEvent(record))
+}
+
+// CollectCacheStats is part of the CacheStatsCollector interface.
+func (c *MemoryCollector) CollectCacheStats(record *CacheStatsRecord) {
+	c.add(newCacheStatsEvent(record))
+}
+
+// CollectEnforcerStats is part of the EnforcerStatsCollector interface.
+func (c *MemoryCollector) CollectEnforcerStats(record *EnforcerStatsRecord) {
+	c.add(newEnforcerStatsEvent(record))
+}
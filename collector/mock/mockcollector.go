@@ -61,3 +61,237 @@ func (m *MockEventCollector) CollectContainerEvent(record *collector.ContainerRe
 func (mr *MockEventCollectorMockRecorder) CollectContainerEvent(record interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectContainerEvent", reflect.TypeOf((*MockEventCollector)(nil).CollectContainerEvent), record)
 }
+
+// MockPacketEventCollector is a mock of PacketEventCollector interface
+// nolint
+type MockPacketEventCollector struct {
+	ctrl     *gomock.Controller
+	recorder *MockPacketEventCollectorMockRecorder
+}
+
+// MockPacketEventCollectorMockRecorder is the mock recorder for MockPacketEventCollector
+// nolint
+type MockPacketEventCollectorMockRecorder struct {
+	mock *MockPacketEventCollector
+}
+
+// NewMockPacketEventCollector creates a new mock instance
+// nolint
+func NewMockPacketEventCollector(ctrl *gomock.Controller) *MockPacketEventCollector {
+	mock := &MockPacketEventCollector{ctrl: ctrl}
+	mock.recorder = &MockPacketEventCollectorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+// nolint
+func (m *MockPacketEventCollector) EXPECT() *MockPacketEventCollectorMockRecorder {
+	return m.recorder
+}
+
+// CollectPacketEvent mocks base method
+// nolint
+func (m *MockPacketEventCollector) CollectPacketEvent(record *collector.PacketRecord) {
+	m.ctrl.Call(m, "CollectPacketEvent", record)
+}
+
+// CollectPacketEvent indicates an expected call of CollectPacketEvent
+// nolint
+func (mr *MockPacketEventCollectorMockRecorder) CollectPacketEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectPacketEvent", reflect.TypeOf((*MockPacketEventCollector)(nil).CollectPacketEvent), record)
+}
+
+// MockConnectionMetricsCollector is a mock of ConnectionMetricsCollector interface
+// nolint
+type MockConnectionMetricsCollector struct {
+	ctrl     *gomock.Controller
+	recorder *MockConnectionMetricsCollectorMockRecorder
+}
+
+// MockConnectionMetricsCollectorMockRecorder is the mock recorder for MockConnectionMetricsCollector
+// nolint
+type MockConnectionMetricsCollectorMockRecorder struct {
+	mock *MockConnectionMetricsCollector
+}
+
+// NewMockConnectionMetricsCollector creates a new mock instance
+// nolint
+func NewMockConnectionMetricsCollector(ctrl *gomock.Controller) *MockConnectionMetricsCollector {
+	mock := &MockConnectionMetricsCollector{ctrl: ctrl}
+	mock.recorder = &MockConnectionMetricsCollectorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+// nolint
+func (m *MockConnectionMetricsCollector) EXPECT() *MockConnectionMetricsCollectorMockRecorder {
+	return m.recorder
+}
+
+// CollectConnectionMetrics mocks base method
+// nolint
+func (m *MockConnectionMetricsCollector) CollectConnectionMetrics(record *collector.ConnectionMetricsRecord) {
+	m.ctrl.Call(m, "CollectConnectionMetrics", record)
+}
+
+// CollectConnectionMetrics indicates an expected call of CollectConnectionMetrics
+// nolint
+func (mr *MockConnectionMetricsCollectorMockRecorder) CollectConnectionMetrics(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectConnectionMetrics", reflect.TypeOf((*MockConnectionMetricsCollector)(nil).CollectConnectionMetrics), record)
+}
+
+// MockUserEventCollector is a mock of UserEventCollector interface
+// nolint
+type MockUserEventCollector struct {
+	ctrl     *gomock.Controller
+	recorder *MockUserEventCollectorMockRecorder
+}
+
+// MockUserEventCollectorMockRecorder is the mock recorder for MockUserEventCollector
+// nolint
+type MockUserEventCollectorMockRecorder struct {
+	mock *MockUserEventCollector
+}
+
+// NewMockUserEventCollector creates a new mock instance
+// nolint
+func NewMockUserEventCollector(ctrl *gomock.Controller) *MockUserEventCollector {
+	mock := &MockUserEventCollector{ctrl: ctrl}
+	mock.recorder = &MockUserEventCollectorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+// nolint
+func (m *MockUserEventCollector) EXPECT() *MockUserEventCollectorMockRecorder {
+	return m.recorder
+}
+
+// CollectUserEvent mocks base method
+// nolint
+func (m *MockUserEventCollector) CollectUserEvent(record *collector.UserRecord) {
+	m.ctrl.Call(m, "CollectUserEvent", record)
+}
+
+// CollectUserEvent indicates an expected call of CollectUserEvent
+// nolint
+func (mr *MockUserEventCollectorMockRecorder) CollectUserEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectUserEvent", reflect.TypeOf((*MockUserEventCollector)(nil).CollectUserEvent), record)
+}
+
+// MockQueueStatsCollector is a mock of QueueStatsCollector interface
+// nolint
+type MockQueueStatsCollector struct {
+	ctrl     *gomock.Controller
+	recorder *MockQueueStatsCollectorMockRecorder
+}
+
+// MockQueueStatsCollectorMockRecorder is the mock recorder for MockQueueStatsCollector
+// nolint
+type MockQueueStatsCollectorMockRecorder struct {
+	mock *MockQueueStatsCollector
+}
+
+// NewMockQueueStatsCollector creates a new mock instance
+// nolint
+func NewMockQueueStatsCollector(ctrl *gomock.Controller) *MockQueueStatsCollector {
+	mock := &MockQueueStatsCollector{ctrl: ctrl}
+	mock.recorder = &MockQueueStatsCollectorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+// nolint
+func (m *MockQueueStatsCollector) EXPECT() *MockQueueStatsCollectorMockRecorder {
+	return m.recorder
+}
+
+// CollectQueueStats mocks base method
+// nolint
+func (m *MockQueueStatsCollector) CollectQueueStats(record *collector.QueueStatsRecord) {
+	m.ctrl.Call(m, "CollectQueueStats", record)
+}
+
+// CollectQueueStats indicates an expected call of CollectQueueStats
+// nolint
+func (mr *MockQueueStatsCollectorMockRecorder) CollectQueueStats(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectQueueStats", reflect.TypeOf((*MockQueueStatsCollector)(nil).CollectQueueStats), record)
+}
+
+// MockCacheStatsCollector is a mock of CacheStatsCollector interface
+// nolint
+type MockCacheStatsCollector struct {
+	ctrl     *gomock.Controller
+	recorder *MockCacheStatsCollectorMockRecorder
+}
+
+// MockCacheStatsCollectorMockRecorder is the mock recorder for MockCacheStatsCollector
+// nolint
+type MockCacheStatsCollectorMockRecorder struct {
+	mock *MockCacheStatsCollector
+}
+
+// NewMockCacheStatsCollector creates a new mock instance
+// nolint
+func NewMockCacheStatsCollector(ctrl *gomock.Controller) *MockCacheStatsCollector {
+	mock := &MockCacheStatsCollector{ctrl: ctrl}
+	mock.recorder = &MockCacheStatsCollectorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+// nolint
+func (m *MockCacheStatsCollector) EXPECT() *MockCacheStatsCollectorMockRecorder {
+	return m.recorder
+}
+
+// CollectCacheStats mocks base method
+// nolint
+func (m *MockCacheStatsCollector) CollectCacheStats(record *collector.CacheStatsRecord) {
+	m.ctrl.Call(m, "CollectCacheStats", record)
+}
+
+// CollectCacheStats indicates an expected call of CollectCacheStats
+// nolint
+func (mr *MockCacheStatsCollectorMockRecorder) CollectCacheStats(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectCacheStats", reflect.TypeOf((*MockCacheStatsCollector)(nil).CollectCacheStats), record)
+}
+
+// MockEnforcerStatsCollector is a mock of EnforcerStatsCollector interface
+// nolint
+type MockEnforcerStatsCollector struct {
+	ctrl     *gomock.Controller
+	recorder *MockEnforcerStatsCollectorMockRecorder
+}
+
+// MockEnforcerStatsCollectorMockRecorder is the mock recorder for MockEnforcerStatsCollector
+// nolint
+type MockEnforcerStatsCollectorMockRecorder struct {
+	mock *MockEnforcerStatsCollector
+}
+
+// NewMockEnforcerStatsCollector creates a new mock instance
+// nolint
+func NewMockEnforcerStatsCollector(ctrl *gomock.Controller) *MockEnforcerStatsCollector {
+	mock := &MockEnforcerStatsCollector{ctrl: ctrl}
+	mock.recorder = &MockEnforcerStatsCollectorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+// nolint
+func (m *MockEnforcerStatsCollector) EXPECT() *MockEnforcerStatsCollectorMockRecorder {
+	return m.recorder
+}
+
+// CollectEnforcerStats mocks base method
+// nolint
+func (m *MockEnforcerStatsCollector) CollectEnforcerStats(record *collector.EnforcerStatsRecord) {
+	m.ctrl.Call(m, "CollectEnforcerStats", record)
+}
+
+// CollectEnforcerStats indicates an expected call of CollectEnforcerStats
+// nolint
+func (mr *MockEnforcerStatsCollectorMockRecorder) CollectEnforcerStats(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectEnforcerStats", reflect.TypeOf((*MockEnforcerStatsCollector)(nil).CollectEnforcerStats), record)
+}
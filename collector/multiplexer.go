@@ -0,0 +1,263 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+)
+
+// EventType is a type of the events of a collector. The types can be combined
+// to select the events of a sink.
+type EventType int
+
+// Event types
+const (
+	// FlowEvent selects the flow records
+	FlowEvent EventType = 1 << iota
+	// ContainerEvent selects the container records
+	ContainerEvent
+	// ConnectionMetricsEvent selects the connection metrics records
+	ConnectionMetricsEvent
+	// UserEvent selects the user records
+	UserEvent
+	// PacketEvent selects the packet records
+	PacketEvent
+	// QueueStatsEvent selects the queue records
+	QueueStatsEvent
+	// CacheStatsEvent selects the cache records
+	CacheStatsEvent
+	// EnforcerStatsEvent selects the enforcer records
+	EnforcerStatsEvent
+	// AllEvents selects all the records
+	AllEvents = FlowEvent | ContainerEvent | ConnectionMetricsEvent | UserEvent | PacketEvent | QueueStatsEvent | CacheStatsEvent | EnforcerStatsEvent
+)
+
+// sink is a collector registered in a multiplexer
+type sink struct {
+	name      string
+	collector EventCollector
+	events    EventType
+	// containerEvents and userEvents select the container and user records by
+	// event if they are not empty
+	containerEvents map[string]bool
+	userEvents      map[string]bool
+}
+
+// SinkOption is an option of a sink of a multiplexer
+type SinkOption func(*sink)
+
+// OptionSinkEvents selects the types of the events sent to a sink. A sink
+// receives all the events by default.
+func OptionSinkEvents(events EventType) SinkOption {
+	return func(s *sink) {
+		s.events = events
+	}
+}
+
+// OptionSinkContainerEvents selects the container records sent to a sink by
+// event, like ContainerStart or ContainerFailed
+func OptionSinkContainerEvents(events ...string) SinkOption {
+	return func(s *sink) {
+		for _, event := range events {
+			s.containerEvents[event] = true
+		}
+	}
+}
+
+// OptionSinkUserEvents selects the user records sent to a sink by event, like
+// UserLogin
+func OptionSinkUserEvents(events ...string) SinkOption {
+	return func(s *sink) {
+		for _, event := range events {
+			s.userEvents[event] = true
+		}
+	}
+}
+
+// Multiplexer is an EventCollector that fans the events out to the collectors
+// registered as its sinks, like the in-memory, Prometheus, file and remote
+// collectors. The events are sent to the sinks in the goroutine of the caller,
+// in the order of their registration, so the sinks must not block.
+type Multiplexer struct {
+	sinks []*sink
+	sync.RWMutex
+}
+
+// NewMultiplexer returns a multiplexer without sinks
+func NewMultiplexer() *Multiplexer {
+	return &Multiplexer{
+		sinks: []*sink{},
+	}
+}
+
+// Register adds a sink to the multiplexer. The name identifies the sink.
+func (m *Multiplexer) Register(name string, collector EventCollector, opts ...SinkOption) error {
+
+	if collector == nil {
+		return fmt.Errorf("sink %s has no collector", name)
+	}
+
+	s := &sink{
+		name:            name,
+		collector:       collector,
+		events:          AllEvents,
+		containerEvents: map[string]bool{},
+		userEvents:      map[string]bool{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, existing := range m.sinks {
+		if existing.name == name {
+			return fmt.Errorf("sink %s is already registered", name)
+		}
+	}
+
+	// The slice is replaced so that the events being sent are not affected
+	m.sinks = append(append([]*sink{}, m.sinks...), s)
+
+	return nil
+}
+
+// Unregister removes a sink from the multiplexer
+func (m *Multiplexer) Unregister(name string) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	sinks := []*sink{}
+	for _, s := range m.sinks {
+		if s.name != name {
+			sinks = append(sinks, s)
+		}
+	}
+
+	m.sinks = sinks
+}
+
+// Sinks returns the names of the sinks of the multiplexer
+func (m *Multiplexer) Sinks() []string {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	names := make([]string, 0, len(m.sinks))
+	for _, s := range m.sinks {
+		names = append(names, s.name)
+	}
+
+	return names
+}
+
+// current returns the sinks of the multiplexer. The slice is never modified.
+func (m *Multiplexer) current() []*sink {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.sinks
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (m *Multiplexer) CollectFlowEvent(record *FlowRecord) {
+
+	for _, s := range m.current() {
+		if s.events&FlowEvent == 0 {
+			continue
+		}
+		s.collector.CollectFlowEvent(record)
+	}
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (m *Multiplexer) CollectContainerEvent(record *ContainerRecord) {
+
+	for _, s := range m.current() {
+		if s.events&ContainerEvent == 0 || (len(s.containerEvents) > 0 && !s.containerEvents[record.Event]) {
+			continue
+		}
+		s.collector.CollectContainerEvent(record)
+	}
+}
+
+// CollectConnectionMetrics is part of the ConnectionMetricsCollector interface.
+func (m *Multiplexer) CollectConnectionMetrics(record *ConnectionMetricsRecord) {
+
+	for _, s := range m.current() {
+		if s.events&ConnectionMetricsEvent == 0 {
+			continue
+		}
+		if c, ok := s.collector.(ConnectionMetricsCollector); ok {
+			c.CollectConnectionMetrics(record)
+		}
+	}
+}
+
+// CollectUserEvent is part of the UserEventCollector interface.
+func (m *Multiplexer) CollectUserEvent(record *UserRecord) {
+
+	for _, s := range m.current() {
+		if s.events&UserEvent == 0 || (len(s.userEvents) > 0 && !s.userEvents[record.Event]) {
+			continue
+		}
+		if c, ok := s.collector.(UserEventCollector); ok {
+			c.CollectUserEvent(record)
+		}
+	}
+}
+
+// CollectPacketEvent is part of the PacketEventCollector interface.
+func (m *Multiplexer) CollectPacketEvent(record *PacketRecord) {
+
+	for _, s := range m.current() {
+		if s.events&PacketEvent == 0 {
+			continue
+		}
+		if c, ok := s.collector.(PacketEventCollector); ok {
+			c.CollectPacketEvent(record)
+		}
+	}
+}
+
+// CollectQueueStats is part of the QueueStatsCollector interface.
+func (m *Multiplexer) CollectQueueStats(record *QueueStatsRecord) {
+
+	for _, s := range m.current() {
+		if s.events&QueueStatsEvent == 0 {
+			continue
+		}
+		if c, ok := s.collector.(QueueStatsCollector); ok {
+			c.CollectQueueStats(record)
+		}
+	}
+}
+
+// CollectCacheStats is part of the CacheStatsCollector interface.
+func (m *Multiplexer) CollectCacheStats(record *CacheStatsRecord) {
+
+	for _, s := range m.current() {
+		if s.events&CacheStatsEvent == 0 {
+			continue
+		}
+		if c, ok := s.collector.(CacheStatsCollector); ok {
+			c.CollectCacheStats(record)
+		}
+	}
+}
+
+// CollectEnforcerStats is part of the EnforcerStatsCollector interface.
+func (m *Multiplexer) CollectEnforcerStats(record *EnforcerStatsRecord) {
+
+	for _, s := range m.current() {
+		if s.events&EnforcerStatsEvent == 0 {
+			continue
+		}
+		if c, ok := s.collector.(EnforcerStatsCollector); ok {
+			c.CollectEnforcerStats(record)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package collector
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testFlowRecord(action policy.ActionType, reason string) *FlowRecord {
+	return &FlowRecord{
+		ContextID:   "pu1",
+		Count:       1,
+		Source:      &EndPoint{ID: "A", IP: "1.1.1.1", Type: Address},
+		Destination: &EndPoint{ID: "pu1", IP: "2.2.2.2", Port: 80, Type: PU},
+		Action:      action,
+		DropReason:  reason,
+	}
+}
+
+func TestMultiplexer(t *testing.T) {
+	Convey("Given a multiplexer with an in-memory sink for all the events and one for the start events", t, func() {
+		m := NewMultiplexer()
+		all := NewMemoryCollector(10)
+		starts := NewMemoryCollector(10)
+		So(m.Register("all", all), ShouldBeNil)
+		So(m.Register("starts", starts, OptionSinkEvents(ContainerEvent), OptionSinkContainerEvents(ContainerStart)), ShouldBeNil)
+
+		Convey("When I register a sink with the same name, it should fail", func() {
+			So(m.Register("all", NewMemoryCollector(1)), ShouldNotBeNil)
+			So(m.Sinks(), ShouldResemble, []string{"all", "starts"})
+		})
+
+		Convey("When I collect events, they should be sent to the sinks that select them", func() {
+			m.CollectFlowEvent(testFlowRecord(policy.Accept, ""))
+			m.CollectContainerEvent(&ContainerRecord{ContextID: "pu1", Event: ContainerStart})
+			m.CollectContainerEvent(&ContainerRecord{ContextID: "pu1", Event: ContainerStop})
+			m.CollectUserEvent(&UserRecord{ContextID: "pu2", Event: UserLogin})
+			m.CollectPacketEvent(&PacketRecord{ContextID: "pu1", Reason: InvalidToken})
+
+			So(len(all.Events()), ShouldEqual, 5)
+			events := starts.Events()
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Type, ShouldEqual, EventTypeContainer)
+			So(events[0].Container.Event, ShouldEqual, ContainerStart)
+		})
+
+		Convey("When I unregister a sink, it should not receive the events", func() {
+			m.Unregister("all")
+			m.CollectFlowEvent(testFlowRecord(policy.Accept, ""))
+			So(all.Events(), ShouldBeEmpty)
+			So(m.Sinks(), ShouldResemble, []string{"starts"})
+		})
+	})
+}
+
+func TestMemoryCollector(t *testing.T) {
+	Convey("Given an in-memory collector of two events", t, func() {
+		c := NewMemoryCollector(2)
+
+		Convey("When I collect three events, the oldest one should be dropped", func() {
+			c.CollectUserEvent(&UserRecord{Username: "a"})
+			c.CollectUserEvent(&UserRecord{Username: "b"})
+			c.CollectUserEvent(&UserRecord{Username: "c"})
+
+			events := c.Events()
+			So(len(events), ShouldEqual, 2)
+			So(events[0].User.Username, ShouldEqual, "b")
+			So(events[1].User.Username, ShouldEqual, "c")
+
+			c.Reset()
+			So(c.Events(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestFileCollector(t *testing.T) {
+	Convey("Given a file collector", t, func() {
+		dir, err := ioutil.TempDir("", "collector")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "events.json")
+		c, err := NewFileCollector(path)
+		So(err, ShouldBeNil)
+
+		Convey("When I collect events, they should be written one per line", func() {
+			c.CollectFlowEvent(testFlowRecord(policy.Reject, PolicyDrop))
+			c.CollectConnectionMetrics(&ConnectionMetricsRecord{ContextID: "pu1", NewConnections: 3})
+			So(c.Close(), ShouldBeNil)
+			c.CollectFlowEvent(testFlowRecord(policy.Reject, PolicyDrop))
+
+			file, err := os.Open(path)
+			So(err, ShouldBeNil)
+			defer file.Close() // nolint
+
+			events := []*Event{}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				event := &Event{}
+				So(json.Unmarshal(scanner.Bytes(), event), ShouldBeNil)
+				events = append(events, event)
+			}
+
+			So(len(events), ShouldEqual, 2)
+			So(events[0].Flow.DropReason, ShouldEqual, PolicyDrop)
+			So(events[1].ConnectionMetrics.NewConnections, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestRemoteCollector(t *testing.T) {
+	Convey("Given a remote collector and an endpoint", t, func() {
+		var lock sync.Mutex
+		received := []*Event{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			batch := []*Event{}
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			lock.Lock()
+			received = append(received, batch...)
+			lock.Unlock()
+		}))
+		defer server.Close()
+
+		c := NewRemoteCollector(server.URL, OptionRemoteBatch(2, time.Hour))
+
+		Convey("When I collect events, they should be posted in batches", func() {
+			c.CollectFlowEvent(testFlowRecord(policy.Accept, ""))
+			c.CollectContainerEvent(&ContainerRecord{ContextID: "pu1", Event: ContainerStart})
+			c.CollectUserEvent(&UserRecord{ContextID: "pu2", Event: UserLogin})
+			c.Close()
+			c.CollectUserEvent(&UserRecord{ContextID: "pu2", Event: UserLogout})
+
+			lock.Lock()
+			defer lock.Unlock()
+			So(len(received), ShouldEqual, 3)
+			So(received[2].User.Event, ShouldEqual, UserLogin)
+			So(c.Dropped(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestPrometheusCollector(t *testing.T) {
+	Convey("Given a Prometheus collector", t, func() {
+		c := NewPrometheusCollector()
+
+		Convey("When I collect events, they should be counted", func() {
+			c.CollectFlowEvent(testFlowRecord(policy.Accept|policy.Log, ""))
+			c.CollectFlowEvent(testFlowRecord(policy.Reject, PolicyDrop))
+			c.CollectFlowEvent(testFlowRecord(policy.Reject, PolicyDrop))
+			c.CollectConnectionMetrics(&ConnectionMetricsRecord{ContextID: "pu1", NewConnections: 3, ConcurrentConnections: 2})
+			c.CollectContainerEvent(&ContainerRecord{ContextID: "pu2", Event: ContainerDelete})
+			c.CollectPacketEvent(&PacketRecord{ContextID: "pu1", Reason: InvalidToken})
+			c.CollectQueueStats(&QueueStatsRecord{Queue: 4, Network: true, Packets: 10, Overruns: 1})
+			c.CollectQueueStats(&QueueStatsRecord{Queue: 4, Network: true, Packets: 12, Overruns: 2})
+			c.CollectCacheStats(&CacheStatsRecord{ContextID: "pu1", Cache: "External IP Cache", Entries: 3, Hits: 5, Evictions: 1})
+			c.CollectEnforcerStats(&EnforcerStatsRecord{ContextID: "pu1", PID: 42, RSS: 1024, CPUTime: 1500 * time.Millisecond})
+
+			recorder := httptest.NewRecorder()
+			c.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+			metrics := recorder.Body.String()
+			So(recorder.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+			So(metrics, ShouldContainSubstring, "# TYPE trireme_flows_total counter\n")
+			So(metrics, ShouldContainSubstring, `trireme_flows_total{action="accept",reason=""} 1`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_flows_total{action="reject",reason="policy"} 2`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_concurrent_connections{context_id="pu1"} 2`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_container_events_total{event="delete"} 1`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_packet_drops_total{reason="token"} 1`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_queue_packets_total{context_id="",direction="network",queue="4"} 12`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_queue_overruns_total{context_id="",direction="network",queue="4"} 2`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_cache_entries{cache="External IP Cache",context_id="pu1"} 3`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_cache_evictions_total{cache="External IP Cache",context_id="pu1"} 1`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_enforcer_rss_bytes{context_id="pu1"} 1024`+"\n")
+			So(metrics, ShouldContainSubstring, `trireme_enforcer_cpu_seconds_total{context_id="pu1"} 1.5`+"\n")
+
+			Convey("When the PU is deleted, its connection metrics should be removed", func() {
+				c.CollectContainerEvent(&ContainerRecord{ContextID: "pu1", Event: ContainerDelete})
+				So(string(c.Metrics()), ShouldNotContainSubstring, `context_id="pu1"`)
+			})
+		})
+	})
+}
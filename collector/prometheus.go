@@ -0,0 +1,312 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// PrometheusCollector is an EventCollector that counts the events, and exposes
+// the counts as metrics in the Prometheus text format with its ServeHTTP
+// method. The connection metrics, the queue and cache counters and the
+// resources of the remote enforcer of a PU are removed when it is deleted.
+type PrometheusCollector struct {
+	flows           map[[2]string]uint64
+	flowBytes       map[string]uint64
+	containerEvents map[string]uint64
+	userEvents      map[string]uint64
+	packetDrops     map[string]uint64
+	connections     map[string]uint64
+	concurrent      map[string]int
+	queues          map[queueKey]*QueueStatsRecord
+	caches          map[cacheKey]*CacheStatsRecord
+	enforcers       map[string]*EnforcerStatsRecord
+	sync.Mutex
+}
+
+// queueKey is the key of the last counters of a queue
+type queueKey struct {
+	contextID string
+	queue     uint16
+	network   bool
+}
+
+// cacheKey is the key of the last counters of a cache
+type cacheKey struct {
+	contextID string
+	cache     string
+}
+
+// NewPrometheusCollector returns a collector without metrics
+func NewPrometheusCollector() *PrometheusCollector {
+	return &PrometheusCollector{
+		flows:           map[[2]string]uint64{},
+		flowBytes:       map[string]uint64{},
+		containerEvents: map[string]uint64{},
+		userEvents:      map[string]uint64{},
+		packetDrops:     map[string]uint64{},
+		connections:     map[string]uint64{},
+		concurrent:      map[string]int{},
+		queues:          map[queueKey]*QueueStatsRecord{},
+		caches:          map[cacheKey]*CacheStatsRecord{},
+		enforcers:       map[string]*EnforcerStatsRecord{},
+	}
+}
+
+// flowAction returns the action label of a flow
+func flowAction(action policy.ActionType) string {
+
+	switch {
+	case action&policy.Accept != 0:
+		return FlowAccept
+	case action&policy.Reject != 0:
+		return FlowReject
+	default:
+		return "unknown"
+	}
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (c *PrometheusCollector) CollectFlowEvent(record *FlowRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if record.Count > 0 {
+		c.flows[[2]string{flowAction(record.Action), record.DropReason}] += uint64(record.Count)
+	}
+
+	if record.Accounting != nil {
+		c.flowBytes["sent"] += record.Accounting.BytesSent
+		c.flowBytes["received"] += record.Accounting.BytesReceived
+	}
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (c *PrometheusCollector) CollectContainerEvent(record *ContainerRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.containerEvents[record.Event]++
+
+	if record.Event == ContainerDelete {
+		delete(c.connections, record.ContextID)
+		delete(c.concurrent, record.ContextID)
+		delete(c.enforcers, record.ContextID)
+
+		for key := range c.queues {
+			if key.contextID == record.ContextID {
+				delete(c.queues, key)
+			}
+		}
+
+		for key := range c.caches {
+			if key.contextID == record.ContextID {
+				delete(c.caches, key)
+			}
+		}
+	}
+}
+
+// CollectConnectionMetrics is part of the ConnectionMetricsCollector interface.
+func (c *PrometheusCollector) CollectConnectionMetrics(record *ConnectionMetricsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.connections[record.ContextID] += uint64(record.NewConnections)
+	c.concurrent[record.ContextID] = record.ConcurrentConnections
+}
+
+// CollectUserEvent is part of the UserEventCollector interface.
+func (c *PrometheusCollector) CollectUserEvent(record *UserRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.userEvents[record.Event]++
+}
+
+// CollectPacketEvent is part of the PacketEventCollector interface.
+func (c *PrometheusCollector) CollectPacketEvent(record *PacketRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.packetDrops[record.Reason]++
+}
+
+// CollectQueueStats is part of the QueueStatsCollector interface. The counters of
+// the queues are kept as they are reported by the enforcers.
+func (c *PrometheusCollector) CollectQueueStats(record *QueueStatsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.queues[queueKey{contextID: record.ContextID, queue: record.Queue, network: record.Network}] = record
+}
+
+// CollectCacheStats is part of the CacheStatsCollector interface. The counters of
+// the caches are kept as they are reported by the enforcers.
+func (c *PrometheusCollector) CollectCacheStats(record *CacheStatsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.caches[cacheKey{contextID: record.ContextID, cache: record.Cache}] = record
+}
+
+// CollectEnforcerStats is part of the EnforcerStatsCollector interface. The resources
+// of the remote enforcers are kept as they are reported.
+func (c *PrometheusCollector) CollectEnforcerStats(record *EnforcerStatsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.enforcers[record.ContextID] = record
+}
+
+// promLabel returns a label of a metric with its value escaped
+func promLabel(name, value string) string {
+
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+
+	return name + `="` + value + `"`
+}
+
+// promMetric writes a metric with its samples, sorted by labels
+func promMetric(buf *bytes.Buffer, name, kind, help string, samples map[string]string) {
+
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+
+	labels := make([]string, 0, len(samples))
+	for label := range samples {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		fmt.Fprintf(buf, "%s{%s} %s\n", name, label, samples[label])
+	}
+}
+
+// Metrics returns the metrics in the Prometheus text format
+func (c *PrometheusCollector) Metrics() []byte {
+
+	c.Lock()
+	defer c.Unlock()
+
+	buf := &bytes.Buffer{}
+
+	samples := map[string]string{}
+	for key, count := range c.flows {
+		samples[promLabel("action", key[0])+","+promLabel("reason", key[1])] = strconv.FormatUint(count, 10)
+	}
+	promMetric(buf, "trireme_flows_total", "counter", "Number of flows by action and drop reason.", samples)
+
+	samples = map[string]string{}
+	for direction, count := range c.flowBytes {
+		samples[promLabel("direction", direction)] = strconv.FormatUint(count, 10)
+	}
+	promMetric(buf, "trireme_flow_bytes_total", "counter", "Number of bytes of the accounted flows by direction.", samples)
+
+	samples = map[string]string{}
+	for event, count := range c.containerEvents {
+		samples[promLabel("event", event)] = strconv.FormatUint(count, 10)
+	}
+	promMetric(buf, "trireme_container_events_total", "counter", "Number of container events by event.", samples)
+
+	samples = map[string]string{}
+	for event, count := range c.userEvents {
+		samples[promLabel("event", event)] = strconv.FormatUint(count, 10)
+	}
+	promMetric(buf, "trireme_user_events_total", "counter", "Number of user events by event.", samples)
+
+	samples = map[string]string{}
+	for reason, count := range c.packetDrops {
+		samples[promLabel("reason", reason)] = strconv.FormatUint(count, 10)
+	}
+	promMetric(buf, "trireme_packet_drops_total", "counter", "Number of packets dropped by reason.", samples)
+
+	samples = map[string]string{}
+	for contextID, count := range c.connections {
+		samples[promLabel("context_id", contextID)] = strconv.FormatUint(count, 10)
+	}
+	promMetric(buf, "trireme_connections_total", "counter", "Number of connections authorized by PU.", samples)
+
+	samples = map[string]string{}
+	for contextID, count := range c.concurrent {
+		samples[promLabel("context_id", contextID)] = strconv.Itoa(count)
+	}
+	promMetric(buf, "trireme_concurrent_connections", "gauge", "Number of established authorized connections by PU.", samples)
+
+	packets, drops, overruns := map[string]string{}, map[string]string{}, map[string]string{}
+	backlog, latency, maxLatency := map[string]string{}, map[string]string{}, map[string]string{}
+	for key, record := range c.queues {
+		direction := "application"
+		if key.network {
+			direction = "network"
+		}
+		labels := promLabel("context_id", key.contextID) + "," + promLabel("direction", direction) + "," + promLabel("queue", strconv.Itoa(int(key.queue)))
+
+		packets[labels] = strconv.FormatUint(record.Packets, 10)
+		drops[labels] = strconv.FormatUint(record.Dropped, 10)
+		overruns[labels] = strconv.FormatUint(record.Overruns, 10)
+		backlog[labels] = strconv.FormatUint(uint64(record.Backlog), 10)
+		latency[labels] = strconv.FormatFloat(record.VerdictLatency.Seconds(), 'g', -1, 64)
+		maxLatency[labels] = strconv.FormatFloat(record.MaxVerdictLatency.Seconds(), 'g', -1, 64)
+	}
+	promMetric(buf, "trireme_queue_packets_total", "counter", "Number of packets that got a verdict by queue.", packets)
+	promMetric(buf, "trireme_queue_drops_total", "counter", "Number of packets dropped by the enforcer by queue.", drops)
+	promMetric(buf, "trireme_queue_overruns_total", "counter", "Number of packets dropped by the kernel by queue.", overruns)
+	promMetric(buf, "trireme_queue_backlog", "gauge", "Number of packets waiting for a verdict by queue.", backlog)
+	promMetric(buf, "trireme_queue_verdict_latency_seconds", "gauge", "Average time to give a verdict by queue.", latency)
+	promMetric(buf, "trireme_queue_max_verdict_latency_seconds", "gauge", "Longest time to give a verdict by queue.", maxLatency)
+
+	entries, hits, misses := map[string]string{}, map[string]string{}, map[string]string{}
+	evictions, rejections, expirations := map[string]string{}, map[string]string{}, map[string]string{}
+	for key, record := range c.caches {
+		labels := promLabel("cache", key.cache) + "," + promLabel("context_id", key.contextID)
+
+		entries[labels] = strconv.Itoa(record.Entries)
+		hits[labels] = strconv.FormatUint(record.Hits, 10)
+		misses[labels] = strconv.FormatUint(record.Misses, 10)
+		evictions[labels] = strconv.FormatUint(record.Evictions, 10)
+		rejections[labels] = strconv.FormatUint(record.Rejections, 10)
+		expirations[labels] = strconv.FormatUint(record.Expirations, 10)
+	}
+	promMetric(buf, "trireme_cache_entries", "gauge", "Number of entries by cache.", entries)
+	promMetric(buf, "trireme_cache_hits_total", "counter", "Number of lookups that found an entry by cache.", hits)
+	promMetric(buf, "trireme_cache_misses_total", "counter", "Number of lookups that found no entry by cache.", misses)
+	promMetric(buf, "trireme_cache_evictions_total", "counter", "Number of entries removed to make room by cache.", evictions)
+	promMetric(buf, "trireme_cache_rejections_total", "counter", "Number of entries rejected because the cache was full by cache.", rejections)
+	promMetric(buf, "trireme_cache_expirations_total", "counter", "Number of entries removed at the end of their lifetime by cache.", expirations)
+
+	rss, cpu := map[string]string{}, map[string]string{}
+	for contextID, record := range c.enforcers {
+		labels := promLabel("context_id", contextID)
+
+		rss[labels] = strconv.FormatUint(record.RSS, 10)
+		cpu[labels] = strconv.FormatFloat(record.CPUTime.Seconds(), 'g', -1, 64)
+	}
+	promMetric(buf, "trireme_enforcer_rss_bytes", "gauge", "Memory used by remote enforcer.", rss)
+	promMetric(buf, "trireme_enforcer_cpu_seconds_total", "counter", "CPU time used by remote enforcer.", cpu)
+
+	return buf.Bytes()
+}
+
+// ServeHTTP serves the metrics to Prometheus
+func (c *PrometheusCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(c.Metrics()) // nolint
+}
@@ -0,0 +1,237 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Defaults of the remote collector
+const (
+	DefaultRemoteQueueSize     = 10000
+	DefaultRemoteBatchSize     = 500
+	DefaultRemoteFlushInterval = 5 * time.Second
+)
+
+// RemoteCollector is an EventCollector that posts the events to a remote HTTP
+// endpoint, in batches of JSON events. The events are queued and posted in the
+// background, so that a slow endpoint does not slow down the enforcer: the
+// events are dropped when the queue is full or when the endpoint fails.
+type RemoteCollector struct {
+	url           string
+	client        *http.Client
+	queue         chan *Event
+	batchSize     int
+	flushInterval time.Duration
+	dropped       uint64
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// RemoteOption is an option of a remote collector
+type RemoteOption func(*RemoteCollector)
+
+// OptionRemoteClient sets the HTTP client of the collector, like a client with
+// TLS certificates
+func OptionRemoteClient(client *http.Client) RemoteOption {
+	return func(c *RemoteCollector) {
+		c.client = client
+	}
+}
+
+// OptionRemoteBatch sets the maximum number of events of a batch, and the
+// interval after which a batch is posted even if it is not full
+func OptionRemoteBatch(size int, interval time.Duration) RemoteOption {
+	return func(c *RemoteCollector) {
+		if size > 0 {
+			c.batchSize = size
+		}
+		if interval > 0 {
+			c.flushInterval = interval
+		}
+	}
+}
+
+// OptionRemoteQueueSize sets the maximum number of events waiting to be posted
+func OptionRemoteQueueSize(size int) RemoteOption {
+	return func(c *RemoteCollector) {
+		if size > 0 {
+			c.queue = make(chan *Event, size)
+		}
+	}
+}
+
+// NewRemoteCollector returns a collector that posts the events to url. It
+// must be closed to post the last events.
+func NewRemoteCollector(url string, opts ...RemoteOption) *RemoteCollector {
+
+	c := &RemoteCollector{
+		url:           url,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		queue:         make(chan *Event, DefaultRemoteQueueSize),
+		batchSize:     DefaultRemoteBatchSize,
+		flushInterval: DefaultRemoteFlushInterval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	go c.run()
+
+	return c
+}
+
+// Dropped returns the number of events that were dropped
+func (c *RemoteCollector) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Close posts the queued events and stops the collector. The events collected
+// afterwards are dropped.
+func (c *RemoteCollector) Close() {
+
+	close(c.stop)
+	<-c.done
+}
+
+// enqueue queues an event, or drops it if the queue is full or the collector
+// is closed
+func (c *RemoteCollector) enqueue(event *Event) {
+
+	select {
+	case <-c.stop:
+		atomic.AddUint64(&c.dropped, 1)
+		return
+	default:
+	}
+
+	select {
+	case c.queue <- event:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+// run posts the queued events in batches until the collector is closed
+func (c *RemoteCollector) run() {
+
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Event, 0, c.batchSize)
+
+	for {
+		select {
+		case event := <-c.queue:
+			batch = append(batch, event)
+			if len(batch) >= c.batchSize {
+				batch = c.post(batch)
+			}
+		case <-ticker.C:
+			batch = c.post(batch)
+		case <-c.stop:
+			for {
+				select {
+				case event := <-c.queue:
+					batch = append(batch, event)
+					if len(batch) >= c.batchSize {
+						batch = c.post(batch)
+					}
+				default:
+					c.post(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// post posts a batch of events and returns an empty batch
+func (c *RemoteCollector) post(batch []*Event) []*Event {
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := c.send(batch); err != nil {
+		atomic.AddUint64(&c.dropped, uint64(len(batch)))
+		zap.L().Warn("Unable to post events",
+			zap.String("url", c.url),
+			zap.Int("events", len(batch)),
+			zap.Error(err),
+		)
+	}
+
+	return batch[:0]
+}
+
+// send posts a batch of events to the endpoint
+func (c *RemoteCollector) send(batch []*Event) error {
+
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (c *RemoteCollector) CollectFlowEvent(record *FlowRecord) {
+	c.enqueue(newFlowEvent(record))
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (c *RemoteCollector) CollectContainerEvent(record *ContainerRecord) {
+	c.enqueue(newContainerEvent(record))
+}
+
+// CollectConnectionMetrics is part of the ConnectionMetricsCollector interface.
+func (c *RemoteCollector) CollectConnectionMetrics(record *ConnectionMetricsRecord) {
+	c.enqueue(newConnectionMetricsEvent(record))
+}
+
+// CollectUserEvent is part of the UserEventCollector interface.
+func (c *RemoteCollector) CollectUserEvent(record *UserRecord) {
+	c.enqueue(newUserEvent(record))
+}
+
+// CollectPacketEvent is part of the PacketEventCollector interface.
+func (c *RemoteCollector) CollectPacketEvent(record *PacketRecord) {
+	c.enqueue(newPacketEvent(record))
+}
+
+// CollectQueueStats is part of the QueueStatsCollector interface.
+func (c *RemoteCollector) CollectQueueStats(record *QueueStatsRecord) {
+	c.enqueue(newQueueStatsEvent(record))
+}
+
+// CollectCacheStats is part of the CacheStatsCollector interface.
+func (c *RemoteCollector) CollectCacheStats(record *CacheStatsRecord) {
+	c.enqueue(newCacheStatsEvent(record))
+}
+
+// CollectEnforcerStats is part of the EnforcerStatsCollector interface.
+func (c *RemoteCollector) CollectEnforcerStats(record *EnforcerStatsRecord) {
+	c.enqueue(newEnforcerStatsEvent(record))
+}
@@ -0,0 +1,463 @@
+package collector
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// SyslogFormat is the format of the audit messages of a syslog collector
+type SyslogFormat int
+
+// Syslog formats
+const (
+	// CEF formats the messages in the Common Event Format of ArcSight
+	CEF SyslogFormat = iota
+	// LEEF formats the messages in the Log Event Extended Format of QRadar
+	LEEF
+)
+
+// Defaults of the syslog collector
+const (
+	DefaultSyslogQueueSize = 10000
+	// DefaultSyslogFacility is the local4 facility
+	DefaultSyslogFacility = 20
+	// syslogTimeout is the timeout of the connections and of the writes to the
+	// syslog server
+	syslogTimeout = 10 * time.Second
+)
+
+// The vendor, product and version of the audit messages
+const (
+	auditVendor  = "Aporeto"
+	auditProduct = "Trireme"
+	auditVersion = "1.0"
+)
+
+// Syslog severities of the audit messages
+const (
+	syslogWarning = 4
+	syslogNotice  = 5
+	syslogInfo    = 6
+)
+
+// auditField is a field of an audit message, with its CEF and LEEF keys
+type auditField struct {
+	cef   string
+	leef  string
+	value string
+}
+
+// auditEvent is a policy decision or a PU event to audit
+type auditEvent struct {
+	id   string
+	name string
+	// severity is the CEF severity of the event, from 0 to 10
+	severity int
+	fields   []auditField
+}
+
+// add adds a field to the event if its value is not empty
+func (a *auditEvent) add(cef, leef, value string) {
+	if value != "" {
+		a.fields = append(a.fields, auditField{cef: cef, leef: leef, value: value})
+	}
+}
+
+// SyslogCollector is an EventCollector that sends the policy decisions of the
+// flow records and the container records to a syslog server, as CEF or LEEF
+// messages, for the audit of the enforcer. The messages are queued and sent in
+// the background over UDP, TCP or TLS, so that a slow server does not slow
+// down the enforcer: the messages are dropped when the queue is full or when
+// the server is unreachable. The other records are ignored.
+type SyslogCollector struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	format    SyslogFormat
+	facility  int
+	hostname  string
+	conn      net.Conn
+	queue     chan []byte
+	dropped   uint64
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// SyslogOption is an option of a syslog collector
+type SyslogOption func(*SyslogCollector)
+
+// OptionSyslogFormat sets the format of the messages, CEF by default
+func OptionSyslogFormat(format SyslogFormat) SyslogOption {
+	return func(c *SyslogCollector) {
+		c.format = format
+	}
+}
+
+// OptionSyslogFacility sets the facility of the messages, local4 by default
+func OptionSyslogFacility(facility int) SyslogOption {
+	return func(c *SyslogCollector) {
+		if facility >= 0 && facility < 24 {
+			c.facility = facility
+		}
+	}
+}
+
+// OptionSyslogTLS sets the TLS configuration of the connections to the server
+// over TLS, like its certificate authorities or the certificate of the client
+func OptionSyslogTLS(config *tls.Config) SyslogOption {
+	return func(c *SyslogCollector) {
+		c.tlsConfig = config
+	}
+}
+
+// OptionSyslogQueueSize sets the maximum number of messages waiting to be sent
+func OptionSyslogQueueSize(size int) SyslogOption {
+	return func(c *SyslogCollector) {
+		if size > 0 {
+			c.queue = make(chan []byte, size)
+		}
+	}
+}
+
+// NewSyslogCollector returns a collector that sends the audit messages to the
+// syslog server at address, like siem:514, over network, which is udp, tcp or
+// tls. The messages of a stream are separated by new lines. It must be closed
+// to send the last messages.
+func NewSyslogCollector(network, address string, opts ...SyslogOption) (*SyslogCollector, error) {
+
+	if network != "udp" && network != "tcp" && network != "tls" {
+		return nil, fmt.Errorf("invalid syslog network %s", network)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	c := &SyslogCollector{
+		network:  network,
+		address:  address,
+		format:   CEF,
+		facility: DefaultSyslogFacility,
+		hostname: hostname,
+		queue:    make(chan []byte, DefaultSyslogQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	go c.run()
+
+	return c, nil
+}
+
+// Dropped returns the number of messages that were dropped
+func (c *SyslogCollector) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Close sends the queued messages and stops the collector. The records
+// collected afterwards are dropped.
+func (c *SyslogCollector) Close() {
+
+	close(c.stop)
+	<-c.done
+}
+
+// enqueue formats an event and queues its message, or drops it if the queue is
+// full or the collector is closed
+func (c *SyslogCollector) enqueue(event *auditEvent) {
+
+	select {
+	case <-c.stop:
+		atomic.AddUint64(&c.dropped, 1)
+		return
+	default:
+	}
+
+	select {
+	case c.queue <- c.message(event, time.Now()):
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+// run sends the queued messages until the collector is closed
+func (c *SyslogCollector) run() {
+
+	defer close(c.done)
+
+	for {
+		select {
+		case m := <-c.queue:
+			c.send(m)
+		case <-c.stop:
+			for {
+				select {
+				case m := <-c.queue:
+					c.send(m)
+				default:
+					c.disconnect()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send writes a message to the server, and connects to it first if needed.
+// The connection is closed if the write fails, and opened again for the next
+// message.
+func (c *SyslogCollector) send(m []byte) {
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			atomic.AddUint64(&c.dropped, 1)
+			zap.L().Debug("Unable to connect to syslog server", zap.String("address", c.address), zap.Error(err))
+			return
+		}
+	}
+
+	if c.network != "udp" {
+		m = append(m, '\n')
+	}
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if err == nil {
+		_, err = c.conn.Write(m)
+	}
+
+	if err != nil {
+		atomic.AddUint64(&c.dropped, 1)
+		zap.L().Warn("Unable to send audit message", zap.String("address", c.address), zap.Error(err))
+		c.disconnect()
+	}
+}
+
+// connect opens a connection to the server
+func (c *SyslogCollector) connect() error {
+
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+
+	var conn net.Conn
+	var err error
+	if c.network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(c.network, c.address)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+
+	return nil
+}
+
+// disconnect closes the connection to the server
+func (c *SyslogCollector) disconnect() {
+
+	if c.conn == nil {
+		return
+	}
+
+	c.conn.Close() // nolint
+	c.conn = nil
+}
+
+// message returns the RFC 5424 syslog message of an event
+func (c *SyslogCollector) message(event *auditEvent, now time.Time) []byte {
+
+	severity := syslogInfo
+	switch {
+	case event.severity >= 7:
+		severity = syslogWarning
+	case event.severity >= 5:
+		severity = syslogNotice
+	}
+
+	var content string
+	if c.format == LEEF {
+		content = formatLEEF(event)
+	} else {
+		content = formatCEF(event)
+	}
+
+	return []byte(fmt.Sprintf("<%d>1 %s %s trireme %d %s - %s",
+		c.facility*8+severity,
+		now.UTC().Format(time.RFC3339Nano),
+		c.hostname,
+		os.Getpid(),
+		event.id,
+		content,
+	))
+}
+
+// formatCEF returns the CEF message of an event
+func formatCEF(event *auditEvent) string {
+
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	extension := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+	fields := make([]string, 0, len(event.fields))
+	for _, f := range event.fields {
+		fields = append(fields, f.cef+"="+extension.Replace(f.value))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		auditVendor,
+		auditProduct,
+		auditVersion,
+		header.Replace(event.id),
+		header.Replace(event.name),
+		event.severity,
+		strings.Join(fields, " "),
+	)
+}
+
+// formatLEEF returns the LEEF 1.0 message of an event. The attributes are
+// separated by tabs.
+func formatLEEF(event *auditEvent) string {
+
+	header := strings.NewReplacer(`|`, `_`)
+	attribute := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+	fields := make([]string, 0, len(event.fields)+1)
+	fields = append(fields, "sev="+strconv.Itoa(event.severity))
+	for _, f := range event.fields {
+		if f.leef != "" {
+			fields = append(fields, f.leef+"="+attribute.Replace(f.value))
+		}
+	}
+
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s",
+		auditVendor,
+		auditProduct,
+		auditVersion,
+		header.Replace(event.id),
+		strings.Join(fields, "\t"),
+	)
+}
+
+// tagsString returns the tags of a record separated by commas
+func tagsString(tags *policy.TagStore) string {
+
+	if tags == nil {
+		return ""
+	}
+
+	return strings.Join(tags.GetSlice(), ",")
+}
+
+// flowAuditEvent returns the audit event of the policy decision of a flow
+func flowAuditEvent(r *FlowRecord) *auditEvent {
+
+	event := &auditEvent{id: "flow-accept", name: "Flow accepted", severity: 3}
+	if r.Action.Rejected() {
+		event = &auditEvent{id: "flow-reject", name: "Flow rejected", severity: 7}
+	}
+
+	event.add("act", "action", r.Action.ActionString())
+	if r.Source != nil {
+		event.add("src", "src", r.Source.IP)
+		event.add("cs2", "srcPU", r.Source.ID)
+		if r.Source.Port != 0 {
+			event.add("spt", "srcPort", strconv.Itoa(int(r.Source.Port)))
+		}
+	}
+	if r.Destination != nil {
+		event.add("dst", "dst", r.Destination.IP)
+		event.add("cs3", "dstPU", r.Destination.ID)
+		if r.Destination.Port != 0 {
+			event.add("dpt", "dstPort", strconv.Itoa(int(r.Destination.Port)))
+		}
+	}
+	event.add("cs1", "policyID", r.PolicyID)
+	event.add("flexString1", "serviceID", r.ServiceID)
+	event.add("reason", "reason", r.DropReason)
+	event.add("cs4", "contextID", r.ContextID)
+	event.add("cs5", "observedPolicyID", r.ObservedPolicyID)
+	event.add("cs6", "tags", tagsString(r.Tags))
+	if r.Count > 0 {
+		event.add("cnt", "count", strconv.Itoa(r.Count))
+	}
+	if r.Accounting != nil {
+		event.add("out", "srcBytes", strconv.FormatUint(r.Accounting.BytesSent, 10))
+		event.add("in", "dstBytes", strconv.FormatUint(r.Accounting.BytesReceived, 10))
+	}
+
+	event.labels(map[string]string{
+		"cs1":         "policyID",
+		"flexString1": "serviceID",
+		"cs2":         "sourceID",
+		"cs3":         "destinationID",
+		"cs4":         "contextID",
+		"cs5":         "observedPolicyID",
+		"cs6":         "tags",
+	})
+
+	return event
+}
+
+// containerAuditEvent returns the audit event of a container record
+func containerAuditEvent(r *ContainerRecord) *auditEvent {
+
+	event := &auditEvent{id: "pu-" + r.Event, name: "PU " + r.Event, severity: 3}
+	switch r.Event {
+	case ContainerFailed, ContainerQuarantined, ContainerRulesDrift:
+		event.severity = 8
+	case ContainerBackendDown:
+		event.severity = 5
+	}
+
+	if ip, ok := r.IPAddress.Get(policy.DefaultNamespace); ok {
+		event.add("dvc", "src", ip)
+	}
+	event.add("cs4", "contextID", r.ContextID)
+	event.add("cs5", "backend", r.Backend)
+	event.add("cs6", "tags", tagsString(r.Tags))
+
+	event.labels(map[string]string{
+		"cs4": "contextID",
+		"cs5": "backend",
+		"cs6": "tags",
+	})
+
+	return event
+}
+
+// labels adds the CEF labels of the custom strings of the event. The labels are
+// not needed by LEEF, whose keys are named.
+func (a *auditEvent) labels(labels map[string]string) {
+
+	fields := a.fields
+	for _, f := range fields {
+		if label, ok := labels[f.cef]; ok {
+			a.fields = append(a.fields, auditField{cef: f.cef + "Label", value: label})
+		}
+	}
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (c *SyslogCollector) CollectFlowEvent(record *FlowRecord) {
+	c.enqueue(flowAuditEvent(record))
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (c *SyslogCollector) CollectContainerEvent(record *ContainerRecord) {
+	c.enqueue(containerAuditEvent(record))
+}
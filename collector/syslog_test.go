@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSyslogFormats(t *testing.T) {
+	Convey("Given the audit event of a rejected flow", t, func() {
+		record := testFlowRecord(policy.Reject, PolicyDrop)
+		record.PolicyID = "p=1"
+		event := flowAuditEvent(record)
+
+		Convey("Its CEF message should have the decision and the escaped fields", func() {
+			m := formatCEF(event)
+			So(m, ShouldStartWith, "CEF:0|Aporeto|Trireme|1.0|flow-reject|Flow rejected|7|act=reject src=1.1.1.1 cs2=A dst=2.2.2.2 cs3=pu1 dpt=80 cs1=p\\=1 reason=policy")
+			So(m, ShouldContainSubstring, "cs1Label=policyID")
+			So(m, ShouldNotContainSubstring, "spt=")
+		})
+
+		Convey("Its LEEF message should have the decision in tab separated attributes", func() {
+			m := formatLEEF(event)
+			So(m, ShouldStartWith, "LEEF:1.0|Aporeto|Trireme|1.0|flow-reject|sev=7\taction=reject\tsrc=1.1.1.1\t")
+			So(m, ShouldContainSubstring, "\tpolicyID=p=1\t")
+			So(m, ShouldNotContainSubstring, "Label")
+		})
+	})
+
+	Convey("Given the audit event of a quarantined PU", t, func() {
+		event := containerAuditEvent(&ContainerRecord{
+			ContextID: "pu1",
+			IPAddress: policy.ExtendedMap{policy.DefaultNamespace: "2.2.2.2"},
+			Event:     ContainerQuarantined,
+		})
+
+		Convey("It should have a high severity", func() {
+			So(formatCEF(event), ShouldEqual, "CEF:0|Aporeto|Trireme|1.0|pu-quarantine|PU quarantine|8|dvc=2.2.2.2 cs4=pu1 cs4Label=contextID")
+		})
+	})
+}
+
+func TestSyslogCollector(t *testing.T) {
+	Convey("Given a syslog server over UDP", t, func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer conn.Close() // nolint
+
+		Convey("When I collect a flow, it should send its CEF message with the syslog header", func() {
+			c, err := NewSyslogCollector("udp", conn.LocalAddr().String(), OptionSyslogFacility(16))
+			So(err, ShouldBeNil)
+
+			c.CollectFlowEvent(testFlowRecord(policy.Accept, ""))
+			c.Close()
+
+			buf := make([]byte, 2048)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second)) // nolint
+			n, _, err := conn.ReadFrom(buf)
+			So(err, ShouldBeNil)
+
+			m := string(buf[:n])
+			So(m, ShouldStartWith, "<134>1 ")
+			So(strings.Contains(m, " trireme "), ShouldBeTrue)
+			So(m, ShouldContainSubstring, " flow-accept - CEF:0|Aporeto|Trireme|1.0|flow-accept|Flow accepted|3|act=accept")
+			So(c.Dropped(), ShouldEqual, 0)
+		})
+
+		Convey("When I use an invalid network, it should fail", func() {
+			_, err := NewSyslogCollector("unix", conn.LocalAddr().String())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -19,6 +19,28 @@ const (
 	LocalServer
 )
 
+// ImplementationType defines the packet filter implementation of the supervisor.
+type ImplementationType int
+
+const (
+	// IPTables indicates that the supervisor programs the rules with iptables and ipsets
+	IPTables ImplementationType = iota
+	// IPSets indicates that the supervisor programs the rules with iptables and
+	// matches the ACLs of the PUs with ipsets instead of one rule per ACL
+	IPSets
+)
+
+// FailMode defines what happens to the traffic of a PU when its enforcer stops
+// reading the queues, for example when a remote enforcer crashes.
+type FailMode int
+
+const (
+	// FailClosed drops the packets that are trapped for the enforcer
+	FailClosed FailMode = iota
+	// FailOpen accepts the packets that are trapped for the enforcer
+	FailOpen
+)
+
 // PUType defines the PU type
 type PUType int
 
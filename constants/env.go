@@ -8,6 +8,9 @@ const (
 	// AporetoEnvContextSocket stores the path to the context specific socket
 	AporetoEnvContextSocket = "APORETO_ENV_SOCKET_PATH"
 
+	// AporetoEnvContextID stores the context ID of the PU the remote enforcer was launched for
+	AporetoEnvContextID = "APORETO_ENV_CONTEXT_ID"
+
 	// AporetoEnvStatsChannel stores the path to the stats channel
 	AporetoEnvStatsChannel = "APORETO_ENV_STATS_CHANNEL_PATH"
 
@@ -17,6 +20,22 @@ const (
 	// AporetoEnvStatsSecret is the secret to be used for the stats channel
 	AporetoEnvStatsSecret = "APORETO_ENV_STATS_SECRET"
 
+	// AporetoEnvStatsInterval is the interval, like 5s, at which the flows
+	// are aggregated before they are sent on the stats channel
+	AporetoEnvStatsInterval = "APORETO_ENV_STATS_INTERVAL"
+
+	// AporetoEnvStatsSampling is the rate at which the flow events are sampled
+	// before they are aggregated, like 10 to keep one in ten
+	AporetoEnvStatsSampling = "APORETO_ENV_STATS_SAMPLING"
+
+	// AporetoEnvStatsBufferDir is the directory where the stats that cannot
+	// be sent on the stats channel are buffered. They are lost if it is empty.
+	AporetoEnvStatsBufferDir = "APORETO_ENV_STATS_BUFFER_DIR"
+
+	// AporetoEnvStatsBufferSize is the maximum size in bytes of the buffered
+	// stats
+	AporetoEnvStatsBufferSize = "APORETO_ENV_STATS_BUFFER_SIZE"
+
 	// AporetoEnvContainerPID is the PID of the container
 	AporetoEnvContainerPID = "APORETO_ENV_CONTAINER_PID"
 
@@ -32,6 +51,18 @@ const (
 	// AporetoEnvLogLevel store the log level to be used.
 	AporetoEnvLogLevel = "APORETO_ENV_LOG_LEVEL"
 
+	// AporetoEnvLogForwardLevel stores the level of the logs forwarded to the controller.
+	AporetoEnvLogForwardLevel = "APORETO_ENV_LOG_FORWARD_LEVEL"
+
+	// AporetoEnvCrashDir stores the directory where the remote enforcer writes its crash reports.
+	AporetoEnvCrashDir = "APORETO_ENV_CRASH_DIR"
+
+	// AporetoEnvHardening specifies if the remote enforcer drops its capabilities and filters its syscalls.
+	AporetoEnvHardening = "APORETO_ENV_HARDENING"
+
+	// AporetoEnvHardeningEnable specifies value to enable the hardening of the remote enforcer.
+	AporetoEnvHardeningEnable = "1"
+
 	// AporetoEnvLogFormat store the log format to be used.
 	AporetoEnvLogFormat = "APORETO_ENV_LOG_FORMAT"
 
@@ -0,0 +1,251 @@
+package diagnostics
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/connection"
+	"github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/datapath/tokenaccessor"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/bvandewalle/go-ipset/ipset"
+)
+
+const (
+	// diagnosticsContextID is the context used by the checks that need a PU
+	diagnosticsContextID = "trireme-diagnostics"
+	// diagnosticsQueueID is the queue number bound by the nfqueue check. It is
+	// outside the range of queues used by the default filter queue configuration.
+	diagnosticsQueueID = 65000
+	// pingResponse is the status returned by the diagnostics rpc server
+	pingResponse = "pong"
+	// pingTimeout is the time the diagnostics rpc server has to answer
+	pingTimeout = 5 * time.Second
+)
+
+// kernelFeatures are the files exposed by the kernel when a feature
+// required by trireme is available
+var kernelFeatures = map[string]string{
+	"conntrack":       "/proc/sys/net/netfilter/nf_conntrack_tcp_be_liberal",
+	"nfnetlink_queue": "/proc/net/netfilter/nfnetlink_queue",
+	"nfnetlink_log":   "/proc/net/netfilter/nfnetlink_log",
+	"ip_set":          "/sys/module/ip_set",
+}
+
+// cgroupRoot is the mount point of the cgroup hierarchies
+var cgroupRoot = "/sys/fs/cgroup"
+
+// commands are the binaries trireme relies on
+var commands = []string{"iptables", "ipset", "sysctl"}
+
+// checkKernelFeatures validates that the kernel modules and the commands
+// required by trireme are available
+func checkKernelFeatures() error {
+
+	missing := []string{}
+
+	for feature, path := range kernelFeatures {
+		if _, err := os.Stat(path); err != nil {
+			missing = append(missing, feature)
+		}
+	}
+
+	for _, command := range commands {
+		if _, err := exec.LookPath(command); err != nil {
+			missing = append(missing, command+" command")
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing kernel features or commands: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// checkNetClsCgroup validates that the net_cls cgroup that marks the packets
+// of the Linux processes is mounted. The hosts with only the unified hierarchy
+// of cgroup v2 have no net_cls controller, and the check is skipped since the
+// containers are enforced in their network namespace without it.
+func checkNetClsCgroup() error {
+
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "net_cls")); err == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return skip("cgroup v2 unified hierarchy without net_cls: linux processes cannot be enforced")
+	}
+
+	return errors.New("net_cls cgroup not mounted")
+}
+
+// checkIpsetCreate validates that ipsets can be created and programmed
+func checkIpsetCreate() error {
+
+	name := fmt.Sprintf("TRI-Diag-%d", os.Getpid())
+
+	set, err := provider.NewGoIPsetProvider().NewIpset(name, "hash:net", &ipset.Params{})
+	if err != nil {
+		return fmt.Errorf("unable to create ipset %s: %s", name, err)
+	}
+
+	defer set.Destroy() // nolint
+
+	if err := set.Add("10.0.0.0/8", 0); err != nil {
+		return fmt.Errorf("unable to add entry to ipset %s: %s", name, err)
+	}
+
+	found, err := set.Test("10.1.1.1")
+	if err != nil {
+		return fmt.Errorf("unable to test entry in ipset %s: %s", name, err)
+	}
+
+	if !found {
+		return fmt.Errorf("entry not found in ipset %s", name)
+	}
+
+	return nil
+}
+
+// checkTokenHandshake runs the Syn, SynAck and Ack token exchange between
+// two ends of a loopback connection with the given secrets
+func checkTokenHandshake(serverID string, s secrets.Secrets) error {
+
+	if s == nil {
+		return skip("no secrets configured")
+	}
+
+	accessor, err := tokenaccessor.New(serverID, time.Minute, s)
+	if err != nil {
+		return fmt.Errorf("unable to create token engine: %s", err)
+	}
+
+	puInfo := policy.NewPUInfo(diagnosticsContextID, constants.ContainerPU)
+	puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, diagnosticsContextID)
+
+	context, err := pucontext.NewPU(diagnosticsContextID, puInfo, time.Second)
+	if err != nil {
+		return fmt.Errorf("unable to create pu context: %s", err)
+	}
+
+	client := &connection.AuthInfo{}
+	server := &connection.AuthInfo{}
+
+	token, err := accessor.CreateSynPacketToken(context, client)
+	if err != nil {
+		return fmt.Errorf("unable to create syn token: %s", err)
+	}
+
+	if _, err = accessor.ParsePacketToken(server, token); err != nil {
+		return fmt.Errorf("unable to parse syn token: %s", err)
+	}
+
+	if token, err = accessor.CreateSynAckPacketToken(context, server); err != nil {
+		return fmt.Errorf("unable to create synack token: %s", err)
+	}
+
+	if _, err = accessor.ParsePacketToken(client, token); err != nil {
+		return fmt.Errorf("unable to parse synack token: %s", err)
+	}
+
+	if token, err = accessor.CreateAckPacketToken(context, client); err != nil {
+		return fmt.Errorf("unable to create ack token: %s", err)
+	}
+
+	if _, err = accessor.ParseAckToken(server, token); err != nil {
+		return fmt.Errorf("unable to parse ack token: %s", err)
+	}
+
+	return nil
+}
+
+// Server is the rpc server used by the rpc channel check
+type Server struct {
+	secret string
+}
+
+// Ping validates the request and responds with a pong
+func (s *Server) Ping(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !rpcwrapper.NewRPCServer().CheckValidity(&req, s.secret) {
+		return errors.New("message sent by controller failed the validity check")
+	}
+
+	resp.Status = pingResponse
+
+	return nil
+}
+
+// checkRPCChannel runs a round trip on an rpc channel between a client and
+// a server in the same setup as between the controller and the remote enforcers
+func checkRPCChannel() error {
+
+	dir, err := ioutil.TempDir("", diagnosticsContextID)
+	if err != nil {
+		return fmt.Errorf("unable to create channel directory: %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	channel := filepath.Join(dir, "diagnostics.sock")
+
+	listener, err := net.Listen("unix", channel)
+	if err != nil {
+		return fmt.Errorf("unable to listen on channel: %s", err)
+	}
+	defer listener.Close() // nolint
+
+	secretBytes := make([]byte, 16)
+	if _, err = rand.Read(secretBytes); err != nil {
+		return fmt.Errorf("unable to generate rpc secret: %s", err)
+	}
+	secret := hex.EncodeToString(secretBytes)
+
+	server := rpc.NewServer()
+	if err = server.Register(&Server{secret: secret}); err != nil {
+		return fmt.Errorf("unable to register rpc server: %s", err)
+	}
+
+	go http.Serve(listener, server) // nolint
+
+	client := rpcwrapper.NewRPCWrapper()
+	if err = client.NewRPCClient(diagnosticsContextID, channel, secret); err != nil {
+		return fmt.Errorf("unable to connect rpc client: %s", err)
+	}
+	defer client.DestroyRPCClient(diagnosticsContextID)
+
+	req := &rpcwrapper.Request{
+		Payload: rpcwrapper.UnEnforcePayload{ContextID: diagnosticsContextID},
+	}
+	resp := &rpcwrapper.Response{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	if err = client.RemoteCall(ctx, diagnosticsContextID, "Server.Ping", req, resp); err != nil {
+		return fmt.Errorf("rpc round trip failed: %s", err)
+	}
+
+	if resp.Status != pingResponse {
+		return fmt.Errorf("unexpected rpc response: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package diagnostics
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+)
+
+// Status is the outcome of a single diagnostic check
+type Status string
+
+const (
+	// Passed indicates that the check succeeded
+	Passed Status = "pass"
+	// Failed indicates that the check failed
+	Failed Status = "fail"
+	// Skipped indicates that the check could not run in this environment
+	Skipped Status = "skip"
+)
+
+// Result is the result of a single diagnostic check
+type Result struct {
+	Name     string        `json:"name"`
+	Status   Status        `json:"status"`
+	Message  string        `json:"message,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report is the structured report of all the diagnostic checks
+type Report struct {
+	ServerID string    `json:"serverID"`
+	Time     time.Time `json:"time"`
+	Results  []*Result `json:"results"`
+}
+
+// Healthy returns true if none of the checks failed
+func (r *Report) Healthy() bool {
+
+	for _, result := range r.Results {
+		if result.Status == Failed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// skipError signals that a check could not run and must be skipped
+type skipError struct {
+	reason string
+}
+
+func (s *skipError) Error() string {
+	return s.reason
+}
+
+// skip returns an error that marks a check as skipped
+func skip(format string, a ...interface{}) error {
+	return &skipError{reason: fmt.Sprintf(format, a...)}
+}
+
+// check is a named diagnostic check
+type check struct {
+	name string
+	run  func() error
+}
+
+// Run runs all the self checks of a trireme installation end to end and
+// returns the report. The token handshake is skipped if no secrets are given.
+func Run(serverID string, s secrets.Secrets) *Report {
+
+	checks := []check{
+		{name: "kernel-features", run: checkKernelFeatures},
+		{name: "netcls-cgroup", run: checkNetClsCgroup},
+		{name: "nfqueue-bind", run: checkNfqueueBind},
+		{name: "ipset-create", run: checkIpsetCreate},
+		{name: "token-handshake", run: func() error { return checkTokenHandshake(serverID, s) }},
+		{name: "rpc-channel", run: checkRPCChannel},
+	}
+
+	report := &Report{
+		ServerID: serverID,
+		Time:     time.Now(),
+		Results:  make([]*Result, 0, len(checks)),
+	}
+
+	for _, c := range checks {
+		report.Results = append(report.Results, runCheck(c))
+	}
+
+	return report
+}
+
+// runCheck runs a single check and converts its outcome into a result
+func runCheck(c check) *Result {
+
+	start := time.Now()
+	err := c.run()
+
+	result := &Result{
+		Name:     c.name,
+		Status:   Passed,
+		Duration: time.Since(start),
+	}
+
+	if err != nil {
+		result.Message = err.Error()
+		result.Status = Failed
+		if _, ok := err.(*skipError); ok {
+			result.Status = Skipped
+		}
+	}
+
+	zap.L().Debug("Diagnostic check completed",
+		zap.String("check", result.Name),
+		zap.String("status", string(result.Status)),
+		zap.String("message", result.Message),
+	)
+
+	return result
+}
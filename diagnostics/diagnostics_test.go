@@ -0,0 +1,109 @@
+package diagnostics
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRunCheck(t *testing.T) {
+
+	Convey("When I run a check that succeeds", t, func() {
+		r := runCheck(check{name: "ok", run: func() error { return nil }})
+
+		Convey("The check should pass", func() {
+			So(r.Name, ShouldEqual, "ok")
+			So(r.Status, ShouldEqual, Passed)
+			So(r.Message, ShouldBeEmpty)
+		})
+	})
+
+	Convey("When I run a check that fails", t, func() {
+		r := runCheck(check{name: "ko", run: func() error { return errors.New("broken") }})
+
+		Convey("The check should fail with the error", func() {
+			So(r.Status, ShouldEqual, Failed)
+			So(r.Message, ShouldEqual, "broken")
+		})
+	})
+
+	Convey("When I run a check that is skipped", t, func() {
+		r := runCheck(check{name: "skip", run: func() error { return skip("not %s", "here") }})
+
+		Convey("The check should be skipped", func() {
+			So(r.Status, ShouldEqual, Skipped)
+			So(r.Message, ShouldEqual, "not here")
+		})
+	})
+}
+
+func TestReportHealthy(t *testing.T) {
+
+	report := &Report{Results: []*Result{{Status: Passed}, {Status: Skipped}}}
+	if !report.Healthy() {
+		t.Errorf("Report with passed and skipped checks must be healthy")
+	}
+
+	report.Results = append(report.Results, &Result{Status: Failed})
+	if report.Healthy() {
+		t.Errorf("Report with a failed check must not be healthy")
+	}
+}
+
+func TestCheckTokenHandshake(t *testing.T) {
+
+	if err := checkTokenHandshake("server", nil); err == nil {
+		t.Errorf("Token handshake without secrets must be skipped")
+	} else if _, ok := err.(*skipError); !ok {
+		t.Errorf("Token handshake without secrets must be skipped: %s", err)
+	}
+
+	if err := checkTokenHandshake("server", secrets.NewPSKSecrets([]byte("Dummy Test Password"))); err != nil {
+		t.Errorf("Token handshake failed: %s", err)
+	}
+}
+
+func TestCheckNetClsCgroup(t *testing.T) {
+
+	Convey("Given a cgroup mount point", t, func() {
+		root, err := ioutil.TempDir("", "cgroup")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root) // nolint
+
+		saved := cgroupRoot
+		cgroupRoot = root
+		defer func() { cgroupRoot = saved }()
+
+		Convey("When the net_cls cgroup is mounted, the check should pass", func() {
+			So(os.Mkdir(filepath.Join(root, "net_cls"), 0700), ShouldBeNil)
+			So(checkNetClsCgroup(), ShouldBeNil)
+		})
+
+		Convey("When only the unified hierarchy is mounted, the check should be skipped", func() {
+			So(ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory pids"), 0644), ShouldBeNil)
+			err := checkNetClsCgroup()
+			So(err, ShouldNotBeNil)
+			_, skipped := err.(*skipError)
+			So(skipped, ShouldBeTrue)
+		})
+
+		Convey("When no net_cls cgroup is mounted, the check should fail", func() {
+			err := checkNetClsCgroup()
+			So(err, ShouldNotBeNil)
+			_, skipped := err.(*skipError)
+			So(skipped, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCheckRPCChannel(t *testing.T) {
+
+	if err := checkRPCChannel(); err != nil {
+		t.Errorf("RPC channel round trip failed: %s", err)
+	}
+}
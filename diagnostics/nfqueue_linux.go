@@ -0,0 +1,31 @@
+// +build linux
+
+package diagnostics
+
+import (
+	"fmt"
+
+	nfqueue "github.com/aporeto-inc/netlink-go/nfqueue"
+)
+
+// checkNfqueueBind validates that the process can bind to a netfilter queue
+func checkNfqueueBind() error {
+
+	queue, err := nfqueue.CreateAndStartNfQueue(
+		diagnosticsQueueID,
+		1,
+		nfqueue.NfDefaultPacketSize,
+		func(*nfqueue.NFPacket, interface{}) {},
+		func(error, interface{}) {},
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to bind to queue %d: %s", diagnosticsQueueID, err)
+	}
+
+	if err := queue.StopQueue(); err != nil {
+		return fmt.Errorf("unable to unbind from queue %d: %s", diagnosticsQueueID, err)
+	}
+
+	return nil
+}
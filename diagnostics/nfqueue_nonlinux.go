@@ -0,0 +1,9 @@
+// +build !linux
+
+package diagnostics
+
+// checkNfqueueBind is skipped since netfilter queues are only available on linux
+func checkNfqueueBind() error {
+
+	return skip("netfilter queues are only supported on linux")
+}
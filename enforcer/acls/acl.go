@@ -13,14 +13,20 @@ import (
 )
 
 func newACL() *acl {
+	return newProtocolACL("tcp")
+}
+
+func newProtocolACL(protocol string) *acl {
 	return &acl{
+		protocol:         protocol,
 		sortedPrefixLens: make([]int, 0),
 		prefixLenMap:     make(map[int]*prefixRules),
 	}
 }
 
-// acl holds all the ACLS in an internal DB
+// acl holds all the ACLS of a protocol in an internal DB
 type acl struct {
+	protocol         string
 	sortedPrefixLens []int
 	prefixLenMap     map[int]*prefixRules
 }
@@ -38,7 +44,7 @@ func (a *acl) addRule(rule policy.IPRule) (err error) {
 
 	var subnet, mask uint32
 
-	if strings.ToLower(rule.Protocol) != "tcp" {
+	if strings.ToLower(rule.Protocol) != a.protocol {
 		return nil
 	}
 
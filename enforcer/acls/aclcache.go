@@ -2,6 +2,7 @@ package acls
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -10,10 +11,19 @@ var catchAllPolicy = &policy.FlowPolicy{Action: policy.Reject, PolicyID: "defaul
 
 // ACLCache holds all the ACLS in an internal DB
 // map[prefixes][subnets] -> list of ports with their actions
+// The ACLs are grouped by priority and the groups are searched by increasing
+// priority.
 type ACLCache struct {
-	reject  *acl
-	accept  *acl
-	observe *acl
+	protocol string
+	groups   []*aclGroup
+}
+
+// aclGroup holds the ACLs of a priority
+type aclGroup struct {
+	priority int
+	reject   *acl
+	accept   *acl
+	observe  *acl
 }
 
 type prefixRules struct {
@@ -21,27 +31,59 @@ type prefixRules struct {
 	rules map[uint32]portActionList
 }
 
-// NewACLCache creates a new ACL cache
+// NewACLCache creates a new ACL cache for the TCP rules
 func NewACLCache() *ACLCache {
+	return NewProtocolACLCache("tcp")
+}
+
+// NewProtocolACLCache creates a new ACL cache for the rules of the given
+// protocol. Rules of other protocols are ignored.
+func NewProtocolACLCache(protocol string) *ACLCache {
 	return &ACLCache{
-		reject:  newACL(),
-		accept:  newACL(),
-		observe: newACL(),
+		protocol: protocol,
+		groups:   []*aclGroup{},
+	}
+}
+
+// group returns the group of the ACLs of a priority, and creates it if needed
+func (c *ACLCache) group(priority int) *aclGroup {
+
+	i := sort.Search(len(c.groups), func(i int) bool {
+		return c.groups[i].priority >= priority
+	})
+
+	if i < len(c.groups) && c.groups[i].priority == priority {
+		return c.groups[i]
+	}
+
+	g := &aclGroup{
+		priority: priority,
+		reject:   newProtocolACL(c.protocol),
+		accept:   newProtocolACL(c.protocol),
+		observe:  newProtocolACL(c.protocol),
 	}
+
+	c.groups = append(c.groups, nil)
+	copy(c.groups[i+1:], c.groups[i:])
+	c.groups[i] = g
+
+	return g
 }
 
 // AddRule adds a single rule to the ACL Cache
 func (c *ACLCache) AddRule(rule policy.IPRule) (err error) {
 
+	g := c.group(rule.Priority)
+
 	if rule.Policy.ObserveAction.ObserveApply() {
-		return c.observe.addRule(rule)
+		return g.observe.addRule(rule)
 	}
 
 	if rule.Policy.Action.Accepted() {
-		return c.accept.addRule(rule)
+		return g.accept.addRule(rule)
 	}
 
-	return c.reject.addRule(rule)
+	return g.reject.addRule(rule)
 }
 
 // AddRuleList adds a list of rules to the cache
@@ -53,28 +95,26 @@ func (c *ACLCache) AddRuleList(rules policy.IPRuleList) (err error) {
 		}
 	}
 
-	c.reject.reverseSort()
-	c.accept.reverseSort()
-	c.observe.reverseSort()
+	for _, g := range c.groups {
+		g.reject.reverseSort()
+		g.accept.reverseSort()
+		g.observe.reverseSort()
+	}
 	return
 }
 
-// GetMatchingAction gets the matching action
+// GetMatchingAction gets the matching action. The groups of ACLs are searched
+// by increasing priority, and the reject, accept and observe ACLs of a group in
+// that order. The first ACL that matches decides the action.
 func (c *ACLCache) GetMatchingAction(ip []byte, port uint16) (report *policy.FlowPolicy, packet *policy.FlowPolicy, err error) {
 
-	report, packet, err = c.reject.getMatchingAction(ip, port, report)
-	if err == nil {
-		return
-	}
-
-	report, packet, err = c.accept.getMatchingAction(ip, port, report)
-	if err == nil {
-		return
-	}
-
-	report, packet, err = c.observe.getMatchingAction(ip, port, report)
-	if err == nil {
-		return
+	for _, g := range c.groups {
+		for _, a := range []*acl{g.reject, g.accept, g.observe} {
+			report, packet, err = a.getMatchingAction(ip, port, report)
+			if err == nil {
+				return
+			}
+		}
 	}
 
 	if report == nil {
@@ -200,3 +200,101 @@ func TestObserveContinueApplyCacheLookup(t *testing.T) {
 		})
 	})
 }
+
+func TestProtocolACLCacheLookup(t *testing.T) {
+
+	rules = policy.IPRuleList{
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Port:     "53",
+			Protocol: "UDP",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "udp10/8"},
+		},
+		policy.IPRule{
+			Address:  "10.0.0.0/8",
+			Port:     "80",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcp10/8"},
+		},
+	}
+
+	Convey("Given a UDP ACL Cache with tcp and udp rules", t, func() {
+		c := NewProtocolACLCache("udp")
+		So(c, ShouldNotBeNil)
+		err := c.AddRuleList(rules)
+		So(err, ShouldBeNil)
+
+		Convey("When I lookup for a matching udp port, I should get accept", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 53)
+			So(err, ShouldBeNil)
+			So(p.Action, ShouldEqual, policy.Accept)
+			So(p.PolicyID, ShouldEqual, "udp10/8")
+		})
+
+		Convey("When I lookup for a tcp port, I should get reject", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 80)
+			So(err, ShouldNotBeNil)
+			So(p.Action, ShouldEqual, policy.Reject)
+		})
+	})
+}
+
+func TestPriorityCacheLookup(t *testing.T) {
+
+	rules := policy.IPRuleList{
+		policy.IPRule{
+			Address:  "0.0.0.0/0",
+			Port:     "1",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: "catchAllDrop"},
+		},
+		policy.IPRule{
+			Address:  "172.0.0.0/8",
+			Port:     "1",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: "tcp172/8"},
+			Priority: -1,
+		},
+		policy.IPRule{
+			Address:  "172.1.0.0/16",
+			Port:     "1",
+			Protocol: "tcp",
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: "tcp172.1/16"},
+			Priority: -2,
+		},
+	}
+
+	Convey("Given an ACL Cache with rules of different priorities", t, func() {
+		c := NewACLCache()
+		err := c.AddRuleList(rules)
+		So(err, ShouldBeNil)
+
+		Convey("When I lookup for an address matched by all the rules, I should get the rule with the lowest priority", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("172.1.1.1").To4(), 1)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "tcp172.1/16")
+		})
+
+		Convey("When I lookup for an address matched by the accept and the default rules, I should get the accept", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("172.2.1.1").To4(), 1)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "tcp172/8")
+		})
+
+		Convey("When I lookup for an address matched by the default rule only, I should get the reject", func() {
+			_, p, err := c.GetMatchingAction(net.ParseIP("10.1.1.1").To4(), 1)
+			So(err, ShouldBeNil)
+			So(p.PolicyID, ShouldEqual, "catchAllDrop")
+		})
+	})
+}
@@ -40,6 +40,10 @@ const (
 
 	// TCPData indicates that the packets are now data packets
 	TCPData
+
+	// TCPServerNamePending indicates that the flow to an external service
+	// waits for the server name of the TLS ClientHello to be authorized
+	TCPServerNamePending
 )
 
 const (
@@ -76,10 +80,16 @@ type AuthInfo struct {
 	RemoteContext        []byte
 	RemoteContextID      string
 	RemotePublicKey      interface{}
+	RemoteTransmittedKey []byte
 	RemoteIP             string
 	RemotePort           string
 	LocalServiceContext  []byte
 	RemoteServiceContext []byte
+	LocalUserToken       string
+	RemoteUserToken      string
+	RemoteClaims         map[string]string
+	// RemoteTags are the tags of the remote PU received in its identity token
+	RemoteTags *policy.TagStore
 }
 
 // TCPConnection is information regarding TCP Connection
@@ -89,6 +99,11 @@ type TCPConnection struct {
 	state TCPFlowState
 	Auth  AuthInfo
 
+	// CrossedAuth keeps the authentication state of the Syn packet received
+	// from the remote in a simultaneous open, and of the SynAck packet sent in
+	// reply to it. Auth keeps the state of the Syn packet of the application.
+	CrossedAuth AuthInfo
+
 	// Debugging Information
 	flowReported int
 
@@ -108,11 +123,26 @@ type TCPConnection struct {
 	// ServiceConnection indicates that this connection is handled by a service
 	ServiceConnection bool
 
+	// SimultaneousOpen indicates that both ends sent a Syn packet for this
+	// connection. The handshake completes when both SynAck packets are seen.
+	SimultaneousOpen bool
+
 	// ReportFlowPolicy holds the last matched observed policy
 	ReportFlowPolicy *policy.FlowPolicy
 
 	// PacketFlowPolicy holds the last matched actual policy
 	PacketFlowPolicy *policy.FlowPolicy
+
+	// HandshakeStart is the time of the first Syn packet of the connection,
+	// HandshakeReply the time of the last packet of the handshake sent by the
+	// PU, and HandshakeEnd the time of the reply of the remote to it
+	HandshakeStart time.Time
+	HandshakeReply time.Time
+	HandshakeEnd   time.Time
+
+	// AuthorizationLatency is the time spent by the enforcer to create and
+	// validate the tokens of the handshake
+	AuthorizationLatency time.Duration
 }
 
 // TCPConnectionExpirationNotifier handles processing the expiration of an element
@@ -175,6 +205,82 @@ func NewTCPConnection(context *pucontext.PUContext) *TCPConnection {
 	}
 }
 
+// UDPFlowState identifies the constants of the state of a UDP flow
+type UDPFlowState int
+
+const (
+	// UDPStart indicates that no datagram of the flow has been processed yet
+	UDPStart UDPFlowState = iota
+
+	// UDPClientSendToken indicates that the client attaches its token to the
+	// datagrams of the flow until it receives the token of the server
+	UDPClientSendToken
+
+	// UDPServerSendToken indicates that the server has authorized the client
+	// and attaches its token to the replies until the client stops sending
+	// its own token
+	UDPServerSendToken
+
+	// UDPClientAuthorized indicates that the client has authorized the server
+	// and sends datagrams without token
+	UDPClientAuthorized
+
+	// UDPData indicates that the flow is authorized and released to the kernel
+	UDPData
+)
+
+// UDPConnection is information regarding a UDP flow. There is no handshake in
+// UDP and the tokens are carried by the first datagrams of the flow.
+type UDPConnection struct {
+	sync.Mutex
+
+	state UDPFlowState
+	Auth  AuthInfo
+
+	// Context is the pucontext.PUContext that is associated with this flow
+	Context *pucontext.PUContext
+
+	// LocalToken is the token attached to the datagrams of the flow. The same
+	// token is repeated on every datagram until the handshake completes.
+	LocalToken []byte
+
+	// RemoteToken is the last token of the peer that was validated
+	RemoteToken []byte
+
+	// ReportFlowPolicy holds the last matched observed policy
+	ReportFlowPolicy *policy.FlowPolicy
+
+	// PacketFlowPolicy holds the last matched actual policy
+	PacketFlowPolicy *policy.FlowPolicy
+}
+
+// NewUDPConnection returns a UDPConnection information struct
+func NewUDPConnection(context *pucontext.PUContext) *UDPConnection {
+
+	return &UDPConnection{
+		state:   UDPStart,
+		Context: context,
+	}
+}
+
+// GetState is used to return the state
+func (c *UDPConnection) GetState() UDPFlowState {
+
+	return c.state
+}
+
+// SetState is used to setup the state for the UDP flow
+func (c *UDPConnection) SetState(state UDPFlowState) {
+
+	c.state = state
+}
+
+// String returns a printable version of connection
+func (c *UDPConnection) String() string {
+
+	return fmt.Sprintf("state:%d auth: %+v", c.state, c.Auth)
+}
+
 // ProxyConnection is a record to keep state of proxy auth
 type ProxyConnection struct {
 	sync.Mutex
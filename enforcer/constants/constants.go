@@ -13,4 +13,10 @@ const (
 	DefaultNetwork = "0.0.0.0/0"
 	// DefaultExternalIPTimeout is the default used for the cache for External IPTimeout.
 	DefaultExternalIPTimeout = "500ms"
+	// DefaultIdleFlowTimeout is the time an authorized flow is retained after it
+	// was released to the kernel, so that it can be re-validated if its connmark is lost.
+	DefaultIdleFlowTimeout = "2h"
+	// DefaultConnectionMetricsInterval is the interval at which the connection
+	// metrics of every PU are reported to the collector.
+	DefaultConnectionMetricsInterval = "10s"
 )
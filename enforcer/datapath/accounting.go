@@ -0,0 +1,299 @@
+package datapath
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+const (
+	conntrackPath     = "/proc/net/nf_conntrack"
+	conntrackAcctPath = "/proc/sys/net/netfilter/nf_conntrack_acct"
+)
+
+// accountedFlow is an accepted flow whose traffic is reported. The counters
+// are the totals of the flow when it was last reported.
+type accountedFlow struct {
+	record collector.FlowRecord
+	total  collector.FlowAccounting
+	// seen is set when the flow is found in the conntrack table
+	seen bool
+}
+
+// conntrackFlow is an entry of the conntrack table. The reply tuple is the
+// original one reversed if the flow is not translated. The state is only set
+// for the tcp entries, and the accounting only when the conntrack accounting
+// of the kernel is enabled.
+type conntrackFlow struct {
+	original   string
+	reply      string
+	state      string
+	mark       uint32
+	accounted  bool
+	accounting collector.FlowAccounting
+}
+
+// SetFlowAccounting enables the accounting of the bytes and packets of the
+// accepted flows, which are reported to the collector at the given interval.
+// It must be called before Start. The traffic is read from the conntrack
+// table, so the conntrack accounting of the kernel is enabled.
+func (d *Datapath) SetFlowAccounting(interval time.Duration) {
+
+	d.accountingLock.Lock()
+	defer d.accountingLock.Unlock()
+
+	d.accountingInterval = interval
+	d.accountedFlows = map[string]*accountedFlow{}
+}
+
+// flowTuple returns the key of a flow from its endpoints
+func flowTuple(srcIP string, srcPort uint16, dstIP string, dstPort uint16) string {
+
+	return srcIP + ":" + strconv.Itoa(int(srcPort)) + "-" + dstIP + ":" + strconv.Itoa(int(dstPort))
+}
+
+// trackFlowAccounting starts the accounting of a flow that was reported as
+// accepted
+func (d *Datapath) trackFlowAccounting(record *collector.FlowRecord) {
+
+	if d.accountingInterval <= 0 || !record.Action.Accepted() {
+		return
+	}
+
+	d.accountingLock.Lock()
+	defer d.accountingLock.Unlock()
+
+	key := flowTuple(record.Source.IP, record.Source.Port, record.Destination.IP, record.Destination.Port)
+	if _, ok := d.accountedFlows[key]; ok {
+		return
+	}
+
+	d.accountedFlows[key] = &accountedFlow{
+		record: *record,
+		seen:   true,
+	}
+}
+
+// updateFlowAccounting reports the traffic of the accounted flows since their
+// last report. The flows that are no longer in the conntrack table are
+// finished and are not accounted anymore.
+func (d *Datapath) updateFlowAccounting(flows []*conntrackFlow) {
+
+	records := []*collector.FlowRecord{}
+
+	d.accountingLock.Lock()
+
+	for _, flow := range d.accountedFlows {
+		flow.seen = false
+	}
+
+	for _, ct := range flows {
+		if !ct.accounted {
+			continue
+		}
+
+		flow, ok := d.accountedFlows[ct.original]
+		if !ok {
+			if flow, ok = d.accountedFlows[ct.reply]; !ok {
+				continue
+			}
+		}
+
+		flow.seen = true
+
+		delta := accountingDelta(&ct.accounting, &flow.total)
+		flow.total = ct.accounting
+
+		if delta.PacketsSent == 0 && delta.PacketsReceived == 0 {
+			continue
+		}
+
+		record := flow.record
+		record.Count = 0
+		record.Latency = nil
+		record.Accounting = delta
+		records = append(records, &record)
+	}
+
+	for key, flow := range d.accountedFlows {
+		if !flow.seen {
+			delete(d.accountedFlows, key)
+		}
+	}
+
+	d.accountingLock.Unlock()
+
+	for _, record := range records {
+		d.collector.CollectFlowEvent(record)
+	}
+}
+
+// accountingDelta returns the traffic of a flow since its last report. A
+// counter lower than the reported one belongs to a new entry of the flow.
+func accountingDelta(current, reported *collector.FlowAccounting) *collector.FlowAccounting {
+
+	delta := func(current, reported uint64) uint64 {
+		if current < reported {
+			return current
+		}
+		return current - reported
+	}
+
+	return &collector.FlowAccounting{
+		BytesSent:       delta(current.BytesSent, reported.BytesSent),
+		PacketsSent:     delta(current.PacketsSent, reported.PacketsSent),
+		BytesReceived:   delta(current.BytesReceived, reported.BytesReceived),
+		PacketsReceived: delta(current.PacketsReceived, reported.PacketsReceived),
+	}
+}
+
+// parseConntrackFlows returns the tcp and udp entries of a conntrack table in
+// the format of /proc/net/nf_conntrack
+func parseConntrackFlows(r io.Reader) ([]*conntrackFlow, error) {
+
+	flows := []*conntrackFlow{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || (fields[2] != "tcp" && fields[2] != "udp") {
+			continue
+		}
+
+		// Each direction has its own src, dst, sport, dport, packets and bytes
+		tuples := []map[string]string{}
+		mark := "0"
+		for _, field := range fields {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			if kv[0] == "mark" {
+				mark = kv[1]
+				continue
+			}
+			if kv[0] == "src" {
+				tuples = append(tuples, map[string]string{})
+			}
+			if len(tuples) > 0 && len(tuples) <= 2 {
+				tuples[len(tuples)-1][kv[0]] = kv[1]
+			}
+		}
+
+		if len(tuples) != 2 {
+			continue
+		}
+
+		flow, err := newConntrackFlow(tuples[0], tuples[1], mark)
+		if err != nil {
+			zap.L().Debug("Invalid conntrack entry", zap.String("entry", scanner.Text()), zap.Error(err))
+			continue
+		}
+
+		if !strings.Contains(fields[5], "=") {
+			flow.state = fields[5]
+		}
+
+		flows = append(flows, flow)
+	}
+
+	return flows, scanner.Err()
+}
+
+// newConntrackFlow returns the conntrack entry with the given original and
+// reply tuples and mark
+func newConntrackFlow(original, reply map[string]string, mark string) (*conntrackFlow, error) {
+
+	parse := func(strs ...string) ([]uint64, error) {
+		parsed := []uint64{}
+		for _, value := range strs {
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %s: %s", value, err)
+			}
+			parsed = append(parsed, v)
+		}
+		return parsed, nil
+	}
+
+	values, err := parse(original["sport"], original["dport"], reply["sport"], reply["dport"], mark)
+	if err != nil {
+		return nil, err
+	}
+
+	flow := &conntrackFlow{
+		original: flowTuple(original["src"], uint16(values[0]), original["dst"], uint16(values[1])),
+		reply:    flowTuple(reply["dst"], uint16(values[3]), reply["src"], uint16(values[2])),
+		mark:     uint32(values[4]),
+	}
+
+	if original["packets"] == "" || reply["packets"] == "" {
+		return flow, nil
+	}
+
+	counters, err := parse(original["bytes"], original["packets"], reply["bytes"], reply["packets"])
+	if err != nil {
+		return nil, err
+	}
+
+	flow.accounted = true
+	flow.accounting = collector.FlowAccounting{
+		BytesSent:       counters[0],
+		PacketsSent:     counters[1],
+		BytesReceived:   counters[2],
+		PacketsReceived: counters[3],
+	}
+
+	return flow, nil
+}
+
+// readConntrackFlows reads the entries of the conntrack table
+func readConntrackFlows() ([]*conntrackFlow, error) {
+
+	file, err := os.Open(conntrackPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // nolint
+
+	return parseConntrackFlows(file)
+}
+
+// startFlowAccounting enables the conntrack accounting and periodically
+// reports the traffic of the accepted flows
+func (d *Datapath) startFlowAccounting() {
+
+	if err := ioutil.WriteFile(conntrackAcctPath, []byte("1"), 0644); err != nil {
+		zap.L().Warn("Unable to enable the conntrack accounting", zap.Error(err))
+	}
+
+	d.accountingStop = make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(d.accountingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				flows, err := readConntrackFlows()
+				if err != nil {
+					zap.L().Warn("Unable to read the conntrack accounting", zap.Error(err))
+					continue
+				}
+				d.updateFlowAccounting(flows)
+			case <-d.accountingStop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,124 @@
+package datapath
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const conntrackTable = `ipv4     2 tcp      6 431999 ESTABLISHED src=10.1.1.1 dst=10.2.2.2 sport=40000 dport=80 packets=10 bytes=1000 src=10.2.2.2 dst=10.1.1.1 sport=80 dport=40000 packets=8 bytes=20000 [ASSURED] mark=0 zone=0 use=2
+ipv4     2 udp      17 29 src=10.1.1.1 dst=10.3.3.3 sport=50000 dport=53 packets=1 bytes=60 src=172.17.0.5 dst=10.1.1.1 sport=53 dport=50000 packets=1 bytes=120 mark=0 zone=0 use=2
+ipv4     2 icmp     1 29 src=10.1.1.1 dst=10.2.2.2 type=8 code=0 id=1 packets=1 bytes=84 src=10.2.2.2 dst=10.1.1.1 type=0 code=0 id=1 packets=1 bytes=84 mark=0 zone=0 use=2
+ipv4     2 tcp      6 431999 ESTABLISHED src=10.1.1.1 dst=10.2.2.2 sport=40001 dport=80 src=10.2.2.2 dst=10.1.1.1 sport=80 dport=40001 [ASSURED] mark=61166 zone=0 use=2
+`
+
+// flowCollector keeps the flow records it collects
+type flowCollector struct {
+	collector.DefaultCollector
+	records []*collector.FlowRecord
+}
+
+func (c *flowCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.records = append(c.records, record)
+}
+
+func TestParseConntrackFlows(t *testing.T) {
+
+	Convey("When I parse a conntrack table", t, func() {
+		flows, err := parseConntrackFlows(strings.NewReader(conntrackTable))
+		So(err, ShouldBeNil)
+
+		Convey("I should get the tcp and udp entries with their accounting", func() {
+			So(len(flows), ShouldEqual, 3)
+
+			So(flows[0].original, ShouldEqual, "10.1.1.1:40000-10.2.2.2:80")
+			So(flows[0].state, ShouldEqual, "ESTABLISHED")
+			So(flows[0].mark, ShouldEqual, 0)
+			So(flows[0].reply, ShouldEqual, "10.1.1.1:40000-10.2.2.2:80")
+			So(flows[0].accounting, ShouldResemble, collector.FlowAccounting{
+				BytesSent:       1000,
+				PacketsSent:     10,
+				BytesReceived:   20000,
+				PacketsReceived: 8,
+			})
+
+			So(flows[1].original, ShouldEqual, "10.1.1.1:50000-10.3.3.3:53")
+			So(flows[1].reply, ShouldEqual, "10.1.1.1:50000-172.17.0.5:53")
+			So(flows[1].state, ShouldBeEmpty)
+			So(flows[1].accounted, ShouldBeTrue)
+
+			So(flows[2].state, ShouldEqual, "ESTABLISHED")
+			So(flows[2].mark, ShouldEqual, 0xeeee)
+			So(flows[2].accounted, ShouldBeFalse)
+		})
+	})
+}
+
+func TestUpdateFlowAccounting(t *testing.T) {
+
+	Convey("Given a datapath with the flow accounting enabled", t, func() {
+		c := &flowCollector{}
+		d := &Datapath{collector: c}
+		d.SetFlowAccounting(time.Second)
+
+		record := &collector.FlowRecord{
+			ContextID:   "pu",
+			Count:       1,
+			Source:      &collector.EndPoint{IP: "10.1.1.1", Port: 50000},
+			Destination: &collector.EndPoint{IP: "172.17.0.5", Port: 53},
+			Action:      policy.Accept,
+			PolicyID:    "dns",
+		}
+		d.trackFlowAccounting(record)
+
+		Convey("If a rejected flow is reported, it should not be accounted", func() {
+			d.trackFlowAccounting(&collector.FlowRecord{
+				Source:      &collector.EndPoint{IP: "10.1.1.1", Port: 40000},
+				Destination: &collector.EndPoint{IP: "10.2.2.2", Port: 80},
+				Action:      policy.Reject,
+			})
+			So(len(d.accountedFlows), ShouldEqual, 1)
+		})
+
+		Convey("When I update the accounting twice, the traffic of the flow should be reported", func() {
+			flows, err := parseConntrackFlows(strings.NewReader(conntrackTable))
+			So(err, ShouldBeNil)
+
+			d.updateFlowAccounting(flows)
+			So(len(c.records), ShouldEqual, 1)
+			So(c.records[0].ContextID, ShouldEqual, "pu")
+			So(c.records[0].PolicyID, ShouldEqual, "dns")
+			So(c.records[0].Count, ShouldEqual, 0)
+			So(c.records[0].Accounting, ShouldResemble, &collector.FlowAccounting{
+				BytesSent:       60,
+				PacketsSent:     1,
+				BytesReceived:   120,
+				PacketsReceived: 1,
+			})
+
+			flows[1].accounting.BytesSent = 100
+			flows[1].accounting.PacketsSent = 2
+			d.updateFlowAccounting(flows)
+			So(len(c.records), ShouldEqual, 2)
+			So(c.records[1].Accounting, ShouldResemble, &collector.FlowAccounting{
+				BytesSent:   40,
+				PacketsSent: 1,
+			})
+
+			Convey("If the traffic did not change, nothing should be reported", func() {
+				d.updateFlowAccounting(flows)
+				So(len(c.records), ShouldEqual, 2)
+			})
+
+			Convey("If the flow is no longer in the conntrack table, it should not be accounted", func() {
+				d.updateFlowAccounting(flows[:1])
+				So(len(d.accountedFlows), ShouldEqual, 0)
+			})
+		})
+	})
+}
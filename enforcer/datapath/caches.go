@@ -0,0 +1,214 @@
+package datapath
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+const (
+	// defaultConnectionTimeout is the lifetime of the connections that are
+	// being authorized
+	defaultConnectionTimeout = 24 * time.Second
+	// defaultUnknownSynTimeout is the lifetime of the Syn packets of unknown
+	// connections
+	defaultUnknownSynTimeout = 2 * time.Second
+	// defaultIdleFlowMaxEntries is the number of flow directions retained to
+	// re-validate the idle flows
+	defaultIdleFlowMaxEntries = 65536
+	// externalIPCacheName is the name of the external IP caches of the PUs in
+	// the cache records
+	externalIPCacheName = "External IP Cache"
+)
+
+// CacheConfig configures the caches of the connections of the datapath. The
+// zero values keep the defaults.
+type CacheConfig struct {
+	// MaxEntries limits the number of entries of each connection cache
+	MaxEntries int
+	// ExternalIPMaxEntries limits the number of external flows cached for
+	// each PU
+	ExternalIPMaxEntries int
+	// EvictionPolicy selects how a full cache makes room for a new entry
+	EvictionPolicy cache.EvictionPolicy
+	// ConnectionTimeout is the lifetime of the connections that are being
+	// authorized
+	ConnectionTimeout time.Duration
+	// UnknownSynTimeout is the lifetime of the Syn packets of unknown
+	// connections
+	UnknownSynTimeout time.Duration
+	// IdleFlowTimeout is the lifetime of the authorized flows released to
+	// the kernel
+	IdleFlowTimeout time.Duration
+	// IdleFlowMaxEntries limits the number of flow directions retained to
+	// re-validate the idle flows. The oldest flows are forgotten first and
+	// need a new handshake.
+	IdleFlowMaxEntries int
+}
+
+// statsCache is a cache that counts its lookups and evictions
+type statsCache interface {
+	Stats() cache.Stats
+}
+
+// SetCacheConfig sets the lifetimes and the limits of the connection caches.
+// It must be called before Start. The external IP cache of every PU enforced
+// afterwards is limited to ExternalIPMaxEntries.
+func (d *Datapath) SetCacheConfig(cfg CacheConfig) error {
+
+	if cfg.MaxEntries < 0 || cfg.ExternalIPMaxEntries < 0 || cfg.IdleFlowMaxEntries < 0 {
+		return errors.New("negative cache size")
+	}
+
+	if cfg.ConnectionTimeout < 0 || cfg.UnknownSynTimeout < 0 || cfg.IdleFlowTimeout < 0 {
+		return errors.New("negative cache timeout")
+	}
+
+	if cfg.EvictionPolicy != cache.EvictOldest && cfg.EvictionPolicy != cache.RejectNew {
+		return errors.New("invalid cache eviction policy")
+	}
+
+	if cfg.ConnectionTimeout == 0 {
+		cfg.ConnectionTimeout = defaultConnectionTimeout
+	}
+
+	if cfg.UnknownSynTimeout == 0 {
+		cfg.UnknownSynTimeout = defaultUnknownSynTimeout
+	}
+
+	if cfg.IdleFlowTimeout == 0 {
+		cfg.IdleFlowTimeout = d.idleFlowTimeout
+	}
+
+	if cfg.IdleFlowMaxEntries == 0 {
+		cfg.IdleFlowMaxEntries = defaultIdleFlowMaxEntries
+	}
+
+	newCache := func(name string, lifetime time.Duration, expirer cache.ExpirationNotifier) *cache.Cache {
+		c := cache.NewCacheWithExpirationNotifier(name, lifetime, expirer)
+		c.SetLimit(cfg.MaxEntries, cfg.EvictionPolicy)
+		return c
+	}
+
+	d.sourcePortConnectionCache = newCache("sourcePortConnectionCache", cfg.ConnectionTimeout, nil)
+	d.appOrigConnectionTracker = newCache("appOrigConnectionTracker", cfg.ConnectionTimeout, nil)
+	d.appReplyConnectionTracker = newCache("appReplyConnectionTracker", cfg.ConnectionTimeout, nil)
+	d.netOrigConnectionTracker = newCache("netOrigConnectionTracker", cfg.ConnectionTimeout, nil)
+	d.netReplyConnectionTracker = newCache("netReplyConnectionTracker", cfg.ConnectionTimeout, nil)
+	d.unknownSynConnectionTracker = newCache("unknownSynConnectionTracker", cfg.UnknownSynTimeout, nil)
+	d.udpAppConnectionTracker = newCache("udpAppConnectionTracker", cfg.ConnectionTimeout, nil)
+	d.udpNetConnectionTracker = newCache("udpNetConnectionTracker", cfg.ConnectionTimeout, nil)
+	d.idleFlowTracker = newIdleFlowTracker(cfg.IdleFlowTimeout, cfg.IdleFlowMaxEntries)
+	d.authorizedFlows = newCache("authorizedFlows", cfg.IdleFlowTimeout, nil)
+
+	d.idleFlowTimeout = cfg.IdleFlowTimeout
+	d.cacheConfig = cfg
+
+	return nil
+}
+
+// newIdleFlowTracker returns the cache of the idle flows. The oldest flows
+// are always evicted, so that the new flows can be re-validated.
+func newIdleFlowTracker(lifetime time.Duration, maxEntries int) *cache.Cache {
+
+	c := cache.NewCacheWithExpiration("idleFlowTracker", lifetime)
+	c.SetLimit(maxEntries, cache.EvictOldest)
+
+	return c
+}
+
+// connectionCaches returns the connection caches of the datapath by name
+func (d *Datapath) connectionCaches() map[string]cache.DataStore {
+
+	return map[string]cache.DataStore{
+		"sourcePortConnectionCache":   d.sourcePortConnectionCache,
+		"appOrigConnectionTracker":    d.appOrigConnectionTracker,
+		"appReplyConnectionTracker":   d.appReplyConnectionTracker,
+		"netOrigConnectionTracker":    d.netOrigConnectionTracker,
+		"netReplyConnectionTracker":   d.netReplyConnectionTracker,
+		"unknownSynConnectionTracker": d.unknownSynConnectionTracker,
+		"udpAppConnectionTracker":     d.udpAppConnectionTracker,
+		"udpNetConnectionTracker":     d.udpNetConnectionTracker,
+		"idleFlowTracker":             d.idleFlowTracker,
+		"authorizedFlows":             d.authorizedFlows,
+	}
+}
+
+// cacheStats returns the counters of the connection caches, and the counters
+// of the external IP caches of all the PUs. The caches of a remote enforcer
+// have the context ID of its PU.
+func (d *Datapath) cacheStats() []*collector.CacheStatsRecord {
+
+	puID := ""
+	if d.puFromIP != nil {
+		puID = d.puFromIP.ID()
+	}
+
+	records := []*collector.CacheStatsRecord{}
+
+	for name, c := range d.connectionCaches() {
+		s, ok := c.(statsCache)
+		if !ok {
+			continue
+		}
+		records = append(records, newCacheStatsRecord(puID, name, s.Stats()))
+	}
+
+	external := cache.Stats{MaxEntries: d.cacheConfig.ExternalIPMaxEntries}
+	for _, key := range d.puFromContextID.KeyList() {
+		item, err := d.puFromContextID.Get(key)
+		if err != nil {
+			continue
+		}
+
+		stats := item.(*pucontext.PUContext).ExternalIPCacheStats()
+		external.Entries += stats.Entries
+		external.Hits += stats.Hits
+		external.Misses += stats.Misses
+		external.Evictions += stats.Evictions
+		external.Rejections += stats.Rejections
+		external.Expirations += stats.Expirations
+	}
+	records = append(records, newCacheStatsRecord(puID, externalIPCacheName, external))
+
+	return records
+}
+
+// newCacheStatsRecord returns the record of the counters of a cache
+func newCacheStatsRecord(contextID string, name string, stats cache.Stats) *collector.CacheStatsRecord {
+
+	return &collector.CacheStatsRecord{
+		ContextID:   contextID,
+		Cache:       name,
+		Entries:     stats.Entries,
+		MaxEntries:  stats.MaxEntries,
+		Hits:        stats.Hits,
+		Misses:      stats.Misses,
+		Evictions:   stats.Evictions,
+		Rejections:  stats.Rejections,
+		Expirations: stats.Expirations,
+	}
+}
+
+// GetCacheStats implements the interface policyenforcer.CacheStatsReporter. It
+// returns the counters of the connection caches and of the external IP caches.
+func (d *Datapath) GetCacheStats() []*collector.CacheStatsRecord {
+	return d.cacheStats()
+}
+
+// reportCacheStats reports the counters of the caches to the collector, if it
+// collects the cache records
+func (d *Datapath) reportCacheStats() {
+
+	statsCollector, ok := d.collector.(collector.CacheStatsCollector)
+	if !ok {
+		return
+	}
+
+	for _, record := range d.cacheStats() {
+		statsCollector.CollectCacheStats(record)
+	}
+}
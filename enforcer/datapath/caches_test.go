@@ -0,0 +1,78 @@
+package datapath
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+// cacheStatsCollector keeps the cache records it collects by cache
+type cacheStatsCollector struct {
+	collector.DefaultCollector
+	records map[string]*collector.CacheStatsRecord
+}
+
+func (c *cacheStatsCollector) CollectCacheStats(record *collector.CacheStatsRecord) {
+	c.records[record.Cache] = record
+}
+
+func TestCacheConfig(t *testing.T) {
+
+	Convey("Given a datapath", t, func() {
+		c := &cacheStatsCollector{records: map[string]*collector.CacheStatsRecord{}}
+		d := &Datapath{
+			collector:       c,
+			puFromContextID: cache.NewCache("puFromContextID"),
+			connMetrics:     map[string]*connectionMetrics{},
+			idleFlowTimeout: time.Hour,
+		}
+
+		Convey("When I set an invalid configuration, it should fail", func() {
+			So(d.SetCacheConfig(CacheConfig{MaxEntries: -1}), ShouldNotBeNil)
+			So(d.SetCacheConfig(CacheConfig{ConnectionTimeout: -time.Second}), ShouldNotBeNil)
+			So(d.SetCacheConfig(CacheConfig{EvictionPolicy: cache.EvictionPolicy(5)}), ShouldNotBeNil)
+		})
+
+		Convey("When I limit the connection caches to two entries", func() {
+			So(d.SetCacheConfig(CacheConfig{MaxEntries: 2, ExternalIPMaxEntries: 1}), ShouldBeNil)
+			So(d.idleFlowTimeout, ShouldEqual, time.Hour)
+
+			d.appOrigConnectionTracker.AddOrUpdate("a", 1)
+			d.appOrigConnectionTracker.AddOrUpdate("b", 2)
+			d.appOrigConnectionTracker.AddOrUpdate("c", 3)
+			d.appOrigConnectionTracker.Get("a") // nolint
+			d.appOrigConnectionTracker.Get("c") // nolint
+
+			pu, err := pucontext.NewPU("pu1", policy.NewPUInfo("pu1", constants.LinuxProcessPU), time.Second)
+			So(err, ShouldBeNil)
+			pu.SetExternalIPCacheLimit(d.cacheConfig.ExternalIPMaxEntries, d.cacheConfig.EvictionPolicy)
+			d.puFromContextID.AddOrUpdate("pu1", pu)
+
+			Convey("Then the oldest entry should be evicted and the lookups counted", func() {
+				d.reportCacheStats()
+
+				So(c.records["appOrigConnectionTracker"], ShouldResemble, &collector.CacheStatsRecord{
+					Cache:      "appOrigConnectionTracker",
+					Entries:    2,
+					MaxEntries: 2,
+					Hits:       1,
+					Misses:     1,
+					Evictions:  1,
+				})
+				So(c.records[externalIPCacheName], ShouldResemble, &collector.CacheStatsRecord{
+					Cache:      externalIPCacheName,
+					MaxEntries: 1,
+				})
+				So(c.records["idleFlowTracker"].MaxEntries, ShouldEqual, defaultIdleFlowMaxEntries)
+				So(len(c.records), ShouldEqual, 11)
+			})
+		})
+	})
+}
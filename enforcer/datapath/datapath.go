@@ -2,8 +2,12 @@ package datapath
 
 // Go libraries
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"os/exec"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -15,6 +19,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/enforcer/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer/datapath/nflog"
 	"github.com/aporeto-inc/trireme-lib/enforcer/datapath/proxy/tcp"
+	"github.com/aporeto-inc/trireme-lib/enforcer/datapath/proxy/udp"
 	"github.com/aporeto-inc/trireme-lib/enforcer/datapath/tokenaccessor"
 	"github.com/aporeto-inc/trireme-lib/enforcer/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/enforcer/policyenforcer"
@@ -22,6 +27,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme-lib/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
@@ -41,8 +47,10 @@ type Datapath struct {
 	tokenAccessor  tokenaccessor.TokenAccessor
 	service        packetprocessor.PacketProcessor
 	secrets        secrets.Secrets
+	secretsLock    sync.RWMutex
 	nflogger       nflog.NFLogger
 	proxyhdl       policyenforcer.Enforcer
+	udpProxyhdl    policyenforcer.Enforcer
 	procMountPoint string
 
 	// Internal structures and caches
@@ -68,6 +76,58 @@ type Datapath struct {
 	netReplyConnectionTracker   cache.DataStore
 	unknownSynConnectionTracker cache.DataStore
 
+	// Hash on the flow of the datagrams seen by the application and the
+	// network paths and return the UDP flow. Both directions of a flow are
+	// tracked by the same record.
+	udpAppConnectionTracker cache.DataStore
+	udpNetConnectionTracker cache.DataStore
+	// udpMTU is the largest datagram that can carry a token
+	udpMTU int
+
+	// Hash on the flow in both directions for authorized connections that
+	// were released to the kernel. Used to re-validate long idle flows that
+	// lost their connmark without a new handshake.
+	idleFlowTracker cache.DataStore
+
+	// Key=tuple of the conntrack entry of an authorized connection
+	// Value=ContextIds of the PUs that authorized it. Used to count the
+	// concurrent connections of the PUs in the conntrack table.
+	authorizedFlows cache.DataStore
+
+	// Lifetime of the idle flows, and the lifetimes and limits of the caches
+	idleFlowTimeout time.Duration
+	cacheConfig     CacheConfig
+
+	// Key=ContextId Value=connection counters since the last report
+	connMetrics         map[string]*connectionMetrics
+	connMetricsLock     sync.Mutex
+	connMetricsInterval time.Duration
+	connMetricsStop     chan bool
+
+	// Key=queue number Value=counters of the netfilter queue, and
+	// Key=ContextId Value=packets dropped for the PU
+	queueCounters map[uint16]*queueCounters
+	puDrops       map[string]uint64
+	puDropsLock   sync.Mutex
+
+	// queueLock protects the filter queue configuration, the queue counters
+	// and the stop signals of the queues while they are updated
+	queueLock sync.Mutex
+
+	// Key=flow tuple Value=traffic of the accepted flows since their last report
+	accountedFlows     map[string]*accountedFlow
+	accountingLock     sync.Mutex
+	accountingInterval time.Duration
+	accountingStop     chan bool
+
+	// Interval of the verification of the peers of the authorized flows
+	revalidationInterval time.Duration
+	revalidationStop     chan bool
+
+	// HTTP server of the authorization requests of the Envoy sidecars
+	envoyAuthorization string
+	envoyServer        *http.Server
+
 	// CacheTimeout used for Trireme auto-detecion
 	ExternalIPCacheTimeout time.Duration
 
@@ -107,6 +167,55 @@ func New(
 	packetLogs bool,
 ) *Datapath {
 
+	if mode == constants.RemoteContainer || mode == constants.LocalServer {
+		// Make conntrack liberal for TCP
+
+		sysctlCmd, err := exec.LookPath("sysctl")
+		if err != nil {
+			zap.L().Fatal("sysctl command must be installed", zap.Error(err))
+		}
+
+		cmd := exec.Command(sysctlCmd, "-w", "net.netfilter.nf_conntrack_tcp_be_liberal=1")
+		if err := cmd.Run(); err != nil {
+			zap.L().Fatal("Failed to set conntrack options", zap.Error(err))
+		}
+
+	}
+
+	return NewWithConntrack(
+		mutualAuth,
+		filterQueue,
+		collector,
+		serverID,
+		validity,
+		service,
+		secrets,
+		mode,
+		procMountPoint,
+		ExternalIPCacheTimeout,
+		packetLogs,
+		conntrack.NewHandle(),
+	)
+}
+
+// NewWithConntrack creates a new data path structure that updates the connection
+// marks through the given conntrack handle. It does not change the kernel
+// parameters of the host, which allows datapaths to run without privileges.
+func NewWithConntrack(
+	mutualAuth bool,
+	filterQueue *fqconfig.FilterQueue,
+	collector collector.EventCollector,
+	serverID string,
+	validity time.Duration,
+	service packetprocessor.PacketProcessor,
+	secrets secrets.Secrets,
+	mode constants.ModeType,
+	procMountPoint string,
+	ExternalIPCacheTimeout time.Duration,
+	packetLogs bool,
+	conntrackHdl conntrack.Conntrack,
+) *Datapath {
+
 	tokenAccessor, err := tokenaccessor.New(serverID, validity, secrets)
 	if err != nil {
 		zap.L().Fatal("Cannot create a token engine")
@@ -115,6 +224,7 @@ func New(
 	puFromContextID := cache.NewCache("puFromContextID")
 
 	tcpProxy := tcp.NewProxy(":5000", true, false, tokenAccessor, collector, puFromContextID, mutualAuth)
+	udpProxy := udp.NewProxy(collector, puFromContextID)
 
 	if ExternalIPCacheTimeout <= 0 {
 		var err error
@@ -124,19 +234,14 @@ func New(
 		}
 	}
 
-	if mode == constants.RemoteContainer || mode == constants.LocalServer {
-		// Make conntrack liberal for TCP
-
-		sysctlCmd, err := exec.LookPath("sysctl")
-		if err != nil {
-			zap.L().Fatal("sysctl command must be installed", zap.Error(err))
-		}
-
-		cmd := exec.Command(sysctlCmd, "-w", "net.netfilter.nf_conntrack_tcp_be_liberal=1")
-		if err := cmd.Run(); err != nil {
-			zap.L().Fatal("Failed to set conntrack options", zap.Error(err))
-		}
+	idleFlowTimeout, err := time.ParseDuration(enforcerconstants.DefaultIdleFlowTimeout)
+	if err != nil {
+		idleFlowTimeout = time.Hour
+	}
 
+	connMetricsInterval, err := time.ParseDuration(enforcerconstants.DefaultConnectionMetricsInterval)
+	if err != nil {
+		connMetricsInterval = 10 * time.Second
 	}
 
 	// This cache is shared with portSetInstance. The portSetInstance
@@ -156,12 +261,21 @@ func New(
 
 		puFromContextID: puFromContextID,
 
-		sourcePortConnectionCache:   cache.NewCacheWithExpiration("sourcePortConnectionCache", time.Second*24),
-		appOrigConnectionTracker:    cache.NewCacheWithExpiration("appOrigConnectionTracker", time.Second*24),
-		appReplyConnectionTracker:   cache.NewCacheWithExpiration("appReplyConnectionTracker", time.Second*24),
-		netOrigConnectionTracker:    cache.NewCacheWithExpiration("netOrigConnectionTracker", time.Second*24),
-		netReplyConnectionTracker:   cache.NewCacheWithExpiration("netReplyConnectionTracker", time.Second*24),
-		unknownSynConnectionTracker: cache.NewCacheWithExpiration("unknownSynConnectionTracker", time.Second*2),
+		sourcePortConnectionCache:   cache.NewCacheWithExpiration("sourcePortConnectionCache", defaultConnectionTimeout),
+		appOrigConnectionTracker:    cache.NewCacheWithExpiration("appOrigConnectionTracker", defaultConnectionTimeout),
+		appReplyConnectionTracker:   cache.NewCacheWithExpiration("appReplyConnectionTracker", defaultConnectionTimeout),
+		netOrigConnectionTracker:    cache.NewCacheWithExpiration("netOrigConnectionTracker", defaultConnectionTimeout),
+		netReplyConnectionTracker:   cache.NewCacheWithExpiration("netReplyConnectionTracker", defaultConnectionTimeout),
+		unknownSynConnectionTracker: cache.NewCacheWithExpiration("unknownSynConnectionTracker", defaultUnknownSynTimeout),
+		idleFlowTracker:             newIdleFlowTracker(idleFlowTimeout, defaultIdleFlowMaxEntries),
+		idleFlowTimeout:             idleFlowTimeout,
+		udpAppConnectionTracker:     cache.NewCacheWithExpiration("udpAppConnectionTracker", defaultConnectionTimeout),
+		udpNetConnectionTracker:     cache.NewCacheWithExpiration("udpNetConnectionTracker", defaultConnectionTimeout),
+		udpMTU:                      DefaultUDPMTU,
+		connMetrics:                 map[string]*connectionMetrics{},
+		connMetricsInterval:         connMetricsInterval,
+		queueCounters:               newQueueCounters(filterQueue),
+		puDrops:                     map[string]uint64{},
 		ExternalIPCacheTimeout:      ExternalIPCacheTimeout,
 		filterQueue:                 filterQueue,
 		mutualAuthorization:         mutualAuth,
@@ -172,14 +286,17 @@ func New(
 		ackSize:                     secrets.AckSize(),
 		mode:                        mode,
 		procMountPoint:              procMountPoint,
-		conntrackHdl:                conntrack.NewHandle(),
+		conntrackHdl:                conntrackHdl,
 		proxyhdl:                    tcpProxy,
+		udpProxyhdl:                 udpProxy,
 		portSetInstance:             portSetInstance,
 		packetLogs:                  packetLogs,
 	}
 
 	packet.PacketLogLevel = packetLogs
 
+	d.authorizedFlows = cache.NewCacheWithExpiration("authorizedFlows", idleFlowTimeout)
+
 	d.nflogger = nflog.NewNFLogger(11, 10, d.puInfoDelegate, collector)
 
 	return d
@@ -232,12 +349,20 @@ func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
 		return fmt.Errorf("Unable to enforce proxy: %s", err)
 	}
 
+	if err := d.udpProxyhdl.Enforce(contextID, puInfo); err != nil {
+		return fmt.Errorf("Unable to enforce udp proxy: %s", err)
+	}
+
 	// Always create a new PU context
 	pu, err := pucontext.NewPU(contextID, puInfo, d.ExternalIPCacheTimeout)
 	if err != nil {
 		return fmt.Errorf("error creating new pu: %s", err)
 	}
 
+	if d.cacheConfig.ExternalIPMaxEntries > 0 {
+		pu.SetExternalIPCacheLimit(d.cacheConfig.ExternalIPMaxEntries, d.cacheConfig.EvictionPolicy)
+	}
+
 	// Cache PUs for retrieval based on packet information
 	if pu.Type() == constants.LinuxProcessPU || pu.Type() == constants.UIDLoginPU {
 		mark, ports := pu.GetProcessKeys()
@@ -254,7 +379,8 @@ func (d *Datapath) Enforce(contextID string, puInfo *policy.PUInfo) error {
 		d.puFromIP = pu
 	}
 
-	// Cache PU from contextID for management and policy updates
+	// Cache PU from contextID for management and policy updates. The idle
+	// flows of the PU are re-validated with the new policy.
 	d.puFromContextID.AddOrUpdate(contextID, pu)
 
 	return nil
@@ -277,6 +403,13 @@ func (d *Datapath) Unenforce(contextID string) error {
 		)
 	}
 
+	if err = d.udpProxyhdl.Unenforce(contextID); err != nil {
+		zap.L().Error("Failed to unenforce udp proxy of contextID",
+			zap.String("ContextID", contextID),
+			zap.Error(err),
+		)
+	}
+
 	// Cleanup the IP based lookup
 	pu := puContext.(*pucontext.PUContext)
 
@@ -298,6 +431,31 @@ func (d *Datapath) Unenforce(contextID string) error {
 		}
 	}
 
+	// The traffic of the network namespace of a remote enforcer shared by
+	// several PUs is handled by one of the remaining PUs
+	if d.puFromIP == pu {
+		for _, key := range d.puFromContextID.KeyList() {
+			if key == contextID {
+				continue
+			}
+
+			item, err := d.puFromContextID.Get(key)
+			if err != nil {
+				continue
+			}
+
+			if other := item.(*pucontext.PUContext); other.Type() != constants.LinuxProcessPU && other.Type() != constants.UIDLoginPU {
+				d.puFromIP = other
+				break
+			}
+		}
+	}
+
+	// Cleanup the idle flows, the connection metrics and the dropped packets
+	d.forgetIdleFlows(contextID)
+	d.removeConnectionMetrics(contextID)
+	d.removeQueueStats(contextID)
+
 	// Cleanup the contextID cache
 	if err := d.puFromContextID.RemoveWithDelay(contextID, 10*time.Second); err != nil {
 		zap.L().Warn("Unable to remove context from cache",
@@ -312,9 +470,59 @@ func (d *Datapath) Unenforce(contextID string) error {
 // GetFilterQueue returns the filter queues used by the data path
 func (d *Datapath) GetFilterQueue() *fqconfig.FilterQueue {
 
+	d.queueLock.Lock()
+	defer d.queueLock.Unlock()
+
 	return d.filterQueue
 }
 
+// UpdateFilterQueue implements the interface policyenforcer.FilterQueueUpdater.
+// The running queues are stopped and the queues of the new configuration are
+// started, with new counters. The packets sent to the queues while they are
+// restarted are dropped, unless they are bypassed.
+func (d *Datapath) UpdateFilterQueue(fq *fqconfig.FilterQueue) error {
+
+	if fq == nil {
+		return errors.New("filter queue cannot be nil")
+	}
+
+	d.queueLock.Lock()
+	defer d.queueLock.Unlock()
+
+	// The queues are only running if the nfqueue datapath was started
+	running := d.appStop != nil || d.netStop != nil
+
+	d.stopInterceptors()
+
+	d.filterQueue = fq
+	d.queueCounters = newQueueCounters(fq)
+
+	if running {
+		d.startApplicationInterceptor()
+		d.startNetworkInterceptor()
+	}
+
+	zap.L().Info("Updated the filter queues",
+		zap.Uint16("applicationQueues", fq.GetNumApplicationQueues()),
+		zap.Uint16("networkQueues", fq.GetNumNetworkQueues()),
+	)
+
+	return nil
+}
+
+// stopInterceptors stops the application and network queues and waits until
+// they are stopped. The queueLock must be held.
+func (d *Datapath) stopInterceptors() {
+
+	for _, stop := range append(d.appStop, d.netStop...) {
+		stop <- true
+		<-stop
+	}
+
+	d.appStop = nil
+	d.netStop = nil
+}
+
 // GetPortSetInstance returns the portset instance used by data path
 func (d *Datapath) GetPortSetInstance() portset.PortSet {
 
@@ -326,14 +534,35 @@ func (d *Datapath) Start() error {
 
 	zap.L().Debug("Start enforcer", zap.Int("mode", int(d.mode)))
 	if d.service != nil {
-		d.service.Initialize(d.secrets, d.filterQueue)
+		d.service.Initialize(d.currentSecrets(), d.filterQueue)
 	}
 
+	d.queueLock.Lock()
 	d.startApplicationInterceptor()
 	d.startNetworkInterceptor()
+	d.queueLock.Unlock()
+	d.startConnectionMetricsReporter()
+
+	if d.accountingInterval > 0 {
+		d.startFlowAccounting()
+	}
+
+	if d.revalidationInterval > 0 {
+		d.startFlowRevalidation()
+	}
+
+	if d.envoyAuthorization != "" {
+		if err := d.startEnvoyAuthorization(); err != nil {
+			return err
+		}
+	}
 
 	go d.nflogger.Start()
 
+	if err := d.udpProxyhdl.Start(); err != nil {
+		return err
+	}
+
 	return d.proxyhdl.Start()
 }
 
@@ -342,16 +571,32 @@ func (d *Datapath) Stop() error {
 
 	zap.L().Debug("Stoping enforcer")
 
-	for i := uint16(0); i < d.filterQueue.GetNumApplicationQueues(); i++ {
-		d.appStop[i] <- true
+	d.queueLock.Lock()
+	d.stopInterceptors()
+	d.queueLock.Unlock()
+
+	if d.connMetricsStop != nil {
+		d.connMetricsStop <- true
 	}
 
-	for i := uint16(0); i < d.filterQueue.GetNumNetworkQueues(); i++ {
-		d.netStop[i] <- true
+	if d.accountingStop != nil {
+		d.accountingStop <- true
+	}
+
+	if d.revalidationStop != nil {
+		d.revalidationStop <- true
+	}
+
+	if d.envoyServer != nil {
+		d.envoyServer.Close() // nolint
 	}
 
 	d.nflogger.Stop()
 
+	if err := d.udpProxyhdl.Stop(); err != nil {
+		zap.L().Warn("Unable to stop the udp proxy", zap.Error(err))
+	}
+
 	if d.service != nil {
 		if err := d.service.Stop(); err != nil {
 			return err
@@ -362,8 +607,30 @@ func (d *Datapath) Stop() error {
 }
 
 // UpdateSecrets updates the secrets used for signing communication between trireme instances
+// The peers of the authorized flows are verified with the new secrets, and the
+// flows of the peers that are not trusted anymore lose their authorization.
 func (d *Datapath) UpdateSecrets(token secrets.Secrets) error {
-	return d.tokenAccessor.SetToken(d.tokenAccessor.GetTokenServerID(), d.tokenAccessor.GetTokenValidity(), token)
+
+	if err := d.tokenAccessor.SetToken(d.tokenAccessor.GetTokenServerID(), d.tokenAccessor.GetTokenValidity(), token); err != nil {
+		return err
+	}
+
+	// The size of the ACK packets depends on the key of the secrets
+	atomic.StoreUint32(&d.ackSize, token.AckSize())
+
+	d.secretsLock.Lock()
+	d.secrets = token
+	d.secretsLock.Unlock()
+
+	d.revalidateAuthorizedFlows(token)
+
+	return nil
+}
+
+// SetTokenFormat implements the interface policyenforcer.TokenFormatter
+func (d *Datapath) SetTokenFormat(format tokens.Format) error {
+
+	return d.tokenAccessor.SetTokenFormat(format)
 }
 
 func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.TagStore) {
@@ -383,24 +650,53 @@ func (d *Datapath) puInfoDelegate(contextID string) (ID string, tags *policy.Tag
 
 func (d *Datapath) reportFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
 
+	src := &collector.EndPoint{
+		ID:   sourceID,
+		IP:   p.SourceAddress.String(),
+		Port: p.SourcePort,
+		Type: collector.PU,
+	}
+
+	dst := &collector.EndPoint{
+		ID:   destID,
+		IP:   p.DestinationAddress.String(),
+		Port: p.DestinationPort,
+		Type: collector.PU,
+	}
+
+	d.reportFlowCommon(src, dst, connection, context, mode, report, packet)
+}
+
+func (d *Datapath) reportReverseFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+
+	src := &collector.EndPoint{
+		ID:   sourceID,
+		IP:   p.DestinationAddress.String(),
+		Port: p.DestinationPort,
+		Type: collector.PU,
+	}
+
+	dst := &collector.EndPoint{
+		ID:   destID,
+		IP:   p.SourceAddress.String(),
+		Port: p.SourcePort,
+		Type: collector.PU,
+	}
+
+	d.reportFlowCommon(src, dst, connection, context, mode, report, packet)
+}
+
+func (d *Datapath) reportFlowCommon(src, dst *collector.EndPoint, conn *connection.TCPConnection, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+
 	c := &collector.FlowRecord{
-		ContextID: context.ID(),
-		Source: &collector.EndPoint{
-			ID:   sourceID,
-			IP:   p.SourceAddress.String(),
-			Port: p.SourcePort,
-			Type: collector.PU,
-		},
-		Destination: &collector.EndPoint{
-			ID:   destID,
-			IP:   p.DestinationAddress.String(),
-			Port: p.DestinationPort,
-			Type: collector.PU,
-		},
-		Tags:       context.Annotations(),
-		Action:     report.Action,
-		DropReason: mode,
-		PolicyID:   report.PolicyID,
+		ContextID:   context.ID(),
+		Source:      src,
+		Destination: dst,
+		Tags:        context.Annotations(),
+		Action:      report.Action,
+		DropReason:  mode,
+		PolicyID:    report.PolicyID,
+		ServiceID:   report.ServiceID,
 	}
 
 	if report.ObserveAction.Observed() {
@@ -408,5 +704,24 @@ func (d *Datapath) reportFlow(p *packet.Packet, connection *connection.TCPConnec
 		c.ObservedPolicyID = packet.PolicyID
 	}
 
+	var remoteTags *policy.TagStore
+	if conn != nil {
+		c.PeerClaims = conn.Auth.RemoteClaims
+		c.Latency = handshakeLatency(conn)
+		remoteTags = conn.Auth.RemoteTags
+	}
+
+	// The local PU is the source of the flows of the application, and the
+	// destination of the flows of the network.
+	if src.ID == context.ManagementID() {
+		src.Tags = context.Annotations()
+		dst.Tags = remoteTags
+	} else {
+		src.Tags = remoteTags
+		dst.Tags = context.Annotations()
+	}
+
 	d.collector.CollectFlowEvent(c)
+
+	d.trackFlowAccounting(c)
 }
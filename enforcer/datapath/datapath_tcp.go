@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -37,6 +39,16 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 		)
 	}
 
+	// The checksum is recomputed before the packet is accepted. Corrupted packets
+	// must be dropped here, otherwise they would leave the datapath with a valid
+	// checksum. Packets with an offloaded checksum only carry the pseudo-header
+	// sum and are accepted.
+	if p.TCPChecksumState() == packet.ChecksumInvalid {
+		p.Print(packet.PacketFailureCreate)
+		d.reportDroppedPacket(p, "", collector.InvalidChecksum, "", nil)
+		return errors.New("network packet dropped because of invalid tcp checksum")
+	}
+
 	var conn *connection.TCPConnection
 
 	// Retrieve connection state of SynAck packets and
@@ -130,6 +142,11 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) (err error) {
 
 	}
 
+	// A closed flow cannot be re-validated once it is released to the kernel
+	if p.TCPFlags&(packet.TCPFinMask|packet.TCPRstMask) != 0 {
+		d.forgetIdleFlow(p)
+	}
+
 	// Accept the packet
 	p.UpdateTCPChecksum()
 	p.Print(packet.PacketStageOutgoing)
@@ -239,6 +256,11 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) (err error) {
 		}
 	}
 
+	// A closed flow cannot be re-validated once it is released to the kernel
+	if p.TCPFlags&(packet.TCPFinMask|packet.TCPRstMask) != 0 {
+		d.forgetIdleFlow(p)
+	}
+
 	// Accept the packet
 	p.UpdateTCPChecksum()
 	p.Print(packet.PacketStageOutgoing)
@@ -281,6 +303,8 @@ func (d *Datapath) processApplicationSynPacket(tcpPacket *packet.Packet, context
 		return policy, nil
 	}
 
+	start := time.Now()
+
 	// We are now processing as a Trireme packet that needs authorization headers
 	// Create TCP Option
 	tcpOptions := d.createTCPAuthenticationOption([]byte{})
@@ -292,8 +316,16 @@ func (d *Datapath) processApplicationSynPacket(tcpPacket *packet.Packet, context
 		return nil, err
 	}
 
-	// Set the state indicating that we send out a Syn packet
-	conn.SetState(connection.TCPSynSend)
+	conn.HandshakeStart = start
+	conn.AuthorizationLatency = time.Since(start)
+	conn.HandshakeReply = time.Now()
+
+	// Set the state indicating that we send out a Syn packet. A Syn packet
+	// retransmitted after the Syn packet of the remote crossed it keeps the
+	// state of the simultaneous open.
+	if !conn.SimultaneousOpen {
+		conn.SetState(connection.TCPSynSend)
+	}
 
 	// Poplate the caches to track the connection
 	hash := tcpPacket.L4FlowHash()
@@ -311,7 +343,7 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 	// At this point we can release the flow to the kernel by updating conntrack
 	// We can also clean up the state since we are not going to see any more
 	// packets from this connection.
-	if conn.GetState() == connection.TCPData && !conn.ServiceConnection {
+	if conn.GetState() == connection.TCPData && !conn.ServiceConnection && !conn.SimultaneousOpen {
 		if err := d.conntrackHdl.ConntrackTableUpdateMark(
 			tcpPacket.DestinationAddress.String(),
 			tcpPacket.SourceAddress.String(),
@@ -338,16 +370,32 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 	}
 
 	// We now process packets that need authorization options
+	start := time.Now()
 
 	// Create TCP Option
 	tcpOptions := d.createTCPAuthenticationOption([]byte{})
 
-	tcpData, err := d.tokenAccessor.CreateSynAckPacketToken(context, &conn.Auth)
+	tcpData, err := d.tokenAccessor.CreateSynAckPacketToken(context, synAuth(conn))
 
 	if err != nil {
 		return nil, err
 	}
 
+	conn.AuthorizationLatency += time.Since(start)
+	conn.HandshakeReply = time.Now()
+
+	// In a simultaneous open the SynAck of the remote has already been
+	// processed. Both ends are authorized and there is no Ack to wait for.
+	if conn.SimultaneousOpen && conn.GetState() == connection.TCPSynAckReceived {
+		if err := tcpPacket.TCPDataAttach(tcpOptions, tcpData); err != nil {
+			return nil, err
+		}
+
+		d.completeSimultaneousOpen(tcpPacket, context, conn, true)
+
+		return nil, nil
+	}
+
 	// Set the state for future reference
 	conn.SetState(connection.TCPSynAckSend)
 
@@ -373,8 +421,8 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 		tcpOptions := d.createTCPAuthenticationOption([]byte{})
 
 		// Since we adjust sequence numbers let's make sure we haven't made a mistake
-		if len(token) != int(d.ackSize) {
-			return nil, fmt.Errorf("protocol error: tokenlen=%d acksize=%d", len(token), int(d.ackSize))
+		if ackSize := atomic.LoadUint32(&d.ackSize); len(token) != int(ackSize) {
+			return nil, fmt.Errorf("protocol error: tokenlen=%d acksize=%d", len(token), int(ackSize))
 		}
 
 		// Attach the tags to the packet
@@ -403,6 +451,9 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 					zap.String("state", fmt.Sprintf("%d", conn.GetState())),
 				)
 			}
+
+			d.retainIdleFlow(tcpPacket, conn, false)
+			d.trackAuthorizedFlow(tcpPacket, conn)
 		}
 
 		return nil, nil
@@ -413,6 +464,10 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 		return nil, nil
 	}
 
+	if conn.GetState() == connection.TCPServerNamePending {
+		return d.processApplicationServerName(tcpPacket, context, conn)
+	}
+
 	// Here we capture the first data packet after an ACK packet by modyfing the
 	// state. We will not release the caches though to deal with re-transmissions.
 	// We will let the caches expire.
@@ -469,9 +524,12 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 		return packet, nil, nil
 	}
 
+	start := time.Now()
+	conn.HandshakeStart = start
+
 	// Packets that have authorization information go through the auth path
 	// Decode the JWT token using the context key
-	claims, err = d.tokenAccessor.ParsePacketToken(&conn.Auth, tcpPacket.ReadTCPData())
+	claims, err = d.tokenAccessor.ParsePacketToken(synAuth(conn), tcpPacket.ReadTCPData())
 
 	// If the token signature is not valid,
 	// we must drop the connection and we drop the Syn packet. The source will
@@ -508,6 +566,7 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 	claims.T.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(tcpPacket.DestinationPort)))
 
 	report, packet := context.SearchRcvRules(claims.T)
+	conn.AuthorizationLatency = time.Since(start)
 	if packet.Action.Rejected() {
 		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID(), context, collector.PolicyDrop, report, packet)
 		return nil, nil, fmt.Errorf("connection rejected because of policy: %s", claims.T.String())
@@ -516,7 +575,11 @@ func (d *Datapath) processNetworkSynPacket(context *pucontext.PUContext, conn *c
 	hash := tcpPacket.L4FlowHash()
 	// Update the connection state and store the Nonse send to us by the host.
 	// We use the nonse in the subsequent packets to achieve randomization.
-	conn.SetState(connection.TCPSynReceived)
+	// A retransmitted Syn packet does not revert a simultaneous open where a
+	// SynAck packet was already sent or received.
+	if !conn.SimultaneousOpen || conn.GetState() == connection.TCPSynSend {
+		conn.SetState(connection.TCPSynReceived)
+	}
 
 	// conntrack
 	d.netOrigConnectionTracker.AddOrUpdate(hash, conn)
@@ -551,6 +614,15 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 
 		// Never seen this IP before, let's parse them.
 		report, packet, perr := context.ApplicationACLPolicy(tcpPacket)
+
+		// The flows that no ACL matches may be authorized by the server name
+		// of the TLS ClientHello that the application sends next
+		if perr != nil && context.HasSNIRules(tcpPacket.SourcePort) {
+			conn.SetState(connection.TCPServerNamePending)
+			d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+			return nil, nil, nil
+		}
+
 		if perr != nil || packet.Action.Rejected() {
 			d.reportReverseExternalServiceFlow(context, report, packet, true, tcpPacket)
 			return nil, nil, fmt.Errorf("no auth or acls: drop synack packet and connection: %s: action=%d", perr, packet.Action)
@@ -573,6 +645,10 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 		return packet, nil, nil
 	}
 
+	// In a simultaneous open our SynAck has already been sent and this SynAck
+	// completes the handshake for both ends.
+	simultaneousOpen := conn.SimultaneousOpen && conn.GetState() == connection.TCPSynAckSend
+
 	// This is a corner condition. We are receiving a SynAck packet and we are in
 	// a state that indicates that we have already processed one. This means that
 	// our ack packet was lost. We need to revert conntrack in this case and get
@@ -596,6 +672,9 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 		}
 	}
 
+	start := time.Now()
+	conn.HandshakeEnd = start
+
 	// Now we can process the SynAck packet with its options
 	tcpData := tcpPacket.ReadTCPData()
 	if len(tcpData) == 0 {
@@ -631,14 +710,21 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 
 	if !d.mutualAuthorization {
 		// If we dont do mutual authorization, dont lookup txt rules.
+		conn.AuthorizationLatency += time.Since(start)
 		conn.SetState(connection.TCPSynAckReceived)
 
 		// conntrack
 		d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+
+		if simultaneousOpen {
+			d.completeSimultaneousOpen(tcpPacket, context, conn, false)
+		}
+
 		return nil, claims, nil
 	}
 
 	report, packet := context.SearchTxtRules(claims.T, !d.mutualAuthorization)
+	conn.AuthorizationLatency += time.Since(start)
 	if packet.Action.Rejected() {
 		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, packet)
 		return nil, nil, fmt.Errorf("dropping because of reject rule on transmitter: %s", claims.T.String())
@@ -648,13 +734,20 @@ func (d *Datapath) processNetworkSynAckPacket(context *pucontext.PUContext, conn
 
 	// conntrack
 	d.netReplyConnectionTracker.AddOrUpdate(tcpPacket.L4FlowHash(), conn)
+
+	if simultaneousOpen {
+		d.completeSimultaneousOpen(tcpPacket, context, conn, false)
+	}
+
 	return packet, claims, nil
 }
 
 // processNetworkAckPacket processes an Ack packet arriving from the network
 func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet) (action interface{}, claims *tokens.ConnectionClaims, err error) {
 
-	if conn.GetState() == connection.TCPData || conn.GetState() == connection.TCPAckSend {
+	// The server of a flow that waits for its server name only acknowledges
+	// the packets of the application
+	if conn.GetState() == connection.TCPData || conn.GetState() == connection.TCPAckSend || conn.GetState() == connection.TCPServerNamePending {
 		return nil, nil, nil
 	}
 
@@ -663,6 +756,9 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 	// Validate that the source/destination nonse matches. The signature has validated both directions
 	if conn.GetState() == connection.TCPSynAckSend || conn.GetState() == connection.TCPSynReceived {
 
+		start := time.Now()
+		conn.HandshakeEnd = start
+
 		if err := tcpPacket.CheckTCPAuthenticationOption(enforcerconstants.TCPAuthenticationOptionBaseLen); err != nil {
 			d.reportRejectedFlow(tcpPacket, conn, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
 			return nil, nil, fmt.Errorf("TCP authentication option not found: %s", err)
@@ -681,6 +777,8 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 
 		tcpPacket.DropDetachedBytes()
 
+		conn.AuthorizationLatency += time.Since(start)
+
 		if conn.PacketFlowPolicy != nil && conn.PacketFlowPolicy.Action.Rejected() {
 			if !conn.PacketFlowPolicy.ObserveAction.Observed() {
 				zap.L().Error("Flow rejected but not observed", zap.String("conn", context.ManagementID()))
@@ -705,6 +803,9 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 			); err != nil {
 				zap.L().Error("Failed to update conntrack table after ack packet")
 			}
+
+			d.retainIdleFlow(tcpPacket, conn, true)
+			d.trackAuthorizedFlow(tcpPacket, conn)
 		}
 
 		// Accept the packet
@@ -726,6 +827,58 @@ func (d *Datapath) processNetworkAckPacket(context *pucontext.PUContext, conn *c
 	return nil, nil, fmt.Errorf("Ack packet dropped, invalid duplicate state: %d", conn.GetState())
 }
 
+// completeSimultaneousOpen completes the handshake of a connection where both
+// ends sent a Syn packet. Both Syn and SynAck tokens have been validated in
+// each direction, so the flow is reported and released to the kernel without
+// waiting for Ack packets that the stacks may never send.
+func (d *Datapath) completeSimultaneousOpen(tcpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.TCPConnection, app bool) {
+
+	conn.SetState(connection.TCPData)
+
+	// The flow is always reported in the direction of the received Syn packet
+	// that was authorized by the receive rules.
+	if app {
+		d.reportReverseAcceptedFlow(tcpPacket, conn, conn.CrossedAuth.RemoteContextID, context.ManagementID(), context, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
+	} else {
+		d.reportAcceptedFlow(tcpPacket, conn, conn.CrossedAuth.RemoteContextID, context.ManagementID(), context, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
+	}
+
+	if conn.ServiceConnection {
+		return
+	}
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		tcpPacket.SourceAddress.String(),
+		tcpPacket.DestinationAddress.String(),
+		tcpPacket.IPProto,
+		tcpPacket.SourcePort,
+		tcpPacket.DestinationPort,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Error("Failed to update conntrack table after simultaneous open",
+			zap.String("context", context.ManagementID()),
+			zap.String("flow", tcpPacket.L4FlowHash()),
+		)
+	}
+
+	// The Syn packet received from the network was authorized by the receive
+	// rules
+	d.retainIdleFlow(tcpPacket, conn, true)
+	d.trackAuthorizedFlow(tcpPacket, conn)
+}
+
+// synAuth returns the authentication state of the Syn packet received from the
+// network and of the SynAck packet sent in reply to it. In a simultaneous open
+// the Syn packet of the application keeps its own state.
+func synAuth(conn *connection.TCPConnection) *connection.AuthInfo {
+
+	if conn.SimultaneousOpen {
+		return &conn.CrossedAuth
+	}
+
+	return &conn.Auth
+}
+
 // createTCPAuthenticationOption creates the TCP authentication option -
 func (d *Datapath) createTCPAuthenticationOption(token []byte) []byte {
 
@@ -804,6 +957,10 @@ func (d *Datapath) appRetrieveState(p *packet.Packet) (*connection.TCPConnection
 				}
 			}
 
+			if idleConn, rerr := d.revalidateIdleFlow(p); rerr == nil {
+				return idleConn, nil
+			}
+
 			return nil, fmt.Errorf("app state not found: %s", err)
 		}
 		if uerr := updateTimer(d.appOrigConnectionTracker, hash, conn.(*connection.TCPConnection)); uerr != nil {
@@ -854,12 +1011,30 @@ func (d *Datapath) netSynRetrieveState(p *packet.Packet) (*connection.TCPConnect
 			return nil, nil
 		}
 
+		d.reportDroppedPacket(p, "", collector.InvalidContext, "", nil)
 		return nil, errors.New("no context in net processing")
 	}
 
 	if conn, err := d.netOrigConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
 		return conn.(*connection.TCPConnection), nil
 	}
+
+	// The application has already sent a Syn packet for the reverse flow and
+	// this is a simultaneous open. Both ends use the same connection so that
+	// the handshake completes once, and the crossed Syn packet is authorized
+	// with its own state.
+	if conn, err := d.appOrigConnectionTracker.Get(p.L4ReverseFlowHash()); err == nil {
+		tcpConn := conn.(*connection.TCPConnection)
+
+		tcpConn.Lock()
+		defer tcpConn.Unlock()
+
+		if tcpConn.GetState() == connection.TCPSynSend && len(tcpConn.Auth.LocalContext) > 0 {
+			tcpConn.SimultaneousOpen = true
+			return tcpConn, nil
+		}
+	}
+
 	return connection.NewTCPConnection(context), nil
 }
 
@@ -888,6 +1063,10 @@ func (d *Datapath) netRetrieveState(p *packet.Packet) (*connection.TCPConnection
 	if err != nil {
 		conn, err = d.netOrigConnectionTracker.GetReset(hash, 0)
 		if err != nil {
+			if idleConn, rerr := d.revalidateIdleFlow(p); rerr == nil {
+				return idleConn, nil
+			}
+
 			return nil, fmt.Errorf("net state not found: %s", err)
 		}
 		if err = updateTimer(d.netOrigConnectionTracker, hash, conn.(*connection.TCPConnection)); err != nil {
@@ -903,6 +1082,156 @@ func (d *Datapath) netRetrieveState(p *packet.Packet) (*connection.TCPConnection
 
 }
 
+// idleFlow is an authorized flow that was released to the kernel, with the
+// tuple of one of its directions
+type idleFlow struct {
+	conn    *connection.TCPConnection
+	srcIP   string
+	dstIP   string
+	proto   uint8
+	srcPort uint16
+	dstPort uint16
+	// receiver is true if the flow was authorized by the receive rules of the PU
+	receiver bool
+}
+
+// retainIdleFlow keeps track of an authorized flow that is released to the
+// kernel. Packets of the flow are not seen by the datapath anymore and if
+// the flow stays idle long enough to lose its conntrack entry, they come back
+// without the connmark and without any state in the connection trackers.
+func (d *Datapath) retainIdleFlow(p *packet.Packet, conn *connection.TCPConnection, receiver bool) {
+
+	src, dst := p.SourceAddress.String(), p.DestinationAddress.String()
+	if src == dst {
+		return
+	}
+
+	d.idleFlowTracker.AddOrUpdate(p.L4FlowHash(), &idleFlow{
+		conn:     conn,
+		srcIP:    src,
+		dstIP:    dst,
+		proto:    p.IPProto,
+		srcPort:  p.SourcePort,
+		dstPort:  p.DestinationPort,
+		receiver: receiver,
+	})
+	d.idleFlowTracker.AddOrUpdate(p.L4ReverseFlowHash(), &idleFlow{
+		conn:     conn,
+		srcIP:    dst,
+		dstIP:    src,
+		proto:    p.IPProto,
+		srcPort:  p.DestinationPort,
+		dstPort:  p.SourcePort,
+		receiver: receiver,
+	})
+}
+
+// forgetIdleFlow stops tracking both directions of the flow of the packet
+func (d *Datapath) forgetIdleFlow(p *packet.Packet) {
+
+	d.idleFlowTracker.Remove(p.L4FlowHash())        // nolint
+	d.idleFlowTracker.Remove(p.L4ReverseFlowHash()) // nolint
+}
+
+// forgetIdleFlows stops tracking the idle flows of a PU. The flows keep their
+// connmark, but they are not re-validated with a stale policy once they lose it.
+func (d *Datapath) forgetIdleFlows(contextID string) {
+
+	for _, key := range d.idleFlowTracker.KeyList() {
+
+		item, err := d.idleFlowTracker.Get(key)
+		if err != nil {
+			continue
+		}
+
+		if item.(*idleFlow).conn.Context.ID() == contextID {
+			d.idleFlowTracker.Remove(key) // nolint
+		}
+	}
+}
+
+// idleFlowAuthorized evaluates the tags of the remote PU of an idle flow with
+// the current policy of the PU, the same way they were evaluated during the
+// handshake of the flow.
+func (d *Datapath) idleFlowAuthorized(context *pucontext.PUContext, flow *idleFlow) bool {
+
+	flow.conn.RLock()
+	tags := flow.conn.Auth.RemoteTags
+	flow.conn.RUnlock()
+
+	if !flow.receiver && !d.mutualAuthorization {
+		return true
+	}
+
+	if tags == nil {
+		return false
+	}
+
+	var packet *policy.FlowPolicy
+	if flow.receiver {
+		_, packet = context.SearchRcvRules(tags)
+	} else {
+		_, packet = context.SearchTxtRules(tags, false)
+	}
+
+	return !packet.Action.Rejected()
+}
+
+// revalidateIdleFlow retrieves the state of an authorized flow that lost its
+// connmark. The flow is accepted again only if its PU is still activated and
+// its policy still accepts the remote PU. The connmark is then restored so that
+// the kernel handles the rest of the flow. Fin and Rst packets are accepted,
+// but the flow cannot be re-validated anymore.
+func (d *Datapath) revalidateIdleFlow(p *packet.Packet) (*connection.TCPConnection, error) {
+
+	// Only data packets of an established flow can be re-validated
+	if p.TCPFlags&packet.TCPSynMask != 0 {
+		return nil, errors.New("idle flow cannot be re-validated with a syn packet")
+	}
+
+	hash := p.L4FlowHash()
+
+	item, err := d.idleFlowTracker.GetReset(hash, 0)
+	if err != nil {
+		return nil, fmt.Errorf("idle flow not found: %s", err)
+	}
+	flow := item.(*idleFlow)
+	conn := flow.conn
+
+	item, err = d.puFromContextID.Get(conn.Context.ID())
+	if err != nil {
+		d.forgetIdleFlow(p)
+		return nil, fmt.Errorf("idle flow context not found: %s", err)
+	}
+
+	if !d.idleFlowAuthorized(item.(*pucontext.PUContext), flow) {
+		d.forgetIdleFlow(p)
+		return nil, errors.New("idle flow rejected because of policy")
+	}
+
+	if p.TCPFlags&(packet.TCPFinMask|packet.TCPRstMask) != 0 {
+		d.forgetIdleFlow(p)
+	}
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		p.SourceAddress.String(),
+		p.DestinationAddress.String(),
+		p.IPProto,
+		p.SourcePort,
+		p.DestinationPort,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Debug("Failed to restore conntrack mark for idle flow",
+			zap.String("flow", hash),
+			zap.Error(err),
+		)
+	}
+
+	zap.L().Debug("Idle flow re-validated", zap.String("flow", hash))
+
+	return conn, nil
+}
+
 // updateTimer updates the timers for the service connections
 func updateTimer(c cache.DataStore, hash string, conn *connection.TCPConnection) error {
 	conn.RLock()
@@ -1,10 +1,13 @@
 package datapath
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -1205,6 +1208,481 @@ func TestCacheState(t *testing.T) {
 	})
 }
 
+func TestIdleFlowRevalidation(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance with an activated PU", t, func() {
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.LocalServer, "/proc")
+		contextID := "123"
+
+		puInfo := policy.NewPUInfo(contextID, constants.ContainerPU)
+		ip := policy.ExtendedMap{"bridge": "164.67.228.152"}
+		puInfo.Runtime.SetIPAddresses(ip)
+		puInfo.Policy.SetIPAddresses(ip)
+		puInfo.Policy.AddReceiverRules(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{
+					Key:      enforcerconstants.TransmitterLabel,
+					Value:    []string{"value"},
+					Operator: policy.Equal,
+				},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		err := enforcer.Enforce(contextID, puInfo)
+		So(err, ShouldBeNil)
+
+		item, err := enforcer.puFromContextID.Get(contextID)
+		So(err, ShouldBeNil)
+
+		conn := connection.NewTCPConnection(item.(*pucontext.PUContext))
+		conn.Auth.RemoteTags = policy.NewTagStoreFromMap(map[string]string{enforcerconstants.TransmitterLabel: "value"})
+		conn.SetState(connection.TCPData)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err = PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+		ackPacket, err := PacketFlow.GetFirstAckPacket().ToBytes()
+		So(err, ShouldBeNil)
+		tcpPacket, err := packet.New(0, ackPacket, "0")
+		So(err, ShouldBeNil)
+
+		Convey("When the flow was not released to the kernel", func() {
+
+			_, err := enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then I should not find any state", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the flow was released to the kernel and its state expired", func() {
+
+			enforcer.retainIdleFlow(tcpPacket, conn, true)
+
+			netConn, err := enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then I should recover the connection", func() {
+				So(err, ShouldBeNil)
+				So(netConn, ShouldEqual, conn)
+			})
+		})
+
+		Convey("When the flow was released to the kernel and the PU is deactivated", func() {
+
+			enforcer.retainIdleFlow(tcpPacket, conn, true)
+
+			err := enforcer.puFromContextID.Remove(contextID)
+			So(err, ShouldBeNil)
+
+			_, err = enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then I should not find any state", func() {
+				So(err, ShouldNotBeNil)
+				So(enforcer.idleFlowTracker.KeyList(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the flow was released to the kernel and the policy does not accept the remote PU anymore", func() {
+
+			enforcer.retainIdleFlow(tcpPacket, conn, true)
+
+			conn.Auth.RemoteTags = policy.NewTagStoreFromMap(map[string]string{enforcerconstants.TransmitterLabel: "other"})
+
+			_, err := enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then I should not find any state", func() {
+				So(err, ShouldNotBeNil)
+				So(enforcer.idleFlowTracker.KeyList(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the flow was released to the kernel and the policy of the PU is updated", func() {
+
+			enforcer.retainIdleFlow(tcpPacket, conn, true)
+
+			err := enforcer.Enforce(contextID, puInfo)
+			So(err, ShouldBeNil)
+
+			netConn, err := enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then I should recover the connection with the new policy", func() {
+				So(err, ShouldBeNil)
+				So(netConn, ShouldEqual, conn)
+			})
+		})
+
+		Convey("When the flow was released to the kernel and the updated policy does not accept the remote PU", func() {
+
+			enforcer.retainIdleFlow(tcpPacket, conn, true)
+
+			updated := policy.NewPUInfo(contextID, constants.ContainerPU)
+			updated.Runtime.SetIPAddresses(ip)
+			updated.Policy.SetIPAddresses(ip)
+
+			err := enforcer.Enforce(contextID, updated)
+			So(err, ShouldBeNil)
+
+			_, err = enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then I should not find any state", func() {
+				So(err, ShouldNotBeNil)
+				So(enforcer.idleFlowTracker.KeyList(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the flow was released to the kernel and the PU is unenforced", func() {
+
+			enforcer.retainIdleFlow(tcpPacket, conn, true)
+
+			err := enforcer.Unenforce(contextID)
+			So(err, ShouldBeNil)
+
+			Convey("Then the flow should not be tracked anymore", func() {
+				So(enforcer.idleFlowTracker.KeyList(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the flow was released to the kernel and a Fin packet of the flow is received", func() {
+
+			enforcer.retainIdleFlow(tcpPacket, conn, true)
+
+			tcpPacket.TCPFlags |= packet.TCPFinMask
+
+			netConn, err := enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then I should recover the connection only once", func() {
+				So(err, ShouldBeNil)
+				So(netConn, ShouldEqual, conn)
+				So(enforcer.idleFlowTracker.KeyList(), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+// untrustedSecrets are secrets that do not trust any peer
+type untrustedSecrets struct {
+	secrets.Secrets
+}
+
+func (s *untrustedSecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
+	return nil, fmt.Errorf("untrusted peer")
+}
+
+func TestAuthorizedFlowRevalidation(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance with an activated PU and an authorized flow", t, func() {
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.LocalServer, "/proc")
+		contextID := "123"
+
+		puInfo := policy.NewPUInfo(contextID, constants.ContainerPU)
+		ip := policy.ExtendedMap{"bridge": "164.67.228.152"}
+		puInfo.Runtime.SetIPAddresses(ip)
+		puInfo.Policy.SetIPAddresses(ip)
+		puInfo.Policy.AddReceiverRules(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{
+					Key:      enforcerconstants.TransmitterLabel,
+					Value:    []string{"value"},
+					Operator: policy.Equal,
+				},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		})
+
+		err := enforcer.Enforce(contextID, puInfo)
+		So(err, ShouldBeNil)
+
+		item, err := enforcer.puFromContextID.Get(contextID)
+		So(err, ShouldBeNil)
+
+		conn := connection.NewTCPConnection(item.(*pucontext.PUContext))
+		conn.Auth.RemoteTags = policy.NewTagStoreFromMap(map[string]string{enforcerconstants.TransmitterLabel: "value"})
+		conn.SetState(connection.TCPData)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err = PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+		ackPacket, err := PacketFlow.GetFirstAckPacket().ToBytes()
+		So(err, ShouldBeNil)
+		tcpPacket, err := packet.New(0, ackPacket, "0")
+		So(err, ShouldBeNil)
+
+		enforcer.retainIdleFlow(tcpPacket, conn, true)
+
+		Convey("When the secrets are updated with secrets that trust the peer", func() {
+
+			err := enforcer.UpdateSecrets(secrets.NewPSKSecrets([]byte("Dummy Test Password")))
+			So(err, ShouldBeNil)
+
+			netConn, err := enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then the flow should keep its state", func() {
+				So(err, ShouldBeNil)
+				So(netConn, ShouldEqual, conn)
+			})
+		})
+
+		Convey("When the flows are verified with secrets that do not trust the peer", func() {
+
+			revoked := enforcer.revalidateAuthorizedFlows(&untrustedSecrets{Secrets: secret})
+
+			_, err := enforcer.netRetrieveState(tcpPacket)
+
+			Convey("Then the flow should lose its state in both directions", func() {
+				So(revoked, ShouldEqual, 2)
+				So(err, ShouldNotBeNil)
+				So(enforcer.idleFlowTracker.KeyList(), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+// tlsClientHello returns the first segment of the ClientHello of a TLS client
+func tlsClientHello(serverName string) []byte {
+
+	client, server := net.Pipe()
+	defer server.Close() // nolint
+
+	go tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake() // nolint
+
+	buffer := make([]byte, 16384)
+	n, _ := server.Read(buffer) // nolint
+
+	client.Close() // nolint
+
+	return buffer[:n]
+}
+
+// withPayload returns a packet with the headers of the packet and the payload
+func withPayload(buffer []byte, payload []byte) (*packet.Packet, error) {
+
+	length := binary.BigEndian.Uint16(buffer[2:4])
+	data := append(append([]byte{}, buffer[:length]...), payload...)
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+
+	return packet.New(0, data, "0")
+}
+
+func TestServerNameAuthorization(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance with a PU that has SNI rules and a flow that waits for its server name", t, func() {
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.LocalServer, "/proc")
+		contextID := "123"
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err := PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+		ackPacket, err := PacketFlow.GetFirstAckPacket().ToBytes()
+		So(err, ShouldBeNil)
+		tcpPacket, err := packet.New(0, ackPacket, "0")
+		So(err, ShouldBeNil)
+
+		puInfo := policy.NewPUInfo(contextID, constants.ContainerPU)
+		ip := policy.ExtendedMap{"bridge": "164.67.228.152"}
+		puInfo.Runtime.SetIPAddresses(ip)
+		puInfo.Policy.SetIPAddresses(ip)
+		err = puInfo.Policy.SetSNIRules(policy.SNIRuleList{
+			policy.SNIRule{
+				Port:        strconv.Itoa(int(tcpPacket.DestinationPort)),
+				ServerNames: []string{"*.amazonaws.com"},
+				Policy:      &policy.FlowPolicy{Action: policy.Accept, PolicyID: "aws"},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		err = enforcer.Enforce(contextID, puInfo)
+		So(err, ShouldBeNil)
+
+		item, err := enforcer.puFromContextID.Get(contextID)
+		So(err, ShouldBeNil)
+		context := item.(*pucontext.PUContext)
+		So(context.HasSNIRules(tcpPacket.DestinationPort), ShouldBeTrue)
+
+		conn := connection.NewTCPConnection(context)
+		conn.SetState(connection.TCPServerNamePending)
+
+		Convey("When the application acknowledges the handshake", func() {
+
+			_, err := enforcer.processApplicationAckPacket(tcpPacket, context, conn)
+
+			Convey("Then the flow should still wait for its server name", func() {
+				So(err, ShouldBeNil)
+				So(conn.GetState(), ShouldEqual, connection.TCPServerNamePending)
+			})
+		})
+
+		Convey("When the application sends the ClientHello of a server name that a rule accepts", func() {
+
+			helloPacket, err := withPayload(ackPacket, tlsClientHello("s3.amazonaws.com"))
+			So(err, ShouldBeNil)
+
+			action, err := enforcer.processApplicationAckPacket(helloPacket, context, conn)
+
+			Convey("Then the flow should be accepted", func() {
+				So(err, ShouldBeNil)
+				So(action.(*policy.FlowPolicy).PolicyID, ShouldEqual, "aws")
+				So(conn.GetState(), ShouldEqual, connection.TCPData)
+			})
+		})
+
+		Convey("When the application sends the ClientHello of another server name", func() {
+
+			helloPacket, err := withPayload(ackPacket, tlsClientHello("example.com"))
+			So(err, ShouldBeNil)
+
+			_, err = enforcer.processApplicationAckPacket(helloPacket, context, conn)
+
+			Convey("Then the flow should be rejected", func() {
+				So(err, ShouldNotBeNil)
+				So(conn.GetState(), ShouldEqual, connection.TCPServerNamePending)
+			})
+		})
+	})
+}
+
+// connectionMetricsCollector collects the connection metrics with a mock
+type connectionMetricsCollector struct {
+	*mockcollector.MockEventCollector
+	*mockcollector.MockConnectionMetricsCollector
+}
+
+func TestConnectionMetrics(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given I create a new enforcer instance with an activated PU", t, func() {
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		mockCollector := mockcollector.NewMockEventCollector(ctrl)
+		mockMetrics := mockcollector.NewMockConnectionMetricsCollector(ctrl)
+		enforcer := NewWithDefaults("SomeServerId", &connectionMetricsCollector{mockCollector, mockMetrics}, nil, secret, constants.LocalServer, "/proc")
+		contextID := "123"
+
+		puInfo := policy.NewPUInfo(contextID, constants.ContainerPU)
+		ip := policy.ExtendedMap{"bridge": "164.67.228.152"}
+		puInfo.Runtime.SetIPAddresses(ip)
+		puInfo.Policy.SetIPAddresses(ip)
+
+		err := enforcer.Enforce(contextID, puInfo)
+		So(err, ShouldBeNil)
+
+		item, err := enforcer.puFromContextID.Get(contextID)
+		So(err, ShouldBeNil)
+
+		conn := connection.NewTCPConnection(item.(*pucontext.PUContext))
+		conn.SetState(connection.TCPData)
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err = PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+		ackPacket, err := PacketFlow.GetFirstAckPacket().ToBytes()
+		So(err, ShouldBeNil)
+		tcpPacket, err := packet.New(0, ackPacket, "0")
+		So(err, ShouldBeNil)
+
+		// conntrackEntry returns the conntrack entry of the flow of the packet
+		conntrackEntry := func(state string, mark uint32) string {
+			return fmt.Sprintf("ipv4 2 tcp 6 431999 %s src=%s dst=%s sport=%d dport=%d src=%s dst=%s sport=%d dport=%d [ASSURED] mark=%d zone=0 use=2\n",
+				state,
+				tcpPacket.SourceAddress, tcpPacket.DestinationAddress, tcpPacket.SourcePort, tcpPacket.DestinationPort,
+				tcpPacket.DestinationAddress, tcpPacket.SourceAddress, tcpPacket.DestinationPort, tcpPacket.SourcePort,
+				mark,
+			)
+		}
+
+		Convey("When a flow is authorized twice and its conntrack entry has the default connmark", func() {
+
+			enforcer.trackAuthorizedFlow(tcpPacket, conn)
+			enforcer.trackAuthorizedFlow(tcpPacket, conn)
+
+			flows, err := parseConntrackFlows(strings.NewReader(conntrackEntry("ESTABLISHED", constants.DefaultConnMark)))
+			So(err, ShouldBeNil)
+			enforcer.countAuthorizedFlows(flows)
+
+			Convey("Then I should report one new and one concurrent connection", func() {
+				mockMetrics.EXPECT().CollectConnectionMetrics(&collector.ConnectionMetricsRecord{
+					ContextID:             contextID,
+					NewConnections:        1,
+					ConcurrentConnections: 1,
+					Interval:              time.Second,
+				}).Times(1)
+				enforcer.reportConnectionMetrics(time.Second)
+
+				Convey("And the next report should only have the concurrent connection", func() {
+					mockMetrics.EXPECT().CollectConnectionMetrics(&collector.ConnectionMetricsRecord{
+						ContextID:             contextID,
+						NewConnections:        0,
+						ConcurrentConnections: 1,
+						Interval:              time.Second,
+					}).Times(1)
+					enforcer.reportConnectionMetrics(time.Second)
+				})
+			})
+
+			Convey("When the entry is no longer in the conntrack table", func() {
+
+				enforcer.countAuthorizedFlows([]*conntrackFlow{})
+
+				mockMetrics.EXPECT().CollectConnectionMetrics(&collector.ConnectionMetricsRecord{
+					ContextID:             contextID,
+					NewConnections:        1,
+					ConcurrentConnections: 0,
+					Interval:              time.Second,
+				}).Times(1)
+				enforcer.reportConnectionMetrics(time.Second)
+
+				Convey("Then I should not report the PU anymore", func() {
+					mockMetrics.EXPECT().CollectConnectionMetrics(gomock.Any()).Times(0)
+					enforcer.reportConnectionMetrics(time.Second)
+				})
+			})
+		})
+
+		Convey("When a flow is authorized and its conntrack entry is closing or lost its connmark", func() {
+
+			enforcer.trackAuthorizedFlow(tcpPacket, conn)
+
+			flows, err := parseConntrackFlows(strings.NewReader(conntrackEntry("TIME_WAIT", constants.DefaultConnMark) + conntrackEntry("ESTABLISHED", 0)))
+			So(err, ShouldBeNil)
+			enforcer.countAuthorizedFlows(flows)
+
+			Convey("Then I should not report it as a concurrent connection", func() {
+				mockMetrics.EXPECT().CollectConnectionMetrics(&collector.ConnectionMetricsRecord{
+					ContextID:             contextID,
+					NewConnections:        1,
+					ConcurrentConnections: 0,
+					Interval:              time.Second,
+				}).Times(1)
+				enforcer.reportConnectionMetrics(time.Second)
+			})
+		})
+
+		Convey("When the PU is unenforced", func() {
+
+			enforcer.trackAuthorizedFlow(tcpPacket, conn)
+
+			err := enforcer.Unenforce(contextID)
+			So(err, ShouldBeNil)
+
+			Convey("Then I should not report the PU", func() {
+				mockMetrics.EXPECT().CollectConnectionMetrics(gomock.Any()).Times(0)
+				enforcer.reportConnectionMetrics(time.Second)
+			})
+		})
+	})
+}
+
 func TestDoCreatePU(t *testing.T) {
 
 	Convey("Given an initialized enforcer for Linux Processes", t, func() {
@@ -1339,6 +1817,69 @@ func TestContextFromIP(t *testing.T) {
 	})
 }
 
+func TestSimultaneousOpenRetrieveState(t *testing.T) {
+
+	Convey("Given an initialized enforcer for containers", t, func() {
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.RemoteContainer, "/proc")
+
+		puInfo := policy.NewPUInfo("SomePU", constants.ContainerPU)
+		context, err := pucontext.NewPU("SomePU", puInfo, 10*time.Second)
+		So(err, ShouldBeNil)
+		enforcer.puFromIP = context
+
+		PacketFlow := packetgen.NewTemplateFlow()
+		_, err = PacketFlow.GenerateTCPFlow(packetgen.PacketFlowTypeGoodFlowTemplate)
+		So(err, ShouldBeNil)
+		synPacket, err := PacketFlow.GetFirstSynPacket().ToBytes()
+		So(err, ShouldBeNil)
+		tcpPacket, err := packet.New(0, synPacket, "0")
+		So(err, ShouldBeNil)
+
+		Convey("When the application has not sent a syn packet for the reverse flow", func() {
+
+			conn, err := enforcer.netSynRetrieveState(tcpPacket)
+
+			Convey("Then I should get a new connection", func() {
+				So(err, ShouldBeNil)
+				So(conn, ShouldNotBeNil)
+				So(conn.SimultaneousOpen, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the application has sent a syn packet for the reverse flow", func() {
+
+			appConn := connection.NewTCPConnection(context)
+			appConn.SetState(connection.TCPSynSend)
+			appConn.Auth.LocalContext = []byte("nonce")
+			enforcer.appOrigConnectionTracker.AddOrUpdate(tcpPacket.L4ReverseFlowHash(), appConn)
+
+			conn, err := enforcer.netSynRetrieveState(tcpPacket)
+
+			Convey("Then I should get the application connection in simultaneous open", func() {
+				So(err, ShouldBeNil)
+				So(conn, ShouldEqual, appConn)
+				So(conn.SimultaneousOpen, ShouldBeTrue)
+			})
+		})
+
+		Convey("When the application syn packet was sent to an external service", func() {
+
+			appConn := connection.NewTCPConnection(context)
+			enforcer.appOrigConnectionTracker.AddOrUpdate(tcpPacket.L4ReverseFlowHash(), appConn)
+
+			conn, err := enforcer.netSynRetrieveState(tcpPacket)
+
+			Convey("Then I should get a new connection", func() {
+				So(err, ShouldBeNil)
+				So(conn, ShouldNotEqual, appConn)
+				So(conn.SimultaneousOpen, ShouldBeFalse)
+			})
+		})
+	})
+}
+
 func TestInvalidPacket(t *testing.T) {
 	// collector := &collector.DefaultCollector{}
 	// secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
@@ -3993,6 +4534,8 @@ func TestSynPacketWithInvalidAuthenticationOptionLength(t *testing.T) {
 
 							//changing the option length
 							outPacket.Buffer[outPacket.TCPDataStartBytes()-enforcerconstants.TCPAuthenticationOptionBaseLen] = 233
+							// The checksum stays valid, so that the packet is rejected for its option
+							outPacket.UpdateTCPChecksum()
 
 							err = enforcer.processNetworkTCPPackets(outPacket)
 							So(err, ShouldNotBeNil)
@@ -4102,6 +4645,8 @@ func TestSynAckPacketWithInvalidAuthenticationOptionLength(t *testing.T) {
 							if PacketFlow.GetUptoFirstSynAckPacket().GetNthPacket(i).GetTCPSyn() && PacketFlow.GetUptoFirstSynAckPacket().GetNthPacket(i).GetTCPAck() {
 								//changing the option length of SynAck packet
 								outPacket.Buffer[outPacket.TCPDataStartBytes()-enforcerconstants.TCPAuthenticationOptionBaseLen] = 233
+								// The checksum stays valid, so that the packet is rejected for its option
+								outPacket.UpdateTCPChecksum()
 								err = enforcer.processNetworkTCPPackets(outPacket)
 								So(err, ShouldNotBeNil)
 							} else {
@@ -4649,3 +5194,33 @@ func TestForPacketsWithRandomFlags(t *testing.T) {
 		})
 	})
 }
+
+func TestPacketBuffer(t *testing.T) {
+
+	Convey("Given a segment received from a queue", t, func() {
+		buffer := make([]byte, 60)
+		buffer[0] = 0x45
+		binary.BigEndian.PutUint16(buffer[2:4], 60)
+		buffer[9] = packet.IPProtocolTCP
+		copy(buffer[12:20], []byte{10, 1, 1, 1, 10, 1, 1, 2})
+		buffer[32] = 0x50
+
+		p, err := packet.New(packet.PacketTypeNetwork, buffer, "0")
+		So(err, ShouldBeNil)
+
+		Convey("If it is not modified, its buffer should be given the verdict without a copy", func() {
+			output := packetBuffer(p)
+			So(len(output), ShouldEqual, 60)
+			So(&output[0], ShouldEqual, &buffer[0])
+		})
+
+		Convey("If data is attached, it should be assembled in a new buffer", func() {
+			So(p.TCPDataAttach([]byte{}, []byte("data")), ShouldBeNil)
+
+			output := packetBuffer(p)
+			So(len(output), ShouldEqual, 64)
+			So(string(output[60:]), ShouldEqual, "data")
+			So(&output[0], ShouldNotEqual, &buffer[0])
+		})
+	})
+}
@@ -0,0 +1,423 @@
+package datapath
+
+// UDP has no handshake that can carry the identity of the PUs. The client
+// appends its token to the datagrams of a new flow until it receives a reply
+// with the token of the server. The server appends its token to the replies
+// until it receives a datagram without token, which completes the exchange.
+// Each end releases the flow to the kernel when it receives a datagram without
+// token from the other end.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/connection"
+	"github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
+)
+
+// DefaultUDPMTU is the MTU of the datagrams that carry the tokens
+const DefaultUDPMTU = 1500
+
+// SetUDPMTU sets the largest datagram that can carry a token. The datagrams
+// that would be larger with the token are dropped, since the kernel would not
+// send them without fragmentation. It must be called before Start.
+func (d *Datapath) SetUDPMTU(mtu int) error {
+
+	if mtu < 576 || mtu > packet.MaxIPPacketLen {
+		return fmt.Errorf("invalid udp mtu: %d", mtu)
+	}
+
+	d.udpMTU = mtu
+
+	return nil
+}
+
+// processApplicationUDPPackets processes datagrams arriving from an application and destined to the network
+func (d *Datapath) processApplicationUDPPackets(p *packet.Packet) (err error) {
+
+	if d.packetLogs {
+		zap.L().Debug("Processing application datagram ",
+			zap.String("flow", p.L4FlowHash()),
+		)
+
+		defer zap.L().Debug("Finished Processing application datagram ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	conn, err := d.appUDPRetrieveState(p)
+	if err != nil {
+		if d.packetLogs {
+			zap.L().Debug("Datagram rejected",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	p.Print(packet.PacketStageIncoming)
+	p.Print(packet.PacketStageAuth)
+
+	if err = d.processApplicationUDPPacket(p, conn.Context, conn); err != nil {
+		p.Print(packet.PacketFailureAuth)
+		return fmt.Errorf("processing failed for application datagram: %s", err)
+	}
+
+	p.Print(packet.PacketStageOutgoing)
+
+	return nil
+}
+
+// processApplicationUDPPacket attaches the token of the PU to the datagram
+// while the handshake is in progress
+func (d *Datapath) processApplicationUDPPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) (err error) {
+
+	switch conn.GetState() {
+	case connection.UDPStart:
+		// Flows to external services are accepted based on the ACLs and
+		// never carry a token
+		if report, packet, perr := context.ApplicationUDPACLPolicy(udpPacket); perr == nil && packet.Action.Accepted() {
+			conn.SetState(connection.UDPData)
+			d.reportExternalServiceFlow(context, report, packet, true, udpPacket)
+			d.releaseUDPFlow(udpPacket)
+			return nil
+		}
+
+		if conn.LocalToken, err = d.tokenAccessor.CreateSynPacketToken(context, &conn.Auth); err != nil {
+			return err
+		}
+
+		conn.SetState(connection.UDPClientSendToken)
+
+		return d.attachUDPToken(udpPacket, context, conn)
+
+	case connection.UDPClientSendToken:
+		return d.attachUDPToken(udpPacket, context, conn)
+
+	case connection.UDPServerSendToken:
+		if conn.LocalToken == nil {
+			if conn.LocalToken, err = d.tokenAccessor.CreateSynAckPacketToken(context, &conn.Auth); err != nil {
+				return err
+			}
+		}
+
+		return d.attachUDPToken(udpPacket, context, conn)
+
+	case connection.UDPData:
+		// The flow should have been released. Try again.
+		d.releaseUDPFlow(udpPacket)
+	}
+
+	return nil
+}
+
+// attachUDPToken attaches the token of the PU to a datagram. A datagram that
+// cannot carry the token within the MTU is dropped: it cannot be sent without
+// the token either, since the peer would take it as the end of the exchange.
+func (d *Datapath) attachUDPToken(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	if err := udpPacket.UDPTokenAttach(conn.LocalToken, d.udpMTU); err != nil {
+		d.reportRejectedFlow(udpPacket, nil, context.ManagementID(), collector.DefaultEndPoint, context, collector.PacketTooLarge, nil, nil)
+		return err
+	}
+
+	return nil
+}
+
+// processNetworkUDPPackets processes datagrams arriving from the network and destined to the application
+func (d *Datapath) processNetworkUDPPackets(p *packet.Packet) (err error) {
+
+	if d.packetLogs {
+		zap.L().Debug("Processing network datagram ",
+			zap.String("flow", p.L4FlowHash()),
+		)
+
+		defer zap.L().Debug("Finished Processing network datagram ",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	// The checksum is recomputed when the token is removed. Corrupted datagrams
+	// must be dropped here, otherwise they would leave the datapath with a
+	// valid checksum.
+	if !p.VerifyUDPChecksum() {
+		p.Print(packet.PacketFailureCreate)
+		return errors.New("network datagram dropped because of invalid udp checksum")
+	}
+
+	conn, err := d.netUDPRetrieveState(p)
+	if err != nil {
+		if d.packetLogs {
+			zap.L().Debug("Datagram rejected",
+				zap.String("flow", p.L4FlowHash()),
+				zap.Error(err),
+			)
+		}
+		return err
+	}
+
+	if conn == nil {
+		// The datagram is not destined to a PU
+		return nil
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	p.Print(packet.PacketStageIncoming)
+	p.Print(packet.PacketStageAuth)
+
+	if err = d.processNetworkUDPPacket(p, conn.Context, conn); err != nil {
+		p.Print(packet.PacketFailureAuth)
+		return fmt.Errorf("packet processing failed for network datagram: %s", err)
+	}
+
+	p.Print(packet.PacketStageOutgoing)
+
+	return nil
+}
+
+// processNetworkUDPPacket validates and removes the token of the peer from the
+// datagram and moves the flow through the handshake
+func (d *Datapath) processNetworkUDPPacket(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	token := udpPacket.ReadUDPToken()
+
+	switch conn.GetState() {
+	case connection.UDPStart:
+		if token == nil {
+			return d.processNetworkUDPExternalFlow(udpPacket, context, conn)
+		}
+		return d.processNetworkUDPClientToken(udpPacket, context, conn, token)
+
+	case connection.UDPServerSendToken:
+		if token == nil {
+			// The client received our token and authorized the flow
+			conn.SetState(connection.UDPData)
+			d.releaseUDPFlow(udpPacket)
+			return nil
+		}
+
+		if !bytes.Equal(token, conn.RemoteToken) {
+			return d.processNetworkUDPClientToken(udpPacket, context, conn, token)
+		}
+
+	case connection.UDPClientSendToken:
+		if token == nil {
+			d.reportRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.MissingToken, nil, nil)
+			return errors.New("datagram dropped because of missing token")
+		}
+		return d.processNetworkUDPServerToken(udpPacket, context, conn, token)
+
+	case connection.UDPClientAuthorized:
+		if token == nil {
+			// The server stopped sending its token, the flow is authorized at both ends
+			conn.SetState(connection.UDPData)
+			d.releaseUDPFlow(udpPacket)
+			return nil
+		}
+
+		if !bytes.Equal(token, conn.RemoteToken) {
+			return d.processNetworkUDPServerToken(udpPacket, context, conn, token)
+		}
+
+	case connection.UDPData:
+		if token == nil {
+			// The flow should have been released. Try again.
+			d.releaseUDPFlow(udpPacket)
+			return nil
+		}
+
+		if !bytes.Equal(token, conn.RemoteToken) {
+			d.reportRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidState, nil, nil)
+			return errors.New("datagram dropped because of unexpected token")
+		}
+	}
+
+	// Tokens that were already validated are removed without parsing them again
+	return udpPacket.UDPTokenDetach()
+}
+
+// processNetworkUDPClientToken authorizes a new flow with the token of the
+// client, like a Syn packet
+func (d *Datapath) processNetworkUDPClientToken(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection, token []byte) error {
+
+	claims, err := d.tokenAccessor.ParsePacketToken(&conn.Auth, token)
+	if err != nil {
+		d.reportRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		return fmt.Errorf("datagram dropped because of invalid token: %s", err)
+	}
+
+	if claims == nil {
+		d.reportRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		return errors.New("datagram dropped because of no claims")
+	}
+
+	txLabel, _ := claims.T.Get(enforcerconstants.TransmitterLabel)
+
+	// The token points into the buffer of the datagram
+	remoteToken := append([]byte{}, token...)
+
+	if err := udpPacket.UDPTokenDetach(); err != nil {
+		d.reportRejectedFlow(udpPacket, nil, txLabel, context.ManagementID(), context, collector.InvalidFormat, nil, nil)
+		return fmt.Errorf("datagram dropped because of invalid format: %s", err)
+	}
+
+	// Add the port as a label with an @ prefix like for TCP connections
+	claims.T.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(udpPacket.DestinationPort)))
+
+	report, packet := context.SearchRcvRules(claims.T)
+	if packet.Action.Rejected() {
+		d.reportRejectedFlow(udpPacket, nil, txLabel, context.ManagementID(), context, collector.PolicyDrop, report, packet)
+		return fmt.Errorf("flow rejected because of policy: %s", claims.T.String())
+	}
+
+	conn.SetState(connection.UDPServerSendToken)
+	conn.RemoteToken = remoteToken
+	conn.LocalToken = nil
+	conn.ReportFlowPolicy = report
+	conn.PacketFlowPolicy = packet
+
+	d.udpNetConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+	d.udpAppConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+
+	d.reportAcceptedFlow(udpPacket, nil, txLabel, context.ManagementID(), context, report, packet)
+
+	return nil
+}
+
+// processNetworkUDPServerToken validates the token of the server in a reply,
+// like a SynAck packet
+func (d *Datapath) processNetworkUDPServerToken(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection, token []byte) error {
+
+	claims, err := d.tokenAccessor.ParsePacketToken(&conn.Auth, token)
+	if err != nil {
+		d.reportRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		return fmt.Errorf("datagram dropped because of invalid token: %s", err)
+	}
+
+	if claims == nil {
+		d.reportRejectedFlow(udpPacket, nil, collector.DefaultEndPoint, context.ManagementID(), context, collector.InvalidToken, nil, nil)
+		return errors.New("datagram dropped because of no claims")
+	}
+
+	remoteToken := append([]byte{}, token...)
+
+	if err := udpPacket.UDPTokenDetach(); err != nil {
+		d.reportRejectedFlow(udpPacket, nil, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.InvalidFormat, nil, nil)
+		return fmt.Errorf("datagram dropped because of invalid format: %s", err)
+	}
+
+	if d.mutualAuthorization {
+		report, packet := context.SearchTxtRules(claims.T, !d.mutualAuthorization)
+		if packet.Action.Rejected() {
+			d.reportRejectedFlow(udpPacket, nil, context.ManagementID(), conn.Auth.RemoteContextID, context, collector.PolicyDrop, report, packet)
+			return fmt.Errorf("dropping because of reject rule on transmitter: %s", claims.T.String())
+		}
+	}
+
+	conn.SetState(connection.UDPClientAuthorized)
+	conn.RemoteToken = remoteToken
+
+	return nil
+}
+
+// processNetworkUDPExternalFlow accepts a new flow without token based on the ACLs
+func (d *Datapath) processNetworkUDPExternalFlow(udpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.UDPConnection) error {
+
+	report, packet, err := context.NetworkUDPACLPolicy(udpPacket)
+	d.reportExternalServiceFlow(context, report, packet, false, udpPacket)
+	if err != nil || packet.Action.Rejected() {
+		return fmt.Errorf("no auth or acls: datagram dropped: %s", err)
+	}
+
+	conn.SetState(connection.UDPData)
+
+	d.udpNetConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+	d.udpAppConnectionTracker.AddOrUpdate(udpPacket.L4ReverseFlowHash(), conn)
+
+	d.releaseUDPFlow(udpPacket)
+
+	return nil
+}
+
+// appUDPRetrieveState retrieves the state of the flow of an application
+// datagram. A new flow is created for the first datagram.
+func (d *Datapath) appUDPRetrieveState(p *packet.Packet) (*connection.UDPConnection, error) {
+
+	if conn, err := d.udpAppConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	context, err := d.contextFromIP(true, p.SourceAddress.String(), p.Mark, p.SourcePort)
+	if err != nil {
+		return nil, errors.New("no context in app processing")
+	}
+
+	conn := connection.NewUDPConnection(context)
+
+	d.udpAppConnectionTracker.AddOrUpdate(p.L4FlowHash(), conn)
+	d.udpNetConnectionTracker.AddOrUpdate(p.L4ReverseFlowHash(), conn)
+
+	return conn, nil
+}
+
+// netUDPRetrieveState retrieves the state of the flow of a network datagram.
+// New flows are only tracked once they are authorized.
+func (d *Datapath) netUDPRetrieveState(p *packet.Packet) (*connection.UDPConnection, error) {
+
+	if conn, err := d.udpNetConnectionTracker.GetReset(p.L4FlowHash(), 0); err == nil {
+		return conn.(*connection.UDPConnection), nil
+	}
+
+	context, err := d.contextFromIP(false, p.DestinationAddress.String(), p.Mark, p.DestinationPort)
+	if err != nil {
+		// Local processes that are not PUs receive the datagram without token
+		if d.mode != constants.RemoteContainer {
+			if p.ReadUDPToken() != nil {
+				if err = p.UDPTokenDetach(); err != nil {
+					return nil, fmt.Errorf("datagram dropped because of invalid format: %s", err)
+				}
+			}
+
+			return nil, nil
+		}
+
+		return nil, errors.New("no context in net processing")
+	}
+
+	return connection.NewUDPConnection(context), nil
+}
+
+// releaseUDPFlow marks the flow of the datagram in conntrack so that the
+// kernel accepts the following datagrams without queuing them
+func (d *Datapath) releaseUDPFlow(udpPacket *packet.Packet) {
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		udpPacket.SourceAddress.String(),
+		udpPacket.DestinationAddress.String(),
+		udpPacket.IPProto,
+		udpPacket.SourcePort,
+		udpPacket.DestinationPort,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Error("Failed to update conntrack table for udp flow",
+			zap.String("flow", udpPacket.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,48 @@
+package datapath
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/envoyauthz"
+)
+
+// SetEnvoyAuthorization implements the interface policyenforcer.EnvoyAuthorizer.
+// The authorization requests of the Envoy sidecars are served on the address
+// when the datapath starts.
+func (d *Datapath) SetEnvoyAuthorization(address string) error {
+
+	if address == "" {
+		return errors.New("empty envoy authorization address")
+	}
+
+	d.envoyAuthorization = address
+
+	return nil
+}
+
+// startEnvoyAuthorization serves the authorization requests of the Envoy
+// sidecars with the policies of the PUs of the datapath
+func (d *Datapath) startEnvoyAuthorization() error {
+
+	listener, err := net.Listen("tcp", d.envoyAuthorization)
+	if err != nil {
+		return fmt.Errorf("unable to listen for envoy authorization requests: %s", err)
+	}
+
+	d.envoyServer = &http.Server{
+		Handler: envoyauthz.NewAuthorizer(d.tokenAccessor, d.collector, d.puFromContextID),
+	}
+
+	go func() {
+		if err := d.envoyServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			zap.L().Error("Envoy authorization server stopped", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package datapath
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/connection"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
+)
+
+// connectionMetrics holds the connection counters of a PU. The concurrent
+// connections are the established entries of the conntrack table that carry
+// the default connmark and belong to the authorized flows of the PU.
+type connectionMetrics struct {
+	newConnections        int
+	concurrentConnections int
+}
+
+// trackAuthorizedFlow accounts a connection that was authorized for the PU of
+// the connection. Retransmissions of the same flow are only accounted once.
+// The flows are keyed by the tuple of the conntrack entry they create, and
+// can be authorized by the two PUs of a connection on the same host.
+func (d *Datapath) trackAuthorizedFlow(p *packet.Packet, conn *connection.TCPConnection) {
+
+	contextID := conn.Context.ID()
+	key := flowTuple(p.SourceAddress.String(), p.SourcePort, p.DestinationAddress.String(), p.DestinationPort)
+
+	d.connMetricsLock.Lock()
+	defer d.connMetricsLock.Unlock()
+
+	contextIDs := []string{}
+	if item, err := d.authorizedFlows.Get(key); err == nil {
+		for _, id := range item.([]string) {
+			if id == contextID {
+				return
+			}
+			contextIDs = append(contextIDs, id)
+		}
+	}
+
+	d.authorizedFlows.AddOrUpdate(key, append(contextIDs, contextID))
+
+	metrics, ok := d.connMetrics[contextID]
+	if !ok {
+		metrics = &connectionMetrics{}
+		d.connMetrics[contextID] = metrics
+	}
+
+	metrics.newConnections++
+}
+
+// countAuthorizedFlows sets the concurrent connections of the PUs from the
+// entries of the conntrack table. The authorized flows found in the table
+// are kept, the others expire with the idle flow timeout.
+func (d *Datapath) countAuthorizedFlows(flows []*conntrackFlow) {
+
+	d.connMetricsLock.Lock()
+	defer d.connMetricsLock.Unlock()
+
+	for _, metrics := range d.connMetrics {
+		metrics.concurrentConnections = 0
+	}
+
+	for _, ct := range flows {
+		item, err := d.authorizedFlows.GetReset(ct.original, 0)
+		if err != nil {
+			if item, err = d.authorizedFlows.GetReset(ct.reply, 0); err != nil {
+				continue
+			}
+		}
+
+		if ct.mark != constants.DefaultConnMark || ct.state != "ESTABLISHED" {
+			continue
+		}
+
+		for _, contextID := range item.([]string) {
+			if metrics, ok := d.connMetrics[contextID]; ok {
+				metrics.concurrentConnections++
+			}
+		}
+	}
+}
+
+// removeConnectionMetrics removes the connection counters of a PU
+func (d *Datapath) removeConnectionMetrics(contextID string) {
+
+	d.connMetricsLock.Lock()
+	defer d.connMetricsLock.Unlock()
+
+	delete(d.connMetrics, contextID)
+}
+
+// reportConnectionMetrics reports the connection metrics of every PU with
+// connections to the collector and resets the new connection counters. PUs
+// without any connection are not reported. The metrics are only reported to
+// the collectors of the connection metrics.
+func (d *Datapath) reportConnectionMetrics(interval time.Duration) {
+
+	records := []*collector.ConnectionMetricsRecord{}
+
+	d.connMetricsLock.Lock()
+	for contextID, metrics := range d.connMetrics {
+		if metrics.newConnections == 0 && metrics.concurrentConnections == 0 {
+			delete(d.connMetrics, contextID)
+			continue
+		}
+
+		records = append(records, &collector.ConnectionMetricsRecord{
+			ContextID:             contextID,
+			NewConnections:        metrics.newConnections,
+			ConcurrentConnections: metrics.concurrentConnections,
+			Interval:              interval,
+		})
+
+		metrics.newConnections = 0
+	}
+	d.connMetricsLock.Unlock()
+
+	metricsCollector, ok := d.collector.(collector.ConnectionMetricsCollector)
+	if !ok {
+		return
+	}
+
+	for _, record := range records {
+		metricsCollector.CollectConnectionMetrics(record)
+	}
+}
+
+// startConnectionMetricsReporter periodically reports the connection metrics
+// and the counters of the queues
+func (d *Datapath) startConnectionMetricsReporter() {
+
+	d.connMetricsStop = make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(d.connMetricsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, ok := d.collector.(collector.ConnectionMetricsCollector); ok {
+					flows, err := readConntrackFlows()
+					if err != nil {
+						zap.L().Debug("Unable to read the conntrack table", zap.Error(err))
+					} else {
+						d.countAuthorizedFlows(flows)
+					}
+				}
+				d.reportConnectionMetrics(d.connMetricsInterval)
+				d.reportQueueStats()
+				d.reportCacheStats()
+			case <-d.connMetricsStop:
+				return
+			}
+		}
+	}()
+}
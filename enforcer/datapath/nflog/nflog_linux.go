@@ -37,16 +37,30 @@ func NewNFLogger(ipv4groupSource, ipv4groupDest uint16, getPUInfo GetPUInfoFunc,
 
 func (a *nfLog) Start() {
 	a.Lock()
-	a.srcNflogHandle, _ = nflog.BindAndListenForLogs([]uint16{a.ipv4groupSource}, 64, a.sourceNFLogsHanlder, a.nflogErrorHandler)
-	a.dstNflogHandle, _ = nflog.BindAndListenForLogs([]uint16{a.ipv4groupDest}, 64, a.destNFLogsHandler, a.nflogErrorHandler)
-	a.Unlock()
+	defer a.Unlock()
+
+	var err error
+
+	if a.srcNflogHandle, err = nflog.BindAndListenForLogs([]uint16{a.ipv4groupSource}, 64, a.sourceNFLogsHanlder, a.nflogErrorHandler); err != nil {
+		zap.L().Error("Unable to listen for nflog packets", zap.Uint16("group", a.ipv4groupSource), zap.Error(err))
+	}
+
+	if a.dstNflogHandle, err = nflog.BindAndListenForLogs([]uint16{a.ipv4groupDest}, 64, a.destNFLogsHandler, a.nflogErrorHandler); err != nil {
+		zap.L().Error("Unable to listen for nflog packets", zap.Uint16("group", a.ipv4groupDest), zap.Error(err))
+	}
 }
 
 func (a *nfLog) Stop() {
 	a.Lock()
-	a.srcNflogHandle.NFlogClose()
-	a.dstNflogHandle.NFlogClose()
-	a.Unlock()
+	defer a.Unlock()
+
+	if a.srcNflogHandle != nil {
+		a.srcNflogHandle.NFlogClose()
+	}
+
+	if a.dstNflogHandle != nil {
+		a.dstNflogHandle.NFlogClose()
+	}
 }
 
 func (a *nfLog) sourceNFLogsHanlder(buf *nflog.NfPacket, data interface{}) {
@@ -78,6 +92,10 @@ func (a *nfLog) nflogErrorHandler(err error) {
 
 func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*collector.FlowRecord, error) {
 
+	if len(buf.Prefix) == 0 {
+		return nil, fmt.Errorf("nflog: empty prefix")
+	}
+
 	parts := strings.SplitN(buf.Prefix[:len(buf.Prefix)-1], ":", 3)
 
 	if len(parts) != 3 {
@@ -100,15 +118,17 @@ func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*co
 	record := &collector.FlowRecord{
 		ContextID: contextID,
 		Source: &collector.EndPoint{
-			IP: buf.SrcIP.String(),
+			IP:   buf.SrcIP.String(),
+			Port: uint16(buf.SrcPort),
 		},
 		Destination: &collector.EndPoint{
 			IP:   buf.DstIP.String(),
 			Port: uint16(buf.DstPort),
 		},
-		PolicyID: policyID,
-		Tags:     tags,
-		Action:   action,
+		PolicyID:  policyID,
+		ServiceID: extSrvID,
+		Tags:      tags,
+		Action:    action,
 	}
 
 	if action.Observed() {
@@ -116,9 +136,14 @@ func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*co
 		record.ObservedPolicyID = policyID
 	}
 
+	if action.Rejected() {
+		record.DropReason = collector.PolicyDrop
+	}
+
 	if puIsSource {
 		record.Source.Type = collector.PU
 		record.Source.ID = puID
+		record.Source.Tags = tags
 		record.Destination.Type = collector.Address
 		record.Destination.ID = extSrvID
 	} else {
@@ -126,6 +151,7 @@ func (a *nfLog) recordFromNFLogBuffer(buf *nflog.NfPacket, puIsSource bool) (*co
 		record.Source.ID = extSrvID
 		record.Destination.Type = collector.PU
 		record.Destination.ID = puID
+		record.Destination.Tags = tags
 	}
 
 	return record, nil
@@ -0,0 +1,99 @@
+// +build linux
+
+package nflog
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aporeto-inc/netlink-go/nflog"
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testCollector struct {
+	flows []*collector.FlowRecord
+}
+
+func (c *testCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.flows = append(c.flows, record)
+}
+
+func (c *testCollector) CollectContainerEvent(record *collector.ContainerRecord) {}
+
+func testPUInfo(id string) (string, *policy.TagStore) {
+
+	if id != "pu1" {
+		return "", nil
+	}
+
+	return "management1", policy.NewTagStoreFromMap(map[string]string{"app": "web"})
+}
+
+func testNFPacket(prefix string) *nflog.NfPacket {
+
+	return &nflog.NfPacket{
+		Prefix:  prefix,
+		SrcIP:   net.ParseIP("10.1.1.1"),
+		DstIP:   net.ParseIP("192.168.1.1"),
+		SrcPort: 3000,
+		DstPort: 80,
+	}
+}
+
+func TestRecordFromNFLogBuffer(t *testing.T) {
+
+	Convey("Given an nflog reader", t, func() {
+		c := &testCollector{}
+		a := NewNFLogger(11, 10, testPUInfo, c).(*nfLog)
+
+		Convey("When I receive the log of an accepted application flow", func() {
+			flow := &policy.FlowPolicy{Action: policy.Accept, PolicyID: "policy1", ServiceID: "service1"}
+			a.destNFLogsHandler(testNFPacket(flow.LogPrefix("pu1")), nil)
+
+			Convey("Then the collector should get a flow from the PU to the external service", func() {
+				So(c.flows, ShouldHaveLength, 1)
+				record := c.flows[0]
+				So(record.ContextID, ShouldEqual, "pu1")
+				So(record.PolicyID, ShouldEqual, "policy1")
+				So(record.ServiceID, ShouldEqual, "service1")
+				So(record.Action, ShouldEqual, policy.Accept)
+				So(record.DropReason, ShouldBeEmpty)
+				So(record.Source.Type, ShouldEqual, collector.PU)
+				So(record.Source.ID, ShouldEqual, "management1")
+				So(record.Source.Port, ShouldEqual, 3000)
+				So(record.Source.Tags.GetSlice(), ShouldResemble, []string{"app=web"})
+				So(record.Destination.Type, ShouldEqual, collector.Address)
+				So(record.Destination.ID, ShouldEqual, "service1")
+				So(record.Destination.IP, ShouldEqual, "192.168.1.1")
+				So(record.Destination.Port, ShouldEqual, 80)
+			})
+		})
+
+		Convey("When I receive the log of the default reject of the network", func() {
+			a.sourceNFLogsHanlder(testNFPacket(policy.DefaultLogPrefix("pu1")), nil)
+
+			Convey("Then the collector should get a dropped flow from the external service to the PU", func() {
+				So(c.flows, ShouldHaveLength, 1)
+				record := c.flows[0]
+				So(record.Action, ShouldEqual, policy.Reject)
+				So(record.DropReason, ShouldEqual, collector.PolicyDrop)
+				So(record.Source.Type, ShouldEqual, collector.Address)
+				So(record.Source.ID, ShouldEqual, "default")
+				So(record.Destination.Type, ShouldEqual, collector.PU)
+				So(record.Destination.ID, ShouldEqual, "management1")
+				So(record.Destination.Tags, ShouldNotBeNil)
+				So(record.Source.Tags, ShouldBeNil)
+			})
+		})
+
+		Convey("When I receive logs that cannot be decoded, I should get errors", func() {
+			for _, prefix := range []string{"", "pu1:policy1", "pu2:policy1:service13", "pu1:policy1:service1x"} {
+				_, err := a.recordFromNFLogBuffer(testNFPacket(prefix), true)
+				So(err, ShouldNotBeNil)
+			}
+			So(c.flows, ShouldBeEmpty)
+		})
+	})
+}
@@ -4,7 +4,6 @@ package datapath
 
 // Go libraries
 import (
-	"fmt"
 	"strconv"
 	"time"
 
@@ -13,15 +12,29 @@ import (
 	"go.uber.org/zap"
 )
 
+// nfqueueCopyRange is the number of bytes copied from the queued packets. The
+// segments aggregated by GRO or GSO are copied whole, so that they can be
+// parsed and given a verdict without disabling the offloads of the interfaces.
+const nfqueueCopyRange = packet.MaxIPPacketLen
+
 func errorCallback(err error, data interface{}) {
 	zap.L().Error("Error while processing packets on queue", zap.Error(err))
 }
-func networkCallback(packet *nfqueue.NFPacket, d interface{}) {
-	d.(*Datapath).processNetworkPacketsFromNFQ(packet)
+
+// queueHandler is the private data of the callbacks of a queue
+type queueHandler struct {
+	datapath *Datapath
+	counters *queueCounters
+}
+
+func networkCallback(packet *nfqueue.NFPacket, h interface{}) {
+	handler := h.(*queueHandler)
+	handler.datapath.processNetworkPacketsFromNFQ(packet, handler.counters)
 }
 
-func appCallBack(packet *nfqueue.NFPacket, d interface{}) {
-	d.(*Datapath).processApplicationPacketsFromNFQ(packet)
+func appCallBack(packet *nfqueue.NFPacket, h interface{}) {
+	handler := h.(*queueHandler)
+	handler.datapath.processApplicationPacketsFromNFQ(packet, handler.counters)
 }
 
 // startNetworkInterceptor will the process that processes  packets from the network
@@ -37,25 +50,29 @@ func (d *Datapath) startNetworkInterceptor() {
 
 	for i := uint16(0); i < d.filterQueue.GetNumNetworkQueues(); i++ {
 
+		queue := d.filterQueue.GetNetworkQueueStart() + i
+		handler := &queueHandler{datapath: d, counters: d.queueCounters[queue]}
+
 		// Initialize all the queues
-		nfq[i], err = nfqueue.CreateAndStartNfQueue(d.filterQueue.GetNetworkQueueStart()+i, d.filterQueue.GetNetworkQueueSize(), nfqueue.NfDefaultPacketSize, networkCallback, errorCallback, d)
+		nfq[i], err = nfqueue.CreateAndStartNfQueue(queue, d.filterQueue.GetNetworkQueueSize(), nfqueueCopyRange, networkCallback, errorCallback, handler)
 		if err != nil {
 			for retry := 0; retry < 5 && err != nil; retry++ {
-				nfq[i], err = nfqueue.CreateAndStartNfQueue(d.filterQueue.GetNetworkQueueStart()+i, d.filterQueue.GetNetworkQueueSize(), nfqueue.NfDefaultPacketSize, networkCallback, errorCallback, d)
+				nfq[i], err = nfqueue.CreateAndStartNfQueue(queue, d.filterQueue.GetNetworkQueueSize(), nfqueueCopyRange, networkCallback, errorCallback, handler)
 				<-time.After(3 * time.Second)
 			}
 			if err != nil {
 				zap.L().Fatal("Unable to initialize netfilter queue", zap.Error(err))
 			}
 		}
-		go func(j uint16) {
-			for range d.netStop[j] {
+		go func(j uint16, stop chan bool) {
+			for range stop {
 				if err := nfq[j].StopQueue(); err != nil {
 					zap.L().Error("Error when stoping nfq", zap.Error(err))
 				}
+				close(stop)
 				return
 			}
-		}(i)
+		}(i, d.netStop[i])
 
 	}
 }
@@ -73,11 +90,15 @@ func (d *Datapath) startApplicationInterceptor() {
 	nfq := make([]nfqueue.Verdict, d.filterQueue.GetNumApplicationQueues())
 
 	for i := uint16(0); i < d.filterQueue.GetNumApplicationQueues(); i++ {
-		nfq[i], err = nfqueue.CreateAndStartNfQueue(d.filterQueue.GetApplicationQueueStart()+i, d.filterQueue.GetApplicationQueueSize(), nfqueue.NfDefaultPacketSize, appCallBack, errorCallback, d)
+
+		queue := d.filterQueue.GetApplicationQueueStart() + i
+		handler := &queueHandler{datapath: d, counters: d.queueCounters[queue]}
+
+		nfq[i], err = nfqueue.CreateAndStartNfQueue(queue, d.filterQueue.GetApplicationQueueSize(), nfqueueCopyRange, appCallBack, errorCallback, handler)
 
 		if err != nil {
 			for retry := 0; retry < 5 && err != nil; retry++ {
-				nfq[i], err = nfqueue.CreateAndStartNfQueue(d.filterQueue.GetApplicationQueueStart()+i, d.filterQueue.GetApplicationQueueSize(), nfqueue.NfDefaultPacketSize, appCallBack, errorCallback, d)
+				nfq[i], err = nfqueue.CreateAndStartNfQueue(queue, d.filterQueue.GetApplicationQueueSize(), nfqueueCopyRange, appCallBack, errorCallback, handler)
 				<-time.After(3 * time.Second)
 			}
 			if err != nil {
@@ -85,85 +106,53 @@ func (d *Datapath) startApplicationInterceptor() {
 			}
 
 		}
-		go func(j uint16) {
-			for range d.appStop[j] {
+		go func(j uint16, stop chan bool) {
+			for range stop {
 				//Call StopQueue
 				if err := nfq[j].StopQueue(); err != nil {
 					zap.L().Error("Error when stoping nfq", zap.Error(err))
 				}
+				close(stop)
 				return
 			}
 
-		}(i)
+		}(i, d.appStop[i])
 
 	}
 }
 
 // processNetworkPacketsFromNFQ processes packets arriving from the network in an NF queue
-func (d *Datapath) processNetworkPacketsFromNFQ(p *nfqueue.NFPacket) {
+func (d *Datapath) processNetworkPacketsFromNFQ(p *nfqueue.NFPacket, counters *queueCounters) {
 
-	// Parse the packet - drop if parsing fails
-	netPacket, err := packet.New(packet.PacketTypeNetwork, p.Buffer, strconv.Itoa(int(p.Mark)))
+	start := time.Now()
 
-	if err != nil {
-		netPacket.Print(packet.PacketFailureCreate)
-	} else if netPacket.IPProto == packet.IPProtocolTCP {
-		err = d.processNetworkTCPPackets(netPacket)
-	} else {
-		err = fmt.Errorf("invalid ip protocol: %d", netPacket.IPProto)
-	}
+	buffer, err := d.ProcessNetworkPacket(p.Buffer, strconv.Itoa(int(p.Mark)))
 	if err != nil {
 		length := uint32(len(p.Buffer))
-		buffer := p.Buffer
-		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 0, uint32(p.Mark), length, uint32(p.ID), buffer)
+		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 0, uint32(p.Mark), length, uint32(p.ID), p.Buffer)
+		counters.count(true, time.Since(start))
 		return
 	}
 
-	// // Accept the packet
-	buffer := make([]byte, len(netPacket.Buffer)+netPacket.TCPOptionLength()+netPacket.TCPDataLength())
-	copyIndex := copy(buffer, netPacket.Buffer)
-	copyIndex += copy(buffer[copyIndex:], netPacket.GetTCPOptions())
-	copyIndex += copy(buffer[copyIndex:], netPacket.GetTCPData())
-	// buffer = append(buffer, netPacket.GetTCPOptions()...)
-	// buffer = append(buffer, netPacket.GetTCPData()...)
-	// length = uint32(len(buffer))
-	p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 1, uint32(p.Mark), uint32(copyIndex), uint32(p.ID), buffer)
-
+	// Accept the packet
+	p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 1, uint32(p.Mark), uint32(len(buffer)), uint32(p.ID), buffer)
+	counters.count(false, time.Since(start))
 }
 
 // processApplicationPackets processes packets arriving from an application and are destined to the network
-func (d *Datapath) processApplicationPacketsFromNFQ(p *nfqueue.NFPacket) {
-
-	// Being liberal on what we transmit - malformed TCP packets are let go
-	// We are strict on what we accept on the other side, but we don't block
-	// lots of things at the ingress to the network
-	appPacket, err := packet.New(packet.PacketTypeApplication, p.Buffer, strconv.Itoa(int(p.Mark)))
+func (d *Datapath) processApplicationPacketsFromNFQ(p *nfqueue.NFPacket, counters *queueCounters) {
 
-	if err != nil {
-		appPacket.Print(packet.PacketFailureCreate)
-	} else if appPacket.IPProto == packet.IPProtocolTCP {
-		err = d.processApplicationTCPPackets(appPacket)
-	} else {
-		err = fmt.Errorf("invalid ip protocol: %d", appPacket.IPProto)
-	}
+	start := time.Now()
 
+	buffer, err := d.ProcessApplicationPacket(p.Buffer, strconv.Itoa(int(p.Mark)))
 	if err != nil {
 		length := uint32(len(p.Buffer))
-		buffer := p.Buffer
-		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 0, uint32(p.Mark), length, uint32(p.ID), buffer)
+		p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 0, uint32(p.Mark), length, uint32(p.ID), p.Buffer)
+		counters.count(true, time.Since(start))
 		return
 	}
 
 	// Accept the packet
-	buffer := make([]byte, len(appPacket.Buffer)+appPacket.TCPOptionLength()+appPacket.TCPDataLength())
-	copyIndex := copy(buffer, appPacket.Buffer)
-	copyIndex += copy(buffer[copyIndex:], appPacket.GetTCPOptions())
-	copyIndex += copy(buffer[copyIndex:], appPacket.GetTCPData())
-	// buffer := appPacket.Buffer
-	// buffer = append(buffer, appPacket.GetTCPOptions()...)
-	// buffer = append(buffer, appPacket.GetTCPData()...)
-	// length = uint32(len(buffer))
-
-	p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 1, uint32(p.Mark), uint32(copyIndex), uint32(p.ID), buffer)
-
+	p.QueueHandle.SetVerdict2(uint32(p.QueueHandle.QueueNum), 1, uint32(p.Mark), uint32(len(buffer)), uint32(p.ID), buffer)
+	counters.count(false, time.Since(start))
 }
@@ -0,0 +1,78 @@
+package datapath
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
+)
+
+// ProcessNetworkPacket processes a packet arriving from the network. It returns
+// the buffer to deliver to the application or an error if the packet must be
+// dropped.
+func (d *Datapath) ProcessNetworkPacket(buffer []byte, mark string) ([]byte, error) {
+
+	// Parse the packet - drop if parsing fails
+	netPacket, err := packet.New(packet.PacketTypeNetwork, buffer, mark)
+
+	if err != nil {
+		netPacket.Print(packet.PacketFailureCreate)
+	} else if netPacket.IPProto == packet.IPProtocolTCP {
+		err = d.processNetworkTCPPackets(netPacket)
+	} else if netPacket.IPProto == packet.IPProtocolUDP {
+		err = d.processNetworkUDPPackets(netPacket)
+	} else {
+		err = fmt.Errorf("invalid ip protocol: %d", netPacket.IPProto)
+	}
+
+	if err != nil {
+		d.countDroppedPacket(netPacket, false)
+		return nil, err
+	}
+
+	return packetBuffer(netPacket), nil
+}
+
+// ProcessApplicationPacket processes a packet arriving from an application. It
+// returns the buffer to transmit to the network or an error if the packet must
+// be dropped.
+func (d *Datapath) ProcessApplicationPacket(buffer []byte, mark string) ([]byte, error) {
+
+	// Being liberal on what we transmit - malformed TCP packets are let go
+	// We are strict on what we accept on the other side, but we don't block
+	// lots of things at the ingress to the network
+	appPacket, err := packet.New(packet.PacketTypeApplication, buffer, mark)
+
+	if err != nil {
+		appPacket.Print(packet.PacketFailureCreate)
+	} else if appPacket.IPProto == packet.IPProtocolTCP {
+		err = d.processApplicationTCPPackets(appPacket)
+	} else if appPacket.IPProto == packet.IPProtocolUDP {
+		err = d.processApplicationUDPPackets(appPacket)
+	} else {
+		err = fmt.Errorf("invalid ip protocol: %d", appPacket.IPProto)
+	}
+
+	if err != nil {
+		d.countDroppedPacket(appPacket, true)
+		return nil, err
+	}
+
+	return packetBuffer(appPacket), nil
+}
+
+// packetBuffer assembles the header, the options and the data of a processed
+// packet in a new buffer. The buffer of packets without detached options or
+// data is returned as is, to avoid copying large segments.
+func packetBuffer(p *packet.Packet) []byte {
+
+	if p.TCPOptionLength() == 0 && p.TCPDataLength() == 0 {
+		return p.Buffer
+	}
+
+	buffer := make([]byte, len(p.Buffer)+p.TCPOptionLength()+p.TCPDataLength())
+	copyIndex := copy(buffer, p.Buffer)
+	copyIndex += copy(buffer[copyIndex:], p.GetTCPOptions())
+	copyIndex += copy(buffer[copyIndex:], p.GetTCPData())
+
+	return buffer[:copyIndex]
+}
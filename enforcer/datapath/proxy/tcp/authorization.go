@@ -0,0 +1,25 @@
+package tcp
+
+import (
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tunnel"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Authorization is the outcome of the handshake of a proxied connection
+type Authorization struct {
+	// UpKeys are the keys of the tunnel of the up connection, if it is encrypted
+	UpKeys *tunnel.Keys
+	// DownKeys are the keys of the tunnel of the down connection, if it is
+	// encrypted
+	DownKeys *tunnel.Keys
+	// InspectHTTP is true if the HTTP requests of the up connection must be
+	// authorized by the HTTP rules
+	InspectHTTP bool
+	// HTTPRules are the HTTP rules that apply to the peer of the up connection
+	HTTPRules policy.HTTPRuleList
+}
+
+// inspected returns true if the data of the connection cannot be spliced
+func (a *Authorization) inspected() bool {
+	return a.UpKeys != nil || a.DownKeys != nil || a.InspectHTTP
+}
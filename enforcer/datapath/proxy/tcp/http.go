@@ -0,0 +1,125 @@
+//+build linux
+
+package tcp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// bufferedConn is a connection whose data was partially read in a buffer
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// CloseWrite shuts down the sending side of the connection, if it supports it
+func (c *bufferedConn) CloseWrite() error {
+
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
+
+// pipeHTTP proxies the HTTP requests of the client to the backend one at a
+// time, and the responses of the backend to the client. A request that is not
+// authorized by the rules gets a forbidden response and the connection is
+// closed. The connection is proxied without inspection after a protocol
+// upgrade, like a websocket.
+func pipeHTTP(client net.Conn, backend net.Conn, rules policy.HTTPRuleList) error {
+
+	clientReader := bufio.NewReader(client)
+	backendReader := bufio.NewReader(backend)
+
+	for {
+		req, err := http.ReadRequest(clientReader)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("invalid http request: %s", err)
+		}
+
+		if rule := rules.Search(req.Method, req.URL.Path, req.Host); rule == nil {
+			zap.L().Debug("HTTP request rejected by the http rules",
+				zap.String("method", req.Method),
+				zap.String("path", req.URL.Path),
+				zap.String("host", req.Host),
+			)
+			return writeForbidden(client, req)
+		}
+
+		// The backend does not see the expectation, the body is requested
+		// from the client right away
+		if req.Header.Get("Expect") == "100-continue" {
+			req.Header.Del("Expect")
+			if _, err := io.WriteString(client, "HTTP/1.1 100 Continue\r\n\r\n"); err != nil {
+				return err
+			}
+		}
+
+		// Only the headers of the client are sent
+		if _, ok := req.Header["User-Agent"]; !ok {
+			req.Header["User-Agent"] = []string{""}
+		}
+
+		if err := req.Write(backend); err != nil {
+			return fmt.Errorf("unable to send http request: %s", err)
+		}
+
+		resp, err := http.ReadResponse(backendReader, req)
+		if err != nil {
+			return fmt.Errorf("invalid http response: %s", err)
+		}
+
+		if err := resp.Write(client); err != nil {
+			return fmt.Errorf("unable to send http response: %s", err)
+		}
+
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			var wg sync.WaitGroup
+			wg.Add(2)
+
+			go copyConn("to backend", backend, &bufferedConn{Conn: client, reader: clientReader}, &wg)
+			go copyConn("from backend", client, &bufferedConn{Conn: backend, reader: backendReader}, &wg)
+			wg.Wait()
+
+			return nil
+		}
+
+		if req.Close || resp.Close {
+			return nil
+		}
+	}
+}
+
+// writeForbidden sends the forbidden response of a request that closes the
+// connection
+func writeForbidden(client net.Conn, req *http.Request) error {
+
+	resp := &http.Response{
+		StatusCode:    http.StatusForbidden,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        http.Header{},
+		ContentLength: 0,
+		Close:         true,
+	}
+
+	return resp.Write(client)
+}
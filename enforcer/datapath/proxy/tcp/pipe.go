@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"sync"
 	"syscall"
 
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tunnel"
 	"go.uber.org/zap"
 )
 
@@ -116,3 +118,70 @@ func CopyPipe(a, b net.Conn) error {
 	}
 	return nil
 }
+
+// PipeTunnel proxies data bi-directionally between the up connection and the
+// down socket. The data of a side with keys is encrypted with the keys, and
+// the HTTP requests of the up connection are authorized if they are inspected.
+func PipeTunnel(up net.Conn, down int, authorization *Authorization) error {
+
+	// The socket is duplicated, the caller still owns it
+	fd, err := syscall.Dup(down)
+	if err != nil {
+		return fmt.Errorf("unable to duplicate socket: %s", err)
+	}
+
+	file := os.NewFile(uintptr(fd), "downconn")
+	downConn, err := net.FileConn(file)
+	if cerr := file.Close(); cerr != nil {
+		zap.L().Warn("Failed to close down file", zap.Error(cerr))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open down connection: %s", err)
+	}
+	defer downConn.Close() // nolint
+
+	if authorization.UpKeys != nil {
+		if up, err = tunnel.NewConn(up, authorization.UpKeys); err != nil {
+			return err
+		}
+	}
+
+	if authorization.DownKeys != nil {
+		if downConn, err = tunnel.NewConn(downConn, authorization.DownKeys); err != nil {
+			return err
+		}
+	}
+
+	if authorization.InspectHTTP {
+		return pipeHTTP(up, downConn, authorization.HTTPRules)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go copyConn("to backend", downConn, up, &wg)
+	go copyConn("from backend", up, downConn, &wg)
+	wg.Wait()
+
+	return nil
+}
+
+// copyConn copies the data of src to dst and shuts down the sending side of
+// dst. Both connections are closed if the copy fails, like when a record
+// of a tunnel is not authenticated.
+func copyConn(direction string, dst, src net.Conn, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		zap.L().Debug("Copy failed", zap.String("Direction", direction), zap.Error(err))
+		dst.Close() // nolint
+		src.Close() // nolint
+		return
+	}
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err != nil {
+			zap.L().Debug("Unable to close write", zap.String("Direction", direction), zap.Error(err))
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"strconv"
 	"sync"
 	"syscall"
@@ -25,6 +26,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tunnel"
 	"github.com/aporeto-inc/trireme-lib/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
@@ -33,6 +35,8 @@ import (
 const (
 	sockOptOriginalDst = 80
 	proxyMarkInt       = 0x40 //Duplicated from supervisor/iptablesctrl refer to it
+	// synTokenBufferSize leaves room for the user token in the syn token
+	synTokenBufferSize = 8192
 
 )
 
@@ -68,8 +72,9 @@ type proxyFlowProperties struct {
 }
 
 type socketListenerEntry struct {
-	listen net.Listener
-	port   string
+	listen      net.Listener
+	port        string
+	transparent bool
 }
 type sockaddr struct {
 	family uint16
@@ -112,8 +117,9 @@ func (p *Proxy) Enforce(contextID string, puInfo *policy.PUInfo) error {
 		errChan := make(chan error, 1)
 
 		port := puInfo.Runtime.Options().ProxyPort
+		transparent := puInfo.Runtime.Options().TransparentProxy
 
-		go p.StartListener(contextID, errChan, port)
+		go p.StartListener(contextID, errChan, port, transparent)
 		err, closed := <-errChan
 		if closed {
 			return nil
@@ -127,14 +133,15 @@ func (p *Proxy) Enforce(contextID string, puInfo *policy.PUInfo) error {
 
 }
 
-// StartListener implements policyenforcer.Enforcer interface
-func (p *Proxy) StartListener(contextID string, reterr chan error, port string) {
+// StartListener implements policyenforcer.Enforcer interface. A transparent
+// listener accepts the connections sent with TPROXY to any destination.
+func (p *Proxy) StartListener(contextID string, reterr chan error, port string, transparent bool) {
 
 	var err error
 	var listener net.Listener
 	port = ":" + port
 	if p.Forward || !p.Encrypt {
-		if listener, err = net.Listen("tcp", port); err != nil {
+		if listener, err = listen(port, transparent); err != nil {
 			zap.L().Warn("Failed to Bind", zap.Error(err))
 			reterr <- nil
 			return
@@ -147,16 +154,19 @@ func (p *Proxy) StartListener(contextID string, reterr chan error, port string)
 			reterr <- err
 		}
 
-		if listener, err = tls.Listen("tcp", port, config); err != nil {
+		if listener, err = listen(port, transparent); err != nil {
 			reterr <- err
+			return
 		}
+		listener = tls.NewListener(listener, config)
 	}
 	//At this point we are done initing lets close channel
 	close(reterr)
 
 	p.socketListeners.AddOrUpdate(contextID, &socketListenerEntry{
-		listen: listener,
-		port:   port,
+		listen:      listener,
+		port:        port,
+		transparent: transparent,
 	})
 	for {
 
@@ -171,7 +181,7 @@ func (p *Proxy) StartListener(contextID string, reterr chan error, port string)
 			p.wg.Add(1)
 			go func() {
 				defer p.wg.Done()
-				p.handle(conn, contextID)
+				p.handle(conn, contextID, transparent)
 				if connErr := conn.Close(); connErr != nil {
 					zap.L().Error("Failed to close DownConn", zap.String("ContextID", contextID))
 				}
@@ -237,8 +247,9 @@ func (p *Proxy) loadTLS() (*tls.Config, error) {
 	return &tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true}, nil
 }
 
-// handle handles a connection
-func (p *Proxy) handle(upConn net.Conn, contextID string) {
+// handle handles a connection. The connections accepted by a transparent
+// listener are still addressed to their original destination.
+func (p *Proxy) handle(upConn net.Conn, contextID string, transparent bool) {
 	var err error
 
 	var ip []byte
@@ -251,7 +262,11 @@ func (p *Proxy) handle(upConn net.Conn, contextID string) {
 
 	//backend := p.Backend
 	if p.Forward {
-		ip, port, err = getOriginalDestination(upConn)
+		if transparent {
+			ip, port, err = getLocalDestination(upConn)
+		} else {
+			ip, port, err = getOriginalDestination(upConn)
+		}
 		if err != nil {
 			return
 		}
@@ -274,10 +289,17 @@ func (p *Proxy) handle(upConn net.Conn, contextID string) {
 	}()
 
 	// Now let us handle the state machine for the down connection
-	if err := p.CompleteEndPointAuthorization(string(ip), port, upConn, downConn, contextID); err != nil {
+	authorization, err := p.CompleteEndPointAuthorization(string(ip), port, upConn, downConn, contextID)
+	if err != nil {
 		zap.L().Error("Error on Authorization", zap.Error(err))
 		return
 	}
+	if authorization.inspected() {
+		if err := PipeTunnel(upConn, downConn, authorization); err != nil {
+			zap.L().Error("Inspected pipe failed", zap.String("ContextID", contextID), zap.Error(err))
+		}
+		return
+	}
 	if !p.Encrypt {
 		if err := Pipe(upConn.(*net.TCPConn), downConn); err != nil {
 			fmt.Printf("pipe failed: %s", err)
@@ -320,6 +342,67 @@ func getOriginalDestination(conn net.Conn) ([]byte, uint16, error) {
 	return ip, port, nil
 }
 
+// getLocalDestination returns the destination of a connection accepted by a
+// transparent listener, which is its local address
+func getLocalDestination(conn net.Conn) ([]byte, uint16, error) {
+
+	addr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok || addr.IP.To4() == nil {
+		return []byte{}, 0, errors.New("invalid address family")
+	}
+
+	return addr.IP.To4(), uint16(addr.Port), nil
+}
+
+// listen opens a tcp listener. A transparent listener is bound with
+// IP_TRANSPARENT, so that it accepts the connections sent to it with TPROXY.
+func listen(address string, transparent bool) (net.Listener, error) {
+
+	if !transparent {
+		return net.Listen("tcp", address)
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create socket: %s", err)
+	}
+
+	sockaddr := &syscall.SockaddrInet4{Port: addr.Port}
+	if ip := addr.IP.To4(); ip != nil {
+		copy(sockaddr.Addr[:], ip)
+	}
+
+	for _, opt := range []struct{ level, name int }{
+		{syscall.SOL_SOCKET, syscall.SO_REUSEADDR},
+		{syscall.SOL_IP, syscall.IP_TRANSPARENT},
+	} {
+		if err = syscall.SetsockoptInt(fd, opt.level, opt.name, 1); err != nil {
+			syscall.Close(fd) // nolint
+			return nil, fmt.Errorf("unable to set socket options: %s", err)
+		}
+	}
+
+	if err = syscall.Bind(fd, sockaddr); err != nil {
+		syscall.Close(fd) // nolint
+		return nil, fmt.Errorf("unable to bind socket: %s", err)
+	}
+
+	if err = syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		syscall.Close(fd) // nolint
+		return nil, fmt.Errorf("unable to listen on socket: %s", err)
+	}
+
+	file := os.NewFile(uintptr(fd), "tproxy"+address)
+	defer file.Close() // nolint
+
+	return net.FileListener(file)
+}
+
 func (p *Proxy) puContextFromContextID(contextID string) (*pucontext.PUContext, error) {
 
 	ctx, err := p.puFromContextID.Get(contextID)
@@ -392,11 +475,13 @@ func (p *Proxy) downConnection(ip []byte, port uint16) (int, error) {
 
 // CompleteEndPointAuthorization -- Aporeto Handshake on top of a completed connection
 // We will define states here equivalent to SYN_SENT AND SYN_RECEIVED
-func (p *Proxy) CompleteEndPointAuthorization(backendip string, backendport uint16, upConn net.Conn, downConn int, contextID string) error {
+// It returns the keys of the tunnel of the up or the down connection if the
+// connection must be encrypted, and the HTTP rules of the up connection.
+func (p *Proxy) CompleteEndPointAuthorization(backendip string, backendport uint16, upConn net.Conn, downConn int, contextID string) (*Authorization, error) {
 
 	puContext, err := p.puContextFromContextID(contextID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	puContext.Lock()
@@ -430,14 +515,26 @@ func (p *Proxy) CompleteEndPointAuthorization(backendip string, backendport uint
 }
 
 //StartClientAuthStateMachine -- Starts the aporeto handshake for client application
-func (p *Proxy) StartClientAuthStateMachine(backendip string, backendport uint16, upConn net.Conn, downConn int, contextID string) error {
+// The ephemeral key of the client is always sent, and the server sends its own
+// key if its policy encrypts the connection. It returns the keys of the tunnel
+// of the down connection if the connection must be encrypted.
+func (p *Proxy) StartClientAuthStateMachine(backendip string, backendport uint16, upConn net.Conn, downConn int, contextID string) (*Authorization, error) {
 
 	// We are running on top of TCP nothing should be lost or come out of order makes the state machines easy....
 	puContext, err := p.puContextFromContextID(contextID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	conn := connection.NewProxyConnection()
+	if puContext.Type() == constants.UIDLoginPU {
+		conn.Auth.LocalUserToken = puContext.UserToken()
+	}
+	ephemeralKey, err := tunnel.NewEphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+	conn.Auth.LocalServiceContext = ephemeralKey.PublicKey()
+	var keys *tunnel.Keys
 	toAddr, _ := syscall.Getpeername(downConn)
 	localaddr, _ := syscall.Getsockname(downConn)
 	localinet4ip, _ := localaddr.(*syscall.SockaddrInet4)
@@ -458,31 +555,42 @@ L:
 			case connection.ClientTokenSend:
 				token, err := p.tokenaccessor.CreateSynPacketToken(puContext, &conn.Auth)
 				if err != nil {
-					return fmt.Errorf("unable to create syn token: %s", err)
+					return nil, fmt.Errorf("unable to create syn token: %s", err)
 				}
 				if err := syscall.Sendto(downConn, token, 0, toAddr); err != nil {
-					return fmt.Errorf("unable to send syn: %s", err)
+					return nil, fmt.Errorf("unable to send syn: %s", err)
 				}
 				conn.SetState(connection.ClientPeerTokenReceive)
 
 			case connection.ClientPeerTokenReceive:
 				n, _, err := syscall.Recvfrom(downConn, msg, 0)
 				if err != nil {
-					return fmt.Errorf("unable to recvfrom: %s", err)
+					return nil, fmt.Errorf("unable to recvfrom: %s", err)
 				}
 
 				msg = msg[:n]
 				claims, err := p.tokenaccessor.ParsePacketToken(&conn.Auth, msg)
 				if err != nil || claims == nil {
 					p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
-					return fmt.Errorf("peer token reject because of bad claims: error: %s, claims: %v", err, claims)
+					return nil, fmt.Errorf("peer token reject because of bad claims: error: %s, claims: %v", err, claims)
+				}
+
+				if len(conn.Auth.RemoteServiceContext) > 0 {
+					if keys, err = ephemeralKey.Keys(conn.Auth.RemoteServiceContext, conn.Auth.LocalContext, conn.Auth.RemoteContext, true); err != nil {
+						p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
+						return nil, fmt.Errorf("unable to derive tunnel keys: %s", err)
+					}
 				}
 
 				if p.mutualAuthorization {
 					report, packet := puContext.SearchTxtRules(claims.T, !p.mutualAuthorization)
 					if packet.Action.Rejected() {
 						p.reportRejectedFlow(flowProperties, conn, puContext.ManagementID(), conn.Auth.RemoteContextID, puContext, collector.PolicyDrop, report, packet)
-						return errors.New("dropping because of reject rule on transmitter")
+						return nil, errors.New("dropping because of reject rule on transmitter")
+					}
+					if packet.Action.Encrypted() && keys == nil {
+						p.reportRejectedFlow(flowProperties, conn, puContext.ManagementID(), conn.Auth.RemoteContextID, puContext, collector.EncryptionMismatch, report, packet)
+						return nil, errors.New("dropping because the peer does not encrypt the connection")
 					}
 				}
 				conn.SetState(connection.ClientSendSignedPair)
@@ -490,26 +598,29 @@ L:
 			case connection.ClientSendSignedPair:
 				token, err := p.tokenaccessor.CreateAckPacketToken(puContext, &conn.Auth)
 				if err != nil {
-					return fmt.Errorf("unable to create ack token: %s", err)
+					return nil, fmt.Errorf("unable to create ack token: %s", err)
 				}
 				if err := syscall.Sendto(downConn, token, 0, toAddr); err != nil {
-					return fmt.Errorf("unable to send ack: %s", err)
+					return nil, fmt.Errorf("unable to send ack: %s", err)
 				}
 				break L
 			}
 
 		}
 	}
-	return nil
+	return &Authorization{DownKeys: keys}, nil
 
 }
 
 // StartServerAuthStateMachine -- Start the aporeto handshake for a server application
-func (p *Proxy) StartServerAuthStateMachine(backendip string, backendport uint16, upConn io.ReadWriter, downConn int, contextID string) error {
+// It returns the keys of the tunnel of the up connection if the policy of the
+// flow encrypts the connection, and the HTTP rules that apply to the peer if
+// the PU has HTTP rules.
+func (p *Proxy) StartServerAuthStateMachine(backendip string, backendport uint16, upConn io.ReadWriter, downConn int, contextID string) (*Authorization, error) {
 
 	puContext, err := p.puContextFromContextID(contextID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	toAddr, _ := syscall.Getpeername(downConn)
 	localaddr, _ := syscall.Getsockname(downConn)
@@ -523,6 +634,8 @@ func (p *Proxy) StartServerAuthStateMachine(backendip string, backendport uint16
 	}
 	conn := connection.NewProxyConnection()
 	conn.SetState(connection.ServerReceivePeerToken)
+	var ephemeralKey *tunnel.EphemeralKey
+	authorization := &Authorization{}
 
 E:
 	for conn.GetState() == connection.ServerReceivePeerToken {
@@ -532,14 +645,14 @@ E:
 			switch conn.GetState() {
 			case connection.ServerReceivePeerToken:
 				for {
-					data := make([]byte, 1024)
+					data := make([]byte, synTokenBufferSize)
 					n, err := upConn.Read(data)
-					if n < 1024 || err == nil {
+					if n < synTokenBufferSize || err == nil {
 						msg = append(msg, data[:n]...)
 						break
 					}
 					if err != nil {
-						return err
+						return nil, err
 					}
 					msg = append(msg, data[:n]...)
 				}
@@ -547,14 +660,41 @@ E:
 				claims, err := p.tokenaccessor.ParsePacketToken(&conn.Auth, msg)
 				if err != nil || claims == nil {
 					p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
-					return fmt.Errorf("reported rejected flow due to invalid token: %s", err)
+					return nil, fmt.Errorf("reported rejected flow due to invalid token: %s", err)
+				}
+
+				if verifier := puContext.UserVerifier(); verifier != nil {
+					tags, err := verifier.Authorize(conn.Auth.RemoteUserToken, claims.T)
+					if err != nil {
+						p.reportRejectedFlow(flowProperties, conn, conn.Auth.RemoteContextID, puContext.ManagementID(), puContext, collector.InvalidUserToken, nil, nil)
+						return nil, fmt.Errorf("user authorization failed: %s", err)
+					}
+					claims.T = tags
 				}
 
 				claims.T.AppendKeyValue(enforcerconstants.PortNumberLabelString, strconv.Itoa(int(backendport)))
 				report, packet := puContext.SearchRcvRules(claims.T)
 				if packet.Action.Rejected() {
 					p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.PolicyDrop, report, packet)
-					return fmt.Errorf("connection dropped by policy %s: %s", packet.PolicyID, err)
+					return nil, fmt.Errorf("connection dropped by policy %s: %s", packet.PolicyID, err)
+				}
+
+				// The ephemeral key is only sent back if the connection
+				// must be encrypted
+				if packet.Action.Encrypted() {
+					if len(conn.Auth.RemoteServiceContext) == 0 {
+						p.reportRejectedFlow(flowProperties, conn, conn.Auth.RemoteContextID, puContext.ManagementID(), puContext, collector.EncryptionMismatch, report, packet)
+						return nil, errors.New("connection dropped because the peer does not support encryption")
+					}
+					if ephemeralKey, err = tunnel.NewEphemeralKey(); err != nil {
+						return nil, err
+					}
+					conn.Auth.LocalServiceContext = ephemeralKey.PublicKey()
+				}
+
+				if puContext.HasHTTPRules() {
+					authorization.InspectHTTP = true
+					authorization.HTTPRules = puContext.SearchHTTPRules(claims.T)
 				}
 
 				conn.ReportFlowPolicy = report
@@ -564,7 +704,7 @@ E:
 			case connection.ServerSendToken:
 				claims, err := p.tokenaccessor.CreateSynAckPacketToken(puContext, &conn.Auth)
 				if err != nil {
-					return fmt.Errorf("unable to create synack token: %s", err)
+					return nil, fmt.Errorf("unable to create synack token: %s", err)
 				}
 				synackn, err := upConn.Write(claims)
 				if err != nil {
@@ -582,13 +722,20 @@ E:
 						break
 					}
 					if err != nil {
-						return err
+						return nil, err
 					}
 					msg = append(msg, data[:n]...)
 				}
 				if _, err := p.tokenaccessor.ParseAckToken(&conn.Auth, msg); err != nil {
 					p.reportRejectedFlow(flowProperties, conn, collector.DefaultEndPoint, puContext.ManagementID(), puContext, collector.InvalidFormat, nil, nil)
-					return fmt.Errorf("ack packet dropped because signature validation failed %s", err)
+					return nil, fmt.Errorf("ack packet dropped because signature validation failed %s", err)
+				}
+
+				if ephemeralKey != nil {
+					if authorization.UpKeys, err = ephemeralKey.Keys(conn.Auth.RemoteServiceContext, conn.Auth.RemoteContext, conn.Auth.LocalContext, false); err != nil {
+						p.reportRejectedFlow(flowProperties, conn, conn.Auth.RemoteContextID, puContext.ManagementID(), puContext, collector.InvalidToken, nil, nil)
+						return nil, fmt.Errorf("unable to derive tunnel keys: %s", err)
+					}
 				}
 
 				break E
@@ -597,7 +744,7 @@ E:
 	}
 
 	p.reportAcceptedFlow(flowProperties, conn, conn.Auth.RemoteContextID, puContext.ManagementID(), puContext, conn.ReportFlowPolicy, conn.PacketFlowPolicy)
-	return nil
+	return authorization, nil
 }
 
 func (p *Proxy) reportFlow(flowproperties *proxyFlowProperties, conn *connection.ProxyConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
@@ -619,6 +766,7 @@ func (p *Proxy) reportFlow(flowproperties *proxyFlowProperties, conn *connection
 		Action:     report.Action,
 		DropReason: mode,
 		PolicyID:   report.PolicyID,
+		ServiceID:  report.ServiceID,
 	}
 
 	if report.ObserveAction.Observed() {
@@ -626,6 +774,20 @@ func (p *Proxy) reportFlow(flowproperties *proxyFlowProperties, conn *connection
 		c.ObservedPolicyID = packet.PolicyID
 	}
 
+	var remoteTags *policy.TagStore
+	if conn != nil {
+		c.PeerClaims = conn.Auth.RemoteClaims
+		remoteTags = conn.Auth.RemoteTags
+	}
+
+	if sourceID == context.ManagementID() {
+		c.Source.Tags = context.Annotations()
+		c.Destination.Tags = remoteTags
+	} else {
+		c.Source.Tags = remoteTags
+		c.Destination.Tags = context.Annotations()
+	}
+
 	p.collector.CollectFlowEvent(c)
 }
 
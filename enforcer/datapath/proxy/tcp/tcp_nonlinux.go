@@ -92,7 +92,7 @@ func (p *Proxy) Enforce(contextID string, puInfo *policy.PUInfo) error {
 }
 
 // StartListener is a dummy implementation of the policyenforcer.Enforcer for nonlinux compilers.
-func (p *Proxy) StartListener(contextID string, reterr chan error, port string) {
+func (p *Proxy) StartListener(contextID string, reterr chan error, port string, transparent bool) {
 
 	return
 }
@@ -132,19 +132,19 @@ func (p *Proxy) UpdateSecrets(secrets secrets.Secrets) error {
 }
 
 // CompleteEndPointAuthorization is a dummy implementation of the policyenforcer.Enforcer for nonlinux compilers.
-func (p *Proxy) CompleteEndPointAuthorization(backendip string, backendport uint16, upConn net.Conn, downConn int, contextID string) error {
+func (p *Proxy) CompleteEndPointAuthorization(backendip string, backendport uint16, upConn net.Conn, downConn int, contextID string) (*Authorization, error) {
 
-	return nil
+	return nil, nil
 }
 
 // StartClientAuthStateMachine is a dummy implementation of the policyenforcer.Enforcer for nonlinux compilers.
-func (p *Proxy) StartClientAuthStateMachine(backendip string, backendport uint16, upConn net.Conn, downConn int, contextID string) error {
+func (p *Proxy) StartClientAuthStateMachine(backendip string, backendport uint16, upConn net.Conn, downConn int, contextID string) (*Authorization, error) {
 
-	return nil
+	return nil, nil
 }
 
 // StartServerAuthStateMachine is a dummy implementation of the policyenforcer.Enforcer for nonlinux compilers.
-func (p *Proxy) StartServerAuthStateMachine(backendip string, backendport uint16, upConn io.ReadWriter, downConn int, contextID string) error {
+func (p *Proxy) StartServerAuthStateMachine(backendip string, backendport uint16, upConn io.ReadWriter, downConn int, contextID string) (*Authorization, error) {
 
-	return nil
+	return nil, nil
 }
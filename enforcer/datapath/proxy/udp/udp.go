@@ -0,0 +1,498 @@
+// +build linux
+
+package udp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/enforcer/policyenforcer"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+const (
+	proxyMarkInt = 0x40 //Duplicated from supervisor/iptablesctrl refer to it
+	// flowTimeout is the time after which an idle flow is removed
+	flowTimeout = 60 * time.Second
+	// maxDatagramSize is the size of the largest udp datagram
+	maxDatagramSize = 65535
+)
+
+// Proxy relays the datagrams of the udp services proxied for the PUs. The
+// datagrams are sent to the proxy with TPROXY, so that they keep their
+// original destination, and are relayed if the ACLs of the PU accept them.
+type Proxy struct {
+	collector       collector.EventCollector
+	puFromContextID cache.DataStore
+	socketListeners *cache.Cache
+	flows           *cache.Cache
+	// List of local IP's
+	IPList []string
+}
+
+type socketListenerEntry struct {
+	listen *net.UDPConn
+	port   string
+}
+
+// flow is a relayed udp flow. The datagrams of the client are sent to the
+// destination by the upstream socket, and the replies are sent back to the
+// client from the destination by the downstream socket.
+type flow struct {
+	upstream   *net.UDPConn
+	downstream *net.UDPConn
+	// rejected flows are kept to drop their datagrams until they expire
+	rejected bool
+}
+
+// sockopt is an integer socket option
+type sockopt struct {
+	level int
+	name  int
+	value int
+}
+
+// NewProxy creates a new instance of the udp proxy
+func NewProxy(c collector.EventCollector, puFromContextID cache.DataStore) policyenforcer.Enforcer {
+	ifaces, _ := net.Interfaces()
+	iplist := []string{}
+	for _, intf := range ifaces {
+		addrs, _ := intf.Addrs()
+		for _, addr := range addrs {
+			ip, _, _ := net.ParseCIDR(addr.String())
+			if ip.To4() != nil {
+				iplist = append(iplist, ip.String())
+			}
+		}
+	}
+
+	return &Proxy{
+		collector:       c,
+		puFromContextID: puFromContextID,
+		socketListeners: cache.NewCache("udpsocketlisteners"),
+		flows:           cache.NewCacheWithExpirationNotifier("udpproxyflows", flowTimeout, closeFlow),
+		IPList:          iplist,
+	}
+}
+
+// Enforce implements policyenforcer.Enforcer interface. The proxy listens on
+// the proxy port of the PU, which is also the port of its tcp proxy.
+func (p *Proxy) Enforce(contextID string, puInfo *policy.PUInfo) error {
+
+	if _, err := p.socketListeners.Get(contextID); err == nil {
+		return nil
+	}
+
+	port := puInfo.Runtime.Options().ProxyPort
+	if port == "" {
+		return nil
+	}
+
+	conn, err := listen(":" + port)
+	if err != nil {
+		zap.L().Warn("Failed to Bind", zap.String("ContextID", contextID), zap.Error(err))
+		return nil
+	}
+
+	p.socketListeners.AddOrUpdate(contextID, &socketListenerEntry{
+		listen: conn,
+		port:   port,
+	})
+
+	go p.serve(contextID, conn)
+
+	return nil
+}
+
+// Unenforce implements policyenforcer.Enforcer interface. The flows of the PU
+// are left to expire.
+func (p *Proxy) Unenforce(contextID string) error {
+
+	entry, err := p.socketListeners.Get(contextID)
+	if err != nil {
+		return nil
+	}
+
+	if cerr := entry.(*socketListenerEntry).listen.Close(); cerr != nil {
+		zap.L().Error("Close failed for udp listener", zap.String("ContextID", contextID))
+	}
+
+	if err = p.socketListeners.Remove(contextID); err != nil {
+		zap.L().Error("Cannot remove Socket Listener", zap.Error(err), zap.String("ContextID", contextID))
+	}
+
+	return nil
+}
+
+// GetFilterQueue is a stub for UDP proxy
+func (p *Proxy) GetFilterQueue() *fqconfig.FilterQueue {
+	return nil
+}
+
+// GetPortSetInstance returns nil for the proxy
+func (p *Proxy) GetPortSetInstance() portset.PortSet {
+	return nil
+}
+
+// Start is a stub for UDP proxy
+func (p *Proxy) Start() error {
+	return nil
+}
+
+// Stop closes the listeners of the proxy
+func (p *Proxy) Stop() error {
+
+	for _, contextID := range p.socketListeners.KeyList() {
+		p.Unenforce(contextID.(string)) // nolint
+	}
+
+	return nil
+}
+
+// UpdateSecrets is a stub for UDP proxy, which does not use tokens
+func (p *Proxy) UpdateSecrets(secrets secrets.Secrets) error {
+	return nil
+}
+
+// serve relays the datagrams received by the listener of a PU until it is
+// closed
+func (p *Proxy) serve(contextID string, conn *net.UDPConn) {
+
+	buf := make([]byte, maxDatagramSize)
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofSockaddrInet4))
+
+	for {
+		n, oobn, _, client, err := conn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			return
+		}
+
+		dest, err := originalDestination(oob[:oobn])
+		if err != nil {
+			zap.L().Debug("Unable to find the destination of a datagram", zap.String("ContextID", contextID), zap.Error(err))
+			continue
+		}
+
+		p.forward(contextID, client, dest, buf[:n])
+	}
+}
+
+// forward sends a datagram of a client to its destination. A new flow is
+// created for the first datagram sent by a client to a destination.
+func (p *Proxy) forward(contextID string, client *net.UDPAddr, dest *net.UDPAddr, data []byte) {
+
+	key := contextID + ":" + client.String() + ":" + dest.String()
+
+	var f *flow
+	if entry, err := p.flows.GetReset(key, 0); err == nil {
+		f = entry.(*flow)
+	} else {
+		if f, err = p.newFlow(contextID, key, client, dest); err != nil {
+			zap.L().Warn("Unable to create udp proxy flow", zap.String("ContextID", contextID), zap.Error(err))
+			return
+		}
+	}
+
+	if f.rejected {
+		return
+	}
+
+	if _, err := f.upstream.Write(data); err != nil {
+		zap.L().Debug("Unable to forward datagram", zap.String("ContextID", contextID), zap.Error(err))
+	}
+}
+
+// newFlow creates the flow of a client and a destination if the ACLs of the PU
+// accept it
+func (p *Proxy) newFlow(contextID string, key string, client *net.UDPAddr, dest *net.UDPAddr) (*flow, error) {
+
+	puContext, err := p.puContextFromContextID(contextID)
+	if err != nil {
+		return nil, err
+	}
+
+	report, action, app, err := p.flowPolicy(puContext, client, dest)
+	if err != nil || action.Action.Rejected() {
+		p.reportFlow(puContext, report, action, app, client, dest)
+		f := &flow{rejected: true}
+		p.flows.AddOrUpdate(key, f)
+		return f, nil
+	}
+
+	upstream, err := udpSocket([]sockopt{
+		{syscall.SOL_SOCKET, syscall.SO_MARK, proxyMarkInt},
+	}, nil, dest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %s", dest, err)
+	}
+
+	// The replies are sent from the destination, which may not be local. The
+	// socket is connected to the client, so TPROXY delivers the next datagrams
+	// of the client to it, and it does not receive the datagrams of others.
+	downstream, err := udpSocket([]sockopt{
+		{syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1},
+		{syscall.SOL_IP, syscall.IP_TRANSPARENT, 1},
+		{syscall.SOL_SOCKET, syscall.SO_MARK, proxyMarkInt},
+	}, dest, client)
+	if err != nil {
+		upstream.Close() // nolint
+		return nil, fmt.Errorf("unable to bind to %s: %s", dest, err)
+	}
+
+	f := &flow{
+		upstream:   upstream,
+		downstream: downstream,
+	}
+	p.flows.AddOrUpdate(key, f)
+
+	p.reportFlow(puContext, report, action, app, client, dest)
+
+	go p.relay(key, upstream, downstream)
+	go p.relay(key, downstream, upstream)
+
+	return f, nil
+}
+
+// relay sends the datagrams received by a socket of a flow to the other one
+// until the flow expires
+func (p *Proxy) relay(key string, from *net.UDPConn, to *net.UDPConn) {
+
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, err := from.Read(buf)
+		if err != nil {
+			return
+		}
+
+		p.flows.GetReset(key, 0) // nolint
+
+		if _, err := to.Write(buf[:n]); err != nil {
+			zap.L().Debug("Unable to relay datagram", zap.String("Flow", key), zap.Error(err))
+		}
+	}
+}
+
+// flowPolicy returns the policy of the ACLs of a PU for a flow. The flows sent
+// to a local address are received by the PU, the others are sent by the PU.
+func (p *Proxy) flowPolicy(puContext *pucontext.PUContext, client *net.UDPAddr, dest *net.UDPAddr) (report *policy.FlowPolicy, action *policy.FlowPolicy, app bool, err error) {
+
+	pkt := &packet.Packet{
+		SourceAddress:      client.IP.To4(),
+		DestinationAddress: dest.IP.To4(),
+		SourcePort:         uint16(client.Port),
+		DestinationPort:    uint16(dest.Port),
+	}
+
+	for _, ip := range p.IPList {
+		if ip == dest.IP.String() {
+			report, action, err = puContext.NetworkUDPACLPolicy(pkt)
+			return report, action, false, err
+		}
+	}
+
+	report, action, err = puContext.ApplicationUDPACLPolicy(pkt)
+	return report, action, true, err
+}
+
+// reportFlow reports a flow of a PU with an external service
+func (p *Proxy) reportFlow(puContext *pucontext.PUContext, report *policy.FlowPolicy, action *policy.FlowPolicy, app bool, client *net.UDPAddr, dest *net.UDPAddr) {
+
+	if report == nil {
+		report = &policy.FlowPolicy{
+			Action:   policy.Reject,
+			PolicyID: "",
+		}
+	}
+	if action == nil {
+		action = report
+	}
+
+	src := &collector.EndPoint{
+		IP:   client.IP.String(),
+		Port: uint16(client.Port),
+	}
+
+	dst := &collector.EndPoint{
+		IP:   dest.IP.String(),
+		Port: uint16(dest.Port),
+	}
+
+	if app {
+		src.ID = puContext.ManagementID()
+		src.Type = collector.PU
+		src.Tags = puContext.Annotations()
+		dst.ID = report.ServiceID
+		dst.Type = collector.Address
+	} else {
+		src.ID = report.ServiceID
+		src.Type = collector.Address
+		dst.ID = puContext.ManagementID()
+		dst.Type = collector.PU
+		dst.Tags = puContext.Annotations()
+	}
+
+	record := &collector.FlowRecord{
+		ContextID:   puContext.ID(),
+		Source:      src,
+		Destination: dst,
+		DropReason:  collector.PolicyDrop,
+		Action:      report.Action,
+		Tags:        puContext.Annotations(),
+		PolicyID:    report.PolicyID,
+		ServiceID:   report.ServiceID,
+	}
+
+	if report.ObserveAction.Observed() {
+		record.ObservedAction = action.Action
+		record.ObservedPolicyID = action.PolicyID
+	}
+
+	p.collector.CollectFlowEvent(record)
+}
+
+func (p *Proxy) puContextFromContextID(contextID string) (*pucontext.PUContext, error) {
+
+	ctx, err := p.puFromContextID.Get(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("Context not found %s", contextID)
+	}
+
+	puContext, ok := ctx.(*pucontext.PUContext)
+	if !ok {
+		return nil, fmt.Errorf("Context not converted %s", contextID)
+	}
+
+	return puContext, nil
+}
+
+// closeFlow closes the sockets of an expired flow
+func closeFlow(c cache.DataStore, id interface{}, item interface{}) {
+
+	f, ok := item.(*flow)
+	if !ok || f.rejected {
+		return
+	}
+
+	if err := f.upstream.Close(); err != nil {
+		zap.L().Debug("Unable to close upstream socket", zap.Error(err))
+	}
+
+	if err := f.downstream.Close(); err != nil {
+		zap.L().Debug("Unable to close downstream socket", zap.Error(err))
+	}
+}
+
+// originalDestination returns the original destination of a datagram from its
+// IP_ORIGDSTADDR control message
+func originalDestination(oob []byte) (*net.UDPAddr, error) {
+
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		if msg.Header.Level != syscall.SOL_IP || msg.Header.Type != syscall.IP_ORIGDSTADDR {
+			continue
+		}
+
+		if len(msg.Data) < syscall.SizeofSockaddrInet4 {
+			return nil, errors.New("invalid original destination")
+		}
+
+		return &net.UDPAddr{
+			IP:   net.IPv4(msg.Data[4], msg.Data[5], msg.Data[6], msg.Data[7]),
+			Port: int(msg.Data[2])<<8 + int(msg.Data[3]),
+		}, nil
+	}
+
+	return nil, errors.New("no original destination")
+}
+
+// listen opens the transparent udp socket of a proxy. It receives the
+// datagrams sent to it with TPROXY along with their original destinations.
+func listen(address string) (*net.UDPConn, error) {
+
+	addr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return udpSocket([]sockopt{
+		{syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1},
+		{syscall.SOL_IP, syscall.IP_TRANSPARENT, 1},
+		{syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, 1},
+	}, addr, nil)
+}
+
+// udpSocket opens a udp socket with the given options. It is bound to laddr and
+// connected to raddr if they are given.
+func udpSocket(opts []sockopt, laddr *net.UDPAddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create socket: %s", err)
+	}
+
+	for _, opt := range opts {
+		if err = syscall.SetsockoptInt(fd, opt.level, opt.name, opt.value); err != nil {
+			syscall.Close(fd) // nolint
+			return nil, fmt.Errorf("unable to set socket options: %s", err)
+		}
+	}
+
+	if laddr != nil {
+		if err = syscall.Bind(fd, sockaddr(laddr)); err != nil {
+			syscall.Close(fd) // nolint
+			return nil, fmt.Errorf("unable to bind socket: %s", err)
+		}
+	}
+
+	if raddr != nil {
+		if err = syscall.Connect(fd, sockaddr(raddr)); err != nil {
+			syscall.Close(fd) // nolint
+			return nil, fmt.Errorf("unable to connect socket: %s", err)
+		}
+	}
+
+	file := os.NewFile(uintptr(fd), "udpproxy")
+	defer file.Close() // nolint
+
+	conn, err := net.FileConn(file)
+	if err != nil {
+		return nil, err
+	}
+
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close() // nolint
+		return nil, errors.New("invalid udp socket")
+	}
+
+	return udpConn, nil
+}
+
+// sockaddr converts a udp address to a socket address
+func sockaddr(addr *net.UDPAddr) *syscall.SockaddrInet4 {
+
+	sa := &syscall.SockaddrInet4{Port: addr.Port}
+	if ip := addr.IP.To4(); ip != nil {
+		copy(sa.Addr[:], ip)
+	}
+
+	return sa
+}
@@ -0,0 +1,56 @@
+// +build !linux
+
+package udp
+
+import (
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/enforcer/policyenforcer"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+// Proxy is a dummy udp proxy for nonlinux compilers
+type Proxy struct{}
+
+// NewProxy is a dummy implementation for nonlinux compilers
+func NewProxy(c collector.EventCollector, puFromContextID cache.DataStore) policyenforcer.Enforcer {
+	return &Proxy{}
+}
+
+// Enforce is a dummy implementation of the policyenforcer.Enforcer for nonlinux compilers
+func (p *Proxy) Enforce(contextID string, puInfo *policy.PUInfo) error {
+	return nil
+}
+
+// Unenforce is a dummy implementation of the policyenforcer.Enforcer for nonlinux compilers
+func (p *Proxy) Unenforce(contextID string) error {
+	return nil
+}
+
+// GetFilterQueue is a stub for UDP proxy
+func (p *Proxy) GetFilterQueue() *fqconfig.FilterQueue {
+	return nil
+}
+
+// GetPortSetInstance returns nil for the proxy
+func (p *Proxy) GetPortSetInstance() portset.PortSet {
+	return nil
+}
+
+// Start is a stub for UDP proxy
+func (p *Proxy) Start() error {
+	return nil
+}
+
+// Stop is a stub for UDP proxy
+func (p *Proxy) Stop() error {
+	return nil
+}
+
+// UpdateSecrets is a stub for UDP proxy
+func (p *Proxy) UpdateSecrets(secrets secrets.Secrets) error {
+	return nil
+}
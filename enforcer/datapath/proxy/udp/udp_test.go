@@ -0,0 +1,125 @@
+// +build linux
+
+package udp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+// origDstMessage returns an IP_ORIGDSTADDR control message for an address
+func origDstMessage(ip net.IP, port int) []byte {
+
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofSockaddrInet4))
+
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = syscall.SOL_IP
+	h.Type = syscall.IP_ORIGDSTADDR
+	h.SetLen(syscall.CmsgLen(syscall.SizeofSockaddrInet4))
+
+	data := oob[syscall.CmsgLen(0):]
+	data[0] = syscall.AF_INET
+	data[2] = byte(port >> 8)
+	data[3] = byte(port)
+	copy(data[4:8], ip.To4())
+
+	return oob
+}
+
+func TestOriginalDestination(t *testing.T) {
+	Convey("Given the control message of a datagram", t, func() {
+
+		Convey("If it has the original destination, I should get it", func() {
+			dest, err := originalDestination(origDstMessage(net.ParseIP("10.1.1.1"), 53))
+			So(err, ShouldBeNil)
+			So(dest.IP.String(), ShouldEqual, "10.1.1.1")
+			So(dest.Port, ShouldEqual, 53)
+		})
+
+		Convey("If it has no original destination, I should get an error", func() {
+			_, err := originalDestination([]byte{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFlowPolicy(t *testing.T) {
+	Convey("Given a udp proxy and a PU with udp ACLs", t, func() {
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.1.1.0/24",
+				Port:     "53",
+				Protocol: "udp",
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: "dns",
+				},
+			},
+		}
+
+		puInfo := policy.NewPUInfo("pu", constants.ContainerPU)
+		puInfo.Policy = policy.NewPUPolicy("pu", policy.Police, rules, rules, nil, nil, nil, nil, nil, []string{}, []string{}, &policy.ProxiedServicesInfo{})
+
+		puContext, err := pucontext.NewPU("pu", puInfo, 0)
+		So(err, ShouldBeNil)
+
+		puFromContextID := cache.NewCache("test")
+		puFromContextID.AddOrUpdate("pu", puContext)
+
+		p := NewProxy(&collector.DefaultCollector{}, puFromContextID).(*Proxy)
+		p.IPList = []string{"172.17.0.2"}
+
+		Convey("If the PU sends a datagram to an accepted service, it should be accepted", func() {
+			_, action, app, err := p.flowPolicy(puContext,
+				&net.UDPAddr{IP: net.ParseIP("172.17.0.2"), Port: 40000},
+				&net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 53},
+			)
+			So(err, ShouldBeNil)
+			So(app, ShouldBeTrue)
+			So(action.Action.Accepted(), ShouldBeTrue)
+			So(action.PolicyID, ShouldEqual, "dns")
+		})
+
+		Convey("If the PU sends a datagram to another service, it should not be accepted", func() {
+			_, action, _, err := p.flowPolicy(puContext,
+				&net.UDPAddr{IP: net.ParseIP("172.17.0.2"), Port: 40000},
+				&net.UDPAddr{IP: net.ParseIP("10.2.1.1"), Port: 53},
+			)
+			So(err != nil || action.Action.Rejected(), ShouldBeTrue)
+		})
+
+		Convey("If the PU receives a datagram from an accepted network, it should be accepted", func() {
+			_, action, app, err := p.flowPolicy(puContext,
+				&net.UDPAddr{IP: net.ParseIP("10.1.1.1"), Port: 40000},
+				&net.UDPAddr{IP: net.ParseIP("172.17.0.2"), Port: 53},
+			)
+			So(err, ShouldBeNil)
+			So(app, ShouldBeFalse)
+			So(action.Action.Accepted(), ShouldBeTrue)
+		})
+
+		Convey("If a flow is rejected, it should be kept to drop its datagrams", func() {
+			f, err := p.newFlow("pu", "key",
+				&net.UDPAddr{IP: net.ParseIP("172.17.0.2"), Port: 40000},
+				&net.UDPAddr{IP: net.ParseIP("10.2.1.1"), Port: 53},
+			)
+			So(err, ShouldBeNil)
+			So(f.rejected, ShouldBeTrue)
+
+			entry, err := p.flows.Get("key")
+			So(err, ShouldBeNil)
+			So(entry.(*flow).rejected, ShouldBeTrue)
+		})
+	})
+}
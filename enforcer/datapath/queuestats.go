@@ -0,0 +1,231 @@
+package datapath
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
+	"go.uber.org/zap"
+)
+
+// nfqueueStatsPath is the file with the counters of the netfilter queues kept
+// by the kernel for the network namespace of the enforcer
+var nfqueueStatsPath = "/proc/net/netfilter/nfnetlink_queue"
+
+// queueCounters holds the counters of a netfilter queue. They are only updated
+// by the callbacks of the queue, and are read atomically by the reports.
+type queueCounters struct {
+	packets uint64
+	dropped uint64
+	// latency is the total time spent to give verdicts, in nanoseconds
+	latency    int64
+	maxLatency int64
+	network    bool
+}
+
+// count accounts a packet that got a verdict after latency
+func (q *queueCounters) count(dropped bool, latency time.Duration) {
+
+	atomic.AddUint64(&q.packets, 1)
+	if dropped {
+		atomic.AddUint64(&q.dropped, 1)
+	}
+
+	atomic.AddInt64(&q.latency, int64(latency))
+	if int64(latency) > atomic.LoadInt64(&q.maxLatency) {
+		atomic.StoreInt64(&q.maxLatency, int64(latency))
+	}
+}
+
+// kernelQueueStats holds the counters of a netfilter queue kept by the kernel
+type kernelQueueStats struct {
+	backlog  uint32
+	overruns uint64
+}
+
+// newQueueCounters returns the counters of the application and network queues
+// of a filter queue configuration, by queue number
+func newQueueCounters(fq *fqconfig.FilterQueue) map[uint16]*queueCounters {
+
+	queues := map[uint16]*queueCounters{}
+
+	for i := uint16(0); i < fq.GetNumApplicationQueues(); i++ {
+		queue := fq.GetApplicationQueueStart() + i
+		queues[queue] = &queueCounters{}
+	}
+
+	for i := uint16(0); i < fq.GetNumNetworkQueues(); i++ {
+		queue := fq.GetNetworkQueueStart() + i
+		queues[queue] = &queueCounters{network: true}
+	}
+
+	return queues
+}
+
+// readKernelQueueStats reads the counters of the netfilter queues kept by the
+// kernel
+func readKernelQueueStats() (map[uint16]*kernelQueueStats, error) {
+
+	file, err := os.Open(nfqueueStatsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // nolint
+
+	return parseKernelQueueStats(file)
+}
+
+// parseKernelQueueStats parses the counters of the netfilter queues kept by the
+// kernel. Every line holds the queue number, the port ID of the listener, the
+// backlog, the copy mode and range, the packets dropped because the queue was
+// full, the packets dropped because the socket of the listener was full, and
+// the ID of the last packet.
+func parseKernelQueueStats(r io.Reader) (map[uint16]*kernelQueueStats, error) {
+
+	stats := map[uint16]*kernelQueueStats{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+
+		queue, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			continue
+		}
+		backlog, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		queueDropped, err := strconv.ParseUint(fields[5], 10, 64)
+		if err != nil {
+			continue
+		}
+		userDropped, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		stats[uint16(queue)] = &kernelQueueStats{
+			backlog:  uint32(backlog),
+			overruns: queueDropped + userDropped,
+		}
+	}
+
+	return stats, scanner.Err()
+}
+
+// countDroppedPacket accounts a dropped packet for its PU, if the PU is known.
+// The queues of a remote enforcer already count the packets of its PU.
+func (d *Datapath) countDroppedPacket(p *packet.Packet, app bool) {
+
+	if p == nil || d.puFromIP != nil {
+		return
+	}
+
+	ip, port := p.DestinationAddress.String(), p.DestinationPort
+	if app {
+		ip, port = p.SourceAddress.String(), p.SourcePort
+	}
+
+	context, err := d.contextFromIP(app, ip, p.Mark, port)
+	if err != nil {
+		return
+	}
+
+	d.puDropsLock.Lock()
+	defer d.puDropsLock.Unlock()
+
+	d.puDrops[context.ID()]++
+}
+
+// removeQueueStats removes the dropped packets of a PU
+func (d *Datapath) removeQueueStats(contextID string) {
+
+	d.puDropsLock.Lock()
+	defer d.puDropsLock.Unlock()
+
+	delete(d.puDrops, contextID)
+}
+
+// GetStats implements the interface policyenforcer.StatsReporter. It returns
+// the counters of the queues that got packets or are known to the kernel, and
+// the packets dropped for each PU. The queues of a remote enforcer have the
+// context ID of its PU.
+func (d *Datapath) GetStats() []*collector.QueueStatsRecord {
+
+	kernel, err := readKernelQueueStats()
+	if err != nil {
+		zap.L().Debug("Unable to read the counters of the netfilter queues", zap.Error(err))
+	}
+
+	puID := ""
+	if d.puFromIP != nil {
+		puID = d.puFromIP.ID()
+	}
+
+	records := []*collector.QueueStatsRecord{}
+
+	d.queueLock.Lock()
+	for queue, counters := range d.queueCounters {
+		record := &collector.QueueStatsRecord{
+			ContextID: puID,
+			Queue:     queue,
+			Network:   counters.network,
+			Packets:   atomic.LoadUint64(&counters.packets),
+			Dropped:   atomic.LoadUint64(&counters.dropped),
+		}
+
+		stats, ok := kernel[queue]
+		if !ok && record.Packets == 0 {
+			continue
+		}
+
+		if ok {
+			record.Backlog = stats.backlog
+			record.Overruns = stats.overruns
+		}
+
+		if record.Packets > 0 {
+			record.VerdictLatency = time.Duration(atomic.LoadInt64(&counters.latency) / int64(record.Packets))
+			record.MaxVerdictLatency = time.Duration(atomic.LoadInt64(&counters.maxLatency))
+		}
+
+		records = append(records, record)
+	}
+	d.queueLock.Unlock()
+
+	d.puDropsLock.Lock()
+	for contextID, dropped := range d.puDrops {
+		records = append(records, &collector.QueueStatsRecord{
+			ContextID: contextID,
+			Dropped:   dropped,
+		})
+	}
+	d.puDropsLock.Unlock()
+
+	return records
+}
+
+// reportQueueStats reports the counters of the queues to the collector, if it
+// collects the queue records
+func (d *Datapath) reportQueueStats() {
+
+	statsCollector, ok := d.collector.(collector.QueueStatsCollector)
+	if !ok {
+		return
+	}
+
+	for _, record := range d.GetStats() {
+		statsCollector.CollectQueueStats(record)
+	}
+}
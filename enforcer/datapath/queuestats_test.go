@@ -0,0 +1,117 @@
+package datapath
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+)
+
+const nfqueueStats = `    0  28462     0 2 65531     0     0     1254  1
+    4  28462    12 2 65531    30     5   983412  1
+`
+
+// queueStatsCollector keeps the queue records it collects
+type queueStatsCollector struct {
+	collector.DefaultCollector
+	records []*collector.QueueStatsRecord
+}
+
+func (c *queueStatsCollector) CollectQueueStats(record *collector.QueueStatsRecord) {
+	c.records = append(c.records, record)
+}
+
+func TestParseKernelQueueStats(t *testing.T) {
+
+	Convey("When I parse the counters of the netfilter queues", t, func() {
+		stats, err := parseKernelQueueStats(strings.NewReader(nfqueueStats))
+		So(err, ShouldBeNil)
+
+		Convey("I should get the backlog and the overruns of every queue", func() {
+			So(len(stats), ShouldEqual, 2)
+			So(stats[0].backlog, ShouldEqual, 0)
+			So(stats[0].overruns, ShouldEqual, 0)
+			So(stats[4].backlog, ShouldEqual, 12)
+			So(stats[4].overruns, ShouldEqual, 35)
+		})
+	})
+}
+
+func TestQueueStats(t *testing.T) {
+
+	Convey("Given a datapath with an application and a network queue", t, func() {
+		nfqueueStatsPath = "/nonexistent"
+
+		c := &queueStatsCollector{}
+		d := &Datapath{
+			collector: c,
+			queueCounters: map[uint16]*queueCounters{
+				0: {},
+				1: {network: true},
+			},
+			puDrops: map[string]uint64{},
+		}
+
+		Convey("When the network queue gives verdicts", func() {
+			d.queueCounters[1].count(false, time.Millisecond)
+			d.queueCounters[1].count(true, 3*time.Millisecond)
+			d.puDrops["pu1"]++
+
+			Convey("Then only the queue with packets and the PU should be reported", func() {
+				d.reportQueueStats()
+				So(len(c.records), ShouldEqual, 2)
+
+				So(c.records[0], ShouldResemble, &collector.QueueStatsRecord{
+					Queue:             1,
+					Network:           true,
+					Packets:           2,
+					Dropped:           1,
+					VerdictLatency:    2 * time.Millisecond,
+					MaxVerdictLatency: 3 * time.Millisecond,
+				})
+				So(c.records[1], ShouldResemble, &collector.QueueStatsRecord{
+					ContextID: "pu1",
+					Dropped:   1,
+				})
+			})
+
+			Convey("Then the dropped packets of a removed PU should not be reported", func() {
+				d.removeQueueStats("pu1")
+				So(len(d.GetStats()), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestUpdateFilterQueue(t *testing.T) {
+
+	Convey("Given a datapath that is not started", t, func() {
+		d := &Datapath{
+			filterQueue:   fqconfig.NewFilterQueue(true, fqconfig.DefaultMarkValue, 0, 1, 1, 100, 100),
+			queueCounters: map[uint16]*queueCounters{0: {}},
+		}
+		d.queueCounters[0].count(false, time.Millisecond)
+
+		Convey("When I update its filter queue", func() {
+			fq := d.GetFilterQueue().Resize(2, 2)
+			err := d.UpdateFilterQueue(fq)
+
+			Convey("Then it should use the new queues with new counters", func() {
+				So(err, ShouldBeNil)
+				So(d.GetFilterQueue(), ShouldEqual, fq)
+				So(len(d.queueCounters), ShouldEqual, 16)
+				So(d.queueCounters[0].packets, ShouldEqual, 0)
+				So(d.queueCounters[8].network, ShouldBeTrue)
+				So(d.appStop, ShouldBeNil)
+			})
+		})
+
+		Convey("When I update it without a filter queue, it should fail", func() {
+			So(d.UpdateFilterQueue(nil), ShouldNotBeNil)
+		})
+	})
+}
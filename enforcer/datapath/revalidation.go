@@ -0,0 +1,115 @@
+package datapath
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+)
+
+// SetFlowRevalidation enables the periodic verification of the peers of the
+// authorized flows with the current secrets, so that the flows of the peers
+// whose certificate expired or was revoked lose their authorization. It must
+// be called before Start. The flows are always verified when the secrets are
+// updated.
+func (d *Datapath) SetFlowRevalidation(interval time.Duration) {
+
+	d.revalidationInterval = interval
+}
+
+// revalidateAuthorizedFlows verifies the public keys of the peers of the
+// authorized flows that were released to the kernel with the given secrets.
+// The flows of the peers that are still trusted are not interrupted. The other
+// flows lose their connmark and their state, so that their next packets are
+// not accepted without a new handshake. The peers of the pre-shared keys are
+// always trusted, since they do not transmit a key. It returns the number of
+// flows that lost their authorization.
+func (d *Datapath) revalidateAuthorizedFlows(s secrets.Secrets) int {
+
+	// Peers transmit the same key for all their flows
+	trusted := map[string]bool{}
+	revoked := 0
+
+	for _, key := range d.idleFlowTracker.KeyList() {
+
+		item, err := d.idleFlowTracker.Get(key)
+		if err != nil {
+			continue
+		}
+		flow := item.(*idleFlow)
+
+		flow.conn.RLock()
+		txKey := flow.conn.Auth.RemoteTransmittedKey
+		flow.conn.RUnlock()
+
+		valid, ok := trusted[string(txKey)]
+		if !ok {
+			_, err := s.VerifyPublicKey(txKey)
+			valid = err == nil
+			trusted[string(txKey)] = valid
+		}
+
+		if valid {
+			continue
+		}
+
+		if err := d.idleFlowTracker.Remove(key); err != nil {
+			continue
+		}
+
+		// The packets of the flow go through the datapath again, where they
+		// have no state anymore
+		if err := d.conntrackHdl.ConntrackTableUpdateMark(
+			flow.srcIP,
+			flow.dstIP,
+			flow.proto,
+			flow.srcPort,
+			flow.dstPort,
+			0,
+		); err != nil {
+			zap.L().Debug("Failed to clear conntrack mark of revoked flow",
+				zap.String("flow", key.(string)),
+				zap.Error(err),
+			)
+		}
+
+		revoked++
+	}
+
+	if revoked > 0 {
+		zap.L().Info("Revoked the authorization of flows with untrusted peers", zap.Int("flows", revoked))
+	}
+
+	return revoked
+}
+
+// startFlowRevalidation periodically verifies the peers of the authorized
+// flows with the current secrets
+func (d *Datapath) startFlowRevalidation() {
+
+	d.revalidationStop = make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(d.revalidationInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.revalidateAuthorizedFlows(d.currentSecrets())
+			case <-d.revalidationStop:
+				return
+			}
+		}
+	}()
+}
+
+// currentSecrets returns the secrets of the last update
+func (d *Datapath) currentSecrets() secrets.Secrets {
+
+	d.secretsLock.RLock()
+	defer d.secretsLock.RUnlock()
+
+	return d.secrets
+}
@@ -0,0 +1,342 @@
+package simulation
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packetgen"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	clientMAC = "aa:ff:aa:ff:aa:ff"
+	serverMAC = "bb:ff:bb:ff:bb:ff"
+)
+
+// Flow is a TCP connection between two nodes. Every segment is processed by
+// the application path of the sender and then by the network path of the
+// receiver, the same way it would be between two hosts.
+type Flow struct {
+	client    *Node
+	server    *Node
+	sport     uint16
+	dport     uint16
+	clientSeq uint32
+	serverSeq uint32
+}
+
+// NewFlow creates a new flow from the client to the server
+func NewFlow(client, server *Node, sport, dport uint16) *Flow {
+
+	return &Flow{
+		client:    client,
+		server:    server,
+		sport:     sport,
+		dport:     dport,
+		clientSeq: 1000,
+		serverSeq: 5000,
+	}
+}
+
+// Handshake runs the Syn, SynAck and Ack exchange between the two nodes
+func (f *Flow) Handshake() error {
+
+	syn, err := f.segment(true, func(p packetgen.PacketManipulator) { p.SetTCPSyn() }, f.clientSeq, 0, "")
+	if err != nil {
+		return err
+	}
+	if err := transmit(f.client, f.server, syn); err != nil {
+		return fmt.Errorf("syn: %s", err)
+	}
+	f.clientSeq++
+
+	synAck, err := f.segment(false, func(p packetgen.PacketManipulator) { p.SetTCPSynAck() }, f.serverSeq, f.clientSeq, "")
+	if err != nil {
+		return err
+	}
+	if err := transmit(f.server, f.client, synAck); err != nil {
+		return fmt.Errorf("synack: %s", err)
+	}
+	f.serverSeq++
+
+	ack, err := f.segment(true, func(p packetgen.PacketManipulator) { p.SetTCPAck() }, f.clientSeq, f.serverSeq, "")
+	if err != nil {
+		return err
+	}
+	if err := transmit(f.client, f.server, ack); err != nil {
+		return fmt.Errorf("ack: %s", err)
+	}
+
+	return nil
+}
+
+// SimultaneousOpen runs a handshake where the Syn packets of the two nodes
+// cross each other, and both nodes reply with a SynAck packet
+func (f *Flow) SimultaneousOpen() error {
+
+	clientSyn, err := f.Syn(true)
+	if err != nil {
+		return fmt.Errorf("client syn: %s", err)
+	}
+	serverSyn, err := f.Syn(false)
+	if err != nil {
+		return fmt.Errorf("server syn: %s", err)
+	}
+
+	if err := f.Receive(true, clientSyn); err != nil {
+		return fmt.Errorf("client syn: %s", err)
+	}
+	if err := f.Receive(false, serverSyn); err != nil {
+		return fmt.Errorf("server syn: %s", err)
+	}
+
+	clientSynAck, err := f.SynAck(true)
+	if err != nil {
+		return fmt.Errorf("client synack: %s", err)
+	}
+	serverSynAck, err := f.SynAck(false)
+	if err != nil {
+		return fmt.Errorf("server synack: %s", err)
+	}
+
+	if err := f.Receive(true, clientSynAck); err != nil {
+		return fmt.Errorf("client synack: %s", err)
+	}
+	if err := f.Receive(false, serverSynAck); err != nil {
+		return fmt.Errorf("server synack: %s", err)
+	}
+
+	f.clientSeq++
+	f.serverSeq++
+
+	return nil
+}
+
+// Syn processes a Syn packet of the client, or of the server in a simultaneous
+// open, on the application path of its sender and returns the packet sent to
+// the network. Retransmissions are sent with the same sequence number.
+func (f *Flow) Syn(fromClient bool) ([]byte, error) {
+
+	seq := f.clientSeq
+	if !fromClient {
+		seq = f.serverSeq
+	}
+
+	syn, err := f.segment(fromClient, func(p packetgen.PacketManipulator) { p.SetTCPSyn() }, seq, 0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return send(f.sender(fromClient), syn)
+}
+
+// SynAck processes the SynAck packet of a simultaneous open, that
+// acknowledges the Syn packet of the other node, on the application path of
+// its sender and returns the packet sent to the network
+func (f *Flow) SynAck(fromClient bool) ([]byte, error) {
+
+	seq, ack := f.clientSeq, f.serverSeq+1
+	if !fromClient {
+		seq, ack = f.serverSeq, f.clientSeq+1
+	}
+
+	synAck, err := f.segment(fromClient, func(p packetgen.PacketManipulator) { p.SetTCPSynAck() }, seq, ack, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return send(f.sender(fromClient), synAck)
+}
+
+// Receive processes a packet sent by the client, or by the server, on the
+// network path of the other node
+func (f *Flow) Receive(fromClient bool, buffer []byte) error {
+
+	_, err := receive(f.sender(!fromClient), buffer)
+
+	return err
+}
+
+// sender returns the client or the server node
+func (f *Flow) sender(fromClient bool) *Node {
+
+	if fromClient {
+		return f.client
+	}
+
+	return f.server
+}
+
+// Send transmits a data segment from the client to the server
+func (f *Flow) Send(data string) error {
+
+	segment, err := f.segment(true, func(p packetgen.PacketManipulator) { p.SetTCPAck() }, f.clientSeq, f.serverSeq, data)
+	if err != nil {
+		return err
+	}
+	if err := transmit(f.client, f.server, segment); err != nil {
+		return fmt.Errorf("data: %s", err)
+	}
+	f.clientSeq += uint32(len(data))
+
+	return nil
+}
+
+// segment builds a TCP segment in the client to server direction or the
+// reverse one
+func (f *Flow) segment(fromClient bool, flags func(packetgen.PacketManipulator), seq, ack uint32, data string) ([]byte, error) {
+
+	srcMAC, dstMAC := clientMAC, serverMAC
+	srcIP, dstIP := f.client.IP, f.server.IP
+	sport, dport := f.sport, f.dport
+	if !fromClient {
+		srcMAC, dstMAC = dstMAC, srcMAC
+		srcIP, dstIP = dstIP, srcIP
+		sport, dport = dport, sport
+	}
+
+	p := packetgen.NewPacket()
+	if err := p.AddEthernetLayer(srcMAC, dstMAC); err != nil {
+		return nil, fmt.Errorf("unable to build packet: %s", err)
+	}
+	if err := p.AddIPLayer(srcIP, dstIP); err != nil {
+		return nil, fmt.Errorf("unable to build packet: %s", err)
+	}
+	if err := p.AddTCPLayer(layers.TCPPort(sport), layers.TCPPort(dport)); err != nil {
+		return nil, fmt.Errorf("unable to build packet: %s", err)
+	}
+
+	flags(p)
+	p.SetTCPSequenceNumber(seq)
+	p.SetTCPAcknowledgementNumber(ack)
+
+	if data != "" {
+		if err := p.NewTCPPayload(data); err != nil {
+			return nil, fmt.Errorf("unable to build packet: %s", err)
+		}
+	}
+
+	return p.ToBytes()
+}
+
+// UDPFlow is a UDP flow between two nodes. Every datagram is processed by the
+// application path of the sender and then by the network path of the receiver.
+type UDPFlow struct {
+	client *Node
+	server *Node
+	sport  uint16
+	dport  uint16
+}
+
+// NewUDPFlow creates a new UDP flow from the client to the server
+func NewUDPFlow(client, server *Node, sport, dport uint16) *UDPFlow {
+
+	return &UDPFlow{
+		client: client,
+		server: server,
+		sport:  sport,
+		dport:  dport,
+	}
+}
+
+// Send transmits a datagram from the client to the server and returns the
+// payload delivered to the server application
+func (f *UDPFlow) Send(data string) (string, error) {
+
+	return f.transmit(true, data)
+}
+
+// Reply transmits a datagram from the server to the client and returns the
+// payload delivered to the client application
+func (f *UDPFlow) Reply(data string) (string, error) {
+
+	return f.transmit(false, data)
+}
+
+func (f *UDPFlow) transmit(fromClient bool, data string) (string, error) {
+
+	from, to := f.client, f.server
+	sport, dport := f.sport, f.dport
+	if !fromClient {
+		from, to = to, from
+		sport, dport = dport, sport
+	}
+
+	ip := &layers.IPv4{
+		SrcIP:    net.ParseIP(from.IP),
+		DstIP:    net.ParseIP(to.IP),
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+	}
+
+	udp := &layers.UDP{
+		SrcPort: layers.UDPPort(sport),
+		DstPort: layers.UDPPort(dport),
+	}
+
+	if err := udp.SetNetworkLayerForChecksum(ip); err != nil {
+		return "", fmt.Errorf("unable to build datagram: %s", err)
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opts, ip, udp, gopacket.Payload(data)); err != nil {
+		return "", fmt.Errorf("unable to build datagram: %s", err)
+	}
+
+	out, err := deliver(from, to, buffer.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	// Payload of the datagram after the IP and UDP headers
+	return string(out[28:]), nil
+}
+
+// transmit processes a segment on the application path of the sender and
+// delivers the result to the network path of the receiver
+func transmit(from, to *Node, buffer []byte) error {
+
+	_, err := deliver(from, to, buffer)
+
+	return err
+}
+
+// deliver processes a packet on the application path of the sender and on the
+// network path of the receiver and returns the packet received by the
+// application of the receiver
+func deliver(from, to *Node, buffer []byte) ([]byte, error) {
+
+	out, err := send(from, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	return receive(to, out)
+}
+
+// send processes a packet on the application path of the sender and returns
+// the packet sent to the network
+func send(from *Node, buffer []byte) ([]byte, error) {
+
+	out, err := from.Datapath.ProcessApplicationPacket(buffer, "")
+	if err != nil {
+		return nil, fmt.Errorf("dropped by %s on the application path: %s", from.ContextID, err)
+	}
+
+	return out, nil
+}
+
+// receive processes a packet on the network path of the receiver and returns
+// the packet received by its application
+func receive(to *Node, buffer []byte) ([]byte, error) {
+
+	in, err := to.Datapath.ProcessNetworkPacket(buffer, "")
+	if err != nil {
+		return nil, fmt.Errorf("dropped by %s on the network path: %s", to.ContextID, err)
+	}
+
+	return in, nil
+}
@@ -0,0 +1,196 @@
+package simulation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/netlink-go/conntrack"
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/datapath"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Conntrack records the connection marks set by a datapath instead of
+// programming the kernel
+type Conntrack struct {
+	conntrack.Conntrack
+
+	marks map[string]uint32
+	sync.Mutex
+}
+
+// NewConntrack creates a new in-memory conntrack table
+func NewConntrack() *Conntrack {
+
+	return &Conntrack{
+		marks: map[string]uint32{},
+	}
+}
+
+// ConntrackTableUpdateMark records the mark of the given flow. Like the
+// entries of the kernel, the flow is updated by the tuple of either direction.
+func (c *Conntrack) ConntrackTableUpdateMark(ipSrc, ipDst string, protonum uint8, srcport, dstport uint16, newmark uint32) error {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.marks[flowKey(ipSrc, ipDst, srcport, dstport)] = newmark
+	c.marks[flowKey(ipDst, ipSrc, dstport, srcport)] = newmark
+
+	return nil
+}
+
+// Mark returns the mark of the given flow in either direction
+func (c *Conntrack) Mark(ipSrc, ipDst string, srcport, dstport uint16) (uint32, bool) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	mark, ok := c.marks[flowKey(ipSrc, ipDst, srcport, dstport)]
+	return mark, ok
+}
+
+func flowKey(ipSrc, ipDst string, srcport, dstport uint16) string {
+	return fmt.Sprintf("%s:%s:%d:%d", ipSrc, ipDst, srcport, dstport)
+}
+
+// Collector records the flow events, packet events and connection metrics
+// reported by a datapath
+type Collector struct {
+	flows   []*collector.FlowRecord
+	packets []*collector.PacketRecord
+	metrics []*collector.ConnectionMetricsRecord
+	sync.Mutex
+}
+
+// CollectFlowEvent is part of the EventCollector interface.
+func (c *Collector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.flows = append(c.flows, record)
+}
+
+// CollectContainerEvent is part of the EventCollector interface.
+func (c *Collector) CollectContainerEvent(record *collector.ContainerRecord) {}
+
+// CollectUserEvent is part of the UserEventCollector interface.
+func (c *Collector) CollectUserEvent(record *collector.UserRecord) {}
+
+// CollectPacketEvent is part of the PacketEventCollector interface.
+func (c *Collector) CollectPacketEvent(record *collector.PacketRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.packets = append(c.packets, record)
+}
+
+// CollectQueueStats is part of the QueueStatsCollector interface.
+func (c *Collector) CollectQueueStats(record *collector.QueueStatsRecord) {}
+
+// CollectCacheStats is part of the CacheStatsCollector interface.
+func (c *Collector) CollectCacheStats(record *collector.CacheStatsRecord) {}
+
+// CollectEnforcerStats is part of the EnforcerStatsCollector interface.
+func (c *Collector) CollectEnforcerStats(record *collector.EnforcerStatsRecord) {}
+
+// CollectConnectionMetrics is part of the ConnectionMetricsCollector interface.
+func (c *Collector) CollectConnectionMetrics(record *collector.ConnectionMetricsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.metrics = append(c.metrics, record)
+}
+
+// Flows returns the flow events reported so far
+func (c *Collector) Flows() []*collector.FlowRecord {
+
+	c.Lock()
+	defer c.Unlock()
+
+	flows := make([]*collector.FlowRecord, len(c.flows))
+	copy(flows, c.flows)
+
+	return flows
+}
+
+// Packets returns the packet events reported so far
+func (c *Collector) Packets() []*collector.PacketRecord {
+
+	c.Lock()
+	defer c.Unlock()
+
+	packets := make([]*collector.PacketRecord, len(c.packets))
+	copy(packets, c.packets)
+
+	return packets
+}
+
+// Node is a processing unit with its own datapath. The datapath does not use
+// any netfilter queue and packets are injected by the simulation.
+type Node struct {
+	ContextID string
+	IP        string
+	Datapath  *datapath.Datapath
+	Conntrack *Conntrack
+	Collector *Collector
+}
+
+// NewNode creates a node for a container processing unit with the given IP and
+// policy and enforces the policy on its datapath.
+func NewNode(contextID string, ip string, s secrets.Secrets, puPolicy *policy.PUPolicy) (*Node, error) {
+
+	n := &Node{
+		ContextID: contextID,
+		IP:        ip,
+		Conntrack: NewConntrack(),
+		Collector: &Collector{},
+	}
+
+	n.Datapath = datapath.NewWithConntrack(
+		true,
+		fqconfig.NewFilterQueueWithDefaults(),
+		n.Collector,
+		contextID,
+		time.Hour,
+		nil,
+		s,
+		constants.RemoteContainer,
+		constants.DefaultProcMountPoint,
+		0,
+		false,
+		n.Conntrack,
+	)
+
+	puInfo := policy.NewPUInfo(contextID, constants.ContainerPU)
+	puInfo.Policy = puPolicy
+	puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, contextID)
+	puInfo.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: ip})
+	puInfo.Runtime.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: ip})
+
+	if err := n.Datapath.Enforce(contextID, puInfo); err != nil {
+		return nil, fmt.Errorf("unable to enforce policy on node %s: %s", contextID, err)
+	}
+
+	return n, nil
+}
+
+// ConnectionMetrics returns the connection metrics reported so far
+func (c *Collector) ConnectionMetrics() []*collector.ConnectionMetricsRecord {
+
+	c.Lock()
+	defer c.Unlock()
+
+	metrics := make([]*collector.ConnectionMetricsRecord, len(c.metrics))
+	copy(metrics, c.metrics)
+
+	return metrics
+}
@@ -0,0 +1,287 @@
+package simulation
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func acceptingPolicy(peer string) *policy.PUPolicy {
+
+	rules := policy.TagSelectorList{
+		policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{
+					Key:      enforcerconstants.TransmitterLabel,
+					Value:    []string{peer},
+					Operator: policy.Equal,
+				},
+			},
+			Policy: &policy.FlowPolicy{Action: policy.Accept},
+		},
+	}
+
+	return policy.NewPUPolicy("", policy.AllowAll, nil, nil, rules, rules, nil, nil, nil, nil, nil, nil)
+}
+
+func rejectingPolicy() *policy.PUPolicy {
+
+	return policy.NewPUPolicy("", policy.AllowAll, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+}
+
+func TestHandshake(t *testing.T) {
+
+	Convey("Given two nodes sharing the same secrets", t, func() {
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+		Convey("When the server accepts traffic from the client", func() {
+
+			client, err := NewNode("client", "10.1.10.76", secret, acceptingPolicy("server"))
+			So(err, ShouldBeNil)
+			server, err := NewNode("server", "164.67.228.152", secret, acceptingPolicy("client"))
+			So(err, ShouldBeNil)
+
+			flow := NewFlow(client, server, 666, 80)
+
+			Convey("Then the handshake and the data should go through", func() {
+				So(flow.Handshake(), ShouldBeNil)
+				So(flow.Send("Hello"), ShouldBeNil)
+
+				_, marked := client.Conntrack.Mark(client.IP, server.IP, 666, 80)
+				So(marked, ShouldBeTrue)
+				mark, marked := server.Conntrack.Mark(client.IP, server.IP, 666, 80)
+				So(marked, ShouldBeTrue)
+				So(mark, ShouldEqual, constants.DefaultConnMark)
+
+				So(len(server.Collector.Flows()), ShouldBeGreaterThan, 0)
+			})
+		})
+
+		Convey("When the server has no rule for the client", func() {
+
+			client, err := NewNode("client", "10.1.10.76", secret, acceptingPolicy("server"))
+			So(err, ShouldBeNil)
+			server, err := NewNode("server", "164.67.228.152", secret, rejectingPolicy())
+			So(err, ShouldBeNil)
+
+			flow := NewFlow(client, server, 666, 80)
+
+			Convey("Then the syn should be dropped by the server", func() {
+				err := flow.Handshake()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "dropped by server on the network path")
+
+				_, marked := server.Conntrack.Mark(client.IP, server.IP, 666, 80)
+				So(marked, ShouldBeFalse)
+
+				packets := server.Collector.Packets()
+				So(len(packets), ShouldEqual, 1)
+				So(packets[0].Reason, ShouldEqual, collector.PolicyDrop)
+				So(packets[0].RemoteID, ShouldEqual, "client")
+				So(packets[0].TCPFlags, ShouldEqual, "....S.")
+			})
+		})
+	})
+}
+
+func TestUDPHandshake(t *testing.T) {
+
+	Convey("Given two nodes sharing the same secrets", t, func() {
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+		Convey("When the server accepts traffic from the client", func() {
+
+			client, err := NewNode("client", "10.1.10.76", secret, acceptingPolicy("server"))
+			So(err, ShouldBeNil)
+			server, err := NewNode("server", "164.67.228.152", secret, acceptingPolicy("client"))
+			So(err, ShouldBeNil)
+
+			flow := NewUDPFlow(client, server, 666, 53)
+
+			Convey("Then the tokens should be removed and the flow released at both ends", func() {
+				data, err := flow.Send("query")
+				So(err, ShouldBeNil)
+				So(data, ShouldEqual, "query")
+				So(len(server.Collector.Flows()), ShouldEqual, 1)
+
+				data, err = flow.Reply("answer")
+				So(err, ShouldBeNil)
+				So(data, ShouldEqual, "answer")
+
+				_, marked := server.Conntrack.Mark(client.IP, server.IP, 666, 53)
+				So(marked, ShouldBeFalse)
+
+				data, err = flow.Send("query")
+				So(err, ShouldBeNil)
+				So(data, ShouldEqual, "query")
+
+				mark, marked := server.Conntrack.Mark(client.IP, server.IP, 666, 53)
+				So(marked, ShouldBeTrue)
+				So(mark, ShouldEqual, constants.DefaultConnMark)
+				_, marked = client.Conntrack.Mark(client.IP, server.IP, 666, 53)
+				So(marked, ShouldBeFalse)
+
+				data, err = flow.Reply("answer")
+				So(err, ShouldBeNil)
+				So(data, ShouldEqual, "answer")
+
+				_, marked = client.Conntrack.Mark(client.IP, server.IP, 666, 53)
+				So(marked, ShouldBeTrue)
+			})
+
+			Convey("Then datagrams sent before the reply should carry the same token", func() {
+				_, err := flow.Send("first")
+				So(err, ShouldBeNil)
+				data, err := flow.Send("second")
+				So(err, ShouldBeNil)
+				So(data, ShouldEqual, "second")
+				So(len(server.Collector.Flows()), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the server has no rule for the client", func() {
+
+			client, err := NewNode("client", "10.1.10.76", secret, acceptingPolicy("server"))
+			So(err, ShouldBeNil)
+			server, err := NewNode("server", "164.67.228.152", secret, rejectingPolicy())
+			So(err, ShouldBeNil)
+
+			flow := NewUDPFlow(client, server, 666, 53)
+
+			Convey("Then the datagrams should be dropped by the server", func() {
+				_, err := flow.Send("query")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "dropped by server on the network path")
+
+				_, marked := server.Conntrack.Mark(client.IP, server.IP, 666, 53)
+				So(marked, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestSimultaneousOpen(t *testing.T) {
+
+	Convey("Given two nodes that accept the traffic of each other", t, func() {
+
+		secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
+
+		client, err := NewNode("client", "10.1.10.76", secret, acceptingPolicy("server"))
+		So(err, ShouldBeNil)
+		server, err := NewNode("server", "164.67.228.152", secret, acceptingPolicy("client"))
+		So(err, ShouldBeNil)
+
+		flow := NewFlow(client, server, 666, 80)
+
+		released := func() {
+			mark, marked := client.Conntrack.Mark(server.IP, client.IP, 80, 666)
+			So(marked, ShouldBeTrue)
+			So(mark, ShouldEqual, constants.DefaultConnMark)
+			mark, marked = server.Conntrack.Mark(client.IP, server.IP, 666, 80)
+			So(marked, ShouldBeTrue)
+			So(mark, ShouldEqual, constants.DefaultConnMark)
+
+			flows := client.Collector.Flows()
+			So(len(flows), ShouldEqual, 1)
+			So(flows[0].Source.ID, ShouldEqual, "server")
+			So(flows[0].Action.Accepted(), ShouldBeTrue)
+			flows = server.Collector.Flows()
+			So(len(flows), ShouldEqual, 1)
+			So(flows[0].Source.ID, ShouldEqual, "client")
+			So(flows[0].Action.Accepted(), ShouldBeTrue)
+		}
+
+		Convey("When the Syn packets of the two nodes cross each other", func() {
+
+			err := flow.SimultaneousOpen()
+
+			Convey("Then the flow should be released at both ends without Ack packets", func() {
+				So(err, ShouldBeNil)
+				released()
+				So(flow.Send("Hello"), ShouldBeNil)
+			})
+		})
+
+		Convey("When the client retransmits its Syn packet after the crossed Syn packet", func() {
+
+			clientSyn, err := flow.Syn(true)
+			So(err, ShouldBeNil)
+			serverSyn, err := flow.Syn(false)
+			So(err, ShouldBeNil)
+			So(flow.Receive(true, clientSyn), ShouldBeNil)
+			So(flow.Receive(false, serverSyn), ShouldBeNil)
+
+			clientSyn, err = flow.Syn(true)
+			So(err, ShouldBeNil)
+			So(flow.Receive(true, clientSyn), ShouldBeNil)
+
+			clientSynAck, err := flow.SynAck(true)
+			So(err, ShouldBeNil)
+			serverSynAck, err := flow.SynAck(false)
+			So(err, ShouldBeNil)
+
+			Convey("Then the SynAck packets should complete the handshake", func() {
+				So(flow.Receive(true, clientSynAck), ShouldBeNil)
+				So(flow.Receive(false, serverSynAck), ShouldBeNil)
+				released()
+			})
+		})
+
+		Convey("When the first Syn packet of the client is lost", func() {
+
+			_, err := flow.Syn(true)
+			So(err, ShouldBeNil)
+			serverSyn, err := flow.Syn(false)
+			So(err, ShouldBeNil)
+			So(flow.Receive(false, serverSyn), ShouldBeNil)
+
+			clientSyn, err := flow.Syn(true)
+			So(err, ShouldBeNil)
+			So(flow.Receive(true, clientSyn), ShouldBeNil)
+
+			clientSynAck, err := flow.SynAck(true)
+			So(err, ShouldBeNil)
+			serverSynAck, err := flow.SynAck(false)
+			So(err, ShouldBeNil)
+
+			Convey("Then the SynAck packets should complete the handshake", func() {
+				So(flow.Receive(true, clientSynAck), ShouldBeNil)
+				So(flow.Receive(false, serverSynAck), ShouldBeNil)
+				released()
+			})
+		})
+
+		Convey("When a Syn packet is retransmitted after the SynAck packet of its receiver", func() {
+
+			clientSyn, err := flow.Syn(true)
+			So(err, ShouldBeNil)
+			serverSyn, err := flow.Syn(false)
+			So(err, ShouldBeNil)
+			So(flow.Receive(true, clientSyn), ShouldBeNil)
+			So(flow.Receive(false, serverSyn), ShouldBeNil)
+
+			clientSynAck, err := flow.SynAck(true)
+			So(err, ShouldBeNil)
+			So(flow.Receive(true, clientSynAck), ShouldBeNil)
+
+			serverSyn, err = flow.Syn(false)
+			So(err, ShouldBeNil)
+			So(flow.Receive(false, serverSyn), ShouldBeNil)
+
+			serverSynAck, err := flow.SynAck(false)
+			So(err, ShouldBeNil)
+
+			Convey("Then the SynAck packet of the sender should complete the handshake", func() {
+				So(flow.Receive(false, serverSynAck), ShouldBeNil)
+				released()
+			})
+		})
+	})
+}
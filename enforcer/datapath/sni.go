@@ -0,0 +1,77 @@
+package datapath
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/connection"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// processApplicationServerName authorizes a flow to an external service with
+// the SNI rules of the PU, based on the server name of the TLS ClientHello of
+// the first data packet of the application. The server name must be in the
+// first segment of the ClientHello. The flow is released to the kernel if a
+// rule accepts it, and its packets are dropped otherwise. The verdict is not
+// cached for the address of the service, since other names may resolve to it.
+func (d *Datapath) processApplicationServerName(tcpPacket *packet.Packet, context *pucontext.PUContext, conn *connection.TCPConnection) (interface{}, error) {
+
+	// The Ack of the handshake has no payload
+	if tcpPacket.IsEmptyTCPPayload() {
+		return nil, nil
+	}
+
+	serverName, err := packet.TLSServerName(tcpPacket.ReadTCPData())
+	if err != nil {
+		zap.L().Debug("Unable to read the server name of the flow",
+			zap.String("flow", tcpPacket.L4FlowHash()),
+			zap.Error(err),
+		)
+	}
+
+	report, action, err := context.SNIPolicy(tcpPacket.DestinationPort, serverName)
+	if err != nil || action.Action.Rejected() {
+		d.reportExternalServiceFlow(context, report, action, true, tcpPacket)
+		return nil, fmt.Errorf("no sni rule accepts the server name %s: action=%d", serverName, action.Action)
+	}
+
+	conn.SetState(connection.TCPData)
+
+	d.releaseServerNameFlow(context, report, action, tcpPacket)
+
+	return action, nil
+}
+
+// releaseServerNameFlow releases a flow authorized by its server name to the
+// kernel. The packet is the first data packet of the application.
+func (d *Datapath) releaseServerNameFlow(context *pucontext.PUContext, report *policy.FlowPolicy, action *policy.FlowPolicy, tcpPacket *packet.Packet) {
+
+	if err := d.appOrigConnectionTracker.Remove(tcpPacket.L4FlowHash()); err != nil {
+		zap.L().Debug("Failed to clean cache appOrigConnectionTracker", zap.Error(err))
+	}
+
+	if err := d.netReplyConnectionTracker.Remove(tcpPacket.L4ReverseFlowHash()); err != nil {
+		zap.L().Debug("Failed to clean cache netReplyConnectionTracker", zap.Error(err))
+	}
+
+	if err := d.sourcePortConnectionCache.Remove(tcpPacket.SourcePortHash(packet.PacketTypeApplication)); err != nil {
+		zap.L().Debug("Failed to clean cache sourcePortConnectionCache", zap.Error(err))
+	}
+
+	if err := d.conntrackHdl.ConntrackTableUpdateMark(
+		tcpPacket.SourceAddress.String(),
+		tcpPacket.DestinationAddress.String(),
+		tcpPacket.IPProto,
+		tcpPacket.SourcePort,
+		tcpPacket.DestinationPort,
+		constants.DefaultConnMark,
+	); err != nil {
+		zap.L().Error("Failed to update conntrack table", zap.Error(err))
+	}
+
+	d.reportExternalServiceFlow(context, report, action, true, tcpPacket)
+}
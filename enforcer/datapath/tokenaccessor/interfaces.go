@@ -12,6 +12,7 @@ import (
 // TokenAccessor define an interface to access LockedTokenEngine
 type TokenAccessor interface {
 	SetToken(serverID string, validity time.Duration, secret secrets.Secrets) error
+	SetTokenFormat(format tokens.Format) error
 	GetTokenValidity() time.Duration
 	GetTokenServerID() string
 
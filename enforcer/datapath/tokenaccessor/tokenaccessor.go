@@ -19,6 +19,8 @@ type tokenAccessor struct {
 	tokens   tokens.TokenEngine
 	serverID string
 	validity time.Duration
+	format   tokens.Format
+	secret   secrets.Secrets
 }
 
 // New creates a new instance of TokenAccessor interface
@@ -33,6 +35,7 @@ func New(serverID string, validity time.Duration, secret secrets.Secrets) (Token
 		tokens:   tokenEngine,
 		serverID: serverID,
 		validity: validity,
+		secret:   secret,
 	}, nil
 }
 
@@ -49,11 +52,27 @@ func (t *tokenAccessor) SetToken(serverID string, validity time.Duration, secret
 
 	t.Lock()
 	defer t.Unlock()
-	tokenEngine, err := tokens.NewJWT(validity, serverID, secret)
+	tokenEngine, err := tokens.New(t.format, validity, serverID, secret)
 	if err != nil {
 		return err
 	}
 	t.tokens = tokenEngine
+	t.secret = secret
+	return nil
+}
+
+// SetTokenFormat changes the format of the tokens of the Syn and SynAck packets.
+// The tokens of both formats are always accepted.
+func (t *tokenAccessor) SetTokenFormat(format tokens.Format) error {
+
+	t.Lock()
+	defer t.Unlock()
+	tokenEngine, err := tokens.New(format, t.validity, t.serverID, t.secret)
+	if err != nil {
+		return err
+	}
+	t.tokens = tokenEngine
+	t.format = format
 	return nil
 }
 
@@ -86,6 +105,23 @@ func (t *tokenAccessor) CreateAckPacketToken(context *pucontext.PUContext, auth
 // createSynPacketToken creates the authentication token
 func (t *tokenAccessor) CreateSynPacketToken(context *pucontext.PUContext, auth *connection.AuthInfo) (token []byte, err error) {
 
+	// Tokens with a user token are never cached since they are only sent by
+	// the proxy of user PUs
+	if auth.LocalUserToken != "" {
+		claims := &tokens.ConnectionClaims{
+			T:  context.Identity(),
+			EK: auth.LocalServiceContext,
+			UT: auth.LocalUserToken,
+			CC: context.IdentityClaims(),
+		}
+
+		if token, auth.LocalContext, err = t.getToken().CreateAndSign(false, claims); err != nil {
+			return []byte{}, err
+		}
+
+		return token, nil
+	}
+
 	token, serviceContext, err := context.GetCachedTokenAndServiceContext()
 
 	if err == nil && bytes.Equal(auth.LocalServiceContext, serviceContext) {
@@ -100,6 +136,7 @@ func (t *tokenAccessor) CreateSynPacketToken(context *pucontext.PUContext, auth
 	claims := &tokens.ConnectionClaims{
 		T:  context.Identity(),
 		EK: auth.LocalServiceContext,
+		CC: context.IdentityClaims(),
 	}
 
 	if token, auth.LocalContext, err = t.getToken().CreateAndSign(false, claims); err != nil {
@@ -119,6 +156,7 @@ func (t *tokenAccessor) CreateSynAckPacketToken(context *pucontext.PUContext, au
 		T:   context.Identity(),
 		RMT: auth.RemoteContext,
 		EK:  auth.LocalServiceContext,
+		CC:  context.IdentityClaims(),
 	}
 
 	if token, auth.LocalContext, err = t.getToken().CreateAndSign(false, claims); err != nil {
@@ -144,10 +182,18 @@ func (t *tokenAccessor) ParsePacketToken(auth *connection.AuthInfo, data []byte)
 		return nil, errors.New("no transmitter label")
 	}
 
+	// The key is kept to verify the peer again when the secrets are rotated
+	if txKey, err := tokens.TransmittedKey(data); err == nil {
+		auth.RemoteTransmittedKey = txKey
+	}
+
 	auth.RemotePublicKey = cert
 	auth.RemoteContext = nonce
 	auth.RemoteContextID = remoteContextID
 	auth.RemoteServiceContext = claims.EK
+	auth.RemoteUserToken = claims.UT
+	auth.RemoteClaims = claims.CC
+	auth.RemoteTags = claims.T
 
 	return claims, nil
 }
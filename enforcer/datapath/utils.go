@@ -15,6 +15,13 @@ func (d *Datapath) reportAcceptedFlow(p *packet.Packet, conn *connection.TCPConn
 	d.reportFlow(p, conn, sourceID, destID, context, "", report, packet)
 }
 
+func (d *Datapath) reportReverseAcceptedFlow(p *packet.Packet, conn *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+	if conn != nil {
+		conn.SetReported(connection.AcceptReported)
+	}
+	d.reportReverseFlow(p, conn, sourceID, destID, context, "", report, packet)
+}
+
 func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *connection.TCPConnection, sourceID string, destID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
 	if conn != nil && mode == collector.PolicyDrop {
 		conn.SetReported(connection.RejectReported)
@@ -30,6 +37,52 @@ func (d *Datapath) reportRejectedFlow(p *packet.Packet, conn *connection.TCPConn
 		packet = report
 	}
 	d.reportFlow(p, conn, sourceID, destID, context, mode, report, packet)
+
+	remoteID := sourceID
+	if remoteID == context.ManagementID() {
+		remoteID = destID
+	}
+	d.reportDroppedPacket(p, context.ID(), mode, remoteID, report)
+}
+
+// reportDroppedPacket reports the diagnostics of a packet dropped by the
+// datapath. The context and the remote identity are empty when they are not
+// known. The packets are only reported to the collectors of the packet events.
+func (d *Datapath) reportDroppedPacket(p *packet.Packet, contextID string, reason string, remoteID string, report *policy.FlowPolicy) {
+
+	packetCollector, ok := d.collector.(collector.PacketEventCollector)
+	if !ok {
+		return
+	}
+
+	if remoteID == collector.DefaultEndPoint {
+		remoteID = ""
+	}
+
+	r := &collector.PacketRecord{
+		ContextID: contextID,
+		Source: &collector.EndPoint{
+			IP:   p.SourceAddress.String(),
+			Port: p.SourcePort,
+		},
+		Destination: &collector.EndPoint{
+			IP:   p.DestinationAddress.String(),
+			Port: p.DestinationPort,
+		},
+		Protocol: p.IPProto,
+		Reason:   reason,
+		RemoteID: remoteID,
+	}
+
+	if p.IPProto == packet.IPProtocolTCP {
+		r.TCPFlags = packet.TCPFlagsToStr(p.TCPFlags)
+	}
+
+	if report != nil {
+		r.PolicyID = report.PolicyID
+	}
+
+	packetCollector.CollectPacketEvent(r)
 }
 
 func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet, src, dst *collector.EndPoint) {
@@ -37,6 +90,7 @@ func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext,
 	if app {
 		src.ID = context.ManagementID()
 		src.Type = collector.PU
+		src.Tags = context.Annotations()
 		dst.ID = report.ServiceID
 		dst.Type = collector.Address
 	} else {
@@ -44,6 +98,7 @@ func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext,
 		src.Type = collector.Address
 		dst.ID = context.ManagementID()
 		dst.Type = collector.PU
+		dst.Tags = context.Annotations()
 	}
 
 	record := &collector.FlowRecord{
@@ -54,6 +109,7 @@ func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext,
 		Action:      report.Action,
 		Tags:        context.Annotations(),
 		PolicyID:    report.PolicyID,
+		ServiceID:   report.ServiceID,
 	}
 
 	if report.ObserveAction.Observed() {
@@ -62,6 +118,8 @@ func (d *Datapath) reportExternalServiceFlowCommon(context *pucontext.PUContext,
 	}
 
 	d.collector.CollectFlowEvent(record)
+
+	d.trackFlowAccounting(record)
 }
 
 func (d *Datapath) reportExternalServiceFlow(context *pucontext.PUContext, report *policy.FlowPolicy, packet *policy.FlowPolicy, app bool, p *packet.Packet) {
@@ -93,3 +151,26 @@ func (d *Datapath) reportReverseExternalServiceFlow(context *pucontext.PUContext
 
 	d.reportExternalServiceFlowCommon(context, report, packet, app, p, src, dst)
 }
+
+// handshakeLatency returns the latency of the handshake of a connection, or
+// nil if it was not authorized with tokens. The latencies of the packets that
+// were not seen yet are zero.
+func handshakeLatency(conn *connection.TCPConnection) *collector.FlowLatency {
+
+	if conn.HandshakeStart.IsZero() {
+		return nil
+	}
+
+	latency := &collector.FlowLatency{
+		Authorization: conn.AuthorizationLatency,
+	}
+
+	if !conn.HandshakeEnd.IsZero() {
+		latency.Handshake = conn.HandshakeEnd.Sub(conn.HandshakeStart)
+		if !conn.HandshakeReply.IsZero() {
+			latency.RoundTrip = conn.HandshakeEnd.Sub(conn.HandshakeReply)
+		}
+	}
+
+	return latency
+}
@@ -0,0 +1,275 @@
+package envoyauthz
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/enforcer/connection"
+	"github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/datapath/tokenaccessor"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+const (
+	// IdentityHeader is the header of the identity token of the PU that sent
+	// a request. Its value is the base64 encoded token of a Syn packet.
+	IdentityHeader = "X-Trireme-Identity"
+
+	// PortHeader is the header of the port of the service that received a
+	// request. It is added by the Envoy of the service to its authorization
+	// requests.
+	PortHeader = "X-Trireme-Port"
+
+	// PeerHeader is the header of the context ID of the PU that sent an
+	// authorized request
+	PeerHeader = "X-Trireme-Peer"
+
+	// IngressPrefix is the path prefix of the authorization requests of the
+	// requests received by a PU. It is followed by the context ID of the PU.
+	IngressPrefix = "/ingress/"
+
+	// EgressPrefix is the path prefix of the authorization requests of the
+	// requests sent by a PU. It is followed by the context ID of the PU.
+	EgressPrefix = "/egress/"
+
+	forwardedForHeader = "X-Forwarded-For"
+	bearerPrefix       = "Bearer "
+)
+
+// requestFlow holds the addresses of a request that are known by Envoy
+type requestFlow struct {
+	SourceIP string
+	DestPort uint16
+}
+
+// Authorizer is the HTTP service of the ext_authz filter of Envoy. It lets
+// Envoy sidecars enforce the policies of the PUs of the enforcer:
+//   - The Envoy of a client PU sends its requests to EgressPrefix followed by
+//     the context ID of the PU, and forwards the IdentityHeader of the response
+//     to the service.
+//   - The Envoy of a service PU sends its requests to IngressPrefix followed
+//     by the context ID of the PU, with the PortHeader of the service. The
+//     request is allowed if the identity of the client is valid, the
+//     receiver rules of the PU accept it and the HTTP rules of the PU, if
+//     any, authorize its method, path and host.
+//
+// The identity token is a bearer credential: the traffic between the sidecars
+// must be protected by TLS. The Encrypt action of the rules is not enforced.
+type Authorizer struct {
+	tokenaccessor   tokenaccessor.TokenAccessor
+	collector       collector.EventCollector
+	puFromContextID cache.DataStore
+}
+
+// NewAuthorizer creates the authorizer of the PUs of an enforcer
+func NewAuthorizer(t tokenaccessor.TokenAccessor, c collector.EventCollector, puFromContextID cache.DataStore) *Authorizer {
+
+	return &Authorizer{
+		tokenaccessor:   t,
+		collector:       c,
+		puFromContextID: puFromContextID,
+	}
+}
+
+// ServeHTTP implements http.Handler. Any other status than http.StatusOK
+// denies the request.
+func (a *Authorizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	var prefix string
+	switch {
+	case strings.HasPrefix(r.URL.Path, IngressPrefix):
+		prefix = IngressPrefix
+	case strings.HasPrefix(r.URL.Path, EgressPrefix):
+		prefix = EgressPrefix
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	// Envoy appends the path of the original request
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)
+	contextID := parts[0]
+	path := "/"
+	if len(parts) == 2 {
+		path += parts[1]
+	}
+
+	item, err := a.puFromContextID.Get(contextID)
+	if err != nil {
+		zap.L().Debug("Authorization request for unknown PU", zap.String("contextID", contextID))
+		http.Error(w, "unknown processing unit", http.StatusForbidden)
+		return
+	}
+	puContext := item.(*pucontext.PUContext)
+
+	if prefix == EgressPrefix {
+		a.authorizeEgress(w, puContext)
+		return
+	}
+
+	a.authorizeIngress(w, r, path, puContext)
+}
+
+// authorizeEgress returns the identity token of the PU
+func (a *Authorizer) authorizeEgress(w http.ResponseWriter, puContext *pucontext.PUContext) {
+
+	auth := &connection.AuthInfo{
+		LocalUserToken: puContext.UserToken(),
+	}
+
+	token, err := a.tokenaccessor.CreateSynPacketToken(puContext, auth)
+	if err != nil || len(token) == 0 {
+		zap.L().Error("Unable to create identity token", zap.String("contextID", puContext.ID()), zap.Error(err))
+		http.Error(w, "unable to create identity token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(IdentityHeader, base64.StdEncoding.EncodeToString(token))
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorizeIngress verifies the identity token of the client and applies the
+// receiver rules and the HTTP rules of the PU to the original request
+func (a *Authorizer) authorizeIngress(w http.ResponseWriter, r *http.Request, path string, puContext *pucontext.PUContext) {
+
+	port := r.Header.Get(PortHeader)
+	flow := newRequestFlow(r, port)
+	auth := &connection.AuthInfo{}
+
+	// Only the proxied services of the PU are authorized if it has any
+	if services := puContext.ProxiedServices(); !services.IsEmpty() && !services.HasTCPService(port) {
+		a.reportRejectedFlow(flow, auth, collector.DefaultEndPoint, puContext, collector.InvalidContext, nil, nil)
+		http.Error(w, "service is not proxied", http.StatusForbidden)
+		return
+	}
+
+	encoded := r.Header.Get(IdentityHeader)
+	if encoded == "" {
+		a.reportRejectedFlow(flow, auth, collector.DefaultEndPoint, puContext, collector.MissingToken, nil, nil)
+		http.Error(w, "missing identity", http.StatusForbidden)
+		return
+	}
+
+	token, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		a.reportRejectedFlow(flow, auth, collector.DefaultEndPoint, puContext, collector.InvalidFormat, nil, nil)
+		http.Error(w, "invalid identity", http.StatusForbidden)
+		return
+	}
+
+	claims, err := a.tokenaccessor.ParsePacketToken(auth, token)
+	if err != nil || claims == nil {
+		a.reportRejectedFlow(flow, auth, collector.DefaultEndPoint, puContext, collector.InvalidToken, nil, nil)
+		http.Error(w, "invalid identity", http.StatusForbidden)
+		return
+	}
+
+	if verifier := puContext.UserVerifier(); verifier != nil {
+		// The user token of the request takes precedence over the one of
+		// the identity of the client
+		userToken := auth.RemoteUserToken
+		if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, bearerPrefix) {
+			userToken = strings.TrimPrefix(bearer, bearerPrefix)
+		}
+
+		tags, err := verifier.Authorize(userToken, claims.T)
+		if err != nil {
+			a.reportRejectedFlow(flow, auth, auth.RemoteContextID, puContext, collector.InvalidUserToken, nil, nil)
+			http.Error(w, "user authorization failed", http.StatusForbidden)
+			return
+		}
+		claims.T = tags
+	}
+
+	if port != "" {
+		claims.T.AppendKeyValue(enforcerconstants.PortNumberLabelString, port)
+	}
+
+	report, packet := puContext.SearchRcvRules(claims.T)
+	if packet.Action.Rejected() {
+		a.reportRejectedFlow(flow, auth, auth.RemoteContextID, puContext, collector.PolicyDrop, report, packet)
+		http.Error(w, "rejected by policy", http.StatusForbidden)
+		return
+	}
+
+	if puContext.HasHTTPRules() && puContext.SearchHTTPRules(claims.T).Search(r.Method, path, r.Host) == nil {
+		a.reportRejectedFlow(flow, auth, auth.RemoteContextID, puContext, collector.PolicyDrop, nil, nil)
+		http.Error(w, "rejected by http rules", http.StatusForbidden)
+		return
+	}
+
+	a.reportFlow(flow, auth, auth.RemoteContextID, puContext, "N/A", report, packet)
+
+	w.Header().Set(PeerHeader, auth.RemoteContextID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// newRequestFlow returns the flow of a request. The address of the client is
+// the first one of the forwarded addresses.
+func newRequestFlow(r *http.Request, port string) *requestFlow {
+
+	flow := &requestFlow{}
+
+	if forwarded := r.Header.Get(forwardedForHeader); forwarded != "" {
+		flow.SourceIP = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+
+	if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+		flow.DestPort = uint16(p)
+	}
+
+	return flow
+}
+
+func (a *Authorizer) reportFlow(flow *requestFlow, auth *connection.AuthInfo, sourceID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+
+	c := &collector.FlowRecord{
+		ContextID: context.ID(),
+		Source: &collector.EndPoint{
+			ID:   sourceID,
+			IP:   flow.SourceIP,
+			Type: collector.PU,
+			Tags: auth.RemoteTags,
+		},
+		Destination: &collector.EndPoint{
+			ID:   context.ManagementID(),
+			Port: flow.DestPort,
+			Type: collector.PU,
+			Tags: context.Annotations(),
+		},
+		Tags:       context.Annotations(),
+		Action:     report.Action,
+		DropReason: mode,
+		PolicyID:   report.PolicyID,
+		ServiceID:  report.ServiceID,
+		PeerClaims: auth.RemoteClaims,
+	}
+
+	if report.ObserveAction.Observed() {
+		c.ObservedAction = packet.Action
+		c.ObservedPolicyID = packet.PolicyID
+	}
+
+	a.collector.CollectFlowEvent(c)
+}
+
+func (a *Authorizer) reportRejectedFlow(flow *requestFlow, auth *connection.AuthInfo, sourceID string, context *pucontext.PUContext, mode string, report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+
+	if report == nil {
+		report = &policy.FlowPolicy{
+			Action:   policy.Reject,
+			PolicyID: "",
+		}
+	}
+	if packet == nil {
+		packet = report
+	}
+	a.reportFlow(flow, auth, sourceID, context, mode, report, packet)
+}
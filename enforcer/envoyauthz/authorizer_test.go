@@ -0,0 +1,202 @@
+package envoyauthz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/datapath/tokenaccessor"
+	"github.com/aporeto-inc/trireme-lib/enforcer/pucontext"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cache"
+)
+
+// testCollector keeps the flow records
+type testCollector struct {
+	collector.DefaultCollector
+	flows []*collector.FlowRecord
+}
+
+func (c *testCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.flows = append(c.flows, record)
+}
+
+func newTestPU(contextID string, app string, puInfo *policy.PUInfo) *pucontext.PUContext {
+
+	puInfo.Policy.AddIdentityTag(enforcerconstants.TransmitterLabel, contextID)
+	puInfo.Policy.AddIdentityTag("app", app)
+
+	pu, _ := pucontext.NewPU(contextID, puInfo, time.Second) // nolint
+
+	return pu
+}
+
+func acceptRule(app string) policy.TagSelector {
+	return policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      "app",
+				Value:    []string{app},
+				Operator: policy.Equal,
+			},
+		},
+		Policy: &policy.FlowPolicy{
+			Action:   policy.Accept,
+			PolicyID: "accept-" + app,
+		},
+	}
+}
+
+func request(authorizer *Authorizer, path string, header http.Header) *httptest.ResponseRecorder {
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+
+	w := httptest.NewRecorder()
+	authorizer.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestAuthorizer(t *testing.T) {
+
+	Convey("Given an authorizer with a client PU and a server PU that accepts the client", t, func() {
+
+		accessor, err := tokenaccessor.New("server", time.Hour, secrets.NewPSKSecrets([]byte("Dummy Test Password")))
+		So(err, ShouldBeNil)
+
+		flows := &testCollector{}
+		puFromContextID := cache.NewCache("pu")
+
+		client := newTestPU("client", "client", policy.NewPUInfo("client", constants.ContainerPU))
+
+		serverInfo := policy.NewPUInfo("server", constants.ContainerPU)
+		serverInfo.Policy.AddReceiverRules(acceptRule("client"))
+		server := newTestPU("server", "server", serverInfo)
+
+		puFromContextID.AddOrUpdate("client", client)
+		puFromContextID.AddOrUpdate("server", server)
+
+		authorizer := NewAuthorizer(accessor, flows, puFromContextID)
+
+		Convey("When the client gets its identity and the server receives it", func() {
+			egress := request(authorizer, EgressPrefix+"client/api", nil)
+			So(egress.Code, ShouldEqual, http.StatusOK)
+
+			identity := egress.Header().Get(IdentityHeader)
+			So(identity, ShouldNotBeEmpty)
+
+			ingress := request(authorizer, IngressPrefix+"server/api", http.Header{
+				IdentityHeader:     []string{identity},
+				PortHeader:         []string{"80"},
+				forwardedForHeader: []string{"10.0.0.1, 10.0.0.2"},
+			})
+
+			Convey("Then the request should be allowed and reported", func() {
+				So(ingress.Code, ShouldEqual, http.StatusOK)
+				So(ingress.Header().Get(PeerHeader), ShouldEqual, "client")
+				So(len(flows.flows), ShouldEqual, 1)
+				So(flows.flows[0].Action.Accepted(), ShouldBeTrue)
+				So(flows.flows[0].PolicyID, ShouldEqual, "accept-client")
+				So(flows.flows[0].Source.ID, ShouldEqual, "client")
+				So(flows.flows[0].Source.IP, ShouldEqual, "10.0.0.1")
+				So(flows.flows[0].Destination.Port, ShouldEqual, 80)
+			})
+		})
+
+		Convey("When the server receives the identity of a PU that it does not accept", func() {
+			egress := request(authorizer, EgressPrefix+"server/api", nil)
+			So(egress.Code, ShouldEqual, http.StatusOK)
+
+			ingress := request(authorizer, IngressPrefix+"server/api", http.Header{
+				IdentityHeader: []string{egress.Header().Get(IdentityHeader)},
+			})
+
+			Convey("Then the request should be denied by the policy", func() {
+				So(ingress.Code, ShouldEqual, http.StatusForbidden)
+				So(len(flows.flows), ShouldEqual, 1)
+				So(flows.flows[0].DropReason, ShouldEqual, collector.PolicyDrop)
+			})
+		})
+
+		Convey("When the server receives a request without a valid identity", func() {
+			missing := request(authorizer, IngressPrefix+"server/api", nil)
+			invalid := request(authorizer, IngressPrefix+"server/api", http.Header{
+				IdentityHeader: []string{"aW52YWxpZA=="},
+			})
+
+			Convey("Then the requests should be denied", func() {
+				So(missing.Code, ShouldEqual, http.StatusForbidden)
+				So(invalid.Code, ShouldEqual, http.StatusForbidden)
+				So(len(flows.flows), ShouldEqual, 2)
+				So(flows.flows[0].DropReason, ShouldEqual, collector.MissingToken)
+				So(flows.flows[1].DropReason, ShouldEqual, collector.InvalidToken)
+			})
+		})
+
+		Convey("When the server has proxied services", func() {
+			services := &policy.ProxiedServicesInfo{}
+			services.AddPrivateIPPortPair("172.17.0.2,8080")
+			serverInfo.Policy = policy.NewPUPolicy("", policy.AllowAll, nil, nil, nil, policy.TagSelectorList{acceptRule("client")}, nil, nil, nil, []string{}, []string{}, services)
+			puFromContextID.AddOrUpdate("server", newTestPU("server", "server", serverInfo))
+
+			identity := request(authorizer, EgressPrefix+"client/api", nil).Header().Get(IdentityHeader)
+
+			Convey("Then only the requests of the proxied services should be allowed", func() {
+				other := request(authorizer, IngressPrefix+"server/api", http.Header{
+					IdentityHeader: []string{identity},
+					PortHeader:     []string{"80"},
+				})
+				So(other.Code, ShouldEqual, http.StatusForbidden)
+
+				proxied := request(authorizer, IngressPrefix+"server/api", http.Header{
+					IdentityHeader: []string{identity},
+					PortHeader:     []string{"8080"},
+				})
+				So(proxied.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When the server has HTTP rules", func() {
+			err := serverInfo.Policy.SetHTTPRules(policy.HTTPRuleList{
+				policy.HTTPRule{Methods: []string{"GET"}, Paths: []string{"/api/*"}},
+			})
+			So(err, ShouldBeNil)
+			puFromContextID.AddOrUpdate("server", newTestPU("server", "server", serverInfo))
+
+			identity := request(authorizer, EgressPrefix+"client/api", nil).Header().Get(IdentityHeader)
+
+			Convey("Then only the requests authorized by the rules should be allowed", func() {
+				allowed := request(authorizer, IngressPrefix+"server/api/orders", http.Header{
+					IdentityHeader: []string{identity},
+				})
+				So(allowed.Code, ShouldEqual, http.StatusOK)
+
+				denied := request(authorizer, IngressPrefix+"server/admin", http.Header{
+					IdentityHeader: []string{identity},
+				})
+				So(denied.Code, ShouldEqual, http.StatusForbidden)
+				So(flows.flows[1].DropReason, ShouldEqual, collector.PolicyDrop)
+			})
+		})
+
+		Convey("When the request is for an unknown PU or path", func() {
+			unknown := request(authorizer, EgressPrefix+"unknown/api", nil)
+			invalid := request(authorizer, "/api", nil)
+
+			Convey("Then the requests should be denied", func() {
+				So(unknown.Code, ShouldEqual, http.StatusForbidden)
+				So(invalid.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
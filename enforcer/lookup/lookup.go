@@ -3,6 +3,7 @@ package lookup
 import (
 	"fmt"
 	"sort"
+	"strings"
 
 	"go.uber.org/zap"
 
@@ -23,24 +24,28 @@ type intList []int
 //PolicyDB is the structure of a policy
 type PolicyDB struct {
 	// rules    []policy
-	numberOfPolicies       int
-	equalPrefixes          map[string]intList
-	equalMapTable          map[string]map[string][]*ForwardingPolicy
-	notEqualMapTable       map[string]map[string][]*ForwardingPolicy
-	notStarTable           map[string][]*ForwardingPolicy
-	defaultNotExistsPolicy *ForwardingPolicy
+	numberOfPolicies int
+	equalPrefixes    map[string]intList
+	equalMapTable    map[string]map[string][]*ForwardingPolicy
+	notEqualMapTable map[string]map[string][]*ForwardingPolicy
+	notStarTable     map[string][]*ForwardingPolicy
+	notPrefixes      map[string]intList
+	notMapTable      map[string]map[string][]*ForwardingPolicy
+	negativePolicies []*ForwardingPolicy
 }
 
 //NewPolicyDB creates a new PolicyDB for efficient search of policies
 func NewPolicyDB() (m *PolicyDB) {
 
 	m = &PolicyDB{
-		numberOfPolicies:       0,
-		equalMapTable:          map[string]map[string][]*ForwardingPolicy{},
-		equalPrefixes:          map[string]intList{},
-		notEqualMapTable:       map[string]map[string][]*ForwardingPolicy{},
-		notStarTable:           map[string][]*ForwardingPolicy{},
-		defaultNotExistsPolicy: nil,
+		numberOfPolicies: 0,
+		equalMapTable:    map[string]map[string][]*ForwardingPolicy{},
+		equalPrefixes:    map[string]intList{},
+		notEqualMapTable: map[string]map[string][]*ForwardingPolicy{},
+		notStarTable:     map[string][]*ForwardingPolicy{},
+		notPrefixes:      map[string]intList{},
+		notMapTable:      map[string]map[string][]*ForwardingPolicy{},
+		negativePolicies: []*ForwardingPolicy{},
 	}
 
 	return m
@@ -57,6 +62,10 @@ func (array intList) sortedInsert(value int) intList {
 		return array[i] <= value
 	})
 
+	if i < l && array[i] == value { // the value is already in the list
+		return array
+	}
+
 	if i == 0 { // new value is the largest
 		array = append([]int{value}, array...)
 		return array
@@ -99,24 +108,31 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
 		case policy.KeyNotExists:
 			m.notStarTable[keyValueOp.Key] = append(m.notStarTable[keyValueOp.Key], &e)
-			if len(selector.Clause) == 1 {
-				m.defaultNotExistsPolicy = &e
-			}
 
-		case policy.Equal:
-			if _, ok := m.equalMapTable[keyValueOp.Key]; !ok {
-				m.equalMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
+		case policy.Not:
+			// The policy is disabled by any tag with one of the values
+			if _, ok := m.notMapTable[keyValueOp.Key]; !ok {
+				m.notMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
 			}
 			for _, v := range keyValueOp.Value {
-				if end := len(v) - 1; v[end] == '*' {
-					m.equalPrefixes[keyValueOp.Key] = m.equalPrefixes[keyValueOp.Key].sortedInsert(end)
-					m.equalMapTable[keyValueOp.Key][v[:end]] = append(m.equalMapTable[keyValueOp.Key][v[:end]], &e)
-				} else {
-					m.equalMapTable[keyValueOp.Key][v] = append(m.equalMapTable[keyValueOp.Key][v], &e)
+				if end := len(v) - 1; end >= 0 && v[end] == '*' {
+					m.notPrefixes[keyValueOp.Key] = m.notPrefixes[keyValueOp.Key].sortedInsert(end)
+					v = v[:end]
 				}
+				m.notMapTable[keyValueOp.Key][v] = append(m.notMapTable[keyValueOp.Key][v], &e)
 			}
+
+		case policy.Equal, policy.AnyOf:
+			m.addEqual(keyValueOp.Key, keyValueOp.Value, &e)
 			e.count++
 
+		case policy.AllOf:
+			// Every value is matched as a separate equal clause
+			for _, v := range keyValueOp.Value {
+				m.addEqual(keyValueOp.Key, []string{v}, &e)
+				e.count++
+			}
+
 		default: // policy.NotEqual
 			if _, ok := m.notEqualMapTable[keyValueOp.Key]; !ok {
 				m.notEqualMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
@@ -128,6 +144,12 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 		}
 	}
 
+	// The policies with only negative clauses are not hit by any tag. They
+	// match if none of their clauses disabled them.
+	if e.count == 0 {
+		m.negativePolicies = append(m.negativePolicies, &e)
+	}
+
 	// Increase the number of policies
 	m.numberOfPolicies++
 
@@ -139,6 +161,24 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
 }
 
+// addEqual associates a policy with the values of a key. The values ending
+// with a * match all the values with that prefix.
+func (m *PolicyDB) addEqual(key string, values []string, e *ForwardingPolicy) {
+
+	if _, ok := m.equalMapTable[key]; !ok {
+		m.equalMapTable[key] = map[string][]*ForwardingPolicy{}
+	}
+
+	for _, v := range values {
+		if end := len(v) - 1; v[end] == '*' {
+			m.equalPrefixes[key] = m.equalPrefixes[key].sortedInsert(end)
+			m.equalMapTable[key][v[:end]] = append(m.equalMapTable[key][v[:end]], e)
+		} else {
+			m.equalMapTable[key][v] = append(m.equalMapTable[key][v], e)
+		}
+	}
+}
+
 // Custom implementation for splitting strings. Gives significant performance
 // improvement. Do not allocate new strings
 func (m *PolicyDB) tagSplit(str string, k *string, v *string) error {
@@ -174,24 +214,35 @@ func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 		for _, policy := range m.notStarTable[k] {
 			skip[policy.index] = true
 		}
+
+		// Disable all policies that fail the not operator
+		for _, policy := range m.notMapTable[k][v] {
+			skip[policy.index] = true
+		}
+		for _, i := range m.notPrefixes[k] {
+			if i <= len(v) {
+				for _, policy := range m.notMapTable[k][v[:i]] {
+					skip[policy.index] = true
+				}
+			}
+		}
 	}
 
-	// Go through the list of tags
+	// Go through the list of tags. The match is the first policy added among
+	// the ones that match, whatever the order of the tags.
+	var match *ForwardingPolicy
 	for _, t := range tags.GetSlice() {
 		if err := m.tagSplit(t, &k, &v); err != nil {
 			continue
 		}
 		// Search for matches of k=v
-		if index, action := searchInMapTabe(m.equalMapTable[k][v], count, skip); index >= 0 {
-			return index, action
-		}
+		match = searchInMapTabe(m.equalMapTable[k][v], count, skip, match)
 
-		// Search for matches in prefixes
+		// Search for matches in prefixes. The prefix of the length of the value
+		// is the value itself, that was already searched.
 		for _, i := range m.equalPrefixes[k] {
-			if i <= len(v) {
-				if index, action := searchInMapTabe(m.equalMapTable[k][v[:i]], count, skip); index >= 0 {
-					return index, action
-				}
+			if i < len(v) {
+				match = searchInMapTabe(m.equalMapTable[k][v[:i]], count, skip, match)
 			}
 		}
 
@@ -202,20 +253,32 @@ func (m *PolicyDB) Search(tags *policy.TagStore) (int, interface{}) {
 				continue
 			}
 
-			if index, action := searchInMapTabe(policies, count, skip); index >= 0 {
-				return index, action
+			if end := len(value) - 1; end >= 0 && value[end] == '*' && strings.HasPrefix(v, value[:end]) {
+				continue
 			}
+
+			match = searchInMapTabe(policies, count, skip, match)
+		}
+	}
+
+	for _, policy := range m.negativePolicies {
+		if !skip[policy.index] && (match == nil || policy.index < match.index) {
+			match = policy
+			break
 		}
 	}
 
-	if m.defaultNotExistsPolicy != nil && !skip[m.defaultNotExistsPolicy.index] {
-		return m.defaultNotExistsPolicy.index, m.defaultNotExistsPolicy.actions
+	if match == nil {
+		return -1, nil
 	}
 
-	return -1, nil
+	return match.index, match.actions
 }
 
-func searchInMapTabe(table []*ForwardingPolicy, count []int, skip []bool) (int, interface{}) {
+// searchInMapTabe counts a hit for the policies of the table and returns the
+// first policy added among the match and the policies whose tags have all been
+// hit.
+func searchInMapTabe(table []*ForwardingPolicy, count []int, skip []bool, match *ForwardingPolicy) *ForwardingPolicy {
 	for _, policy := range table {
 
 		// Skip the policy if we have marked it
@@ -227,13 +290,13 @@ func searchInMapTabe(table []*ForwardingPolicy, count []int, skip []bool) (int,
 		count[policy.index]++
 
 		// If all tags of the policy have been hit, there is a match
-		if count[policy.index] == policy.count {
-			return policy.index, policy.actions
+		if count[policy.index] == policy.count && (match == nil || policy.index < match.index) {
+			match = policy
 		}
 
 	}
 
-	return -1, nil
+	return match
 }
 
 // PrintPolicyDB is a debugging function to dump the map
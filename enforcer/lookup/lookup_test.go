@@ -248,14 +248,16 @@ func TestFuncSearch(t *testing.T) {
 			So(index4, ShouldEqual, 4)
 			So(index5, ShouldEqual, 5)
 			So(index6, ShouldEqual, 6)
+			So(index8, ShouldEqual, 8)
 			So(index9, ShouldEqual, 9)
+			So(index10, ShouldEqual, 10)
 
-			Convey("The vulnerability tag policy should match", func() {
+			Convey("The env not exists policy, added before the vulnerability tag policy, should match", func() {
 				tags := policy.NewTagStore()
 				tags.AppendKeyValue("vulnerability", "high")
 
 				index, action := policyDB.Search(tags)
-				So(index, ShouldEqual, index10)
+				So(index, ShouldEqual, index9)
 				So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Accept)
 			})
 
@@ -369,12 +371,12 @@ func TestFuncSearch(t *testing.T) {
 				So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Accept)
 			})
 
-			Convey("Given that I search for a value that matches a complete value ", func() {
+			Convey("Given that I search for a value that matches a complete value and a prefix, the prefix added first should match", func() {
 				tags := policy.NewTagStore()
 				tags.AppendKeyValue("domain", "com.example.web")
 
 				index, action := policyDB.Search(tags)
-				So(index, ShouldEqual, index8)
+				So(index, ShouldEqual, index7)
 				So(action.(*policy.FlowPolicy).Action, ShouldEqual, policy.Accept)
 			})
 
@@ -418,3 +420,109 @@ func TestFuncDumpDB(t *testing.T) {
 		})
 	})
 }
+
+// TestFuncSearchSetOperators tests the search with the not, any of and all of
+// operators
+func TestFuncSearchSetOperators(t *testing.T) {
+
+	envNotDev := policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{Key: "env", Value: []string{"dev", "test*"}, Operator: policy.Not},
+		},
+		Policy: &policy.FlowPolicy{Action: policy.Accept},
+	}
+
+	roleAllOfAdminAudit := policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{Key: "role", Value: []string{"admin", "audit*"}, Operator: policy.AllOf},
+		},
+		Policy: &policy.FlowPolicy{Action: policy.Accept},
+	}
+
+	appAnyOfWebAndLangNotJavaLike := policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{Key: "app", Value: []string{"web", "api"}, Operator: policy.AnyOf},
+			{Key: "lang", Value: []string{"java*"}, Operator: policy.NotEqual},
+		},
+		Policy: &policy.FlowPolicy{Action: policy.Accept},
+	}
+
+	Convey("Given a policy DB with a not policy", t, func() {
+		policyDB := NewPolicyDB()
+		index := policyDB.AddPolicy(envNotDev)
+
+		Convey("The tags without env should match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+
+			i, action := policyDB.Search(tags)
+			So(i, ShouldEqual, index)
+			So(action, ShouldResemble, envNotDev.Policy)
+		})
+
+		Convey("The tags with another env should match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("env", "prod")
+
+			i, _ := policyDB.Search(tags)
+			So(i, ShouldEqual, index)
+		})
+
+		Convey("The tags with one of the values should not match", func() {
+			for _, env := range []string{"dev", "test", "test-2"} {
+				tags := policy.NewTagStore()
+				tags.AppendKeyValue("app", "web")
+				tags.AppendKeyValue("env", env)
+
+				i, action := policyDB.Search(tags)
+				So(i, ShouldEqual, -1)
+				So(action, ShouldBeNil)
+			}
+		})
+	})
+
+	Convey("Given a policy DB with an all of policy", t, func() {
+		policyDB := NewPolicyDB()
+		index := policyDB.AddPolicy(roleAllOfAdminAudit)
+
+		Convey("The tags with all the values should match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("role", "admin")
+			tags.AppendKeyValue("role", "auditor")
+
+			i, _ := policyDB.Search(tags)
+			So(i, ShouldEqual, index)
+		})
+
+		Convey("The tags with one of the values should not match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("role", "admin")
+
+			i, _ := policyDB.Search(tags)
+			So(i, ShouldEqual, -1)
+		})
+	})
+
+	Convey("Given a policy DB with an any of policy and a not equal wildcard", t, func() {
+		policyDB := NewPolicyDB()
+		index := policyDB.AddPolicy(appAnyOfWebAndLangNotJavaLike)
+
+		Convey("The tags with one of the values and another lang should match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "api")
+			tags.AppendKeyValue("lang", "go")
+
+			i, _ := policyDB.Search(tags)
+			So(i, ShouldEqual, index)
+		})
+
+		Convey("The tags with a lang matching the wildcard should not match", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+			tags.AppendKeyValue("lang", "javascript")
+
+			i, _ := policyDB.Search(tags)
+			So(i, ShouldEqual, -1)
+		})
+	})
+}
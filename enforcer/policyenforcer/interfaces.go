@@ -1,8 +1,12 @@
 package policyenforcer
 
 import (
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme-lib/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
@@ -31,3 +35,80 @@ type Enforcer interface {
 	// UpdateSecrets -- updates the secrets of running enforcers managed by trireme. Remote enforcers will get the secret updates with the next policy push
 	UpdateSecrets(secrets secrets.Secrets) error
 }
+
+// TokenFormatter is implemented by the enforcers whose tokens can be emitted
+// in another format
+type TokenFormatter interface {
+
+	// SetTokenFormat sets the format of the tokens of the Syn and SynAck packets
+	SetTokenFormat(format tokens.Format) error
+}
+
+// EnvoyAuthorizer is implemented by the enforcers that can authorize the
+// requests of Envoy sidecars with the policies of their PUs
+type EnvoyAuthorizer interface {
+
+	// SetEnvoyAuthorization sets the address of the ext_authz HTTP service of
+	// the Envoy sidecars. It must be called before Start.
+	SetEnvoyAuthorization(address string) error
+}
+
+// StatsReporter is implemented by the enforcers that count the packets of their
+// netfilter queues
+type StatsReporter interface {
+
+	// GetStats returns the counters of the queues of the enforcer and of the
+	// packets they dropped for each PU
+	GetStats() []*collector.QueueStatsRecord
+}
+
+// CacheStatsReporter is implemented by the enforcers that count the lookups and
+// the evictions of their connection caches
+type CacheStatsReporter interface {
+
+	// GetCacheStats returns the counters of the caches of the enforcer
+	GetCacheStats() []*collector.CacheStatsRecord
+}
+
+// FilterQueueUpdater is implemented by the enforcers whose netfilter queues can
+// be resized or rebalanced while they are running
+type FilterQueueUpdater interface {
+
+	// UpdateFilterQueue stops the queues of the enforcer and starts the queues
+	// of the new configuration
+	UpdateFilterQueue(fq *fqconfig.FilterQueue) error
+}
+
+// LogForwarder is implemented by the enforcers whose remote enforcers forward
+// their logs to the controller
+type LogForwarder interface {
+
+	// SetLogForwarding sets the level of the logs the enforcer of a PU
+	// forwards. The logs are not forwarded if the level is empty.
+	SetLogForwarding(contextID string, level string) error
+}
+
+// LogLevelSetter is implemented by the enforcers whose remote enforcers can
+// change the level of their logs at runtime
+type LogLevelSetter interface {
+
+	// SetLogLevel sets the level of the logs of the enforcer of a PU
+	SetLogLevel(contextID string, level string) error
+}
+
+// DebugListener is implemented by the enforcers whose remote enforcers can
+// expose their profiles and counters on a debug listener
+type DebugListener interface {
+
+	// SetDebugListener starts or stops the debug listener of the enforcer of a
+	// PU
+	SetDebugListener(contextID string, enable bool) error
+}
+
+// CallTimeoutSetter is implemented by the enforcers whose calls to their remote
+// enforcers time out
+type CallTimeoutSetter interface {
+
+	// SetCallTimeout sets the time a remote enforcer has to answer a call
+	SetCallTimeout(timeout time.Duration) error
+}
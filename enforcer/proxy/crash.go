@@ -0,0 +1,31 @@
+package enforcerproxy
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
+
+// PostCrash is the function called from the remoteenforcer when it crashes,
+// right before it exits. The crash is logged by the remote logger with the
+// stack of the crash and the last lines logged by the remote enforcer.
+func (r *StatsServer) PostCrash(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !r.rpchdl.ProcessMessage(&req, r.secret) {
+		zap.L().Error("Message sender cannot be verified")
+		return errors.New("message sender cannot be verified")
+	}
+
+	payload := req.Payload.(rpcwrapper.CrashPayload)
+
+	zap.L().Named(remoteLoggerName).Error("Remote enforcer crashed",
+		zap.String("contextID", payload.ContextID),
+		zap.String("reason", payload.Reason),
+		zap.String("remoteStack", payload.Stack),
+		zap.Strings("remoteLogs", payload.Logs),
+	)
+
+	return nil
+}
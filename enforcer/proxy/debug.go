@@ -0,0 +1,19 @@
+package enforcerproxy
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+	"github.com/aporeto-inc/trireme-lib/internal/remoteenforcer"
+)
+
+// SetDebugListener implements the interface policyenforcer.DebugListener. It
+// starts or stops the debug listener of the remote enforcer of a PU.
+func (s *ProxyInfo) SetDebugListener(contextID string, enable bool) error {
+
+	if err := s.versionedCall(contextID, remoteenforcer.SetDebugListener, rpcwrapper.DebugListenerVersion, &rpcwrapper.DebugListenerPayload{Enable: enable}); err != nil {
+		return fmt.Errorf("failed to set debug listener: %s", err)
+	}
+
+	return nil
+}
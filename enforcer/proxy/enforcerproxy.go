@@ -4,8 +4,11 @@
 package enforcerproxy
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,6 +22,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme-lib/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/internal/processmon"
 	"github.com/aporeto-inc/trireme-lib/internal/remoteenforcer"
@@ -36,6 +40,26 @@ type tokenPKICertifier interface {
 	TokenPEMs() [][]byte
 }
 
+// processSharer is implemented by the process managers that share a remote
+// enforcer between the PUs of a network namespace
+type processSharer interface {
+	ProcessContexts(contextID string) []string
+}
+
+const (
+	// heartbeatInterval is the period of the heartbeats sent to the remote enforcers
+	heartbeatInterval = 10 * time.Second
+	// heartbeatTimeout is the time a remote enforcer has to answer a heartbeat
+	heartbeatTimeout = 5 * time.Second
+	// maxHeartbeatsInFlight is the number of heartbeats waiting for an answer
+	// at the same time, so that the remote enforcers that do not answer do not
+	// delay the heartbeats of the others beyond the interval
+	maxHeartbeatsInFlight = 64
+	// maxMissedHeartbeats is the number of consecutive heartbeats a remote
+	// enforcer can miss before it is restarted
+	maxMissedHeartbeats = 3
+)
+
 // ProxyInfo is the struct used to hold state about active enforcers in the system
 type ProxyInfo struct {
 	MutualAuth             bool
@@ -50,8 +74,22 @@ type ProxyInfo struct {
 	commandArg             string
 	statsServerSecret      string
 	procMountPoint         string
+	tokenFormat            tokens.Format
 	ExternalIPCacheTimeout time.Duration
 	portSetInstance        portset.PortSet
+	// versions holds the protocol version negotiated with each remote enforcer
+	versions map[string]int
+	// puInfos holds the last policy enforced by each remote enforcer, so that
+	// it can be enforced again when a remote enforcer is restarted
+	puInfos           map[string]*policy.PUInfo
+	restartHandlers   []func(contextID string)
+	heartbeatInterval time.Duration
+	stopHeartbeat     chan struct{}
+	// callTimeout is the time a remote enforcer has to answer a call
+	callTimeout time.Duration
+	// queueStats holds the last counters of the queues reported by each
+	// remote enforcer
+	queueStats map[string][]*collector.QueueStatsRecord
 	sync.RWMutex
 }
 
@@ -76,23 +114,164 @@ func (s *ProxyInfo) InitRemoteEnforcer(contextID string) error {
 		},
 	}
 
+	s.RLock()
+	request.Payload.(*rpcwrapper.InitRequestPayload).TokenFormat = s.tokenFormat
+	s.RUnlock()
+
+	// The remote enforcers open the signers of the keys that never leave
+	// their devices themselves
+	if signer, ok := s.Secrets.(secrets.SignerSecrets); ok {
+		request.Payload.(*rpcwrapper.InitRequestPayload).PrivateKeyURI = signer.PrivateKeyURI()
+	}
+
 	if s.Secrets.Type() == secrets.PKICompactType {
 		payload := request.Payload.(*rpcwrapper.InitRequestPayload)
 		payload.Token = s.Secrets.TransmittedKey()
 		payload.TokenKeyPEMs = s.Secrets.(tokenPKICertifier).TokenPEMs()
 	}
 
-	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.InitEnforcer, request, resp); err != nil {
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	if err := s.rpchdl.RemoteCall(ctx, contextID, remoteenforcer.InitEnforcer, request, resp); err != nil {
 		return fmt.Errorf("failed to initialize remote enforcer: status: %s: %s", resp.Status, err)
 	}
 
+	// Remote enforcers built before the payloads were versioned report version 0
+	version, err := rpcwrapper.NegotiateVersion(resp.Version)
+	if err != nil {
+		return fmt.Errorf("incompatible remote enforcer: %s", err)
+	}
+
+	if err := s.rpchdl.SetVersion(contextID, version); err != nil {
+		return fmt.Errorf("unable to set protocol version of remote enforcer: %s", err)
+	}
+
+	zap.L().Debug("Negotiated protocol version with remote enforcer",
+		zap.String("contextID", contextID),
+		zap.Int("version", version),
+	)
+
+	s.Lock()
+	s.initDone[contextID] = true
+	s.versions[contextID] = version
+	s.Unlock()
+
+	return nil
+}
+
+// sharedContexts returns the other PUs that share the remote enforcer of a PU
+func (s *ProxyInfo) sharedContexts(contextID string) []string {
+
+	sharer, ok := s.prochdl.(processSharer)
+	if !ok {
+		return nil
+	}
+
+	contexts := []string{}
+	for _, shared := range sharer.ProcessContexts(contextID) {
+		if shared != contextID {
+			contexts = append(contexts, shared)
+		}
+	}
+
+	return contexts
+}
+
+// initSharedRemoteEnforcer initializes the remote enforcer of a PU, unless it
+// is shared with another PU of its network namespace that initialized it. The
+// PU then uses the protocol version negotiated by the other PU.
+func (s *ProxyInfo) initSharedRemoteEnforcer(contextID string) error {
+
+	shared := s.sharedContexts(contextID)
+
+	s.RLock()
+	version, initialized := 0, false
+	for _, c := range shared {
+		if s.initDone[c] {
+			version, initialized = s.versions[c], true
+			break
+		}
+	}
+	s.RUnlock()
+
+	if !initialized {
+		return s.InitRemoteEnforcer(contextID)
+	}
+
+	if err := s.rpchdl.SetVersion(contextID, version); err != nil {
+		return fmt.Errorf("unable to set protocol version of remote enforcer: %s", err)
+	}
+
 	s.Lock()
 	s.initDone[contextID] = true
+	s.versions[contextID] = version
 	s.Unlock()
 
 	return nil
 }
 
+// checkSharedPolicy returns an error if a PU cannot share the remote enforcer
+// of its network namespace with the other PUs. The remote enforcer handles the
+// traffic of the namespace with the context of one of its PUs, so a PU can only
+// join the others if it has the same tags and the same policy. Once it shares
+// the remote enforcer, it keeps it as long as its tags are the same, since the
+// policies of the PUs are updated one after the other.
+func (s *ProxyInfo) checkSharedPolicy(contextID string, puInfo *policy.PUInfo, joining bool) error {
+
+	shared := s.sharedContexts(contextID)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, c := range shared {
+		other, ok := s.puInfos[c]
+		if !ok {
+			continue
+		}
+
+		if !sameTags(puInfo.Policy.Identity(), other.Policy.Identity()) {
+			return fmt.Errorf("pu %s has different tags than pu %s of its network namespace", contextID, c)
+		}
+
+		if joining && !samePolicy(puInfo.Policy, other.Policy) {
+			return fmt.Errorf("pu %s has a different policy than pu %s of its network namespace", contextID, c)
+		}
+	}
+
+	return nil
+}
+
+// sameTags returns true if two tag stores hold the same tags in any order
+func sameTags(a, b *policy.TagStore) bool {
+
+	tagsA := append([]string{}, a.GetSlice()...)
+	tagsB := append([]string{}, b.GetSlice()...)
+	sort.Strings(tagsA)
+	sort.Strings(tagsB)
+
+	return reflect.DeepEqual(tagsA, tagsB)
+}
+
+// samePolicy returns true if two policies enforce the same rules. The
+// management ids are not compared.
+func samePolicy(a, b *policy.PUPolicy) bool {
+
+	return a.TriremeAction() == b.TriremeAction() &&
+		reflect.DeepEqual(a.ApplicationACLs(), b.ApplicationACLs()) &&
+		reflect.DeepEqual(a.NetworkACLs(), b.NetworkACLs()) &&
+		reflect.DeepEqual(a.IPAddresses(), b.IPAddresses()) &&
+		sameTags(a.Annotations(), b.Annotations()) &&
+		reflect.DeepEqual(a.ReceiverRules(), b.ReceiverRules()) &&
+		reflect.DeepEqual(a.TransmitterRules(), b.TransmitterRules()) &&
+		reflect.DeepEqual(a.TriremeNetworks(), b.TriremeNetworks()) &&
+		reflect.DeepEqual(a.ExcludedNetworks(), b.ExcludedNetworks()) &&
+		reflect.DeepEqual(a.ProxiedServices(), b.ProxiedServices()) &&
+		reflect.DeepEqual(a.IdentityClaims(), b.IdentityClaims()) &&
+		reflect.DeepEqual(a.HTTPRules(), b.HTTPRules()) &&
+		reflect.DeepEqual(a.SNIRules(), b.SNIRules())
+}
+
 // UpdateSecrets updates the secrets used for signing communication between trireme instances
 func (s *ProxyInfo) UpdateSecrets(token secrets.Secrets) error {
 	s.Lock()
@@ -113,9 +292,18 @@ func (s *ProxyInfo) Enforce(contextID string, puInfo *policy.PUInfo) error {
 
 	s.Lock()
 	_, ok := s.initDone[contextID]
+	_, enforced := s.puInfos[contextID]
 	s.Unlock()
+
+	if err = s.checkSharedPolicy(contextID, puInfo, !enforced); err != nil {
+		if !enforced {
+			s.prochdl.KillProcess(contextID)
+		}
+		return err
+	}
+
 	if !ok {
-		if err = s.InitRemoteEnforcer(contextID); err != nil {
+		if err = s.initSharedRemoteEnforcer(contextID); err != nil {
 			return err
 		}
 	}
@@ -141,12 +329,27 @@ func (s *ProxyInfo) Enforce(contextID string, puInfo *policy.PUInfo) error {
 	enforcerPayload.PublicPEM = pkier.TransmittedPEM()
 	enforcerPayload.PrivatePEM = pkier.EncodingPEM()
 	enforcerPayload.SecretType = s.Secrets.Type()
+	if revoker, ok := s.Secrets.(secrets.Revoker); ok && s.versions[contextID] >= rpcwrapper.RevocationsVersion {
+		enforcerPayload.RevokedSerials, enforcerPayload.RevokedTokens = revoker.Revocations().List()
+	}
+	if s.versions[contextID] >= rpcwrapper.IdentityClaimsVersion {
+		enforcerPayload.IdentityClaims = puInfo.Policy.IdentityClaims()
+	}
+	if s.versions[contextID] >= rpcwrapper.HTTPRulesVersion {
+		enforcerPayload.HTTPRules = puInfo.Policy.HTTPRules()
+	}
+	if s.versions[contextID] >= rpcwrapper.SNIRulesVersion {
+		enforcerPayload.SNIRules = puInfo.Policy.SNIRules()
+	}
 	s.RUnlock()
 	request := &rpcwrapper.Request{
 		Payload: enforcerPayload,
 	}
 
-	err = s.rpchdl.RemoteCall(contextID, remoteenforcer.Enforce, request, &rpcwrapper.Response{})
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	err = s.rpchdl.RemoteCall(ctx, contextID, remoteenforcer.Enforce, request, &rpcwrapper.Response{})
 	if err != nil {
 		// We can't talk to the enforcer. Kill it and restart it
 		s.Lock()
@@ -156,6 +359,10 @@ func (s *ProxyInfo) Enforce(contextID string, puInfo *policy.PUInfo) error {
 		return fmt.Errorf("failed to enforce rules: %s", err)
 	}
 
+	s.Lock()
+	s.puInfos[contextID] = puInfo
+	s.Unlock()
+
 	return nil
 }
 
@@ -164,16 +371,105 @@ func (s *ProxyInfo) Unenforce(contextID string) error {
 
 	s.Lock()
 	delete(s.initDone, contextID)
+	delete(s.versions, contextID)
+	delete(s.puInfos, contextID)
+	delete(s.queueStats, contextID)
 	s.Unlock()
 
 	return nil
 }
 
+// GetStats implements the interface policyenforcer.StatsReporter. It returns
+// the last counters of the queues reported by the remote enforcers.
+func (s *ProxyInfo) GetStats() []*collector.QueueStatsRecord {
+
+	s.RLock()
+	defer s.RUnlock()
+
+	records := []*collector.QueueStatsRecord{}
+	for _, queues := range s.queueStats {
+		records = append(records, queues...)
+	}
+
+	return records
+}
+
+// setQueueStats keeps the counters of the queues reported by remote enforcers
+func (s *ProxyInfo) setQueueStats(records []*collector.QueueStatsRecord) {
+
+	queues := map[string][]*collector.QueueStatsRecord{}
+	for _, record := range records {
+		queues[record.ContextID] = append(queues[record.ContextID], record)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.queueStats == nil {
+		s.queueStats = map[string][]*collector.QueueStatsRecord{}
+	}
+
+	for contextID, records := range queues {
+		if _, ok := s.initDone[contextID]; ok {
+			s.queueStats[contextID] = records
+		}
+	}
+}
+
+// RegisterRestartHandler registers a handler that is called when a remote
+// enforcer was restarted after missing its heartbeats and its policy was
+// enforced again.
+func (s *ProxyInfo) RegisterRestartHandler(handler func(contextID string)) {
+
+	s.Lock()
+	defer s.Unlock()
+	s.restartHandlers = append(s.restartHandlers, handler)
+}
+
 // GetFilterQueue returns the current FilterQueueConfig.
 func (s *ProxyInfo) GetFilterQueue() *fqconfig.FilterQueue {
 	return s.filterQueue
 }
 
+// SetTokenFormat implements the interface policyenforcer.TokenFormatter. The
+// remote enforcers get the format when they are initialized.
+func (s *ProxyInfo) SetTokenFormat(format tokens.Format) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.tokenFormat = format
+
+	return nil
+}
+
+// SetCallTimeout implements the interface policyenforcer.CallTimeoutSetter.
+// The calls to the remote enforcers that do not answer in time fail.
+func (s *ProxyInfo) SetCallTimeout(timeout time.Duration) error {
+
+	if timeout <= 0 {
+		return fmt.Errorf("invalid call timeout %s", timeout)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.callTimeout = timeout
+
+	return nil
+}
+
+// callContext returns the context of a call to a remote enforcer, which ends
+// with the call timeout
+func (s *ProxyInfo) callContext() (context.Context, context.CancelFunc) {
+
+	s.RLock()
+	timeout := s.callTimeout
+	s.RUnlock()
+
+	return context.WithTimeout(context.Background(), timeout)
+}
+
 // GetPortSetInstance returns nil for the proxy
 func (s *ProxyInfo) GetPortSetInstance() portset.PortSet {
 	return s.portSetInstance
@@ -181,11 +477,195 @@ func (s *ProxyInfo) GetPortSetInstance() portset.PortSet {
 
 // Start starts the the remote enforcer proxy.
 func (s *ProxyInfo) Start() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.heartbeatInterval > 0 && s.stopHeartbeat == nil {
+		s.stopHeartbeat = make(chan struct{})
+		go s.heartbeatLoop(s.heartbeatInterval, s.stopHeartbeat)
+	}
+
 	return nil
 }
 
 // Stop stops the remote enforcer.
 func (s *ProxyInfo) Stop() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.stopHeartbeat != nil {
+		close(s.stopHeartbeat)
+		s.stopHeartbeat = nil
+	}
+
+	return nil
+}
+
+// heartbeatLoop sends heartbeats to the remote enforcers until it is stopped
+func (s *ProxyInfo) heartbeatLoop(interval time.Duration, stop chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := map[string]int{}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.checkRemoteEnforcers(missed)
+		}
+	}
+}
+
+// checkRemoteEnforcers sends a heartbeat to each remote enforcer that supports
+// them and restarts the ones that missed too many heartbeats
+func (s *ProxyInfo) checkRemoteEnforcers(missed map[string]int) {
+
+	contexts := map[string]bool{}
+
+	s.RLock()
+	for contextID := range s.initDone {
+		if s.versions[contextID] >= rpcwrapper.HeartbeatVersion {
+			contexts[contextID] = true
+		}
+	}
+	s.RUnlock()
+
+	for contextID := range missed {
+		if !contexts[contextID] {
+			delete(missed, contextID)
+		}
+	}
+
+	failures := s.sendHeartbeats(contexts)
+
+	for contextID := range contexts {
+
+		err, failed := failures[contextID]
+		if !failed {
+			delete(missed, contextID)
+			continue
+		}
+
+		missed[contextID]++
+		zap.L().Warn("Remote enforcer missed a heartbeat",
+			zap.String("contextID", contextID),
+			zap.Int("missed", missed[contextID]),
+			zap.Error(err),
+		)
+
+		if missed[contextID] < maxMissedHeartbeats {
+			continue
+		}
+
+		delete(missed, contextID)
+		if err := s.restartRemoteEnforcer(contextID); err != nil {
+			zap.L().Error("Unable to restart remote enforcer",
+				zap.String("contextID", contextID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// sendHeartbeats sends a heartbeat to the remote enforcers in parallel, with at
+// most maxHeartbeatsInFlight heartbeats waiting for an answer, and returns the
+// errors of the heartbeats that failed
+func (s *ProxyInfo) sendHeartbeats(contexts map[string]bool) map[string]error {
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+
+	failures := map[string]error{}
+	inFlight := make(chan struct{}, maxHeartbeatsInFlight)
+
+	for contextID := range contexts {
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func(contextID string) {
+			defer func() {
+				<-inFlight
+				wg.Done()
+			}()
+
+			if err := s.heartbeat(contextID); err != nil {
+				lock.Lock()
+				failures[contextID] = err
+				lock.Unlock()
+			}
+		}(contextID)
+	}
+	wg.Wait()
+
+	return failures
+}
+
+// heartbeat sends a heartbeat to a remote enforcer
+func (s *ProxyInfo) heartbeat(contextID string) error {
+
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.HeartbeatPayload{
+			ContextID: contextID,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), heartbeatTimeout)
+	defer cancel()
+
+	return s.rpchdl.RemoteCall(ctx, contextID, remoteenforcer.Heartbeat, request, &rpcwrapper.Response{})
+}
+
+// restartRemoteEnforcer kills a remote enforcer, launches it again and
+// enforces its last policy. The policies of the other PUs sharing the remote
+// enforcer are enforced again as well.
+func (s *ProxyInfo) restartRemoteEnforcer(contextID string) error {
+
+	contexts := append([]string{contextID}, s.sharedContexts(contextID)...)
+
+	s.Lock()
+	puInfos := map[string]*policy.PUInfo{}
+	for _, c := range contexts {
+		if puInfo, ok := s.puInfos[c]; ok {
+			puInfos[c] = puInfo
+		}
+		delete(s.initDone, c)
+	}
+	handlers := s.restartHandlers
+	s.Unlock()
+
+	if _, ok := puInfos[contextID]; !ok {
+		return errors.New("no policy to enforce")
+	}
+
+	zap.L().Info("Restarting remote enforcer",
+		zap.String("contextID", contextID),
+		zap.Strings("sharedContextIDs", contexts[1:]),
+	)
+
+	// The process is only killed with the last PU that uses it
+	for _, c := range contexts {
+		s.prochdl.KillProcess(c)
+	}
+
+	for _, c := range contexts {
+		puInfo, ok := puInfos[c]
+		if !ok {
+			continue
+		}
+
+		if err := s.Enforce(c, puInfo); err != nil {
+			return fmt.Errorf("unable to enforce policy of %s: %s", c, err)
+		}
+
+		for _, handler := range handlers {
+			handler(c)
+		}
+	}
+
 	return nil
 }
 
@@ -261,12 +741,16 @@ func newProxyEnforcer(mutualAuth bool,
 		ExternalIPCacheTimeout: ExternalIPCacheTimeout,
 		PacketLogs:             packetLogs,
 		portSetInstance:        portSetInstance,
+		versions:               map[string]int{},
+		puInfos:                map[string]*policy.PUInfo{},
+		heartbeatInterval:      heartbeatInterval,
+		callTimeout:            rpcwrapper.DefaultCallTimeout,
 	}
 
 	zap.L().Debug("Called NewDataPathEnforcer")
 
 	statsServer := rpcwrapper.NewRPCWrapper()
-	rpcServer := &StatsServer{rpchdl: statsServer, collector: collector, secret: statsServersecret}
+	rpcServer := &StatsServer{rpchdl: statsServer, collector: collector, secret: statsServersecret, proxy: proxydata}
 
 	// Start hte server for statistics collection
 	go statsServer.StartServer("unix", rpcwrapper.StatsChannel, rpcServer) // nolint
@@ -311,9 +795,10 @@ type StatsServer struct {
 	collector collector.EventCollector
 	rpchdl    rpcwrapper.RPCServer
 	secret    string
+	proxy     *ProxyInfo
 }
 
-// GetStats is the function called from the remoteenforcer when it has new flow events to publish.
+// GetStats is the function called from the remoteenforcer when it has new flow events, connection metrics, packet and queue records to publish.
 func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
 	if !r.rpchdl.ProcessMessage(&req, r.secret) {
@@ -327,5 +812,37 @@ func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response
 		r.collector.CollectFlowEvent(record)
 	}
 
+	if c, ok := r.collector.(collector.ConnectionMetricsCollector); ok {
+		for _, record := range payload.ConnectionMetrics {
+			c.CollectConnectionMetrics(record)
+		}
+	}
+
+	if c, ok := r.collector.(collector.PacketEventCollector); ok {
+		for _, record := range payload.Packets {
+			c.CollectPacketEvent(record)
+		}
+	}
+
+	if c, ok := r.collector.(collector.QueueStatsCollector); ok {
+		for _, record := range payload.QueueStats {
+			c.CollectQueueStats(record)
+		}
+	}
+
+	if c, ok := r.collector.(collector.CacheStatsCollector); ok {
+		for _, record := range payload.CacheStats {
+			c.CollectCacheStats(record)
+		}
+	}
+
+	if c, ok := r.collector.(collector.EnforcerStatsCollector); ok && payload.EnforcerStats != nil {
+		c.CollectEnforcerStats(payload.EnforcerStats)
+	}
+
+	if r.proxy != nil && len(payload.QueueStats) > 0 {
+		r.proxy.setQueueStats(payload.QueueStats)
+	}
+
 	return nil
 }
@@ -1,7 +1,10 @@
 package enforcerproxy
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -179,7 +182,8 @@ func TestInitRemoteEnforcer(t *testing.T) {
 		})
 
 		Convey("When I try to initiate a remote enforcer", func() {
-			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().SetVersion("testServerID", 0).Times(1).Return(nil)
 			err := policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID")
 
 			Convey("Then I should not get any error", func() {
@@ -188,6 +192,49 @@ func TestInitRemoteEnforcer(t *testing.T) {
 		})
 	})
 
+	Convey("When I try to start a proxy enforcer with a remote enforcer of a newer version", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		policyEnf := NewDefaultProxyEnforcer("testServerID", eventCollector(), secretGen(nil, nil, nil), rpchdl, procMountPoint)
+
+		Convey("When I try to initiate a remote enforcer", func() {
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+				func(ctx context.Context, contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					resp.Version = rpcwrapper.ProtocolVersion + 1
+				}).Return(nil)
+			rpchdl.EXPECT().SetVersion("testServerID", rpcwrapper.ProtocolVersion).Times(1).Return(nil)
+			err := policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID")
+
+			Convey("Then I should not get any error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("When I try to start a proxy enforcer with a call timeout", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		policyEnf := NewDefaultProxyEnforcer("testServerID", eventCollector(), secretGen(nil, nil, nil), rpchdl, procMountPoint)
+
+		Convey("Then an invalid timeout should be rejected", func() {
+			So(policyEnf.(*ProxyInfo).SetCallTimeout(0), ShouldNotBeNil)
+		})
+
+		Convey("When I try to initiate a remote enforcer that does not answer in time", func() {
+			So(policyEnf.(*ProxyInfo).SetCallTimeout(time.Second), ShouldBeNil)
+
+			var deadline time.Time
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+				func(ctx context.Context, contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					deadline, _ = ctx.Deadline()
+				}).Return(context.DeadlineExceeded)
+			err := policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID")
+
+			Convey("Then I should get an error and the call should have the timeout", func() {
+				So(err, ShouldNotBeNil)
+				So(deadline, ShouldHappenWithin, time.Second, time.Now())
+			})
+		})
+	})
+
 	Convey("When I try to start a proxy enforcer with defaults and PKICompactType", t, func() {
 		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
 		cpki, _ := secrets.NewCompactPKI([]byte(keypem), []byte(certPEM), []byte(caPool), token)
@@ -198,7 +245,8 @@ func TestInitRemoteEnforcer(t *testing.T) {
 		})
 
 		Convey("When I try to initiate a remote enforcer", func() {
-			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().SetVersion("testServerID", 0).Times(1).Return(nil)
 			err := policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID")
 
 			Convey("Then I should not get any error", func() {
@@ -223,7 +271,8 @@ func TestEnforce(t *testing.T) {
 		})
 
 		Convey("When I try to initiate a remote enforcer", func() {
-			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().SetVersion("testServerID", 0).Times(1).Return(nil)
 			err := policyEnf.(*ProxyInfo).InitRemoteEnforcer("testServerID")
 
 			Convey("Then I should not get any error", func() {
@@ -232,7 +281,7 @@ func TestEnforce(t *testing.T) {
 
 			Convey("When I try to call enforce method", func() {
 				prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
-				rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+				rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
 
 				err := policyEnf.(*ProxyInfo).Enforce("testServerID", createPUInfo())
 
@@ -254,8 +303,9 @@ func TestEnforce(t *testing.T) {
 
 		Convey("When I try to call enforce method without enforcer running", func() {
 			prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
-			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
-			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().SetVersion("testServerID", 0).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
 			err := policyEnf.(*ProxyInfo).Enforce("testServerID", createPUInfo())
 
 			Convey("Then I should not get any error", func() {
@@ -266,6 +316,96 @@ func TestEnforce(t *testing.T) {
 	})
 }
 
+// sharingProcessManager shares a remote enforcer between the PUs of contexts
+type sharingProcessManager struct {
+	*mockprocessmon.MockProcessManager
+	contexts []string
+}
+
+func (p *sharingProcessManager) ProcessContexts(contextID string) []string {
+	return p.contexts
+}
+
+func TestEnforceSharedRemoteEnforcer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a proxy enforcer with a remote enforcer shared by two PUs", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := &sharingProcessManager{
+			MockProcessManager: mockprocessmon.NewMockProcessManager(ctrl),
+			contexts:           []string{"pod", "testServerID"},
+		}
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl).(*ProxyInfo)
+		policyEnf.initDone["pod"] = true
+		policyEnf.versions["pod"] = rpcwrapper.SNIRulesVersion
+
+		Convey("When I enforce the second PU, it should not initialize the remote enforcer again", func() {
+			prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
+			rpchdl.EXPECT().SetVersion("testServerID", rpcwrapper.SNIRulesVersion).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+
+			err := policyEnf.Enforce("testServerID", createPUInfo())
+			So(err, ShouldBeNil)
+			So(policyEnf.initDone["testServerID"], ShouldBeTrue)
+			So(policyEnf.versions["testServerID"], ShouldEqual, rpcwrapper.SNIRulesVersion)
+		})
+
+		Convey("When I enforce the second PU with the policy of the first PU, it should share the remote enforcer", func() {
+			policyEnf.puInfos["pod"] = createPUInfo()
+
+			prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
+			rpchdl.EXPECT().SetVersion("testServerID", rpcwrapper.SNIRulesVersion).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+
+			err := policyEnf.Enforce("testServerID", createPUInfo())
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When I enforce the second PU with a different policy, it should be rejected", func() {
+			podInfo := createPUInfo()
+			podInfo.Policy.SetTriremeAction(policy.AllowAll)
+			policyEnf.puInfos["pod"] = podInfo
+
+			prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
+			prochdl.EXPECT().KillProcess("testServerID")
+
+			err := policyEnf.Enforce("testServerID", createPUInfo())
+			So(err, ShouldNotBeNil)
+			So(policyEnf.initDone["testServerID"], ShouldBeFalse)
+			So(policyEnf.puInfos["testServerID"], ShouldBeNil)
+		})
+
+		Convey("When I enforce the second PU with different tags, it should be rejected", func() {
+			podInfo := createPUInfo()
+			podInfo.Policy.AddIdentityTag("app", "frontend")
+			policyEnf.puInfos["pod"] = podInfo
+
+			prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
+			prochdl.EXPECT().KillProcess("testServerID")
+
+			err := policyEnf.Enforce("testServerID", createPUInfo())
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I update the policy of a PU that shares the remote enforcer, it should be enforced", func() {
+			policyEnf.puInfos["pod"] = createPUInfo()
+			policyEnf.initDone["testServerID"] = true
+			policyEnf.versions["testServerID"] = rpcwrapper.SNIRulesVersion
+			policyEnf.puInfos["testServerID"] = createPUInfo()
+
+			updated := createPUInfo()
+			updated.Policy.SetTriremeAction(policy.AllowAll)
+
+			prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+
+			err := policyEnf.Enforce("testServerID", updated)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
 func TestUnenforce(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -281,8 +421,9 @@ func TestUnenforce(t *testing.T) {
 
 		Convey("When I try to call enforce method", func() {
 			prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
-			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
-			rpchdl.EXPECT().RemoteCall("testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			rpchdl.EXPECT().SetVersion("testServerID", 0).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
 			err := policyEnf.(*ProxyInfo).Enforce("testServerID", createPUInfo())
 
 			Convey("Then I should not get any error", func() {
@@ -299,3 +440,111 @@ func TestUnenforce(t *testing.T) {
 		})
 	})
 }
+
+func TestHeartbeat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a proxy enforcer with a remote enforcer that supports heartbeats", t, func() {
+		rpchdl := mockrpcwrapper.NewMockRPCClient(ctrl)
+		prochdl := mockprocessmon.NewMockProcessManager(ctrl)
+		policyEnf := setupProxyEnforcer(rpchdl, prochdl).(*ProxyInfo)
+
+		initRemote := func() {
+			prochdl.EXPECT().LaunchProcess("testServerID", gomock.Any(), gomock.Any(), rpchdl, gomock.Any(), gomock.Any(), gomock.Any())
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.InitEnforcer, gomock.Any(), gomock.Any()).Times(1).Do(
+				func(ctx context.Context, contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+					resp.Version = rpcwrapper.ProtocolVersion
+				}).Return(nil)
+			rpchdl.EXPECT().SetVersion("testServerID", rpcwrapper.ProtocolVersion).Times(1).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Enforce, gomock.Any(), gomock.Any()).Times(1).Return(nil)
+		}
+
+		initRemote()
+		So(policyEnf.Enforce("testServerID", createPUInfo()), ShouldBeNil)
+
+		restarted := []string{}
+		policyEnf.RegisterRestartHandler(func(contextID string) {
+			restarted = append(restarted, contextID)
+		})
+
+		Convey("When the remote enforcer answers the heartbeats, it should not be restarted", func() {
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Heartbeat, gomock.Any(), gomock.Any()).Times(maxMissedHeartbeats).Return(nil)
+
+			missed := map[string]int{}
+			for i := 0; i < maxMissedHeartbeats; i++ {
+				policyEnf.checkRemoteEnforcers(missed)
+			}
+
+			So(missed, ShouldBeEmpty)
+			So(restarted, ShouldBeEmpty)
+		})
+
+		Convey("When the remote enforcer misses its heartbeats, it should be restarted and its policy enforced again", func() {
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Heartbeat, gomock.Any(), gomock.Any()).Times(maxMissedHeartbeats).Return(errors.New("connection is shut down"))
+			prochdl.EXPECT().KillProcess("testServerID").Times(1)
+			initRemote()
+
+			missed := map[string]int{}
+			for i := 0; i < maxMissedHeartbeats-1; i++ {
+				policyEnf.checkRemoteEnforcers(missed)
+			}
+			So(missed["testServerID"], ShouldEqual, maxMissedHeartbeats-1)
+			So(restarted, ShouldBeEmpty)
+
+			policyEnf.checkRemoteEnforcers(missed)
+			So(missed, ShouldBeEmpty)
+			So(restarted, ShouldResemble, []string{"testServerID"})
+		})
+
+		Convey("When several remote enforcers are checked, their heartbeats should be sent concurrently", func() {
+			policyEnf.Lock()
+			policyEnf.initDone["otherServerID"] = true
+			policyEnf.versions["otherServerID"] = rpcwrapper.ProtocolVersion
+			policyEnf.Unlock()
+
+			var lock sync.Mutex
+			arrived := 0
+			answered := 0
+			wait := func(ctx context.Context, contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) {
+				lock.Lock()
+				arrived++
+				lock.Unlock()
+
+				for {
+					lock.Lock()
+					both := arrived == 2
+					if both {
+						answered++
+					}
+					lock.Unlock()
+
+					if both {
+						return
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(10 * time.Millisecond):
+					}
+				}
+			}
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "testServerID", remoteenforcer.Heartbeat, gomock.Any(), gomock.Any()).Times(1).Do(wait).Return(nil)
+			rpchdl.EXPECT().RemoteCall(gomock.Any(), "otherServerID", remoteenforcer.Heartbeat, gomock.Any(), gomock.Any()).Times(1).Do(wait).Return(nil)
+
+			missed := map[string]int{}
+			policyEnf.checkRemoteEnforcers(missed)
+			So(answered, ShouldEqual, 2)
+			So(missed, ShouldBeEmpty)
+		})
+
+		Convey("When the remote enforcer was unenforced, no heartbeat should be sent", func() {
+			So(policyEnf.Unenforce("testServerID"), ShouldBeNil)
+
+			missed := map[string]int{}
+			policyEnf.checkRemoteEnforcers(missed)
+			So(missed, ShouldBeEmpty)
+		})
+	})
+}
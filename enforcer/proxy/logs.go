@@ -0,0 +1,149 @@
+package enforcerproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+	"github.com/aporeto-inc/trireme-lib/internal/remoteenforcer"
+)
+
+// remoteLoggerName is the name of the logger of the logs forwarded by the
+// remote enforcers
+const remoteLoggerName = "remote"
+
+// SetLogForwarding implements the interface policyenforcer.LogForwarder. It
+// sets the level of the logs the remote enforcer of a PU forwards to the
+// controller. The logs are not forwarded if the level is empty.
+func (s *ProxyInfo) SetLogForwarding(contextID string, level string) error {
+
+	if err := s.versionedCall(contextID, remoteenforcer.SetLogForwarding, rpcwrapper.LogForwardingVersion, &rpcwrapper.LogForwardingPayload{Level: level}); err != nil {
+		return fmt.Errorf("failed to set log forwarding: %s", err)
+	}
+
+	return nil
+}
+
+// SetLogLevel implements the interface policyenforcer.LogLevelSetter. It sets
+// the level of the logs of the remote enforcer of a PU, which is shared by the
+// PUs of its network namespace.
+func (s *ProxyInfo) SetLogLevel(contextID string, level string) error {
+
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %s", level)
+	}
+
+	if err := s.versionedCall(contextID, remoteenforcer.SetLogLevel, rpcwrapper.LogLevelVersion, &rpcwrapper.LogLevelPayload{Level: level}); err != nil {
+		return fmt.Errorf("failed to set log level: %s", err)
+	}
+
+	return nil
+}
+
+// versionedCall calls a method of the remote enforcer of a PU if its version
+// supports it
+func (s *ProxyInfo) versionedCall(contextID string, method string, version int, payload interface{}) error {
+
+	s.RLock()
+	_, initialized := s.initDone[contextID]
+	enforcerVersion := s.versions[contextID]
+	s.RUnlock()
+
+	if !initialized {
+		return fmt.Errorf("remote enforcer of %s is not initialized", contextID)
+	}
+
+	if enforcerVersion < version {
+		return fmt.Errorf("remote enforcer of %s is too old", contextID)
+	}
+
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	return s.rpchdl.RemoteCall(ctx, contextID, method, &rpcwrapper.Request{Payload: payload}, &rpcwrapper.Response{})
+}
+
+// PostLogs is the function called from the remoteenforcer when it has log
+// entries to forward. The entries are logged by the remote logger with the
+// context ID of the remote enforcer.
+func (r *StatsServer) PostLogs(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !r.rpchdl.ProcessMessage(&req, r.secret) {
+		zap.L().Error("Message sender cannot be verified")
+		return errors.New("message sender cannot be verified")
+	}
+
+	payload := req.Payload.(rpcwrapper.LogPayload)
+
+	logger := zap.L().Named(remoteLoggerName).With(zap.String("contextID", payload.ContextID))
+
+	for _, entry := range payload.Entries {
+		logEntry(logger, entry)
+	}
+
+	if payload.Dropped > 0 {
+		logger.Warn("Remote enforcer dropped log entries", zap.Int("dropped", payload.Dropped))
+	}
+
+	return nil
+}
+
+// logEntry logs an entry forwarded by a remote enforcer. The entries above the
+// error level are logged as errors, so that they do not stop the controller.
+func logEntry(logger *zap.Logger, entry *rpcwrapper.LogEntry) {
+
+	level := entry.Level
+	if level > zapcore.ErrorLevel {
+		level = zapcore.ErrorLevel
+	}
+
+	checked := logger.Check(level, entry.Message)
+	if checked == nil {
+		return
+	}
+
+	fields := []zapcore.Field{
+		zap.Time("remoteTime", entry.Time),
+	}
+
+	if level != entry.Level {
+		fields = append(fields, zap.Stringer("remoteLevel", entry.Level))
+	}
+
+	if entry.Logger != "" {
+		fields = append(fields, zap.String("remoteLogger", entry.Logger))
+	}
+
+	if entry.Caller != "" {
+		fields = append(fields, zap.String("remoteCaller", entry.Caller))
+	}
+
+	if entry.Stack != "" {
+		fields = append(fields, zap.String("remoteStack", entry.Stack))
+	}
+
+	if len(entry.Fields) > 0 {
+		values := map[string]interface{}{}
+		if err := json.Unmarshal(entry.Fields, &values); err != nil {
+			fields = append(fields, zap.ByteString("remoteFields", entry.Fields))
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fields = append(fields, zap.Any(key, values[key]))
+		}
+	}
+
+	checked.Write(fields...)
+}
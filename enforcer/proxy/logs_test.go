@@ -0,0 +1,51 @@
+package enforcerproxy
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
+
+func TestLogEntry(t *testing.T) {
+	Convey("Given a logger of the forwarded logs", t, func() {
+		core, logs := observer.New(zapcore.InfoLevel)
+		logger := zap.New(core).With(zap.String("contextID", "pu1"))
+
+		Convey("When I log a forwarded entry, it should keep its level and fields", func() {
+			logEntry(logger, &rpcwrapper.LogEntry{
+				Level:   zapcore.WarnLevel,
+				Time:    time.Now(),
+				Message: "dropped packet",
+				Caller:  "datapath/datapath.go:42",
+				Fields:  []byte(`{"port":80,"error":"invalid token"}`),
+			})
+			logEntry(logger, &rpcwrapper.LogEntry{Level: zapcore.DebugLevel, Message: "ignored"})
+
+			So(logs.Len(), ShouldEqual, 1)
+			entry := logs.All()[0]
+			So(entry.Level, ShouldEqual, zapcore.WarnLevel)
+			So(entry.Message, ShouldEqual, "dropped packet")
+
+			fields := entry.ContextMap()
+			So(fields["contextID"], ShouldEqual, "pu1")
+			So(fields["remoteCaller"], ShouldEqual, "datapath/datapath.go:42")
+			So(fields["port"], ShouldEqual, float64(80))
+			So(fields["error"], ShouldEqual, "invalid token")
+		})
+
+		Convey("When I log a fatal entry, it should be logged as an error", func() {
+			logEntry(logger, &rpcwrapper.LogEntry{Level: zapcore.FatalLevel, Message: "exiting"})
+
+			So(logs.Len(), ShouldEqual, 1)
+			So(logs.All()[0].Level, ShouldEqual, zapcore.ErrorLevel)
+			So(logs.All()[0].ContextMap()["remoteLevel"], ShouldEqual, "fatal")
+		})
+	})
+}
@@ -11,11 +11,18 @@ import (
 	"github.com/aporeto-inc/trireme-lib/enforcer/acls"
 	"github.com/aporeto-inc/trireme-lib/enforcer/lookup"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/usertokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 )
 
 type policies struct {
+	groups   []*ruleGroup // Rules by increasing priority
+	verdicts *cache.Cache // Verdicts of the searches indexed by tags
+}
+
+// ruleGroup holds the rules of a priority
+type ruleGroup struct {
 	observeRejectRules *lookup.PolicyDB // Packet: Continue       Report:    Drop
 	rejectRules        *lookup.PolicyDB // Packet:     Drop       Report:    Drop
 	observeAcceptRules *lookup.PolicyDB // Packet: Continue       Report: Forward
@@ -23,6 +30,28 @@ type policies struct {
 	observeApplyRules  *lookup.PolicyDB // Packet:  Forward       Report: Forward
 }
 
+// httpRule is an HTTP rule with the database of the peers of its clause
+type httpRule struct {
+	peers *lookup.PolicyDB // nil if the rule selects all the peers
+	rule  policy.HTTPRule
+}
+
+// verdict is the result of a search of the rules
+type verdict struct {
+	report *policy.FlowPolicy
+	packet *policy.FlowPolicy
+}
+
+const (
+	// verdictCacheTimeout is the time a verdict is cached. The cache is replaced
+	// whenever the rules are updated.
+	verdictCacheTimeout = time.Minute
+)
+
+// sniCatchAllPolicy is the policy of the TLS connections that no SNI rule
+// matches, like the default policy of the ACLs
+var sniCatchAllPolicy = &policy.FlowPolicy{Action: policy.Reject, PolicyID: "default", ServiceID: "default"}
+
 // PUContext holds data indexed by the PU ID
 type PUContext struct {
 	id                string
@@ -33,7 +62,9 @@ type PUContext struct {
 	rcv               *policies
 	applicationACLs   *acls.ACLCache
 	networkACLs       *acls.ACLCache
-	externalIPCache   cache.DataStore
+	udpAppACLs        *acls.ACLCache
+	udpNetACLs        *acls.ACLCache
+	externalIPCache   *cache.Cache
 	mark              string
 	ProxyPort         string
 	ports             []string
@@ -41,6 +72,12 @@ type PUContext struct {
 	synToken          []byte
 	synServiceContext []byte
 	synExpiration     time.Time
+	userToken         string
+	userVerifier      usertokens.Verifier
+	identityClaims    map[string]string
+	proxiedServices   *policy.ProxiedServicesInfo
+	httpRules         []*httpRule
+	sniRules          policy.SNIRuleList
 	Extension         interface{}
 	sync.RWMutex
 }
@@ -57,13 +94,29 @@ func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUC
 		externalIPCache: cache.NewCacheWithExpiration("External IP Cache", timeout),
 		applicationACLs: acls.NewACLCache(),
 		networkACLs:     acls.NewACLCache(),
+		udpAppACLs:      acls.NewProtocolACLCache("udp"),
+		udpNetACLs:      acls.NewProtocolACLCache("udp"),
 		mark:            puInfo.Runtime.Options().CgroupMark,
+		userToken:       puInfo.Runtime.Options().UserToken,
+		identityClaims:  puInfo.Policy.IdentityClaims(),
+		proxiedServices: puInfo.Policy.ProxiedServices(),
+		sniRules:        puInfo.Policy.SNIRules(),
+	}
+
+	if authorization := puInfo.Policy.UserAuthorization(); authorization != nil {
+		verifier, err := usertokens.NewVerifier(authorization)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user authorization: %s", err)
+		}
+		pu.userVerifier = verifier
 	}
 
 	pu.CreateRcvRules(puInfo.Policy.ReceiverRules())
 
 	pu.CreateTxtRules(puInfo.Policy.TransmitterRules())
 
+	pu.CreateHTTPRules(puInfo.Policy.HTTPRules())
+
 	ports := policy.ConvertServicesToPortList(puInfo.Runtime.Options().Services)
 	pu.ports = strings.Split(ports, ",")
 
@@ -75,6 +128,14 @@ func NewPU(contextID string, puInfo *policy.PUInfo, timeout time.Duration) (*PUC
 		return nil, err
 	}
 
+	if err := pu.udpAppACLs.AddRuleList(puInfo.Policy.ApplicationACLs()); err != nil {
+		return nil, err
+	}
+
+	if err := pu.udpNetACLs.AddRuleList(puInfo.Policy.NetworkACLs()); err != nil {
+		return nil, err
+	}
+
 	return pu, nil
 
 }
@@ -114,11 +175,43 @@ func (p *PUContext) Annotations() *policy.TagStore {
 	return p.annotations
 }
 
+// UserToken returns the token of the user of the PU
+func (p *PUContext) UserToken() string {
+	return p.userToken
+}
+
+// UserVerifier returns the verifier of the user tokens of the peers. It is
+// nil if the peers do not need a user token.
+func (p *PUContext) UserVerifier() usertokens.Verifier {
+	return p.userVerifier
+}
+
+// IdentityClaims returns the claims of the application that are added to the
+// identity tokens of the PU
+func (p *PUContext) IdentityClaims() map[string]string {
+	return p.identityClaims
+}
+
+// ProxiedServices returns the services of the PU that are proxied
+func (p *PUContext) ProxiedServices() *policy.ProxiedServicesInfo {
+	return p.proxiedServices
+}
+
 // RetrieveCachedExternalFlowPolicy returns the policy for an external IP
 func (p *PUContext) RetrieveCachedExternalFlowPolicy(id string) (interface{}, error) {
 	return p.externalIPCache.Get(id)
 }
 
+// SetExternalIPCacheLimit limits the number of external flows cached for the PU
+func (p *PUContext) SetExternalIPCacheLimit(maxEntries int, evictionPolicy cache.EvictionPolicy) {
+	p.externalIPCache.SetLimit(maxEntries, evictionPolicy)
+}
+
+// ExternalIPCacheStats returns the counters of the external flows cached for the PU
+func (p *PUContext) ExternalIPCacheStats() cache.Stats {
+	return p.externalIPCache.Stats()
+}
+
 // NetworkACLPolicy retrieves the policy based on ACLs
 func (p *PUContext) NetworkACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
 	return p.networkACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.DestinationPort)
@@ -129,6 +222,34 @@ func (p *PUContext) ApplicationACLPolicy(packet *packet.Packet) (report *policy.
 	return p.applicationACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.SourcePort)
 }
 
+// HasSNIRules returns true if the TLS connections to the port are authorized
+// by the server names of SNI rules
+func (p *PUContext) HasSNIRules(port uint16) bool {
+	return p.sniRules.HasPort(port)
+}
+
+// SNIPolicy retrieves the policy of a TLS connection to an external service
+// based on the server name of its ClientHello. It returns the default reject
+// policy and an error if no SNI rule matches.
+func (p *PUContext) SNIPolicy(port uint16, serverName string) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+
+	if rule := p.sniRules.Search(port, serverName); rule != nil {
+		return rule.Policy, rule.Policy, nil
+	}
+
+	return sniCatchAllPolicy, sniCatchAllPolicy, fmt.Errorf("no sni rule for %s", serverName)
+}
+
+// NetworkUDPACLPolicy retrieves the policy of a datagram from the network based on ACLs
+func (p *PUContext) NetworkUDPACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+	return p.udpNetACLs.GetMatchingAction(packet.SourceAddress.To4(), packet.DestinationPort)
+}
+
+// ApplicationUDPACLPolicy retrieves the policy of a datagram from the application based on ACLs
+func (p *PUContext) ApplicationUDPACLPolicy(packet *packet.Packet) (report *policy.FlowPolicy, action *policy.FlowPolicy, err error) {
+	return p.udpAppACLs.GetMatchingAction(packet.DestinationAddress.To4(), packet.DestinationPort)
+}
+
 // CacheExternalFlowPolicy will cache an external flow
 func (p *PUContext) CacheExternalFlowPolicy(packet *packet.Packet, plc interface{}) {
 	p.externalIPCache.AddOrUpdate(packet.SourceAddress.String()+":"+strconv.Itoa(int(packet.SourcePort)), plc)
@@ -184,29 +305,39 @@ func (p *PUContext) UpdateCachedTokenAndServiceContext(token []byte, serviceCont
 func (p *PUContext) createRuleDBs(policyRules policy.TagSelectorList) *policies {
 
 	policyDB := &policies{
-		rejectRules:        lookup.NewPolicyDB(),
-		observeRejectRules: lookup.NewPolicyDB(),
-		acceptRules:        lookup.NewPolicyDB(),
-		observeAcceptRules: lookup.NewPolicyDB(),
-		observeApplyRules:  lookup.NewPolicyDB(),
+		groups:   []*ruleGroup{},
+		verdicts: cache.NewCacheWithExpiration("Policy Verdict Cache", verdictCacheTimeout),
 	}
 
-	for _, rule := range policyRules {
-		if rule.Policy.ObserveAction.ObserveContinue() {
-			if rule.Policy.Action.Accepted() {
-				policyDB.observeAcceptRules.AddPolicy(rule)
+	for _, priority := range policyRules.Priorities() {
+
+		group := &ruleGroup{
+			rejectRules:        lookup.NewPolicyDB(),
+			observeRejectRules: lookup.NewPolicyDB(),
+			acceptRules:        lookup.NewPolicyDB(),
+			observeAcceptRules: lookup.NewPolicyDB(),
+			observeApplyRules:  lookup.NewPolicyDB(),
+		}
+
+		for _, rule := range policyRules.WithPriority(priority) {
+			if rule.Policy.ObserveAction.ObserveContinue() {
+				if rule.Policy.Action.Accepted() {
+					group.observeAcceptRules.AddPolicy(rule)
+				} else if rule.Policy.Action.Rejected() {
+					group.observeRejectRules.AddPolicy(rule)
+				}
+			} else if rule.Policy.ObserveAction.ObserveApply() {
+				group.observeApplyRules.AddPolicy(rule)
+			} else if rule.Policy.Action.Accepted() {
+				group.acceptRules.AddPolicy(rule)
 			} else if rule.Policy.Action.Rejected() {
-				policyDB.observeRejectRules.AddPolicy(rule)
+				group.rejectRules.AddPolicy(rule)
+			} else {
+				continue
 			}
-		} else if rule.Policy.ObserveAction.ObserveApply() {
-			policyDB.observeApplyRules.AddPolicy(rule)
-		} else if rule.Policy.Action.Accepted() {
-			policyDB.acceptRules.AddPolicy(rule)
-		} else if rule.Policy.Action.Rejected() {
-			policyDB.rejectRules.AddPolicy(rule)
-		} else {
-			continue
 		}
+
+		policyDB.groups = append(policyDB.groups, group)
 	}
 
 	return policyDB
@@ -222,25 +353,125 @@ func (p *PUContext) CreateTxtRules(policyRules policy.TagSelectorList) {
 	p.txt = p.createRuleDBs(policyRules)
 }
 
-// searchRules searches all reject, accpet and observed rules and returns reporting and packet forwarding action
+// CreateHTTPRules creates the HTTP rules of this PU based on the update of the policy.
+func (p *PUContext) CreateHTTPRules(rules policy.HTTPRuleList) {
+
+	p.httpRules = make([]*httpRule, 0, len(rules))
+
+	for _, rule := range rules {
+		r := &httpRule{rule: rule}
+		if len(rule.Clause) > 0 {
+			r.peers = lookup.NewPolicyDB()
+			r.peers.AddPolicy(policy.TagSelector{
+				Clause: rule.Clause,
+				Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: rule.PolicyID},
+			})
+		}
+		p.httpRules = append(p.httpRules, r)
+	}
+}
+
+// HasHTTPRules returns true if the HTTP requests of the proxied connections
+// of the PU are authorized by HTTP rules
+func (p *PUContext) HasHTTPRules() bool {
+	return len(p.httpRules) > 0
+}
+
+// SearchHTTPRules returns the HTTP rules that apply to the peer with the tags,
+// which include the identity of the peer and the destination port
+func (p *PUContext) SearchHTTPRules(tags *policy.TagStore) policy.HTTPRuleList {
+
+	rules := policy.HTTPRuleList{}
+
+	for _, r := range p.httpRules {
+		if r.peers != nil {
+			if index, _ := r.peers.Search(tags); index < 0 {
+				continue
+			}
+		}
+		rules = append(rules, r.rule)
+	}
+
+	return rules
+}
+
+// searchRules returns the cached verdict for the tags, which include the
+// identity of the remote and the destination port, or searches the rules.
 func (p *PUContext) searchRules(
 	policies *policies,
 	tags *policy.TagStore,
 	skipRejectPolicies bool,
 ) (report *policy.FlowPolicy, packet *policy.FlowPolicy) {
 
+	key := strconv.FormatBool(skipRejectPolicies) + "\x00" + strings.Join(tags.GetSlice(), "\x00")
+
+	if cached, err := policies.verdicts.Get(key); err == nil {
+		v := cached.(*verdict)
+		return v.report, v.packet
+	}
+
+	report, packet = p.matchRules(policies, tags, skipRejectPolicies)
+
+	policies.verdicts.AddOrUpdate(key, &verdict{
+		report: report,
+		packet: packet,
+	})
+
+	return report, packet
+}
+
+// matchRules searches the rules by increasing priority and returns reporting and packet forwarding action
+func (p *PUContext) matchRules(
+	policies *policies,
+	tags *policy.TagStore,
+	skipRejectPolicies bool,
+) (report *policy.FlowPolicy, packet *policy.FlowPolicy) {
+
 	var reportingAction *policy.FlowPolicy
 	var packetAction *policy.FlowPolicy
 
+	for _, group := range policies.groups {
+		reportingAction, packetAction = group.matchRules(tags, skipRejectPolicies, reportingAction)
+		if packetAction != nil {
+			return reportingAction, packetAction
+		}
+	}
+
+	// Handle default if nothing provides to drop with no policyID.
+	packetAction = &policy.FlowPolicy{
+		Action:   policy.Reject,
+		PolicyID: "",
+	}
+
+	if reportingAction == nil {
+		reportingAction = packetAction
+	}
+
+	return reportingAction, packetAction
+}
+
+// matchRules searches all reject, accpet and observed rules of a priority and
+// returns reporting and packet forwarding action. The packet action is nil if
+// no rule decides it. The reporting action of the rules of previous priorities
+// is kept.
+func (g *ruleGroup) matchRules(
+	tags *policy.TagStore,
+	skipRejectPolicies bool,
+	report *policy.FlowPolicy,
+) (*policy.FlowPolicy, *policy.FlowPolicy) {
+
+	reportingAction := report
+	var packetAction *policy.FlowPolicy
+
 	if !skipRejectPolicies {
 		// Look for rejection rules
-		observeIndex, observeAction := policies.observeRejectRules.Search(tags)
-		if observeIndex >= 0 {
+		observeIndex, observeAction := g.observeRejectRules.Search(tags)
+		if observeIndex >= 0 && reportingAction == nil {
 			reportingAction = observeAction.(*policy.FlowPolicy)
 		}
 
 		if packetAction == nil {
-			index, action := policies.rejectRules.Search(tags)
+			index, action := g.rejectRules.Search(tags)
 			if index >= 0 {
 				packetAction = action.(*policy.FlowPolicy)
 				if reportingAction == nil {
@@ -253,14 +484,14 @@ func (p *PUContext) searchRules(
 
 	if reportingAction == nil {
 		// Look for allow rules
-		observeIndex, observeAction := policies.observeAcceptRules.Search(tags)
+		observeIndex, observeAction := g.observeAcceptRules.Search(tags)
 		if observeIndex >= 0 {
 			reportingAction = observeAction.(*policy.FlowPolicy)
 		}
 	}
 
 	if packetAction == nil {
-		index, action := policies.acceptRules.Search(tags)
+		index, action := g.acceptRules.Search(tags)
 		if index >= 0 {
 			packetAction = action.(*policy.FlowPolicy)
 			if reportingAction == nil {
@@ -271,7 +502,7 @@ func (p *PUContext) searchRules(
 	}
 
 	// Look for observe apply rules
-	observeIndex, observeAction := policies.observeApplyRules.Search(tags)
+	observeIndex, observeAction := g.observeApplyRules.Search(tags)
 	if observeIndex >= 0 {
 		packetAction = observeAction.(*policy.FlowPolicy)
 		if reportingAction == nil {
@@ -280,19 +511,7 @@ func (p *PUContext) searchRules(
 		return reportingAction, packetAction
 	}
 
-	// Handle default if nothing provides to drop with no policyID.
-	if packetAction == nil {
-		packetAction = &policy.FlowPolicy{
-			Action:   policy.Reject,
-			PolicyID: "",
-		}
-	}
-
-	if reportingAction == nil {
-		reportingAction = packetAction
-	}
-
-	return reportingAction, packetAction
+	return reportingAction, nil
 }
 
 // SearchTxtRules searches both receive and observed transmit rules and returns the index and action
@@ -0,0 +1,191 @@
+package pucontext
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+func rule(value string, action policy.ActionType, policyID string) policy.TagSelector {
+	return policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      "app",
+				Value:    []string{value},
+				Operator: policy.Equal,
+			},
+		},
+		Policy: &policy.FlowPolicy{
+			Action:   action,
+			PolicyID: policyID,
+		},
+	}
+}
+
+func TestSearchRcvRules(t *testing.T) {
+
+	Convey("Given a PU with a receive rule", t, func() {
+
+		puInfo := policy.NewPUInfo("pu1", constants.LinuxProcessPU)
+		puInfo.Policy.AddReceiverRules(rule("web", policy.Accept, "accept-web"))
+
+		pu, err := NewPU("pu1", puInfo, time.Second)
+		So(err, ShouldBeNil)
+
+		tags := policy.NewTagStore()
+		tags.AppendKeyValue("app", "web")
+		tags.AppendKeyValue("@port", "80")
+
+		Convey("When I search the rules for matching tags", func() {
+			_, packet := pu.SearchRcvRules(tags)
+
+			Convey("Then the flow should be accepted and the verdict cached", func() {
+				So(packet.Action.Accepted(), ShouldBeTrue)
+				So(packet.PolicyID, ShouldEqual, "accept-web")
+				So(pu.rcv.verdicts.SizeOf(), ShouldEqual, 1)
+			})
+
+			Convey("Then the same search should return the cached verdict", func() {
+				_, cached := pu.SearchRcvRules(tags)
+				So(cached, ShouldEqual, packet)
+				So(pu.rcv.verdicts.SizeOf(), ShouldEqual, 1)
+			})
+
+			Convey("Then a search for another port should not use the cached verdict", func() {
+				other := policy.NewTagStore()
+				other.AppendKeyValue("app", "web")
+				other.AppendKeyValue("@port", "443")
+				pu.SearchRcvRules(other)
+				So(pu.rcv.verdicts.SizeOf(), ShouldEqual, 2)
+			})
+
+			Convey("Then the verdict should be invalidated when the rules are updated", func() {
+				pu.CreateRcvRules(policy.TagSelectorList{rule("web", policy.Reject, "reject-web")})
+				_, updated := pu.SearchRcvRules(tags)
+				So(updated.Action.Rejected(), ShouldBeTrue)
+				So(updated.PolicyID, ShouldEqual, "reject-web")
+			})
+		})
+	})
+}
+
+func TestSearchRulesPriorities(t *testing.T) {
+
+	Convey("Given a PU with receive rules of different priorities", t, func() {
+
+		accept := rule("web", policy.Accept, "accept-web")
+		accept.Priority = -1
+
+		puInfo := policy.NewPUInfo("pu1", constants.LinuxProcessPU)
+		puInfo.Policy.AddReceiverRules(rule("web", policy.Reject, "reject-web"))
+		puInfo.Policy.AddReceiverRules(accept)
+
+		pu, err := NewPU("pu1", puInfo, time.Second)
+		So(err, ShouldBeNil)
+
+		Convey("When I search the rules for tags matched by both rules", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+
+			report, packet := pu.SearchRcvRules(tags)
+
+			Convey("Then the rule with the lowest priority should decide the action", func() {
+				So(packet.Action.Accepted(), ShouldBeTrue)
+				So(packet.PolicyID, ShouldEqual, "accept-web")
+				So(report, ShouldEqual, packet)
+			})
+		})
+	})
+}
+
+func TestSearchRulesObserveOnly(t *testing.T) {
+
+	Convey("Given a PU with an observe only policy", t, func() {
+
+		puInfo := policy.NewPUInfo("pu1", constants.LinuxProcessPU)
+		puInfo.Policy.AddReceiverRules(rule("web", policy.Accept, "accept-web"))
+		puInfo.Policy.AddReceiverRules(rule("db", policy.Reject, "reject-db"))
+		puInfo.Policy = puInfo.Policy.ObserveOnly()
+
+		pu, err := NewPU("pu1", puInfo, time.Second)
+		So(err, ShouldBeNil)
+
+		search := func(app string) (*policy.FlowPolicy, *policy.FlowPolicy) {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", app)
+			return pu.SearchRcvRules(tags)
+		}
+
+		Convey("The accepted flows should be accepted", func() {
+			report, packet := search("web")
+			So(report.PolicyID, ShouldEqual, "accept-web")
+			So(packet.Action.Accepted(), ShouldBeTrue)
+		})
+
+		Convey("The rejected flows should be reported as rejected and accepted", func() {
+			report, packet := search("db")
+			So(report.PolicyID, ShouldEqual, "reject-db")
+			So(report.Action.Rejected(), ShouldBeTrue)
+			So(packet.Action.Accepted(), ShouldBeTrue)
+		})
+
+		Convey("The flows of no rule should be reported as rejected by the default policy and accepted", func() {
+			report, packet := search("cache")
+			So(report.PolicyID, ShouldEqual, "default")
+			So(report.Action.Rejected(), ShouldBeTrue)
+			So(packet.Action.Accepted(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestSearchHTTPRules(t *testing.T) {
+
+	Convey("Given a PU with HTTP rules for some peers", t, func() {
+
+		puInfo := policy.NewPUInfo("pu1", constants.LinuxProcessPU)
+		err := puInfo.Policy.SetHTTPRules(policy.HTTPRuleList{
+			policy.HTTPRule{
+				Clause:   rule("web", policy.Accept, "").Clause,
+				Methods:  []string{"POST"},
+				PolicyID: "write-web",
+			},
+			policy.HTTPRule{
+				Methods:  []string{"GET"},
+				PolicyID: "read-all",
+			},
+		})
+		So(err, ShouldBeNil)
+
+		pu, err := NewPU("pu1", puInfo, time.Second)
+		So(err, ShouldBeNil)
+		So(pu.HasHTTPRules(), ShouldBeTrue)
+
+		Convey("The rules of the peers selected by their clause should apply", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "web")
+
+			rules := pu.SearchHTTPRules(tags)
+			So(rules, ShouldHaveLength, 2)
+			So(rules.Search("POST", "/", "").PolicyID, ShouldEqual, "write-web")
+		})
+
+		Convey("Only the rules without a clause should apply to the other peers", func() {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("app", "db")
+
+			rules := pu.SearchHTTPRules(tags)
+			So(rules, ShouldHaveLength, 1)
+			So(rules.Search("POST", "/", ""), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a PU without HTTP rules, its requests should not be inspected", t, func() {
+		pu, err := NewPU("pu1", policy.NewPUInfo("pu1", constants.LinuxProcessPU), time.Second)
+		So(err, ShouldBeNil)
+		So(pu.HasHTTPRules(), ShouldBeFalse)
+	})
+}
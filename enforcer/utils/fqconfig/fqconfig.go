@@ -1,6 +1,12 @@
 package fqconfig
 
-import "strconv"
+import (
+	"runtime"
+	"strconv"
+)
+
+// numCPU returns the number of CPUs the default queues are sized for
+var numCPU = runtime.NumCPU
 
 // FilterQueue captures all the configuration parameters of the NFQUEUEs
 type FilterQueue struct {
@@ -38,19 +44,36 @@ type FilterQueue struct {
 	ApplicationQueuesSynAckStr string
 }
 
-// NewFilterQueueWithDefaults return a default filter queue config
+// NewFilterQueueWithDefaults return a default filter queue config. The number
+// of queues is sized for the CPUs of the host.
 func NewFilterQueueWithDefaults() *FilterQueue {
 	return NewFilterQueue(
 		DefaultQueueSeperation,
 		DefaultMarkValue,
 		DefaultQueueStart,
-		DefaultNumberOfQueues,
-		DefaultNumberOfQueues,
+		AutoNumberOfQueues(),
+		AutoNumberOfQueues(),
 		DefaultQueueSize,
 		DefaultQueueSize,
 	)
 }
 
+// AutoNumberOfQueues returns the number of queues per packet type for the CPUs
+// of the host, between 1 and MaxNumberOfQueues
+func AutoNumberOfQueues() uint16 {
+
+	cpus := numCPU()
+	if cpus < 1 {
+		return 1
+	}
+
+	if cpus > MaxNumberOfQueues {
+		return MaxNumberOfQueues
+	}
+
+	return uint16(cpus)
+}
+
 // NewFilterQueue returns an instance of FilterQueue
 func NewFilterQueue(queueSeparation bool, MarkValue int, QueueStart, NumberOfNetworkQueues, NumberOfApplicationQueues uint16, NetworkQueueSize, ApplicationQueueSize uint32) *FilterQueue {
 
@@ -96,6 +119,22 @@ func NewFilterQueue(queueSeparation bool, MarkValue int, QueueStart, NumberOfNet
 	return fq
 }
 
+// Resize returns a copy of the configuration with the given number of network
+// and application queues per packet type. The queues still start at the first
+// application queue and keep their size.
+func (f *FilterQueue) Resize(NumberOfNetworkQueues, NumberOfApplicationQueues uint16) *FilterQueue {
+
+	return NewFilterQueue(
+		f.QueueSeparation,
+		f.MarkValue,
+		f.ApplicationQueue,
+		NumberOfNetworkQueues,
+		NumberOfApplicationQueues,
+		f.NetworkQueueSize,
+		f.ApplicationQueueSize,
+	)
+}
+
 // GetMarkValue returns a mark value to be used by iptables action
 func (f *FilterQueue) GetMarkValue() int {
 	return f.MarkValue
@@ -180,7 +219,11 @@ const (
 	// DefaultQueueSeperation specifies if we should use separate queues for packet types
 	DefaultQueueSeperation = true
 	// DefaultNumberOfQueues  is the default number of queues used in NFQUEUE
+	// when they are not sized for the CPUs of the host
 	DefaultNumberOfQueues = 4
+	// MaxNumberOfQueues is the maximum number of queues per packet type sized
+	// for the CPUs of the host
+	MaxNumberOfQueues = 8
 	// DefaultQueueStart represents the queue number to start
 	DefaultQueueStart = 0
 	// DefaultQueueSize is the size of the queues
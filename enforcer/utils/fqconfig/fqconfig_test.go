@@ -1,6 +1,7 @@
 package fqconfig
 
 import (
+	"runtime"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -8,7 +9,10 @@ import (
 
 func TestFqDefaultConfig(t *testing.T) {
 
-	Convey("Given I create a new default filter queue config", t, func() {
+	Convey("Given I create a new default filter queue config on a host with 4 CPUs", t, func() {
+		numCPU = func() int { return 4 }
+		defer func() { numCPU = runtime.NumCPU }()
+
 		fqc := NewFilterQueueWithDefaults()
 		Convey("Then I should see a config", func() {
 
@@ -17,7 +21,7 @@ func TestFqDefaultConfig(t *testing.T) {
 			So(fqc.GetMarkValue(), ShouldEqual, DefaultMarkValue)
 
 			So(fqc.GetApplicationQueueSize(), ShouldEqual, DefaultQueueSize)
-			So(fqc.GetNumApplicationQueues(), ShouldEqual, DefaultNumberOfQueues*4)
+			So(fqc.GetNumApplicationQueues(), ShouldEqual, 4*4)
 			So(fqc.GetApplicationQueueStart(), ShouldEqual, 0)
 			So(fqc.GetApplicationQueueSynStr(), ShouldEqual, "0:3")
 			So(fqc.GetApplicationQueueAckStr(), ShouldEqual, "4:7")
@@ -25,7 +29,7 @@ func TestFqDefaultConfig(t *testing.T) {
 			So(fqc.GetApplicationQueueSvcStr(), ShouldEqual, "12:15")
 
 			So(fqc.GetNetworkQueueSize(), ShouldEqual, DefaultQueueSize)
-			So(fqc.GetNumNetworkQueues(), ShouldEqual, DefaultNumberOfQueues*4)
+			So(fqc.GetNumNetworkQueues(), ShouldEqual, 4*4)
 			So(fqc.GetNetworkQueueStart(), ShouldEqual, fqc.GetNumApplicationQueues())
 			So(fqc.GetNetworkQueueSynStr(), ShouldEqual, "16:19")
 			So(fqc.GetNetworkQueueAckStr(), ShouldEqual, "20:23")
@@ -34,3 +38,49 @@ func TestFqDefaultConfig(t *testing.T) {
 		})
 	})
 }
+
+func TestAutoNumberOfQueues(t *testing.T) {
+
+	Convey("Given hosts with different numbers of CPUs", t, func() {
+		defer func() { numCPU = runtime.NumCPU }()
+
+		Convey("Then a host with 2 CPUs should have 2 queues per packet type", func() {
+			numCPU = func() int { return 2 }
+			So(AutoNumberOfQueues(), ShouldEqual, 2)
+		})
+
+		Convey("Then a host with many CPUs should have the maximum number of queues", func() {
+			numCPU = func() int { return 64 }
+			So(AutoNumberOfQueues(), ShouldEqual, MaxNumberOfQueues)
+		})
+	})
+}
+
+func TestFqResize(t *testing.T) {
+
+	Convey("Given a filter queue config", t, func() {
+		fqc := NewFilterQueue(true, DefaultMarkValue, 10, 4, 4, 1000, 2000)
+
+		Convey("When I resize it", func() {
+			resized := fqc.Resize(2, 1)
+
+			Convey("Then the queues should be balanced over the new numbers of queues", func() {
+				So(resized.GetMarkValue(), ShouldEqual, DefaultMarkValue)
+				So(resized.GetApplicationQueueStart(), ShouldEqual, 10)
+				So(resized.GetNumApplicationQueues(), ShouldEqual, 4)
+				So(resized.GetApplicationQueueSynStr(), ShouldEqual, "10:10")
+				So(resized.GetApplicationQueueSize(), ShouldEqual, 2000)
+				So(resized.GetNetworkQueueStart(), ShouldEqual, 14)
+				So(resized.GetNumNetworkQueues(), ShouldEqual, 8)
+				So(resized.GetNetworkQueueSynStr(), ShouldEqual, "14:15")
+				So(resized.GetNetworkQueueSvcStr(), ShouldEqual, "20:21")
+				So(resized.GetNetworkQueueSize(), ShouldEqual, 1000)
+			})
+
+			Convey("Then the original config should not change", func() {
+				So(fqc.GetNumApplicationQueues(), ShouldEqual, 16)
+				So(fqc.GetApplicationQueueSynStr(), ShouldEqual, "10:13")
+			})
+		})
+	})
+}
@@ -0,0 +1,178 @@
+// Package jwtsigning selects the JWT signing methods of the keys of the PKI
+// secrets and provides the EdDSA signing method of the Ed25519 keys, and the
+// signing methods of the keys that are only available as a crypto.Signer,
+// like the keys of an HSM.
+package jwtsigning
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SigningMethodEdDSA signs the tokens with Ed25519 keys
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+// ValidMethods are the signing methods accepted in the tokens signed with PKI
+// secrets. The method of a token is the one of the key of its issuer.
+var ValidMethods = []string{
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodES384.Alg(),
+	SigningMethodEdDSA.Alg(),
+}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// ForKey returns the signing method of a private or a public key. The
+// supported keys are the ECDSA keys on the P-256 and P-384 curves and the
+// Ed25519 keys. The private keys can also be a crypto.Signer of one of these
+// keys, whose tokens are signed with the same algorithms.
+func ForKey(key interface{}) (jwt.SigningMethod, error) {
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return forCurve(k.Curve)
+	case *ecdsa.PublicKey:
+		return forCurve(k.Curve)
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return SigningMethodEdDSA, nil
+	case crypto.Signer:
+		return forSigner(k)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", key)
+	}
+}
+
+// forSigner returns the signing method of the key of a signer
+func forSigner(signer crypto.Signer) (jwt.SigningMethod, error) {
+
+	method, err := ForKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	m := &signingMethodSigner{SigningMethod: method}
+
+	switch method {
+	case jwt.SigningMethodES256:
+		m.hash, m.size = crypto.SHA256, 32
+	case jwt.SigningMethodES384:
+		m.hash, m.size = crypto.SHA384, 48
+	}
+
+	return m, nil
+}
+
+// forCurve returns the signing method of the ECDSA keys of a curve
+func forCurve(curve elliptic.Curve) (jwt.SigningMethod, error) {
+
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	default:
+		return nil, fmt.Errorf("unsupported curve: %s", curve.Params().Name)
+	}
+}
+
+// signingMethodEdDSA implements jwt.SigningMethod for Ed25519 keys
+type signingMethodEdDSA struct{}
+
+// Alg implements jwt.SigningMethod
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify implements jwt.SigningMethod
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+
+	return nil
+}
+
+// Sign implements jwt.SigningMethod
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// signingMethodSigner signs the tokens with a crypto.Signer. The tokens are
+// verified with the method of the public key of the signer.
+type signingMethodSigner struct {
+	jwt.SigningMethod
+	// hash is the hash of the ECDSA signatures, and zero for Ed25519
+	hash crypto.Hash
+	// size is the size of the integers of the ECDSA signatures
+	size int
+}
+
+// Sign implements jwt.SigningMethod
+func (m *signingMethodSigner) Sign(signingString string, key interface{}) (string, error) {
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	digest := []byte(signingString)
+	if m.hash != 0 {
+		hasher := m.hash.New()
+		hasher.Write(digest) // nolint
+		digest = hasher.Sum(nil)
+	}
+
+	sig, err := signer.Sign(rand.Reader, digest, m.hash)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign token: %s", err)
+	}
+
+	if m.hash == 0 {
+		return jwt.EncodeSegment(sig), nil
+	}
+
+	// The ECDSA signers return ASN.1 signatures, and the JWS signatures are
+	// the concatenation of the integers
+	var esig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+		return "", fmt.Errorf("invalid ecdsa signature: %s", err)
+	}
+
+	out := make([]byte, 2*m.size)
+	esig.R.FillBytes(out[:m.size])
+	esig.S.FillBytes(out[m.size:])
+
+	return jwt.EncodeSegment(out), nil
+}
@@ -0,0 +1,146 @@
+package jwtsigning
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestForKey(t *testing.T) {
+	Convey("When I get the signing methods of keys", t, func() {
+		p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader) // nolint
+		p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader) // nolint
+		public, private, _ := ed25519.GenerateKey(rand.Reader)     // nolint
+
+		Convey("They should be the ones of their type and curve", func() {
+			for key, method := range map[interface{}]jwt.SigningMethod{
+				p256:            jwt.SigningMethodES256,
+				&p256.PublicKey: jwt.SigningMethodES256,
+				p384:            jwt.SigningMethodES384,
+				&p384.PublicKey: jwt.SigningMethodES384,
+			} {
+				m, err := ForKey(key)
+				So(err, ShouldBeNil)
+				So(m, ShouldEqual, method)
+			}
+
+			m, err := ForKey(private)
+			So(err, ShouldBeNil)
+			So(m, ShouldEqual, SigningMethodEdDSA)
+
+			m, err = ForKey(public)
+			So(err, ShouldBeNil)
+			So(m, ShouldEqual, SigningMethodEdDSA)
+		})
+
+		Convey("The other keys should not be supported", func() {
+			_, err := ForKey(p521)
+			So(err, ShouldNotBeNil)
+
+			_, err = ForKey([]byte("psk"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSigningMethodEdDSA(t *testing.T) {
+	Convey("Given an Ed25519 key", t, func() {
+		public, private, _ := ed25519.GenerateKey(rand.Reader) // nolint
+
+		Convey("When I sign a token, it should be verified with the public key", func() {
+			token, err := jwt.NewWithClaims(SigningMethodEdDSA, jwt.StandardClaims{Issuer: "trireme"}).SignedString(private)
+			So(err, ShouldBeNil)
+
+			parsed, err := (&jwt.Parser{ValidMethods: ValidMethods}).Parse(token, func(*jwt.Token) (interface{}, error) {
+				return public, nil
+			})
+			So(err, ShouldBeNil)
+			So(parsed.Valid, ShouldBeTrue)
+			So(parsed.Method, ShouldEqual, SigningMethodEdDSA)
+
+			Convey("It should not be verified with another key", func() {
+				other, _, _ := ed25519.GenerateKey(rand.Reader) // nolint
+				_, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+					return other, nil
+				})
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("It should not be verified with an ECDSA key", func() {
+				key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+				_, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+					return &key.PublicKey, nil
+				})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I sign a token with an ECDSA key, it should fail", func() {
+			key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+			_, err := jwt.NewWithClaims(SigningMethodEdDSA, jwt.StandardClaims{}).SignedString(key)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// testSigner hides the type of a key, like the signer of a key in an HSM
+type testSigner struct {
+	key crypto.Signer
+}
+
+func (s *testSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *testSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestSigningMethodSigner(t *testing.T) {
+	Convey("Given signers of P-256, P-384 and Ed25519 keys", t, func() {
+		p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader) // nolint
+		_, ed, _ := ed25519.GenerateKey(rand.Reader)               // nolint
+
+		Convey("When I sign tokens, they should be verified with the methods of the public keys", func() {
+			for _, tc := range []struct {
+				key crypto.Signer
+				alg string
+			}{
+				{key: p256, alg: jwt.SigningMethodES256.Alg()},
+				{key: p384, alg: jwt.SigningMethodES384.Alg()},
+				{key: ed, alg: SigningMethodEdDSA.Alg()},
+			} {
+				key := tc.key
+				signer := &testSigner{key: key}
+
+				method, err := ForKey(signer)
+				So(err, ShouldBeNil)
+				So(method.Alg(), ShouldEqual, tc.alg)
+
+				token, err := jwt.NewWithClaims(method, jwt.StandardClaims{Issuer: "trireme"}).SignedString(signer)
+				So(err, ShouldBeNil)
+
+				parsed, err := (&jwt.Parser{ValidMethods: ValidMethods}).Parse(token, func(*jwt.Token) (interface{}, error) {
+					return key.Public(), nil
+				})
+				So(err, ShouldBeNil)
+				So(parsed.Valid, ShouldBeTrue)
+			}
+		})
+
+		Convey("When I get the method of a signer of a P-521 key, it should fail", func() {
+			p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader) // nolint
+			_, err := ForKey(&testSigner{key: p521})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -3,13 +3,41 @@
 #define _GNU_SOURCE
 #include <errno.h>
 #include <fcntl.h>
+#include <linux/capability.h>
 #include <sched.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
 #include <sys/types.h>
 #include <sys/stat.h>
+#include <unistd.h>
 #define STRBUF_SIZE     128
+
+// drop_capabilities keeps only CAP_NET_ADMIN and CAP_NET_RAW. It is called
+// before the runtime of Go starts its threads, so that they all inherit the
+// capabilities, and the programs executed by the remote enforcer, like
+// iptables, cannot get the others back.
+static int drop_capabilities(void) {
+
+  int cap;
+  for (cap = 0; prctl(PR_CAPBSET_READ, cap, 0, 0, 0) >= 0; cap++) {
+    if (cap == CAP_NET_ADMIN || cap == CAP_NET_RAW) {
+      continue;
+    }
+    if (prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) < 0) {
+      return -1;
+    }
+  }
+
+  struct __user_cap_header_struct header = {_LINUX_CAPABILITY_VERSION_3, 0};
+  struct __user_cap_data_struct data[_LINUX_CAPABILITY_U32S_3];
+  memset(data, 0, sizeof(data));
+  data[0].effective = data[0].permitted = (1 << CAP_NET_ADMIN) | (1 << CAP_NET_RAW);
+
+  return syscall(SYS_capset, &header, data);
+}
 void nsexec(void) {
 
   int fd = 0;
@@ -53,5 +81,15 @@ void nsexec(void) {
   setenv("APORETO_ENV_NSENTER_LOGS",msg,1);
   if(retval < 0){
     setenv("APORET_ENV_NSENTER_ERROR_STATE",strerror(errno),1);
+    return;
+  }
+
+  // Drop the capabilities the remote enforcer does not need if it is hardened
+  if(getenv("APORETO_ENV_HARDENING") != NULL){
+    if(drop_capabilities() < 0){
+      snprintf(msg, sizeof(msg), "unable to drop capabilities: %s", strerror(errno));
+      setenv("APORETO_ENV_NSENTER_ERROR_STATE",strerror(errno),1);
+      setenv("APORETO_ENV_NSENTER_LOGS",msg,1);
+    }
   }
 }
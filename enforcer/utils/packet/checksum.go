@@ -0,0 +1,96 @@
+package packet
+
+import "encoding/binary"
+
+// ChecksumState is the state of the TCP checksum of a packet as it was
+// received from the queue, before any modification by the datapath.
+type ChecksumState int
+
+const (
+	// ChecksumUnknown indicates that the checksum was not verified yet
+	ChecksumUnknown ChecksumState = iota
+
+	// ChecksumValid indicates that the checksum covers the full segment
+	ChecksumValid
+
+	// ChecksumPartial indicates that the checksum computation was offloaded to
+	// the interface (virtio, veth, loopback) and the checksum field only holds
+	// the folded sum of the pseudo-header.
+	ChecksumPartial
+
+	// ChecksumInvalid indicates that the checksum is neither valid nor partial
+	// and the packet is corrupted.
+	ChecksumInvalid
+)
+
+// String returns a human readable representation of the checksum state
+func (s ChecksumState) String() string {
+
+	switch s {
+	case ChecksumValid:
+		return "valid"
+	case ChecksumPartial:
+		return "partial"
+	case ChecksumInvalid:
+		return "invalid"
+	default:
+		return "unknown"
+	}
+}
+
+// TCPChecksumState returns the state of the TCP checksum of the packet. The
+// state is computed on the first call and cached, so it must be called before
+// the datapath modifies the packet to reflect the checksum as received.
+func (p *Packet) TCPChecksumState() ChecksumState {
+
+	if p.tcpChecksumState != ChecksumUnknown {
+		return p.tcpChecksumState
+	}
+
+	switch p.TCPChecksum {
+	case p.computeTCPChecksum():
+		p.tcpChecksumState = ChecksumValid
+	case p.computeTCPPseudoHeaderSum():
+		p.tcpChecksumState = ChecksumPartial
+	default:
+		p.tcpChecksumState = ChecksumInvalid
+	}
+
+	return p.tcpChecksumState
+}
+
+// IsChecksumOffloaded returns true if the TCP checksum of the packet was left
+// for the interface to compute. These packets are valid even though the
+// checksum does not verify.
+func (p *Packet) IsChecksumOffloaded() bool {
+
+	return p.TCPChecksumState() == ChecksumPartial
+}
+
+// computeTCPPseudoHeaderSum computes the folded one's complement sum of the
+// TCP pseudo-header. This is the value the kernel stores in the checksum
+// field when the computation is offloaded. The packet is not modified.
+func (p *Packet) computeTCPPseudoHeaderSum() uint16 {
+
+	return checksumDelta(p.tcpPseudoHeader())
+}
+
+// tcpPseudoHeader returns the pseudo-header of the TCP segment, with the length
+// of the header, options and payload, including the ones that are detached.
+func (p *Packet) tcpPseudoHeader() []byte {
+
+	buf := make([]byte, 12)
+
+	// bytes 0-7: Source and Destination IP address
+	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
+	copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
+
+	// byte 9: Protocol
+	buf[9] = p.IPProto
+
+	// bytes 10,11: TCP length (header + options + payload)
+	tcpSize := uint16(len(p.Buffer)) - p.l4BeginPos + uint16(len(p.tcpOptions)+len(p.tcpData))
+	binary.BigEndian.PutUint16(buf[10:12], tcpSize)
+
+	return buf
+}
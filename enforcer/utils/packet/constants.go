@@ -4,10 +4,17 @@ const (
 	// minIPPacketLen is the min ip packet size
 	minIPPacketLen = 40
 
+	// minUDPPacketLen is the min ip packet size of a UDP datagram
+	minUDPPacketLen = 28
+
 	// minIPHdrSize
 	minIPHdrSize = 20
 
 	minIPHdrWords = (minIPHdrSize / 4)
+
+	// MaxIPPacketLen is the largest IP packet that can be stated in the header.
+	// Segments aggregated by GRO or GSO are queued up to this size.
+	MaxIPPacketLen = 0xffff
 )
 
 // IP Header field position constants
@@ -72,6 +79,18 @@ const (
 	TCPChecksumPos = 36
 )
 
+// UDP Header field position constants
+const (
+	// udpLengthPos is the location of the UDP length
+	udpLengthPos = 24
+
+	// UDPChecksumPos is the location of UDP checksum
+	UDPChecksumPos = 26
+
+	// udpDataPos is the location of the UDP payload
+	udpDataPos = 28
+)
+
 // TCP Header masks
 const (
 	// tcpDataOffsetMask is a mask for TCP data offset field
@@ -43,7 +43,9 @@ func (p *Packet) VerifyTCPChecksum() bool {
 }
 
 // UpdateTCPChecksum computes the TCP header checksum and updates the
-// packet with the value.
+// packet with the value. The full checksum is always computed, including for
+// packets received with an offloaded checksum, since the kernel does not
+// complete the checksum of packets modified through the queue.
 func (p *Packet) UpdateTCPChecksum() {
 
 	p.TCPChecksum = p.computeTCPChecksum()
@@ -89,42 +91,18 @@ func (p *Packet) computeIPChecksum() uint16 {
 	return sum
 }
 
-// Computes the TCP header checksum. The packet is not modified.
+// Computes the TCP header checksum. The packet is not modified. The segment
+// is summed in place, without the checksum field, so that segments aggregated
+// by GRO or GSO are not copied.
 func (p *Packet) computeTCPChecksum() uint16 {
 
-	var pseudoHeaderLen uint16 = 12
-	tcpSize := uint16(len(p.Buffer)) - p.l4BeginPos
-	bufLen := pseudoHeaderLen + tcpSize
-	buf := make([]byte, bufLen)
-
-	// Construct the pseudo-header for TCP checksum computation:
-
-	// bytes 0-3: Source IP address
-	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
-
-	// bytes 4-7: Destination IP address
-	copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
-
-	// byte 8: Constant zero
-	buf[8] = 0
-
-	// byte 9: Protocol (6==TCP)
-	buf[9] = 6
-
-	// bytes 10,11: TCP buffer size (real header + payload)
-	binary.BigEndian.PutUint16(buf[10:12], tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
-
-	// bytes 12+: The TCP buffer (real header + payload)
-	copy(buf[12:], p.Buffer[p.l4BeginPos:])
-
-	// Set current checksum to zero (in buf, not changing packet)
-	buf[pseudoHeaderLen+16] = 0
-	buf[pseudoHeaderLen+17] = 0
-
-	buf = append(buf, p.tcpOptions...)
-	buf = append(buf, p.tcpData...)
-
-	return checksum(buf)
+	return checksum(
+		p.tcpPseudoHeader(),
+		p.Buffer[p.l4BeginPos:TCPChecksumPos],
+		p.Buffer[TCPChecksumPos+2:],
+		p.tcpOptions,
+		p.tcpData,
+	)
 }
 
 // incCsum16 implements rfc1624, equation 3.
@@ -141,16 +119,26 @@ func incCsum16(start, old, new uint16) uint16 {
 	return uint16(csum)
 }
 
-// Computes a sum of 16 bit numbers
-func checksumDelta(buf []byte) uint16 {
-
-	sum := uint32(0)
-
-	for ; len(buf) >= 2; buf = buf[2:] {
-		sum += uint32(buf[0])<<8 | uint32(buf[1])
-	}
-	if len(buf) > 0 {
-		sum += uint32(buf[0]) << 8
+// Computes a sum of 16 bit numbers over the given slices, as if they were
+// contiguous.
+func checksumDelta(bufs ...[]byte) uint16 {
+
+	sum := uint64(0)
+	odd := false
+
+	for _, buf := range bufs {
+		if odd && len(buf) > 0 {
+			sum += uint64(buf[0])
+			buf = buf[1:]
+			odd = false
+		}
+		for ; len(buf) >= 2; buf = buf[2:] {
+			sum += uint64(buf[0])<<8 | uint64(buf[1])
+		}
+		if len(buf) > 0 {
+			sum += uint64(buf[0]) << 8
+			odd = true
+		}
 	}
 	for sum > 0xffff {
 		sum = (sum >> 16) + (sum & 0xffff)
@@ -158,10 +146,10 @@ func checksumDelta(buf []byte) uint16 {
 	return uint16(sum)
 }
 
-// Computes a checksum over the given slice.
-func checksum(buf []byte) uint16 {
+// Computes a checksum over the given slices.
+func checksum(bufs ...[]byte) uint16 {
 
-	sum := checksumDelta(buf)
+	sum := checksumDelta(bufs...)
 	csum := ^sum
 	return csum
 }
@@ -54,6 +54,16 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 
 	var p Packet
 
+	if len(bytes) < minIPHdrSize {
+		return nil, fmt.Errorf("ip packet too small: length=%d", len(bytes))
+	}
+
+	// Segments larger than the maximum IP packet length (BIG TCP) have a zero
+	// length in the header and cannot be represented
+	if len(bytes) > MaxIPPacketLen {
+		return nil, fmt.Errorf("ip packet larger than %d bytes not supported: length=%d", MaxIPPacketLen, len(bytes))
+	}
+
 	// Buffer Setup
 	p.Buffer = bytes
 
@@ -73,8 +83,13 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 	p.SourceAddress = net.IP(bytes[ipSourceAddrPos : ipSourceAddrPos+4])
 	p.DestinationAddress = net.IP(bytes[ipDestAddrPos : ipDestAddrPos+4])
 
+	minLength := uint16(minIPPacketLen)
+	if p.IPProto == IPProtocolUDP {
+		minLength = minUDPPacketLen
+	}
+
 	// Some sanity checking...
-	if p.IPTotalLength < minIPPacketLen {
+	if p.IPTotalLength < minLength {
 		return nil, fmt.Errorf("ip packet too small: hdrlen=%d", p.ipHeaderLen)
 	}
 
@@ -86,12 +101,23 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 		if p.IPTotalLength < uint16(len(p.Buffer)) {
 			p.Buffer = p.Buffer[:p.IPTotalLength]
 		} else {
+			// The copy range of the queue is smaller than the segment
 			return nil, fmt.Errorf("stated ip packet length %d differs from bytes available %d", p.IPTotalLength, len(p.Buffer))
 		}
 	}
 
-	// TCP Header Processing
 	p.l4BeginPos = minIPHdrSize
+	p.context = context
+
+	// UDP Header Processing
+	if p.IPProto == IPProtocolUDP {
+		p.SourcePort = binary.BigEndian.Uint16(bytes[tcpSourcePortPos : tcpSourcePortPos+2])
+		p.DestinationPort = binary.BigEndian.Uint16(bytes[tcpDestPortPos : tcpDestPortPos+2])
+		p.UDPChecksum = binary.BigEndian.Uint16(bytes[UDPChecksumPos : UDPChecksumPos+2])
+		return &p, nil
+	}
+
+	// TCP Header Processing
 	p.TCPChecksum = binary.BigEndian.Uint16(bytes[TCPChecksumPos : TCPChecksumPos+2])
 	p.SourcePort = binary.BigEndian.Uint16(bytes[tcpSourcePortPos : tcpSourcePortPos+2])
 	p.DestinationPort = binary.BigEndian.Uint16(bytes[tcpDestPortPos : tcpDestPortPos+2])
@@ -100,8 +126,6 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 	p.tcpDataOffset = (bytes[tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
 	p.TCPFlags = bytes[tcpFlagsOffsetPos]
 
-	p.context = context
-
 	return &p, nil
 }
 
@@ -380,6 +404,12 @@ func (p *Packet) tcpDataAttach(options []byte, data []byte) (err error) {
 // TCPDataAttach modifies the TCP and IP header fields and checksum
 func (p *Packet) TCPDataAttach(tcpOptions []byte, tcpData []byte) (err error) {
 
+	// Segments aggregated by GRO can be close to the maximum length
+	length := int(p.IPTotalLength) + len(tcpData) + len(tcpOptions)
+	if length > MaxIPPacketLen {
+		return fmt.Errorf("tcp segment too large to attach data: length=%d", length)
+	}
+
 	if err = p.tcpDataAttach(tcpOptions, tcpData); err != nil {
 		return fmt.Errorf("tcp data attachment failed: %s", err)
 	}
@@ -1,6 +1,9 @@
 package packet
 
-import "testing"
+import (
+	"encoding/binary"
+	"testing"
+)
 
 type SamplePacketName int
 
@@ -347,6 +350,12 @@ func TestRawChecksums(t *testing.T) {
 		t.Error("Second checksum calculation (odd bytes) failed")
 	}
 
+	// Slices are summed as if they were contiguous
+	c4 := checksum(buf2[:3], buf2[3:6], buf2[6:])
+	if c4 != 0x210E {
+		t.Error("Checksum calculation over odd slices failed")
+	}
+
 	var buf3 = []byte{0x45, 0x00, 0x00, 0x3c, 0x1c, 0x46, 0x40, 0x00, 0x40, 0x06,
 		0x00, 0x00, 0xac, 0x10, 0x0a, 0x63, 0xac, 0x10, 0x0a, 0x0c}
 	c3 := checksum(buf3)
@@ -375,3 +384,144 @@ func getTestPacketWithError(t *testing.T, id SamplePacketName) error {
 	_, err := New(0, tmp, "0")
 	return err
 }
+
+func TestChecksumStateOffloaded(t *testing.T) {
+
+	t.Parallel()
+	// Loopback and veth packets carry the pseudo-header sum only
+	pkt := getTestPacket(t, synBadTCPChecksum)
+
+	if pkt.TCPChecksumState() != ChecksumPartial {
+		t.Errorf("Expected partial checksum, got %s", pkt.TCPChecksumState())
+	}
+
+	if !pkt.IsChecksumOffloaded() {
+		t.Error("Expected packet checksum to be offloaded")
+	}
+
+	pkt.UpdateTCPChecksum()
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("TCP checksum is wrong after update of offloaded packet")
+	}
+
+	// The state reflects the packet as received
+	if pkt.TCPChecksumState() != ChecksumPartial {
+		t.Error("Checksum state must not change after update")
+	}
+}
+
+func TestChecksumStateValid(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+
+	if pkt.TCPChecksumState() != ChecksumValid {
+		t.Errorf("Expected valid checksum, got %s", pkt.TCPChecksumState())
+	}
+
+	if pkt.IsChecksumOffloaded() {
+		t.Error("Expected packet checksum not to be offloaded")
+	}
+}
+
+func TestChecksumStateInvalid(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+	pkt.TCPChecksum = pkt.TCPChecksum + 1
+
+	if pkt.TCPChecksumState() != ChecksumInvalid {
+		t.Errorf("Expected invalid checksum, got %s", pkt.TCPChecksumState())
+	}
+}
+
+func TestChecksumStateOffloadedWithPayload(t *testing.T) {
+
+	t.Parallel()
+	// Virtio packets with payload carry the pseudo-header sum over the
+	// full segment length
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+	if err := pkt.TCPDataAttach([]byte{}, []byte("offloaded payload")); err != nil {
+		t.Fatal(err)
+	}
+
+	pkt.TCPChecksum = pkt.computeTCPPseudoHeaderSum()
+	if pkt.TCPChecksumState() != ChecksumPartial {
+		t.Errorf("Expected partial checksum, got %s", pkt.TCPChecksumState())
+	}
+
+	pkt.UpdateTCPChecksum()
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("TCP checksum is wrong after update of offloaded packet with payload")
+	}
+}
+
+// getLargeTestSegment returns a segment of the maximum IP packet length, as
+// aggregated by GRO, with the headers of the given packet
+func getLargeTestSegment(t *testing.T, id SamplePacketName) *Packet {
+
+	buffer := make([]byte, MaxIPPacketLen)
+	copy(buffer, testPackets[id])
+	binary.BigEndian.PutUint16(buffer[ipLengthPos:ipLengthPos+2], MaxIPPacketLen)
+	for i := len(testPackets[id]); i < len(buffer); i++ {
+		buffer[i] = byte(i)
+	}
+
+	pkt, err := New(0, buffer, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func TestLargeSegmentChecksum(t *testing.T) {
+
+	t.Parallel()
+	pkt := getLargeTestSegment(t, synGoodTCPChecksum)
+
+	// Reference checksum over a contiguous copy of the pseudo-header and segment
+	buf := append(pkt.tcpPseudoHeader(), pkt.Buffer[pkt.l4BeginPos:]...)
+	buf[12+TCPChecksumPos-minIPHdrSize] = 0
+	buf[12+TCPChecksumPos-minIPHdrSize+1] = 0
+	expected := checksum(buf)
+
+	pkt.UpdateTCPChecksum()
+	if pkt.TCPChecksum != expected {
+		t.Errorf("Expected checksum 0x%04x of large segment, got 0x%04x", expected, pkt.TCPChecksum)
+	}
+
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("TCP checksum is wrong after update of large segment")
+	}
+}
+
+func TestLargeSegmentAttach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getLargeTestSegment(t, synGoodTCPChecksum)
+
+	if err := pkt.TCPDataAttach([]byte{}, []byte("data")); err == nil {
+		t.Error("Expected error when the segment exceeds the maximum length")
+	}
+
+	if pkt.IPTotalLength != MaxIPPacketLen {
+		t.Errorf("Expected length of segment to be unchanged, got %d", pkt.IPTotalLength)
+	}
+}
+
+func TestOversizedBuffer(t *testing.T) {
+
+	t.Parallel()
+	// IPv4 BIG TCP segments have a zero length in the header
+	buffer := make([]byte, MaxIPPacketLen+1)
+	copy(buffer, testPackets[synGoodTCPChecksum])
+	binary.BigEndian.PutUint16(buffer[ipLengthPos:ipLengthPos+2], 0)
+
+	if _, err := New(0, buffer, "0"); err == nil {
+		t.Error("Expected error for segment larger than the maximum IP packet length")
+	}
+
+	if _, err := New(0, testPackets[synGoodTCPChecksum][:10], "0"); err == nil {
+		t.Error("Expected error for buffer shorter than the IP header")
+	}
+}
@@ -0,0 +1,176 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	tlsRecordHandshake      = 0x16
+	tlsHandshakeClientHello = 0x01
+	tlsExtensionServerName  = 0x0000
+	tlsServerNameHost       = 0x00
+)
+
+// errTLSTruncated is returned when the data ends before the server name
+var errTLSTruncated = errors.New("truncated tls client hello")
+
+// tlsReader reads the fields of a TLS message
+type tlsReader struct {
+	data []byte
+}
+
+func (r *tlsReader) bytes(n int) ([]byte, error) {
+
+	if n > len(r.data) {
+		return nil, errTLSTruncated
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+
+	return b, nil
+}
+
+func (r *tlsReader) uint8() (int, error) {
+
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(b[0]), nil
+}
+
+func (r *tlsReader) uint16() (int, error) {
+
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(binary.BigEndian.Uint16(b)), nil
+}
+
+// vector reads a vector whose length is encoded with the given number of bytes
+func (r *tlsReader) vector(lengthBytes int) ([]byte, error) {
+
+	var n int
+	var err error
+
+	if lengthBytes == 1 {
+		n, err = r.uint8()
+	} else {
+		n, err = r.uint16()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.bytes(n)
+}
+
+// TLSServerName returns the server name of the TLS ClientHello at the start
+// of the payload of a TCP packet. The ClientHello may be split in several
+// segments: only the data before the server name extension is required. It
+// returns an empty name if the ClientHello has no server name, and an error
+// if the payload is not a ClientHello.
+func TLSServerName(data []byte) (string, error) {
+
+	r := &tlsReader{data: data}
+
+	// Record header: type, version and length
+	header, err := r.bytes(5)
+	if err != nil {
+		return "", err
+	}
+	if header[0] != tlsRecordHandshake {
+		return "", errors.New("not a tls handshake")
+	}
+
+	// Handshake header: type and length
+	handshake, err := r.bytes(4)
+	if err != nil {
+		return "", err
+	}
+	if handshake[0] != tlsHandshakeClientHello {
+		return "", errors.New("not a tls client hello")
+	}
+	length := int(handshake[1])<<16 | int(binary.BigEndian.Uint16(handshake[2:]))
+	start := len(r.data)
+
+	// Version and random
+	if _, err = r.bytes(2 + 32); err != nil {
+		return "", err
+	}
+
+	// Session ID, cipher suites and compression methods
+	for _, lengthBytes := range []int{1, 2, 1} {
+		if _, err = r.vector(lengthBytes); err != nil {
+			return "", err
+		}
+	}
+
+	if start-len(r.data) >= length {
+		// No extensions
+		return "", nil
+	}
+
+	extensionsLength, err := r.uint16()
+	if err != nil {
+		return "", err
+	}
+
+	// The extensions are complete if the segment holds all of them
+	complete := len(r.data) >= extensionsLength
+	if complete {
+		r.data = r.data[:extensionsLength]
+	}
+
+	for len(r.data) > 0 {
+		extension, err := r.uint16()
+		if err != nil {
+			return "", err
+		}
+
+		value, err := r.vector(2)
+		if err != nil {
+			return "", err
+		}
+
+		if extension != tlsExtensionServerName {
+			continue
+		}
+
+		names := &tlsReader{data: value}
+		list, err := names.vector(2)
+		if err != nil {
+			return "", err
+		}
+
+		names.data = list
+		for len(names.data) > 0 {
+			nameType, err := names.uint8()
+			if err != nil {
+				return "", err
+			}
+
+			name, err := names.vector(2)
+			if err != nil {
+				return "", err
+			}
+
+			if nameType == tlsServerNameHost {
+				return string(name), nil
+			}
+		}
+
+		return "", nil
+	}
+
+	if complete {
+		return "", nil
+	}
+
+	return "", errTLSTruncated
+}
@@ -0,0 +1,51 @@
+package packet
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// clientHello returns the first segment of the ClientHello of a TLS client
+func clientHello(t *testing.T, serverName string) []byte {
+
+	client, server := net.Pipe()
+	defer server.Close() // nolint
+
+	go tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake() // nolint
+
+	buffer := make([]byte, 16384)
+	n, err := server.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close() // nolint
+
+	return buffer[:n]
+}
+
+func TestTLSServerName(t *testing.T) {
+
+	t.Parallel()
+
+	hello := clientHello(t, "s3.amazonaws.com")
+
+	name, err := TLSServerName(hello)
+	if err != nil || name != "s3.amazonaws.com" {
+		t.Errorf("Expected server name s3.amazonaws.com, got %s %v", name, err)
+	}
+
+	name, err = TLSServerName(clientHello(t, ""))
+	if err != nil || name != "" {
+		t.Errorf("Expected no server name, got %s %v", name, err)
+	}
+
+	if _, err = TLSServerName(hello[:60]); err != errTLSTruncated {
+		t.Errorf("Expected truncated client hello, got %v", err)
+	}
+
+	if _, err = TLSServerName([]byte("GET / HTTP/1.1\r\n\r\n")); err == nil {
+		t.Error("Expected an error for a payload that is not a client hello")
+	}
+}
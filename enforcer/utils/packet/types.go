@@ -170,6 +170,12 @@ type Packet struct {
 	TCPFlags      uint8
 	TCPChecksum   uint16
 
+	// UDP Specific fields
+	UDPChecksum uint16
+
+	// tcpChecksumState caches the state of the checksum as received
+	tcpChecksumState ChecksumState
+
 	// Service Metadata
 	SvcMetadata interface{}
 	// Connection Metadata
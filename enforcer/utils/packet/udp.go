@@ -0,0 +1,155 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// udpTokenMarker terminates the datagrams that carry an identity token. The
+// token is appended to the payload, followed by its length and the marker, so
+// that it can be found and removed from the end of the datagram.
+var udpTokenMarker = []byte{'T', 'r', 'i', 'r', 'e', 'm', 'e', 'U'}
+
+// udpTokenTrailerLen is the length of the token length and the marker
+var udpTokenTrailerLen = 2 + len(udpTokenMarker)
+
+// ReadUDPData returns the payload of the datagram. It does not remove the
+// payload from the packet.
+func (p *Packet) ReadUDPData() []byte {
+
+	if uint16(len(p.Buffer)) >= p.IPTotalLength {
+		return p.Buffer[udpDataPos:p.IPTotalLength]
+	}
+
+	return []byte{}
+}
+
+// ReadUDPToken returns the identity token carried at the end of the datagram
+// or nil if there is none. The packet is not modified.
+func (p *Packet) ReadUDPToken() []byte {
+
+	data := p.ReadUDPData()
+	end := len(data) - udpTokenTrailerLen
+
+	if end < 0 || !bytes.Equal(data[end+2:], udpTokenMarker) {
+		return nil
+	}
+
+	tokenLength := int(binary.BigEndian.Uint16(data[end : end+2]))
+	if tokenLength == 0 || tokenLength > end {
+		return nil
+	}
+
+	return data[end-tokenLength : end]
+}
+
+// UDPTokenAttach appends an identity token to the payload of the datagram and
+// updates the IP and UDP headers. The datagram is left unchanged if it would
+// be larger than mtu with the token, since it could not be sent without being
+// fragmented.
+func (p *Packet) UDPTokenAttach(token []byte, mtu int) error {
+
+	if len(token) == 0 {
+		return errors.New("empty udp token")
+	}
+
+	length := int(p.IPTotalLength) + len(token) + udpTokenTrailerLen
+	if length > mtu || length > MaxIPPacketLen {
+		return fmt.Errorf("udp datagram too large to attach token: length=%d mtu=%d", length, mtu)
+	}
+
+	// The buffer may belong to the queue, the datagram is copied before it grows
+	buffer := make([]byte, length)
+	offset := copy(buffer, p.Buffer[:p.IPTotalLength])
+	offset += copy(buffer[offset:], token)
+	binary.BigEndian.PutUint16(buffer[offset:offset+2], uint16(len(token)))
+	copy(buffer[offset+2:], udpTokenMarker)
+
+	p.Buffer = buffer
+	p.fixupUDPLength(uint16(length))
+
+	return nil
+}
+
+// UDPTokenDetach removes the identity token from the end of the datagram and
+// updates the IP and UDP headers
+func (p *Packet) UDPTokenDetach() error {
+
+	token := p.ReadUDPToken()
+	if token == nil {
+		return errors.New("udp token not found")
+	}
+
+	length := p.IPTotalLength - uint16(len(token)+udpTokenTrailerLen)
+
+	p.Buffer = p.Buffer[:length]
+	p.fixupUDPLength(length)
+
+	return nil
+}
+
+// fixupUDPLength updates the IP and UDP lengths and checksums after the
+// payload of the datagram is modified
+func (p *Packet) fixupUDPLength(length uint16) {
+
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, length)
+	binary.BigEndian.PutUint16(p.Buffer[udpLengthPos:udpLengthPos+2], length-p.l4BeginPos)
+
+	p.UpdateUDPChecksum()
+}
+
+// VerifyUDPChecksum returns true if the UDP checksum of the packet is correct.
+// Datagrams sent without a checksum and datagrams with an offloaded checksum,
+// that only holds the sum of the pseudo-header, are valid.
+func (p *Packet) VerifyUDPChecksum() bool {
+
+	if p.UDPChecksum == 0 {
+		return true
+	}
+
+	// The pseudo-header of UDP is the same as the one of TCP
+	return p.UDPChecksum == p.computeUDPChecksum() || p.UDPChecksum == p.computeTCPPseudoHeaderSum()
+}
+
+// UpdateUDPChecksum computes the UDP checksum and updates the packet with the
+// value. Datagrams sent without a checksum are left without one.
+func (p *Packet) UpdateUDPChecksum() {
+
+	if p.UDPChecksum == 0 {
+		return
+	}
+
+	p.UDPChecksum = p.computeUDPChecksum()
+
+	binary.BigEndian.PutUint16(p.Buffer[UDPChecksumPos:UDPChecksumPos+2], p.UDPChecksum)
+}
+
+// Computes the UDP checksum. The packet is not modified.
+func (p *Packet) computeUDPChecksum() uint16 {
+
+	udpSize := p.IPTotalLength - p.l4BeginPos
+	buf := make([]byte, 12)
+
+	// bytes 0-7: Source and Destination IP address
+	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
+	copy(buf[4:8], p.Buffer[ipDestAddrPos:ipDestAddrPos+4])
+
+	// byte 9: Protocol (17==UDP)
+	buf[9] = IPProtocolUDP
+
+	// bytes 10,11: UDP length (header + payload)
+	binary.BigEndian.PutUint16(buf[10:12], udpSize)
+
+	// The UDP datagram is summed in place, without the checksum field
+	datagram := p.Buffer[p.l4BeginPos:p.IPTotalLength]
+	header := UDPChecksumPos - p.l4BeginPos
+
+	// A computed checksum of zero is transmitted as all ones
+	if sum := checksum(buf, datagram[:header], datagram[header+2:]); sum != 0 {
+		return sum
+	}
+
+	return 0xffff
+}
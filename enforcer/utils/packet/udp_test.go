@@ -0,0 +1,122 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+)
+
+// UDP datagram from 10.1.10.76:1234 to 164.67.228.152:53 with payload "hello"
+var testUDPPacket = []byte{0x45, 0x00, 0x00, 0x21, 0x12, 0x34, 0x40, 0x00, 0x40, 0x11, 0x8b,
+	0x6f, 0x0a, 0x01, 0x0a, 0x4c, 0xa4, 0x43, 0xe4, 0x98, 0x04, 0xd2, 0x00, 0x35, 0x00, 0x0d,
+	0x19, 0xd2, 0x68, 0x65, 0x6c, 0x6c, 0x6f}
+
+func getTestUDPPacket(t *testing.T) *Packet {
+
+	buffer := make([]byte, len(testUDPPacket))
+	copy(buffer, testUDPPacket)
+
+	pkt, err := New(0, buffer, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pkt
+}
+
+func TestUDPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestUDPPacket(t)
+
+	if pkt.IPProto != IPProtocolUDP {
+		t.Errorf("Expected udp protocol, got %d", pkt.IPProto)
+	}
+
+	if pkt.SourcePort != 1234 || pkt.DestinationPort != 53 {
+		t.Errorf("Unexpected ports %d %d", pkt.SourcePort, pkt.DestinationPort)
+	}
+
+	if string(pkt.ReadUDPData()) != "hello" {
+		t.Errorf("Unexpected payload %s", pkt.ReadUDPData())
+	}
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("Expected valid udp checksum")
+	}
+
+	if pkt.ReadUDPToken() != nil {
+		t.Error("Expected no token in datagram")
+	}
+}
+
+func TestUDPTokenAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestUDPPacket(t)
+
+	if err := pkt.UDPTokenAttach([]byte("token"), 1500); err != nil {
+		t.Fatal(err)
+	}
+
+	// The datagram must be parsed again on the other side
+	received, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !received.VerifyIPChecksum() || !received.VerifyUDPChecksum() {
+		t.Error("Checksums are wrong after token attach")
+	}
+
+	if string(received.ReadUDPToken()) != "token" {
+		t.Errorf("Unexpected token %s", received.ReadUDPToken())
+	}
+
+	if err := received.UDPTokenDetach(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received.GetBytes(), testUDPPacket) {
+		t.Error("Datagram differs from the original after token detach")
+	}
+
+	if err := received.UDPTokenDetach(); err == nil {
+		t.Error("Expected failure to detach a missing token")
+	}
+}
+
+func TestUDPTokenAttachMTU(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestUDPPacket(t)
+	mtu := int(pkt.IPTotalLength) + len("token") + udpTokenTrailerLen
+
+	if err := pkt.UDPTokenAttach([]byte("token"), mtu-1); err == nil {
+		t.Error("Expected failure to attach a token past the mtu")
+	}
+
+	if !bytes.Equal(pkt.GetBytes(), testUDPPacket) {
+		t.Error("Datagram modified by a failed token attach")
+	}
+
+	if err := pkt.UDPTokenAttach([]byte("token"), mtu); err != nil {
+		t.Errorf("Unable to attach a token up to the mtu: %s", err)
+	}
+}
+
+func TestUDPChecksumDisabled(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestUDPPacket(t)
+	pkt.UDPChecksum = 0
+	pkt.Buffer[UDPChecksumPos] = 0
+	pkt.Buffer[UDPChecksumPos+1] = 0
+
+	if err := pkt.UDPTokenAttach([]byte("token"), 1500); err != nil {
+		t.Fatal(err)
+	}
+
+	if pkt.UDPChecksum != 0 || !pkt.VerifyUDPChecksum() {
+		t.Error("Datagrams without checksum must stay without checksum")
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"crypto/elliptic"
 	"crypto/x509"
 	"errors"
+	"fmt"
 	"math/big"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
 
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/jwtsigning"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 )
 
@@ -24,36 +27,67 @@ type PKITokenIssuer interface {
 }
 
 // PKITokenVerifier is the interface of an object that can verify a PKI token.
+// The public key of a token is an *ecdsa.PublicKey or an ed25519.PublicKey.
 type PKITokenVerifier interface {
-	Verify([]byte) (*ecdsa.PublicKey, error)
+	Verify([]byte) (interface{}, error)
 }
 
+// verifierClaims holds the public key of a certificate. The curve of ECDSA
+// keys is empty for P-256 keys, so that their tokens do not change, and the
+// Ed25519 keys are in the Key claim.
 type verifierClaims struct {
-	X *big.Int
-	Y *big.Int
+	X     *big.Int `json:",omitempty"`
+	Y     *big.Int `json:",omitempty"`
+	Curve string   `json:",omitempty"`
+	Key   []byte   `json:",omitempty"`
 	jwt.StandardClaims
 }
 
+// RevokedFunc returns true if a token or the certificate with the serial
+// number is revoked. The serial number is empty in the tokens that do not
+// have one.
+type RevokedFunc func(token []byte, serial string) bool
+
+// cachedKey is the public key of a verified token and the serial number of
+// its certificate
+type cachedKey struct {
+	key    interface{}
+	serial string
+}
+
 type tokenManager struct {
 	publicKeys []*ecdsa.PublicKey
 	privateKey *ecdsa.PrivateKey
 	signMethod jwt.SigningMethod
 	keycache   cache.DataStore
 	validity   time.Duration
+	revoked    RevokedFunc
 }
 
 // NewPKIIssuer initializes a new signer structure
 func NewPKIIssuer(privateKey *ecdsa.PrivateKey) PKITokenIssuer {
 
+	signMethod, err := jwtsigning.ForKey(privateKey)
+	if err != nil {
+		signMethod = jwt.SigningMethodES256
+	}
+
 	return &tokenManager{
 		privateKey: privateKey,
-		signMethod: jwt.SigningMethodES256,
+		signMethod: signMethod,
 	}
 }
 
 // NewPKIVerifier returns a new PKIConfiguration.
 func NewPKIVerifier(publicKeys []*ecdsa.PublicKey, cacheValidity time.Duration) PKITokenVerifier {
 
+	return NewPKIVerifierWithRevocations(publicKeys, cacheValidity, nil)
+}
+
+// NewPKIVerifierWithRevocations returns a new PKIConfiguration that rejects
+// the tokens for which revoked returns true, even if they are in the cache.
+func NewPKIVerifierWithRevocations(publicKeys []*ecdsa.PublicKey, cacheValidity time.Duration, revoked RevokedFunc) PKITokenVerifier {
+
 	validity := defaultValidity * time.Second
 	if cacheValidity > 0 {
 		validity = cacheValidity
@@ -64,25 +98,32 @@ func NewPKIVerifier(publicKeys []*ecdsa.PublicKey, cacheValidity time.Duration)
 		signMethod: jwt.SigningMethodES256,
 		keycache:   cache.NewCacheWithExpiration("PKIVerifierKey", validity),
 		validity:   validity,
+		revoked:    revoked,
 	}
 }
 
 // Verify verifies a token and returns the public key
-func (p *tokenManager) Verify(token []byte) (*ecdsa.PublicKey, error) {
+func (p *tokenManager) Verify(token []byte) (interface{}, error) {
 
 	tokenString := string(token)
 
 	claims := &verifierClaims{}
 
-	if pk, err := p.keycache.Get(tokenString); err == nil {
-		return pk.(*ecdsa.PublicKey), nil
+	if entry, err := p.keycache.Get(tokenString); err == nil {
+		cached := entry.(*cachedKey)
+		if p.isRevoked(token, cached.serial) {
+			return nil, errors.New("token is revoked")
+		}
+		return cached.key, nil
 	}
 
+	parser := &jwt.Parser{ValidMethods: jwtsigning.ValidMethods}
+
 	var JWTToken *jwt.Token
 	var err error
 	for _, pk := range p.publicKeys {
 
-		JWTToken, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		JWTToken, err = parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 			return pk, nil
 		})
 
@@ -90,10 +131,17 @@ func (p *tokenManager) Verify(token []byte) (*ecdsa.PublicKey, error) {
 			continue
 		}
 
-		pk := KeyFromClaims(claims)
+		pk, err := KeyFromClaims(claims)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.isRevoked(token, claims.Id) {
+			return nil, errors.New("token is revoked")
+		}
 
 		if time.Now().Add(p.validity).Unix() <= claims.ExpiresAt {
-			p.keycache.AddOrUpdate(tokenString, pk)
+			p.keycache.AddOrUpdate(tokenString, &cachedKey{key: pk, serial: claims.Id})
 		}
 
 		return pk, nil
@@ -105,12 +153,30 @@ func (p *tokenManager) Verify(token []byte) (*ecdsa.PublicKey, error) {
 // CreateTokenFromCertificate creates and signs a token
 func (p *tokenManager) CreateTokenFromCertificate(cert *x509.Certificate) ([]byte, error) {
 
+	// The keys are the ones the tokens can be signed with
+	if _, err := jwtsigning.ForKey(cert.PublicKey); err != nil {
+		return []byte{}, err
+	}
+
 	// Combine the application claims with the standard claims
-	claims := &verifierClaims{
-		X: cert.PublicKey.(*ecdsa.PublicKey).X,
-		Y: cert.PublicKey.(*ecdsa.PublicKey).Y,
+	claims := &verifierClaims{}
+
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		claims.X = key.X
+		claims.Y = key.Y
+		if key.Curve != elliptic.P256() {
+			claims.Curve = key.Curve.Params().Name
+		}
+	case ed25519.PublicKey:
+		claims.Key = key
+	default:
+		return []byte{}, fmt.Errorf("unsupported public key: %T", cert.PublicKey)
 	}
 	claims.ExpiresAt = cert.NotAfter.Unix()
+	if cert.SerialNumber != nil {
+		claims.Id = cert.SerialNumber.String()
+	}
 
 	// Create the token and sign with our key
 	strtoken, err := jwt.NewWithClaims(p.signMethod, claims).SignedString(p.privateKey)
@@ -121,11 +187,39 @@ func (p *tokenManager) CreateTokenFromCertificate(cert *x509.Certificate) ([]byt
 	return []byte(strtoken), nil
 }
 
+// isRevoked returns true if the token is revoked
+func (p *tokenManager) isRevoked(token []byte, serial string) bool {
+
+	return p.revoked != nil && p.revoked(token, serial)
+}
+
 // KeyFromClaims creates the public key structure from the claims
-func KeyFromClaims(claims *verifierClaims) *ecdsa.PublicKey {
+func KeyFromClaims(claims *verifierClaims) (interface{}, error) {
+
+	if len(claims.Key) > 0 {
+		if len(claims.Key) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+		return ed25519.PublicKey(claims.Key), nil
+	}
+
+	var curve elliptic.Curve
+	switch claims.Curve {
+	case "", elliptic.P256().Params().Name:
+		curve = elliptic.P256()
+	case elliptic.P384().Params().Name:
+		curve = elliptic.P384()
+	default:
+		return nil, fmt.Errorf("unsupported curve: %s", claims.Curve)
+	}
+
+	if claims.X == nil || claims.Y == nil || !curve.IsOnCurve(claims.X, claims.Y) {
+		return nil, errors.New("invalid ecdsa public key")
+	}
+
 	return &ecdsa.PublicKey{
-		Curve: elliptic.P256(),
+		Curve: curve,
 		X:     claims.X,
 		Y:     claims.Y,
-	}
+	}, nil
 }
@@ -2,11 +2,16 @@ package pkiverifier
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
 	"testing"
 	"time"
 
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ed25519"
 )
 
 var (
@@ -95,9 +100,9 @@ func TestCreateAndVerify(t *testing.T) {
 			So(err1, ShouldBeNil)
 			rxtoken, err2 := v.Verify(token)
 			So(err2, ShouldBeNil)
-			So(*rxtoken.X, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).X)
-			So(*rxtoken.Y, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).Y)
-			So(rxtoken.Curve, ShouldResemble, cert.PublicKey.(*ecdsa.PublicKey).Curve)
+			So(*rxtoken.(*ecdsa.PublicKey).X, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).X)
+			So(*rxtoken.(*ecdsa.PublicKey).Y, ShouldResemble, *cert.PublicKey.(*ecdsa.PublicKey).Y)
+			So(rxtoken.(*ecdsa.PublicKey).Curve, ShouldResemble, cert.PublicKey.(*ecdsa.PublicKey).Curve)
 		})
 	})
 
@@ -147,3 +152,77 @@ func TestCaching(t *testing.T) {
 		})
 	})
 }
+
+func TestCreateAndVerifyAlgorithms(t *testing.T) {
+	Convey("Given a verifier with a P-384 token key", t, func() {
+		caKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader) // nolint
+		p := NewPKIIssuer(caKey)
+		v := NewPKIVerifier([]*ecdsa.PublicKey{&caKey.PublicKey}, -1)
+
+		certificate := func(public interface{}) *x509.Certificate {
+			der, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{ // nolint
+				SerialNumber: big.NewInt(1),
+				NotAfter:     time.Now().Add(time.Hour),
+			}, &x509.Certificate{}, public, caKey)
+			cert, _ := x509.ParseCertificate(der) // nolint
+			return cert
+		}
+
+		Convey("When I create the tokens of P-256, P-384 and Ed25519 keys, I should get their keys", func() {
+			p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+			p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader) // nolint
+			ed, _, _ := ed25519.GenerateKey(rand.Reader)               // nolint
+
+			for _, public := range []interface{}{&p256.PublicKey, &p384.PublicKey, ed} {
+				token, err := p.CreateTokenFromCertificate(certificate(public))
+				So(err, ShouldBeNil)
+
+				key, err := v.Verify(token)
+				So(err, ShouldBeNil)
+				So(key, ShouldResemble, public)
+			}
+		})
+
+		Convey("When I create the token of a P-521 key, it should fail", func() {
+			p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader) // nolint
+			_, err := p.CreateTokenFromCertificate(certificate(&p521.PublicKey))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRevocations(t *testing.T) {
+	Convey("Given a verifier that rejects the revoked serial numbers", t, func() {
+		caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)      // nolint
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)        // nolint
+		der, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{ // nolint
+			SerialNumber: big.NewInt(42),
+			NotAfter:     time.Now().Add(time.Hour),
+		}, &x509.Certificate{}, &key.PublicKey, caKey)
+		cert, _ := x509.ParseCertificate(der) // nolint
+
+		revoked := map[string]bool{}
+		p := NewPKIIssuer(caKey)
+		v := NewPKIVerifierWithRevocations([]*ecdsa.PublicKey{&caKey.PublicKey}, time.Minute, func(token []byte, serial string) bool {
+			return revoked[serial]
+		})
+
+		token, err := p.CreateTokenFromCertificate(cert)
+		So(err, ShouldBeNil)
+
+		Convey("When the certificate is not revoked, I should get its key", func() {
+			pk, err := v.Verify(token)
+			So(err, ShouldBeNil)
+			So(pk, ShouldResemble, &key.PublicKey)
+		})
+
+		Convey("When the certificate is revoked after its token is cached, the token should be rejected", func() {
+			_, err := v.Verify(token)
+			So(err, ShouldBeNil)
+
+			revoked["42"] = true
+			_, err = v.Verify(token)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -1,10 +1,13 @@
 package rpcwrapper
 
+import "context"
+
 // RPCClient is the client interface
 type RPCClient interface {
 	NewRPCClient(contextID string, channel string, rpcSecret string) error
 	GetRPCClient(contextID string) (*RPCHdl, error)
-	RemoteCall(contextID string, methodName string, req *Request, resp *Response) error
+	RemoteCall(ctx context.Context, contextID string, methodName string, req *Request, resp *Response) error
+	SetVersion(contextID string, version int) error
 	DestroyRPCClient(contextID string)
 	ContextList() []string
 	CheckValidity(req *Request, secret string) bool
@@ -4,6 +4,8 @@
 package mockrpcwrapper
 
 import (
+	context "context"
+
 	rpcwrapper "github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
 	gomock "github.com/golang/mock/gomock"
 )
@@ -91,15 +93,27 @@ func (_mr *MockRPCClientMockRecorder) NewRPCClient(arg0, arg1, arg2 interface{})
 }
 
 // RemoteCall mocks base method
-func (_m *MockRPCClient) RemoteCall(_param0 string, _param1 string, _param2 *rpcwrapper.Request, _param3 *rpcwrapper.Response) error {
-	ret := _m.ctrl.Call(_m, "RemoteCall", _param0, _param1, _param2, _param3)
+func (_m *MockRPCClient) RemoteCall(_param0 context.Context, _param1 string, _param2 string, _param3 *rpcwrapper.Request, _param4 *rpcwrapper.Response) error {
+	ret := _m.ctrl.Call(_m, "RemoteCall", _param0, _param1, _param2, _param3, _param4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RemoteCall indicates an expected call of RemoteCall
-func (_mr *MockRPCClientMockRecorder) RemoteCall(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoteCall", arg0, arg1, arg2, arg3)
+func (_mr *MockRPCClientMockRecorder) RemoteCall(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoteCall", arg0, arg1, arg2, arg3, arg4)
+}
+
+// SetVersion mocks base method
+func (_m *MockRPCClient) SetVersion(_param0 string, _param1 int) error {
+	ret := _m.ctrl.Call(_m, "SetVersion", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetVersion indicates an expected call of SetVersion
+func (_mr *MockRPCClientMockRecorder) SetVersion(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetVersion", arg0, arg1)
 }
 
 // MockRPCServer is a mock of RPCServer interface
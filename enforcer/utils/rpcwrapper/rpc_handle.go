@@ -1,6 +1,7 @@
 package rpcwrapper
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/gob"
@@ -27,6 +28,7 @@ type RPCHdl struct {
 	Client  *rpc.Client
 	Channel string
 	Secret  string
+	Version int
 }
 
 // RPCWrapper  is a struct which holds stats for all rpc sesions
@@ -51,6 +53,9 @@ func NewRPCWrapper() *RPCWrapper {
 const (
 	maxRetries     = 10000
 	envRetryString = "REMOTE_RPCRETRIES"
+	// DefaultCallTimeout is the default time a remote end has to answer a
+	// call, so that a hung remote end does not block its callers
+	DefaultCallTimeout = 30 * time.Second
 )
 
 // NewRPCClient exported
@@ -78,7 +83,7 @@ func (r *RPCWrapper) NewRPCClient(contextID string, channel string, sharedsecret
 	r.contextList = append(r.contextList, contextID)
 	r.Unlock()
 
-	return r.rpcClientMap.Add(contextID, &RPCHdl{Client: client, Channel: channel, Secret: sharedsecret})
+	return r.rpcClientMap.Add(contextID, &RPCHdl{Client: client, Channel: channel, Secret: sharedsecret, Version: ProtocolVersion})
 
 }
 
@@ -93,8 +98,9 @@ func (r *RPCWrapper) GetRPCClient(contextID string) (*RPCHdl, error) {
 	return val.(*RPCHdl), nil
 }
 
-// RemoteCall is a wrapper around rpc.Call and also ensure message integrity by adding a hmac
-func (r *RPCWrapper) RemoteCall(contextID string, methodName string, req *Request, resp *Response) error {
+// RemoteCall is a wrapper around rpc.Call and also ensure message integrity by adding a hmac.
+// The call is abandoned when the context is done, and a late reply of the remote end is dropped.
+func (r *RPCWrapper) RemoteCall(ctx context.Context, contextID string, methodName string, req *Request, resp *Response) error {
 
 	rpcClient, err := r.GetRPCClient(contextID)
 	if err != nil {
@@ -107,8 +113,58 @@ func (r *RPCWrapper) RemoteCall(contextID string, methodName string, req *Reques
 	}
 
 	req.HashAuth = digest.Sum(nil)
+	req.Version = rpcClient.Version
 
-	return rpcClient.Client.Call(methodName, req, resp)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s not called: %s", methodName, err)
+	}
+
+	// The reply is decoded in its own response, so that a late reply does not
+	// race with the caller
+	reply := &Response{}
+	call := rpcClient.Client.Go(methodName, req, reply, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return call.Error
+		}
+		*resp = *reply
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s abandoned: %s", methodName, ctx.Err())
+	}
+}
+
+// SetVersion sets the version of the payloads sent to a remote end. The
+// handle is replaced so that calls in progress are not affected.
+func (r *RPCWrapper) SetVersion(contextID string, version int) error {
+
+	rpcClient, err := r.GetRPCClient(contextID)
+	if err != nil {
+		return err
+	}
+
+	hdl := *rpcClient
+	hdl.Version = version
+	r.rpcClientMap.AddOrUpdate(contextID, &hdl)
+
+	return nil
+}
+
+// NegotiateVersion returns the version of the payloads to exchange with a
+// remote end that supports payloads up to the given version
+func NegotiateVersion(version int) (int, error) {
+
+	if version < MinProtocolVersion {
+		return 0, fmt.Errorf("unsupported protocol version %d: minimum version is %d", version, MinProtocolVersion)
+	}
+
+	if version > ProtocolVersion {
+		return ProtocolVersion, nil
+	}
+
+	return version, nil
 }
 
 // CheckValidity checks if the received message is valid
@@ -181,7 +237,9 @@ func (r *RPCWrapper) StartServer(protocol string, path string, handler interface
 	return nil
 }
 
-// DestroyRPCClient calls close on the rpc and cleans up the connection
+// DestroyRPCClient calls close on the rpc and cleans up the connection. The
+// channel of a remote enforcer shared by several contexts is only removed with
+// the client of the last of them.
 func (r *RPCWrapper) DestroyRPCClient(contextID string) {
 
 	rpcHdl, err := r.rpcClientMap.Get(contextID)
@@ -196,21 +254,38 @@ func (r *RPCWrapper) DestroyRPCClient(contextID string) {
 		)
 	}
 
-	if err = os.Remove(rpcHdl.(*RPCHdl).Channel); err != nil {
-		zap.L().Debug("Failed to remove channel - already closed",
+	if err = r.rpcClientMap.Remove(contextID); err != nil {
+		zap.L().Warn("Failed to remove item from cache",
 			zap.String("contextID", contextID),
 			zap.Error(err),
 		)
 	}
 
-	if err = r.rpcClientMap.Remove(contextID); err != nil {
-		zap.L().Warn("Failed to remove item from cache",
+	if r.channelInUse(rpcHdl.(*RPCHdl).Channel) {
+		return
+	}
+
+	if err = os.Remove(rpcHdl.(*RPCHdl).Channel); err != nil {
+		zap.L().Debug("Failed to remove channel - already closed",
 			zap.String("contextID", contextID),
 			zap.Error(err),
 		)
 	}
 }
 
+// channelInUse returns true if a client uses the channel
+func (r *RPCWrapper) channelInUse(channel string) bool {
+
+	for _, key := range r.rpcClientMap.KeyList() {
+		rpcHdl, err := r.rpcClientMap.Get(key)
+		if err == nil && rpcHdl.(*RPCHdl).Channel == channel {
+			return true
+		}
+	}
+
+	return false
+}
+
 // ContextList returns the list of active context managed by the rpcwrapper
 func (r *RPCWrapper) ContextList() []string {
 	r.Lock()
@@ -237,4 +312,10 @@ func RegisterTypes() {
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Supervise_Request_Payload", *(&SuperviseRequestPayload{}))
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.UnSupervise_Payload", *(&UnSupervisePayload{}))
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Stats_Payload", *(&StatsPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Heartbeat_Payload", *(&HeartbeatPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Log_Payload", *(&LogPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Log_Forwarding_Payload", *(&LogForwardingPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Log_Level_Payload", *(&LogLevelPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Debug_Listener_Payload", *(&DebugListenerPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Crash_Payload", *(&CrashPayload{}))
 }
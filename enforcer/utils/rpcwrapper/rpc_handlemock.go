@@ -1,6 +1,7 @@
 package rpcwrapper
 
 import (
+	"context"
 	"net/rpc"
 	"sync"
 	"testing"
@@ -15,7 +16,8 @@ type MockRPCHdl struct {
 type mockedMethods struct {
 	NewRPCClientMock     func(contextID string, channel string, secret string) error
 	GetRPCClientMock     func(contextID string) (*RPCHdl, error)
-	RemoteCallMock       func(contextID string, methodName string, req *Request, resp *Response) error
+	RemoteCallMock       func(ctx context.Context, contextID string, methodName string, req *Request, resp *Response) error
+	SetVersionMock       func(contextID string, version int) error
 	DestroyRPCClientMock func(contextID string)
 	StartServerMock      func(protocol string, path string, handler interface{}) error
 	ProcessMessageMock   func(req *Request, secret string) bool
@@ -28,7 +30,8 @@ type TestRPCClient interface {
 	RPCClient
 	MockNewRPCClient(t *testing.T, impl func(contextID string, channel string, secret string) error)
 	MockGetRPCClient(t *testing.T, impl func(contextID string) (*RPCHdl, error))
-	MockRemoteCall(t *testing.T, impl func(contextID string, methodName string, req *Request, resp *Response) error)
+	MockRemoteCall(t *testing.T, impl func(ctx context.Context, contextID string, methodName string, req *Request, resp *Response) error)
+	MockSetVersion(t *testing.T, impl func(contextID string, version int) error)
 	MockDestroyRPCClient(t *testing.T, impl func(contextID string))
 	MockContextList(t *testing.T, impl func() []string)
 	MockCheckValidity(t *testing.T, impl func(req *Request, secret string) bool)
@@ -75,10 +78,15 @@ func (m *testRPC) MockGetRPCClient(t *testing.T, impl func(contextID string) (*R
 }
 
 // MockRemoteCall mocks the RemoteCall function
-func (m *testRPC) MockRemoteCall(t *testing.T, impl func(contextID string, methodName string, req *Request, resp *Response) error) {
+func (m *testRPC) MockRemoteCall(t *testing.T, impl func(ctx context.Context, contextID string, methodName string, req *Request, resp *Response) error) {
 	m.currentMocks(t).RemoteCallMock = impl
 }
 
+// MockSetVersion mocks the SetVersion function
+func (m *testRPC) MockSetVersion(t *testing.T, impl func(contextID string, version int) error) {
+	m.currentMocks(t).SetVersionMock = impl
+}
+
 // MockDestroyRPCClient mocks the DestroyRPCClient function
 func (m *testRPC) MockDestroyRPCClient(t *testing.T, impl func(contextID string)) {
 	m.currentMocks(t).DestroyRPCClientMock = impl
@@ -122,9 +130,17 @@ func (m *testRPC) GetRPCClient(contextID string) (*RPCHdl, error) {
 }
 
 // RemoteCall implements the interface with a mock
-func (m *testRPC) RemoteCall(contextID string, methodName string, req *Request, resp *Response) error {
+func (m *testRPC) RemoteCall(ctx context.Context, contextID string, methodName string, req *Request, resp *Response) error {
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.RemoteCallMock != nil {
-		return mock.RemoteCallMock(contextID, methodName, req, resp)
+		return mock.RemoteCallMock(ctx, contextID, methodName, req, resp)
+	}
+	return nil
+}
+
+// SetVersion implements the interface with a mock
+func (m *testRPC) SetVersion(contextID string, version int) error {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.SetVersionMock != nil {
+		return mock.SetVersionMock(contextID, version)
 	}
 	return nil
 }
@@ -3,9 +3,13 @@ package rpcwrapper
 import (
 	"time"
 
+	"go.uber.org/zap/zapcore"
+
 	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme-lib/policy"
 )
 
@@ -19,10 +23,45 @@ const (
 	IPSets
 )
 
+// Versions of the payloads exchanged between the controller and the remote
+// enforcers. Peers built before the payloads were versioned do not send a
+// version and use version 0.
+const (
+	// ProtocolVersion is the version of the payloads of this library
+	ProtocolVersion = 9
+	// MinProtocolVersion is the oldest version of the payloads that is supported
+	MinProtocolVersion = 0
+	// HeartbeatVersion is the first version of the payloads with heartbeats
+	HeartbeatVersion = 2
+	// RevocationsVersion is the first version of the payloads with the revoked
+	// certificates and tokens
+	RevocationsVersion = 3
+	// IdentityClaimsVersion is the first version of the payloads with the
+	// identity claims of the PUs
+	IdentityClaimsVersion = 4
+	// HTTPRulesVersion is the first version of the payloads with the HTTP
+	// rules of the PUs
+	HTTPRulesVersion = 5
+	// SNIRulesVersion is the first version of the payloads with the SNI rules
+	// of the PUs
+	SNIRulesVersion = 6
+	// LogForwardingVersion is the first version of the remote enforcers that
+	// forward their logs at a level set at runtime
+	LogForwardingVersion = 7
+	// LogLevelVersion is the first version of the remote enforcers whose log
+	// level can be set at runtime
+	LogLevelVersion = 8
+	// DebugListenerVersion is the first version of the remote enforcers with
+	// a debug listener
+	DebugListenerVersion = 9
+)
+
 //Request exported
 type Request struct {
 	HashAuth []byte
 	Payload  interface{}
+	// Version is the version of the payload
+	Version int
 }
 
 //exported consts from the package
@@ -35,6 +74,8 @@ const (
 //made on the remote end
 type Response struct {
 	Status string
+	// Version is the latest version of the payloads supported by the remote end
+	Version int
 }
 
 //InitRequestPayload Payload for enforcer init request
@@ -49,14 +90,18 @@ type InitRequestPayload struct {
 	TokenKeyPEMs           [][]byte                   `json:",omitempty"`
 	PublicPEM              []byte                     `json:",omitempty"`
 	PrivatePEM             []byte                     `json:",omitempty"`
+	PrivateKeyURI          string                     `json:",omitempty"`
 	Token                  []byte                     `json:",omitempty"`
+	TokenFormat            tokens.Format              `json:",omitempty"`
 	ExternalIPCacheTimeout time.Duration              `json:",omitempty"`
 }
 
 //InitSupervisorPayload for supervisor init request
 type InitSupervisorPayload struct {
-	TriremeNetworks []string    `json:",omitempty"`
-	CaptureMethod   CaptureType `json:",omitempty"`
+	TriremeNetworks []string           `json:",omitempty"`
+	CaptureMethod   CaptureType        `json:",omitempty"`
+	FailMode        constants.FailMode `json:",omitempty"`
+	UDPEnforcement  bool               `json:",omitempty"`
 }
 
 // EnforcePayload Payload for enforce request
@@ -80,6 +125,11 @@ type EnforcePayload struct {
 	PublicPEM        []byte                      `json:",omitempty"`
 	PrivatePEM       []byte                      `json:",omitempty"`
 	Token            []byte                      `json:",omitempty"`
+	RevokedSerials   []string                    `json:",omitempty"`
+	RevokedTokens    []string                    `json:",omitempty"`
+	IdentityClaims   map[string]string           `json:",omitempty"`
+	HTTPRules        policy.HTTPRuleList         `json:",omitempty"`
+	SNIRules         policy.SNIRuleList          `json:",omitempty"`
 }
 
 //SuperviseRequestPayload for Supervise request
@@ -104,6 +154,11 @@ type UnEnforcePayload struct {
 	ContextID string `json:",omitempty"`
 }
 
+// HeartbeatPayload payload for heartbeat request
+type HeartbeatPayload struct {
+	ContextID string `json:",omitempty"`
+}
+
 //UnSupervisePayload payload for unsupervise request
 type UnSupervisePayload struct {
 	ContextID string `json:",omitempty"`
@@ -131,7 +186,58 @@ type UnEnforceResponsePayload struct {
 
 //StatsPayload is the payload carries by the stats reporting form the remote enforcer
 type StatsPayload struct {
-	Flows map[string]*collector.FlowRecord `json:",omitempty"`
+	Flows             map[string]*collector.FlowRecord              `json:",omitempty"`
+	ConnectionMetrics map[string]*collector.ConnectionMetricsRecord `json:",omitempty"`
+	Packets           []*collector.PacketRecord                     `json:",omitempty"`
+	QueueStats        []*collector.QueueStatsRecord                 `json:",omitempty"`
+	CacheStats        []*collector.CacheStatsRecord                 `json:",omitempty"`
+	EnforcerStats     *collector.EnforcerStatsRecord                `json:",omitempty"`
+}
+
+// LogEntry is a log entry of a remote enforcer forwarded to the controller
+type LogEntry struct {
+	Level   zapcore.Level
+	Time    time.Time
+	Logger  string `json:",omitempty"`
+	Message string
+	Caller  string `json:",omitempty"`
+	Stack   string `json:",omitempty"`
+	// Fields are the fields of the entry encoded in JSON
+	Fields []byte `json:",omitempty"`
+}
+
+// LogPayload is the payload that carries the log entries forwarded by a remote
+// enforcer, with the number of entries it dropped because they could not be
+// sent fast enough
+type LogPayload struct {
+	ContextID string      `json:",omitempty"`
+	Entries   []*LogEntry `json:",omitempty"`
+	Dropped   int         `json:",omitempty"`
+}
+
+// LogForwardingPayload sets the level of the logs forwarded by a remote
+// enforcer. The logs are not forwarded if it is empty.
+type LogForwardingPayload struct {
+	Level string `json:",omitempty"`
+}
+
+// LogLevelPayload sets the level of the logs of a remote enforcer
+type LogLevelPayload struct {
+	Level string `json:",omitempty"`
+}
+
+// CrashPayload reports the crash of a remote enforcer to the controller, with
+// the stack of the crash and the last lines logged before it
+type CrashPayload struct {
+	ContextID string   `json:",omitempty"`
+	Reason    string   `json:",omitempty"`
+	Stack     string   `json:",omitempty"`
+	Logs      []string `json:",omitempty"`
+}
+
+// DebugListenerPayload starts or stops the debug listener of a remote enforcer
+type DebugListenerPayload struct {
+	Enable bool `json:",omitempty"`
 }
 
 //ExcludeIPRequestPayload carries the list of excluded ips
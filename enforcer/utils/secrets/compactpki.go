@@ -4,6 +4,8 @@ import (
 	"crypto/ecdsa"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/pkiverifier"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
@@ -16,11 +18,14 @@ type CompactPKI struct {
 	PublicKeyPEM  []byte
 	AuthorityPEM  []byte
 	TokenKeyPEMs  [][]byte
-	privateKey    *ecdsa.PrivateKey
+	privateKey    interface{}
+	keyURI        string
 	publicKey     *x509.Certificate
 	certPool      *x509.CertPool
 	txKey         []byte
 	verifier      pkiverifier.PKITokenVerifier
+	revocations   *Revocations
+	sync.RWMutex
 }
 
 // NewCompactPKI creates new secrets for PKI implementation based on compact encoding
@@ -30,44 +35,98 @@ func NewCompactPKI(keyPEM []byte, certPEM []byte, caPEM []byte, txKey []byte) (*
 	return NewCompactPKIWithTokenCA(keyPEM, certPEM, caPEM, [][]byte{[]byte(caPEM)}, txKey)
 }
 
-// NewCompactPKIWithTokenCA creates new secrets for PKI implementation based on compact encoding
+// NewCompactPKIWithTokenCA creates new secrets for PKI implementation based on compact encoding.
+// The keys are ECDSA P-256 or P-384 keys, or Ed25519 keys. The token keys are ECDSA keys.
 func NewCompactPKIWithTokenCA(keyPEM []byte, certPEM []byte, caPEM []byte, tokenKeyPEMs [][]byte, txKey []byte) (*CompactPKI, error) {
 
 	zap.L().Debug("Initializing with Compact PKI")
 
-	key, cert, caCertPool, err := crypto.LoadAndVerifyECSecrets(keyPEM, certPEM, caPEM)
+	key, cert, caCertPool, err := crypto.LoadAndVerifySecrets(keyPEM, certPEM, caPEM)
 	if err != nil {
 		return nil, err
 	}
 
+	p := &CompactPKI{
+		PrivateKeyPEM: keyPEM,
+		PublicKeyPEM:  certPEM,
+		AuthorityPEM:  caPEM,
+		privateKey:    key,
+		publicKey:     cert,
+		certPool:      caCertPool,
+	}
+
+	if err := p.setTokens(tokenKeyPEMs, txKey); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// NewCompactPKIWithSigner creates new secrets for PKI implementation based on
+// compact encoding, whose private key is the signer of the key URI, opened with
+// the opener registered for its scheme. The key never leaves the signer.
+func NewCompactPKIWithSigner(keyURI string, certPEM []byte, caPEM []byte, tokenKeyPEMs [][]byte, txKey []byte) (*CompactPKI, error) {
+
+	zap.L().Debug("Initializing with Compact PKI and a signer", zap.String("uri", keyURI))
+
+	signer, err := OpenSigner(keyURI)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, caCertPool, err := crypto.LoadAndVerifySigner(signer, certPEM, caPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &CompactPKI{
+		PublicKeyPEM: certPEM,
+		AuthorityPEM: caPEM,
+		privateKey:   signer,
+		keyURI:       keyURI,
+		publicKey:    cert,
+		certPool:     caCertPool,
+	}
+
+	if err := p.setTokens(tokenKeyPEMs, txKey); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// setTokens sets the transmitted token and the verifier of the tokens of the
+// token keys
+func (p *CompactPKI) setTokens(tokenKeyPEMs [][]byte, txKey []byte) error {
+
 	var tokenKeys []*ecdsa.PublicKey
 	for _, ca := range tokenKeyPEMs {
 
 		caCert, err := crypto.LoadCertificate(ca)
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		tokenKey, ok := caCert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("unsupported token key: %T", caCert.PublicKey)
 		}
 
-		tokenKeys = append(tokenKeys, caCert.PublicKey.(*ecdsa.PublicKey))
+		tokenKeys = append(tokenKeys, tokenKey)
 	}
 
 	if len(txKey) == 0 {
-		return nil, errors.New("transmit token missing")
+		return errors.New("transmit token missing")
 	}
 
-	p := &CompactPKI{
-		PrivateKeyPEM: keyPEM,
-		PublicKeyPEM:  certPEM,
-		AuthorityPEM:  caPEM,
-		TokenKeyPEMs:  tokenKeyPEMs,
-		privateKey:    key,
-		publicKey:     cert,
-		certPool:      caCertPool,
-		txKey:         txKey,
-		verifier:      pkiverifier.NewPKIVerifier(tokenKeys, -1),
-	}
+	p.TokenKeyPEMs = tokenKeyPEMs
+	p.txKey = txKey
+	p.revocations = NewRevocations()
+	p.verifier = pkiverifier.NewPKIVerifierWithRevocations(tokenKeys, -1, func(token []byte, serial string) bool {
+		return p.Revocations().Revoked(token, serial)
+	})
 
-	return p, nil
+	return nil
 }
 
 // Type implements the interface Secrets
@@ -90,7 +149,7 @@ func (p *CompactPKI) DecodingKey(server string, ackKey interface{}, prevKey inte
 
 	// If we have an inband certificate, return this one
 	if ackKey != nil {
-		return ackKey.(*ecdsa.PublicKey), nil
+		return ackKey, nil
 	}
 
 	// Otherwise, return the prevCert
@@ -116,7 +175,7 @@ func (p *CompactPKI) TransmittedKey() []byte {
 
 // AckSize returns the default size of an ACK packet
 func (p *CompactPKI) AckSize() uint32 {
-	return uint32(322)
+	return ackSize(322, p.privateKey)
 }
 
 // AuthPEM returns the Certificate Authority PEM
@@ -143,3 +202,45 @@ func (p *CompactPKI) TransmittedPEM() []byte {
 func (p *CompactPKI) EncodingPEM() []byte {
 	return p.PrivateKeyPEM
 }
+
+// Revocations implements the interface Revoker
+func (p *CompactPKI) Revocations() *Revocations {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.revocations
+}
+
+// SetRevocations implements the interface Revoker
+func (p *CompactPKI) SetRevocations(r *Revocations) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.revocations = r
+}
+
+// RevokeToken rejects the token of the public key of a compromised enforcer,
+// before its certificate expires
+func (p *CompactPKI) RevokeToken(token []byte) error {
+
+	return p.Revocations().RevokeToken(token)
+}
+
+// AddCRL rejects the tokens of the certificates of a PEM encoded certificate
+// revocation list signed by the Certificate Authority
+func (p *CompactPKI) AddCRL(crlPEM []byte) error {
+
+	authorities, err := crypto.LoadCertificates(p.AuthorityPEM)
+	if err != nil {
+		return err
+	}
+
+	return p.Revocations().AddCRL(crlPEM, authorities)
+}
+
+// PrivateKeyURI implements the interface SignerSecrets
+func (p *CompactPKI) PrivateKeyURI() string {
+	return p.keyURI
+}
@@ -1,7 +1,9 @@
 package secrets
 
 import (
+	gocrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/x509"
 	"errors"
 	"fmt"
@@ -17,14 +19,16 @@ type PKISecrets struct {
 	PublicKeyPEM     []byte
 	AuthorityPEM     []byte
 	CertificateCache map[string]*ecdsa.PublicKey
-	privateKey       *ecdsa.PrivateKey
+	privateKey       interface{}
+	keyURI           string
 	publicKey        *x509.Certificate
 	certPool         *x509.CertPool
 }
 
-// NewPKISecrets creates new secrets for PKI implementations
+// NewPKISecrets creates new secrets for PKI implementations. The keys are ECDSA
+// P-256 or P-384 keys, or Ed25519 keys.
 func NewPKISecrets(keyPEM, certPEM, caPEM []byte, certCache map[string]*ecdsa.PublicKey) (*PKISecrets, error) {
-	key, cert, caCertPool, err := crypto.LoadAndVerifyECSecrets(keyPEM, certPEM, caPEM)
+	key, cert, caCertPool, err := crypto.LoadAndVerifySecrets(keyPEM, certPEM, caPEM)
 	if err != nil {
 		return nil, fmt.Errorf("invalid certificates: %s", err)
 	}
@@ -42,6 +46,32 @@ func NewPKISecrets(keyPEM, certPEM, caPEM []byte, certCache map[string]*ecdsa.Pu
 	return p, nil
 }
 
+// NewPKISecretsWithSigner creates new secrets for PKI implementations whose
+// private key is the signer of the key URI, opened with the opener registered
+// for its scheme. The key never leaves the signer.
+func NewPKISecretsWithSigner(keyURI string, certPEM, caPEM []byte, certCache map[string]*ecdsa.PublicKey) (*PKISecrets, error) {
+
+	signer, err := OpenSigner(keyURI)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, caCertPool, err := crypto.LoadAndVerifySigner(signer, certPEM, caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificates: %s", err)
+	}
+
+	return &PKISecrets{
+		PublicKeyPEM:     certPEM,
+		AuthorityPEM:     caPEM,
+		CertificateCache: certCache,
+		privateKey:       signer,
+		keyURI:           keyURI,
+		publicKey:        cert,
+		certPool:         caCertPool,
+	}, nil
+}
+
 // Type implements the interface Secrets
 func (p *PKISecrets) Type() PrivateSecretsType {
 	return PKIType
@@ -73,10 +103,14 @@ func (p *PKISecrets) DecodingKey(server string, ackCert interface{}, prevCert in
 
 	// If we have an inband certificate, return this one
 	if ackCert != nil {
-		return ackCert.(*x509.Certificate).PublicKey.(*ecdsa.PublicKey), nil
+		return ackCert.(*x509.Certificate).PublicKey, nil
 	}
 
 	// Otherwise, return the prevCert
+	if cert, ok := prevCert.(*x509.Certificate); ok {
+		return cert.PublicKey, nil
+	}
+
 	if prevCert != nil {
 		return prevCert, nil
 	}
@@ -103,7 +137,25 @@ func (p *PKISecrets) TransmittedKey() []byte {
 
 // AckSize returns the default size of an ACK packet
 func (p *PKISecrets) AckSize() uint32 {
-	return uint32(336)
+	return ackSize(322, p.privateKey)
+}
+
+// ackSize returns the size of an ACK packet signed with a key, given the size
+// with a P-256 key. The signatures of P-384 keys are longer, the ones of Ed25519
+// keys have the same size.
+func ackSize(size uint32, key interface{}) uint32 {
+
+	signer, ok := key.(gocrypto.Signer)
+	if !ok {
+		return size
+	}
+
+	if k, ok := signer.Public().(*ecdsa.PublicKey); ok && k.Curve == elliptic.P384() {
+		// 96 bytes instead of 64 bytes, in base64
+		return size + 42
+	}
+
+	return size
 }
 
 // PublicKeyAdd validates the parameter certificate.
@@ -116,9 +168,14 @@ func (p *PKISecrets) PublicKeyAdd(host string, newCert []byte) error {
 		return fmt.Errorf("unable to load certificate: %s", err)
 	}
 
+	key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unable to cache certificate: %T keys are transmitted in band", cert.PublicKey)
+	}
+
 	zap.L().Debug("Adding cert for host", zap.String("host", host))
 
-	p.CertificateCache[host] = cert.PublicKey.(*ecdsa.PublicKey)
+	p.CertificateCache[host] = key
 	return nil
 }
 
@@ -136,3 +193,8 @@ func (p *PKISecrets) TransmittedPEM() []byte {
 func (p *PKISecrets) EncodingPEM() []byte {
 	return p.PrivateKeyPEM
 }
+
+// PrivateKeyURI implements the interface SignerSecrets
+func (p *PKISecrets) PrivateKeyURI() string {
+	return p.keyURI
+}
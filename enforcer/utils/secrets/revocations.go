@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// Revoker is implemented by the secrets that reject the tokens of the revoked
+// certificates
+type Revoker interface {
+
+	// Revocations returns the certificates and the tokens that are revoked
+	Revocations() *Revocations
+
+	// SetRevocations replaces the revocations, so that they are kept when the
+	// secrets are rotated
+	SetRevocations(r *Revocations)
+}
+
+// Revocations holds the serial numbers of the revoked certificates and the
+// hashes of the revoked tokens
+type Revocations struct {
+	serials map[string]struct{}
+	tokens  map[string]struct{}
+	sync.RWMutex
+}
+
+// NewRevocations returns an empty list of revocations
+func NewRevocations() *Revocations {
+
+	return &Revocations{
+		serials: map[string]struct{}{},
+		tokens:  map[string]struct{}{},
+	}
+}
+
+// RevokeSerial revokes the certificate with the given serial number
+func (r *Revocations) RevokeSerial(serial *big.Int) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.serials[serial.String()] = struct{}{}
+}
+
+// RevokeToken revokes a token transmitted by a compromised enforcer
+func (r *Revocations) RevokeToken(token []byte) error {
+
+	if len(token) == 0 {
+		return errors.New("empty token")
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.tokens[tokenHash(token)] = struct{}{}
+
+	return nil
+}
+
+// AddCRL revokes the certificates of a PEM encoded certificate revocation
+// list. The list must be signed by one of the authorities.
+func (r *Revocations) AddCRL(crlPEM []byte, authorities []*x509.Certificate) error {
+
+	block, _ := pem.Decode(crlPEM)
+	if block == nil {
+		return fmt.Errorf("unable to parse pem block: %s", string(crlPEM))
+	}
+
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid crl: %s", err)
+	}
+
+	signed := false
+	for _, authority := range authorities {
+		if crl.CheckSignatureFrom(authority) == nil {
+			signed = true
+			break
+		}
+	}
+
+	if !signed {
+		return errors.New("crl is not signed by a known authority")
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, entry := range crl.RevokedCertificateEntries {
+		r.serials[entry.SerialNumber.String()] = struct{}{}
+	}
+
+	return nil
+}
+
+// Revoked returns true if the token or the certificate with the serial number
+// is revoked. The serial number is empty if it is not known.
+func (r *Revocations) Revoked(token []byte, serial string) bool {
+
+	r.RLock()
+	defer r.RUnlock()
+
+	if _, ok := r.tokens[tokenHash(token)]; ok {
+		return true
+	}
+
+	_, ok := r.serials[serial]
+
+	return ok && serial != ""
+}
+
+// List returns the serial numbers and the token hashes that are revoked, so
+// that they can be sent to the remote enforcers
+func (r *Revocations) List() (serials []string, tokenHashes []string) {
+
+	r.RLock()
+	defer r.RUnlock()
+
+	for serial := range r.serials {
+		serials = append(serials, serial)
+	}
+
+	for hash := range r.tokens {
+		tokenHashes = append(tokenHashes, hash)
+	}
+
+	return serials, tokenHashes
+}
+
+// Add adds serial numbers and token hashes returned by List
+func (r *Revocations) Add(serials []string, tokenHashes []string) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, serial := range serials {
+		r.serials[serial] = struct{}{}
+	}
+
+	for _, hash := range tokenHashes {
+		r.tokens[hash] = struct{}{}
+	}
+}
+
+// tokenHash returns the key of a token in the revocations
+func tokenHash(token []byte) string {
+
+	hash := sha256.Sum256(token)
+
+	return hex.EncodeToString(hash[:])
+}
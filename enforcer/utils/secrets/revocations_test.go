@@ -0,0 +1,180 @@
+package secrets
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/pkiverifier"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testAuthority is a CA that signs the certificates and the CRLs of the tests
+type testAuthority struct {
+	key   *ecdsa.PrivateKey
+	cert  *x509.Certificate
+	pem   []byte
+	count int64
+}
+
+func newTestAuthority() *testAuthority {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"example.org"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key) // nolint
+	cert, _ := x509.ParseCertificate(der)                                                  // nolint
+
+	return &testAuthority{
+		key:   key,
+		cert:  cert,
+		pem:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		count: 1,
+	}
+}
+
+// issue returns the key and the certificate PEMs of a new PU and its certificate
+func (a *testAuthority) issue() ([]byte, []byte, *x509.Certificate) {
+
+	a.count++
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)        // nolint
+	keyDER, _ := x509.MarshalECPrivateKey(key)                       // nolint
+	der, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{ // nolint
+		SerialNumber: big.NewInt(a.count),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}, a.cert, &key.PublicKey, a.key)
+	cert, _ := x509.ParseCertificate(der) // nolint
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		cert
+}
+
+// crl returns the PEM of a CRL of the certificates
+func (a *testAuthority) crl(revoked ...*x509.Certificate) []byte {
+
+	entries := []x509.RevocationListEntry{}
+	for _, cert := range revoked {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: cert.SerialNumber, RevocationTime: time.Now()})
+	}
+
+	der, _ := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{ // nolint
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, a.cert, a.key)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func TestRevocations(t *testing.T) {
+
+	ca := newTestAuthority()
+	_, _, cert := ca.issue()
+
+	Convey("Given empty revocations", t, func() {
+		r := NewRevocations()
+
+		Convey("When I revoke a token, it should be revoked", func() {
+			So(r.RevokeToken([]byte("token")), ShouldBeNil)
+			So(r.Revoked([]byte("token"), ""), ShouldBeTrue)
+			So(r.Revoked([]byte("other"), ""), ShouldBeFalse)
+		})
+
+		Convey("When I revoke an empty token, it should fail", func() {
+			So(r.RevokeToken([]byte{}), ShouldNotBeNil)
+		})
+
+		Convey("When I revoke a serial number, its tokens should be revoked", func() {
+			r.RevokeSerial(big.NewInt(42))
+			So(r.Revoked([]byte("token"), "42"), ShouldBeTrue)
+			So(r.Revoked([]byte("token"), "43"), ShouldBeFalse)
+			So(r.Revoked([]byte("token"), ""), ShouldBeFalse)
+		})
+
+		Convey("When I add a CRL of the authority, its certificates should be revoked", func() {
+			So(r.AddCRL(ca.crl(cert), []*x509.Certificate{ca.cert}), ShouldBeNil)
+			So(r.Revoked([]byte("token"), cert.SerialNumber.String()), ShouldBeTrue)
+		})
+
+		Convey("When I add a CRL of another authority, it should fail", func() {
+			So(r.AddCRL(newTestAuthority().crl(cert), []*x509.Certificate{ca.cert}), ShouldNotBeNil)
+			So(r.Revoked([]byte("token"), cert.SerialNumber.String()), ShouldBeFalse)
+		})
+
+		Convey("When I add an invalid CRL, it should fail", func() {
+			So(r.AddCRL([]byte("crl"), []*x509.Certificate{ca.cert}), ShouldNotBeNil)
+		})
+
+		Convey("When I add the list of other revocations, they should be revoked", func() {
+			other := NewRevocations()
+			other.RevokeSerial(big.NewInt(42))
+			So(other.RevokeToken([]byte("token")), ShouldBeNil)
+
+			r.Add(other.List())
+			So(r.Revoked([]byte("token"), ""), ShouldBeTrue)
+			So(r.Revoked([]byte("other"), "42"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestCompactPKIRevocations(t *testing.T) {
+
+	ca := newTestAuthority()
+	issuer := pkiverifier.NewPKIIssuer(ca.key)
+
+	keyPEM, certPEM, cert := ca.issue()
+	txKey, _ := issuer.CreateTokenFromCertificate(cert) // nolint
+
+	_, _, peerCert := ca.issue()
+	peerToken, _ := issuer.CreateTokenFromCertificate(peerCert) // nolint
+
+	Convey("Given compact PKI secrets", t, func() {
+		p, err := NewCompactPKIWithTokenCA(keyPEM, certPEM, ca.pem, [][]byte{ca.pem}, txKey)
+		So(err, ShouldBeNil)
+
+		_, err = p.VerifyPublicKey(peerToken)
+		So(err, ShouldBeNil)
+
+		Convey("When I revoke the token of a peer, it should be rejected", func() {
+			So(p.RevokeToken(peerToken), ShouldBeNil)
+			_, err := p.VerifyPublicKey(peerToken)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I add a CRL with the certificate of a peer, its token should be rejected", func() {
+			So(p.AddCRL(ca.crl(peerCert)), ShouldBeNil)
+			_, err := p.VerifyPublicKey(peerToken)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the secrets are rotated, the new secrets should keep the revocations", func() {
+			So(p.RevokeToken(peerToken), ShouldBeNil)
+
+			rotated, err := NewCompactPKIWithTokenCA(keyPEM, certPEM, ca.pem, [][]byte{ca.pem}, txKey)
+			So(err, ShouldBeNil)
+			rotated.SetRevocations(p.Revocations())
+
+			_, err = rotated.VerifyPublicKey(peerToken)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
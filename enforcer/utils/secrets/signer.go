@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	gocrypto "crypto"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SignerOpener opens the signer of a private key that never leaves its
+// device, like a key in an HSM or a TPM, from the URI of the key
+type SignerOpener func(uri string) (gocrypto.Signer, error)
+
+// SignerSecrets is implemented by the secrets whose private key is a signer.
+// The remote enforcers open the signer from the URI of the key instead of
+// receiving the key.
+type SignerSecrets interface {
+
+	// PrivateKeyURI returns the URI of the key of the signer, or an empty
+	// string if the private key is in memory
+	PrivateKeyURI() string
+}
+
+var (
+	signerOpeners     = map[string]SignerOpener{}
+	signerOpenersLock sync.RWMutex
+)
+
+// RegisterSignerOpener registers the opener of the keys whose URI has the
+// given scheme, like "pkcs11" for the URIs of RFC 7512. The opener must be
+// registered by the init of the application, so that it is also registered
+// in the remote enforcers.
+func RegisterSignerOpener(scheme string, opener SignerOpener) {
+
+	signerOpenersLock.Lock()
+	defer signerOpenersLock.Unlock()
+
+	signerOpeners[scheme] = opener
+}
+
+// OpenSigner opens the signer of a key URI with the opener of its scheme
+func OpenSigner(uri string) (gocrypto.Signer, error) {
+
+	parts := strings.SplitN(uri, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid key uri: %s", uri)
+	}
+
+	signerOpenersLock.RLock()
+	opener, ok := signerOpeners[parts[0]]
+	signerOpenersLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no signer opener for scheme %s", parts[0])
+	}
+
+	signer, err := opener(uri)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open signer %s: %s", uri, err)
+	}
+
+	if signer == nil {
+		return nil, errors.New("signer can not be nil")
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,82 @@
+package secrets
+
+import (
+	gocrypto "crypto"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/pkiverifier"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testSigner hides the type of a key, like the signer of a key in an HSM
+type testSigner struct {
+	key gocrypto.Signer
+}
+
+func (s *testSigner) Public() gocrypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *testSigner) Sign(rand io.Reader, digest []byte, opts gocrypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestSignerSecrets(t *testing.T) {
+
+	ca := newTestAuthority()
+	issuer := pkiverifier.NewPKIIssuer(ca.key)
+
+	keyPEM, certPEM, cert := ca.issue()
+	_, otherCertPEM, _ := ca.issue()
+	txKey, _ := issuer.CreateTokenFromCertificate(cert) // nolint
+
+	RegisterSignerOpener("test", func(uri string) (gocrypto.Signer, error) {
+		if uri != "test:key" {
+			return nil, errors.New("no such key")
+		}
+		key, err := crypto.LoadPrivateKey(keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		return &testSigner{key: key.(gocrypto.Signer)}, nil
+	})
+
+	Convey("When I create PKI secrets with the signer of a key, it should succeed", t, func() {
+		p, err := NewPKISecretsWithSigner("test:key", certPEM, ca.pem, nil)
+		So(err, ShouldBeNil)
+		So(p.PrivateKeyURI(), ShouldEqual, "test:key")
+		So(p.EncodingPEM(), ShouldBeEmpty)
+		So(p.EncodingKey(), ShouldHaveSameTypeAs, &testSigner{})
+		So(p.AckSize(), ShouldEqual, 322)
+	})
+
+	Convey("When I create compact PKI secrets with the signer of a key, it should succeed", t, func() {
+		p, err := NewCompactPKIWithSigner("test:key", certPEM, ca.pem, [][]byte{ca.pem}, txKey)
+		So(err, ShouldBeNil)
+		So(p.PrivateKeyURI(), ShouldEqual, "test:key")
+		So(p.EncodingPEM(), ShouldBeEmpty)
+		So(p.TransmittedKey(), ShouldResemble, txKey)
+	})
+
+	Convey("When I create secrets with the signer of another key than the one of the certificate, it should fail", t, func() {
+		_, err := NewPKISecretsWithSigner("test:key", otherCertPEM, ca.pem, nil)
+		So(err, ShouldNotBeNil)
+
+		_, err = NewCompactPKIWithSigner("test:key", otherCertPEM, ca.pem, [][]byte{ca.pem}, txKey)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When I create secrets with a key that can not be opened, it should fail", t, func() {
+		_, err := NewPKISecretsWithSigner("test:other", certPEM, ca.pem, nil)
+		So(err, ShouldNotBeNil)
+
+		_, err = NewPKISecretsWithSigner("pkcs11:object=key", certPEM, ca.pem, nil)
+		So(err, ShouldNotBeNil)
+
+		_, err = NewCompactPKIWithSigner("key", certPEM, ca.pem, [][]byte{ca.pem}, txKey)
+		So(err, ShouldNotBeNil)
+	})
+}
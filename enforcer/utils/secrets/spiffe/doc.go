@@ -0,0 +1,8 @@
+// Package spiffe provides the SVIDs of a SPIRE agent to the secrets of Trireme
+// through the SPIFFE Workload API.
+//
+// The Workload API client of go-spiffe needs a recent Go and gRPC, so the
+// package is only built with the spiffe build tag, and its dependencies must
+// be vendored by the programs that use it. Other SVID sources can be given to
+// secrets.NewSpiffeSecrets without it.
+package spiffe
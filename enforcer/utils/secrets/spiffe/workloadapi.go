@@ -0,0 +1,147 @@
+// +build spiffe
+
+package spiffe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"go.uber.org/zap"
+)
+
+// WorkloadAPISource is a secrets.SVIDSource that fetches the X509 SVIDs and the
+// trust bundles of the workload from the Workload API of a SPIRE agent
+type WorkloadAPISource struct {
+	client *workloadapi.Client
+}
+
+// NewWorkloadAPISource connects to the Workload API at the given address, like
+// unix:///run/spire/sockets/agent.sock. The address of the SPIFFE_ENDPOINT_SOCKET
+// environment variable is used if it is empty.
+func NewWorkloadAPISource(ctx context.Context, address string) (*WorkloadAPISource, error) {
+
+	var opts []workloadapi.ClientOption
+	if address != "" {
+		opts = append(opts, workloadapi.WithAddr(address))
+	}
+
+	client, err := workloadapi.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to workload api: %s", err)
+	}
+
+	return &WorkloadAPISource{client: client}, nil
+}
+
+// NewSpiffeSecrets returns the secrets of the SVID of the workload, that are
+// rotated with the SVID until the context is done
+func NewSpiffeSecrets(ctx context.Context, address string) (*secrets.SpiffeSecrets, error) {
+
+	source, err := NewWorkloadAPISource(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		source.Close() // nolint
+	}()
+
+	return secrets.NewSpiffeSecrets(ctx, source)
+}
+
+// FetchSVID implements secrets.SVIDSource
+func (s *WorkloadAPISource) FetchSVID(ctx context.Context) (*secrets.SVID, error) {
+
+	x509Context, err := s.client.FetchX509Context(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return svidFromX509Context(x509Context)
+}
+
+// WatchSVIDs implements secrets.SVIDSource
+func (s *WorkloadAPISource) WatchSVIDs(ctx context.Context, handler func(*secrets.SVID)) error {
+
+	return s.client.WatchX509Context(ctx, &watcher{handler: handler})
+}
+
+// Close closes the connection to the Workload API
+func (s *WorkloadAPISource) Close() error {
+
+	return s.client.Close()
+}
+
+// watcher converts the updates of the Workload API to SVIDs
+type watcher struct {
+	handler func(*secrets.SVID)
+}
+
+// OnX509ContextUpdate implements workloadapi.X509ContextWatcher
+func (w *watcher) OnX509ContextUpdate(x509Context *workloadapi.X509Context) {
+
+	svid, err := svidFromX509Context(x509Context)
+	if err != nil {
+		zap.L().Error("Invalid svid update", zap.Error(err))
+		return
+	}
+
+	w.handler(svid)
+}
+
+// OnX509ContextWatchError implements workloadapi.X509ContextWatcher
+func (w *watcher) OnX509ContextWatchError(err error) {
+
+	zap.L().Warn("Unable to watch svids", zap.Error(err))
+}
+
+// svidFromX509Context returns the PEMs of the default SVID of the context and
+// of the trust bundle of its trust domain
+func svidFromX509Context(x509Context *workloadapi.X509Context) (*secrets.SVID, error) {
+
+	svid := x509Context.DefaultSVID()
+	if svid == nil || len(svid.Certificates) == 0 {
+		return nil, errors.New("no svid")
+	}
+
+	// The tokens are signed with ES256
+	key, ok := svid.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("svid %s: private key is not an elliptic curve key", svid.ID)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("svid %s: %s", svid.ID, err)
+	}
+
+	bundle, err := x509Context.Bundles.GetX509BundleForTrustDomain(svid.ID.TrustDomain())
+	if err != nil {
+		return nil, fmt.Errorf("svid %s: %s", svid.ID, err)
+	}
+
+	return &secrets.SVID{
+		ID:        svid.ID.String(),
+		KeyPEM:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		CertPEM:   certificatesPEM(svid.Certificates[:1]),
+		BundlePEM: certificatesPEM(bundle.X509Authorities()),
+	}, nil
+}
+
+// certificatesPEM returns the PEM of a list of certificates
+func certificatesPEM(certs []*x509.Certificate) []byte {
+
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return data
+}
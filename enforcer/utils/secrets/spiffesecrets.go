@@ -0,0 +1,167 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// SVID holds the PEMs of an X509 SVID and of the trust bundle of its trust domain
+type SVID struct {
+	ID        string
+	KeyPEM    []byte
+	CertPEM   []byte
+	BundlePEM []byte
+}
+
+// SVIDSource is a source of X509 SVIDs, like the SPIFFE Workload API of a
+// SPIRE agent
+type SVIDSource interface {
+
+	// FetchSVID returns the current SVID of the workload
+	FetchSVID(ctx context.Context) (*SVID, error)
+
+	// WatchSVIDs calls the handler with the SVID of the workload every time it
+	// is rotated, until the context is done
+	WatchSVIDs(ctx context.Context, handler func(*SVID)) error
+}
+
+// SpiffeSecrets holds the PKI information of the SVID of a SPIFFE workload
+// and replaces it when the SVID or the trust bundle is rotated
+type SpiffeSecrets struct {
+	id  string
+	pki *PKISecrets
+	sync.RWMutex
+}
+
+// NewSpiffeSecrets creates new secrets for PKI implementations from the SVIDs
+// of the source. The secrets are rotated with the SVIDs until the context is
+// done.
+func NewSpiffeSecrets(ctx context.Context, source SVIDSource) (*SpiffeSecrets, error) {
+
+	if source == nil {
+		return nil, errors.New("svid source can not be nil")
+	}
+
+	svid, err := source.FetchSVID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch svid: %s", err)
+	}
+
+	p := &SpiffeSecrets{}
+	if err := p.update(svid); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if err := source.WatchSVIDs(ctx, p.rotate); err != nil && ctx.Err() == nil {
+			zap.L().Error("Stopped watching svids", zap.String("id", p.ID()), zap.Error(err))
+		}
+	}()
+
+	return p, nil
+}
+
+// update replaces the PKI information with the one of the SVID
+func (p *SpiffeSecrets) update(svid *SVID) error {
+
+	if svid == nil {
+		return errors.New("no svid")
+	}
+
+	pki, err := NewPKISecrets(svid.KeyPEM, svid.CertPEM, svid.BundlePEM, nil)
+	if err != nil {
+		return fmt.Errorf("invalid svid %s: %s", svid.ID, err)
+	}
+
+	p.Lock()
+	p.id = svid.ID
+	p.pki = pki
+	p.Unlock()
+
+	return nil
+}
+
+// rotate is the handler of the SVIDs of the source. The current SVID is kept
+// if a rotated one is invalid.
+func (p *SpiffeSecrets) rotate(svid *SVID) {
+
+	if err := p.update(svid); err != nil {
+		zap.L().Error("Unable to rotate svid", zap.Error(err))
+		return
+	}
+
+	zap.L().Debug("Rotated svid", zap.String("id", svid.ID))
+}
+
+// current returns the PKI information of the current SVID
+func (p *SpiffeSecrets) current() *PKISecrets {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.pki
+}
+
+// ID returns the SPIFFE ID of the current SVID
+func (p *SpiffeSecrets) ID() string {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.id
+}
+
+// Type implements the interface Secrets. The secrets of an SVID are PKI
+// secrets, so that they are sent to the remote enforcers as PEMs.
+func (p *SpiffeSecrets) Type() PrivateSecretsType {
+	return PKIType
+}
+
+// EncodingKey returns the private key
+func (p *SpiffeSecrets) EncodingKey() interface{} {
+	return p.current().EncodingKey()
+}
+
+// PublicKey returns the public key
+func (p *SpiffeSecrets) PublicKey() interface{} {
+	return p.current().PublicKey()
+}
+
+// DecodingKey returns the public key
+func (p *SpiffeSecrets) DecodingKey(server string, ackCert interface{}, prevCert interface{}) (interface{}, error) {
+	return p.current().DecodingKey(server, ackCert, prevCert)
+}
+
+// VerifyPublicKey verifies if the inband public key is correct.
+func (p *SpiffeSecrets) VerifyPublicKey(pkey []byte) (interface{}, error) {
+	return p.current().VerifyPublicKey(pkey)
+}
+
+// TransmittedKey returns the PEM of the certificate of the SVID
+func (p *SpiffeSecrets) TransmittedKey() []byte {
+	return p.current().TransmittedKey()
+}
+
+// AckSize returns the default size of an ACK packet
+func (p *SpiffeSecrets) AckSize() uint32 {
+	return p.current().AckSize()
+}
+
+// AuthPEM returns the PEM of the trust bundle
+func (p *SpiffeSecrets) AuthPEM() []byte {
+	return p.current().AuthPEM()
+}
+
+// TransmittedPEM returns the PEM certificate that is transmitted
+func (p *SpiffeSecrets) TransmittedPEM() []byte {
+	return p.current().TransmittedPEM()
+}
+
+// EncodingPEM returns the key PEM that is used for encoding
+func (p *SpiffeSecrets) EncodingPEM() []byte {
+	return p.current().EncodingPEM()
+}
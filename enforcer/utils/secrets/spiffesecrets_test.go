@@ -0,0 +1,139 @@
+package secrets
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testSVIDSource struct {
+	svid    *SVID
+	err     error
+	updates chan *SVID
+}
+
+func (s *testSVIDSource) FetchSVID(ctx context.Context) (*SVID, error) {
+	return s.svid, s.err
+}
+
+func (s *testSVIDSource) WatchSVIDs(ctx context.Context, handler func(*SVID)) error {
+	for {
+		select {
+		case svid := <-s.updates:
+			handler(svid)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// testSVID returns an SVID signed by a new CA
+func testSVID(id string) *SVID {
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"example.org"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey) // nolint
+	ca, _ := x509.ParseCertificate(caDER)                                                            // nolint
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+	keyDER, _ := x509.MarshalECPrivateKey(key)                // nolint
+	certDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}, ca, &key.PublicKey, caKey) // nolint
+
+	return &SVID{
+		ID:        id,
+		KeyPEM:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		CertPEM:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		BundlePEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+	}
+}
+
+func TestNewSpiffeSecrets(t *testing.T) {
+
+	svid := testSVID("spiffe://example.org/web")
+
+	Convey("When I create spiffe secrets from a valid svid, it should succeed", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		p, err := NewSpiffeSecrets(ctx, &testSVIDSource{svid: svid, updates: make(chan *SVID)})
+		So(err, ShouldBeNil)
+		So(p.ID(), ShouldEqual, "spiffe://example.org/web")
+		So(p.Type(), ShouldEqual, PKIType)
+		So(p.TransmittedKey(), ShouldResemble, svid.CertPEM)
+		So(p.EncodingPEM(), ShouldResemble, svid.KeyPEM)
+		So(p.AuthPEM(), ShouldResemble, svid.BundlePEM)
+
+		_, err = p.VerifyPublicKey(svid.CertPEM)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("When I create spiffe secrets and the svid can not be fetched, it should fail", t, func() {
+		p, err := NewSpiffeSecrets(context.Background(), &testSVIDSource{err: errors.New("no agent")})
+		So(err, ShouldNotBeNil)
+		So(p, ShouldBeNil)
+	})
+
+	Convey("When I create spiffe secrets from an invalid svid, it should fail", t, func() {
+		p, err := NewSpiffeSecrets(context.Background(), &testSVIDSource{svid: &SVID{KeyPEM: svid.KeyPEM, CertPEM: svid.CertPEM, BundlePEM: testSVID("").BundlePEM}})
+		So(err, ShouldNotBeNil)
+		So(p, ShouldBeNil)
+	})
+
+	Convey("Given spiffe secrets", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		source := &testSVIDSource{svid: svid, updates: make(chan *SVID)}
+		p, err := NewSpiffeSecrets(ctx, source)
+		So(err, ShouldBeNil)
+
+		Convey("When the svid is rotated, the secrets should be the ones of the new svid", func() {
+			rotated := testSVID("spiffe://example.org/db")
+			source.updates <- rotated
+
+			So(func() bool {
+				for i := 0; i < 100 && p.ID() != "spiffe://example.org/db"; i++ {
+					time.Sleep(10 * time.Millisecond)
+				}
+				return p.ID() == "spiffe://example.org/db"
+			}(), ShouldBeTrue)
+			So(p.TransmittedKey(), ShouldResemble, rotated.CertPEM)
+			So(p.AuthPEM(), ShouldResemble, rotated.BundlePEM)
+
+			_, err := p.VerifyPublicKey(svid.CertPEM)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the rotated svid is invalid, the secrets should not change", func() {
+			source.updates <- &SVID{ID: "spiffe://example.org/db", KeyPEM: []byte("key")}
+			source.updates <- svid
+
+			So(p.ID(), ShouldEqual, "spiffe://example.org/web")
+			So(p.TransmittedKey(), ShouldResemble, svid.CertPEM)
+		})
+	})
+}
@@ -0,0 +1,210 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultWatchInterval is the default interval at which the files of a
+// FileWatcher are read
+const DefaultWatchInterval = 10 * time.Second
+
+// Updater updates the secrets of the enforcers, like Trireme
+type Updater interface {
+	UpdateSecrets(secrets Secrets) error
+}
+
+// LoadFunc creates secrets from the content of the watched files, in the
+// order of the files
+type LoadFunc func(pems [][]byte) (Secrets, error)
+
+// FileWatcher watches the PEM files of secrets and updates the secrets of the
+// enforcers when the files change, so that the certificates can be rotated
+// by replacing the files
+type FileWatcher struct {
+	files    []string
+	load     LoadFunc
+	updater  Updater
+	interval time.Duration
+	pems     [][]byte
+	stop     chan struct{}
+	sync.Mutex
+}
+
+// NewFileWatcher returns a watcher of the given files. The secrets are created
+// with the load function and given to the updater when one of the files
+// changes. The files are read at the given interval, or at
+// DefaultWatchInterval if it is zero.
+func NewFileWatcher(files []string, load LoadFunc, updater Updater, interval time.Duration) (*FileWatcher, error) {
+
+	if len(files) == 0 {
+		return nil, errors.New("no files to watch")
+	}
+
+	if load == nil || updater == nil {
+		return nil, errors.New("load function and updater can not be nil")
+	}
+
+	if interval == 0 {
+		interval = DefaultWatchInterval
+	}
+
+	return &FileWatcher{
+		files:    files,
+		load:     load,
+		updater:  updater,
+		interval: interval,
+	}, nil
+}
+
+// NewPKIFileWatcher returns a watcher of the files of PKI secrets. The
+// certificates of the secrets are transmitted on the wire.
+func NewPKIFileWatcher(keyFile, certFile, caFile string, updater Updater, interval time.Duration) (*FileWatcher, error) {
+
+	return NewFileWatcher(
+		[]string{keyFile, certFile, caFile},
+		func(pems [][]byte) (Secrets, error) {
+			return NewPKISecrets(pems[0], pems[1], pems[2], nil)
+		},
+		updater,
+		interval,
+	)
+}
+
+// NewCompactPKIFileWatcher returns a watcher of the files of compact PKI
+// secrets
+func NewCompactPKIFileWatcher(keyFile, certFile, caFile, tokenFile string, tokenCAFiles []string, updater Updater, interval time.Duration) (*FileWatcher, error) {
+
+	return NewFileWatcher(
+		append([]string{keyFile, certFile, caFile, tokenFile}, tokenCAFiles...),
+		func(pems [][]byte) (Secrets, error) {
+			return NewCompactPKIWithTokenCA(pems[0], pems[1], pems[2], pems[4:], pems[3])
+		},
+		updater,
+		interval,
+	)
+}
+
+// Start reads the files and watches them until the watcher is stopped. The
+// secrets are not updated with the files that are read when it starts.
+func (w *FileWatcher) Start() error {
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.stop != nil {
+		return errors.New("watcher already started")
+	}
+
+	pems, err := w.read()
+	if err != nil {
+		return err
+	}
+
+	w.pems = pems
+	w.stop = make(chan struct{})
+
+	go w.watch(w.stop)
+
+	return nil
+}
+
+// Stop stops watching the files
+func (w *FileWatcher) Stop() {
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+// watch reads the files periodically until it is stopped
+func (w *FileWatcher) watch(stop chan struct{}) {
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := w.Check(); err != nil {
+				zap.L().Error("Unable to rotate secrets", zap.Strings("files", w.files), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Check reads the files and updates the secrets if they changed since they
+// were last read. The files are read again at the next check if they are
+// being written and the secrets can not be created.
+func (w *FileWatcher) Check() error {
+
+	w.Lock()
+	defer w.Unlock()
+
+	pems, err := w.read()
+	if err != nil {
+		return err
+	}
+
+	if equalPEMs(pems, w.pems) {
+		return nil
+	}
+
+	w.pems = pems
+
+	secrets, err := w.load(pems)
+	if err != nil {
+		return fmt.Errorf("invalid secrets: %s", err)
+	}
+
+	if err := w.updater.UpdateSecrets(secrets); err != nil {
+		return fmt.Errorf("unable to update secrets: %s", err)
+	}
+
+	zap.L().Info("Rotated secrets", zap.Strings("files", w.files))
+
+	return nil
+}
+
+// read returns the content of the files
+func (w *FileWatcher) read() ([][]byte, error) {
+
+	pems := make([][]byte, len(w.files))
+	for i, file := range w.files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %s", file, err)
+		}
+		pems[i] = data
+	}
+
+	return pems, nil
+}
+
+// equalPEMs returns true if the contents of the files are the same
+func equalPEMs(a, b [][]byte) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
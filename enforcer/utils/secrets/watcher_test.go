@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testUpdater struct {
+	secrets []Secrets
+	err     error
+}
+
+func (u *testUpdater) UpdateSecrets(s Secrets) error {
+	u.secrets = append(u.secrets, s)
+	return u.err
+}
+
+func writeSVID(dir string, svid *SVID) {
+	ioutil.WriteFile(filepath.Join(dir, "key.pem"), svid.KeyPEM, 0600)   // nolint
+	ioutil.WriteFile(filepath.Join(dir, "cert.pem"), svid.CertPEM, 0600) // nolint
+	ioutil.WriteFile(filepath.Join(dir, "ca.pem"), svid.BundlePEM, 0600) // nolint
+}
+
+func TestFileWatcher(t *testing.T) {
+
+	Convey("When I create a file watcher without files, it should fail", t, func() {
+		w, err := NewFileWatcher(nil, func([][]byte) (Secrets, error) { return nil, nil }, &testUpdater{}, 0)
+		So(err, ShouldNotBeNil)
+		So(w, ShouldBeNil)
+	})
+
+	Convey("Given a watcher of the files of PKI secrets", t, func() {
+		dir, err := ioutil.TempDir("", "secrets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		writeSVID(dir, testSVID("node"))
+
+		updater := &testUpdater{}
+		w, err := NewPKIFileWatcher(filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem"), filepath.Join(dir, "ca.pem"), updater, time.Hour)
+		So(err, ShouldBeNil)
+		So(w.Start(), ShouldBeNil)
+		defer w.Stop()
+
+		Convey("When the files do not change, the secrets should not be updated", func() {
+			So(w.Check(), ShouldBeNil)
+			So(updater.secrets, ShouldBeEmpty)
+		})
+
+		Convey("When the files change, the secrets should be updated", func() {
+			rotated := testSVID("node")
+			writeSVID(dir, rotated)
+
+			So(w.Check(), ShouldBeNil)
+			So(updater.secrets, ShouldHaveLength, 1)
+			So(updater.secrets[0].TransmittedKey(), ShouldResemble, rotated.CertPEM)
+
+			Convey("And they should be updated once", func() {
+				So(w.Check(), ShouldBeNil)
+				So(updater.secrets, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When only the key changes, the secrets should be updated when the certificate changes", func() {
+			rotated := testSVID("node")
+			ioutil.WriteFile(filepath.Join(dir, "key.pem"), rotated.KeyPEM, 0600) // nolint
+
+			So(w.Check(), ShouldNotBeNil)
+			So(updater.secrets, ShouldBeEmpty)
+
+			writeSVID(dir, rotated)
+			So(w.Check(), ShouldBeNil)
+			So(updater.secrets, ShouldHaveLength, 1)
+		})
+
+		Convey("When a file is removed, the check should fail", func() {
+			So(os.Remove(filepath.Join(dir, "ca.pem")), ShouldBeNil)
+			So(w.Check(), ShouldNotBeNil)
+			So(updater.secrets, ShouldBeEmpty)
+		})
+
+		Convey("When the updater fails, the check should fail", func() {
+			updater.err = errors.New("failed")
+			writeSVID(dir, testSVID("node"))
+			So(w.Check(), ShouldNotBeNil)
+		})
+
+		Convey("When I start it again, it should fail", func() {
+			So(w.Start(), ShouldNotBeNil)
+		})
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/jwtsigning"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
@@ -32,8 +33,13 @@ type JWTConfig struct {
 	ValidityPeriod time.Duration
 	// Issuer is the server that issues the JWT
 	Issuer string
-	// signMethod is the method used to sign the JWT
+	// signMethod is the method used to sign the JWT. It is nil with PKI
+	// secrets, whose method is the one of their current key
 	signMethod jwt.SigningMethod
+	// parser parses the JWT with the methods that are accepted
+	parser *jwt.Parser
+	// format is the format of the tokens of the Syn and SynAck packets
+	format Format
 	// secrets is the secrets used for signing and verifying the JWT
 	secrets secrets.Secrets
 	// cache test
@@ -52,6 +58,7 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 	}
 
 	var signMethod jwt.SigningMethod
+	parser := &jwt.Parser{}
 
 	if s == nil {
 		return nil, errors.New("secrets can not be nil")
@@ -59,7 +66,12 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 
 	switch s.Type() {
 	case secrets.PKIType, secrets.PKICompactType:
-		signMethod = jwt.SigningMethodES256
+		// The receivers verify the tokens with the method of the key of
+		// their issuer, which is in the header of the tokens
+		if _, err := jwtsigning.ForKey(s.EncodingKey()); err != nil {
+			return nil, err
+		}
+		parser.ValidMethods = jwtsigning.ValidMethods
 	case secrets.PSKType:
 		signMethod = jwt.SigningMethodHS256
 	default:
@@ -70,6 +82,7 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 		ValidityPeriod: validity,
 		Issuer:         issuer,
 		signMethod:     signMethod,
+		parser:         parser,
 		secrets:        s,
 		tokenCache:     cache.NewCacheWithExpiration("JWTTokenCache", time.Millisecond*500),
 	}, nil
@@ -79,6 +92,10 @@ func NewJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfi
 // key. It also randomizes the source nonce of the token. It returns back the token and the private key.
 func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) (token []byte, nonce []byte, err error) {
 
+	if !isAck && c.format == StandardFormat {
+		return c.createStandard(claims)
+	}
+
 	// Combine the application claims with the standard claims
 	allclaims := &JWTClaims{
 		claims,
@@ -88,8 +105,13 @@ func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) (token [
 		},
 	}
 
+	signMethod, err := c.signingMethod()
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+
 	// Create the token and sign with our key
-	strtoken, err := jwt.NewWithClaims(c.signMethod, allclaims).SignedString(c.secrets.EncodingKey())
+	strtoken, err := jwt.NewWithClaims(signMethod, allclaims).SignedString(c.secrets.EncodingKey())
 	if err != nil {
 		return []byte{}, []byte{}, err
 	}
@@ -131,11 +153,25 @@ func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) (token [
 
 }
 
+// signingMethod returns the method the tokens are signed with
+func (c *JWTConfig) signingMethod() (jwt.SigningMethod, error) {
+
+	if c.signMethod != nil {
+		return c.signMethod, nil
+	}
+
+	return jwtsigning.ForKey(c.secrets.EncodingKey())
+}
+
 // Decode  takes as argument the JWT token and the certificate of the issuer.
 // First it verifies the certificate with the local CA pool, and the decodes
 // the JWT if the certificate is trusted
 func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (claims *ConnectionClaims, nonce []byte, publicKey interface{}, err error) {
 
+	if !isAck && isStandard(data) {
+		return c.decodeStandard(data, previousCert)
+	}
+
 	var ackCert interface{}
 
 	token := data
@@ -177,7 +213,7 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (c
 	}
 
 	// Parse the JWT token with the public key recovered
-	jwttoken, err := jwt.ParseWithClaims(string(token), jwtClaims, func(token *jwt.Token) (interface{}, error) {
+	jwttoken, err := c.parser.ParseWithClaims(string(token), jwtClaims, func(token *jwt.Token) (interface{}, error) {
 		server := token.Claims.(*JWTClaims).Issuer
 		server = strings.Trim(server, " ")
 		return c.secrets.DecodingKey(server, ackCert, previousCert)
@@ -199,6 +235,10 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (c
 // Randomize adds a nonce to an existing token. Returns the nonce
 func (c *JWTConfig) Randomize(token []byte) (nonce []byte, err error) {
 
+	if isStandard(token) {
+		return []byte{}, errors.New("standard tokens are signed with their nonce")
+	}
+
 	if len(token) < tokenPosition {
 		return []byte{}, errors.New("token is too small")
 	}
@@ -216,6 +256,10 @@ func (c *JWTConfig) Randomize(token []byte) (nonce []byte, err error) {
 // RetrieveNonce returns the nonce of a token. It copies the value
 func (c *JWTConfig) RetrieveNonce(token []byte) ([]byte, error) {
 
+	if isStandard(token) {
+		return standardNonce(token)
+	}
+
 	if len(token) < tokenPosition {
 		return []byte{}, errors.New("invalid token")
 	}
@@ -225,3 +269,28 @@ func (c *JWTConfig) RetrieveNonce(token []byte) ([]byte, error) {
 
 	return nonce, nil
 }
+
+// TransmittedKey returns a copy of the public key that was transmitted with a
+// token of a Syn or SynAck packet, as it is given to VerifyPublicKey of the
+// secrets. It is empty if the sender did not transmit its key.
+func TransmittedKey(token []byte) ([]byte, error) {
+
+	if isStandard(token) {
+		jwttoken, _, err := (&jwt.Parser{}).ParseUnverified(string(token), &standardClaims{})
+		if err != nil {
+			return []byte{}, fmt.Errorf("invalid token: %s", err)
+		}
+		return standardPublicKey(jwttoken)
+	}
+
+	if len(token) < tokenPosition {
+		return []byte{}, errors.New("invalid token length")
+	}
+
+	tokenLength := int(binary.BigEndian.Uint16(token[0:noncePosition]))
+	if len(token) < tokenPosition+tokenLength+1 {
+		return []byte{}, errors.New("invalid token length")
+	}
+
+	return append([]byte{}, token[tokenPosition+tokenLength+1:]...), nil
+}
@@ -1,7 +1,15 @@
 package tokens
 
 import (
+	gocrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +18,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/utils/crypto"
 	jwt "github.com/dgrijalva/jwt-go"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ed25519"
 )
 
 var (
@@ -163,6 +172,26 @@ func TestCreateAndVerifyPSK(t *testing.T) {
 			So(recoveredClaims.T, ShouldBeNil)
 		})
 
+		Convey("Given a signature request with a user token", func() {
+			claims := defaultClaims
+			claims.UT = "usertoken"
+			token, _, err1 := jwtConfig.CreateAndSign(false, &claims)
+			recoveredClaims, _, _, err2 := jwtConfig.Decode(false, token, nil)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+			So(recoveredClaims.UT, ShouldEqual, "usertoken")
+		})
+
+		Convey("Given a signature request with the claims of the application", func() {
+			claims := defaultClaims
+			claims.CC = map[string]string{"image": "sha256:1234"}
+			token, _, err1 := jwtConfig.CreateAndSign(false, &claims)
+			recoveredClaims, _, _, err2 := jwtConfig.Decode(false, token, nil)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+			So(recoveredClaims.CC, ShouldResemble, claims.CC)
+		})
+
 		Convey("Given a signature request with a bad packet ", func() {
 			recoveredClaims, _, _, err := jwtConfig.Decode(false, nil, nil)
 			So(err, ShouldNotBeNil)
@@ -321,3 +350,203 @@ func TestRetrieveNonce(t *testing.T) {
 		})
 	})
 }
+
+// newTestPKISecrets returns PKI secrets with a new key of the given type,
+// signed by the CA
+func newTestPKISecrets(key interface{}, caKey *ecdsa.PrivateKey, ca *x509.Certificate, caPEM []byte) secrets.Secrets {
+
+	var public interface{}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		public = &k.PublicKey
+	case ed25519.PrivateKey:
+		public = k.Public()
+	}
+
+	certDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{ // nolint
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, public, caKey)
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key) // nolint
+
+	s, err := secrets.NewPKISecrets(
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		caPEM,
+		nil,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func TestCreateAndVerifyPKIAlgorithms(t *testing.T) {
+	Convey("Given JWT engines with P-256, P-384 and Ed25519 keys signed by the same CA", t, func() {
+		caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey) // nolint
+		ca, _ := x509.ParseCertificate(caDER)                                                            // nolint
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+		p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader) // nolint
+		_, ed, _ := ed25519.GenerateKey(rand.Reader)               // nolint
+
+		engines := map[string]*JWTConfig{}
+		for alg, key := range map[string]interface{}{"ES256": p256, "ES384": p384, "EdDSA": ed} {
+			s := newTestPKISecrets(key, caKey, ca, caPEM)
+			engine, err := NewJWT(validity, "TRIREME", s)
+			So(err, ShouldBeNil)
+			engines[alg] = engine
+		}
+
+		Convey("The tokens should be verified whatever the algorithms of the issuer and the receiver", func() {
+			// The nonces of the datapath
+			ackClaims := ConnectionClaims{RMT: []byte(rmt), LCL: []byte(lcl + "7")}
+
+			for alg, issuer := range engines {
+				for _, receiver := range engines {
+					token, _, err := issuer.CreateAndSign(false, &defaultClaims)
+					So(err, ShouldBeNil)
+
+					claims, _, key, err := receiver.Decode(false, token, nil)
+					So(err, ShouldBeNil)
+					So(claims.RMT, ShouldResemble, []byte(rmt))
+
+					ack, _, err := issuer.CreateAndSign(true, &ackClaims)
+					So(err, ShouldBeNil)
+					So(len(ack), ShouldEqual, issuer.secrets.AckSize())
+
+					header, _ := jwt.DecodeSegment(string(ack[:strings.Index(string(ack), ".")])) // nolint
+					So(string(header), ShouldContainSubstring, `"alg":"`+alg+`"`)
+
+					claims, _, _, err = receiver.Decode(true, ack, key)
+					So(err, ShouldBeNil)
+					So(claims.LCL, ShouldResemble, ackClaims.LCL)
+				}
+			}
+		})
+
+		Convey("The transmitted key of a token should be verified by the secrets of the receiver", func() {
+			token, _, err := engines["ES384"].CreateAndSign(false, &defaultClaims)
+			So(err, ShouldBeNil)
+
+			txKey, err := TransmittedKey(token)
+			So(err, ShouldBeNil)
+			So(txKey, ShouldNotBeEmpty)
+
+			_, err = engines["ES256"].secrets.VerifyPublicKey(txKey)
+			So(err, ShouldBeNil)
+
+			_, err = TransmittedKey([]byte("short"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The tokens of another algorithm should be rejected", func() {
+			pskConfig, _ := NewJWT(validity, "TRIREME", secrets.NewPSKSecrets(psk))
+			token, _, err := pskConfig.CreateAndSign(true, &ackClaims)
+			So(err, ShouldBeNil)
+
+			_, _, _, err = engines["ES256"].Decode(true, token, &p256.PublicKey)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The tokens signed with another key than the one of the certificate should be rejected", func() {
+			ack, _, err := engines["ES384"].CreateAndSign(true, &ackClaims)
+			So(err, ShouldBeNil)
+
+			other, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader) // nolint
+			_, _, _, err = engines["ES256"].Decode(true, ack, &other.PublicKey)
+			So(err, ShouldNotBeNil)
+
+			_, _, _, err = engines["ES256"].Decode(true, ack, &p256.PublicKey)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// testSigner hides the type of a key, like the signer of a key in an HSM
+type testSigner struct {
+	key gocrypto.Signer
+}
+
+func (s *testSigner) Public() gocrypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *testSigner) Sign(rand io.Reader, digest []byte, opts gocrypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestCreateAndVerifyPKISigner(t *testing.T) {
+	Convey("Given a JWT engine with the signer of a P-384 key and one with a P-256 key", t, func() {
+		caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey) // nolint
+		ca, _ := x509.ParseCertificate(caDER)                                                            // nolint
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+		key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)            // nolint
+		certDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{ // nolint
+			SerialNumber: big.NewInt(3),
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}, ca, &key.PublicKey, caKey)
+
+		secrets.RegisterSignerOpener("tokentest", func(uri string) (gocrypto.Signer, error) {
+			return &testSigner{key: key}, nil
+		})
+
+		s, err := secrets.NewPKISecretsWithSigner("tokentest:key", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), caPEM, nil)
+		So(err, ShouldBeNil)
+
+		signerEngine, err := NewJWT(validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+
+		p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		keyEngine, err := NewJWT(validity, "TRIREME", newTestPKISecrets(p256, caKey, ca, caPEM))
+		So(err, ShouldBeNil)
+
+		Convey("The tokens should be verified in both directions", func() {
+			ackClaims := ConnectionClaims{RMT: []byte(rmt), LCL: []byte(lcl + "7")}
+
+			for _, engines := range [][2]*JWTConfig{{signerEngine, keyEngine}, {keyEngine, signerEngine}} {
+				issuer, receiver := engines[0], engines[1]
+
+				token, _, err := issuer.CreateAndSign(false, &defaultClaims)
+				So(err, ShouldBeNil)
+
+				claims, _, key, err := receiver.Decode(false, token, nil)
+				So(err, ShouldBeNil)
+				So(claims.RMT, ShouldResemble, []byte(rmt))
+
+				ack, _, err := issuer.CreateAndSign(true, &ackClaims)
+				So(err, ShouldBeNil)
+				So(len(ack), ShouldEqual, issuer.secrets.AckSize())
+
+				claims, _, _, err = receiver.Decode(true, ack, key)
+				So(err, ShouldBeNil)
+				So(claims.LCL, ShouldResemble, ackClaims.LCL)
+			}
+		})
+	})
+}
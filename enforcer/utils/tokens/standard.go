@@ -0,0 +1,217 @@
+package tokens
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/crypto"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Format is the encoding of the tokens of the Syn and SynAck packets
+type Format int
+
+const (
+	// CompactFormat frames the JWT with its nonce and the public key of its
+	// issuer. It is the default format.
+	CompactFormat Format = iota
+	// StandardFormat emits standard JWTs, with the nonce in the claims and
+	// the certificate of the issuer in the header, so that the identities
+	// can be validated by any JWT library
+	StandardFormat
+)
+
+// standardPrefix is the prefix of the encoded header of the standard tokens.
+// The compact tokens start with the length of the JWT, which is never that
+// long, so the formats can be told apart.
+var standardPrefix = []byte("eyJ")
+
+// standardClaims are the claims of the tokens in the standard format. The
+// subject is the context ID of the PU.
+type standardClaims struct {
+	Tags  []string          `json:"tags,omitempty"`
+	Nonce []byte            `json:"nonce"`
+	RMT   []byte            `json:"rmt,omitempty"`
+	EK    []byte            `json:"ek,omitempty"`
+	UT    string            `json:"ut,omitempty"`
+	CC    map[string]string `json:"cc,omitempty"`
+	jwt.StandardClaims
+}
+
+// New returns the token engine of the format
+func New(format Format, validity time.Duration, issuer string, s secrets.Secrets) (TokenEngine, error) {
+
+	switch format {
+	case CompactFormat:
+		return NewJWT(validity, issuer, s)
+	case StandardFormat:
+		return NewStandardJWT(validity, issuer, s)
+	default:
+		return nil, fmt.Errorf("unknown token format: %d", format)
+	}
+}
+
+// NewStandardJWT creates a new JWT token processor that emits the tokens of the
+// Syn and SynAck packets in the standard format. It decodes the tokens of both
+// formats, and the Ack tokens are the same in both formats.
+func NewStandardJWT(validity time.Duration, issuer string, s secrets.Secrets) (*JWTConfig, error) {
+
+	c, err := NewJWT(validity, issuer, s)
+	if err != nil {
+		return nil, err
+	}
+
+	c.format = StandardFormat
+
+	return c, nil
+}
+
+// isStandard returns true if the token is in the standard format
+func isStandard(token []byte) bool {
+
+	return bytes.HasPrefix(token, standardPrefix)
+}
+
+// createStandard creates and signs a token in the standard format. The nonce
+// is signed with the token, so the tokens can not be randomized.
+func (c *JWTConfig) createStandard(claims *ConnectionClaims) (token []byte, nonce []byte, err error) {
+
+	nonce, err = crypto.GenerateRandomBytes(NonceLength)
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+
+	now := time.Now()
+	allclaims := &standardClaims{
+		Nonce: nonce,
+		RMT:   claims.RMT,
+		EK:    claims.EK,
+		UT:    claims.UT,
+		CC:    claims.CC,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    strings.TrimRight(c.Issuer, " "),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(c.ValidityPeriod).Unix(),
+		},
+	}
+
+	if claims.T != nil {
+		allclaims.Tags = claims.T.GetSlice()
+		allclaims.Subject, _ = claims.T.Get(enforcerconstants.TransmitterLabel)
+	}
+
+	signMethod, err := c.signingMethod()
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+
+	jwttoken := jwt.NewWithClaims(signMethod, allclaims)
+
+	// The certificates are in the x5c header of RFC 7515. The other public
+	// keys, like the tokens of the compact PKI, are sent as they are.
+	if txKey := c.secrets.TransmittedKey(); len(txKey) > 0 {
+		if block, _ := pem.Decode(txKey); block != nil && block.Type == "CERTIFICATE" {
+			jwttoken.Header["x5c"] = []string{base64.StdEncoding.EncodeToString(block.Bytes)}
+		} else {
+			jwttoken.Header["tkey"] = string(txKey)
+		}
+	}
+
+	strtoken, err := jwttoken.SignedString(c.secrets.EncodingKey())
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+
+	return []byte(strtoken), nonce, nil
+}
+
+// decodeStandard verifies a token in the standard format with the public key
+// in its header
+func (c *JWTConfig) decodeStandard(data []byte, previousCert interface{}) (claims *ConnectionClaims, nonce []byte, publicKey interface{}, err error) {
+
+	allclaims := &standardClaims{}
+
+	jwttoken, err := c.parser.ParseWithClaims(string(data), allclaims, func(token *jwt.Token) (interface{}, error) {
+
+		txKey, err := standardPublicKey(token)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(txKey) > 0 {
+			if publicKey, err = c.secrets.VerifyPublicKey(txKey); err != nil {
+				return nil, fmt.Errorf("invalid public key: %s", err)
+			}
+		}
+
+		return c.secrets.DecodingKey(token.Claims.(*standardClaims).Issuer, publicKey, previousCert)
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to parse token: %s", err)
+	}
+
+	if !jwttoken.Valid {
+		return nil, nil, nil, errors.New("invalid token")
+	}
+
+	if len(allclaims.Nonce) != NonceLength {
+		return nil, nil, nil, errors.New("invalid nonce")
+	}
+
+	return &ConnectionClaims{
+		T:   &policy.TagStore{Tags: allclaims.Tags},
+		RMT: allclaims.RMT,
+		EK:  allclaims.EK,
+		UT:  allclaims.UT,
+		CC:  allclaims.CC,
+	}, allclaims.Nonce, publicKey, nil
+}
+
+// standardPublicKey returns the public key in the header of a token in the
+// standard format, as it is transmitted in the compact format
+func standardPublicKey(token *jwt.Token) ([]byte, error) {
+
+	if chain, ok := token.Header["x5c"].([]interface{}); ok && len(chain) > 0 {
+		cert, ok := chain[0].(string)
+		if !ok {
+			return nil, errors.New("invalid x5c header")
+		}
+
+		der, err := base64.StdEncoding.DecodeString(cert)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x5c header: %s", err)
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+	}
+
+	if key, ok := token.Header["tkey"].(string); ok {
+		return []byte(key), nil
+	}
+
+	return nil, nil
+}
+
+// standardNonce returns the nonce of a token in the standard format
+func standardNonce(token []byte) ([]byte, error) {
+
+	allclaims := &standardClaims{}
+	if _, _, err := (&jwt.Parser{}).ParseUnverified(string(token), allclaims); err != nil {
+		return []byte{}, fmt.Errorf("invalid token: %s", err)
+	}
+
+	if len(allclaims.Nonce) != NonceLength {
+		return []byte{}, errors.New("invalid nonce")
+	}
+
+	return allclaims.Nonce, nil
+}
@@ -0,0 +1,158 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	enforcerconstants "github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	jwt "github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStandardFormat(t *testing.T) {
+	Convey("Given JWT engines with PKI secrets in the standard and the compact formats", t, func() {
+		caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey) // nolint
+		ca, _ := x509.ParseCertificate(caDER)                                                            // nolint
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		s := newTestPKISecrets(key, caKey, ca, caPEM)
+
+		standard, err := New(StandardFormat, validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+		compact, err := New(CompactFormat, validity, "TRIREME", s)
+		So(err, ShouldBeNil)
+
+		claims := ConnectionClaims{
+			T:  policy.NewTagStoreFromMap(map[string]string{enforcerconstants.TransmitterLabel: "pu1", "app": "web"}),
+			EK: []byte("ek"),
+		}
+
+		Convey("When I create a Syn token, it should be a standard JWT signed with the key of the certificate in its header", func() {
+			token, nonce, err := standard.CreateAndSign(false, &claims)
+			So(err, ShouldBeNil)
+			So(len(nonce), ShouldEqual, NonceLength)
+			So(strings.Count(string(token), "."), ShouldEqual, 2)
+
+			parsed, err := jwt.Parse(string(token), func(t *jwt.Token) (interface{}, error) {
+				der, err := base64.StdEncoding.DecodeString(t.Header["x5c"].([]interface{})[0].(string))
+				if err != nil {
+					return nil, err
+				}
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, err
+				}
+				return cert.PublicKey, nil
+			})
+			So(err, ShouldBeNil)
+			So(parsed.Valid, ShouldBeTrue)
+
+			mapClaims := parsed.Claims.(jwt.MapClaims)
+			So(mapClaims["sub"], ShouldEqual, "pu1")
+			So(mapClaims["iss"], ShouldEqual, "TRIREME")
+			So(mapClaims["tags"], ShouldContain, "app=web")
+
+			Convey("It should be decoded in both formats with its nonce", func() {
+				for _, engine := range []TokenEngine{standard, compact} {
+					decoded, rxNonce, cert, err := engine.Decode(false, token, nil)
+					So(err, ShouldBeNil)
+					So(rxNonce, ShouldResemble, nonce)
+					So(cert, ShouldNotBeNil)
+					So(decoded.T.GetSlice(), ShouldResemble, claims.T.GetSlice())
+					So(decoded.EK, ShouldResemble, claims.EK)
+				}
+
+				retrieved, err := standard.RetrieveNonce(token)
+				So(err, ShouldBeNil)
+				So(retrieved, ShouldResemble, nonce)
+			})
+
+			Convey("It should not be randomized", func() {
+				_, err := standard.Randomize(token)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("It should be rejected if it is modified", func() {
+				parts := strings.Split(string(token), ".")
+				tampered, _ := jwt.DecodeSegment(parts[1]) // nolint
+				tampered = []byte(strings.Replace(string(tampered), "app=web", "app=db", 1))
+				parts[1] = jwt.EncodeSegment(tampered)
+
+				_, _, _, err := standard.Decode(false, []byte(strings.Join(parts, ".")), nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create a Syn token with the claims of the application, they should be in the claims of the JWT", func() {
+			withClaims := claims
+			withClaims.CC = map[string]string{"image": "sha256:1234"}
+			token, _, err := standard.CreateAndSign(false, &withClaims)
+			So(err, ShouldBeNil)
+
+			parsed, _, err := (&jwt.Parser{}).ParseUnverified(string(token), jwt.MapClaims{})
+			So(err, ShouldBeNil)
+			So(parsed.Claims.(jwt.MapClaims)["cc"], ShouldResemble, map[string]interface{}{"image": "sha256:1234"})
+
+			decoded, _, _, err := compact.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(decoded.CC, ShouldResemble, withClaims.CC)
+		})
+
+		Convey("When I receive a Syn token in the compact format, it should be decoded", func() {
+			token, nonce, err := compact.CreateAndSign(false, &claims)
+			So(err, ShouldBeNil)
+
+			decoded, rxNonce, _, err := standard.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(rxNonce, ShouldResemble, nonce)
+			So(decoded.T.GetSlice(), ShouldResemble, claims.T.GetSlice())
+		})
+
+		Convey("When I create an Ack token, it should have the size of the Ack packets", func() {
+			ackClaims := ConnectionClaims{RMT: []byte(rmt), LCL: []byte(lcl + "7")}
+			ack, _, err := standard.CreateAndSign(true, &ackClaims)
+			So(err, ShouldBeNil)
+			So(len(ack), ShouldEqual, s.AckSize())
+		})
+	})
+
+	Convey("Given a JWT engine with PSK secrets in the standard format", t, func() {
+		standard, err := NewStandardJWT(validity, "TRIREME", secrets.NewPSKSecrets(psk))
+		So(err, ShouldBeNil)
+
+		Convey("When I create a Syn token, it should be decoded", func() {
+			token, nonce, err := standard.CreateAndSign(false, &defaultClaims)
+			So(err, ShouldBeNil)
+
+			decoded, rxNonce, _, err := standard.Decode(false, token, nil)
+			So(err, ShouldBeNil)
+			So(rxNonce, ShouldResemble, nonce)
+			So(decoded.T.GetSlice(), ShouldResemble, defaultClaims.T.GetSlice())
+		})
+	})
+
+	Convey("When I create a token engine of an unknown format, it should fail", t, func() {
+		_, err := New(Format(42), validity, "TRIREME", secrets.NewPSKSecrets(psk))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -11,6 +11,11 @@ type ConnectionClaims struct {
 	LCL []byte
 	// EK is the ephemeral EC key for encryption
 	EK []byte
+	// UT is the token of the user of the PU. It is only sent by the proxy.
+	UT string `json:",omitempty"`
+	// CC are the claims of the application of the PU. They are only sent in
+	// the Syn and SynAck packets.
+	CC map[string]string `json:",omitempty"`
 }
 
 // TokenEngine is the interface to the different implementations of tokens
@@ -0,0 +1,172 @@
+package tunnel
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	// MaxRecordSize is the maximum size of the data of a record
+	MaxRecordSize = 16384
+
+	headerSize = 2
+)
+
+// Conn encrypts the data of a connection with AES-GCM. The data is sent in
+// records of at most MaxRecordSize bytes, prefixed by the size of the sealed
+// record. The nonce of a record is its sequence number in its direction, so a
+// key must only be used by one Conn.
+type Conn struct {
+	net.Conn
+
+	tx      cipher.AEAD
+	txSeq   uint64
+	txLock  sync.Mutex
+	rx      cipher.AEAD
+	rxSeq   uint64
+	rxBuf   []byte
+	rxLock  sync.Mutex
+	rxError error
+}
+
+// NewConn returns a connection that encrypts the data sent on conn with the
+// Tx key and decrypts the data received with the Rx key
+func NewConn(conn net.Conn, keys *Keys) (*Conn, error) {
+
+	tx, err := newAEAD(keys.Tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rx, err := newAEAD(keys.Rx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		Conn: conn,
+		tx:   tx,
+		rx:   rx,
+	}, nil
+}
+
+// Write encrypts and sends the data
+func (c *Conn) Write(b []byte) (int, error) {
+
+	c.txLock.Lock()
+	defer c.txLock.Unlock()
+
+	written := 0
+	for written < len(b) {
+		size := len(b) - written
+		if size > MaxRecordSize {
+			size = MaxRecordSize
+		}
+
+		record := make([]byte, headerSize, headerSize+size+c.tx.Overhead())
+		record = c.tx.Seal(record, nonce(c.tx, c.txSeq), b[written:written+size], nil)
+		binary.BigEndian.PutUint16(record, uint16(len(record)-headerSize))
+		c.txSeq++
+
+		if _, err := c.Conn.Write(record); err != nil {
+			return written, err
+		}
+
+		written += size
+	}
+
+	return written, nil
+}
+
+// Read receives and decrypts the data. A record that fails the authentication
+// is an error and the connection must be closed.
+func (c *Conn) Read(b []byte) (int, error) {
+
+	c.rxLock.Lock()
+	defer c.rxLock.Unlock()
+
+	for len(c.rxBuf) == 0 {
+		if c.rxError != nil {
+			return 0, c.rxError
+		}
+
+		if c.rxBuf, c.rxError = c.readRecord(); c.rxError != nil {
+			return 0, c.rxError
+		}
+	}
+
+	n := copy(b, c.rxBuf)
+	c.rxBuf = c.rxBuf[n:]
+
+	return n, nil
+}
+
+// CloseWrite shuts down the sending side of the connection, if it supports it
+func (c *Conn) CloseWrite() error {
+
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
+
+// readRecord reads and opens the next record
+func (c *Conn) readRecord() ([]byte, error) {
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return nil, err
+	}
+
+	size := int(binary.BigEndian.Uint16(header))
+	if size < c.rx.Overhead() || size > MaxRecordSize+c.rx.Overhead() {
+		return nil, fmt.Errorf("invalid record size %d", size)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(c.Conn, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	data, err := c.rx.Open(sealed[:0], nonce(c.rx, c.rxSeq), sealed, nil)
+	if err != nil {
+		return nil, errors.New("invalid record")
+	}
+	c.rxSeq++
+
+	return data, nil
+}
+
+// newAEAD returns the AES-GCM cipher of a key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of the record with the sequence number
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+
+	return n
+}
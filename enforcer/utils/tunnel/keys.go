@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// KeySize is the size of the AES-256 keys of the tunnels
+	KeySize = 32
+
+	clientKeyLabel = "trireme tunnel client key"
+	serverKeyLabel = "trireme tunnel server key"
+)
+
+// EphemeralKey is the P-256 key of one end of a connection. Its public key is
+// sent in the signed handshake tokens, so that the ends derive the keys of the
+// tunnel from an authenticated key agreement.
+type EphemeralKey struct {
+	private []byte
+	public  []byte
+}
+
+// Keys are the keys of one end of a tunnel
+type Keys struct {
+	// Tx encrypts the data sent to the other end
+	Tx []byte
+	// Rx decrypts the data received from the other end
+	Rx []byte
+}
+
+// NewEphemeralKey generates a new ephemeral key
+func NewEphemeralKey() (*EphemeralKey, error) {
+
+	curve := elliptic.P256()
+
+	private, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate ephemeral key: %s", err)
+	}
+
+	return &EphemeralKey{
+		private: private,
+		public:  elliptic.Marshal(curve, x, y),
+	}, nil
+}
+
+// PublicKey returns the encoded public key that is sent to the other end
+func (k *EphemeralKey) PublicKey() []byte {
+	return k.public
+}
+
+// Keys derives the keys of the tunnel from the public key of the other end
+// and the nonces of the handshake. The client is the end that sent the Syn
+// token. Both ends derive the same keys, with Tx and Rx swapped.
+func (k *EphemeralKey) Keys(remotePublicKey []byte, clientNonce []byte, serverNonce []byte, client bool) (*Keys, error) {
+
+	if len(clientNonce) == 0 || len(serverNonce) == 0 {
+		return nil, errors.New("missing nonce")
+	}
+
+	secret, err := sharedSecret(k.private, remotePublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// HKDF of RFC 5869 with SHA-256. A single block of the expansion is a
+	// whole key.
+	salt := append(append([]byte{}, clientNonce...), serverNonce...)
+	prk := hmacSHA256(salt, secret)
+	clientKey := hmacSHA256(prk, []byte(clientKeyLabel), []byte{1})
+	serverKey := hmacSHA256(prk, []byte(serverKeyLabel), []byte{1})
+
+	if client {
+		return &Keys{Tx: clientKey, Rx: serverKey}, nil
+	}
+
+	return &Keys{Tx: serverKey, Rx: clientKey}, nil
+}
+
+// sharedSecret returns the x coordinate of the product of the remote public
+// key and the private key, as the ECDH of SEC 1. The remote public key must be
+// an uncompressed point of the curve.
+func sharedSecret(private []byte, remotePublicKey []byte) ([]byte, error) {
+
+	curve := elliptic.P256()
+	params := curve.Params()
+
+	x, y := elliptic.Unmarshal(curve, remotePublicKey)
+	if x == nil || x.Cmp(params.P) >= 0 || y.Cmp(params.P) >= 0 || !curve.IsOnCurve(x, y) {
+		return nil, errors.New("invalid ephemeral key")
+	}
+
+	sx, sy := curve.ScalarMult(x, y, private)
+	if sx.Sign() == 0 && sy.Sign() == 0 {
+		return nil, errors.New("unable to agree on a key")
+	}
+
+	return padBytes(sx, (params.BitSize+7)/8), nil
+}
+
+// padBytes returns the big-endian bytes of the integer, left padded with
+// zeros to the given size
+func padBytes(n *big.Int, size int) []byte {
+
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// hmacSHA256 returns the HMAC of the data with the key
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	for _, d := range data {
+		mac.Write(d) // nolint
+	}
+
+	return mac.Sum(nil)
+}
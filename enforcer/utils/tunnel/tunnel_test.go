@@ -0,0 +1,134 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testKeys() (clientKeys *Keys, serverKeys *Keys) {
+
+	client, _ := NewEphemeralKey() // nolint
+	server, _ := NewEphemeralKey() // nolint
+
+	clientKeys, _ = client.Keys(server.PublicKey(), []byte("clientnonce"), []byte("servernonce"), true)  // nolint
+	serverKeys, _ = server.Keys(client.PublicKey(), []byte("clientnonce"), []byte("servernonce"), false) // nolint
+
+	return clientKeys, serverKeys
+}
+
+func TestKeys(t *testing.T) {
+	Convey("Given the ephemeral keys of a client and a server", t, func() {
+		client, err := NewEphemeralKey()
+		So(err, ShouldBeNil)
+		server, err := NewEphemeralKey()
+		So(err, ShouldBeNil)
+
+		Convey("When they derive the keys of the tunnel, they should get the same keys swapped", func() {
+			clientKeys, err := client.Keys(server.PublicKey(), []byte("clientnonce"), []byte("servernonce"), true)
+			So(err, ShouldBeNil)
+			serverKeys, err := server.Keys(client.PublicKey(), []byte("clientnonce"), []byte("servernonce"), false)
+			So(err, ShouldBeNil)
+
+			So(len(clientKeys.Tx), ShouldEqual, KeySize)
+			So(clientKeys.Tx, ShouldResemble, serverKeys.Rx)
+			So(clientKeys.Rx, ShouldResemble, serverKeys.Tx)
+			So(clientKeys.Tx, ShouldNotResemble, clientKeys.Rx)
+		})
+
+		Convey("When the nonces are different, the keys should be different", func() {
+			keys1, err := client.Keys(server.PublicKey(), []byte("clientnonce"), []byte("servernonce"), true)
+			So(err, ShouldBeNil)
+			keys2, err := client.Keys(server.PublicKey(), []byte("clientnonce"), []byte("othernonce"), true)
+			So(err, ShouldBeNil)
+
+			So(keys1.Tx, ShouldNotResemble, keys2.Tx)
+		})
+
+		Convey("When the public key is invalid or a nonce is missing, I should get an error", func() {
+			_, err := client.Keys([]byte("invalid"), []byte("clientnonce"), []byte("servernonce"), true)
+			So(err, ShouldNotBeNil)
+
+			_, err = client.Keys(server.PublicKey(), nil, []byte("servernonce"), true)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConn(t *testing.T) {
+	Convey("Given the ends of a tunnel", t, func() {
+		clientKeys, serverKeys := testKeys()
+		c1, c2 := net.Pipe()
+
+		client, err := NewConn(c1, clientKeys)
+		So(err, ShouldBeNil)
+		server, err := NewConn(c2, serverKeys)
+		So(err, ShouldBeNil)
+
+		Convey("When the client sends data larger than a record, the server should receive it", func() {
+			data := bytes.Repeat([]byte("0123456789"), MaxRecordSize/5)
+			go func() {
+				client.Write(data) // nolint
+				client.Close()     // nolint
+			}()
+
+			received, err := ioutil.ReadAll(server)
+			So(err, ShouldBeNil)
+			So(received, ShouldResemble, data)
+		})
+
+		Convey("When the server replies, the client should receive the reply", func() {
+			go func() {
+				server.Write([]byte("reply")) // nolint
+			}()
+
+			received := make([]byte, 5)
+			_, err := io.ReadFull(client, received)
+			So(err, ShouldBeNil)
+			So(string(received), ShouldEqual, "reply")
+		})
+
+		Convey("When the data is not encrypted with the key of the tunnel, it should be rejected", func() {
+			otherKeys, _ := testKeys()
+			other, err := NewConn(c1, otherKeys)
+			So(err, ShouldBeNil)
+
+			go func() {
+				other.Write([]byte("data")) // nolint
+			}()
+
+			_, err = server.Read(make([]byte, 10))
+			So(err, ShouldNotBeNil)
+
+			_, err = server.Read(make([]byte, 10))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the data is sent in clear text, it should be rejected", func() {
+			go func() {
+				c1.Write([]byte{0, 4, 'd', 'a', 't', 'a'}) // nolint
+			}()
+
+			_, err = server.Read(make([]byte, 10))
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			c1.Close() // nolint
+			c2.Close() // nolint
+		})
+	})
+
+	Convey("Given keys of an invalid size, I should get an error", t, func() {
+		c1, c2 := net.Pipe()
+		defer c1.Close() // nolint
+		defer c2.Close() // nolint
+
+		_, err := NewConn(c1, &Keys{Tx: []byte("short"), Rx: []byte("short")})
+		So(err, ShouldNotBeNil)
+	})
+}
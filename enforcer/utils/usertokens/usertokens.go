@@ -0,0 +1,197 @@
+package usertokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const (
+	// TagPrefix is the prefix of the tags created from the claims of a user token
+	TagPrefix = "@oidc:"
+
+	// defaultClaim is the claim added to the tags if none is configured
+	defaultClaim = "sub"
+)
+
+// Verifier is the interface of an object that validates user tokens.
+type Verifier interface {
+	// Authorize validates a user token and returns the tags of the peer
+	// with the claims of the token.
+	Authorize(token string, tags *policy.TagStore) (*policy.TagStore, error)
+}
+
+type tokenVerifier struct {
+	issuer     string
+	audience   string
+	publicKeys []interface{}
+	claims     []string
+}
+
+// NewVerifier creates a verifier of the user tokens of a user authorization
+func NewVerifier(authorization *policy.UserAuthorization) (Verifier, error) {
+
+	if authorization.Issuer == "" {
+		return nil, errors.New("issuer is required")
+	}
+
+	if len(authorization.PublicKeys) == 0 {
+		return nil, errors.New("at least one public key is required")
+	}
+
+	publicKeys := []interface{}{}
+	for _, data := range authorization.PublicKeys {
+		key, err := parsePublicKey([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, key)
+	}
+
+	claims := authorization.Claims
+	if len(claims) == 0 {
+		claims = []string{defaultClaim}
+	}
+
+	return &tokenVerifier{
+		issuer:     authorization.Issuer,
+		audience:   authorization.Audience,
+		publicKeys: publicKeys,
+		claims:     claims,
+	}, nil
+}
+
+// Authorize validates the token against the keys of the issuer. The tags with
+// the user prefix given by the peer are replaced by the claims of the token.
+func (v *tokenVerifier) Authorize(token string, tags *policy.TagStore) (*policy.TagStore, error) {
+
+	if token == "" {
+		return nil, errors.New("missing user token")
+	}
+
+	claims, err := v.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(v.issuer, true) {
+		return nil, fmt.Errorf("invalid issuer: %v", claims["iss"])
+	}
+
+	if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+		return nil, fmt.Errorf("invalid audience: %v", claims["aud"])
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("user token has no expiration")
+	}
+
+	userTags := policy.NewTagStore()
+	for _, tag := range tags.GetSlice() {
+		if !strings.HasPrefix(tag, TagPrefix) {
+			userTags.Tags = append(userTags.Tags, tag)
+		}
+	}
+
+	for _, name := range v.claims {
+		for _, value := range claimValues(claims[name]) {
+			userTags.AppendKeyValue(TagPrefix+name, value)
+		}
+	}
+
+	return userTags, nil
+}
+
+// verify checks the signature and the validity period of a token with each of
+// the keys of the issuer.
+func (v *tokenVerifier) verify(token string) (jwt.MapClaims, error) {
+
+	var err error
+	for _, publicKey := range v.publicKeys {
+
+		key := publicKey
+		claims := jwt.MapClaims{}
+
+		parsed, perr := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+			switch key.(type) {
+			case *rsa.PublicKey:
+				if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+					return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+				}
+			case *ecdsa.PublicKey:
+				if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
+					return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+				}
+			}
+			return key, nil
+		})
+
+		if perr == nil && parsed.Valid {
+			return claims, nil
+		}
+		err = perr
+	}
+
+	return nil, fmt.Errorf("invalid user token: %s", err)
+}
+
+// parsePublicKey parses a PEM encoded RSA or ECDSA public key
+func parsePublicKey(data []byte) (interface{}, error) {
+
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	key, err := jwt.ParseECPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", err)
+	}
+
+	return key, nil
+}
+
+// hasAudience checks the audience claim, which is either a string or a list
+// of strings.
+func hasAudience(claim interface{}, audience string) bool {
+
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// claimValues converts the value of a claim to tag values
+func claimValues(claim interface{}) []string {
+
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case bool:
+		return []string{strconv.FormatBool(value)}
+	case float64:
+		return []string{strconv.FormatFloat(value, 'f', -1, 64)}
+	case []interface{}:
+		values := []string{}
+		for _, v := range value {
+			values = append(values, claimValues(v)...)
+		}
+		return values
+	}
+
+	return []string{}
+}
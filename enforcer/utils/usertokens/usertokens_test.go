@@ -0,0 +1,161 @@
+package usertokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+func publicKeyPEM(key interface{}) string {
+
+	data, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: data}))
+}
+
+func userToken(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return token
+}
+
+func TestNewVerifier(t *testing.T) {
+
+	Convey("Given an RSA key", t, func() {
+
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+
+		Convey("If the authorization is valid, I should get a verifier", func() {
+			v, err := NewVerifier(&policy.UserAuthorization{
+				Issuer:     "https://issuer",
+				PublicKeys: []string{publicKeyPEM(&key.PublicKey)},
+			})
+			So(err, ShouldBeNil)
+			So(v, ShouldNotBeNil)
+		})
+
+		Convey("If the authorization has no issuer, I should get an error", func() {
+			_, err := NewVerifier(&policy.UserAuthorization{
+				PublicKeys: []string{publicKeyPEM(&key.PublicKey)},
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If the authorization has no key, I should get an error", func() {
+			_, err := NewVerifier(&policy.UserAuthorization{
+				Issuer: "https://issuer",
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If the key is invalid, I should get an error", func() {
+			_, err := NewVerifier(&policy.UserAuthorization{
+				Issuer:     "https://issuer",
+				PublicKeys: []string{"invalid"},
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+
+	Convey("Given a verifier with an RSA and an ECDSA key", t, func() {
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		v, err := NewVerifier(&policy.UserAuthorization{
+			Issuer:     "https://issuer",
+			Audience:   "proxy",
+			PublicKeys: []string{publicKeyPEM(&rsaKey.PublicKey), publicKeyPEM(&ecKey.PublicKey)},
+			Claims:     []string{"email", "groups"},
+		})
+		So(err, ShouldBeNil)
+
+		claims := jwt.MapClaims{
+			"iss":    "https://issuer",
+			"aud":    []string{"proxy", "other"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"sub":    "1234",
+			"email":  "alice@example.com",
+			"groups": []string{"dev", "ops"},
+		}
+		tags := policy.NewTagStoreFromMap(map[string]string{
+			"app":             "web",
+			TagPrefix + "sub": "spoofed",
+		})
+
+		Convey("If the token is signed with the RSA key, I should get the claims as tags", func() {
+			userTags, err := v.Authorize(userToken(jwt.SigningMethodRS256, rsaKey, claims), tags)
+			So(err, ShouldBeNil)
+			So(userTags.GetSlice(), ShouldResemble, []string{
+				"app=web",
+				TagPrefix + "email=alice@example.com",
+				TagPrefix + "groups=dev",
+				TagPrefix + "groups=ops",
+			})
+		})
+
+		Convey("If the token is signed with the ECDSA key, it should be accepted", func() {
+			_, err := v.Authorize(userToken(jwt.SigningMethodES256, ecKey, claims), tags)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("If the token is missing, it should be rejected", func() {
+			_, err := v.Authorize("", tags)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If the token is signed by another key, it should be rejected", func() {
+			otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			So(err, ShouldBeNil)
+			_, err = v.Authorize(userToken(jwt.SigningMethodRS256, otherKey, claims), tags)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If the token is expired, it should be rejected", func() {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			_, err := v.Authorize(userToken(jwt.SigningMethodRS256, rsaKey, claims), tags)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If the token has no expiration, it should be rejected", func() {
+			delete(claims, "exp")
+			_, err := v.Authorize(userToken(jwt.SigningMethodRS256, rsaKey, claims), tags)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If the token has another issuer, it should be rejected", func() {
+			claims["iss"] = "https://other"
+			_, err := v.Authorize(userToken(jwt.SigningMethodRS256, rsaKey, claims), tags)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("If the token has another audience, it should be rejected", func() {
+			claims["aud"] = "other"
+			_, err := v.Authorize(userToken(jwt.SigningMethodRS256, rsaKey, claims), tags)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -2,6 +2,7 @@ package trireme
 
 import (
 	"os"
+	"time"
 
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer/packetprocessor"
@@ -23,6 +24,93 @@ func SetLogParameters(logToConsole, logWithID bool, logLevel string, logFormat s
 	h.SetLogParameters(logToConsole, logWithID, logLevel, logFormat)
 }
 
+// SetRemoteEnforcerLogDirectory sets up a directory where the output of the
+// remote trireme instances is persisted in addition to the controller log, and
+// where they write their crash reports.
+func SetRemoteEnforcerLogDirectory(dir string) {
+
+	h := processmon.GetProcessManagerHdl()
+	if h == nil {
+		panic("Unable to find process manager handle")
+	}
+
+	h.SetLogDirectory(dir)
+}
+
+// SetRemoteEnforcerStatsParameters sets the interval at which the remote
+// trireme instances aggregate the flows before they report them, and the rate
+// at which they sample the flow events, like 10 to keep one in ten, so that
+// port scans do not flood the controller. The defaults are used if they are
+// zero.
+func SetRemoteEnforcerStatsParameters(interval time.Duration, sampling int) {
+
+	h := processmon.GetProcessManagerHdl()
+	if h == nil {
+		panic("Unable to find process manager handle")
+	}
+
+	h.SetStatsParameters(interval, sampling)
+}
+
+// SetRemoteEnforcerStatsBuffer sets up a directory where the remote trireme
+// instances buffer the stats that cannot be sent to the controller, so that
+// they survive the outages of the controller and the restarts of the remote
+// instances. Each instance buffers at most size bytes of stats, 10MB by
+// default, and drops the oldest stats first.
+func SetRemoteEnforcerStatsBuffer(dir string, size int64) {
+
+	h := processmon.GetProcessManagerHdl()
+	if h == nil {
+		panic("Unable to find process manager handle")
+	}
+
+	h.SetStatsBuffer(dir, size)
+}
+
+// SetRemoteEnforcerResourceLimits places each remote trireme instance in its
+// own cgroups, limited to memory bytes and to cpuQuota CPUs, like 0.5, and
+// adds oomScoreAdj to its OOM score, so that a misbehaving instance cannot
+// take down the node. The limits are not applied if they are zero.
+func SetRemoteEnforcerResourceLimits(memory int64, cpuQuota float64, oomScoreAdj int) error {
+
+	h := processmon.GetProcessManagerHdl()
+	if h == nil {
+		panic("Unable to find process manager handle")
+	}
+
+	return h.SetResourceLimits(memory, cpuQuota, oomScoreAdj)
+}
+
+// SetRemoteEnforcerLogForwarding sets the level of the logs the remote trireme
+// instances forward to the controller, like info. The forwarded logs are
+// logged by the remote logger of the controller with the context ID of the
+// PU of the instance. The logs are not forwarded if the level is empty, and
+// the level of an instance can be changed with SetLogForwarding of Trireme.
+func SetRemoteEnforcerLogForwarding(level string) error {
+
+	h := processmon.GetProcessManagerHdl()
+	if h == nil {
+		panic("Unable to find process manager handle")
+	}
+
+	return h.SetLogForwarding(level)
+}
+
+// SetRemoteEnforcerHardening sets if the remote trireme instances drop all
+// their capabilities but CAP_NET_ADMIN and CAP_NET_RAW when they enter the
+// network namespace of their PU, and install a seccomp filter of the syscalls
+// they do not need, like mount or ptrace, once they are initialized. The
+// programs they execute, like iptables, inherit the restrictions.
+func SetRemoteEnforcerHardening(enable bool) {
+
+	h := processmon.GetProcessManagerHdl()
+	if h == nil {
+		panic("Unable to find process manager handle")
+	}
+
+	h.SetHardening(enable)
+}
+
 // GetLogParameters retrieves log parameters for Remote Enforcer.
 func GetLogParameters() (logToConsole bool, logID string, logLevel string, logFormat string) {
 
@@ -53,7 +141,7 @@ func LaunchRemoteEnforcer(service packetprocessor.PacketProcessor) error {
 // CleanOldState ensures all state in trireme is cleaned up.
 func CleanOldState() {
 
-	ipt, _ := iptablesctrl.NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, nil)
+	ipt, _ := iptablesctrl.NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, nil, nil)
 
 	if err := ipt.CleanAllSynAckPacketCaptures(); err != nil {
 		zap.L().Fatal("Unable to clean all syn/ack captures", zap.Error(err))
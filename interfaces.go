@@ -1,7 +1,12 @@
 package trireme
 
 import (
+	"context"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/diagnostics"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
 	"github.com/aporeto-inc/trireme-lib/internal/supervisor"
 	"github.com/aporeto-inc/trireme-lib/policy"
@@ -14,15 +19,52 @@ type Trireme interface {
 	// PURuntime returns a getter for a specific contextID.
 	PURuntime(contextID string) (policy.RuntimeReader, error)
 
-	// Start starts the component.
-	Start() error
+	// Start starts the component. It gives up when the context is done.
+	Start(ctx context.Context) error
 
-	// Stop stops the component.
-	Stop() error
+	// Stop stops the component. It does not wait for the components after the
+	// context is done.
+	Stop(ctx context.Context) error
 
 	// Supervisor returns the supervisor for a given PU type
 	Supervisor(kind constants.PUType) supervisor.Supervisor
 
+	// Diagnose runs the self checks of the installation and returns a report.
+	Diagnose() *diagnostics.Report
+
+	// GetStats returns the counters of the netfilter queues of the enforcers
+	// and of the packets they dropped for each PU.
+	GetStats() []*collector.QueueStatsRecord
+
+	// UpdateFilterQueue resizes or rebalances the netfilter queues of the
+	// enforcement of linux processes while it is running.
+	UpdateFilterQueue(fq *fqconfig.FilterQueue) error
+
+	// SetLogForwarding sets the level of the logs the remote enforcer of a PU
+	// forwards to the controller. The logs are not forwarded if it is empty.
+	SetLogForwarding(contextID string, level string) error
+
+	// SetLogLevel sets the level of the logs of the remote enforcer of a PU,
+	// like debug, without restarting it.
+	SetLogLevel(contextID string, level string) error
+
+	// SetDebugListener starts or stops the debug listener of the remote
+	// enforcer of a PU, which serves its pprof profiles, its expvar variables
+	// and the counters of its caches.
+	SetDebugListener(contextID string, enable bool) error
+
+	// ListPUs returns the state of the PUs that are enforced.
+	ListPUs() []*PUState
+
+	// GetPUPolicy returns the state of an enforced PU with its policy.
+	GetPUPolicy(contextID string) (*PUState, error)
+
+	// DiffPolicy returns the changes between two versions of the policy of a PU.
+	DiffPolicy(contextID string, v1, v2 int) (policy.PUPolicyDiff, error)
+
+	// RollbackPolicy enforces again the previous version of the policy of a PU.
+	RollbackPolicy(contextID string) error
+
 	// processor.ProcessingUnitsHandler
 	// CreatePURuntime is called when a monitor detects creation of a new ProcessingUnit.
 	CreatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error
@@ -38,6 +80,24 @@ type Trireme interface {
 	// SecretsUpdater
 	// UpdateSecrets updates the secrets of running enforcers managed by trireme. Remote enforcers will get the secret updates with the next policy push
 	UpdateSecrets(secrets secrets.Secrets) error
+
+	// RevokeToken rejects the token of a compromised enforcer before its certificate expires. Remote enforcers will get the revocation with the next policy push
+	RevokeToken(token []byte) error
+}
+
+// PUState is the state of a PU enforced by Trireme. It holds copies of the
+// runtime and of the policy of the PU.
+type PUState struct {
+	// ContextID is the ID of the PU
+	ContextID string
+	// Runtime is the runtime information of the PU
+	Runtime *policy.PURuntime
+	// Policy is the policy enforced for the PU. In observe only mode, it is the
+	// policy before it is made observe only.
+	Policy *policy.PUPolicy
+	// PolicyVersion starts at 1 and is incremented every time a new policy is
+	// enforced for the PU
+	PolicyVersion int
 }
 
 // A PolicyUpdater has the ability to receive an update for a specific policy.
@@ -62,4 +122,7 @@ type PolicyResolver interface {
 type SecretsUpdater interface {
 	// UpdateSecrets updates the secrets of running enforcers managed by trireme. Remote enforcers will get the secret updates with the next policy push
 	UpdateSecrets(secrets secrets.Secrets) error
+
+	// RevokeToken rejects the token of a compromised enforcer before its certificate expires. Remote enforcers will get the revocation with the next policy push
+	RevokeToken(token []byte) error
 }
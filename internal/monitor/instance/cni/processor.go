@@ -76,6 +76,11 @@ func (c *cniProcessor) Pause(eventInfo *events.EventInfo) error {
 	return nil
 }
 
+// Update handles an update event
+func (c *cniProcessor) Update(eventInfo *events.EventInfo) error {
+	return nil
+}
+
 // ReSync resyncs with all the existing services that were there before we start
 func (c *cniProcessor) ReSync(e *events.EventInfo) error {
 
@@ -0,0 +1,76 @@
+package crimonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runtimeClient lists and inspects the pod sandboxes of a CRI runtime
+type runtimeClient interface {
+	listSandboxes(ctx context.Context) ([]Sandbox, error)
+	inspectSandbox(ctx context.Context, id string) (*SandboxStatus, error)
+}
+
+// crictlClient talks to the CRI runtime with crictl, which implements the
+// gRPC API of the runtime
+type crictlClient struct {
+	crictl   string
+	endpoint string
+}
+
+// newCrictlClient returns a client of the runtime at the endpoint, like
+// unix:///run/containerd/containerd.sock
+func newCrictlClient(crictl string, endpoint string) (*crictlClient, error) {
+
+	path, err := exec.LookPath(crictl)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find crictl: %s", err)
+	}
+
+	return &crictlClient{
+		crictl:   path,
+		endpoint: endpoint,
+	}, nil
+}
+
+// listSandboxes returns the pod sandboxes of the runtime
+func (c *crictlClient) listSandboxes(ctx context.Context) ([]Sandbox, error) {
+
+	list := &SandboxList{}
+	if err := c.run(ctx, list, "pods", "-o", "json"); err != nil {
+		return nil, fmt.Errorf("unable to list sandboxes: %s", err)
+	}
+
+	return list.Items, nil
+}
+
+// inspectSandbox returns the status of a pod sandbox
+func (c *crictlClient) inspectSandbox(ctx context.Context, id string) (*SandboxStatus, error) {
+
+	status := &SandboxStatus{}
+	if err := c.run(ctx, status, "inspectp", "-o", "json", id); err != nil {
+		return nil, fmt.Errorf("unable to inspect sandbox %s: %s", id, err)
+	}
+
+	return status, nil
+}
+
+// run runs crictl and decodes its output
+func (c *crictlClient) run(ctx context.Context, v interface{}, args ...string) error {
+
+	cmd := exec.CommandContext(ctx, c.crictl, append([]string{"--runtime-endpoint", c.endpoint}, args...)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return json.Unmarshal(out, v)
+}
@@ -0,0 +1,306 @@
+package crimonitor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/rpc/registerer"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+	"github.com/aporeto-inc/trireme-lib/rpc/processor"
+)
+
+const (
+	// DefaultEndpoint is the default endpoint of the CRI runtime
+	DefaultEndpoint = "unix:///run/containerd/containerd.sock"
+
+	// DefaultSyncInterval is the default interval between two lists of the
+	// sandboxes of the runtime
+	DefaultSyncInterval = 2 * time.Second
+
+	// requestTimeout is the timeout of a request to the runtime
+	requestTimeout = 10 * time.Second
+)
+
+// Config is the configuration options to start a CRI monitor
+type Config struct {
+	// Endpoint is the endpoint of the CRI runtime, like the socket of
+	// containerd or CRI-O
+	Endpoint string
+	// Crictl is the name or the path of the crictl command
+	Crictl                   string
+	SyncInterval             time.Duration
+	SandboxMetadataExtractor SandboxMetadataExtractor
+}
+
+// DefaultConfig provides a default configuration
+func DefaultConfig() *Config {
+
+	return &Config{
+		Endpoint:                 DefaultEndpoint,
+		Crictl:                   "crictl",
+		SyncInterval:             DefaultSyncInterval,
+		SandboxMetadataExtractor: DefaultSandboxMetadataExtractor,
+	}
+}
+
+// SetupDefaultConfig adds defaults to a partial configuration
+func SetupDefaultConfig(criConfig *Config) *Config {
+
+	defaultConfig := DefaultConfig()
+
+	if criConfig.Endpoint == "" {
+		criConfig.Endpoint = defaultConfig.Endpoint
+	}
+	if criConfig.Crictl == "" {
+		criConfig.Crictl = defaultConfig.Crictl
+	}
+	if criConfig.SyncInterval <= 0 {
+		criConfig.SyncInterval = defaultConfig.SyncInterval
+	}
+	if criConfig.SandboxMetadataExtractor == nil {
+		criConfig.SandboxMetadataExtractor = defaultConfig.SandboxMetadataExtractor
+	}
+
+	return criConfig
+}
+
+// criMonitor lists the pod sandboxes of a CRI runtime periodically, since the
+// CRI has no events, and generates the lifecycle events of their PUs. The
+// sandbox id is the context id, since the PU is bound to the network namespace
+// of the sandbox. The labels of a sandbox never change.
+type criMonitor struct {
+	client            runtimeClient
+	syncInterval      time.Duration
+	metadataExtractor SandboxMetadataExtractor
+	config            *processor.Config
+
+	// sandboxes holds the ready sandboxes that were handled. They have an
+	// active PU, unless they are in the network namespace of the node.
+	sandboxes map[string]bool
+	lock      sync.Mutex
+
+	cancel context.CancelFunc
+}
+
+// New returns a new CRI monitor
+func New() monitorinstance.Implementation {
+
+	return &criMonitor{
+		sandboxes: map[string]bool{},
+	}
+}
+
+// SetupConfig provides a configuration to implmentations. Every implmentation
+// can have its own config type.
+func (c *criMonitor) SetupConfig(registerer registerer.Registerer, cfg interface{}) (err error) {
+
+	defaultConfig := DefaultConfig()
+	if cfg == nil {
+		cfg = defaultConfig
+	}
+
+	criConfig, ok := cfg.(*Config)
+	if !ok {
+		return fmt.Errorf("Invalid configuration specified")
+	}
+
+	// Setup defaults
+	criConfig = SetupDefaultConfig(criConfig)
+
+	if c.client, err = newCrictlClient(criConfig.Crictl, criConfig.Endpoint); err != nil {
+		return err
+	}
+
+	c.syncInterval = criConfig.SyncInterval
+	c.metadataExtractor = criConfig.SandboxMetadataExtractor
+
+	return nil
+}
+
+// SetupHandlers sets up handlers for monitors to invoke for various events such as
+// processing unit events and synchronization events. This will be called before Start()
+// by the consumer of the monitor
+func (c *criMonitor) SetupHandlers(cfg *processor.Config) {
+
+	c.config = cfg
+}
+
+// Start implements Implementation interface
+func (c *criMonitor) Start() error {
+
+	if err := c.config.IsComplete(); err != nil {
+		return fmt.Errorf("cri: %s", err)
+	}
+
+	if err := c.ReSync(); err != nil {
+		return fmt.Errorf("cri: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	go c.sync(ctx)
+
+	return nil
+}
+
+// Stop implements Implementation interface
+func (c *criMonitor) Stop() error {
+
+	zap.L().Debug("Stopping the CRI monitor")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	return nil
+}
+
+// ReSync lists the sandboxes of the runtime and brings the PUs in line with
+// them
+func (c *criMonitor) ReSync() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	sandboxes, err := c.client.listSandboxes(ctx)
+	if err != nil {
+		return err
+	}
+
+	ready := map[string]bool{}
+	for i := range sandboxes {
+		if sandboxes[i].State != SandboxReady {
+			continue
+		}
+		ready[sandboxes[i].ID] = true
+		if err := c.handleSandbox(ctx, &sandboxes[i]); err != nil {
+			zap.L().Error("Unable to process sandbox",
+				zap.String("sandbox", sandboxes[i].ID),
+				zap.String("pod", sandboxes[i].Metadata.Name),
+				zap.String("namespace", sandboxes[i].Metadata.Namespace),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// Sandboxes stopped or removed since the last list
+	for _, contextID := range c.handledSandboxes() {
+		if ready[contextID] {
+			continue
+		}
+		if err := c.stopSandbox(contextID); err != nil {
+			zap.L().Error("Unable to stop sandbox", zap.String("contextID", contextID), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// sync lists the sandboxes periodically until the context is cancelled
+func (c *criMonitor) sync(ctx context.Context) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.syncInterval):
+		}
+
+		if err := c.ReSync(); err != nil {
+			zap.L().Error("Unable to resync sandboxes", zap.Error(err))
+		}
+	}
+}
+
+// handleSandbox starts the PU of a ready sandbox
+func (c *criMonitor) handleSandbox(ctx context.Context, sandbox *Sandbox) error {
+
+	c.lock.Lock()
+	_, handled := c.sandboxes[sandbox.ID]
+	c.lock.Unlock()
+
+	if handled {
+		return nil
+	}
+
+	status, err := c.client.inspectSandbox(ctx, sandbox.ID)
+	if err != nil {
+		return err
+	}
+
+	if status.HostNetwork() {
+		c.setHandled(sandbox.ID, false)
+		return nil
+	}
+
+	runtimeInfo, err := c.metadataExtractor(status)
+	if err != nil {
+		return fmt.Errorf("unable to extract metadata: %s", err)
+	}
+
+	return c.startSandbox(sandbox.ID, runtimeInfo)
+}
+
+func (c *criMonitor) startSandbox(contextID string, runtimeInfo *policy.PURuntime) error {
+
+	if err := c.config.PUHandler.CreatePURuntime(contextID, runtimeInfo); err != nil {
+		return fmt.Errorf("unable to create sandbox %s: %s", contextID, err)
+	}
+
+	if err := c.config.PUHandler.HandlePUEvent(contextID, events.EventStart); err != nil {
+		return fmt.Errorf("unable to set policy of sandbox %s: %s", contextID, err)
+	}
+
+	c.setHandled(contextID, true)
+
+	return nil
+}
+
+func (c *criMonitor) stopSandbox(contextID string) error {
+
+	c.lock.Lock()
+	active := c.sandboxes[contextID]
+	delete(c.sandboxes, contextID)
+	c.lock.Unlock()
+
+	if !active {
+		return nil
+	}
+
+	if err := c.config.PUHandler.HandlePUEvent(contextID, events.EventStop); err != nil {
+		return fmt.Errorf("unable to stop sandbox %s: %s", contextID, err)
+	}
+
+	return c.config.PUHandler.HandlePUEvent(contextID, events.EventDestroy)
+}
+
+func (c *criMonitor) setHandled(contextID string, active bool) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sandboxes[contextID] = active
+}
+
+func (c *criMonitor) handledSandboxes() []string {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	contextIDs := make([]string, 0, len(c.sandboxes))
+	for contextID := range c.sandboxes {
+		contextIDs = append(contextIDs, contextID)
+	}
+
+	sort.Strings(contextIDs)
+
+	return contextIDs
+}
@@ -0,0 +1,145 @@
+package crimonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+	"github.com/aporeto-inc/trireme-lib/rpc/processor"
+)
+
+// testStatus is the output of crictl inspectp with containerd
+const testStatus = `{
+  "status": {
+    "id": "%s",
+    "metadata": {"attempt": 0, "name": "web-0", "namespace": "shop", "uid": "1234"},
+    "state": "SANDBOX_READY",
+    "network": {"additionalIps": [], "ip": "10.1.1.1"},
+    "linux": {"namespaces": {"options": {"ipc": "POD", "network": "%s", "pid": "CONTAINER"}}},
+    "labels": {"app": "web", "io.kubernetes.pod.name": "web-0", "io.kubernetes.pod.namespace": "shop"},
+    "annotations": {}
+  },
+  "info": {
+    "pid": 100,
+    "runtimeSpec": {"linux": {"namespaces": [{"type": "pid"}, {"type": "network", "path": "/var/run/netns/cni-%s"}]}}
+  }
+}`
+
+type testPUHandler struct {
+	events   []string
+	runtimes map[string]*policy.PURuntime
+	sync.Mutex
+}
+
+func (h *testPUHandler) CreatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, "create:"+contextID)
+	h.runtimes[contextID] = runtimeInfo
+	return nil
+}
+
+func (h *testPUHandler) UpdatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, "update:"+contextID)
+	h.runtimes[contextID] = runtimeInfo
+	return nil
+}
+
+func (h *testPUHandler) HandlePUEvent(contextID string, event events.Event) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, string(event)+":"+contextID)
+	return nil
+}
+
+// testClient is a runtime whose sandboxes are in the network namespace of
+// the node if their id starts with host
+type testClient struct {
+	sandboxes []Sandbox
+	inspected []string
+}
+
+func (c *testClient) listSandboxes(ctx context.Context) ([]Sandbox, error) {
+	return c.sandboxes, nil
+}
+
+func (c *testClient) inspectSandbox(ctx context.Context, id string) (*SandboxStatus, error) {
+	c.inspected = append(c.inspected, id)
+
+	network := "POD"
+	if strings.HasPrefix(id, "host") {
+		network = "NODE"
+	}
+
+	status := &SandboxStatus{}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(testStatus, id, network, id)), status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+func TestReSync(t *testing.T) {
+
+	Convey("Given a CRI monitor", t, func() {
+
+		h := &testPUHandler{runtimes: map[string]*policy.PURuntime{}}
+		client := &testClient{}
+
+		c := New().(*criMonitor)
+		c.client = client
+		c.metadataExtractor = DefaultSandboxMetadataExtractor
+		c.SetupHandlers(&processor.Config{
+			Collector: &collector.DefaultCollector{},
+			PUHandler: h,
+		})
+
+		Convey("When the runtime has a ready sandbox", func() {
+
+			client.sandboxes = []Sandbox{
+				{ID: "abc", State: SandboxReady},
+				{ID: "def", State: SandboxNotReady},
+				{ID: "host1", State: SandboxReady},
+			}
+			So(c.ReSync(), ShouldBeNil)
+
+			Convey("Then its PU should be started with the metadata of the pod", func() {
+				So(h.events, ShouldResemble, []string{"create:abc", "start:abc"})
+
+				runtime := h.runtimes["abc"]
+				So(runtime.Name(), ShouldEqual, "web-0")
+				So(runtime.Pid(), ShouldEqual, 100)
+				So(runtime.NSPath(), ShouldEqual, "/var/run/netns/cni-abc")
+				So(runtime.PUType(), ShouldEqual, constants.KubernetesPU)
+				So(runtime.IPAddresses(), ShouldResemble, policy.ExtendedMap{"bridge": "10.1.1.1"})
+				So(runtime.Tags().GetSlice(), ShouldResemble, []string{"@sys:name=web-0", "@sys:namespace=shop", "@usr:app=web"})
+			})
+
+			Convey("Then the sandboxes should not be inspected again", func() {
+				So(c.ReSync(), ShouldBeNil)
+				So(client.inspected, ShouldResemble, []string{"abc", "host1"})
+				So(h.events, ShouldHaveLength, 2)
+			})
+
+			Convey("Then its PU should be stopped when the sandbox is stopped", func() {
+				client.sandboxes = []Sandbox{
+					{ID: "abc", State: SandboxNotReady},
+				}
+				So(c.ReSync(), ShouldBeNil)
+				So(h.events, ShouldResemble, []string{"create:abc", "start:abc", "stop:abc", "destroy:abc"})
+				So(c.handledSandboxes(), ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -0,0 +1,126 @@
+package crimonitor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// States of the pod sandboxes reported by the runtime
+const (
+	SandboxReady    = "SANDBOX_READY"
+	SandboxNotReady = "SANDBOX_NOTREADY"
+)
+
+// kubeletLabelPrefix is the prefix of the labels that the kubelet adds to the
+// labels of the pod on its sandbox
+const kubeletLabelPrefix = "io.kubernetes."
+
+// SandboxMetadata is the metadata of the pod of a sandbox
+type SandboxMetadata struct {
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+	Namespace string `json:"namespace"`
+}
+
+// Sandbox is a pod sandbox as listed by the runtime
+type Sandbox struct {
+	ID          string            `json:"id"`
+	Metadata    SandboxMetadata   `json:"metadata"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// SandboxList is a list of pod sandboxes
+type SandboxList struct {
+	Items []Sandbox `json:"items"`
+}
+
+// SandboxStatus is the part of the status of a pod sandbox used by the
+// monitor. The info is specific to the runtime: containerd and CRI-O report
+// the pid of the sandbox and its OCI runtime spec.
+type SandboxStatus struct {
+	Status struct {
+		Sandbox
+		Network struct {
+			IP string `json:"ip"`
+		} `json:"network"`
+		Linux struct {
+			Namespaces struct {
+				Options struct {
+					Network string `json:"network"`
+				} `json:"options"`
+			} `json:"namespaces"`
+		} `json:"linux"`
+	} `json:"status"`
+	Info struct {
+		Pid         int `json:"pid"`
+		RuntimeSpec struct {
+			Linux struct {
+				Namespaces []struct {
+					Type string `json:"type"`
+					Path string `json:"path"`
+				} `json:"namespaces"`
+			} `json:"linux"`
+		} `json:"runtimeSpec"`
+	} `json:"info"`
+}
+
+// HostNetwork returns true if the sandbox is in the network namespace of the
+// node
+func (s *SandboxStatus) HostNetwork() bool {
+
+	return s.Status.Linux.Namespaces.Options.Network == "NODE"
+}
+
+// NetworkNamespace returns the path of the network namespace of the sandbox.
+// The namespace of the pid is used if the runtime spec has no path.
+func (s *SandboxStatus) NetworkNamespace() (string, error) {
+
+	for _, ns := range s.Info.RuntimeSpec.Linux.Namespaces {
+		if ns.Type == "network" && ns.Path != "" {
+			return ns.Path, nil
+		}
+	}
+
+	if s.Info.Pid > 0 {
+		return fmt.Sprintf("/proc/%d/ns/net", s.Info.Pid), nil
+	}
+
+	return "", fmt.Errorf("no network namespace for sandbox %s", s.Status.ID)
+}
+
+// A SandboxMetadataExtractor is a function used to extract a *policy.PURuntime
+// from the status of a pod sandbox.
+type SandboxMetadataExtractor func(status *SandboxStatus) (*policy.PURuntime, error)
+
+// DefaultSandboxMetadataExtractor is the default metadata extractor for pod
+// sandboxes. The labels of the pod become user tags, like with the kubernetes
+// monitor.
+func DefaultSandboxMetadataExtractor(status *SandboxStatus) (*policy.PURuntime, error) {
+
+	nsPath, err := status.NetworkNamespace()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue("@sys:name", status.Status.Metadata.Name)
+	tags.AppendKeyValue("@sys:namespace", status.Status.Metadata.Namespace)
+
+	for k, v := range status.Status.Labels {
+		if strings.HasPrefix(k, kubeletLabelPrefix) {
+			continue
+		}
+		tags.AppendKeyValue("@usr:"+k, v)
+	}
+
+	ipa := policy.ExtendedMap{
+		"bridge": status.Status.Network.IP,
+	}
+
+	return policy.NewPURuntime(status.Status.Metadata.Name, status.Info.Pid, nsPath, tags, ipa, constants.KubernetesPU, nil), nil
+}
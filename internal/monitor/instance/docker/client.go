@@ -0,0 +1,88 @@
+package dockermonitor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	dockerClient "github.com/docker/docker/client"
+)
+
+// dockerUserAgent is the user agent of the requests sent to the daemon
+const dockerUserAgent = "engine-api-dockerClient-1.0"
+
+// initDockerClient creates a client of the daemon listening on a socket. The
+// connections to a tcp socket use TLS if tlsConfig is not nil. The headers
+// are added to the requests, and the client uses the latest version of the
+// API that it supports if version is empty.
+func initDockerClient(socketType string, socketAddress string, tlsConfig *tls.Config, headers map[string]string, version string) (*dockerClient.Client, error) {
+
+	var socket string
+	var httpClient *http.Client
+
+	switch socketType {
+	case "tcp":
+		socket = "https://" + socketAddress
+		if tlsConfig != nil {
+			httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		}
+
+	case "unix":
+		// Sanity check that this path exists
+		if _, oserr := os.Stat(socketAddress); os.IsNotExist(oserr) {
+			return nil, oserr
+		}
+		socket = "unix://" + socketAddress
+
+	default:
+		return nil, fmt.Errorf("bad socket type: %s", socketType)
+	}
+
+	defaultHeaders := map[string]string{"User-Agent": dockerUserAgent}
+	for k, v := range headers {
+		defaultHeaders[k] = v
+	}
+
+	dockerClient, err := dockerClient.NewClient(socket, version, httpClient, defaultHeaders)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to create docker client: %s", err)
+	}
+
+	return dockerClient, nil
+}
+
+// newTLSConfig returns the TLS configuration of the connections to a daemon
+// whose certificate is signed by the certificate authority of caFile. The
+// client presents the certificate of certFile if the daemon verifies the
+// certificates of its clients.
+func newTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{}
+
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read certificate authority: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid certificate authority")
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/aporeto-inc/trireme-lib/policy"
+	tevents "github.com/aporeto-inc/trireme-lib/rpc/events"
 	"github.com/docker/docker/api/types"
 )
 
@@ -54,3 +56,52 @@ func NewExternalExtractor(filePath string) (MetadataExtractor, error) {
 
 	return externalExtractor, nil
 }
+
+// NewECSMetadataExtractor returns a metadata extractor for Docker that adds the
+// metadata of the ECS task of a container to the tags of the runtime returned
+// by extractor. The metadata is read from the task metadata endpoint that the
+// ECS agent gives to the container. The containers that are not started by the
+// agent are left unchanged.
+func NewECSMetadataExtractor(extractor MetadataExtractor) MetadataExtractor {
+
+	return func(dockerInfo *types.ContainerJSON) (*policy.PURuntime, error) {
+
+		runtime, err := extractor(dockerInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		uri := ecsMetadataURI(dockerInfo)
+		if uri == "" {
+			return runtime, nil
+		}
+
+		metadata, err := tevents.ECSTaskMetadataFromURI(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		tags := runtime.Tags()
+		metadata.AddTags(tags)
+		runtime.SetTags(tags)
+
+		return runtime, nil
+	}
+}
+
+// ecsMetadataURI returns the URI of the task metadata endpoint in the
+// environment of a container
+func ecsMetadataURI(dockerInfo *types.ContainerJSON) string {
+
+	if dockerInfo == nil || dockerInfo.Config == nil {
+		return ""
+	}
+
+	for _, env := range dockerInfo.Config.Env {
+		if strings.HasPrefix(env, tevents.ECSMetadataURIEnv+"=") {
+			return strings.TrimPrefix(env, tevents.ECSMetadataURIEnv+"=")
+		}
+	}
+
+	return ""
+}
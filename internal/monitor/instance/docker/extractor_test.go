@@ -3,6 +3,8 @@ package dockermonitor
 import (
 	"bufio"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 
 	"testing"
@@ -52,3 +54,35 @@ func TestReturnedFunc(t *testing.T) {
 		t.Errorf("Failed to create extractor")
 	}
 }
+
+func TestECSMetadataExtractor(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Cluster": "shop", "TaskARN": "task", "Family": "web", "Revision": "3", "ServiceName": "frontend"}`) // nolint
+	}))
+	defer server.Close()
+
+	extractor := NewECSMetadataExtractor(defaultMetadataExtractor)
+
+	// A container that is not started by the agent is left unchanged
+	info := initTestDockerInfo(ID, "default", true)
+	runtime, err := extractor(info)
+	if err != nil {
+		t.Fatalf("Failed to extract metadata: %s", err)
+	}
+	if _, ok := runtime.Tags().Get("@sys:ecs-cluster"); ok {
+		t.Errorf("Expected no ecs tags")
+	}
+
+	info.Config.Env = []string{"PATH=/bin", "ECS_CONTAINER_METADATA_URI_V4=" + server.URL + "/v4/abc"}
+	runtime, err = extractor(info)
+	if err != nil {
+		t.Fatalf("Failed to extract metadata: %s", err)
+	}
+	if service, _ := runtime.Tags().Get("@sys:ecs-service"); service != "frontend" {
+		t.Errorf("Expected the service of the task, got %s", service)
+	}
+	if image, _ := runtime.Tags().Get("@sys:image"); image != "centos" {
+		t.Errorf("Expected the tags of the extractor, got %s", image)
+	}
+}
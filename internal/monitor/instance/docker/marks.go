@@ -0,0 +1,70 @@
+package dockermonitor
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
+)
+
+// hostModeMarks keeps the cgroup marks allocated to the host mode containers,
+// so that they are returned to the pool when the containers stop.
+type hostModeMarks struct {
+	// Key=ContextID Value=mark of the cgroup of the container
+	marks map[string]uint64
+	sync.Mutex
+}
+
+// newHostModeMarks creates a new tracker of the marks of the host mode
+// containers
+func newHostModeMarks() *hostModeMarks {
+
+	return &hostModeMarks{
+		marks: map[string]uint64{},
+	}
+}
+
+// allocate returns the mark of a container. A container that is started again
+// without being stopped keeps its mark.
+func (h *hostModeMarks) allocate(contextID string) (uint64, error) {
+
+	h.Lock()
+	defer h.Unlock()
+
+	if mark, ok := h.marks[contextID]; ok {
+		return mark, nil
+	}
+
+	mark, err := cgnetcls.AllocateMark()
+	if err != nil {
+		return 0, err
+	}
+
+	h.marks[contextID] = mark
+
+	return mark, nil
+}
+
+// release returns the mark of a container to the pool. It does nothing if the
+// container has no mark.
+func (h *hostModeMarks) release(contextID string) {
+
+	h.Lock()
+	defer h.Unlock()
+
+	mark, ok := h.marks[contextID]
+	if !ok {
+		return
+	}
+
+	delete(h.marks, contextID)
+
+	if err := cgnetcls.ReleaseMark(mark); err != nil {
+		zap.L().Debug("Unable to release mark",
+			zap.String("contextID", contextID),
+			zap.Uint64("mark", mark),
+			zap.Error(err),
+		)
+	}
+}
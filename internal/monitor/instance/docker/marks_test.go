@@ -0,0 +1,43 @@
+package dockermonitor
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
+)
+
+func TestHostModeMarks(t *testing.T) {
+
+	Convey("Given a tracker of the marks of the host mode containers", t, func() {
+
+		h := newHostModeMarks()
+		inUse := cgnetcls.GetMarkStats().InUse
+
+		Convey("When I allocate the mark of a container", func() {
+
+			mark, err := h.allocate("container")
+			So(err, ShouldBeNil)
+			So(mark, ShouldNotEqual, 0)
+			So(cgnetcls.GetMarkStats().InUse, ShouldEqual, inUse+1)
+
+			Convey("Then the container should keep its mark when it starts again", func() {
+				again, err := h.allocate("container")
+				So(err, ShouldBeNil)
+				So(again, ShouldEqual, mark)
+				So(cgnetcls.GetMarkStats().InUse, ShouldEqual, inUse+1)
+			})
+
+			Convey("Then the mark should be returned to the pool when it is released", func() {
+				h.release("container")
+				So(cgnetcls.GetMarkStats().InUse, ShouldEqual, inUse)
+
+				h.release("container")
+				So(cgnetcls.GetMarkStats().InUse, ShouldEqual, inUse)
+			})
+
+			h.release("container")
+		})
+	})
+}
@@ -2,11 +2,12 @@ package dockermonitor
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -55,9 +56,18 @@ const (
 	// EventUnpause represents the Docker "unpause" event.
 	EventUnpause Event = "unpause"
 
-	// EventConnect represents the Docker "connect" event.
+	// EventConnect represents the Docker "connect" event of a network.
 	EventConnect Event = "connect"
 
+	// EventDisconnect represents the Docker "disconnect" event of a network.
+	EventDisconnect Event = "disconnect"
+
+	// EventExecCreate represents the Docker "exec_create" event.
+	EventExecCreate Event = "exec_create"
+
+	// EventExecStart represents the Docker "exec_start" event.
+	EventExecStart Event = "exec_start"
+
 	// DockerClientVersion is the version sent out as the client
 	DockerClientVersion = "v1.23"
 
@@ -69,6 +79,17 @@ const (
 
 	// dockerInitializationWait is the time after which we will retry to bring docker up.
 	dockerInitializationWait = 2 * dockerRetryTimer
+
+	// dockerReconnectTimer is the time after which we will retry to connect to
+	// docker when it restarted.
+	dockerReconnectTimer = 1 * time.Second
+
+	// execInspectRetries is the number of times we inspect an exec session for its process.
+	// Docker reports the exec start before the process is started.
+	execInspectRetries = 10
+
+	// execInspectInterval is the time between two inspections of an exec session.
+	execInspectInterval = 100 * time.Millisecond
 )
 const (
 	cstorePath = "/var/run/trireme/docker"
@@ -87,46 +108,47 @@ type EventHandler func(event *events.Message) error
 // docker ContainerJSON.
 type MetadataExtractor func(*types.ContainerJSON) (*policy.PURuntime, error)
 
-func contextIDFromDockerID(dockerID string) (string, error) {
+// eventAction returns the event of a docker action. Some actions carry
+// additional information after a colon, e.g. "exec_start: sh".
+func eventAction(action string) Event {
 
-	if dockerID == "" {
-		return "", errors.New("unable to generate context id: empty docker id")
-	}
-
-	if len(dockerID) < 12 {
-		return "", fmt.Errorf("unable to generate context id: dockerid smaller than 12 characters: %s", dockerID)
-	}
-
-	return dockerID[:12], nil
+	return Event(strings.TrimSpace(strings.SplitN(action, ":", 2)[0]))
 }
 
-func initDockerClient(socketType string, socketAddress string) (*dockerClient.Client, error) {
+// messageContainerID returns the id of the container of a docker event. The
+// container of a network event is in its attributes.
+func messageContainerID(message *events.Message) string {
 
-	var socket string
+	if message.Type == events.NetworkEventType {
+		return message.Actor.Attributes["container"]
+	}
 
-	switch socketType {
-	case "tcp":
-		socket = "https://" + socketAddress
+	return message.ID
+}
 
-	case "unix":
-		// Sanity check that this path exists
-		if _, oserr := os.Stat(socketAddress); os.IsNotExist(oserr) {
-			return nil, oserr
-		}
-		socket = "unix://" + socketAddress
+// isContainerNetworkEvent returns true if a network event connects or
+// disconnects a container. The other network events are not handled.
+func isContainerNetworkEvent(message *events.Message) bool {
 
+	switch Event(message.Action) {
+	case EventConnect, EventDisconnect:
+		return message.Actor.Attributes["container"] != ""
 	default:
-		return nil, fmt.Errorf("bad socket type: %s", socketType)
+		return false
 	}
+}
 
-	defaultHeaders := map[string]string{"User-Agent": "engine-api-dockerClient-1.0"}
-	dockerClient, err := dockerClient.NewClient(socket, DockerClientVersion, nil, defaultHeaders)
+func contextIDFromDockerID(dockerID string) (string, error) {
 
-	if err != nil {
-		return nil, fmt.Errorf("unable to create docker client: %s", err)
+	if dockerID == "" {
+		return "", errors.New("unable to generate context id: empty docker id")
+	}
+
+	if len(dockerID) < 12 {
+		return "", fmt.Errorf("unable to generate context id: dockerid smaller than 12 characters: %s", dockerID)
 	}
 
-	return dockerClient, nil
+	return dockerID[:12], nil
 }
 
 // defaultMetadataExtractor is the default metadata extractor for Docker
@@ -140,9 +162,7 @@ func defaultMetadataExtractor(info *types.ContainerJSON) (*policy.PURuntime, err
 		tags.AppendKeyValue("@usr:"+k, v)
 	}
 
-	ipa := policy.ExtendedMap{
-		"bridge": info.NetworkSettings.IPAddress,
-	}
+	ipa := containerIPAddresses(info)
 
 	if info.HostConfig.NetworkMode == constants.DockerHostMode {
 		return policy.NewPURuntime(info.Name, info.State.Pid, "", tags, ipa, constants.LinuxProcessPU, hostModeOptions(info)), nil
@@ -151,13 +171,40 @@ func defaultMetadataExtractor(info *types.ContainerJSON) (*policy.PURuntime, err
 	return policy.NewPURuntime(info.Name, info.State.Pid, "", tags, ipa, constants.ContainerPU, nil), nil
 }
 
+// containerIPAddresses returns the IP addresses of a container by network. The
+// default address is the address on the default bridge, or the address on the
+// first network of the container by name if it is not on the default bridge.
+func containerIPAddresses(info *types.ContainerJSON) policy.ExtendedMap {
+
+	ipa := policy.ExtendedMap{
+		policy.DefaultNamespace: info.NetworkSettings.IPAddress,
+	}
+
+	names := make([]string, 0, len(info.NetworkSettings.Networks))
+	for name, endpoint := range info.NetworkSettings.Networks {
+		if endpoint != nil && endpoint.IPAddress != "" && name != policy.DefaultNamespace {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ipa[name] = info.NetworkSettings.Networks[name].IPAddress
+		if ipa[policy.DefaultNamespace] == "" {
+			ipa[policy.DefaultNamespace] = ipa[name]
+		}
+	}
+
+	return ipa
+}
+
 // hostModeOptions creates the default options for a host-mode container. This is done
-// based on the policy and the metadata extractor logic and can very by implementation
+// based on the policy and the metadata extractor logic and can very by implementation.
+// The mark of the cgroup is only allocated when the container starts.
 func hostModeOptions(dockerInfo *types.ContainerJSON) *policy.OptionsType {
 
 	options := policy.OptionsType{
 		CgroupName: strconv.Itoa(dockerInfo.State.Pid),
-		CgroupMark: strconv.FormatUint(cgnetcls.MarkVal(), 10),
 	}
 
 	for p := range dockerInfo.Config.ExposedPorts {
@@ -184,7 +231,24 @@ type Config struct {
 	SocketAddress              string
 	SyncAtStart                bool
 	KillContainerOnPolicyError bool
-	NoProxyMode                bool
+	// QuarantineOnPolicyError drops all the traffic of a container whose
+	// policy could not be set instead of killing it. The container is killed
+	// if it cannot be quarantined and KillContainerOnPolicyError is set.
+	QuarantineOnPolicyError bool
+	NoProxyMode             bool
+	// TLSCACert is the certificate authority of a daemon listening on a tcp
+	// socket with TLS. TLSCert and TLSKey are the client certificate if the
+	// daemon verifies its clients.
+	TLSCACert string
+	TLSCert   string
+	TLSKey    string
+	// Headers are added to the requests sent to the daemon
+	Headers map[string]string
+	// APIVersion is the version of the API used with the daemon
+	APIVersion string
+	// NegotiateAPIVersion uses the latest version of the API supported by
+	// both the monitor and the daemon instead of APIVersion
+	NegotiateAPIVersion bool
 }
 
 // DefaultConfig provides a default configuration
@@ -196,6 +260,7 @@ func DefaultConfig() *Config {
 		SyncAtStart:                true,
 		KillContainerOnPolicyError: false,
 		NoProxyMode:                false,
+		APIVersion:                 DockerClientVersion,
 	}
 }
 
@@ -213,6 +278,9 @@ func SetupDefaultConfig(dockerConfig *Config) *Config {
 	if dockerConfig.SocketAddress == "" {
 		dockerConfig.SocketAddress = defaultConfig.SocketAddress
 	}
+	if dockerConfig.APIVersion == "" {
+		dockerConfig.APIVersion = defaultConfig.APIVersion
+	}
 	return dockerConfig
 }
 
@@ -221,6 +289,10 @@ type dockerMonitor struct {
 	dockerClient       *dockerClient.Client
 	socketType         string
 	socketAddress      string
+	tlsConfig          *tls.Config
+	headers            map[string]string
+	apiVersion         string
+	negotiateVersion   bool
 	metadataExtractor  MetadataExtractor
 	handlers           map[Event]func(event *events.Message) error
 	eventnotifications []chan *events.Message
@@ -231,9 +303,13 @@ type dockerMonitor struct {
 	netcls             cgnetcls.Cgroupnetcls
 	// killContainerError if enabled kills the container if a policy setting resulted in an error.
 	killContainerOnPolicyError bool
-	syncAtStart                bool
-	NoProxyMode                bool
-	cstore                     contextstore.ContextStore
+	// quarantineOnPolicyError if enabled drops the traffic of the container instead.
+	quarantineOnPolicyError bool
+	syncAtStart             bool
+	NoProxyMode             bool
+	cstore                  contextstore.ContextStore
+	netns                   *netnsTracker
+	marks                   *hostModeMarks
 }
 
 // New returns a new docker monitor
@@ -261,9 +337,29 @@ func (d *dockerMonitor) SetupConfig(registerer registerer.Registerer, cfg interf
 
 	d.socketType = dockerConfig.SocketType
 	d.socketAddress = dockerConfig.SocketAddress
+	d.headers = dockerConfig.Headers
+	d.apiVersion = dockerConfig.APIVersion
+	d.negotiateVersion = dockerConfig.NegotiateAPIVersion
+
+	if dockerConfig.TLSCACert != "" || dockerConfig.TLSCert != "" || dockerConfig.TLSKey != "" {
+		if d.socketType != "tcp" {
+			return fmt.Errorf("tls requires a tcp socket: %s", d.socketType)
+		}
+		if d.tlsConfig, err = newTLSConfig(dockerConfig.TLSCACert, dockerConfig.TLSCert, dockerConfig.TLSKey); err != nil {
+			return err
+		}
+	}
+
+	// The client starts with the latest version that it supports, which is
+	// lowered to the version of the daemon
+	if d.negotiateVersion {
+		d.apiVersion = ""
+	}
+
 	d.metadataExtractor = dockerConfig.EventMetadataExtractor
 	d.syncAtStart = dockerConfig.SyncAtStart
 	d.killContainerOnPolicyError = dockerConfig.KillContainerOnPolicyError
+	d.quarantineOnPolicyError = dockerConfig.QuarantineOnPolicyError
 	d.handlers = make(map[Event]func(event *events.Message) error)
 	d.stoplistener = make(chan bool)
 	d.netcls = cgnetcls.NewDockerCgroupNetController()
@@ -272,6 +368,8 @@ func (d *dockerMonitor) SetupConfig(registerer registerer.Registerer, cfg interf
 	d.stopprocessor = make([]chan bool, d.numberOfQueues)
 	d.NoProxyMode = dockerConfig.NoProxyMode
 	d.cstore = contextstore.NewFileContextStore(cstorePath, nil)
+	d.netns = newNetnsTracker()
+	d.marks = newHostModeMarks()
 	for i := 0; i < d.numberOfQueues; i++ {
 		d.eventnotifications[i] = make(chan *events.Message, 1000)
 		d.stopprocessor[i] = make(chan bool)
@@ -284,6 +382,10 @@ func (d *dockerMonitor) SetupConfig(registerer registerer.Registerer, cfg interf
 	d.addHandler(EventDestroy, d.handleDestroyEvent)
 	d.addHandler(EventPause, d.handlePauseEvent)
 	d.addHandler(EventUnpause, d.handleUnpauseEvent)
+	d.addHandler(EventExecCreate, d.handleExecCreateEvent)
+	d.addHandler(EventExecStart, d.handleExecStartEvent)
+	d.addHandler(EventConnect, d.handleNetworkEvent)
+	d.addHandler(EventDisconnect, d.handleNetworkEvent)
 
 	return nil
 }
@@ -304,13 +406,15 @@ func (d *dockerMonitor) addHandler(event Event, handler EventHandler) {
 	d.handlers[event] = handler
 }
 
-// sendRequestToQueue sends a request to a channel based on a hash function
+// sendRequestToQueue sends a request to a channel based on a hash function.
+// The events of a container, including the events of its networks, are sent
+// to the same channel so that they are processed in order.
 func (d *dockerMonitor) sendRequestToQueue(r *events.Message) {
 
 	key0 := uint64(256203161)
 	key1 := uint64(982451653)
 
-	h := siphash.Hash(key0, key1, []byte(r.ID))
+	h := siphash.Hash(key0, key1, []byte(messageContainerID(r)))
 
 	d.eventnotifications[int(h%uint64(d.numberOfQueues))] <- r
 }
@@ -319,15 +423,24 @@ func (d *dockerMonitor) setupDockerDaemon() (err error) {
 
 	if d.dockerClient == nil {
 		// Initialize client
-		if d.dockerClient, err = initDockerClient(d.socketType, d.socketAddress); err != nil {
+		if d.dockerClient, err = initDockerClient(d.socketType, d.socketAddress, d.tlsConfig, d.headers, d.apiVersion); err != nil {
 			return err
 		}
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), dockerPingTimeout)
 	defer cancel()
-	_, err = d.dockerClient.Ping(ctx)
-	return err
+	ping, err := d.dockerClient.Ping(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The version is negotiated once the daemon answers
+	if d.negotiateVersion {
+		d.dockerClient.NegotiateAPIVersionPing(ping)
+	}
+
+	return nil
 }
 
 // waitForDockerDaemon is a blocking call which will try to bring up docker, if not return err
@@ -412,7 +525,7 @@ func (d *dockerMonitor) eventProcessors() {
 				select {
 				case event := <-d.eventnotifications[i]:
 					if event.Action != "" {
-						f, ok := d.handlers[Event(event.Action)]
+						f, ok := d.handlers[eventAction(event.Action)]
 						if ok {
 							err := f(event)
 							if err != nil {
@@ -442,17 +555,40 @@ func (d *dockerMonitor) eventProcessors() {
 
 // eventListener listens to Docker events from the daemon and passes to
 // to the processor through a buffered channel. This minimizes the chances
-// that we will miss events because the processor is delayed
+// that we will miss events because the processor is delayed. The stream of
+// events ends when the daemon restarts: the listener then reconnects and
+// synchronizes the containers that changed while it was not listening.
 func (d *dockerMonitor) eventListener(listenerReady chan struct{}) {
 
 	options := types.EventsOptions{}
 	options.Filters = filters.NewArgs()
-	options.Filters.Add("type", "container")
+	options.Filters.Add("type", events.ContainerEventType)
+	options.Filters.Add("type", events.NetworkEventType)
 
-	messages, errs := d.dockerClient.Events(context.Background(), options)
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		messages, errs := d.dockerClient.Events(ctx, options)
+
+		if listenerReady != nil {
+			// Once the buffered event channel was returned by Docker we return the ready status.
+			listenerReady <- struct{}{}
+			listenerReady = nil
+		} else if err := d.resyncChanges(); err != nil {
+			zap.L().Error("Unable to sync containers after docker restarted", zap.Error(err))
+		}
 
-	// Once the buffered event channel was returned by Docker we return the ready status.
-	listenerReady <- struct{}{}
+		stopped := d.listen(messages, errs)
+		cancel()
+
+		if stopped || !d.reconnect() {
+			return
+		}
+	}
+}
+
+// listen passes the events of a stream to the processor until the stream
+// ends. It returns true if the monitor was stopped.
+func (d *dockerMonitor) listen(messages <-chan events.Message, errs <-chan error) bool {
 
 	for {
 		select {
@@ -461,6 +597,9 @@ func (d *dockerMonitor) eventListener(listenerReady chan struct{}) {
 				zap.String("action", message.Action),
 				zap.String("ID", message.ID),
 			)
+			if message.Type == events.NetworkEventType && !isContainerNetworkEvent(&message) {
+				continue
+			}
 			d.sendRequestToQueue(&message)
 
 		case err := <-errs:
@@ -469,12 +608,120 @@ func (d *dockerMonitor) eventListener(listenerReady chan struct{}) {
 					zap.Error(err),
 				)
 			}
+			return false
+
+		case stop := <-d.stoplistener:
+			if stop {
+				return true
+			}
+		}
+	}
+}
+
+// reconnect waits until the daemon answers again. It returns false if the
+// monitor was stopped.
+func (d *dockerMonitor) reconnect() bool {
+
+	zap.L().Info("Lost connection to docker. Reconnecting...")
+
+	for {
+		select {
 		case stop := <-d.stoplistener:
 			if stop {
-				return
+				return false
+			}
+		case <-time.After(dockerReconnectTimer):
+			if err := d.setupDockerDaemon(); err != nil {
+				zap.L().Debug("Unable to reconnect to docker. Retrying...", zap.Error(err))
+				continue
+			}
+			zap.L().Info("Reconnected to docker")
+			return true
+		}
+	}
+}
+
+// resyncChanges sends the events missed while the monitor was not listening
+// to the processor, so that they are processed in order with the new events.
+// The containers that stopped are stopped and the containers that were
+// removed are destroyed. The containers that started or restarted are
+// started.
+func (d *dockerMonitor) resyncChanges() error {
+
+	containers, err := d.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("unable to get container list: %s", err)
+	}
+
+	pid := func(dockerID string) int {
+		info, err := d.dockerClient.ContainerInspect(context.Background(), dockerID)
+		if err != nil || info.ContainerJSONBase == nil || info.State == nil {
+			return 0
+		}
+		return info.State.Pid
+	}
+
+	for _, message := range missedEvents(containers, d.netns.started(), pid) {
+		d.sendRequestToQueue(message)
+	}
+
+	return nil
+}
+
+// missedEvents returns the events of the containers whose state differs from
+// the PUs that are started, given as the pid of their runtime by context id.
+// The containers that are still running restarted if their pid changed. The
+// pid of the containers sharing the namespace of another container is 0.
+func missedEvents(containers []types.Container, started map[string]int, pid func(dockerID string) int) []*events.Message {
+
+	messages := []*events.Message{}
+	message := func(action Event, dockerID string) *events.Message {
+		return &events.Message{
+			Type:   events.ContainerEventType,
+			Action: string(action),
+			ID:     dockerID,
+			Actor:  events.Actor{ID: dockerID},
+		}
+	}
+
+	listed := map[string]bool{}
+	for _, c := range containers {
+
+		contextID, err := contextIDFromDockerID(c.ID)
+		if err != nil {
+			continue
+		}
+		listed[contextID] = true
+
+		startedPid, ok := started[contextID]
+		running := c.State == "running" || c.State == "paused"
+
+		switch {
+		case running && !ok:
+			messages = append(messages, message(EventStart, c.ID))
+		case !running && ok:
+			messages = append(messages, message(EventDie, c.ID))
+		case running && startedPid > 0:
+			if current := pid(c.ID); current > 0 && current != startedPid {
+				messages = append(messages, message(EventDie, c.ID), message(EventStart, c.ID))
 			}
 		}
 	}
+
+	removed := []string{}
+	for contextID := range started {
+		if !listed[contextID] {
+			removed = append(removed, contextID)
+		}
+	}
+	sort.Strings(removed)
+
+	// The context id is a valid docker id for the handlers
+	for _, contextID := range removed {
+		messages = append(messages, message(EventDie, contextID), message(EventDestroy, contextID))
+	}
+
+	return messages
 }
 
 // ReSync resyncs all the existing containers on the Host, using the
@@ -670,7 +917,21 @@ func (d *dockerMonitor) startDockerContainer(dockerInfo *types.ContainerJSON) er
 		t.Merge(storedContext.Tags)
 		runtimeInfo.SetTags(t)
 	}
+
+	if dockerInfo.HostConfig.NetworkMode.IsContainer() {
+		return d.joinNetworkNamespace(contextID, dockerInfo, runtimeInfo)
+	}
+
+	if dockerInfo.HostConfig.NetworkMode == constants.DockerHostMode {
+		if err = d.assignHostModeMark(contextID, runtimeInfo); err != nil {
+			return err
+		}
+	}
+
+	d.netns.addOwner(contextID, runtimeInfo)
+
 	if err = d.config.PUHandler.CreatePURuntime(contextID, runtimeInfo); err != nil {
+		d.marks.release(contextID)
 		return err
 	}
 
@@ -689,14 +950,20 @@ func (d *dockerMonitor) startDockerContainer(dockerInfo *types.ContainerJSON) er
 		event = tevents.EventStart
 	}
 
+	var quarantineErr error
 	if err = d.config.PUHandler.HandlePUEvent(contextID, event); err != nil {
-		if d.killContainerOnPolicyError {
-			if derr := d.dockerClient.ContainerRemove(context.Background(), dockerInfo.ID, types.ContainerRemoveOptions{Force: true}); derr != nil {
-				return fmt.Errorf("unable to set policy: unable to remove container %s: %s, %s", contextID, err, derr)
+		if !d.quarantineOnPolicyError || !d.quarantine(contextID) {
+			if d.killContainerOnPolicyError {
+				if derr := d.dockerClient.ContainerRemove(context.Background(), dockerInfo.ID, types.ContainerRemoveOptions{Force: true}); derr != nil {
+					return fmt.Errorf("unable to set policy: unable to remove container %s: %s, %s", contextID, err, derr)
+				}
+				return fmt.Errorf("unable to set policy: removed container %s: %s", contextID, err)
 			}
-			return fmt.Errorf("unable to set policy: removed container %s: %s", contextID, err)
+			return fmt.Errorf("unable to set policy: container %s kept alive per policy: %s", contextID, err)
 		}
-		return fmt.Errorf("unable to set policy: container %s kept alive per policy: %s", contextID, err)
+		// The quarantined container is tracked like the other ones, so that its
+		// rules are deleted when it stops
+		quarantineErr = fmt.Errorf("unable to set policy: container %s quarantined: %s", contextID, err)
 	}
 
 	if dockerInfo.HostConfig.NetworkMode == constants.DockerHostMode {
@@ -710,7 +977,47 @@ func (d *dockerMonitor) startDockerContainer(dockerInfo *types.ContainerJSON) er
 		Tags:          runtimeInfo.Tags(),
 	}
 
-	return d.cstore.Store(contextID, storedContext)
+	if err = d.cstore.Store(contextID, storedContext); err != nil {
+		return err
+	}
+
+	return quarantineErr
+}
+
+// assignHostModeMark sets the mark of the cgroup of a host mode container in
+// the options of its runtime, unless the metadata extractor already set one.
+// The mark is released when the container stops.
+func (d *dockerMonitor) assignHostModeMark(contextID string, runtimeInfo *policy.PURuntime) error {
+
+	options := runtimeInfo.Options()
+	if options.CgroupMark != "" {
+		return nil
+	}
+
+	mark, err := d.marks.allocate(contextID)
+	if err != nil {
+		return fmt.Errorf("unable to allocate mark for container %s: %s", contextID, err)
+	}
+
+	options.CgroupMark = strconv.FormatUint(mark, 10)
+	runtimeInfo.SetOptions(options)
+
+	return nil
+}
+
+// quarantine drops all the traffic of a container whose policy could not be
+// set. It returns false if the container could not be quarantined.
+func (d *dockerMonitor) quarantine(contextID string) bool {
+
+	if err := d.config.PUHandler.HandlePUEvent(contextID, tevents.EventQuarantine); err != nil {
+		zap.L().Warn("Unable to quarantine container",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	return true
 }
 
 func (d *dockerMonitor) stopDockerContainer(dockerID string) error {
@@ -719,12 +1026,65 @@ func (d *dockerMonitor) stopDockerContainer(dockerID string) error {
 	if err != nil {
 		return err
 	}
+
+	d.marks.release(contextID)
+
 	if err = d.cstore.Remove(contextID); err != nil {
 		return err
 	}
+
+	// A container sharing the namespace of another container has no PU
+	if owner, runtimeInfo, ok := d.netns.leave(contextID); ok {
+		if runtimeInfo == nil {
+			return nil
+		}
+		return d.updateNetworkNamespace(owner, runtimeInfo)
+	}
+
+	d.netns.removeOwner(contextID)
+
 	return d.config.PUHandler.HandlePUEvent(contextID, tevents.EventStop)
 }
 
+// joinNetworkNamespace attaches a container that shares the network namespace
+// of another container to the PU of that container instead of creating a new
+// PU. The PU of the namespace is enforced with the tags of both containers.
+func (d *dockerMonitor) joinNetworkNamespace(contextID string, dockerInfo *types.ContainerJSON, runtimeInfo *policy.PURuntime) error {
+
+	ownerInfo, err := d.dockerClient.ContainerInspect(context.Background(), dockerInfo.HostConfig.NetworkMode.ConnectedContainer())
+	if err != nil {
+		return fmt.Errorf("unable to read network namespace owner of container %s: %s", contextID, err)
+	}
+
+	owner, err := contextIDFromDockerID(ownerInfo.ID)
+	if err != nil {
+		return err
+	}
+
+	if ownerRuntime := d.netns.join(owner, contextID, runtimeInfo.Tags()); ownerRuntime != nil {
+		if err := d.updateNetworkNamespace(owner, ownerRuntime); err != nil {
+			return fmt.Errorf("unable to update pu %s with container %s: %s", owner, contextID, err)
+		}
+	}
+
+	return d.cstore.Store(contextID, &StoredContext{
+		containerInfo: dockerInfo,
+		Tags:          runtimeInfo.Tags(),
+	})
+}
+
+// updateNetworkNamespace updates the tags of the PU of the owner of a network
+// namespace after a container joined or left the namespace, and resolves its
+// policy again.
+func (d *dockerMonitor) updateNetworkNamespace(owner string, runtimeInfo *policy.PURuntime) error {
+
+	if err := d.config.PUHandler.UpdatePURuntime(owner, runtimeInfo); err != nil {
+		return err
+	}
+
+	return d.config.PUHandler.HandlePUEvent(owner, tevents.EventUpdate)
+}
+
 // ExtractMetadata generates the RuntimeInfo based on Docker primitive
 func (d *dockerMonitor) extractMetadata(dockerInfo *types.ContainerJSON) (*policy.PURuntime, error) {
 
@@ -805,6 +1165,8 @@ func (d *dockerMonitor) handleDestroyEvent(event *events.Message) error {
 		return err
 	}
 
+	d.marks.release(contextID)
+
 	err = d.config.PUHandler.HandlePUEvent(contextID, tevents.EventDestroy)
 
 	if err != nil {
@@ -823,6 +1185,78 @@ func (d *dockerMonitor) handleDestroyEvent(event *events.Message) error {
 	return nil
 }
 
+// handleNetworkEvent updates the IP addresses of the PU of a running container
+// that was connected to a network or disconnected from a network. The events
+// of the containers without a PU, like the containers that are not started
+// yet or that share the network namespace of another container, are ignored.
+func (d *dockerMonitor) handleNetworkEvent(event *events.Message) error {
+
+	dockerID := messageContainerID(event)
+
+	contextID, err := contextIDFromDockerID(dockerID)
+	if err != nil {
+		return err
+	}
+
+	runtimeInfo := d.netns.ownerRuntime(contextID)
+	if runtimeInfo == nil {
+		return nil
+	}
+
+	info, err := d.dockerClient.ContainerInspect(context.Background(), dockerID)
+	if err != nil {
+		return fmt.Errorf("unable to read container information: container %s: %s", contextID, err)
+	}
+
+	if !info.State.Running {
+		return nil
+	}
+
+	current, err := d.extractMetadata(&info)
+	if err != nil {
+		return err
+	}
+
+	ipa := current.IPAddresses()
+	if sameIPAddresses(ipa, runtimeInfo.IPAddresses()) {
+		return nil
+	}
+
+	zap.L().Debug("Container IP addresses changed",
+		zap.String("contextID", contextID),
+		zap.String("action", event.Action),
+		zap.String("network", event.Actor.Attributes["name"]),
+	)
+
+	// The runtime known by the tracker is only updated once the PU handler
+	// accepted the new addresses
+	updated := runtimeInfo.Clone()
+	updated.SetIPAddresses(ipa)
+
+	if err := d.config.PUHandler.UpdatePURuntime(contextID, updated); err != nil {
+		return fmt.Errorf("unable to update container %s: %s", contextID, err)
+	}
+	d.netns.setIPAddresses(contextID, ipa)
+
+	return d.config.PUHandler.HandlePUEvent(contextID, tevents.EventUpdate)
+}
+
+// sameIPAddresses compares the IP addresses of two runtimes
+func sameIPAddresses(a, b policy.ExtendedMap) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+
+	return true
+}
+
 // handlePauseEvent generates a create event type.
 func (d *dockerMonitor) handlePauseEvent(event *events.Message) error {
 	zap.L().Info("UnPause Event for nativeID", zap.String("ID", event.ID))
@@ -844,3 +1278,79 @@ func (d *dockerMonitor) handleUnpauseEvent(event *events.Message) error {
 
 	return d.config.PUHandler.HandlePUEvent(contextID, tevents.EventUnpause)
 }
+
+// handleExecCreateEvent handles the creation of an exec session. There is no
+// process yet and the session is attached to its container when it starts.
+func (d *dockerMonitor) handleExecCreateEvent(event *events.Message) error {
+
+	contextID, err := contextIDFromDockerID(event.ID)
+	if err != nil {
+		return err
+	}
+
+	zap.L().Debug("Exec session created",
+		zap.String("contextID", contextID),
+		zap.String("execID", event.Actor.Attributes["execID"]),
+	)
+
+	return nil
+}
+
+// handleExecStartEvent attaches the process of an exec session to the net_cls
+// cgroup of its container. Only host mode containers need it since the
+// sessions of other containers share the network namespace of the container.
+func (d *dockerMonitor) handleExecStartEvent(event *events.Message) error {
+
+	contextID, err := contextIDFromDockerID(event.ID)
+	if err != nil {
+		return err
+	}
+
+	execID := event.Actor.Attributes["execID"]
+	if execID == "" {
+		return fmt.Errorf("unable to attach exec session to container %s: no exec id", contextID)
+	}
+
+	info, err := d.dockerClient.ContainerInspect(context.Background(), event.ID)
+	if err != nil {
+		return fmt.Errorf("unable to read container information: container %s: %s", contextID, err)
+	}
+
+	if info.HostConfig == nil || info.HostConfig.NetworkMode != constants.DockerHostMode {
+		return nil
+	}
+
+	return d.attachExecSession(contextID, execID, execInspectRetries)
+}
+
+// attachExecSession attaches the process of an exec session to the net_cls
+// cgroup of its container. Docker reports the start of the session before its
+// process is started, so the session is inspected again after a while from a
+// timer, without blocking the processing of the events.
+func (d *dockerMonitor) attachExecSession(contextID string, execID string, retries int) error {
+
+	execInfo, err := d.dockerClient.ContainerExecInspect(context.Background(), execID)
+	if err != nil {
+		return fmt.Errorf("unable to attach exec session %s to container %s: %s", execID, contextID, err)
+	}
+
+	if execInfo.Pid == 0 {
+		if retries <= 1 {
+			return fmt.Errorf("unable to attach exec session %s to container %s: exec session not started", execID, contextID)
+		}
+
+		time.AfterFunc(execInspectInterval, func() {
+			if err := d.attachExecSession(contextID, execID, retries-1); err != nil {
+				zap.L().Error("Unable to attach exec session", zap.Error(err))
+			}
+		})
+
+		return nil
+	}
+
+	if err := d.netcls.AddProcess(contextID, execInfo.Pid); err != nil {
+		return fmt.Errorf("unable to attach exec session %s to container %s: %s", execID, contextID, err)
+	}
+
+	return nil
+}
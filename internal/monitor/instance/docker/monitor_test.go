@@ -2,6 +2,7 @@ package dockermonitor
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance"
+	"github.com/aporeto-inc/trireme-lib/policy"
 	tevents "github.com/aporeto-inc/trireme-lib/rpc/events"
 	"github.com/aporeto-inc/trireme-lib/rpc/processor"
 	"github.com/aporeto-inc/trireme-lib/rpc/processor/mock"
@@ -21,6 +23,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -108,7 +111,7 @@ func TestNewDockerMonitor(t *testing.T) {
 func TestInitDockerClient(t *testing.T) {
 
 	Convey("When I try to initialize a new docker client as unix", t, func() {
-		dc, err := initDockerClient(constants.DefaultDockerSocketType, constants.DefaultDockerSocket)
+		dc, err := initDockerClient(constants.DefaultDockerSocketType, constants.DefaultDockerSocket, nil, nil, DockerClientVersion)
 
 		Convey("Then docker client should not be nil", func() {
 			So(dc, ShouldNotBeNil)
@@ -117,7 +120,7 @@ func TestInitDockerClient(t *testing.T) {
 	})
 
 	Convey("When I try to initialize a new docker client as tcp", t, func() {
-		dc, err := initDockerClient("tcp", constants.DefaultDockerSocket)
+		dc, err := initDockerClient("tcp", constants.DefaultDockerSocket, &tls.Config{}, map[string]string{"X-Trireme": "1"}, "")
 
 		Convey("Then docker client should not be nil", func() {
 			So(dc, ShouldNotBeNil)
@@ -126,7 +129,7 @@ func TestInitDockerClient(t *testing.T) {
 	})
 
 	Convey("When I try to initialize a new docker client with some random type", t, func() {
-		dc, err := initDockerClient("wrongtype", constants.DefaultDockerSocket)
+		dc, err := initDockerClient("wrongtype", constants.DefaultDockerSocket, nil, nil, DockerClientVersion)
 
 		Convey("Then docker client should be nil and I should get error", func() {
 			So(dc, ShouldBeNil)
@@ -135,7 +138,7 @@ func TestInitDockerClient(t *testing.T) {
 	})
 
 	Convey("When I try to initialize a new docker client with some random path", t, func() {
-		dc, err := initDockerClient(constants.DefaultDockerSocketType, "/var/random.sock")
+		dc, err := initDockerClient(constants.DefaultDockerSocketType, "/var/random.sock", nil, nil, DockerClientVersion)
 
 		Convey("Then docker client should be nil and I should get error", func() {
 			So(dc, ShouldBeNil)
@@ -291,11 +294,41 @@ func TestStartDockerContainer(t *testing.T) {
 			})
 		})
 
+		Convey("When I try to start default docker container with invalid context ID and quarantineOnPolicyError set", func() {
+			dmi.killContainerOnPolicyError = true
+			dmi.quarantineOnPolicyError = true
+
+			mockPU.EXPECT().CreatePURuntime(gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			mockPU.EXPECT().HandlePUEvent(gomock.Any(), tevents.EventStart).Times(1).Return(fmt.Errorf("Error"))
+			mockPU.EXPECT().HandlePUEvent("74cc486f9ec3", tevents.EventQuarantine).Times(1).Return(nil)
+			store.EXPECT().Retrieve(gomock.Any(), gomock.Any()).Return(nil)
+			store.EXPECT().Store("74cc486f9ec3", gomock.Any()).Times(1).Return(nil)
+			err := dmi.startDockerContainer(initTestDockerInfo(ID, "default", true))
+
+			Convey("Then the container should be quarantined and kept alive", func() {
+				So(err, ShouldResemble, errors.New("unable to set policy: container 74cc486f9ec3 quarantined: Error"))
+			})
+		})
+
+		Convey("When I try to start default docker container with invalid context ID and the quarantine fails", func() {
+			dmi.quarantineOnPolicyError = true
+
+			mockPU.EXPECT().CreatePURuntime(gomock.Any(), gomock.Any()).Times(1).Return(nil)
+			mockPU.EXPECT().HandlePUEvent(gomock.Any(), tevents.EventStart).Times(1).Return(fmt.Errorf("Error"))
+			mockPU.EXPECT().HandlePUEvent("74cc486f9ec3", tevents.EventQuarantine).Times(1).Return(fmt.Errorf("quarantine"))
+			store.EXPECT().Retrieve(gomock.Any(), gomock.Any()).Return(nil)
+			err := dmi.startDockerContainer(initTestDockerInfo(ID, "default", true))
+
+			Convey("Then I should get error", func() {
+				So(err, ShouldResemble, errors.New("unable to set policy: container 74cc486f9ec3 kept alive per policy: Error"))
+			})
+		})
+
 		Convey("When I try to start host docker container", func() {
 			mockPU.EXPECT().CreatePURuntime(gomock.Any(), gomock.Any()).Times(1).Return(nil)
 			mockPU.EXPECT().HandlePUEvent(gomock.Any(), tevents.EventStart).Times(1).Return(nil)
 			mockCG.EXPECT().Creategroup("74cc486f9ec3").Times(1).Return(nil)
-			mockCG.EXPECT().AssignMark("74cc486f9ec3", uint64(102)).Times(1).Return(nil)
+			mockCG.EXPECT().AssignMark("74cc486f9ec3", gomock.Any()).Times(1).Return(nil)
 			mockCG.EXPECT().AddProcess("74cc486f9ec3", int(4912)).Times(1).Return(nil)
 			store.EXPECT().Retrieve(gomock.Any(), gomock.Any()).Return(nil)
 			store.EXPECT().Store(gomock.Any(), gomock.Any()).Return(nil)
@@ -305,6 +338,15 @@ func TestStartDockerContainer(t *testing.T) {
 			Convey("Then I should not get any error", func() {
 				So(err, ShouldBeNil)
 			})
+
+			Convey("Then the container should hold a mark until it stops", func() {
+				So(dmi.marks.marks, ShouldContainKey, "74cc486f9ec3")
+
+				mockPU.EXPECT().HandlePUEvent("74cc486f9ec3", tevents.EventStop).Times(1).Return(nil)
+				store.EXPECT().Remove("74cc486f9ec3").Return(nil)
+				So(dmi.stopDockerContainer(ID), ShouldBeNil)
+				So(dmi.marks.marks, ShouldBeEmpty)
+			})
 		})
 
 		Convey("When I try to start host docker container with error in assigning mark", func() {
@@ -326,7 +368,7 @@ func TestStartDockerContainer(t *testing.T) {
 			mockPU.EXPECT().CreatePURuntime(gomock.Any(), gomock.Any()).Times(1).Return(nil)
 			mockPU.EXPECT().HandlePUEvent(gomock.Any(), tevents.EventStart).Times(1).Return(nil)
 			mockCG.EXPECT().Creategroup("74cc486f9ec3").Times(1).Return(nil)
-			mockCG.EXPECT().AssignMark("74cc486f9ec3", uint64(104)).Times(1).Return(nil)
+			mockCG.EXPECT().AssignMark("74cc486f9ec3", gomock.Any()).Times(1).Return(nil)
 			mockCG.EXPECT().AddProcess(gomock.Any(), gomock.Any()).Times(1).Return(errors.New("error"))
 			mockCG.EXPECT().DeleteCgroup("74cc486f9ec3").Times(1).Return(nil)
 			store.EXPECT().Retrieve(gomock.Any(), gomock.Any()).Return(nil)
@@ -358,6 +400,7 @@ func TestStartDockerContainer(t *testing.T) {
 
 			Convey("Then I should get error", func() {
 				So(err, ShouldResemble, errors.New("Error"))
+				So(dmi.marks.marks, ShouldBeEmpty)
 			})
 		})
 	})
@@ -791,3 +834,200 @@ func TestStart(t *testing.T) {
 		})
 	})
 }
+
+func TestEventAction(t *testing.T) {
+
+	Convey("When I get the event of a docker action", t, func() {
+
+		Convey("Then actions without details should be returned as is", func() {
+			So(eventAction("start"), ShouldEqual, EventStart)
+		})
+
+		Convey("Then the details of exec actions should be removed", func() {
+			So(eventAction("exec_create: sh -c ls"), ShouldEqual, EventExecCreate)
+			So(eventAction("exec_start: sh -c ls"), ShouldEqual, EventExecStart)
+		})
+	})
+}
+
+func TestContainerIPAddresses(t *testing.T) {
+
+	Convey("Given a container on the default bridge", t, func() {
+
+		info := initTestDockerInfo(ID, "default", true)
+
+		Convey("Then its default address should be its address on the bridge", func() {
+			So(containerIPAddresses(info), ShouldResemble, policy.ExtendedMap{"bridge": "172.17.0.2"})
+		})
+
+		Convey("When it is connected to other networks", func() {
+
+			info.NetworkSettings.Networks = map[string]*network.EndpointSettings{
+				"bridge":  {IPAddress: "172.17.0.2"},
+				"db":      {IPAddress: "10.2.0.3"},
+				"backend": {IPAddress: "10.1.0.3"},
+				"pending": {},
+			}
+
+			Convey("Then it should have the addresses of all its networks", func() {
+				So(containerIPAddresses(info), ShouldResemble, policy.ExtendedMap{
+					"bridge":  "172.17.0.2",
+					"backend": "10.1.0.3",
+					"db":      "10.2.0.3",
+				})
+			})
+
+			Convey("Then its default address should be on the first network if it is not on the bridge", func() {
+				info.NetworkSettings.IPAddress = ""
+				delete(info.NetworkSettings.Networks, "bridge")
+				So(containerIPAddresses(info), ShouldResemble, policy.ExtendedMap{
+					"bridge":  "10.1.0.3",
+					"backend": "10.1.0.3",
+					"db":      "10.2.0.3",
+				})
+			})
+		})
+	})
+}
+
+func TestNetworkEvents(t *testing.T) {
+
+	Convey("Given the events of a network", t, func() {
+
+		connect := &events.Message{
+			Type:   events.NetworkEventType,
+			Action: "connect",
+			ID:     "4d2e7e9a2f4b",
+			Actor: events.Actor{
+				ID:         "4d2e7e9a2f4b",
+				Attributes: map[string]string{"container": ID, "name": "backend"},
+			},
+		}
+
+		Convey("Then the connections of containers should be handled with their container", func() {
+			So(isContainerNetworkEvent(connect), ShouldBeTrue)
+			So(messageContainerID(connect), ShouldEqual, ID)
+
+			connect.Action = "disconnect"
+			So(isContainerNetworkEvent(connect), ShouldBeTrue)
+		})
+
+		Convey("Then the other network events should not be handled", func() {
+			connect.Action = "destroy"
+			So(isContainerNetworkEvent(connect), ShouldBeFalse)
+		})
+
+		Convey("Then the container events should be handled with their id", func() {
+			So(messageContainerID(initTestMessage(ID)), ShouldEqual, ID)
+		})
+	})
+}
+
+func TestMissedEvents(t *testing.T) {
+
+	Convey("Given the containers listed when docker restarted", t, func() {
+
+		containers := []types.Container{
+			{ID: "aaaaaaaaaaaa0000", State: "running"},
+			{ID: "bbbbbbbbbbbb0000", State: "exited"},
+			{ID: "cccccccccccc0000", State: "running"},
+			{ID: "dddddddddddd0000", State: "running"},
+			{ID: "eeeeeeeeeeee0000", State: "paused"},
+		}
+
+		pids := map[string]int{"cccccccccccc0000": 200, "dddddddddddd0000": 300, "eeeeeeeeeeee0000": 400}
+		pid := func(dockerID string) int {
+			return pids[dockerID]
+		}
+
+		Convey("When I compare them with the started PUs", func() {
+
+			started := map[string]int{
+				"bbbbbbbbbbbb": 100,
+				"cccccccccccc": 200,
+				"dddddddddddd": 301,
+				"eeeeeeeeeeee": 0,
+				"ffffffffffff": 500,
+			}
+
+			actions := []string{}
+			for _, message := range missedEvents(containers, started, pid) {
+				So(message.Type, ShouldEqual, events.ContainerEventType)
+				actions = append(actions, message.Action+":"+message.ID)
+			}
+
+			Convey("Then I should get the events missed while it restarted", func() {
+				So(actions, ShouldResemble, []string{
+					"start:aaaaaaaaaaaa0000",
+					"die:bbbbbbbbbbbb0000",
+					"die:dddddddddddd0000",
+					"start:dddddddddddd0000",
+					"die:ffffffffffff",
+					"destroy:ffffffffffff",
+				})
+			})
+		})
+	})
+}
+
+func TestNewTLSConfig(t *testing.T) {
+
+	Convey("When I create the TLS configuration of a daemon", t, func() {
+
+		Convey("Then it should fail if the files cannot be read", func() {
+			_, err := newTLSConfig("/var/random-ca.pem", "", "")
+			So(err, ShouldNotBeNil)
+
+			_, err = newTLSConfig("", "/var/random-cert.pem", "/var/random-key.pem")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then it should use the system authorities without files", func() {
+			tlsConfig, err := newTLSConfig("", "", "")
+			So(err, ShouldBeNil)
+			So(tlsConfig.RootCAs, ShouldBeNil)
+			So(tlsConfig.Certificates, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestHandleExecEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("When I try to initialize a new docker monitor", t, func() {
+
+		dm, dmi, _, _ := setupDockerMonitor(ctrl)
+
+		Convey("Then docker monitor should not be nil", func() {
+			So(dm, ShouldNotBeNil)
+			So(dmi, ShouldNotBeNil)
+		})
+
+		Convey("When I try to handle exec create event", func() {
+			err := dmi.handleExecCreateEvent(initTestMessage(ID))
+
+			Convey("Then I should not get any error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When I try to handle exec events with no ID given", func() {
+			errCreate := dmi.handleExecCreateEvent(initTestMessage(""))
+			errStart := dmi.handleExecStartEvent(initTestMessage(""))
+
+			Convey("Then I should get errors", func() {
+				So(errCreate, ShouldResemble, errors.New("unable to generate context id: empty docker id"))
+				So(errStart, ShouldResemble, errors.New("unable to generate context id: empty docker id"))
+			})
+		})
+
+		Convey("When I try to handle exec start event with no exec ID given", func() {
+			err := dmi.handleExecStartEvent(initTestMessage(ID))
+
+			Convey("Then I should get error", func() {
+				So(err, ShouldResemble, errors.New("unable to attach exec session to container 74cc486f9ec3: no exec id"))
+			})
+		})
+	})
+}
@@ -0,0 +1,222 @@
+package dockermonitor
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// netnsGroup is a network namespace shared by several containers. The PU of
+// the container that created the namespace enforces the whole namespace with
+// the tags of all its containers.
+type netnsGroup struct {
+	// copy of the runtime of the PU of the owner. It is nil until the owner is
+	// started, and is never given to the PU handler.
+	runtime *policy.PURuntime
+	// tags of the owner itself
+	tags *policy.TagStore
+	// Key=ContextID Value=tags of the member container
+	members map[string]*policy.TagStore
+}
+
+// netnsTracker keeps track of the containers sharing the network namespace
+// of another container (--net=container:X, pods).
+type netnsTracker struct {
+	// Key=ContextID of the owner
+	groups map[string]*netnsGroup
+	// Key=ContextID of a member Value=ContextID of the owner
+	owners map[string]string
+	sync.Mutex
+}
+
+// newNetnsTracker creates a new tracker of shared network namespaces
+func newNetnsTracker() *netnsTracker {
+
+	return &netnsTracker{
+		groups: map[string]*netnsGroup{},
+		owners: map[string]string{},
+	}
+}
+
+// group returns the group of an owner and creates it if needed
+func (n *netnsTracker) group(owner string) *netnsGroup {
+
+	g, ok := n.groups[owner]
+	if !ok {
+		g = &netnsGroup{
+			tags:    policy.NewTagStore(),
+			members: map[string]*policy.TagStore{},
+		}
+		n.groups[owner] = g
+	}
+
+	return g
+}
+
+// addOwner registers the runtime of a container that owns its network
+// namespace. The tags of the containers that already joined the namespace are
+// merged in the runtime, so it must be called before the runtime is given to
+// the PU handler.
+func (n *netnsTracker) addOwner(owner string, runtime *policy.PURuntime) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	g := n.group(owner)
+	g.tags = runtime.Tags()
+	runtime.SetTags(g.mergedTags())
+	g.runtime = runtime.Clone()
+}
+
+// ownerRuntime returns a copy of the runtime of the PU of a container that
+// owns its network namespace, or nil if it is not started.
+func (n *netnsTracker) ownerRuntime(owner string) *policy.PURuntime {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if g, ok := n.groups[owner]; ok && g.runtime != nil {
+		return g.runtime.Clone()
+	}
+
+	return nil
+}
+
+// setIPAddresses records the IP addresses of the runtime of the PU of an
+// owner after they were updated.
+func (n *netnsTracker) setIPAddresses(owner string, ips policy.ExtendedMap) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if g, ok := n.groups[owner]; ok && g.runtime != nil {
+		g.runtime.SetIPAddresses(ips)
+	}
+}
+
+// started returns the containers whose PU is started, or that joined the
+// namespace of another container, with the pid of the runtime of their PU. The
+// pid of the containers that joined a namespace is 0.
+func (n *netnsTracker) started() map[string]int {
+
+	n.Lock()
+	defer n.Unlock()
+
+	started := map[string]int{}
+	for owner, g := range n.groups {
+		if g.runtime != nil {
+			started[owner] = g.runtime.Pid()
+		}
+	}
+	for member := range n.owners {
+		started[member] = 0
+	}
+
+	return started
+}
+
+// join adds a container to the network namespace of the owner. It returns a
+// copy of the runtime of the owner with the new tags, or nil if the owner is
+// not started.
+func (n *netnsTracker) join(owner string, member string, tags *policy.TagStore) *policy.PURuntime {
+
+	n.Lock()
+	defer n.Unlock()
+
+	g := n.group(owner)
+	g.members[member] = tags
+	n.owners[member] = owner
+
+	return g.apply()
+}
+
+// leave removes a container from the network namespace it joined. It returns
+// the owner of the namespace with a copy of its runtime with the new tags, or
+// nil if the owner is not started, and false if the container was not a
+// member.
+func (n *netnsTracker) leave(member string) (string, *policy.PURuntime, bool) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	owner, ok := n.owners[member]
+	if !ok {
+		return "", nil, false
+	}
+
+	delete(n.owners, member)
+
+	var runtime *policy.PURuntime
+	if g, ok := n.groups[owner]; ok {
+		delete(g.members, member)
+		runtime = g.apply()
+		n.cleanup(owner, g)
+	}
+
+	return owner, runtime, true
+}
+
+// removeOwner removes the runtime of the owner of a namespace. The group is
+// kept until all the members left.
+func (n *netnsTracker) removeOwner(owner string) {
+
+	n.Lock()
+	defer n.Unlock()
+
+	if g, ok := n.groups[owner]; ok {
+		g.runtime = nil
+		n.cleanup(owner, g)
+	}
+}
+
+// cleanup removes a group without owner and members
+func (n *netnsTracker) cleanup(owner string, g *netnsGroup) {
+
+	if g.runtime == nil && len(g.members) == 0 {
+		delete(n.groups, owner)
+	}
+}
+
+// apply sets the merged tags on the copy of the runtime of the owner. It
+// returns a new copy of the runtime, or nil if the owner is not started.
+func (g *netnsGroup) apply() *policy.PURuntime {
+
+	if g.runtime == nil {
+		return nil
+	}
+
+	g.runtime.SetTags(g.mergedTags())
+
+	return g.runtime.Clone()
+}
+
+// mergedTags returns the tags of the owner and of all the members. Members
+// are merged in a stable order and duplicate tags are only kept once.
+func (g *netnsGroup) mergedTags() *policy.TagStore {
+
+	merged := g.tags.Copy()
+
+	seen := map[string]bool{}
+	for _, tag := range merged.Tags {
+		seen[tag] = true
+	}
+
+	members := make([]string, 0, len(g.members))
+	for member := range g.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	for _, member := range members {
+		for _, tag := range g.members[member].Tags {
+			if seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			merged.Tags = append(merged.Tags, tag)
+		}
+	}
+
+	return merged
+}
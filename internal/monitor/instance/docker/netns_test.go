@@ -0,0 +1,108 @@
+package dockermonitor
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func netnsTestRuntime(tags ...string) *policy.PURuntime {
+
+	return policy.NewPURuntime("owner", 1, "", &policy.TagStore{Tags: tags}, nil, constants.ContainerPU, nil)
+}
+
+func TestNetnsTracker(t *testing.T) {
+
+	Convey("Given a tracker with the owner of a namespace", t, func() {
+
+		n := newNetnsTracker()
+		runtime := netnsTestRuntime("@usr:app=pause", "@usr:pod=web")
+		n.addOwner("owner", runtime)
+
+		Convey("When a container joins the namespace", func() {
+
+			updated := n.join("owner", "member", &policy.TagStore{Tags: []string{"@usr:app=nginx", "@usr:pod=web"}})
+
+			Convey("Then I should get a copy of the owner runtime with the tags of both containers", func() {
+				So(updated, ShouldNotBeNil)
+				So(updated, ShouldNotEqual, runtime)
+				So(updated.Tags().Tags, ShouldResemble, []string{"@usr:app=pause", "@usr:pod=web", "@usr:app=nginx"})
+				So(runtime.Tags().Tags, ShouldResemble, []string{"@usr:app=pause", "@usr:pod=web"})
+			})
+
+			Convey("When the container leaves the namespace", func() {
+
+				owner, updated, ok := n.leave("member")
+
+				Convey("Then I should get a copy of the owner runtime with its own tags", func() {
+					So(ok, ShouldBeTrue)
+					So(owner, ShouldEqual, "owner")
+					So(updated.Tags().Tags, ShouldResemble, []string{"@usr:app=pause", "@usr:pod=web"})
+				})
+			})
+
+			Convey("When the owner stops before the member", func() {
+
+				n.removeOwner("owner")
+
+				Convey("Then the member should still leave the namespace", func() {
+					owner, updated, ok := n.leave("member")
+					So(ok, ShouldBeTrue)
+					So(owner, ShouldEqual, "owner")
+					So(updated, ShouldBeNil)
+					So(len(n.groups), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When I get the runtime of the owner", func() {
+
+			Convey("Then I should get a copy until the owner is removed", func() {
+				So(n.ownerRuntime("owner"), ShouldNotEqual, runtime)
+				So(n.ownerRuntime("owner").Tags().Tags, ShouldResemble, runtime.Tags().Tags)
+				So(n.ownerRuntime("member"), ShouldBeNil)
+				n.removeOwner("owner")
+				So(n.ownerRuntime("owner"), ShouldBeNil)
+			})
+		})
+
+		Convey("When I get the started containers", func() {
+
+			n.join("owner", "member", &policy.TagStore{})
+
+			Convey("Then I should get the owner with its pid and the member", func() {
+				So(n.started(), ShouldResemble, map[string]int{"owner": 1, "member": 0})
+				n.removeOwner("owner")
+				So(n.started(), ShouldResemble, map[string]int{"member": 0})
+			})
+		})
+
+		Convey("When a container that did not join leaves", func() {
+
+			_, _, ok := n.leave("other")
+
+			Convey("Then it should not be a member", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given a tracker without the owner of a namespace", t, func() {
+
+		n := newNetnsTracker()
+
+		Convey("When a container joins before the owner is started", func() {
+
+			updated := n.join("owner", "member", &policy.TagStore{Tags: []string{"@usr:app=nginx"}})
+			runtime := netnsTestRuntime("@usr:app=pause")
+			n.addOwner("owner", runtime)
+
+			Convey("Then the owner should start with the tags of the member", func() {
+				So(updated, ShouldBeNil)
+				So(runtime.Tags().Tags, ShouldResemble, []string{"@usr:app=pause", "@usr:app=nginx"})
+			})
+		})
+	})
+}
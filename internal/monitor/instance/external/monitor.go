@@ -0,0 +1,405 @@
+package externalmonitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/rpc/registerer"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+	"github.com/aporeto-inc/trireme-lib/rpc/external"
+	"github.com/aporeto-inc/trireme-lib/rpc/processor"
+)
+
+const (
+	maxContextIDLength = 64
+	maxNameLength      = 64
+)
+
+// A MetadataExtractor is a function used to extract a *policy.PURuntime from
+// the request of an external process
+type MetadataExtractor func(request *external.PURequest) (*policy.PURuntime, error)
+
+// DefaultMetadataExtractor is the default metadata extractor for the external
+// monitor. The tags of the request become user tags.
+func DefaultMetadataExtractor(request *external.PURequest) (*policy.PURuntime, error) {
+
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue("@sys:name", request.Name)
+
+	for _, tag := range request.Tags {
+		kv := strings.SplitN(tag, "=", 2)
+		tags.AppendKeyValue("@usr:"+kv[0], kv[1])
+	}
+
+	return policy.NewPURuntime(request.Name, int(request.Pid), request.Ns, tags, request.Ips, constants.ContainerPU, nil), nil
+}
+
+// Config is the configuration options to start an external monitor
+type Config struct {
+	// Address is the Unix socket of the gRPC API. It is only accessible to
+	// root.
+	Address           string
+	MetadataExtractor MetadataExtractor
+}
+
+// DefaultConfig provides a default configuration
+func DefaultConfig() *Config {
+
+	return &Config{
+		Address:           external.DefaultAddress,
+		MetadataExtractor: DefaultMetadataExtractor,
+	}
+}
+
+// SetupDefaultConfig adds defaults to a partial configuration
+func SetupDefaultConfig(externalConfig *Config) *Config {
+
+	defaultConfig := DefaultConfig()
+
+	if externalConfig.Address == "" {
+		externalConfig.Address = defaultConfig.Address
+	}
+	if externalConfig.MetadataExtractor == nil {
+		externalConfig.MetadataExtractor = defaultConfig.MetadataExtractor
+	}
+
+	return externalConfig
+}
+
+// externalMonitor generates the lifecycle events of the PUs of an external
+// process, like the agent of a scheduler, that drives them with the requests
+// of the external gRPC API. The PUs are container PUs.
+type externalMonitor struct {
+	address           string
+	metadataExtractor MetadataExtractor
+	config            *processor.Config
+	server            *grpc.Server
+
+	// pus holds the state of the registered PUs
+	pus  map[string]events.State
+	lock sync.Mutex
+}
+
+// New returns a new external monitor
+func New() monitorinstance.Implementation {
+
+	return &externalMonitor{
+		pus: map[string]events.State{},
+	}
+}
+
+// SetupConfig provides a configuration to implmentations. Every implmentation
+// can have its own config type.
+func (e *externalMonitor) SetupConfig(registerer registerer.Registerer, cfg interface{}) error {
+
+	defaultConfig := DefaultConfig()
+	if cfg == nil {
+		cfg = defaultConfig
+	}
+
+	externalConfig, ok := cfg.(*Config)
+	if !ok {
+		return fmt.Errorf("Invalid configuration specified")
+	}
+
+	// Setup defaults
+	externalConfig = SetupDefaultConfig(externalConfig)
+
+	e.address = externalConfig.Address
+	e.metadataExtractor = externalConfig.MetadataExtractor
+
+	return nil
+}
+
+// SetupHandlers sets up handlers for monitors to invoke for various events such as
+// processing unit events and synchronization events. This will be called before Start()
+// by the consumer of the monitor
+func (e *externalMonitor) SetupHandlers(c *processor.Config) {
+
+	e.config = c
+}
+
+// Start implements Implementation interface
+func (e *externalMonitor) Start() error {
+
+	if err := e.config.IsComplete(); err != nil {
+		return fmt.Errorf("external: %s", err)
+	}
+
+	if _, err := os.Stat(e.address); err == nil {
+		if err := os.Remove(e.address); err != nil {
+			return fmt.Errorf("external: unable to clean up socket: %s", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", e.address)
+	if err != nil {
+		return fmt.Errorf("external: unable to listen: %s", err)
+	}
+
+	if err := os.Chmod(e.address, 0600); err != nil {
+		listener.Close() // nolint
+		return fmt.Errorf("external: %s", err)
+	}
+
+	e.server = grpc.NewServer()
+	external.RegisterExternalMonitorServer(e.server, &ExternalMonitor{monitor: e})
+
+	go func(server *grpc.Server) {
+		if err := server.Serve(listener); err != nil {
+			zap.L().Error("External monitor stopped serving", zap.Error(err))
+		}
+	}(e.server)
+
+	return nil
+}
+
+// Stop implements Implementation interface
+func (e *externalMonitor) Stop() error {
+
+	zap.L().Debug("Stopping the external monitor")
+
+	if e.server == nil {
+		return nil
+	}
+
+	e.server.Stop()
+	e.server = nil
+
+	if err := os.RemoveAll(e.address); err != nil {
+		zap.L().Warn("Failed to clean up external monitor socket", zap.Error(err))
+	}
+
+	return nil
+}
+
+// ReSync implements Implementation interface. The PUs are driven by the
+// external process, which must register them again when Trireme restarts.
+func (e *externalMonitor) ReSync() error {
+
+	return nil
+}
+
+// register creates a PU, or updates the metadata of an existing PU
+func (e *externalMonitor) register(request *external.PURequest) error {
+
+	if err := validateRegister(request); err != nil {
+		return err
+	}
+
+	r := *request
+	if r.Name == "" {
+		r.Name = r.ContextId
+	}
+
+	runtimeInfo, err := e.metadataExtractor(&r)
+	if err != nil {
+		return fmt.Errorf("unable to extract metadata: %s", err)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	state, ok := e.pus[r.ContextId]
+	if !ok {
+		if err := e.config.PUHandler.CreatePURuntime(r.ContextId, runtimeInfo); err != nil {
+			return fmt.Errorf("unable to create pu %s: %s", r.ContextId, err)
+		}
+		e.pus[r.ContextId] = events.StateStopped
+		return nil
+	}
+
+	if err := e.config.PUHandler.UpdatePURuntime(r.ContextId, runtimeInfo); err != nil {
+		return fmt.Errorf("unable to update pu %s: %s", r.ContextId, err)
+	}
+
+	if state != events.StateStarted {
+		return nil
+	}
+
+	if err := e.config.PUHandler.HandlePUEvent(r.ContextId, events.EventUpdate); err != nil {
+		return fmt.Errorf("unable to update policy of pu %s: %s", r.ContextId, err)
+	}
+
+	return nil
+}
+
+// start activates the policy of a registered PU
+func (e *externalMonitor) start(contextID string) error {
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	state, ok := e.pus[contextID]
+	if !ok {
+		return fmt.Errorf("pu %s is not registered", contextID)
+	}
+
+	if state == events.StateStarted {
+		return nil
+	}
+
+	if err := e.config.PUHandler.HandlePUEvent(contextID, events.EventStart); err != nil {
+		return fmt.Errorf("unable to set policy of pu %s: %s", contextID, err)
+	}
+
+	e.pus[contextID] = events.StateStarted
+
+	return nil
+}
+
+// stop removes the policy of a started PU
+func (e *externalMonitor) stop(contextID string) error {
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	state, ok := e.pus[contextID]
+	if !ok {
+		return fmt.Errorf("pu %s is not registered", contextID)
+	}
+
+	if state != events.StateStarted {
+		return nil
+	}
+
+	if err := e.config.PUHandler.HandlePUEvent(contextID, events.EventStop); err != nil {
+		return fmt.Errorf("unable to stop pu %s: %s", contextID, err)
+	}
+
+	e.pus[contextID] = events.StateStopped
+
+	return nil
+}
+
+// destroy removes a PU, and stops it first if it is started
+func (e *externalMonitor) destroy(contextID string) error {
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	state, ok := e.pus[contextID]
+	if !ok {
+		return fmt.Errorf("pu %s is not registered", contextID)
+	}
+
+	delete(e.pus, contextID)
+
+	if state == events.StateStarted {
+		if err := e.config.PUHandler.HandlePUEvent(contextID, events.EventStop); err != nil {
+			zap.L().Warn("Unable to stop pu", zap.String("contextID", contextID), zap.Error(err))
+		}
+	}
+
+	if err := e.config.PUHandler.HandlePUEvent(contextID, events.EventDestroy); err != nil {
+		return fmt.Errorf("unable to destroy pu %s: %s", contextID, err)
+	}
+
+	return nil
+}
+
+// validateRequest validates the version and the context ID of a request
+func validateRequest(request *external.PURequest) error {
+
+	if request.Version < external.MinAPIVersion || request.Version > external.APIVersion {
+		return fmt.Errorf("Unsupported API version %d - Must be between %d and %d", request.Version, external.MinAPIVersion, external.APIVersion)
+	}
+
+	if request.ContextId == "" || len(request.ContextId) > maxContextIDLength {
+		return fmt.Errorf("Invalid context ID - Must not be empty or greater than %d characters", maxContextIDLength)
+	}
+
+	return nil
+}
+
+// validateRegister validates the metadata of a register request
+func validateRegister(request *external.PURequest) error {
+
+	if len(request.Name) > maxNameLength {
+		return fmt.Errorf("Invalid name - Must not be greater than %d characters", maxNameLength)
+	}
+
+	if request.Pid <= 0 {
+		return fmt.Errorf("Invalid PID - Must be a positive number")
+	}
+
+	if request.Ns != "" && !filepath.IsAbs(request.Ns) {
+		return fmt.Errorf("Invalid network namespace path - Must be an absolute path")
+	}
+
+	for _, tag := range request.Tags {
+		if !strings.Contains(tag, "=") {
+			return fmt.Errorf("Invalid tag %s - Must be of the form key=value", tag)
+		}
+	}
+
+	return nil
+}
+
+// ExternalMonitor is the gRPC server of the external API
+type ExternalMonitor struct {
+	monitor *externalMonitor
+}
+
+// Register creates a PU with its metadata, or updates the metadata of an
+// existing PU
+func (s *ExternalMonitor) Register(ctx context.Context, request *external.PURequest) (*external.PUResponse, error) {
+
+	return s.handle(request, s.monitor.register), nil
+}
+
+// Start activates the policy of a registered PU
+func (s *ExternalMonitor) Start(ctx context.Context, request *external.PURequest) (*external.PUResponse, error) {
+
+	return s.handle(request, func(r *external.PURequest) error {
+		return s.monitor.start(r.ContextId)
+	}), nil
+}
+
+// Stop removes the policy of a PU
+func (s *ExternalMonitor) Stop(ctx context.Context, request *external.PURequest) (*external.PUResponse, error) {
+
+	return s.handle(request, func(r *external.PURequest) error {
+		return s.monitor.stop(r.ContextId)
+	}), nil
+}
+
+// Destroy removes a PU
+func (s *ExternalMonitor) Destroy(ctx context.Context, request *external.PURequest) (*external.PUResponse, error) {
+
+	return s.handle(request, func(r *external.PURequest) error {
+		return s.monitor.destroy(r.ContextId)
+	}), nil
+}
+
+// handle validates a request and processes it. The errors are returned in the
+// response instead of a gRPC status, so that the response with the version of
+// the monitor is always sent.
+func (s *ExternalMonitor) handle(request *external.PURequest, f func(*external.PURequest) error) *external.PUResponse {
+
+	response := &external.PUResponse{
+		Version: external.APIVersion,
+	}
+
+	if err := validateRequest(request); err != nil {
+		response.Error = err.Error()
+		return response
+	}
+
+	if err := f(request); err != nil {
+		response.Error = err.Error()
+	}
+
+	return response
+}
@@ -0,0 +1,159 @@
+package externalmonitor
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+	"github.com/aporeto-inc/trireme-lib/rpc/external"
+	"github.com/aporeto-inc/trireme-lib/rpc/processor"
+)
+
+type testPUHandler struct {
+	events   []string
+	runtimes map[string]*policy.PURuntime
+	sync.Mutex
+}
+
+func (h *testPUHandler) CreatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, "create:"+contextID)
+	h.runtimes[contextID] = runtimeInfo
+	return nil
+}
+
+func (h *testPUHandler) UpdatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, "update:"+contextID)
+	h.runtimes[contextID] = runtimeInfo
+	return nil
+}
+
+func (h *testPUHandler) HandlePUEvent(contextID string, event events.Event) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, string(event)+":"+contextID)
+	return nil
+}
+
+func TestExternalMonitor(t *testing.T) {
+
+	Convey("Given a started external monitor and a client", t, func() {
+
+		dir, err := ioutil.TempDir("", "external")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		address := filepath.Join(dir, "external.sock")
+
+		h := &testPUHandler{runtimes: map[string]*policy.PURuntime{}}
+		m := New()
+		m.SetupHandlers(&processor.Config{
+			Collector: collector.NewDefaultCollector(),
+			PUHandler: h,
+		})
+		So(m.SetupConfig(nil, &Config{Address: address}), ShouldBeNil)
+		So(m.Start(), ShouldBeNil)
+		defer m.Stop() // nolint
+
+		c, err := external.NewClient(address)
+		So(err, ShouldBeNil)
+		defer c.Close() // nolint
+
+		ctx := context.Background()
+		request := &external.PURequest{
+			ContextId: "task1",
+			Pid:       100,
+			Tags:      []string{"job=web"},
+			Ips:       map[string]string{"bridge": "172.17.0.2"},
+		}
+
+		Convey("When I register and start a PU", func() {
+
+			So(c.Register(ctx, request), ShouldBeNil)
+			So(c.Start(ctx, "task1"), ShouldBeNil)
+
+			Convey("Then the PU should be created with its metadata and started", func() {
+				So(h.events, ShouldResemble, []string{"create:task1", "start:task1"})
+				runtime := h.runtimes["task1"]
+				So(runtime.Name(), ShouldEqual, "task1")
+				So(runtime.Pid(), ShouldEqual, 100)
+				So(runtime.Tags().GetSlice(), ShouldResemble, []string{"@sys:name=task1", "@usr:job=web"})
+				So(runtime.IPAddresses(), ShouldResemble, policy.ExtendedMap{"bridge": "172.17.0.2"})
+			})
+
+			Convey("Then registering it again should update its policy", func() {
+				request.Tags = []string{"job=api"}
+				So(c.Register(ctx, request), ShouldBeNil)
+				So(h.events, ShouldResemble, []string{"create:task1", "start:task1", "update:task1", "update:task1"})
+			})
+
+			Convey("Then destroying it should stop it first", func() {
+				So(c.Destroy(ctx, "task1"), ShouldBeNil)
+				So(h.events, ShouldResemble, []string{"create:task1", "start:task1", "stop:task1", "destroy:task1"})
+				So(c.Start(ctx, "task1"), ShouldNotBeNil)
+			})
+
+			Convey("Then stopping it twice should stop it once", func() {
+				So(c.Stop(ctx, "task1"), ShouldBeNil)
+				So(c.Stop(ctx, "task1"), ShouldBeNil)
+				So(h.events, ShouldResemble, []string{"create:task1", "start:task1", "stop:task1"})
+			})
+		})
+
+		Convey("When I start a PU that is not registered", func() {
+
+			err := c.Start(ctx, "task2")
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+				So(h.events, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I register a PU with invalid metadata", func() {
+
+			request.Tags = []string{"job"}
+
+			Convey("Then I should get an error", func() {
+				So(c.Register(ctx, request), ShouldNotBeNil)
+				request.Tags = nil
+				request.Pid = 0
+				So(c.Register(ctx, request), ShouldNotBeNil)
+				So(h.events, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I send a request of an unsupported version", func() {
+
+			conn, err := grpc.Dial(address, grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+				return net.DialTimeout("unix", addr, timeout)
+			}))
+			So(err, ShouldBeNil)
+			defer conn.Close() // nolint
+
+			request.Version = external.APIVersion + 1
+			response, err := external.NewExternalMonitorClient(conn).Register(ctx, request)
+
+			Convey("Then I should get the version of the monitor and an error", func() {
+				So(err, ShouldBeNil)
+				So(response.Version, ShouldEqual, external.APIVersion)
+				So(response.Error, ShouldNotBeEmpty)
+				So(h.events, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -0,0 +1,349 @@
+package kubernetesmonitor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"go.uber.org/zap"
+)
+
+const (
+	serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// Watch event types of the API server
+	watchAdded    = "ADDED"
+	watchModified = "MODIFIED"
+	watchDeleted  = "DELETED"
+	watchError    = "ERROR"
+)
+
+// kubeconfig is the subset of a kubeconfig file used by the monitor
+type kubeconfig struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData []byte `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token                 string `json:"token"`
+			TokenFile             string `json:"tokenFile"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData []byte `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         []byte `json:"client-key-data"`
+			// Exec and AuthProvider are only detected, the credentials of
+			// plugins are not supported
+			Exec         interface{} `json:"exec"`
+			AuthProvider interface{} `json:"auth-provider"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+// watchEvent is an event of a watch of the pods
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// apiClient lists and watches the pods of the API server
+type apiClient struct {
+	server string
+	client *http.Client
+	// tokenFile is read again for every request when it is set, as the
+	// projected service account tokens are rotated. The token is the last
+	// token that was read.
+	tokenFile string
+	token     string
+	sync.Mutex
+}
+
+// newAPIClient creates a client from a kubeconfig file in YAML or JSON format,
+// or from the service account of the pod if the path is empty. The users of
+// the kubeconfig are authenticated with a token, a token file or a client
+// certificate: the exec and auth-provider plugins are not supported.
+func newAPIClient(kubeconfigPath string) (*apiClient, error) {
+
+	if kubeconfigPath == "" {
+		return inClusterClient()
+	}
+
+	data, err := ioutil.ReadFile(kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read kubeconfig: %s", err)
+	}
+
+	cfg := &kubeconfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %s", err)
+	}
+
+	return kubeconfigClient(cfg)
+}
+
+// inClusterClient creates a client with the service account of the pod
+func inClusterClient() (*apiClient, error) {
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster and no kubeconfig provided")
+	}
+
+	c := &apiClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		tokenFile: serviceAccountPath + "/token",
+	}
+
+	if err := c.readToken(); err != nil {
+		return nil, fmt.Errorf("unable to read service account token: %s", err)
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountPath + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account ca: %s", err)
+	}
+
+	tlsConfig := &tls.Config{RootCAs: x509.NewCertPool()}
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account ca")
+	}
+
+	c.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	return c, nil
+}
+
+// kubeconfigClient creates a client with the cluster and the user of the
+// current context of a kubeconfig.
+func kubeconfigClient(cfg *kubeconfig) (*apiClient, error) {
+
+	var clusterName, userName string
+	for _, c := range cfg.Contexts {
+		if c.Name == cfg.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+
+	if clusterName == "" {
+		return nil, fmt.Errorf("context %s not found in kubeconfig", cfg.CurrentContext)
+	}
+
+	c := &apiClient{}
+	tlsConfig := &tls.Config{}
+
+	for _, cluster := range cfg.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+
+		c.server = strings.TrimSuffix(cluster.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify // nolint
+
+		ca := cluster.Cluster.CertificateAuthorityData
+		if cluster.Cluster.CertificateAuthority != "" {
+			data, err := ioutil.ReadFile(cluster.Cluster.CertificateAuthority)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read certificate authority: %s", err)
+			}
+			ca = data
+		}
+
+		if len(ca) > 0 {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, errors.New("invalid certificate authority")
+			}
+		}
+	}
+
+	if c.server == "" {
+		return nil, fmt.Errorf("cluster %s not found in kubeconfig", clusterName)
+	}
+
+	for _, user := range cfg.Users {
+		if user.Name != userName {
+			continue
+		}
+
+		c.token = user.User.Token
+		if user.User.TokenFile != "" {
+			c.tokenFile = user.User.TokenFile
+			if err := c.readToken(); err != nil {
+				return nil, fmt.Errorf("unable to read token file: %s", err)
+			}
+		}
+
+		cert, key := user.User.ClientCertificateData, user.User.ClientKeyData
+		if user.User.ClientCertificate != "" && user.User.ClientKey != "" {
+			var err error
+			if cert, err = ioutil.ReadFile(user.User.ClientCertificate); err != nil {
+				return nil, fmt.Errorf("unable to read client certificate: %s", err)
+			}
+			if key, err = ioutil.ReadFile(user.User.ClientKey); err != nil {
+				return nil, fmt.Errorf("unable to read client key: %s", err)
+			}
+		}
+
+		if len(cert) > 0 && len(key) > 0 {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate: %s", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+
+		if c.token == "" && len(tlsConfig.Certificates) == 0 && (user.User.Exec != nil || user.User.AuthProvider != nil) {
+			return nil, fmt.Errorf("user %s uses an exec or auth-provider plugin, which is not supported", userName)
+		}
+	}
+
+	c.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	return c, nil
+}
+
+// readToken reads the token file
+func (c *apiClient) readToken() error {
+
+	token, err := ioutil.ReadFile(c.tokenFile)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	c.token = strings.TrimSpace(string(token))
+	c.Unlock()
+
+	return nil
+}
+
+// bearerToken returns the token of the requests. The token file is read again
+// if it is set, and the last token is kept if it can not be read.
+func (c *apiClient) bearerToken() string {
+
+	if c.tokenFile != "" {
+		if err := c.readToken(); err != nil {
+			zap.L().Warn("Unable to read the token file of the api server", zap.String("file", c.tokenFile), zap.Error(err))
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.token
+}
+
+// podsURL returns the url of the pods of a node matching a label selector
+func (c *apiClient) podsURL(nodename, labelSelector string, watch bool, resourceVersion string) string {
+
+	query := url.Values{}
+	if nodename != "" {
+		query.Set("fieldSelector", "spec.nodeName="+nodename)
+	}
+	if labelSelector != "" {
+		query.Set("labelSelector", labelSelector)
+	}
+	if watch {
+		query.Set("watch", "true")
+		query.Set("resourceVersion", resourceVersion)
+	}
+
+	return c.server + "/api/v1/pods?" + query.Encode()
+}
+
+func (c *apiClient) get(ctx context.Context, u string) (*http.Response, error) {
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if token := c.bearerToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close() // nolint
+		return nil, fmt.Errorf("unexpected status from api server: %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// listPods lists the pods of a node
+func (c *apiClient) listPods(ctx context.Context, nodename, labelSelector string) (*PodList, error) {
+
+	resp, err := c.get(ctx, c.podsURL(nodename, labelSelector, false, ""))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pods: %s", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	pods := &PodList{}
+	if err := json.NewDecoder(resp.Body).Decode(pods); err != nil {
+		return nil, fmt.Errorf("unable to decode pods: %s", err)
+	}
+
+	return pods, nil
+}
+
+// watchPods calls the handler for every change of the pods of a node since
+// the given resource version. It returns when the watch ends.
+func (c *apiClient) watchPods(ctx context.Context, nodename, labelSelector, resourceVersion string, handler func(eventType string, pod *Pod)) error {
+
+	resp, err := c.get(ctx, c.podsURL(nodename, labelSelector, true, resourceVersion))
+	if err != nil {
+		return fmt.Errorf("unable to watch pods: %s", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		event := &watchEvent{}
+		if err := decoder.Decode(event); err != nil {
+			return fmt.Errorf("watch ended: %s", err)
+		}
+
+		if event.Type == watchError {
+			return fmt.Errorf("watch failed: %s", string(event.Object))
+		}
+
+		pod := &Pod{}
+		if err := json.Unmarshal(event.Object, pod); err != nil {
+			return fmt.Errorf("unable to decode pod: %s", err)
+		}
+
+		handler(event.Type, pod)
+	}
+}
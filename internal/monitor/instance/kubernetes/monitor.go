@@ -0,0 +1,382 @@
+package kubernetesmonitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/rpc/registerer"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+	"github.com/aporeto-inc/trireme-lib/rpc/processor"
+)
+
+const (
+	// watchRetryTimer is the time after which a failed watch is restarted
+	watchRetryTimer = 5 * time.Second
+)
+
+// Config is the configuration options to start a kubernetes monitor
+type Config struct {
+	// Kubeconfig is the path of a kubeconfig file in YAML or JSON format. The
+	// service account of the pod is used if it is empty.
+	Kubeconfig           string
+	Nodename             string
+	LabelSelector        string
+	ProcMountPoint       string
+	PodMetadataExtractor PodMetadataExtractor
+}
+
+// DefaultConfig provides a default configuration
+func DefaultConfig() *Config {
+
+	nodename, err := os.Hostname()
+	if err != nil {
+		zap.L().Warn("Unable to get the hostname", zap.Error(err))
+	}
+
+	return &Config{
+		Nodename:             nodename,
+		ProcMountPoint:       "/proc",
+		PodMetadataExtractor: DefaultPodMetadataExtractor,
+	}
+}
+
+// SetupDefaultConfig adds defaults to a partial configuration
+func SetupDefaultConfig(kubernetesConfig *Config) *Config {
+
+	defaultConfig := DefaultConfig()
+
+	if kubernetesConfig.Nodename == "" {
+		kubernetesConfig.Nodename = defaultConfig.Nodename
+	}
+	if kubernetesConfig.ProcMountPoint == "" {
+		kubernetesConfig.ProcMountPoint = defaultConfig.ProcMountPoint
+	}
+	if kubernetesConfig.PodMetadataExtractor == nil {
+		kubernetesConfig.PodMetadataExtractor = defaultConfig.PodMetadataExtractor
+	}
+
+	return kubernetesConfig
+}
+
+// kubernetesMonitor watches the pods of the node on the API server and
+// generates the lifecycle events of their PUs. The pod uid is the context id.
+type kubernetesMonitor struct {
+	client            *apiClient
+	nodename          string
+	labelSelector     string
+	procMountPoint    string
+	metadataExtractor PodMetadataExtractor
+	config            *processor.Config
+
+	// pods holds the tags of the pods with an active PU
+	pods            map[string]*policy.TagStore
+	resourceVersion string
+	podLock         sync.Mutex
+
+	cancel context.CancelFunc
+}
+
+// New returns a new kubernetes monitor
+func New() monitorinstance.Implementation {
+
+	return &kubernetesMonitor{
+		pods: map[string]*policy.TagStore{},
+	}
+}
+
+// SetupConfig provides a configuration to implmentations. Every implmentation
+// can have its own config type.
+func (k *kubernetesMonitor) SetupConfig(registerer registerer.Registerer, cfg interface{}) (err error) {
+
+	defaultConfig := DefaultConfig()
+	if cfg == nil {
+		cfg = defaultConfig
+	}
+
+	kubernetesConfig, ok := cfg.(*Config)
+	if !ok {
+		return fmt.Errorf("Invalid configuration specified")
+	}
+
+	// Setup defaults
+	kubernetesConfig = SetupDefaultConfig(kubernetesConfig)
+
+	if kubernetesConfig.Nodename == "" {
+		return fmt.Errorf("Unable to determine the node name")
+	}
+
+	if k.client, err = newAPIClient(kubernetesConfig.Kubeconfig); err != nil {
+		return err
+	}
+
+	k.nodename = kubernetesConfig.Nodename
+	k.labelSelector = kubernetesConfig.LabelSelector
+	k.procMountPoint = kubernetesConfig.ProcMountPoint
+	k.metadataExtractor = kubernetesConfig.PodMetadataExtractor
+
+	return nil
+}
+
+// SetupHandlers sets up handlers for monitors to invoke for various events such as
+// processing unit events and synchronization events. This will be called before Start()
+// by the consumer of the monitor
+func (k *kubernetesMonitor) SetupHandlers(c *processor.Config) {
+
+	k.config = c
+}
+
+// Start implements Implementation interface
+func (k *kubernetesMonitor) Start() error {
+
+	if err := k.config.IsComplete(); err != nil {
+		return fmt.Errorf("kubernetes: %s", err)
+	}
+
+	if err := k.ReSync(); err != nil {
+		return fmt.Errorf("kubernetes: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	k.cancel = cancel
+
+	go k.watch(ctx)
+
+	return nil
+}
+
+// Stop implements Implementation interface
+func (k *kubernetesMonitor) Stop() error {
+
+	zap.L().Debug("Stopping the kubernetes monitor")
+
+	if k.cancel != nil {
+		k.cancel()
+	}
+
+	return nil
+}
+
+// ReSync lists the pods of the node and brings the PUs in line with them
+func (k *kubernetesMonitor) ReSync() error {
+
+	pods, err := k.client.listPods(context.Background(), k.nodename, k.labelSelector)
+	if err != nil {
+		return err
+	}
+
+	active := map[string]bool{}
+	for i := range pods.Items {
+		active[pods.Items[i].Metadata.UID] = true
+		if err := k.handlePod(watchModified, &pods.Items[i]); err != nil {
+			zap.L().Error("Unable to process pod",
+				zap.String("pod", pods.Items[i].Metadata.Name),
+				zap.String("namespace", pods.Items[i].Metadata.Namespace),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// Pods deleted while we were not watching
+	for _, contextID := range k.activePods() {
+		if active[contextID] {
+			continue
+		}
+		if err := k.stopPod(contextID); err != nil {
+			zap.L().Error("Unable to stop pod", zap.String("contextID", contextID), zap.Error(err))
+		}
+	}
+
+	k.setResourceVersion(pods.Metadata.ResourceVersion)
+
+	return nil
+}
+
+// watch watches the pods until the context is cancelled. The pods are listed
+// again after a failure, since the watch may have missed events.
+func (k *kubernetesMonitor) watch(ctx context.Context) {
+
+	for {
+		err := k.client.watchPods(ctx, k.nodename, k.labelSelector, k.getResourceVersion(), func(eventType string, pod *Pod) {
+			k.setResourceVersion(pod.Metadata.ResourceVersion)
+			if err := k.handlePod(eventType, pod); err != nil {
+				zap.L().Error("Unable to process pod event",
+					zap.String("event", eventType),
+					zap.String("pod", pod.Metadata.Name),
+					zap.String("namespace", pod.Metadata.Namespace),
+					zap.Error(err),
+				)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		zap.L().Warn("Kubernetes watch ended, restarting", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryTimer):
+		}
+
+		if err := k.ReSync(); err != nil {
+			zap.L().Error("Unable to resync pods", zap.Error(err))
+		}
+	}
+}
+
+// handlePod generates the PU events of a change of a pod
+func (k *kubernetesMonitor) handlePod(eventType string, pod *Pod) error {
+
+	if pod.Spec.HostNetwork {
+		return nil
+	}
+
+	contextID := pod.Metadata.UID
+
+	switch {
+	case eventType == watchDeleted, pod.Status.Phase == PodSucceeded, pod.Status.Phase == PodFailed:
+		if !k.isActive(contextID) {
+			return nil
+		}
+		return k.stopPod(contextID)
+
+	case pod.Status.Phase != PodRunning || pod.Status.PodIP == "":
+		return nil
+	}
+
+	pid, err := podPid(k.procMountPoint, contextID)
+	if err != nil {
+		return err
+	}
+
+	runtimeInfo, err := k.metadataExtractor(pod, pid)
+	if err != nil {
+		return fmt.Errorf("unable to extract metadata: %s", err)
+	}
+
+	k.podLock.Lock()
+	tags, active := k.pods[contextID]
+	k.podLock.Unlock()
+
+	if !active {
+		return k.startPod(contextID, runtimeInfo)
+	}
+
+	if sameTags(tags, runtimeInfo.Tags()) {
+		return nil
+	}
+
+	if err := k.config.PUHandler.UpdatePURuntime(contextID, runtimeInfo); err != nil {
+		return fmt.Errorf("unable to update pod %s: %s", contextID, err)
+	}
+
+	if err := k.config.PUHandler.HandlePUEvent(contextID, events.EventUpdate); err != nil {
+		return fmt.Errorf("unable to update policy of pod %s: %s", contextID, err)
+	}
+
+	k.setActive(contextID, runtimeInfo.Tags())
+
+	return nil
+}
+
+func (k *kubernetesMonitor) startPod(contextID string, runtimeInfo *policy.PURuntime) error {
+
+	if err := k.config.PUHandler.CreatePURuntime(contextID, runtimeInfo); err != nil {
+		return fmt.Errorf("unable to create pod %s: %s", contextID, err)
+	}
+
+	if err := k.config.PUHandler.HandlePUEvent(contextID, events.EventStart); err != nil {
+		return fmt.Errorf("unable to set policy of pod %s: %s", contextID, err)
+	}
+
+	k.setActive(contextID, runtimeInfo.Tags())
+
+	return nil
+}
+
+func (k *kubernetesMonitor) stopPod(contextID string) error {
+
+	k.podLock.Lock()
+	delete(k.pods, contextID)
+	k.podLock.Unlock()
+
+	if err := k.config.PUHandler.HandlePUEvent(contextID, events.EventStop); err != nil {
+		return fmt.Errorf("unable to stop pod %s: %s", contextID, err)
+	}
+
+	return k.config.PUHandler.HandlePUEvent(contextID, events.EventDestroy)
+}
+
+func (k *kubernetesMonitor) setActive(contextID string, tags *policy.TagStore) {
+
+	k.podLock.Lock()
+	defer k.podLock.Unlock()
+
+	k.pods[contextID] = tags
+}
+
+func (k *kubernetesMonitor) isActive(contextID string) bool {
+
+	k.podLock.Lock()
+	defer k.podLock.Unlock()
+
+	_, ok := k.pods[contextID]
+	return ok
+}
+
+func (k *kubernetesMonitor) activePods() []string {
+
+	k.podLock.Lock()
+	defer k.podLock.Unlock()
+
+	contextIDs := make([]string, 0, len(k.pods))
+	for contextID := range k.pods {
+		contextIDs = append(contextIDs, contextID)
+	}
+
+	return contextIDs
+}
+
+func (k *kubernetesMonitor) setResourceVersion(resourceVersion string) {
+
+	k.podLock.Lock()
+	defer k.podLock.Unlock()
+
+	k.resourceVersion = resourceVersion
+}
+
+func (k *kubernetesMonitor) getResourceVersion() string {
+
+	k.podLock.Lock()
+	defer k.podLock.Unlock()
+
+	return k.resourceVersion
+}
+
+// sameTags compares two tag stores regardless of the order of the tags
+func sameTags(a, b *policy.TagStore) bool {
+
+	x, y := a.GetSlice(), b.GetSlice()
+	if len(x) != len(y) {
+		return false
+	}
+
+	x, y = append([]string{}, x...), append([]string{}, y...)
+	sort.Strings(x)
+	sort.Strings(y)
+
+	return strings.Join(x, "\n") == strings.Join(y, "\n")
+}
@@ -0,0 +1,349 @@
+package kubernetesmonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+	"github.com/aporeto-inc/trireme-lib/rpc/processor"
+)
+
+type testPUHandler struct {
+	events   []string
+	runtimes map[string]*policy.PURuntime
+	sync.Mutex
+}
+
+func (h *testPUHandler) CreatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, "create:"+contextID)
+	h.runtimes[contextID] = runtimeInfo
+	return nil
+}
+
+func (h *testPUHandler) UpdatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, "update:"+contextID)
+	h.runtimes[contextID] = runtimeInfo
+	return nil
+}
+
+func (h *testPUHandler) HandlePUEvent(contextID string, event events.Event) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events = append(h.events, string(event)+":"+contextID)
+	return nil
+}
+
+func testPod(uid, phase string, labels map[string]string) Pod {
+	return Pod{
+		Metadata: ObjectMeta{
+			Name:            "pod-" + uid,
+			Namespace:       "default",
+			UID:             uid,
+			ResourceVersion: "1",
+			Labels:          labels,
+		},
+		Spec:   PodSpec{NodeName: "node1"},
+		Status: PodStatus{Phase: phase, PodIP: "10.1.1.1"},
+	}
+}
+
+// testProc creates a proc directory with one process per pod uid
+func testProc(uids ...string) string {
+	dir, err := ioutil.TempDir("", "proc")
+	So(err, ShouldBeNil)
+
+	for i, uid := range uids {
+		pid := filepath.Join(dir, fmt.Sprintf("%d", 100+i))
+		So(os.Mkdir(pid, 0700), ShouldBeNil)
+		cgroup := "4:net_cls:/kubepods/besteffort/pod" + uid + "/abcdef\n"
+		So(ioutil.WriteFile(filepath.Join(pid, "cgroup"), []byte(cgroup), 0600), ShouldBeNil)
+	}
+
+	return dir
+}
+
+func testMonitor(server *httptest.Server, proc string) (*kubernetesMonitor, *testPUHandler) {
+
+	h := &testPUHandler{runtimes: map[string]*policy.PURuntime{}}
+
+	m := New().(*kubernetesMonitor)
+	m.SetupHandlers(&processor.Config{
+		Collector: &collector.DefaultCollector{},
+		PUHandler: h,
+	})
+	m.client = &apiClient{server: server.URL, client: http.DefaultClient}
+	m.nodename = "node1"
+	m.procMountPoint = proc
+	m.metadataExtractor = DefaultPodMetadataExtractor
+
+	return m, h
+}
+
+func TestDefaultPodMetadataExtractor(t *testing.T) {
+
+	Convey("Given a pod with labels", t, func() {
+		pod := testPod("uid1", PodRunning, map[string]string{"app": "web"})
+
+		Convey("Then the runtime should have the tags and the ip of the pod", func() {
+			runtime, err := DefaultPodMetadataExtractor(&pod, 100)
+			So(err, ShouldBeNil)
+			So(runtime.Pid(), ShouldEqual, 100)
+			So(runtime.PUType(), ShouldEqual, constants.KubernetesPU)
+
+			tags := runtime.Tags()
+			name, _ := tags.Get("@sys:name")
+			So(name, ShouldEqual, "pod-uid1")
+			namespace, _ := tags.Get("@sys:namespace")
+			So(namespace, ShouldEqual, "default")
+			app, _ := tags.Get("@usr:app")
+			So(app, ShouldEqual, "web")
+
+			So(runtime.IPAddresses()["bridge"], ShouldEqual, "10.1.1.1")
+		})
+	})
+}
+
+func TestPodPid(t *testing.T) {
+
+	Convey("Given a proc directory with pod processes", t, func() {
+		proc := testProc("1234-5678", "abcd_ef01")
+		defer os.RemoveAll(proc) // nolint
+
+		Convey("Then I should find the process of a pod", func() {
+			pid, err := podPid(proc, "1234-5678")
+			So(err, ShouldBeNil)
+			So(pid, ShouldEqual, 100)
+		})
+
+		Convey("Then I should find the process of a pod with the systemd driver", func() {
+			pid, err := podPid(proc, "abcd-ef01")
+			So(err, ShouldBeNil)
+			So(pid, ShouldEqual, 101)
+		})
+
+		Convey("Then I should get an error for an unknown pod", func() {
+			_, err := podPid(proc, "9999")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestNewAPIClient(t *testing.T) {
+
+	Convey("Given a kubeconfig file", t, func() {
+		file, err := ioutil.TempFile("", "kubeconfig")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name()) // nolint
+
+		Convey("When the current context exists, I should get a client for its cluster and user", func() {
+			_, err := file.WriteString(`{
+				"current-context": "ctx",
+				"clusters": [{"name": "c1", "cluster": {"server": "https://10.0.0.1:6443/"}}],
+				"contexts": [{"name": "ctx", "context": {"cluster": "c1", "user": "u1"}}],
+				"users": [{"name": "u1", "user": {"token": "secret"}}]
+			}`)
+			So(err, ShouldBeNil)
+
+			c, err := newAPIClient(file.Name())
+			So(err, ShouldBeNil)
+			So(c.server, ShouldEqual, "https://10.0.0.1:6443")
+			So(c.token, ShouldEqual, "secret")
+		})
+
+		Convey("When the current context does not exist, I should get an error", func() {
+			_, err := file.WriteString(`{"current-context": "ctx"}`)
+			So(err, ShouldBeNil)
+
+			_, err = newAPIClient(file.Name())
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the file is in YAML format, I should get a client for its cluster and user", func() {
+			_, err := file.WriteString(`apiVersion: v1
+kind: Config
+current-context: ctx
+clusters:
+- name: c1
+  cluster:
+    server: https://10.0.0.1:6443
+contexts:
+- name: ctx
+  context:
+    cluster: c1
+    user: u1
+users:
+- name: u1
+  user:
+    token: secret
+`)
+			So(err, ShouldBeNil)
+
+			c, err := newAPIClient(file.Name())
+			So(err, ShouldBeNil)
+			So(c.server, ShouldEqual, "https://10.0.0.1:6443")
+			So(c.token, ShouldEqual, "secret")
+		})
+
+		Convey("When the user has a token file, I should read it again for every request", func() {
+			tokenFile, err := ioutil.TempFile("", "token")
+			So(err, ShouldBeNil)
+			defer os.Remove(tokenFile.Name()) // nolint
+
+			So(ioutil.WriteFile(tokenFile.Name(), []byte("first\n"), 0600), ShouldBeNil)
+
+			_, err = file.WriteString(`{
+				"current-context": "ctx",
+				"clusters": [{"name": "c1", "cluster": {"server": "https://10.0.0.1:6443/"}}],
+				"contexts": [{"name": "ctx", "context": {"cluster": "c1", "user": "u1"}}],
+				"users": [{"name": "u1", "user": {"tokenFile": "` + tokenFile.Name() + `"}}]
+			}`)
+			So(err, ShouldBeNil)
+
+			c, err := newAPIClient(file.Name())
+			So(err, ShouldBeNil)
+			So(c.bearerToken(), ShouldEqual, "first")
+
+			So(ioutil.WriteFile(tokenFile.Name(), []byte("second\n"), 0600), ShouldBeNil)
+			So(c.bearerToken(), ShouldEqual, "second")
+
+			So(os.Remove(tokenFile.Name()), ShouldBeNil)
+			So(c.bearerToken(), ShouldEqual, "second")
+		})
+
+		Convey("When the user has an exec plugin, I should get an error", func() {
+			_, err := file.WriteString(`{
+				"current-context": "ctx",
+				"clusters": [{"name": "c1", "cluster": {"server": "https://10.0.0.1:6443/"}}],
+				"contexts": [{"name": "ctx", "context": {"cluster": "c1", "user": "u1"}}],
+				"users": [{"name": "u1", "user": {"exec": {"command": "aws"}}}]
+			}`)
+			So(err, ShouldBeNil)
+
+			_, err = newAPIClient(file.Name())
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the file is invalid, I should get an error", func() {
+			_, err := file.WriteString("current-context: [ctx\n")
+			So(err, ShouldBeNil)
+
+			_, err = newAPIClient(file.Name())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReSync(t *testing.T) {
+
+	Convey("Given a kubernetes monitor and an API server", t, func() {
+		proc := testProc("uid1", "uid2")
+		defer os.RemoveAll(proc) // nolint
+
+		list := &PodList{}
+		var query string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.RawQuery
+			json.NewEncoder(w).Encode(list) // nolint
+		}))
+		defer server.Close()
+
+		m, h := testMonitor(server, proc)
+		m.labelSelector = "app=web"
+
+		Convey("When I resync with running pods, their PUs should be started", func() {
+			list.Metadata.ResourceVersion = "10"
+			list.Items = []Pod{
+				testPod("uid1", PodRunning, map[string]string{"app": "web"}),
+				testPod("uid2", "Pending", nil),
+			}
+
+			So(m.ReSync(), ShouldBeNil)
+			So(query, ShouldContainSubstring, "fieldSelector=spec.nodeName%3Dnode1")
+			So(query, ShouldContainSubstring, "labelSelector=app%3Dweb")
+			So(h.events, ShouldResemble, []string{"create:uid1", "start:uid1"})
+			So(h.runtimes["uid1"].Pid(), ShouldEqual, 100)
+			So(m.getResourceVersion(), ShouldEqual, "10")
+
+			Convey("When I resync again without changes, nothing should happen", func() {
+				So(m.ReSync(), ShouldBeNil)
+				So(h.events, ShouldHaveLength, 2)
+			})
+
+			Convey("When the labels of the pod change, the PU should be updated", func() {
+				list.Items[0].Metadata.Labels["app"] = "db"
+				So(m.ReSync(), ShouldBeNil)
+				So(h.events[2:], ShouldResemble, []string{"update:uid1", "update:uid1"})
+			})
+
+			Convey("When the pod is gone, the PU should be stopped and destroyed", func() {
+				list.Items = nil
+				So(m.ReSync(), ShouldBeNil)
+				So(h.events[2:], ShouldResemble, []string{"stop:uid1", "destroy:uid1"})
+			})
+		})
+
+		Convey("When a pod uses the host network, no PU should be created", func() {
+			pod := testPod("uid1", PodRunning, nil)
+			pod.Spec.HostNetwork = true
+			list.Items = []Pod{pod}
+
+			So(m.ReSync(), ShouldBeNil)
+			So(h.events, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestWatch(t *testing.T) {
+
+	Convey("Given a kubernetes monitor and an API server with pod events", t, func() {
+		proc := testProc("uid1")
+		defer os.RemoveAll(proc) // nolint
+
+		running := testPod("uid1", PodRunning, nil)
+		succeeded := testPod("uid1", PodSucceeded, nil)
+		succeeded.Metadata.ResourceVersion = "3"
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoder := json.NewEncoder(w)
+			for _, e := range []struct {
+				Type   string `json:"type"`
+				Object Pod    `json:"object"`
+			}{
+				{watchAdded, running},
+				{watchModified, succeeded},
+			} {
+				encoder.Encode(e) // nolint
+			}
+		}))
+		defer server.Close()
+
+		m, h := testMonitor(server, proc)
+
+		Convey("When I watch the pods, the PU should be started and then stopped", func() {
+			err := m.client.watchPods(context.Background(), m.nodename, "", "1", func(eventType string, pod *Pod) {
+				m.setResourceVersion(pod.Metadata.ResourceVersion)
+				So(m.handlePod(eventType, pod), ShouldBeNil)
+			})
+			So(err, ShouldNotBeNil)
+			So(h.events, ShouldResemble, []string{"create:uid1", "start:uid1", "stop:uid1", "destroy:uid1"})
+			So(m.getResourceVersion(), ShouldEqual, "3")
+		})
+	})
+}
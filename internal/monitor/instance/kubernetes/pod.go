@@ -0,0 +1,131 @@
+package kubernetesmonitor
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Pod phases reported by the API server
+const (
+	PodRunning   = "Running"
+	PodSucceeded = "Succeeded"
+	PodFailed    = "Failed"
+)
+
+// ObjectMeta is the metadata of a pod
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	UID             string            `json:"uid"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Labels          map[string]string `json:"labels"`
+}
+
+// PodSpec is the part of the specification of a pod used by the monitor
+type PodSpec struct {
+	NodeName    string `json:"nodeName"`
+	HostNetwork bool   `json:"hostNetwork"`
+}
+
+// PodStatus is the part of the status of a pod used by the monitor
+type PodStatus struct {
+	Phase string `json:"phase"`
+	PodIP string `json:"podIP"`
+}
+
+// Pod is a pod as returned by the API server
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     PodSpec    `json:"spec"`
+	Status   PodStatus  `json:"status"`
+}
+
+// PodList is a list of pods as returned by the API server
+type PodList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []Pod `json:"items"`
+}
+
+// A PodMetadataExtractor is a function used to extract a *policy.PURuntime from a given
+// pod and the pid of one of its processes.
+type PodMetadataExtractor func(pod *Pod, pid int) (*policy.PURuntime, error)
+
+// DefaultPodMetadataExtractor is the default metadata extractor for pods. The labels
+// of the pod become user tags.
+func DefaultPodMetadataExtractor(pod *Pod, pid int) (*policy.PURuntime, error) {
+
+	tags := policy.NewTagStore()
+	tags.AppendKeyValue("@sys:name", pod.Metadata.Name)
+	tags.AppendKeyValue("@sys:namespace", pod.Metadata.Namespace)
+
+	for k, v := range pod.Metadata.Labels {
+		tags.AppendKeyValue("@usr:"+k, v)
+	}
+
+	ipa := policy.ExtendedMap{
+		"bridge": pod.Status.PodIP,
+	}
+
+	return policy.NewPURuntime(pod.Metadata.Name, pid, "", tags, ipa, constants.KubernetesPU, nil), nil
+}
+
+// podPid returns the pid of a process running in the cgroup of a pod. The
+// kubelet names the cgroups of a pod after its uid, with underscores instead
+// of dashes when the systemd cgroup driver is used.
+func podPid(procMountPoint string, uid string) (int, error) {
+
+	if uid == "" {
+		return 0, fmt.Errorf("empty pod uid")
+	}
+
+	names := []string{"pod" + uid, "pod" + strings.Replace(uid, "-", "_", -1)}
+
+	dirs, err := ioutil.ReadDir(procMountPoint)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read %s: %s", procMountPoint, err)
+	}
+
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil || !dir.IsDir() {
+			continue
+		}
+
+		if inCgroup(filepath.Join(procMountPoint, dir.Name(), "cgroup"), names) {
+			return pid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no process found for pod %s", uid)
+}
+
+// inCgroup returns true if a cgroup file of a process contains one of the names
+func inCgroup(path string, names []string) bool {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close() // nolint
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		for _, name := range names {
+			if strings.Contains(scanner.Text(), name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -19,6 +19,7 @@ type Config struct {
 	StoredPath             string
 	ReleasePath            string
 	Host                   bool
+	AutoPort               bool
 }
 
 // DefaultConfig provides a default configuration
@@ -122,6 +123,7 @@ func (l *linuxMonitor) SetupConfig(registerer registerer.Registerer, cfg interfa
 	l.proc.netcls = cgnetcls.NewCgroupNetController(linuxConfig.ReleasePath)
 	l.proc.contextStore = contextstore.NewFileContextStore(linuxConfig.StoredPath, l.proc.RemapData)
 	l.proc.storePath = linuxConfig.StoredPath
+	l.proc.autoPort = linuxConfig.AutoPort
 
 	l.proc.regStart = regexp.MustCompile("^[a-zA-Z0-9_].{0,11}$")
 	l.proc.regStop = regexp.MustCompile("^/trireme/[a-zA-Z0-9_].{0,11}$")
@@ -23,6 +23,7 @@ import (
 type StoredContext struct {
 	EventInfo *events.EventInfo
 	Tags      *policy.TagStore `json:"Tags,omitempty"`
+	MarkVal   string           `json:"MarkVal,omitempty"`
 }
 
 // linuxProcessor captures all the monitor processor information
@@ -36,6 +37,7 @@ type linuxProcessor struct {
 	regStart          *regexp.Regexp
 	regStop           *regexp.Regexp
 	storePath         string
+	autoPort          bool
 }
 
 func baseName(name, separator string) string {
@@ -93,8 +95,17 @@ func (l *linuxProcessor) startInternal(runtimeInfo *policy.PURuntime, eventInfo
 		return fmt.Errorf("invalid pu id: %s", eventInfo.PUID)
 	}
 
+	// The ports of the PU are discovered from the processes of its cgroup
+	// instead of being declared. Host services are not in their own cgroup.
+	if l.autoPort || eventInfo.AutoPort {
+		options := runtimeInfo.Options()
+		options.AutoPort = !eventInfo.HostService
+		runtimeInfo.SetOptions(options)
+	}
+
 	// Setup the run time
 	if err = l.config.PUHandler.CreatePURuntime(eventInfo.PUID, runtimeInfo); err != nil {
+		releaseMark(runtimeInfo.Options().CgroupMark)
 		return fmt.Errorf("create runtime failed: %s", err)
 	}
 
@@ -122,6 +133,7 @@ func (l *linuxProcessor) startInternal(runtimeInfo *policy.PURuntime, eventInfo
 	return l.contextStore.Store(eventInfo.PUID, &StoredContext{
 		EventInfo: eventInfo,
 		Tags:      runtimeInfo.Tags(),
+		MarkVal:   runtimeInfo.Options().CgroupMark,
 	})
 }
 
@@ -183,6 +195,11 @@ func (l *linuxProcessor) Destroy(eventInfo *events.EventInfo) error {
 		}
 	}
 
+	storedContext := StoredContext{}
+	if err := l.contextStore.Retrieve("/"+contextID, &storedContext); err == nil {
+		releaseMark(storedContext.MarkVal)
+	}
+
 	//let us remove the cgroup files now
 	if err := l.netcls.DeleteCgroup(contextID); err != nil {
 		zap.L().Warn("Failed to clean netcls group",
@@ -212,6 +229,47 @@ func (l *linuxProcessor) Pause(eventInfo *events.EventInfo) error {
 	return l.config.PUHandler.HandlePUEvent(contextID, events.EventPause)
 }
 
+// Update handles an update event. The tags of the event replace the tags of the
+// running PU, which is updated without a restart.
+func (l *linuxProcessor) Update(eventInfo *events.EventInfo) error {
+
+	contextID, err := l.generateContextID(eventInfo)
+	if err != nil {
+		return fmt.Errorf("unable to generate context id: %s", err)
+	}
+
+	contextID = baseName(contextID, "/")
+
+	storedContext := StoredContext{}
+	if err = l.contextStore.Retrieve("/"+contextID, &storedContext); err != nil {
+		return fmt.Errorf("unable to find pu %s: %s", contextID, err)
+	}
+
+	storedEvent := storedContext.EventInfo
+	storedEvent.Tags = eventInfo.Tags
+
+	runtimeInfo, err := l.metadataExtractor(storedEvent)
+	if err != nil {
+		return err
+	}
+	// Only the tags are updated, the PU keeps its mark
+	releaseMark(runtimeInfo.Options().CgroupMark)
+
+	if err = l.config.PUHandler.UpdatePURuntime(contextID, runtimeInfo); err != nil {
+		return fmt.Errorf("update runtime failed: %s", err)
+	}
+
+	if err = l.config.PUHandler.HandlePUEvent(contextID, events.EventUpdate); err != nil {
+		return fmt.Errorf("handle pu failed: %s", err)
+	}
+
+	return l.contextStore.Store(contextID, &StoredContext{
+		EventInfo: storedEvent,
+		Tags:      runtimeInfo.Tags(),
+		MarkVal:   storedContext.MarkVal,
+	})
+}
+
 // ReSync resyncs with all the existing services that were there before we start
 func (l *linuxProcessor) ReSync(e *events.EventInfo) error {
 
@@ -297,6 +355,7 @@ func (l *linuxProcessor) ReSync(e *events.EventInfo) error {
 
 			processlist, err := cgnetcls.ListCgroupProcesses(eventInfo.PUID)
 			if err != nil {
+				releaseMark(runtimeInfo.Options().CgroupMark)
 				deleted = append(deleted, eventInfo.PUID)
 				if err := l.contextStore.Remove(eventInfo.PUID); err != nil {
 					zap.L().Warn("Failed to remove state from store handler",
@@ -308,6 +367,7 @@ func (l *linuxProcessor) ReSync(e *events.EventInfo) error {
 
 			if len(processlist) <= 0 {
 
+				releaseMark(runtimeInfo.Options().CgroupMark)
 				deleted = append(deleted, eventInfo.PUID)
 
 				// We have an empty cgroup. Remove the cgroup and context store file
@@ -358,6 +418,19 @@ func (l *linuxProcessor) ReSync(e *events.EventInfo) error {
 	return nil
 }
 
+// releaseMark returns the mark of a PU to the pool
+func releaseMark(mark string) {
+
+	value, err := strconv.ParseUint(mark, 10, 64)
+	if err != nil {
+		return
+	}
+
+	if err := cgnetcls.ReleaseMark(value); err != nil {
+		zap.L().Debug("Unable to release mark", zap.String("mark", mark), zap.Error(err))
+	}
+}
+
 // generateContextID creates the contextID from the event information
 func (l *linuxProcessor) generateContextID(eventInfo *events.EventInfo) (string, error) {
 
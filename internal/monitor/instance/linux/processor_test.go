@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/rpc/events"
 	"github.com/aporeto-inc/trireme-lib/rpc/processor"
 	"github.com/aporeto-inc/trireme-lib/rpc/processor/mock"
+	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
 	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls/mock"
 	"github.com/aporeto-inc/trireme-lib/utils/contextstore/mock"
 	"github.com/golang/mock/gomock"
@@ -123,6 +126,7 @@ func TestDestroy(t *testing.T) {
 				PUID: "/trireme/1234",
 			}
 			mockcls.EXPECT().DeleteCgroup(gomock.Any()).Return(nil)
+			store.EXPECT().Retrieve(gomock.Any(), gomock.Any()).Return(nil)
 			store.EXPECT().Remove(gomock.Any()).Return(nil)
 
 			puHandler.EXPECT().HandlePUEvent(gomock.Any(), gomock.Any()).Return(nil)
@@ -132,6 +136,29 @@ func TestDestroy(t *testing.T) {
 			})
 		})
 
+		Convey("When I get a destroy event for a PU with a mark", func() {
+			event := &events.EventInfo{
+				PUID: "/trireme/1234",
+			}
+
+			mark, err := cgnetcls.AllocateMark()
+			So(err, ShouldBeNil)
+			inUse := cgnetcls.GetMarkStats().InUse
+
+			mockcls.EXPECT().DeleteCgroup(gomock.Any()).Return(nil)
+			store.EXPECT().Retrieve("/1234", gomock.Any()).Do(func(id string, v interface{}) {
+				v.(*StoredContext).MarkVal = strconv.FormatUint(mark, 10)
+			}).Return(nil)
+			store.EXPECT().Remove(gomock.Any()).Return(nil)
+			puHandler.EXPECT().HandlePUEvent(gomock.Any(), gomock.Any()).Return(nil)
+
+			Convey("The mark should be released", func() {
+				err := p.Destroy(event)
+				So(err, ShouldBeNil)
+				So(cgnetcls.GetMarkStats().InUse, ShouldEqual, inUse-1)
+			})
+		})
+
 	})
 }
 
@@ -160,6 +187,64 @@ func TestPause(t *testing.T) {
 	})
 }
 
+func TestUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a valid processor", t, func() {
+		puHandler := mockprocessor.NewMockProcessingUnitsHandler(ctrl)
+		store := mockcontextstore.NewMockContextStore(ctrl)
+
+		p := testLinuxProcessor(puHandler, nil)
+		p.contextStore = store
+
+		event := &events.EventInfo{
+			PUID: "1234",
+			Tags: []string{"app=db"},
+		}
+
+		storedEvent := func(id string, v interface{}) {
+			v.(*StoredContext).EventInfo = &events.EventInfo{
+				Name: "PU",
+				PID:  "1234",
+				PUID: "1234",
+				Tags: []string{"app=web"},
+			}
+		}
+
+		Convey("When I get an update event for an unknown PU", func() {
+			store.EXPECT().Retrieve("/1234", gomock.Any()).Return(errors.New("error"))
+
+			Convey("I should get an error", func() {
+				err := p.Update(event)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I get an update event and updating the PU runtime fails", func() {
+			store.EXPECT().Retrieve("/1234", gomock.Any()).Do(storedEvent).Return(nil)
+			puHandler.EXPECT().UpdatePURuntime("1234", gomock.Any()).Return(errors.New("error"))
+
+			Convey("I should get an error", func() {
+				err := p.Update(event)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I get an update event that is valid", func() {
+			store.EXPECT().Retrieve("/1234", gomock.Any()).Do(storedEvent).Return(nil)
+			puHandler.EXPECT().UpdatePURuntime("1234", gomock.Any()).Return(nil)
+			puHandler.EXPECT().HandlePUEvent("1234", events.EventUpdate).Return(nil)
+			store.EXPECT().Store("1234", gomock.Any()).Return(nil)
+
+			Convey("I should get no error", func() {
+				err := p.Update(event)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
 func TestStart(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -269,6 +354,67 @@ func TestStart(t *testing.T) {
 				So(err, ShouldBeNil)
 			})
 		})
+
+		Convey("When I get a start event and the ports are discovered", func() {
+			event := &events.EventInfo{
+				Name:      "PU",
+				PID:       "1",
+				PUID:      "12345",
+				EventType: events.EventStart,
+				PUType:    constants.LinuxProcessPU,
+			}
+
+			p.autoPort = true
+			mockcls := mockcgnetcls.NewMockCgroupnetcls(ctrl)
+			p.netcls = mockcls
+
+			Convey("The runtime should request the port discovery", func() {
+				var runtime *policy.PURuntime
+				puHandler.EXPECT().CreatePURuntime(gomock.Any(), gomock.Any()).Do(func(contextID string, r *policy.PURuntime) {
+					runtime = r
+				}).Return(nil)
+				puHandler.EXPECT().HandlePUEvent(gomock.Any(), gomock.Any()).Return(nil)
+
+				mockcls.EXPECT().Creategroup(gomock.Any()).Return(nil)
+				mockcls.EXPECT().AssignMark(gomock.Any(), gomock.Any()).Return(nil)
+				mockcls.EXPECT().AddProcess(gomock.Any(), gomock.Any())
+				store.EXPECT().Store(gomock.Any(), gomock.Any())
+				err := p.Start(event)
+				So(err, ShouldBeNil)
+				So(runtime.Options().AutoPort, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I get a start event that requests the port discovery", func() {
+			event := &events.EventInfo{
+				Name:      "PU",
+				PID:       "1",
+				PUID:      "12345",
+				EventType: events.EventStart,
+				PUType:    constants.LinuxProcessPU,
+				AutoPort:  true,
+			}
+
+			mockcls := mockcgnetcls.NewMockCgroupnetcls(ctrl)
+			p.netcls = mockcls
+
+			Convey("The runtime should request the port discovery", func() {
+				var runtime *policy.PURuntime
+				puHandler.EXPECT().CreatePURuntime(gomock.Any(), gomock.Any()).Do(func(contextID string, r *policy.PURuntime) {
+					runtime = r
+				}).Return(nil)
+				puHandler.EXPECT().HandlePUEvent(gomock.Any(), gomock.Any()).Return(nil)
+
+				mockcls.EXPECT().Creategroup(gomock.Any()).Return(nil)
+				mockcls.EXPECT().AssignMark(gomock.Any(), gomock.Any()).Return(nil)
+				mockcls.EXPECT().AddProcess(gomock.Any(), gomock.Any())
+				store.EXPECT().Store(gomock.Any(), gomock.Any())
+				err := p.Start(event)
+				So(err, ShouldBeNil)
+				So(runtime.Options().AutoPort, ShouldBeTrue)
+				So(runtime.Options().UserID, ShouldEqual, "")
+			})
+		})
 	})
 }
 
@@ -0,0 +1,173 @@
+package uidmonitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+)
+
+// credentialEvent is a change of the user of a process
+type credentialEvent struct {
+	pid int
+	uid uint32
+}
+
+// handleCredentialEvents re-attaches the processes that changed their user
+// until the events channel is closed
+func (u *uidProcessor) handleCredentialEvents(credentials <-chan credentialEvent) {
+
+	for event := range credentials {
+		if err := u.reattachProcess(event.pid, event.uid); err != nil {
+			zap.L().Warn("Unable to re-attach process after a uid change",
+				zap.Int("pid", event.pid),
+				zap.Uint32("uid", event.uid),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// reattachProcess moves a process that changed its user (sudo, su ...) to the
+// PU of its new user. The process is released from any PU if there is no PU
+// for the new user, so that it does not keep the mark of its original user.
+func (u *uidProcessor) reattachProcess(pid int, uid uint32) error {
+
+	u.Lock()
+	defer u.Unlock()
+
+	cgroup, err := u.processCgroup(pid)
+	if err != nil {
+		// The process is already gone
+		return nil
+	}
+
+	currentPU := ""
+	if cgroup != "" {
+		if contextID, err := u.pidToPU.Get(cgroup); err == nil {
+			currentPU = contextID.(string)
+		}
+	}
+
+	targetPU := u.puFromUID(uid)
+	if targetPU == currentPU {
+		return nil
+	}
+
+	PID := strconv.Itoa(pid)
+
+	if currentPU != "" {
+		if cgroup == PID {
+			// The process owns its cgroup. It is removed from its PU and the
+			// cgroup is kept for the new PU.
+			u.detachPid(currentPU, PID)
+		}
+
+		if err := u.netcls.RemoveProcess(cgroup, pid); err != nil {
+			return fmt.Errorf("unable to remove process from cgroup %s: %s", cgroup, err)
+		}
+	}
+
+	if targetPU == "" {
+		if cgroup == PID {
+			if err := u.netcls.DeleteCgroup(cgroup); err != nil {
+				zap.L().Debug("Cgroup still in use after a uid change", zap.String("cgroup", cgroup), zap.Error(err))
+			}
+		}
+
+		zap.L().Debug("Process released from its PU after a uid change",
+			zap.Int("pid", pid),
+			zap.String("contextID", currentPU),
+		)
+		return nil
+	}
+
+	return u.start(&events.EventInfo{
+		PUID: targetPU,
+		PID:  PID,
+	})
+}
+
+// detachPid removes a pid from its PU and stops the PU if it has no processes
+// left. It must be called with the lock held.
+func (u *uidProcessor) detachPid(contextID string, pid string) {
+
+	entry, err := u.putoPidMap.Get(contextID)
+	if err != nil {
+		return
+	}
+	ctx := entry.(*puToPidEntry)
+
+	delete(ctx.pidlist, pid)
+
+	if err := u.pidToPU.Remove(pid); err != nil {
+		zap.L().Warn("Failed to remove entry in the cache", zap.Error(err), zap.String("pid", pid))
+	}
+
+	if len(ctx.pidlist) == 0 {
+		u.stopPU(contextID, ctx)
+	}
+}
+
+// puFromUID returns the PU of a user. Users are identified by name or by uid
+// in the PU options.
+func (u *uidProcessor) puFromUID(uid uint32) string {
+
+	candidates := []string{strconv.FormatUint(uint64(uid), 10)}
+	if usr, err := user.LookupId(candidates[0]); err == nil {
+		candidates = append(candidates, usr.Username)
+	}
+
+	for _, candidate := range candidates {
+		if contextID, err := u.userToPU.Get(candidate); err == nil {
+			return contextID.(string)
+		}
+	}
+
+	return ""
+}
+
+// processCgroup returns the trireme net_cls cgroup of a process or an empty
+// string if the process is not in a trireme cgroup
+func (u *uidProcessor) processCgroup(pid int) (string, error) {
+
+	data, err := ioutil.ReadFile(filepath.Join(u.procMountPoint, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	return netclsCgroup(string(data)), nil
+}
+
+// netclsCgroup parses the content of /proc/<pid>/cgroup and returns the name
+// of the trireme net_cls cgroup
+func netclsCgroup(data string) string {
+
+	for _, line := range strings.Split(data, "\n") {
+
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller != "net_cls" {
+				continue
+			}
+
+			if !strings.HasPrefix(parts[2], triremeBaseCgroup+"/") {
+				return ""
+			}
+
+			return baseName(parts[2], "/")
+		}
+	}
+
+	return ""
+}
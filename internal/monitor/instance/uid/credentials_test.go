@@ -0,0 +1,111 @@
+package uidmonitor
+
+import (
+	"encoding/binary"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func uidEvent(pid, tgid, uid uint32) []byte {
+
+	data := make([]byte, cnMsgLen+procEventHeaderLen+idProcEventLen)
+	binary.LittleEndian.PutUint32(data[0:4], cnIdxProc)
+	binary.LittleEndian.PutUint32(data[4:8], cnValProc)
+
+	event := data[cnMsgLen:]
+	binary.LittleEndian.PutUint32(event[0:4], procEventUID)
+
+	id := event[procEventHeaderLen:]
+	binary.LittleEndian.PutUint32(id[0:4], pid)
+	binary.LittleEndian.PutUint32(id[4:8], tgid)
+	binary.LittleEndian.PutUint32(id[8:12], 1000)
+	binary.LittleEndian.PutUint32(id[12:16], uid)
+
+	return data
+}
+
+func TestParseCredentialEvent(t *testing.T) {
+
+	Convey("Given a uid change event of a process", t, func() {
+
+		data := uidEvent(1234, 1234, 0)
+
+		Convey("Then I should get the pid and the effective uid", func() {
+			event, ok := parseCredentialEvent(data)
+			So(ok, ShouldBeTrue)
+			So(event.pid, ShouldEqual, 1234)
+			So(event.uid, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a uid change event of a thread", t, func() {
+
+		data := uidEvent(1235, 1234, 0)
+
+		Convey("Then the event should be ignored", func() {
+			_, ok := parseCredentialEvent(data)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given an event that is not a uid change", t, func() {
+
+		data := uidEvent(1234, 1234, 0)
+		binary.LittleEndian.PutUint32(data[cnMsgLen:], 0x00000002)
+
+		Convey("Then the event should be ignored", func() {
+			_, ok := parseCredentialEvent(data)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a truncated event", t, func() {
+
+		data := uidEvent(1234, 1234, 0)[:cnMsgLen+4]
+
+		Convey("Then the event should be ignored", func() {
+			_, ok := parseCredentialEvent(data)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestControlMessage(t *testing.T) {
+
+	Convey("When I build a listen message", t, func() {
+
+		msg := controlMessage(procCnMcastListen, 42)
+
+		Convey("Then the message should address the proc connector", func() {
+			So(len(msg), ShouldEqual, 40)
+			So(binary.LittleEndian.Uint32(msg[0:4]), ShouldEqual, 40)
+			So(binary.LittleEndian.Uint32(msg[12:16]), ShouldEqual, 42)
+			So(binary.LittleEndian.Uint32(msg[16:20]), ShouldEqual, cnIdxProc)
+			So(binary.LittleEndian.Uint32(msg[20:24]), ShouldEqual, cnValProc)
+			So(binary.LittleEndian.Uint16(msg[32:34]), ShouldEqual, 4)
+			So(binary.LittleEndian.Uint32(msg[36:40]), ShouldEqual, procCnMcastListen)
+		})
+	})
+}
+
+func TestNetclsCgroup(t *testing.T) {
+
+	Convey("Given a process in a trireme cgroup", t, func() {
+
+		data := "12:cpu,cpuacct:/user.slice\n4:net_cls,net_prio:/trireme/1234\n1:name=systemd:/user.slice/session-1.scope\n"
+
+		Convey("Then I should get the name of the cgroup", func() {
+			So(netclsCgroup(data), ShouldEqual, "1234")
+		})
+	})
+
+	Convey("Given a process outside of trireme cgroups", t, func() {
+
+		data := "4:net_cls,net_prio:/\n1:name=systemd:/user.slice/session-1.scope\n"
+
+		Convey("Then I should get no cgroup", func() {
+			So(netclsCgroup(data), ShouldEqual, "")
+		})
+	})
+}
@@ -0,0 +1,222 @@
+package uidmonitor
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
+	"github.com/aporeto-inc/trireme-lib/utils/contextstore"
+)
+
+const (
+	// defaultMarksRetention is how long the mark of a stopped PU is remembered
+	defaultMarksRetention = 24 * time.Hour
+)
+
+// storedMark is the mark of a PU remembered in the context store. Stopped is
+// zero while the PU runs.
+type storedMark struct {
+	Mark    string
+	Stopped time.Time
+}
+
+// markAllocator remembers the marks of the PUs in a context store, so that a
+// PU gets the same mark when it is started again, including after a restart.
+// The published context id, the portsets and the chains of a PU are derived
+// from its mark, and stay the same as well. The remembered marks are reserved
+// and are not assigned to another PU, until the PU has been stopped for longer
+// than the retention.
+type markAllocator struct {
+	store     contextstore.ContextStore
+	retention time.Duration
+	// marks are the marks by context id
+	marks map[string]string
+	// held are the remembered marks
+	held map[string]bool
+	// stopped are the times the PUs were stopped by context id
+	stopped map[string]time.Time
+	sync.Mutex
+}
+
+// newMarkAllocator returns an allocator with the marks remembered in store. The
+// marks of the PUs stopped for longer than retention are forgotten. The PUs
+// that were running when the monitor stopped are considered stopped now, until
+// they start again.
+func newMarkAllocator(store contextstore.ContextStore, retention time.Duration) *markAllocator {
+
+	a := &markAllocator{
+		store:     store,
+		retention: retention,
+		marks:     map[string]string{},
+		held:      map[string]bool{},
+		stopped:   map[string]time.Time{},
+	}
+
+	now := time.Now()
+
+	walker, err := store.Walk()
+	if err != nil {
+		zap.L().Debug("No remembered marks", zap.Error(err))
+		return a
+	}
+
+	for {
+		contextID := <-walker
+		if contextID == "" {
+			break
+		}
+
+		stored := &storedMark{}
+		if err := store.Retrieve("/"+contextID, stored); err != nil {
+			zap.L().Warn("Unable to retrieve remembered mark", zap.String("contextID", contextID), zap.Error(err))
+			continue
+		}
+
+		if !stored.Stopped.IsZero() && now.Sub(stored.Stopped) > retention {
+			a.forget(contextID)
+			continue
+		}
+
+		if err := reserveMark(stored.Mark); err != nil {
+			zap.L().Warn("Unable to reserve remembered mark",
+				zap.String("contextID", contextID),
+				zap.String("mark", stored.Mark),
+				zap.Error(err),
+			)
+			a.forget(contextID)
+			continue
+		}
+
+		a.marks[contextID] = stored.Mark
+		a.held[stored.Mark] = true
+		a.stopped[contextID] = now
+		if !stored.Stopped.IsZero() {
+			a.stopped[contextID] = stored.Stopped
+		}
+	}
+
+	return a
+}
+
+// assign sets the mark of the runtime of a PU to the mark remembered for the
+// PU, and returns the mark allocated by the metadata extractor to the pool. The
+// mark of the runtime is remembered if the PU has no mark yet.
+func (a *markAllocator) assign(contextID string, runtime *policy.PURuntime) {
+
+	a.Lock()
+	defer a.Unlock()
+
+	options := runtime.Options()
+
+	if mark, ok := a.marks[contextID]; ok {
+		if options.CgroupMark != mark {
+			releaseMark(options.CgroupMark)
+			options.CgroupMark = mark
+			runtime.SetOptions(options)
+		}
+		if _, ok := a.stopped[contextID]; ok {
+			delete(a.stopped, contextID)
+			a.remember(contextID, &storedMark{Mark: mark})
+		}
+		return
+	}
+
+	a.marks[contextID] = options.CgroupMark
+	a.held[options.CgroupMark] = true
+
+	a.remember(contextID, &storedMark{Mark: options.CgroupMark})
+}
+
+// stop records that a PU stopped, and forgets the marks of the PUs stopped for
+// longer than the retention
+func (a *markAllocator) stop(contextID string) {
+
+	a.Lock()
+	defer a.Unlock()
+
+	mark, ok := a.marks[contextID]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	a.stopped[contextID] = now
+	a.remember(contextID, &storedMark{Mark: mark, Stopped: now})
+
+	a.expire(now)
+}
+
+// expireAll forgets the marks of the PUs stopped for longer than the retention,
+// and returns them to the pool
+func (a *markAllocator) expireAll() {
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.expire(time.Now())
+}
+
+// expire forgets the marks of the PUs stopped before now minus the retention.
+// It must be called with the lock held.
+func (a *markAllocator) expire(now time.Time) {
+
+	for contextID, stopped := range a.stopped {
+		if now.Sub(stopped) <= a.retention {
+			continue
+		}
+
+		mark := a.marks[contextID]
+
+		delete(a.stopped, contextID)
+		delete(a.marks, contextID)
+		delete(a.held, mark)
+		a.forget(contextID)
+
+		releaseMark(mark)
+	}
+}
+
+// remember stores the mark of a PU. The PU keeps its mark until the monitor
+// stops if it cannot be stored.
+func (a *markAllocator) remember(contextID string, stored *storedMark) {
+
+	if err := a.store.Store(contextID, stored); err != nil {
+		zap.L().Warn("Unable to remember mark",
+			zap.String("contextID", contextID),
+			zap.String("mark", stored.Mark),
+			zap.Error(err),
+		)
+	}
+}
+
+// forget removes the stored mark of a PU
+func (a *markAllocator) forget(contextID string) {
+
+	if err := a.store.Remove("/" + contextID); err != nil {
+		zap.L().Warn("Unable to remove remembered mark", zap.String("contextID", contextID), zap.Error(err))
+	}
+}
+
+// holds returns true if a mark is remembered for a PU
+func (a *markAllocator) holds(mark string) bool {
+
+	a.Lock()
+	defer a.Unlock()
+
+	return a.held[mark]
+}
+
+// reserveMark reserves a mark in the pool
+func reserveMark(mark string) error {
+
+	value, err := strconv.ParseUint(mark, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	return cgnetcls.ReserveMark(value)
+}
@@ -0,0 +1,121 @@
+package uidmonitor
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
+	"github.com/aporeto-inc/trireme-lib/utils/contextstore"
+)
+
+func markRuntime() *policy.PURuntime {
+
+	mark, err := cgnetcls.AllocateMark()
+	So(err, ShouldBeNil)
+
+	return policy.NewPURuntime("alice", 0, "", policy.NewTagStore(), nil, 0, &policy.OptionsType{
+		CgroupMark: strconv.FormatUint(mark, 10),
+	})
+}
+
+func TestMarkAllocator(t *testing.T) {
+
+	Convey("Given a mark allocator", t, func() {
+
+		dir, err := ioutil.TempDir("", "marks")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		store := contextstore.NewFileContextStore(dir, nil)
+		a := newMarkAllocator(store, time.Hour)
+
+		runtime := markRuntime()
+		mark := runtime.Options().CgroupMark
+		defer releaseMark(mark)
+
+		a.assign("alice", runtime)
+
+		Convey("The mark of the first start should be remembered", func() {
+			So(runtime.Options().CgroupMark, ShouldEqual, mark)
+			So(a.holds(mark), ShouldBeTrue)
+
+			stored := &storedMark{}
+			So(store.Retrieve("/alice", stored), ShouldBeNil)
+			So(stored.Mark, ShouldEqual, mark)
+		})
+
+		Convey("When the PU starts again, it should get its remembered mark", func() {
+			again := markRuntime()
+			allocated := again.Options().CgroupMark
+
+			a.assign("alice", again)
+
+			So(again.Options().CgroupMark, ShouldEqual, mark)
+			So(a.holds(allocated), ShouldBeFalse)
+
+			// The mark of the extractor is returned to the pool
+			So(reserveMark(allocated), ShouldBeNil)
+			releaseMark(allocated)
+		})
+
+		Convey("When the monitor restarts, the remembered marks should be reserved", func() {
+			releaseMark(mark)
+
+			restarted := newMarkAllocator(store, time.Hour)
+
+			So(restarted.holds(mark), ShouldBeTrue)
+			So(reserveMark(mark), ShouldNotBeNil)
+
+			again := markRuntime()
+			restarted.assign("alice", again)
+			So(again.Options().CgroupMark, ShouldEqual, mark)
+		})
+
+		Convey("When the PU stops, its mark should be remembered until the retention expires", func() {
+			a.stop("alice")
+
+			stored := &storedMark{}
+			So(store.Retrieve("/alice", stored), ShouldBeNil)
+			So(stored.Stopped.IsZero(), ShouldBeFalse)
+
+			a.expireAll()
+			So(a.holds(mark), ShouldBeTrue)
+
+			a.expire(time.Now().Add(2 * time.Hour))
+			So(a.holds(mark), ShouldBeFalse)
+			So(store.Retrieve("/alice", stored), ShouldNotBeNil)
+
+			// The mark is returned to the pool
+			So(reserveMark(mark), ShouldBeNil)
+		})
+
+		Convey("When the PU starts again after a stop, its mark should not expire", func() {
+			a.stop("alice")
+
+			a.assign("alice", markRuntime())
+
+			a.expire(time.Now().Add(2 * time.Hour))
+			So(a.holds(mark), ShouldBeTrue)
+
+			stored := &storedMark{}
+			So(store.Retrieve("/alice", stored), ShouldBeNil)
+			So(stored.Stopped.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("When the monitor restarts after the retention, the mark should be forgotten", func() {
+			So(store.Store("alice", &storedMark{Mark: mark, Stopped: time.Now().Add(-2 * time.Hour)}), ShouldBeNil)
+			releaseMark(mark)
+
+			restarted := newMarkAllocator(store, time.Hour)
+
+			So(restarted.holds(mark), ShouldBeFalse)
+			So(store.Retrieve("/alice", &storedMark{}), ShouldNotBeNil)
+		})
+	})
+}
@@ -3,6 +3,9 @@ package uidmonitor
 import (
 	"fmt"
 	"regexp"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance"
@@ -19,6 +22,19 @@ type Config struct {
 	EventMetadataExtractor events.EventMetadataExtractor
 	StoredPath             string
 	ReleasePath            string
+	// MarksPath is where the marks of the PUs are remembered, so that a PU gets
+	// the same mark, and the same rules, when it is started again after a
+	// restart. The marks are allocated for each start if it is empty.
+	MarksPath string
+	// MarksRetention is how long the mark of a stopped PU is remembered. The
+	// mark is returned to the pool afterwards.
+	MarksRetention time.Duration
+	// Sessions starts the PUs of the users of the login sessions, like the ssh
+	// and console sessions, from the login records of UTMPPath, and stops them
+	// when the sessions end. No event is needed for these sessions.
+	Sessions        bool
+	UTMPPath        string
+	SessionInterval time.Duration
 }
 
 // DefaultConfig provides default configuration for uid monitor
@@ -28,6 +44,10 @@ func DefaultConfig() *Config {
 		EventMetadataExtractor: events.UIDMetadataExtractor,
 		StoredPath:             "/var/run/trireme/uid",
 		ReleasePath:            "/var/lib/aporeto/cleaner",
+		MarksPath:              "/var/run/trireme/uid-marks",
+		MarksRetention:         defaultMarksRetention,
+		UTMPPath:               defaultUTMPPath,
+		SessionInterval:        defaultSessionInterval,
 	}
 }
 
@@ -45,6 +65,15 @@ func SetupDefaultConfig(uidConfig *Config) *Config {
 	if uidConfig.EventMetadataExtractor == nil {
 		uidConfig.EventMetadataExtractor = defaultConfig.EventMetadataExtractor
 	}
+	if uidConfig.MarksRetention == 0 {
+		uidConfig.MarksRetention = defaultConfig.MarksRetention
+	}
+	if uidConfig.UTMPPath == "" {
+		uidConfig.UTMPPath = defaultConfig.UTMPPath
+	}
+	if uidConfig.SessionInterval == 0 {
+		uidConfig.SessionInterval = defaultConfig.SessionInterval
+	}
 
 	return uidConfig
 }
@@ -52,7 +81,9 @@ func SetupDefaultConfig(uidConfig *Config) *Config {
 // uidMonitor captures all the monitor processor information for a UIDLoginPU
 // It implements the EventProcessor interface of the rpc monitor
 type uidMonitor struct {
-	proc *uidProcessor
+	proc      *uidProcessor
+	connector *procConnector
+	sessions  *sessionWatcher
 }
 
 // New returns a new implmentation of a monitor implmentation
@@ -74,15 +105,47 @@ func (u *uidMonitor) Start() error {
 		return err
 	}
 
+	u.startCredentialMonitor()
+
+	if u.sessions != nil {
+		go u.sessions.run()
+	}
+
 	return nil
 }
 
 // Stop implements Implementation interface
 func (u *uidMonitor) Stop() error {
 
+	if u.sessions != nil {
+		u.sessions.close()
+	}
+
+	if u.connector != nil {
+		return u.connector.close()
+	}
+
 	return nil
 }
 
+// startCredentialMonitor follows the uid changes of processes so that processes
+// started with sudo or su are moved to the PU of their new user. The monitor
+// keeps working without it if the kernel events are not available.
+func (u *uidMonitor) startCredentialMonitor() {
+
+	connector, err := newProcConnector()
+	if err != nil {
+		zap.L().Warn("Unable to monitor uid changes of processes", zap.Error(err))
+		return
+	}
+
+	u.connector = connector
+
+	credentials := make(chan credentialEvent, 100)
+	go connector.listen(credentials)
+	go u.proc.handleCredentialEvents(credentials)
+}
+
 // SetupConfig provides a configuration to implmentations. Every implmentation
 // can have its own config type.
 func (u *uidMonitor) SetupConfig(registerer registerer.Registerer, cfg interface{}) error {
@@ -114,11 +177,25 @@ func (u *uidMonitor) SetupConfig(registerer registerer.Registerer, cfg interface
 	u.proc.regStop = regexp.MustCompile("^/trireme/[a-zA-Z0-9_].{0,11}$")
 	u.proc.putoPidMap = cache.NewCache("putoPidMap")
 	u.proc.pidToPU = cache.NewCache("pidToPU")
+	u.proc.userToPU = cache.NewCache("userToPU")
+	u.proc.procMountPoint = constants.DefaultProcMountPoint
 	u.proc.metadataExtractor = uidConfig.EventMetadataExtractor
 	if u.proc.metadataExtractor == nil {
 		return fmt.Errorf("Unable to setup a metadata extractor")
 	}
 
+	if uidConfig.MarksPath != "" {
+		store := contextstore.NewFileContextStore(uidConfig.MarksPath, nil)
+		if store == nil {
+			return fmt.Errorf("unable to create marks store %s", uidConfig.MarksPath)
+		}
+		u.proc.marks = newMarkAllocator(store, uidConfig.MarksRetention)
+	}
+
+	if uidConfig.Sessions {
+		u.sessions = newSessionWatcher(u.proc, uidConfig.UTMPPath, uidConfig.SessionInterval)
+	}
+
 	return nil
 }
 
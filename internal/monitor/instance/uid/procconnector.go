@@ -0,0 +1,76 @@
+package uidmonitor
+
+import "encoding/binary"
+
+// Kernel proc connector definitions from linux/connector.h and linux/cn_proc.h
+const (
+	cnIdxProc = 0x1
+	cnValProc = 0x1
+
+	procCnMcastListen = 1
+	procCnMcastIgnore = 2
+
+	procEventUID = 0x00000004
+
+	// struct cn_msg
+	cnMsgLen = 20
+	// what, cpu and timestamp_ns of struct proc_event
+	procEventHeaderLen = 16
+	// struct id_proc_event
+	idProcEventLen = 16
+)
+
+// parseCredentialEvent parses a proc connector message and returns the uid
+// change it carries. Only the events of the main thread of a process are
+// returned since the kernel reports one event for every thread.
+func parseCredentialEvent(data []byte) (credentialEvent, bool) {
+
+	if len(data) < cnMsgLen+procEventHeaderLen+idProcEventLen {
+		return credentialEvent{}, false
+	}
+
+	if binary.LittleEndian.Uint32(data[0:4]) != cnIdxProc || binary.LittleEndian.Uint32(data[4:8]) != cnValProc {
+		return credentialEvent{}, false
+	}
+
+	event := data[cnMsgLen:]
+	if binary.LittleEndian.Uint32(event[0:4]) != procEventUID {
+		return credentialEvent{}, false
+	}
+
+	id := event[procEventHeaderLen:]
+	pid := binary.LittleEndian.Uint32(id[0:4])
+	tgid := binary.LittleEndian.Uint32(id[4:8])
+	if pid != tgid {
+		return credentialEvent{}, false
+	}
+
+	return credentialEvent{
+		pid: int(tgid),
+		uid: binary.LittleEndian.Uint32(id[12:16]),
+	}, true
+}
+
+// controlMessage builds the netlink message that subscribes or unsubscribes
+// from the proc connector
+func controlMessage(op uint32, pid uint32) []byte {
+
+	const nlmsgHdrLen = 16
+
+	msg := make([]byte, nlmsgHdrLen+cnMsgLen+4)
+
+	// struct nlmsghdr
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	binary.LittleEndian.PutUint16(msg[4:6], 0x3) // NLMSG_DONE
+	binary.LittleEndian.PutUint32(msg[12:16], pid)
+
+	// struct cn_msg
+	cn := msg[nlmsgHdrLen:]
+	binary.LittleEndian.PutUint32(cn[0:4], cnIdxProc)
+	binary.LittleEndian.PutUint32(cn[4:8], cnValProc)
+	binary.LittleEndian.PutUint16(cn[16:18], 4)
+
+	binary.LittleEndian.PutUint32(cn[cnMsgLen:], op)
+
+	return msg
+}
@@ -0,0 +1,111 @@
+// +build linux
+
+package uidmonitor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// procConnector receives the process events of the kernel proc connector
+type procConnector struct {
+	fd   int
+	stop chan bool
+}
+
+// newProcConnector subscribes to the kernel process events. It requires
+// CAP_NET_ADMIN.
+func newProcConnector() (*procConnector, error) {
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, syscall.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open proc connector socket: %s", err)
+	}
+
+	p := &procConnector{
+		fd:   fd,
+		stop: make(chan bool, 1),
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: cnIdxProc,
+		Pid:    uint32(os.Getpid()),
+	}); err != nil {
+		syscall.Close(fd) // nolint
+		return nil, fmt.Errorf("unable to bind proc connector socket: %s", err)
+	}
+
+	// Wake up the receiver regularly so that it can be stopped
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &syscall.Timeval{Sec: 1}); err != nil {
+		syscall.Close(fd) // nolint
+		return nil, fmt.Errorf("unable to set proc connector timeout: %s", err)
+	}
+
+	if err := p.control(procCnMcastListen); err != nil {
+		syscall.Close(fd) // nolint
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// control sends a subscription operation to the proc connector
+func (p *procConnector) control(op uint32) error {
+
+	if err := syscall.Sendto(p.fd, controlMessage(op, uint32(os.Getpid())), 0, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+	}); err != nil {
+		return fmt.Errorf("unable to subscribe to proc connector: %s", err)
+	}
+
+	return nil
+}
+
+// listen sends the uid changes of processes to the channel until the connector
+// is closed. The socket and the channel are closed when listen returns.
+func (p *procConnector) listen(credentials chan<- credentialEvent) {
+
+	defer close(credentials)
+	defer syscall.Close(p.fd) // nolint
+
+	buf := make([]byte, os.Getpagesize())
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		n, _, err := syscall.Recvfrom(p.fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		for _, msg := range msgs {
+			if event, ok := parseCredentialEvent(msg.Data); ok {
+				credentials <- event
+			}
+		}
+	}
+}
+
+// close unsubscribes from the proc connector and stops the listener
+func (p *procConnector) close() error {
+
+	err := p.control(procCnMcastIgnore)
+
+	p.stop <- true
+
+	return err
+}
@@ -0,0 +1,23 @@
+// +build !linux
+
+package uidmonitor
+
+import "errors"
+
+// procConnector receives the process events of the kernel proc connector
+type procConnector struct{}
+
+// newProcConnector is not supported on this platform
+func newProcConnector() (*procConnector, error) {
+	return nil, errors.New("proc connector is not supported on this platform")
+}
+
+// listen closes the channel immediately
+func (p *procConnector) listen(credentials chan<- credentialEvent) {
+	close(credentials)
+}
+
+// close does nothing
+func (p *procConnector) close() error {
+	return nil
+}
@@ -33,6 +33,9 @@ type uidProcessor struct {
 	storePath         string
 	putoPidMap        *cache.Cache
 	pidToPU           *cache.Cache
+	userToPU          *cache.Cache
+	procMountPoint    string
+	marks             *markAllocator
 	sync.Mutex
 }
 
@@ -43,6 +46,7 @@ const (
 // puToPidEntry represents an entry to puToPidMap
 type puToPidEntry struct {
 	pidlist            map[string]bool
+	sessions           map[string]string
 	Info               *policy.PURuntime
 	publishedContextID string
 }
@@ -96,6 +100,19 @@ func (u *uidProcessor) Start(eventInfo *events.EventInfo) error {
 	u.Lock()
 	defer u.Unlock()
 
+	if err := u.start(eventInfo); err != nil {
+		return err
+	}
+
+	u.collectUserEvent(eventInfo.PUID, eventInfo.PID, collector.UserLogin)
+
+	return nil
+}
+
+// start adds the process of the event to its PU and creates the PU if this is
+// its first process. It must be called with the lock held.
+func (u *uidProcessor) start(eventInfo *events.EventInfo) error {
+
 	contextID := eventInfo.PUID
 	pids, err := u.putoPidMap.Get(contextID)
 	var runtimeInfo *policy.PURuntime
@@ -105,9 +122,14 @@ func (u *uidProcessor) Start(eventInfo *events.EventInfo) error {
 			return err
 		}
 
+		if u.marks != nil {
+			u.marks.assign(contextID, runtimeInfo)
+		}
+
 		publishedContextID := contextID + runtimeInfo.Options().CgroupMark
 		// Setup the run time
 		if err = u.config.PUHandler.CreatePURuntime(publishedContextID, runtimeInfo); err != nil {
+			u.releasePUMark(runtimeInfo.Options().CgroupMark)
 			return err
 		}
 
@@ -131,6 +153,7 @@ func (u *uidProcessor) Start(eventInfo *events.EventInfo) error {
 			Info:               runtimeInfo,
 			publishedContextID: publishedContextID,
 			pidlist:            map[string]bool{},
+			sessions:           map[string]string{},
 		}
 
 		entry.pidlist[eventInfo.PID] = true
@@ -148,6 +171,16 @@ func (u *uidProcessor) Start(eventInfo *events.EventInfo) error {
 				zap.String("contextID", contextID),
 			)
 		}
+
+		if user := runtimeInfo.Options().UserID; user != "" {
+			if err := u.userToPU.Add(user, contextID); err != nil {
+				zap.L().Warn("Failed to add user/contextID in the cache",
+					zap.Error(err),
+					zap.String("user", user),
+					zap.String("contextID", contextID),
+				)
+			}
+		}
 		// Store the state in the context store for future access
 		return u.contextStore.Store(contextID, &StoredContext{
 			MarkVal:   runtimeInfo.Options().CgroupMark,
@@ -170,6 +203,17 @@ func (u *uidProcessor) Start(eventInfo *events.EventInfo) error {
 
 }
 
+// hasPid returns true if a process is in a PU
+func (u *uidProcessor) hasPid(pid string) bool {
+
+	u.Lock()
+	defer u.Unlock()
+
+	_, err := u.pidToPU.Get(pid)
+
+	return err == nil
+}
+
 // Stop handles a stop event and destroy as well. Destroy does nothing for the uid monitor
 func (u *uidProcessor) Stop(eventInfo *events.EventInfo) error {
 
@@ -190,10 +234,10 @@ func (u *uidProcessor) Stop(eventInfo *events.EventInfo) error {
 		contextID = puid.(string)
 	}
 
-	var publishedContextID string
 	if pidlist, err := u.putoPidMap.Get(contextID); err == nil {
 		ctx := pidlist.(*puToPidEntry)
-		publishedContextID = ctx.publishedContextID
+		u.collectUserEvent(contextID, stoppedpid, collector.UserLogout)
+
 		// Clean pid from both caches
 		delete(ctx.pidlist, stoppedpid)
 
@@ -206,36 +250,54 @@ func (u *uidProcessor) Stop(eventInfo *events.EventInfo) error {
 			return u.netcls.DeleteCgroup(stoppedpid)
 		}
 
-		if err = u.config.PUHandler.HandlePUEvent(publishedContextID, events.EventStop); err != nil {
-			zap.L().Warn("Failed to stop trireme PU ",
-				zap.String("contextID", contextID),
-				zap.Error(err),
-			)
-		}
+		u.stopPU(contextID, ctx)
 
-		if err = u.putoPidMap.Remove(contextID); err != nil {
-			zap.L().Warn("Failed to remove entry in the cache", zap.Error(err), zap.String("contextID", contextID))
-		}
+		return u.netcls.DeleteCgroup(stoppedpid)
+	}
 
-		if err = u.contextStore.Remove(contextID); err != nil {
-			zap.L().Error("Failed to clean cache while destroying process",
-				zap.String("contextID", contextID),
-				zap.Error(err),
-			)
-		}
+	return nil
 
-		if err = u.config.PUHandler.HandlePUEvent(publishedContextID, events.EventDestroy); err != nil {
-			zap.L().Warn("Failed to Destroy clean trireme ",
-				zap.String("contextID", contextID),
-				zap.Error(err),
-			)
+}
+
+// stopPU stops and destroys a PU that has no processes left. It must be called
+// with the lock held.
+func (u *uidProcessor) stopPU(contextID string, ctx *puToPidEntry) {
+
+	if err := u.config.PUHandler.HandlePUEvent(ctx.publishedContextID, events.EventStop); err != nil {
+		zap.L().Warn("Failed to stop trireme PU ",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+	}
+
+	if err := u.putoPidMap.Remove(contextID); err != nil {
+		zap.L().Warn("Failed to remove entry in the cache", zap.Error(err), zap.String("contextID", contextID))
+	}
+
+	if user := ctx.Info.Options().UserID; user != "" {
+		if err := u.userToPU.Remove(user); err != nil {
+			zap.L().Warn("Failed to remove entry in the cache", zap.Error(err), zap.String("user", user))
 		}
+	}
 
-		return u.netcls.DeleteCgroup(stoppedpid)
+	if u.marks != nil {
+		u.marks.stop(contextID)
 	}
+	u.releasePUMark(ctx.Info.Options().CgroupMark)
 
-	return nil
+	if err := u.contextStore.Remove(contextID); err != nil {
+		zap.L().Error("Failed to clean cache while destroying process",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+	}
 
+	if err := u.config.PUHandler.HandlePUEvent(ctx.publishedContextID, events.EventDestroy); err != nil {
+		zap.L().Warn("Failed to Destroy clean trireme ",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+	}
 }
 
 // Create handles create events
@@ -263,6 +325,50 @@ func (u *uidProcessor) Pause(eventInfo *events.EventInfo) error {
 	return u.config.PUHandler.HandlePUEvent(contextID, events.EventPause)
 }
 
+// Update handles an update event. The tags of the event replace the tags of the
+// running PU, which is updated without a restart.
+func (u *uidProcessor) Update(eventInfo *events.EventInfo) error {
+
+	u.Lock()
+	defer u.Unlock()
+
+	contextID := eventInfo.PUID
+	entry, err := u.putoPidMap.Get(contextID)
+	if err != nil {
+		return fmt.Errorf("unable to find pu %s: %s", contextID, err)
+	}
+	ctx := entry.(*puToPidEntry)
+
+	storedContext := StoredContext{}
+	if err = u.contextStore.Retrieve("/"+contextID, &storedContext); err != nil {
+		return fmt.Errorf("unable to find pu %s: %s", contextID, err)
+	}
+
+	storedEvent := storedContext.EventInfo
+	storedEvent.Tags = eventInfo.Tags
+
+	runtimeInfo, err := u.metadataExtractor(storedEvent)
+	if err != nil {
+		return err
+	}
+	// Only the tags are updated, the PU keeps its mark
+	releaseMark(runtimeInfo.Options().CgroupMark)
+
+	if err = u.config.PUHandler.UpdatePURuntime(ctx.publishedContextID, runtimeInfo); err != nil {
+		return fmt.Errorf("update runtime failed: %s", err)
+	}
+
+	if err = u.config.PUHandler.HandlePUEvent(ctx.publishedContextID, events.EventUpdate); err != nil {
+		return fmt.Errorf("handle pu failed: %s", err)
+	}
+
+	return u.contextStore.Store(contextID, &StoredContext{
+		MarkVal:   storedContext.MarkVal,
+		EventInfo: storedEvent,
+		Tags:      ctx.Info.Tags(),
+	})
+}
+
 // ReSync resyncs with all the existing services that were there before we start
 func (u *uidProcessor) ReSync(e *events.EventInfo) error {
 
@@ -302,6 +408,10 @@ func (u *uidProcessor) ReSync(e *events.EventInfo) error {
 		}
 	}()
 
+	if u.marks != nil {
+		u.marks.expireAll()
+	}
+
 	walker, err := u.contextStore.Walk()
 	if err != nil {
 		return fmt.Errorf("unable to walk context store: %s", err)
@@ -358,6 +468,9 @@ func (u *uidProcessor) ReSync(e *events.EventInfo) error {
 			metadataExtractionFailed++
 			continue
 		}
+		// The runtime is only used for the synchronization. The PU gets a new
+		// mark when it is started again.
+		releaseMark(runtimeInfo.Options().CgroupMark)
 		t := runtimeInfo.Tags()
 		if t != nil {
 			t.Merge(storedContext.Tags)
@@ -390,19 +503,46 @@ func (u *uidProcessor) ReSync(e *events.EventInfo) error {
 				}
 			}
 
+			// The sessions are reacquired, they are not reported as new logins
+			u.Lock()
 			for _, pid := range pids {
 				eventInfo.PID = pid
-				if err := u.Start(eventInfo); err != nil {
+				if err := u.start(eventInfo); err != nil {
 					zap.L().Debug("Failed to start", zap.Error(err), zap.String("eventInfoPID", eventInfo.PID))
 					puStartFailed++
 				}
 			}
+			u.Unlock()
 		}
 	}
 
 	return nil
 }
 
+// releaseMark returns the mark of a PU to the pool
+func releaseMark(mark string) {
+
+	value, err := strconv.ParseUint(mark, 10, 64)
+	if err != nil {
+		return
+	}
+
+	if err := cgnetcls.ReleaseMark(value); err != nil {
+		zap.L().Debug("Unable to release mark", zap.String("mark", mark), zap.Error(err))
+	}
+}
+
+// releasePUMark returns the mark of a PU to the pool, unless it is remembered
+// for the PU
+func (u *uidProcessor) releasePUMark(mark string) {
+
+	if u.marks != nil && u.marks.holds(mark) {
+		return
+	}
+
+	releaseMark(mark)
+}
+
 // generateContextID creates the contextID from the event information
 func (u *uidProcessor) generateContextID(eventInfo *events.EventInfo) (string, error) {
 
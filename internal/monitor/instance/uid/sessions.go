@@ -0,0 +1,108 @@
+package uidmonitor
+
+import (
+	"io/ioutil"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// sshEnvironment are the environment variables set by sshd with the address
+// of the client as their first field.
+var sshEnvironment = []string{"SSH_CONNECTION=", "SSH_CLIENT="}
+
+// collectUserEvent reports a login or logout of the session of the given pid
+// to the collector, if it collects the user events. It must be called with the
+// lock held.
+func (u *uidProcessor) collectUserEvent(contextID string, pid string, event string) {
+
+	entry, err := u.putoPidMap.Get(contextID)
+	if err != nil {
+		return
+	}
+	ctx := entry.(*puToPidEntry)
+
+	if ctx.sessions == nil {
+		ctx.sessions = map[string]string{}
+	}
+
+	var sourceAddress string
+	if event == collector.UserLogin {
+		sourceAddress = sessionSourceAddress(u.procMountPoint, pid)
+		ctx.sessions[pid] = sourceAddress
+	} else {
+		sourceAddress = ctx.sessions[pid]
+		delete(ctx.sessions, pid)
+	}
+
+	userCollector, ok := u.config.Collector.(collector.UserEventCollector)
+	if !ok {
+		return
+	}
+
+	uid, username := lookupUser(ctx.Info.Options().UserID)
+
+	ports := []string{}
+	for _, service := range ctx.Info.Options().Services {
+		if service.Ports != nil {
+			ports = append(ports, service.Ports.String())
+		}
+	}
+
+	userCollector.CollectUserEvent(&collector.UserRecord{
+		ContextID:     contextID,
+		UID:           uid,
+		Username:      username,
+		Ports:         ports,
+		SourceAddress: sourceAddress,
+		Event:         event,
+	})
+}
+
+// sessionSourceAddress returns the address of the client of an ssh session
+// from the environment of the session process. It returns an empty string
+// for local sessions.
+func sessionSourceAddress(procMountPoint string, pid string) string {
+
+	data, err := ioutil.ReadFile(filepath.Join(procMountPoint, pid, "environ"))
+	if err != nil {
+		return ""
+	}
+
+	for _, variable := range strings.Split(string(data), "\x00") {
+		for _, name := range sshEnvironment {
+			if !strings.HasPrefix(variable, name) {
+				continue
+			}
+			if fields := strings.Fields(strings.TrimPrefix(variable, name)); len(fields) > 0 {
+				return fields[0]
+			}
+		}
+	}
+
+	return ""
+}
+
+// lookupUser returns the uid and the name of a user given either of them.
+func lookupUser(name string) (string, string) {
+
+	if name == "" {
+		return "", ""
+	}
+
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u.Uid, u.Username
+		}
+		return name, ""
+	}
+
+	if u, err := user.Lookup(name); err == nil {
+		return u.Uid, u.Username
+	}
+
+	return "", name
+}
@@ -0,0 +1,68 @@
+package uidmonitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessionSourceAddress(t *testing.T) {
+
+	Convey("Given a proc directory", t, func() {
+
+		procRoot, err := ioutil.TempDir("", "proc")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(procRoot) // nolint
+
+		writeEnviron := func(pid string, environ string) {
+			So(os.MkdirAll(filepath.Join(procRoot, pid), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(procRoot, pid, "environ"), []byte(environ), 0644), ShouldBeNil)
+		}
+
+		Convey("If the session is an ssh session, I should get the client address", func() {
+			writeEnviron("1234", "HOME=/home/user\x00SSH_CONNECTION=10.1.1.1 52000 10.1.1.2 22\x00")
+			So(sessionSourceAddress(procRoot, "1234"), ShouldEqual, "10.1.1.1")
+		})
+
+		Convey("If only the legacy ssh variable is set, I should get the client address", func() {
+			writeEnviron("1234", "SSH_CLIENT=10.1.1.1 52000 22\x00")
+			So(sessionSourceAddress(procRoot, "1234"), ShouldEqual, "10.1.1.1")
+		})
+
+		Convey("If the session is local, I should get no address", func() {
+			writeEnviron("1234", "HOME=/home/user\x00TERM=xterm\x00")
+			So(sessionSourceAddress(procRoot, "1234"), ShouldBeEmpty)
+		})
+
+		Convey("If the process does not exist, I should get no address", func() {
+			So(sessionSourceAddress(procRoot, "4321"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestLookupUser(t *testing.T) {
+
+	Convey("Given the root user", t, func() {
+
+		Convey("If I look it up by uid, I should get its name", func() {
+			uid, username := lookupUser("0")
+			So(uid, ShouldEqual, "0")
+			So(username, ShouldEqual, "root")
+		})
+
+		Convey("If I look it up by name, I should get its uid", func() {
+			uid, username := lookupUser("root")
+			So(uid, ShouldEqual, "0")
+			So(username, ShouldEqual, "root")
+		})
+	})
+
+	Convey("Given an unknown user name, I should only get the name", t, func() {
+		uid, username := lookupUser("nosuchuser")
+		So(uid, ShouldBeEmpty)
+		So(username, ShouldEqual, "nosuchuser")
+	})
+}
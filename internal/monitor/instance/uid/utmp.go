@@ -0,0 +1,181 @@
+package uidmonitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+)
+
+const (
+	// defaultUTMPPath is the file of the login records
+	defaultUTMPPath = "/var/run/utmp"
+
+	// defaultSessionInterval is the interval between two reads of the login records
+	defaultSessionInterval = 2 * time.Second
+
+	// utmpUserProcess is the type of the records of the sessions of the users
+	utmpUserProcess = 7
+)
+
+// utmpRecord is the layout of a login record of glibc. It is the same on 32
+// and 64 bits architectures, and is read in the little endian byte order of
+// the supported architectures.
+type utmpRecord struct {
+	Type    int16
+	_       [2]byte
+	Pid     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Time    [2]int32
+	Addr    [4]int32
+	_       [20]byte
+}
+
+// loginSessions returns the users of the login sessions by pid of the
+// process of the session
+func loginSessions(path string) (map[string]string, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // nolint
+
+	sessions := map[string]string{}
+
+	for {
+		record := utmpRecord{}
+		if err := binary.Read(file, binary.LittleEndian, &record); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return sessions, nil
+			}
+			return nil, err
+		}
+
+		if record.Type != utmpUserProcess || record.Pid <= 0 {
+			continue
+		}
+
+		user := string(bytes.TrimRight(record.User[:], "\x00"))
+		if user == "" {
+			continue
+		}
+
+		sessions[strconv.Itoa(int(record.Pid))] = user
+	}
+}
+
+// sessionWatcher starts the PU of the user of a login session, like an ssh or
+// console session, and stops it when the session ends, from the login records
+type sessionWatcher struct {
+	proc     *uidProcessor
+	path     string
+	interval time.Duration
+	// sessions are the users of the sessions by pid added to the PUs
+	sessions map[string]string
+	stop     chan struct{}
+}
+
+// newSessionWatcher returns a watcher of the login records of path
+func newSessionWatcher(proc *uidProcessor, path string, interval time.Duration) *sessionWatcher {
+
+	return &sessionWatcher{
+		proc:     proc,
+		path:     path,
+		interval: interval,
+		sessions: map[string]string{},
+		stop:     make(chan struct{}),
+	}
+}
+
+// run synchronizes the PUs with the login sessions until the watcher is stopped
+func (w *sessionWatcher) run() {
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.sync()
+
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync adds the processes of the new sessions to the PUs of their users, and
+// removes the processes of the sessions that ended from their PUs. The
+// processes that are already in a PU, because of an event, are left alone.
+func (w *sessionWatcher) sync() {
+
+	current, err := loginSessions(w.path)
+	if err != nil {
+		zap.L().Debug("Unable to read login records", zap.String("path", w.path), zap.Error(err))
+		return
+	}
+
+	for pid, user := range w.sessions {
+		if current[pid] == user && w.alive(pid) {
+			continue
+		}
+
+		delete(w.sessions, pid)
+
+		if err := w.proc.Stop(&events.EventInfo{
+			EventType: events.EventStop,
+			PUType:    constants.UIDLoginPU,
+			PUID:      pid,
+		}); err != nil {
+			zap.L().Warn("Unable to stop the PU of a login session", zap.String("pid", pid), zap.String("user", user), zap.Error(err))
+		}
+	}
+
+	for pid, user := range current {
+		if _, ok := w.sessions[pid]; ok || !w.alive(pid) || w.proc.hasPid(pid) {
+			continue
+		}
+
+		if err := w.proc.Start(&events.EventInfo{
+			EventType: events.EventStart,
+			PUType:    constants.UIDLoginPU,
+			PUID:      user,
+			Name:      user,
+			PID:       pid,
+			Tags:      []string{"user=" + user},
+		}); err != nil {
+			zap.L().Warn("Unable to start the PU of a login session", zap.String("pid", pid), zap.String("user", user), zap.Error(err))
+			continue
+		}
+
+		w.sessions[pid] = user
+	}
+}
+
+// alive returns true if the process of a session is running
+func (w *sessionWatcher) alive(pid string) bool {
+
+	_, err := os.Stat(filepath.Join(w.proc.procMountPoint, pid))
+
+	return err == nil
+}
+
+// close stops the watcher
+func (w *sessionWatcher) close() {
+
+	close(w.stop)
+}
@@ -0,0 +1,64 @@
+package uidmonitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func utmpEntry(recordType int16, pid int32, user string) utmpRecord {
+
+	record := utmpRecord{Type: recordType, Pid: pid}
+	copy(record.User[:], user)
+
+	return record
+}
+
+func TestLoginSessions(t *testing.T) {
+
+	Convey("Given login records", t, func() {
+
+		dir, err := ioutil.TempDir("", "utmp")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := filepath.Join(dir, "utmp")
+
+		Convey("The records should have the size of the records of glibc", func() {
+			So(binary.Size(utmpRecord{}), ShouldEqual, 384)
+		})
+
+		Convey("When I read the records of sessions", func() {
+			buffer := &bytes.Buffer{}
+			for _, record := range []utmpRecord{
+				utmpEntry(2, 0, "reboot"),
+				utmpEntry(utmpUserProcess, 1234, "alice"),
+				utmpEntry(8, 1235, "bob"),
+				utmpEntry(utmpUserProcess, 1236, "carol"),
+			} {
+				So(binary.Write(buffer, binary.LittleEndian, &record), ShouldBeNil)
+			}
+			So(ioutil.WriteFile(path, buffer.Bytes(), 0644), ShouldBeNil)
+
+			sessions, err := loginSessions(path)
+
+			Convey("Then I should get the users of the active sessions by pid", func() {
+				So(err, ShouldBeNil)
+				So(sessions, ShouldResemble, map[string]string{"1234": "alice", "1236": "carol"})
+			})
+		})
+
+		Convey("When the records do not exist", func() {
+			_, err := loginSessions(path)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
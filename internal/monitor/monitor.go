@@ -7,7 +7,10 @@ import (
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/cni"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/cri"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/docker"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/external"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/kubernetes"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/linux"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/uid"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/rpc"
@@ -26,6 +29,9 @@ const (
 	LinuxProcess
 	LinuxHost
 	UID
+	Kubernetes
+	External
+	CRI
 )
 
 // Config specifies the configs for monitors.
@@ -130,6 +136,30 @@ func NewMonitors(collector collector.EventCollector, puhandler processor.Process
 			}
 			m.monitors[UID] = mon
 
+		case Kubernetes:
+			mon := kubernetesmonitor.New()
+			mon.SetupHandlers(&c.Common)
+			if err := mon.SetupConfig(nil, v); err != nil {
+				return nil, fmt.Errorf("Kubernetes: %s", err.Error())
+			}
+			m.monitors[Kubernetes] = mon
+
+		case External:
+			mon := externalmonitor.New()
+			mon.SetupHandlers(&c.Common)
+			if err := mon.SetupConfig(nil, v); err != nil {
+				return nil, fmt.Errorf("External: %s", err.Error())
+			}
+			m.monitors[External] = mon
+
+		case CRI:
+			mon := crimonitor.New()
+			mon.SetupHandlers(&c.Common)
+			if err := mon.SetupConfig(nil, v); err != nil {
+				return nil, fmt.Errorf("CRI: %s", err.Error())
+			}
+			m.monitors[CRI] = mon
+
 		default:
 			return nil, fmt.Errorf("Unsupported type %d", k)
 		}
@@ -3,11 +3,13 @@ package eventserver
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/rpc/registerer"
 	"github.com/aporeto-inc/trireme-lib/rpc/events"
 )
@@ -88,6 +90,22 @@ func validateEvent(event *events.EventInfo) error {
 			return fmt.Errorf("Invalid PID - Must be a positive number")
 		}
 
+		if event.NS != "" && !filepath.IsAbs(event.NS) {
+			return fmt.Errorf("Invalid network namespace path - Must be an absolute path")
+		}
+
+		if err := validateServices(event); err != nil {
+			return err
+		}
+
+		if err := validateAutoPort(event); err != nil {
+			return err
+		}
+
+		if event.UserToken != "" && event.PUType != constants.UIDLoginPU {
+			return fmt.Errorf("Invalid user token - Only UID PUs have a user token")
+		}
+
 		if event.HostService {
 			if event.NetworkOnlyTraffic {
 				if event.Name == "" || event.Name == "default" {
@@ -106,7 +124,19 @@ func validateEvent(event *events.EventInfo) error {
 		}
 	}
 
-	if event.EventType == events.EventStop || event.EventType == events.EventDestroy {
+	if event.EventType == events.EventUpdate {
+		if event.PUID == "" && event.Cgroup == "" {
+			return fmt.Errorf("PUID or Cgroup must be provided")
+		}
+
+		for _, tag := range event.Tags {
+			if !strings.Contains(tag, "=") {
+				return fmt.Errorf("Invalid tag %s - Must be of the form key=value", tag)
+			}
+		}
+	}
+
+	if event.EventType == events.EventStop || event.EventType == events.EventDestroy || event.EventType == events.EventUpdate {
 		regStop := regexp.MustCompile("^/trireme/[a-zA-Z0-9_].{0,11}$")
 		if event.Cgroup != "" && !regStop.Match([]byte(event.Cgroup)) {
 			return fmt.Errorf("Cgroup is not of the right format")
@@ -115,3 +145,37 @@ func validateEvent(event *events.EventInfo) error {
 
 	return nil
 }
+
+// validateServices validates the declared services of an event. Services
+// using the deprecated Port field are still accepted, and named services must
+// be known by the host.
+func validateServices(event *events.EventInfo) error {
+
+	for _, s := range event.Services {
+		if s.Protocol != packet.IPProtocolTCP && s.Protocol != packet.IPProtocolUDP {
+			return fmt.Errorf("Invalid service protocol %d - Must be TCP or UDP", s.Protocol)
+		}
+
+		if s.Ports == nil && s.Port == 0 && s.Name == "" {
+			return fmt.Errorf("Invalid service - Ports or name must be provided")
+		}
+
+		if err := s.Resolve(); err != nil {
+			return fmt.Errorf("Invalid service - %s", err)
+		}
+	}
+
+	return nil
+}
+
+// validateAutoPort validates that ports can be discovered for the PU. Ports are
+// discovered for the processes of the cgroup of the PU, and host services are
+// not in their own cgroup.
+func validateAutoPort(event *events.EventInfo) error {
+
+	if event.AutoPort && event.HostService {
+		return fmt.Errorf("Invalid port discovery - Host services must declare their ports")
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package eventserver
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+	"github.com/aporeto-inc/trireme-lib/utils/portspec"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateEvent(t *testing.T) {
+
+	Convey("Given a start event", t, func() {
+
+		ports, _ := portspec.NewPortSpecFromString("80:90", nil) // nolint
+		event := &events.EventInfo{
+			EventType: events.EventStart,
+			PUType:    constants.LinuxProcessPU,
+			Name:      "web",
+			PID:       "1234",
+			Tags:      []string{"app=web"},
+			Services: []policy.Service{
+				policy.Service{
+					Protocol: 6,
+					Ports:    ports,
+				},
+			},
+		}
+
+		Convey("If it only has the legacy fields, it should be valid", func() {
+			So(validateEvent(event), ShouldBeNil)
+			So(event.PUID, ShouldEqual, "1234")
+		})
+
+		Convey("If a service uses the deprecated port field, it should be valid", func() {
+			event.Services = []policy.Service{
+				policy.Service{
+					Protocol: 17,
+					Port:     53,
+				},
+			}
+			So(validateEvent(event), ShouldBeNil)
+		})
+
+		Convey("If a service has no ports, it should be rejected", func() {
+			event.Services[0].Ports = nil
+			So(validateEvent(event), ShouldNotBeNil)
+		})
+
+		Convey("If a service has an unsupported protocol, it should be rejected", func() {
+			event.Services[0].Protocol = 1
+			So(validateEvent(event), ShouldNotBeNil)
+		})
+
+		Convey("If the network namespace path is relative, it should be rejected", func() {
+			event.NS = "netns/web"
+			So(validateEvent(event), ShouldNotBeNil)
+		})
+
+		Convey("If the network namespace path is absolute, it should be valid", func() {
+			event.NS = "/var/run/netns/web"
+			So(validateEvent(event), ShouldBeNil)
+		})
+
+		Convey("If port discovery is requested, it should be valid", func() {
+			event.AutoPort = true
+			So(validateEvent(event), ShouldBeNil)
+		})
+
+		Convey("If port discovery is requested for a host service, it should be rejected", func() {
+			event.AutoPort = true
+			event.HostService = true
+			So(validateEvent(event), ShouldNotBeNil)
+		})
+
+		Convey("If port discovery is requested for a UID PU, it should be valid", func() {
+			event.AutoPort = true
+			event.PUType = constants.UIDLoginPU
+			event.PUID = "web"
+			So(validateEvent(event), ShouldBeNil)
+		})
+
+		Convey("If a host PU has a user token, it should be rejected", func() {
+			event.UserToken = "token"
+			So(validateEvent(event), ShouldNotBeNil)
+		})
+
+		Convey("If a UID PU has a user token, it should be valid", func() {
+			event.UserToken = "token"
+			event.PUType = constants.UIDLoginPU
+			event.PUID = "web"
+			So(validateEvent(event), ShouldBeNil)
+		})
+	})
+}
+
+func TestValidateUpdateEvent(t *testing.T) {
+
+	Convey("Given an update event", t, func() {
+
+		event := &events.EventInfo{
+			EventType: events.EventUpdate,
+			PUID:      "1234",
+			Tags:      []string{"app=db"},
+		}
+
+		Convey("If it is valid, it should be accepted", func() {
+			So(validateEvent(event), ShouldBeNil)
+		})
+
+		Convey("If it has no PUID or cgroup, it should be rejected", func() {
+			event.PUID = ""
+			So(validateEvent(event), ShouldNotBeNil)
+		})
+
+		Convey("If it has an invalid tag, it should be rejected", func() {
+			event.Tags = []string{"app"}
+			So(validateEvent(event), ShouldNotBeNil)
+		})
+
+		Convey("If it has an invalid cgroup, it should be rejected", func() {
+			event.Cgroup = "/invalid/1234"
+			So(validateEvent(event), ShouldNotBeNil)
+		})
+	})
+}
@@ -36,6 +36,7 @@ func (r *registerer) RegisterProcessor(puType constants.PUType, ep processor.Pro
 	r.addHandler(puType, events.EventCreate, ep.Create)
 	r.addHandler(puType, events.EventDestroy, ep.Destroy)
 	r.addHandler(puType, events.EventPause, ep.Pause)
+	r.addHandler(puType, events.EventUpdate, ep.Update)
 	r.addHandler(puType, events.EventResync, ep.ReSync)
 
 	return nil
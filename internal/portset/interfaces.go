@@ -10,6 +10,14 @@ type UserManipulator interface {
 	GetUserMark(mark string) (string, error)
 }
 
+// CgroupManipulator provides a manipulator interface
+// to add/delete cgroups to portset mappings.
+type CgroupManipulator interface {
+	AddCgroupPortSet(cgroup string, portset string, mark string) error
+	DelCgroupPortSet(cgroup string, mark string) error
+	GetCgroupPortSet(cgroup string) (string, error)
+}
+
 // PortManipulator provides a manipulator interface
 // to update user to port mappings.
 type PortManipulator interface {
@@ -21,6 +29,8 @@ type PortManipulator interface {
 type PortSet interface {
 	UserManipulator
 
+	CgroupManipulator
+
 	PortManipulator
 
 	addPortSet(userName string, port string) error
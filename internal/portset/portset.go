@@ -4,12 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"os/user"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
+	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
 	"github.com/aporeto-inc/trireme-lib/utils/portcache"
 	"github.com/bvandewalle/go-ipset/ipset"
 	"go.uber.org/zap"
@@ -17,9 +21,15 @@ import (
 
 const (
 	procNetTCPFile                 = "/proc/net/tcp"
+	procNetTCP6File                = "/proc/net/tcp6"
+	procMountPoint                 = "/proc"
+	cgroupOwnerPrefix              = "@cgroup/"
+	groupOwnerPrefix               = "@group/"
+	socketLinkPrefix               = "socket:["
 	portSetUpdateIntervalinSeconds = 2
 	portEntryTimeout               = 5 * portSetUpdateIntervalinSeconds
 	uidFieldOffset                 = 7
+	inodeFieldOffset               = 9
 	procHeaderLineNum              = 0
 	portOffset                     = 1
 	ipPortOffset                   = 1
@@ -36,6 +46,12 @@ type portSetInstance struct {
 	userPortMap       cache.DataStore
 	markUserMap       cache.DataStore
 	contextIDFromPort *portcache.PortCache
+	// cgroups are the cgroups of the PUs whose ports are discovered
+	cgroups     map[string]bool
+	cgroupsLock sync.Mutex
+	// groups are the groups of the PUs whose ports are discovered
+	groups     map[string]bool
+	groupsLock sync.Mutex
 }
 
 // expirer deletes the port entry in the portset when the key uid:port expires.
@@ -76,6 +92,8 @@ func New(contextIDFromPort *portcache.PortCache) PortSet {
 		userPortMap:       cache.NewCacheWithExpirationNotifier("userPortMap", portEntryTimeout*time.Second, expirer),
 		markUserMap:       cache.NewCache("markUserMap"),
 		contextIDFromPort: contextIDFromPort,
+		cgroups:           map[string]bool{},
+		groups:            map[string]bool{},
 	}
 
 	go startPortSetTask(p)
@@ -110,12 +128,80 @@ func (p *portSetInstance) AddPortToUser(userName string, port string) (bool, err
 // the userName. This gets called during the creation of PU/on reception of application SYN-ACK packet.
 func (p *portSetInstance) AddUserPortSet(userName string, portset string, mark string) (err error) {
 
+	if group, ok := OwnerGroup(userName); ok {
+		p.groupsLock.Lock()
+		p.groups[group] = true
+		p.groupsLock.Unlock()
+	}
+
 	p.userPortSet.AddOrUpdate(userName, portset)
 	p.markUserMap.AddOrUpdate(mark, userName)
 	return nil
 
 }
 
+// cgroupOwner returns the owner of the ports of a cgroup. Cgroups share the
+// lookup tables of the users, the prefix can not be part of a user name.
+func cgroupOwner(cgroup string) string {
+	return cgroupOwnerPrefix + cgroup
+}
+
+// GroupOwner returns the owner of the ports of the members of a group. Groups
+// share the lookup tables of the users, the prefix can not be part of a user
+// name.
+func GroupOwner(group string) string {
+	return groupOwnerPrefix + group
+}
+
+// PUOwner returns the owner of the ports of a UID PU: its user, or its group if
+// it is the PU of the members of a group. It is empty for the other PUs.
+func PUOwner(userID string, groupID string) string {
+
+	if userID != "" || groupID == "" {
+		return userID
+	}
+
+	return GroupOwner(groupID)
+}
+
+// OwnerGroup returns the group of an owner, and false if the owner is not a
+// group.
+func OwnerGroup(owner string) (string, bool) {
+
+	if !strings.HasPrefix(owner, groupOwnerPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(owner, groupOwnerPrefix), true
+}
+
+// AddCgroupPortSet registers the portset of a cgroup based PU. The listening
+// ports of the processes of the cgroup are programmed in the portset.
+func (p *portSetInstance) AddCgroupPortSet(cgroup string, portset string, mark string) error {
+
+	p.cgroupsLock.Lock()
+	p.cgroups[cgroup] = true
+	p.cgroupsLock.Unlock()
+
+	return p.AddUserPortSet(cgroupOwner(cgroup), portset, mark)
+}
+
+// DelCgroupPortSet deletes the cgroup and mark entries from caches.
+func (p *portSetInstance) DelCgroupPortSet(cgroup string, mark string) error {
+
+	p.cgroupsLock.Lock()
+	delete(p.cgroups, cgroup)
+	p.cgroupsLock.Unlock()
+
+	return p.DelUserPortSet(cgroupOwner(cgroup), mark)
+}
+
+// GetCgroupPortSet returns the portset associated with a cgroup.
+func (p *portSetInstance) GetCgroupPortSet(cgroup string) (string, error) {
+
+	return p.getUserPortSet(cgroupOwner(cgroup))
+}
+
 // getUserPortSet returns the portset associated with user.
 func (p *portSetInstance) getUserPortSet(userName string) (string, error) {
 
@@ -135,6 +221,12 @@ func (p *portSetInstance) getUserPortSet(userName string) (string, error) {
 // DelUserPortSet deletes user and mark entries from caches.
 func (p *portSetInstance) DelUserPortSet(userName string, mark string) (err error) {
 
+	if group, ok := OwnerGroup(userName); ok {
+		p.groupsLock.Lock()
+		delete(p.groups, group)
+		p.groupsLock.Unlock()
+	}
+
 	if err = p.userPortSet.Remove(userName); err != nil {
 		return fmt.Errorf("unable to remove uid from portset cache: %s", err)
 	}
@@ -204,8 +296,8 @@ func (p *portSetInstance) deletePortSet(userName string, port string) error {
 	return nil
 }
 
-// startPortSetTask is a go routine that periodically scans /proc/net/tcp file
-// for listening ports and programs the portsets. This worker thread is setup
+// startPortSetTask is a go routine that periodically scans the /proc/net/tcp
+// and /proc/net/tcp6 files for listening ports and programs the portsets. This worker thread is setup
 // during datapath initilisation.
 func startPortSetTask(p *portSetInstance) {
 
@@ -218,14 +310,32 @@ func startPortSetTask(p *portSetInstance) {
 
 func (p *portSetInstance) updateIPPortSets() {
 
-	buffer, err := ioutil.ReadFile(procNetTCPFile)
-	if err != nil {
-		zap.L().Debug("Failed to read /proc/net/tcp file", zap.Error(err))
-		// This is a go routine, cannot return error
-		return
+	// listening maps the socket inodes to the listening ports
+	listening := map[string]string{}
+
+	// members maps the uids to the groups of PUs they are members of
+	members := map[string][]string{}
+	groups := p.groupNames()
+
+	for _, file := range []string{procNetTCPFile, procNetTCP6File} {
+
+		buffer, err := ioutil.ReadFile(file)
+		if err != nil {
+			// The tcp6 file is missing when IPv6 is disabled
+			zap.L().Debug("Failed to read sockets file", zap.String("file", file), zap.Error(err))
+			continue
+		}
+
+		p.updateListeningPorts(string(buffer), listening, members, groups)
 	}
 
-	s := string(buffer)
+	p.updateCgroupPortSets(listening)
+}
+
+// updateListeningPorts programs the listening ports of the sockets of a
+// /proc/net/tcp or /proc/net/tcp6 file in the portsets of their users and
+// groups, and records the ports of the socket inodes in listening.
+func (p *portSetInstance) updateListeningPorts(s string, listening map[string]string, members map[string][]string, groups map[string]bool) {
 
 	for cnt, line := range strings.Split(s, "\n") {
 
@@ -255,6 +365,12 @@ func (p *portSetInstance) updateIPPortSets() {
 			continue
 		}
 
+		port = strconv.Itoa(int(portNum))
+
+		if len(line) > inodeFieldOffset {
+			listening[line[inodeFieldOffset]] = port
+		}
+
 		// /proc/net/tcp file contains uid. Conversion to
 		// userName is required as they are keys to lookup tables.
 		userName, err := getUserName(uid)
@@ -263,20 +379,136 @@ func (p *portSetInstance) updateIPPortSets() {
 			continue
 		}
 
-		port = strconv.Itoa(int(portNum))
-		portKey := userName + ":" + port
+		p.addDiscoveredPort(userName, port)
 
-		// check if username corresponds to a valid uidloginpu
-		if _, err = p.userPortSet.Get(userName); err != nil {
+		if len(groups) == 0 {
 			continue
 		}
 
-		if updated := p.userPortMap.AddOrUpdate(portKey, p); updated {
+		if _, ok := members[uid]; !ok {
+			members[uid] = memberOf(uid, groups)
+		}
+
+		for _, group := range members[uid] {
+			p.addDiscoveredPort(GroupOwner(group), port)
+		}
+	}
+}
+
+// groupNames returns the groups of the PUs whose ports are discovered
+func (p *portSetInstance) groupNames() map[string]bool {
+
+	p.groupsLock.Lock()
+	defer p.groupsLock.Unlock()
+
+	groups := make(map[string]bool, len(p.groups))
+	for group := range p.groups {
+		groups[group] = true
+	}
+
+	return groups
+}
+
+// memberOf returns the groups that a user belongs to, as its primary group or
+// one of its supplementary groups.
+func memberOf(uid string, groups map[string]bool) []string {
+
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return nil
+	}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		gids = []string{u.Gid}
+	}
+
+	member := []string{}
+	for _, gid := range gids {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		if groups[g.Name] {
+			member = append(member, g.Name)
+		}
+	}
+
+	return member
+}
+
+// updateCgroupPortSets programs the portsets of the cgroups with the listening
+// ports of their processes.
+func (p *portSetInstance) updateCgroupPortSets(listening map[string]string) {
+
+	p.cgroupsLock.Lock()
+	cgroups := make([]string, 0, len(p.cgroups))
+	for cgroup := range p.cgroups {
+		cgroups = append(cgroups, cgroup)
+	}
+	p.cgroupsLock.Unlock()
+
+	if len(cgroups) == 0 || len(listening) == 0 {
+		return
+	}
+
+	for _, cgroup := range cgroups {
+
+		pids, err := cgnetcls.ListCgroupProcesses(cgroup)
+		if err != nil {
+			zap.L().Debug("Unable to list the processes of the cgroup", zap.String("cgroup", cgroup), zap.Error(err))
+			continue
+		}
+
+		for _, inode := range socketInodes(procMountPoint, pids) {
+			if port, ok := listening[inode]; ok {
+				p.addDiscoveredPort(cgroupOwner(cgroup), port)
+			}
+		}
+	}
+}
+
+// addDiscoveredPort programs a listening port in the portset of its owner.
+func (p *portSetInstance) addDiscoveredPort(owner string, port string) {
+
+	portKey := owner + ":" + port
+
+	// check if the owner corresponds to a PU with port discovery
+	if _, err := p.userPortSet.Get(owner); err != nil {
+		return
+	}
+
+	if updated := p.userPortMap.AddOrUpdate(portKey, p); updated {
+		return
+	}
+
+	if err := p.addPortSet(owner, port); err != nil {
+		zap.L().Debug("Unable to add port to portset ", zap.Error(err))
+	}
+}
+
+// socketInodes returns the inodes of the sockets opened by the processes.
+func socketInodes(procRoot string, pids []string) []string {
+
+	inodes := []string{}
+
+	for _, pid := range pids {
+
+		fdPath := filepath.Join(procRoot, pid, "fd")
+		fds, err := ioutil.ReadDir(fdPath)
+		if err != nil {
 			continue
 		}
 
-		if err = p.addPortSet(userName, port); err != nil {
-			zap.L().Debug("Unable to add port to portset ", zap.Error(err))
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdPath, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, socketLinkPrefix) {
+				continue
+			}
+
+			inodes = append(inodes, strings.TrimSuffix(strings.TrimPrefix(link, socketLinkPrefix), "]"))
 		}
 	}
+
+	return inodes
 }
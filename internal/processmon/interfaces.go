@@ -1,10 +1,20 @@
 package processmon
 
-import "github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+import (
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
 
 // ProcessManager interface exposes methods implemented by a processmon
 type ProcessManager interface {
 	KillProcess(contextID string)
 	LaunchProcess(contextID string, refPid int, refNsPath string, rpchdl rpcwrapper.RPCClient, arg string, statssecret string, procMountPoint string) error
 	SetLogParameters(logToConsole, logWithID bool, logLevel string, logFormat string)
+	SetLogDirectory(dir string)
+	SetLogForwarding(level string) error
+	SetStatsParameters(interval time.Duration, sampling int)
+	SetStatsBuffer(dir string, size int64)
+	SetResourceLimits(memory int64, cpuQuota float64, oomScoreAdj int) error
+	SetHardening(enable bool)
 }
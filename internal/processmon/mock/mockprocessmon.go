@@ -6,6 +6,7 @@ package mockprocessmon
 
 import (
 	reflect "reflect"
+	time "time"
 
 	rpcwrapper "github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
 	gomock "github.com/golang/mock/gomock"
@@ -75,3 +76,79 @@ func (m *MockProcessManager) SetLogParameters(logToConsole, logWithID bool, logL
 func (mr *MockProcessManagerMockRecorder) SetLogParameters(logToConsole, logWithID, logLevel, logFormat interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogParameters", reflect.TypeOf((*MockProcessManager)(nil).SetLogParameters), logToConsole, logWithID, logLevel, logFormat)
 }
+
+// SetLogDirectory mocks base method
+// nolint
+func (m *MockProcessManager) SetLogDirectory(dir string) {
+	m.ctrl.Call(m, "SetLogDirectory", dir)
+}
+
+// SetLogDirectory indicates an expected call of SetLogDirectory
+// nolint
+func (mr *MockProcessManagerMockRecorder) SetLogDirectory(dir interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogDirectory", reflect.TypeOf((*MockProcessManager)(nil).SetLogDirectory), dir)
+}
+
+// SetStatsParameters mocks base method
+// nolint
+func (m *MockProcessManager) SetStatsParameters(interval time.Duration, sampling int) {
+	m.ctrl.Call(m, "SetStatsParameters", interval, sampling)
+}
+
+// SetStatsParameters indicates an expected call of SetStatsParameters
+// nolint
+func (mr *MockProcessManagerMockRecorder) SetStatsParameters(interval, sampling interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetStatsParameters", reflect.TypeOf((*MockProcessManager)(nil).SetStatsParameters), interval, sampling)
+}
+
+// SetStatsBuffer mocks base method
+// nolint
+func (m *MockProcessManager) SetStatsBuffer(dir string, size int64) {
+	m.ctrl.Call(m, "SetStatsBuffer", dir, size)
+}
+
+// SetStatsBuffer indicates an expected call of SetStatsBuffer
+// nolint
+func (mr *MockProcessManagerMockRecorder) SetStatsBuffer(dir, size interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetStatsBuffer", reflect.TypeOf((*MockProcessManager)(nil).SetStatsBuffer), dir, size)
+}
+
+// SetResourceLimits mocks base method
+// nolint
+func (m *MockProcessManager) SetResourceLimits(memory int64, cpuQuota float64, oomScoreAdj int) error {
+	ret := m.ctrl.Call(m, "SetResourceLimits", memory, cpuQuota, oomScoreAdj)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetResourceLimits indicates an expected call of SetResourceLimits
+// nolint
+func (mr *MockProcessManagerMockRecorder) SetResourceLimits(memory, cpuQuota, oomScoreAdj interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetResourceLimits", reflect.TypeOf((*MockProcessManager)(nil).SetResourceLimits), memory, cpuQuota, oomScoreAdj)
+}
+
+// SetHardening mocks base method
+// nolint
+func (m *MockProcessManager) SetHardening(enable bool) {
+	m.ctrl.Call(m, "SetHardening", enable)
+}
+
+// SetHardening indicates an expected call of SetHardening
+// nolint
+func (mr *MockProcessManagerMockRecorder) SetHardening(enable interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHardening", reflect.TypeOf((*MockProcessManager)(nil).SetHardening), enable)
+}
+
+// SetLogForwarding mocks base method
+// nolint
+func (m *MockProcessManager) SetLogForwarding(level string) error {
+	ret := m.ctrl.Call(m, "SetLogForwarding", level)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLogForwarding indicates an expected call of SetLogForwarding
+// nolint
+func (mr *MockProcessManagerMockRecorder) SetLogForwarding(level interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogForwarding", reflect.TypeOf((*MockProcessManager)(nil).SetLogForwarding), level)
+}
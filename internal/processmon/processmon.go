@@ -3,17 +3,22 @@ package processmon
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
@@ -33,6 +38,8 @@ const (
 	netNSPath               = "/var/run/netns/"
 	processMonitorCacheName = "ProcessMonitorCache"
 	secretLength            = 32
+	// remoteLogFileSuffix is the suffix of the files persisting remote enforcer output
+	remoteLogFileSuffix = ".log"
 )
 
 // processMon is an instance of processMonitor
@@ -40,6 +47,10 @@ type processMon struct {
 	// netNSPath made configurable to enable running tests
 	netNSPath       string
 	activeProcesses *cache.Cache
+	// namespaces holds the remote enforcers by the inode of their network
+	// namespace, so that the PUs of a namespace share the same process
+	namespaces      map[uint64]*processInfo
+	namespacesLock  sync.Mutex
 	childExitStatus chan exitStatus
 	// logToConsole stores if we should log to console.
 	logToConsole bool
@@ -48,13 +59,40 @@ type processMon struct {
 	// logLevel is the level of logs for remote command.
 	logLevel  string
 	logFormat string
+	// logDir is the directory where the output of remote enforcers is persisted.
+	// Output is not persisted if empty.
+	logDir string
+	// statsInterval is the interval at which the remote enforcers aggregate
+	// the flows. The default interval is used if it is zero.
+	statsInterval time.Duration
+	// statsSampling is the rate at which the remote enforcers sample the flow
+	// events. The flow events are not sampled if it is zero.
+	statsSampling int
+	// statsBufferDir is the directory where the remote enforcers buffer the
+	// stats that cannot be sent, each in the directory of its context. The
+	// stats are not buffered if it is empty.
+	statsBufferDir  string
+	statsBufferSize int64
+	// logForwardLevel is the level of the logs the remote enforcers forward
+	// to the controller. The logs are not forwarded if it is empty.
+	logForwardLevel string
+	// limits are the limits of the resources of the remote enforcers
+	limits resourceLimits
+	// hardening drops the capabilities of the remote enforcers and filters
+	// their syscalls
+	hardening bool
 }
 
-// processInfo stores per process information
+// processInfo stores per process information. The process is launched for
+// the PU of contextID and is shared by all the PUs of its network namespace.
 type processInfo struct {
 	contextID string
 	RPCHdl    rpcwrapper.RPCClient
 	process   *os.Process
+	netns     uint64
+	secret    string
+	// contexts are the PUs using the process
+	contexts map[string]bool
 }
 
 // exitStatus captures the exit status of a process
@@ -81,19 +119,79 @@ func contextID2SocketPath(contextID string) string {
 	return filepath.Join("/var/run/", contextID+".sock")
 }
 
-// processIOReader will read from a reader and print it on the calling process
-func processIOReader(fd io.Reader, contextID string, exited chan int) {
+// remoteOutputLog persists the output of a remote enforcer. It is shared
+// between the stdout and stderr readers of a process.
+type remoteOutputLog struct {
+	file *os.File
+	sync.Mutex
+}
+
+// newRemoteOutputLog opens the log file for the given context in dir.
+func newRemoteOutputLog(dir string, contextID string) (*remoteOutputLog, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, contextID+remoteLogFileSuffix), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteOutputLog{file: f}, nil
+}
+
+// write appends a line of output to the log file
+func (r *remoteOutputLog) write(stream string, line string) {
+
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, err := fmt.Fprintf(r.file, "%s [%s] %s\n", time.Now().Format(time.RFC3339), stream, line); err != nil {
+		zap.L().Debug("Unable to persist remote enforcer output", zap.Error(err))
+	}
+}
+
+// close closes the underlying log file
+func (r *remoteOutputLog) close() {
+
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.file.Close(); err != nil {
+		zap.L().Debug("Unable to close remote enforcer log", zap.Error(err))
+	}
+}
+
+// processIOReader will read from a reader and forward every line to the
+// controller log. If persist is not nil, the output is also written to a file.
+func processIOReader(fd io.Reader, contextID string, stream string, persist *remoteOutputLog, exited chan int) {
 
 	reader := bufio.NewReader(fd)
 
 	for {
 		str, err := reader.ReadString('\n')
+		if line := strings.TrimRight(str, "\r\n"); line != "" {
+			zap.L().Info("Remote enforcer output",
+				zap.String("contextID", contextID),
+				zap.String("stream", stream),
+				zap.String("output", line),
+			)
+			persist.write(stream, line)
+		}
+
 		if err != nil {
 			exited <- 1
 			return
 		}
-
-		fmt.Print("[" + contextID + "]:" + str)
 	}
 }
 
@@ -103,6 +201,7 @@ func newProcessMon(netns string) ProcessManager {
 	launcher = &processMon{
 		netNSPath:       netns,
 		activeProcesses: cache.NewCache(processMonitorCacheName),
+		namespaces:      map[uint64]*processInfo{},
 		childExitStatus: make(chan exitStatus, 100),
 	}
 
@@ -140,7 +239,80 @@ func (p *processMon) SetLogParameters(logToConsole, logWithID bool, logLevel str
 	p.logFormat = logFormat
 }
 
-// KillProcess sends a rpc to the process to exit failing which it will kill the process
+// SetLogDirectory sets the directory where the output of remote enforcers
+// is persisted. An empty directory disables persistence.
+func (p *processMon) SetLogDirectory(dir string) {
+
+	p.logDir = dir
+}
+
+// SetStatsParameters sets the interval at which the remote enforcers aggregate
+// the flows before they report them, and the rate at which they sample the flow
+// events, like 10 to keep one in ten. The defaults are used if they are zero.
+func (p *processMon) SetStatsParameters(interval time.Duration, sampling int) {
+
+	p.statsInterval = interval
+	p.statsSampling = sampling
+}
+
+// SetLogForwarding sets the level of the logs the remote enforcers forward to
+// the controller, like info. The logs are not forwarded if it is empty.
+func (p *processMon) SetLogForwarding(level string) error {
+
+	if level != "" {
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid log forwarding level %s", level)
+		}
+	}
+
+	p.logForwardLevel = level
+
+	return nil
+}
+
+// SetHardening sets if the remote enforcers keep only the capabilities
+// CAP_NET_ADMIN and CAP_NET_RAW, and filter the syscalls they do not need
+// once they are initialized
+func (p *processMon) SetHardening(enable bool) {
+
+	p.hardening = enable
+}
+
+// SetStatsBuffer sets the directory where the remote enforcers buffer the
+// stats that cannot be sent, and the maximum size in bytes of the stats
+// buffered by each of them. The default size is used if it is zero, and the
+// stats are not buffered if the directory is empty.
+func (p *processMon) SetStatsBuffer(dir string, size int64) {
+
+	p.statsBufferDir = dir
+	p.statsBufferSize = size
+}
+
+// ProcessContexts returns the PUs that share the remote enforcer of a PU,
+// including the PU itself, or nil if it has no remote enforcer
+func (p *processMon) ProcessContexts(contextID string) []string {
+
+	s, err := p.activeProcesses.Get(contextID)
+	if err != nil {
+		return nil
+	}
+
+	p.namespacesLock.Lock()
+	defer p.namespacesLock.Unlock()
+
+	contexts := []string{}
+	for c := range s.(*processInfo).contexts {
+		contexts = append(contexts, c)
+	}
+	sort.Strings(contexts)
+
+	return contexts
+}
+
+// KillProcess sends a rpc to the process to exit failing which it will kill
+// the process. The process is only killed with the last PU that uses it: the
+// other PUs are removed from the remote enforcer.
 func (p *processMon) KillProcess(contextID string) {
 
 	s, err := p.activeProcesses.Get(contextID)
@@ -154,34 +326,77 @@ func (p *processMon) KillProcess(contextID string) {
 		return
 	}
 
+	p.namespacesLock.Lock()
+	delete(procInfo.contexts, contextID)
+	shared := len(procInfo.contexts) > 0
+	if !shared && p.namespaces[procInfo.netns] == procInfo {
+		delete(p.namespaces, procInfo.netns)
+	}
+	p.namespacesLock.Unlock()
+
+	if shared {
+		p.leaveProcess(contextID, procInfo)
+		return
+	}
+
 	req := &rpcwrapper.Request{}
 	resp := &rpcwrapper.Response{}
 	req.Payload = procInfo.process.Pid
 
-	c := make(chan error, 1)
-	go func() {
-		c <- procInfo.RPCHdl.RemoteCall(contextID, remoteenforcer.EnforcerExit, req, resp)
-	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	select {
-	case err := <-c:
-		if err != nil {
-			zap.L().Debug("Failed to stop gracefully",
-				zap.String("Remote error", err.Error()))
-		}
+	if err := procInfo.RPCHdl.RemoteCall(ctx, contextID, remoteenforcer.EnforcerExit, req, resp); err != nil {
+		zap.L().Debug("Failed to stop gracefully",
+			zap.String("Remote error", err.Error()))
+	}
 
-		if err := procInfo.process.Kill(); err != nil {
-			zap.L().Debug("Process is already dead",
-				zap.String("Kill error", err.Error()))
-		}
+	if err := procInfo.process.Kill(); err != nil {
+		zap.L().Debug("Process is already dead",
+			zap.String("Kill error", err.Error()))
+	}
+
+	p.removeContext(contextID, procInfo)
+}
+
+// leaveProcess removes a PU from a remote enforcer that is still used by
+// other PUs of its network namespace
+func (p *processMon) leaveProcess(contextID string, procInfo *processInfo) {
+
+	zap.L().Debug("Removing PU from shared remote enforcer",
+		zap.String("contextID", contextID),
+		zap.String("processContextID", procInfo.contextID),
+	)
+
+	calls := []struct {
+		method  string
+		payload interface{}
+	}{
+		{method: remoteenforcer.Unsupervise, payload: &rpcwrapper.UnSupervisePayload{ContextID: contextID}},
+		{method: remoteenforcer.Unenforce, payload: &rpcwrapper.UnEnforcePayload{ContextID: contextID}},
+	}
 
-	case <-time.After(5 * time.Second):
-		if err := procInfo.process.Kill(); err != nil {
-			zap.L().Info("Time out while killing process ",
-				zap.Error(err))
+	for _, call := range calls {
+		req := &rpcwrapper.Request{Payload: call.payload}
+		ctx, cancel := context.WithTimeout(context.Background(), rpcwrapper.DefaultCallTimeout)
+		err := procInfo.RPCHdl.RemoteCall(ctx, contextID, call.method, req, &rpcwrapper.Response{})
+		cancel()
+		if err != nil {
+			zap.L().Debug("Unable to remove PU from shared remote enforcer",
+				zap.String("contextID", contextID),
+				zap.String("method", call.method),
+				zap.Error(err),
+			)
 		}
 	}
 
+	p.removeContext(contextID, procInfo)
+}
+
+// removeContext removes the RPC client, the netns link and the cache entry
+// of a PU
+func (p *processMon) removeContext(contextID string, procInfo *processInfo) {
+
 	procInfo.RPCHdl.DestroyRPCClient(contextID)
 	if err := os.Remove(filepath.Join(p.netNSPath, contextID)); err != nil {
 		zap.L().Warn("Failed to remote process from netns path", zap.Error(err))
@@ -192,11 +407,31 @@ func (p *processMon) KillProcess(contextID string) {
 	}
 }
 
+// shareProcess adds a PU to the remote enforcer launched in its network
+// namespace for another PU. The namespaces must be locked.
+func (p *processMon) shareProcess(contextID string, procInfo *processInfo, rpchdl rpcwrapper.RPCClient) error {
+
+	if err := rpchdl.NewRPCClient(contextID, contextID2SocketPath(procInfo.contextID), procInfo.secret); err != nil {
+		return err
+	}
+
+	procInfo.contexts[contextID] = true
+	p.activeProcesses.AddOrUpdate(contextID, procInfo)
+
+	zap.L().Debug("Sharing remote enforcer of network namespace",
+		zap.String("contextID", contextID),
+		zap.String("processContextID", procInfo.contextID),
+	)
+
+	return nil
+}
+
 // pollStdOutAndErr polls std out and err
 func (p *processMon) pollStdOutAndErr(
 	cmd *exec.Cmd,
 	exited chan int,
 	contextID string,
+	persist *remoteOutputLog,
 ) (initializedCount int, err error) {
 
 	stdout, err := cmd.StdoutPipe()
@@ -214,8 +449,8 @@ func (p *processMon) pollStdOutAndErr(
 	initializedCount++
 
 	// Stdout/err processing
-	go processIOReader(stdout, contextID, exited)
-	go processIOReader(stderr, contextID, exited)
+	go processIOReader(stdout, contextID, "stdout", persist, exited)
+	go processIOReader(stderr, contextID, "stderr", persist, exited)
 
 	return initializedCount, nil
 }
@@ -250,6 +485,7 @@ func (p *processMon) getLaunchProcessEnvVars(
 	newEnvVars := []string{
 		constants.AporetoEnvMountPoint + "=" + procMountPoint,
 		constants.AporetoEnvContextSocket + "=" + contextID2SocketPath(contextID),
+		constants.AporetoEnvContextID + "=" + contextID,
 		constants.AporetoEnvStatsChannel + "=" + rpcwrapper.StatsChannel,
 		constants.AporetoEnvRPCClientSecret + "=" + randomkeystring,
 		constants.AporetoEnvStatsSecret + "=" + statsServerSecret,
@@ -264,6 +500,34 @@ func (p *processMon) getLaunchProcessEnvVars(
 		newEnvVars = append(newEnvVars, constants.AporetoEnvLogID+"="+contextID)
 	}
 
+	if p.logForwardLevel != "" {
+		newEnvVars = append(newEnvVars, constants.AporetoEnvLogForwardLevel+"="+p.logForwardLevel)
+	}
+
+	if p.statsInterval > 0 {
+		newEnvVars = append(newEnvVars, constants.AporetoEnvStatsInterval+"="+p.statsInterval.String())
+	}
+
+	if p.statsSampling > 0 {
+		newEnvVars = append(newEnvVars, constants.AporetoEnvStatsSampling+"="+strconv.Itoa(p.statsSampling))
+	}
+
+	if p.statsBufferDir != "" {
+		newEnvVars = append(newEnvVars, constants.AporetoEnvStatsBufferDir+"="+filepath.Join(p.statsBufferDir, contextID))
+		if p.statsBufferSize > 0 {
+			newEnvVars = append(newEnvVars, constants.AporetoEnvStatsBufferSize+"="+strconv.FormatInt(p.statsBufferSize, 10))
+		}
+	}
+
+	if p.hardening {
+		newEnvVars = append(newEnvVars, constants.AporetoEnvHardening+"="+constants.AporetoEnvHardeningEnable)
+	}
+
+	// The crash reports are written with the output of the remote enforcers
+	if p.logDir != "" {
+		newEnvVars = append(newEnvVars, constants.AporetoEnvCrashDir+"="+p.logDir)
+	}
+
 	// If the PURuntime Specified a NSPath, then it is added as a new env var also.
 	if refNSPath != "" {
 		newEnvVars = append(newEnvVars, constants.AporetoEnvNSPath+"="+refNSPath)
@@ -304,7 +568,8 @@ func (p *processMon) LaunchProcess(
 		return fmt.Errorf("container pid %d not found: %s", refPid, err)
 	}
 
-	if pidstat.Sys().(*syscall.Stat_t).Ino == hoststat.Sys().(*syscall.Stat_t).Ino {
+	netns := pidstat.Sys().(*syscall.Stat_t).Ino
+	if netns == hoststat.Sys().(*syscall.Stat_t).Ino {
 		return errors.New("refused to launch a remote enforcer in host namespace")
 	}
 
@@ -323,19 +588,45 @@ func (p *processMon) LaunchProcess(
 		}
 	}
 
+	p.namespacesLock.Lock()
+	defer p.namespacesLock.Unlock()
+
+	// The PUs of a namespace, like the containers of a pod, share its
+	// remote enforcer
+	if procInfo, ok := p.namespaces[netns]; ok {
+		if err := p.shareProcess(contextID, procInfo, rpchdl); err != nil {
+			if err1 := os.Remove(contextFile); err1 != nil {
+				zap.L().Warn("Failed to clean up netns path", zap.Error(err1))
+			}
+			return err
+		}
+		return nil
+	}
+
 	cmd, err := p.getLaunchProcessCmd(arg)
 	if err != nil {
 		return fmt.Errorf("enforcer binary not found: %s", err)
 	}
 
-	exited := make(chan int, 2)
-	waitForExitCount := 0
-	if p.logToConsole {
-		if waitForExitCount, err = p.pollStdOutAndErr(cmd, exited, contextID); err != nil {
-			return err
+	var persist *remoteOutputLog
+	if p.logDir != "" {
+		if persist, err = newRemoteOutputLog(p.logDir, contextID); err != nil {
+			zap.L().Warn("Unable to persist remote enforcer output",
+				zap.String("contextID", contextID),
+				zap.Error(err),
+			)
 		}
 	}
 
+	// Output of the remote enforcer is always captured so that crashes that
+	// happen before its own logger is setup are never lost.
+	exited := make(chan int, 2)
+	waitForExitCount, err := p.pollStdOutAndErr(cmd, exited, contextID, persist)
+	if err != nil {
+		persist.close()
+		return err
+	}
+
 	randomkeystring, err := crypto.GenerateRandomString(secretLength)
 	if err != nil {
 		// This is a more serious failure. We can't reliably control the remote enforcer
@@ -357,14 +648,25 @@ func (p *processMon) LaunchProcess(
 		if err1 := os.Remove(contextFile); err1 != nil {
 			zap.L().Warn("Failed to clean up netns path", zap.Error(err1))
 		}
+		persist.close()
 		return fmt.Errorf("unable to start enforcer binary: %s", err)
 	}
 
+	cgroups, err := p.limitResources(contextID, cmd.Process.Pid, procMountPoint)
+	if err != nil {
+		zap.L().Warn("Unable to limit the resources of the remote enforcer",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+	}
+
 	go func() {
 		for i := 0; i < waitForExitCount; i++ {
 			<-exited
 		}
+		persist.close()
 		status := cmd.Wait()
+		removeCgroups(cgroups)
 		p.childExitStatus <- exitStatus{
 			process:    cmd.Process.Pid,
 			contextID:  contextID,
@@ -376,10 +678,16 @@ func (p *processMon) LaunchProcess(
 		return err
 	}
 
-	p.activeProcesses.AddOrUpdate(contextID, &processInfo{
+	procInfo := &processInfo{
 		contextID: contextID,
 		process:   cmd.Process,
-		RPCHdl:    rpchdl})
+		RPCHdl:    rpchdl,
+		netns:     netns,
+		secret:    randomkeystring,
+		contexts:  map[string]bool{contextID: true},
+	}
+	p.namespaces[netns] = procInfo
+	p.activeProcesses.AddOrUpdate(contextID, procInfo)
 
 	return nil
 }
@@ -3,8 +3,10 @@ package processmon
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,6 +16,7 @@ import (
 	"testing"
 
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+	"github.com/aporeto-inc/trireme-lib/internal/remoteenforcer"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 )
 
@@ -87,7 +90,7 @@ func TestLaunchProcess(t *testing.T) {
 		t.Errorf("TEST:Launch Process Fails to launch a process")
 	}
 	//Cleanup
-	rpchdl.MockRemoteCall(t, func(passed_contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	rpchdl.MockRemoteCall(t, func(ctx context.Context, passed_contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) error {
 		return errors.New("null error")
 	})
 	p.KillProcess(contextID)
@@ -138,7 +141,7 @@ func TestKillProcess(t *testing.T) {
 	if err := p.LaunchProcess(contextID, refPid, refNSPath, rpchdl, "", "mysecret", testDirBase); err != nil {
 		t.Errorf("Failed to launch process  %s", err.Error())
 	}
-	rpchdl.MockRemoteCall(t, func(passed_contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	rpchdl.MockRemoteCall(t, func(ctx context.Context, passed_contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) error {
 		calledRemoteCall = true
 		return errors.New("null error")
 	})
@@ -159,3 +162,174 @@ func TestGetProcessManagerHdl(t *testing.T) {
 		t.Errorf("ProcessManagerhandle don't match with cache")
 	}
 }
+
+func TestSharedProcess(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "processmon")
+	if err != nil {
+		t.Fatalf("TEST:Setup failed %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	p := newProcessMon(dir).(*processMon)
+	rpchdl := rpcwrapper.NewTestRPCClient()
+
+	procInfo := &processInfo{
+		contextID: "pod",
+		RPCHdl:    rpchdl,
+		netns:     4026532000,
+		secret:    "mysecret",
+		contexts:  map[string]bool{"pod": true},
+	}
+	p.namespaces[procInfo.netns] = procInfo
+	p.activeProcesses.AddOrUpdate("pod", procInfo)
+
+	channel := ""
+	rpchdl.MockNewRPCClient(t, func(contextID string, c string, secret string) error {
+		channel = c
+		return nil
+	})
+
+	//A PU of the same namespace should use the channel of the process
+	if err := p.shareProcess("sidecar", procInfo, rpchdl); err != nil {
+		t.Fatalf("TEST:Unable to share process %s", err)
+	}
+	if channel != contextID2SocketPath("pod") {
+		t.Errorf("TEST:Shared process uses channel %s", channel)
+	}
+	if contexts := p.ProcessContexts("sidecar"); !reflect.DeepEqual(contexts, []string{"pod", "sidecar"}) {
+		t.Errorf("TEST:Unexpected contexts of shared process %v", contexts)
+	}
+
+	//Killing a PU that shares the process should only remove it from the remote enforcer
+	methods := []string{}
+	rpchdl.MockRemoteCall(t, func(ctx context.Context, contextID string, methodName string, req *rpcwrapper.Request, resp *rpcwrapper.Response) error {
+		if contextID != "sidecar" {
+			t.Errorf("TEST:Unexpected call for %s", contextID)
+		}
+		methods = append(methods, methodName)
+		return nil
+	})
+	p.KillProcess("sidecar")
+
+	if !reflect.DeepEqual(methods, []string{remoteenforcer.Unsupervise, remoteenforcer.Unenforce}) {
+		t.Errorf("TEST:Unexpected calls %v", methods)
+	}
+	if contexts := p.ProcessContexts("sidecar"); contexts != nil {
+		t.Errorf("TEST:Killed PU still uses the process %v", contexts)
+	}
+	if contexts := p.ProcessContexts("pod"); !reflect.DeepEqual(contexts, []string{"pod"}) {
+		t.Errorf("TEST:Unexpected contexts of process %v", contexts)
+	}
+	if _, ok := p.namespaces[procInfo.netns]; !ok {
+		t.Errorf("TEST:Process of the namespace was removed")
+	}
+}
+
+func TestProcessIOReader(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "processmon")
+	if err != nil {
+		t.Fatalf("TEST:Setup failed %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	persist, err := newRemoteOutputLog(dir, "12345")
+	if err != nil {
+		t.Fatalf("TEST:Unable to create output log %s", err)
+	}
+
+	exited := make(chan int, 1)
+	processIOReader(strings.NewReader("first line\nsecond line"), "12345", "stderr", persist, exited)
+	persist.close()
+
+	if len(exited) != 1 {
+		t.Errorf("TEST:Reader did not signal exit")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "12345"+remoteLogFileSuffix))
+	if err != nil {
+		t.Fatalf("TEST:Output was not persisted %s", err)
+	}
+
+	if !strings.Contains(string(data), "[stderr] first line") || !strings.Contains(string(data), "[stderr] second line") {
+		t.Errorf("TEST:Persisted output does not match %s", string(data))
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "processmon")
+	if err != nil {
+		t.Fatalf("TEST:Setup failed %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	defer func(root string) { cgroupRoot = root }(cgroupRoot)
+	cgroupRoot = filepath.Join(dir, "cgroup")
+
+	p := newProcessMon(dir).(*processMon)
+
+	//Invalid limits should be rejected
+	if err := p.SetResourceLimits(-1, 0, 0); err == nil {
+		t.Errorf("TEST:Negative memory limit was accepted")
+	}
+	if err := p.SetResourceLimits(0, 0, 1001); err == nil {
+		t.Errorf("TEST:Out of range oom score adjustment was accepted")
+	}
+
+	if err := p.SetResourceLimits(64<<20, 0.5, 500); err != nil {
+		t.Fatalf("TEST:Unable to set resource limits %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "1234"), 0755); err != nil {
+		t.Fatalf("TEST:Setup failed %s", err)
+	}
+
+	readFile := func(path ...string) string {
+		data, err := ioutil.ReadFile(filepath.Join(path...))
+		if err != nil {
+			t.Errorf("TEST:Unable to read %s", err)
+		}
+		return string(data)
+	}
+
+	//The remote enforcer should be placed in the cgroups of the controllers
+	cgroups, err := p.limitResources("pu1", 1234, dir)
+	if err != nil {
+		t.Fatalf("TEST:Unable to limit resources %s", err)
+	}
+	if len(cgroups) != 2 {
+		t.Errorf("TEST:Unexpected cgroups %v", cgroups)
+	}
+	if value := readFile(dir, "1234", "oom_score_adj"); value != "500" {
+		t.Errorf("TEST:Unexpected oom score adjustment %s", value)
+	}
+	if value := readFile(cgroupRoot, "memory", enforcerCgroupName, "pu1", "memory.limit_in_bytes"); value != "67108864" {
+		t.Errorf("TEST:Unexpected memory limit %s", value)
+	}
+	if value := readFile(cgroupRoot, "cpu", enforcerCgroupName, "pu1", "cpu.cfs_quota_us"); value != "50000" {
+		t.Errorf("TEST:Unexpected cpu quota %s", value)
+	}
+	if value := readFile(cgroupRoot, "cpu", enforcerCgroupName, "pu1", "cgroup.procs"); value != "1234" {
+		t.Errorf("TEST:Unexpected process in cgroup %s", value)
+	}
+
+	//The unified hierarchy should be used if it is mounted
+	if err := ioutil.WriteFile(filepath.Join(cgroupRoot, "cgroup.controllers"), []byte("cpu memory"), 0644); err != nil {
+		t.Fatalf("TEST:Setup failed %s", err)
+	}
+	cgroups, err = p.limitResources("pu2", 1234, dir)
+	if err != nil {
+		t.Fatalf("TEST:Unable to limit resources %s", err)
+	}
+	if !reflect.DeepEqual(cgroups, []string{filepath.Join(cgroupRoot, enforcerCgroupName, "pu2")}) {
+		t.Errorf("TEST:Unexpected cgroups %v", cgroups)
+	}
+	if value := readFile(cgroupRoot, enforcerCgroupName, "cgroup.subtree_control"); value != "+cpu" {
+		t.Errorf("TEST:Unexpected controllers %s", value)
+	}
+	if value := readFile(cgroups[0], "cpu.max"); value != "50000 100000" {
+		t.Errorf("TEST:Unexpected cpu limit %s", value)
+	}
+}
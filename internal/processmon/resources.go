@@ -0,0 +1,186 @@
+package processmon
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// enforcerCgroupName is the parent cgroup of the cgroups of the remote
+	// enforcers
+	enforcerCgroupName = "trireme-enforcers"
+	// cpuPeriod is the period of the CPU quota of the remote enforcers in
+	// microseconds
+	cpuPeriod = 100000
+	// maxOOMScoreAdj is the largest adjustment of the OOM score of a process
+	maxOOMScoreAdj = 1000
+)
+
+// cgroupRoot is the mount point of the cgroups, made configurable to enable
+// running tests
+var cgroupRoot = "/sys/fs/cgroup"
+
+// resourceLimits are the limits of the resources of the remote enforcers
+type resourceLimits struct {
+	// memory is the maximum memory of a remote enforcer in bytes
+	memory int64
+	// cpuQuota is the number of CPUs a remote enforcer can use, like 0.5
+	cpuQuota float64
+	// oomScoreAdj is added to the OOM score of the remote enforcers
+	oomScoreAdj int
+}
+
+// cgroupLimit is a file of a cgroup and the value written to it
+type cgroupLimit struct {
+	file  string
+	value string
+}
+
+// SetResourceLimits sets the maximum memory in bytes and the number of CPUs,
+// like 0.5, each remote enforcer can use, and the adjustment of their OOM
+// score, from -1000 to 1000. The remote enforcers are placed in their own
+// cgroups if they have limits. A positive adjustment makes the kernel kill a
+// remote enforcer before the other processes of the host when it runs out of
+// memory.
+func (p *processMon) SetResourceLimits(memory int64, cpuQuota float64, oomScoreAdj int) error {
+
+	if memory < 0 {
+		return errors.New("negative memory limit")
+	}
+
+	if cpuQuota < 0 {
+		return errors.New("negative cpu quota")
+	}
+
+	if oomScoreAdj < -maxOOMScoreAdj || oomScoreAdj > maxOOMScoreAdj {
+		return fmt.Errorf("oom score adjustment %d out of range [%d,%d]", oomScoreAdj, -maxOOMScoreAdj, maxOOMScoreAdj)
+	}
+
+	p.limits = resourceLimits{
+		memory:      memory,
+		cpuQuota:    cpuQuota,
+		oomScoreAdj: oomScoreAdj,
+	}
+
+	return nil
+}
+
+// cgroupLimits returns the limits of the cgroups of a remote enforcer by
+// cgroup. The cgroups of the unified hierarchy are used if it is mounted.
+func (r resourceLimits) cgroupLimits(contextID string) map[string][]cgroupLimit {
+
+	cgroups := map[string][]cgroupLimit{}
+
+	quota := strconv.FormatInt(int64(r.cpuQuota*cpuPeriod), 10)
+	period := strconv.Itoa(cpuPeriod)
+
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		cgroup := filepath.Join(cgroupRoot, enforcerCgroupName, contextID)
+		if r.memory > 0 {
+			cgroups[cgroup] = append(cgroups[cgroup], cgroupLimit{file: "memory.max", value: strconv.FormatInt(r.memory, 10)})
+		}
+		if r.cpuQuota > 0 {
+			cgroups[cgroup] = append(cgroups[cgroup], cgroupLimit{file: "cpu.max", value: quota + " " + period})
+		}
+		return cgroups
+	}
+
+	if r.memory > 0 {
+		cgroup := filepath.Join(cgroupRoot, "memory", enforcerCgroupName, contextID)
+		cgroups[cgroup] = []cgroupLimit{
+			{file: "memory.limit_in_bytes", value: strconv.FormatInt(r.memory, 10)},
+		}
+	}
+
+	if r.cpuQuota > 0 {
+		cgroup := filepath.Join(cgroupRoot, "cpu", enforcerCgroupName, contextID)
+		cgroups[cgroup] = []cgroupLimit{
+			{file: "cpu.cfs_period_us", value: period},
+			{file: "cpu.cfs_quota_us", value: quota},
+		}
+	}
+
+	return cgroups
+}
+
+// enableControllers enables the controllers of the limits for the cgroups of
+// the remote enforcers in the unified hierarchy
+func enableControllers(parent string, limits []cgroupLimit) error {
+
+	for _, limit := range limits {
+		controller := "+memory"
+		if limit.file == "cpu.max" {
+			controller = "+cpu"
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(controller), 0644); err != nil {
+			return fmt.Errorf("unable to enable controller %s: %s", controller, err)
+		}
+	}
+
+	return nil
+}
+
+// limitResources places a remote enforcer in its cgroups with the limits and
+// adjusts its OOM score. It returns the cgroups that were created.
+func (p *processMon) limitResources(contextID string, pid int, procMountPoint string) ([]string, error) {
+
+	if p.limits.oomScoreAdj != 0 {
+		oomFile := filepath.Join(procMountPoint, strconv.Itoa(pid), "oom_score_adj")
+		if err := ioutil.WriteFile(oomFile, []byte(strconv.Itoa(p.limits.oomScoreAdj)), 0644); err != nil {
+			return nil, fmt.Errorf("unable to adjust oom score: %s", err)
+		}
+	}
+
+	created := []string{}
+
+	for cgroup, limits := range p.limits.cgroupLimits(contextID) {
+
+		parent := filepath.Dir(cgroup)
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return created, fmt.Errorf("unable to create cgroup %s: %s", parent, err)
+		}
+
+		if filepath.Dir(parent) == cgroupRoot {
+			if err := enableControllers(parent, limits); err != nil {
+				return created, err
+			}
+		}
+
+		if err := os.Mkdir(cgroup, 0755); err != nil && !os.IsExist(err) {
+			return created, fmt.Errorf("unable to create cgroup %s: %s", cgroup, err)
+		}
+		created = append(created, cgroup)
+
+		for _, limit := range limits {
+			if err := ioutil.WriteFile(filepath.Join(cgroup, limit.file), []byte(limit.value), 0644); err != nil {
+				return created, fmt.Errorf("unable to set %s of cgroup %s: %s", limit.file, cgroup, err)
+			}
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(cgroup, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return created, fmt.Errorf("unable to add remote enforcer to cgroup %s: %s", cgroup, err)
+		}
+	}
+
+	return created, nil
+}
+
+// removeCgroups removes the cgroups of a remote enforcer once it exited
+func removeCgroups(cgroups []string) {
+
+	for _, cgroup := range cgroups {
+		if err := os.Remove(cgroup); err != nil {
+			zap.L().Debug("Unable to remove cgroup of remote enforcer",
+				zap.String("cgroup", cgroup),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package remoteenforcer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
+
+// maxCrashLogLines is the number of the last lines logged before a crash that
+// are reported with it
+const maxCrashLogLines = 100
+
+// crashReporter reports the crash of the remote enforcer to the controller, and
+// writes it to a crash file if it has a directory. A crash is reported once.
+type crashReporter struct {
+	contextID string
+	dir       string
+	report    func(*rpcwrapper.CrashPayload) error
+	lines     []string
+	next      int
+	once      sync.Once
+	sync.Mutex
+}
+
+// newCrashReporter returns a reporter of the crashes of the remote enforcer of
+// the PU contextID. The report function and the directory are optional.
+func newCrashReporter(contextID string, dir string, report func(*rpcwrapper.CrashPayload) error) *crashReporter {
+
+	return &crashReporter{
+		contextID: contextID,
+		dir:       dir,
+		report:    report,
+		lines:     make([]string, 0, maxCrashLogLines),
+	}
+}
+
+// keep keeps a line, and forgets the oldest one if there are too many
+func (c *crashReporter) keep(line string) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.lines) < maxCrashLogLines {
+		c.lines = append(c.lines, line)
+		return
+	}
+
+	c.lines[c.next] = line
+	c.next = (c.next + 1) % maxCrashLogLines
+}
+
+// logs returns the lines kept, oldest first
+func (c *crashReporter) logs() []string {
+
+	c.Lock()
+	defer c.Unlock()
+
+	logs := make([]string, 0, len(c.lines))
+	logs = append(logs, c.lines[c.next:]...)
+	logs = append(logs, c.lines[:c.next]...)
+
+	return logs
+}
+
+// crash reports a crash with its reason and its stack. It is reported to the
+// controller and written to the crash file, whichever are available.
+func (c *crashReporter) crash(reason string, stack string) {
+
+	c.once.Do(func() {
+		report := &rpcwrapper.CrashPayload{
+			ContextID: c.contextID,
+			Reason:    reason,
+			Stack:     stack,
+			Logs:      c.logs(),
+		}
+
+		if c.dir != "" {
+			if err := writeCrashFile(c.dir, report); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to write crash report: %s\n", err) // nolint
+			}
+		}
+
+		if c.report != nil {
+			if err := c.report(report); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to report crash: %s\n", err) // nolint
+			}
+		}
+	})
+}
+
+// writeCrashFile writes a crash report in the file of its PU in dir
+func writeCrashFile(dir string, report *rpcwrapper.CrashPayload) error {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("%s\n\n%s\n\n%s\n", report.Reason, report.Stack, strings.Join(report.Logs, "\n"))
+
+	return ioutil.WriteFile(filepath.Join(dir, report.ContextID+".crash"), []byte(content), 0600)
+}
+
+// recoverCrash reports the panic of the goroutine of the caller, if any, and
+// panics again so that the remote enforcer exits. It must be deferred, and
+// the panic is not reported if the reporter is nil.
+func (c *crashReporter) recoverCrash() {
+
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if c == nil {
+		panic(r)
+	}
+
+	c.crash(fmt.Sprintf("panic: %v", r), string(debug.Stack()))
+
+	panic(r)
+}
+
+// crashCore is a zapcore.Core that keeps the lines logged by the remote
+// enforcer for its crash reports, and reports a crash when a panic or a fatal
+// entry is logged
+type crashCore struct {
+	reporter *crashReporter
+	encoder  zapcore.Encoder
+}
+
+// newCrashCore returns a core that keeps the lines of the reporter
+func newCrashCore(reporter *crashReporter) zapcore.Core {
+
+	config := zap.NewProductionEncoderConfig()
+	config.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	return &crashCore{
+		reporter: reporter,
+		encoder:  zapcore.NewConsoleEncoder(config),
+	}
+}
+
+// Enabled implements zapcore.Core. The level is checked by the enclosing core.
+func (c *crashCore) Enabled(level zapcore.Level) bool {
+	return true
+}
+
+// With implements zapcore.Core
+func (c *crashCore) With(fields []zapcore.Field) zapcore.Core {
+
+	encoder := c.encoder.Clone()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	return &crashCore{
+		reporter: c.reporter,
+		encoder:  encoder,
+	}
+}
+
+// Check implements zapcore.Core
+func (c *crashCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checked.AddCore(entry, c)
+}
+
+// Write implements zapcore.Core. The panic and fatal entries are kept before
+// the crash is reported, since zap stops the remote enforcer right after.
+func (c *crashCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	c.reporter.keep(strings.TrimSuffix(buf.String(), "\n"))
+	buf.Free()
+
+	if entry.Level >= zapcore.PanicLevel {
+		stack := entry.Stack
+		if stack == "" {
+			stack = string(debug.Stack())
+		}
+		c.reporter.crash(entry.Message, stack)
+	}
+
+	return nil
+}
+
+// Sync implements zapcore.Core
+func (c *crashCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,85 @@
+package remoteenforcer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
+
+func TestCrashReporter(t *testing.T) {
+	Convey("Given a crash reporter with a crash directory", t, func() {
+		dir, err := ioutil.TempDir("", "crash")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		reports := []*rpcwrapper.CrashPayload{}
+		reporter := newCrashReporter("pu1", dir, func(report *rpcwrapper.CrashPayload) error {
+			reports = append(reports, report)
+			return nil
+		})
+		logger := zap.New(newCrashCore(reporter)).With(zap.String("contextID", "pu1"))
+
+		Convey("When more lines than the maximum are logged, the last ones should be kept in order", func() {
+			for i := 0; i < maxCrashLogLines+10; i++ {
+				logger.Info(fmt.Sprintf("line %d", i))
+			}
+
+			logs := reporter.logs()
+			So(len(logs), ShouldEqual, maxCrashLogLines)
+			So(logs[0], ShouldContainSubstring, "line 10")
+			So(logs[0], ShouldContainSubstring, `"contextID": "pu1"`)
+			So(logs[maxCrashLogLines-1], ShouldContainSubstring, fmt.Sprintf("line %d", maxCrashLogLines+9))
+		})
+
+		Convey("When a panic is logged, the crash should be reported once with the last lines", func() {
+			logger.Info("before the crash")
+
+			So(func() { logger.Panic("invalid state") }, ShouldPanic)
+			So(func() {
+				defer reporter.recoverCrash()
+				panic("invalid state")
+			}, ShouldPanic)
+
+			So(len(reports), ShouldEqual, 1)
+			So(reports[0].ContextID, ShouldEqual, "pu1")
+			So(reports[0].Reason, ShouldEqual, "invalid state")
+			So(reports[0].Stack, ShouldNotBeEmpty)
+			So(len(reports[0].Logs), ShouldEqual, 2)
+			So(reports[0].Logs[0], ShouldContainSubstring, "before the crash")
+
+			content, err := ioutil.ReadFile(filepath.Join(dir, "pu1.crash"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldContainSubstring, "invalid state")
+			So(string(content), ShouldContainSubstring, "before the crash")
+		})
+
+		Convey("When a deferred recovery catches a panic, the crash should be reported and the panic raised again", func() {
+			So(func() {
+				defer reporter.recoverCrash()
+				panic("nil map")
+			}, ShouldPanicWith, "nil map")
+
+			So(len(reports), ShouldEqual, 1)
+			So(reports[0].Reason, ShouldEqual, "panic: nil map")
+		})
+	})
+
+	Convey("Given no crash reporter", t, func() {
+		var reporter *crashReporter
+
+		Convey("When a deferred recovery catches a panic, the panic should be raised again", func() {
+			So(func() {
+				defer reporter.recoverCrash()
+				panic("nil map")
+			}, ShouldPanicWith, "nil map")
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package remoteenforcer
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+// debugSocketPath returns the path of the debug socket of the remote enforcer
+// listening on the RPC socket rpcChannel, like /var/run/pu1-debug.sock for
+// /var/run/pu1.sock
+func debugSocketPath(rpcChannel string) string {
+	return strings.TrimSuffix(rpcChannel, ".sock") + "-debug.sock"
+}
+
+// newDebugHandler returns the handler of the debug listener. It serves the
+// pprof profiles, including the goroutine dumps, under /debug/pprof/, the
+// expvar variables under /debug/vars and the counters of the caches of the
+// datapath under /debug/caches.
+func newDebugHandler(cacheStats func() []*collector.CacheStatsRecord) http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.HandleFunc("/debug/caches", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cacheStats()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	return mux
+}
+
+// debugListener serves the debug handler on a unix socket only accessible to
+// the owner of the remote enforcer
+type debugListener struct {
+	path   string
+	server *http.Server
+}
+
+// startDebugListener starts to serve handler on the unix socket path. A stale
+// socket of a previous remote enforcer is removed.
+func startDebugListener(path string, handler http.Handler) (*debugListener, error) {
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to remove debug socket %s: %s", path, err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on debug socket %s: %s", path, err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close() // nolint
+		return nil, fmt.Errorf("unable to restrict debug socket %s: %s", path, err)
+	}
+
+	d := &debugListener{
+		path:   path,
+		server: &http.Server{Handler: handler},
+	}
+
+	go d.server.Serve(listener) // nolint
+
+	return d, nil
+}
+
+// stop stops the listener and removes its socket
+func (d *debugListener) stop() error {
+
+	if err := d.server.Close(); err != nil {
+		return fmt.Errorf("unable to stop debug listener: %s", err)
+	}
+
+	if err := os.Remove(d.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove debug socket %s: %s", d.path, err)
+	}
+
+	return nil
+}
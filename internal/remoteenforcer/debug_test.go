@@ -0,0 +1,82 @@
+package remoteenforcer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+func TestDebugListener(t *testing.T) {
+	Convey("Given the RPC socket of a remote enforcer", t, func() {
+		So(debugSocketPath("/var/run/pu1.sock"), ShouldEqual, "/var/run/pu1-debug.sock")
+
+		dir, err := ioutil.TempDir("", "debug")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		path := debugSocketPath(filepath.Join(dir, "pu1.sock"))
+		handler := newDebugHandler(func() []*collector.CacheStatsRecord {
+			return []*collector.CacheStatsRecord{{ContextID: "pu1", Cache: "authorizedFlows", Entries: 2, Hits: 5}}
+		})
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return net.Dial("unix", path)
+				},
+			},
+		}
+
+		Convey("When I start the debug listener", func() {
+			debug, err := startDebugListener(path, handler)
+			So(err, ShouldBeNil)
+
+			info, err := os.Stat(path)
+			So(err, ShouldBeNil)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+			Convey("Then it should serve the counters of the caches", func() {
+				resp, err := client.Get("http://enforcer/debug/caches")
+				So(err, ShouldBeNil)
+				defer resp.Body.Close() // nolint
+
+				records := []*collector.CacheStatsRecord{}
+				So(json.NewDecoder(resp.Body).Decode(&records), ShouldBeNil)
+				So(len(records), ShouldEqual, 1)
+				So(records[0].Cache, ShouldEqual, "authorizedFlows")
+				So(records[0].Hits, ShouldEqual, 5)
+
+				So(debug.stop(), ShouldBeNil)
+			})
+
+			Convey("Then it should serve the goroutine dumps", func() {
+				resp, err := client.Get("http://enforcer/debug/pprof/goroutine?debug=2")
+				So(err, ShouldBeNil)
+				defer resp.Body.Close() // nolint
+
+				dump, err := ioutil.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(dump), ShouldContainSubstring, "goroutine")
+
+				So(debug.stop(), ShouldBeNil)
+			})
+
+			Convey("When I stop it, its socket should be removed", func() {
+				So(debug.stop(), ShouldBeNil)
+
+				_, err := os.Stat(path)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+	})
+}
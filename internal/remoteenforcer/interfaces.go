@@ -19,6 +19,14 @@ const (
 	Enforce = "RemoteEnforcer.Enforce"
 	// EnforcerExit is string for invoking RPC
 	EnforcerExit = "RemoteEnforcer.EnforcerExit"
+	// Heartbeat is string for invoking RPC
+	Heartbeat = "RemoteEnforcer.Heartbeat"
+	// SetLogForwarding is string for invoking RPC
+	SetLogForwarding = "RemoteEnforcer.SetLogForwarding"
+	// SetLogLevel is string for invoking RPC
+	SetLogLevel = "RemoteEnforcer.SetLogLevel"
+	// SetDebugListener is string for invoking RPC
+	SetDebugListener = "RemoteEnforcer.SetDebugListener"
 )
 
 // RemoteIntf is the interface implemented by the remote enforcer
@@ -45,4 +53,20 @@ type RemoteIntf interface {
 	// EnforcerExit this method is called when  we received a killrpocess message from the controller
 	// This allows a graceful exit of the enforcer
 	EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// Heartbeat this method is called periodically by the controller to verify that
+	// the remote enforcer is alive and enforcing
+	Heartbeat(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// SetLogForwarding this method is called by the controller to change the
+	// level of the logs forwarded to it
+	SetLogForwarding(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// SetLogLevel this method is called by the controller to change the level
+	// of the logs of the remote enforcer
+	SetLogLevel(req rpcwrapper.Request, resp *rpcwrapper.Response) error
+
+	// SetDebugListener this method is called by the controller to start or
+	// stop the debug listener of the remote enforcer
+	SetDebugListener(req rpcwrapper.Request, resp *rpcwrapper.Response) error
 }
@@ -0,0 +1,218 @@
+package statsclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
+
+const (
+	defaultBufferSize = 10 * 1024 * 1024
+	bufferFileSuffix  = ".json"
+)
+
+// diskBuffer is a bounded queue of the stats payloads that could not be sent,
+// kept in a directory so that they survive the outages of the stats server and
+// the restarts of the enforcer. The oldest payloads are evicted first when the
+// buffer is full.
+type diskBuffer struct {
+	dir     string
+	maxSize int64
+	// seq is the sequence number of the last payload in the buffer
+	seq uint64
+	// count is the number of payloads in the buffer
+	count int
+}
+
+// newDiskBuffer returns a buffer of at most maxSize bytes in dir, with the
+// payloads buffered by a previous run. The default size is used if maxSize is
+// zero.
+func newDiskBuffer(dir string, maxSize int64) (*diskBuffer, error) {
+
+	if maxSize <= 0 {
+		maxSize = defaultBufferSize
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create stats buffer %s: %s", dir, err)
+	}
+
+	b := &diskBuffer{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+
+	// The payloads that were being written when the enforcer stopped are
+	// incomplete
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*"+bufferFileSuffix+".tmp")) // nolint
+	for _, tmp := range tmps {
+		os.Remove(tmp) // nolint
+	}
+
+	names, err := b.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	b.count = len(names)
+	if len(names) > 0 {
+		b.seq, _ = strconv.ParseUint(strings.TrimSuffix(names[len(names)-1], bufferFileSuffix), 10, 64) // nolint
+	}
+
+	return b, nil
+}
+
+// entries returns the names of the buffered payloads, oldest first
+func (b *diskBuffer) entries() ([]string, error) {
+
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read stats buffer %s: %s", b.dir, err)
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if file.Mode().IsRegular() && strings.HasSuffix(file.Name(), bufferFileSuffix) {
+			names = append(names, file.Name())
+		}
+	}
+
+	// The names are zero padded sequence numbers
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// push adds a payload to the buffer, and evicts the oldest payloads if the
+// buffer is full
+func (b *diskBuffer) push(payload *rpcwrapper.StatsPayload) error {
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) > b.maxSize {
+		return fmt.Errorf("stats payload of %d bytes is larger than the buffer", len(data))
+	}
+
+	b.seq++
+	name := fmt.Sprintf("%020d%s", b.seq, bufferFileSuffix)
+
+	// The payload is written to a temporary file first, so that a crash never
+	// leaves a partial payload in the buffer
+	tmp := filepath.Join(b.dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("unable to buffer stats: %s", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(b.dir, name)); err != nil {
+		os.Remove(tmp) // nolint
+		return fmt.Errorf("unable to buffer stats: %s", err)
+	}
+	b.count++
+
+	return b.evict()
+}
+
+// evict removes the oldest payloads until the buffer fits in its size
+func (b *diskBuffer) evict() error {
+
+	names, err := b.entries()
+	if err != nil {
+		return err
+	}
+
+	sizes := make([]int64, len(names))
+	total := int64(0)
+	for idx, name := range names {
+		info, err := os.Stat(filepath.Join(b.dir, name))
+		if err != nil {
+			continue
+		}
+		sizes[idx] = info.Size()
+		total += sizes[idx]
+	}
+
+	for idx := 0; total > b.maxSize && idx < len(names); idx++ {
+		if err := b.remove(names[idx]); err != nil {
+			return err
+		}
+		total -= sizes[idx]
+		zap.L().Warn("Stats buffer is full, dropping the oldest stats", zap.String("payload", names[idx]))
+	}
+
+	return nil
+}
+
+// load returns a buffered payload
+func (b *diskBuffer) load(name string) (*rpcwrapper.StatsPayload, error) {
+
+	data, err := ioutil.ReadFile(filepath.Join(b.dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &rpcwrapper.StatsPayload{}
+	if err := json.Unmarshal(data, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// remove removes a payload from the buffer
+func (b *diskBuffer) remove(name string) error {
+
+	if err := os.Remove(filepath.Join(b.dir, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove buffered stats %s: %s", name, err)
+	}
+	b.count--
+
+	return nil
+}
+
+// flush sends the buffered payloads, oldest first, and removes them once they
+// are sent. It stops at the first payload that cannot be sent.
+func (b *diskBuffer) flush(send func(*rpcwrapper.StatsPayload) error) error {
+
+	if b.count == 0 {
+		return nil
+	}
+
+	names, err := b.entries()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+
+		payload, err := b.load(name)
+		if err != nil {
+			zap.L().Warn("Dropping unreadable buffered stats", zap.String("payload", name), zap.Error(err))
+			if err := b.remove(name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := send(payload); err != nil {
+			return err
+		}
+
+		if err := b.remove(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package statsclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testPayload(contextID string) *rpcwrapper.StatsPayload {
+	return &rpcwrapper.StatsPayload{
+		ConnectionMetrics: map[string]*collector.ConnectionMetricsRecord{
+			contextID: {ContextID: contextID, NewConnections: 1},
+		},
+	}
+}
+
+func TestDiskBuffer(t *testing.T) {
+	Convey("Given a stats buffer", t, func() {
+		dir, err := ioutil.TempDir("", "statsbuffer")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		b, err := newDiskBuffer(dir, 0)
+		So(err, ShouldBeNil)
+
+		So(b.push(testPayload("pu1")), ShouldBeNil)
+		So(b.push(testPayload("pu2")), ShouldBeNil)
+
+		Convey("When the stats cannot be sent, they should be kept", func() {
+			err := b.flush(func(*rpcwrapper.StatsPayload) error { return errors.New("shutdown") })
+			So(err, ShouldNotBeNil)
+			So(b.count, ShouldEqual, 2)
+		})
+
+		Convey("When the buffer is opened again, the stats should be sent oldest first", func() {
+			b, err := newDiskBuffer(dir, 0)
+			So(err, ShouldBeNil)
+			So(b.push(testPayload("pu3")), ShouldBeNil)
+
+			sent := []string{}
+			err = b.flush(func(payload *rpcwrapper.StatsPayload) error {
+				for contextID := range payload.ConnectionMetrics {
+					sent = append(sent, contextID)
+				}
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(sent, ShouldResemble, []string{"pu1", "pu2", "pu3"})
+			So(b.count, ShouldEqual, 0)
+
+			names, err := b.entries()
+			So(err, ShouldBeNil)
+			So(names, ShouldBeEmpty)
+		})
+
+		Convey("When the buffer is full, the oldest stats should be evicted", func() {
+			names, err := b.entries()
+			So(err, ShouldBeNil)
+			info, err := os.Stat(filepath.Join(dir, names[0]))
+			So(err, ShouldBeNil)
+
+			b.maxSize = 2 * info.Size()
+			So(b.push(testPayload("pu3")), ShouldBeNil)
+
+			sent := []string{}
+			So(b.flush(func(payload *rpcwrapper.StatsPayload) error {
+				for contextID := range payload.ConnectionMetrics {
+					sent = append(sent, contextID)
+				}
+				return nil
+			}), ShouldBeNil)
+			So(sent, ShouldResemble, []string{"pu2", "pu3"})
+		})
+
+		Convey("When a payload is larger than the buffer, it should be rejected", func() {
+			b.maxSize = 10
+			So(b.push(testPayload("pu3")), ShouldNotBeNil)
+		})
+	})
+}
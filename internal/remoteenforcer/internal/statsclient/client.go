@@ -1,8 +1,12 @@
 package statsclient
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/rpc"
 	"os"
+	"strconv"
 	"time"
 
 	"go.uber.org/zap"
@@ -26,7 +30,14 @@ type statsClient struct {
 	secret        string
 	statsChannel  string
 	statsInterval time.Duration
-	stop          chan bool
+	// contextID is the context ID of the PU the remote enforcer was launched
+	// for
+	contextID string
+	// buffer keeps the stats that cannot be sent if it is not nil
+	buffer *diskBuffer
+	// logs keeps the log entries forwarded to the controller
+	logs *logForwarder
+	stop chan bool
 }
 
 // NewStatsClient initializes a new stats client
@@ -38,9 +49,40 @@ func NewStatsClient(cr statscollector.Collector) (StatsClient, error) {
 		secret:        os.Getenv(constants.AporetoEnvStatsSecret),
 		statsChannel:  os.Getenv(constants.AporetoEnvStatsChannel),
 		statsInterval: defaultStatsIntervalMiliseconds * time.Millisecond,
+		contextID:     os.Getenv(constants.AporetoEnvContextID),
 		stop:          make(chan bool),
 	}
 
+	if interval := os.Getenv(constants.AporetoEnvStatsInterval); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid stats interval %s", interval)
+		}
+		sc.statsInterval = d
+	}
+
+	level, err := parseLogForwardLevel(os.Getenv(constants.AporetoEnvLogForwardLevel))
+	if err != nil {
+		return nil, err
+	}
+	sc.logs = newLogForwarder(level)
+
+	if dir := os.Getenv(constants.AporetoEnvStatsBufferDir); dir != "" {
+		size := int64(0)
+		if value := os.Getenv(constants.AporetoEnvStatsBufferSize); value != "" {
+			var err error
+			if size, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid stats buffer size %s", value)
+			}
+		}
+
+		buffer, err := newDiskBuffer(dir, size)
+		if err != nil {
+			return nil, err
+		}
+		sc.buffer = buffer
+	}
+
 	if sc.statsChannel == "" {
 		return nil, errors.New("no path to stats socket provided")
 	}
@@ -52,47 +94,146 @@ func NewStatsClient(cr statscollector.Collector) (StatsClient, error) {
 	return sc, nil
 }
 
-// sendStats  async function which makes a rpc call to send stats every STATS_INTERVAL
+// sendStats  async function which makes a rpc call to send stats every STATS_INTERVAL.
+// The flows are aggregated by the collector during the interval, and the
+// forwarded logs are sent with the stats. The resources used by the remote
+// enforcer are collected every resourceUsageInterval.
 func (s *statsClient) sendStats() {
 
+	s.collectResourceUsage()
+
 	ticker := time.NewTicker(s.statsInterval)
-	// nolint : gosimple
+	usageTicker := time.NewTicker(resourceUsageInterval)
 	for {
 		select {
+		case <-usageTicker.C:
+			s.collectResourceUsage()
+
 		case <-ticker.C:
 
-			if s.collector.Count() == 0 {
-				break
-			}
-			collected := s.collector.GetAllRecords()
-			if len(collected) == 0 {
-				continue
+			var rpcPayload *rpcwrapper.StatsPayload
+			if s.collector.Count() != 0 {
+				collected := s.collector.GetAllRecords()
+				metrics := s.collector.GetAllConnectionMetrics()
+				packets := s.collector.GetAllPacketRecords()
+				queues := s.collector.GetAllQueueStats()
+				caches := s.collector.GetAllCacheStats()
+				enforcer := s.collector.GetEnforcerStats()
+				if len(collected) != 0 || len(metrics) != 0 || len(packets) != 0 || len(queues) != 0 || len(caches) != 0 || enforcer != nil {
+					rpcPayload = &rpcwrapper.StatsPayload{
+						Flows:             collected,
+						ConnectionMetrics: metrics,
+						Packets:           packets,
+						QueueStats:        queues,
+						CacheStats:        caches,
+						EnforcerStats:     enforcer,
+					}
+				}
 			}
 
-			rpcPayload := &rpcwrapper.StatsPayload{
-				Flows: collected,
-			}
+			s.report(rpcPayload)
+			s.sendLogs()
 
-			request := rpcwrapper.Request{
-				Payload: rpcPayload,
-			}
+		case <-s.stop:
+			ticker.Stop()
+			usageTicker.Stop()
+			return
+		}
+	}
 
-			err := s.rpchdl.RemoteCall(
-				statsContextID,
-				statsRPCCommand,
-				&request,
-				&rpcwrapper.Response{},
-			)
+}
 
-			if err != nil {
-				zap.L().Error("RPC failure in sending statistics: Unable to send flows")
-			}
+// collectResourceUsage collects the resources used by the remote enforcer
+func (s *statsClient) collectResourceUsage() {
 
-		case <-s.stop:
+	record, err := resourceUsage(s.contextID)
+	if err != nil {
+		zap.L().Debug("Unable to collect resource usage", zap.Error(err))
+		return
+	}
+
+	s.collector.CollectEnforcerStats(record)
+}
+
+// report sends the buffered stats, oldest first, and then the collected stats
+// if there are any. The stats that cannot be sent are buffered if the client
+// has a buffer, and are lost otherwise.
+func (s *statsClient) report(rpcPayload *rpcwrapper.StatsPayload) {
+
+	if s.buffer != nil {
+		if err := s.buffer.flush(s.send); err != nil {
+			zap.L().Debug("Unable to send buffered statistics", zap.Error(err))
+			s.keep(rpcPayload)
 			return
 		}
 	}
 
+	if rpcPayload == nil {
+		return
+	}
+
+	if err := s.send(rpcPayload); err != nil {
+		if s.buffer == nil {
+			zap.L().Error("RPC failure in sending statistics: Unable to send flows", zap.Error(err))
+			return
+		}
+		s.keep(rpcPayload)
+	}
+}
+
+// keep buffers stats that could not be sent
+func (s *statsClient) keep(rpcPayload *rpcwrapper.StatsPayload) {
+
+	if rpcPayload == nil {
+		return
+	}
+
+	if err := s.buffer.push(rpcPayload); err != nil {
+		zap.L().Error("Unable to buffer statistics: Flows are lost", zap.Error(err))
+	}
+}
+
+// send sends stats to the stats server. The client connects again if the
+// connection was shut down, so that the stats are sent again once the server
+// is back.
+func (s *statsClient) send(rpcPayload *rpcwrapper.StatsPayload) error {
+
+	request := rpcwrapper.Request{
+		Payload: rpcPayload,
+	}
+
+	ctx, cancel := callContext()
+	defer cancel()
+
+	err := s.rpchdl.RemoteCall(
+		ctx,
+		statsContextID,
+		statsRPCCommand,
+		&request,
+		&rpcwrapper.Response{},
+	)
+
+	if err == rpc.ErrShutdown {
+		s.reconnect()
+	}
+
+	return err
+}
+
+// reconnect replaces the connection to the stats server
+func (s *statsClient) reconnect() {
+
+	rpchdl := rpcwrapper.NewRPCWrapper()
+	if err := rpchdl.NewRPCClient(statsContextID, s.statsChannel, s.secret); err != nil {
+		zap.L().Debug("Stats RPC client cannot reconnect", zap.Error(err))
+		return
+	}
+
+	if hdl, err := s.rpchdl.GetRPCClient(statsContextID); err == nil {
+		hdl.Client.Close() // nolint
+	}
+
+	s.rpchdl = rpchdl
 }
 
 // Start This is an private function called by the remoteenforcer to connect back
@@ -116,3 +257,9 @@ func (s *statsClient) Stop() {
 
 	zap.L().Debug("Stopping stats collector")
 }
+
+// callContext returns the context of a call to the stats server, so that the
+// remote enforcer does not hang when the controller stops answering
+func callContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), rpcwrapper.DefaultCallTimeout)
+}
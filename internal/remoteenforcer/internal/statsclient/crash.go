@@ -0,0 +1,28 @@
+package statsclient
+
+import (
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
+
+// crashRPCCommand is the method of the stats server that receives the crash
+// reports
+const crashRPCCommand = "StatsServer.PostCrash"
+
+// ReportCrash sends the crash report of the remote enforcer to the controller.
+// The logs kept for the controller are sent first, since the remote enforcer
+// exits right after.
+func (s *statsClient) ReportCrash(report *rpcwrapper.CrashPayload) error {
+
+	s.sendLogs()
+
+	ctx, cancel := callContext()
+	defer cancel()
+
+	return s.rpchdl.RemoteCall(
+		ctx,
+		statsContextID,
+		crashRPCCommand,
+		&rpcwrapper.Request{Payload: report},
+		&rpcwrapper.Response{},
+	)
+}
@@ -1,9 +1,20 @@
 package statsclient
 
+import (
+	"go.uber.org/zap/zapcore"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
+
 // StatsClient interface provides functions to start/stop a stats client
 // A stats client is an active component which is responsible for collecting
 // stats events stored by datapath and ship them to the master enforcer.
+// It also forwards the logs of its core and the crashes of the remote enforcer
+// to the master enforcer.
 type StatsClient interface {
 	Start() error
 	Stop()
+	LogCore() zapcore.Core
+	SetLogForwardLevel(level string) error
+	ReportCrash(report *rpcwrapper.CrashPayload) error
 }
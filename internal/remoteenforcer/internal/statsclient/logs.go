@@ -0,0 +1,211 @@
+package statsclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/rpc"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
+)
+
+const (
+	// logsRPCCommand is the method of the stats server that receives the logs
+	logsRPCCommand = "StatsServer.PostLogs"
+	// maxLogEntries is the maximum number of log entries kept until they are
+	// sent, so that a flood of logs does not flood the controller
+	maxLogEntries = 1000
+	// logForwardingDisabled is a level above all the levels of the entries
+	logForwardingDisabled = zapcore.FatalLevel + 1
+)
+
+// parseLogForwardLevel returns the level of the logs forwarded to the
+// controller. The logs are not forwarded if the level is empty.
+func parseLogForwardLevel(name string) (zapcore.Level, error) {
+
+	if name == "" {
+		return logForwardingDisabled, nil
+	}
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("invalid log forwarding level %s", name)
+	}
+
+	return level, nil
+}
+
+// logBuffer keeps the log entries until they are sent to the controller
+type logBuffer struct {
+	entries []*rpcwrapper.LogEntry
+	dropped int
+	sync.Mutex
+}
+
+// add keeps an entry, or drops it if the buffer is full
+func (b *logBuffer) add(entry *rpcwrapper.LogEntry) {
+
+	b.Lock()
+	defer b.Unlock()
+
+	if len(b.entries) >= maxLogEntries {
+		b.dropped++
+		return
+	}
+
+	b.entries = append(b.entries, entry)
+}
+
+// take returns the payload of the entries kept so far, or nil if there are
+// none
+func (b *logBuffer) take(contextID string) *rpcwrapper.LogPayload {
+
+	b.Lock()
+	defer b.Unlock()
+
+	if len(b.entries) == 0 && b.dropped == 0 {
+		return nil
+	}
+
+	payload := &rpcwrapper.LogPayload{
+		ContextID: contextID,
+		Entries:   b.entries,
+		Dropped:   b.dropped,
+	}
+
+	b.entries = nil
+	b.dropped = 0
+
+	return payload
+}
+
+// logForwarder is a zapcore.Core that keeps the log entries of the remote
+// enforcer at or above its level, so that the stats client sends them to the
+// controller
+type logForwarder struct {
+	level  zap.AtomicLevel
+	fields []zapcore.Field
+	buffer *logBuffer
+}
+
+// newLogForwarder returns a forwarder of the logs at or above level
+func newLogForwarder(level zapcore.Level) *logForwarder {
+
+	return &logForwarder{
+		level:  zap.NewAtomicLevelAt(level),
+		buffer: &logBuffer{},
+	}
+}
+
+// Enabled implements zapcore.Core
+func (l *logForwarder) Enabled(level zapcore.Level) bool {
+	return l.level.Enabled(level)
+}
+
+// With implements zapcore.Core
+func (l *logForwarder) With(fields []zapcore.Field) zapcore.Core {
+
+	clone := *l
+	clone.fields = make([]zapcore.Field, 0, len(l.fields)+len(fields))
+	clone.fields = append(clone.fields, l.fields...)
+	clone.fields = append(clone.fields, fields...)
+
+	return &clone
+}
+
+// Check implements zapcore.Core
+func (l *logForwarder) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+
+	if l.Enabled(entry.Level) {
+		return checked.AddCore(entry, l)
+	}
+
+	return checked
+}
+
+// Write implements zapcore.Core. The fields are encoded in JSON.
+func (l *logForwarder) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range l.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	data, err := json.Marshal(enc.Fields)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"fieldsError": err.Error()}) // nolint
+	}
+
+	forwarded := &rpcwrapper.LogEntry{
+		Level:   entry.Level,
+		Time:    entry.Time,
+		Logger:  entry.LoggerName,
+		Message: entry.Message,
+		Stack:   entry.Stack,
+		Fields:  data,
+	}
+
+	if entry.Caller.Defined {
+		forwarded.Caller = entry.Caller.TrimmedPath()
+	}
+
+	l.buffer.add(forwarded)
+
+	return nil
+}
+
+// Sync implements zapcore.Core
+func (l *logForwarder) Sync() error {
+	return nil
+}
+
+// LogCore returns the core that forwards the logs of the remote enforcer to
+// the controller
+func (s *statsClient) LogCore() zapcore.Core {
+	return s.logs
+}
+
+// SetLogForwardLevel sets the level of the logs forwarded to the controller.
+// The logs are not forwarded if the level is empty.
+func (s *statsClient) SetLogForwardLevel(name string) error {
+
+	level, err := parseLogForwardLevel(name)
+	if err != nil {
+		return err
+	}
+
+	s.logs.level.SetLevel(level)
+
+	return nil
+}
+
+// sendLogs sends the log entries kept since the last time. The entries that
+// cannot be sent are lost.
+func (s *statsClient) sendLogs() {
+
+	payload := s.logs.buffer.take(s.contextID)
+	if payload == nil {
+		return
+	}
+
+	ctx, cancel := callContext()
+	defer cancel()
+
+	err := s.rpchdl.RemoteCall(
+		ctx,
+		statsContextID,
+		logsRPCCommand,
+		&rpcwrapper.Request{Payload: payload},
+		&rpcwrapper.Response{},
+	)
+
+	if err == rpc.ErrShutdown {
+		s.reconnect()
+	}
+}
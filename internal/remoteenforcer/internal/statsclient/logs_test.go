@@ -0,0 +1,63 @@
+package statsclient
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogForwarder(t *testing.T) {
+	Convey("Given a forwarder of the logs at the info level", t, func() {
+		s := &statsClient{contextID: "pu1", logs: newLogForwarder(zapcore.InfoLevel)}
+		logger := zap.New(s.LogCore()).With(zap.String("component", "datapath"))
+
+		Convey("When I log entries, only the entries at or above the level should be kept", func() {
+			logger.Debug("ignored")
+			logger.Warn("dropped packet", zap.Int("port", 80), zap.Error(errors.New("invalid token")))
+
+			payload := s.logs.buffer.take(s.contextID)
+			So(payload, ShouldNotBeNil)
+			So(payload.ContextID, ShouldEqual, "pu1")
+			So(len(payload.Entries), ShouldEqual, 1)
+			So(payload.Entries[0].Level, ShouldEqual, zapcore.WarnLevel)
+			So(payload.Entries[0].Message, ShouldEqual, "dropped packet")
+
+			fields := map[string]interface{}{}
+			So(json.Unmarshal(payload.Entries[0].Fields, &fields), ShouldBeNil)
+			So(fields, ShouldResemble, map[string]interface{}{
+				"component": "datapath",
+				"port":      float64(80),
+				"error":     "invalid token",
+			})
+
+			So(s.logs.buffer.take(s.contextID), ShouldBeNil)
+		})
+
+		Convey("When I change the level at runtime, it should be applied", func() {
+			So(s.SetLogForwardLevel("debug"), ShouldBeNil)
+			logger.Debug("kept")
+			So(len(s.logs.buffer.take(s.contextID).Entries), ShouldEqual, 1)
+
+			So(s.SetLogForwardLevel(""), ShouldBeNil)
+			logger.Error("not forwarded")
+			So(s.logs.buffer.take(s.contextID), ShouldBeNil)
+
+			So(s.SetLogForwardLevel("verbose"), ShouldNotBeNil)
+		})
+
+		Convey("When too many entries are logged, the last ones should be dropped", func() {
+			for i := 0; i < maxLogEntries+5; i++ {
+				logger.Info("flood")
+			}
+
+			payload := s.logs.buffer.take(s.contextID)
+			So(len(payload.Entries), ShouldEqual, maxLogEntries)
+			So(payload.Dropped, ShouldEqual, 5)
+		})
+	})
+}
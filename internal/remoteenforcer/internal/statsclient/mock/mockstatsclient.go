@@ -7,7 +7,9 @@ package mockstatsclient
 import (
 	reflect "reflect"
 
+	rpcwrapper "github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
 	gomock "github.com/golang/mock/gomock"
+	zapcore "go.uber.org/zap/zapcore"
 )
 
 // MockStatsClient is a mock of StatsClient interface
@@ -62,3 +64,45 @@ func (m *MockStatsClient) Stop() {
 func (mr *MockStatsClientMockRecorder) Stop() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockStatsClient)(nil).Stop))
 }
+
+// LogCore mocks base method
+// nolint
+func (m *MockStatsClient) LogCore() zapcore.Core {
+	ret := m.ctrl.Call(m, "LogCore")
+	ret0, _ := ret[0].(zapcore.Core)
+	return ret0
+}
+
+// LogCore indicates an expected call of LogCore
+// nolint
+func (mr *MockStatsClientMockRecorder) LogCore() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogCore", reflect.TypeOf((*MockStatsClient)(nil).LogCore))
+}
+
+// SetLogForwardLevel mocks base method
+// nolint
+func (m *MockStatsClient) SetLogForwardLevel(level string) error {
+	ret := m.ctrl.Call(m, "SetLogForwardLevel", level)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLogForwardLevel indicates an expected call of SetLogForwardLevel
+// nolint
+func (mr *MockStatsClientMockRecorder) SetLogForwardLevel(level interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogForwardLevel", reflect.TypeOf((*MockStatsClient)(nil).SetLogForwardLevel), level)
+}
+
+// ReportCrash mocks base method
+// nolint
+func (m *MockStatsClient) ReportCrash(report *rpcwrapper.CrashPayload) error {
+	ret := m.ctrl.Call(m, "ReportCrash", report)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReportCrash indicates an expected call of ReportCrash
+// nolint
+func (mr *MockStatsClientMockRecorder) ReportCrash(report interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportCrash", reflect.TypeOf((*MockStatsClient)(nil).ReportCrash), report)
+}
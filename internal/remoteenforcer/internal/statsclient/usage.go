@@ -0,0 +1,65 @@
+package statsclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+)
+
+const (
+	// resourceUsageInterval is the interval at which the resources used by
+	// the remote enforcer are reported
+	resourceUsageInterval = 10 * time.Second
+	// statmPath is the file of the memory used by the process
+	statmPath = "/proc/self/statm"
+)
+
+// resourceUsage returns the memory and the CPU time used by the remote
+// enforcer of contextID
+func resourceUsage(contextID string) (*collector.EnforcerStatsRecord, error) {
+
+	data, err := ioutil.ReadFile(statmPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read memory usage: %s", err)
+	}
+
+	rss, err := parseStatm(string(data), os.Getpagesize())
+	if err != nil {
+		return nil, err
+	}
+
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return nil, fmt.Errorf("unable to read cpu usage: %s", err)
+	}
+
+	return &collector.EnforcerStatsRecord{
+		ContextID: contextID,
+		PID:       os.Getpid(),
+		RSS:       rss,
+		CPUTime:   time.Duration(usage.Utime.Nano() + usage.Stime.Nano()),
+	}, nil
+}
+
+// parseStatm returns the resident memory in bytes of the content of a statm
+// file, which holds the sizes of the memory of a process in pages
+func parseStatm(data string, pageSize int) (uint64, error) {
+
+	fields := strings.Fields(data)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("invalid memory usage %s", data)
+	}
+
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid resident memory %s", fields[1])
+	}
+
+	return pages * uint64(pageSize), nil
+}
@@ -0,0 +1,41 @@
+package statsclient
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResourceUsage(t *testing.T) {
+	Convey("Given the memory usage of a process", t, func() {
+
+		Convey("When it is valid, the resident memory should be returned in bytes", func() {
+			rss, err := parseStatm("2600 512 300 200 0 900 0\n", 4096)
+			So(err, ShouldBeNil)
+			So(rss, ShouldEqual, 512*4096)
+		})
+
+		Convey("When it is invalid, it should fail", func() {
+			_, err := parseStatm("2600", 4096)
+			So(err, ShouldNotBeNil)
+			_, err = parseStatm("2600 abc", 4096)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a running process", t, func() {
+		if _, err := os.Stat(statmPath); err != nil {
+			SkipConvey("The memory usage is not available", func() {})
+			return
+		}
+
+		Convey("When I read its resource usage, it should be reported for the context", func() {
+			record, err := resourceUsage("pu1")
+			So(err, ShouldBeNil)
+			So(record.ContextID, ShouldEqual, "pu1")
+			So(record.PID, ShouldEqual, os.Getpid())
+			So(record.RSS, ShouldBeGreaterThan, 0)
+		})
+	})
+}
@@ -1,16 +1,49 @@
 package statscollector
 
 import (
+	"math/rand"
 	"sync"
 
 	"github.com/aporeto-inc/trireme-lib/collector"
 )
 
+// Option is an option of the collector
+type Option func(*collectorImpl)
+
+// OptionFlowSampling keeps one in every rate flow events, so that port scans
+// do not flood the controller. The count of a kept flow event is multiplied by
+// the rate, so that the reported counts estimate the flows. The accounting of
+// the flows is always kept. All the flow events are kept if the rate is 1 or
+// less.
+func OptionFlowSampling(rate int) Option {
+	return func(c *collectorImpl) {
+		if rate > 1 {
+			c.samplingRate = rate
+		}
+	}
+}
+
+// maxPacketRecords is the maximum number of packet records kept until they are
+// reported, so that a flood of dropped packets does not flood the controller
+const maxPacketRecords = 1000
+
 // NewCollector provides a new collector interface
-func NewCollector() Collector {
-	return &collectorImpl{
-		Flows: map[string]*collector.FlowRecord{},
+func NewCollector(opts ...Option) Collector {
+
+	c := &collectorImpl{
+		Flows:             map[string]*collector.FlowRecord{},
+		ConnectionMetrics: map[string]*collector.ConnectionMetricsRecord{},
+		QueueStats:        map[string]*collector.QueueStatsRecord{},
+		CacheStats:        map[string]*collector.CacheStatsRecord{},
+		samplingRate:      1,
+		random:            rand.Intn,
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	return c
 }
 
 // collectorImpl : This object is a stash implements two interfaces.
@@ -19,8 +52,20 @@ func NewCollector() Collector {
 //  CollectorReader - so components can extract information out of this stash
 //
 // It has a flow entries cache which contains unique flows that are reported
-// back to the controller/launcher process
+// back to the controller/launcher process and the connection metrics of every
+// PU aggregated since the last report, the first packet records since the
+// last report, the latest counters of every queue and cache and the latest
+// resources used by the enforcer
 type collectorImpl struct {
-	Flows map[string]*collector.FlowRecord
+	Flows             map[string]*collector.FlowRecord
+	ConnectionMetrics map[string]*collector.ConnectionMetricsRecord
+	Packets           []*collector.PacketRecord
+	QueueStats        map[string]*collector.QueueStatsRecord
+	CacheStats        map[string]*collector.CacheStatsRecord
+	EnforcerStats     *collector.EnforcerStatsRecord
+	// samplingRate keeps one in every samplingRate flow events
+	samplingRate int
+	// random returns a random number in [0,n)
+	random func(n int) int
 	sync.Mutex
 }
@@ -2,12 +2,17 @@ package statscollector
 
 import "github.com/aporeto-inc/trireme-lib/collector"
 
-// Count returns the current number of flows.
+// Count returns the current number of flows, connection metrics, packet, queue, cache and enforcer records.
 func (c *collectorImpl) Count() int {
 	c.Lock()
 	defer c.Unlock()
 
-	return len(c.Flows)
+	count := len(c.Flows) + len(c.ConnectionMetrics) + len(c.Packets) + len(c.QueueStats) + len(c.CacheStats)
+	if c.EnforcerStats != nil {
+		count++
+	}
+
+	return count
 }
 
 // GetAllRecords should return all flow records stashed so far.
@@ -23,3 +28,75 @@ func (c *collectorImpl) GetAllRecords() map[string]*collector.FlowRecord {
 	c.Flows = make(map[string]*collector.FlowRecord)
 	return retval
 }
+
+// GetAllConnectionMetrics should return all connection metrics stashed so far.
+func (c *collectorImpl) GetAllConnectionMetrics() map[string]*collector.ConnectionMetricsRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.ConnectionMetrics) == 0 {
+		return nil
+	}
+
+	retval := c.ConnectionMetrics
+	c.ConnectionMetrics = make(map[string]*collector.ConnectionMetricsRecord)
+	return retval
+}
+
+// GetAllPacketRecords should return all packet records stashed so far.
+func (c *collectorImpl) GetAllPacketRecords() []*collector.PacketRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.Packets) == 0 {
+		return nil
+	}
+
+	retval := c.Packets
+	c.Packets = nil
+	return retval
+}
+
+// GetAllQueueStats should return all queue records stashed so far.
+func (c *collectorImpl) GetAllQueueStats() []*collector.QueueStatsRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.QueueStats) == 0 {
+		return nil
+	}
+
+	retval := make([]*collector.QueueStatsRecord, 0, len(c.QueueStats))
+	for _, record := range c.QueueStats {
+		retval = append(retval, record)
+	}
+	c.QueueStats = make(map[string]*collector.QueueStatsRecord)
+	return retval
+}
+
+// GetAllCacheStats should return all cache records stashed so far.
+func (c *collectorImpl) GetAllCacheStats() []*collector.CacheStatsRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.CacheStats) == 0 {
+		return nil
+	}
+
+	retval := make([]*collector.CacheStatsRecord, 0, len(c.CacheStats))
+	for _, record := range c.CacheStats {
+		retval = append(retval, record)
+	}
+	c.CacheStats = make(map[string]*collector.CacheStatsRecord)
+	return retval
+}
+
+// GetEnforcerStats should return the enforcer record stashed so far.
+func (c *collectorImpl) GetEnforcerStats() *collector.EnforcerStatsRecord {
+	c.Lock()
+	defer c.Unlock()
+
+	retval := c.EnforcerStats
+	c.EnforcerStats = nil
+	return retval
+}
@@ -2,6 +2,7 @@ package statscollector
 
 import (
 	"testing"
+	"time"
 
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/policy"
@@ -101,3 +102,257 @@ func TestCollectFlowEvent(t *testing.T) {
 		})
 	})
 }
+
+func TestCollectConnectionMetrics(t *testing.T) {
+
+	Convey("Given a stats collector", t, func() {
+
+		c := NewCollector()
+
+		Convey("When I add the connection metrics of a PU twice", func() {
+
+			c.CollectConnectionMetrics(&collector.ConnectionMetricsRecord{
+				ContextID:             "1",
+				NewConnections:        10,
+				ConcurrentConnections: 8,
+				Interval:              time.Second,
+			})
+			c.CollectConnectionMetrics(&collector.ConnectionMetricsRecord{
+				ContextID:             "1",
+				NewConnections:        5,
+				ConcurrentConnections: 3,
+				Interval:              time.Second,
+			})
+
+			Convey("Then the metrics should be aggregated", func() {
+				So(c.Count(), ShouldEqual, 1)
+
+				metrics := c.GetAllConnectionMetrics()
+				So(len(metrics), ShouldEqual, 1)
+				So(metrics["1"].NewConnections, ShouldEqual, 15)
+				So(metrics["1"].ConcurrentConnections, ShouldEqual, 3)
+				So(metrics["1"].Interval, ShouldEqual, 2*time.Second)
+				So(metrics["1"].Rate(), ShouldEqual, 7.5)
+
+				So(c.GetAllConnectionMetrics(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestCollectQueueStats(t *testing.T) {
+
+	Convey("Given a stats collector", t, func() {
+
+		c := NewCollector()
+
+		Convey("When I add the counters of a queue twice and of another queue", func() {
+
+			c.CollectQueueStats(&collector.QueueStatsRecord{ContextID: "1", Queue: 4, Network: true, Packets: 10})
+			c.CollectQueueStats(&collector.QueueStatsRecord{ContextID: "1", Queue: 4, Network: true, Packets: 15, Overruns: 2})
+			c.CollectQueueStats(&collector.QueueStatsRecord{ContextID: "1", Queue: 4, Packets: 3})
+
+			Convey("Then only the latest counters of each queue should be kept", func() {
+				So(c.Count(), ShouldEqual, 2)
+
+				queues := c.GetAllQueueStats()
+				So(len(queues), ShouldEqual, 2)
+				for _, q := range queues {
+					if q.Network {
+						So(q.Packets, ShouldEqual, 15)
+						So(q.Overruns, ShouldEqual, 2)
+					} else {
+						So(q.Packets, ShouldEqual, 3)
+					}
+				}
+
+				So(c.GetAllQueueStats(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestCollectCacheStats(t *testing.T) {
+
+	Convey("Given a stats collector", t, func() {
+
+		c := NewCollector()
+
+		Convey("When I add the counters of a cache twice and of another cache", func() {
+
+			c.CollectCacheStats(&collector.CacheStatsRecord{ContextID: "1", Cache: "Connection Cache", Entries: 10, Hits: 4})
+			c.CollectCacheStats(&collector.CacheStatsRecord{ContextID: "1", Cache: "Connection Cache", Entries: 12, Hits: 6, Evictions: 1})
+			c.CollectCacheStats(&collector.CacheStatsRecord{ContextID: "1", Cache: "External IP Cache", Entries: 3})
+
+			Convey("Then only the latest counters of each cache should be kept", func() {
+				So(c.Count(), ShouldEqual, 2)
+
+				caches := c.GetAllCacheStats()
+				So(len(caches), ShouldEqual, 2)
+				for _, r := range caches {
+					if r.Cache == "Connection Cache" {
+						So(r.Entries, ShouldEqual, 12)
+						So(r.Evictions, ShouldEqual, 1)
+					} else {
+						So(r.Entries, ShouldEqual, 3)
+					}
+				}
+
+				So(c.GetAllCacheStats(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestCollectEnforcerStats(t *testing.T) {
+
+	Convey("Given a stats collector", t, func() {
+
+		c := NewCollector()
+
+		Convey("When I add the resources used by the enforcer twice", func() {
+
+			c.CollectEnforcerStats(&collector.EnforcerStatsRecord{ContextID: "1", PID: 42, RSS: 1024, CPUTime: time.Second})
+			c.CollectEnforcerStats(&collector.EnforcerStatsRecord{ContextID: "1", PID: 42, RSS: 2048, CPUTime: 2 * time.Second})
+
+			Convey("Then only the latest resources should be kept", func() {
+				So(c.Count(), ShouldEqual, 1)
+
+				record := c.GetEnforcerStats()
+				So(record, ShouldNotBeNil)
+				So(record.RSS, ShouldEqual, 2048)
+				So(record.CPUTime, ShouldEqual, 2*time.Second)
+
+				So(c.GetEnforcerStats(), ShouldBeNil)
+				So(c.Count(), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestCollectFlowAccounting(t *testing.T) {
+
+	Convey("Given a stats collector", t, func() {
+
+		c := &collectorImpl{
+			Flows: map[string]*collector.FlowRecord{},
+		}
+
+		record := func(accounting *collector.FlowAccounting) *collector.FlowRecord {
+			return &collector.FlowRecord{
+				ContextID: "1",
+				Source: &collector.EndPoint{
+					ID:   "A",
+					IP:   "1.1.1.1",
+					Type: collector.PU,
+				},
+				Destination: &collector.EndPoint{
+					ID:   "B",
+					IP:   "2.2.2.2",
+					Type: collector.PU,
+					Port: 80,
+				},
+				Tags:       policy.NewTagStore(),
+				Accounting: accounting,
+			}
+		}
+
+		Convey("When I add a flow and its accounting twice", func() {
+			r := record(nil)
+			c.CollectFlowEvent(r)
+			c.CollectFlowEvent(record(&collector.FlowAccounting{BytesSent: 100, PacketsSent: 2, BytesReceived: 1000, PacketsReceived: 3}))
+			c.CollectFlowEvent(record(&collector.FlowAccounting{BytesSent: 50, PacketsSent: 1}))
+
+			Convey("Then the accounting should be aggregated without counting more flows", func() {
+				So(len(c.Flows), ShouldEqual, 1)
+				flow := c.Flows[collector.StatsFlowHash(r)]
+				So(flow.Count, ShouldEqual, 1)
+				So(flow.Accounting, ShouldResemble, &collector.FlowAccounting{BytesSent: 150, PacketsSent: 3, BytesReceived: 1000, PacketsReceived: 3})
+			})
+		})
+
+		Convey("When I add the accounting of a flow that was already reported", func() {
+			r := record(&collector.FlowAccounting{BytesSent: 100, PacketsSent: 2})
+			c.CollectFlowEvent(r)
+
+			Convey("Then it should not count a flow", func() {
+				So(c.Flows[collector.StatsFlowHash(r)].Count, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestCollectFlowLatency(t *testing.T) {
+
+	Convey("Given a stats collector", t, func() {
+
+		c := &collectorImpl{
+			Flows: map[string]*collector.FlowRecord{},
+		}
+
+		record := func(latency *collector.FlowLatency) *collector.FlowRecord {
+			return &collector.FlowRecord{
+				ContextID:   "1",
+				Source:      &collector.EndPoint{ID: "A", IP: "1.1.1.1", Type: collector.PU},
+				Destination: &collector.EndPoint{ID: "B", IP: "2.2.2.2", Type: collector.PU, Port: 80},
+				Tags:        policy.NewTagStore(),
+				Latency:     latency,
+			}
+		}
+
+		Convey("When I add identical flows with different latencies", func() {
+			r := record(&collector.FlowLatency{Handshake: 10 * time.Millisecond, RoundTrip: 2 * time.Millisecond, Authorization: time.Millisecond})
+			c.CollectFlowEvent(r)
+			c.CollectFlowEvent(record(&collector.FlowLatency{Handshake: 5 * time.Millisecond, RoundTrip: 4 * time.Millisecond}))
+			c.CollectFlowEvent(record(nil))
+
+			Convey("Then the flow should keep the maximum latencies", func() {
+				flow := c.Flows[collector.StatsFlowHash(r)]
+				So(flow.Count, ShouldEqual, 3)
+				So(flow.Latency, ShouldResemble, &collector.FlowLatency{Handshake: 10 * time.Millisecond, RoundTrip: 4 * time.Millisecond, Authorization: time.Millisecond})
+			})
+		})
+	})
+}
+
+func TestCollectFlowSampling(t *testing.T) {
+	Convey("Given a stats collector that keeps one in four flow events", t, func() {
+		c := NewCollector(OptionFlowSampling(4)).(*collectorImpl)
+
+		draws := 0
+		c.random = func(n int) int {
+			draws++
+			return draws % n
+		}
+
+		record := func(port uint16, accounting *collector.FlowAccounting) *collector.FlowRecord {
+			return &collector.FlowRecord{
+				ContextID:   "1",
+				Source:      &collector.EndPoint{ID: "A", IP: "1.1.1.1", Type: collector.PU},
+				Destination: &collector.EndPoint{ID: "B", IP: "2.2.2.2", Type: collector.PU, Port: port},
+				Tags:        policy.NewTagStore(),
+				Accounting:  accounting,
+			}
+		}
+
+		Convey("When I add eight identical flow events", func() {
+			for idx := 0; idx < 8; idx++ {
+				c.CollectFlowEvent(record(80, nil))
+			}
+
+			Convey("Then two should be kept and counted as eight flows", func() {
+				So(len(c.Flows), ShouldEqual, 1)
+				So(c.Flows[collector.StatsFlowHash(record(80, nil))].Count, ShouldEqual, 8)
+			})
+		})
+
+		Convey("When I add the accounting of a flow", func() {
+			c.CollectFlowEvent(record(443, &collector.FlowAccounting{BytesSent: 100}))
+
+			Convey("Then it should not be sampled", func() {
+				So(draws, ShouldEqual, 0)
+				So(c.Flows[collector.StatsFlowHash(record(443, nil))].Accounting.BytesSent, ShouldEqual, 100)
+			})
+		})
+	})
+}
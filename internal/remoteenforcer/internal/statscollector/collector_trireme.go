@@ -1,25 +1,50 @@
 package statscollector
 
 import (
+	"strconv"
+
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"go.uber.org/zap"
 )
 
-// CollectFlowEvent collects a new flow event and adds it to a local list it shares with SendStats
+// CollectFlowEvent collects a new flow event and adds it to a local list it shares with SendStats.
+// The identical flows are coalesced with their counts until they are reported,
+// with the maximum latency of their handshakes, and the flow events are
+// sampled if the collector has a sampling rate.
 func (c *collectorImpl) CollectFlowEvent(record *collector.FlowRecord) {
 
 	hash := collector.StatsFlowHash(record)
 
-	// If flow event doesn't have a count make it equal to 1. At least one flow is collected
-	if record.Count == 0 {
+	// If flow event doesn't have a count make it equal to 1. At least one flow is collected.
+	// The accounting of a flow that was already collected has no count.
+	if record.Count == 0 && record.Accounting == nil {
 		record.Count = 1
 	}
 
 	c.Lock()
 	defer c.Unlock()
 
+	if c.samplingRate > 1 && record.Accounting == nil {
+		if c.random(c.samplingRate) != 0 {
+			return
+		}
+		record.Count = record.Count * c.samplingRate
+	}
+
 	if r, ok := c.Flows[hash]; ok {
 		r.Count = r.Count + record.Count
+		if record.Accounting != nil {
+			if r.Accounting == nil {
+				r.Accounting = &collector.FlowAccounting{}
+			}
+			r.Accounting.Add(record.Accounting)
+		}
+		if record.Latency != nil {
+			if r.Latency == nil {
+				r.Latency = &collector.FlowLatency{}
+			}
+			r.Latency.Max(record.Latency)
+		}
 		return
 	}
 
@@ -32,3 +57,62 @@ func (c *collectorImpl) CollectFlowEvent(record *collector.FlowRecord) {
 func (c *collectorImpl) CollectContainerEvent(record *collector.ContainerRecord) {
 	zap.L().Error("Unexpected call for collecting container event")
 }
+
+// CollectPacketEvent keeps the packet records until they are reported. The
+// records above maxPacketRecords are dropped.
+func (c *collectorImpl) CollectPacketEvent(record *collector.PacketRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.Packets) < maxPacketRecords {
+		c.Packets = append(c.Packets, record)
+	}
+}
+
+// CollectQueueStats keeps the latest counters of every queue until they are
+// reported.
+func (c *collectorImpl) CollectQueueStats(record *collector.QueueStatsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.QueueStats[record.ContextID+":"+strconv.Itoa(int(record.Queue))+":"+strconv.FormatBool(record.Network)] = record
+}
+
+// CollectCacheStats keeps the latest counters of every cache until they are
+// reported.
+func (c *collectorImpl) CollectCacheStats(record *collector.CacheStatsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.CacheStats[record.ContextID+":"+record.Cache] = record
+}
+
+// CollectEnforcerStats keeps the latest resources used by the enforcer until
+// they are reported.
+func (c *collectorImpl) CollectEnforcerStats(record *collector.EnforcerStatsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.EnforcerStats = record
+}
+
+// CollectConnectionMetrics aggregates the connection metrics of a PU until they
+// are reported. The concurrent connections are always the latest known value.
+func (c *collectorImpl) CollectConnectionMetrics(record *collector.ConnectionMetricsRecord) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if r, ok := c.ConnectionMetrics[record.ContextID]; ok {
+		r.NewConnections = r.NewConnections + record.NewConnections
+		r.ConcurrentConnections = record.ConcurrentConnections
+		r.Interval = r.Interval + record.Interval
+		return
+	}
+
+	c.ConnectionMetrics[record.ContextID] = record
+}
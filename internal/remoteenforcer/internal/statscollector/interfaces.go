@@ -8,10 +8,20 @@ import (
 type CollectorReader interface {
 	Count() int
 	GetAllRecords() map[string]*collector.FlowRecord
+	GetAllConnectionMetrics() map[string]*collector.ConnectionMetricsRecord
+	GetAllPacketRecords() []*collector.PacketRecord
+	GetAllQueueStats() []*collector.QueueStatsRecord
+	GetAllCacheStats() []*collector.CacheStatsRecord
+	GetEnforcerStats() *collector.EnforcerStatsRecord
 }
 
 // Collector interface implements
 type Collector interface {
 	CollectorReader
 	collector.EventCollector
+	collector.PacketEventCollector
+	collector.ConnectionMetricsCollector
+	collector.EnforcerStatsCollector
+	collector.CacheStatsCollector
+	collector.QueueStatsCollector
 }
@@ -66,6 +66,76 @@ func (mr *MockCollectorReaderMockRecorder) GetAllRecords() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllRecords", reflect.TypeOf((*MockCollectorReader)(nil).GetAllRecords))
 }
 
+// GetAllConnectionMetrics mocks base method
+// nolint
+func (m *MockCollectorReader) GetAllConnectionMetrics() map[string]*collector.ConnectionMetricsRecord {
+	ret := m.ctrl.Call(m, "GetAllConnectionMetrics")
+	ret0, _ := ret[0].(map[string]*collector.ConnectionMetricsRecord)
+	return ret0
+}
+
+// GetAllConnectionMetrics indicates an expected call of GetAllConnectionMetrics
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetAllConnectionMetrics() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllConnectionMetrics", reflect.TypeOf((*MockCollectorReader)(nil).GetAllConnectionMetrics))
+}
+
+// GetAllPacketRecords mocks base method
+// nolint
+func (m *MockCollectorReader) GetAllPacketRecords() []*collector.PacketRecord {
+	ret := m.ctrl.Call(m, "GetAllPacketRecords")
+	ret0, _ := ret[0].([]*collector.PacketRecord)
+	return ret0
+}
+
+// GetAllPacketRecords indicates an expected call of GetAllPacketRecords
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetAllPacketRecords() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllPacketRecords", reflect.TypeOf((*MockCollectorReader)(nil).GetAllPacketRecords))
+}
+
+// GetAllQueueStats mocks base method
+// nolint
+func (m *MockCollectorReader) GetAllQueueStats() []*collector.QueueStatsRecord {
+	ret := m.ctrl.Call(m, "GetAllQueueStats")
+	ret0, _ := ret[0].([]*collector.QueueStatsRecord)
+	return ret0
+}
+
+// GetAllQueueStats indicates an expected call of GetAllQueueStats
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetAllQueueStats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllQueueStats", reflect.TypeOf((*MockCollectorReader)(nil).GetAllQueueStats))
+}
+
+// GetAllCacheStats mocks base method
+// nolint
+func (m *MockCollectorReader) GetAllCacheStats() []*collector.CacheStatsRecord {
+	ret := m.ctrl.Call(m, "GetAllCacheStats")
+	ret0, _ := ret[0].([]*collector.CacheStatsRecord)
+	return ret0
+}
+
+// GetAllCacheStats indicates an expected call of GetAllCacheStats
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetAllCacheStats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllCacheStats", reflect.TypeOf((*MockCollectorReader)(nil).GetAllCacheStats))
+}
+
+// GetEnforcerStats mocks base method
+// nolint
+func (m *MockCollectorReader) GetEnforcerStats() *collector.EnforcerStatsRecord {
+	ret := m.ctrl.Call(m, "GetEnforcerStats")
+	ret0, _ := ret[0].(*collector.EnforcerStatsRecord)
+	return ret0
+}
+
+// GetEnforcerStats indicates an expected call of GetEnforcerStats
+// nolint
+func (mr *MockCollectorReaderMockRecorder) GetEnforcerStats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEnforcerStats", reflect.TypeOf((*MockCollectorReader)(nil).GetEnforcerStats))
+}
+
 // MockCollector is a mock of Collector interface
 // nolint
 type MockCollector struct {
@@ -121,6 +191,76 @@ func (mr *MockCollectorMockRecorder) GetAllRecords() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllRecords", reflect.TypeOf((*MockCollector)(nil).GetAllRecords))
 }
 
+// GetAllConnectionMetrics mocks base method
+// nolint
+func (m *MockCollector) GetAllConnectionMetrics() map[string]*collector.ConnectionMetricsRecord {
+	ret := m.ctrl.Call(m, "GetAllConnectionMetrics")
+	ret0, _ := ret[0].(map[string]*collector.ConnectionMetricsRecord)
+	return ret0
+}
+
+// GetAllConnectionMetrics indicates an expected call of GetAllConnectionMetrics
+// nolint
+func (mr *MockCollectorMockRecorder) GetAllConnectionMetrics() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllConnectionMetrics", reflect.TypeOf((*MockCollector)(nil).GetAllConnectionMetrics))
+}
+
+// GetAllPacketRecords mocks base method
+// nolint
+func (m *MockCollector) GetAllPacketRecords() []*collector.PacketRecord {
+	ret := m.ctrl.Call(m, "GetAllPacketRecords")
+	ret0, _ := ret[0].([]*collector.PacketRecord)
+	return ret0
+}
+
+// GetAllPacketRecords indicates an expected call of GetAllPacketRecords
+// nolint
+func (mr *MockCollectorMockRecorder) GetAllPacketRecords() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllPacketRecords", reflect.TypeOf((*MockCollector)(nil).GetAllPacketRecords))
+}
+
+// GetAllQueueStats mocks base method
+// nolint
+func (m *MockCollector) GetAllQueueStats() []*collector.QueueStatsRecord {
+	ret := m.ctrl.Call(m, "GetAllQueueStats")
+	ret0, _ := ret[0].([]*collector.QueueStatsRecord)
+	return ret0
+}
+
+// GetAllQueueStats indicates an expected call of GetAllQueueStats
+// nolint
+func (mr *MockCollectorMockRecorder) GetAllQueueStats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllQueueStats", reflect.TypeOf((*MockCollector)(nil).GetAllQueueStats))
+}
+
+// GetAllCacheStats mocks base method
+// nolint
+func (m *MockCollector) GetAllCacheStats() []*collector.CacheStatsRecord {
+	ret := m.ctrl.Call(m, "GetAllCacheStats")
+	ret0, _ := ret[0].([]*collector.CacheStatsRecord)
+	return ret0
+}
+
+// GetAllCacheStats indicates an expected call of GetAllCacheStats
+// nolint
+func (mr *MockCollectorMockRecorder) GetAllCacheStats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllCacheStats", reflect.TypeOf((*MockCollector)(nil).GetAllCacheStats))
+}
+
+// GetEnforcerStats mocks base method
+// nolint
+func (m *MockCollector) GetEnforcerStats() *collector.EnforcerStatsRecord {
+	ret := m.ctrl.Call(m, "GetEnforcerStats")
+	ret0, _ := ret[0].(*collector.EnforcerStatsRecord)
+	return ret0
+}
+
+// GetEnforcerStats indicates an expected call of GetEnforcerStats
+// nolint
+func (mr *MockCollectorMockRecorder) GetEnforcerStats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEnforcerStats", reflect.TypeOf((*MockCollector)(nil).GetEnforcerStats))
+}
+
 // CollectFlowEvent mocks base method
 // nolint
 func (m *MockCollector) CollectFlowEvent(record *collector.FlowRecord) {
@@ -144,3 +284,63 @@ func (m *MockCollector) CollectContainerEvent(record *collector.ContainerRecord)
 func (mr *MockCollectorMockRecorder) CollectContainerEvent(record interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectContainerEvent", reflect.TypeOf((*MockCollector)(nil).CollectContainerEvent), record)
 }
+
+// CollectConnectionMetrics mocks base method
+// nolint
+func (m *MockCollector) CollectConnectionMetrics(record *collector.ConnectionMetricsRecord) {
+	m.ctrl.Call(m, "CollectConnectionMetrics", record)
+}
+
+// CollectConnectionMetrics indicates an expected call of CollectConnectionMetrics
+// nolint
+func (mr *MockCollectorMockRecorder) CollectConnectionMetrics(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectConnectionMetrics", reflect.TypeOf((*MockCollector)(nil).CollectConnectionMetrics), record)
+}
+
+// CollectPacketEvent mocks base method
+// nolint
+func (m *MockCollector) CollectPacketEvent(record *collector.PacketRecord) {
+	m.ctrl.Call(m, "CollectPacketEvent", record)
+}
+
+// CollectPacketEvent indicates an expected call of CollectPacketEvent
+// nolint
+func (mr *MockCollectorMockRecorder) CollectPacketEvent(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectPacketEvent", reflect.TypeOf((*MockCollector)(nil).CollectPacketEvent), record)
+}
+
+// CollectQueueStats mocks base method
+// nolint
+func (m *MockCollector) CollectQueueStats(record *collector.QueueStatsRecord) {
+	m.ctrl.Call(m, "CollectQueueStats", record)
+}
+
+// CollectQueueStats indicates an expected call of CollectQueueStats
+// nolint
+func (mr *MockCollectorMockRecorder) CollectQueueStats(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectQueueStats", reflect.TypeOf((*MockCollector)(nil).CollectQueueStats), record)
+}
+
+// CollectCacheStats mocks base method
+// nolint
+func (m *MockCollector) CollectCacheStats(record *collector.CacheStatsRecord) {
+	m.ctrl.Call(m, "CollectCacheStats", record)
+}
+
+// CollectCacheStats indicates an expected call of CollectCacheStats
+// nolint
+func (mr *MockCollectorMockRecorder) CollectCacheStats(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectCacheStats", reflect.TypeOf((*MockCollector)(nil).CollectCacheStats), record)
+}
+
+// CollectEnforcerStats mocks base method
+// nolint
+func (m *MockCollector) CollectEnforcerStats(record *collector.EnforcerStatsRecord) {
+	m.ctrl.Call(m, "CollectEnforcerStats", record)
+}
+
+// CollectEnforcerStats indicates an expected call of CollectEnforcerStats
+// nolint
+func (mr *MockCollectorMockRecorder) CollectEnforcerStats(record interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CollectEnforcerStats", reflect.TypeOf((*MockCollector)(nil).CollectEnforcerStats), record)
+}
@@ -0,0 +1,67 @@
+package remoteenforcer
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// parseLogLevel returns the level of a log level name, like debug
+func parseLogLevel(name string) (zapcore.Level, error) {
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("invalid log level %s", name)
+	}
+
+	return level, nil
+}
+
+// levelCore is a zapcore.Core that logs the entries at or above its level with
+// the core of the logger of the application, whatever the level of this core.
+// The level of the logs can then be raised or lowered at runtime.
+type levelCore struct {
+	core  zapcore.Core
+	level zap.AtomicLevel
+}
+
+// newLevelCore returns a core that logs the entries of core at or above level
+func newLevelCore(core zapcore.Core, level zap.AtomicLevel) zapcore.Core {
+
+	return &levelCore{
+		core:  core,
+		level: level,
+	}
+}
+
+// Enabled implements zapcore.Core
+func (l *levelCore) Enabled(level zapcore.Level) bool {
+	return l.level.Enabled(level)
+}
+
+// With implements zapcore.Core
+func (l *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return newLevelCore(l.core.With(fields), l.level)
+}
+
+// Check implements zapcore.Core
+func (l *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+
+	if l.Enabled(entry.Level) {
+		return checked.AddCore(entry, l)
+	}
+
+	return checked
+}
+
+// Write implements zapcore.Core. The level of the core of the application is
+// not checked again.
+func (l *levelCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return l.core.Write(entry, fields)
+}
+
+// Sync implements zapcore.Core
+func (l *levelCore) Sync() error {
+	return l.core.Sync()
+}
@@ -0,0 +1,56 @@
+package remoteenforcer
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLevelCore(t *testing.T) {
+	Convey("Given a core of the application at the info level", t, func() {
+		core, logs := observer.New(zapcore.InfoLevel)
+		level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+		logger := zap.New(newLevelCore(core, level)).With(zap.String("contextID", "pu1"))
+
+		Convey("When I log at the info level, only the entries at or above info should be logged", func() {
+			logger.Debug("ignored")
+			logger.Info("logged")
+
+			So(logs.Len(), ShouldEqual, 1)
+			So(logs.All()[0].Message, ShouldEqual, "logged")
+			So(logs.All()[0].ContextMap()["contextID"], ShouldEqual, "pu1")
+		})
+
+		Convey("When I raise the level to debug, the debug entries should be logged", func() {
+			level.SetLevel(zapcore.DebugLevel)
+			logger.Debug("logged")
+
+			So(logs.Len(), ShouldEqual, 1)
+			So(logs.All()[0].Level, ShouldEqual, zapcore.DebugLevel)
+
+			Convey("When I lower it again to warn, the info entries should be ignored", func() {
+				level.SetLevel(zapcore.WarnLevel)
+				logger.Info("ignored")
+
+				So(logs.Len(), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given a log level name", t, func() {
+		Convey("When it is valid, it should be parsed", func() {
+			level, err := parseLogLevel("debug")
+			So(err, ShouldBeNil)
+			So(level, ShouldEqual, zapcore.DebugLevel)
+		})
+
+		Convey("When it is invalid, it should fail", func() {
+			_, err := parseLogLevel("verbose")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -135,3 +135,59 @@ func (m *MockRemoteIntf) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.R
 func (mr *MockRemoteIntfMockRecorder) EnforcerExit(req, resp interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnforcerExit", reflect.TypeOf((*MockRemoteIntf)(nil).EnforcerExit), req, resp)
 }
+
+// Heartbeat mocks base method
+// nolint
+func (m *MockRemoteIntf) Heartbeat(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "Heartbeat", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Heartbeat indicates an expected call of Heartbeat
+// nolint
+func (mr *MockRemoteIntfMockRecorder) Heartbeat(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Heartbeat", reflect.TypeOf((*MockRemoteIntf)(nil).Heartbeat), req, resp)
+}
+
+// SetLogForwarding mocks base method
+// nolint
+func (m *MockRemoteIntf) SetLogForwarding(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "SetLogForwarding", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLogForwarding indicates an expected call of SetLogForwarding
+// nolint
+func (mr *MockRemoteIntfMockRecorder) SetLogForwarding(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogForwarding", reflect.TypeOf((*MockRemoteIntf)(nil).SetLogForwarding), req, resp)
+}
+
+// SetLogLevel mocks base method
+// nolint
+func (m *MockRemoteIntf) SetLogLevel(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "SetLogLevel", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLogLevel indicates an expected call of SetLogLevel
+// nolint
+func (mr *MockRemoteIntfMockRecorder) SetLogLevel(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogLevel", reflect.TypeOf((*MockRemoteIntf)(nil).SetLogLevel), req, resp)
+}
+
+// SetDebugListener mocks base method
+// nolint
+func (m *MockRemoteIntf) SetDebugListener(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	ret := m.ctrl.Call(m, "SetDebugListener", req, resp)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetDebugListener indicates an expected call of SetDebugListener
+// nolint
+func (mr *MockRemoteIntfMockRecorder) SetDebugListener(req, resp interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDebugListener", reflect.TypeOf((*MockRemoteIntf)(nil).SetDebugListener), req, resp)
+}
@@ -9,7 +9,7 @@ package remoteenforcer
 import "C"
 
 import (
-	"crypto/ecdsa"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -23,13 +23,17 @@ import (
 	"golang.org/x/sys/unix"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
+	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer"
 	"github.com/aporeto-inc/trireme-lib/enforcer/packetprocessor"
+	"github.com/aporeto-inc/trireme-lib/enforcer/policyenforcer"
 	_ "github.com/aporeto-inc/trireme-lib/enforcer/utils/nsenter" // nolint
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme-lib/internal/remoteenforcer/internal/statsclient"
 	"github.com/aporeto-inc/trireme-lib/internal/remoteenforcer/internal/statscollector"
 	"github.com/aporeto-inc/trireme-lib/internal/supervisor"
@@ -48,12 +52,44 @@ func newServer(
 ) (s RemoteIntf, err error) {
 
 	var collector statscollector.Collector
+	var logLevel *zap.AtomicLevel
+	var crash *crashReporter
 	if statsClient == nil {
-		collector = statscollector.NewCollector()
+		var opts []statscollector.Option
+		if sampling := os.Getenv(constants.AporetoEnvStatsSampling); sampling != "" {
+			rate, err := strconv.Atoi(sampling)
+			if err != nil {
+				return nil, fmt.Errorf("invalid stats sampling rate %s", sampling)
+			}
+			opts = append(opts, statscollector.OptionFlowSampling(rate))
+		}
+
+		collector = statscollector.NewCollector(opts...)
 		statsClient, err = statsclient.NewStatsClient(collector)
 		if err != nil {
 			return nil, err
 		}
+
+		level, err := parseLogLevel(os.Getenv(constants.AporetoEnvLogLevel))
+		if err != nil {
+			level = zapcore.InfoLevel
+		}
+		atomicLevel := zap.NewAtomicLevelAt(level)
+		logLevel = &atomicLevel
+
+		// The last lines logged are kept for the crash reports
+		crash = newCrashReporter(
+			os.Getenv(constants.AporetoEnvContextID),
+			os.Getenv(constants.AporetoEnvCrashDir),
+			statsClient.ReportCrash,
+		)
+
+		// The level of the logs can be changed at runtime, and the logs are
+		// also forwarded to the controller, at their own level
+		logCore := statsClient.LogCore()
+		zap.ReplaceGlobals(zap.L().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(newLevelCore(zapcore.NewTee(core, newCrashCore(crash)), atomicLevel), logCore)
+		})))
 	}
 
 	procMountPoint := os.Getenv(constants.AporetoEnvMountPoint)
@@ -69,6 +105,9 @@ func newServer(
 		rpcHandle:      rpcHandle,
 		procMountPoint: procMountPoint,
 		statsClient:    statsClient,
+		logLevel:       logLevel,
+		crash:          crash,
+		hardening:      os.Getenv(constants.AporetoEnvHardening) != "",
 	}, nil
 }
 
@@ -94,8 +133,13 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) (err error) {
 
 	switch payload.SecretType {
 	case secrets.PKIType:
-		// PKI params
-		s.secrets, err = secrets.NewPKISecrets(payload.PrivatePEM, payload.PublicPEM, payload.CAPEM, map[string]*ecdsa.PublicKey{})
+		// PKI params. The certificates of the other enforcers are transmitted
+		// in band, since they are not added to the remote enforcers
+		if payload.PrivateKeyURI != "" {
+			s.secrets, err = secrets.NewPKISecretsWithSigner(payload.PrivateKeyURI, payload.PublicPEM, payload.CAPEM, nil)
+		} else {
+			s.secrets, err = secrets.NewPKISecrets(payload.PrivatePEM, payload.PublicPEM, payload.CAPEM, nil)
+		}
 		if err != nil {
 			return fmt.Errorf("unable to initialize secrets: %s", err)
 		}
@@ -106,7 +150,11 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) (err error) {
 
 	case secrets.PKICompactType:
 		// Compact PKI Parameters
-		s.secrets, err = secrets.NewCompactPKIWithTokenCA(payload.PrivatePEM, payload.PublicPEM, payload.CAPEM, payload.TokenKeyPEMs, payload.Token)
+		if payload.PrivateKeyURI != "" {
+			s.secrets, err = secrets.NewCompactPKIWithSigner(payload.PrivateKeyURI, payload.PublicPEM, payload.CAPEM, payload.TokenKeyPEMs, payload.Token)
+		} else {
+			s.secrets, err = secrets.NewCompactPKIWithTokenCA(payload.PrivatePEM, payload.PublicPEM, payload.CAPEM, payload.TokenKeyPEMs, payload.Token)
+		}
 		if err != nil {
 			return fmt.Errorf("unable to initialize secrets: %s", err)
 		}
@@ -138,6 +186,16 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) (err error) {
 		return errors.New("unable to setup enforcer: we don't know as this function does not return an error")
 	}
 
+	if payload.TokenFormat != tokens.CompactFormat {
+		formatter, ok := s.enforcer.(policyenforcer.TokenFormatter)
+		if !ok {
+			return errors.New("enforcer does not support the token format")
+		}
+		if err := formatter.SetTokenFormat(payload.TokenFormat); err != nil {
+			return fmt.Errorf("unable to set token format: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -145,6 +203,12 @@ func (s *RemoteEnforcer) setupEnforcer(req rpcwrapper.Request) (err error) {
 // data structure required by the remote enforcer
 func (s *RemoteEnforcer) InitEnforcer(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
+	defer s.crash.recoverCrash()
+
+	// Report the version of the payloads we support so that the controller
+	// can negotiate the version of the next requests
+	resp.Version = rpcwrapper.ProtocolVersion
+
 	// Check if successfully switched namespace
 	nsEnterState := getCEnvVariable(constants.AporetoEnvNsenterErrorState)
 	nsEnterLogMsg := getCEnvVariable(constants.AporetoEnvNsenterLogs)
@@ -188,6 +252,11 @@ func (s *RemoteEnforcer) InitEnforcer(req rpcwrapper.Request, resp *rpcwrapper.R
 		return fmt.Errorf(resp.Status)
 	}
 
+	if _, err := rpcwrapper.NegotiateVersion(req.Version); err != nil {
+		resp.Status = fmt.Sprintf("incompatible controller: %s", err)
+		return fmt.Errorf(resp.Status)
+	}
+
 	cmdLock.Lock()
 	defer cmdLock.Unlock()
 
@@ -206,6 +275,16 @@ func (s *RemoteEnforcer) InitEnforcer(req rpcwrapper.Request, resp *rpcwrapper.R
 		return nil
 	}
 
+	// The capabilities were dropped when the namespace was entered, and the
+	// syscalls are filtered once the enforcer is initialized
+	if s.hardening && !s.filtered {
+		if err := installSeccompFilter(); err != nil {
+			resp.Status = err.Error()
+			return nil
+		}
+		s.filtered = true
+	}
+
 	resp.Status = ""
 	return nil
 }
@@ -213,6 +292,8 @@ func (s *RemoteEnforcer) InitEnforcer(req rpcwrapper.Request, resp *rpcwrapper.R
 // InitSupervisor is a function called from the controller over RPC. It initializes data structure required by the supervisor
 func (s *RemoteEnforcer) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
+	defer s.crash.recoverCrash()
+
 	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
 		resp.Status = fmt.Sprintf("supervisor init message auth failed")
 		return fmt.Errorf(resp.Status)
@@ -223,23 +304,30 @@ func (s *RemoteEnforcer) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper
 
 	payload := req.Payload.(rpcwrapper.InitSupervisorPayload)
 	if s.supervisor == nil {
-		switch payload.CaptureMethod {
-		case rpcwrapper.IPSets:
-			//TO DO
-			return errors.New("ipsets not supported yet")
-		default:
-			supervisorHandle, err := supervisor.NewSupervisor(
-				s.collector,
-				s.enforcer,
-				constants.RemoteContainer,
-				payload.TriremeNetworks,
-			)
-			if err != nil {
-				zap.L().Error("unable to instantiate the iptables supervisor", zap.Error(err))
-				return err
-			}
-			s.supervisor = supervisorHandle
+		implementation := constants.IPTables
+		if payload.CaptureMethod == rpcwrapper.IPSets {
+			implementation = constants.IPSets
+		}
+
+		opts := []supervisor.Option{}
+		if payload.UDPEnforcement {
+			opts = append(opts, supervisor.OptionUDPEnforcement())
+		}
+
+		supervisorHandle, err := supervisor.NewSupervisor(
+			s.collector,
+			s.enforcer,
+			constants.RemoteContainer,
+			implementation,
+			payload.TriremeNetworks,
+			opts...,
+		)
+		if err != nil {
+			zap.L().Error("unable to instantiate the iptables supervisor", zap.Error(err))
+			return err
 		}
+		supervisorHandle.SetFailMode(payload.FailMode)
+		s.supervisor = supervisorHandle
 
 		if err := s.supervisor.Start(); err != nil {
 			zap.L().Error("unable to start the supervisor", zap.Error(err))
@@ -263,6 +351,8 @@ func (s *RemoteEnforcer) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper
 // Supervise This method calls the supervisor method on the supervisor created during initsupervisor
 func (s *RemoteEnforcer) Supervise(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
+	defer s.crash.recoverCrash()
+
 	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
 		resp.Status = fmt.Sprintf("supervise message auth failed")
 		return fmt.Errorf(resp.Status)
@@ -294,7 +384,7 @@ func (s *RemoteEnforcer) Supervise(req rpcwrapper.Request, resp *rpcwrapper.Resp
 
 	zap.L().Debug("Called Supervise Start in remote_enforcer")
 
-	err := s.supervisor.Supervise(payload.ContextID, puInfo)
+	err := s.supervisor.Supervise(context.Background(), payload.ContextID, puInfo)
 	if err != nil {
 		zap.L().Error("Unable to initialize supervisor",
 			zap.String("ContextID", payload.ContextID),
@@ -311,6 +401,8 @@ func (s *RemoteEnforcer) Supervise(req rpcwrapper.Request, resp *rpcwrapper.Resp
 // Unenforce this method calls the unenforce method on the enforcer created from initenforcer
 func (s *RemoteEnforcer) Unenforce(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
+	defer s.crash.recoverCrash()
+
 	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
 		resp.Status = "unenforce message auth failed"
 		return fmt.Errorf(resp.Status)
@@ -326,6 +418,8 @@ func (s *RemoteEnforcer) Unenforce(req rpcwrapper.Request, resp *rpcwrapper.Resp
 // Unsupervise This method calls the unsupervise method on the supervisor created during initsupervisor
 func (s *RemoteEnforcer) Unsupervise(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
+	defer s.crash.recoverCrash()
+
 	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
 		resp.Status = "unsupervise message auth failed"
 		return fmt.Errorf(resp.Status)
@@ -335,12 +429,14 @@ func (s *RemoteEnforcer) Unsupervise(req rpcwrapper.Request, resp *rpcwrapper.Re
 	defer cmdLock.Unlock()
 
 	payload := req.Payload.(rpcwrapper.UnSupervisePayload)
-	return s.supervisor.Unsupervise(payload.ContextID)
+	return s.supervisor.Unsupervise(context.Background(), payload.ContextID)
 }
 
 // Enforce this method calls the enforce method on the enforcer created during initenforcer
 func (s *RemoteEnforcer) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
+	defer s.crash.recoverCrash()
+
 	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
 		resp.Status = "enforce message auth failed"
 		return fmt.Errorf(resp.Status)
@@ -364,6 +460,21 @@ func (s *RemoteEnforcer) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Respon
 		payload.ExcludedNetworks,
 		payload.ProxiedServices)
 
+	if err := pupolicy.SetIdentityClaims(payload.IdentityClaims); err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	if err := pupolicy.SetHTTPRules(payload.HTTPRules); err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	if err := pupolicy.SetSNIRules(payload.SNIRules); err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
 	runtime := policy.NewPURuntimeWithDefaults()
 	puInfo := policy.PUInfoFromPolicyAndRuntime(payload.ContextID, pupolicy, runtime)
 	if puInfo == nil {
@@ -372,6 +483,9 @@ func (s *RemoteEnforcer) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Respon
 	if s.enforcer == nil {
 		zap.L().Fatal("Enforcer not initialized")
 	}
+	if revoker, ok := s.secrets.(secrets.Revoker); ok {
+		revoker.Revocations().Add(payload.RevokedSerials, payload.RevokedTokens)
+	}
 	if err := s.enforcer.Enforce(payload.ContextID, puInfo); err != nil {
 		resp.Status = err.Error()
 		return err
@@ -384,10 +498,148 @@ func (s *RemoteEnforcer) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Respon
 	return nil
 }
 
+// Heartbeat this method is called periodically by the controller to verify that
+// the remote enforcer is alive and enforcing
+func (s *RemoteEnforcer) Heartbeat(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "heartbeat message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.enforcer == nil {
+		resp.Status = "enforcer not initialized"
+		return fmt.Errorf(resp.Status)
+	}
+
+	resp.Status = ""
+
+	return nil
+}
+
+// SetLogForwarding this method is called by the controller to change the level
+// of the logs forwarded to it. The logs are not forwarded if the level is empty.
+func (s *RemoteEnforcer) SetLogForwarding(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "log forwarding message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.statsClient == nil {
+		resp.Status = "stats client not started"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.LogForwardingPayload)
+	if err := s.statsClient.SetLogForwardLevel(payload.Level); err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	return nil
+}
+
+// SetLogLevel this method is called by the controller to change the level of
+// the logs of the remote enforcer, like debug to troubleshoot its PUs
+func (s *RemoteEnforcer) SetLogLevel(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "log level message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.logLevel == nil {
+		resp.Status = "log level cannot be changed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.LogLevelPayload)
+	level, err := parseLogLevel(payload.Level)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	s.logLevel.SetLevel(level)
+
+	return nil
+}
+
+// SetDebugListener this method is called by the controller to start or stop the
+// debug listener of the remote enforcer. It listens on the RPC socket of the
+// remote enforcer with the -debug suffix, like /var/run/pu1-debug.sock.
+func (s *RemoteEnforcer) SetDebugListener(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpcHandle.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = "debug listener message auth failed"
+		return fmt.Errorf(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	payload := req.Payload.(rpcwrapper.DebugListenerPayload)
+
+	if !payload.Enable {
+		if s.debug == nil {
+			return nil
+		}
+		if err := s.debug.stop(); err != nil {
+			resp.Status = err.Error()
+			return err
+		}
+		s.debug = nil
+		zap.L().Info("Debug listener stopped")
+		return nil
+	}
+
+	if s.debug != nil {
+		return nil
+	}
+
+	debug, err := startDebugListener(debugSocketPath(s.rpcChannel), newDebugHandler(s.cacheStats))
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+	s.debug = debug
+
+	zap.L().Info("Debug listener started", zap.String("socket", debug.path))
+
+	return nil
+}
+
+// cacheStats returns the counters of the caches of the datapath, if the
+// enforcer is initialized
+func (s *RemoteEnforcer) cacheStats() []*collector.CacheStatsRecord {
+
+	cmdLock.Lock()
+	reporter, ok := s.enforcer.(policyenforcer.CacheStatsReporter)
+	cmdLock.Unlock()
+
+	if !ok {
+		return []*collector.CacheStatsRecord{}
+	}
+
+	return reporter.GetCacheStats()
+}
+
 // EnforcerExit this method is called when  we received a killrpocess message from the controller
 // This allows a graceful exit of the enforcer
 func (s *RemoteEnforcer) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
+	defer s.crash.recoverCrash()
+
 	cmdLock.Lock()
 	defer cmdLock.Unlock()
 
@@ -408,6 +660,13 @@ func (s *RemoteEnforcer) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.R
 		s.enforcer = nil
 	}
 
+	if s.debug != nil {
+		if err := s.debug.stop(); err != nil {
+			msgErrors = append(msgErrors, fmt.Sprintf("debug listener error: %s", err))
+		}
+		s.debug = nil
+	}
+
 	if s.statsClient != nil {
 		s.statsClient.Stop()
 		s.statsClient = nil
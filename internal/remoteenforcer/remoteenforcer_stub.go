@@ -58,3 +58,27 @@ func (s *RemoteEnforcer) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Respon
 func (s *RemoteEnforcer) EnforcerExit(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 	return nil
 }
+
+// Heartbeat this method is called periodically by the controller to verify that
+// the remote enforcer is alive and enforcing
+func (s *RemoteEnforcer) Heartbeat(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// SetLogForwarding this method is called by the controller to change the
+// level of the logs forwarded to it
+func (s *RemoteEnforcer) SetLogForwarding(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// SetLogLevel this method is called by the controller to change the level of
+// the logs of the remote enforcer
+func (s *RemoteEnforcer) SetLogLevel(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
+
+// SetDebugListener this method is called by the controller to start or stop
+// the debug listener of the remote enforcer
+func (s *RemoteEnforcer) SetDebugListener(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+	return nil
+}
@@ -305,8 +305,9 @@ func TestInitEnforcer(t *testing.T) {
 
 				err := server.InitEnforcer(rpcwrperreq, &rpcwrperres)
 
-				Convey("Then I should get no error", func() {
+				Convey("Then I should get no error and the version of the payloads", func() {
 					So(err, ShouldBeNil)
+					So(rpcwrperres.Version, ShouldEqual, rpcwrapper.ProtocolVersion)
 				})
 				serr = os.Setenv(constants.AporetoEnvStatsChannel, "")
 				So(serr, ShouldBeNil)
@@ -386,8 +387,9 @@ func TestInitSupervisor(t *testing.T) {
 
 				err := server.InitSupervisor(rpcwrperreq, &rpcwrperres)
 
-				Convey("Then I should get error for no IPset support", func() {
-					So(err, ShouldResemble, errors.New("ipsets not supported yet"))
+				Convey("Then I should get no error", func() {
+					So(err, ShouldBeNil)
+					So(server.supervisor, ShouldNotBeNil)
 				})
 			})
 
@@ -454,7 +456,7 @@ func TestInitSupervisor(t *testing.T) {
 				collector := &collector.DefaultCollector{}
 				secret := secrets.NewPSKSecrets([]byte("Dummy Test Password"))
 				server.enforcer = enforcer.NewWithDefaults("someServerID", collector, nil, secret, constants.RemoteContainer, "/proc").(*datapath.Datapath)
-				server.supervisor, _ = supervisor.NewSupervisor(collector, server.enforcer, constants.RemoteContainer, []string{})
+				server.supervisor, _ = supervisor.NewSupervisor(collector, server.enforcer, constants.RemoteContainer, constants.IPTables, []string{})
 
 				err := server.InitSupervisor(rpcwrperreq, &rpcwrperres)
 
@@ -540,7 +542,7 @@ func TestLaunchRemoteEnforcer(t *testing.T) {
 				c := &collector.DefaultCollector{}
 				secrets := secrets.NewPSKSecrets([]byte("test password"))
 				e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
-				server.supervisor, _ = supervisor.NewSupervisor(c, e, constants.RemoteContainer, []string{})
+				server.supervisor, _ = supervisor.NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{})
 				server.enforcer = nil
 				err := server.EnforcerExit(rpcwrapper.Request{}, &rpcwrapper.Response{})
 
@@ -622,7 +624,7 @@ func TestSupervise(t *testing.T) {
 
 			Convey("When I try to send supervise command", func() {
 				rpcHdl.EXPECT().CheckValidity(gomock.Any(), os.Getenv(constants.AporetoEnvStatsSecret)).Times(1).Return(true)
-				mockSup.EXPECT().Supervise("ac0d3577e808", gomock.Any()).Times(1).Return(nil)
+				mockSup.EXPECT().Supervise(gomock.Any(), "ac0d3577e808", gomock.Any()).Times(1).Return(nil)
 				var rpcwrperreq rpcwrapper.Request
 				var rpcwrperres rpcwrapper.Response
 
@@ -927,7 +929,7 @@ func TestUnSupervise(t *testing.T) {
 				secrets := secrets.NewPSKSecrets([]byte("test password"))
 				e := enforcer.NewWithDefaults("ac0d3577e808", c, nil, secrets, constants.RemoteContainer, "/proc")
 
-				server.supervisor, _ = supervisor.NewSupervisor(c, e, constants.RemoteContainer, []string{})
+				server.supervisor, _ = supervisor.NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{})
 
 				err := server.Unsupervise(rpcwrperreq, &rpcwrperres)
 
@@ -937,7 +939,7 @@ func TestUnSupervise(t *testing.T) {
 			})
 
 			Convey("When I try to send unsupervise command", func() {
-				mockSup.EXPECT().Unsupervise("ac0d3577e808").Times(1).Return(nil)
+				mockSup.EXPECT().Unsupervise(gomock.Any(), "ac0d3577e808").Times(1).Return(nil)
 				var rpcwrperreq rpcwrapper.Request
 				var rpcwrperres rpcwrapper.Response
 
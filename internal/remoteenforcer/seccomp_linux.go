@@ -0,0 +1,143 @@
+// +build linux
+
+package remoteenforcer
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// seccompSetModeFilter is the SECCOMP_SET_MODE_FILTER operation
+	seccompSetModeFilter = 1
+	// seccompFilterFlagTsync is the SECCOMP_FILTER_FLAG_TSYNC flag, which
+	// installs the filter on all the threads of the process
+	seccompFilterFlagTsync = 1
+	// seccompRetAllow is the SECCOMP_RET_ALLOW action
+	seccompRetAllow = 0x7fff0000
+	// seccompRetErrno is the SECCOMP_RET_ERRNO action
+	seccompRetErrno = 0x00050000
+	// seccompDataArch and seccompDataNr are the offsets of the architecture
+	// and of the number of the syscall in the struct seccomp_data
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	// x32SyscallBit is set in the numbers of the syscalls of the x32 ABI
+	x32SyscallBit = 0x40000000
+)
+
+// auditArches are the audit architectures of the architectures supported by
+// the seccomp filter
+var auditArches = map[string]uint32{
+	"amd64":   0xc000003e,
+	"arm64":   0xc00000b7,
+	"ppc64le": 0xc0000015,
+	"s390x":   0x80000016,
+}
+
+// deniedSyscalls are the syscalls a remote enforcer never needs once it is
+// initialized, like the ones that change namespaces, mount filesystems, load
+// kernel modules or inspect other processes
+var deniedSyscalls = []uintptr{
+	unix.SYS_ACCT,
+	unix.SYS_ADD_KEY,
+	unix.SYS_ADJTIMEX,
+	unix.SYS_BPF,
+	unix.SYS_CHROOT,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEYCTL,
+	unix.SYS_MOUNT,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PTRACE,
+	unix.SYS_QUOTACTL,
+	unix.SYS_REBOOT,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SETNS,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_SWAPOFF,
+	unix.SYS_SWAPON,
+	unix.SYS_SYSLOG,
+	unix.SYS_UMOUNT2,
+	unix.SYS_UNSHARE,
+	unix.SYS_USERFAULTFD,
+}
+
+// seccompFilter returns the BPF program that fails the denied syscalls with
+// EPERM, and all the syscalls of the other architectures and ABIs
+func seccompFilter(arch uint32, denied []uintptr) []unix.SockFilter {
+
+	// The program loads the architecture, checks it, loads the number of the
+	// syscall, checks the ABI and then the denied syscalls, and ends with the
+	// allow and the deny returns
+	deny := len(denied) + 5
+	jumpToDeny := func(index int) uint8 {
+		return uint8(deny - index - 1)
+	}
+
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompDataArch},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jf: jumpToDeny(1), K: arch},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompDataNr},
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: jumpToDeny(3), K: x32SyscallBit},
+	}
+
+	for _, nr := range denied {
+		filter = append(filter, unix.SockFilter{
+			Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K,
+			Jt:   jumpToDeny(len(filter)),
+			K:    uint32(nr),
+		})
+	}
+
+	return append(filter,
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetAllow},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetErrno | uint32(unix.EPERM)},
+	)
+}
+
+// installSeccompFilter installs the seccomp filter of the denied syscalls on
+// all the threads of the remote enforcer. It cannot be removed, and the
+// programs executed afterwards, like iptables, inherit it.
+func installSeccompFilter() error {
+
+	arch, ok := auditArches[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("seccomp filter not supported on %s", runtime.GOARCH)
+	}
+
+	filter := seccompFilter(arch, deniedSyscalls)
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	// The flag is set on the current thread, and the filter synchronizes it
+	// on the other threads
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("unable to set no new privileges: %s", err)
+	}
+
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("unable to install seccomp filter: %s", errno)
+	}
+
+	if tid != 0 {
+		return fmt.Errorf("unable to install seccomp filter on thread %d", tid)
+	}
+
+	return nil
+}
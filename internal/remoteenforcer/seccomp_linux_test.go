@@ -0,0 +1,69 @@
+// +build linux
+
+package remoteenforcer
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// runSeccompFilter runs the instructions of a seccomp filter used by
+// seccompFilter on a syscall, and returns the action of the filter
+func runSeccompFilter(filter []unix.SockFilter, arch uint32, nr uint32) uint32 {
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = nr
+			if ins.K == seccompDataArch {
+				acc = arch
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			if acc >= ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		}
+	}
+
+	return 0
+}
+
+func TestSeccompFilter(t *testing.T) {
+	Convey("Given the seccomp filter of an architecture", t, func() {
+		arch := auditArches["amd64"]
+		filter := seccompFilter(arch, deniedSyscalls)
+		denied := seccompRetErrno | uint32(unix.EPERM)
+
+		Convey("Then the denied syscalls should fail with EPERM", func() {
+			for _, nr := range deniedSyscalls {
+				So(runSeccompFilter(filter, arch, uint32(nr)), ShouldEqual, denied)
+			}
+		})
+
+		Convey("Then the other syscalls should be allowed", func() {
+			So(runSeccompFilter(filter, arch, uint32(unix.SYS_READ)), ShouldEqual, seccompRetAllow)
+			So(runSeccompFilter(filter, arch, uint32(unix.SYS_EXECVE)), ShouldEqual, seccompRetAllow)
+			So(runSeccompFilter(filter, arch, uint32(unix.SYS_SOCKET)), ShouldEqual, seccompRetAllow)
+		})
+
+		Convey("Then the syscalls of the other architectures and of the x32 ABI should fail", func() {
+			So(runSeccompFilter(filter, auditArches["arm64"], uint32(unix.SYS_READ)), ShouldEqual, denied)
+			So(runSeccompFilter(filter, arch, x32SyscallBit|uint32(unix.SYS_READ)), ShouldEqual, denied)
+		})
+	})
+}
@@ -1,6 +1,8 @@
 package remoteenforcer
 
 import (
+	"go.uber.org/zap"
+
 	"github.com/aporeto-inc/trireme-lib/enforcer/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/enforcer/policyenforcer"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
@@ -27,4 +29,16 @@ type RemoteEnforcer struct {
 	supervisor     supervisor.Supervisor
 	service        packetprocessor.PacketProcessor
 	secrets        secrets.Secrets
+	// logLevel is the level of the logs of the remote enforcer, if they can
+	// be changed at runtime
+	logLevel *zap.AtomicLevel
+	// debug is the debug listener of the remote enforcer, if it is started
+	debug *debugListener
+	// crash reports the crashes of the remote enforcer, if it was launched by
+	// the controller
+	crash *crashReporter
+	// hardening installs the seccomp filter once the enforcer is initialized,
+	// and filtered is set once it is installed
+	hardening bool
+	filtered  bool
 }
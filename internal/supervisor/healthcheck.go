@@ -0,0 +1,348 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Defaults of the health checks of the proxied services
+const (
+	defaultHealthCheckInterval           = 10 * time.Second
+	defaultHealthCheckTimeout            = 2 * time.Second
+	defaultHealthCheckUnhealthyThreshold = 3
+	defaultHealthCheckHealthyThreshold   = 2
+)
+
+// backendHealth is the health of a private backend of the proxied services
+type backendHealth struct {
+	healthy   bool
+	failures  int
+	successes int
+}
+
+// healthChecker checks the private backends of the proxied services of a PU,
+// and reports the backends whose health changed
+type healthChecker struct {
+	contextID string
+	services  *policy.ProxiedServicesInfo
+	check     policy.ServiceHealthCheck
+	// backends are the tcp backends by ip,port pair
+	backends map[string]*backendHealth
+	// dial checks a backend
+	dial func(address string, timeout time.Duration) error
+	// changed is called when the health of a backend changes
+	changed func(checker *healthChecker, backend string, healthy bool)
+	stop    chan struct{}
+	sync.Mutex
+}
+
+// newHealthChecker returns a checker of the private tcp backends of services,
+// or nil if they are not checked
+func newHealthChecker(contextID string, services *policy.ProxiedServicesInfo) *healthChecker {
+
+	if services == nil || services.HealthCheck == nil {
+		return nil
+	}
+
+	check := *services.HealthCheck
+	if check.Interval <= 0 {
+		check.Interval = defaultHealthCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+	if check.UnhealthyThreshold <= 0 {
+		check.UnhealthyThreshold = defaultHealthCheckUnhealthyThreshold
+	}
+	if check.HealthyThreshold <= 0 {
+		check.HealthyThreshold = defaultHealthCheckHealthyThreshold
+	}
+
+	backends := map[string]*backendHealth{}
+	for _, pair := range services.PrivateIPPortPair {
+		if _, ok := backendAddress(pair); ok {
+			backends[pair] = &backendHealth{healthy: true}
+		}
+	}
+
+	if len(backends) == 0 {
+		return nil
+	}
+
+	return &healthChecker{
+		contextID: contextID,
+		services:  services,
+		check:     check,
+		backends:  backends,
+		dial:      dialBackend,
+		stop:      make(chan struct{}),
+	}
+}
+
+// backendAddress returns the address of the backend of an ip,port pair. The
+// udp backends are not checked.
+func backendAddress(pair string) (string, bool) {
+
+	parts := strings.SplitN(pair, ",", 2)
+	if len(parts) != 2 || strings.HasPrefix(parts[1], "udp:") {
+		return "", false
+	}
+
+	return net.JoinHostPort(parts[0], strings.TrimPrefix(parts[1], "tcp:")), true
+}
+
+// dialBackend opens a tcp connection to a backend
+func dialBackend(address string, timeout time.Duration) error {
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// run checks the backends until the checker is stopped
+func (h *healthChecker) run() {
+
+	ticker := time.NewTicker(h.check.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.checkBackends()
+		}
+	}
+}
+
+// checkBackends checks all the backends once, in parallel
+func (h *healthChecker) checkBackends() {
+
+	var wg sync.WaitGroup
+	for pair := range h.backends {
+		address, _ := backendAddress(pair)
+		wg.Add(1)
+		go func(pair, address string) {
+			defer wg.Done()
+			h.record(pair, h.dial(address, h.check.Timeout))
+		}(pair, address)
+	}
+	wg.Wait()
+}
+
+// record records the result of a check of a backend, and reports the backend
+// if its health changed
+func (h *healthChecker) record(pair string, err error) {
+
+	h.Lock()
+
+	backend := h.backends[pair]
+	if err == nil {
+		backend.failures = 0
+		backend.successes++
+	} else {
+		backend.successes = 0
+		backend.failures++
+	}
+
+	changed := false
+	switch {
+	case backend.healthy && backend.failures >= h.check.UnhealthyThreshold:
+		backend.healthy = false
+		changed = true
+		zap.L().Warn("Proxied service backend is unhealthy",
+			zap.String("contextID", h.contextID),
+			zap.String("backend", pair),
+			zap.Error(err),
+		)
+	case !backend.healthy && backend.successes >= h.check.HealthyThreshold:
+		backend.healthy = true
+		changed = true
+		zap.L().Info("Proxied service backend recovered",
+			zap.String("contextID", h.contextID),
+			zap.String("backend", pair),
+		)
+	}
+
+	healthy := backend.healthy
+
+	h.Unlock()
+
+	if changed && h.changed != nil {
+		h.changed(h, pair, healthy)
+	}
+}
+
+// healthyServices returns the proxied services without the unhealthy backends
+func (h *healthChecker) healthyServices() *policy.ProxiedServicesInfo {
+
+	h.Lock()
+	defer h.Unlock()
+
+	services := &policy.ProxiedServicesInfo{
+		PublicIPPortPair:  h.services.PublicIPPortPair,
+		PrivateIPPortPair: []string{},
+		HealthCheck:       h.services.HealthCheck,
+	}
+
+	for _, pair := range h.services.PrivateIPPortPair {
+		if backend, ok := h.backends[pair]; ok && !backend.healthy {
+			continue
+		}
+		services.PrivateIPPortPair = append(services.PrivateIPPortPair, pair)
+	}
+
+	return services
+}
+
+// unhealthy returns true if a backend is unhealthy
+func (h *healthChecker) unhealthy() bool {
+
+	h.Lock()
+	defer h.Unlock()
+
+	for _, backend := range h.backends {
+		if !backend.healthy {
+			return true
+		}
+	}
+
+	return false
+}
+
+// close stops the checker
+func (h *healthChecker) close() {
+
+	close(h.stop)
+}
+
+// superviseHealthCheck starts the health checks of the proxied services of a
+// PU, or keeps the checker of its previous policy if its services did not
+// change. The unhealthy backends are removed again from the proxy sets that
+// were programmed with the policy. It must be called by the operation of the
+// PU with the rulesLock held.
+func (s *Config) superviseHealthCheck(contextID string, pu *policy.PUInfo) {
+
+	services := pu.Policy.ProxiedServices()
+
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+
+	if checker, ok := s.healthChecks[contextID]; ok {
+		if reflect.DeepEqual(checker.services, services) {
+			if checker.unhealthy() {
+				if err := s.updateProxiedServices(contextID, checker.healthyServices()); err != nil {
+					zap.L().Warn("Unable to remove the unhealthy backends", zap.String("contextID", contextID), zap.Error(err))
+				}
+			}
+			return
+		}
+
+		checker.close()
+		delete(s.healthChecks, contextID)
+	}
+
+	checker := newHealthChecker(contextID, services)
+	if checker == nil {
+		return
+	}
+
+	checker.changed = s.backendChanged
+	s.healthChecks[contextID] = checker
+
+	go checker.run()
+}
+
+// stopHealthCheck stops the health checks of the proxied services of a PU
+func (s *Config) stopHealthCheck(contextID string) {
+
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+
+	if checker, ok := s.healthChecks[contextID]; ok {
+		checker.close()
+		delete(s.healthChecks, contextID)
+	}
+}
+
+// backendChanged removes an unhealthy backend from the proxy sets of its PU,
+// or adds it again when it recovers, and reports the change
+func (s *Config) backendChanged(checker *healthChecker, backend string, healthy bool) {
+
+	var ips policy.ExtendedMap
+	var tags *policy.TagStore
+	stale := false
+
+	if err := s.run(context.Background(), checker.contextID, func() error {
+
+		// The checker of a previous policy is ignored
+		s.healthLock.Lock()
+		stale = s.healthChecks[checker.contextID] != checker
+		s.healthLock.Unlock()
+		if stale {
+			return nil
+		}
+
+		data, err := s.versionTracker.Get(checker.contextID)
+		if err != nil {
+			return err
+		}
+
+		c := data.(*cacheData)
+		ips = c.ips
+		tags = c.containerInfo.Runtime.Tags()
+
+		// The services are the current ones, since the health of other
+		// backends may have changed in the meantime
+		return s.updateProxiedServices(checker.contextID, checker.healthyServices())
+	}); err != nil {
+		zap.L().Warn("Unable to update the proxied services", zap.String("contextID", checker.contextID), zap.Error(err))
+		return
+	}
+
+	if stale {
+		return
+	}
+
+	event := collector.ContainerBackendDown
+	if healthy {
+		event = collector.ContainerBackendUp
+	}
+
+	s.collector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: checker.contextID,
+		IPAddress: ips,
+		Tags:      tags,
+		Event:     event,
+		Backend:   backend,
+	})
+}
+
+// updateProxiedServices updates the proxy sets of a PU with the implementation
+func (s *Config) updateProxiedServices(contextID string, services *policy.ProxiedServicesInfo) error {
+
+	updater, ok := s.impl.(ProxiedServicesUpdater)
+	if !ok {
+		return errors.New("the implementation cannot update the proxied services")
+	}
+
+	data, err := s.versionTracker.Get(contextID)
+	if err != nil {
+		return err
+	}
+
+	return updater.UpdateProxiedServices(contextID, data.(*cacheData).containerInfo, services)
+}
@@ -0,0 +1,168 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/policy"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testProxyUpdater programs no rules, and records the proxied services of the
+// updates of the proxy sets
+type testProxyUpdater struct {
+	Implementor
+	updates []*policy.ProxiedServicesInfo
+}
+
+func (u *testProxyUpdater) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+	return nil
+}
+
+func (u *testProxyUpdater) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) error {
+	return nil
+}
+
+func (u *testProxyUpdater) DeleteRules(version int, contextID string, port string, mark string, uid string, proxyPort string, proxyPortSetName string) error {
+	return nil
+}
+
+func (u *testProxyUpdater) UpdateProxiedServices(contextID string, containerInfo *policy.PUInfo, services *policy.ProxiedServicesInfo) error {
+	u.updates = append(u.updates, services)
+	return nil
+}
+
+func createProxiedPUInfo() *policy.PUInfo {
+
+	services := &policy.ProxiedServicesInfo{
+		PublicIPPortPair:  []string{"10.0.0.1,80"},
+		PrivateIPPortPair: []string{"172.17.0.2,8080", "172.17.0.3,8080", "172.17.0.4,udp:53"},
+		HealthCheck: &policy.ServiceHealthCheck{
+			Interval:           time.Hour,
+			UnhealthyThreshold: 2,
+			HealthyThreshold:   1,
+		},
+	}
+
+	runtime := policy.NewPURuntimeWithDefaults()
+	plc := policy.NewPUPolicy("context", policy.Police, nil, nil, nil, nil, nil, nil, nil, []string{"172.17.0.0/24"}, []string{}, services)
+
+	return policy.PUInfoFromPolicyAndRuntime("context", plc, runtime)
+}
+
+func TestNewHealthChecker(t *testing.T) {
+
+	Convey("Given proxied services", t, func() {
+
+		Convey("If they have no health check, I should get no checker", func() {
+			So(newHealthChecker("contextID", &policy.ProxiedServicesInfo{PrivateIPPortPair: []string{"172.17.0.2,8080"}}), ShouldBeNil)
+			So(newHealthChecker("contextID", nil), ShouldBeNil)
+		})
+
+		Convey("If they only have udp backends, I should get no checker", func() {
+			So(newHealthChecker("contextID", &policy.ProxiedServicesInfo{
+				PrivateIPPortPair: []string{"172.17.0.2,udp:53"},
+				HealthCheck:       &policy.ServiceHealthCheck{},
+			}), ShouldBeNil)
+		})
+
+		Convey("If they have a health check, I should get a checker of the tcp backends with defaults", func() {
+			checker := newHealthChecker("contextID", &policy.ProxiedServicesInfo{
+				PrivateIPPortPair: []string{"172.17.0.2,8080", "172.17.0.3,tcp:8081", "172.17.0.4,udp:53"},
+				HealthCheck:       &policy.ServiceHealthCheck{},
+			})
+			So(checker, ShouldNotBeNil)
+			So(len(checker.backends), ShouldEqual, 2)
+			So(checker.check.Interval, ShouldEqual, defaultHealthCheckInterval)
+			So(checker.check.UnhealthyThreshold, ShouldEqual, defaultHealthCheckUnhealthyThreshold)
+
+			address, ok := backendAddress("172.17.0.3,tcp:8081")
+			So(ok, ShouldBeTrue)
+			So(address, ShouldEqual, "172.17.0.3:8081")
+		})
+	})
+}
+
+func TestHealthCheck(t *testing.T) {
+
+	Convey("Given a supervisor with a PU with checked proxied services", t, func() {
+		c := &testEventCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"})
+		So(s, ShouldNotBeNil)
+
+		impl := &testProxyUpdater{}
+		s.impl = impl
+
+		puInfo := createProxiedPUInfo()
+		So(s.Supervise(context.Background(), "contextID", puInfo), ShouldBeNil)
+
+		checker := s.healthChecks["contextID"]
+		So(checker, ShouldNotBeNil)
+		defer s.stopHealthCheck("contextID")
+
+		down := map[string]bool{"172.17.0.2:8080": true}
+		checker.dial = func(address string, timeout time.Duration) error {
+			if down[address] {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+
+		Convey("When a backend fails less than the threshold, it should be kept", func() {
+			checker.checkBackends()
+			So(len(impl.updates), ShouldEqual, 0)
+			So(len(c.records), ShouldEqual, 0)
+		})
+
+		Convey("When a backend fails its checks, it should be removed and reported", func() {
+			checker.checkBackends()
+			checker.checkBackends()
+
+			So(len(impl.updates), ShouldEqual, 1)
+			So(impl.updates[0].PublicIPPortPair, ShouldResemble, []string{"10.0.0.1,80"})
+			So(impl.updates[0].PrivateIPPortPair, ShouldResemble, []string{"172.17.0.3,8080", "172.17.0.4,udp:53"})
+			So(len(c.records), ShouldEqual, 1)
+			So(c.records[0].Event, ShouldEqual, collector.ContainerBackendDown)
+			So(c.records[0].Backend, ShouldEqual, "172.17.0.2,8080")
+
+			Convey("When it recovers, it should be added again and reported", func() {
+				delete(down, "172.17.0.2:8080")
+				checker.checkBackends()
+
+				So(len(impl.updates), ShouldEqual, 2)
+				So(impl.updates[1].PrivateIPPortPair, ShouldResemble, puInfo.Policy.ProxiedServices().PrivateIPPortPair)
+				So(len(c.records), ShouldEqual, 2)
+				So(c.records[1].Event, ShouldEqual, collector.ContainerBackendUp)
+			})
+
+			Convey("When the same policy is supervised again, the backend should stay removed", func() {
+				So(s.Supervise(context.Background(), "contextID", createProxiedPUInfo()), ShouldBeNil)
+
+				So(s.healthChecks["contextID"], ShouldEqual, checker)
+				So(len(impl.updates), ShouldEqual, 2)
+				So(strings.Join(impl.updates[1].PrivateIPPortPair, " "), ShouldNotContainSubstring, "172.17.0.2")
+			})
+		})
+
+		Convey("When the PU is unsupervised, its backends should not be checked anymore", func() {
+			So(s.Unsupervise(context.Background(), "contextID"), ShouldBeNil)
+			So(len(s.healthChecks), ShouldEqual, 0)
+
+			checker.checkBackends()
+			checker.checkBackends()
+			So(len(impl.updates), ShouldEqual, 0)
+			So(len(c.records), ShouldEqual, 0)
+		})
+	})
+}
@@ -1,15 +1,23 @@
 package supervisor
 
-import "github.com/aporeto-inc/trireme-lib/policy"
+import (
+	"context"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
 
 // A Supervisor is implementing the node control plane that captures the packets.
 type Supervisor interface {
 
-	// Supervise adds a new supervised processing unit.
-	Supervise(contextID string, puInfo *policy.PUInfo) error
+	// Supervise adds a new supervised processing unit. It gives up when the
+	// context is done.
+	Supervise(ctx context.Context, contextID string, puInfo *policy.PUInfo) error
 
-	// Unsupervise unsupervises the given PU
-	Unsupervise(contextID string) error
+	// Unsupervise unsupervises the given PU. It gives up when the context is
+	// done.
+	Unsupervise(ctx context.Context, contextID string) error
 
 	// Start starts the Supervisor.
 	Start() error
@@ -30,15 +38,84 @@ type Implementor interface {
 	// UpdateRules updates the rules with a new version
 	UpdateRules(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) error
 
+	// GetRules returns the rules programmed for a PU with the given version
+	GetRules(version int, contextID string, containerInfo *policy.PUInfo) (*policy.PURules, error)
+
 	// DeleteRules
 	DeleteRules(version int, context string, port string, mark string, uid string, proxyPort string, proxyPortSetName string) error
 
 	// SetTargetNetworks sets the target networks of the supervisor
 	SetTargetNetworks([]string, []string) error
 
+	// SetFailMode sets what happens to the traffic of the PUs when the enforcer stops
+	SetFailMode(mode constants.FailMode)
+
 	// Start initializes any defaults
 	Start() error
 
 	// Stop cleans up state
 	Stop() error
 }
+
+// Reconciler is implemented by the implementors that can verify the rules
+// they programmed and program the missing ones again
+type Reconciler interface {
+
+	// ReconcileGlobalRules programs the missing global rules again. It returns
+	// true if rules were missing.
+	ReconcileGlobalRules() (bool, error)
+
+	// ReconcileRules programs the missing rules of a PU with the given version
+	// again. It returns true if rules were missing.
+	ReconcileRules(version int, contextID string, containerInfo *policy.PUInfo) (bool, error)
+}
+
+// InPlaceUpdater is implemented by the implementors that can apply a policy
+// update to the current version of the rules of a PU
+type InPlaceUpdater interface {
+
+	// UpdateRulesInPlace applies the difference between the old and the new
+	// policy to the rules of the given version. It returns false if the rules
+	// were not changed and a new version must be programmed.
+	UpdateRulesInPlace(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) (bool, error)
+}
+
+// Saver is implemented by the implementors that can return the rules they
+// programmed
+type Saver interface {
+
+	// Save returns the rules in the format of iptables-save
+	Save() (string, error)
+}
+
+// Adopter is implemented by the implementors that can adopt the rules
+// programmed by a previous run on a warm restart
+type Adopter interface {
+
+	// AdoptRules adopts the rules of a PU programmed by the previous run, and
+	// programs the differences with its policy. It returns the version of the
+	// rules, and false if there were no rules to adopt.
+	AdoptRules(contextID string, containerInfo *policy.PUInfo) (int, bool, error)
+
+	// CleanStaleRules removes the rules of the previous run that were not
+	// adopted
+	CleanStaleRules() error
+}
+
+// ProxiedServicesUpdater is implemented by the implementors that can update the
+// proxy sets of a PU without a new version of its rules
+type ProxiedServicesUpdater interface {
+
+	// UpdateProxiedServices updates the proxy sets of a PU with proxied
+	// services that replace the ones of its policy
+	UpdateProxiedServices(contextID string, containerInfo *policy.PUInfo, services *policy.ProxiedServicesInfo) error
+}
+
+// FilterQueueUpdater is implemented by the supervisors and the implementors
+// that can send the packets to new queues while they are running
+type FilterQueueUpdater interface {
+
+	// UpdateFilterQueue programs the rules that send the packets to the
+	// queues of the new configuration
+	UpdateFilterQueue(fq *fqconfig.FilterQueue) error
+}
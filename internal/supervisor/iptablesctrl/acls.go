@@ -10,12 +10,11 @@ import (
 
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme-lib/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cgnetcls"
 )
 
-const observeMark = "39"
-
 func (i *Instance) cgroupChainRules(appChain string, netChain string, mark string, port string, uid string, proxyPort string, proxyPortSetName string) [][]string {
 
 	destSetName, srcSetName := i.getSetNamePair(proxyPortSetName)
@@ -37,10 +36,10 @@ func (i *Instance) cgroupChainRules(appChain string, netChain string, mark strin
 
 		{
 			i.appProxyIPTableContext,
-			natProxyInputChain,
+			i.natProxyInputChain,
 			"-p", "tcp",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-m", "set",
 			"--match-set", srcSetName, "src,dst",
 			"-j", "REDIRECT",
@@ -48,71 +47,87 @@ func (i *Instance) cgroupChainRules(appChain string, netChain string, mark strin
 		},
 		{
 			i.appProxyIPTableContext,
-			natProxyOutputChain,
+			i.natProxyOutputChain,
 			"-p", "tcp",
 			"-m", "set",
 			"--match-set", destSetName, "dst,dst",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-j", "REDIRECT",
 			"--to-port", proxyPort,
 		},
 		{
 			i.netPacketIPTableContext,
-			proxyInputChain,
+			i.proxyInputChain,
 			"-p", "tcp",
 			"-m", "set",
 			"--match-set", destSetName, "src,src",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-j", "ACCEPT",
 		},
 		{
 			i.netPacketIPTableContext,
-			proxyInputChain,
+			i.proxyInputChain,
 			"-p", "tcp",
 			"-m", "set",
 			"--match-set", srcSetName, "src,dst",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-j", "ACCEPT",
 		},
 		{
 			i.appPacketIPTableContext,
-			proxyOutputChain,
+			i.proxyOutputChain,
 			"-p", "tcp",
 			"-m", "set",
 			"--match-set", destSetName, "dst,dst",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-j", "ACCEPT",
 		},
-		{
-			i.netPacketIPTableContext,
-			i.netPacketIPTableSection,
-			"-p", "tcp",
-			"-m", "multiport",
-			"--destination-ports", port,
-			"-m", "comment", "--comment", "Container-specific-chain",
-			"-j", netChain,
-		},
+	}
+
+	// The port list of the services gives the udp ports as udp:port
+	tcpPorts, udpPorts := policy.SplitProtocolPortList(port)
+	if tcpPorts != "" || udpPorts == "" {
+		if tcpPorts == "" {
+			tcpPorts = "0"
+		}
+		str = append(str, i.serviceChainRule("tcp", tcpPorts, netChain))
+	}
+	if udpPorts != "" {
+		str = append(str, i.serviceChainRule("udp", udpPorts, netChain))
 	}
 
 	return str
 }
 
+// serviceChainRule returns the rule that sends the traffic towards the ports of
+// the services of a cgroup based PU to its chain
+func (i *Instance) serviceChainRule(protocol string, ports string, netChain string) []string {
+
+	return []string{
+		i.netPacketIPTableContext,
+		i.netPacketIPTableSection,
+		"-p", protocol,
+		"-m", "multiport",
+		"--destination-ports", ports,
+		"-m", "comment", "--comment", "Container-specific-chain",
+		"-j", netChain,
+	}
+}
+
 func (i *Instance) uidChainRules(portSetName, appChain string, netChain string, mark string, port string, uid string, proxyPort string, proyPortSetName string) [][]string {
 
+	owner := append([]string{i.appPacketIPTableContext, i.uidChain}, ownerMatch(uid)...)
+
 	str := [][]string{
-		{
-			i.appPacketIPTableContext,
-			uidchain,
-			"-m", "owner", "--uid-owner", uid, "-j", "MARK", "--set-mark", mark,
-		},
+		append(owner, "-j", "MARK", "--set-mark", mark),
 
 		{
 			i.appPacketIPTableContext,
-			uidchain,
+			i.uidChain,
 			"-m", "mark", "--mark", mark,
 			"-m", "comment", "--comment", "Server-specific-chain",
 			"-j", appChain,
@@ -137,33 +152,93 @@ func (i *Instance) uidChainRules(portSetName, appChain string, netChain string,
 	return str
 }
 
+// ownerMatch returns the match of the packets of the processes of the owner of
+// a UID PU: a user, or the members of a group if the owner is a group. The
+// supplementary groups of the processes are matched.
+func ownerMatch(owner string) []string {
+
+	if group, ok := portset.OwnerGroup(owner); ok {
+		return []string{"-m", "owner", "--gid-owner", group, "--suppl-groups"}
+	}
+
+	return []string{"-m", "owner", "--uid-owner", owner}
+}
+
+// puOwner returns the owner of the rules and the ports of a UID PU
+func puOwner(containerInfo *policy.PUInfo) string {
+
+	options := containerInfo.Runtime.Options()
+
+	return portset.PUOwner(options.UserID, options.GroupID)
+}
+
+// autoPortChainRules provides the rules that send the traffic towards the
+// discovered ports of a cgroup based PU to its chain
+func (i *Instance) autoPortChainRules(portSetName string, netChain string) [][]string {
+
+	return [][]string{
+		{
+			i.netPacketIPTableContext,
+			i.netPacketIPTableSection,
+			"-p", "tcp",
+			"-m", "set", "--match-set", portSetName, "dst",
+			"-m", "comment", "--comment", "Container-specific-chain",
+			"-j", netChain,
+		},
+	}
+}
+
 // chainRules provides the list of rules that are used to send traffic to
-// a particular chain
-func (i *Instance) chainRules(appChain string, netChain string, port string, proxyPort string, proxyPortSetName string) [][]string {
+// a particular chain. The rules match the addresses of the container if
+// they are known, and all the traffic otherwise.
+func (i *Instance) chainRules(appChain string, netChain string, port string, proxyPort string, proxyPortSetName string, ips []string) [][]string {
 
 	rules := [][]string{}
 	destSetName, srcSetName := i.getSetNamePair(proxyPortSetName)
 
-	rules = append(rules, []string{
-		i.appPacketIPTableContext,
-		i.appPacketIPTableSection,
-		"-m", "comment", "--comment", "Container-specific-chain",
-		"-j", appChain,
-	})
+	if len(ips) == 0 {
+		rules = append(rules, []string{
+			i.appPacketIPTableContext,
+			i.appPacketIPTableSection,
+			"-m", "comment", "--comment", "Container-specific-chain",
+			"-j", appChain,
+		})
+
+		rules = append(rules, []string{
+			i.netPacketIPTableContext,
+			i.netPacketIPTableSection,
+			"-m", "comment", "--comment", "Container-specific-chain",
+			"-j", netChain,
+		})
+	}
+
+	// The traffic of a container attached to several networks is sent to
+	// its chains for each of its addresses
+	for _, ip := range ips {
+		rules = append(rules, []string{
+			i.appPacketIPTableContext,
+			i.appPacketIPTableSection,
+			"-s", ip,
+			"-m", "comment", "--comment", "Container-specific-chain",
+			"-j", appChain,
+		})
+
+		rules = append(rules, []string{
+			i.netPacketIPTableContext,
+			i.netPacketIPTableSection,
+			"-d", ip,
+			"-m", "comment", "--comment", "Container-specific-chain",
+			"-j", netChain,
+		})
+	}
 
-	rules = append(rules, []string{
-		i.netPacketIPTableContext,
-		i.netPacketIPTableSection,
-		"-m", "comment", "--comment", "Container-specific-chain",
-		"-j", netChain,
-	})
 	proxyRules := [][]string{
 		{
 			i.appProxyIPTableContext,
-			natProxyInputChain,
+			i.natProxyInputChain,
 			"-p", "tcp",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-m", "set",
 			"--match-set", srcSetName, "src,dst",
 			"-j", "REDIRECT",
@@ -171,50 +246,50 @@ func (i *Instance) chainRules(appChain string, netChain string, port string, pro
 		},
 		{
 			i.appProxyIPTableContext,
-			natProxyOutputChain,
+			i.natProxyOutputChain,
 			"-p", "tcp",
 			"-m", "set",
 			"--match-set", destSetName, "dst,dst",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-j", "REDIRECT",
 			"--to-port", proxyPort,
 		},
 		{
 			i.netPacketIPTableContext,
-			proxyInputChain,
+			i.proxyInputChain,
 			"-p", "tcp",
 			"-m", "set",
 			"--match-set", destSetName, "src,src",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-j", "ACCEPT",
 		},
 		{
 			i.netPacketIPTableContext,
-			proxyInputChain,
+			i.proxyInputChain,
 			"-p", "tcp",
 			"-m", "set",
 			"--match-set", srcSetName, "src,dst",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-j", "ACCEPT",
 		},
 		{
 			i.netPacketIPTableContext,
-			proxyInputChain,
+			i.proxyInputChain,
 			"-p", "tcp",
 			"--dport", proxyPort,
 			"-j", "ACCEPT",
 		},
 		{
 			i.appPacketIPTableContext,
-			proxyOutputChain,
+			i.proxyOutputChain,
 			"-p", "tcp",
 			"-m", "set",
 			"--match-set", destSetName, "dst,dst",
 			"-m", "mark", "!",
-			"--mark", proxyMark,
+			"--mark", i.proxyMark,
 			"-j", "ACCEPT",
 		},
 	}
@@ -223,6 +298,21 @@ func (i *Instance) chainRules(appChain string, netChain string, port string, pro
 
 }
 
+// queueBypass returns a copy of a rule where the packets sent to the queues are
+// accepted when no process is reading the queues
+func queueBypass(rule []string) []string {
+
+	bypass := make([]string, 0, len(rule)+1)
+	for _, arg := range rule {
+		bypass = append(bypass, arg)
+		if arg == "NFQUEUE" {
+			bypass = append(bypass, "--queue-bypass")
+		}
+	}
+
+	return bypass
+}
+
 //trapRules provides the packet trap rules to add/delete
 func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 
@@ -231,7 +321,7 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Application Packets - SYN
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueSynStr(),
 	})
@@ -239,14 +329,14 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Application Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 	})
 
 	rules = append(rules, []string{
 		i.appPacketIPTableContext, appChain,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
 	})
@@ -254,18 +344,45 @@ func (i *Instance) trapRules(appChain string, netChain string) [][]string {
 	// Network Packets - SYN
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueSynStr(),
 	})
 	// Network Packets - Evertyhing but SYN and SYN,ACK (first 4 packets). SYN,ACK is captured by global rule
 	rules = append(rules, []string{
 		i.netPacketIPTableContext, netChain,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "ACK",
 		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
 	})
 
+	if !i.udpEnforcement {
+		return rules
+	}
+
+	// UDP datagrams carry the tokens until the flow is released with the connmark
+	rules = append(rules, []string{
+		i.appPacketIPTableContext, appChain,
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
+		"-p", "udp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetApplicationQueueAckStr(),
+	})
+
+	rules = append(rules, []string{
+		i.netPacketIPTableContext, netChain,
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
+		"-p", "udp",
+		"-j", "NFQUEUE", "--queue-balance", i.fqc.GetNetworkQueueAckStr(),
+	})
+
+	// In fail open mode the packets are accepted when the enforcer is not
+	// reading the queues. Otherwise they are dropped.
+	if i.failMode == constants.FailOpen {
+		for idx, rule := range rules {
+			rules[idx] = queueBypass(rule)
+		}
+	}
+
 	return rules
 }
 
@@ -308,18 +425,35 @@ func (i *Instance) processRulesFromList(rulelist [][]string, methodType string)
 }
 
 // addChainrules implements all the iptable rules that redirect traffic to a chain
-func (i *Instance) addChainRules(portSetName string, appChain string, netChain string, port string, mark string, uid string, proxyPort string, proxyPortSetName string) error {
+func (i *Instance) addChainRules(portSetName string, appChain string, netChain string, port string, mark string, uid string, proxyPort string, proxyPortSetName string, transparent bool, ips []string) error {
+
+	return i.processRulesFromList(i.redirectRules(portSetName, appChain, netChain, port, mark, uid, proxyPort, proxyPortSetName, transparent, ips), "Append")
+}
+
+// redirectRules returns the rules that redirect traffic to the chains of a PU.
+// The connections sent to the proxy of the PU keep their destination if the
+// proxy is transparent. The addresses only apply to the container PUs.
+func (i *Instance) redirectRules(portSetName string, appChain string, netChain string, port string, mark string, uid string, proxyPort string, proxyPortSetName string, transparent bool, ips []string) [][]string {
+
+	var rules [][]string
+
 	if i.mode == constants.LocalServer {
 		if port != "0" || uid == "" {
-			return i.processRulesFromList(i.cgroupChainRules(appChain, netChain, mark, port, uid, proxyPort, proxyPortSetName), "Append")
+			rules = i.cgroupChainRules(appChain, netChain, mark, port, uid, proxyPort, proxyPortSetName)
+		} else {
+			rules = i.uidChainRules(portSetName, appChain, netChain, mark, port, uid, proxyPort, proxyPortSetName)
 		}
+	} else {
+		rules = i.chainRules(appChain, netChain, port, proxyPort, proxyPortSetName, ips)
+	}
 
-		return i.processRulesFromList(i.uidChainRules(portSetName, appChain, netChain, mark, port, uid, proxyPort, proxyPortSetName), "Append")
+	udp := i.udpProxyRules(rules)
 
+	if transparent {
+		rules = i.transparentProxyRules(rules)
 	}
 
-	return i.processRulesFromList(i.chainRules(appChain, netChain, port, proxyPort, proxyPortSetName), "Append")
-
+	return append(rules, udp...)
 }
 
 // addPacketTrap adds the necessary iptables rules to capture control packets to user space
@@ -329,12 +463,178 @@ func (i *Instance) addPacketTrap(appChain string, netChain string, networks []st
 
 }
 
+// protocolMatch returns the match of the protocol of a rule without ports. Icmp
+// rules match the type and code of the rule if present.
+func protocolMatch(rule policy.IPRule) []string {
+
+	match := []string{"-p", rule.Protocol}
+
+	if strings.ToLower(rule.Protocol) != "icmp" || rule.ICMPType == "" {
+		return match
+	}
+
+	icmpType := rule.ICMPType
+	if rule.ICMPCode != "" {
+		icmpType = icmpType + "/" + rule.ICMPCode
+	}
+
+	return append(match, "--icmp-type", icmpType)
+}
+
+// addMarkRules adds the rules that set the DSCP and the fwmark of the policy of
+// an accept ACL. They match all the packets of the flows of the ACL, so they
+// are added before the rule that accepts the new flows.
+func (i *Instance) addMarkRules(table, chain string, flowPolicy *policy.FlowPolicy, match ...string) error {
+
+	if !flowPolicy.Action.Marked() {
+		return nil
+	}
+
+	if flowPolicy.DSCP != "" {
+		dscp := []string{"-j", "DSCP", "--set-dscp", flowPolicy.DSCP}
+		if _, err := strconv.ParseUint(flowPolicy.DSCP, 0, 8); err != nil {
+			dscp = []string{"-j", "DSCP", "--set-dscp-class", flowPolicy.DSCP}
+		}
+
+		if err := i.ipt.Append(table, chain, append(append([]string{}, match...), dscp...)...); err != nil {
+			return fmt.Errorf("unable to add acl dscp rule for table %s, chain %s: %s", table, chain, err)
+		}
+	}
+
+	if flowPolicy.FwMark != "" {
+		if err := i.ipt.Append(table, chain, append(append([]string{}, match...), "-j", "MARK", "--set-mark", flowPolicy.FwMark)...); err != nil {
+			return fmt.Errorf("unable to add acl mark rule for table %s, chain %s: %s", table, chain, err)
+		}
+	}
+
+	return nil
+}
+
+// nflogRule returns the rule that logs the flows of match to an NFLOG group,
+// at most at the rate of the instance. The group and the rate of the policy,
+// if any, override the ones of the instance.
+func (i *Instance) nflogRule(group string, flowPolicy *policy.FlowPolicy, prefix string, match ...string) []string {
+
+	rate := i.nflogRate
+	burst := i.nflogBurst
+
+	if flowPolicy != nil {
+		if flowPolicy.NFLOGGroup != 0 {
+			group = strconv.Itoa(flowPolicy.NFLOGGroup)
+		}
+		if flowPolicy.NFLOGRate != "" {
+			rate = flowPolicy.NFLOGRate
+		}
+		if flowPolicy.NFLOGBurst != 0 {
+			burst = strconv.Itoa(flowPolicy.NFLOGBurst)
+		}
+	}
+
+	rule := append([]string{}, match...)
+	if rate != UnlimitedNFLOGRate {
+		rule = append(rule, "-m", "limit", "--limit", rate, "--limit-burst", burst)
+	}
+
+	rule = append(rule, "-j", "NFLOG", "--nflog-group", group, "--nflog-prefix", prefix)
+	if i.nflogThreshold != "1" {
+		rule = append(rule, "--nflog-threshold", i.nflogThreshold)
+	}
+
+	return rule
+}
+
+// addACLSetRules adds the rules that match the ACL sets of a chain. The reject
+// set is matched with the highest priority, like the reject ACLs. The direction
+// is the ipset direction of the address and the port of the ACLs.
+func (i *Instance) addACLSetRules(table, chain, direction string) error {
+
+	if err := i.ipt.Insert(
+		table, chain, 1,
+		"-m", "set", "--match-set", aclSetName(chain, aclSetReject), direction,
+		"-m", "state", "--state", "NEW",
+		"-j", "DROP",
+	); err != nil {
+		return fmt.Errorf("unable to add acl set rule for table %s, chain %s: %s", table, chain, err)
+	}
+
+	if err := i.ipt.Append(
+		table, chain,
+		"-m", "set", "--match-set", aclSetName(chain, aclSetAccept), direction,
+		"-m", "state", "--state", "NEW",
+		"-j", "ACCEPT",
+	); err != nil {
+		return fmt.Errorf("unable to add acl set rule for table %s, chain %s: %s", table, chain, err)
+	}
+
+	return nil
+}
+
 // addAppACLs adds a set of rules to the external services that are initiated
-// by an application. The allow rules are inserted with highest priority.
+// by an application. The allow rules are inserted with highest priority. The
+// rules are added by increasing priority.
 func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList) error {
 
+	if err := i.addPriorityACLs(i.appPacketIPTableContext, chain, rules, func(tx *Instance, rules policy.IPRuleList, sets bool) error {
+		return tx.addAppACLRules(contextID, chain, rules, sets)
+	}); err != nil {
+		return err
+	}
+
+	// Accept established connections
+	if err := i.ipt.Append(
+		i.appPacketIPTableContext, chain,
+		"-d", "0.0.0.0/0",
+		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
+		"-j", "ACCEPT"); err != nil {
+
+		return fmt.Errorf("unable to add default udp acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+	}
+
+	if err := i.ipt.Append(
+		i.appPacketIPTableContext, chain,
+		"-d", "0.0.0.0/0",
+		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
+		"-j", "ACCEPT"); err != nil {
+
+		return fmt.Errorf("unable to add default tcp acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+	}
+
+	// Log everything else
+	if err := i.ipt.Append(
+		i.appPacketIPTableContext,
+		chain,
+		i.nflogRule(i.appNFLOGGroup, nil, policy.DefaultLogPrefix(contextID),
+			"-d", "0.0.0.0/0",
+			"-m", "state", "--state", "NEW",
+		)...,
+	); err != nil {
+		return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+	}
+
+	// Drop everything else
+	if err := i.ipt.Append(
+		i.appPacketIPTableContext, chain,
+		"-d", "0.0.0.0/0",
+		"-j", "DROP"); err != nil {
+
+		return fmt.Errorf("unable to add default drop acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+	}
+
+	return nil
+}
+
+// addAppACLRules adds the application ACLs of a priority: the observed rules,
+// the rejects, the ACL sets if sets is true, and the accepts.
+func (i *Instance) addAppACLRules(contextID, chain string, rules policy.IPRuleList, sets bool) error {
+
 	for loop := 0; loop < 3; loop++ {
 
+		if loop == 1 && sets {
+			if err := i.addACLSetRules(i.appPacketIPTableContext, chain, "dst,dst"); err != nil {
+				return err
+			}
+		}
+
 		for _, rule := range rules {
 
 			observeContinue := rule.Policy.ObserveAction.ObserveContinue()
@@ -344,7 +644,7 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 					continue
 				}
 			case 1:
-				if rule.Policy.ObserveAction.Observed() {
+				if rule.Policy.ObserveAction.Observed() || i.inACLSet(rule) {
 					continue
 				}
 			case 2:
@@ -364,13 +664,13 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext,
 							chain,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							i.nflogRule(i.appNFLOGGroup, rule.Policy, rule.Policy.LogPrefix(contextID),
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", i.observeMark,
+								"-m", "state", "--state", "NEW",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -382,12 +682,20 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 							"-d", rule.Address,
 							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							"-m", "mark", "!", "--mark", i.observeMark,
+							"-j", "MARK", "--set-mark", i.observeMark,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
+						if err := i.addMarkRules(i.appPacketIPTableContext, chain, rule.Policy,
+							"-p", rule.Protocol,
+							"-d", rule.Address,
+							"--dport", rule.Port,
+						); err != nil {
+							return err
+						}
+
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext, chain,
 							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
@@ -406,8 +714,8 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 							"-p", rule.Protocol, "-m", "state", "--state", "NEW",
 							"-d", rule.Address,
 							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							"-m", "mark", "!", "--mark", i.observeMark,
+							"-j", "MARK", "--set-mark", i.observeMark,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -428,13 +736,13 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 							i.appPacketIPTableContext,
 							chain,
 							1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							i.nflogRule(i.appNFLOGGroup, rule.Policy, rule.Policy.LogPrefix(contextID),
+								"-p", rule.Protocol,
+								"-d", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", i.observeMark,
+								"-m", "state", "--state", "NEW",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -453,12 +761,13 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext,
 							chain,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-m", "state", "--state", "NEW",
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							i.nflogRule(i.appNFLOGGroup, rule.Policy, rule.Policy.LogPrefix(contextID),
+								append(protocolMatch(rule),
+									"-d", rule.Address,
+									"-m", "state", "--state", "NEW",
+									"-m", "mark", "!", "--mark", i.observeMark,
+								)...,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -467,19 +776,27 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 					if observeContinue {
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext, chain,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							append(protocolMatch(rule),
+								"-d", rule.Address,
+								"-m", "mark", "!", "--mark", i.observeMark,
+								"-j", "MARK", "--set-mark", i.observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
+						if err := i.addMarkRules(i.appPacketIPTableContext, chain, rule.Policy,
+							append(protocolMatch(rule), "-d", rule.Address)...,
+						); err != nil {
+							return err
+						}
+
 						if err := i.ipt.Append(
 							i.appPacketIPTableContext, chain,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-j", "ACCEPT",
+							append(protocolMatch(rule),
+								"-d", rule.Address,
+								"-j", "ACCEPT",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -489,19 +806,21 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, chain, 1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							append(protocolMatch(rule),
+								"-d", rule.Address,
+								"-m", "mark", "!", "--mark", i.observeMark,
+								"-j", "MARK", "--set-mark", i.observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.appPacketIPTableContext, chain, 1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-j", "DROP",
+							append(protocolMatch(rule),
+								"-d", rule.Address,
+								"-j", "DROP",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -512,12 +831,13 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 							i.appPacketIPTableContext,
 							chain,
 							1,
-							"-p", rule.Protocol,
-							"-d", rule.Address,
-							"-m", "state", "--state", "NEW",
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "NFLOG", "--nflog-group", "10",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							i.nflogRule(i.appNFLOGGroup, rule.Policy, rule.Policy.LogPrefix(contextID),
+								append(protocolMatch(rule),
+									"-d", rule.Address,
+									"-m", "state", "--state", "NEW",
+									"-m", "mark", "!", "--mark", i.observeMark,
+								)...,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
 						}
@@ -529,55 +849,78 @@ func (i *Instance) addAppACLs(contextID, chain string, rules policy.IPRuleList)
 		}
 	}
 
+	return nil
+}
+
+// addNetACLs adds iptables rules that manage traffic from external services. The
+// explicit rules are added with the highest priority since they are direct allows.
+// The rules are added by increasing priority.
+func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList) error {
+
+	if err := i.addPriorityACLs(i.netPacketIPTableContext, chain, rules, func(tx *Instance, rules policy.IPRuleList, sets bool) error {
+		return tx.addNetACLRules(contextID, chain, rules, sets)
+	}); err != nil {
+		return err
+	}
+
 	// Accept established connections
 	if err := i.ipt.Append(
-		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
-		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
-		"-j", "ACCEPT"); err != nil {
+		i.netPacketIPTableContext, chain,
+		"-s", "0.0.0.0/0",
+		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
+		"-j", "ACCEPT",
+	); err != nil {
 
-		return fmt.Errorf("unable to add default udp acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+		return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 	}
 
 	if err := i.ipt.Append(
-		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
-		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
-		"-j", "ACCEPT"); err != nil {
+		i.netPacketIPTableContext, chain,
+		"-s", "0.0.0.0/0",
+		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
+		"-j", "ACCEPT",
+	); err != nil {
 
-		return fmt.Errorf("unable to add default tcp acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+		return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 	}
 
-	// Log everything else
+	// Log everything
 	if err := i.ipt.Append(
-		i.appPacketIPTableContext,
+		i.netPacketIPTableContext,
 		chain,
-		"-d", "0.0.0.0/0",
-		"-m", "state", "--state", "NEW",
-		"-j", "NFLOG", "--nflog-group", "10",
-		"--nflog-prefix", policy.DefaultLogPrefix(contextID),
+		i.nflogRule(i.netNFLOGGroup, nil, policy.DefaultLogPrefix(contextID),
+			"-s", "0.0.0.0/0",
+			"-m", "state", "--state", "NEW",
+		)...,
 	); err != nil {
-		return fmt.Errorf("unable to add acl log rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+		return fmt.Errorf("unable to add net log rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 	}
 
 	// Drop everything else
 	if err := i.ipt.Append(
-		i.appPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
-		"-j", "DROP"); err != nil {
+		i.netPacketIPTableContext, chain,
+		"-s", "0.0.0.0/0",
+		"-j", "DROP",
+	); err != nil {
 
-		return fmt.Errorf("unable to add default drop acl rule for table %s, chain %s: %s", i.appPacketIPTableContext, chain, err)
+		return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 	}
 
 	return nil
 }
 
-// addNetACLs adds iptables rules that manage traffic from external services. The
-// explicit rules are added with the highest priority since they are direct allows.
-func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList) error {
+// addNetACLRules adds the network ACLs of a priority: the observed rules, the
+// rejects, the ACL sets if sets is true, and the accepts.
+func (i *Instance) addNetACLRules(contextID, chain string, rules policy.IPRuleList, sets bool) error {
 
 	for loop := 0; loop < 3; loop++ {
 
+		if loop == 1 && sets {
+			if err := i.addACLSetRules(i.netPacketIPTableContext, chain, "src,dst"); err != nil {
+				return err
+			}
+		}
+
 		for _, rule := range rules {
 
 			observeContinue := rule.Policy.ObserveAction.ObserveContinue()
@@ -587,7 +930,7 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 					continue
 				}
 			case 1:
-				if rule.Policy.ObserveAction.Observed() {
+				if rule.Policy.ObserveAction.Observed() || i.inACLSet(rule) {
 					continue
 				}
 			case 2:
@@ -607,13 +950,13 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext,
 							chain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							i.nflogRule(i.netNFLOGGroup, rule.Policy, rule.Policy.LogPrefix(contextID),
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", i.observeMark,
+								"-m", "state", "--state", "NEW",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
@@ -625,12 +968,20 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							"-m", "mark", "!", "--mark", i.observeMark,
+							"-j", "MARK", "--set-mark", i.observeMark,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
 					} else {
+						if err := i.addMarkRules(i.netPacketIPTableContext, chain, rule.Policy,
+							"-p", rule.Protocol,
+							"-s", rule.Address,
+							"--dport", rule.Port,
+						); err != nil {
+							return err
+						}
+
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext, chain,
 							"-p", rule.Protocol,
@@ -649,8 +1000,8 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 							"-p", rule.Protocol,
 							"-s", rule.Address,
 							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							"-m", "mark", "!", "--mark", i.observeMark,
+							"-j", "MARK", "--set-mark", i.observeMark,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
@@ -671,13 +1022,13 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 							i.netPacketIPTableContext,
 							chain,
 							1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"--dport", rule.Port,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							i.nflogRule(i.netNFLOGGroup, rule.Policy, rule.Policy.LogPrefix(contextID),
+								"-p", rule.Protocol,
+								"-s", rule.Address,
+								"--dport", rule.Port,
+								"-m", "mark", "!", "--mark", i.observeMark,
+								"-m", "state", "--state", "NEW",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
@@ -695,12 +1046,13 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext,
 							chain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							i.nflogRule(i.netNFLOGGroup, rule.Policy, rule.Policy.LogPrefix(contextID),
+								append(protocolMatch(rule),
+									"-s", rule.Address,
+									"-m", "mark", "!", "--mark", i.observeMark,
+									"-m", "state", "--state", "NEW",
+								)...,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
@@ -709,19 +1061,27 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 					if observeContinue {
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext, chain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							append(protocolMatch(rule),
+								"-s", rule.Address,
+								"-m", "mark", "!", "--mark", i.observeMark,
+								"-j", "MARK", "--set-mark", i.observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
 					} else {
+						if err := i.addMarkRules(i.netPacketIPTableContext, chain, rule.Policy,
+							append(protocolMatch(rule), "-s", rule.Address)...,
+						); err != nil {
+							return err
+						}
+
 						if err := i.ipt.Append(
 							i.netPacketIPTableContext, chain,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-j", "ACCEPT",
+							append(protocolMatch(rule),
+								"-s", rule.Address,
+								"-j", "ACCEPT",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
@@ -731,19 +1091,21 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 					if observeContinue {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, chain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-j", "MARK", "--set-mark", observeMark,
+							append(protocolMatch(rule),
+								"-s", rule.Address,
+								"-m", "mark", "!", "--mark", i.observeMark,
+								"-j", "MARK", "--set-mark", i.observeMark,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
 					} else {
 						if err := i.ipt.Insert(
 							i.netPacketIPTableContext, chain, 1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-j", "DROP",
+							append(protocolMatch(rule),
+								"-s", rule.Address,
+								"-j", "DROP",
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
@@ -754,12 +1116,13 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 							i.netPacketIPTableContext,
 							chain,
 							1,
-							"-p", rule.Protocol,
-							"-s", rule.Address,
-							"-m", "mark", "!", "--mark", observeMark,
-							"-m", "state", "--state", "NEW",
-							"-j", "NFLOG", "--nflog-group", "11",
-							"--nflog-prefix", rule.Policy.LogPrefix(contextID),
+							i.nflogRule(i.netNFLOGGroup, rule.Policy, rule.Policy.LogPrefix(contextID),
+								append(protocolMatch(rule),
+									"-s", rule.Address,
+									"-m", "mark", "!", "--mark", i.observeMark,
+									"-m", "state", "--state", "NEW",
+								)...,
+							)...,
 						); err != nil {
 							return fmt.Errorf("unable to add net log rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
 						}
@@ -771,63 +1134,36 @@ func (i *Instance) addNetACLs(contextID, chain string, rules policy.IPRuleList)
 		}
 	}
 
-	// Accept established connections
-	if err := i.ipt.Append(
-		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
-		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
-		"-j", "ACCEPT",
-	); err != nil {
-
-		return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
-	}
-
-	if err := i.ipt.Append(
-		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
-		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
-		"-j", "ACCEPT",
-	); err != nil {
-
-		return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
-	}
-
-	// Log everything
-	if err := i.ipt.Append(
-		i.netPacketIPTableContext,
-		chain,
-		"-s", "0.0.0.0/0",
-		"-m", "state", "--state", "NEW",
-		"-j", "NFLOG", "--nflog-group", "11",
-		"--nflog-prefix", policy.DefaultLogPrefix(contextID),
-	); err != nil {
-		return fmt.Errorf("unable to add net log rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
-	}
-
-	// Drop everything else
-	if err := i.ipt.Append(
-		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
-		"-j", "DROP",
-	); err != nil {
-
-		return fmt.Errorf("unable to add net acl rule for table %s, chain %s: %s", i.netPacketIPTableContext, chain, err)
-	}
-
 	return nil
 }
 
 // deleteChainRules deletes the rules that send traffic to our chain
-func (i *Instance) deleteChainRules(portSetName, appChain, netChain, port string, mark string, uid string, proxyPort string, proxyPortSetName string) error {
+func (i *Instance) deleteChainRules(portSetName, appChain, netChain, port string, mark string, uid string, proxyPort string, proxyPortSetName string, ips []string) error {
+
+	var rules [][]string
 
 	if i.mode == constants.LocalServer {
 		if uid == "" {
-			return i.processRulesFromList(i.cgroupChainRules(appChain, netChain, mark, port, uid, proxyPort, proxyPortSetName), "Delete")
+			rules = i.cgroupChainRules(appChain, netChain, mark, port, uid, proxyPort, proxyPortSetName)
+		} else {
+			rules = i.uidChainRules(portSetName, appChain, netChain, mark, port, uid, proxyPort, proxyPortSetName)
 		}
-		return i.processRulesFromList(i.uidChainRules(portSetName, appChain, netChain, mark, port, uid, proxyPort, proxyPortSetName), "Delete")
+	} else {
+		rules = i.chainRules(appChain, netChain, port, proxyPort, proxyPortSetName, ips)
 	}
 
-	return i.processRulesFromList(i.chainRules(appChain, netChain, port, proxyPort, proxyPortSetName), "Delete")
+	udp := i.udpProxyRules(rules)
+
+	// The proxy of the PU may be transparent, so the rules of both modes are deleted
+	for _, rule := range rules {
+		if tproxy, ok := i.tproxyRules(rule); ok {
+			rules = append(rules, tproxy...)
+		}
+	}
+
+	rules = append(rules, udp...)
+
+	return i.processRulesFromList(rules, "Delete")
 }
 
 // deleteAllContainerChains removes all the container specific chains and basic rules
@@ -865,6 +1201,10 @@ func (i *Instance) deleteAllContainerChains(appChain, netChain string) error {
 		)
 	}
 
+	if i.aclSets {
+		i.destroyACLSets(appChain, netChain)
+	}
+
 	return nil
 }
 
@@ -874,7 +1214,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err := i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", i.connMark,
 		"-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at app: %s", err)
@@ -883,7 +1223,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueSynAckStr())
 	if err != nil {
@@ -893,7 +1233,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "MARK", "--set-mark", strconv.Itoa(cgnetcls.Initialmarkval-1))
 	if err != nil {
@@ -904,7 +1244,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 		err = i.ipt.Insert(
 			i.appPacketIPTableContext,
 			i.appPacketIPTableSection, 1,
-			"-j", uidchain)
+			"-j", i.uidChain)
 		if err != nil {
 			return fmt.Errorf("unable to add uid chain %s, chain %s: %s", i.appPacketIPTableContext, i.appPacketIPTableSection, err)
 		}
@@ -913,7 +1253,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.appPacketIPTableContext,
 		appChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", i.connMark,
 		"-j", "ACCEPT")
 
 	if err != nil {
@@ -923,7 +1263,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN", "--tcp-option",
 		"34", "-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynStr())
 
@@ -934,7 +1274,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueSynAckStr())
 
@@ -945,7 +1285,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(
 		i.netPacketIPTableContext,
 		netChain, 1,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", i.connMark,
 		"-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("unable to add capture synack rule for table %s, chain %s: %s", i.appPacketIPTableContext, i.appPacketIPTableSection, err)
@@ -953,49 +1293,49 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 
 	err = i.ipt.Insert(i.appProxyIPTableContext,
 		ipTableSectionPreRouting, 1,
-		"-j", natProxyInputChain)
+		"-j", i.natProxyInputChain)
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at net: %s", err)
 	}
 
 	err = i.ipt.Insert(i.appProxyIPTableContext,
 		ipTableSectionOutput, 1,
-		"-j", natProxyOutputChain)
+		"-j", i.natProxyOutputChain)
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at net: %s", err)
 	}
 
 	err = i.ipt.Insert(i.appProxyIPTableContext,
-		natProxyInputChain, 1,
+		i.natProxyInputChain, 1,
 		"-m", "mark",
-		"--mark", proxyMark,
+		"--mark", i.proxyMark,
 		"-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at net: %s", err)
 	}
 
 	err = i.ipt.Insert(i.appProxyIPTableContext,
-		natProxyOutputChain, 1,
+		i.natProxyOutputChain, 1,
 		"-m", "mark",
-		"--mark", proxyMark,
+		"--mark", i.proxyMark,
 		"-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at net: %s", err)
 	}
 
 	err = i.ipt.Insert(i.netPacketIPTableContext,
-		proxyInputChain, 1,
+		i.proxyInputChain, 1,
 		"-m", "mark",
-		"--mark", proxyMark,
+		"--mark", i.proxyMark,
 		"-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at net: %s", err)
 	}
 
 	err = i.ipt.Insert(i.netPacketIPTableContext,
-		proxyOutputChain, 1,
+		i.proxyOutputChain, 1,
 		"-m", "mark",
-		"--mark", proxyMark,
+		"--mark", i.proxyMark,
 		"-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at net: %s", err)
@@ -1003,7 +1343,7 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 
 	err = i.ipt.Insert(i.appPacketIPTableContext,
 		i.netPacketIPTableSection, 1,
-		"-j", proxyInputChain,
+		"-j", i.proxyInputChain,
 	)
 	if err != nil {
 		return fmt.Errorf("unable to add default allow for marked packets at net: %s", err)
@@ -1012,22 +1352,63 @@ func (i *Instance) setGlobalRules(appChain, netChain string) error {
 	err = i.ipt.Insert(i.appPacketIPTableContext,
 		i.appPacketIPTableSection,
 		1,
-		"-j", proxyOutputChain,
+		"-j", i.proxyOutputChain,
 	)
 	if err != nil {
 		return fmt.Errorf("unable to add proxy output chain: %s", err)
 	}
 
+	if err := i.addTransparentProxyChains(); err != nil {
+		return err
+	}
+
+	// The MSS is clamped before the packets are sent to the queues
+	for _, rule := range i.mssClampRules() {
+		if err := i.ipt.Insert(rule[0], rule[1], 1, rule[2:]...); err != nil {
+			return fmt.Errorf("unable to add mss clamping rule for table %s, chain %s: %s", rule[0], rule[1], err)
+		}
+	}
+
 	return nil
 }
 
+// mssClampRules returns the rules that clamp the MSS of the SYN and SYN-ACK
+// packets exchanged with the target networks, if the MSS is configured
+func (i *Instance) mssClampRules() [][]string {
+
+	if i.clampMSS == "" {
+		return nil
+	}
+
+	return [][]string{
+		{
+			i.appPacketIPTableContext, i.appPacketIPTableSection,
+			"-m", "set", "--match-set", i.targetNetworkSet, "dst",
+			"-p", "tcp", "--tcp-flags", "SYN,RST", "SYN",
+			"-j", "TCPMSS", "--set-mss", i.clampMSS,
+		},
+		{
+			i.netPacketIPTableContext, i.netPacketIPTableSection,
+			"-m", "set", "--match-set", i.targetNetworkSet, "src",
+			"-p", "tcp", "--tcp-flags", "SYN,RST", "SYN",
+			"-j", "TCPMSS", "--set-mss", i.clampMSS,
+		},
+	}
+}
+
 // CleanGlobalRules cleans the capture rules for SynAck packets
 func (i *Instance) CleanGlobalRules() error {
 
+	for _, rule := range i.mssClampRules() {
+		if err := i.ipt.Delete(rule[0], rule[1], rule[2:]...); err != nil {
+			zap.L().Debug("Can not clear the mss clamping rule", zap.String("chain", rule[1]), zap.Error(err))
+		}
+	}
+
 	if err := i.ipt.Delete(
 		i.appPacketIPTableContext,
 		i.appPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "dst",
+		"-m", "set", "--match-set", i.targetNetworkSet, "dst",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetApplicationQueueAckStr()); err != nil {
 		zap.L().Debug("Can not clear the SynAck packet capcture app chain", zap.Error(err))
@@ -1036,7 +1417,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.netPacketIPTableContext,
 		i.netPacketIPTableSection,
-		"-m", "set", "--match-set", targetNetworkSet, "src",
+		"-m", "set", "--match-set", i.targetNetworkSet, "src",
 		"-p", "tcp", "--tcp-flags", "SYN,ACK", "SYN,ACK",
 		"-j", "NFQUEUE", "--queue-bypass", "--queue-balance", i.fqc.GetNetworkQueueAckStr()); err != nil {
 		zap.L().Debug("Can not clear the SynAck packet capcture net chain", zap.Error(err))
@@ -1045,7 +1426,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.appPacketIPTableContext,
 		i.appPacketIPTableSection,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", i.connMark,
 		"-j", "ACCEPT"); err != nil {
 		zap.L().Debug("Can not clear the global app mark rule", zap.Error(err))
 		return fmt.Errorf("unable to add default allow for marked packets at app: %s", err)
@@ -1054,7 +1435,7 @@ func (i *Instance) CleanGlobalRules() error {
 	if err := i.ipt.Delete(
 		i.netPacketIPTableContext,
 		i.netPacketIPTableSection,
-		"-m", "connmark", "--mark", strconv.Itoa(int(constants.DefaultConnMark)),
+		"-m", "connmark", "--mark", i.connMark,
 		"-j", "ACCEPT"); err != nil {
 		zap.L().Debug("Can not clear the global net mark rule", zap.Error(err))
 	}
@@ -1078,10 +1459,10 @@ func (i *Instance) CleanAllSynAckPacketCaptures() error {
 	}
 	if i.mode == constants.LocalServer {
 		//We installed UID CHAINS with synack lets remove it here
-		if err := i.ipt.ClearChain(i.appPacketIPTableContext, uidchain); err != nil {
+		if err := i.ipt.ClearChain(i.appPacketIPTableContext, i.uidChain); err != nil {
 			zap.L().Debug("Cannot clear UID Chain", zap.Error(err))
 		}
-		if err := i.ipt.DeleteChain(i.appPacketIPTableContext, uidchain); err != nil {
+		if err := i.ipt.DeleteChain(i.appPacketIPTableContext, i.uidChain); err != nil {
 			zap.L().Debug("Cannot delete UID Chain", zap.Error(err))
 		}
 	}
@@ -1163,20 +1544,23 @@ func (i *Instance) cleanACLs() error {
 	}
 
 	// Clean Application Rules/Chains
-	i.cleanACLSection(i.appPacketIPTableContext, i.netPacketIPTableSection, i.appPacketIPTableSection, ipTableSectionPreRouting, chainPrefix)
+	i.cleanACLSection(i.appPacketIPTableContext, i.netPacketIPTableSection, i.appPacketIPTableSection, ipTableSectionPreRouting, i.chainPrefix)
 
 	// Cannot clear chains in nat table there are masquerade rules in nat table which we don't want to touch
 	if err := i.removeProxyRules(i.appProxyIPTableContext,
 		i.appPacketIPTableContext,
 		ipTableSectionPreRouting,
 		ipTableSectionOutput,
-		natProxyInputChain,
-		natProxyOutputChain,
-		proxyInputChain,
-		proxyOutputChain); err != nil {
+		i.natProxyInputChain,
+		i.natProxyOutputChain,
+		i.proxyInputChain,
+		i.proxyOutputChain); err != nil {
 		zap.L().Error("Unable to remove Proxy Rules", zap.Error(err))
 	}
 
+	i.removeTransparentProxyChains()
+	i.removeTransparentProxyRouting()
+
 	return nil
 }
 
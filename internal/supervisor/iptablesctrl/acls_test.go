@@ -3,6 +3,7 @@ package iptablesctrl
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/aporeto-inc/trireme-lib/constants"
@@ -29,7 +30,7 @@ func matchSpec(term string, rulespec []string) error {
 func TestAddContainerChain(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -72,10 +73,100 @@ func TestAddContainerChain(t *testing.T) {
 	})
 }
 
+func TestAutoPortChainRules(t *testing.T) {
+
+	Convey("Given an iptables controller for LocalServer", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), nil)
+
+		Convey("When I request the port discovery rules", func() {
+			rules := i.autoPortChainRules("portset", "netchain")
+
+			Convey("I should get a rule matching the portset towards the net chain", func() {
+				So(len(rules), ShouldEqual, 1)
+				So(rules[0][0], ShouldEqual, i.netPacketIPTableContext)
+				So(rules[0][1], ShouldEqual, i.netPacketIPTableSection)
+				So(matchSpec("portset", rules[0]), ShouldBeNil)
+				So(rules[0][len(rules[0])-1], ShouldEqual, "netchain")
+			})
+		})
+	})
+}
+
+func TestUIDChainRules(t *testing.T) {
+
+	Convey("Given an iptables controller for LocalServer", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), nil)
+
+		Convey("When I request the rules of a user PU", func() {
+			rules := i.uidChainRules("portset", "appchain", "netchain", "100", "0", "alice", "5000", "proxyset")
+
+			Convey("I should match the packets of the user", func() {
+				So(rules[0], ShouldResemble, []string{i.appPacketIPTableContext, i.uidChain, "-m", "owner", "--uid-owner", "alice", "-j", "MARK", "--set-mark", "100"})
+			})
+		})
+
+		Convey("When I request the rules of a group PU", func() {
+			owner := portset.PUOwner("", "developers")
+			rules := i.uidChainRules("portset", "appchain", "netchain", "100", "0", owner, "5000", "proxyset")
+
+			Convey("I should match the packets of the members of the group", func() {
+				So(rules[0], ShouldResemble, []string{i.appPacketIPTableContext, i.uidChain, "-m", "owner", "--gid-owner", "developers", "--suppl-groups", "-j", "MARK", "--set-mark", "100"})
+				So(len(rules), ShouldEqual, 4)
+			})
+		})
+
+		Convey("When a PU has both a user and a group", func() {
+			Convey("The user should be its owner", func() {
+				So(portset.PUOwner("alice", "developers"), ShouldEqual, "alice")
+				So(portset.PUOwner("", ""), ShouldEqual, "")
+			})
+		})
+	})
+}
+
+func TestCgroupChainRules(t *testing.T) {
+
+	Convey("Given an iptables controller for LocalServer", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), nil)
+
+		services := []policy.Service{
+			{Name: "https", Protocol: 6},
+			{Name: "domain", Protocol: 17},
+		}
+
+		Convey("When I request the rules of a PU with tcp and udp services", func() {
+			rules := i.cgroupChainRules("appchain", "netchain", "100", policy.ConvertServicesToProtocolPortList(services), "", "5000", "proxyset")
+
+			Convey("I should get a rule for the ports of each protocol", func() {
+				So(rules[len(rules)-2], ShouldResemble, i.serviceChainRule("tcp", "443", "netchain"))
+				So(rules[len(rules)-1], ShouldResemble, i.serviceChainRule("udp", "53", "netchain"))
+			})
+		})
+
+		Convey("When I request the rules of a PU with only udp services", func() {
+			rules := i.cgroupChainRules("appchain", "netchain", "100", "udp:53", "", "5000", "proxyset")
+
+			Convey("I should only get a rule for the udp ports", func() {
+				So(rules[len(rules)-1], ShouldResemble, i.serviceChainRule("udp", "53", "netchain"))
+				So(rules[len(rules)-2][3], ShouldNotEqual, "udp")
+				So(matchSpec("--destination-ports", rules[len(rules)-2]), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I request the rules of a PU without services", func() {
+			rules := i.cgroupChainRules("appchain", "netchain", "100", "0", "", "5000", "proxyset")
+
+			Convey("I should get the rule for the default port", func() {
+				So(rules[len(rules)-1], ShouldResemble, i.serviceChainRule("tcp", "0", "netchain"))
+			})
+		})
+	})
+}
+
 func TestAddChainRules(t *testing.T) {
 
 	Convey("Given an iptables controller for LocalContainer", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -84,7 +175,7 @@ func TestAddChainRules(t *testing.T) {
 				return nil
 			})
 
-			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet")
+			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet", false, nil)
 			So(err, ShouldBeNil)
 		})
 
@@ -95,7 +186,7 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet")
+			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet", false, nil)
 			So(err, ShouldNotBeNil)
 
 		})
@@ -107,7 +198,7 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet")
+			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet", false, nil)
 			So(err, ShouldNotBeNil)
 
 		})
@@ -115,7 +206,7 @@ func TestAddChainRules(t *testing.T) {
 	})
 
 	Convey("Given an iptables controller for LocalServer", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -123,7 +214,7 @@ func TestAddChainRules(t *testing.T) {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet")
+			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet", false, nil)
 			So(err, ShouldBeNil)
 		})
 
@@ -134,7 +225,7 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet")
+			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet", false, nil)
 			So(err, ShouldNotBeNil)
 		})
 
@@ -145,27 +236,27 @@ func TestAddChainRules(t *testing.T) {
 				}
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet")
+			err := i.addChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSet", false, nil)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("When i add chain rules with non-zero uid and port 0", func() {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.addChainRules("appchain", "netchain", "0", "0", "1001", "", "5000", "proxyPortSet")
+			err := i.addChainRules("appchain", "netchain", "0", "0", "1001", "", "5000", "proxyPortSet", false, nil)
 			So(err, ShouldBeNil)
 
 		})
 
 		Convey("When i add chain rules with non-zero uid and port 0 rules are added to the UID Chain", func() {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
-				if chain == "UIDCHAIN" || chain == "netchain" || chain == "INPUT" || chain == "OUTPUT" || chain == "RedirProxy-Net" || chain == "RedirProxy-App" || chain == "Proxy-Net" || chain == "Proxy-App" {
+				if chain == "UIDCHAIN" || chain == "netchain" || chain == "INPUT" || chain == "OUTPUT" || chain == "RedirProxy-Net" || chain == "RedirProxy-App" || chain == "Proxy-Net" || chain == "Proxy-App" || chain == "Tproxy-Net" || chain == "Tproxy-App" {
 					return nil
 				}
 
 				return fmt.Errorf("added to different chain: %s", chain)
 			})
-			err := i.addChainRules("appchain", "netchain", "80", "0", "1001", "", "5000", "proxyPortSet")
+			err := i.addChainRules("appchain", "netchain", "80", "0", "1001", "", "5000", "proxyPortSet", false, nil)
 			So(err, ShouldBeNil)
 
 		})
@@ -173,10 +264,72 @@ func TestAddChainRules(t *testing.T) {
 	})
 }
 
+func TestTransparentProxyRules(t *testing.T) {
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+
+		Convey("When the proxy of a PU is transparent, its connections should be sent to it with TPROXY", func() {
+			rules := i.redirectRules("", "appchain", "netchain", "", "", "", "5000", "proxyPortSet", true, nil)
+
+			tables := map[string]bool{}
+			joined := []string{}
+			for _, rule := range rules {
+				tables[rule[0]] = true
+				joined = append(joined, strings.Join(rule[1:], " "))
+				So(rule, ShouldNotContain, "REDIRECT")
+			}
+			So(tables, ShouldResemble, map[string]bool{i.appPacketIPTableContext: true, i.netPacketIPTableContext: true})
+			So(joined, ShouldContain, "Tproxy-Net -p tcp -m mark ! --mark 0x40 -m set --match-set src-proxyPortSet src,dst -j TPROXY --on-port 5000 --tproxy-mark 0x80")
+			So(joined, ShouldContain, "Tproxy-App -p tcp -m set --match-set dst-proxyPortSet dst,dst -m mark ! --mark 0x40 -j MARK --set-mark 0x80")
+			So(joined, ShouldContain, "Tproxy-Net -m mark --mark 0x80 -p tcp -m set --match-set dst-proxyPortSet dst,dst -m mark ! --mark 0x40 -j TPROXY --on-port 5000 --tproxy-mark 0x80")
+		})
+
+		Convey("When the proxy of a PU is not transparent, its connections should be redirected", func() {
+			rules := i.redirectRules("", "appchain", "netchain", "", "", "", "5000", "proxyPortSet", false, nil)
+			chainRules := i.chainRules("appchain", "netchain", "", "5000", "proxyPortSet", nil)
+			So(rules[:len(chainRules)], ShouldResemble, chainRules)
+		})
+
+		Convey("When the proxy of a PU is not transparent, its udp datagrams should still be sent to it with TPROXY", func() {
+			rules := i.redirectRules("", "appchain", "netchain", "", "", "", "5000", "proxyPortSet", false, nil)
+			chainRules := i.chainRules("appchain", "netchain", "", "5000", "proxyPortSet", nil)
+
+			joined := []string{}
+			for _, rule := range rules[len(chainRules):] {
+				joined = append(joined, strings.Join(rule[1:], " "))
+				So(rule, ShouldContain, "udp")
+				So(rule, ShouldNotContain, "REDIRECT")
+			}
+			So(joined, ShouldContain, "Tproxy-Net -p udp -m mark ! --mark 0x40 -m set --match-set src-proxyPortSet src,dst -j TPROXY --on-port 5000 --tproxy-mark 0x80")
+			So(joined, ShouldContain, "Tproxy-App -p udp -m set --match-set dst-proxyPortSet dst,dst -m mark ! --mark 0x40 -j MARK --set-mark 0x80")
+			So(joined, ShouldContain, "Proxy-Net -p udp --dport 5000 -j ACCEPT")
+			So(joined, ShouldContain, "Proxy-App -p udp -m set --match-set dst-proxyPortSet dst,dst -m mark ! --mark 0x40 -j ACCEPT")
+		})
+
+		Convey("When I delete the chain rules, the rules of both modes should be deleted", func() {
+			iptables := provider.NewTestIptablesProvider()
+			i.ipt = iptables
+
+			deleted := map[string]int{}
+			iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+				deleted[chain]++
+				return nil
+			})
+
+			So(i.deleteChainRules("", "appchain", "netchain", "", "", "", "5000", "proxyPortSet", nil), ShouldBeNil)
+			So(deleted[i.natProxyInputChain], ShouldEqual, 1)
+			So(deleted[i.natProxyOutputChain], ShouldEqual, 1)
+			So(deleted[i.tproxyInputChain], ShouldEqual, 4)
+			So(deleted[i.tproxyOutputChain], ShouldEqual, 2)
+			So(deleted[i.proxyInputChain], ShouldEqual, 6)
+		})
+	})
+}
+
 func TestAddPacketTrap(t *testing.T) {
 
 	Convey("Given an iptables controller, when I test addPacketTrap for Local Container", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -219,7 +372,7 @@ func TestAddPacketTrap(t *testing.T) {
 	})
 
 	Convey("Given an iptables controller, when I test addPacketTrap for Local Server", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -265,7 +418,7 @@ func TestAddPacketTrap(t *testing.T) {
 func TestAddAppACLs(t *testing.T) {
 
 	Convey("Given an iptables controller ", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -413,7 +566,7 @@ func TestAddAppACLs(t *testing.T) {
 func TestAddNetAcls(t *testing.T) {
 
 	Convey("Given an iptables controller ", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -567,10 +720,176 @@ func TestAddNetAcls(t *testing.T) {
 	})
 }
 
+func TestProtocolMatch(t *testing.T) {
+
+	Convey("Given rules without ports", t, func() {
+
+		Convey("When the rule is an icmp rule with a type and code, both should be matched", func() {
+			rule := policy.IPRule{Protocol: "ICMP", ICMPType: "8", ICMPCode: "0"}
+			So(protocolMatch(rule), ShouldResemble, []string{"-p", "ICMP", "--icmp-type", "8/0"})
+		})
+
+		Convey("When the rule is an icmp rule with a type only, the type should be matched", func() {
+			rule := policy.IPRule{Protocol: "icmp", ICMPType: "echo-request"}
+			So(protocolMatch(rule), ShouldResemble, []string{"-p", "icmp", "--icmp-type", "echo-request"})
+		})
+
+		Convey("When the rule is an icmp rule without a type, only the protocol should be matched", func() {
+			rule := policy.IPRule{Protocol: "icmp", ICMPCode: "0"}
+			So(protocolMatch(rule), ShouldResemble, []string{"-p", "icmp"})
+		})
+
+		Convey("When the rule is not an icmp rule, the type should be ignored", func() {
+			rule := policy.IPRule{Protocol: "gre", ICMPType: "8"}
+			So(protocolMatch(rule), ShouldResemble, []string{"-p", "gre"})
+		})
+	})
+
+	Convey("Given an iptables controller and an icmp rule", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.1.1.0/24",
+				Protocol: "icmp",
+				ICMPType: "8",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		var specs [][]string
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			specs = append(specs, rulespec)
+			return nil
+		})
+
+		Convey("When I add app and net ACLs, the icmp type should be matched in both", func() {
+			So(i.addAppACLs("pu1", "app", rules), ShouldBeNil)
+			So(specs[0], ShouldResemble, []string{"-p", "icmp", "--icmp-type", "8", "-d", "10.1.1.0/24", "-j", "ACCEPT"})
+
+			specs = nil
+			So(i.addNetACLs("pu1", "net", rules), ShouldBeNil)
+			So(specs[0], ShouldResemble, []string{"-p", "icmp", "--icmp-type", "8", "-s", "10.1.1.0/24", "-j", "ACCEPT"})
+		})
+	})
+}
+
+func TestMarkACLs(t *testing.T) {
+
+	Convey("Given an iptables controller and ACLs with a mark action", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.1.1.0/24",
+				Port:     "443",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Mark, DSCP: "46", FwMark: "0x100/0xff00"},
+			},
+			policy.IPRule{
+				Address:  "10.2.1.0/24",
+				Protocol: "gre",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Mark, DSCP: "AF41"},
+			},
+			policy.IPRule{
+				Address:  "10.3.1.0/24",
+				Port:     "53",
+				Protocol: "udp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept, DSCP: "46"},
+			},
+		}
+
+		var specs []string
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			specs = append(specs, strings.Join(rulespec, " "))
+			return nil
+		})
+
+		Convey("When I add the app ACLs, the packets should be marked before the flows are accepted", func() {
+			So(i.addAppACLs("pu1", "app", rules), ShouldBeNil)
+			So(specs[0], ShouldEqual, "-p tcp -d 10.1.1.0/24 --dport 443 -j DSCP --set-dscp 46")
+			So(specs[1], ShouldEqual, "-p tcp -d 10.1.1.0/24 --dport 443 -j MARK --set-mark 0x100/0xff00")
+			So(specs[2], ShouldEqual, "-p tcp -m state --state NEW -d 10.1.1.0/24 --dport 443 -j ACCEPT")
+			So(specs[3], ShouldEqual, "-p gre -d 10.2.1.0/24 -j DSCP --set-dscp-class AF41")
+			So(specs[4], ShouldEqual, "-p gre -d 10.2.1.0/24 -j ACCEPT")
+			So(specs[5], ShouldEqual, "-p udp -m state --state NEW -d 10.3.1.0/24 --dport 53 -j ACCEPT")
+		})
+
+		Convey("When I add the net ACLs, the packets should be marked before the flows are accepted", func() {
+			So(i.addNetACLs("pu1", "net", rules), ShouldBeNil)
+			So(specs[0], ShouldEqual, "-p tcp -s 10.1.1.0/24 --dport 443 -j DSCP --set-dscp 46")
+			So(specs[1], ShouldEqual, "-p tcp -s 10.1.1.0/24 --dport 443 -j MARK --set-mark 0x100/0xff00")
+			So(specs[2], ShouldEqual, "-p tcp -s 10.1.1.0/24 --dport 443 -j ACCEPT")
+		})
+
+		Convey("When the ACLs are matched by ipsets, the marked ACLs should not be in the sets", func() {
+			_, ok := aclSetEntry(rules[0])
+			So(ok, ShouldBeFalse)
+			_, ok = aclSetEntry(rules[2])
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
+
+func TestNFLOGACLs(t *testing.T) {
+
+	Convey("Given an iptables controller and ACLs with a log action", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), &Config{NFLOGThreshold: 10})
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "10.1.1.0/24",
+				Port:     "443",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log, PolicyID: "p1"},
+			},
+			policy.IPRule{
+				Address:  "10.2.1.0/24",
+				Port:     "80",
+				Protocol: "tcp",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log, PolicyID: "p2", NFLOGGroup: 20, NFLOGRate: "1/minute", NFLOGBurst: 3},
+			},
+			policy.IPRule{
+				Address:  "10.3.1.0/24",
+				Protocol: "gre",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log, PolicyID: "p3", NFLOGRate: UnlimitedNFLOGRate},
+			},
+		}
+
+		var specs []string
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			if matchSpec("NFLOG", rulespec) == nil {
+				specs = append(specs, strings.Join(rulespec, " "))
+			}
+			return nil
+		})
+
+		Convey("When I add the app ACLs, the flows should be logged at the rate of the instance or of their policy", func() {
+			So(i.addAppACLs("pu1", "app", rules), ShouldBeNil)
+			So(specs[0], ShouldEqual, "-p tcp -d 10.1.1.0/24 --dport 443 -m mark ! --mark 39 -m state --state NEW -m limit --limit 50/second --limit-burst 100 -j NFLOG --nflog-group 10 --nflog-prefix pu1:p1:3 --nflog-threshold 10")
+			So(specs[1], ShouldEqual, "-p tcp -d 10.2.1.0/24 --dport 80 -m mark ! --mark 39 -m state --state NEW -m limit --limit 1/minute --limit-burst 3 -j NFLOG --nflog-group 20 --nflog-prefix pu1:p2:3 --nflog-threshold 10")
+			So(specs[2], ShouldEqual, "-p gre -d 10.3.1.0/24 -m state --state NEW -m mark ! --mark 39 -j NFLOG --nflog-group 10 --nflog-prefix pu1:p3:3 --nflog-threshold 10")
+			So(specs[3], ShouldEqual, "-d 0.0.0.0/0 -m state --state NEW -m limit --limit 50/second --limit-burst 100 -j NFLOG --nflog-group 10 --nflog-prefix pu1:default:default6 --nflog-threshold 10")
+		})
+
+		Convey("When I add the net ACLs, the flows should be logged to the net group", func() {
+			So(i.addNetACLs("pu1", "net", rules), ShouldBeNil)
+			So(specs[0], ShouldEqual, "-p tcp -s 10.1.1.0/24 --dport 443 -m mark ! --mark 39 -m state --state NEW -m limit --limit 50/second --limit-burst 100 -j NFLOG --nflog-group 11 --nflog-prefix pu1:p1:3 --nflog-threshold 10")
+			So(specs[1], ShouldStartWith, "-p tcp -s 10.2.1.0/24 --dport 80 -m mark ! --mark 39 -m state --state NEW -m limit --limit 1/minute --limit-burst 3 -j NFLOG --nflog-group 20")
+		})
+	})
+}
+
 func TestDeleteChainRules(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -578,7 +897,7 @@ func TestDeleteChainRules(t *testing.T) {
 			iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.deleteChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSetName")
+			err := i.deleteChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSetName", nil)
 			So(err, ShouldBeNil)
 		})
 
@@ -586,7 +905,7 @@ func TestDeleteChainRules(t *testing.T) {
 			iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
 				return nil
 			})
-			err := i.deleteChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSetName")
+			err := i.deleteChainRules("appchain", "netchain", "0", "100", "", "", "5000", "proxyPortSetName", nil)
 			So(err, ShouldBeNil)
 
 		})
@@ -598,7 +917,7 @@ func TestDeleteChainRules(t *testing.T) {
 func TestDeleteAllContainerChains(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -648,7 +967,7 @@ func TestDeleteAllContainerChains(t *testing.T) {
 func TestAcceptMarkedPackets(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -658,7 +977,7 @@ func TestAcceptMarkedPackets(t *testing.T) {
 func TestRemoveMarkRule(t *testing.T) {
 
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -685,7 +1004,7 @@ func TestRemoveMarkRule(t *testing.T) {
 
 func TestAddExclusionACLs(t *testing.T) {
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -829,7 +1148,7 @@ func TestAddExclusionACLs(t *testing.T) {
 
 func TestClearCaptureSynAckPackets(t *testing.T) {
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -855,7 +1174,7 @@ func TestClearCaptureSynAckPackets(t *testing.T) {
 
 func TestUpdateTargetNetworks(t *testing.T) {
 	Convey("Given an iptables controller,", t, func() {
-		i, _ := NewInstance(&fqconfig.FilterQueue{}, constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(&fqconfig.FilterQueue{}, constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 		ipsets := provider.NewTestIpsetProvider()
@@ -900,3 +1219,66 @@ func TestUpdateTargetNetworks(t *testing.T) {
 		})
 	})
 }
+
+func TestACLPriorities(t *testing.T) {
+
+	Convey("Given an iptables controller with a memory provider", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		ipt := provider.NewMemoryIPTablesProvider()
+		i.ipt = ipt
+
+		So(ipt.NewChain(i.appPacketIPTableContext, "chain"), ShouldBeNil)
+		So(ipt.Append(i.appPacketIPTableContext, "chain", "-s", "192.168.0.1", "-j", "ACCEPT"), ShouldBeNil)
+
+		Convey("When I add ACLs with priorities", func() {
+			rules := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Port:     "80",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Reject},
+					Priority: 1,
+				},
+				policy.IPRule{
+					Address:  "10.1.0.0/16",
+					Port:     "80",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+					Priority: 1,
+				},
+				policy.IPRule{
+					Address:  "10.1.1.0/24",
+					Port:     "80",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				},
+				policy.IPRule{
+					Address:  "10.1.1.1",
+					Port:     "80",
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Reject},
+					Priority: -1,
+				},
+			}
+
+			err := i.addAppACLs("pu", "chain", rules)
+			So(err, ShouldBeNil)
+
+			Convey("The ACLs should be evaluated by increasing priority", func() {
+				list, err := ipt.List(i.appPacketIPTableContext, "chain")
+				So(err, ShouldBeNil)
+
+				order := []string{}
+				for _, rule := range list {
+					for _, term := range []string{"10.1.1.1", "192.168.0.1", "10.1.1.0/24", "10.0.0.0/8", "10.1.0.0/16"} {
+						if strings.Contains(rule, " "+term+" ") {
+							order = append(order, term)
+						}
+					}
+				}
+
+				So(order, ShouldResemble, []string{"10.1.1.1", "192.168.0.1", "10.1.1.0/24", "10.0.0.0/8", "10.1.0.0/16"})
+			})
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package iptablesctrl
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// addresses holds the IP addresses that the redirect rules of the container
+// PUs match, by context id, so that the rules can be deleted when the
+// addresses of a PU are no longer known
+type addresses struct {
+	ips map[string][]string
+	sync.Mutex
+}
+
+func newAddresses() *addresses {
+
+	return &addresses{
+		ips: map[string][]string{},
+	}
+}
+
+// get returns the addresses of the rules of a PU
+func (a *addresses) get(contextID string) []string {
+
+	a.Lock()
+	defer a.Unlock()
+
+	return a.ips[contextID]
+}
+
+// set records the addresses of the rules of a PU
+func (a *addresses) set(contextID string, ips []string) {
+
+	a.Lock()
+	defer a.Unlock()
+
+	if len(ips) == 0 {
+		delete(a.ips, contextID)
+		return
+	}
+
+	a.ips[contextID] = ips
+}
+
+// remove forgets a PU and returns the addresses of its rules
+func (a *addresses) remove(contextID string) []string {
+
+	a.Lock()
+	defer a.Unlock()
+
+	ips := a.ips[contextID]
+	delete(a.ips, contextID)
+
+	return ips
+}
+
+// puIPAddresses returns the IPv4 addresses of a PU on all its networks,
+// sorted and without duplicates. A container can be attached to several
+// bridge or overlay networks, with one address on each of them.
+func puIPAddresses(containerInfo *policy.PUInfo) []string {
+
+	if containerInfo == nil || containerInfo.Policy == nil {
+		return nil
+	}
+
+	unique := map[string]bool{}
+	for _, address := range containerInfo.Policy.IPAddresses() {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() == nil || ip.IsUnspecified() {
+			continue
+		}
+		unique[ip.String()] = true
+	}
+
+	ips := make([]string, 0, len(unique))
+	for ip := range unique {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	return ips
+}
+
+// sameAddresses returns true if two sorted lists of addresses are equal
+func sameAddresses(a, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k := range a {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,543 @@
+package iptablesctrl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"go.uber.org/zap"
+)
+
+// adoption holds the chains of the PUs programmed by a previous run until
+// they are adopted or cleaned, and the sets of the PUs supervised since the
+// instance started
+type adoption struct {
+	// chains are the versions of the chains of the previous run, by the name
+	// of their application chain without its version. It is nil once the
+	// stale rules are cleaned.
+	chains map[string][]int
+	// sets are the PU sets that are used by the adopted and the configured PUs
+	sets map[string]bool
+	sync.Mutex
+}
+
+// take returns the versions of the chains of a PU and removes them from the
+// chains to adopt
+func (a *adoption) take(key string) []int {
+
+	a.Lock()
+	defer a.Unlock()
+
+	versions := a.chains[key]
+	delete(a.chains, key)
+
+	return versions
+}
+
+// keep records the sets of a PU, so that the rules that match them are not
+// cleaned. It does nothing once the stale rules are cleaned.
+func (a *adoption) keep(sets ...string) {
+
+	if a == nil {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.chains == nil {
+		return
+	}
+
+	for _, set := range sets {
+		a.sets[set] = true
+	}
+}
+
+// finish returns the chains that were not adopted and the sets of the PUs,
+// and stops the adoption. The chains are nil if it was already stopped.
+func (a *adoption) finish() (map[string][]int, map[string]bool) {
+
+	a.Lock()
+	defer a.Unlock()
+
+	chains, sets := a.chains, a.sets
+	a.chains = nil
+	a.sets = nil
+
+	return chains, sets
+}
+
+// discoverChains returns the chains of the PUs programmed by a previous run
+func (i *Instance) discoverChains() (*adoption, error) {
+
+	chains, err := i.ipt.ListChains(i.appPacketIPTableContext)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list chains of table %s: %s", i.appPacketIPTableContext, err)
+	}
+
+	a := &adoption{
+		chains: map[string][]int{},
+		sets:   map[string]bool{},
+	}
+
+	for _, chain := range chains {
+
+		if !strings.HasPrefix(chain, i.appChainPrefix) {
+			continue
+		}
+
+		idx := strings.LastIndex(chain, "-")
+		version, err := strconv.Atoi(chain[idx+1:])
+		if err != nil || version&1 != version {
+			continue
+		}
+
+		key := chain[:idx]
+		a.chains[key] = append(a.chains[key], version)
+		sort.Ints(a.chains[key])
+	}
+
+	return a, nil
+}
+
+// adoptedChains returns the chains of a version of a PU of the previous run
+func (i *Instance) adoptedChains(key string, version int) (app, net string) {
+
+	suffix := "-" + strconv.Itoa(version)
+
+	return key + suffix, i.netChainPrefix + strings.TrimPrefix(key, i.appChainPrefix) + suffix
+}
+
+// adoptGlobalRules creates the global chains and rules that are missing after
+// a warm restart, and updates the target networks of the previous run
+func (i *Instance) adoptGlobalRules(networks []string) error {
+
+	// The set does not exist if there was no previous run
+	current, err := i.listSet(i.targetNetworkSet)
+	if err != nil {
+		current = nil
+	}
+
+	if err := i.createTargetSet(nil); err != nil {
+		return err
+	}
+
+	if err := i.updateTargetNetworks(current, networks); err != nil {
+		return err
+	}
+
+	chains := []struct{ table, chain string }{
+		{i.appProxyIPTableContext, i.natProxyInputChain},
+		{i.appProxyIPTableContext, i.natProxyOutputChain},
+		{i.appPacketIPTableContext, i.proxyOutputChain},
+		{i.appPacketIPTableContext, i.proxyInputChain},
+		{i.appPacketIPTableContext, i.tproxyInputChain},
+		{i.appPacketIPTableContext, i.tproxyOutputChain},
+	}
+	if i.mode == constants.LocalServer {
+		chains = append(chains, struct{ table, chain string }{i.appPacketIPTableContext, i.uidChain})
+	}
+
+	for _, c := range chains {
+		if err := i.ensureChain(c.table, c.chain); err != nil {
+			return err
+		}
+	}
+
+	if i.mode == constants.LocalServer {
+		exists, err := i.ipt.Exists(i.appPacketIPTableContext, i.appPacketIPTableSection, "-j", i.uidChain)
+		if err != nil {
+			return fmt.Errorf("unable to check rule in table %s, chain %s: %s", i.appPacketIPTableContext, i.appPacketIPTableSection, err)
+		}
+
+		if !exists {
+			if err := i.ipt.Insert(i.appPacketIPTableContext, i.appPacketIPTableSection, 1, "-j", i.uidChain); err != nil {
+				return fmt.Errorf("unable to add rule in table %s, chain %s: %s", i.appPacketIPTableContext, i.appPacketIPTableSection, err)
+			}
+		}
+	}
+
+	if _, err := i.ReconcileGlobalRules(); err != nil {
+		return fmt.Errorf("failed to update synack networks: %s", err)
+	}
+
+	return nil
+}
+
+// AdoptRules implements the Adopter interface of the supervisor. It adopts the
+// chains of a PU programmed by a previous run, that were discovered when the
+// instance started with a warm restart. The chains are kept if they hold the
+// rules of the policy, so that the flows of the PU are not interrupted, and
+// are replaced by the chains of the other version otherwise. It returns the
+// version of the chains, and false if there were no chains to adopt.
+func (i *Instance) AdoptRules(contextID string, containerInfo *policy.PUInfo) (int, bool, error) {
+
+	if containerInfo == nil || containerInfo.Policy == nil || containerInfo.Runtime == nil {
+		return 0, false, errors.New("container info cannot be nil")
+	}
+
+	if i.adoption == nil {
+		return 0, false, nil
+	}
+
+	appChain, _, err := i.chainName(contextID, 0)
+	if err != nil {
+		return 0, false, err
+	}
+
+	versions := i.adoption.take(strings.TrimSuffix(appChain, "-0"))
+	if len(versions) == 0 {
+		return 0, false, nil
+	}
+
+	if err := i.adoptSets(contextID, containerInfo); err != nil {
+		return 0, false, err
+	}
+
+	for _, version := range versions {
+
+		match, err := i.adoptedRulesMatch(version, contextID, containerInfo)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if !match {
+			continue
+		}
+
+		// The chains of the other version are left by an interrupted update
+		for _, v := range versions {
+			if v != version {
+				i.removePUChains(contextID, v)
+			}
+		}
+
+		appChain, netChain, err := i.chainName(contextID, version)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if err := i.createACLSets(appChain, netChain, containerInfo.Policy); err != nil {
+			return 0, false, err
+		}
+
+		if i.mode != constants.LocalServer {
+			i.addresses.set(contextID, puIPAddresses(containerInfo))
+		}
+
+		return version, true, nil
+	}
+
+	zap.L().Info("Rules of the previous run differ from the policy of the PU",
+		zap.String("contextID", contextID),
+	)
+
+	version := versions[0]
+	for _, v := range versions[1:] {
+		i.removePUChains(contextID, v)
+	}
+
+	// The rules of the policy are programmed before the chains of the
+	// previous run are removed
+	if err := i.UpdateRules(version^1, contextID, containerInfo, containerInfo); err != nil {
+		return 0, false, err
+	}
+
+	i.removePUChains(contextID, version)
+
+	return version ^ 1, true, nil
+}
+
+// adoptSets creates the sets of a PU that are missing, updates its proxy
+// sets with its policy and registers its port set
+func (i *Instance) adoptSets(contextID string, containerInfo *policy.PUInfo) error {
+
+	i.adoption.keep(i.puSets(contextID, containerInfo)...)
+
+	if containerInfo.Runtime.Options().TransparentProxy || containerInfo.Policy.ProxiedServices().HasUDPServices() {
+		if err := i.addTransparentProxyRouting(); err != nil {
+			return err
+		}
+	}
+
+	mark := ""
+	if i.mode == constants.LocalServer {
+		mark = containerInfo.Runtime.Options().CgroupMark
+	}
+
+	proxyPortSetName := PuPortSetName(contextID, mark, proxyPortSet)
+	if err := i.createProxySets(nil, nil, proxyPortSetName); err != nil {
+		return fmt.Errorf("Failed to create ProxySet %s : %s", proxyPortSetName, err)
+	}
+
+	if err := i.updateProxyPorts(contextID, containerInfo); err != nil {
+		return err
+	}
+
+	uid := puOwner(containerInfo)
+	if i.mode != constants.LocalServer || (uid == "" && !containerInfo.Runtime.Options().AutoPort) {
+		return nil
+	}
+
+	portSetName := PuPortSetName(contextID, mark, PuPortSet)
+	if _, err := i.listSet(portSetName); err != nil {
+		if err := i.createPUPortSet(portSetName); err != nil {
+			return err
+		}
+	}
+
+	if i.portSetInstance == nil {
+		return errors.New("enforcer portset instance cannot be nil for host")
+	}
+
+	if uid != "" {
+		return i.portSetInstance.AddUserPortSet(uid, portSetName, mark)
+	}
+
+	return i.portSetInstance.AddCgroupPortSet(contextID, portSetName, mark)
+}
+
+// adoptedRulesMatch returns true if the chains of a version of a PU hold the
+// rules of its policy and the traffic of the PU is redirected to them
+func (i *Instance) adoptedRulesMatch(version int, contextID string, containerInfo *policy.PUInfo) (bool, error) {
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return false, err
+	}
+
+	expected, err := i.recordPolicyRules(contextID, appChain, netChain, containerInfo)
+	if err != nil {
+		return false, err
+	}
+
+	for _, key := range expected.keys {
+
+		rules := expected.chains[key]
+
+		// The chain of the other version may not exist
+		listed, err := i.ipt.List(key.table, key.chain)
+		if err != nil {
+			return false, nil
+		}
+
+		count := 0
+		for _, line := range listed {
+			if strings.HasPrefix(line, "-A ") {
+				count++
+			}
+		}
+
+		if count != len(rules) {
+			return false, nil
+		}
+
+		missing, err := i.missingRules(key.table, key.chain, rules)
+		if err != nil {
+			return false, err
+		}
+
+		if len(missing) > 0 {
+			return false, nil
+		}
+	}
+
+	for _, rule := range i.puRedirectRules(contextID, appChain, netChain, containerInfo) {
+
+		exists, err := i.ipt.Exists(rule[0], rule[1], rule[2:]...)
+		if err != nil {
+			return false, fmt.Errorf("unable to check rule in table %s, chain %s: %s", rule[0], rule[1], err)
+		}
+
+		if !exists {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// removePUChains removes the chains of a version of a PU with the rules that
+// jump to them
+func (i *Instance) removePUChains(contextID string, version int) {
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		zap.L().Warn("Unable to generate chain name", zap.Error(err))
+		return
+	}
+
+	if err := i.removeRules(func(rulespec []string) bool {
+		target := ruleTarget(rulespec)
+		return target == appChain || target == netChain
+	}); err != nil {
+		zap.L().Warn("Unable to remove the rules of the previous run", zap.String("contextID", contextID), zap.Error(err))
+	}
+
+	if err := i.deleteAllContainerChains(appChain, netChain); err != nil {
+		zap.L().Warn("Unable to remove the chains of the previous run", zap.String("contextID", contextID), zap.Error(err))
+	}
+}
+
+// CleanStaleRules implements the Adopter interface of the supervisor. It
+// removes the chains of the previous run that were not adopted, the rules that
+// jump to them, and the rules and the sets of the PUs of the previous run. It
+// must be called once the PUs of the previous run are supervised again.
+func (i *Instance) CleanStaleRules() error {
+
+	if i.adoption == nil {
+		return nil
+	}
+
+	chains, sets := i.adoption.finish()
+	if chains == nil {
+		return nil
+	}
+
+	staleChains := map[string]bool{}
+	for key, versions := range chains {
+		for _, version := range versions {
+			appChain, netChain := i.adoptedChains(key, version)
+			staleChains[appChain] = true
+			staleChains[netChain] = true
+		}
+	}
+
+	staleSets := map[string]bool{}
+
+	err := i.removeRules(func(rulespec []string) bool {
+
+		stale := staleChains[ruleTarget(rulespec)]
+
+		for _, set := range ruleSets(rulespec) {
+			if i.isPUSet(set) && !sets[set] {
+				staleSets[set] = true
+				stale = true
+			}
+		}
+
+		return stale
+	})
+
+	for key, versions := range chains {
+		for _, version := range versions {
+			appChain, netChain := i.adoptedChains(key, version)
+			if err := i.deleteAllContainerChains(appChain, netChain); err != nil {
+				zap.L().Warn("Unable to remove the chains of the previous run", zap.String("chain", appChain), zap.Error(err))
+			}
+		}
+	}
+
+	for set := range staleSets {
+		if err := i.namedIpset(set).Destroy(); err != nil {
+			zap.L().Warn("Unable to destroy the set of the previous run", zap.String("set", set), zap.Error(err))
+		}
+	}
+
+	return err
+}
+
+// removeRules deletes the rules of the tables of the instance that match,
+// except the rules of the chains of the PUs
+func (i *Instance) removeRules(match func(rulespec []string) bool) error {
+
+	seen := map[string]bool{}
+
+	for _, table := range []string{i.appPacketIPTableContext, i.netPacketIPTableContext, i.appProxyIPTableContext} {
+
+		if seen[table] {
+			continue
+		}
+		seen[table] = true
+
+		chains, err := i.ipt.ListChains(table)
+		if err != nil {
+			return fmt.Errorf("unable to list chains of table %s: %s", table, err)
+		}
+
+		for _, chain := range chains {
+
+			if strings.HasPrefix(chain, i.appChainPrefix) || strings.HasPrefix(chain, i.netChainPrefix) {
+				continue
+			}
+
+			rules, err := i.ipt.List(table, chain)
+			if err != nil {
+				return fmt.Errorf("unable to list chain %s of table %s: %s", chain, table, err)
+			}
+
+			for _, line := range rules {
+
+				_, rulespec, err := provider.ParseRule(line)
+				if err != nil || !match(rulespec) {
+					continue
+				}
+
+				if err := i.ipt.Delete(table, chain, rulespec...); err != nil {
+					return fmt.Errorf("unable to delete rule in table %s, chain %s: %s", table, chain, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// puSets returns the names of the port and the proxy sets of a PU
+func (i *Instance) puSets(contextID string, containerInfo *policy.PUInfo) []string {
+
+	mark := ""
+	if i.mode == constants.LocalServer {
+		mark = containerInfo.Runtime.Options().CgroupMark
+	}
+
+	dstSetName, srcSetName := i.getSetNamePair(PuPortSetName(contextID, mark, proxyPortSet))
+	sets := []string{dstSetName, srcSetName}
+
+	if i.mode == constants.LocalServer && (puOwner(containerInfo) != "" || containerInfo.Runtime.Options().AutoPort) {
+		sets = append(sets, PuPortSetName(contextID, mark, PuPortSet))
+	}
+
+	return sets
+}
+
+// isPUSet returns true if a set is a port or a proxy set of a PU
+func (i *Instance) isPUSet(set string) bool {
+
+	dstPrefix, srcPrefix := i.getSetNamePair(proxyPortSet)
+
+	return strings.HasPrefix(set, PuPortSet) || strings.HasPrefix(set, dstPrefix) || strings.HasPrefix(set, srcPrefix)
+}
+
+// ruleTarget returns the target of a rulespec
+func ruleTarget(rulespec []string) string {
+
+	for idx := len(rulespec) - 2; idx >= 0; idx-- {
+		if rulespec[idx] == "-j" || rulespec[idx] == "-g" {
+			return rulespec[idx+1]
+		}
+	}
+
+	return ""
+}
+
+// ruleSets returns the sets matched by a rulespec
+func ruleSets(rulespec []string) []string {
+
+	sets := []string{}
+
+	for idx := 0; idx < len(rulespec)-1; idx++ {
+		if rulespec[idx] == "--match-set" {
+			sets = append(sets, rulespec[idx+1])
+		}
+	}
+
+	return sets
+}
@@ -0,0 +1,200 @@
+package iptablesctrl
+
+import (
+	"strconv"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
+)
+
+// Default values of the configuration of an instance
+const (
+	DefaultChainPrefix    = "TRIREME-"
+	DefaultProxyMark      = "0x40"
+	DefaultObserveMark    = "39"
+	DefaultAppNFLOGGroup  = 10
+	DefaultNetNFLOGGroup  = 11
+	DefaultNFLOGRate      = "50/second"
+	DefaultNFLOGBurst     = 100
+	DefaultNFLOGThreshold = 1
+	DefaultTproxyMark     = "0x80"
+	DefaultTproxyTable    = 100
+)
+
+// UnlimitedNFLOGRate is the rate of the NFLOG rules that log all the flows
+const UnlimitedNFLOGRate = "unlimited"
+
+// Config holds the names and the values used by the rules of an instance, so
+// that several instances, or an instance and other agents like kube-proxy, can
+// program their rules side by side. The fields left empty take the defaults.
+type Config struct {
+	// ChainPrefix is the prefix of the chains of the PUs. The rules that jump
+	// to chains with this prefix are removed when the instance is cleaned.
+	ChainPrefix string
+	// GlobalPrefix is prepended to the names of the global chains and sets
+	GlobalPrefix string
+	// ProxyMark is the mark of the packets of the proxied connections
+	ProxyMark string
+	// ObserveMark is the mark of the packets of the observed flows
+	ObserveMark string
+	// ConnMark is the connmark of the connections accepted by the enforcer. It
+	// must be the connmark set by the enforcer.
+	ConnMark uint32
+	// AppNFLOGGroup is the NFLOG group of the flows started by the PUs. It must
+	// be the group the enforcer listens on.
+	AppNFLOGGroup int
+	// NetNFLOGGroup is the NFLOG group of the flows received by the PUs. It
+	// must be the group the enforcer listens on.
+	NetNFLOGGroup int
+	// NFLOGRate is the rate, like 50/second, above which the flows are not
+	// logged to the NFLOG groups, so that scans do not overwhelm the enforcer.
+	// All the flows are logged if it is UnlimitedNFLOGRate. The rate of an ACL
+	// can be set by its policy.
+	NFLOGRate string
+	// NFLOGBurst is the number of flows logged above the NFLOGRate before the
+	// rate applies
+	NFLOGBurst int
+	// NFLOGThreshold is the number of packets queued by the kernel before they
+	// are sent to the NFLOG groups
+	NFLOGThreshold int
+	// ProxyPort is the port of the proxy of the PUs that do not have one
+	ProxyPort string
+	// TproxyMark is the mark of the packets sent to a transparent proxy
+	TproxyMark string
+	// TproxyTable is the routing table that delivers the packets with the
+	// TproxyMark locally
+	TproxyTable int
+	// NetlinkIptables programs the rules with nf_tables netlink messages
+	// instead of running the iptables command. It requires iptables-nft, and
+	// the iptables command is used when it is not available.
+	NetlinkIptables bool
+	// DryRun keeps the rules and the ipsets in memory instead of programming
+	// them, so that the rules of the PUs can be reviewed without privileges.
+	// The rules are returned by Save.
+	DryRun bool
+	// AuditLog records the iptables and ipset operations of the instance if
+	// it is not nil
+	AuditLog *provider.AuditLog
+	// WarmRestart keeps the rules and the ipsets when the instance stops, and
+	// adopts the rules of a previous run instead of cleaning them when it
+	// starts, so that the flows of the PUs are not interrupted by a restart.
+	// The PUs are adopted with AdoptRules, and the rules of the previous run
+	// that are not adopted are removed with CleanStaleRules.
+	WarmRestart bool
+	// ClampMSS is the largest MSS advertised by the SYN and SYN-ACK packets
+	// exchanged with the target networks, so that the segments of the
+	// authorized connections leave room for the authentication option on the
+	// paths with a small MTU. The MSS is not clamped if it is 0.
+	ClampMSS int
+	// UDPEnforcement sends the UDP datagrams of the target networks to the
+	// queues, so that the identity of the PUs is enforced on the UDP flows.
+	// The UDP datagrams are not trapped if it is false.
+	UDPEnforcement bool
+}
+
+// DefaultConfig returns the configuration used when none is given
+func DefaultConfig() *Config {
+
+	return &Config{
+		ChainPrefix:    DefaultChainPrefix,
+		ProxyMark:      DefaultProxyMark,
+		ObserveMark:    DefaultObserveMark,
+		ConnMark:       constants.DefaultConnMark,
+		AppNFLOGGroup:  DefaultAppNFLOGGroup,
+		NetNFLOGGroup:  DefaultNetNFLOGGroup,
+		NFLOGRate:      DefaultNFLOGRate,
+		NFLOGBurst:     DefaultNFLOGBurst,
+		NFLOGThreshold: DefaultNFLOGThreshold,
+		ProxyPort:      ProxyPort,
+		TproxyMark:     DefaultTproxyMark,
+		TproxyTable:    DefaultTproxyTable,
+	}
+}
+
+// withDefaults returns a copy of the configuration with the defaults in the
+// fields left empty
+func (c *Config) withDefaults() Config {
+
+	cfg := *DefaultConfig()
+	if c == nil {
+		return cfg
+	}
+
+	cfg.GlobalPrefix = c.GlobalPrefix
+	cfg.NetlinkIptables = c.NetlinkIptables
+	cfg.DryRun = c.DryRun
+	cfg.AuditLog = c.AuditLog
+	cfg.WarmRestart = c.WarmRestart
+	cfg.ClampMSS = c.ClampMSS
+	cfg.UDPEnforcement = c.UDPEnforcement
+
+	if c.ChainPrefix != "" {
+		cfg.ChainPrefix = c.ChainPrefix
+	}
+	if c.ProxyMark != "" {
+		cfg.ProxyMark = c.ProxyMark
+	}
+	if c.ObserveMark != "" {
+		cfg.ObserveMark = c.ObserveMark
+	}
+	if c.ConnMark != 0 {
+		cfg.ConnMark = c.ConnMark
+	}
+	if c.AppNFLOGGroup != 0 {
+		cfg.AppNFLOGGroup = c.AppNFLOGGroup
+	}
+	if c.NetNFLOGGroup != 0 {
+		cfg.NetNFLOGGroup = c.NetNFLOGGroup
+	}
+	if c.NFLOGRate != "" {
+		cfg.NFLOGRate = c.NFLOGRate
+	}
+	if c.NFLOGBurst != 0 {
+		cfg.NFLOGBurst = c.NFLOGBurst
+	}
+	if c.NFLOGThreshold != 0 {
+		cfg.NFLOGThreshold = c.NFLOGThreshold
+	}
+	if c.ProxyPort != "" {
+		cfg.ProxyPort = c.ProxyPort
+	}
+	if c.TproxyMark != "" {
+		cfg.TproxyMark = c.TproxyMark
+	}
+	if c.TproxyTable != 0 {
+		cfg.TproxyTable = c.TproxyTable
+	}
+
+	return cfg
+}
+
+// applyConfig sets the names and the values of the rules of the instance
+func (i *Instance) applyConfig(cfg Config) {
+
+	i.chainPrefix = cfg.ChainPrefix
+	i.appChainPrefix = cfg.ChainPrefix + "App-"
+	i.netChainPrefix = cfg.ChainPrefix + "Net-"
+	i.uidChain = cfg.GlobalPrefix + uidchain
+	i.targetNetworkSet = cfg.GlobalPrefix + targetNetworkSet
+	i.natProxyInputChain = cfg.GlobalPrefix + natProxyInputChain
+	i.natProxyOutputChain = cfg.GlobalPrefix + natProxyOutputChain
+	i.proxyInputChain = cfg.GlobalPrefix + proxyInputChain
+	i.proxyOutputChain = cfg.GlobalPrefix + proxyOutputChain
+	i.proxyMark = cfg.ProxyMark
+	i.observeMark = cfg.ObserveMark
+	i.connMark = strconv.FormatUint(uint64(cfg.ConnMark), 10)
+	i.appNFLOGGroup = strconv.Itoa(cfg.AppNFLOGGroup)
+	i.netNFLOGGroup = strconv.Itoa(cfg.NetNFLOGGroup)
+	i.nflogRate = cfg.NFLOGRate
+	i.nflogBurst = strconv.Itoa(cfg.NFLOGBurst)
+	i.nflogThreshold = strconv.Itoa(cfg.NFLOGThreshold)
+	i.proxyPort = cfg.ProxyPort
+	i.tproxyInputChain = cfg.GlobalPrefix + tproxyInputChain
+	i.tproxyOutputChain = cfg.GlobalPrefix + tproxyOutputChain
+	i.tproxyMark = cfg.TproxyMark
+	i.tproxyTable = strconv.Itoa(cfg.TproxyTable)
+	if cfg.ClampMSS > 0 {
+		i.clampMSS = strconv.Itoa(cfg.ClampMSS)
+	}
+	i.udpEnforcement = cfg.UDPEnforcement
+}
@@ -1,13 +1,27 @@
 package iptablesctrl
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"os/exec"
+	"strings"
 
+	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
+	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/bvandewalle/go-ipset/ipset"
 	"go.uber.org/zap"
 )
 
+const (
+	aclSetPrefix = "TRI-ACL-"
+	aclSetAccept = "A"
+	aclSetReject = "R"
+	// aclSetSwap is the suffix of the set in which the entries of an ACL
+	// set are loaded before it is swapped with the ACL set
+	aclSetSwap = "-S"
+)
+
 // updateTargetNetworks updates the set of target networks. Tries to minimize
 // read/writes to the ipset structures
 func (i *Instance) updateTargetNetworks(old, new []string) error {
@@ -41,9 +55,9 @@ func (i *Instance) updateTargetNetworks(old, new []string) error {
 // createTargetSet creates a new target set
 func (i *Instance) createTargetSet(networks []string) error {
 
-	ips, err := i.ipset.NewIpset(targetNetworkSet, "hash:net", &ipset.Params{})
+	ips, err := i.ipset.NewIpset(i.targetNetworkSet, "hash:net", &ipset.Params{})
 	if err != nil {
-		return fmt.Errorf("unable to create ipset for %s: %s", targetNetworkSet, err)
+		return fmt.Errorf("unable to create ipset for %s: %s", i.targetNetworkSet, err)
 	}
 
 	i.targetSet = ips
@@ -95,29 +109,25 @@ func (i *Instance) createProxySets(vipipportset []string, pipipportset []string,
 
 func (i *Instance) updateProxySet(vipipportset []string, pipipportset []string, portSetName string) error {
 	dstSetName, srcSetName := i.getSetNamePair(portSetName)
-	vipTargetSet := ipset.IPSet{
-		Name: dstSetName,
-	}
-	if ferr := vipTargetSet.Flush(); ferr != nil {
+	if ferr := i.namedIpset(dstSetName).Flush(); ferr != nil {
 		zap.L().Warn("Unable to flush the vip proxy set")
 	}
 
+	// The sets are updated by name, since the instance only keeps the sets
+	// of the last PU that it created
 	for _, net := range vipipportset {
-		if err := i.vipTargetSet.Add(net, 0); err != nil {
+		if err := i.namedIpset(dstSetName).Add(net, 0); err != nil {
 			zap.L().Error("Failed to add vip", zap.Error(err))
 			return fmt.Errorf("unable to add ip %s to target networks ipset: %s", net, err)
 		}
 	}
 
-	pipTargetSet := ipset.IPSet{
-		Name: srcSetName,
-	}
-	if ferr := pipTargetSet.Flush(); ferr != nil {
+	if ferr := i.namedIpset(srcSetName).Flush(); ferr != nil {
 		zap.L().Warn("Unable to flush the pip proxy set")
 	}
 
 	for _, net := range pipipportset {
-		if err := i.pipTargetSet.Add(net, 0); err != nil {
+		if err := i.namedIpset(srcSetName).Add(net, 0); err != nil {
 			zap.L().Error("Failed to add vip", zap.Error(err))
 			return fmt.Errorf("unable to add ip %s to target networks ipset: %s", net, err)
 		}
@@ -132,15 +142,227 @@ func (i *Instance) getSetNamePair(portSetName string) (string, string) {
 
 }
 
+// namedIpset returns an ipset that was not created by the instance
+func (i *Instance) namedIpset(setName string) provider.Ipset {
+
+	if named, ok := i.ipset.(provider.NamedIpsetProvider); ok {
+		return named.GetIpset(setName)
+	}
+
+	set := &ipset.IPSet{
+		Name: setName,
+	}
+	if i.audit == nil {
+		return set
+	}
+
+	return provider.NewAuditIpset(setName, set, i.audit)
+}
+
+// listSet returns the members of an ipset
+func (i *Instance) listSet(setName string) ([]string, error) {
+
+	if named, ok := i.ipset.(provider.NamedIpsetProvider); ok {
+		return named.ListIpset(setName)
+	}
+
+	set := ipset.IPSet{
+		Name: setName,
+	}
+
+	var members []string
+	err := i.audit.Audit(provider.AuditEntry{
+		Provider:  provider.AuditIpset,
+		Operation: "ListIpset",
+		Set:       setName,
+	}, func() (err error) {
+		members, err = set.List()
+		return err
+	})
+
+	return members, err
+}
+
 //Not using ipset from coreos library they don't support bitmap:port
 func (i *Instance) createPUPortSet(setname string) error {
+	// The providers that operate on the sets by name create sets of any type
+	if _, ok := i.ipset.(provider.NamedIpsetProvider); ok {
+		_, err := i.ipset.NewIpset(setname, "bitmap:port", &ipset.Params{})
+		return err
+	}
+
 	//Bitmap type is not supported by the ipset library
 	//_, err := i.ipset.NewIpset(setname, "hash:port", &ipset.Params{})
-	path, _ := exec.LookPath("ipset")
-	out, err := exec.Command(path, "create", setname, "bitmap:port", "range", "0-65535", "timeout", "0").CombinedOutput()
+	return i.audit.Audit(provider.AuditEntry{
+		Provider:  provider.AuditIpset,
+		Operation: "NewIpset",
+		Set:       setname,
+		Args:      []string{"bitmap:port"},
+	}, func() error {
+		path, _ := exec.LookPath("ipset")
+		out, err := exec.Command(path, "create", setname, "bitmap:port", "range", "0-65535", "timeout", "0").CombinedOutput()
+		if err != nil {
+			zap.L().Error("Unable to creating set", zap.String("ipset-output", string(out)))
+		}
+		return err
+	})
+
+}
+
+// aclSetName returns the name of the set of the ACLs of a chain with an action.
+// Chain names are too long for ipsets, so they are hashed.
+func aclSetName(chain, action string) string {
+	hash := md5.Sum([]byte(chain))
+	return aclSetPrefix + action + "-" + hex.EncodeToString(hash[:])[:12]
+}
+
+// aclSetNames returns the names of the ACL sets of the chains of a PU
+func (i *Instance) aclSetNames(appChain, netChain string) []string {
+	return []string{
+		aclSetName(appChain, aclSetAccept),
+		aclSetName(appChain, aclSetReject),
+		aclSetName(netChain, aclSetAccept),
+		aclSetName(netChain, aclSetReject),
+	}
+}
+
+// aclSetEntry returns the entry of an ACL in a hash:net,port set. Only the TCP
+// and UDP ACLs that are not logged nor observed can be matched with a set.
+func aclSetEntry(rule policy.IPRule) (string, bool) {
+
+	proto := strings.ToLower(rule.Protocol)
+	if proto != "tcp" && proto != "udp" {
+		return "", false
+	}
+
+	// The logged and marked ACLs, and the ones with an explicit priority, need
+	// their own rules
+	if rule.Policy.Action&(policy.Log|policy.Mark) > 0 || rule.Policy.ObserveAction.Observed() || rule.Priority != 0 {
+		return "", false
+	}
+
+	// ipsets do not accept the networks of size 0
+	if rule.Port == "" || strings.HasSuffix(rule.Address, "/0") {
+		return "", false
+	}
+
+	return rule.Address + "," + proto + ":" + strings.Replace(rule.Port, ":", "-", 1), true
+}
+
+// inACLSet returns true if an ACL is matched by the ACL sets of its chain
+func (i *Instance) inACLSet(rule policy.IPRule) bool {
+
+	if !i.aclSets {
+		return false
+	}
+
+	if _, ok := aclSetEntry(rule); !ok {
+		return false
+	}
+
+	action := rule.Policy.Action & (policy.Accept | policy.Reject)
+	return action == policy.Accept || action == policy.Reject
+}
+
+// aclSetEntries returns the entries of the accept and reject sets of a list of ACLs
+func aclSetEntries(rules policy.IPRuleList) (accepts []string, rejects []string) {
+
+	for _, rule := range rules {
+		entry, ok := aclSetEntry(rule)
+		if !ok {
+			continue
+		}
+
+		switch rule.Policy.Action & (policy.Accept | policy.Reject) {
+		case policy.Accept:
+			accepts = append(accepts, entry)
+		case policy.Reject:
+			rejects = append(rejects, entry)
+		}
+	}
+
+	return accepts, rejects
+}
+
+// createACLSets creates the ACL sets of the chains of a PU with the ACLs of its
+// policy. The sets must exist before the rules of the chains refer to them.
+func (i *Instance) createACLSets(appChain, netChain string, policyrules *policy.PUPolicy) error {
+
+	if !i.aclSets {
+		return nil
+	}
+
+	appAccepts, appRejects := aclSetEntries(policyrules.ApplicationACLs())
+	netAccepts, netRejects := aclSetEntries(policyrules.NetworkACLs())
+
+	for _, set := range []struct {
+		name    string
+		entries []string
+	}{
+		{aclSetName(appChain, aclSetAccept), appAccepts},
+		{aclSetName(appChain, aclSetReject), appRejects},
+		{aclSetName(netChain, aclSetAccept), netAccepts},
+		{aclSetName(netChain, aclSetReject), netRejects},
+	} {
+		if err := i.loadACLSet(set.name, set.entries); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadACLSet creates an ACL set with its entries. A set left by a previous run
+// is reused. If the provider can swap sets, the entries are loaded in another
+// set that is swapped with it, so that its entries are replaced atomically.
+func (i *Instance) loadACLSet(name string, entries []string) error {
+
+	ips, err := i.ipset.NewIpset(name, "hash:net,port", &ipset.Params{})
 	if err != nil {
-		zap.L().Error("Unable to creating set", zap.String("ipset-output", string(out)))
+		return fmt.Errorf("unable to create ipset for %s: %s", name, err)
+	}
+
+	swapper, ok := i.ipset.(provider.SwapIpsetProvider)
+	if !ok {
+		if err := ips.Flush(); err != nil {
+			return fmt.Errorf("unable to flush ipset %s: %s", name, err)
+		}
+
+		if err := provider.AddEntries(ips, entries, 0); err != nil {
+			return fmt.Errorf("unable to add entries to ipset %s: %s", name, err)
+		}
+
+		return nil
+	}
+
+	tmp, err := i.ipset.NewIpset(name+aclSetSwap, "hash:net,port", &ipset.Params{})
+	if err != nil {
+		return fmt.Errorf("unable to create ipset for %s: %s", name+aclSetSwap, err)
+	}
+	defer tmp.Destroy() // nolint
+
+	if err := tmp.Flush(); err != nil {
+		return fmt.Errorf("unable to flush ipset %s: %s", name+aclSetSwap, err)
+	}
+
+	if err := provider.AddEntries(tmp, entries, 0); err != nil {
+		return fmt.Errorf("unable to add entries to ipset %s: %s", name+aclSetSwap, err)
 	}
-	return err
 
+	if err := swapper.Swap(name+aclSetSwap, name); err != nil {
+		return fmt.Errorf("unable to swap ipset %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// destroyACLSets destroys the ACL sets of the chains of a PU. The chains must
+// be deleted first, since the sets cannot be destroyed while rules refer to them.
+func (i *Instance) destroyACLSets(appChain, netChain string) {
+
+	for _, name := range i.aclSetNames(appChain, netChain) {
+		if err := i.namedIpset(name).Destroy(); err != nil {
+			zap.L().Warn("Failed to destroy acl set", zap.String("SetName", name), zap.Error(err))
+		}
+	}
 }
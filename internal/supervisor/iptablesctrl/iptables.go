@@ -14,16 +14,12 @@ import (
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/policy"
-	"github.com/bvandewalle/go-ipset/ipset"
 
 	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
 )
 
 const (
 	uidchain         = "UIDCHAIN"
-	chainPrefix      = "TRIREME-"
-	appChainPrefix   = chainPrefix + "App-"
-	netChainPrefix   = chainPrefix + "Net-"
 	targetNetworkSet = "TargetNetSet"
 	// PuPortSet The prefix for portset names
 	PuPortSet                = "PUPort-"
@@ -35,7 +31,8 @@ const (
 	natProxyInputChain       = "RedirProxy-Net"
 	proxyOutputChain         = "Proxy-App"
 	proxyInputChain          = "Proxy-Net"
-	proxyMark                = "0x40"
+	tproxyOutputChain        = "Tproxy-App"
+	tproxyInputChain         = "Tproxy-Net"
 	// ProxyPort DefaultProxyPort
 	ProxyPort = "5000"
 )
@@ -57,22 +54,102 @@ type Instance struct {
 	appSynAckIPTableSection string
 	mode                    constants.ModeType
 	portSetInstance         portset.PortSet
+	failMode                constants.FailMode
+	aclSets                 bool
+	chainPrefix             string
+	appChainPrefix          string
+	netChainPrefix          string
+	uidChain                string
+	targetNetworkSet        string
+	natProxyInputChain      string
+	natProxyOutputChain     string
+	proxyInputChain         string
+	proxyOutputChain        string
+	proxyMark               string
+	observeMark             string
+	connMark                string
+	appNFLOGGroup           string
+	netNFLOGGroup           string
+	nflogRate               string
+	nflogBurst              string
+	nflogThreshold          string
+	proxyPort               string
+	tproxyInputChain        string
+	tproxyOutputChain       string
+	tproxyMark              string
+	tproxyTable             string
+	tproxyRouting           bool
+	clampMSS                string
+	udpEnforcement          bool
+	ipCommand               func(args ...string) error
+	audit                   *provider.AuditLog
+	warmRestart             bool
+	adoption                *adoption
+	addresses               *addresses
 }
 
-// NewInstance creates a new iptables controller instance
-func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet) (*Instance, error) {
+// NewInstance creates a new iptables controller instance. The rules use the
+// default configuration if cfg is nil.
+func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet, cfg *Config) (*Instance, error) {
 
-	ipt, err := provider.NewGoIPTablesProvider()
-	if err != nil {
-		return nil, fmt.Errorf("unable to initialize iptables provider: %s", err)
+	config := cfg.withDefaults()
+
+	if config.ClampMSS < 0 || config.ClampMSS > 0xffff {
+		return nil, fmt.Errorf("invalid mss to clamp: %d", config.ClampMSS)
 	}
 
-	ips := provider.NewGoIPsetProvider()
+	ipt, ips, err := newProviders(config)
 	if err != nil {
-		return nil, fmt.Errorf("unable to initialize ipsets: %s", err)
+		return nil, err
+	}
+
+	if config.AuditLog != nil {
+		ipt = provider.NewAuditIptablesProvider(ipt, config.AuditLog)
+		ips = provider.NewAuditIpsetProvider(ips, config.AuditLog)
+	}
+
+	i := newInstance(fqc, mode, portset, ipt, ips)
+	i.audit = config.AuditLog
+	if config.DryRun {
+		// The routes of the transparent proxies are not programmed
+		i.ipCommand = func(args ...string) error { return nil }
+	}
+	i.warmRestart = config.WarmRestart
+	i.applyConfig(config)
+
+	return i, nil
+}
+
+// newProviders returns the iptables and the ipset providers of a configuration
+func newProviders(config Config) (provider.IptablesProvider, provider.IpsetProvider, error) {
+
+	if config.DryRun {
+		return provider.NewMemoryIPTablesProvider(), provider.NewMemoryIpsetProvider(), nil
 	}
 
-	i := &Instance{
+	var ipt provider.IptablesProvider
+	if config.NetlinkIptables {
+		var err error
+		if ipt, err = provider.NewNftIPTablesProvider(); err != nil {
+			zap.L().Warn("Unable to program iptables with netlink, using the iptables command", zap.Error(err))
+		}
+	}
+
+	if ipt == nil {
+		var err error
+		if ipt, err = provider.NewGoIPTablesProvider(); err != nil {
+			return nil, nil, fmt.Errorf("unable to initialize iptables provider: %s", err)
+		}
+	}
+
+	return ipt, provider.NewIpsetProvider(), nil
+}
+
+// newInstance returns an instance that programs the rules with the given
+// providers
+func newInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet, ipt provider.IptablesProvider, ips provider.IpsetProvider) *Instance {
+
+	return &Instance{
 		fqc:   fqc,
 		ipt:   ipt,
 		ipset: ips,
@@ -85,10 +162,43 @@ func NewInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset por
 		appCgroupIPTableSection: ipTableSectionOutput,
 		netPacketIPTableSection: ipTableSectionInput,
 		appSynAckIPTableSection: ipTableSectionOutput,
+		ipCommand:               runIP,
+		addresses:               newAddresses(),
+	}
+}
+
+// NewIpsetInstance creates a new iptables controller instance that matches the
+// ACLs of the PUs with ipsets. The chains of a PU hold a fixed number of rules
+// for the TCP and UDP ACLs, whatever the size of its policy.
+func NewIpsetInstance(fqc *fqconfig.FilterQueue, mode constants.ModeType, portset portset.PortSet, cfg *Config) (*Instance, error) {
+
+	i, err := NewInstance(fqc, mode, portset, cfg)
+	if err != nil {
+		return nil, err
 	}
 
+	i.aclSets = true
+
 	return i, nil
+}
+
+// Save returns the rules programmed by the instance in the format of
+// iptables-save. Only the dry run instances can return their rules.
+func (i *Instance) Save() (string, error) {
+
+	saver, ok := i.ipt.(provider.IptablesSaver)
+	if !ok {
+		return "", errors.New("the rules can only be saved by a dry run instance")
+	}
+
+	return saver.Save(), nil
+}
 
+// SetFailMode implements the Implementor interface. It must be called before
+// the rules are programmed.
+func (i *Instance) SetFailMode(mode constants.FailMode) {
+
+	i.failMode = mode
 }
 
 // chainPrefix returns the chain name for the specific PU
@@ -105,8 +215,8 @@ func (i *Instance) chainName(contextID string, version int) (app, net string, er
 		contextID = contextID + string(output[:6])
 	}
 
-	app = appChainPrefix + contextID + "-" + strconv.Itoa(version)
-	net = netChainPrefix + contextID + "-" + strconv.Itoa(version)
+	app = i.appChainPrefix + contextID + "-" + strconv.Itoa(version)
+	net = i.netChainPrefix + contextID + "-" + strconv.Itoa(version)
 
 	return app, net, nil
 }
@@ -130,8 +240,54 @@ func PuPortSetName(contextID string, mark string, prefix string) string {
 	return (prefix + contextID + mark)
 }
 
+// batchRules calls add with a copy of the instance whose provider collects
+// the rules, and commits them atomically. Nothing is programmed if add fails.
+// The rules are programmed one by one if the provider cannot restore them.
+func (i *Instance) batchRules(add func(*Instance) error) error {
+
+	restorer, ok := i.ipt.(provider.IptablesRestorer)
+	if !ok {
+		return add(i)
+	}
+
+	batch := provider.NewBatchProvider(i.ipt, restorer)
+
+	tx := *i
+	tx.ipt = batch
+	if err := add(&tx); err != nil {
+		return err
+	}
+
+	return batch.Commit()
+}
+
 // ConfigureRules implmenets the ConfigureRules interface
 func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	// The udp services are always proxied with TPROXY
+	if containerInfo.Runtime.Options().TransparentProxy || containerInfo.Policy.ProxiedServices().HasUDPServices() {
+		if err := i.addTransparentProxyRouting(); err != nil {
+			return err
+		}
+	}
+
+	if err := i.batchRules(func(tx *Instance) error {
+		return tx.configureRules(version, contextID, containerInfo)
+	}); err != nil {
+		return err
+	}
+
+	// The sets of the PU are not stale rules of a previous run
+	i.adoption.keep(i.puSets(contextID, containerInfo)...)
+
+	if i.mode != constants.LocalServer {
+		i.addresses.set(contextID, puIPAddresses(containerInfo))
+	}
+
+	return nil
+}
+
+func (i *Instance) configureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
 	policyrules := containerInfo.Policy
 
 	appChain, netChain, err := i.chainName(contextID, version)
@@ -139,7 +295,7 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 		return err
 	}
 
-	proxyPort := containerInfo.Runtime.Options().ProxyPort
+	proxyPort := i.puProxyPort(containerInfo)
 	zap.L().Debug("Configure rules", zap.String("proxyPort", proxyPort))
 	proxiedServices := containerInfo.Policy.ProxiedServices()
 
@@ -156,7 +312,7 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 			return fmt.Errorf("Failed to create ProxySet %s : %s", proxyPortSetName, err)
 		}
 
-		if err = i.addChainRules("", appChain, netChain, "", "", "", proxyPort, proxyPortSetName); err != nil {
+		if err = i.addChainRules("", appChain, netChain, "", "", "", proxyPort, proxyPortSetName, containerInfo.Runtime.Options().TransparentProxy, puIPAddresses(containerInfo)); err != nil {
 			return err
 		}
 
@@ -166,9 +322,9 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 			return errors.New("no mark value found")
 		}
 
-		port := policy.ConvertServicesToPortList(containerInfo.Runtime.Options().Services)
+		port := policy.ConvertServicesToProtocolPortList(containerInfo.Runtime.Options().Services)
 
-		uid := containerInfo.Runtime.Options().UserID
+		uid := puOwner(containerInfo)
 		if uid != "" {
 
 			// We are about to create a uid login pu
@@ -188,6 +344,21 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 				return err
 			}
 
+		} else if containerInfo.Runtime.Options().AutoPort {
+
+			// The ports of the PU are discovered from the listening sockets of its cgroup
+			portSetName := PuPortSetName(contextID, mark, PuPortSet)
+
+			if puseterr := i.createPUPortSet(portSetName); puseterr != nil {
+				return puseterr
+			}
+
+			if i.portSetInstance == nil {
+				return errors.New("enforcer portset instance cannot be nil for host")
+			}
+			if err = i.portSetInstance.AddCgroupPortSet(contextID, portSetName, mark); err != nil {
+				return err
+			}
 		}
 
 		portSetName := PuPortSetName(contextID, mark, PuPortSet)
@@ -198,16 +369,26 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 			return fmt.Errorf("Failed to create ProxySet %s : %s", proxyPortSetName, err)
 		}
 
-		if err := i.addChainRules(portSetName, appChain, netChain, port, mark, uid, proxyPort, proxyPortSetName); err != nil {
+		if err := i.addChainRules(portSetName, appChain, netChain, port, mark, uid, proxyPort, proxyPortSetName, containerInfo.Runtime.Options().TransparentProxy, nil); err != nil {
 
 			return err
 		}
+
+		if uid == "" && containerInfo.Runtime.Options().AutoPort {
+			if err := i.processRulesFromList(i.autoPortChainRules(portSetName, netChain), "Append"); err != nil {
+				return err
+			}
+		}
 	}
 
 	if err := i.addPacketTrap(appChain, netChain, containerInfo.Policy.TriremeNetworks()); err != nil {
 		return err
 	}
 
+	if err := i.createACLSets(appChain, netChain, policyrules); err != nil {
+		return err
+	}
+
 	if err := i.addAppACLs(contextID, appChain, policyrules.ApplicationACLs()); err != nil {
 		return err
 	}
@@ -228,10 +409,20 @@ func (i *Instance) DeleteRules(version int, contextID string, port string, mark
 		zap.L().Error("Count not generate chain name", zap.Error(err))
 	}
 	portSetName := PuPortSetName(contextID, mark, PuPortSet)
-	if derr := i.deleteChainRules(portSetName, appChain, netChain, port, mark, uid, proxyPort, proxyPortSetName); derr != nil {
+	if derr := i.deleteChainRules(portSetName, appChain, netChain, port, mark, uid, proxyPort, proxyPortSetName, i.addresses.remove(contextID)); derr != nil {
 		zap.L().Warn("Failed to clean rules", zap.Error(derr))
 	}
 
+	autoPort := false
+	if uid == "" && i.mode == constants.LocalServer && i.portSetInstance != nil {
+		if _, perr := i.portSetInstance.GetCgroupPortSet(contextID); perr == nil {
+			autoPort = true
+			if derr := i.processRulesFromList(i.autoPortChainRules(portSetName, netChain), "Delete"); derr != nil {
+				zap.L().Warn("Failed to clean port discovery rules", zap.Error(derr))
+			}
+		}
+	}
+
 	if err = i.deleteAllContainerChains(appChain, netChain); err != nil {
 		zap.L().Warn("Failed to clean container chains while deleting the rules", zap.Error(err))
 	}
@@ -239,10 +430,7 @@ func (i *Instance) DeleteRules(version int, contextID string, port string, mark
 
 		portSetName := PuPortSetName(contextID, mark, PuPortSet)
 
-		ips := ipset.IPSet{
-			Name: portSetName,
-		}
-		if err = ips.Destroy(); err != nil {
+		if err = i.namedIpset(portSetName).Destroy(); err != nil {
 			zap.L().Warn("Failed to clear puport set", zap.Error(err))
 		}
 
@@ -254,17 +442,22 @@ func (i *Instance) DeleteRules(version int, contextID string, port string, mark
 			return err
 		}
 	}
-	dstPortSetName, srcPortSetName := i.getSetNamePair(proxyPortSetName)
-	ips := ipset.IPSet{
-		Name: dstPortSetName,
+
+	if autoPort {
+
+		if err = i.namedIpset(portSetName).Destroy(); err != nil {
+			zap.L().Warn("Failed to clear puport set", zap.Error(err))
+		}
+
+		if err = i.portSetInstance.DelCgroupPortSet(contextID, mark); err != nil {
+			return err
+		}
 	}
-	if err := ips.Destroy(); err != nil {
+	dstPortSetName, srcPortSetName := i.getSetNamePair(proxyPortSetName)
+	if err := i.namedIpset(dstPortSetName).Destroy(); err != nil {
 		zap.L().Warn("Failed to destroy proxyPortSet", zap.String("SetName", proxyPortSetName), zap.Error(err))
 	}
-	ips = ipset.IPSet{
-		Name: srcPortSetName,
-	}
-	if err := ips.Destroy(); err != nil {
+	if err := i.namedIpset(srcPortSetName).Destroy(); err != nil {
 		zap.L().Warn("Failed to destroy proxyPortSet", zap.String("SetName", proxyPortSetName), zap.Error(err))
 	}
 	return nil
@@ -282,7 +475,12 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return errors.New("policy rules cannot be nil")
 	}
 
-	proxyPort := containerInfo.Runtime.Options().ProxyPort
+	proxyPort := i.puProxyPort(containerInfo)
+
+	// The rules of the previous version match the previous addresses of the
+	// container, which may have been attached to or detached from networks
+	ips := puIPAddresses(containerInfo)
+	oldIPs := i.addresses.get(contextID)
 
 	appChain, netChain, err := i.chainName(contextID, version)
 
@@ -296,75 +494,179 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return err
 	}
 
-	// Add a new chain for this update and map all rules there
-	if err := i.addContainerChain(appChain, netChain); err != nil {
-		return err
-	}
-
-	if err := i.addPacketTrap(appChain, netChain, containerInfo.Policy.TriremeNetworks()); err != nil {
-		return err
+	// The udp services are always proxied with TPROXY
+	if containerInfo.Runtime.Options().TransparentProxy || containerInfo.Policy.ProxiedServices().HasUDPServices() {
+		if err := i.addTransparentProxyRouting(); err != nil {
+			return err
+		}
 	}
 
-	if err := i.addAppACLs(contextID, appChain, policyrules.ApplicationACLs()); err != nil {
-		return err
-	}
+	// Add a new chain for this update and map all rules there
+	if err := i.batchRules(func(tx *Instance) error {
+		if err := tx.addContainerChain(appChain, netChain); err != nil {
+			return err
+		}
 
-	if err := i.addNetACLs(contextID, netChain, policyrules.NetworkACLs()); err != nil {
-		return err
-	}
+		if err := tx.addPacketTrap(appChain, netChain, containerInfo.Policy.TriremeNetworks()); err != nil {
+			return err
+		}
 
-	if err := i.addExclusionACLs(appChain, netChain, policyrules.ExcludedNetworks()); err != nil {
-		return err
-	}
+		if err := tx.createACLSets(appChain, netChain, policyrules); err != nil {
+			return err
+		}
 
-	// Add mapping to new chain
-	if i.mode != constants.LocalServer {
-		proxyPortSetName := PuPortSetName(contextID, "", proxyPortSet)
-		if err := i.addChainRules("", appChain, netChain, "", "", "", proxyPort, proxyPortSetName); err != nil {
+		if err := tx.addAppACLs(contextID, appChain, policyrules.ApplicationACLs()); err != nil {
 			return err
 		}
-	} else {
-		mark := containerInfo.Runtime.Options().CgroupMark
-		if mark == "" {
-			return errors.New("no mark value found")
+
+		if err := tx.addNetACLs(contextID, netChain, policyrules.NetworkACLs()); err != nil {
+			return err
 		}
-		portlist := policy.ConvertServicesToPortList(containerInfo.Runtime.Options().Services)
-		uid := containerInfo.Runtime.Options().UserID
 
-		portSetName := PuPortSetName(contextID, mark, PuPortSet)
-		proxyPortSetName := PuPortSetName(contextID, mark, proxyPortSet)
-		if err := i.addChainRules(portSetName, appChain, netChain, portlist, mark, uid, proxyPort, proxyPortSetName); err != nil {
+		if err := tx.addExclusionACLs(appChain, netChain, policyrules.ExcludedNetworks()); err != nil {
 			return err
 		}
 
+		// Add mapping to new chain
+		if tx.mode != constants.LocalServer {
+			proxyPortSetName := PuPortSetName(contextID, "", proxyPortSet)
+			if err := tx.addChainRules("", appChain, netChain, "", "", "", proxyPort, proxyPortSetName, containerInfo.Runtime.Options().TransparentProxy, ips); err != nil {
+				return err
+			}
+		} else {
+			mark := containerInfo.Runtime.Options().CgroupMark
+			if mark == "" {
+				return errors.New("no mark value found")
+			}
+			portlist := policy.ConvertServicesToProtocolPortList(containerInfo.Runtime.Options().Services)
+			uid := puOwner(containerInfo)
+
+			portSetName := PuPortSetName(contextID, mark, PuPortSet)
+			proxyPortSetName := PuPortSetName(contextID, mark, proxyPortSet)
+			if err := tx.addChainRules(portSetName, appChain, netChain, portlist, mark, uid, proxyPort, proxyPortSetName, containerInfo.Runtime.Options().TransparentProxy, nil); err != nil {
+				return err
+			}
+
+			if uid == "" && containerInfo.Runtime.Options().AutoPort {
+				if err := tx.processRulesFromList(tx.autoPortChainRules(portSetName, netChain), "Append"); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
 	// Remove mapping from old chain
 	if i.mode != constants.LocalServer {
 		proxyPortSetName := PuPortSetName(contextID, "", proxyPortSet)
-		if err := i.deleteChainRules("", oldAppChain, oldNetChain, "", "", "", proxyPort, proxyPortSetName); err != nil {
+		i.addresses.set(contextID, ips)
+		if err := i.deleteChainRules("", oldAppChain, oldNetChain, "", "", "", proxyPort, proxyPortSetName, oldIPs); err != nil {
 
 			return err
 		}
 	} else {
 		mark := containerInfo.Runtime.Options().CgroupMark
-		port := policy.ConvertServicesToPortList(containerInfo.Runtime.Options().Services)
-		uid := containerInfo.Runtime.Options().UserID
+		port := policy.ConvertServicesToProtocolPortList(containerInfo.Runtime.Options().Services)
+		uid := puOwner(containerInfo)
 
 		portSetName := PuPortSetName(contextID, mark, PuPortSet)
 		proxyPortSetName := PuPortSetName(contextID, mark, proxyPortSet)
-		if err := i.deleteChainRules(portSetName, oldAppChain, oldNetChain, port, mark, uid, proxyPort, proxyPortSetName); err != nil {
+		if err := i.deleteChainRules(portSetName, oldAppChain, oldNetChain, port, mark, uid, proxyPort, proxyPortSetName, nil); err != nil {
 			return err
 		}
 
+		if uid == "" && containerInfo.Runtime.Options().AutoPort {
+			if err := i.processRulesFromList(i.autoPortChainRules(portSetName, oldNetChain), "Delete"); err != nil {
+				return err
+			}
+		}
+
+	}
+
+	if err := i.updateProxyPorts(contextID, containerInfo); err != nil {
+		return err
+	}
+
+	// Delete the old chain to clean up
+	return i.deleteAllContainerChains(oldAppChain, oldNetChain)
+}
+
+// GetRules implements the GetRules interface. It returns the rules of the
+// chains of the given version and the members of the ipsets of the PU. The
+// ipsets that cannot be listed are not returned.
+func (i *Instance) GetRules(version int, contextID string, containerInfo *policy.PUInfo) (*policy.PURules, error) {
+
+	if containerInfo == nil || containerInfo.Runtime == nil {
+		return nil, errors.New("container info cannot be nil")
+	}
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &policy.PURules{
+		Chains: map[string][]string{},
+		IPSets: map[string][]string{},
+	}
+
+	for _, c := range []struct{ table, chain string }{
+		{i.appPacketIPTableContext, appChain},
+		{i.netPacketIPTableContext, netChain},
+	} {
+		list, err := i.ipt.List(c.table, c.chain)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list chain %s of table %s: %s", c.chain, c.table, err)
+		}
+		rules.Chains[c.chain] = list
+	}
+
+	setNames := i.puSets(contextID, containerInfo)
+	if i.aclSets {
+		setNames = append(setNames, i.aclSetNames(appChain, netChain)...)
+	}
+
+	for _, setName := range setNames {
+		members, err := i.listSet(setName)
+		if err != nil {
+			zap.L().Debug("Unable to list ipset", zap.String("set", setName), zap.Error(err))
+			continue
+		}
+		rules.IPSets[setName] = members
+	}
+
+	return rules, nil
+}
+
+// puProxyPort returns the port of the proxy of a PU
+func (i *Instance) puProxyPort(containerInfo *policy.PUInfo) string {
+
+	if port := containerInfo.Runtime.Options().ProxyPort; port != "" {
+		return port
 	}
-	// Update Proxy Ports
+
+	return i.proxyPort
+}
+
+// updateProxyPorts updates the proxy port set of a PU with its proxied services
+func (i *Instance) updateProxyPorts(contextID string, containerInfo *policy.PUInfo) error {
+
+	return i.UpdateProxiedServices(contextID, containerInfo, containerInfo.Policy.ProxiedServices())
+}
+
+// UpdateProxiedServices updates the proxy sets of a PU with proxied services
+// that replace the ones of its policy, like the services without the backends
+// that failed their health checks. The rules of the PU are not changed.
+func (i *Instance) UpdateProxiedServices(contextID string, containerInfo *policy.PUInfo, proxiedServiceList *policy.ProxiedServicesInfo) error {
+
 	mark := ""
 	if i.mode == constants.LocalServer {
 		mark = containerInfo.Runtime.Options().CgroupMark
 	}
 	proxyPortSetName := PuPortSetName(contextID, mark, proxyPortSet)
-	proxiedServiceList := containerInfo.Policy.ProxiedServices()
 	if err := i.updateProxySet(proxiedServiceList.PublicIPPortPair, proxiedServiceList.PrivateIPPortPair, proxyPortSetName); err != nil {
 		zap.L().Debug("Failed to update Proxy Set", zap.Error(err),
 			zap.Strings("Public ProxiedService List", proxiedServiceList.PublicIPPortPair),
@@ -373,13 +675,27 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return fmt.Errorf("Failed to update proxySet %s : %s", proxyPortSetName, err)
 	}
 
-	// Delete the old chain to clean up
-	return i.deleteAllContainerChains(oldAppChain, oldNetChain)
+	if proxiedServiceList.HasUDPServices() {
+		return i.addTransparentProxyRouting()
+	}
+
+	return nil
 }
 
-// Start starts the iptables controller
+// Start starts the iptables controller. The rules of a previous run are
+// adopted on a warm restart, and cleaned otherwise.
 func (i *Instance) Start() error {
 
+	if i.warmRestart {
+		adoption, err := i.discoverChains()
+		if err == nil {
+			i.adoption = adoption
+			zap.L().Debug("Started the iptables controller with the rules of the previous run", zap.Int("chains", len(adoption.chains)))
+			return nil
+		}
+		zap.L().Warn("Unable to adopt the rules of the previous run, cleaning them", zap.Error(err))
+	}
+
 	// Clean any previous ACLs
 	if err := i.cleanACLs(); err != nil {
 		zap.L().Warn("Unable to clean previous acls while starting the supervisor", zap.Error(err))
@@ -402,33 +718,43 @@ func (i *Instance) SetTargetNetworks(current, networks []string) error {
 		return i.updateTargetNetworks(current, networks)
 	}
 
+	if i.adoption != nil {
+		return i.adoptGlobalRules(networks)
+	}
+
 	// Create the target network set
 	if err := i.createTargetSet(networks); err != nil {
 		return err
 	}
 	if i.mode == constants.LocalServer {
-		if err := i.ipt.NewChain(i.appPacketIPTableContext, uidchain); err != nil {
-			zap.L().Error("Unable to create new chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", uidchain))
+		if err := i.ipt.NewChain(i.appPacketIPTableContext, i.uidChain); err != nil {
+			zap.L().Error("Unable to create new chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", i.uidChain))
 			return err
 		}
 	}
-	if err := i.ipt.NewChain(i.appProxyIPTableContext, natProxyInputChain); err != nil {
-		zap.L().Info("Unable to create New Chain", zap.String("TableContext", i.appProxyIPTableContext), zap.String("ChainName", natProxyInputChain))
+	if err := i.ipt.NewChain(i.appProxyIPTableContext, i.natProxyInputChain); err != nil {
+		zap.L().Info("Unable to create New Chain", zap.String("TableContext", i.appProxyIPTableContext), zap.String("ChainName", i.natProxyInputChain))
+	}
+	zap.L().Debug("Created NewChain ", zap.String("TableContext", i.appProxyIPTableContext), zap.String("ChainName", i.natProxyOutputChain))
+	if err := i.ipt.NewChain(i.appProxyIPTableContext, i.natProxyOutputChain); err != nil {
+		zap.L().Info("Unable to create New Chain", zap.String("TableContext", i.appProxyIPTableContext), zap.String("ChainName", i.natProxyOutputChain))
 	}
-	zap.L().Debug("Created NewChain ", zap.String("TableContext", i.appProxyIPTableContext), zap.String("ChainName", natProxyOutputChain))
-	if err := i.ipt.NewChain(i.appProxyIPTableContext, natProxyOutputChain); err != nil {
-		zap.L().Info("Unable to create New Chain", zap.String("TableContext", i.appProxyIPTableContext), zap.String("ChainName", natProxyOutputChain))
+	zap.L().Debug("Created NewChain ", zap.String("TableContext", i.appProxyIPTableContext), zap.String("ChainName", i.natProxyOutputChain))
+	if err := i.ipt.NewChain(i.appPacketIPTableContext, i.proxyOutputChain); err != nil {
+		zap.L().Error("Unable to create New Chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", i.proxyOutputChain))
 	}
-	zap.L().Debug("Created NewChain ", zap.String("TableContext", i.appProxyIPTableContext), zap.String("ChainName", natProxyOutputChain))
-	if err := i.ipt.NewChain(i.appPacketIPTableContext, proxyOutputChain); err != nil {
-		zap.L().Error("Unable to create New Chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", proxyOutputChain))
+	if err := i.ipt.NewChain(i.appPacketIPTableContext, i.proxyInputChain); err != nil {
+		zap.L().Error("Unable to create New Chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", i.proxyInputChain))
 	}
-	if err := i.ipt.NewChain(i.appPacketIPTableContext, proxyInputChain); err != nil {
-		zap.L().Error("Unable to create New Chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", proxyInputChain))
+	if err := i.ipt.NewChain(i.appPacketIPTableContext, i.tproxyInputChain); err != nil {
+		zap.L().Error("Unable to create New Chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", i.tproxyInputChain))
+	}
+	if err := i.ipt.NewChain(i.appPacketIPTableContext, i.tproxyOutputChain); err != nil {
+		zap.L().Error("Unable to create New Chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", i.tproxyOutputChain))
 	}
 	if i.mode == constants.LocalServer {
-		if err := i.ipt.Insert(i.appPacketIPTableContext, i.appPacketIPTableSection, 1, "-j", uidchain); err != nil {
-			zap.L().Error("Unable to Insert", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", uidchain))
+		if err := i.ipt.Insert(i.appPacketIPTableContext, i.appPacketIPTableSection, 1, "-j", i.uidChain); err != nil {
+			zap.L().Error("Unable to Insert", zap.String("TableContext", i.appPacketIPTableContext), zap.String("ChainName", i.uidChain))
 		}
 	}
 	// Insert the ACLS that point to the target networks
@@ -444,6 +770,11 @@ func (i *Instance) Stop() error {
 
 	zap.L().Debug("Stop the supervisor")
 
+	// The rules are adopted by the next run on a warm restart
+	if i.warmRestart {
+		return nil
+	}
+
 	// Clean any previous ACLs that we have installed
 	if err := i.cleanACLs(); err != nil {
 		zap.L().Error("Failed to clean acls while stopping the supervisor", zap.Error(err))
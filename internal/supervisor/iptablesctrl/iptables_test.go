@@ -2,6 +2,8 @@ package iptablesctrl
 
 import (
 	"errors"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/aporeto-inc/trireme-lib/constants"
@@ -9,13 +11,14 @@ import (
 	"github.com/aporeto-inc/trireme-lib/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
 	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/bvandewalle/go-ipset/ipset"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestNewInstance(t *testing.T) {
 	Convey("When I create a new iptables instance", t, func() {
 		Convey("If I create a remote implemenetation and iptables exists", func() {
-			i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+			i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 			Convey("It should succeed", func() {
 				So(i, ShouldNotBeNil)
 				So(err, ShouldBeNil)
@@ -26,9 +29,66 @@ func TestNewInstance(t *testing.T) {
 	})
 }
 
+func TestConfig(t *testing.T) {
+	Convey("When I create an instance without a configuration", t, func() {
+		i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		So(err, ShouldBeNil)
+
+		Convey("Then it should use the default names and values", func() {
+			app, net, err := i.chainName("Context", 1)
+			So(err, ShouldBeNil)
+			So(app, ShouldStartWith, "TRIREME-App-")
+			So(net, ShouldStartWith, "TRIREME-Net-")
+			So(i.uidChain, ShouldEqual, "UIDCHAIN")
+			So(i.targetNetworkSet, ShouldEqual, "TargetNetSet")
+			So(i.proxyMark, ShouldEqual, "0x40")
+			So(i.connMark, ShouldEqual, "61166")
+			So(i.appNFLOGGroup, ShouldEqual, "10")
+			So(i.netNFLOGGroup, ShouldEqual, "11")
+			So(i.nflogRate, ShouldEqual, DefaultNFLOGRate)
+			So(i.nflogBurst, ShouldEqual, "100")
+			So(i.nflogThreshold, ShouldEqual, "1")
+		})
+	})
+
+	Convey("When I create an instance with a configuration", t, func() {
+		i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), &Config{
+			ChainPrefix:   "OTHER-",
+			GlobalPrefix:  "O-",
+			ProxyMark:     "0x80",
+			AppNFLOGGroup: 20,
+			NFLOGRate:     UnlimitedNFLOGRate,
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Then it should use the configured names and values", func() {
+			app, net, err := i.chainName("Context", 1)
+			So(err, ShouldBeNil)
+			So(app, ShouldStartWith, "OTHER-App-")
+			So(net, ShouldStartWith, "OTHER-Net-")
+			So(i.uidChain, ShouldEqual, "O-UIDCHAIN")
+			So(i.natProxyInputChain, ShouldEqual, "O-RedirProxy-Net")
+			So(i.proxyMark, ShouldEqual, "0x80")
+			So(i.appNFLOGGroup, ShouldEqual, "20")
+			So(i.nflogRate, ShouldEqual, UnlimitedNFLOGRate)
+
+			for _, rule := range i.trapRules(app, net) {
+				So(rule, ShouldContain, "O-TargetNetSet")
+			}
+		})
+
+		Convey("Then the fields left empty should take the defaults", func() {
+			So(i.observeMark, ShouldEqual, DefaultObserveMark)
+			So(i.connMark, ShouldEqual, "61166")
+			So(i.netNFLOGGroup, ShouldEqual, "11")
+			So(i.proxyPort, ShouldEqual, ProxyPort)
+		})
+	})
+}
+
 func TestChainName(t *testing.T) {
 	Convey("When I test the creation of the name of the chain", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		Convey("With a contextID of Context and version of 1", func() {
 			app, net, err := i.chainName("Context", 1)
 			So(err, ShouldBeNil)
@@ -45,7 +105,7 @@ func TestChainName(t *testing.T) {
 
 func TestConfigureRules(t *testing.T) {
 	Convey("Given an iptables controllers", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -182,9 +242,55 @@ func TestConfigureRules(t *testing.T) {
 	})
 }
 
+type testRestoreProvider struct {
+	provider.TestIptablesProvider
+	inputs []string
+}
+
+func (p *testRestoreProvider) Restore(input string) error {
+	p.inputs = append(p.inputs, input)
+	return nil
+}
+
+func TestBatchRules(t *testing.T) {
+	Convey("Given an iptables controller with a provider that can restore rules", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := &testRestoreProvider{TestIptablesProvider: provider.NewTestIptablesProvider()}
+		i.ipt = iptables
+
+		appended := false
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			appended = true
+			return nil
+		})
+
+		Convey("When the rules are added successfully, they should be committed at once", func() {
+			err := i.batchRules(func(tx *Instance) error {
+				return tx.addContainerChain("TRIREME-App", "TRIREME-Net")
+			})
+			So(err, ShouldBeNil)
+			So(iptables.inputs, ShouldHaveLength, 1)
+			So(iptables.inputs[0], ShouldContainSubstring, ":TRIREME-App - [0:0]")
+			So(i.ipt, ShouldEqual, iptables)
+		})
+
+		Convey("When adding the rules fails, nothing should be programmed", func() {
+			err := i.batchRules(func(tx *Instance) error {
+				if err := tx.processRulesFromList(tx.trapRules("TRIREME-App", "TRIREME-Net"), "Append"); err != nil {
+					return err
+				}
+				return errors.New("failed")
+			})
+			So(err, ShouldNotBeNil)
+			So(iptables.inputs, ShouldBeEmpty)
+			So(appended, ShouldBeFalse)
+		})
+	})
+}
+
 func TestDeleteRules(t *testing.T) {
 	Convey("Given an iptables controllers", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -207,7 +313,7 @@ func TestDeleteRules(t *testing.T) {
 
 func TestUpdateRules(t *testing.T) {
 	Convey("Given an iptables controllers", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -263,7 +369,7 @@ func TestUpdateRules(t *testing.T) {
 			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
 
 				if chain == app1 || chain == net1 || chain == "RedirProxy-Net" || chain == "RedirProxy-App" ||
-					chain == "Proxy-Net" || chain == "Proxy-App" {
+					chain == "Proxy-Net" || chain == "Proxy-App" || chain == "Tproxy-Net" || chain == "Tproxy-App" {
 					return nil
 				}
 				if matchSpec(app1, rulespec) == nil || matchSpec(net1, rulespec) == nil {
@@ -309,9 +415,615 @@ func TestUpdateRules(t *testing.T) {
 	})
 }
 
+func TestContainerAddresses(t *testing.T) {
+	Convey("Given an iptables controller and a container attached to two networks", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		jumps := func(rules map[string][]string) []string {
+			joined := []string{}
+			for _, chain := range []string{i.appPacketIPTableSection, i.netPacketIPTableSection} {
+				joined = append(joined, rules[chain]...)
+			}
+			return joined
+		}
+
+		appended := map[string][]string{}
+		deleted := map[string][]string{}
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			appended[chain] = append(appended[chain], strings.Join(rulespec, " "))
+			return nil
+		})
+		iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+			deleted[chain] = append(deleted[chain], strings.Join(rulespec, " "))
+			return nil
+		})
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			return nil
+		})
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			return nil
+		})
+		iptables.MockClearChain(t, func(table string, chain string) error {
+			return nil
+		})
+		iptables.MockDeleteChain(t, func(table string, chain string) error {
+			return nil
+		})
+
+		containerInfo := func(ips policy.ExtendedMap) *policy.PUInfo {
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, nil, nil, nil, nil, nil, nil, ips, []string{"172.17.0.0/24"}, []string{}, &policy.ProxiedServicesInfo{})
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+			return containerinfo
+		}
+
+		app1, net1, _ := i.chainName("Context", 1)
+		app0, net0, _ := i.chainName("Context", 0)
+
+		So(i.UpdateRules(1, "Context", containerInfo(policy.ExtendedMap{
+			policy.DefaultNamespace: "172.17.0.2",
+			"backend":               "10.0.1.2",
+			"frontend":              "172.17.0.2",
+			"host":                  "",
+		}), nil), ShouldBeNil)
+
+		Convey("Then the traffic of each address should be sent to the chains of the container", func() {
+			So(jumps(appended), ShouldResemble, []string{
+				"-s 10.0.1.2 -m comment --comment Container-specific-chain -j " + app1,
+				"-s 172.17.0.2 -m comment --comment Container-specific-chain -j " + app1,
+				"-d 10.0.1.2 -m comment --comment Container-specific-chain -j " + net1,
+				"-d 172.17.0.2 -m comment --comment Container-specific-chain -j " + net1,
+			})
+			So(i.addresses.get("Context"), ShouldResemble, []string{"10.0.1.2", "172.17.0.2"})
+		})
+
+		Convey("Then the rules of the previous addresses should be deleted when the container leaves a network", func() {
+			deleted = map[string][]string{}
+			So(i.UpdateRules(0, "Context", containerInfo(policy.ExtendedMap{
+				policy.DefaultNamespace: "172.17.0.2",
+			}), nil), ShouldBeNil)
+
+			So(jumps(deleted), ShouldResemble, []string{
+				"-s 10.0.1.2 -m comment --comment Container-specific-chain -j " + app1,
+				"-s 172.17.0.2 -m comment --comment Container-specific-chain -j " + app1,
+				"-d 10.0.1.2 -m comment --comment Container-specific-chain -j " + net1,
+				"-d 172.17.0.2 -m comment --comment Container-specific-chain -j " + net1,
+			})
+
+			deleted = map[string][]string{}
+			So(i.DeleteRules(0, "Context", "", "", "", "5000", PuPortSetName("Context", "", proxyPortSet)), ShouldBeNil)
+			So(jumps(deleted), ShouldResemble, []string{
+				"-s 172.17.0.2 -m comment --comment Container-specific-chain -j " + app0,
+				"-d 172.17.0.2 -m comment --comment Container-specific-chain -j " + net0,
+			})
+			So(i.addresses.get("Context"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestUpdateRulesInPlace(t *testing.T) {
+	Convey("Given an iptables controller and a PU", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		app, net, err := i.chainName("Context", 1)
+		So(err, ShouldBeNil)
+
+		inserts := map[string][][]string{}
+		deletes := map[string][]string{}
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			inserts[chain] = append(inserts[chain], rulespec)
+			return nil
+		})
+		iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+			deletes[chain] = append(deletes[chain], rulespec...)
+			return nil
+		})
+
+		rules := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject},
+			},
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "443",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		puInfo := func(appACLs policy.IPRuleList) *policy.PUInfo {
+			ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, appACLs, rules, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{}, &policy.ProxiedServicesInfo{})
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+			return containerinfo
+		}
+
+		Convey("When the policy does not change, nothing should be programmed", func() {
+			updated, err := i.UpdateRulesInPlace(1, "Context", puInfo(rules), puInfo(rules))
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeTrue)
+			So(inserts, ShouldBeEmpty)
+			So(deletes, ShouldBeEmpty)
+		})
+
+		Convey("When an application ACL is added, only its rule should be inserted", func() {
+			newRules := append(policy.IPRuleList{}, rules...)
+			newRules = append(newRules, policy.IPRule{
+				Address:  "10.1.1.0/24",
+				Port:     "8080",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			})
+
+			updated, err := i.UpdateRulesInPlace(1, "Context", puInfo(newRules), puInfo(rules))
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeTrue)
+			So(inserts, ShouldHaveLength, 1)
+			So(inserts[app], ShouldHaveLength, 1)
+			So(inserts[app][0], ShouldContain, "10.1.1.0/24")
+			So(deletes, ShouldBeEmpty)
+		})
+
+		Convey("When an application ACL is removed, only its rule should be deleted", func() {
+			updated, err := i.UpdateRulesInPlace(1, "Context", puInfo(rules[:1]), puInfo(rules))
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeTrue)
+			So(inserts, ShouldBeEmpty)
+			So(deletes, ShouldHaveLength, 1)
+			So(deletes[app], ShouldHaveLength, 1)
+			So(deletes[net], ShouldBeNil)
+		})
+
+		Convey("When many ACLs change, the rules should not be updated in place", func() {
+			newRules := policy.IPRuleList{}
+			for port := 1000; port < 1000+maxIncrementalRuleChanges; port++ {
+				newRules = append(newRules, policy.IPRule{
+					Address:  "10.1.1.0/24",
+					Port:     strconv.Itoa(port),
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Accept},
+				})
+			}
+
+			updated, err := i.UpdateRulesInPlace(1, "Context", puInfo(newRules), puInfo(rules))
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeFalse)
+			So(inserts, ShouldBeEmpty)
+			So(deletes, ShouldBeEmpty)
+		})
+
+		Convey("When there is no old policy, the rules should not be updated in place", func() {
+			updated, err := i.UpdateRulesInPlace(1, "Context", puInfo(rules), nil)
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeFalse)
+		})
+
+		Convey("When the container is attached to another network, the rules should not be updated in place", func() {
+			newInfo := puInfo(rules)
+			newInfo.Policy.SetIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1", "backend": "10.0.1.2"})
+
+			updated, err := i.UpdateRulesInPlace(1, "Context", newInfo, puInfo(rules))
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeFalse)
+			So(inserts, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestGetRules(t *testing.T) {
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		app, net, err := i.chainName("Context", 1)
+		So(err, ShouldBeNil)
+
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		Convey("When the chains exist, I should get their rules", func() {
+			iptables.MockList(t, func(table string, chain string) ([]string, error) {
+				return []string{"-N " + chain, "-A " + chain + " -j DROP"}, nil
+			})
+
+			rules, err := i.GetRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(rules.Chains, ShouldHaveLength, 2)
+			So(rules.Chains[app], ShouldResemble, []string{"-N " + app, "-A " + app + " -j DROP"})
+			So(rules.Chains[net], ShouldResemble, []string{"-N " + net, "-A " + net + " -j DROP"})
+		})
+
+		Convey("When a chain cannot be listed, I should get an error", func() {
+			iptables.MockList(t, func(table string, chain string) ([]string, error) {
+				return nil, errors.New("error")
+			})
+
+			_, err := i.GetRules(1, "Context", containerinfo)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When the container info is nil, I should get an error", func() {
+			_, err := i.GetRules(1, "Context", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReconcileRules(t *testing.T) {
+	Convey("Given an iptables controller and a PU", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		app, net, err := i.chainName("Context", 1)
+		So(err, ShouldBeNil)
+
+		cleared := []string{}
+		appends := map[string]int{}
+		iptables.MockClearChain(t, func(table string, chain string) error {
+			cleared = append(cleared, chain)
+			return nil
+		})
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			appends[chain]++
+			return nil
+		})
+
+		ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, nil, nil, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{}, &policy.ProxiedServicesInfo{})
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		expected, err := i.recordPolicyRules("Context", app, net, containerinfo)
+		So(err, ShouldBeNil)
+
+		Convey("When all the rules exist, nothing should be programmed", func() {
+			drift, err := i.ReconcileRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeFalse)
+			So(cleared, ShouldBeEmpty)
+			So(appends, ShouldBeEmpty)
+		})
+
+		Convey("When a rule of the application chain is missing, only that chain should be programmed again", func() {
+			iptables.MockExists(t, func(table string, chain string, rulespec ...string) (bool, error) {
+				return chain != app, nil
+			})
+
+			drift, err := i.ReconcileRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeTrue)
+			So(cleared, ShouldResemble, []string{app})
+			So(appends, ShouldHaveLength, 1)
+			So(appends[app], ShouldEqual, len(expected.chains[chainKey{table: i.appPacketIPTableContext, chain: app}]))
+		})
+
+		Convey("When a redirection rule is missing, it should be appended again", func() {
+			iptables.MockExists(t, func(table string, chain string, rulespec ...string) (bool, error) {
+				return chain == app || chain == net, nil
+			})
+
+			drift, err := i.ReconcileRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeTrue)
+			So(cleared, ShouldBeEmpty)
+			So(appends, ShouldNotBeEmpty)
+			So(appends[app], ShouldEqual, 0)
+			So(appends[net], ShouldEqual, 0)
+		})
+
+		Convey("When the rules cannot be checked, I should get an error", func() {
+			iptables.MockExists(t, func(table string, chain string, rulespec ...string) (bool, error) {
+				return false, errors.New("error")
+			})
+
+			_, err := i.ReconcileRules(1, "Context", containerinfo)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReconcileGlobalRules(t *testing.T) {
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		inserts := []int{}
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			inserts = append(inserts, pos)
+			return nil
+		})
+
+		Convey("When all the rules exist, nothing should be programmed", func() {
+			drift, err := i.ReconcileGlobalRules()
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeFalse)
+			So(inserts, ShouldBeEmpty)
+		})
+
+		Convey("When the global rules were flushed, they should be inserted again", func() {
+			iptables.MockExists(t, func(table string, chain string, rulespec ...string) (bool, error) {
+				return false, nil
+			})
+			iptables.MockListChains(t, func(table string) ([]string, error) {
+				return []string{}, nil
+			})
+
+			drift, err := i.ReconcileGlobalRules()
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeTrue)
+			So(inserts, ShouldNotBeEmpty)
+		})
+	})
+}
+
+func TestFailMode(t *testing.T) {
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+
+		Convey("When it fails closed, the packets should not bypass the queues", func() {
+			for _, rule := range i.trapRules("app", "net") {
+				So(rule, ShouldContain, "NFQUEUE")
+				So(rule, ShouldNotContain, "--queue-bypass")
+			}
+		})
+
+		Convey("When it fails open, the packets should bypass the queues", func() {
+			i.SetFailMode(constants.FailOpen)
+			for _, rule := range i.trapRules("app", "net") {
+				So(rule, ShouldContain, "--queue-bypass")
+			}
+		})
+	})
+}
+
+func TestUDPEnforcement(t *testing.T) {
+	Convey("Given an iptables controller with the defaults", t, func() {
+		i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		So(err, ShouldBeNil)
+
+		Convey("The udp datagrams should not be trapped", func() {
+			for _, rule := range i.trapRules("app", "net") {
+				So(rule, ShouldNotContain, "udp")
+			}
+		})
+	})
+
+	Convey("Given an iptables controller that enforces the udp flows", t, func() {
+		i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), &Config{UDPEnforcement: true})
+		So(err, ShouldBeNil)
+
+		Convey("The udp datagrams should be trapped in both directions", func() {
+			udp := 0
+			for _, rule := range i.trapRules("app", "net") {
+				for _, arg := range rule {
+					if arg == "udp" {
+						udp++
+					}
+				}
+			}
+			So(udp, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestClampMSS(t *testing.T) {
+	Convey("Given an iptables controller that clamps the MSS", t, func() {
+		fqc := fqconfig.NewFilterQueueWithDefaults()
+		i, err := programGlobalRules(fqc, &Config{ClampMSS: 1400})
+		So(err, ShouldBeNil)
+
+		Convey("Then the MSS should be clamped before the packets are sent to the queues", func() {
+			app, err := i.ipt.List(i.appPacketIPTableContext, i.appPacketIPTableSection)
+			So(err, ShouldBeNil)
+			So(app[1], ShouldContainSubstring, "--match-set TargetNetSet dst -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1400")
+
+			net, err := i.ipt.List(i.netPacketIPTableContext, i.netPacketIPTableSection)
+			So(err, ShouldBeNil)
+			So(net[1], ShouldContainSubstring, "--match-set TargetNetSet src -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1400")
+		})
+
+		Convey("Then the global rules should not drift", func() {
+			drift, err := i.ReconcileGlobalRules()
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeFalse)
+		})
+
+		Convey("When I clean the global rules, the clamping rules should be removed", func() {
+			i.ipset = provider.NewMemoryIpsetProvider()
+			So(i.CleanGlobalRules(), ShouldBeNil)
+
+			rules, err := i.Save()
+			So(err, ShouldBeNil)
+			So(rules, ShouldNotContainSubstring, "TCPMSS")
+		})
+	})
+
+	Convey("Given an iptables controller that does not clamp the MSS", t, func() {
+		i, err := programGlobalRules(fqconfig.NewFilterQueueWithDefaults(), nil)
+		So(err, ShouldBeNil)
+
+		Convey("Then there should be no clamping rules", func() {
+			rules, err := i.Save()
+			So(err, ShouldBeNil)
+			So(rules, ShouldNotContainSubstring, "TCPMSS")
+		})
+	})
+
+	Convey("When I create an iptables controller with an invalid MSS, it should fail", t, func() {
+		_, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), &Config{ClampMSS: 70000})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestACLSets(t *testing.T) {
+	Convey("Given an ipset controller and a PU", t, func() {
+		i, err := NewIpsetInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		So(err, ShouldBeNil)
+
+		ips := provider.NewTestIpsetProvider()
+		i.ipset = ips
+
+		sets := map[string][]string{}
+		ips.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+			So(hasht, ShouldEqual, "hash:net,port")
+			set := provider.NewTestIpset()
+			set.MockFlush(t, func() error {
+				sets[name] = []string{}
+				return nil
+			})
+			set.MockAdd(t, func(entry string, timeout int) error {
+				sets[name] = append(sets[name], entry)
+				return nil
+			})
+			return set, nil
+		})
+
+		app, net, err := i.chainName("Context", 1)
+		So(err, ShouldBeNil)
+
+		appACLs := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject},
+			},
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "1000:2000",
+				Protocol: "UDP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+			policy.IPRule{
+				Address:  "10.1.1.0/24",
+				Port:     "443",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept | policy.Log},
+			},
+		}
+		netACLs := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "0.0.0.0/0",
+				Port:     "22",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Accept},
+			},
+		}
+
+		ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, appACLs, netACLs, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{}, &policy.ProxiedServicesInfo{})
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		Convey("When I create the ACL sets, they should hold the plain TCP and UDP ACLs", func() {
+			So(i.createACLSets(app, net, containerinfo.Policy), ShouldBeNil)
+			So(sets, ShouldResemble, map[string][]string{
+				aclSetName(app, aclSetAccept): {"192.30.253.0/24,udp:1000-2000"},
+				aclSetName(app, aclSetReject): {"192.30.253.0/24,tcp:80"},
+				aclSetName(net, aclSetAccept): {},
+				aclSetName(net, aclSetReject): {},
+			})
+			for name := range sets {
+				So(len(name), ShouldBeLessThanOrEqualTo, 31)
+			}
+		})
+
+		Convey("When I program the chains, the ACLs in the sets should be matched with the sets", func() {
+			recorder, err := i.recordPolicyRules("Context", app, net, containerinfo)
+			So(err, ShouldBeNil)
+
+			appRules := []string{}
+			for _, rule := range recorder.chains[chainKey{i.appPacketIPTableContext, app}] {
+				appRules = append(appRules, strings.Join(rule, " "))
+			}
+			So(appRules, ShouldContain, "-m set --match-set "+aclSetName(app, aclSetReject)+" dst,dst -m state --state NEW -j DROP")
+			So(appRules, ShouldContain, "-m set --match-set "+aclSetName(app, aclSetAccept)+" dst,dst -m state --state NEW -j ACCEPT")
+			So(appRules, ShouldContain, "-p TCP -m state --state NEW -d 10.1.1.0/24 --dport 443 -j ACCEPT")
+			So(appRules, ShouldNotContain, "-p TCP -m state --state NEW -d 192.30.253.0/24 --dport 80 -j DROP")
+
+			netRules := []string{}
+			for _, rule := range recorder.chains[chainKey{i.netPacketIPTableContext, net}] {
+				netRules = append(netRules, strings.Join(rule, " "))
+			}
+			So(netRules, ShouldContain, "-m set --match-set "+aclSetName(net, aclSetAccept)+" src,dst -m state --state NEW -j ACCEPT")
+		})
+
+		Convey("When I update the rules, they should not be updated in place", func() {
+			updated, err := i.UpdateRulesInPlace(1, "Context", containerinfo, containerinfo)
+			So(err, ShouldBeNil)
+			So(updated, ShouldBeFalse)
+		})
+	})
+}
+
+func TestApplyRuleEdits(t *testing.T) {
+	Convey("Given an iptables controller with a chain", t, func() {
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		chain := []string{}
+		iptables.MockInsert(t, func(table string, c string, pos int, rulespec ...string) error {
+			chain = append(chain, "")
+			copy(chain[pos:], chain[pos-1:])
+			chain[pos-1] = rulespec[0]
+			return nil
+		})
+		iptables.MockDelete(t, func(table string, c string, rulespec ...string) error {
+			pos, err := strconv.Atoi(rulespec[0])
+			if err != nil {
+				return err
+			}
+			chain = append(chain[:pos-1], chain[pos:]...)
+			return nil
+		})
+
+		toRules := func(names ...string) [][]string {
+			rules := [][]string{}
+			for _, name := range names {
+				rules = append(rules, []string{name})
+			}
+			return rules
+		}
+
+		for _, test := range []struct {
+			old []string
+			new []string
+		}{
+			{[]string{"a", "b", "c"}, []string{"a", "b", "c"}},
+			{[]string{"a", "b", "c"}, []string{"a", "x", "c"}},
+			{[]string{"a", "b", "a", "c"}, []string{"b", "a", "a", "d", "c"}},
+			{[]string{}, []string{"a", "b"}},
+			{[]string{"a", "b"}, []string{}},
+		} {
+			Convey("When I apply the edits from "+strings.Join(test.old, "")+" to "+strings.Join(test.new, ""), func() {
+				chain = append([]string{}, test.old...)
+				edits, ok := diffRules(toRules(test.old...), toRules(test.new...))
+				So(ok, ShouldBeTrue)
+				So(i.applyRuleEdits("filter", "chain", edits), ShouldBeNil)
+
+				Convey("Then the chain should have the new rules", func() {
+					So(chain, ShouldResemble, test.new)
+				})
+			})
+		}
+	})
+}
+
 func TestStart(t *testing.T) {
 	Convey("Given an iptables controllers,", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -338,7 +1050,7 @@ func TestStart(t *testing.T) {
 
 func TestStop(t *testing.T) {
 	Convey("Given an iptables controller", t, func() {
-		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil))
+		i, _ := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
 		iptables := provider.NewTestIptablesProvider()
 		i.ipt = iptables
 
@@ -348,3 +1060,183 @@ func TestStop(t *testing.T) {
 		})
 	})
 }
+
+func TestDryRun(t *testing.T) {
+	Convey("Given a dry run ipset controller for the host", t, func() {
+		i, err := NewIpsetInstance(fqconfig.NewFilterQueueWithDefaults(), constants.LocalServer, portset.New(nil), &Config{DryRun: true})
+		So(err, ShouldBeNil)
+		So(i.Start(), ShouldBeNil)
+		So(i.SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}), ShouldBeNil)
+
+		appACLs := policy.IPRuleList{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Policy:   &policy.FlowPolicy{Action: policy.Reject},
+			},
+		}
+
+		ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+		containerinfo := policy.NewPUInfo("Context", constants.LinuxProcessPU)
+		containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, appACLs, policy.IPRuleList{}, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{}, &policy.ProxiedServicesInfo{})
+		containerinfo.Runtime = policy.NewPURuntime("", 0, "", nil, nil, constants.LinuxProcessPU, &policy.OptionsType{
+			CgroupMark: "100",
+			UserID:     "1000",
+			ProxyPort:  "5000",
+		})
+
+		app, _, err := i.chainName("Context", 1)
+		So(err, ShouldBeNil)
+
+		Convey("When I configure the rules of a PU, they should be saved with its sets", func() {
+			So(i.ConfigureRules(1, "Context", containerinfo), ShouldBeNil)
+
+			saved, err := i.Save()
+			So(err, ShouldBeNil)
+			So(saved, ShouldContainSubstring, ":"+app+" - [0:0]\n")
+			So(saved, ShouldContainSubstring, "-A UIDCHAIN ")
+
+			rules, err := i.GetRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(rules.IPSets[aclSetName(app, aclSetReject)], ShouldResemble, []string{"192.30.253.0/24,tcp:80"})
+			So(rules.IPSets, ShouldContainKey, PuPortSetName("Context", "100", PuPortSet))
+
+			Convey("When I delete them, they should not be saved anymore", func() {
+				So(i.DeleteRules(1, "Context", "0", "100", "1000", "5000", PuPortSetName("Context", "100", proxyPortSet)), ShouldBeNil)
+
+				saved, err := i.Save()
+				So(err, ShouldBeNil)
+				So(saved, ShouldNotContainSubstring, app)
+
+				named := i.ipset.(provider.NamedIpsetProvider)
+				_, err = named.ListIpset(PuPortSetName("Context", "100", PuPortSet))
+				So(err, ShouldNotBeNil)
+				_, err = named.ListIpset(aclSetName(app, aclSetReject))
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a controller that programs the rules", t, func() {
+		i, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), nil)
+		So(err, ShouldBeNil)
+
+		Convey("When I save the rules, I should get an error", func() {
+			_, err := i.Save()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// warmInstance returns an instance that adopts the rules programmed by an
+// instance with the same providers
+func warmInstance(prev *Instance) *Instance {
+
+	i := newInstance(prev.fqc, prev.mode, prev.portSetInstance, prev.ipt, prev.ipset)
+	i.warmRestart = true
+	i.applyConfig((&Config{}).withDefaults())
+
+	return i
+}
+
+func TestWarmRestart(t *testing.T) {
+	Convey("Given a dry run controller with a warm restart", t, func() {
+		prev, err := NewInstance(fqconfig.NewFilterQueueWithDefaults(), constants.RemoteContainer, portset.New(nil), &Config{DryRun: true, WarmRestart: true})
+		So(err, ShouldBeNil)
+		So(prev.Start(), ShouldBeNil)
+		So(prev.SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}), ShouldBeNil)
+
+		puInfo := func(port string) *policy.PUInfo {
+			appACLs := policy.IPRuleList{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     port,
+					Protocol: "TCP",
+					Policy:   &policy.FlowPolicy{Action: policy.Reject},
+				},
+			}
+
+			ipl := policy.ExtendedMap{policy.DefaultNamespace: "172.17.0.1"}
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policy.NewPUPolicy("Context", policy.Police, appACLs, policy.IPRuleList{}, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{}, &policy.ProxiedServicesInfo{})
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			return containerinfo
+		}
+
+		So(prev.ConfigureRules(0, "Context", puInfo("80")), ShouldBeNil)
+		So(prev.ConfigureRules(0, "Stale", puInfo("80")), ShouldBeNil)
+		So(prev.Stop(), ShouldBeNil)
+
+		saved, err := prev.Save()
+		So(err, ShouldBeNil)
+
+		contextApp, _, err := prev.chainName("Context", 0)
+		So(err, ShouldBeNil)
+		contextNext, _, err := prev.chainName("Context", 1)
+		So(err, ShouldBeNil)
+		staleApp, staleNet, err := prev.chainName("Stale", 0)
+		So(err, ShouldBeNil)
+		staleProxySet, _ := prev.getSetNamePair(PuPortSetName("Stale", "", proxyPortSet))
+
+		Convey("The rules should be kept when it stops", func() {
+			So(saved, ShouldContainSubstring, ":"+contextApp+" - [0:0]\n")
+			So(saved, ShouldContainSubstring, ":"+staleApp+" - [0:0]\n")
+		})
+
+		Convey("When the next instance starts, the rules should not change", func() {
+			i := warmInstance(prev)
+			So(i.Start(), ShouldBeNil)
+			So(i.SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}), ShouldBeNil)
+
+			restarted, err := i.Save()
+			So(err, ShouldBeNil)
+			So(restarted, ShouldEqual, saved)
+
+			Convey("When it adopts a PU with the same policy, its rules should not change", func() {
+				version, adopted, err := i.AdoptRules("Context", puInfo("80"))
+				So(err, ShouldBeNil)
+				So(adopted, ShouldBeTrue)
+				So(version, ShouldEqual, 0)
+
+				adoptedRules, err := i.Save()
+				So(err, ShouldBeNil)
+				So(adoptedRules, ShouldEqual, saved)
+
+				Convey("When it cleans the stale rules, the rules of the other PUs should be removed", func() {
+					So(i.CleanStaleRules(), ShouldBeNil)
+
+					cleaned, err := i.Save()
+					So(err, ShouldBeNil)
+					So(cleaned, ShouldContainSubstring, ":"+contextApp+" - [0:0]\n")
+					So(cleaned, ShouldNotContainSubstring, staleApp)
+					So(cleaned, ShouldNotContainSubstring, staleNet)
+					So(cleaned, ShouldNotContainSubstring, staleProxySet)
+
+					_, err = i.ipset.(provider.NamedIpsetProvider).ListIpset(staleProxySet)
+					So(err, ShouldNotBeNil)
+				})
+			})
+
+			Convey("When it adopts a PU with another policy, its rules should be programmed in the other version", func() {
+				version, adopted, err := i.AdoptRules("Context", puInfo("443"))
+				So(err, ShouldBeNil)
+				So(adopted, ShouldBeTrue)
+				So(version, ShouldEqual, 1)
+
+				updated, err := i.Save()
+				So(err, ShouldBeNil)
+				So(updated, ShouldContainSubstring, ":"+contextNext+" - [0:0]\n")
+				So(updated, ShouldNotContainSubstring, contextApp)
+				So(updated, ShouldContainSubstring, "--dport 443")
+			})
+
+			Convey("When it adopts a PU that was not programmed, it should not be adopted", func() {
+				_, adopted, err := i.AdoptRules("Other", puInfo("80"))
+				So(err, ShouldBeNil)
+				So(adopted, ShouldBeFalse)
+			})
+		})
+	})
+}
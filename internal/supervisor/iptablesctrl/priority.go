@@ -0,0 +1,120 @@
+package iptablesctrl
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// groupProvider collects the rules added to a chain, so that the rules
+// inserted at the top of the chain can be appended before the other ones
+// after the rules that are already in the chain. The rules of other chains are
+// added directly.
+type groupProvider struct {
+	provider.IptablesProvider
+	table    string
+	chain    string
+	inserted [][]string
+	appended [][]string
+}
+
+func newGroupProvider(ipt provider.IptablesProvider, table, chain string) *groupProvider {
+
+	return &groupProvider{
+		IptablesProvider: ipt,
+		table:            table,
+		chain:            chain,
+		inserted:         [][]string{},
+		appended:         [][]string{},
+	}
+}
+
+// Append collects a rule appended to the chain
+func (g *groupProvider) Append(table, chain string, rulespec ...string) error {
+
+	if table != g.table || chain != g.chain {
+		return g.IptablesProvider.Append(table, chain, rulespec...)
+	}
+
+	g.appended = append(g.appended, rulespec)
+
+	return nil
+}
+
+// Insert collects a rule inserted at the top of the chain
+func (g *groupProvider) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	if table != g.table || chain != g.chain || pos != 1 {
+		return g.IptablesProvider.Insert(table, chain, pos, rulespec...)
+	}
+
+	g.inserted = append([][]string{rulespec}, g.inserted...)
+
+	return nil
+}
+
+// flush appends the collected rules to the chain, the inserted ones first
+func (g *groupProvider) flush() error {
+
+	for _, rulespec := range append(g.inserted, g.appended...) {
+		if err := g.IptablesProvider.Append(g.table, g.chain, rulespec...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withProvider returns a copy of the instance that uses the given provider
+func (i *Instance) withProvider(ipt provider.IptablesProvider) *Instance {
+
+	tx := *i
+	tx.ipt = ipt
+
+	return &tx
+}
+
+// aclPriorities returns the priorities of the ACLs in increasing order. The
+// default priority is always present, since the ACL sets are added with it.
+func aclPriorities(rules policy.IPRuleList) []int {
+
+	priorities := rules.Priorities()
+
+	for _, priority := range priorities {
+		if priority == 0 {
+			return priorities
+		}
+	}
+
+	return append(policy.IPRuleList{{}}, rules...).Priorities()
+}
+
+// addPriorityACLs calls add with the ACLs of every priority in increasing
+// order. The ACLs of a priority are all added after the ones of the previous
+// priorities. The ACL sets are added with the ACLs of the default priority.
+func (i *Instance) addPriorityACLs(table, chain string, rules policy.IPRuleList, add func(*Instance, policy.IPRuleList, bool) error) error {
+
+	for idx, priority := range aclPriorities(rules) {
+
+		// The ACLs of the first priority are added to the chain as they are
+		if idx == 0 {
+			if err := add(i, rules.WithPriority(priority), priority == 0 && i.aclSets); err != nil {
+				return err
+			}
+			continue
+		}
+
+		group := newGroupProvider(i.ipt, table, chain)
+
+		if err := add(i.withProvider(group), rules.WithPriority(priority), priority == 0 && i.aclSets); err != nil {
+			return err
+		}
+
+		if err := group.flush(); err != nil {
+			return fmt.Errorf("unable to add acl rules for table %s, chain %s: %s", table, chain, err)
+		}
+	}
+
+	return nil
+}
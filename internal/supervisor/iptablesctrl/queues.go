@@ -0,0 +1,80 @@
+package iptablesctrl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+	"go.uber.org/zap"
+)
+
+// recordGlobalRules returns the global rules programmed with a filter queue
+// configuration, in the order of their chains
+func (i *Instance) recordGlobalRules(fqc *fqconfig.FilterQueue) (*chainRecorder, error) {
+
+	recorder := newChainRecorder()
+
+	tx := *i
+	tx.ipt = recorder
+	tx.fqc = fqc
+
+	if err := tx.setGlobalRules(i.appPacketIPTableSection, i.netPacketIPTableSection); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// UpdateFilterQueue implements the FilterQueueUpdater interface of the
+// supervisor. The global rules that send packets to the queues are replaced
+// at their position. The rules of the PUs use the new queues when they are
+// programmed again.
+func (i *Instance) UpdateFilterQueue(fqc *fqconfig.FilterQueue) error {
+
+	if fqc == nil {
+		return errors.New("filter queue cannot be nil")
+	}
+
+	current, err := i.recordGlobalRules(i.fqc)
+	if err != nil {
+		return err
+	}
+
+	expected, err := i.recordGlobalRules(fqc)
+	if err != nil {
+		return err
+	}
+
+	i.fqc = fqc
+
+	for _, key := range expected.keys {
+
+		rules := current.chains[key]
+
+		for idx, rule := range expected.chains[key] {
+
+			if idx < len(rules) && strings.Join(rule, " ") == strings.Join(rules[idx], " ") {
+				continue
+			}
+
+			// The new rule is inserted before the old one, so that the packets
+			// are always sent to a queue
+			if err := i.ipt.Insert(key.table, key.chain, idx+1, rule...); err != nil {
+				return fmt.Errorf("unable to add rule in table %s, chain %s: %s", key.table, key.chain, err)
+			}
+
+			if idx < len(rules) {
+				if err := i.ipt.Delete(key.table, key.chain, rules[idx]...); err != nil {
+					zap.L().Warn("Unable to delete the rule of the previous queues",
+						zap.String("table", key.table),
+						zap.String("chain", key.chain),
+						zap.Error(err),
+					)
+				}
+			}
+		}
+	}
+
+	return nil
+}
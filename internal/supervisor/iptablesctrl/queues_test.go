@@ -0,0 +1,76 @@
+package iptablesctrl
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/internal/portset"
+	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// programGlobalRules returns an instance of the configuration with a memory
+// provider where its global rules are programmed
+func programGlobalRules(fqc *fqconfig.FilterQueue, cfg *Config) (*Instance, error) {
+
+	i, err := NewInstance(fqc, constants.RemoteContainer, portset.New(nil), cfg)
+	if err != nil {
+		return nil, err
+	}
+	i.ipt = provider.NewMemoryIPTablesProvider()
+
+	recorder, err := i.recordGlobalRules(fqc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range recorder.keys {
+		if err := i.ensureChain(key.table, key.chain); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := i.ensureChain(i.appPacketIPTableContext, i.tproxyOutputChain); err != nil {
+		return nil, err
+	}
+
+	return i, i.setGlobalRules(i.appPacketIPTableSection, i.netPacketIPTableSection)
+}
+
+func TestUpdateFilterQueue(t *testing.T) {
+
+	Convey("Given an iptables controller with its global rules", t, func() {
+		fqc := fqconfig.NewFilterQueue(true, fqconfig.DefaultMarkValue, 0, 4, 4, 500, 500)
+		i, err := programGlobalRules(fqc, nil)
+		So(err, ShouldBeNil)
+
+		Convey("When I resize the queues", func() {
+			resized := fqc.Resize(2, 1)
+			err := i.UpdateFilterQueue(resized)
+			So(err, ShouldBeNil)
+
+			Convey("Then the global rules should be the ones of the new queues, in the same order", func() {
+				expected, err := programGlobalRules(resized, nil)
+				So(err, ShouldBeNil)
+
+				rules, err := i.Save()
+				So(err, ShouldBeNil)
+				expectedRules, err := expected.Save()
+				So(err, ShouldBeNil)
+
+				So(rules, ShouldEqual, expectedRules)
+				So(rules, ShouldNotContainSubstring, fqc.GetNetworkQueueSynStr())
+			})
+
+			Convey("Then the rules of the PUs should use the new queues", func() {
+				rules := i.trapRules("app", "net")
+				So(rules[0], ShouldContain, resized.GetApplicationQueueSynStr())
+			})
+		})
+
+		Convey("When I update the queues without a configuration, it should fail", func() {
+			So(i.UpdateFilterQueue(nil), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,212 @@
+package iptablesctrl
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"go.uber.org/zap"
+)
+
+// ReconcileGlobalRules implements the Reconciler interface of the supervisor.
+// The global rules are inserted at the top of their chains, so a missing rule
+// is inserted again at its position among them.
+func (i *Instance) ReconcileGlobalRules() (bool, error) {
+
+	expected, err := i.recordGlobalRules(i.fqc)
+	if err != nil {
+		return false, err
+	}
+
+	missing := map[chainKey][]int{}
+
+	for _, key := range expected.keys {
+
+		chainMissing, err := i.missingRules(key.table, key.chain, expected.chains[key])
+		if err != nil {
+			return false, err
+		}
+
+		if len(chainMissing) > 0 {
+			missing[key] = chainMissing
+			zap.L().Warn("Global rules are missing",
+				zap.String("table", key.table),
+				zap.String("chain", key.chain),
+				zap.Int("missing", len(chainMissing)),
+			)
+		}
+	}
+
+	if len(missing) == 0 {
+		return false, nil
+	}
+
+	// The chains are created first since the rules may jump to them
+	for _, key := range expected.keys {
+		if err := i.ensureChain(key.table, key.chain); err != nil {
+			return true, err
+		}
+	}
+
+	for _, key := range expected.keys {
+		for _, idx := range missing[key] {
+			if err := i.ipt.Insert(key.table, key.chain, idx+1, expected.chains[key][idx]...); err != nil {
+				return true, fmt.Errorf("unable to restore rule in table %s, chain %s: %s", key.table, key.chain, err)
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// ReconcileRules implements the Reconciler interface of the supervisor. The
+// order of the rules of the chains of a PU matters, so a chain with a missing
+// rule is programmed again. The missing rules that redirect the traffic to
+// the chains are appended again.
+func (i *Instance) ReconcileRules(version int, contextID string, containerInfo *policy.PUInfo) (bool, error) {
+
+	if containerInfo == nil || containerInfo.Policy == nil || containerInfo.Runtime == nil {
+		return false, errors.New("container info cannot be nil")
+	}
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return false, err
+	}
+
+	expected, err := i.recordPolicyRules(contextID, appChain, netChain, containerInfo)
+	if err != nil {
+		return false, err
+	}
+
+	drift := false
+
+	for _, key := range expected.keys {
+
+		rules := expected.chains[key]
+
+		missing, err := i.missingRules(key.table, key.chain, rules)
+		if err != nil {
+			return drift, err
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		drift = true
+		zap.L().Warn("Rules of the PU are missing",
+			zap.String("contextID", contextID),
+			zap.String("chain", key.chain),
+			zap.Int("missing", len(missing)),
+		)
+
+		// ClearChain creates the chain if it was deleted
+		if err := i.ipt.ClearChain(key.table, key.chain); err != nil {
+			return drift, fmt.Errorf("unable to clear chain %s of table %s: %s", key.chain, key.table, err)
+		}
+
+		if err := i.batchRules(func(tx *Instance) error {
+			for _, rule := range rules {
+				if err := tx.ipt.Append(key.table, key.chain, rule...); err != nil {
+					return fmt.Errorf("unable to restore rule in table %s, chain %s: %s", key.table, key.chain, err)
+				}
+			}
+			return nil
+		}); err != nil {
+			return drift, err
+		}
+	}
+
+	redirect := i.puRedirectRules(contextID, appChain, netChain, containerInfo)
+
+	for _, rule := range redirect {
+
+		exists, err := i.ipt.Exists(rule[0], rule[1], rule[2:]...)
+		if err != nil {
+			return drift, fmt.Errorf("unable to check rule in table %s, chain %s: %s", rule[0], rule[1], err)
+		}
+
+		if exists {
+			continue
+		}
+
+		drift = true
+		zap.L().Warn("Redirection rule of the PU is missing",
+			zap.String("contextID", contextID),
+			zap.String("chain", rule[1]),
+		)
+
+		if err := i.processRulesFromList([][]string{rule}, "Append"); err != nil {
+			return drift, err
+		}
+	}
+
+	return drift, nil
+}
+
+// puRedirectRules returns the rules that redirect the traffic of a PU to its
+// chains, as programmed by ConfigureRules and UpdateRules
+func (i *Instance) puRedirectRules(contextID, appChain, netChain string, containerInfo *policy.PUInfo) [][]string {
+
+	proxyPort := i.puProxyPort(containerInfo)
+
+	if i.mode != constants.LocalServer {
+		proxyPortSetName := PuPortSetName(contextID, "", proxyPortSet)
+		return i.redirectRules("", appChain, netChain, "", "", "", proxyPort, proxyPortSetName, containerInfo.Runtime.Options().TransparentProxy, puIPAddresses(containerInfo))
+	}
+
+	mark := containerInfo.Runtime.Options().CgroupMark
+	port := policy.ConvertServicesToProtocolPortList(containerInfo.Runtime.Options().Services)
+	uid := puOwner(containerInfo)
+
+	portSetName := PuPortSetName(contextID, mark, PuPortSet)
+	proxyPortSetName := PuPortSetName(contextID, mark, proxyPortSet)
+
+	rules := i.redirectRules(portSetName, appChain, netChain, port, mark, uid, proxyPort, proxyPortSetName, containerInfo.Runtime.Options().TransparentProxy, nil)
+	if uid == "" && containerInfo.Runtime.Options().AutoPort {
+		rules = append(rules, i.autoPortChainRules(portSetName, netChain)...)
+	}
+
+	return rules
+}
+
+// missingRules returns the indexes of the rules that are not in a chain
+func (i *Instance) missingRules(table, chain string, rules [][]string) ([]int, error) {
+
+	missing := []int{}
+
+	for idx, rule := range rules {
+		exists, err := i.ipt.Exists(table, chain, rule...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check rule in table %s, chain %s: %s", table, chain, err)
+		}
+		if !exists {
+			missing = append(missing, idx)
+		}
+	}
+
+	return missing, nil
+}
+
+// ensureChain creates a chain if it does not exist
+func (i *Instance) ensureChain(table, chain string) error {
+
+	chains, err := i.ipt.ListChains(table)
+	if err != nil {
+		return fmt.Errorf("unable to list chains of table %s: %s", table, err)
+	}
+
+	for _, c := range chains {
+		if c == chain {
+			return nil
+		}
+	}
+
+	if err := i.ipt.NewChain(table, chain); err != nil {
+		return fmt.Errorf("unable to add chain %s of table %s: %s", chain, table, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package iptablesctrl
+
+import (
+	"fmt"
+	"os/exec"
+
+	"go.uber.org/zap"
+)
+
+// transparentProxyRules returns a copy of the rules of a PU where the
+// connections redirected to the proxy with NAT are sent to it with TPROXY
+// instead, so that the proxy sees their original destinations.
+func (i *Instance) transparentProxyRules(rules [][]string) [][]string {
+
+	transparent := [][]string{}
+
+	for _, rule := range rules {
+		if tproxy, ok := i.tproxyRules(rule); ok {
+			transparent = append(transparent, tproxy...)
+			continue
+		}
+		transparent = append(transparent, rule)
+	}
+
+	return transparent
+}
+
+// tproxyRules returns the TPROXY rules that replace a REDIRECT rule of the
+// proxy chains. TPROXY only applies to the packets entering the host, so the
+// packets of the PU are marked in the output chain and routed back to the host
+// by the routing table of the mark.
+func (i *Instance) tproxyRules(rule []string) ([][]string, bool) {
+
+	if len(rule) < 4 || rule[0] != i.appProxyIPTableContext {
+		return nil, false
+	}
+
+	if rule[1] != i.natProxyInputChain && rule[1] != i.natProxyOutputChain {
+		return nil, false
+	}
+
+	target := -1
+	for pos := 2; pos+3 < len(rule); pos++ {
+		if rule[pos] == "-j" && rule[pos+1] == "REDIRECT" && rule[pos+2] == "--to-port" {
+			target = pos
+			break
+		}
+	}
+	if target < 0 {
+		return nil, false
+	}
+
+	match := rule[2:target]
+	tproxy := []string{"-j", "TPROXY", "--on-port", rule[target+3], "--tproxy-mark", i.tproxyMark}
+
+	if rule[1] == i.natProxyInputChain {
+		return [][]string{
+			append(append([]string{i.appPacketIPTableContext, i.tproxyInputChain}, match...), tproxy...),
+		}, true
+	}
+
+	return [][]string{
+		append(append([]string{i.appPacketIPTableContext, i.tproxyOutputChain}, match...), "-j", "MARK", "--set-mark", i.tproxyMark),
+		append(append([]string{i.appPacketIPTableContext, i.tproxyInputChain, "-m", "mark", "--mark", i.tproxyMark}, match...), tproxy...),
+	}, true
+}
+
+// udpProxyRules returns the udp copies of the rules of the proxy chains, so that
+// the udp services of the proxy sets are proxied as well. The original
+// destination of a redirected datagram is lost, so the datagrams are always
+// sent to the proxy with TPROXY.
+func (i *Instance) udpProxyRules(rules [][]string) [][]string {
+
+	udp := [][]string{}
+
+	for _, rule := range rules {
+		if len(rule) < 4 || rule[2] != "-p" || rule[3] != "tcp" {
+			continue
+		}
+
+		if rule[1] != i.natProxyInputChain && rule[1] != i.natProxyOutputChain &&
+			rule[1] != i.proxyInputChain && rule[1] != i.proxyOutputChain {
+			continue
+		}
+
+		udpRule := make([]string, len(rule))
+		copy(udpRule, rule)
+		udpRule[3] = "udp"
+
+		if tproxy, ok := i.tproxyRules(udpRule); ok {
+			udp = append(udp, tproxy...)
+			continue
+		}
+		udp = append(udp, udpRule)
+	}
+
+	return udp
+}
+
+// addTransparentProxyChains adds the global rules of the transparent proxies.
+// The packets of the connections of the transparent proxies are marked, so
+// that they are routed to the proxies, and are accepted.
+func (i *Instance) addTransparentProxyChains() error {
+
+	if err := i.ipt.Insert(i.appPacketIPTableContext,
+		ipTableSectionPreRouting, 1,
+		"-j", i.tproxyInputChain); err != nil {
+		return fmt.Errorf("unable to add transparent proxy input chain: %s", err)
+	}
+
+	if err := i.ipt.Insert(i.appPacketIPTableContext,
+		i.appPacketIPTableSection, 1,
+		"-j", i.tproxyOutputChain); err != nil {
+		return fmt.Errorf("unable to add transparent proxy output chain: %s", err)
+	}
+
+	if err := i.ipt.Insert(i.appPacketIPTableContext,
+		i.tproxyInputChain, 1,
+		"-p", "tcp",
+		"-m", "socket", "--transparent",
+		"-j", "ACCEPT"); err != nil {
+		return fmt.Errorf("unable to add transparent proxy socket rule: %s", err)
+	}
+
+	if err := i.ipt.Insert(i.appPacketIPTableContext,
+		i.tproxyInputChain, 1,
+		"-p", "tcp",
+		"-m", "socket", "--transparent",
+		"-j", "MARK", "--set-mark", i.tproxyMark); err != nil {
+		return fmt.Errorf("unable to add transparent proxy socket rule: %s", err)
+	}
+
+	if err := i.ipt.Insert(i.netPacketIPTableContext,
+		i.proxyInputChain, 1,
+		"-m", "mark",
+		"--mark", i.tproxyMark,
+		"-j", "ACCEPT"); err != nil {
+		return fmt.Errorf("unable to add default allow for transparent proxy packets at net: %s", err)
+	}
+
+	return nil
+}
+
+// removeTransparentProxyChains removes the chains of the transparent proxies.
+// The rules that jump to them are removed with the sections.
+func (i *Instance) removeTransparentProxyChains() {
+
+	for _, chain := range []string{i.tproxyInputChain, i.tproxyOutputChain} {
+		if err := i.ipt.ClearChain(i.appPacketIPTableContext, chain); err != nil {
+			zap.L().Warn("Failed to clear chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("Chain", chain))
+		}
+
+		if err := i.ipt.DeleteChain(i.appPacketIPTableContext, chain); err != nil {
+			zap.L().Warn("Failed to delete chain", zap.String("TableContext", i.appPacketIPTableContext), zap.String("Chain", chain))
+		}
+	}
+}
+
+// addTransparentProxyRouting routes the packets with the mark of the
+// transparent proxies to the host. It is programmed once, when the first PU
+// with a transparent proxy is configured.
+func (i *Instance) addTransparentProxyRouting() error {
+
+	if i.tproxyRouting {
+		return nil
+	}
+
+	// Remove the rule of a previous run, since rules can be duplicated
+	if err := i.ipCommand("rule", "del", "fwmark", i.tproxyMark, "lookup", i.tproxyTable); err != nil {
+		zap.L().Debug("No previous transparent proxy routing rule", zap.Error(err))
+	}
+
+	if err := i.ipCommand("rule", "add", "fwmark", i.tproxyMark, "lookup", i.tproxyTable); err != nil {
+		return fmt.Errorf("unable to add transparent proxy routing rule: %s", err)
+	}
+
+	if err := i.ipCommand("route", "replace", "local", "0.0.0.0/0", "dev", "lo", "table", i.tproxyTable); err != nil {
+		return fmt.Errorf("unable to add transparent proxy route: %s", err)
+	}
+
+	i.tproxyRouting = true
+
+	return nil
+}
+
+// removeTransparentProxyRouting removes the routing of the transparent proxies
+// if it was programmed
+func (i *Instance) removeTransparentProxyRouting() {
+
+	if !i.tproxyRouting {
+		return
+	}
+
+	if err := i.ipCommand("rule", "del", "fwmark", i.tproxyMark, "lookup", i.tproxyTable); err != nil {
+		zap.L().Warn("Unable to remove transparent proxy routing rule", zap.Error(err))
+	}
+
+	if err := i.ipCommand("route", "flush", "table", i.tproxyTable); err != nil {
+		zap.L().Warn("Unable to remove transparent proxy route", zap.Error(err))
+	}
+
+	i.tproxyRouting = false
+}
+
+// runIP runs an ip command
+func runIP(args ...string) error {
+
+	path, err := exec.LookPath("ip")
+	if err != nil {
+		return err
+	}
+
+	if out, err := exec.Command(path, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, string(out))
+	}
+
+	return nil
+}
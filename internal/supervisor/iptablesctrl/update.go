@@ -0,0 +1,427 @@
+package iptablesctrl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxIncrementalRuleChanges is the largest number of rules that are inserted
+	// in or deleted from the chains of a PU when its policy is updated in place.
+	// Larger changes program a new version of the chains.
+	maxIncrementalRuleChanges = 32
+
+	// maxIncrementalDiffSize bounds the size of the table used to compare the
+	// rules of a chain
+	maxIncrementalDiffSize = 1 << 20
+)
+
+// ruleOperation is the operation applied to a rule to move a chain from the
+// old policy to the new one
+type ruleOperation int
+
+const (
+	ruleKeep ruleOperation = iota
+	ruleInsert
+	ruleDelete
+)
+
+type ruleEdit struct {
+	operation ruleOperation
+	rule      []string
+}
+
+type chainKey struct {
+	table string
+	chain string
+}
+
+// chainRecorder is an IptablesProvider that keeps the chains and their rules
+// in memory. It is used to compute the rules of a policy without programming
+// them. The chains are created when rules are first added to them, so that
+// the rules of existing chains, like the built-in ones, can be recorded.
+type chainRecorder struct {
+	chains map[chainKey][][]string
+	keys   []chainKey
+}
+
+func newChainRecorder() *chainRecorder {
+
+	return &chainRecorder{
+		chains: map[chainKey][][]string{},
+		keys:   []chainKey{},
+	}
+}
+
+// chain returns the key of a chain and creates it if needed
+func (r *chainRecorder) chain(table, chain string) chainKey {
+
+	key := chainKey{table: table, chain: chain}
+	if _, ok := r.chains[key]; !ok {
+		r.keys = append(r.keys, key)
+		r.chains[key] = [][]string{}
+	}
+
+	return key
+}
+
+// Append implements the IptablesProvider interface
+func (r *chainRecorder) Append(table, chain string, rulespec ...string) error {
+
+	key := r.chain(table, chain)
+	r.chains[key] = append(r.chains[key], rulespec)
+
+	return nil
+}
+
+// Insert implements the IptablesProvider interface
+func (r *chainRecorder) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	key := r.chain(table, chain)
+	rules := r.chains[key]
+
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("invalid position %d in chain %s of table %s", pos, chain, table)
+	}
+
+	rules = append(rules, nil)
+	copy(rules[pos:], rules[pos-1:])
+	rules[pos-1] = rulespec
+	r.chains[key] = rules
+
+	return nil
+}
+
+// Delete implements the IptablesProvider interface
+func (r *chainRecorder) Delete(table, chain string, rulespec ...string) error {
+	return errors.New("delete is not supported by the recorder")
+}
+
+// Exists implements the IptablesProvider interface
+func (r *chainRecorder) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	key := strings.Join(rulespec, "\x00")
+	for _, rule := range r.chains[chainKey{table: table, chain: chain}] {
+		if strings.Join(rule, "\x00") == key {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// List implements the IptablesProvider interface. The rules are returned in
+// the format of iptables -S.
+func (r *chainRecorder) List(table, chain string) ([]string, error) {
+
+	rules, ok := r.chains[chainKey{table: table, chain: chain}]
+	if !ok {
+		return nil, fmt.Errorf("chain %s of table %s not found", chain, table)
+	}
+
+	list := []string{"-N " + chain}
+	for _, rule := range rules {
+		list = append(list, "-A "+chain+" "+strings.Join(rule, " "))
+	}
+
+	return list, nil
+}
+
+// ListChains implements the IptablesProvider interface
+func (r *chainRecorder) ListChains(table string) ([]string, error) {
+
+	chains := []string{}
+	for _, key := range r.keys {
+		if key.table == table {
+			chains = append(chains, key.chain)
+		}
+	}
+
+	return chains, nil
+}
+
+// ClearChain implements the IptablesProvider interface
+func (r *chainRecorder) ClearChain(table, chain string) error {
+
+	r.chains[r.chain(table, chain)] = [][]string{}
+
+	return nil
+}
+
+// DeleteChain implements the IptablesProvider interface
+func (r *chainRecorder) DeleteChain(table, chain string) error {
+
+	key := chainKey{table: table, chain: chain}
+	if _, ok := r.chains[key]; !ok {
+		return fmt.Errorf("chain %s of table %s not found", chain, table)
+	}
+
+	delete(r.chains, key)
+	for idx, k := range r.keys {
+		if k == key {
+			r.keys = append(r.keys[:idx], r.keys[idx+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// NewChain implements the IptablesProvider interface
+func (r *chainRecorder) NewChain(table, chain string) error {
+
+	key := chainKey{table: table, chain: chain}
+	if _, ok := r.chains[key]; ok {
+		return fmt.Errorf("chain %s of table %s already exists", chain, table)
+	}
+
+	r.keys = append(r.keys, key)
+	r.chains[key] = [][]string{}
+
+	return nil
+}
+
+// recordPolicyRules returns the rules of the chains of a PU for a policy, in
+// the order they are programmed by ConfigureRules and UpdateRules
+func (i *Instance) recordPolicyRules(contextID, appChain, netChain string, containerInfo *policy.PUInfo) (*chainRecorder, error) {
+
+	recorder := newChainRecorder()
+	policyrules := containerInfo.Policy
+
+	tx := *i
+	tx.ipt = recorder
+
+	if err := tx.addContainerChain(appChain, netChain); err != nil {
+		return nil, err
+	}
+
+	if err := tx.addPacketTrap(appChain, netChain, policyrules.TriremeNetworks()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.addAppACLs(contextID, appChain, policyrules.ApplicationACLs()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.addNetACLs(contextID, netChain, policyrules.NetworkACLs()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.addExclusionACLs(appChain, netChain, policyrules.ExcludedNetworks()); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// UpdateRulesInPlace implements the InPlaceUpdater interface of the supervisor.
+// The rules of the old and the new policy are compared and only the rules that
+// changed are inserted and deleted in the chains of the given version. The new
+// rules are inserted before the old ones are deleted, so that the chains are
+// never left without a rule of either policy.
+func (i *Instance) UpdateRulesInPlace(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) (bool, error) {
+
+	if containerInfo == nil || containerInfo.Policy == nil {
+		return false, errors.New("container info and policy cannot be nil")
+	}
+
+	if oldContainerInfo == nil || oldContainerInfo.Policy == nil {
+		return false, nil
+	}
+
+	// The ACL sets of the chains cannot be swapped atomically in place. The
+	// versioned update programs new sets with the new chains.
+	if i.aclSets {
+		return false, nil
+	}
+
+	// The redirect rules of a container match its addresses, which change
+	// when it is attached to or detached from a network
+	if i.mode != constants.LocalServer && !sameAddresses(puIPAddresses(containerInfo), puIPAddresses(oldContainerInfo)) {
+		return false, nil
+	}
+
+	appChain, netChain, err := i.chainName(contextID, version)
+	if err != nil {
+		return false, err
+	}
+
+	oldRules, err := i.recordPolicyRules(contextID, appChain, netChain, oldContainerInfo)
+	if err != nil {
+		return false, err
+	}
+
+	newRules, err := i.recordPolicyRules(contextID, appChain, netChain, containerInfo)
+	if err != nil {
+		return false, err
+	}
+
+	edits := map[chainKey][]ruleEdit{}
+	changes := 0
+
+	for _, key := range newRules.keys {
+
+		chainEdits, ok := diffRules(oldRules.chains[key], newRules.chains[key])
+		if !ok {
+			return false, nil
+		}
+
+		for _, edit := range chainEdits {
+			if edit.operation != ruleKeep {
+				changes++
+			}
+		}
+
+		if changes > maxIncrementalRuleChanges {
+			zap.L().Debug("Policy change too large to update in place",
+				zap.String("contextID", contextID),
+				zap.Int("changes", changes),
+			)
+			return false, nil
+		}
+
+		edits[key] = chainEdits
+	}
+
+	for _, key := range newRules.keys {
+		if err := i.applyRuleEdits(key.table, key.chain, edits[key]); err != nil {
+			return false, err
+		}
+	}
+
+	if err := i.updateProxyPorts(contextID, containerInfo); err != nil {
+		return false, err
+	}
+
+	zap.L().Debug("Updated policy in place",
+		zap.String("contextID", contextID),
+		zap.Int("changes", changes),
+	)
+
+	return true, nil
+}
+
+// applyRuleEdits programs the edits of a chain. Deleted rules are kept until
+// all the new rules are inserted and are then deleted by position, from the
+// end of the chain, since several rules may have the same specification.
+func (i *Instance) applyRuleEdits(table, chain string, edits []ruleEdit) error {
+
+	pos := 0
+	deletes := []int{}
+
+	for _, edit := range edits {
+
+		pos++
+
+		switch edit.operation {
+		case ruleInsert:
+			if err := i.ipt.Insert(table, chain, pos, edit.rule...); err != nil {
+				return fmt.Errorf("unable to insert rule in table %s, chain %s: %s", table, chain, err)
+			}
+		case ruleDelete:
+			deletes = append(deletes, pos)
+		}
+	}
+
+	for idx := len(deletes) - 1; idx >= 0; idx-- {
+		if err := i.ipt.Delete(table, chain, strconv.Itoa(deletes[idx])); err != nil {
+			return fmt.Errorf("unable to delete rule %d from table %s, chain %s: %s", deletes[idx], table, chain, err)
+		}
+	}
+
+	return nil
+}
+
+// diffRules returns the edits that turn the old rules of a chain into the new
+// ones, based on their longest common subsequence. It returns false if the
+// chains are too large to be compared.
+func diffRules(oldRules, newRules [][]string) ([]ruleEdit, bool) {
+
+	oldKeys := ruleKeys(oldRules)
+	newKeys := ruleKeys(newRules)
+
+	// The common prefix and suffix are kept without comparing them further
+	prefix := 0
+	for prefix < len(oldKeys) && prefix < len(newKeys) && oldKeys[prefix] == newKeys[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(oldKeys)-prefix && suffix < len(newKeys)-prefix &&
+		oldKeys[len(oldKeys)-1-suffix] == newKeys[len(newKeys)-1-suffix] {
+		suffix++
+	}
+
+	oldMiddle := oldKeys[prefix : len(oldKeys)-suffix]
+	newMiddle := newKeys[prefix : len(newKeys)-suffix]
+
+	n := len(oldMiddle)
+	m := len(newMiddle)
+	if (n+1)*(m+1) > maxIncrementalDiffSize {
+		return nil, false
+	}
+
+	// lcs[x][y] is the length of the common subsequence of oldMiddle[x:] and newMiddle[y:]
+	lcs := make([][]int, n+1)
+	for x := range lcs {
+		lcs[x] = make([]int, m+1)
+	}
+
+	for x := n - 1; x >= 0; x-- {
+		for y := m - 1; y >= 0; y-- {
+			switch {
+			case oldMiddle[x] == newMiddle[y]:
+				lcs[x][y] = lcs[x+1][y+1] + 1
+			case lcs[x+1][y] >= lcs[x][y+1]:
+				lcs[x][y] = lcs[x+1][y]
+			default:
+				lcs[x][y] = lcs[x][y+1]
+			}
+		}
+	}
+
+	edits := make([]ruleEdit, 0, len(oldRules)+len(newRules))
+
+	for idx := 0; idx < prefix; idx++ {
+		edits = append(edits, ruleEdit{operation: ruleKeep, rule: newRules[idx]})
+	}
+
+	x, y := 0, 0
+	for x < n || y < m {
+		switch {
+		case x < n && y < m && oldMiddle[x] == newMiddle[y]:
+			edits = append(edits, ruleEdit{operation: ruleKeep, rule: newRules[prefix+y]})
+			x++
+			y++
+		case y == m || (x < n && lcs[x+1][y] >= lcs[x][y+1]):
+			edits = append(edits, ruleEdit{operation: ruleDelete, rule: oldRules[prefix+x]})
+			x++
+		default:
+			edits = append(edits, ruleEdit{operation: ruleInsert, rule: newRules[prefix+y]})
+			y++
+		}
+	}
+
+	for idx := len(newRules) - suffix; idx < len(newRules); idx++ {
+		edits = append(edits, ruleEdit{operation: ruleKeep, rule: newRules[idx]})
+	}
+
+	return edits, true
+}
+
+// ruleKeys returns a comparable key for each rule
+func ruleKeys(rules [][]string) []string {
+
+	keys := make([]string, len(rules))
+	for idx, rule := range rules {
+		keys[idx] = strings.Join(rule, "\x00")
+	}
+
+	return keys
+}
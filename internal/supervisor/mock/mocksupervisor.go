@@ -5,8 +5,10 @@
 package mocksupervisor
 
 import (
+	context "context"
 	reflect "reflect"
 
+	constants "github.com/aporeto-inc/trireme-lib/constants"
 	policy "github.com/aporeto-inc/trireme-lib/policy"
 	gomock "github.com/golang/mock/gomock"
 )
@@ -40,30 +42,30 @@ func (m *MockSupervisor) EXPECT() *MockSupervisorMockRecorder {
 
 // Supervise mocks base method
 // nolint
-func (m *MockSupervisor) Supervise(contextID string, puInfo *policy.PUInfo) error {
-	ret := m.ctrl.Call(m, "Supervise", contextID, puInfo)
+func (m *MockSupervisor) Supervise(ctx context.Context, contextID string, puInfo *policy.PUInfo) error {
+	ret := m.ctrl.Call(m, "Supervise", ctx, contextID, puInfo)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Supervise indicates an expected call of Supervise
 // nolint
-func (mr *MockSupervisorMockRecorder) Supervise(contextID, puInfo interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Supervise", reflect.TypeOf((*MockSupervisor)(nil).Supervise), contextID, puInfo)
+func (mr *MockSupervisorMockRecorder) Supervise(ctx, contextID, puInfo interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Supervise", reflect.TypeOf((*MockSupervisor)(nil).Supervise), ctx, contextID, puInfo)
 }
 
 // Unsupervise mocks base method
 // nolint
-func (m *MockSupervisor) Unsupervise(contextID string) error {
-	ret := m.ctrl.Call(m, "Unsupervise", contextID)
+func (m *MockSupervisor) Unsupervise(ctx context.Context, contextID string) error {
+	ret := m.ctrl.Call(m, "Unsupervise", ctx, contextID)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Unsupervise indicates an expected call of Unsupervise
 // nolint
-func (mr *MockSupervisorMockRecorder) Unsupervise(contextID interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unsupervise", reflect.TypeOf((*MockSupervisor)(nil).Unsupervise), contextID)
+func (mr *MockSupervisorMockRecorder) Unsupervise(ctx, contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unsupervise", reflect.TypeOf((*MockSupervisor)(nil).Unsupervise), ctx, contextID)
 }
 
 // Start mocks base method
@@ -163,6 +165,33 @@ func (mr *MockImplementorMockRecorder) UpdateRules(version, contextID, container
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRules", reflect.TypeOf((*MockImplementor)(nil).UpdateRules), version, contextID, containerInfo, oldContainerInfo)
 }
 
+// GetRules mocks base method
+// nolint
+func (m *MockImplementor) GetRules(version int, contextID string, containerInfo *policy.PUInfo) (*policy.PURules, error) {
+	ret := m.ctrl.Call(m, "GetRules", version, contextID, containerInfo)
+	ret0, _ := ret[0].(*policy.PURules)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRules indicates an expected call of GetRules
+// nolint
+func (mr *MockImplementorMockRecorder) GetRules(version, contextID, containerInfo interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRules", reflect.TypeOf((*MockImplementor)(nil).GetRules), version, contextID, containerInfo)
+}
+
+// SetFailMode mocks base method
+// nolint
+func (m *MockImplementor) SetFailMode(mode constants.FailMode) {
+	m.ctrl.Call(m, "SetFailMode", mode)
+}
+
+// SetFailMode indicates an expected call of SetFailMode
+// nolint
+func (mr *MockImplementorMockRecorder) SetFailMode(mode interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFailMode", reflect.TypeOf((*MockImplementor)(nil).SetFailMode), mode)
+}
+
 // DeleteRules mocks base method
 // nolint
 func (m *MockImplementor) DeleteRules(version int, context, port, mark, uid, proxyPort, proxyPortSetName string) error {
@@ -0,0 +1,456 @@
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+)
+
+// Names of the providers of the audit entries
+const (
+	AuditIptables = "iptables"
+	AuditIpset    = "ipset"
+)
+
+// AuditEntry is an operation of a provider
+type AuditEntry struct {
+	// Time is when the operation started
+	Time time.Time
+	// Provider is the kind of provider, AuditIptables or AuditIpset
+	Provider string
+	// Operation is the method of the provider, like Append or Add
+	Operation string
+	// Table is the table of an iptables operation
+	Table string
+	// Chain is the chain of an iptables operation
+	Chain string
+	// Set is the set of an ipset operation
+	Set string
+	// Args are the rulespec of a rule, the entries of a set or the input of
+	// a restore
+	Args []string
+	// Err is the error returned by the operation, nil if it succeeded
+	Err error
+	// Latency is the duration of the operation
+	Latency time.Duration
+}
+
+// AuditLog keeps the last operations of the providers in a ring buffer
+type AuditLog struct {
+	entries []AuditEntry
+	next    int
+	full    bool
+	sync.Mutex
+}
+
+// NewAuditLog returns an audit log that keeps the given number of operations
+func NewAuditLog(size int) *AuditLog {
+
+	if size < 1 {
+		size = 1
+	}
+
+	return &AuditLog{
+		entries: make([]AuditEntry, size),
+	}
+}
+
+// Audit runs an operation and records it with its result and its latency.
+// The operation is run without being recorded if the log is nil.
+func (l *AuditLog) Audit(entry AuditEntry, operation func() error) error {
+
+	if l == nil {
+		return operation()
+	}
+
+	// The arguments can be reused by the caller
+	entry.Args = append([]string(nil), entry.Args...)
+
+	entry.Time = time.Now()
+	entry.Err = operation()
+	entry.Latency = time.Since(entry.Time)
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+
+	return entry.Err
+}
+
+// Entries returns the recorded operations, the oldest first
+func (l *AuditLog) Entries() []AuditEntry {
+
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.full {
+		return append([]AuditEntry{}, l.entries[:l.next]...)
+	}
+
+	entries := append([]AuditEntry{}, l.entries[l.next:]...)
+	return append(entries, l.entries[:l.next]...)
+}
+
+type auditIptablesProvider struct {
+	ipt IptablesProvider
+	log *AuditLog
+}
+
+// NewAuditIptablesProvider returns an IptablesProvider that records the
+// operations of the given provider in the log. It is also an
+// IptablesRestorer or an IptablesSaver if the given provider is.
+func NewAuditIptablesProvider(ipt IptablesProvider, log *AuditLog) IptablesProvider {
+
+	p := &auditIptablesProvider{
+		ipt: ipt,
+		log: log,
+	}
+
+	restorer, ok := ipt.(IptablesRestorer)
+	if !ok {
+		return p
+	}
+
+	r := &auditIptablesRestorer{
+		auditIptablesProvider: p,
+		restorer:              restorer,
+	}
+
+	if saver, ok := ipt.(IptablesSaver); ok {
+		return &auditIptablesSaver{
+			auditIptablesRestorer: r,
+			saver:                 saver,
+		}
+	}
+
+	return r
+}
+
+func (p *auditIptablesProvider) audit(operation, table, chain string, args []string, call func() error) error {
+
+	return p.log.Audit(AuditEntry{
+		Provider:  AuditIptables,
+		Operation: operation,
+		Table:     table,
+		Chain:     chain,
+		Args:      args,
+	}, call)
+}
+
+// Append implements the IptablesProvider interface
+func (p *auditIptablesProvider) Append(table, chain string, rulespec ...string) error {
+
+	return p.audit("Append", table, chain, rulespec, func() error {
+		return p.ipt.Append(table, chain, rulespec...)
+	})
+}
+
+// Insert implements the IptablesProvider interface
+func (p *auditIptablesProvider) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	return p.audit("Insert", table, chain, rulespec, func() error {
+		return p.ipt.Insert(table, chain, pos, rulespec...)
+	})
+}
+
+// Delete implements the IptablesProvider interface
+func (p *auditIptablesProvider) Delete(table, chain string, rulespec ...string) error {
+
+	return p.audit("Delete", table, chain, rulespec, func() error {
+		return p.ipt.Delete(table, chain, rulespec...)
+	})
+}
+
+// Exists implements the IptablesProvider interface
+func (p *auditIptablesProvider) Exists(table, chain string, rulespec ...string) (exists bool, err error) {
+
+	err = p.audit("Exists", table, chain, rulespec, func() error {
+		exists, err = p.ipt.Exists(table, chain, rulespec...)
+		return err
+	})
+
+	return exists, err
+}
+
+// List implements the IptablesProvider interface
+func (p *auditIptablesProvider) List(table, chain string) (rules []string, err error) {
+
+	err = p.audit("List", table, chain, nil, func() error {
+		rules, err = p.ipt.List(table, chain)
+		return err
+	})
+
+	return rules, err
+}
+
+// ListChains implements the IptablesProvider interface
+func (p *auditIptablesProvider) ListChains(table string) (chains []string, err error) {
+
+	err = p.audit("ListChains", table, "", nil, func() error {
+		chains, err = p.ipt.ListChains(table)
+		return err
+	})
+
+	return chains, err
+}
+
+// ClearChain implements the IptablesProvider interface
+func (p *auditIptablesProvider) ClearChain(table, chain string) error {
+
+	return p.audit("ClearChain", table, chain, nil, func() error {
+		return p.ipt.ClearChain(table, chain)
+	})
+}
+
+// DeleteChain implements the IptablesProvider interface
+func (p *auditIptablesProvider) DeleteChain(table, chain string) error {
+
+	return p.audit("DeleteChain", table, chain, nil, func() error {
+		return p.ipt.DeleteChain(table, chain)
+	})
+}
+
+// NewChain implements the IptablesProvider interface
+func (p *auditIptablesProvider) NewChain(table, chain string) error {
+
+	return p.audit("NewChain", table, chain, nil, func() error {
+		return p.ipt.NewChain(table, chain)
+	})
+}
+
+type auditIptablesRestorer struct {
+	*auditIptablesProvider
+	restorer IptablesRestorer
+}
+
+// Restore implements the IptablesRestorer interface
+func (p *auditIptablesRestorer) Restore(input string) error {
+
+	return p.audit("Restore", "", "", []string{input}, func() error {
+		return p.restorer.Restore(input)
+	})
+}
+
+type auditIptablesSaver struct {
+	*auditIptablesRestorer
+	saver IptablesSaver
+}
+
+// Save implements the IptablesSaver interface. It is not recorded, since it
+// does not change the rules.
+func (p *auditIptablesSaver) Save() string {
+
+	return p.saver.Save()
+}
+
+type auditIpsetProvider struct {
+	ips IpsetProvider
+	log *AuditLog
+}
+
+// NewAuditIpsetProvider returns an IpsetProvider that records the operations
+// of the given provider and of its sets in the log. It is also a
+// SwapIpsetProvider if the given provider is, and a NamedIpsetProvider if the
+// given provider is both.
+func NewAuditIpsetProvider(ips IpsetProvider, log *AuditLog) IpsetProvider {
+
+	p := &auditIpsetProvider{
+		ips: ips,
+		log: log,
+	}
+
+	swapper, ok := ips.(SwapIpsetProvider)
+	if !ok {
+		return p
+	}
+
+	s := &auditSwapIpsetProvider{
+		auditIpsetProvider: p,
+		swapper:            swapper,
+	}
+
+	if named, ok := ips.(NamedIpsetProvider); ok {
+		return &auditNamedIpsetProvider{
+			auditSwapIpsetProvider: s,
+			named:                  named,
+		}
+	}
+
+	return s
+}
+
+func (p *auditIpsetProvider) audit(operation, set string, args []string, call func() error) error {
+
+	return p.log.Audit(AuditEntry{
+		Provider:  AuditIpset,
+		Operation: operation,
+		Set:       set,
+		Args:      args,
+	}, call)
+}
+
+// NewIpset implements the IpsetProvider interface
+func (p *auditIpsetProvider) NewIpset(name string, hasht string, params *ipset.Params) (set Ipset, err error) {
+
+	err = p.audit("NewIpset", name, []string{hasht}, func() error {
+		set, err = p.ips.NewIpset(name, hasht, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.auditIpset(name, set), nil
+}
+
+// DestroyAll implements the IpsetProvider interface
+func (p *auditIpsetProvider) DestroyAll() error {
+
+	return p.audit("DestroyAll", "", nil, p.ips.DestroyAll)
+}
+
+// auditIpset returns a set that records its operations
+func (p *auditIpsetProvider) auditIpset(name string, set Ipset) Ipset {
+
+	s := &auditIpset{
+		set:      set,
+		name:     name,
+		provider: p,
+	}
+
+	if batch, ok := set.(BatchIpset); ok {
+		return &auditBatchIpset{
+			auditIpset: s,
+			batch:      batch,
+		}
+	}
+
+	return s
+}
+
+type auditSwapIpsetProvider struct {
+	*auditIpsetProvider
+	swapper SwapIpsetProvider
+}
+
+// Swap implements the SwapIpsetProvider interface
+func (p *auditSwapIpsetProvider) Swap(from, to string) error {
+
+	return p.audit("Swap", from, []string{to}, func() error {
+		return p.swapper.Swap(from, to)
+	})
+}
+
+type auditNamedIpsetProvider struct {
+	*auditSwapIpsetProvider
+	named NamedIpsetProvider
+}
+
+// GetIpset implements the NamedIpsetProvider interface
+func (p *auditNamedIpsetProvider) GetIpset(name string) Ipset {
+
+	return p.auditIpset(name, p.named.GetIpset(name))
+}
+
+// ListIpset implements the NamedIpsetProvider interface
+func (p *auditNamedIpsetProvider) ListIpset(name string) (entries []string, err error) {
+
+	err = p.audit("ListIpset", name, nil, func() error {
+		entries, err = p.named.ListIpset(name)
+		return err
+	})
+
+	return entries, err
+}
+
+// auditIpset is a set that records its operations
+type auditIpset struct {
+	set      Ipset
+	name     string
+	provider *auditIpsetProvider
+}
+
+// NewAuditIpset returns a set that records its operations in the log. It is
+// used for the sets that are not returned by a provider.
+func NewAuditIpset(name string, set Ipset, log *AuditLog) Ipset {
+
+	p := &auditIpsetProvider{
+		log: log,
+	}
+
+	return p.auditIpset(name, set)
+}
+
+// Add implements the Ipset interface
+func (s *auditIpset) Add(entry string, timeout int) error {
+
+	return s.provider.audit("Add", s.name, []string{entry}, func() error {
+		return s.set.Add(entry, timeout)
+	})
+}
+
+// AddOption implements the Ipset interface
+func (s *auditIpset) AddOption(entry string, option string, timeout int) error {
+
+	return s.provider.audit("AddOption", s.name, []string{entry, option}, func() error {
+		return s.set.AddOption(entry, option, timeout)
+	})
+}
+
+// Del implements the Ipset interface
+func (s *auditIpset) Del(entry string) error {
+
+	return s.provider.audit("Del", s.name, []string{entry}, func() error {
+		return s.set.Del(entry)
+	})
+}
+
+// Destroy implements the Ipset interface
+func (s *auditIpset) Destroy() error {
+
+	return s.provider.audit("Destroy", s.name, nil, s.set.Destroy)
+}
+
+// Flush implements the Ipset interface
+func (s *auditIpset) Flush() error {
+
+	return s.provider.audit("Flush", s.name, nil, s.set.Flush)
+}
+
+// Test implements the Ipset interface
+func (s *auditIpset) Test(entry string) (found bool, err error) {
+
+	err = s.provider.audit("Test", s.name, []string{entry}, func() error {
+		found, err = s.set.Test(entry)
+		return err
+	})
+
+	return found, err
+}
+
+type auditBatchIpset struct {
+	*auditIpset
+	batch BatchIpset
+}
+
+// AddBatch implements the BatchIpset interface
+func (s *auditBatchIpset) AddBatch(entries []string, timeout int) error {
+
+	return s.provider.audit("AddBatch", s.name, entries, func() error {
+		return s.batch.AddBatch(entries, timeout)
+	})
+}
+
+// DelBatch implements the BatchIpset interface
+func (s *auditBatchIpset) DelBatch(entries []string) error {
+
+	return s.provider.audit("DelBatch", s.name, entries, func() error {
+		return s.batch.DelBatch(entries)
+	})
+}
@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuditLog(t *testing.T) {
+
+	Convey("Given an audit log of 3 operations", t, func() {
+
+		log := NewAuditLog(3)
+
+		Convey("When I record fewer operations, they should all be returned in order", func() {
+			So(log.Audit(AuditEntry{Operation: "first"}, func() error { return nil }), ShouldBeNil)
+			So(log.Audit(AuditEntry{Operation: "second"}, func() error { return errors.New("error") }), ShouldNotBeNil)
+
+			entries := log.Entries()
+			So(len(entries), ShouldEqual, 2)
+			So(entries[0].Operation, ShouldEqual, "first")
+			So(entries[0].Err, ShouldBeNil)
+			So(entries[0].Time.IsZero(), ShouldBeFalse)
+			So(entries[1].Operation, ShouldEqual, "second")
+			So(entries[1].Err, ShouldNotBeNil)
+		})
+
+		Convey("When I record more operations, only the last ones should be returned", func() {
+			for _, op := range []string{"1", "2", "3", "4", "5"} {
+				So(log.Audit(AuditEntry{Operation: op}, func() error { return nil }), ShouldBeNil)
+			}
+
+			ops := []string{}
+			for _, entry := range log.Entries() {
+				ops = append(ops, entry.Operation)
+			}
+			So(ops, ShouldResemble, []string{"3", "4", "5"})
+		})
+	})
+
+	Convey("Given no audit log, the operations should still run", t, func() {
+
+		var log *AuditLog
+		ran := false
+
+		So(log.Audit(AuditEntry{}, func() error {
+			ran = true
+			return nil
+		}), ShouldBeNil)
+		So(ran, ShouldBeTrue)
+	})
+}
+
+func TestAuditIptablesProvider(t *testing.T) {
+
+	Convey("Given an audited memory iptables provider", t, func() {
+
+		log := NewAuditLog(10)
+		ipt := NewAuditIptablesProvider(NewMemoryIPTablesProvider(), log)
+
+		Convey("The operations should be recorded with their result", func() {
+			So(ipt.NewChain("mangle", "TRIREME-App"), ShouldBeNil)
+			So(ipt.Append("mangle", "TRIREME-App", "-j", "ACCEPT"), ShouldBeNil)
+			So(ipt.Append("mangle", "TRIREME-Net", "-j", "ACCEPT"), ShouldNotBeNil)
+
+			exists, err := ipt.Exists("mangle", "TRIREME-App", "-j", "ACCEPT")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+
+			entries := log.Entries()
+			So(len(entries), ShouldEqual, 4)
+			So(entries[1], ShouldResemble, AuditEntry{
+				Time:      entries[1].Time,
+				Provider:  AuditIptables,
+				Operation: "Append",
+				Table:     "mangle",
+				Chain:     "TRIREME-App",
+				Args:      []string{"-j", "ACCEPT"},
+				Latency:   entries[1].Latency,
+			})
+			So(entries[2].Chain, ShouldEqual, "TRIREME-Net")
+			So(entries[2].Err, ShouldNotBeNil)
+			So(entries[3].Operation, ShouldEqual, "Exists")
+		})
+
+		Convey("It should restore and save the rules like the memory provider", func() {
+			So(ipt.(IptablesRestorer).Restore("*mangle\n:TRIREME-App - [0:0]\nCOMMIT\n"), ShouldBeNil)
+			So(ipt.(IptablesSaver).Save(), ShouldContainSubstring, ":TRIREME-App - [0:0]")
+
+			entries := log.Entries()
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Operation, ShouldEqual, "Restore")
+		})
+	})
+
+	Convey("Given an audited provider that cannot restore rules", t, func() {
+
+		ipt := NewAuditIptablesProvider(NewTestIptablesProvider(), NewAuditLog(10))
+
+		Convey("It should not be a restorer", func() {
+			_, ok := ipt.(IptablesRestorer)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestAuditIpsetProvider(t *testing.T) {
+
+	Convey("Given an audited memory ipset provider", t, func() {
+
+		log := NewAuditLog(10)
+		ips := NewAuditIpsetProvider(NewMemoryIpsetProvider(), log)
+
+		Convey("The operations of the sets should be recorded", func() {
+			set, err := ips.NewIpset("TargetNetSet", "hash:net", &ipset.Params{})
+			So(err, ShouldBeNil)
+			So(AddEntries(set, []string{"10.0.0.0/8"}, 0), ShouldBeNil)
+			So(ips.(NamedIpsetProvider).GetIpset("TargetNetSet").Flush(), ShouldBeNil)
+			So(ips.(SwapIpsetProvider).Swap("TargetNetSet", "missing"), ShouldNotBeNil)
+
+			ops := []string{}
+			for _, entry := range log.Entries() {
+				So(entry.Provider, ShouldEqual, AuditIpset)
+				So(entry.Set, ShouldEqual, "TargetNetSet")
+				ops = append(ops, entry.Operation)
+			}
+			So(ops, ShouldResemble, []string{"NewIpset", "AddBatch", "Flush", "Swap"})
+			So(log.Entries()[3].Err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given an audited ipset provider that cannot swap sets", t, func() {
+
+		ips := NewAuditIpsetProvider(NewTestIpsetProvider(), NewAuditLog(10))
+
+		Convey("It should not be a swap or a named provider", func() {
+			_, ok := ips.(SwapIpsetProvider)
+			So(ok, ShouldBeFalse)
+			_, ok = ips.(NamedIpsetProvider)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
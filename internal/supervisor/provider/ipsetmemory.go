@@ -0,0 +1,216 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+)
+
+// memorySet holds the entries of a set and their options
+type memorySet struct {
+	hasht   string
+	entries map[string]string
+}
+
+type memoryIpsetProvider struct {
+	sets map[string]*memorySet
+	sync.Mutex
+}
+
+// NewMemoryIpsetProvider returns an IpsetProvider that keeps the sets in
+// memory instead of programming them in the kernel. The sets can be of any
+// type and their entries are compared as strings. It is also a
+// SwapIpsetProvider and a NamedIpsetProvider.
+func NewMemoryIpsetProvider() IpsetProvider {
+
+	return &memoryIpsetProvider{
+		sets: map[string]*memorySet{},
+	}
+}
+
+// NewIpset creates a set. An existing set of the same type is kept.
+func (m *memoryIpsetProvider) NewIpset(name string, hasht string, p *ipset.Params) (Ipset, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	if set, ok := m.sets[name]; ok {
+		if set.hasht != hasht {
+			return nil, fmt.Errorf("set %s already exists with type %s", name, set.hasht)
+		}
+		return &memoryIpset{provider: m, name: name}, nil
+	}
+
+	m.sets[name] = &memorySet{
+		hasht:   hasht,
+		entries: map[string]string{},
+	}
+
+	return &memoryIpset{provider: m, name: name}, nil
+}
+
+// DestroyAll destroys all the sets
+func (m *memoryIpsetProvider) DestroyAll() error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.sets = map[string]*memorySet{}
+
+	return nil
+}
+
+// Swap atomically exchanges the names of two sets of the same type
+func (m *memoryIpsetProvider) Swap(from, to string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	fromSet, ok := m.sets[from]
+	if !ok {
+		return fmt.Errorf("set %s does not exist", from)
+	}
+
+	toSet, ok := m.sets[to]
+	if !ok {
+		return fmt.Errorf("set %s does not exist", to)
+	}
+
+	if fromSet.hasht != toSet.hasht {
+		return fmt.Errorf("sets %s and %s have different types", from, to)
+	}
+
+	m.sets[from], m.sets[to] = toSet, fromSet
+
+	return nil
+}
+
+// GetIpset implements the NamedIpsetProvider interface
+func (m *memoryIpsetProvider) GetIpset(name string) Ipset {
+
+	return &memoryIpset{provider: m, name: name}
+}
+
+// ListIpset implements the NamedIpsetProvider interface. The entries are
+// sorted.
+func (m *memoryIpsetProvider) ListIpset(name string) ([]string, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	set, ok := m.sets[name]
+	if !ok {
+		return nil, fmt.Errorf("set %s does not exist", name)
+	}
+
+	entries := []string{}
+	for entry := range set.entries {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+
+	return entries, nil
+}
+
+// memoryIpset is a set of a memory provider. It refers to the set by its name,
+// like the sets of the kernel.
+type memoryIpset struct {
+	provider *memoryIpsetProvider
+	name     string
+}
+
+// Add adds an entry to the set. An existing entry is not an error.
+func (s *memoryIpset) Add(entry string, timeout int) error {
+
+	return s.AddOption(entry, "", timeout)
+}
+
+// AddOption adds an entry with an option to the set
+func (s *memoryIpset) AddOption(entry string, option string, timeout int) error {
+
+	return s.update(func(set *memorySet) error {
+		set.entries[entry] = option
+		return nil
+	})
+}
+
+// AddBatch adds the entries to the set
+func (s *memoryIpset) AddBatch(entries []string, timeout int) error {
+
+	return s.update(func(set *memorySet) error {
+		for _, entry := range entries {
+			set.entries[entry] = ""
+		}
+		return nil
+	})
+}
+
+// Del deletes an entry from the set. A missing entry is not an error.
+func (s *memoryIpset) Del(entry string) error {
+
+	return s.DelBatch([]string{entry})
+}
+
+// DelBatch deletes the entries from the set
+func (s *memoryIpset) DelBatch(entries []string) error {
+
+	return s.update(func(set *memorySet) error {
+		for _, entry := range entries {
+			delete(set.entries, entry)
+		}
+		return nil
+	})
+}
+
+// Destroy destroys the set
+func (s *memoryIpset) Destroy() error {
+
+	s.provider.Lock()
+	defer s.provider.Unlock()
+
+	if _, ok := s.provider.sets[s.name]; !ok {
+		return fmt.Errorf("set %s does not exist", s.name)
+	}
+
+	delete(s.provider.sets, s.name)
+
+	return nil
+}
+
+// Flush removes all the entries of the set
+func (s *memoryIpset) Flush() error {
+
+	return s.update(func(set *memorySet) error {
+		set.entries = map[string]string{}
+		return nil
+	})
+}
+
+// Test returns true if the entry is in the set
+func (s *memoryIpset) Test(entry string) (bool, error) {
+
+	found := false
+
+	err := s.update(func(set *memorySet) error {
+		_, found = set.entries[entry]
+		return nil
+	})
+
+	return found, err
+}
+
+// update calls change with the set if it exists
+func (s *memoryIpset) update(change func(*memorySet) error) error {
+
+	s.provider.Lock()
+	defer s.provider.Unlock()
+
+	set, ok := s.provider.sets[s.name]
+	if !ok {
+		return fmt.Errorf("set %s does not exist", s.name)
+	}
+
+	return change(set)
+}
@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryIpset(t *testing.T) {
+
+	Convey("Given a memory ipset provider with a set", t, func() {
+
+		ips := NewMemoryIpsetProvider()
+		named := ips.(NamedIpsetProvider)
+
+		set, err := ips.NewIpset("TargetNetSet", "hash:net", &ipset.Params{})
+		So(err, ShouldBeNil)
+		So(set.Add("10.0.0.0/8", 0), ShouldBeNil)
+		So(AddEntries(set, []string{"192.168.0.0/16", "172.16.0.0/12"}, 0), ShouldBeNil)
+
+		Convey("The entries should be listed in order", func() {
+			entries, err := named.ListIpset("TargetNetSet")
+			So(err, ShouldBeNil)
+			So(entries, ShouldResemble, []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"})
+		})
+
+		Convey("When I delete an entry, it should not be in the set", func() {
+			So(set.Del("10.0.0.0/8"), ShouldBeNil)
+			So(set.Del("10.0.0.0/8"), ShouldBeNil)
+
+			found, err := set.Test("10.0.0.0/8")
+			So(err, ShouldBeNil)
+			So(found, ShouldBeFalse)
+		})
+
+		Convey("When I create the set again, its entries should be kept", func() {
+			again, err := ips.NewIpset("TargetNetSet", "hash:net", &ipset.Params{})
+			So(err, ShouldBeNil)
+
+			found, err := again.Test("192.168.0.0/16")
+			So(err, ShouldBeNil)
+			So(found, ShouldBeTrue)
+
+			_, err = ips.NewIpset("TargetNetSet", "hash:ip", &ipset.Params{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I flush or destroy it by name, the set should change", func() {
+			So(named.GetIpset("TargetNetSet").Flush(), ShouldBeNil)
+
+			entries, err := named.ListIpset("TargetNetSet")
+			So(err, ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+
+			So(named.GetIpset("TargetNetSet").Destroy(), ShouldBeNil)
+			So(set.Add("10.0.0.0/8", 0), ShouldNotBeNil)
+
+			_, err = named.ListIpset("TargetNetSet")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I swap it with another set, the entries should be exchanged", func() {
+			tmp, err := ips.NewIpset("TargetNetSet-tmp", "hash:net", &ipset.Params{})
+			So(err, ShouldBeNil)
+			So(tmp.Add("100.64.0.0/10", 0), ShouldBeNil)
+
+			So(ips.(SwapIpsetProvider).Swap("TargetNetSet-tmp", "TargetNetSet"), ShouldBeNil)
+
+			entries, err := named.ListIpset("TargetNetSet")
+			So(err, ShouldBeNil)
+			So(entries, ShouldResemble, []string{"100.64.0.0/10"})
+
+			_, err = ips.NewIpset("other", "hash:ip", &ipset.Params{})
+			So(err, ShouldBeNil)
+			So(ips.(SwapIpsetProvider).Swap("other", "TargetNetSet"), ShouldNotBeNil)
+		})
+
+		Convey("When I destroy all the sets, no set should remain", func() {
+			So(ips.DestroyAll(), ShouldBeNil)
+
+			_, err := named.ListIpset("TargetNetSet")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
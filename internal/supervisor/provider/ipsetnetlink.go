@@ -0,0 +1,587 @@
+package provider
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+)
+
+// Kernel ipset netlink definitions from linux/netfilter/ipset/ip_set.h
+const (
+	nfnlSubsysIpset = 6
+	ipsetProtocol   = 6
+
+	ipsetCmdProtocol = 1
+	ipsetCmdCreate   = 2
+	ipsetCmdDestroy  = 3
+	ipsetCmdFlush    = 4
+	ipsetCmdSwap     = 6
+	ipsetCmdAdd      = 9
+	ipsetCmdDel      = 10
+	ipsetCmdTest     = 11
+	ipsetCmdType     = 13
+
+	ipsetAttrProtocol = 1
+	ipsetAttrSetName  = 2
+	ipsetAttrTypeName = 3
+	ipsetAttrSetName2 = ipsetAttrTypeName
+	ipsetAttrRevision = 4
+	ipsetAttrFamily   = 5
+	ipsetAttrData     = 7
+	ipsetAttrADT      = 8
+	ipsetAttrLineNo   = 9
+
+	ipsetAttrIP        = 1
+	ipsetAttrCIDR      = 3
+	ipsetAttrPort      = 4
+	ipsetAttrPortTo    = 5
+	ipsetAttrTimeout   = 6
+	ipsetAttrProto     = 7
+	ipsetAttrCadtFlags = 8
+	ipsetAttrHashSize  = 18
+	ipsetAttrMaxElem   = 19
+	ipsetAttrIP2       = 20
+	ipsetAttrCIDR2     = 21
+
+	ipsetAttrIPAddrIPv4 = 1
+	ipsetAttrIPAddrIPv6 = 2
+
+	ipsetFlagNoMatch = 1 << 2
+
+	ipsetErrPrivate = 4096
+	ipsetErrExist   = 4103
+
+	nfprotoIPv4 = 2
+	nfprotoIPv6 = 10
+
+	ipprotoTCP  = 6
+	ipprotoUDP  = 17
+	ipprotoSCTP = 132
+
+	nlaFNested       = 0x8000
+	nlaFNetByteOrder = 0x4000
+	nlaTypeMask      = ^uint16(nlaFNested | nlaFNetByteOrder)
+
+	nlmFRequest = 0x1
+	nlmFAck     = 0x4
+
+	// nlmsghdr and nfgenmsg
+	nlmsgHeaderLen = 16
+	nfgenmsgLen    = 4
+
+	// ipsetBatchSize is the number of entries added or deleted by a message
+	ipsetBatchSize = 512
+)
+
+// ipsetErrors are the messages of the ipset specific errors of the kernel
+var ipsetErrors = map[int]string{
+	4097: "protocol error",
+	4098: "set type not supported",
+	4099: "maximal number of sets reached",
+	4100: "set is busy",
+	4101: "second set does not exist",
+	4102: "set type mismatch",
+	4103: "element not found",
+	4104: "invalid cidr",
+	4105: "invalid netmask",
+	4106: "invalid family",
+	4107: "set has no timeout support",
+	4108: "set is referenced",
+	4109: "invalid ipv4 address",
+	4110: "invalid ipv6 address",
+	4352: "set is full",
+	4353: "invalid element",
+	4354: "invalid protocol",
+	4355: "missing protocol",
+	4356: "range not supported",
+	4357: "invalid range",
+}
+
+// ipsetError is an error returned by the kernel for an ipset request
+type ipsetError int
+
+func (e ipsetError) Error() string {
+
+	if msg, ok := ipsetErrors[int(e)]; ok {
+		return msg
+	}
+
+	return "ipset error " + strconv.Itoa(int(e))
+}
+
+// ipsetErrno returns the error of a negated error code of a netlink ack
+func ipsetErrno(code int) error {
+
+	if code >= ipsetErrPrivate {
+		return ipsetError(code)
+	}
+
+	return syscall.Errno(code)
+}
+
+// ipsetSocket sends the ipset requests to the kernel
+type ipsetSocket interface {
+	// request sends a command with its attributes and returns the attributes
+	// of the reply of the kernel, if any
+	request(cmd uint8, attrs []byte) ([]byte, error)
+}
+
+type netlinkIpsetProvider struct {
+	socket ipsetSocket
+}
+
+// NewNetlinkIpsetProvider returns an IpsetProvider that programs the ipsets
+// with netlink messages to the kernel instead of the ipset command. It fails
+// if the kernel does not support the ipset netlink protocol.
+func NewNetlinkIpsetProvider() (IpsetProvider, error) {
+
+	socket, err := newIpsetSocket()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open ipset netlink socket: %s", err)
+	}
+
+	if _, err := socket.request(ipsetCmdProtocol, nil); err != nil {
+		return nil, fmt.Errorf("ipset netlink protocol is not supported: %s", err)
+	}
+
+	return &netlinkIpsetProvider{
+		socket: socket,
+	}, nil
+}
+
+// NewIpset creates the set with the highest revision of its type supported by
+// the kernel. An existing set of the same type is kept.
+func (n *netlinkIpsetProvider) NewIpset(name string, hasht string, p *ipset.Params) (Ipset, error) {
+
+	if p == nil {
+		p = &ipset.Params{}
+	}
+
+	var family uint8
+	switch p.HashFamily {
+	case "", "inet":
+		family = nfprotoIPv4
+	case "inet6":
+		family = nfprotoIPv6
+	default:
+		return nil, fmt.Errorf("invalid family %s", p.HashFamily)
+	}
+
+	types, err := ipsetTypes(hasht)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := n.socket.request(ipsetCmdType, nlAttrs(
+		nlAttrString(ipsetAttrTypeName, hasht),
+		nlAttrU8(ipsetAttrFamily, family),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("unable to find set type %s: %s", hasht, err)
+	}
+
+	revision, ok := nlParseAttrs(reply)[ipsetAttrRevision]
+	if !ok || len(revision) < 1 {
+		return nil, fmt.Errorf("no revision for set type %s", hasht)
+	}
+
+	data := [][]byte{}
+	if p.HashSize > 0 {
+		data = append(data, nlAttrBE32(ipsetAttrHashSize, uint32(p.HashSize)))
+	}
+	if p.MaxElem > 0 {
+		data = append(data, nlAttrBE32(ipsetAttrMaxElem, uint32(p.MaxElem)))
+	}
+	if p.Timeout > 0 {
+		data = append(data, nlAttrBE32(ipsetAttrTimeout, uint32(p.Timeout)))
+	}
+
+	if _, err := n.socket.request(ipsetCmdCreate, nlAttrs(
+		nlAttrString(ipsetAttrSetName, name),
+		nlAttrString(ipsetAttrTypeName, hasht),
+		nlAttrU8(ipsetAttrRevision, revision[0]),
+		nlAttrU8(ipsetAttrFamily, family),
+		nlAttr(ipsetAttrData|nlaFNested, data...),
+	)); err != nil {
+		return nil, fmt.Errorf("unable to create set %s: %s", name, err)
+	}
+
+	return &netlinkIpset{
+		name:    name,
+		family:  family,
+		types:   types,
+		timeout: p.Timeout > 0,
+		socket:  n.socket,
+	}, nil
+}
+
+// DestroyAll destroys all the ipsets - it will fail if there are existing references
+func (n *netlinkIpsetProvider) DestroyAll() error {
+
+	_, err := n.socket.request(ipsetCmdDestroy, nil)
+	return err
+}
+
+// Swap atomically exchanges the names of two sets of the same type
+func (n *netlinkIpsetProvider) Swap(from, to string) error {
+
+	_, err := n.socket.request(ipsetCmdSwap, nlAttrs(
+		nlAttrString(ipsetAttrSetName, from),
+		nlAttrString(ipsetAttrSetName2, to),
+	))
+	return err
+}
+
+// netlinkIpset is a set programmed with netlink messages
+type netlinkIpset struct {
+	name    string
+	family  uint8
+	types   []string
+	timeout bool
+	socket  ipsetSocket
+}
+
+// Add adds an entry to the set. An existing entry is not an error.
+func (s *netlinkIpset) Add(entry string, timeout int) error {
+
+	return s.adt(ipsetCmdAdd, []string{entry}, 0, timeout)
+}
+
+// AddOption adds an entry with an option to the set. Only the nomatch option
+// is supported.
+func (s *netlinkIpset) AddOption(entry string, option string, timeout int) error {
+
+	if option != "nomatch" {
+		return fmt.Errorf("unsupported option %s", option)
+	}
+
+	return s.adt(ipsetCmdAdd, []string{entry}, ipsetFlagNoMatch, timeout)
+}
+
+// AddBatch adds the entries to the set with as few messages as possible
+func (s *netlinkIpset) AddBatch(entries []string, timeout int) error {
+
+	return s.adt(ipsetCmdAdd, entries, 0, timeout)
+}
+
+// Del deletes an entry from the set. A missing entry is not an error.
+func (s *netlinkIpset) Del(entry string) error {
+
+	return s.adt(ipsetCmdDel, []string{entry}, 0, 0)
+}
+
+// DelBatch deletes the entries from the set with as few messages as possible
+func (s *netlinkIpset) DelBatch(entries []string) error {
+
+	return s.adt(ipsetCmdDel, entries, 0, 0)
+}
+
+// Destroy destroys the set
+func (s *netlinkIpset) Destroy() error {
+
+	_, err := s.socket.request(ipsetCmdDestroy, nlAttrs(nlAttrString(ipsetAttrSetName, s.name)))
+	return err
+}
+
+// Flush removes all the entries of the set
+func (s *netlinkIpset) Flush() error {
+
+	_, err := s.socket.request(ipsetCmdFlush, nlAttrs(nlAttrString(ipsetAttrSetName, s.name)))
+	return err
+}
+
+// Test returns true if the entry is in the set
+func (s *netlinkIpset) Test(entry string) (bool, error) {
+
+	data, err := s.entryData(entry, 0, 0)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = s.socket.request(ipsetCmdTest, nlAttrs(nlAttrString(ipsetAttrSetName, s.name), data)); err != nil {
+		if err == ipsetError(ipsetErrExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// adt adds or deletes entries. A single entry is sent as the data of the
+// message, and the others are sent in batches of data attributes.
+func (s *netlinkIpset) adt(cmd uint8, entries []string, flags uint32, timeout int) error {
+
+	data := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		d, err := s.entryData(entry, flags, timeout)
+		if err != nil {
+			return err
+		}
+		data = append(data, d)
+	}
+
+	for len(data) > 0 {
+		batch := data
+		if len(batch) > ipsetBatchSize {
+			batch = batch[:ipsetBatchSize]
+		}
+		data = data[len(batch):]
+
+		attrs := nlAttrs(nlAttrString(ipsetAttrSetName, s.name), batch[0])
+		if len(batch) > 1 {
+			attrs = nlAttrs(
+				nlAttrString(ipsetAttrSetName, s.name),
+				nlAttr(ipsetAttrADT|nlaFNested, batch...),
+				nlAttrU32(ipsetAttrLineNo, 0),
+			)
+		}
+
+		if _, err := s.socket.request(cmd, attrs); err != nil {
+			return fmt.Errorf("unable to update set %s: %s", s.name, err)
+		}
+	}
+
+	return nil
+}
+
+// entryData returns the data attribute of an entry of the set
+func (s *netlinkIpset) entryData(entry string, flags uint32, timeout int) ([]byte, error) {
+
+	values := strings.Split(entry, ",")
+	if len(values) != len(s.types) {
+		return nil, fmt.Errorf("invalid entry %s for set %s", entry, s.name)
+	}
+
+	attrs := [][]byte{}
+	addresses := 0
+
+	for i, value := range values {
+		switch s.types[i] {
+		case "ip", "net":
+			ipAttr, cidrAttr := uint16(ipsetAttrIP), uint16(ipsetAttrCIDR)
+			if addresses > 0 {
+				ipAttr, cidrAttr = ipsetAttrIP2, ipsetAttrCIDR2
+			}
+			addresses++
+
+			addr, err := ipsetAddress(ipAttr, cidrAttr, s.family, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry %s: %s", entry, err)
+			}
+			attrs = append(attrs, addr...)
+
+		case "port":
+			port, err := ipsetPort(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry %s: %s", entry, err)
+			}
+			attrs = append(attrs, port...)
+		}
+	}
+
+	if timeout > 0 || s.timeout {
+		attrs = append(attrs, nlAttrBE32(ipsetAttrTimeout, uint32(timeout)))
+	}
+
+	if flags != 0 {
+		attrs = append(attrs, nlAttrBE32(ipsetAttrCadtFlags, flags))
+	}
+
+	return nlAttr(ipsetAttrData|nlaFNested, attrs...), nil
+}
+
+// ipsetTypes returns the types of the values of the entries of a hash set
+func ipsetTypes(hasht string) ([]string, error) {
+
+	if !strings.HasPrefix(hasht, "hash:") {
+		return nil, fmt.Errorf("unsupported set type %s", hasht)
+	}
+
+	types := strings.Split(strings.TrimPrefix(hasht, "hash:"), ",")
+	for _, t := range types {
+		if t != "ip" && t != "net" && t != "port" {
+			return nil, fmt.Errorf("unsupported set type %s", hasht)
+		}
+	}
+
+	return types, nil
+}
+
+// ipsetAddress returns the attributes of an address or a network
+func ipsetAddress(ipAttr, cidrAttr uint16, family uint8, value string) ([][]byte, error) {
+
+	attrs := [][]byte{}
+
+	address := value
+	cidr := -1
+	if slash := strings.Index(value, "/"); slash >= 0 {
+		c, err := strconv.ParseUint(value[slash+1:], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %s", value)
+		}
+		address, cidr = value[:slash], int(c)
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %s", address)
+	}
+
+	if family == nfprotoIPv4 {
+		if ip = ip.To4(); ip == nil {
+			return nil, fmt.Errorf("%s is not an ipv4 address", address)
+		}
+		attrs = append(attrs, nlAttr(ipAttr|nlaFNested, nlAttr(ipsetAttrIPAddrIPv4|nlaFNetByteOrder, ip)))
+	} else {
+		if ip.To4() != nil {
+			return nil, fmt.Errorf("%s is not an ipv6 address", address)
+		}
+		attrs = append(attrs, nlAttr(ipAttr|nlaFNested, nlAttr(ipsetAttrIPAddrIPv6|nlaFNetByteOrder, ip.To16())))
+	}
+
+	if cidr >= 0 {
+		attrs = append(attrs, nlAttrU8(cidrAttr, uint8(cidr)))
+	}
+
+	return attrs, nil
+}
+
+// ipsetPort returns the attributes of a port or a range of ports with an
+// optional protocol, such as udp:53 or 1000-2000. The protocol is tcp if it
+// is not given.
+func ipsetPort(value string) ([][]byte, error) {
+
+	proto := uint8(ipprotoTCP)
+	if colon := strings.Index(value, ":"); colon >= 0 {
+		switch name := value[:colon]; name {
+		case "tcp":
+		case "udp":
+			proto = ipprotoUDP
+		case "sctp":
+			proto = ipprotoSCTP
+		default:
+			p, err := strconv.ParseUint(name, 10, 8)
+			if err != nil || p == 0 {
+				return nil, fmt.Errorf("invalid protocol %s", name)
+			}
+			proto = uint8(p)
+		}
+		value = value[colon+1:]
+	}
+
+	ports := strings.SplitN(value, "-", 2)
+
+	attrs := [][]byte{}
+	for i, port := range ports {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", port)
+		}
+		attr := uint16(ipsetAttrPort)
+		if i > 0 {
+			attr = ipsetAttrPortTo
+		}
+		attrs = append(attrs, nlAttrBE16(attr, uint16(p)))
+	}
+
+	return append(attrs, nlAttrU8(ipsetAttrProto, proto)), nil
+}
+
+// ipsetRequest returns a netlink message with an ipset command. The protocol
+// attribute is added to the attributes of the command.
+func ipsetRequest(cmd uint8, seq uint32, attrs []byte) []byte {
+
+	payload := nlAttrs(nlAttrU8(ipsetAttrProtocol, ipsetProtocol), attrs)
+
+	msg := make([]byte, nlmsgHeaderLen+nfgenmsgLen, nlmsgHeaderLen+nfgenmsgLen+len(payload))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(nlmsgHeaderLen+nfgenmsgLen+len(payload)))
+	binary.LittleEndian.PutUint16(msg[4:6], nfnlSubsysIpset<<8|uint16(cmd))
+	binary.LittleEndian.PutUint16(msg[6:8], nlmFRequest|nlmFAck)
+	binary.LittleEndian.PutUint32(msg[8:12], seq)
+	msg[nlmsgHeaderLen] = nfprotoIPv4
+
+	return append(msg, payload...)
+}
+
+// nlAttr returns a netlink attribute with the concatenation of the values,
+// padded to 4 bytes
+func nlAttr(typ uint16, values ...[]byte) []byte {
+
+	value := nlAttrs(values...)
+
+	attr := make([]byte, 4, 4+len(value)+3)
+	binary.LittleEndian.PutUint16(attr[0:2], uint16(4+len(value)))
+	binary.LittleEndian.PutUint16(attr[2:4], typ)
+	attr = append(attr, value...)
+
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+
+	return attr
+}
+
+// nlAttrs concatenates attributes
+func nlAttrs(attrs ...[]byte) []byte {
+
+	b := []byte{}
+	for _, attr := range attrs {
+		b = append(b, attr...)
+	}
+
+	return b
+}
+
+func nlAttrString(typ uint16, value string) []byte {
+	return nlAttr(typ, append([]byte(value), 0))
+}
+
+func nlAttrU8(typ uint16, value uint8) []byte {
+	return nlAttr(typ, []byte{value})
+}
+
+func nlAttrU32(typ uint16, value uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, value)
+	return nlAttr(typ, b)
+}
+
+func nlAttrBE16(typ uint16, value uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, value)
+	return nlAttr(typ|nlaFNetByteOrder, b)
+}
+
+func nlAttrBE32(typ uint16, value uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+	return nlAttr(typ|nlaFNetByteOrder, b)
+}
+
+// nlParseAttrs returns the values of the attributes by type
+func nlParseAttrs(b []byte) map[uint16][]byte {
+
+	attrs := map[uint16][]byte{}
+
+	for len(b) >= 4 {
+		length := int(binary.LittleEndian.Uint16(b[0:2]))
+		if length < 4 || length > len(b) {
+			break
+		}
+		attrs[binary.LittleEndian.Uint16(b[2:4])&nlaTypeMask] = b[4:length]
+
+		length = (length + 3) &^ 3
+		if length > len(b) {
+			break
+		}
+		b = b[length:]
+	}
+
+	return attrs
+}
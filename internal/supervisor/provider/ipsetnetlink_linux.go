@@ -0,0 +1,104 @@
+// +build linux
+
+package provider
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+const (
+	solNetlink    = 270
+	netlinkCapAck = 10
+)
+
+// netlinkIpsetSocket is a netfilter netlink socket shared by the ipsets of a
+// provider
+type netlinkIpsetSocket struct {
+	fd  int
+	seq uint32
+	buf []byte
+	sync.Mutex
+}
+
+// newIpsetSocket opens a netfilter netlink socket. It requires CAP_NET_ADMIN
+// to send the requests.
+func newIpsetSocket() (ipsetSocket, error) {
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+	}); err != nil {
+		syscall.Close(fd) // nolint
+		return nil, err
+	}
+
+	// The acks do not need to carry the requests, which can be large batches
+	syscall.SetsockoptInt(fd, solNetlink, netlinkCapAck, 1) // nolint
+
+	return &netlinkIpsetSocket{
+		fd:  fd,
+		buf: make([]byte, 1<<16),
+	}, nil
+}
+
+// request sends a request and waits for its ack
+func (s *netlinkIpsetSocket) request(cmd uint8, attrs []byte) ([]byte, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.seq++
+
+	if err := syscall.Sendto(s.fd, ipsetRequest(cmd, s.seq, attrs), 0, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+	}); err != nil {
+		return nil, fmt.Errorf("unable to send ipset request: %s", err)
+	}
+
+	var reply []byte
+
+	for {
+		n, _, err := syscall.Recvfrom(s.fd, s.buf, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return nil, fmt.Errorf("unable to receive ipset reply: %s", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(s.buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ipset reply: %s", err)
+		}
+
+		for _, msg := range msgs {
+			if msg.Header.Seq != s.seq {
+				continue
+			}
+
+			if msg.Header.Type != syscall.NLMSG_ERROR {
+				if len(msg.Data) >= nfgenmsgLen {
+					reply = append([]byte{}, msg.Data[nfgenmsgLen:]...)
+				}
+				continue
+			}
+
+			if len(msg.Data) < 4 {
+				return nil, fmt.Errorf("invalid ipset ack")
+			}
+
+			if code := int32(binary.LittleEndian.Uint32(msg.Data[0:4])); code != 0 {
+				return nil, ipsetErrno(int(-code))
+			}
+
+			return reply, nil
+		}
+	}
+}
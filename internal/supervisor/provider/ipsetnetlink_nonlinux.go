@@ -0,0 +1,10 @@
+// +build !linux
+
+package provider
+
+import "errors"
+
+// newIpsetSocket is not supported on this platform
+func newIpsetSocket() (ipsetSocket, error) {
+	return nil, errors.New("ipset netlink is not supported on this platform")
+}
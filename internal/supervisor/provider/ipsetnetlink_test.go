@@ -0,0 +1,154 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type ipsetRequestRecord struct {
+	cmd   uint8
+	attrs map[uint16][]byte
+}
+
+// testIpsetSocket records the requests and returns the given replies
+type testIpsetSocket struct {
+	requests []ipsetRequestRecord
+	replies  map[uint8][]byte
+	errs     map[uint8]error
+}
+
+func (s *testIpsetSocket) request(cmd uint8, attrs []byte) ([]byte, error) {
+	s.requests = append(s.requests, ipsetRequestRecord{cmd: cmd, attrs: nlParseAttrs(attrs)})
+	return s.replies[cmd], s.errs[cmd]
+}
+
+func TestIpsetEntryData(t *testing.T) {
+
+	Convey("Given a hash:net,port set", t, func() {
+		s := &netlinkIpset{name: "set", family: nfprotoIPv4, types: []string{"net", "port"}}
+
+		Convey("The data of an entry should have the network, the ports and the protocol", func() {
+			data, err := s.entryData("10.1.1.0/24,udp:1000-2000", 0, 0)
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, nlAttr(ipsetAttrData|nlaFNested,
+				nlAttr(ipsetAttrIP|nlaFNested, nlAttr(ipsetAttrIPAddrIPv4|nlaFNetByteOrder, []byte{10, 1, 1, 0})),
+				nlAttrU8(ipsetAttrCIDR, 24),
+				nlAttrBE16(ipsetAttrPort, 1000),
+				nlAttrBE16(ipsetAttrPortTo, 2000),
+				nlAttrU8(ipsetAttrProto, ipprotoUDP),
+			))
+		})
+
+		Convey("The protocol of a port should be tcp by default", func() {
+			data, err := s.entryData("10.1.1.1,80", 0, 30)
+			So(err, ShouldBeNil)
+			attrs := nlParseAttrs(nlParseAttrs(data)[ipsetAttrData])
+			So(attrs[ipsetAttrProto], ShouldResemble, []byte{ipprotoTCP})
+			So(attrs[ipsetAttrPort], ShouldResemble, []byte{0, 80})
+			So(attrs[ipsetAttrTimeout], ShouldResemble, []byte{0, 0, 0, 30})
+			_, ok := attrs[ipsetAttrCIDR]
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Invalid entries should be rejected", func() {
+			for _, entry := range []string{"10.1.1.0/24", "10.1.1.0/24,tcp:http", "10.1.1.0/24,foo:80", "2001:db8::1,80", "10.1.1.0/x,80"} {
+				_, err := s.entryData(entry, 0, 0)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+
+	Convey("Only the hash sets of addresses, networks and ports should be supported", t, func() {
+		types, err := ipsetTypes("hash:ip,port")
+		So(err, ShouldBeNil)
+		So(types, ShouldResemble, []string{"ip", "port"})
+
+		_, err = ipsetTypes("bitmap:port")
+		So(err, ShouldNotBeNil)
+		_, err = ipsetTypes("hash:net,iface")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestNetlinkIpsetProvider(t *testing.T) {
+
+	Convey("Given a netlink ipset provider", t, func() {
+		socket := &testIpsetSocket{
+			replies: map[uint8][]byte{
+				ipsetCmdType: nlAttrs(nlAttrString(ipsetAttrTypeName, "hash:net"), nlAttrU8(ipsetAttrRevision, 7)),
+			},
+			errs: map[uint8]error{},
+		}
+		p := &netlinkIpsetProvider{socket: socket}
+
+		Convey("When I create a set, it should be created with the revision of the kernel", func() {
+			set, err := p.NewIpset("set", "hash:net", &ipset.Params{MaxElem: 1000})
+			So(err, ShouldBeNil)
+			So(set, ShouldNotBeNil)
+			So(len(socket.requests), ShouldEqual, 2)
+
+			create := socket.requests[1]
+			So(create.cmd, ShouldEqual, ipsetCmdCreate)
+			So(string(create.attrs[ipsetAttrSetName]), ShouldEqual, "set\x00")
+			So(string(create.attrs[ipsetAttrTypeName]), ShouldEqual, "hash:net\x00")
+			So(create.attrs[ipsetAttrRevision], ShouldResemble, []byte{7})
+			So(create.attrs[ipsetAttrFamily], ShouldResemble, []byte{nfprotoIPv4})
+			So(nlParseAttrs(create.attrs[ipsetAttrData])[ipsetAttrMaxElem], ShouldResemble, []byte{0, 0, 0x03, 0xe8})
+
+			Convey("When I add a single entry, it should be sent as the data of the request", func() {
+				So(set.Add("10.1.1.0/24", 0), ShouldBeNil)
+				add := socket.requests[2]
+				So(add.cmd, ShouldEqual, ipsetCmdAdd)
+				_, ok := add.attrs[ipsetAttrData]
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("When I add many entries, they should be sent in batches", func() {
+				entries := []string{}
+				for i := 0; i < ipsetBatchSize+1; i++ {
+					entries = append(entries, "10.1.1.1")
+				}
+				So(AddEntries(set, entries, 0), ShouldBeNil)
+				So(len(socket.requests), ShouldEqual, 4)
+				_, ok := socket.requests[2].attrs[ipsetAttrADT]
+				So(ok, ShouldBeTrue)
+				_, ok = socket.requests[3].attrs[ipsetAttrData]
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("When I add an invalid entry, nothing should be sent", func() {
+				So(AddEntries(set, []string{"10.1.1.1", "foo"}, 0), ShouldNotBeNil)
+				So(len(socket.requests), ShouldEqual, 2)
+			})
+
+			Convey("When I test an entry that is not in the set, I should get false", func() {
+				socket.errs[ipsetCmdTest] = ipsetErrno(ipsetErrExist)
+				found, err := set.Test("10.1.1.1")
+				So(err, ShouldBeNil)
+				So(found, ShouldBeFalse)
+			})
+
+			Convey("When I test an entry that is in the set, I should get true", func() {
+				found, err := set.Test("10.1.1.1")
+				So(err, ShouldBeNil)
+				So(found, ShouldBeTrue)
+			})
+		})
+
+		Convey("When I swap two sets, their names should be sent", func() {
+			So(p.Swap("a", "b"), ShouldBeNil)
+			So(socket.requests[0].cmd, ShouldEqual, ipsetCmdSwap)
+			So(string(socket.requests[0].attrs[ipsetAttrSetName]), ShouldEqual, "a\x00")
+			So(string(socket.requests[0].attrs[ipsetAttrSetName2]), ShouldEqual, "b\x00")
+		})
+
+		Convey("When the set type is not supported by the kernel, the set should not be created", func() {
+			socket.errs[ipsetCmdType] = ipsetErrno(4098)
+			_, err := p.NewIpset("set", "hash:net", nil)
+			So(err, ShouldNotBeNil)
+			So(len(socket.requests), ShouldEqual, 1)
+		})
+	})
+}
@@ -1,6 +1,9 @@
 package provider
 
-import "github.com/bvandewalle/go-ipset/ipset"
+import (
+	"github.com/bvandewalle/go-ipset/ipset"
+	"go.uber.org/zap"
+)
 
 // IpsetProvider returns a fabric for Ipset.
 type IpsetProvider interface {
@@ -19,6 +22,46 @@ type Ipset interface {
 	Test(entry string) (bool, error)
 }
 
+// BatchIpset is an Ipset that can add or delete many entries at once.
+type BatchIpset interface {
+	Ipset
+	AddBatch(entries []string, timeout int) error
+	DelBatch(entries []string) error
+}
+
+// SwapIpsetProvider is an IpsetProvider that can atomically exchange two sets
+// of the same type.
+type SwapIpsetProvider interface {
+	IpsetProvider
+	Swap(from, to string) error
+}
+
+// NamedIpsetProvider is an IpsetProvider that can also operate on the sets it
+// did not return, by their name.
+type NamedIpsetProvider interface {
+	IpsetProvider
+	// GetIpset returns the set with the given name
+	GetIpset(name string) Ipset
+	// ListIpset returns the entries of the set with the given name
+	ListIpset(name string) ([]string, error)
+}
+
+// AddEntries adds entries to a set, at once if the set supports batches.
+func AddEntries(set Ipset, entries []string, timeout int) error {
+
+	if batch, ok := set.(BatchIpset); ok {
+		return batch.AddBatch(entries, timeout)
+	}
+
+	for _, entry := range entries {
+		if err := set.Add(entry, timeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type goIpsetProvider struct{}
 
 // NewIpset returns an IpsetProvider interface based on the go-ipset
@@ -36,3 +79,16 @@ func (i *goIpsetProvider) DestroyAll() error {
 func NewGoIPsetProvider() IpsetProvider {
 	return &goIpsetProvider{}
 }
+
+// NewIpsetProvider returns the netlink ipset provider if the kernel supports
+// it, and the go-ipset provider otherwise.
+func NewIpsetProvider() IpsetProvider {
+
+	ips, err := NewNetlinkIpsetProvider()
+	if err != nil {
+		zap.L().Debug("Using the ipset command", zap.Error(err))
+		return NewGoIPsetProvider()
+	}
+
+	return ips
+}
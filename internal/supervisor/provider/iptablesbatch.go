@@ -0,0 +1,206 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BatchProvider is an IptablesProvider that collects the new chains and the
+// rules added to them, and programs them at once when committed. Deletions
+// and reads are not batched and go directly to the underlying provider.
+type BatchProvider interface {
+	IptablesProvider
+	// Commit programs the collected chains and rules atomically. If the
+	// commit fails, whatever was programmed is removed.
+	Commit() error
+}
+
+// batchOperation is a chain or a rule collected by the batch
+type batchOperation struct {
+	table    string
+	chain    string
+	newChain bool
+	rule     string
+	rulespec []string
+}
+
+type iptablesBatch struct {
+	ipt        IptablesProvider
+	restorer   IptablesRestorer
+	tables     []string
+	operations []*batchOperation
+}
+
+// NewBatchProvider returns a BatchProvider that commits the rules with the
+// restorer and removes them with the given provider on failure.
+func NewBatchProvider(ipt IptablesProvider, restorer IptablesRestorer) BatchProvider {
+
+	return &iptablesBatch{
+		ipt:        ipt,
+		restorer:   restorer,
+		tables:     []string{},
+		operations: []*batchOperation{},
+	}
+}
+
+// Append collects a rule appended to a chain
+func (b *iptablesBatch) Append(table, chain string, rulespec ...string) error {
+
+	b.add(&batchOperation{
+		table:    table,
+		chain:    chain,
+		rule:     "-A " + chain + " " + restoreArgs(rulespec),
+		rulespec: rulespec,
+	})
+
+	return nil
+}
+
+// Insert collects a rule inserted in a chain
+func (b *iptablesBatch) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	b.add(&batchOperation{
+		table:    table,
+		chain:    chain,
+		rule:     "-I " + chain + " " + strconv.Itoa(pos) + " " + restoreArgs(rulespec),
+		rulespec: rulespec,
+	})
+
+	return nil
+}
+
+// NewChain collects a new chain
+func (b *iptablesBatch) NewChain(table, chain string) error {
+
+	b.add(&batchOperation{
+		table:    table,
+		chain:    chain,
+		newChain: true,
+	})
+
+	return nil
+}
+
+// Delete deletes a rule with the underlying provider
+func (b *iptablesBatch) Delete(table, chain string, rulespec ...string) error {
+	return b.ipt.Delete(table, chain, rulespec...)
+}
+
+// Exists checks a rule with the underlying provider
+func (b *iptablesBatch) Exists(table, chain string, rulespec ...string) (bool, error) {
+	return b.ipt.Exists(table, chain, rulespec...)
+}
+
+// List lists the rules of a chain with the underlying provider
+func (b *iptablesBatch) List(table, chain string) ([]string, error) {
+	return b.ipt.List(table, chain)
+}
+
+// ListChains lists the chains with the underlying provider
+func (b *iptablesBatch) ListChains(table string) ([]string, error) {
+	return b.ipt.ListChains(table)
+}
+
+// ClearChain clears a chain with the underlying provider
+func (b *iptablesBatch) ClearChain(table, chain string) error {
+	return b.ipt.ClearChain(table, chain)
+}
+
+// DeleteChain deletes a chain with the underlying provider
+func (b *iptablesBatch) DeleteChain(table, chain string) error {
+	return b.ipt.DeleteChain(table, chain)
+}
+
+// Commit implements the BatchProvider interface
+func (b *iptablesBatch) Commit() error {
+
+	if len(b.operations) == 0 {
+		return nil
+	}
+
+	if err := b.restorer.Restore(b.input()); err != nil {
+		b.rollback()
+		return fmt.Errorf("unable to commit iptables rules: %s", err)
+	}
+
+	b.operations = []*batchOperation{}
+
+	return nil
+}
+
+func (b *iptablesBatch) add(op *batchOperation) {
+
+	for _, table := range b.tables {
+		if table == op.table {
+			b.operations = append(b.operations, op)
+			return
+		}
+	}
+
+	b.tables = append(b.tables, op.table)
+	b.operations = append(b.operations, op)
+}
+
+// input returns the iptables-restore input of the collected operations. The
+// chains of a table are declared before its rules.
+func (b *iptablesBatch) input() string {
+
+	lines := []string{}
+
+	for _, table := range b.tables {
+
+		chains := []string{}
+		rules := []string{}
+
+		for _, op := range b.operations {
+			if op.table != table {
+				continue
+			}
+			if op.newChain {
+				chains = append(chains, ":"+op.chain+" - [0:0]")
+			} else {
+				rules = append(rules, op.rule)
+			}
+		}
+
+		lines = append(lines, "*"+table)
+		lines = append(lines, chains...)
+		lines = append(lines, rules...)
+		lines = append(lines, "COMMIT")
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// rollback removes the rules and the chains of the batch in reverse order.
+// iptables-restore commits each table separately, so a failed commit may
+// have programmed some of them.
+func (b *iptablesBatch) rollback() {
+
+	for idx := len(b.operations) - 1; idx >= 0; idx-- {
+		op := b.operations[idx]
+		if op.newChain {
+			b.ipt.ClearChain(op.table, op.chain)  // nolint
+			b.ipt.DeleteChain(op.table, op.chain) // nolint
+			continue
+		}
+		b.ipt.Delete(op.table, op.chain, op.rulespec...) // nolint
+	}
+
+	b.operations = []*batchOperation{}
+}
+
+// restoreArgs quotes the arguments of a rule for iptables-restore
+func restoreArgs(rulespec []string) string {
+
+	args := make([]string, len(rulespec))
+	for idx, arg := range rulespec {
+		if arg == "" || strings.ContainsAny(arg, " \t\"") {
+			arg = strconv.Quote(arg)
+		}
+		args[idx] = arg
+	}
+
+	return strings.Join(args, " ")
+}
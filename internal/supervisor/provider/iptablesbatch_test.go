@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testRestorer struct {
+	input string
+	err   error
+}
+
+func (r *testRestorer) Restore(input string) error {
+	r.input = input
+	return r.err
+}
+
+func TestBatchCommit(t *testing.T) {
+
+	Convey("Given a batch provider", t, func() {
+
+		ipt := NewTestIptablesProvider()
+		restorer := &testRestorer{}
+		batch := NewBatchProvider(ipt, restorer)
+
+		So(batch.NewChain("mangle", "TRIREME-App"), ShouldBeNil)
+		So(batch.Append("mangle", "TRIREME-App", "-p", "tcp", "-j", "ACCEPT"), ShouldBeNil)
+		So(batch.Insert("mangle", "OUTPUT", 1, "-j", "TRIREME-App"), ShouldBeNil)
+		So(batch.Append("nat", "RedirProxy-App", "-m", "comment", "--comment", "proxy rule", "-j", "ACCEPT"), ShouldBeNil)
+
+		Convey("When I commit it, all the rules should be restored at once", func() {
+			So(batch.Commit(), ShouldBeNil)
+			So(restorer.input, ShouldEqual, `*mangle
+:TRIREME-App - [0:0]
+-A TRIREME-App -p tcp -j ACCEPT
+-I OUTPUT 1 -j TRIREME-App
+COMMIT
+*nat
+-A RedirProxy-App -m comment --comment "proxy rule" -j ACCEPT
+COMMIT
+`)
+		})
+
+		Convey("When the commit fails, the rules and chains should be removed", func() {
+
+			deleted := []string{}
+			ipt.MockDelete(t, func(table, chain string, rulespec ...string) error {
+				deleted = append(deleted, table+":"+chain)
+				return nil
+			})
+			ipt.MockDeleteChain(t, func(table, chain string) error {
+				deleted = append(deleted, table+":"+chain+":chain")
+				return nil
+			})
+
+			restorer.err = errors.New("restore error")
+			So(batch.Commit(), ShouldNotBeNil)
+			So(deleted, ShouldResemble, []string{
+				"nat:RedirProxy-App",
+				"mangle:OUTPUT",
+				"mangle:TRIREME-App",
+				"mangle:TRIREME-App:chain",
+			})
+		})
+	})
+
+	Convey("Given an empty batch, the commit should not restore anything", t, func() {
+		restorer := &testRestorer{err: errors.New("restore error")}
+		So(NewBatchProvider(NewTestIptablesProvider(), restorer).Commit(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,548 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IptablesSaver is implemented by the providers that can render the rules
+// they hold.
+type IptablesSaver interface {
+	// Save returns the rules of all the tables in the format of iptables-save
+	Save() string
+}
+
+// memoryTable holds the chains of a table. The builtin chains always exist.
+type memoryTable struct {
+	name   string
+	chains map[string][][]string
+}
+
+type memoryIptablesProvider struct {
+	tables map[string]*memoryTable
+	sync.Mutex
+}
+
+// NewMemoryIPTablesProvider returns an IptablesProvider that keeps the chains
+// and the rules in memory instead of programming them in the kernel. It
+// enforces the constraints of iptables on the chains, so that the rules it
+// accepts could be programmed. It is also an IptablesRestorer and an
+// IptablesSaver.
+func NewMemoryIPTablesProvider() IptablesProvider {
+
+	return &memoryIptablesProvider{
+		tables: map[string]*memoryTable{},
+	}
+}
+
+// Append implements the IptablesProvider interface
+func (m *memoryIptablesProvider) Append(table, chain string, rulespec ...string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return appendMemoryRule(m.tables, table, chain, rulespec)
+}
+
+// Insert implements the IptablesProvider interface
+func (m *memoryIptablesProvider) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return insertMemoryRule(m.tables, table, chain, pos, rulespec)
+}
+
+// Delete implements the IptablesProvider interface
+func (m *memoryIptablesProvider) Delete(table, chain string, rulespec ...string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return deleteMemoryRule(m.tables, table, chain, rulespec)
+}
+
+// Exists implements the IptablesProvider interface
+func (m *memoryIptablesProvider) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	rules, err := memoryChain(m.tables, table, chain)
+	if err != nil {
+		return false, err
+	}
+
+	return memoryRuleIndex(rules, rulespec) >= 0, nil
+}
+
+// List implements the IptablesProvider interface. The rules are returned in
+// the format of iptables -S.
+func (m *memoryIptablesProvider) List(table, chain string) ([]string, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	rules, err := memoryChain(m.tables, table, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []string{}
+	if isBuiltinChain(table, chain) {
+		list = append(list, "-P "+chain+" ACCEPT")
+	} else {
+		list = append(list, "-N "+chain)
+	}
+
+	for _, rule := range rules {
+		list = append(list, "-A "+chain+" "+restoreArgs(rule))
+	}
+
+	return list, nil
+}
+
+// ListChains implements the IptablesProvider interface
+func (m *memoryIptablesProvider) ListChains(table string) ([]string, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	t, err := memoryTableOf(m.tables, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.chainNames(), nil
+}
+
+// ClearChain implements the IptablesProvider interface. The chain is created
+// if it does not exist.
+func (m *memoryIptablesProvider) ClearChain(table, chain string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	t, err := memoryTableOf(m.tables, table)
+	if err != nil {
+		return err
+	}
+
+	t.chains[chain] = [][]string{}
+
+	return nil
+}
+
+// DeleteChain implements the IptablesProvider interface
+func (m *memoryIptablesProvider) DeleteChain(table, chain string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return deleteMemoryChain(m.tables, table, chain)
+}
+
+// NewChain implements the IptablesProvider interface
+func (m *memoryIptablesProvider) NewChain(table, chain string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	return newMemoryChain(m.tables, table, chain)
+}
+
+// Restore implements the IptablesRestorer interface. Nothing is changed if
+// a line of the input fails.
+func (m *memoryIptablesProvider) Restore(input string) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	tables := copyMemoryTables(m.tables)
+	table := ""
+
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+
+		case strings.HasPrefix(line, "*"):
+			if _, err := memoryTableOf(tables, line[1:]); err != nil {
+				return err
+			}
+			table = line[1:]
+
+		case line == "COMMIT":
+			if table == "" {
+				return fmt.Errorf("COMMIT outside of a table")
+			}
+			table = ""
+
+		case table == "":
+			return fmt.Errorf("line outside of a table: %s", line)
+
+		case strings.HasPrefix(line, ":"):
+			// A declared user chain is created, or flushed if it exists
+			fields := strings.Fields(line[1:])
+			if len(fields) == 0 {
+				return fmt.Errorf("invalid line: %s", line)
+			}
+			if !isBuiltinChain(table, fields[0]) {
+				tables[table].chains[fields[0]] = [][]string{}
+			}
+
+		default:
+			if err := restoreMemoryLine(tables, table, line); err != nil {
+				return err
+			}
+		}
+	}
+
+	if table != "" {
+		return fmt.Errorf("missing COMMIT for table %s", table)
+	}
+
+	m.tables = tables
+
+	return nil
+}
+
+// Save implements the IptablesSaver interface. The tables that were never
+// used are not returned. The user chains are sorted by name, so that the
+// output does not depend on the order the chains were created in.
+func (m *memoryIptablesProvider) Save() string {
+
+	m.Lock()
+	defer m.Unlock()
+
+	names := []string{}
+	for name := range m.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+
+	for _, name := range names {
+		t := m.tables[name]
+		chains := t.chainNames()
+
+		b.WriteString("*" + name + "\n")
+		for _, chain := range chains {
+			policy := "-"
+			if isBuiltinChain(name, chain) {
+				policy = "ACCEPT"
+			}
+			b.WriteString(":" + chain + " " + policy + " [0:0]\n")
+		}
+		for _, chain := range chains {
+			for _, rule := range t.chains[chain] {
+				b.WriteString("-A " + chain + " " + restoreArgs(rule) + "\n")
+			}
+		}
+		b.WriteString("COMMIT\n")
+	}
+
+	return b.String()
+}
+
+// chainNames returns the builtin chains in the order of iptables followed by
+// the sorted user chains
+func (t *memoryTable) chainNames() []string {
+
+	names := []string{}
+	user := []string{}
+
+	for _, chain := range nftBuiltinOrder {
+		if _, ok := t.chains[chain]; ok {
+			names = append(names, chain)
+		}
+	}
+
+	for chain := range t.chains {
+		if !isBuiltinChain(t.name, chain) {
+			user = append(user, chain)
+		}
+	}
+	sort.Strings(user)
+
+	return append(names, user...)
+}
+
+// isBuiltinChain returns true if a chain is a builtin chain of a table
+func isBuiltinChain(table, chain string) bool {
+
+	_, ok := nftBuiltinChains[table][chain]
+	return ok
+}
+
+// isChainTarget returns true if the target of a rule is a chain rather than
+// a verdict or a target extension
+func isChainTarget(target string) bool {
+
+	switch target {
+	case "", "ACCEPT", "DROP", "RETURN", "QUEUE":
+		return false
+	}
+
+	if _, ok := nftTargets[target]; ok {
+		return false
+	}
+
+	return !nftUnsupportedTargets[target]
+}
+
+// restoreMemoryLine applies a command line of an iptables-restore input
+func restoreMemoryLine(tables map[string]*memoryTable, table, line string) error {
+
+	args, err := restoreLineArgs(line)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("invalid line: %s", line)
+	}
+
+	chain := args[1]
+
+	switch args[0] {
+	case "-A", "--append":
+		return appendMemoryRule(tables, table, chain, args[2:])
+	case "-I", "--insert":
+		pos := 1
+		if len(args) > 2 {
+			if p, err := strconv.Atoi(args[2]); err == nil {
+				pos = p
+				args = append(args[:2], args[3:]...)
+			}
+		}
+		return insertMemoryRule(tables, table, chain, pos, args[2:])
+	case "-D", "--delete":
+		return deleteMemoryRule(tables, table, chain, args[2:])
+	case "-N", "--new-chain":
+		return newMemoryChain(tables, table, chain)
+	case "-F", "--flush":
+		if _, err := memoryChain(tables, table, chain); err != nil {
+			return err
+		}
+		tables[table].chains[chain] = [][]string{}
+		return nil
+	case "-X", "--delete-chain":
+		return deleteMemoryChain(tables, table, chain)
+	default:
+		return fmt.Errorf("unsupported command %s: %s", args[0], line)
+	}
+}
+
+// memoryTableOf returns a table and creates it with its builtin chains if it
+// was never used
+func memoryTableOf(tables map[string]*memoryTable, table string) (*memoryTable, error) {
+
+	if t, ok := tables[table]; ok {
+		return t, nil
+	}
+
+	builtins, ok := nftBuiltinChains[table]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+
+	t := &memoryTable{
+		name:   table,
+		chains: map[string][][]string{},
+	}
+	for chain := range builtins {
+		t.chains[chain] = [][]string{}
+	}
+	tables[table] = t
+
+	return t, nil
+}
+
+// memoryChain returns the rules of an existing chain
+func memoryChain(tables map[string]*memoryTable, table, chain string) ([][]string, error) {
+
+	t, err := memoryTableOf(tables, table)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, ok := t.chains[chain]
+	if !ok {
+		return nil, fmt.Errorf("chain %s of table %s does not exist", chain, table)
+	}
+
+	return rules, nil
+}
+
+// memoryRuleIndex returns the index of a rule in a chain, or -1
+func memoryRuleIndex(rules [][]string, rulespec []string) int {
+
+	key := strings.Join(rulespec, "\x00")
+	for idx, rule := range rules {
+		if strings.Join(rule, "\x00") == key {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// memoryTarget returns the chain a rule jumps or goes to
+func memoryTarget(rulespec []string) string {
+
+	for idx := 0; idx < len(rulespec)-1; idx++ {
+		switch rulespec[idx] {
+		case "-j", "--jump", "-g", "--goto":
+			return rulespec[idx+1]
+		}
+	}
+
+	return ""
+}
+
+// checkMemoryRule verifies that the chain of a rule exists and that it jumps
+// to an existing chain if its target is a chain of the table
+func checkMemoryRule(tables map[string]*memoryTable, table, chain string, rulespec []string) error {
+
+	if _, err := memoryChain(tables, table, chain); err != nil {
+		return err
+	}
+
+	target := memoryTarget(rulespec)
+	if !isChainTarget(target) {
+		return nil
+	}
+
+	if target == chain {
+		return fmt.Errorf("chain %s of table %s cannot jump to itself", chain, table)
+	}
+
+	if _, ok := tables[table].chains[target]; !ok || isBuiltinChain(table, target) {
+		return fmt.Errorf("target %s of the rule of chain %s of table %s does not exist", target, chain, table)
+	}
+
+	return nil
+}
+
+func appendMemoryRule(tables map[string]*memoryTable, table, chain string, rulespec []string) error {
+
+	if err := checkMemoryRule(tables, table, chain, rulespec); err != nil {
+		return err
+	}
+
+	t := tables[table]
+	t.chains[chain] = append(t.chains[chain], append([]string{}, rulespec...))
+
+	return nil
+}
+
+func insertMemoryRule(tables map[string]*memoryTable, table, chain string, pos int, rulespec []string) error {
+
+	if err := checkMemoryRule(tables, table, chain, rulespec); err != nil {
+		return err
+	}
+
+	t := tables[table]
+	rules := t.chains[chain]
+
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("invalid position %d in chain %s of table %s", pos, chain, table)
+	}
+
+	inserted := make([][]string, 0, len(rules)+1)
+	inserted = append(inserted, rules[:pos-1]...)
+	inserted = append(inserted, append([]string{}, rulespec...))
+	t.chains[chain] = append(inserted, rules[pos-1:]...)
+
+	return nil
+}
+
+func deleteMemoryRule(tables map[string]*memoryTable, table, chain string, rulespec []string) error {
+
+	rules, err := memoryChain(tables, table, chain)
+	if err != nil {
+		return err
+	}
+
+	idx := memoryRuleIndex(rules, rulespec)
+	if idx < 0 {
+		return fmt.Errorf("rule %s does not exist in chain %s of table %s", restoreArgs(rulespec), chain, table)
+	}
+
+	deleted := make([][]string, 0, len(rules)-1)
+	deleted = append(deleted, rules[:idx]...)
+	tables[table].chains[chain] = append(deleted, rules[idx+1:]...)
+
+	return nil
+}
+
+func newMemoryChain(tables map[string]*memoryTable, table, chain string) error {
+
+	t, err := memoryTableOf(tables, table)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := t.chains[chain]; ok {
+		return fmt.Errorf("chain %s of table %s already exists", chain, table)
+	}
+
+	t.chains[chain] = [][]string{}
+
+	return nil
+}
+
+// deleteMemoryChain deletes an empty user chain that no rule refers to
+func deleteMemoryChain(tables map[string]*memoryTable, table, chain string) error {
+
+	rules, err := memoryChain(tables, table, chain)
+	if err != nil {
+		return err
+	}
+
+	t := tables[table]
+
+	if isBuiltinChain(table, chain) {
+		return fmt.Errorf("builtin chain %s of table %s cannot be deleted", chain, table)
+	}
+
+	if len(rules) > 0 {
+		return fmt.Errorf("chain %s of table %s is not empty", chain, table)
+	}
+
+	for name, rules := range t.chains {
+		for _, rule := range rules {
+			if memoryTarget(rule) == chain {
+				return fmt.Errorf("chain %s of table %s is referenced by chain %s", chain, table, name)
+			}
+		}
+	}
+
+	delete(t.chains, chain)
+
+	return nil
+}
+
+// copyMemoryTables returns a copy of the tables that can be changed without
+// changing the original. The rules are not changed in place, so they are
+// shared.
+func copyMemoryTables(tables map[string]*memoryTable) map[string]*memoryTable {
+
+	c := make(map[string]*memoryTable, len(tables))
+
+	for name, t := range tables {
+		chains := make(map[string][][]string, len(t.chains))
+		for chain, rules := range t.chains {
+			chains[chain] = rules
+		}
+		c[name] = &memoryTable{name: name, chains: chains}
+	}
+
+	return c
+}
@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryIptablesRules(t *testing.T) {
+
+	Convey("Given a memory iptables provider", t, func() {
+
+		ipt := NewMemoryIPTablesProvider()
+
+		So(ipt.NewChain("mangle", "TRIREME-App"), ShouldBeNil)
+		So(ipt.Append("mangle", "TRIREME-App", "-p", "tcp", "-j", "ACCEPT"), ShouldBeNil)
+		So(ipt.Append("mangle", "TRIREME-App", "-p", "udp", "-j", "DROP"), ShouldBeNil)
+
+		Convey("When I insert a rule, it should be at its position", func() {
+			So(ipt.Insert("mangle", "TRIREME-App", 2, "-p", "icmp", "-j", "ACCEPT"), ShouldBeNil)
+
+			rules, err := ipt.List("mangle", "TRIREME-App")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, []string{
+				"-N TRIREME-App",
+				"-A TRIREME-App -p tcp -j ACCEPT",
+				"-A TRIREME-App -p icmp -j ACCEPT",
+				"-A TRIREME-App -p udp -j DROP",
+			})
+
+			So(ipt.Insert("mangle", "TRIREME-App", 5, "-j", "DROP"), ShouldNotBeNil)
+		})
+
+		Convey("When I delete a rule, it should not exist", func() {
+			So(ipt.Delete("mangle", "TRIREME-App", "-p", "tcp", "-j", "ACCEPT"), ShouldBeNil)
+
+			exists, err := ipt.Exists("mangle", "TRIREME-App", "-p", "tcp", "-j", "ACCEPT")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+
+			So(ipt.Delete("mangle", "TRIREME-App", "-p", "tcp", "-j", "ACCEPT"), ShouldNotBeNil)
+		})
+
+		Convey("When I add a rule to a chain that does not exist, I should get an error", func() {
+			So(ipt.Append("mangle", "TRIREME-Net", "-j", "ACCEPT"), ShouldNotBeNil)
+			So(ipt.Append("security", "INPUT", "-j", "ACCEPT"), ShouldNotBeNil)
+		})
+
+		Convey("When I jump to a chain that does not exist, I should get an error", func() {
+			So(ipt.Append("mangle", "OUTPUT", "-j", "TRIREME-Net"), ShouldNotBeNil)
+			So(ipt.Append("mangle", "OUTPUT", "-j", "NFQUEUE", "--queue-num", "0"), ShouldBeNil)
+		})
+
+		Convey("When I delete a chain that is referenced or not empty, I should get an error", func() {
+			So(ipt.Append("mangle", "OUTPUT", "-j", "TRIREME-App"), ShouldBeNil)
+			So(ipt.ClearChain("mangle", "TRIREME-App"), ShouldBeNil)
+			So(ipt.DeleteChain("mangle", "TRIREME-App"), ShouldNotBeNil)
+
+			So(ipt.Delete("mangle", "OUTPUT", "-j", "TRIREME-App"), ShouldBeNil)
+			So(ipt.DeleteChain("mangle", "TRIREME-App"), ShouldBeNil)
+			So(ipt.DeleteChain("mangle", "OUTPUT"), ShouldNotBeNil)
+
+			chains, err := ipt.ListChains("mangle")
+			So(err, ShouldBeNil)
+			So(chains, ShouldResemble, []string{"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING"})
+		})
+
+		Convey("When I create a chain that exists, I should get an error", func() {
+			So(ipt.NewChain("mangle", "TRIREME-App"), ShouldNotBeNil)
+			So(ipt.NewChain("mangle", "INPUT"), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMemoryIptablesRestore(t *testing.T) {
+
+	Convey("Given a memory iptables provider with rules", t, func() {
+
+		ipt := NewMemoryIPTablesProvider()
+		restorer := ipt.(IptablesRestorer)
+
+		So(ipt.NewChain("mangle", "TRIREME-App"), ShouldBeNil)
+		So(ipt.Append("mangle", "TRIREME-App", "-j", "DROP"), ShouldBeNil)
+
+		Convey("When I restore rules, the declared chains should be flushed", func() {
+			So(restorer.Restore(`*mangle
+:OUTPUT ACCEPT [0:0]
+:TRIREME-App - [0:0]
+:TRIREME-Net - [0:0]
+-A TRIREME-App -m comment --comment "app rule" -j ACCEPT
+-I OUTPUT 1 -j TRIREME-App
+-A INPUT -j TRIREME-Net
+COMMIT
+`), ShouldBeNil)
+
+			rules, err := ipt.List("mangle", "TRIREME-App")
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, []string{
+				"-N TRIREME-App",
+				`-A TRIREME-App -m comment --comment "app rule" -j ACCEPT`,
+			})
+
+			exists, err := ipt.Exists("mangle", "INPUT", "-j", "TRIREME-Net")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+		})
+
+		Convey("When a line of the input fails, nothing should be restored", func() {
+			So(restorer.Restore(`*mangle
+:TRIREME-Net - [0:0]
+-A OUTPUT -j TRIREME-Net
+COMMIT
+*nat
+-A TRIREME-Proxy -j ACCEPT
+COMMIT
+`), ShouldNotBeNil)
+
+			chains, err := ipt.ListChains("mangle")
+			So(err, ShouldBeNil)
+			So(chains, ShouldNotContain, "TRIREME-Net")
+
+			exists, err := ipt.Exists("mangle", "OUTPUT", "-j", "TRIREME-Net")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		})
+
+		Convey("When the input is invalid, I should get an error", func() {
+			So(restorer.Restore("-A OUTPUT -j ACCEPT\n"), ShouldNotBeNil)
+			So(restorer.Restore("*mangle\n-A OUTPUT -j ACCEPT\n"), ShouldNotBeNil)
+			So(restorer.Restore("*mangle\n-Z OUTPUT\nCOMMIT\n"), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMemoryIptablesSave(t *testing.T) {
+
+	Convey("Given a memory iptables provider with rules", t, func() {
+
+		ipt := NewMemoryIPTablesProvider()
+
+		So(ipt.NewChain("nat", "RedirProxy-App"), ShouldBeNil)
+		So(ipt.Append("nat", "RedirProxy-App", "-p", "tcp", "-j", "REDIRECT", "--to-port", "5000"), ShouldBeNil)
+		So(ipt.NewChain("mangle", "TRIREME-Net"), ShouldBeNil)
+		So(ipt.NewChain("mangle", "TRIREME-App"), ShouldBeNil)
+		So(ipt.Append("mangle", "TRIREME-App", "-m", "comment", "--comment", "app rule", "-j", "ACCEPT"), ShouldBeNil)
+		So(ipt.Insert("mangle", "OUTPUT", 1, "-j", "TRIREME-App"), ShouldBeNil)
+
+		Convey("When I save them, I should get the tables in the format of iptables-save", func() {
+			So(ipt.(IptablesSaver).Save(), ShouldEqual, `*mangle
+:PREROUTING ACCEPT [0:0]
+:INPUT ACCEPT [0:0]
+:FORWARD ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+:POSTROUTING ACCEPT [0:0]
+:TRIREME-App - [0:0]
+:TRIREME-Net - [0:0]
+-A OUTPUT -j TRIREME-App
+-A TRIREME-App -m comment --comment "app rule" -j ACCEPT
+COMMIT
+*nat
+:PREROUTING ACCEPT [0:0]
+:INPUT ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+:POSTROUTING ACCEPT [0:0]
+:RedirProxy-App - [0:0]
+-A RedirProxy-App -p tcp -j REDIRECT --to-port 5000
+COMMIT
+`)
+		})
+
+		Convey("When I restore the saved rules in another provider, it should save the same rules", func() {
+			saved := ipt.(IptablesSaver).Save()
+
+			other := NewMemoryIPTablesProvider()
+			So(other.(IptablesRestorer).Restore(saved), ShouldBeNil)
+			So(other.(IptablesSaver).Save(), ShouldEqual, saved)
+		})
+	})
+}
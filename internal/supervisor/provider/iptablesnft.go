@@ -0,0 +1,712 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// Kernel nf_tables netlink definitions from linux/netfilter/nf_tables.h
+const (
+	nfnlSubsysNftables = 10
+
+	nftMsgNewTable = 0
+	nftMsgNewChain = 3
+	nftMsgGetChain = 4
+	nftMsgDelChain = 5
+	nftMsgNewRule  = 6
+	nftMsgGetRule  = 7
+	nftMsgDelRule  = 8
+
+	nftaTableName = 1
+
+	nftaChainTable  = 1
+	nftaChainName   = 3
+	nftaChainHook   = 4
+	nftaChainType   = 7
+	nftaHookHooknum = 1
+	nftaHookPrio    = 2
+
+	nftaRuleTable       = 1
+	nftaRuleChain       = 2
+	nftaRuleHandle      = 3
+	nftaRuleExpressions = 4
+	nftaRuleCompat      = 5
+	nftaRulePosition    = 6
+	nftaRuleUserdata    = 7
+
+	nlmFExcl   = 0x200
+	nlmFCreate = 0x400
+	nlmFAppend = 0x800
+
+	nfInetPreRouting  = 0
+	nfInetLocalIn     = 1
+	nfInetForward     = 2
+	nfInetLocalOut    = 3
+	nfInetPostRouting = 4
+)
+
+// nftMessage is an nf_tables message of a batch
+type nftMessage struct {
+	msg   uint16
+	flags uint16
+	attrs []byte
+}
+
+// nftSocket sends the nf_tables messages to the kernel
+type nftSocket interface {
+	// batch sends the messages in a single transaction. The kernel applies
+	// all of them or none.
+	batch(msgs []nftMessage) error
+	// dump returns the attributes of the objects of a get request
+	dump(msg uint16, attrs []byte) ([][]byte, error)
+	// setIndex returns the index of an ipset in the kernel
+	setIndex(name string) (uint16, error)
+}
+
+// nftBuiltinChain is a chain created by iptables in a table
+type nftBuiltinChain struct {
+	hook     uint32
+	priority int32
+	typ      string
+}
+
+// nftBuiltinChains are the chains of the tables with their hooks, as created
+// by iptables-nft
+var nftBuiltinChains = map[string]map[string]nftBuiltinChain{
+	"filter": {
+		"INPUT":   {nfInetLocalIn, 0, "filter"},
+		"FORWARD": {nfInetForward, 0, "filter"},
+		"OUTPUT":  {nfInetLocalOut, 0, "filter"},
+	},
+	"mangle": {
+		"PREROUTING":  {nfInetPreRouting, -150, "filter"},
+		"INPUT":       {nfInetLocalIn, -150, "filter"},
+		"FORWARD":     {nfInetForward, -150, "filter"},
+		"OUTPUT":      {nfInetLocalOut, -150, "route"},
+		"POSTROUTING": {nfInetPostRouting, -150, "filter"},
+	},
+	"nat": {
+		"PREROUTING":  {nfInetPreRouting, -100, "nat"},
+		"INPUT":       {nfInetLocalIn, 100, "nat"},
+		"OUTPUT":      {nfInetLocalOut, -100, "nat"},
+		"POSTROUTING": {nfInetPostRouting, 100, "nat"},
+	},
+	"raw": {
+		"PREROUTING": {nfInetPreRouting, -300, "filter"},
+		"OUTPUT":     {nfInetLocalOut, -300, "filter"},
+	},
+}
+
+// nftBuiltinOrder is the order iptables lists the builtin chains in
+var nftBuiltinOrder = []string{"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING"}
+
+type nftIptablesProvider struct {
+	// IptablesProvider runs the iptables command for the rules that are not
+	// translated and to list the rules
+	IptablesProvider
+	restorer IptablesRestorer
+	socket   nftSocket
+	// chains are the chains known to exist, by table
+	chains map[string]map[string]bool
+	sync.Mutex
+}
+
+// NewNftIPTablesProvider returns an IptablesProvider that programs the rules
+// of iptables-nft with netlink messages instead of running the iptables
+// command. The rules are written in the nf_tables tables of iptables with the
+// same expressions as iptables-nft, so the iptables command still lists them.
+// The rules with extensions that are not translated, and the listing of the
+// rules, fall back to the iptables command. It is only available when
+// iptables uses nf_tables.
+func NewNftIPTablesProvider() (IptablesProvider, error) {
+
+	out, err := exec.Command("iptables", "-V").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the version of iptables: %s", err)
+	}
+
+	if !strings.Contains(string(out), "nf_tables") {
+		return nil, fmt.Errorf("iptables does not use nf_tables: %s", strings.TrimSpace(string(out)))
+	}
+
+	ipt, err := NewGoIPTablesProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := newNftSocket()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open nf_tables socket: %s", err)
+	}
+
+	return newNftIPTablesProvider(ipt, socket), nil
+}
+
+func newNftIPTablesProvider(ipt IptablesProvider, socket nftSocket) *nftIptablesProvider {
+
+	restorer, _ := ipt.(IptablesRestorer)
+
+	return &nftIptablesProvider{
+		IptablesProvider: ipt,
+		restorer:         restorer,
+		socket:           socket,
+		chains:           map[string]map[string]bool{},
+	}
+}
+
+// Append implements the IptablesProvider interface
+func (p *nftIptablesProvider) Append(table, chain string, rulespec ...string) error {
+
+	msg, err := p.ruleMessage(table, chain, rulespec, nlmFAppend, 0)
+	if err != nil {
+		if _, ok := err.(*unsupportedRuleError); ok {
+			return p.IptablesProvider.Append(table, chain, rulespec...)
+		}
+		return err
+	}
+
+	return p.apply(table, []string{chain}, []nftMessage{msg})
+}
+
+// Insert implements the IptablesProvider interface. The rule is inserted
+// before the rule at the position.
+func (p *nftIptablesProvider) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	flags, handle := uint16(0), uint64(0)
+
+	if pos > 1 {
+		rules, err := p.rules(table, chain)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case pos-1 < len(rules):
+			handle = rules[pos-1].handle
+		case pos-1 == len(rules):
+			flags = nlmFAppend
+		default:
+			return fmt.Errorf("index of insertion too big: %d", pos)
+		}
+	}
+
+	msg, err := p.ruleMessage(table, chain, rulespec, flags, handle)
+	if err != nil {
+		if _, ok := err.(*unsupportedRuleError); ok {
+			return p.IptablesProvider.Insert(table, chain, pos, rulespec...)
+		}
+		return err
+	}
+
+	return p.apply(table, []string{chain}, []nftMessage{msg})
+}
+
+// Delete implements the IptablesProvider interface. The rules that were not
+// programmed by the provider are deleted with the iptables command.
+func (p *nftIptablesProvider) Delete(table, chain string, rulespec ...string) error {
+
+	rule, err := p.find(table, chain, rulespec)
+	if err != nil {
+		return err
+	}
+
+	if rule == nil {
+		return p.IptablesProvider.Delete(table, chain, rulespec...)
+	}
+
+	return p.batch([]nftMessage{{
+		msg: nftMsgDelRule,
+		attrs: nlAttrs(
+			nlAttrString(nftaRuleTable, table),
+			nlAttrString(nftaRuleChain, chain),
+			nlAttr(nftaRuleHandle, nftBE64(rule.handle)),
+		),
+	}})
+}
+
+// Exists implements the IptablesProvider interface. The rules that were not
+// programmed by the provider are checked with the iptables command.
+func (p *nftIptablesProvider) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	rule, err := p.find(table, chain, rulespec)
+	if err != nil {
+		return false, err
+	}
+
+	if rule != nil {
+		return true, nil
+	}
+
+	return p.IptablesProvider.Exists(table, chain, rulespec...)
+}
+
+// ListChains implements the IptablesProvider interface. Like iptables, the
+// builtin chains of the table are always listed first.
+func (p *nftIptablesProvider) ListChains(table string) ([]string, error) {
+
+	existing, err := p.listChains(table)
+	if err != nil {
+		return nil, err
+	}
+
+	chains := []string{}
+	for _, chain := range nftBuiltinOrder {
+		if _, ok := nftBuiltinChains[table][chain]; ok {
+			chains = append(chains, chain)
+		}
+	}
+
+	for _, chain := range existing {
+		if _, ok := nftBuiltinChains[table][chain]; !ok {
+			chains = append(chains, chain)
+		}
+	}
+
+	return chains, nil
+}
+
+// ClearChain implements the IptablesProvider interface. The chain is created
+// if it does not exist.
+func (p *nftIptablesProvider) ClearChain(table, chain string) error {
+
+	msgs := []nftMessage{}
+	if _, ok := nftBuiltinChains[table][chain]; !ok {
+		msgs = append(msgs, nftChainMessage(table, chain, nlmFCreate))
+	}
+	msgs = append(msgs, nftFlushMessage(table, chain))
+
+	return p.apply(table, []string{chain}, msgs)
+}
+
+// DeleteChain implements the IptablesProvider interface
+func (p *nftIptablesProvider) DeleteChain(table, chain string) error {
+
+	if err := p.batch([]nftMessage{{
+		msg: nftMsgDelChain,
+		attrs: nlAttrs(
+			nlAttrString(nftaChainTable, table),
+			nlAttrString(nftaChainName, chain),
+		),
+	}}); err != nil {
+		return err
+	}
+
+	p.Lock()
+	delete(p.chains[table], chain)
+	p.Unlock()
+
+	return nil
+}
+
+// NewChain implements the IptablesProvider interface. It fails if the chain
+// exists.
+func (p *nftIptablesProvider) NewChain(table, chain string) error {
+
+	return p.apply(table, nil, []nftMessage{nftChainMessage(table, chain, nlmFCreate|nlmFExcl)})
+}
+
+// Restore implements the IptablesRestorer interface. The input is programmed
+// in a single transaction. It is restored with iptables-restore if it has
+// rules that are not translated.
+func (p *nftIptablesProvider) Restore(input string) error {
+
+	msgs, err := p.restoreMessages(input)
+	if err != nil {
+		if _, ok := err.(*unsupportedRuleError); !ok {
+			return err
+		}
+		if p.restorer == nil {
+			return fmt.Errorf("iptables-restore is required: %s", err)
+		}
+		zap.L().Debug("Restoring iptables rules with iptables-restore", zap.Error(err))
+		return p.restorer.Restore(input)
+	}
+
+	return p.batch(msgs)
+}
+
+// restoreMessages translates an iptables-restore input. Like iptables-restore
+// with --noflush, a declared chain is created or flushed.
+func (p *nftIptablesProvider) restoreMessages(input string) ([]nftMessage, error) {
+
+	msgs := []nftMessage{}
+	table := ""
+	tableMsgs := []nftMessage{}
+	chains := []string{}
+
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+
+		case strings.HasPrefix(line, "*"):
+			table, tableMsgs, chains = line[1:], []nftMessage{}, []string{}
+
+		case line == "COMMIT":
+			if table == "" {
+				return nil, fmt.Errorf("COMMIT outside of a table")
+			}
+			prepare, err := p.prepare(table, chains)
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, prepare...)
+			msgs = append(msgs, tableMsgs...)
+			table = ""
+
+		case table == "":
+			return nil, fmt.Errorf("line outside of a table: %s", line)
+
+		case strings.HasPrefix(line, ":"):
+			chain := strings.Fields(line[1:])[0]
+			if _, ok := nftBuiltinChains[table][chain]; ok {
+				chains = append(chains, chain)
+				break
+			}
+			tableMsgs = append(tableMsgs, nftChainMessage(table, chain, nlmFCreate), nftFlushMessage(table, chain))
+
+		default:
+			args, err := restoreLineArgs(line)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) < 2 {
+				return nil, fmt.Errorf("invalid line: %s", line)
+			}
+
+			flags := uint16(nlmFAppend)
+			switch args[0] {
+			case "-A", "--append":
+			case "-I", "--insert":
+				flags = 0
+				if len(args) > 2 {
+					if pos, err := strconv.Atoi(args[2]); err == nil {
+						// The rules of the transaction are not known yet
+						if pos != 1 {
+							return nil, &unsupportedRuleError{option: "-I " + args[1] + " " + args[2]}
+						}
+						args = append(args[:2], args[3:]...)
+					}
+				}
+			default:
+				return nil, &unsupportedRuleError{option: args[0]}
+			}
+
+			msg, err := p.ruleMessage(table, args[1], args[2:], flags, 0)
+			if err != nil {
+				return nil, err
+			}
+			chains = append(chains, args[1])
+			tableMsgs = append(tableMsgs, msg)
+		}
+	}
+
+	if table != "" {
+		return nil, fmt.Errorf("missing COMMIT for table %s", table)
+	}
+
+	return msgs, nil
+}
+
+// apply sends the messages of a table, after the messages that create the
+// table and the builtin chains used by the messages
+func (p *nftIptablesProvider) apply(table string, chains []string, msgs []nftMessage) error {
+
+	prepare, err := p.prepare(table, chains)
+	if err != nil {
+		return err
+	}
+
+	return p.batch(append(prepare, msgs...))
+}
+
+// batch sends the messages in a transaction. The chains are listed again
+// after a failure since the cached ones may not have been created.
+func (p *nftIptablesProvider) batch(msgs []nftMessage) error {
+
+	if err := p.socket.batch(msgs); err != nil {
+		p.Lock()
+		p.chains = map[string]map[string]bool{}
+		p.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// prepare returns the messages that create the table and the builtin chains
+// that do not exist. iptables-nft only creates them when they are used.
+func (p *nftIptablesProvider) prepare(table string, chains []string) ([]nftMessage, error) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	msgs := []nftMessage{}
+
+	existing, ok := p.chains[table]
+	if !ok {
+		names, err := p.listChains(table)
+		if err != nil {
+			return nil, err
+		}
+
+		existing = map[string]bool{}
+		for _, name := range names {
+			existing[name] = true
+		}
+		p.chains[table] = existing
+
+		msgs = append(msgs, nftMessage{
+			msg:   nftMsgNewTable,
+			flags: nlmFCreate,
+			attrs: nlAttrString(nftaTableName, table),
+		})
+	}
+
+	for _, chain := range chains {
+		builtin, ok := nftBuiltinChains[table][chain]
+		if !ok || existing[chain] {
+			continue
+		}
+
+		msgs = append(msgs, nftMessage{
+			msg:   nftMsgNewChain,
+			flags: nlmFCreate,
+			attrs: nlAttrs(
+				nlAttrString(nftaChainTable, table),
+				nlAttrString(nftaChainName, chain),
+				nlAttr(nftaChainHook|nlaFNested,
+					nlAttr(nftaHookHooknum, nftBE32(builtin.hook)),
+					nlAttr(nftaHookPrio, nftBE32(uint32(builtin.priority))),
+				),
+				nlAttrString(nftaChainType, builtin.typ),
+			),
+		})
+
+		// A chain created twice is only updated
+		existing[chain] = true
+	}
+
+	return msgs, nil
+}
+
+// listChains returns the chains of a table in the kernel
+func (p *nftIptablesProvider) listChains(table string) ([]string, error) {
+
+	chains, err := p.socket.dump(nftMsgGetChain, nlAttrString(nftaChainTable, table))
+	if err != nil {
+		if err == syscall.ENOENT {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("unable to list chains of table %s: %s", table, err)
+	}
+
+	names := []string{}
+	for _, chain := range chains {
+		attrs := nlParseAttrs(chain)
+		if nlString(attrs[nftaChainTable]) != table {
+			continue
+		}
+		names = append(names, nlString(attrs[nftaChainName]))
+	}
+
+	return names, nil
+}
+
+// nftRuleRef is a rule of a chain in the kernel
+type nftRuleRef struct {
+	handle   uint64
+	userdata []byte
+}
+
+// rules returns the rules of a chain in the kernel
+func (p *nftIptablesProvider) rules(table, chain string) ([]nftRuleRef, error) {
+
+	dump, err := p.socket.dump(nftMsgGetRule, nlAttrs(
+		nlAttrString(nftaRuleTable, table),
+		nlAttrString(nftaRuleChain, chain),
+	))
+	if err != nil {
+		if err == syscall.ENOENT {
+			return []nftRuleRef{}, nil
+		}
+		return nil, fmt.Errorf("unable to list rules of chain %s: %s", chain, err)
+	}
+
+	rules := []nftRuleRef{}
+	for _, rule := range dump {
+		attrs := nlParseAttrs(rule)
+		if nlString(attrs[nftaRuleTable]) != table || nlString(attrs[nftaRuleChain]) != chain || len(attrs[nftaRuleHandle]) != 8 {
+			continue
+		}
+		rules = append(rules, nftRuleRef{
+			handle:   binary.BigEndian.Uint64(attrs[nftaRuleHandle]),
+			userdata: attrs[nftaRuleUserdata],
+		})
+	}
+
+	return rules, nil
+}
+
+// find returns the first rule of a chain programmed by the provider with the
+// rulespec, or nil
+func (p *nftIptablesProvider) find(table, chain string, rulespec []string) (*nftRuleRef, error) {
+
+	rules, err := p.rules(table, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := nftRuleTag(rulespec)
+	for idx := range rules {
+		if hasNftRuleTag(rules[idx].userdata, tag) {
+			return &rules[idx], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ruleMessage returns the message that adds a rule. With a handle, the rule is
+// added before the rule with this handle, or after it with nlmFAppend.
+func (p *nftIptablesProvider) ruleMessage(table, chain string, rulespec []string, flags uint16, handle uint64) (nftMessage, error) {
+
+	rule, err := translateRule(rulespec, p.socket.setIndex)
+	if err != nil {
+		return nftMessage{}, err
+	}
+
+	attrs := [][]byte{
+		nlAttrString(nftaRuleTable, table),
+		nlAttrString(nftaRuleChain, chain),
+	}
+
+	if handle != 0 {
+		attrs = append(attrs, nlAttr(nftaRulePosition, nftBE64(handle)))
+	}
+
+	attrs = append(attrs, nlAttr(nftaRuleExpressions|nlaFNested, rule.exprs...))
+
+	if rule.compat != nil {
+		attrs = append(attrs, nlAttr(nftaRuleCompat|nlaFNested, rule.compat))
+	}
+
+	attrs = append(attrs, nlAttr(nftaRuleUserdata, rule.tag))
+
+	return nftMessage{
+		msg:   nftMsgNewRule,
+		flags: nlmFCreate | flags,
+		attrs: nlAttrs(attrs...),
+	}, nil
+}
+
+// nftChainMessage returns the message that creates a chain
+func nftChainMessage(table, chain string, flags uint16) nftMessage {
+
+	return nftMessage{
+		msg:   nftMsgNewChain,
+		flags: flags,
+		attrs: nlAttrs(
+			nlAttrString(nftaChainTable, table),
+			nlAttrString(nftaChainName, chain),
+		),
+	}
+}
+
+// nftFlushMessage returns the message that deletes the rules of a chain
+func nftFlushMessage(table, chain string) nftMessage {
+
+	return nftMessage{
+		msg: nftMsgDelRule,
+		attrs: nlAttrs(
+			nlAttrString(nftaRuleTable, table),
+			nlAttrString(nftaRuleChain, chain),
+		),
+	}
+}
+
+// nftRequest returns a netlink message of nf_tables
+func nftRequest(msg nftMessage, seq uint32) []byte {
+
+	return nfnlMessage(nfnlSubsysNftables<<8|msg.msg, nlmFRequest|msg.flags, seq, nfprotoIPv4, 0, msg.attrs)
+}
+
+// nfnlMessage returns a netfilter netlink message
+func nfnlMessage(typ, flags uint16, seq uint32, family uint8, resID uint16, attrs []byte) []byte {
+
+	msg := make([]byte, nlmsgHeaderLen+nfgenmsgLen, nlmsgHeaderLen+nfgenmsgLen+len(attrs))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(nlmsgHeaderLen+nfgenmsgLen+len(attrs)))
+	binary.LittleEndian.PutUint16(msg[4:6], typ)
+	binary.LittleEndian.PutUint16(msg[6:8], flags)
+	binary.LittleEndian.PutUint32(msg[8:12], seq)
+	msg[nlmsgHeaderLen] = family
+	binary.BigEndian.PutUint16(msg[nlmsgHeaderLen+2:nlmsgHeaderLen+4], resID)
+
+	return append(msg, attrs...)
+}
+
+// restoreLineArgs splits a line of an iptables-restore input. The arguments
+// can be quoted like restoreArgs does.
+func restoreLineArgs(line string) ([]string, error) {
+
+	args := []string{}
+
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		end := 1
+		for end < len(line) && line[end] != '"' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(line) {
+			return nil, fmt.Errorf("invalid quoted argument: %s", line)
+		}
+
+		arg := line[:end+1]
+		value, err := strconv.Unquote(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted argument: %s", arg)
+		}
+		args = append(args, value)
+		line = line[len(arg):]
+	}
+}
+
+// nlString returns the value of a string attribute
+func nlString(b []byte) string {
+
+	return string(bytes.TrimRight(b, "\x00"))
+}
+
+func nftBE64(v uint64) []byte {
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+
+	return b
+}
@@ -0,0 +1,258 @@
+// +build linux
+
+package provider
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	nfnlMsgBatchBegin = 0x10
+	nfnlMsgBatchEnd   = 0x11
+
+	nlmFDump = 0x300
+
+	// The batches of a restore can be much larger than the default buffer
+	nftSendBufferSize = 16 << 20
+
+	// ipset getsockopt interface from linux/netfilter/ipset/ip_set.h
+	soIPSet          = 83
+	ipsetOpVersion   = 0x100
+	ipsetOpGetByName = 0x0006
+	ipsetMaxNameLen  = 32
+	ipsetInvalidID   = 0xffff
+)
+
+// netlinkNftSocket is a netfilter netlink socket for the nf_tables messages
+type netlinkNftSocket struct {
+	fd  int
+	seq uint32
+	buf []byte
+	sync.Mutex
+}
+
+// newNftSocket opens a netfilter netlink socket. It requires CAP_NET_ADMIN to
+// send the messages.
+func newNftSocket() (nftSocket, error) {
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+	}); err != nil {
+		syscall.Close(fd) // nolint
+		return nil, err
+	}
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUFFORCE, nftSendBufferSize); err != nil {
+		syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, nftSendBufferSize) // nolint
+	}
+
+	syscall.SetsockoptInt(fd, solNetlink, netlinkCapAck, 1) // nolint
+
+	return &netlinkNftSocket{
+		fd:  fd,
+		buf: make([]byte, 1<<16),
+	}, nil
+}
+
+// batch sends the messages between the begin and the end of a batch. The
+// kernel processes the batch before the send returns, so the errors are
+// already queued on the socket. The messages are not acked otherwise.
+func (s *netlinkNftSocket) batch(msgs []nftMessage) error {
+
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.drain() // nolint
+
+	first := s.seq + 1
+
+	b := []byte{}
+	s.seq++
+	b = append(b, nfnlMessage(nfnlMsgBatchBegin, nlmFRequest, s.seq, syscall.AF_UNSPEC, nfnlSubsysNftables, nil)...)
+	for _, msg := range msgs {
+		s.seq++
+		b = append(b, nftRequest(msg, s.seq)...)
+	}
+	s.seq++
+	b = append(b, nfnlMessage(nfnlMsgBatchEnd, nlmFRequest, s.seq, syscall.AF_UNSPEC, nfnlSubsysNftables, nil)...)
+
+	if err := syscall.Sendto(s.fd, b, 0, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+	}); err != nil {
+		return fmt.Errorf("unable to send nf_tables batch: %s", err)
+	}
+
+	errs, err := s.drain()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range errs {
+		if e.seq >= first && e.seq <= s.seq {
+			return fmt.Errorf("nf_tables message %d of %d failed: %s", e.seq-first, len(msgs), e.err)
+		}
+	}
+
+	return nil
+}
+
+type nftError struct {
+	seq uint32
+	err error
+}
+
+// drain returns the errors queued on the socket without waiting
+func (s *netlinkNftSocket) drain() ([]nftError, error) {
+
+	errs := []nftError{}
+
+	for {
+		n, _, err := syscall.Recvfrom(s.fd, s.buf, syscall.MSG_DONTWAIT)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+				return errs, nil
+			}
+			if err == syscall.EINTR {
+				continue
+			}
+			return nil, fmt.Errorf("unable to receive nf_tables reply: %s", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(s.buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("invalid nf_tables reply: %s", err)
+		}
+
+		for _, msg := range msgs {
+			if msg.Header.Type != syscall.NLMSG_ERROR || len(msg.Data) < 4 {
+				continue
+			}
+			if code := int32(binary.LittleEndian.Uint32(msg.Data[0:4])); code != 0 {
+				seq := msg.Header.Seq
+				if len(msg.Data) >= 4+nlmsgHeaderLen {
+					seq = binary.LittleEndian.Uint32(msg.Data[12:16])
+				}
+				errs = append(errs, nftError{seq: seq, err: syscall.Errno(-code)})
+			}
+		}
+	}
+}
+
+// dump sends a get request and returns the attributes of the objects until
+// the end of the dump
+func (s *netlinkNftSocket) dump(msg uint16, attrs []byte) ([][]byte, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.seq++
+
+	if err := syscall.Sendto(s.fd, nftRequest(nftMessage{msg: msg, flags: nlmFDump, attrs: attrs}, s.seq), 0, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+	}); err != nil {
+		return nil, fmt.Errorf("unable to send nf_tables request: %s", err)
+	}
+
+	objects := [][]byte{}
+
+	for {
+		n, _, err := syscall.Recvfrom(s.fd, s.buf, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return nil, fmt.Errorf("unable to receive nf_tables reply: %s", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(s.buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("invalid nf_tables reply: %s", err)
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != s.seq {
+				continue
+			}
+
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return objects, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("invalid nf_tables error")
+				}
+				if code := int32(binary.LittleEndian.Uint32(m.Data[0:4])); code != 0 {
+					return nil, syscall.Errno(-code)
+				}
+				return objects, nil
+			default:
+				if len(m.Data) >= nfgenmsgLen {
+					objects = append(objects, append([]byte{}, m.Data[nfgenmsgLen:]...))
+				}
+			}
+		}
+	}
+}
+
+// setIndex returns the index of an ipset with the getsockopt interface of
+// ipset, which the set match of xtables uses
+func (s *netlinkNftSocket) setIndex(name string) (uint16, error) {
+
+	if len(name) >= ipsetMaxNameLen {
+		return 0, fmt.Errorf("invalid set name %s", name)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.IPPROTO_RAW)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.Close(fd) // nolint
+
+	version := make([]byte, 8)
+	binary.LittleEndian.PutUint32(version[0:4], ipsetOpVersion)
+	if err := getsockopt(fd, syscall.SOL_IP, soIPSet, version); err != nil {
+		return 0, fmt.Errorf("unable to get ipset version: %s", err)
+	}
+
+	req := make([]byte, 8+ipsetMaxNameLen)
+	binary.LittleEndian.PutUint32(req[0:4], ipsetOpGetByName)
+	copy(req[4:8], version[4:8])
+	copy(req[8:], name)
+	if err := getsockopt(fd, syscall.SOL_IP, soIPSet, req); err != nil {
+		return 0, err
+	}
+
+	index := binary.LittleEndian.Uint16(req[8:10])
+	if index == ipsetInvalidID {
+		return 0, fmt.Errorf("set does not exist")
+	}
+
+	return index, nil
+}
+
+func getsockopt(fd, level, name int, b []byte) error {
+
+	l := uint32(len(b))
+
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(unsafe.Pointer(&b[0])), uintptr(unsafe.Pointer(&l)), 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package provider
+
+import "errors"
+
+// newNftSocket is not supported on this platform
+func newNftSocket() (nftSocket, error) {
+	return nil, errors.New("nf_tables netlink is not supported on this platform")
+}
@@ -0,0 +1,315 @@
+package provider
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testNftSocket records the batches and returns the given objects of the dumps
+type testNftSocket struct {
+	batches [][]nftMessage
+	dumps   map[uint16][][]byte
+	err     error
+}
+
+func (s *testNftSocket) batch(msgs []nftMessage) error {
+	s.batches = append(s.batches, msgs)
+	return s.err
+}
+
+func (s *testNftSocket) dump(msg uint16, attrs []byte) ([][]byte, error) {
+	return s.dumps[msg], nil
+}
+
+func (s *testNftSocket) setIndex(name string) (uint16, error) {
+	if name != "set" {
+		return 0, errors.New("set does not exist")
+	}
+	return 3, nil
+}
+
+// exprNames returns the names of the expressions of a rule message
+func exprNames(msg nftMessage) []string {
+
+	names := []string{}
+
+	b := nlParseAttrs(msg.attrs)[nftaRuleExpressions]
+	for len(b) >= 4 {
+		length := int(binary.LittleEndian.Uint16(b[0:2]))
+		names = append(names, nlString(nlParseAttrs(b[4:length])[nftaExprName]))
+		b = b[(length+3)&^3:]
+	}
+
+	return names
+}
+
+func TestTranslateRule(t *testing.T) {
+
+	Convey("Given the index of the sets", t, func() {
+		s := &testNftSocket{}
+
+		Convey("A rule with addresses and a protocol should use the native expressions", func() {
+			rule, err := translateRule([]string{"-p", "tcp", "-s", "10.0.0.0/8", "!", "-d", "10.1.1.1", "-j", "ACCEPT"}, s.setIndex)
+			So(err, ShouldBeNil)
+			So(len(rule.exprs), ShouldEqual, 9)
+			So(rule.compat, ShouldNotBeNil)
+		})
+
+		Convey("The options of the protocol should load its match", func() {
+			r, err := parseNftRule([]string{"-p", "tcp", "--dport", "80", "-j", "ACCEPT"})
+			So(err, ShouldBeNil)
+			So(len(r.matches), ShouldEqual, 1)
+			So(r.matches[0].name, ShouldEqual, "tcp")
+			So(r.matches[0].implicit, ShouldBeTrue)
+		})
+
+		Convey("The options should be applied to the last match that has them", func() {
+			r, err := parseNftRule([]string{"-m", "mark", "--mark", "0x40", "-m", "connmark", "!", "--mark", "0x10", "-j", "ACCEPT"})
+			So(err, ShouldBeNil)
+			So(len(r.matches[0].options), ShouldEqual, 1)
+			So(r.matches[1].options[0].invert, ShouldBeTrue)
+		})
+
+		Convey("The options of the targets can be abbreviated", func() {
+			r, err := parseNftRule([]string{"-p", "tcp", "-j", "REDIRECT", "--to-port", "8080"})
+			So(err, ShouldBeNil)
+			So(r.target.options[0].name, ShouldEqual, "--to-ports")
+		})
+
+		Convey("The set match should use the index of the set", func() {
+			info, rev, err := encodeNftSet(nil, []nftOption{{name: "--match-set", args: []string{"set", "dst,src"}, invert: true}}, s.setIndex)
+			So(err, ShouldBeNil)
+			So(rev, ShouldEqual, 1)
+			So(info, ShouldResemble, []byte{3, 0, 2, 0x05})
+
+			_, err = translateRule([]string{"-m", "set", "--match-set", "foo", "src", "-j", "ACCEPT"}, s.setIndex)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The mark target should set the mask of --set-mark", func() {
+			info, rev, err := encodeNftMarkTarget(nil, []nftOption{{name: "--set-mark", args: []string{"0x40"}}}, s.setIndex)
+			So(err, ShouldBeNil)
+			So(rev, ShouldEqual, 2)
+			So(info, ShouldResemble, []byte{0x40, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})
+		})
+
+		Convey("The limit match should encode the rate and the burst", func() {
+			info, rev, err := encodeNftLimit(nil, []nftOption{{name: "--limit", args: []string{"10/sec"}}, {name: "--limit-burst", args: []string{"20"}}}, s.setIndex)
+			So(err, ShouldBeNil)
+			So(rev, ShouldEqual, 0)
+			So(info[0:8], ShouldResemble, []byte{0xe8, 0x03, 0, 0, 20, 0, 0, 0})
+
+			_, err = translateRule([]string{"-m", "limit", "--limit", "10/fortnight", "-j", "ACCEPT"}, s.setIndex)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The rules with untranslated options or targets should be unsupported", func() {
+			for _, rulespec := range [][]string{
+				{"-m", "hashlimit", "--hashlimit-upto", "1/sec", "-j", "ACCEPT"},
+				{"-p", "tcp", "-j", "REJECT"},
+				{"-p", "tcp", "--foo", "-j", "ACCEPT"},
+				{"-s", "2001:db8::1", "-j", "ACCEPT"},
+			} {
+				_, err := translateRule(rulespec, s.setIndex)
+				So(err, ShouldHaveSameTypeAs, &unsupportedRuleError{})
+			}
+		})
+
+		Convey("Invalid rules should be rejected", func() {
+			_, err := translateRule([]string{"-m", "mark", "--mark", "foo", "-j", "ACCEPT"}, s.setIndex)
+			So(err, ShouldNotBeNil)
+			_, err = translateRule([]string{"-j"}, s.setIndex)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("The tag of a rule should identify its rulespec", t, func() {
+		tag := nftRuleTag([]string{"-j", "ACCEPT"})
+		So(hasNftRuleTag(append([]byte{0, 1, 'x'}, tag...), tag), ShouldBeTrue)
+		So(hasNftRuleTag(tag, nftRuleTag([]string{"-j", "DROP"})), ShouldBeFalse)
+		So(hasNftRuleTag(nil, tag), ShouldBeFalse)
+	})
+}
+
+func TestRestoreLineArgs(t *testing.T) {
+
+	Convey("The arguments quoted for iptables-restore should be split back", t, func() {
+		rulespec := []string{"-m", "comment", "--comment", "a \"quoted\" comment", "-j", "ACCEPT"}
+		args, err := restoreLineArgs("-A chain " + restoreArgs(rulespec))
+		So(err, ShouldBeNil)
+		So(args, ShouldResemble, append([]string{"-A", "chain"}, rulespec...))
+
+		_, err = restoreLineArgs("-A chain \"unterminated")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseRule(t *testing.T) {
+
+	Convey("The listed rules should be parsed", t, func() {
+		chain, rulespec, err := ParseRule("-A INPUT -m comment --comment \"Container-specific-chain\" -j TRIREME-Net-cont-0")
+		So(err, ShouldBeNil)
+		So(chain, ShouldEqual, "INPUT")
+		So(rulespec, ShouldResemble, []string{"-m", "comment", "--comment", "Container-specific-chain", "-j", "TRIREME-Net-cont-0"})
+
+		_, _, err = ParseRule("-P INPUT ACCEPT")
+		So(err, ShouldNotBeNil)
+
+		_, _, err = ParseRule("-N TRIREME-Net-cont-0")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestNftIptablesProvider(t *testing.T) {
+
+	Convey("Given an nf_tables provider", t, func() {
+		ipt := NewTestIptablesProvider()
+		socket := &testNftSocket{dumps: map[uint16][][]byte{}}
+		restorer := &testRestorer{}
+		p := newNftIPTablesProvider(ipt, socket)
+		p.restorer = restorer
+
+		Convey("When I append the first rule to a builtin chain, the table and the chain should be created", func() {
+			So(p.Append("mangle", "OUTPUT", "-p", "tcp", "-j", "ACCEPT"), ShouldBeNil)
+			So(len(socket.batches), ShouldEqual, 1)
+			msgs := socket.batches[0]
+			So(len(msgs), ShouldEqual, 3)
+			So(msgs[0].msg, ShouldEqual, nftMsgNewTable)
+			So(msgs[1].msg, ShouldEqual, nftMsgNewChain)
+			So(nlString(nlParseAttrs(msgs[1].attrs)[nftaChainType]), ShouldEqual, "route")
+			So(msgs[2].msg, ShouldEqual, nftMsgNewRule)
+			So(msgs[2].flags, ShouldEqual, nlmFCreate|nlmFAppend)
+			So(exprNames(msgs[2]), ShouldResemble, []string{"payload", "cmp", "counter", "immediate"})
+
+			Convey("When I append another rule, only the rule should be sent", func() {
+				So(p.Append("mangle", "OUTPUT", "-j", "MARK", "--set-mark", "0x40"), ShouldBeNil)
+				So(len(socket.batches[1]), ShouldEqual, 1)
+				So(exprNames(socket.batches[1][0]), ShouldResemble, []string{"counter", "target"})
+			})
+
+			Convey("When the batch fails, the chains should be listed again", func() {
+				socket.err = errors.New("batch error")
+				So(p.Append("mangle", "OUTPUT", "-j", "ACCEPT"), ShouldNotBeNil)
+				socket.err = nil
+				So(p.Append("mangle", "OUTPUT", "-j", "ACCEPT"), ShouldBeNil)
+				So(len(socket.batches[2]), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the builtin chain exists, it should not be created", func() {
+			socket.dumps[nftMsgGetChain] = [][]byte{
+				nlAttrs(nlAttrString(nftaChainTable, "filter"), nlAttrString(nftaChainName, "INPUT")),
+			}
+			So(p.Append("filter", "INPUT", "-j", "ACCEPT"), ShouldBeNil)
+			So(len(socket.batches[0]), ShouldEqual, 2)
+		})
+
+		Convey("When I append a rule that is not translated, it should be appended with iptables", func() {
+			appended := false
+			ipt.MockAppend(t, func(table, chain string, rulespec ...string) error {
+				appended = true
+				return nil
+			})
+			So(p.Append("filter", "INPUT", "-p", "tcp", "-j", "REJECT"), ShouldBeNil)
+			So(appended, ShouldBeTrue)
+			So(len(socket.batches), ShouldEqual, 0)
+		})
+
+		Convey("Given rules in a chain", func() {
+			rule := func(handle uint64, rulespec ...string) []byte {
+				return nlAttrs(
+					nlAttrString(nftaRuleTable, "filter"),
+					nlAttrString(nftaRuleChain, "chain"),
+					nlAttr(nftaRuleHandle, nftBE64(handle)),
+					nlAttr(nftaRuleUserdata, nftRuleTag(rulespec)),
+				)
+			}
+			socket.dumps[nftMsgGetRule] = [][]byte{rule(4, "-j", "ACCEPT"), rule(7, "-j", "DROP")}
+
+			Convey("When I delete a rule of the provider, it should be deleted by handle", func() {
+				So(p.Delete("filter", "chain", "-j", "DROP"), ShouldBeNil)
+				msg := socket.batches[0][0]
+				So(msg.msg, ShouldEqual, nftMsgDelRule)
+				So(nlParseAttrs(msg.attrs)[nftaRuleHandle], ShouldResemble, nftBE64(7))
+			})
+
+			Convey("When I delete another rule, it should be deleted with iptables", func() {
+				deleted := false
+				ipt.MockDelete(t, func(table, chain string, rulespec ...string) error {
+					deleted = true
+					return nil
+				})
+				So(p.Delete("filter", "chain", "-j", "RETURN"), ShouldBeNil)
+				So(deleted, ShouldBeTrue)
+			})
+
+			Convey("When I check a rule of the provider, it should exist", func() {
+				ok, err := p.Exists("filter", "chain", "-j", "ACCEPT")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("When I insert a rule, it should be inserted before the rule at the position", func() {
+				So(p.Insert("filter", "chain", 2, "-j", "RETURN"), ShouldBeNil)
+				msg := socket.batches[0][1]
+				So(msg.flags, ShouldEqual, nlmFCreate)
+				So(nlParseAttrs(msg.attrs)[nftaRulePosition], ShouldResemble, nftBE64(7))
+
+				So(p.Insert("filter", "chain", 3, "-j", "RETURN"), ShouldBeNil)
+				So(socket.batches[1][0].flags, ShouldEqual, nlmFCreate|nlmFAppend)
+
+				So(p.Insert("filter", "chain", 5, "-j", "RETURN"), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I list the chains, the builtin chains should be listed first", func() {
+			socket.dumps[nftMsgGetChain] = [][]byte{
+				nlAttrs(nlAttrString(nftaChainTable, "nat"), nlAttrString(nftaChainName, "proxy")),
+				nlAttrs(nlAttrString(nftaChainTable, "nat"), nlAttrString(nftaChainName, "OUTPUT")),
+				nlAttrs(nlAttrString(nftaChainTable, "mangle"), nlAttrString(nftaChainName, "other")),
+			}
+			chains, err := p.ListChains("nat")
+			So(err, ShouldBeNil)
+			So(chains, ShouldResemble, []string{"PREROUTING", "INPUT", "OUTPUT", "POSTROUTING", "proxy"})
+		})
+
+		Convey("When I restore rules, they should be sent in a single batch", func() {
+			So(p.Restore(`*mangle
+:TRIREME-App - [0:0]
+-A TRIREME-App -m comment --comment "a rule" -j ACCEPT
+-I OUTPUT 1 -j TRIREME-App
+COMMIT
+*nat
+-A proxy -j ACCEPT
+COMMIT
+`), ShouldBeNil)
+			So(len(socket.batches), ShouldEqual, 1)
+			msgs := []uint16{}
+			for _, msg := range socket.batches[0] {
+				msgs = append(msgs, msg.msg)
+			}
+			So(msgs, ShouldResemble, []uint16{
+				nftMsgNewTable, nftMsgNewChain, nftMsgNewChain, nftMsgDelRule, nftMsgNewRule, nftMsgNewRule,
+				nftMsgNewTable, nftMsgNewRule,
+			})
+			So(restorer.input, ShouldEqual, "")
+		})
+
+		Convey("When I restore rules that are not translated, they should be restored with iptables-restore", func() {
+			input := "*filter\n-A INPUT -j LOG\nCOMMIT\n"
+			So(p.Restore(input), ShouldBeNil)
+			So(restorer.input, ShouldEqual, input)
+			So(len(socket.batches), ShouldEqual, 0)
+		})
+
+		Convey("When I restore an invalid input, it should fail", func() {
+			So(p.Restore("*filter\n-A INPUT -j ACCEPT\n"), ShouldNotBeNil)
+			So(p.Restore("-A INPUT -j ACCEPT\n"), ShouldNotBeNil)
+			So(len(socket.batches), ShouldEqual, 0)
+			So(restorer.input, ShouldEqual, "")
+		})
+	})
+}
@@ -0,0 +1,1454 @@
+package provider
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Kernel nf_tables definitions from linux/netfilter/nf_tables.h
+const (
+	nftaListElem = 1
+
+	nftaExprName = 1
+	nftaExprData = 2
+
+	nftaPayloadDreg   = 1
+	nftaPayloadBase   = 2
+	nftaPayloadOffset = 3
+	nftaPayloadLen    = 4
+
+	nftPayloadNetworkHeader = 1
+
+	nftaMetaDreg = 1
+	nftaMetaKey  = 2
+
+	nftMetaIifname = 6
+	nftMetaOifname = 7
+
+	nftaCmpSreg = 1
+	nftaCmpOp   = 2
+	nftaCmpData = 3
+
+	nftCmpEq  = 0
+	nftCmpNeq = 1
+
+	nftaBitwiseSreg = 1
+	nftaBitwiseDreg = 2
+	nftaBitwiseLen  = 3
+	nftaBitwiseMask = 4
+	nftaBitwiseXor  = 5
+
+	nftaImmediateDreg = 1
+	nftaImmediateData = 2
+
+	nftaDataValue   = 1
+	nftaDataVerdict = 2
+
+	nftaVerdictCode  = 1
+	nftaVerdictChain = 2
+
+	nftaCounterBytes   = 1
+	nftaCounterPackets = 2
+
+	nftaCompatName = 1
+	nftaCompatRev  = 2
+	nftaCompatInfo = 3
+
+	nftaRuleCompatProto = 1
+	nftaRuleCompatFlags = 2
+	nftRuleCompatFInv   = 1 << 1
+
+	nftRegVerdict = 0
+	nftReg1       = 1
+
+	nfDrop    = 0
+	nfAccept  = 1
+	nftJump   = -3
+	nftGoto   = -4
+	nftReturn = -5
+
+	// ifNameSize is the size of the interface names of the kernel
+	ifNameSize = 16
+
+	// nftRuleTagType is the type of the userdata TLV that holds the tag of
+	// the rules programmed by the provider. iptables only knows the types 0
+	// and 1 and ignores the others.
+	nftRuleTagType = 0x54
+)
+
+// nftProtocols are the protocols that can be given by name
+var nftProtocols = map[string]uint8{
+	"icmp":    1,
+	"tcp":     ipprotoTCP,
+	"udp":     ipprotoUDP,
+	"sctp":    ipprotoSCTP,
+	"udplite": 136,
+}
+
+// nftUnsupportedTargets are the targets of iptables that the provider does
+// not translate. The other names that are not translated targets are chains.
+var nftUnsupportedTargets = map[string]bool{
+	"AUDIT": true, "CHECKSUM": true, "CLASSIFY": true, "CLUSTERIP": true,
+	"CONNMARK": true, "CONNSECMARK": true, "CT": true, "DNAT": true,
+	"ECN": true, "HMARK": true, "IDLETIMER": true, "LED": true, "LOG": true,
+	"MASQUERADE": true, "NETMAP": true, "NOTRACK": true, "RATEEST": true,
+	"REJECT": true, "SECMARK": true, "SET": true, "SNAT": true, "SYNPROXY": true,
+	"TCPMSS": true, "TCPOPTSTRIP": true, "TEE": true, "TOS": true, "TRACE": true,
+	"TTL": true, "ULOG": true,
+}
+
+// unsupportedRuleError is returned for the rules with options that cannot be
+// translated to nf_tables expressions
+type unsupportedRuleError struct {
+	option string
+}
+
+func (e *unsupportedRuleError) Error() string {
+	return "unsupported option " + e.option
+}
+
+// nftSetResolver returns the index of an ipset
+type nftSetResolver func(name string) (uint16, error)
+
+// nftOption is an option of a match or a target and its arguments
+type nftOption struct {
+	name   string
+	invert bool
+	args   []string
+}
+
+// nftExtension is a match or a target of iptables that is programmed with the
+// nf_tables compatibility layer
+type nftExtension struct {
+	// options are the number of arguments of the options, by name
+	options map[string]int
+	// aliases are the other names of the options
+	aliases map[string]string
+	// encode returns the xtables structure of the extension and its revision
+	encode func(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error)
+}
+
+// option returns the name of an option of the extension. Like iptables, the
+// options can be abbreviated.
+func (e *nftExtension) option(name string) (string, bool) {
+
+	if alias, ok := e.aliases[name]; ok {
+		return alias, true
+	}
+
+	if _, ok := e.options[name]; ok {
+		return name, true
+	}
+
+	found := ""
+	for option := range e.options {
+		if strings.HasPrefix(option, name) {
+			if found != "" {
+				return "", false
+			}
+			found = option
+		}
+	}
+
+	return found, found != ""
+}
+
+// nftExtensionSpec is a match or a target of a rule with its options
+type nftExtensionSpec struct {
+	name     string
+	ext      *nftExtension
+	implicit bool
+	options  []nftOption
+}
+
+// nftRuleSpec is a parsed iptables rule
+type nftRuleSpec struct {
+	proto    uint8
+	invProto bool
+	src      *net.IPNet
+	invSrc   bool
+	dst      *net.IPNet
+	invDst   bool
+	in       string
+	invIn    bool
+	out      string
+	invOut   bool
+	matches  []*nftExtensionSpec
+	target   *nftExtensionSpec
+	verdict  string
+	goTo     bool
+}
+
+// nftRule is a rule translated to nf_tables expressions
+type nftRule struct {
+	exprs  [][]byte
+	compat []byte
+	tag    []byte
+}
+
+// nftRuleTag returns the tag of a rulespec. It is stored in the userdata of
+// the rule to find it again.
+func nftRuleTag(rulespec []string) []byte {
+
+	sum := md5.Sum([]byte(strings.Join(rulespec, "\x00")))
+
+	return append([]byte{nftRuleTagType, byte(len(sum))}, sum[:]...)
+}
+
+// hasNftRuleTag returns true if the userdata of a rule holds the tag
+func hasNftRuleTag(userdata, tag []byte) bool {
+
+	for len(userdata) >= 2 {
+		length := 2 + int(userdata[1])
+		if length > len(userdata) {
+			return false
+		}
+		if string(userdata[:length]) == string(tag) {
+			return true
+		}
+		userdata = userdata[length:]
+	}
+
+	return false
+}
+
+// translateRule returns the nf_tables expressions of an iptables rule. An
+// unsupportedRuleError is returned if the rule uses options that are not
+// translated.
+func translateRule(rulespec []string, resolve nftSetResolver) (*nftRule, error) {
+
+	r, err := parseNftRule(rulespec)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &nftRule{
+		tag: nftRuleTag(rulespec),
+	}
+
+	if r.in != "" {
+		rule.exprs = append(rule.exprs, nftIfaceExprs(nftMetaIifname, r.in, r.invIn)...)
+	}
+
+	if r.out != "" {
+		rule.exprs = append(rule.exprs, nftIfaceExprs(nftMetaOifname, r.out, r.invOut)...)
+	}
+
+	if r.proto != 0 {
+		rule.exprs = append(rule.exprs,
+			nftPayloadExpr(nftPayloadNetworkHeader, 9, 1),
+			nftCmpExpr(nftCmpOp(r.invProto), []byte{r.proto}),
+		)
+
+		flags := uint32(0)
+		if r.invProto {
+			flags = nftRuleCompatFInv
+		}
+		rule.compat = nlAttr(nftaRuleCompatProto, nftBE32(uint32(r.proto)))
+		rule.compat = append(rule.compat, nlAttr(nftaRuleCompatFlags, nftBE32(flags))...)
+	}
+
+	if r.src != nil {
+		rule.exprs = append(rule.exprs, nftAddrExprs(12, r.src, r.invSrc)...)
+	}
+
+	if r.dst != nil {
+		rule.exprs = append(rule.exprs, nftAddrExprs(16, r.dst, r.invDst)...)
+	}
+
+	for _, m := range r.matches {
+		info, rev, err := m.ext.encode(r, m.options, resolve)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s match: %s", m.name, err)
+		}
+		rule.exprs = append(rule.exprs, nftCompatExpr("match", m.name, rev, info))
+	}
+
+	rule.exprs = append(rule.exprs, nftExpr("counter",
+		nlAttr(nftaCounterBytes, make([]byte, 8)),
+		nlAttr(nftaCounterPackets, make([]byte, 8)),
+	))
+
+	switch {
+	case r.target != nil:
+		info, rev, err := r.target.ext.encode(r, r.target.options, resolve)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s target: %s", r.target.name, err)
+		}
+		rule.exprs = append(rule.exprs, nftCompatExpr("target", r.target.name, rev, info))
+	case r.verdict == "ACCEPT":
+		rule.exprs = append(rule.exprs, nftVerdictExpr(nfAccept, ""))
+	case r.verdict == "DROP":
+		rule.exprs = append(rule.exprs, nftVerdictExpr(nfDrop, ""))
+	case r.verdict == "RETURN":
+		rule.exprs = append(rule.exprs, nftVerdictExpr(nftReturn, ""))
+	case r.verdict != "" && r.goTo:
+		rule.exprs = append(rule.exprs, nftVerdictExpr(nftGoto, r.verdict))
+	case r.verdict != "":
+		rule.exprs = append(rule.exprs, nftVerdictExpr(nftJump, r.verdict))
+	}
+
+	return rule, nil
+}
+
+// parseNftRule parses the options of an iptables rule. The options of the
+// matches and the target follow them, and a ! before an option inverts it.
+func parseNftRule(rulespec []string) (*nftRuleSpec, error) {
+
+	r := &nftRuleSpec{}
+	invert := false
+
+	for idx := 0; idx < len(rulespec); idx++ {
+		arg := rulespec[idx]
+
+		if arg == "!" {
+			invert = true
+			continue
+		}
+
+		if !strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("unexpected argument %s", arg)
+		}
+
+		value := func() (string, error) {
+			idx++
+			if idx >= len(rulespec) {
+				return "", fmt.Errorf("option %s requires an argument", arg)
+			}
+			return rulespec[idx], nil
+		}
+
+		switch arg {
+		case "-p", "--protocol":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			proto, err := parseNftProtocol(v)
+			if err != nil {
+				return nil, err
+			}
+			r.proto, r.invProto = proto, invert
+
+		case "-s", "--source", "--src", "-d", "--destination", "--dst":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			addr, err := parseNftAddress(v)
+			if err != nil {
+				return nil, err
+			}
+			// 0.0.0.0/0 matches all the addresses
+			if ones, _ := addr.Mask.Size(); ones == 0 && !invert {
+				addr = nil
+			}
+			if strings.HasPrefix(arg, "-s") || strings.HasPrefix(arg, "--s") {
+				r.src, r.invSrc = addr, invert
+			} else {
+				r.dst, r.invDst = addr, invert
+			}
+
+		case "-i", "--in-interface", "-o", "--out-interface":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if len(v) >= ifNameSize {
+				return nil, fmt.Errorf("invalid interface %s", v)
+			}
+			if arg == "-i" || arg == "--in-interface" {
+				r.in, r.invIn = v, invert
+			} else {
+				r.out, r.invOut = v, invert
+			}
+
+		case "-m", "--match":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			ext, ok := nftMatches[v]
+			if !ok {
+				return nil, &unsupportedRuleError{option: "-m " + v}
+			}
+			r.matches = append(r.matches, &nftExtensionSpec{name: v, ext: ext})
+
+		case "-j", "--jump", "-g", "--goto":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if r.target != nil || r.verdict != "" {
+				return nil, fmt.Errorf("multiple targets")
+			}
+			if ext, ok := nftTargets[v]; ok && arg != "-g" && arg != "--goto" {
+				r.target = &nftExtensionSpec{name: v, ext: ext}
+				break
+			}
+			if nftUnsupportedTargets[v] {
+				return nil, &unsupportedRuleError{option: "-j " + v}
+			}
+			r.verdict, r.goTo = v, arg == "-g" || arg == "--goto"
+
+		default:
+			spec, name := r.extensionOption(arg)
+			if spec == nil {
+				return nil, &unsupportedRuleError{option: arg}
+			}
+			option := nftOption{name: name, invert: invert}
+			for n := spec.ext.options[name]; n > 0; n-- {
+				v, err := value()
+				if err != nil {
+					return nil, err
+				}
+				option.args = append(option.args, v)
+			}
+			spec.options = append(spec.options, option)
+		}
+
+		invert = false
+	}
+
+	return r, nil
+}
+
+// extensionOption returns the extension of an option. Like iptables, the
+// match of the protocol of the rule is loaded if the option is one of its
+// options.
+func (r *nftRuleSpec) extensionOption(arg string) (*nftExtensionSpec, string) {
+
+	specs := []*nftExtensionSpec{}
+	if r.target != nil {
+		specs = append(specs, r.target)
+	}
+	for idx := len(r.matches) - 1; idx >= 0; idx-- {
+		specs = append(specs, r.matches[idx])
+	}
+
+	for _, spec := range specs {
+		if name, ok := spec.ext.option(arg); ok {
+			return spec, name
+		}
+	}
+
+	for name, proto := range nftProtocols {
+		ext, ok := nftMatches[name]
+		if !ok || proto != r.proto {
+			continue
+		}
+		option, ok := ext.option(arg)
+		if !ok {
+			continue
+		}
+		spec := &nftExtensionSpec{name: name, ext: ext, implicit: true}
+		r.matches = append(r.matches, spec)
+		return spec, option
+	}
+
+	return nil, ""
+}
+
+// parseNftProtocol returns the number of a protocol given by name or number
+func parseNftProtocol(v string) (uint8, error) {
+
+	v = strings.ToLower(v)
+
+	if v == "all" {
+		return 0, nil
+	}
+
+	if proto, ok := nftProtocols[v]; ok {
+		return proto, nil
+	}
+
+	proto, err := strconv.ParseUint(v, 10, 8)
+	if err != nil {
+		return 0, &unsupportedRuleError{option: "-p " + v}
+	}
+
+	return uint8(proto), nil
+}
+
+// parseNftAddress returns the network of an address with an optional prefix
+// length or mask
+func parseNftAddress(v string) (*net.IPNet, error) {
+
+	address, mask := v, ""
+	if slash := strings.Index(v, "/"); slash >= 0 {
+		address, mask = v[:slash], v[slash+1:]
+	}
+
+	ip := net.ParseIP(address).To4()
+	if ip == nil {
+		return nil, &unsupportedRuleError{option: "address " + v}
+	}
+
+	n := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+
+	switch {
+	case mask == "":
+	case strings.Contains(mask, "."):
+		m := net.ParseIP(mask).To4()
+		if m == nil {
+			return nil, fmt.Errorf("invalid mask %s", v)
+		}
+		n.Mask = net.IPMask(m)
+	default:
+		ones, err := strconv.Atoi(mask)
+		if err != nil || ones < 0 || ones > 32 {
+			return nil, fmt.Errorf("invalid mask %s", v)
+		}
+		n.Mask = net.CIDRMask(ones, 32)
+	}
+
+	n.IP = n.IP.Mask(n.Mask)
+
+	return n, nil
+}
+
+// nftCmpOp returns the comparison of an inverted option or not
+func nftCmpOp(invert bool) uint32 {
+
+	if invert {
+		return nftCmpNeq
+	}
+
+	return nftCmpEq
+}
+
+// nftIfaceExprs returns the expressions that match the name of an interface.
+// A name that ends with + matches the interfaces with this prefix.
+func nftIfaceExprs(key uint32, iface string, invert bool) [][]byte {
+
+	value := append([]byte(iface), 0)
+	if strings.HasSuffix(iface, "+") {
+		value = []byte(strings.TrimSuffix(iface, "+"))
+	}
+
+	return [][]byte{
+		nftExpr("meta", nlAttr(nftaMetaKey, nftBE32(key)), nlAttr(nftaMetaDreg, nftBE32(nftReg1))),
+		nftCmpExpr(nftCmpOp(invert), value),
+	}
+}
+
+// nftAddrExprs returns the expressions that match an address of the ip
+// header. A network is masked first.
+func nftAddrExprs(offset uint32, n *net.IPNet, invert bool) [][]byte {
+
+	exprs := [][]byte{nftPayloadExpr(nftPayloadNetworkHeader, offset, 4)}
+
+	if ones, _ := n.Mask.Size(); ones != 32 {
+		exprs = append(exprs, nftExpr("bitwise",
+			nlAttr(nftaBitwiseSreg, nftBE32(nftReg1)),
+			nlAttr(nftaBitwiseDreg, nftBE32(nftReg1)),
+			nlAttr(nftaBitwiseLen, nftBE32(4)),
+			nlAttr(nftaBitwiseMask|nlaFNested, nlAttr(nftaDataValue, n.Mask)),
+			nlAttr(nftaBitwiseXor|nlaFNested, nlAttr(nftaDataValue, make([]byte, 4))),
+		))
+	}
+
+	return append(exprs, nftCmpExpr(nftCmpOp(invert), n.IP.To4()))
+}
+
+// nftExpr returns an expression of a rule
+func nftExpr(name string, data ...[]byte) []byte {
+
+	return nlAttr(nftaListElem|nlaFNested,
+		nlAttrString(nftaExprName, name),
+		nlAttr(nftaExprData|nlaFNested, data...),
+	)
+}
+
+func nftPayloadExpr(base, offset, length uint32) []byte {
+
+	return nftExpr("payload",
+		nlAttr(nftaPayloadDreg, nftBE32(nftReg1)),
+		nlAttr(nftaPayloadBase, nftBE32(base)),
+		nlAttr(nftaPayloadOffset, nftBE32(offset)),
+		nlAttr(nftaPayloadLen, nftBE32(length)),
+	)
+}
+
+func nftCmpExpr(op uint32, value []byte) []byte {
+
+	return nftExpr("cmp",
+		nlAttr(nftaCmpSreg, nftBE32(nftReg1)),
+		nlAttr(nftaCmpOp, nftBE32(op)),
+		nlAttr(nftaCmpData|nlaFNested, nlAttr(nftaDataValue, value)),
+	)
+}
+
+func nftVerdictExpr(code int32, chain string) []byte {
+
+	verdict := [][]byte{nlAttr(nftaVerdictCode, nftBE32(uint32(code)))}
+	if chain != "" {
+		verdict = append(verdict, nlAttrString(nftaVerdictChain, chain))
+	}
+
+	return nftExpr("immediate",
+		nlAttr(nftaImmediateDreg, nftBE32(nftRegVerdict)),
+		nlAttr(nftaImmediateData|nlaFNested, nlAttr(nftaDataVerdict|nlaFNested, verdict...)),
+	)
+}
+
+// nftCompatExpr returns a match or a target of the xtables compatibility layer
+func nftCompatExpr(kind, name string, rev uint32, info []byte) []byte {
+
+	return nftExpr(kind,
+		nlAttrString(nftaCompatName, name),
+		nlAttr(nftaCompatRev, nftBE32(rev)),
+		nlAttr(nftaCompatInfo, info),
+	)
+}
+
+// nftBE32 returns a value in network byte order. Unlike ipset, nf_tables
+// does not flag the attributes in network byte order.
+func nftBE32(v uint32) []byte {
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+
+	return b
+}
+
+// parseNftMark returns a value with an optional mask. The mask is all ones
+// if it is not given.
+func parseNftMark(v string) (uint32, uint32, error) {
+
+	value, mask := v, "0xffffffff"
+	if slash := strings.Index(v, "/"); slash >= 0 {
+		value, mask = v[:slash], v[slash+1:]
+	}
+
+	mv, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value %s", v)
+	}
+
+	mm, err := strconv.ParseUint(mask, 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid mask %s", v)
+	}
+
+	return uint32(mv), uint32(mm), nil
+}
+
+// parseNftPorts returns a port or a range of ports separated by sep. A missing
+// bound of a range is the lowest or the highest port.
+func parseNftPorts(v string, sep string) (uint16, uint16, error) {
+
+	bounds := strings.SplitN(v, sep, 2)
+
+	ports := []uint16{0, 0xffff}
+	for idx, bound := range bounds {
+		if bound == "" && len(bounds) == 2 {
+			continue
+		}
+		p, err := strconv.ParseUint(bound, 10, 16)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port %s", v)
+		}
+		ports[idx] = uint16(p)
+	}
+
+	if len(bounds) == 1 {
+		ports[1] = ports[0]
+	}
+
+	return ports[0], ports[1], nil
+}
+
+// nftTCPFlags are the names of the tcp flags
+var nftTCPFlags = map[string]uint8{
+	"FIN": 0x01, "SYN": 0x02, "RST": 0x04, "PSH": 0x08,
+	"ACK": 0x10, "URG": 0x20, "ECE": 0x40, "CWR": 0x80,
+	"ALL": 0x3f, "NONE": 0,
+}
+
+func parseNftTCPFlags(v string) (uint8, error) {
+
+	flags := uint8(0)
+	for _, name := range strings.Split(strings.ToUpper(v), ",") {
+		flag, ok := nftTCPFlags[name]
+		if !ok {
+			return 0, fmt.Errorf("invalid tcp flag %s", name)
+		}
+		flags |= flag
+	}
+
+	return flags, nil
+}
+
+// encodeNftPorts encodes the ranges of the source and destination ports of
+// the tcp and udp matches and returns their inversion flags
+func encodeNftPorts(info []byte, opts []nftOption) (uint8, error) {
+
+	binary.LittleEndian.PutUint16(info[2:4], 0xffff)
+	binary.LittleEndian.PutUint16(info[6:8], 0xffff)
+
+	inv := uint8(0)
+	for _, opt := range opts {
+		offset, flag := 0, uint8(0x01)
+		switch opt.name {
+		case "--dport":
+			offset, flag = 4, 0x02
+		case "--sport":
+		default:
+			continue
+		}
+
+		lo, hi, err := parseNftPorts(opt.args[0], ":")
+		if err != nil {
+			return 0, err
+		}
+		binary.LittleEndian.PutUint16(info[offset:offset+2], lo)
+		binary.LittleEndian.PutUint16(info[offset+2:offset+4], hi)
+		if opt.invert {
+			inv |= flag
+		}
+	}
+
+	return inv, nil
+}
+
+// struct xt_tcp
+func encodeNftTCP(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 12)
+
+	inv, err := encodeNftPorts(info, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, opt := range opts {
+		switch opt.name {
+		case "--tcp-flags":
+			mask, err := parseNftTCPFlags(opt.args[0])
+			if err != nil {
+				return nil, 0, err
+			}
+			cmp, err := parseNftTCPFlags(opt.args[1])
+			if err != nil {
+				return nil, 0, err
+			}
+			info[9], info[10] = mask, cmp
+			if opt.invert {
+				inv |= 0x04
+			}
+		case "--syn":
+			info[9], info[10] = 0x17, 0x02
+			if opt.invert {
+				inv |= 0x04
+			}
+		case "--tcp-option":
+			option, err := strconv.ParseUint(opt.args[0], 10, 8)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid tcp option %s", opt.args[0])
+			}
+			info[8] = uint8(option)
+			if opt.invert {
+				inv |= 0x08
+			}
+		}
+	}
+
+	info[11] = inv
+
+	return info, 0, nil
+}
+
+// struct xt_udp
+func encodeNftUDP(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 10)
+
+	inv, err := encodeNftPorts(info, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	info[8] = inv
+
+	return info, 0, nil
+}
+
+// nftICMPTypes are the names of the icmp types
+var nftICMPTypes = map[string]uint8{
+	"echo-reply":              0,
+	"destination-unreachable": 3,
+	"source-quench":           4,
+	"redirect":                5,
+	"echo-request":            8,
+	"time-exceeded":           11,
+	"parameter-problem":       12,
+	"timestamp-request":       13,
+	"timestamp-reply":         14,
+}
+
+// struct ipt_icmp
+func encodeNftICMP(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := []byte{0xff, 0, 0xff, 0}
+
+	for _, opt := range opts {
+		v := opt.args[0]
+
+		if t, ok := nftICMPTypes[v]; ok {
+			info[0] = t
+		} else if v != "any" {
+			code := ""
+			if slash := strings.Index(v, "/"); slash >= 0 {
+				v, code = v[:slash], v[slash+1:]
+			}
+			t, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid icmp type %s", opt.args[0])
+			}
+			info[0] = uint8(t)
+			if code != "" {
+				c, err := strconv.ParseUint(code, 10, 8)
+				if err != nil {
+					return nil, 0, fmt.Errorf("invalid icmp code %s", opt.args[0])
+				}
+				info[1], info[2] = uint8(c), uint8(c)
+			}
+		}
+
+		if opt.invert {
+			info[3] = 0x01
+		}
+	}
+
+	return info, 0, nil
+}
+
+// struct xt_mark_mtinfo1, also used by the connmark match
+func encodeNftMarkMatch(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 12)
+
+	for _, opt := range opts {
+		mark, mask, err := parseNftMark(opt.args[0])
+		if err != nil {
+			return nil, 0, err
+		}
+		binary.LittleEndian.PutUint32(info[0:4], mark)
+		binary.LittleEndian.PutUint32(info[4:8], mask)
+		if opt.invert {
+			info[8] = 1
+		}
+	}
+
+	return info, 1, nil
+}
+
+// nftStates are the bits of the connection states of the state match
+var nftStates = map[string]uint32{
+	"INVALID":     1 << 0,
+	"ESTABLISHED": 1 << 1,
+	"RELATED":     1 << 2,
+	"NEW":         1 << 3,
+	"UNTRACKED":   1 << 6,
+}
+
+// struct xt_state_info
+func encodeNftState(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	mask := uint32(0)
+
+	for _, opt := range opts {
+		for _, name := range strings.Split(strings.ToUpper(opt.args[0]), ",") {
+			state, ok := nftStates[name]
+			if !ok {
+				return nil, 0, fmt.Errorf("invalid state %s", name)
+			}
+			mask |= state
+		}
+		if opt.invert {
+			mask = ^mask
+		}
+	}
+
+	info := make([]byte, 4)
+	binary.LittleEndian.PutUint32(info, mask)
+
+	return info, 0, nil
+}
+
+// struct xt_comment_info
+func encodeNftComment(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 256)
+
+	for _, opt := range opts {
+		if len(opt.args[0]) >= len(info) {
+			return nil, 0, fmt.Errorf("comment too long")
+		}
+		copy(info, opt.args[0])
+	}
+
+	return info, 0, nil
+}
+
+// struct xt_set_info_match_v1
+func encodeNftSet(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	if len(opts) != 1 {
+		return nil, 0, fmt.Errorf("a single set must be matched")
+	}
+
+	index, err := resolve(opts[0].args[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to find set %s: %s", opts[0].args[0], err)
+	}
+
+	info := make([]byte, 4)
+	binary.LittleEndian.PutUint16(info[0:2], index)
+
+	dirs := strings.Split(opts[0].args[1], ",")
+	if len(dirs) > 6 {
+		return nil, 0, fmt.Errorf("too many directions %s", opts[0].args[1])
+	}
+
+	info[2] = uint8(len(dirs))
+	for idx, dir := range dirs {
+		switch dir {
+		case "src":
+			info[3] |= 1 << uint(idx+1)
+		case "dst":
+		default:
+			return nil, 0, fmt.Errorf("invalid direction %s", dir)
+		}
+	}
+
+	if opts[0].invert {
+		info[3] |= 0x01
+	}
+
+	return info, 1, nil
+}
+
+// struct xt_socket_mtinfo1 to 3. The revision is the lowest one that has the
+// flags.
+func encodeNftSocket(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	flags := uint8(0)
+	for _, opt := range opts {
+		switch opt.name {
+		case "--transparent":
+			flags |= 0x01
+		case "--nowildcard":
+			flags |= 0x02
+		case "--restore-skmark":
+			flags |= 0x04
+		}
+	}
+
+	switch {
+	case flags&0x04 != 0:
+		return []byte{flags}, 3, nil
+	case flags&0x02 != 0:
+		return []byte{flags}, 2, nil
+	case flags != 0:
+		return []byte{flags}, 1, nil
+	default:
+		return []byte{}, 0, nil
+	}
+}
+
+// struct xt_cgroup_info_v0
+func encodeNftCgroup(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 8)
+
+	for _, opt := range opts {
+		id, err := strconv.ParseUint(opt.args[0], 0, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid cgroup %s", opt.args[0])
+		}
+		binary.LittleEndian.PutUint32(info[0:4], uint32(id))
+		if opt.invert {
+			binary.LittleEndian.PutUint32(info[4:8], 1)
+		}
+	}
+
+	return info, 0, nil
+}
+
+// struct xt_owner_match_info
+func encodeNftOwner(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 20)
+
+	for _, opt := range opts {
+		v := opt.args[0]
+		if _, err := strconv.ParseUint(strings.SplitN(v, "-", 2)[0], 10, 32); err != nil {
+			u, err := user.Lookup(v)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid user %s", v)
+			}
+			v = u.Uid
+		}
+
+		lo, hi, err := parseNftIDs(v)
+		if err != nil {
+			return nil, 0, err
+		}
+		binary.LittleEndian.PutUint32(info[0:4], lo)
+		binary.LittleEndian.PutUint32(info[4:8], hi)
+
+		info[16] |= 0x01
+		if opt.invert {
+			info[17] |= 0x01
+		}
+	}
+
+	return info, 1, nil
+}
+
+// parseNftIDs returns an id or a range of ids
+func parseNftIDs(v string) (uint32, uint32, error) {
+
+	bounds := strings.SplitN(v, "-", 2)
+
+	lo, err := strconv.ParseUint(bounds[0], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid id %s", v)
+	}
+
+	hi := lo
+	if len(bounds) == 2 {
+		if hi, err = strconv.ParseUint(bounds[1], 10, 32); err != nil {
+			return 0, 0, fmt.Errorf("invalid id %s", v)
+		}
+	}
+
+	return uint32(lo), uint32(hi), nil
+}
+
+// struct xt_multiport_v1
+func encodeNftMultiport(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	if r.proto != ipprotoTCP && r.proto != ipprotoUDP && r.proto != ipprotoSCTP {
+		return nil, 0, fmt.Errorf("multiport requires tcp, udp or sctp")
+	}
+
+	if len(opts) != 1 {
+		return nil, 0, fmt.Errorf("a single list of ports must be given")
+	}
+
+	info := make([]byte, 48)
+
+	switch opts[0].name {
+	case "--sports":
+		info[0] = 0
+	case "--dports":
+		info[0] = 1
+	default:
+		info[0] = 2
+	}
+
+	count := 0
+	for _, port := range strings.Split(opts[0].args[0], ",") {
+		lo, hi, err := parseNftPorts(port, ":")
+		if err != nil {
+			return nil, 0, err
+		}
+
+		n := 1
+		if strings.Contains(port, ":") {
+			n = 2
+		}
+		if count+n > 15 {
+			return nil, 0, fmt.Errorf("too many ports %s", opts[0].args[0])
+		}
+
+		binary.LittleEndian.PutUint16(info[2+2*count:], lo)
+		if n == 2 {
+			info[32+count] = 1
+			binary.LittleEndian.PutUint16(info[2+2*(count+1):], hi)
+		}
+		count += n
+	}
+
+	info[1] = uint8(count)
+	if opts[0].invert {
+		info[47] = 1
+	}
+
+	return info, 1, nil
+}
+
+// nftLimitUnits are the periods in seconds of the units of the limit match.
+// The units can be abbreviated.
+var nftLimitUnits = []struct {
+	name    string
+	seconds uint64
+}{
+	{"second", 1},
+	{"minute", 60},
+	{"hour", 60 * 60},
+	{"day", 24 * 60 * 60},
+}
+
+// parseNftLimitRate returns the average period between the packets of a rate,
+// like 10/second, in units of 1/10000 second
+func parseNftLimitRate(rate string) (uint32, error) {
+
+	parts := strings.SplitN(rate, "/", 2)
+
+	count, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || count == 0 {
+		return 0, fmt.Errorf("invalid rate %s", rate)
+	}
+
+	seconds := uint64(1)
+	if len(parts) == 2 {
+		seconds = 0
+		for _, unit := range nftLimitUnits {
+			if parts[1] != "" && strings.HasPrefix(unit.name, parts[1]) {
+				seconds = unit.seconds
+				break
+			}
+		}
+		if seconds == 0 {
+			return 0, fmt.Errorf("invalid rate %s", rate)
+		}
+	}
+
+	avg := 10000 * seconds / count
+	if avg == 0 || avg > math.MaxUint32 {
+		return 0, fmt.Errorf("invalid rate %s", rate)
+	}
+
+	return uint32(avg), nil
+}
+
+// struct xt_rateinfo. The rate defaults to 3/hour and the burst to 5, as with
+// the iptables command.
+func encodeNftLimit(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 40)
+	binary.LittleEndian.PutUint32(info[0:4], 10000*60*60/3)
+	binary.LittleEndian.PutUint32(info[4:8], 5)
+
+	for _, opt := range opts {
+		if opt.invert {
+			return nil, 0, fmt.Errorf("limit options cannot be inverted")
+		}
+
+		switch opt.name {
+		case "--limit":
+			avg, err := parseNftLimitRate(opt.args[0])
+			if err != nil {
+				return nil, 0, err
+			}
+			binary.LittleEndian.PutUint32(info[0:4], avg)
+		case "--limit-burst":
+			burst, err := strconv.ParseUint(opt.args[0], 10, 32)
+			if err != nil || burst == 0 {
+				return nil, 0, fmt.Errorf("invalid burst %s", opt.args[0])
+			}
+			binary.LittleEndian.PutUint32(info[4:8], uint32(burst))
+		}
+	}
+
+	return info, 0, nil
+}
+
+// struct xt_mark_tginfo2
+func encodeNftMarkTarget(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	if len(opts) != 1 {
+		return nil, 0, fmt.Errorf("a single operation must be given")
+	}
+
+	v, m, err := parseNftMark(opts[0].args[0])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	mark, mask := v, m
+	switch opts[0].name {
+	case "--set-mark":
+		mask = m | v
+	case "--and-mark":
+		mark, mask = 0, ^v
+	case "--or-mark":
+		mark, mask = v, v
+	case "--xor-mark":
+		mark, mask = v, 0
+	}
+
+	info := make([]byte, 8)
+	binary.LittleEndian.PutUint32(info[0:4], mark)
+	binary.LittleEndian.PutUint32(info[4:8], mask)
+
+	return info, 2, nil
+}
+
+// struct xt_NFQ_info_v3
+func encodeNftNFQueue(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	num, total, flags := uint16(0), uint16(1), uint16(0)
+
+	for _, opt := range opts {
+		switch opt.name {
+		case "--queue-num":
+			n, err := strconv.ParseUint(opt.args[0], 10, 16)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid queue %s", opt.args[0])
+			}
+			num = uint16(n)
+		case "--queue-balance":
+			lo, hi, err := parseNftPorts(opt.args[0], ":")
+			if err != nil || hi < lo {
+				return nil, 0, fmt.Errorf("invalid queues %s", opt.args[0])
+			}
+			num, total = lo, hi-lo+1
+		case "--queue-bypass":
+			flags |= 0x01
+		case "--queue-cpu-fanout":
+			flags |= 0x02
+		}
+	}
+
+	info := make([]byte, 6)
+	binary.LittleEndian.PutUint16(info[0:2], num)
+	binary.LittleEndian.PutUint16(info[2:4], total)
+	binary.LittleEndian.PutUint16(info[4:6], flags)
+
+	return info, 3, nil
+}
+
+// struct xt_nflog_info
+func encodeNftNFLog(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 76)
+
+	for _, opt := range opts {
+		switch opt.name {
+		case "--nflog-prefix":
+			if len(opt.args[0]) >= 64 {
+				return nil, 0, fmt.Errorf("prefix too long")
+			}
+			copy(info[12:], opt.args[0])
+			continue
+		}
+
+		n, err := strconv.ParseUint(opt.args[0], 10, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid value %s", opt.args[0])
+		}
+
+		switch opt.name {
+		case "--nflog-group":
+			binary.LittleEndian.PutUint16(info[4:6], uint16(n))
+		case "--nflog-threshold":
+			binary.LittleEndian.PutUint16(info[6:8], uint16(n))
+		case "--nflog-range", "--nflog-size":
+			binary.LittleEndian.PutUint32(info[0:4], uint32(n))
+			binary.LittleEndian.PutUint16(info[8:10], 0x02)
+		}
+	}
+
+	return info, 0, nil
+}
+
+// struct nf_nat_ipv4_multi_range_compat
+func encodeNftRedirect(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 20)
+	binary.LittleEndian.PutUint32(info[0:4], 1)
+
+	flags := uint32(0)
+	for _, opt := range opts {
+		switch opt.name {
+		case "--to-ports":
+			lo, hi, err := parseNftPorts(opt.args[0], "-")
+			if err != nil {
+				return nil, 0, err
+			}
+			flags |= 0x02
+			binary.BigEndian.PutUint16(info[16:18], lo)
+			binary.BigEndian.PutUint16(info[18:20], hi)
+		case "--random":
+			flags |= 0x04
+		}
+	}
+	binary.LittleEndian.PutUint32(info[4:8], flags)
+
+	return info, 0, nil
+}
+
+// nftDSCPClasses are the DSCP values of the classes
+var nftDSCPClasses = map[string]uint8{
+	"BE": 0, "EF": 46,
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+}
+
+// struct xt_DSCP_info
+func encodeNftDSCP(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	if len(opts) != 1 {
+		return nil, 0, fmt.Errorf("a single value must be given")
+	}
+
+	if opts[0].name == "--set-dscp-class" {
+		dscp, ok := nftDSCPClasses[strings.ToUpper(opts[0].args[0])]
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid class %s", opts[0].args[0])
+		}
+		return []byte{dscp}, 0, nil
+	}
+
+	dscp, err := strconv.ParseUint(opts[0].args[0], 0, 8)
+	if err != nil || dscp > 63 {
+		return nil, 0, fmt.Errorf("invalid dscp %s", opts[0].args[0])
+	}
+
+	return []byte{uint8(dscp)}, 0, nil
+}
+
+// struct xt_tproxy_target_info_v1
+func encodeNftTProxy(r *nftRuleSpec, opts []nftOption, resolve nftSetResolver) ([]byte, uint32, error) {
+
+	info := make([]byte, 28)
+
+	hasPort := false
+	for _, opt := range opts {
+		switch opt.name {
+		case "--on-port":
+			port, err := strconv.ParseUint(opt.args[0], 10, 16)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid port %s", opt.args[0])
+			}
+			binary.BigEndian.PutUint16(info[24:26], uint16(port))
+			hasPort = true
+		case "--on-ip":
+			ip := net.ParseIP(opt.args[0]).To4()
+			if ip == nil {
+				return nil, 0, fmt.Errorf("invalid address %s", opt.args[0])
+			}
+			copy(info[8:12], ip)
+		case "--tproxy-mark":
+			mark, mask, err := parseNftMark(opt.args[0])
+			if err != nil {
+				return nil, 0, err
+			}
+			binary.LittleEndian.PutUint32(info[0:4], mask)
+			binary.LittleEndian.PutUint32(info[4:8], mark)
+		}
+	}
+
+	if !hasPort {
+		return nil, 0, fmt.Errorf("--on-port is required")
+	}
+
+	return info, 1, nil
+}
+
+// nftPortOptions are the options of the tcp and udp matches
+var nftPortOptions = map[string]string{
+	"--source-port":      "--sport",
+	"--destination-port": "--dport",
+}
+
+// nftMatches are the matches that are translated
+var nftMatches = map[string]*nftExtension{
+	"tcp": {
+		options: map[string]int{"--sport": 1, "--dport": 1, "--tcp-flags": 2, "--syn": 0, "--tcp-option": 1},
+		aliases: nftPortOptions,
+		encode:  encodeNftTCP,
+	},
+	"udp": {
+		options: map[string]int{"--sport": 1, "--dport": 1},
+		aliases: nftPortOptions,
+		encode:  encodeNftUDP,
+	},
+	"icmp": {
+		options: map[string]int{"--icmp-type": 1},
+		encode:  encodeNftICMP,
+	},
+	"mark": {
+		options: map[string]int{"--mark": 1},
+		encode:  encodeNftMarkMatch,
+	},
+	"connmark": {
+		options: map[string]int{"--mark": 1},
+		encode:  encodeNftMarkMatch,
+	},
+	"state": {
+		options: map[string]int{"--state": 1},
+		encode:  encodeNftState,
+	},
+	"comment": {
+		options: map[string]int{"--comment": 1},
+		encode:  encodeNftComment,
+	},
+	"set": {
+		options: map[string]int{"--match-set": 2},
+		aliases: map[string]string{"--set": "--match-set"},
+		encode:  encodeNftSet,
+	},
+	"socket": {
+		options: map[string]int{"--transparent": 0, "--nowildcard": 0, "--restore-skmark": 0},
+		encode:  encodeNftSocket,
+	},
+	"cgroup": {
+		options: map[string]int{"--cgroup": 1},
+		encode:  encodeNftCgroup,
+	},
+	"owner": {
+		options: map[string]int{"--uid-owner": 1},
+		encode:  encodeNftOwner,
+	},
+	"multiport": {
+		options: map[string]int{"--sports": 1, "--dports": 1, "--ports": 1},
+		aliases: map[string]string{"--source-ports": "--sports", "--destination-ports": "--dports"},
+		encode:  encodeNftMultiport,
+	},
+	"limit": {
+		options: map[string]int{"--limit": 1, "--limit-burst": 1},
+		encode:  encodeNftLimit,
+	},
+}
+
+// nftTargets are the targets that are translated
+var nftTargets = map[string]*nftExtension{
+	"MARK": {
+		options: map[string]int{"--set-mark": 1, "--set-xmark": 1, "--and-mark": 1, "--or-mark": 1, "--xor-mark": 1},
+		encode:  encodeNftMarkTarget,
+	},
+	"NFQUEUE": {
+		options: map[string]int{"--queue-num": 1, "--queue-balance": 1, "--queue-bypass": 0, "--queue-cpu-fanout": 0},
+		encode:  encodeNftNFQueue,
+	},
+	"NFLOG": {
+		options: map[string]int{"--nflog-group": 1, "--nflog-prefix": 1, "--nflog-range": 1, "--nflog-size": 1, "--nflog-threshold": 1},
+		encode:  encodeNftNFLog,
+	},
+	"REDIRECT": {
+		options: map[string]int{"--to-ports": 1, "--random": 0},
+		encode:  encodeNftRedirect,
+	},
+	"DSCP": {
+		options: map[string]int{"--set-dscp": 1, "--set-dscp-class": 1},
+		encode:  encodeNftDSCP,
+	},
+	"TPROXY": {
+		options: map[string]int{"--on-port": 1, "--on-ip": 1, "--tproxy-mark": 1},
+		encode:  encodeNftTProxy,
+	},
+}
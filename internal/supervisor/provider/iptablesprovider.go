@@ -1,6 +1,13 @@
 package provider
 
-import "github.com/coreos/go-iptables/iptables"
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+)
 
 // IptablesProvider is an abstraction of all the methods an implementation of userspace
 // iptables need to provide.
@@ -11,6 +18,10 @@ type IptablesProvider interface {
 	Insert(table, chain string, pos int, rulespec ...string) error
 	// Delete deletes a rule of a chain in the given table
 	Delete(table, chain string, rulespec ...string) error
+	// Exists checks if a rule exists in a chain of the given table
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	// List lists the rules of a chain in the given table
+	List(table, chain string) ([]string, error)
 	// ListChains lists all the chains associated with a table
 	ListChains(table string) ([]string, error)
 	// ClearChain clears a chain in a table
@@ -21,8 +32,69 @@ type IptablesProvider interface {
 	NewChain(table, chain string) error
 }
 
+// IptablesRestorer is implemented by the providers that can program a batch
+// of rules with iptables-restore.
+type IptablesRestorer interface {
+	// Restore programs the rules of an iptables-restore input without
+	// flushing the tables
+	Restore(input string) error
+}
+
+type goIptablesProvider struct {
+	*iptables.IPTables
+	restore string
+}
+
 // NewGoIPTablesProvider returns an IptablesProvider interface based on the go-iptables
-// external package.
+// external package. The provider is also an IptablesRestorer if the
+// iptables-restore command is installed.
 func NewGoIPTablesProvider() (IptablesProvider, error) {
-	return iptables.New()
+
+	ipt, err := iptables.New()
+	if err != nil {
+		return nil, err
+	}
+
+	restore, err := exec.LookPath("iptables-restore")
+	if err != nil {
+		return ipt, nil
+	}
+
+	return &goIptablesProvider{
+		IPTables: ipt,
+		restore:  restore,
+	}, nil
+}
+
+// Restore implements the IptablesRestorer interface
+func (p *goIptablesProvider) Restore(input string) error {
+
+	cmd := exec.Command(p.restore, "--noflush")
+	cmd.Stdin = strings.NewReader(input)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// ParseRule returns the chain and the rulespec of a rule listed by the List
+// method of a provider, like -A INPUT -p tcp -j ACCEPT. The policies and the
+// chains listed with the rules are not rules and return an error.
+func ParseRule(line string) (chain string, rulespec []string, err error) {
+
+	args, err := restoreLineArgs(line)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(args) < 2 || args[0] != "-A" {
+		return "", nil, fmt.Errorf("not a rule: %s", line)
+	}
+
+	return args[1], args[2:], nil
 }
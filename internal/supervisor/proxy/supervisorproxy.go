@@ -3,11 +3,16 @@
 package supervisorproxy
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme-lib/collector"
+	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer/policyenforcer"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
@@ -29,18 +34,29 @@ type ProxyInfo struct {
 	prochdl        processmon.ProcessManager
 	rpchdl         rpcwrapper.RPCClient
 	initDone       map[string]bool
+	failMode       constants.FailMode
+	captureMethod  rpcwrapper.CaptureType
+	udpEnforcement bool
+	// callTimeout is the time a remote supervisor has to answer a call
+	callTimeout time.Duration
 
 	sync.Mutex
 }
 
+// restartNotifier is implemented by the enforcers that restart the remote
+// enforcers that stop answering
+type restartNotifier interface {
+	RegisterRestartHandler(handler func(contextID string))
+}
+
 //Supervise Calls Supervise on the remote supervisor
-func (s *ProxyInfo) Supervise(contextID string, puInfo *policy.PUInfo) error {
+func (s *ProxyInfo) Supervise(ctx context.Context, contextID string, puInfo *policy.PUInfo) error {
 
 	s.Lock()
 	_, ok := s.initDone[contextID]
 	s.Unlock()
 	if !ok {
-		err := s.InitRemoteSupervisor(contextID, puInfo)
+		err := s.initRemoteSupervisor(ctx, contextID, puInfo)
 		if err != nil {
 			return err
 		}
@@ -64,23 +80,28 @@ func (s *ProxyInfo) Supervise(contextID string, puInfo *policy.PUInfo) error {
 		},
 	}
 
-	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.Supervise, req, &rpcwrapper.Response{}); err != nil {
+	if err := s.rpchdl.RemoteCall(ctx, contextID, remoteenforcer.Supervise, req, &rpcwrapper.Response{}); err != nil {
 		s.Lock()
 		delete(s.initDone, contextID)
 		s.Unlock()
 		return fmt.Errorf("unable to send supervise command for context id %s: %s", contextID, err)
 	}
 
+	s.versionTracker.AddOrUpdate(contextID, puInfo)
+
 	return nil
 
 }
 
-// Unsupervise exported stops enforcing policy for the given IP.
-func (s *ProxyInfo) Unsupervise(contextID string) error {
+// Unsupervise exported stops enforcing policy for the given IP. The remote
+// enforcer is killed, which does not wait for the context.
+func (s *ProxyInfo) Unsupervise(ctx context.Context, contextID string) error {
 	s.Lock()
 	delete(s.initDone, contextID)
 	s.Unlock()
 
+	s.versionTracker.Remove(contextID) // nolint
+
 	s.prochdl.KillProcess(contextID)
 
 	return nil
@@ -95,11 +116,16 @@ func (s *ProxyInfo) SetTargetNetworks(networks []string) error {
 			request := &rpcwrapper.Request{
 				Payload: &rpcwrapper.InitSupervisorPayload{
 					TriremeNetworks: networks,
-					CaptureMethod:   rpcwrapper.IPTables,
+					CaptureMethod:   s.captureMethod,
+					FailMode:        s.failMode,
+					UDPEnforcement:  s.udpEnforcement,
 				},
 			}
 
-			if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.InitSupervisor, request, &rpcwrapper.Response{}); err != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), s.callTimeout)
+			err := s.rpchdl.RemoteCall(ctx, contextID, remoteenforcer.InitSupervisor, request, &rpcwrapper.Response{})
+			cancel()
+			if err != nil {
 				return fmt.Errorf("unable to initialize remote supervisor for contextid %s: %s", contextID, err)
 			}
 		}
@@ -108,6 +134,56 @@ func (s *ProxyInfo) SetTargetNetworks(networks []string) error {
 	return nil
 }
 
+// SetFailMode sets what happens to the traffic of a PU when its remote enforcer
+// dies. It applies to the remote supervisors initialized after the call.
+func (s *ProxyInfo) SetFailMode(mode constants.FailMode) {
+	s.Lock()
+	defer s.Unlock()
+	s.failMode = mode
+}
+
+// SetCaptureMethod sets how the remote supervisors capture the traffic of the
+// PUs. It applies to the remote supervisors initialized after the call.
+func (s *ProxyInfo) SetCaptureMethod(method rpcwrapper.CaptureType) {
+	s.Lock()
+	defer s.Unlock()
+	s.captureMethod = method
+}
+
+// SetUDPEnforcement enables the enforcement of the identity of the PUs on the
+// UDP flows. It applies to the remote supervisors initialized after the call.
+func (s *ProxyInfo) SetUDPEnforcement(enabled bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.udpEnforcement = enabled
+}
+
+// SetCallTimeout sets the time the remote supervisors have to answer the calls
+// that are not given a context
+func (s *ProxyInfo) SetCallTimeout(timeout time.Duration) error {
+
+	if timeout <= 0 {
+		return fmt.Errorf("invalid call timeout %s", timeout)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.callTimeout = timeout
+
+	return nil
+}
+
+// callContext returns the context of a call to a remote supervisor, which ends
+// with the call timeout
+func (s *ProxyInfo) callContext() (context.Context, context.CancelFunc) {
+
+	s.Lock()
+	timeout := s.callTimeout
+	s.Unlock()
+
+	return context.WithTimeout(context.Background(), timeout)
+}
+
 // Start This method does nothing and is implemented for completeness
 // THe work done is done in the InitRemoteSupervisor method in the remote enforcer
 func (s *ProxyInfo) Start() error {
@@ -118,7 +194,7 @@ func (s *ProxyInfo) Start() error {
 //Stop This method does nothing
 func (s *ProxyInfo) Stop() error {
 	for c := range s.initDone {
-		s.Unsupervise(c) // nolint
+		s.Unsupervise(context.Background(), c) // nolint
 	}
 	return nil
 }
@@ -142,23 +218,70 @@ func NewProxySupervisor(collector collector.EventCollector, enforcer policyenfor
 		rpchdl:         rpchdl,
 		initDone:       make(map[string]bool),
 		ExcludedIPs:    []string{},
+		callTimeout:    rpcwrapper.DefaultCallTimeout,
+	}
+
+	if notifier, ok := enforcer.(restartNotifier); ok {
+		notifier.RegisterRestartHandler(s.resupervise)
 	}
 
 	return s, nil
 
 }
 
+// resupervise sends the last policy of a PU to its remote supervisor after its
+// remote enforcer was restarted
+func (s *ProxyInfo) resupervise(contextID string) {
+
+	data, err := s.versionTracker.Get(contextID)
+	if err != nil {
+		return
+	}
+
+	s.Lock()
+	delete(s.initDone, contextID)
+	s.Unlock()
+
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	if err := s.Supervise(ctx, contextID, data.(*policy.PUInfo)); err != nil {
+		zap.L().Error("Unable to supervise PU after restart of remote enforcer",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+	}
+}
+
 //InitRemoteSupervisor calls initsupervisor method on the remote
 func (s *ProxyInfo) InitRemoteSupervisor(contextID string, puInfo *policy.PUInfo) error {
 
+	ctx, cancel := s.callContext()
+	defer cancel()
+
+	return s.initRemoteSupervisor(ctx, contextID, puInfo)
+}
+
+// initRemoteSupervisor calls initsupervisor method on the remote until the
+// context is done
+func (s *ProxyInfo) initRemoteSupervisor(ctx context.Context, contextID string, puInfo *policy.PUInfo) error {
+
+	s.Lock()
+	failMode := s.failMode
+	captureMethod := s.captureMethod
+	udpEnforcement := s.udpEnforcement
+	s.Unlock()
+
 	request := &rpcwrapper.Request{
 		Payload: &rpcwrapper.InitSupervisorPayload{
 			TriremeNetworks: puInfo.Policy.TriremeNetworks(),
-			CaptureMethod:   rpcwrapper.IPTables,
+			CaptureMethod:   captureMethod,
+			FailMode:        failMode,
+			UDPEnforcement:  udpEnforcement,
 		},
 	}
 
-	if err := s.rpchdl.RemoteCall(contextID, remoteenforcer.InitSupervisor, request, &rpcwrapper.Response{}); err != nil {
+	if err := s.rpchdl.RemoteCall(ctx, contextID, remoteenforcer.InitSupervisor, request, &rpcwrapper.Response{}); err != nil {
 		return fmt.Errorf("unable to initialize remote supervisor for context id %s: %s", contextID, err)
 	}
 
@@ -180,7 +303,10 @@ func (s *ProxyInfo) AddExcludedIPs(ips []string) error {
 	}
 
 	for _, contextID := range s.rpchdl.ContextList() {
-		if err := s.rpchdl.RemoteCall(contextID, "Server.AddExcludedIP", request, &rpcwrapper.Response{}); err != nil {
+		ctx, cancel := s.callContext()
+		err := s.rpchdl.RemoteCall(ctx, contextID, "Server.AddExcludedIP", request, &rpcwrapper.Response{})
+		cancel()
+		if err != nil {
 			return fmt.Errorf("unable to add excluded ip list for %s: %s", contextID, err)
 		}
 	}
@@ -1,6 +1,7 @@
 package supervisorproxy
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -9,8 +10,8 @@ import (
 )
 
 type mockedMethods struct {
-	SuperviseMock         func(string, *policy.PUInfo) error
-	UnsuperviseMock       func(string) error
+	SuperviseMock         func(context.Context, string, *policy.PUInfo) error
+	UnsuperviseMock       func(context.Context, string) error
 	StartMock             func() error
 	StopMock              func() error
 	SetTargetNetworksMock func([]string) error
@@ -50,11 +51,11 @@ func (m *testSupervisorLauncher) currentMocks(t *testing.T) *mockedMethods {
 	return mocks
 }
 
-func (m *testSupervisorLauncher) MockSupervise(t *testing.T, impl func(string, *policy.PUInfo) error) {
+func (m *testSupervisorLauncher) MockSupervise(t *testing.T, impl func(context.Context, string, *policy.PUInfo) error) {
 	m.currentMocks(t).SuperviseMock = impl
 }
 
-func (m *testSupervisorLauncher) MockUnsupervise(t *testing.T, impl func(string) error) {
+func (m *testSupervisorLauncher) MockUnsupervise(t *testing.T, impl func(context.Context, string) error) {
 	m.currentMocks(t).UnsuperviseMock = impl
 }
 
@@ -70,17 +71,17 @@ func (m *testSupervisorLauncher) MockStop(t *testing.T, impl func() error) {
 	m.currentMocks(t).StopMock = impl
 }
 
-func (m *testSupervisorLauncher) Supervise(contextID string, puInfo *policy.PUInfo) error {
+func (m *testSupervisorLauncher) Supervise(ctx context.Context, contextID string, puInfo *policy.PUInfo) error {
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.SuperviseMock != nil {
-		return mock.SuperviseMock(contextID, puInfo)
+		return mock.SuperviseMock(ctx, contextID, puInfo)
 
 	}
 	return nil
 }
 
-func (m *testSupervisorLauncher) Unsupervise(contextID string) error {
+func (m *testSupervisorLauncher) Unsupervise(ctx context.Context, contextID string) error {
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.UnsuperviseMock != nil {
-		return mock.UnsuperviseMock(contextID)
+		return mock.UnsuperviseMock(ctx, contextID)
 
 	}
 	return nil
@@ -1,9 +1,11 @@
 package supervisor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -13,10 +15,15 @@ import (
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/internal/portset"
 	"github.com/aporeto-inc/trireme-lib/internal/supervisor/iptablesctrl"
+	"github.com/aporeto-inc/trireme-lib/internal/supervisor/provider"
 	"github.com/aporeto-inc/trireme-lib/policy"
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 )
 
+// DefaultReconcileInterval is the default period of the verification of the
+// rules programmed by the supervisor
+const DefaultReconcileInterval = 60 * time.Second
+
 type cacheData struct {
 	version       int
 	ips           policy.ExtendedMap
@@ -44,15 +51,122 @@ type Config struct {
 	excludedIPs []string
 	// triremeNetworks are the target networks where Trireme is implemented
 	triremeNetworks []string
+	// reconcileInterval is the period of the verification of the rules
+	reconcileInterval time.Duration
+	// stopReconcile stops the verification of the rules
+	stopReconcile chan struct{}
+	// rulesLock serializes the verification of the rules with the changes
+	// of the rules of the PUs
+	rulesLock sync.RWMutex
+	// workers is the number of workers that program the rules of the PUs
+	workers int
+	// pool programs the rules of the PUs if there are workers
+	pool *workerPool
+	// dryRun keeps the rules in memory instead of programming them
+	dryRun bool
+	// auditLog records the operations of the iptables and ipset providers
+	auditLog *provider.AuditLog
+	// warmRestart adopts the rules of the previous run
+	warmRestart bool
+	// nflogRate, nflogBurst and nflogThreshold limit the flows logged by the
+	// NFLOG rules
+	nflogRate      string
+	nflogBurst     int
+	nflogThreshold int
+	// clampMSS is the MSS of the handshakes with the target networks
+	clampMSS int
+	// udpEnforcement enforces the identity of the PUs on the UDP flows
+	udpEnforcement bool
+	// netlinkIptables programs the rules with nf_tables netlink messages
+	netlinkIptables bool
+	// healthChecks are the health checkers of the proxied services by PU
+	healthChecks map[string]*healthChecker
+	// healthLock protects the health checkers
+	healthLock sync.Mutex
 
 	sync.Mutex
 }
 
+// Option is an option of the supervisor
+type Option func(*Config)
+
+// OptionDryRun keeps the rules of the PUs in memory instead of programming
+// them, so that they can be reviewed with Save. It does not require
+// privileges.
+func OptionDryRun() Option {
+	return func(s *Config) {
+		s.dryRun = true
+	}
+}
+
+// OptionAuditLog records the last iptables and ipset operations of the
+// supervisor, with their result and their latency, so that they can be
+// inspected with AuditLog.
+func OptionAuditLog(size int) Option {
+	return func(s *Config) {
+		s.auditLog = provider.NewAuditLog(size)
+	}
+}
+
+// OptionWarmRestart keeps the rules of the PUs when the supervisor stops, and
+// adopts the rules of the previous run when it starts, so that the flows of
+// the PUs are not interrupted by a restart. The rules of the previous run that
+// are not adopted are removed with CleanStaleRules.
+func OptionWarmRestart() Option {
+	return func(s *Config) {
+		s.warmRestart = true
+	}
+}
+
+// OptionNFLOGLimit limits the flows logged by the NFLOG rules of the ACLs to a
+// rate, like 50/second, after a burst of flows, and queues threshold packets
+// in the kernel before they are sent to the enforcer. The flows are not limited
+// if the rate is unlimited. The rate and the burst of an ACL can be set by its
+// policy.
+func OptionNFLOGLimit(rate string, burst int, threshold int) Option {
+	return func(s *Config) {
+		s.nflogRate = rate
+		s.nflogBurst = burst
+		s.nflogThreshold = threshold
+	}
+}
+
+// OptionClampMSS clamps the MSS advertised by the SYN and SYN-ACK packets
+// exchanged with the target networks, so that the authentication option does
+// not push the segments of the authorized connections past the MTU of the
+// path.
+func OptionClampMSS(mss int) Option {
+	return func(s *Config) {
+		s.clampMSS = mss
+	}
+}
+
+// OptionUDPEnforcement sends the UDP datagrams exchanged with the target
+// networks to the enforcer, so that the identity of the PUs is enforced on the
+// UDP flows. The first datagrams of a flow carry the identity tokens, and the
+// peers must run an enforcer with the UDP enforcement as well. The UDP flows
+// are not enforced by default.
+func OptionUDPEnforcement() Option {
+	return func(s *Config) {
+		s.udpEnforcement = true
+	}
+}
+
+// OptionNetlinkIptables programs the rules and the ipsets with nf_tables and
+// ipset netlink messages instead of running the iptables and the ipset
+// commands, on hosts where iptables uses nf_tables. The commands are used when
+// netlink is not available, and for the rules that are not translated.
+func OptionNetlinkIptables() Option {
+	return func(s *Config) {
+		s.netlinkIptables = true
+	}
+}
+
 // NewSupervisor will create a new connection supervisor that uses IPTables
 // to redirect specific packets to userspace. It instantiates multiple data stores
 // to maintain efficient mappings between contextID, policy and IP addresses. This
 // simplifies the lookup operations at the expense of memory.
-func NewSupervisor(collector collector.EventCollector, enforcerInstance policyenforcer.Enforcer, mode constants.ModeType, networks []string) (*Config, error) {
+func NewSupervisor(collector collector.EventCollector, enforcerInstance policyenforcer.Enforcer, mode constants.ModeType, implementation constants.ImplementationType, networks []string, opts ...Option) (*Config, error) {
 
 	if collector == nil || enforcerInstance == nil {
 		return nil, errors.New("Invalid parameters")
@@ -68,45 +182,181 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance policyen
 		return nil, errors.New("portSetInstance cannot be nil")
 	}
 
-	impl, err := iptablesctrl.NewInstance(filterQueue, mode, portSetInstance)
+	s := &Config{
+		mode:              mode,
+		versionTracker:    cache.NewCache("SupVersionTracker"),
+		collector:         collector,
+		filterQueue:       filterQueue,
+		excludedIPs:       []string{},
+		triremeNetworks:   networks,
+		portSetInstance:   portSetInstance,
+		reconcileInterval: DefaultReconcileInterval,
+		healthChecks:      map[string]*healthChecker{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	cfg := &iptablesctrl.Config{
+		DryRun:          s.dryRun,
+		AuditLog:        s.auditLog,
+		WarmRestart:     s.warmRestart,
+		NFLOGRate:       s.nflogRate,
+		NFLOGBurst:      s.nflogBurst,
+		NFLOGThreshold:  s.nflogThreshold,
+		ClampMSS:        s.clampMSS,
+		UDPEnforcement:  s.udpEnforcement,
+		NetlinkIptables: s.netlinkIptables,
+	}
+
+	var err error
+	switch implementation {
+	case constants.IPSets:
+		s.impl, err = iptablesctrl.NewIpsetInstance(filterQueue, mode, portSetInstance, cfg)
+	default:
+		s.impl, err = iptablesctrl.NewInstance(filterQueue, mode, portSetInstance, cfg)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize supervisor controllers: %s", err)
 	}
 
-	return &Config{
-		mode:            mode,
-		impl:            impl,
-		versionTracker:  cache.NewCache("SupVersionTracker"),
-		collector:       collector,
-		filterQueue:     filterQueue,
-		excludedIPs:     []string{},
-		triremeNetworks: networks,
-		portSetInstance: portSetInstance,
-	}, nil
+	return s, nil
 }
 
 // Supervise creates a mapping between an IP address and the corresponding labels.
 // it invokes the various handlers that process the parameter policy.
-func (s *Config) Supervise(contextID string, pu *policy.PUInfo) error {
+func (s *Config) Supervise(ctx context.Context, contextID string, pu *policy.PUInfo) error {
 
 	if pu == nil || pu.Policy == nil || pu.Runtime == nil {
 		return errors.New("Invalid PU or policy info")
 	}
 
-	_, err := s.versionTracker.Get(contextID)
+	return s.run(ctx, contextID, func() error {
+		_, err := s.versionTracker.Get(contextID)
+		if err != nil {
+			// ContextID is not found in Cache, New PU: Do create.
+			err = s.doCreatePU(contextID, pu)
+		} else {
+			// Context already in the cache. Just run update
+			err = s.doUpdatePU(contextID, pu)
+		}
+
+		if err == nil {
+			s.superviseHealthCheck(contextID, pu)
+		}
+
+		return err
+	})
+}
+
+// GetRules returns the chains, rules and sets currently programmed for a PU
+func (s *Config) GetRules(contextID string) (*policy.PURules, error) {
+
+	s.rulesLock.RLock()
+	defer s.rulesLock.RUnlock()
+
+	data, err := s.versionTracker.Get(contextID)
 	if err != nil {
-		// ContextID is not found in Cache, New PU: Do create.
-		return s.doCreatePU(contextID, pu)
+		return nil, fmt.Errorf("unable to find pu %s in cache: %s", contextID, err)
 	}
 
-	// Context already in the cache. Just run update
-	return s.doUpdatePU(contextID, pu)
+	c := data.(*cacheData)
+
+	return s.impl.GetRules(c.version, contextID, c.containerInfo)
+}
+
+// Save returns the rules programmed by a dry run supervisor in the format of
+// iptables-save
+func (s *Config) Save() (string, error) {
+
+	s.rulesLock.RLock()
+	defer s.rulesLock.RUnlock()
+
+	saver, ok := s.impl.(Saver)
+	if !ok {
+		return "", errors.New("the implementation cannot save its rules")
+	}
+
+	return saver.Save()
+}
+
+// CleanStaleRules removes the rules of the previous run that were not adopted
+// by a PU on a warm restart. It must be called once the PUs of the previous
+// run are supervised again.
+func (s *Config) CleanStaleRules() error {
+
+	s.rulesLock.Lock()
+	defer s.rulesLock.Unlock()
+
+	adopter, ok := s.impl.(Adopter)
+	if !ok || !s.warmRestart {
+		return nil
+	}
+
+	return adopter.CleanStaleRules()
+}
+
+// AuditLog returns the last iptables and ipset operations of the supervisor,
+// the oldest first. It returns nothing unless the supervisor was created with
+// OptionAuditLog.
+func (s *Config) AuditLog() []provider.AuditEntry {
+
+	if s.auditLog == nil {
+		return nil
+	}
+
+	return s.auditLog.Entries()
 }
 
 // Unsupervise removes the mapping from cache and cleans up the iptable rules. ALL
 // remove operations will print errors by they don't return error. We want to force
 // as much cleanup as possible to avoid stale state
-func (s *Config) Unsupervise(contextID string) error {
+func (s *Config) Unsupervise(ctx context.Context, contextID string) error {
+
+	return s.run(ctx, contextID, func() error {
+		return s.unsupervise(contextID)
+	})
+}
+
+// run runs an operation on the rules of a PU with the worker pool, or in its
+// own goroutine if there are no workers, and holds the rulesLock until the
+// operation ends. The operation is not run if the context is done before it
+// starts. It is not interrupted once it started, so that the rules stay
+// consistent, but the caller does not wait for it after the context is done.
+func (s *Config) run(ctx context.Context, contextID string, operation func() error) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The lock is released by the goroutine of the operation, since it may
+	// outlive the caller
+	s.rulesLock.RLock()
+
+	done := make(chan error, 1)
+	go func() {
+		defer s.rulesLock.RUnlock()
+
+		if s.pool == nil {
+			done <- operation()
+			return
+		}
+
+		done <- s.pool.run(ctx, contextID, operation)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for the rules of %s: %s", contextID, ctx.Err())
+	}
+}
+
+func (s *Config) unsupervise(contextID string) error {
+
+	s.stopHealthCheck(contextID)
 
 	data, err := s.versionTracker.Get(contextID)
 	if err != nil {
@@ -135,16 +385,155 @@ func (s *Config) Start() error {
 		return fmt.Errorf("unable to start the implementer: %s", err)
 	}
 
+	s.Lock()
+	workers := s.workers
+	s.Unlock()
+
+	if workers > 0 {
+		s.rulesLock.Lock()
+		if s.pool == nil {
+			s.pool = newWorkerPool(workers)
+		}
+		s.rulesLock.Unlock()
+	}
+
 	s.Lock()
 	defer s.Unlock()
-	return s.impl.SetTargetNetworks([]string{}, s.triremeNetworks)
+	if err := s.impl.SetTargetNetworks([]string{}, s.triremeNetworks); err != nil {
+		return err
+	}
+
+	if reconciler, ok := s.impl.(Reconciler); ok && s.reconcileInterval > 0 {
+		s.stopReconcile = make(chan struct{})
+		go s.reconcileLoop(reconciler, s.reconcileInterval, s.stopReconcile)
+	}
+
+	return nil
 }
 
 // Stop stops the supervisor
 func (s *Config) Stop() error {
+
+	s.Lock()
+	if s.stopReconcile != nil {
+		close(s.stopReconcile)
+		s.stopReconcile = nil
+	}
+	s.Unlock()
+
+	// The operations in progress are completed before the workers stop
+	s.rulesLock.Lock()
+	pool := s.pool
+	s.pool = nil
+	s.rulesLock.Unlock()
+
+	if pool != nil {
+		pool.stop()
+	}
+
+	s.healthLock.Lock()
+	for contextID, checker := range s.healthChecks {
+		checker.close()
+		delete(s.healthChecks, contextID)
+	}
+	s.healthLock.Unlock()
+
 	return s.impl.Stop()
 }
 
+// SetFailMode sets what happens to the traffic of the PUs when the enforcer
+// stops reading the queues. It must be called before Start.
+func (s *Config) SetFailMode(mode constants.FailMode) {
+
+	s.Lock()
+	defer s.Unlock()
+	s.impl.SetFailMode(mode)
+}
+
+// SetReconcileInterval sets the period of the verification of the rules. The
+// rules are not verified if the interval is zero. It must be called before Start.
+func (s *Config) SetReconcileInterval(interval time.Duration) {
+
+	s.Lock()
+	defer s.Unlock()
+	s.reconcileInterval = interval
+}
+
+// SetWorkers sets the number of PUs whose rules are programmed concurrently.
+// The operations of the same PU are still run one at a time, in order. The
+// rules are programmed by the callers if there are no workers, which is the
+// default. It must be called before Start.
+func (s *Config) SetWorkers(workers int) {
+
+	s.Lock()
+	defer s.Unlock()
+	s.workers = workers
+}
+
+// reconcileLoop verifies the rules periodically until it is stopped
+func (s *Config) reconcileLoop(reconciler Reconciler, interval time.Duration, stop chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.reconcile(reconciler)
+		}
+	}
+}
+
+// reconcile verifies the global rules and the rules of all the PUs and
+// reports the ones that had to be programmed again. The rules of the PUs
+// are not changed while they are verified.
+func (s *Config) reconcile(reconciler Reconciler) {
+
+	s.rulesLock.Lock()
+	defer s.rulesLock.Unlock()
+
+	s.Lock()
+	drift, err := reconciler.ReconcileGlobalRules()
+	s.Unlock()
+
+	if err != nil {
+		zap.L().Error("Unable to reconcile the global rules", zap.Error(err))
+	}
+
+	if drift {
+		s.collector.CollectContainerEvent(&collector.ContainerRecord{
+			Event: collector.ContainerRulesDrift,
+		})
+	}
+
+	for _, key := range s.versionTracker.KeyList() {
+
+		contextID := key.(string)
+
+		data, err := s.versionTracker.Get(contextID)
+		if err != nil {
+			continue
+		}
+
+		c := data.(*cacheData)
+		drift, err := reconciler.ReconcileRules(c.version, contextID, c.containerInfo)
+		if err != nil {
+			zap.L().Error("Unable to reconcile the rules of the PU", zap.String("contextID", contextID), zap.Error(err))
+		}
+
+		if drift {
+			s.collector.CollectContainerEvent(&collector.ContainerRecord{
+				ContextID: contextID,
+				IPAddress: c.ips,
+				Tags:      c.containerInfo.Runtime.Tags(),
+				Event:     collector.ContainerRulesDrift,
+			})
+		}
+	}
+}
+
 // SetTargetNetworks sets the target networks of the supervisor
 func (s *Config) SetTargetNetworks(networks []string) error {
 
@@ -160,24 +549,90 @@ func (s *Config) SetTargetNetworks(networks []string) error {
 	return s.impl.SetTargetNetworks(s.triremeNetworks, networks)
 }
 
+// UpdateFilterQueue implements the FilterQueueUpdater interface. The global
+// rules are programmed for the new queues, and the rules of the PUs are
+// programmed again with a new version. The PUs whose rules cannot be
+// programmed are not supervised anymore.
+func (s *Config) UpdateFilterQueue(fq *fqconfig.FilterQueue) error {
+
+	if fq == nil {
+		return errors.New("filter queue cannot be nil")
+	}
+
+	updater, ok := s.impl.(FilterQueueUpdater)
+	if !ok {
+		return errors.New("the supervisor implementation cannot update the filter queues")
+	}
+
+	s.rulesLock.Lock()
+	defer s.rulesLock.Unlock()
+
+	s.Lock()
+	err := updater.UpdateFilterQueue(fq)
+	if err == nil {
+		s.filterQueue = fq
+	}
+	s.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("unable to update the filter queues: %s", err)
+	}
+
+	var failed error
+	for _, key := range s.versionTracker.KeyList() {
+
+		contextID := key.(string)
+
+		data, err := s.versionTracker.LockedModify(contextID, revert, 1)
+		if err != nil {
+			continue
+		}
+
+		c := data.(*cacheData)
+		if err := s.impl.UpdateRules(c.version, contextID, c.containerInfo, c.containerInfo); err != nil {
+			zap.L().Error("Unable to program the rules of the PU for the new queues", zap.String("contextID", contextID), zap.Error(err))
+			s.unsupervise(contextID) // nolint
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+
+	return failed
+}
+
 func (s *Config) doCreatePU(contextID string, pu *policy.PUInfo) error {
 
 	c := &cacheData{
 		version:       0,
 		ips:           pu.Policy.IPAddresses(),
 		mark:          pu.Runtime.Options().CgroupMark,
-		port:          policy.ConvertServicesToPortList(pu.Runtime.Options().Services),
-		uid:           pu.Runtime.Options().UserID,
+		port:          policy.ConvertServicesToProtocolPortList(pu.Runtime.Options().Services),
+		uid:           portset.PUOwner(pu.Runtime.Options().UserID, pu.Runtime.Options().GroupID),
 		containerInfo: pu,
 	}
 
 	// Version the policy so that we can do hitless policy changes
 	s.versionTracker.AddOrUpdate(contextID, c)
 
+	// The rules of the previous run are adopted on a warm restart
+	if adopter, ok := s.impl.(Adopter); ok && s.warmRestart {
+		version, adopted, err := adopter.AdoptRules(contextID, pu)
+		if err != nil {
+			s.unsupervise(contextID) // nolint
+			return err
+		}
+
+		if adopted {
+			c.version = version
+			return nil
+		}
+	}
+
 	// Configure the rules
 	if err := s.impl.ConfigureRules(c.version, contextID, pu); err != nil {
 		// Revert what you can since we have an error - it will fail most likely
-		s.Unsupervise(contextID) // nolint
+		s.unsupervise(contextID) // nolint
 		return err
 	}
 
@@ -185,9 +640,30 @@ func (s *Config) doCreatePU(contextID string, pu *policy.PUInfo) error {
 }
 
 // UpdatePU creates a mapping between an IP address and the corresponding labels
-//and the invokes the various handlers that process all policies.
+// and the invokes the various handlers that process all policies.
 func (s *Config) doUpdatePU(contextID string, pu *policy.PUInfo) error {
 
+	// Small policy changes are applied to the current version of the rules
+	if updater, ok := s.impl.(InPlaceUpdater); ok {
+		data, err := s.versionTracker.Get(contextID)
+		if err != nil {
+			return fmt.Errorf("unable to find pu %s in cache: %s", contextID, err)
+		}
+
+		c := data.(*cacheData)
+		updated, err := updater.UpdateRulesInPlace(c.version, contextID, pu, c.containerInfo)
+		if err != nil {
+			// Try to clean up, even though this is fatal and it will most likely fail
+			s.unsupervise(contextID) // nolint
+			return err
+		}
+
+		if updated {
+			_, err = s.versionTracker.LockedModify(contextID, updateContainerInfo, pu)
+			return err
+		}
+	}
+
 	data, err := s.versionTracker.LockedModify(contextID, revert, 1)
 	if err != nil {
 		return fmt.Errorf("unable to find pu %s in cache: %s", contextID, err)
@@ -196,11 +672,12 @@ func (s *Config) doUpdatePU(contextID string, pu *policy.PUInfo) error {
 	c := data.(*cacheData)
 	if err := s.impl.UpdateRules(c.version, contextID, pu, c.containerInfo); err != nil {
 		// Try to clean up, even though this is fatal and it will most likely fail
-		s.Unsupervise(contextID) // nolint
+		s.unsupervise(contextID) // nolint
 		return err
 	}
 
-	return nil
+	_, err = s.versionTracker.LockedModify(contextID, updateContainerInfo, pu)
+	return err
 }
 
 func revert(a, b interface{}) interface{} {
@@ -208,3 +685,11 @@ func revert(a, b interface{}) interface{} {
 	entry.version = entry.version ^ 1
 	return entry
 }
+
+// updateContainerInfo keeps the policy of the last update, that the next
+// update is compared to
+func updateContainerInfo(a, b interface{}) interface{} {
+	entry := a.(*cacheData)
+	entry.containerInfo = b.(*policy.PUInfo)
+	return entry
+}
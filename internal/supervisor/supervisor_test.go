@@ -1,12 +1,15 @@
 package supervisor
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
 	mock_supervisor "github.com/aporeto-inc/trireme-lib/internal/supervisor/mock"
 	"github.com/aporeto-inc/trireme-lib/policy"
@@ -54,7 +57,7 @@ func TestNewSupervisor(t *testing.T) {
 		mode := constants.LocalServer
 
 		Convey("When I provide correct parameters", func() {
-			s, err := NewSupervisor(c, e, mode, []string{})
+			s, err := NewSupervisor(c, e, mode, constants.IPTables, []string{})
 			Convey("I should not get an error ", func() {
 				So(err, ShouldBeNil)
 				So(s, ShouldNotBeNil)
@@ -63,7 +66,7 @@ func TestNewSupervisor(t *testing.T) {
 		})
 
 		Convey("When I provide a nil  collector", func() {
-			s, err := NewSupervisor(nil, e, mode, []string{})
+			s, err := NewSupervisor(nil, e, mode, constants.IPTables, []string{})
 			Convey("I should get an error ", func() {
 				So(err, ShouldNotBeNil)
 				So(s, ShouldBeNil)
@@ -71,7 +74,7 @@ func TestNewSupervisor(t *testing.T) {
 		})
 
 		Convey("When I provide a nil enforcer", func() {
-			s, err := NewSupervisor(c, nil, mode, []string{})
+			s, err := NewSupervisor(c, nil, mode, constants.IPTables, []string{})
 			Convey("I should get an error ", func() {
 				So(err, ShouldNotBeNil)
 				So(s, ShouldBeNil)
@@ -90,14 +93,14 @@ func TestSupervise(t *testing.T) {
 		secrets := secrets.NewPSKSecrets([]byte("test password"))
 		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
 
-		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{})
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{})
 		So(s, ShouldNotBeNil)
 
 		impl := mock_supervisor.NewMockImplementor(ctrl)
 		s.impl = impl
 
 		Convey("When I supervise a new PU with invalid policy", func() {
-			err := s.Supervise("contextID", nil)
+			err := s.Supervise(context.Background(), "contextID", nil)
 			Convey("I should get an error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -107,7 +110,7 @@ func TestSupervise(t *testing.T) {
 
 		Convey("When I supervise a new PU with valid policy", func() {
 			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
-			err := s.Supervise("contextID", puInfo)
+			err := s.Supervise(context.Background(), "contextID", puInfo)
 			Convey("I should not get an error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -116,7 +119,7 @@ func TestSupervise(t *testing.T) {
 		Convey("When I supervise a new PU with valid policy, but there is an error", func() {
 			impl.EXPECT().ConfigureRules(0, "errorPU", puInfo).Return(errors.New("error"))
 			impl.EXPECT().DeleteRules(0, "errorPU", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
-			err := s.Supervise("errorPU", puInfo)
+			err := s.Supervise(context.Background(), "errorPU", puInfo)
 			Convey("I should  get an error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -125,9 +128,9 @@ func TestSupervise(t *testing.T) {
 		Convey("When I send supervise command for a second time, it should do an update", func() {
 			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
 			impl.EXPECT().UpdateRules(1, "contextID", gomock.Any(), gomock.Any()).Return(nil)
-			noerr := s.Supervise("contextID", puInfo)
+			noerr := s.Supervise(context.Background(), "contextID", puInfo)
 			So(noerr, ShouldBeNil)
-			err := s.Supervise("contextID", puInfo)
+			err := s.Supervise(context.Background(), "contextID", puInfo)
 			Convey("I should not get an error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -137,9 +140,9 @@ func TestSupervise(t *testing.T) {
 			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
 			impl.EXPECT().UpdateRules(1, "contextID", gomock.Any(), gomock.Any()).Return(errors.New("error"))
 			impl.EXPECT().DeleteRules(1, "contextID", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
-			serr := s.Supervise("contextID", puInfo)
+			serr := s.Supervise(context.Background(), "contextID", puInfo)
 			So(serr, ShouldBeNil)
-			err := s.Supervise("contextID", puInfo)
+			err := s.Supervise(context.Background(), "contextID", puInfo)
 			Convey("I should get an error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -148,6 +151,61 @@ func TestSupervise(t *testing.T) {
 	})
 }
 
+// testInPlaceImplementor updates the rules in place when asked to
+type testInPlaceImplementor struct {
+	*mock_supervisor.MockImplementor
+	inPlace bool
+	old     []*policy.PUInfo
+}
+
+func (i *testInPlaceImplementor) UpdateRulesInPlace(version int, contextID string, containerInfo *policy.PUInfo, oldContainerInfo *policy.PUInfo) (bool, error) {
+	i.old = append(i.old, oldContainerInfo)
+	return i.inPlace, nil
+}
+
+func TestSuperviseInPlace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with an implementor that updates the rules in place", t, func() {
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{})
+		So(s, ShouldNotBeNil)
+
+		impl := &testInPlaceImplementor{MockImplementor: mock_supervisor.NewMockImplementor(ctrl)}
+		s.impl = impl
+
+		puInfo := createPUInfo()
+		updatedPUInfo := createPUInfo()
+		impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+		So(s.Supervise(context.Background(), "contextID", puInfo), ShouldBeNil)
+
+		Convey("When the update is done in place, the version should not change", func() {
+			impl.inPlace = true
+			So(s.Supervise(context.Background(), "contextID", updatedPUInfo), ShouldBeNil)
+			So(s.Supervise(context.Background(), "contextID", puInfo), ShouldBeNil)
+
+			So(impl.old, ShouldResemble, []*policy.PUInfo{puInfo, updatedPUInfo})
+			data, err := s.versionTracker.Get("contextID")
+			So(err, ShouldBeNil)
+			So(data.(*cacheData).version, ShouldEqual, 0)
+		})
+
+		Convey("When the update cannot be done in place, a new version should be programmed", func() {
+			impl.EXPECT().UpdateRules(1, "contextID", updatedPUInfo, puInfo).Return(nil)
+			So(s.Supervise(context.Background(), "contextID", updatedPUInfo), ShouldBeNil)
+
+			data, err := s.versionTracker.Get("contextID")
+			So(err, ShouldBeNil)
+			So(data.(*cacheData).version, ShouldEqual, 1)
+			So(data.(*cacheData).containerInfo, ShouldEqual, updatedPUInfo)
+		})
+	})
+}
+
 func TestUnsupervise(t *testing.T) {
 
 	ctrl := gomock.NewController(t)
@@ -158,14 +216,14 @@ func TestUnsupervise(t *testing.T) {
 		secrets := secrets.NewPSKSecrets([]byte("test password"))
 		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
 
-		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"})
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"})
 		So(s, ShouldNotBeNil)
 
 		impl := mock_supervisor.NewMockImplementor(ctrl)
 		s.impl = impl
 
 		Convey("When I try to unsupervise a PU that was not see before", func() {
-			err := s.Unsupervise("badContext")
+			err := s.Unsupervise(context.Background(), "badContext")
 			Convey("I should get an error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -176,9 +234,9 @@ func TestUnsupervise(t *testing.T) {
 		Convey("When I try to unsupervise a valid PU ", func() {
 			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
 			impl.EXPECT().DeleteRules(0, "contextID", gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
-			serr := s.Supervise("contextID", puInfo)
+			serr := s.Supervise(context.Background(), "contextID", puInfo)
 			So(serr, ShouldBeNil)
-			err := s.Unsupervise("contextID")
+			err := s.Unsupervise(context.Background(), "contextID")
 			Convey("I should get no errors", func() {
 				So(err, ShouldBeNil)
 			})
@@ -186,6 +244,170 @@ func TestUnsupervise(t *testing.T) {
 	})
 }
 
+func TestGetRules(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a properly configured supervisor", t, func() {
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"})
+		So(s, ShouldNotBeNil)
+
+		impl := mock_supervisor.NewMockImplementor(ctrl)
+		s.impl = impl
+
+		Convey("When I get the rules of a PU that was not seen before, I should get an error", func() {
+			_, err := s.GetRules("badContext")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When I get the rules of a supervised PU, I should get the rules of its current version", func() {
+			puInfo := createPUInfo()
+			rules := &policy.PURules{Chains: map[string][]string{"app": {"-A app -j DROP"}}}
+
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			impl.EXPECT().GetRules(0, "contextID", puInfo).Return(rules, nil)
+			So(s.Supervise(context.Background(), "contextID", puInfo), ShouldBeNil)
+
+			r, err := s.GetRules("contextID")
+			So(err, ShouldBeNil)
+			So(r, ShouldEqual, rules)
+		})
+	})
+}
+
+// testReconciler reports drift for the configured contexts
+type testReconciler struct {
+	*mock_supervisor.MockImplementor
+	globalDrift bool
+	drift       map[string]bool
+}
+
+func (r *testReconciler) ReconcileGlobalRules() (bool, error) {
+	return r.globalDrift, nil
+}
+
+func (r *testReconciler) ReconcileRules(version int, contextID string, containerInfo *policy.PUInfo) (bool, error) {
+	return r.drift[contextID], nil
+}
+
+// testEventCollector records the container events
+type testEventCollector struct {
+	collector.DefaultCollector
+	records []*collector.ContainerRecord
+}
+
+func (c *testEventCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.records = append(c.records, record)
+}
+
+func TestReconcile(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with an implementor that reconciles the rules", t, func() {
+		c := &testEventCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"})
+		So(s, ShouldNotBeNil)
+		So(s.reconcileInterval, ShouldEqual, DefaultReconcileInterval)
+
+		impl := &testReconciler{MockImplementor: mock_supervisor.NewMockImplementor(ctrl), drift: map[string]bool{}}
+		s.impl = impl
+
+		impl.EXPECT().ConfigureRules(0, "contextID", gomock.Any()).Return(nil)
+		impl.EXPECT().ConfigureRules(0, "otherID", gomock.Any()).Return(nil)
+		So(s.Supervise(context.Background(), "contextID", createPUInfo()), ShouldBeNil)
+		So(s.Supervise(context.Background(), "otherID", createPUInfo()), ShouldBeNil)
+
+		Convey("When no rule is missing, no event should be reported", func() {
+			s.reconcile(impl)
+			So(len(c.records), ShouldEqual, 0)
+		})
+
+		Convey("When rules are missing, an event should be reported for each drift", func() {
+			impl.globalDrift = true
+			impl.drift["contextID"] = true
+			s.reconcile(impl)
+
+			So(len(c.records), ShouldEqual, 2)
+			So(c.records[0].ContextID, ShouldEqual, "")
+			So(c.records[0].Event, ShouldEqual, collector.ContainerRulesDrift)
+			So(c.records[1].ContextID, ShouldEqual, "contextID")
+			So(c.records[1].Event, ShouldEqual, collector.ContainerRulesDrift)
+		})
+
+		Convey("When the interval is zero, the rules should not be reconciled", func() {
+			s.SetReconcileInterval(0)
+			impl.EXPECT().Start().Return(nil)
+			impl.EXPECT().SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}).Return(nil)
+			So(s.Start(), ShouldBeNil)
+			So(s.stopReconcile, ShouldBeNil)
+		})
+	})
+}
+
+// testQueueUpdater keeps the filter queue it is updated with
+type testQueueUpdater struct {
+	*mock_supervisor.MockImplementor
+	fq *fqconfig.FilterQueue
+}
+
+func (u *testQueueUpdater) UpdateFilterQueue(fq *fqconfig.FilterQueue) error {
+	u.fq = fq
+	return nil
+}
+
+func TestUpdateFilterQueue(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with a PU", t, func() {
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{})
+		So(s, ShouldNotBeNil)
+
+		puInfo := createPUInfo()
+		fq := fqconfig.NewFilterQueue(true, fqconfig.DefaultMarkValue, 0, 2, 2, 100, 100)
+
+		Convey("When its implementor can update the filter queues", func() {
+			impl := &testQueueUpdater{MockImplementor: mock_supervisor.NewMockImplementor(ctrl)}
+			s.impl = impl
+
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			So(s.Supervise(context.Background(), "contextID", puInfo), ShouldBeNil)
+
+			impl.EXPECT().UpdateRules(1, "contextID", puInfo, puInfo).Return(nil)
+			So(s.UpdateFilterQueue(fq), ShouldBeNil)
+
+			Convey("Then the rules of the PU should be programmed again with a new version", func() {
+				So(impl.fq, ShouldEqual, fq)
+				So(s.filterQueue, ShouldEqual, fq)
+
+				data, err := s.versionTracker.Get("contextID")
+				So(err, ShouldBeNil)
+				So(data.(*cacheData).version, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When its implementor cannot update the filter queues, it should fail", func() {
+			s.impl = mock_supervisor.NewMockImplementor(ctrl)
+			So(s.UpdateFilterQueue(fq), ShouldNotBeNil)
+		})
+	})
+}
+
 func TestStart(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -195,7 +417,7 @@ func TestStart(t *testing.T) {
 		secrets := secrets.NewPSKSecrets([]byte("test password"))
 		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
 
-		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"})
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"})
 		So(s, ShouldNotBeNil)
 
 		impl := mock_supervisor.NewMockImplementor(ctrl)
@@ -229,7 +451,7 @@ func TestStop(t *testing.T) {
 		secrets := secrets.NewPSKSecrets([]byte("test password"))
 		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
 
-		s, _ := NewSupervisor(c, e, constants.RemoteContainer, []string{"172.17.0.0/16"})
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"})
 		So(s, ShouldNotBeNil)
 
 		impl := mock_supervisor.NewMockImplementor(ctrl)
@@ -252,3 +474,233 @@ func TestStop(t *testing.T) {
 		})
 	})
 }
+
+func TestSuperviseWithWorkers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with workers", t, func() {
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"})
+		So(s, ShouldNotBeNil)
+
+		impl := mock_supervisor.NewMockImplementor(ctrl)
+		s.impl = impl
+		s.SetWorkers(4)
+
+		impl.EXPECT().Start().Return(nil)
+		impl.EXPECT().SetTargetNetworks([]string{}, []string{"172.17.0.0/16"}).Return(nil)
+		So(s.Start(), ShouldBeNil)
+		So(s.pool, ShouldNotBeNil)
+
+		Convey("When I supervise and unsupervise PUs concurrently, their rules should be programmed and deleted", func() {
+			puInfo := createPUInfo()
+			impl.EXPECT().ConfigureRules(0, gomock.Any(), puInfo).Return(nil).Times(10)
+			impl.EXPECT().DeleteRules(0, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(10)
+
+			errs := make(chan error, 20)
+			for i := 0; i < 10; i++ {
+				go func(contextID string) {
+					if err := s.Supervise(context.Background(), contextID, puInfo); err != nil {
+						errs <- err
+						return
+					}
+					errs <- s.Unsupervise(context.Background(), contextID)
+				}(fmt.Sprintf("contextID%d", i))
+			}
+
+			for i := 0; i < 10; i++ {
+				So(<-errs, ShouldBeNil)
+			}
+			So(s.versionTracker.KeyList(), ShouldBeEmpty)
+		})
+
+		Convey("When I stop it, the workers should be stopped", func() {
+			impl.EXPECT().Stop().Return(nil)
+			So(s.Stop(), ShouldBeNil)
+			So(s.pool, ShouldBeNil)
+		})
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	Convey("Given a dry run supervisor", t, func() {
+
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, err := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"}, OptionDryRun())
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+
+		Convey("When I supervise a PU, its rules should be saved", func() {
+			So(s.Supervise(context.Background(), "contextID", createPUInfo()), ShouldBeNil)
+
+			rules, err := s.Save()
+			So(err, ShouldBeNil)
+			So(rules, ShouldContainSubstring, "*mangle\n")
+			So(rules, ShouldContainSubstring, ":TRIREME-App-contmeGKj6-0 - [0:0]\n")
+			So(rules, ShouldContainSubstring, "192.30.253.0/24")
+
+			Convey("When I unsupervise it, its rules should be removed", func() {
+				So(s.Unsupervise(context.Background(), "contextID"), ShouldBeNil)
+
+				rules, err := s.Save()
+				So(err, ShouldBeNil)
+				So(rules, ShouldNotContainSubstring, "TRIREME-App-cont")
+				So(rules, ShouldNotContainSubstring, "192.30.253.0/24")
+			})
+		})
+
+		Convey("When I stop it, the rules should be cleaned", func() {
+			So(s.Stop(), ShouldBeNil)
+
+			rules, err := s.Save()
+			So(err, ShouldBeNil)
+			So(rules, ShouldNotContainSubstring, "TRIREME-")
+		})
+	})
+
+	Convey("Given a dry run supervisor with the netlink providers", t, func() {
+
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, err := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"}, OptionDryRun(), OptionNetlinkIptables())
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+
+		Convey("When I supervise a PU, its rules should be saved", func() {
+			So(s.Supervise(context.Background(), "contextID", createPUInfo()), ShouldBeNil)
+
+			rules, err := s.Save()
+			So(err, ShouldBeNil)
+			So(rules, ShouldContainSubstring, ":TRIREME-App-contmeGKj6-0 - [0:0]\n")
+		})
+	})
+}
+
+func TestClampMSS(t *testing.T) {
+	Convey("Given a dry run supervisor that clamps the MSS", t, func() {
+
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, err := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"}, OptionDryRun(), OptionClampMSS(1400))
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+
+		Convey("Then the MSS of the handshakes should be clamped", func() {
+			rules, err := s.Save()
+			So(err, ShouldBeNil)
+			So(rules, ShouldContainSubstring, "-j TCPMSS --set-mss 1400")
+		})
+
+		Convey("When I stop it, the clamping rules should be cleaned", func() {
+			So(s.Stop(), ShouldBeNil)
+
+			rules, err := s.Save()
+			So(err, ShouldBeNil)
+			So(rules, ShouldNotContainSubstring, "TCPMSS")
+		})
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	Convey("Given a dry run supervisor with an audit log", t, func() {
+
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, err := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"}, OptionDryRun(), OptionAuditLog(1000))
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+
+		sets := map[string]bool{}
+		for _, entry := range s.AuditLog() {
+			sets[entry.Set] = true
+		}
+		So(sets, ShouldContainKey, "TargetNetSet")
+
+		Convey("When I supervise a PU, its operations should be recorded", func() {
+			started := len(s.AuditLog())
+			So(s.Supervise(context.Background(), "contextID", createPUInfo()), ShouldBeNil)
+
+			entries := s.AuditLog()[started:]
+			So(entries, ShouldNotBeEmpty)
+
+			restored := ""
+			for _, entry := range entries {
+				So(entry.Err, ShouldBeNil)
+				if entry.Operation == "Restore" {
+					restored += entry.Args[0]
+				}
+			}
+			So(restored, ShouldContainSubstring, ":TRIREME-App-contmeGKj6-0 - [0:0]")
+		})
+	})
+
+	Convey("Given a supervisor without an audit log, no operation should be returned", t, func() {
+
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		s, err := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"}, OptionDryRun())
+		So(err, ShouldBeNil)
+		So(s.Start(), ShouldBeNil)
+		So(s.AuditLog(), ShouldBeEmpty)
+	})
+}
+
+func TestWarmRestart(t *testing.T) {
+	Convey("Given a dry run supervisor with a warm restart", t, func() {
+
+		c := &collector.DefaultCollector{}
+		secrets := secrets.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.RemoteContainer, "/proc")
+
+		prev, err := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"}, OptionDryRun(), OptionWarmRestart())
+		So(err, ShouldBeNil)
+		So(prev.Start(), ShouldBeNil)
+		So(prev.Supervise(context.Background(), "contextID", createPUInfo()), ShouldBeNil)
+		So(prev.Supervise(context.Background(), "staleID", createPUInfo()), ShouldBeNil)
+
+		Convey("When I stop it, the rules should be kept", func() {
+			So(prev.Stop(), ShouldBeNil)
+
+			rules, err := prev.Save()
+			So(err, ShouldBeNil)
+			So(rules, ShouldContainSubstring, ":TRIREME-App-contmeGKj6-0 - [0:0]\n")
+
+			Convey("When the next supervisor supervises the PU, its rules should be adopted", func() {
+				s, err := NewSupervisor(c, e, constants.RemoteContainer, constants.IPTables, []string{"172.17.0.0/16"}, OptionDryRun(), OptionWarmRestart())
+				So(err, ShouldBeNil)
+				s.impl = prev.impl
+				So(s.Start(), ShouldBeNil)
+
+				So(s.Supervise(context.Background(), "contextID", createPUInfo()), ShouldBeNil)
+
+				adopted, err := s.Save()
+				So(err, ShouldBeNil)
+				So(adopted, ShouldEqual, rules)
+
+				Convey("When I clean the stale rules, the rules of the other PU should be removed", func() {
+					So(s.CleanStaleRules(), ShouldBeNil)
+
+					cleaned, err := s.Save()
+					So(err, ShouldBeNil)
+					So(cleaned, ShouldContainSubstring, ":TRIREME-App-contmeGKj6-0 - [0:0]\n")
+					So(cleaned, ShouldNotContainSubstring, "TRIREME-App-stal")
+				})
+			})
+		})
+	})
+}
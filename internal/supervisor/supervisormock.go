@@ -1,6 +1,7 @@
 package supervisor
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -10,10 +11,10 @@ import (
 type mockedMethods struct {
 
 	// Supervise adds a new supervised processing unit.
-	superviseMock func(contextID string, puInfo *policy.PUInfo) error
+	superviseMock func(ctx context.Context, contextID string, puInfo *policy.PUInfo) error
 
 	// Unsupervise unsupervises the given PU
-	unsuperviseMock func(contextID string) error
+	unsuperviseMock func(ctx context.Context, contextID string) error
 
 	// Start starts the Supervisor.
 	startMock func() error
@@ -31,8 +32,8 @@ type mockedMethods struct {
 // TestSupervisor is a test implementation for IptablesProvider
 type TestSupervisor interface {
 	Supervisor
-	MockSupervise(t *testing.T, impl func(contextID string, puInfo *policy.PUInfo) error)
-	MockUnsupervise(t *testing.T, impl func(contextID string) error)
+	MockSupervise(t *testing.T, impl func(ctx context.Context, contextID string, puInfo *policy.PUInfo) error)
+	MockUnsupervise(t *testing.T, impl func(ctx context.Context, contextID string) error)
 	MockStart(t *testing.T, impl func() error)
 	MockStop(t *testing.T, impl func() error)
 	MockAddExcludedIPs(t *testing.T, impl func(ips []string) error)
@@ -60,13 +61,13 @@ func (m *TestSupervisorInst) MockAddExcludedIPs(t *testing.T, impl func(ip []str
 }
 
 // MockSupervise mocks the Supervise method
-func (m *TestSupervisorInst) MockSupervise(t *testing.T, impl func(contextID string, puInfo *policy.PUInfo) error) {
+func (m *TestSupervisorInst) MockSupervise(t *testing.T, impl func(ctx context.Context, contextID string, puInfo *policy.PUInfo) error) {
 
 	m.currentMocks(t).superviseMock = impl
 }
 
 // MockUnsupervise mocks the unsupervise method
-func (m *TestSupervisorInst) MockUnsupervise(t *testing.T, impl func(contextID string) error) {
+func (m *TestSupervisorInst) MockUnsupervise(t *testing.T, impl func(ctx context.Context, contextID string) error) {
 
 	m.currentMocks(t).unsuperviseMock = impl
 }
@@ -90,20 +91,20 @@ func (m *TestSupervisorInst) MockSetTargetNetworks(t *testing.T, impl func(netwo
 }
 
 // Supervise is a test implementation of the Supervise interface
-func (m *TestSupervisorInst) Supervise(contextID string, puInfo *policy.PUInfo) error {
+func (m *TestSupervisorInst) Supervise(ctx context.Context, contextID string, puInfo *policy.PUInfo) error {
 
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.superviseMock != nil {
-		return mock.superviseMock(contextID, puInfo)
+		return mock.superviseMock(ctx, contextID, puInfo)
 	}
 
 	return nil
 }
 
 // Unsupervise is a test implementation of the Unsupervise interface
-func (m *TestSupervisorInst) Unsupervise(contextID string) error {
+func (m *TestSupervisorInst) Unsupervise(ctx context.Context, contextID string) error {
 
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.unsuperviseMock != nil {
-		return mock.unsuperviseMock(contextID)
+		return mock.unsuperviseMock(ctx, contextID)
 	}
 
 	return nil
@@ -0,0 +1,91 @@
+package supervisor
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// workerJob is an operation on the rules of a PU
+type workerJob struct {
+	ctx  context.Context
+	run  func() error
+	done chan error
+}
+
+// workerPool programs the rules of the PUs with a bounded number of workers.
+// The operations of a PU always go to the same worker, so they run in order,
+// while the operations of the PUs of different workers run concurrently.
+type workerPool struct {
+	queues []chan *workerJob
+	wg     sync.WaitGroup
+}
+
+// newWorkerPool starts the workers of a pool
+func newWorkerPool(workers int) *workerPool {
+
+	p := &workerPool{
+		queues: make([]chan *workerJob, workers),
+	}
+
+	for idx := range p.queues {
+		p.queues[idx] = make(chan *workerJob)
+		p.wg.Add(1)
+		go p.work(p.queues[idx])
+	}
+
+	return p
+}
+
+// run runs an operation on the rules of a PU with its worker and waits for
+// its result. The operation is not run if the context is done before its
+// worker is available, but it is not interrupted once it started.
+func (p *workerPool) run(ctx context.Context, contextID string, operation func() error) error {
+
+	job := &workerJob{
+		ctx:  ctx,
+		run:  operation,
+		done: make(chan error, 1),
+	}
+
+	select {
+	case p.queues[p.worker(contextID)] <- job:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return <-job.done
+}
+
+// stop stops the workers once they have run the queued operations. No
+// operation must be run after the pool is stopped.
+func (p *workerPool) stop() {
+
+	for _, queue := range p.queues {
+		close(queue)
+	}
+
+	p.wg.Wait()
+}
+
+// worker returns the worker of a PU
+func (p *workerPool) worker(contextID string) int {
+
+	hash := fnv.New32a()
+	hash.Write([]byte(contextID)) // nolint
+
+	return int(hash.Sum32() % uint32(len(p.queues)))
+}
+
+func (p *workerPool) work(queue chan *workerJob) {
+
+	defer p.wg.Done()
+
+	for job := range queue {
+		if err := job.ctx.Err(); err != nil {
+			job.done <- err
+			continue
+		}
+		job.done <- job.run()
+	}
+}
@@ -0,0 +1,144 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWorkerPool(t *testing.T) {
+
+	Convey("Given a worker pool", t, func() {
+		p := newWorkerPool(4)
+		Reset(p.stop)
+
+		Convey("The result of an operation should be returned", func() {
+			So(p.run(context.Background(), "pu", func() error { return nil }), ShouldBeNil)
+			So(p.run(context.Background(), "pu", func() error { return fmt.Errorf("error") }), ShouldNotBeNil)
+		})
+
+		Convey("The operations of a PU should not run concurrently", func() {
+			var lock sync.Mutex
+			running, peak := 0, 0
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.run(context.Background(), "pu", func() error { // nolint
+						lock.Lock()
+						running++
+						if running > peak {
+							peak = running
+						}
+						lock.Unlock()
+
+						time.Sleep(time.Millisecond)
+
+						lock.Lock()
+						running--
+						lock.Unlock()
+						return nil
+					})
+				}()
+			}
+			wg.Wait()
+
+			So(peak, ShouldEqual, 1)
+		})
+
+		Convey("The operations of PUs of different workers should run concurrently", func() {
+			other := ""
+			for i := 0; other == ""; i++ {
+				if id := fmt.Sprintf("pu%d", i); p.worker(id) != p.worker("pu") {
+					other = id
+				}
+			}
+
+			// The operation of the first PU waits for the one of the other PU
+			ran := make(chan struct{})
+			blocked := make(chan error, 1)
+			go func() {
+				blocked <- p.run(context.Background(), "pu", func() error {
+					select {
+					case <-ran:
+						return nil
+					case <-time.After(5 * time.Second):
+						return fmt.Errorf("timeout")
+					}
+				})
+			}()
+
+			So(p.run(context.Background(), other, func() error {
+				close(ran)
+				return nil
+			}), ShouldBeNil)
+			So(<-blocked, ShouldBeNil)
+		})
+
+		Convey("The operations of a PU whose context is done before they start should not run", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			blocked := make(chan error, 1)
+			go func() {
+				blocked <- p.run(context.Background(), "pu", func() error {
+					close(started)
+					<-release
+					return nil
+				})
+			}()
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			ran := false
+			err := p.run(ctx, "pu", func() error {
+				ran = true
+				return nil
+			})
+			close(release)
+
+			So(err, ShouldEqual, context.DeadlineExceeded)
+			So(<-blocked, ShouldBeNil)
+			So(ran, ShouldBeFalse)
+		})
+
+		Convey("The number of operations running at once should be bounded by the workers", func() {
+			var lock sync.Mutex
+			running, peak := 0, 0
+
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(id string) {
+					defer wg.Done()
+					p.run(context.Background(), id, func() error { // nolint
+						lock.Lock()
+						running++
+						if running > peak {
+							peak = running
+						}
+						lock.Unlock()
+
+						time.Sleep(time.Millisecond)
+
+						lock.Lock()
+						running--
+						lock.Unlock()
+						return nil
+					})
+				}(fmt.Sprintf("pu%d", i))
+			}
+			wg.Wait()
+
+			So(peak, ShouldBeLessThanOrEqualTo, 4)
+			So(peak, ShouldBeGreaterThan, 1)
+		})
+	})
+}
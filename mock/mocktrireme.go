@@ -5,9 +5,14 @@
 package mocktrireme
 
 import (
+	context "context"
 	reflect "reflect"
 
+	trireme "github.com/aporeto-inc/trireme-lib"
+	collector "github.com/aporeto-inc/trireme-lib/collector"
 	constants "github.com/aporeto-inc/trireme-lib/constants"
+	diagnostics "github.com/aporeto-inc/trireme-lib/diagnostics"
+	fqconfig "github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	secrets "github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
 	supervisor "github.com/aporeto-inc/trireme-lib/internal/supervisor"
 	policy "github.com/aporeto-inc/trireme-lib/policy"
@@ -59,30 +64,30 @@ func (mr *MockTriremeMockRecorder) PURuntime(contextID interface{}) *gomock.Call
 
 // Start mocks base method
 // nolint
-func (m *MockTrireme) Start() error {
-	ret := m.ctrl.Call(m, "Start")
+func (m *MockTrireme) Start(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "Start", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Start indicates an expected call of Start
 // nolint
-func (mr *MockTriremeMockRecorder) Start() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockTrireme)(nil).Start))
+func (mr *MockTriremeMockRecorder) Start(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockTrireme)(nil).Start), ctx)
 }
 
 // Stop mocks base method
 // nolint
-func (m *MockTrireme) Stop() error {
-	ret := m.ctrl.Call(m, "Stop")
+func (m *MockTrireme) Stop(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "Stop", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Stop indicates an expected call of Stop
 // nolint
-func (mr *MockTriremeMockRecorder) Stop() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockTrireme)(nil).Stop))
+func (mr *MockTriremeMockRecorder) Stop(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockTrireme)(nil).Stop), ctx)
 }
 
 // Supervisor mocks base method
@@ -113,6 +118,20 @@ func (mr *MockTriremeMockRecorder) CreatePURuntime(contextID, runtimeInfo interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePURuntime", reflect.TypeOf((*MockTrireme)(nil).CreatePURuntime), contextID, runtimeInfo)
 }
 
+// UpdatePURuntime mocks base method
+// nolint
+func (m *MockTrireme) UpdatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	ret := m.ctrl.Call(m, "UpdatePURuntime", contextID, runtimeInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePURuntime indicates an expected call of UpdatePURuntime
+// nolint
+func (mr *MockTriremeMockRecorder) UpdatePURuntime(contextID, runtimeInfo interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePURuntime", reflect.TypeOf((*MockTrireme)(nil).UpdatePURuntime), contextID, runtimeInfo)
+}
+
 // HandlePUEvent mocks base method
 // nolint
 func (m *MockTrireme) HandlePUEvent(contextID string, event events.Event) error {
@@ -141,6 +160,148 @@ func (mr *MockTriremeMockRecorder) UpdatePolicy(contextID, policy interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePolicy", reflect.TypeOf((*MockTrireme)(nil).UpdatePolicy), contextID, policy)
 }
 
+// Diagnose mocks base method
+// nolint
+func (m *MockTrireme) Diagnose() *diagnostics.Report {
+	ret := m.ctrl.Call(m, "Diagnose")
+	ret0, _ := ret[0].(*diagnostics.Report)
+	return ret0
+}
+
+// Diagnose indicates an expected call of Diagnose
+// nolint
+func (mr *MockTriremeMockRecorder) Diagnose() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Diagnose", reflect.TypeOf((*MockTrireme)(nil).Diagnose))
+}
+
+// GetStats mocks base method
+// nolint
+func (m *MockTrireme) GetStats() []*collector.QueueStatsRecord {
+	ret := m.ctrl.Call(m, "GetStats")
+	ret0, _ := ret[0].([]*collector.QueueStatsRecord)
+	return ret0
+}
+
+// GetStats indicates an expected call of GetStats
+// nolint
+func (mr *MockTriremeMockRecorder) GetStats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStats", reflect.TypeOf((*MockTrireme)(nil).GetStats))
+}
+
+// UpdateFilterQueue mocks base method
+// nolint
+func (m *MockTrireme) UpdateFilterQueue(fq *fqconfig.FilterQueue) error {
+	ret := m.ctrl.Call(m, "UpdateFilterQueue", fq)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateFilterQueue indicates an expected call of UpdateFilterQueue
+// nolint
+func (mr *MockTriremeMockRecorder) UpdateFilterQueue(fq interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateFilterQueue", reflect.TypeOf((*MockTrireme)(nil).UpdateFilterQueue), fq)
+}
+
+// SetLogForwarding mocks base method
+// nolint
+func (m *MockTrireme) SetLogForwarding(contextID, level string) error {
+	ret := m.ctrl.Call(m, "SetLogForwarding", contextID, level)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLogForwarding indicates an expected call of SetLogForwarding
+// nolint
+func (mr *MockTriremeMockRecorder) SetLogForwarding(contextID, level interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogForwarding", reflect.TypeOf((*MockTrireme)(nil).SetLogForwarding), contextID, level)
+}
+
+// SetLogLevel mocks base method
+// nolint
+func (m *MockTrireme) SetLogLevel(contextID, level string) error {
+	ret := m.ctrl.Call(m, "SetLogLevel", contextID, level)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLogLevel indicates an expected call of SetLogLevel
+// nolint
+func (mr *MockTriremeMockRecorder) SetLogLevel(contextID, level interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLogLevel", reflect.TypeOf((*MockTrireme)(nil).SetLogLevel), contextID, level)
+}
+
+// SetDebugListener mocks base method
+// nolint
+func (m *MockTrireme) SetDebugListener(contextID string, enable bool) error {
+	ret := m.ctrl.Call(m, "SetDebugListener", contextID, enable)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetDebugListener indicates an expected call of SetDebugListener
+// nolint
+func (mr *MockTriremeMockRecorder) SetDebugListener(contextID, enable interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDebugListener", reflect.TypeOf((*MockTrireme)(nil).SetDebugListener), contextID, enable)
+}
+
+// ListPUs mocks base method
+// nolint
+func (m *MockTrireme) ListPUs() []*trireme.PUState {
+	ret := m.ctrl.Call(m, "ListPUs")
+	ret0, _ := ret[0].([]*trireme.PUState)
+	return ret0
+}
+
+// ListPUs indicates an expected call of ListPUs
+// nolint
+func (mr *MockTriremeMockRecorder) ListPUs() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPUs", reflect.TypeOf((*MockTrireme)(nil).ListPUs))
+}
+
+// GetPUPolicy mocks base method
+// nolint
+func (m *MockTrireme) GetPUPolicy(contextID string) (*trireme.PUState, error) {
+	ret := m.ctrl.Call(m, "GetPUPolicy", contextID)
+	ret0, _ := ret[0].(*trireme.PUState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPUPolicy indicates an expected call of GetPUPolicy
+// nolint
+func (mr *MockTriremeMockRecorder) GetPUPolicy(contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPUPolicy", reflect.TypeOf((*MockTrireme)(nil).GetPUPolicy), contextID)
+}
+
+// DiffPolicy mocks base method
+// nolint
+func (m *MockTrireme) DiffPolicy(contextID string, v1, v2 int) (policy.PUPolicyDiff, error) {
+	ret := m.ctrl.Call(m, "DiffPolicy", contextID, v1, v2)
+	ret0, _ := ret[0].(policy.PUPolicyDiff)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DiffPolicy indicates an expected call of DiffPolicy
+// nolint
+func (mr *MockTriremeMockRecorder) DiffPolicy(contextID, v1, v2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DiffPolicy", reflect.TypeOf((*MockTrireme)(nil).DiffPolicy), contextID, v1, v2)
+}
+
+// RollbackPolicy mocks base method
+// nolint
+func (m *MockTrireme) RollbackPolicy(contextID string) error {
+	ret := m.ctrl.Call(m, "RollbackPolicy", contextID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RollbackPolicy indicates an expected call of RollbackPolicy
+// nolint
+func (mr *MockTriremeMockRecorder) RollbackPolicy(contextID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RollbackPolicy", reflect.TypeOf((*MockTrireme)(nil).RollbackPolicy), contextID)
+}
+
 // UpdateSecrets mocks base method
 // nolint
 func (m *MockTrireme) UpdateSecrets(secrets secrets.Secrets) error {
@@ -155,6 +316,20 @@ func (mr *MockTriremeMockRecorder) UpdateSecrets(secrets interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSecrets", reflect.TypeOf((*MockTrireme)(nil).UpdateSecrets), secrets)
 }
 
+// RevokeToken mocks base method
+// nolint
+func (m *MockTrireme) RevokeToken(token []byte) error {
+	ret := m.ctrl.Call(m, "RevokeToken", token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeToken indicates an expected call of RevokeToken
+// nolint
+func (mr *MockTriremeMockRecorder) RevokeToken(token interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeToken", reflect.TypeOf((*MockTrireme)(nil).RevokeToken), token)
+}
+
 // MockPolicyUpdater is a mock of PolicyUpdater interface
 // nolint
 type MockPolicyUpdater struct {
@@ -290,3 +465,17 @@ func (m *MockSecretsUpdater) UpdateSecrets(secrets secrets.Secrets) error {
 func (mr *MockSecretsUpdaterMockRecorder) UpdateSecrets(secrets interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSecrets", reflect.TypeOf((*MockSecretsUpdater)(nil).UpdateSecrets), secrets)
 }
+
+// RevokeToken mocks base method
+// nolint
+func (m *MockSecretsUpdater) RevokeToken(token []byte) error {
+	ret := m.ctrl.Call(m, "RevokeToken", token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeToken indicates an expected call of RevokeToken
+// nolint
+func (mr *MockSecretsUpdaterMockRecorder) RevokeToken(token interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeToken", reflect.TypeOf((*MockSecretsUpdater)(nil).RevokeToken), token)
+}
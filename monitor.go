@@ -1,10 +1,15 @@
 package trireme
 
 import (
+	"time"
+
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/cni"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/cri"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/docker"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/external"
+	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/kubernetes"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/linux"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor/instance/uid"
 	"github.com/aporeto-inc/trireme-lib/rpc/events"
@@ -26,6 +31,15 @@ type DockerMonitorOption func(*dockermonitor.Config)
 // LinuxMonitorOption is provided using functional arguments.
 type LinuxMonitorOption func(*linuxmonitor.Config)
 
+// KubernetesMonitorOption is provided using functional arguments.
+type KubernetesMonitorOption func(*kubernetesmonitor.Config)
+
+// ExternalMonitorOption is provided using functional arguments.
+type ExternalMonitorOption func(*externalmonitor.Config)
+
+// CRIMonitorOption is provided using functional arguments.
+type CRIMonitorOption func(*crimonitor.Config)
+
 // SubOptionMonitorLinuxExtractor provides a way to specify metadata extractor for linux monitors.
 func SubOptionMonitorLinuxExtractor(extractor events.EventMetadataExtractor) LinuxMonitorOption {
 	return func(cfg *linuxmonitor.Config) {
@@ -33,6 +47,38 @@ func SubOptionMonitorLinuxExtractor(extractor events.EventMetadataExtractor) Lin
 	}
 }
 
+// SubOptionMonitorLinuxECS adds the cluster, family, revision, service and ARN
+// of the ECS task of the enforcer to the tags of the linux PUs, when the
+// enforcer runs in the task, like on Fargate. The metadata is read from the
+// endpoint of uri, or from the endpoint given by the ECS agent if uri is empty.
+// It wraps the extractor of the configuration, and must be given after
+// SubOptionMonitorLinuxExtractor.
+func SubOptionMonitorLinuxECS(uri string) LinuxMonitorOption {
+	return func(cfg *linuxmonitor.Config) {
+		cfg.EventMetadataExtractor = events.NewECSMetadataExtractor(uri, cfg.EventMetadataExtractor)
+	}
+}
+
+// SubOptionMonitorLinuxCloudMetadata adds the region, zone, id and tags of the
+// cloud instance to the tags of the linux PUs, like the host PUs. The cloud is
+// detected if provider is events.CloudAuto. It wraps the extractor of the
+// configuration, and must be given after SubOptionMonitorLinuxExtractor.
+func SubOptionMonitorLinuxCloudMetadata(provider events.CloudProvider) LinuxMonitorOption {
+	return func(cfg *linuxmonitor.Config) {
+		cfg.EventMetadataExtractor = events.NewCloudMetadataExtractor(provider, cfg.EventMetadataExtractor)
+	}
+}
+
+// SubOptionMonitorLinuxAutoPort enables the discovery of the listening ports of
+// all the linux PUs, like the AutoPort of their events. The services of the
+// events are not required anymore. The ports of host services are not
+// discovered.
+func SubOptionMonitorLinuxAutoPort(enabled bool) LinuxMonitorOption {
+	return func(cfg *linuxmonitor.Config) {
+		cfg.AutoPort = enabled
+	}
+}
+
 // optionMonitorLinux provides a way to add a linux monitor and related configuration to be used with New().
 func optionMonitorLinux(
 	host bool,
@@ -94,6 +140,46 @@ func SubOptionMonitorUIDExtractor(extractor events.EventMetadataExtractor) UIDMo
 	}
 }
 
+// SubOptionMonitorUIDGroupResolver adds the directory groups of the users, like
+// the groups of events.NewLDAPGroupResolver, to the tags of their PUs. It wraps
+// the extractor of the configuration, and must be given after
+// SubOptionMonitorUIDExtractor.
+func SubOptionMonitorUIDGroupResolver(resolver events.GroupResolver) UIDMonitorOption {
+	return func(cfg *uidmonitor.Config) {
+		cfg.EventMetadataExtractor = events.NewGroupMetadataExtractor(resolver, cfg.EventMetadataExtractor)
+	}
+}
+
+// SubOptionMonitorUIDSessions starts the PUs of the users of the ssh and console
+// login sessions from the login records of utmpPath, and stops them when the
+// sessions end, without events from a wrapper. The default login records are
+// used if utmpPath is empty.
+func SubOptionMonitorUIDSessions(utmpPath string) UIDMonitorOption {
+	return func(cfg *uidmonitor.Config) {
+		cfg.Sessions = true
+		if utmpPath != "" {
+			cfg.UTMPPath = utmpPath
+		}
+	}
+}
+
+// SubOptionMonitorUIDMarksPath sets where the marks of the PUs are remembered
+// across restarts. The marks are allocated for each start of a PU if path is
+// empty.
+func SubOptionMonitorUIDMarksPath(path string) UIDMonitorOption {
+	return func(cfg *uidmonitor.Config) {
+		cfg.MarksPath = path
+	}
+}
+
+// SubOptionMonitorUIDMarksRetention sets how long the mark of a stopped PU is
+// remembered before it is returned to the pool.
+func SubOptionMonitorUIDMarksRetention(retention time.Duration) UIDMonitorOption {
+	return func(cfg *uidmonitor.Config) {
+		cfg.MarksRetention = retention
+	}
+}
+
 // OptionMonitorUID provides a way to add a UID monitor and related configuration to be used with New().
 func OptionMonitorUID(
 	opts ...UIDMonitorOption,
@@ -108,6 +194,133 @@ func OptionMonitorUID(
 	}
 }
 
+// SubOptionMonitorKubernetesKubeconfig provides a way to specify the kubeconfig file
+// of the kubernetes monitor, in YAML or JSON format. The exec and auth-provider
+// plugins of its users are not supported. The service account of the pod is used
+// by default.
+func SubOptionMonitorKubernetesKubeconfig(kubeconfig string) KubernetesMonitorOption {
+	return func(cfg *kubernetesmonitor.Config) {
+		cfg.Kubeconfig = kubeconfig
+	}
+}
+
+// SubOptionMonitorKubernetesLabelSelector provides a way to restrict the pods
+// handled by the kubernetes monitor with a label selector.
+func SubOptionMonitorKubernetesLabelSelector(selector string) KubernetesMonitorOption {
+	return func(cfg *kubernetesmonitor.Config) {
+		cfg.LabelSelector = selector
+	}
+}
+
+// SubOptionMonitorKubernetesNodename provides a way to specify the node of the pods
+// handled by the kubernetes monitor. The hostname is used by default.
+func SubOptionMonitorKubernetesNodename(nodename string) KubernetesMonitorOption {
+	return func(cfg *kubernetesmonitor.Config) {
+		cfg.Nodename = nodename
+	}
+}
+
+// SubOptionMonitorKubernetesExtractor provides a way to specify metadata extractor for kubernetes.
+func SubOptionMonitorKubernetesExtractor(extractor kubernetesmonitor.PodMetadataExtractor) KubernetesMonitorOption {
+	return func(cfg *kubernetesmonitor.Config) {
+		cfg.PodMetadataExtractor = extractor
+	}
+}
+
+// OptionMonitorKubernetes provides a way to add a kubernetes monitor and related configuration to be used with New().
+func OptionMonitorKubernetes(opts ...KubernetesMonitorOption) MonitorOption {
+
+	kc := kubernetesmonitor.DefaultConfig()
+	// Collect all kubernetes options
+	for _, opt := range opts {
+		opt(kc)
+	}
+
+	return func(cfg *monitor.Config) {
+		cfg.Monitors[monitor.Kubernetes] = kc
+	}
+}
+
+// SubOptionMonitorCRIEndpoint provides a way to specify the endpoint of the CRI runtime,
+// like unix:///var/run/crio/crio.sock. The socket of containerd is used by default.
+func SubOptionMonitorCRIEndpoint(endpoint string) CRIMonitorOption {
+	return func(cfg *crimonitor.Config) {
+		cfg.Endpoint = endpoint
+	}
+}
+
+// SubOptionMonitorCRICrictl provides a way to specify the path of the crictl command
+// used by the CRI monitor to talk to the runtime.
+func SubOptionMonitorCRICrictl(crictl string) CRIMonitorOption {
+	return func(cfg *crimonitor.Config) {
+		cfg.Crictl = crictl
+	}
+}
+
+// SubOptionMonitorCRISyncInterval provides a way to specify the interval between two
+// lists of the pod sandboxes of the runtime.
+func SubOptionMonitorCRISyncInterval(interval time.Duration) CRIMonitorOption {
+	return func(cfg *crimonitor.Config) {
+		cfg.SyncInterval = interval
+	}
+}
+
+// SubOptionMonitorCRIExtractor provides a way to specify metadata extractor for the
+// CRI monitor.
+func SubOptionMonitorCRIExtractor(extractor crimonitor.SandboxMetadataExtractor) CRIMonitorOption {
+	return func(cfg *crimonitor.Config) {
+		cfg.SandboxMetadataExtractor = extractor
+	}
+}
+
+// OptionMonitorCRI provides a way to add a CRI monitor and related configuration to be
+// used with New(). The monitor handles the pods of a CRI runtime, like containerd or CRI-O,
+// without the API server.
+func OptionMonitorCRI(opts ...CRIMonitorOption) MonitorOption {
+
+	cc := crimonitor.DefaultConfig()
+	// Collect all CRI options
+	for _, opt := range opts {
+		opt(cc)
+	}
+
+	return func(cfg *monitor.Config) {
+		cfg.Monitors[monitor.CRI] = cc
+	}
+}
+
+// SubOptionMonitorExternalAddress provides a way to specify the Unix socket of the
+// API of the external monitor.
+func SubOptionMonitorExternalAddress(address string) ExternalMonitorOption {
+	return func(cfg *externalmonitor.Config) {
+		cfg.Address = address
+	}
+}
+
+// SubOptionMonitorExternalExtractor provides a way to specify metadata extractor for
+// the external monitor.
+func SubOptionMonitorExternalExtractor(extractor externalmonitor.MetadataExtractor) ExternalMonitorOption {
+	return func(cfg *externalmonitor.Config) {
+		cfg.MetadataExtractor = extractor
+	}
+}
+
+// OptionMonitorExternal provides a way to add an external monitor and related configuration
+// to be used with New(). External processes drive the lifecycle of the PUs with the API of
+// the rpc/external package.
+func OptionMonitorExternal(opts ...ExternalMonitorOption) MonitorOption {
+
+	ec := externalmonitor.DefaultConfig()
+	// Collect all external options
+	for _, opt := range opts {
+		opt(ec)
+	}
+
+	return func(cfg *monitor.Config) {
+		cfg.Monitors[monitor.External] = ec
+	}
+}
+
 // SubOptionMonitorDockerExtractor provides a way to specify metadata extractor for docker.
 func SubOptionMonitorDockerExtractor(extractor dockermonitor.MetadataExtractor) DockerMonitorOption {
 	return func(cfg *dockermonitor.Config) {
@@ -141,6 +354,46 @@ func SubOptionMonitorDockerSocket(socketType, socketAddress string) DockerMonito
 	}
 }
 
+// SubOptionMonitorDockerTLS provides a way to connect to a docker daemon listening
+// on a tcp socket with TLS. The certificate of the daemon is verified with the
+// certificate authority of caFile, or the authorities of the system if it is
+// empty. The client certificate and key are only needed if the daemon verifies
+// its clients.
+func SubOptionMonitorDockerTLS(caFile, certFile, keyFile string) DockerMonitorOption {
+	return func(cfg *dockermonitor.Config) {
+		cfg.TLSCACert = caFile
+		cfg.TLSCert = certFile
+		cfg.TLSKey = keyFile
+	}
+}
+
+// SubOptionMonitorDockerHeaders provides a way to add headers to the requests
+// sent to docker, like the headers required by an authorization proxy.
+func SubOptionMonitorDockerHeaders(headers map[string]string) DockerMonitorOption {
+	return func(cfg *dockermonitor.Config) {
+		cfg.Headers = headers
+	}
+}
+
+// SubOptionMonitorDockerAPIVersion provides a way to specify the version of the
+// docker API. If negotiate is true, the latest version supported by both the
+// monitor and the daemon is used instead.
+func SubOptionMonitorDockerAPIVersion(version string, negotiate bool) DockerMonitorOption {
+	return func(cfg *dockermonitor.Config) {
+		cfg.APIVersion = version
+		cfg.NegotiateAPIVersion = negotiate
+	}
+}
+
+// SubOptionMonitorDockerECS adds the cluster, family, revision, service and ARN
+// of the ECS task of the containers to their tags. It wraps the extractor of
+// the configuration, and must be given after SubOptionMonitorDockerExtractor.
+func SubOptionMonitorDockerECS() DockerMonitorOption {
+	return func(cfg *dockermonitor.Config) {
+		cfg.EventMetadataExtractor = dockermonitor.NewECSMetadataExtractor(cfg.EventMetadataExtractor)
+	}
+}
+
 // SubOptionMonitorDockerFlags provides a way to specify configuration flags info for docker.
 func SubOptionMonitorDockerFlags(syncAtStart, killContainerOnPolicyError bool) DockerMonitorOption {
 	return func(cfg *dockermonitor.Config) {
@@ -149,6 +402,14 @@ func SubOptionMonitorDockerFlags(syncAtStart, killContainerOnPolicyError bool) D
 	}
 }
 
+// SubOptionMonitorDockerQuarantine drops all the traffic of the containers whose
+// policy could not be set, and keeps them running, instead of killing them.
+func SubOptionMonitorDockerQuarantine(quarantineOnPolicyError bool) DockerMonitorOption {
+	return func(cfg *dockermonitor.Config) {
+		cfg.QuarantineOnPolicyError = quarantineOnPolicyError
+	}
+}
+
 // OptionMonitorDocker provides a way to add a docker monitor and related configuration to be used with New().
 func OptionMonitorDocker(opts ...DockerMonitorOption) MonitorOption {
 
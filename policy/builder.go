@@ -0,0 +1,356 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PUPolicyOption is an option of a policy built with BuildPUPolicy
+type PUPolicyOption func(*PUPolicy)
+
+// OptionManagementID sets the management ID of the policy
+func OptionManagementID(id string) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.managementID = id
+	}
+}
+
+// OptionTriremeAction sets the action of the policy
+func OptionTriremeAction(action PUAction) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.triremeAction = action
+	}
+}
+
+// OptionApplicationACLs sets the ACLs of the traffic from the PU to the network
+func OptionApplicationACLs(acls IPRuleList) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.applicationACLs = acls
+	}
+}
+
+// OptionNetworkACLs sets the ACLs of the traffic from the network to the PU
+func OptionNetworkACLs(acls IPRuleList) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.networkACLs = acls
+	}
+}
+
+// OptionTransmitterRules sets the rules matched with the tags of the receivers
+func OptionTransmitterRules(rules TagSelectorList) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.transmitterRules = rules
+	}
+}
+
+// OptionReceiverRules sets the rules matched with the tags of the transmitters
+func OptionReceiverRules(rules TagSelectorList) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.receiverRules = rules
+	}
+}
+
+// OptionIdentity sets the tags sent over the wire
+func OptionIdentity(identity *TagStore) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.identity = identity
+	}
+}
+
+// OptionAnnotations sets the tags used for accounting
+func OptionAnnotations(annotations *TagStore) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.annotations = annotations
+	}
+}
+
+// OptionIPAddresses sets the IP addresses the policy is applied to
+func OptionIPAddresses(ips ExtendedMap) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.ips = ips
+	}
+}
+
+// OptionTriremeNetworks sets the networks where the authorization is enforced
+func OptionTriremeNetworks(networks []string) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.triremeNetworks = networks
+	}
+}
+
+// OptionExcludedNetworks sets the networks that are not policed
+func OptionExcludedNetworks(networks []string) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.excludedNetworks = networks
+	}
+}
+
+// OptionProxiedServices sets the services that are proxied
+func OptionProxiedServices(services *ProxiedServicesInfo) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.proxiedServices = services
+	}
+}
+
+// OptionUserAuthorization sets the validation of the user tokens of the
+// proxied connections
+func OptionUserAuthorization(authorization *UserAuthorization) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.userAuthorization = authorization
+	}
+}
+
+// OptionIdentityClaims sets the claims of the application that are added to
+// the identity tokens
+func OptionIdentityClaims(claims map[string]string) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.identityClaims = copyClaims(claims)
+	}
+}
+
+// OptionHTTPRules sets the rules that authorize the HTTP requests of the
+// proxied connections
+func OptionHTTPRules(rules HTTPRuleList) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.httpRules = rules.Copy()
+	}
+}
+
+// OptionSNIRules sets the rules that authorize the TLS connections to
+// external services by their server names
+func OptionSNIRules(rules SNIRuleList) PUPolicyOption {
+	return func(p *PUPolicy) {
+		p.sniRules = rules.Copy()
+	}
+}
+
+// BuildPUPolicy returns a policy with the given options. The values that are
+// not given are the ones of NewPUPolicyWithDefaults. It returns an error if
+// an address, a network or a port of the policy is invalid.
+func BuildPUPolicy(opts ...PUPolicyOption) (*PUPolicy, error) {
+
+	p := NewPUPolicyWithDefaults()
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	// The options may set nil values, that NewPUPolicy replaces
+	np := NewPUPolicy(
+		p.managementID,
+		p.triremeAction,
+		p.applicationACLs,
+		p.networkACLs,
+		p.transmitterRules,
+		p.receiverRules,
+		p.identity,
+		p.annotations,
+		p.ips,
+		p.triremeNetworks,
+		p.excludedNetworks,
+		p.proxiedServices,
+	)
+	np.userAuthorization = p.userAuthorization
+	np.identityClaims = p.identityClaims
+	np.httpRules = p.httpRules
+	np.sniRules = p.sniRules
+
+	if err := np.validate(); err != nil {
+		return nil, err
+	}
+
+	return np, nil
+}
+
+// validate checks the addresses, the networks and the ports of the policy
+func (p *PUPolicy) validate() error {
+
+	for _, acls := range []struct {
+		name  string
+		rules IPRuleList
+	}{
+		{"application", p.applicationACLs},
+		{"network", p.networkACLs},
+	} {
+		for idx, rule := range acls.rules {
+			if err := validateIPRule(rule); err != nil {
+				return fmt.Errorf("invalid %s acl %d: %s", acls.name, idx, err)
+			}
+		}
+	}
+
+	for _, rules := range []struct {
+		name  string
+		rules TagSelectorList
+	}{
+		{"transmitter", p.transmitterRules},
+		{"receiver", p.receiverRules},
+	} {
+		for idx, rule := range rules.rules {
+			if err := validateTagSelector(rule); err != nil {
+				return fmt.Errorf("invalid %s rule %d: %s", rules.name, idx, err)
+			}
+		}
+	}
+
+	for _, networks := range []struct {
+		name     string
+		networks []string
+	}{
+		{"trireme", p.triremeNetworks},
+		{"excluded", p.excludedNetworks},
+	} {
+		for _, network := range networks.networks {
+			if err := validateAddress(network); err != nil {
+				return fmt.Errorf("invalid %s network: %s", networks.name, err)
+			}
+		}
+	}
+
+	for _, pairs := range [][]string{p.proxiedServices.PublicIPPortPair, p.proxiedServices.PrivateIPPortPair} {
+		for _, pair := range pairs {
+			if err := validateIPPortPair(pair); err != nil {
+				return fmt.Errorf("invalid proxied service: %s", err)
+			}
+		}
+	}
+
+	if err := ValidateIdentityClaims(p.identityClaims); err != nil {
+		return fmt.Errorf("invalid identity claims: %s", err)
+	}
+
+	if err := ValidateHTTPRules(p.httpRules); err != nil {
+		return err
+	}
+
+	if err := ValidateSNIRules(p.sniRules); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateIPRule checks the address and the port of an ACL
+func validateIPRule(rule IPRule) error {
+
+	if rule.Policy == nil {
+		return errors.New("no flow policy")
+	}
+
+	if rule.Protocol == "" {
+		return errors.New("no protocol")
+	}
+
+	if err := validateAddress(rule.Address); err != nil {
+		return err
+	}
+
+	// The rules without a port match all the ports
+	if rule.Port == "" {
+		return nil
+	}
+
+	parts := strings.Split(rule.Port, ":")
+	if len(parts) > 2 {
+		return fmt.Errorf("invalid port range: %s", rule.Port)
+	}
+
+	min, err := parsePort(parts[0])
+	if err != nil {
+		return err
+	}
+
+	max := min
+	if len(parts) == 2 {
+		if max, err = parsePort(parts[1]); err != nil {
+			return err
+		}
+	}
+
+	if min > max {
+		return fmt.Errorf("invalid port range: %s", rule.Port)
+	}
+
+	return nil
+}
+
+// validateTagSelector checks the operators and the values of the clauses of a
+// rule
+func validateTagSelector(rule TagSelector) error {
+
+	if rule.Policy == nil {
+		return errors.New("no flow policy")
+	}
+
+	for _, clause := range rule.Clause {
+		switch clause.Operator {
+		case KeyExists, KeyNotExists:
+			continue
+		case Equal, NotEqual, Not, AnyOf, AllOf:
+		default:
+			return fmt.Errorf("invalid operator for key %s: %s", clause.Key, clause.Operator)
+		}
+
+		if len(clause.Value) == 0 {
+			return fmt.Errorf("no value for key %s", clause.Key)
+		}
+
+		for _, v := range clause.Value {
+			if v == "" {
+				return fmt.Errorf("empty value for key %s", clause.Key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateAddress checks that an address is an IP address or a network
+func validateAddress(address string) error {
+
+	if strings.Contains(address, "/") {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return fmt.Errorf("invalid network: %s", address)
+		}
+		return nil
+	}
+
+	if net.ParseIP(address) == nil {
+		return fmt.Errorf("invalid ip address: %s", address)
+	}
+
+	return nil
+}
+
+// validateIPPortPair checks a proxied service, like 10.0.0.1,80 or
+// 10.0.0.1,udp:53
+func validateIPPortPair(pair string) error {
+
+	parts := strings.Split(pair, ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid ip,port pair: %s", pair)
+	}
+
+	if net.ParseIP(parts[0]) == nil {
+		return fmt.Errorf("invalid ip address: %s", parts[0])
+	}
+
+	port := strings.TrimPrefix(strings.TrimPrefix(parts[1], "tcp:"), "udp:")
+	_, err := parsePort(port)
+
+	return err
+}
+
+// parsePort parses a port number
+func parsePort(port string) (int, error) {
+
+	value, err := strconv.Atoi(port)
+	if err != nil || value < 0 || value > 65535 {
+		return 0, fmt.Errorf("invalid port: %s", port)
+	}
+
+	return value, nil
+}
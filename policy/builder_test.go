@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBuildPUPolicy(t *testing.T) {
+	Convey("When I build a policy without options", t, func() {
+		p, err := BuildPUPolicy()
+
+		Convey("I should get the default policy", func() {
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, NewPUPolicyWithDefaults())
+		})
+	})
+
+	Convey("When I build a policy with options", t, func() {
+		acls := IPRuleList{
+			IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80:443",
+				Protocol: "TCP",
+				Policy:   &FlowPolicy{Action: Accept},
+			},
+			IPRule{
+				Address:  "10.1.1.1",
+				Protocol: "icmp",
+				Policy:   &FlowPolicy{Action: Reject},
+			},
+		}
+		identity := NewTagStore()
+		identity.AppendKeyValue("app", "web")
+
+		p, err := BuildPUPolicy(
+			OptionManagementID("id1"),
+			OptionTriremeAction(Police),
+			OptionApplicationACLs(acls),
+			OptionIdentity(identity),
+			OptionTriremeNetworks([]string{"172.17.0.0/16"}),
+			OptionExcludedNetworks([]string{"10.0.0.1"}),
+			OptionProxiedServices(&ProxiedServicesInfo{PublicIPPortPair: []string{"10.0.0.1,80", "10.0.0.2,udp:53"}}),
+			OptionUserAuthorization(&UserAuthorization{Issuer: "issuer"}),
+			OptionIdentityClaims(map[string]string{"image": "sha256:1234"}),
+			OptionHTTPRules(HTTPRuleList{HTTPRule{Methods: []string{"GET"}, Paths: []string{"/api/*"}}}),
+		)
+
+		Convey("I should get a policy with their values", func() {
+			So(err, ShouldBeNil)
+			So(p.ManagementID(), ShouldEqual, "id1")
+			So(p.TriremeAction(), ShouldEqual, Police)
+			So(p.ApplicationACLs(), ShouldResemble, acls)
+			So(p.NetworkACLs(), ShouldNotBeNil)
+			So(p.Identity().Tags, ShouldResemble, []string{"app=web"})
+			So(p.Annotations(), ShouldNotBeNil)
+			So(p.TriremeNetworks(), ShouldResemble, []string{"172.17.0.0/16"})
+			So(p.ExcludedNetworks(), ShouldResemble, []string{"10.0.0.1"})
+			So(p.ProxiedServices().HasUDPServices(), ShouldBeTrue)
+			So(p.UserAuthorization().Issuer, ShouldEqual, "issuer")
+			So(p.IdentityClaims(), ShouldResemble, map[string]string{"image": "sha256:1234"})
+			So(p.HTTPRules(), ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("When I build a policy with invalid values, I should get an error", t, func() {
+		rule := func(address, port string) IPRuleList {
+			return IPRuleList{IPRule{Address: address, Port: port, Protocol: "tcp", Policy: &FlowPolicy{Action: Accept}}}
+		}
+
+		for _, opt := range []PUPolicyOption{
+			OptionApplicationACLs(rule("192.30.253.0/33", "80")),
+			OptionApplicationACLs(rule("192.30.253", "80")),
+			OptionNetworkACLs(rule("10.0.0.0/8", "http")),
+			OptionNetworkACLs(rule("10.0.0.0/8", "70000")),
+			OptionNetworkACLs(rule("10.0.0.0/8", "443:80")),
+			OptionNetworkACLs(rule("10.0.0.0/8", "1:2:3")),
+			OptionNetworkACLs(IPRuleList{IPRule{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp"}}),
+			OptionNetworkACLs(IPRuleList{IPRule{Address: "10.0.0.0/8", Port: "80", Policy: &FlowPolicy{Action: Accept}}}),
+			OptionTriremeNetworks([]string{"172.17.0.0/40"}),
+			OptionExcludedNetworks([]string{"host"}),
+			OptionProxiedServices(&ProxiedServicesInfo{PrivateIPPortPair: []string{"10.0.0.1:80"}}),
+			OptionProxiedServices(&ProxiedServicesInfo{PrivateIPPortPair: []string{"10.0.0.1,sctp:80"}}),
+			OptionReceiverRules(TagSelectorList{TagSelector{Clause: []KeyValueOperator{{Key: "app", Value: []string{"web"}, Operator: "~"}}, Policy: &FlowPolicy{Action: Accept}}}),
+			OptionReceiverRules(TagSelectorList{TagSelector{Clause: []KeyValueOperator{{Key: "app", Operator: AllOf}}, Policy: &FlowPolicy{Action: Accept}}}),
+			OptionTransmitterRules(TagSelectorList{TagSelector{Clause: []KeyValueOperator{{Key: "app", Value: []string{"web"}, Operator: Not}}}}),
+			OptionIdentityClaims(map[string]string{"": "value"}),
+			OptionHTTPRules(HTTPRuleList{HTTPRule{Paths: []string{"api"}}}),
+		} {
+			p, err := BuildPUPolicy(opt)
+			So(err, ShouldNotBeNil)
+			So(p, ShouldBeNil)
+		}
+	})
+}
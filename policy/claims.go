@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxIdentityClaimsSize is the maximum size of the keys and the values of the
+// identity claims of a PU. The claims are sent in the tokens of the Syn and
+// SynAck packets, that must fit in a packet.
+const MaxIdentityClaimsSize = 1024
+
+// ValidateIdentityClaims checks that the identity claims have no empty key
+// and fit in the tokens
+func ValidateIdentityClaims(claims map[string]string) error {
+
+	size := 0
+	for key, value := range claims {
+		if key == "" {
+			return errors.New("empty claim key")
+		}
+		size += len(key) + len(value)
+	}
+
+	if size > MaxIdentityClaimsSize {
+		return fmt.Errorf("claims of %d bytes exceed the maximum of %d bytes", size, MaxIdentityClaimsSize)
+	}
+
+	return nil
+}
+
+// copyClaims returns a copy of the claims, or nil if there are no claims
+func copyClaims(claims map[string]string) map[string]string {
+
+	if len(claims) == 0 {
+		return nil
+	}
+
+	c := make(map[string]string, len(claims))
+	for key, value := range claims {
+		c[key] = value
+	}
+
+	return c
+}
@@ -0,0 +1,121 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// PUPolicyFieldDiff is the change of a field of a policy. The fields are named
+// as in PUPolicyJSON.
+type PUPolicyFieldDiff struct {
+	// Field is the name of the field that changed
+	Field string
+	// Added are the elements of a list that are only in the new policy
+	Added []interface{} `json:",omitempty"`
+	// Removed are the elements of a list that are only in the old policy
+	Removed []interface{} `json:",omitempty"`
+	// Old is the old value of a field that is not a list
+	Old interface{} `json:",omitempty"`
+	// New is the new value of a field that is not a list
+	New interface{} `json:",omitempty"`
+}
+
+// PUPolicyDiff is the list of the fields that changed between two policies
+type PUPolicyDiff []*PUPolicyFieldDiff
+
+// DiffPUPolicy returns the changes from the old policy to the new one. The
+// ACLs, the rules, the tags and the networks are compared as lists whose order
+// is ignored. The other fields are compared as values.
+func DiffPUPolicy(old, new *PUPolicy) PUPolicyDiff {
+
+	diff := PUPolicyDiff{}
+
+	oldView := reflect.ValueOf(old.jsonView()).Elem()
+	newView := reflect.ValueOf(new.jsonView()).Elem()
+
+	for i := 0; i < oldView.NumField(); i++ {
+		field := oldView.Type().Field(i).Name
+		oldValue := oldView.Field(i).Interface()
+		newValue := newView.Field(i).Interface()
+
+		// The tag stores are compared as lists of tags
+		if tags, ok := oldValue.(*TagStore); ok {
+			oldValue = tagList(tags)
+			newValue = tagList(newValue.(*TagStore))
+		}
+
+		if reflect.ValueOf(oldValue).Kind() == reflect.Slice {
+			added, removed := diffLists(reflect.ValueOf(oldValue), reflect.ValueOf(newValue))
+			if len(added) > 0 || len(removed) > 0 {
+				diff = append(diff, &PUPolicyFieldDiff{
+					Field:   field,
+					Added:   added,
+					Removed: removed,
+				})
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(oldValue, newValue) {
+			diff = append(diff, &PUPolicyFieldDiff{
+				Field: field,
+				Old:   oldValue,
+				New:   newValue,
+			})
+		}
+	}
+
+	return diff
+}
+
+// tagList returns the tags of a tag store
+func tagList(t *TagStore) []string {
+
+	if t == nil {
+		return []string{}
+	}
+
+	return t.Tags
+}
+
+// diffLists returns the elements that are only in the new list and the ones
+// that are only in the old list. The elements are compared by their JSON
+// encoding, so that the rules pointing to equal flow policies are equal.
+func diffLists(old, new reflect.Value) (added []interface{}, removed []interface{}) {
+
+	counts := map[string]int{}
+
+	for i := 0; i < old.Len(); i++ {
+		counts[elementKey(old.Index(i))]++
+	}
+
+	for i := 0; i < new.Len(); i++ {
+		key := elementKey(new.Index(i))
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		added = append(added, new.Index(i).Interface())
+	}
+
+	for i := 0; i < old.Len(); i++ {
+		key := elementKey(old.Index(i))
+		if counts[key] > 0 {
+			counts[key]--
+			removed = append(removed, old.Index(i).Interface())
+		}
+	}
+
+	return added, removed
+}
+
+// elementKey returns the JSON encoding of an element of a list
+func elementKey(v reflect.Value) string {
+
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
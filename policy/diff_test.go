@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDiffPUPolicy(t *testing.T) {
+	Convey("Given a policy", t, func() {
+		web := IPRule{Address: "192.30.253.0/24", Port: "443", Protocol: "TCP", Policy: &FlowPolicy{Action: Accept}}
+		dns := IPRule{Address: "8.8.8.8", Port: "53", Protocol: "UDP", Policy: &FlowPolicy{Action: Accept}}
+
+		identity := NewTagStore()
+		identity.AppendKeyValue("app", "web")
+
+		old, err := BuildPUPolicy(
+			OptionManagementID("id1"),
+			OptionTriremeAction(Police),
+			OptionApplicationACLs(IPRuleList{web, dns}),
+			OptionIdentity(identity),
+		)
+		So(err, ShouldBeNil)
+
+		Convey("When I compare it with itself, I should get no changes", func() {
+			So(DiffPUPolicy(old, old.Clone()), ShouldBeEmpty)
+		})
+
+		Convey("When I compare it with a changed policy", func() {
+			ssh := IPRule{Address: "10.0.0.0/8", Port: "22", Protocol: "TCP", Policy: &FlowPolicy{Action: Accept}}
+
+			newIdentity := NewTagStore()
+			newIdentity.AppendKeyValue("app", "web")
+			newIdentity.AppendKeyValue("env", "prod")
+
+			new, err := BuildPUPolicy(
+				OptionManagementID("id1"),
+				OptionTriremeAction(AllowAll),
+				OptionApplicationACLs(IPRuleList{dns, ssh}),
+				OptionIdentity(newIdentity),
+			)
+			So(err, ShouldBeNil)
+
+			diff := DiffPUPolicy(old, new)
+
+			Convey("I should get the changed fields", func() {
+				So(diff, ShouldResemble, PUPolicyDiff{
+					{Field: "TriremeAction", Old: PUAction(Police), New: PUAction(AllowAll)},
+					{Field: "ApplicationACLs", Added: []interface{}{ssh}, Removed: []interface{}{web}},
+					{Field: "Identity", Added: []interface{}{"env=prod"}},
+				})
+			})
+		})
+
+		Convey("When I only reorder the acls, I should get no changes", func() {
+			new, err := BuildPUPolicy(
+				OptionManagementID("id1"),
+				OptionTriremeAction(Police),
+				OptionApplicationACLs(IPRuleList{dns, web}),
+				OptionIdentity(identity),
+			)
+			So(err, ShouldBeNil)
+			So(DiffPUPolicy(old, new), ShouldBeEmpty)
+		})
+	})
+}
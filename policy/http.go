@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// HTTPRule authorizes the HTTP requests of the peers selected by its clause
+// on the connections received by the proxy of a PU. The methods, the paths and
+// the hosts of a rule match any request when they are empty.
+type HTTPRule struct {
+	// Clause selects the peers by their identity, like the clause of a
+	// TagSelector. It selects all the peers if empty.
+	Clause []KeyValueOperator
+	// Methods are the methods of the requests, like GET
+	Methods []string
+	// Paths are the paths of the requests. A path that ends with * matches
+	// the paths that start with its prefix.
+	Paths []string
+	// Hosts are the hosts of the requests, without their port
+	Hosts []string
+	// PolicyID is the ID of the policy of the rule
+	PolicyID string
+}
+
+// HTTPRuleList is a list of HTTP rules
+type HTTPRuleList []HTTPRule
+
+// Copy returns a copy of the list
+func (l HTTPRuleList) Copy() HTTPRuleList {
+
+	if l == nil {
+		return nil
+	}
+
+	list := make(HTTPRuleList, len(l))
+	for i, v := range l {
+		list[i] = v
+	}
+
+	return list
+}
+
+// Search returns the first rule of the list that matches the request, or nil
+// if none does
+func (l HTTPRuleList) Search(method string, path string, host string) *HTTPRule {
+
+	for i := range l {
+		if l[i].Match(method, path, host) {
+			return &l[i]
+		}
+	}
+
+	return nil
+}
+
+// Match returns true if the rule matches the request. The methods and the
+// hosts are not case sensitive and the port of the host is ignored.
+func (r *HTTPRule) Match(method string, path string, host string) bool {
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return matchFold(r.Methods, method) && matchPath(r.Paths, path) && matchFold(r.Hosts, host)
+}
+
+// Validate checks that the methods, the paths and the hosts of the rule are
+// not empty and that the paths are absolute
+func (r *HTTPRule) Validate() error {
+
+	for _, values := range [][]string{r.Methods, r.Hosts} {
+		for _, value := range values {
+			if value == "" {
+				return errors.New("empty method or host")
+			}
+		}
+	}
+
+	for _, path := range r.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path %s is not absolute", path)
+		}
+	}
+
+	return nil
+}
+
+// ValidateHTTPRules checks the rules of a list
+func ValidateHTTPRules(rules HTTPRuleList) error {
+
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return fmt.Errorf("invalid http rule %s: %s", rules[i].PolicyID, err)
+		}
+	}
+
+	return nil
+}
+
+// matchFold returns true if the values are empty or one of them is equal to
+// the value, without case
+func matchFold(values []string, value string) bool {
+
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPath returns true if the paths are empty or one of them matches the
+// path
+func matchPath(paths []string, path string) bool {
+
+	if len(paths) == 0 {
+		return true
+	}
+
+	for _, p := range paths {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+			continue
+		}
+		if p == path {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHTTPRules(t *testing.T) {
+	Convey("Given HTTP rules", t, func() {
+		rules := HTTPRuleList{
+			HTTPRule{
+				Methods:  []string{"GET", "HEAD"},
+				Paths:    []string{"/api/*", "/health"},
+				Hosts:    []string{"web.example.com"},
+				PolicyID: "read",
+			},
+			HTTPRule{
+				Methods:  []string{"POST"},
+				Paths:    []string{"/api/orders"},
+				PolicyID: "write",
+			},
+		}
+
+		Convey("The requests should match the rules by method, path and host", func() {
+			So(rules.Search("GET", "/api/orders/1", "web.example.com").PolicyID, ShouldEqual, "read")
+			So(rules.Search("head", "/health", "WEB.example.com:8080").PolicyID, ShouldEqual, "read")
+			So(rules.Search("POST", "/api/orders", "other").PolicyID, ShouldEqual, "write")
+		})
+
+		Convey("The requests that do not match any rule should not be found", func() {
+			So(rules.Search("GET", "/healthz", "web.example.com"), ShouldBeNil)
+			So(rules.Search("GET", "/api/orders", "other"), ShouldBeNil)
+			So(rules.Search("DELETE", "/api/orders", "web.example.com"), ShouldBeNil)
+			So(HTTPRuleList{}.Search("GET", "/", ""), ShouldBeNil)
+		})
+
+		Convey("A rule without methods, paths and hosts should match any request", func() {
+			So((&HTTPRule{}).Match("PATCH", "/any", "any"), ShouldBeTrue)
+		})
+
+		Convey("A copy of the rules should be independent", func() {
+			c := rules.Copy()
+			c[0].PolicyID = "other"
+			So(rules[0].PolicyID, ShouldEqual, "read")
+			So(HTTPRuleList(nil).Copy(), ShouldBeNil)
+		})
+
+		Convey("The rules should be valid", func() {
+			So(ValidateHTTPRules(rules), ShouldBeNil)
+			So(ValidateHTTPRules(HTTPRuleList{HTTPRule{Methods: []string{""}}}), ShouldNotBeNil)
+			So(ValidateHTTPRules(HTTPRuleList{HTTPRule{Paths: []string{"api/*"}}}), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a policy", t, func() {
+		p := NewPUPolicyWithDefaults()
+
+		Convey("By default it should have no HTTP rules", func() {
+			So(p.HTTPRules(), ShouldBeNil)
+		})
+
+		Convey("When I set HTTP rules, a clone of the policy should keep them", func() {
+			So(p.SetHTTPRules(HTTPRuleList{HTTPRule{Methods: []string{"GET"}}}), ShouldBeNil)
+			So(p.Clone().HTTPRules(), ShouldResemble, HTTPRuleList{HTTPRule{Methods: []string{"GET"}}})
+		})
+
+		Convey("When I set invalid HTTP rules, I should get an error", func() {
+			So(p.SetHTTPRules(HTTPRuleList{HTTPRule{Hosts: []string{""}}}), ShouldNotBeNil)
+			So(p.HTTPRules(), ShouldBeNil)
+		})
+	})
+}
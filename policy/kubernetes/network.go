@@ -0,0 +1,116 @@
+package kubernetespolicy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// ipv4Network is an IPv4 network
+type ipv4Network struct {
+	address uint32
+	bits    int
+}
+
+func (n ipv4Network) contains(o ipv4Network) bool {
+	return n.bits <= o.bits && n.address == o.address&mask(n.bits)
+}
+
+func (n ipv4Network) String() string {
+
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n.address)
+
+	return fmt.Sprintf("%s/%d", ip, n.bits)
+}
+
+func mask(bits int) uint32 {
+
+	if bits == 0 {
+		return 0
+	}
+
+	return ^uint32(0) << uint(32-bits)
+}
+
+func parseIPv4Network(cidr string) (ipv4Network, bool, error) {
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return ipv4Network{}, false, fmt.Errorf("invalid network %s", cidr)
+	}
+
+	ip := network.IP.To4()
+	if ip == nil {
+		return ipv4Network{}, false, nil
+	}
+
+	bits, _ := network.Mask.Size()
+
+	return ipv4Network{address: binary.BigEndian.Uint32(ip), bits: bits}, true, nil
+}
+
+// subtractNetworks returns the networks that cover the addresses of the
+// network that are not in one of the exceptions. The IPv6 networks are
+// ignored, since the ACLs only have IPv4 addresses.
+func subtractNetworks(cidr string, except []string) ([]string, error) {
+
+	network, ok, err := parseIPv4Network(cidr)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	networks := []ipv4Network{network}
+
+	for _, e := range except {
+		exception, ok, err := parseIPv4Network(e)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		remaining := []ipv4Network{}
+		for _, n := range networks {
+			switch {
+			case exception.contains(n):
+				// The whole network is excluded
+			case n.contains(exception):
+				remaining = append(remaining, split(n, exception)...)
+			default:
+				remaining = append(remaining, n)
+			}
+		}
+		networks = remaining
+	}
+
+	cidrs := make([]string, len(networks))
+	for i, n := range networks {
+		cidrs[i] = n.String()
+	}
+
+	return cidrs, nil
+}
+
+// split returns the networks that cover the network without the exception,
+// which is in the network. At each step, the half of the network that does not
+// contain the exception is kept.
+func split(n ipv4Network, exception ipv4Network) []ipv4Network {
+
+	networks := []ipv4Network{}
+
+	for n.bits < exception.bits {
+		n.bits++
+		half := uint32(1) << uint(32-n.bits)
+
+		if exception.address&half == 0 {
+			networks = append(networks, ipv4Network{address: n.address | half, bits: n.bits})
+		} else {
+			networks = append(networks, ipv4Network{address: n.address, bits: n.bits})
+			n.address |= half
+		}
+	}
+
+	return networks
+}
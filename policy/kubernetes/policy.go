@@ -0,0 +1,483 @@
+package kubernetespolicy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// Tags of the identity of the pods. They are the tags of the pods of the
+// Kubernetes monitor.
+const (
+	NameTag      = "@sys:name"
+	NamespaceTag = "@sys:namespace"
+	LabelPrefix  = "@usr:"
+)
+
+const (
+	// allPorts is the port range of the ACLs that allow all the ports
+	allPorts = "0:65535"
+
+	// allNetworks is the network of the ACLs that allow all the addresses
+	allNetworks = "0.0.0.0/0"
+
+	// maxPortRange is the largest range of ports that the rules of the pods
+	// can have, since every port of the range is a value of the port tag
+	maxPortRange = 1024
+)
+
+// allPeers is the clause that selects all the PUs
+var allPeers = []policy.KeyValueOperator{
+	{
+		Key:      enforcerconstants.TransmitterLabel,
+		Operator: policy.KeyExists,
+	},
+}
+
+// NewPUPolicy translates the network policies that select a pod into its
+// policy. The namespaces are the labels of the namespaces by name, which are
+// needed by the namespace selectors. The options are applied after the
+// translated rules, like the options of policy.BuildPUPolicy.
+//
+// The pods are selected by the tags of the Kubernetes monitor: the peers of the
+// pods are receiver and transmitter rules, and the IP blocks are network and
+// application ACLs. Like Kubernetes, all the traffic of a direction is allowed
+// if no policy of that direction selects the pod. Some rules are translated
+// with less precision:
+//   - The ports of the egress rules to pods are ignored, since the transmitter
+//     rules do not match ports. The egress rules to IP blocks keep their ports.
+//   - The named ports of the egress rules are ignored.
+//   - The IPv6 blocks and the SCTP ports of the IP blocks are ignored.
+func NewPUPolicy(pod *Pod, policies []NetworkPolicy, namespaces map[string]map[string]string, opts ...policy.PUPolicyOption) (*policy.PUPolicy, error) {
+
+	t := &translator{
+		pod:        pod,
+		namespaces: namespaces,
+	}
+
+	ingress, egress := false, false
+
+	for i := range policies {
+		np := &policies[i]
+
+		if np.Metadata.Namespace != pod.Namespace || !matchSelector(&np.Spec.PodSelector, pod.Labels) {
+			continue
+		}
+
+		id := np.Metadata.Namespace + "/" + np.Metadata.Name
+
+		if np.hasType(PolicyTypeIngress) {
+			ingress = true
+			for _, rule := range np.Spec.Ingress {
+				if err := t.addRule(id, rule.From, rule.Ports, true); err != nil {
+					return nil, fmt.Errorf("invalid ingress rule of network policy %s: %s", id, err)
+				}
+			}
+		}
+
+		if np.hasType(PolicyTypeEgress) {
+			egress = true
+			for _, rule := range np.Spec.Egress {
+				if err := t.addRule(id, rule.To, rule.Ports, false); err != nil {
+					return nil, fmt.Errorf("invalid egress rule of network policy %s: %s", id, err)
+				}
+			}
+		}
+	}
+
+	if !ingress {
+		t.allowAll(true)
+	}
+
+	if !egress {
+		t.allowAll(false)
+	}
+
+	identity := policy.NewTagStore()
+	identity.AppendKeyValue(NameTag, pod.Name)
+	identity.AppendKeyValue(NamespaceTag, pod.Namespace)
+	for _, k := range sortedKeys(pod.Labels) {
+		identity.AppendKeyValue(LabelPrefix+k, pod.Labels[k])
+	}
+
+	options := []policy.PUPolicyOption{
+		policy.OptionIdentity(identity),
+		policy.OptionReceiverRules(t.receiverRules),
+		policy.OptionTransmitterRules(t.transmitterRules),
+		policy.OptionNetworkACLs(t.networkACLs),
+		policy.OptionApplicationACLs(t.applicationACLs),
+	}
+
+	if pod.IP != "" {
+		options = append(options, policy.OptionIPAddresses(policy.ExtendedMap{policy.DefaultNamespace: pod.IP}))
+	}
+
+	return policy.BuildPUPolicy(append(options, opts...)...)
+}
+
+// hasType returns true if the policy applies to the traffic of the type. The
+// policies without types apply to the ingress traffic, and to the egress
+// traffic if they have egress rules.
+func (np *NetworkPolicy) hasType(policyType string) bool {
+
+	if len(np.Spec.PolicyTypes) == 0 {
+		return policyType == PolicyTypeIngress || len(np.Spec.Egress) > 0
+	}
+
+	for _, t := range np.Spec.PolicyTypes {
+		if t == policyType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// translator accumulates the rules of the network policies of a pod
+type translator struct {
+	pod              *Pod
+	namespaces       map[string]map[string]string
+	receiverRules    policy.TagSelectorList
+	transmitterRules policy.TagSelectorList
+	networkACLs      policy.IPRuleList
+	applicationACLs  policy.IPRuleList
+}
+
+// addRule adds the rules and the ACLs of the peers and the ports of a rule of
+// the network policy with the ID
+func (t *translator) addRule(id string, peers []NetworkPolicyPeer, ports []NetworkPolicyPort, ingress bool) error {
+
+	flowPolicy := &policy.FlowPolicy{
+		Action:    policy.Accept,
+		PolicyID:  id,
+		ServiceID: id,
+	}
+
+	if len(peers) == 0 {
+		peers = []NetworkPolicyPeer{{IPBlock: &IPBlock{CIDR: allNetworks}}, {}}
+	}
+
+	for _, peer := range peers {
+
+		if peer.IPBlock != nil {
+			if err := t.addIPBlock(peer.IPBlock, ports, flowPolicy, ingress); err != nil {
+				return err
+			}
+			continue
+		}
+
+		clause, ok, err := t.peerClause(&peer)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if !ingress {
+			t.transmitterRules = append(t.transmitterRules, policy.TagSelector{Clause: clause, Policy: flowPolicy})
+			continue
+		}
+
+		values, all, err := t.portValues(ports)
+		if err != nil {
+			return err
+		}
+		if !all {
+			if len(values) == 0 {
+				continue
+			}
+			clause = append(clause, policy.KeyValueOperator{
+				Key:      enforcerconstants.PortNumberLabelString,
+				Value:    values,
+				Operator: policy.AnyOf,
+			})
+		}
+
+		t.receiverRules = append(t.receiverRules, policy.TagSelector{Clause: clause, Policy: flowPolicy})
+	}
+
+	return nil
+}
+
+// allowAll adds the rules and the ACLs that allow all the traffic of a
+// direction
+func (t *translator) allowAll(ingress bool) {
+
+	t.addRule("default", nil, nil, ingress) // nolint
+}
+
+// peerClause returns the clause that selects the pods of a peer. It returns
+// false if the peer selects no namespace.
+func (t *translator) peerClause(peer *NetworkPolicyPeer) ([]policy.KeyValueOperator, bool, error) {
+
+	if peer.PodSelector == nil && peer.NamespaceSelector == nil {
+		return append([]policy.KeyValueOperator{}, allPeers...), true, nil
+	}
+
+	clause := []policy.KeyValueOperator{}
+
+	switch {
+	case peer.NamespaceSelector == nil:
+		clause = append(clause, policy.KeyValueOperator{
+			Key:      NamespaceTag,
+			Value:    []string{t.pod.Namespace},
+			Operator: policy.Equal,
+		})
+
+	case isEmptySelector(peer.NamespaceSelector):
+		clause = append(clause, policy.KeyValueOperator{
+			Key:      NamespaceTag,
+			Operator: policy.KeyExists,
+		})
+
+	default:
+		names := []string{}
+		for _, name := range sortedNamespaces(t.namespaces) {
+			if matchSelector(peer.NamespaceSelector, t.namespaces[name]) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, false, nil
+		}
+		clause = append(clause, policy.KeyValueOperator{
+			Key:      NamespaceTag,
+			Value:    names,
+			Operator: policy.AnyOf,
+		})
+	}
+
+	if peer.PodSelector != nil {
+		labels, err := labelClause(peer.PodSelector)
+		if err != nil {
+			return nil, false, err
+		}
+		clause = append(clause, labels...)
+	}
+
+	return clause, true, nil
+}
+
+// portValues returns the values of the port tag of the ports of the pod, or
+// true if all the ports are allowed. The named ports that the pod does not
+// have are ignored.
+func (t *translator) portValues(ports []NetworkPolicyPort) ([]string, bool, error) {
+
+	values := []string{}
+
+	for _, p := range ports {
+		if p.Port == nil {
+			return nil, true, nil
+		}
+
+		min, ok := t.portNumber(p.Port, true)
+		if !ok {
+			continue
+		}
+
+		max := min
+		if p.EndPort != nil {
+			max = *p.EndPort
+		}
+
+		if max < min || max-min >= maxPortRange {
+			return nil, false, fmt.Errorf("invalid port range %d:%d", min, max)
+		}
+
+		for port := min; port <= max; port++ {
+			values = append(values, strconv.Itoa(port))
+		}
+	}
+
+	return values, len(ports) == 0, nil
+}
+
+// portNumber returns the number of a port. The named ports are the ports of
+// the pod for the ingress rules, and are not known for the egress rules.
+func (t *translator) portNumber(port *PortValue, ingress bool) (int, bool) {
+
+	if port.Name == "" {
+		return port.Number, true
+	}
+
+	if !ingress {
+		return 0, false
+	}
+
+	number, ok := t.pod.Ports[port.Name]
+
+	return number, ok
+}
+
+// addIPBlock adds the ACLs of the networks of an IP block
+func (t *translator) addIPBlock(block *IPBlock, ports []NetworkPolicyPort, flowPolicy *policy.FlowPolicy, ingress bool) error {
+
+	networks, err := subtractNetworks(block.CIDR, block.Except)
+	if err != nil {
+		return err
+	}
+
+	if len(ports) == 0 {
+		ports = []NetworkPolicyPort{{Protocol: ProtocolTCP}, {Protocol: ProtocolUDP}}
+	}
+
+	for _, network := range networks {
+		for _, p := range ports {
+
+			protocol := p.Protocol
+			if protocol == "" {
+				protocol = ProtocolTCP
+			}
+			if protocol == ProtocolSCTP {
+				continue
+			}
+
+			portRange := allPorts
+			if p.Port != nil {
+				port, ok := t.portNumber(p.Port, ingress)
+				if !ok {
+					continue
+				}
+				portRange = strconv.Itoa(port)
+				if p.EndPort != nil {
+					portRange += ":" + strconv.Itoa(*p.EndPort)
+				}
+			}
+
+			rule := policy.IPRule{
+				Address:  network,
+				Port:     portRange,
+				Protocol: strings.ToLower(protocol),
+				Policy:   flowPolicy,
+			}
+
+			if ingress {
+				t.networkACLs = append(t.networkACLs, rule)
+			} else {
+				t.applicationACLs = append(t.applicationACLs, rule)
+			}
+		}
+	}
+
+	return nil
+}
+
+// labelClause returns the clause of the labels of a pod selector
+func labelClause(selector *LabelSelector) ([]policy.KeyValueOperator, error) {
+
+	clause := []policy.KeyValueOperator{}
+
+	for _, k := range sortedKeys(selector.MatchLabels) {
+		clause = append(clause, policy.KeyValueOperator{
+			Key:      LabelPrefix + k,
+			Value:    []string{selector.MatchLabels[k]},
+			Operator: policy.Equal,
+		})
+	}
+
+	for _, r := range selector.MatchExpressions {
+		kv := policy.KeyValueOperator{
+			Key:   LabelPrefix + r.Key,
+			Value: r.Values,
+		}
+
+		switch r.Operator {
+		case LabelSelectorOpIn:
+			kv.Operator = policy.AnyOf
+		case LabelSelectorOpNotIn:
+			kv.Operator = policy.Not
+		case LabelSelectorOpExists:
+			kv.Operator = policy.KeyExists
+			kv.Value = nil
+		case LabelSelectorOpDoesNotExist:
+			kv.Operator = policy.KeyNotExists
+			kv.Value = nil
+		default:
+			return nil, fmt.Errorf("invalid operator %s for label %s", r.Operator, r.Key)
+		}
+
+		clause = append(clause, kv)
+	}
+
+	return clause, nil
+}
+
+// matchSelector returns true if the selector selects the labels
+func matchSelector(selector *LabelSelector, labels map[string]string) bool {
+
+	for k, v := range selector.MatchLabels {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	for _, r := range selector.MatchExpressions {
+		value, ok := labels[r.Key]
+
+		switch r.Operator {
+		case LabelSelectorOpIn:
+			if !ok || !contains(r.Values, value) {
+				return false
+			}
+		case LabelSelectorOpNotIn:
+			if ok && contains(r.Values, value) {
+				return false
+			}
+		case LabelSelectorOpExists:
+			if !ok {
+				return false
+			}
+		case LabelSelectorOpDoesNotExist:
+			if ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// isEmptySelector returns true if the selector selects all the objects
+func isEmptySelector(selector *LabelSelector) bool {
+	return len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedNamespaces(m map[string]map[string]string) []string {
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,190 @@
+package kubernetespolicy
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/enforcer/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const testPolicies = `{
+  "items": [
+    {
+      "metadata": {"name": "db", "namespace": "shop"},
+      "spec": {
+        "podSelector": {"matchLabels": {"app": "db"}},
+        "ingress": [
+          {
+            "from": [
+              {"podSelector": {"matchLabels": {"app": "api"}}},
+              {"namespaceSelector": {"matchLabels": {"team": "ops"}}, "podSelector": {"matchExpressions": [{"key": "role", "operator": "In", "values": ["backup"]}]}},
+              {"ipBlock": {"cidr": "10.0.0.0/8", "except": ["10.1.0.0/16"]}}
+            ],
+            "ports": [{"protocol": "TCP", "port": "postgres"}, {"port": 9100, "endPort": 9101}]
+          }
+        ],
+        "egress": [
+          {"to": [{"namespaceSelector": {}}]},
+          {"to": [{"ipBlock": {"cidr": "52.0.0.0/8"}}], "ports": [{"port": 443}]}
+        ],
+        "policyTypes": ["Ingress", "Egress"]
+      }
+    },
+    {
+      "metadata": {"name": "web", "namespace": "shop"},
+      "spec": {"podSelector": {"matchLabels": {"app": "web"}}}
+    }
+  ]
+}`
+
+func TestNewPUPolicy(t *testing.T) {
+
+	Convey("Given network policies and the namespaces", t, func() {
+
+		list := &NetworkPolicyList{}
+		So(json.Unmarshal([]byte(testPolicies), list), ShouldBeNil)
+
+		namespaces := map[string]map[string]string{
+			"shop":       {"team": "shop"},
+			"monitoring": {"team": "ops"},
+			"logging":    {"team": "ops"},
+		}
+
+		Convey("When I translate the policies of a pod selected by a policy", func() {
+
+			pod := &Pod{
+				Name:      "db-0",
+				Namespace: "shop",
+				Labels:    map[string]string{"app": "db"},
+				IP:        "10.2.0.5",
+				Ports:     map[string]int{"postgres": 5432},
+			}
+
+			p, err := NewPUPolicy(pod, list.Items, namespaces, policy.OptionManagementID("db-0"))
+			So(err, ShouldBeNil)
+
+			Convey("Then the pod should have the identity of the Kubernetes monitor", func() {
+				So(p.ManagementID(), ShouldEqual, "db-0")
+				So(p.Identity().Tags, ShouldResemble, []string{"@sys:name=db-0", "@sys:namespace=shop", "@usr:app=db"})
+				So(p.IPAddresses()[policy.DefaultNamespace], ShouldEqual, "10.2.0.5")
+			})
+
+			Convey("Then the peers should be receiver rules with the ports of the pod", func() {
+				port := policy.KeyValueOperator{
+					Key:      enforcerconstants.PortNumberLabelString,
+					Value:    []string{"5432", "9100", "9101"},
+					Operator: policy.AnyOf,
+				}
+
+				rules := p.ReceiverRules()
+				So(rules, ShouldHaveLength, 2)
+				So(rules[0].Policy.PolicyID, ShouldEqual, "shop/db")
+				So(rules[0].Clause, ShouldResemble, []policy.KeyValueOperator{
+					{Key: NamespaceTag, Value: []string{"shop"}, Operator: policy.Equal},
+					{Key: "@usr:app", Value: []string{"api"}, Operator: policy.Equal},
+					port,
+				})
+				So(rules[1].Clause, ShouldResemble, []policy.KeyValueOperator{
+					{Key: NamespaceTag, Value: []string{"logging", "monitoring"}, Operator: policy.AnyOf},
+					{Key: "@usr:role", Value: []string{"backup"}, Operator: policy.AnyOf},
+					port,
+				})
+			})
+
+			Convey("Then the IP blocks should be ACLs without their exceptions", func() {
+				networks := map[string]bool{}
+				for _, rule := range p.NetworkACLs() {
+					networks[rule.Address] = true
+					So(rule.Protocol, ShouldEqual, "tcp")
+				}
+				So(networks, ShouldResemble, map[string]bool{
+					"10.128.0.0/9": true, "10.64.0.0/10": true, "10.32.0.0/11": true, "10.16.0.0/12": true,
+					"10.8.0.0/13": true, "10.4.0.0/14": true, "10.2.0.0/15": true, "10.0.0.0/16": true,
+				})
+				So(p.NetworkACLs(), ShouldHaveLength, 16)
+			})
+
+			Convey("Then the egress rules should be transmitter rules and application ACLs", func() {
+				So(p.TransmitterRules(), ShouldHaveLength, 1)
+				So(p.TransmitterRules()[0].Clause, ShouldResemble, []policy.KeyValueOperator{
+					{Key: NamespaceTag, Operator: policy.KeyExists},
+				})
+				So(p.ApplicationACLs(), ShouldResemble, policy.IPRuleList{
+					{Address: "52.0.0.0/8", Port: "443", Protocol: "tcp", Policy: p.ApplicationACLs()[0].Policy},
+				})
+			})
+		})
+
+		Convey("When I translate the policies of a pod selected by an ingress policy without rules", func() {
+
+			p, err := NewPUPolicy(&Pod{Name: "web-0", Namespace: "shop", Labels: map[string]string{"app": "web"}}, list.Items, namespaces)
+			So(err, ShouldBeNil)
+
+			Convey("Then its ingress traffic should be denied and its egress traffic allowed", func() {
+				So(p.ReceiverRules(), ShouldBeEmpty)
+				So(p.NetworkACLs(), ShouldBeEmpty)
+				So(p.TransmitterRules(), ShouldHaveLength, 1)
+				So(p.TransmitterRules()[0].Clause[0].Key, ShouldEqual, enforcerconstants.TransmitterLabel)
+				So(p.ApplicationACLs(), ShouldHaveLength, 2)
+				So(p.ApplicationACLs()[0].Address, ShouldEqual, "0.0.0.0/0")
+				So(p.ApplicationACLs()[0].Port, ShouldEqual, "0:65535")
+			})
+		})
+
+		Convey("When I translate the policies of a pod in another namespace", func() {
+
+			p, err := NewPUPolicy(&Pod{Name: "db-0", Namespace: "other", Labels: map[string]string{"app": "db"}}, list.Items, namespaces)
+			So(err, ShouldBeNil)
+
+			Convey("Then all its traffic should be allowed", func() {
+				So(p.ReceiverRules(), ShouldHaveLength, 1)
+				So(p.TransmitterRules(), ShouldHaveLength, 1)
+				So(p.NetworkACLs(), ShouldHaveLength, 2)
+				So(p.ApplicationACLs(), ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When a policy has an invalid selector", func() {
+
+			list.Items[1].Spec.Ingress = []NetworkPolicyIngressRule{
+				{From: []NetworkPolicyPeer{{PodSelector: &LabelSelector{MatchExpressions: []LabelSelectorRequirement{{Key: "app", Operator: "Like"}}}}}},
+			}
+
+			_, err := NewPUPolicy(&Pod{Name: "web-0", Namespace: "shop", Labels: map[string]string{"app": "web"}}, list.Items, namespaces)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestSubtractNetworks(t *testing.T) {
+
+	Convey("Given a network and its exceptions", t, func() {
+
+		Convey("The exceptions should be removed from the network", func() {
+			networks, err := subtractNetworks("192.168.0.0/24", []string{"192.168.0.128/25", "192.168.0.0/26"})
+			So(err, ShouldBeNil)
+			So(networks, ShouldResemble, []string{"192.168.0.64/26"})
+		})
+
+		Convey("The exceptions outside of the network should be ignored", func() {
+			networks, err := subtractNetworks("192.168.0.0/24", []string{"10.0.0.0/8"})
+			So(err, ShouldBeNil)
+			So(networks, ShouldResemble, []string{"192.168.0.0/24"})
+		})
+
+		Convey("The IPv6 networks should be ignored and the invalid networks rejected", func() {
+			networks, err := subtractNetworks("fd00::/8", nil)
+			So(err, ShouldBeNil)
+			So(networks, ShouldBeEmpty)
+
+			_, err = subtractNetworks("10.0.0.0/33", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,140 @@
+package kubernetespolicy
+
+import (
+	"encoding/json"
+)
+
+// Policy types of a network policy
+const (
+	PolicyTypeIngress = "Ingress"
+	PolicyTypeEgress  = "Egress"
+)
+
+// Protocols of the ports of a network policy
+const (
+	ProtocolTCP  = "TCP"
+	ProtocolUDP  = "UDP"
+	ProtocolSCTP = "SCTP"
+)
+
+// Operators of the requirements of a label selector
+const (
+	LabelSelectorOpIn           = "In"
+	LabelSelectorOpNotIn        = "NotIn"
+	LabelSelectorOpExists       = "Exists"
+	LabelSelectorOpDoesNotExist = "DoesNotExist"
+)
+
+// ObjectMeta is the metadata of a network policy
+type ObjectMeta struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// LabelSelectorRequirement is a requirement of a label selector
+type LabelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// LabelSelector selects objects by their labels. An empty selector selects
+// all the objects.
+type LabelSelector struct {
+	MatchLabels      map[string]string          `json:"matchLabels,omitempty"`
+	MatchExpressions []LabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+// IPBlock is a network, without the networks of its exceptions
+type IPBlock struct {
+	CIDR   string   `json:"cidr"`
+	Except []string `json:"except,omitempty"`
+}
+
+// NetworkPolicyPeer selects the pods of namespaces, or a network. The pods of
+// the namespace of the policy are selected if there is no namespace selector.
+type NetworkPolicyPeer struct {
+	PodSelector       *LabelSelector `json:"podSelector,omitempty"`
+	NamespaceSelector *LabelSelector `json:"namespaceSelector,omitempty"`
+	IPBlock           *IPBlock       `json:"ipBlock,omitempty"`
+}
+
+// PortValue is the number or the name of a port
+type PortValue struct {
+	Number int
+	Name   string
+}
+
+// UnmarshalJSON decodes a number or a string
+func (v *PortValue) UnmarshalJSON(data []byte) error {
+
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &v.Name)
+	}
+
+	return json.Unmarshal(data, &v.Number)
+}
+
+// MarshalJSON encodes the name of the port, or its number if it has no name
+func (v PortValue) MarshalJSON() ([]byte, error) {
+
+	if v.Name != "" {
+		return json.Marshal(v.Name)
+	}
+
+	return json.Marshal(v.Number)
+}
+
+// NetworkPolicyPort is a port, or a range of ports up to the end port. The
+// protocol is TCP by default, and all the ports are selected if there is no
+// port.
+type NetworkPolicyPort struct {
+	Protocol string     `json:"protocol,omitempty"`
+	Port     *PortValue `json:"port,omitempty"`
+	EndPort  *int       `json:"endPort,omitempty"`
+}
+
+// NetworkPolicyIngressRule allows the traffic from the peers to the ports. All
+// the peers or all the ports are allowed if there are none.
+type NetworkPolicyIngressRule struct {
+	From  []NetworkPolicyPeer `json:"from,omitempty"`
+	Ports []NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// NetworkPolicyEgressRule allows the traffic to the peers on the ports. All
+// the peers or all the ports are allowed if there are none.
+type NetworkPolicyEgressRule struct {
+	To    []NetworkPolicyPeer `json:"to,omitempty"`
+	Ports []NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// NetworkPolicySpec is the specification of a network policy
+type NetworkPolicySpec struct {
+	PodSelector LabelSelector              `json:"podSelector"`
+	Ingress     []NetworkPolicyIngressRule `json:"ingress,omitempty"`
+	Egress      []NetworkPolicyEgressRule  `json:"egress,omitempty"`
+	PolicyTypes []string                   `json:"policyTypes,omitempty"`
+}
+
+// NetworkPolicy is a network policy as returned by the API server
+type NetworkPolicy struct {
+	Metadata ObjectMeta        `json:"metadata"`
+	Spec     NetworkPolicySpec `json:"spec"`
+}
+
+// NetworkPolicyList is a list of network policies as returned by the API
+// server
+type NetworkPolicyList struct {
+	Items []NetworkPolicy `json:"items"`
+}
+
+// Pod is the part of a pod that is needed to translate its network policies
+type Pod struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+	// IP is the address of the pod, if known
+	IP string
+	// Ports are the numbers of the named ports of the containers of the pod
+	Ports map[string]int
+}
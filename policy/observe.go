@@ -0,0 +1,114 @@
+package policy
+
+import "math"
+
+// observeOnlyPriority is the priority of the rules that accept the flows that
+// are not accepted by the rules of an observe only policy
+const observeOnlyPriority = math.MaxInt32
+
+// ObserveOnly returns a copy of the policy that does not drop any flow. The
+// rejects, and the observed rules that apply a reject, are observed without
+// applying their action, so that the flows they match are reported as
+// rejected. The flows that no rule accepts are reported as rejected by the
+// default policy, and all the flows are accepted.
+func (p *PUPolicy) ObserveOnly() *PUPolicy {
+
+	np := p.Clone()
+
+	np.Lock()
+	defer np.Unlock()
+
+	np.applicationACLs = observeOnlyIPRules(np.applicationACLs)
+	np.networkACLs = observeOnlyIPRules(np.networkACLs)
+	np.transmitterRules = observeOnlyTagSelectors(np.transmitterRules)
+	np.receiverRules = observeOnlyTagSelectors(np.receiverRules)
+
+	return np
+}
+
+// observeOnlyIPRules returns the observe only version of a list of ACLs
+func observeOnlyIPRules(rules IPRuleList) IPRuleList {
+
+	list := IPRuleList{}
+
+	for _, rule := range rules {
+		rule.Policy = observeOnlyFlowPolicy(rule.Policy)
+		list = append(list, rule)
+	}
+
+	// The default ACLs match the flows of the protocols of the datapath and
+	// all the other ones
+	for _, protocol := range []string{"tcp", "udp", "all"} {
+		port := "1:65535"
+		if protocol == "all" {
+			port = ""
+		}
+
+		for _, flowPolicy := range observeOnlyDefaultPolicies() {
+			list = append(list, IPRule{
+				Address:  "0.0.0.0/0",
+				Port:     port,
+				Protocol: protocol,
+				Policy:   flowPolicy,
+				Priority: observeOnlyPriority,
+			})
+		}
+	}
+
+	return list
+}
+
+// observeOnlyTagSelectors returns the observe only version of a list of rules.
+// The default rules have no clause, so that they match all the tags.
+func observeOnlyTagSelectors(rules TagSelectorList) TagSelectorList {
+
+	list := TagSelectorList{}
+
+	for _, rule := range rules {
+		rule.Policy = observeOnlyFlowPolicy(rule.Policy)
+		list = append(list, rule)
+	}
+
+	for _, flowPolicy := range observeOnlyDefaultPolicies() {
+		list = append(list, TagSelector{
+			Clause:   []KeyValueOperator{},
+			Policy:   flowPolicy,
+			Priority: observeOnlyPriority,
+		})
+	}
+
+	return list
+}
+
+// observeOnlyFlowPolicy returns a copy of a reject policy that is observed
+// without applying its action. The other policies are returned as they are.
+func observeOnlyFlowPolicy(f *FlowPolicy) *FlowPolicy {
+
+	if f == nil || !f.Action.Rejected() || f.ObserveAction.ObserveContinue() {
+		return f
+	}
+
+	observed := *f
+	observed.ObserveAction = ObserveContinue
+
+	return &observed
+}
+
+// observeOnlyDefaultPolicies returns the policies of the default rules: the
+// default reject is reported, and the flow is accepted.
+func observeOnlyDefaultPolicies() []*FlowPolicy {
+
+	return []*FlowPolicy{
+		{
+			Action:        Reject,
+			ObserveAction: ObserveContinue,
+			PolicyID:      "default",
+			ServiceID:     "default",
+		},
+		{
+			Action:    Accept,
+			PolicyID:  "default",
+			ServiceID: "default",
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestObserveOnly(t *testing.T) {
+	Convey("Given a policy with accept and reject rules", t, func() {
+		reject := &FlowPolicy{Action: Reject, PolicyID: "reject"}
+		accept := &FlowPolicy{Action: Accept | Encrypt, PolicyID: "accept"}
+
+		p, err := BuildPUPolicy(
+			OptionTriremeAction(Police),
+			OptionApplicationACLs(IPRuleList{
+				IPRule{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp", Policy: reject},
+				IPRule{Address: "10.1.0.0/16", Port: "80", Protocol: "tcp", Policy: accept},
+			}),
+			OptionReceiverRules(TagSelectorList{
+				TagSelector{Clause: []KeyValueOperator{{Key: "app", Value: []string{"db"}, Operator: Equal}}, Policy: reject},
+			}),
+		)
+		So(err, ShouldBeNil)
+
+		Convey("When I make it observe only", func() {
+			o := p.ObserveOnly()
+
+			Convey("The rejects should be observed and the accepts kept", func() {
+				acls := o.ApplicationACLs()
+				So(acls[0].Policy.Action, ShouldEqual, Reject)
+				So(acls[0].Policy.ObserveAction, ShouldEqual, ObserveContinue)
+				So(acls[0].Policy.PolicyID, ShouldEqual, "reject")
+				So(acls[1].Policy, ShouldEqual, accept)
+
+				rules := o.ReceiverRules()
+				So(rules[0].Policy.ObserveAction, ShouldEqual, ObserveContinue)
+			})
+
+			Convey("The default rules should report the reject and accept the flows", func() {
+				acls := o.ApplicationACLs()
+				So(len(acls), ShouldEqual, 8)
+				for _, acl := range acls[2:] {
+					So(acl.Priority, ShouldEqual, observeOnlyPriority)
+				}
+				So(acls[6].Protocol, ShouldEqual, "all")
+				So(acls[6].Policy.ObserveAction, ShouldEqual, ObserveContinue)
+				So(acls[7].Policy.Action, ShouldEqual, Accept)
+
+				rules := o.ReceiverRules()
+				So(len(rules), ShouldEqual, 3)
+				So(rules[2].Clause, ShouldBeEmpty)
+				So(rules[2].Policy.Action, ShouldEqual, Accept)
+
+				So(o.NetworkACLs(), ShouldHaveLength, 6)
+				So(o.TransmitterRules(), ShouldHaveLength, 2)
+			})
+
+			Convey("The original policy should not change", func() {
+				So(reject.ObserveAction, ShouldEqual, ObserveNone)
+				So(p.ApplicationACLs(), ShouldHaveLength, 2)
+				So(p.ReceiverRules(), ShouldHaveLength, 1)
+			})
+		})
+	})
+}
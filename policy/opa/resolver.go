@@ -0,0 +1,303 @@
+package opapolicy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+)
+
+const (
+	// DefaultDecision is the path of the document of the OPA server that is
+	// the policy of a PU
+	DefaultDecision = "trireme/policy"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// puTypes are the names of the types of the PUs in the input
+var puTypes = map[constants.PUType]string{
+	constants.ContainerPU:    "container",
+	constants.LinuxProcessPU: "linuxprocess",
+	constants.KubernetesPU:   "kubernetes",
+	constants.UIDLoginPU:     "uidlogin",
+}
+
+// Input is the input of the Rego policies for a PU
+type Input struct {
+	ContextID string `json:"contextID"`
+	Name      string `json:"name"`
+	PUType    string `json:"puType"`
+	// Tags are the tags of the runtime of the PU, like key=value
+	Tags []string `json:"tags"`
+	// Labels are the tags by key. The last value of a key is kept.
+	Labels      map[string]string `json:"labels"`
+	IPAddresses map[string]string `json:"ipAddresses"`
+}
+
+// newInput returns the input of the runtime of a PU
+func newInput(contextID string, runtime policy.RuntimeReader) *Input {
+
+	input := &Input{
+		ContextID:   contextID,
+		Name:        runtime.Name(),
+		PUType:      puTypes[runtime.PUType()],
+		Tags:        []string{},
+		Labels:      map[string]string{},
+		IPAddresses: runtime.IPAddresses(),
+	}
+
+	if tags := runtime.Tags(); tags != nil {
+		input.Tags = tags.GetSlice()
+		for _, tag := range input.Tags {
+			if kv := strings.SplitN(tag, "=", 2); len(kv) == 2 {
+				input.Labels[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	return input
+}
+
+// PolicyUpdater updates the policies of the PUs, like Trireme
+type PolicyUpdater interface {
+	UpdatePolicy(contextID string, policy *policy.PUPolicy) error
+}
+
+// resolvedPU is a PU whose policy was resolved
+type resolvedPU struct {
+	runtime policy.RuntimeReader
+	policy  []byte
+}
+
+// Resolver is a PolicyResolver that evaluates the Rego policies of an OPA
+// server. The decision document is the policy of a PU in the JSON format of
+// policy.PUPolicy, for the input of the runtime of the PU. The policies are
+// evaluated again by Refresh when the Rego modules or the bundles of the
+// server change.
+type Resolver struct {
+	server   string
+	decision string
+	token    string
+	client   *http.Client
+	pus      map[string]*resolvedPU
+	sync.Mutex
+}
+
+// Option is provided using functional arguments
+type Option func(*Resolver)
+
+// OptionDecision sets the path of the decision document. It is
+// DefaultDecision by default.
+func OptionDecision(path string) Option {
+	return func(r *Resolver) {
+		r.decision = strings.Trim(path, "/")
+	}
+}
+
+// OptionToken sets the bearer token of the requests to the OPA server
+func OptionToken(token string) Option {
+	return func(r *Resolver) {
+		r.token = token
+	}
+}
+
+// OptionHTTPClient sets the client of the requests to the OPA server, like a
+// client with TLS
+func OptionHTTPClient(client *http.Client) Option {
+	return func(r *Resolver) {
+		r.client = client
+	}
+}
+
+// NewResolver creates a resolver of the OPA server at the url, like
+// http://localhost:8181
+func NewResolver(server string, opts ...Option) *Resolver {
+
+	r := &Resolver{
+		server:   strings.TrimSuffix(server, "/"),
+		decision: DefaultDecision,
+		client:   &http.Client{Timeout: defaultTimeout},
+		pus:      map[string]*resolvedPU{},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// ResolvePolicy implements the PolicyResolver interface of Trireme. It returns
+// an error if the decision is not defined for the PU.
+func (r *Resolver) ResolvePolicy(contextID string, runtime policy.RuntimeReader) (*policy.PUPolicy, error) {
+
+	p, data, err := r.evaluate(contextID, runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Lock()
+	r.pus[contextID] = &resolvedPU{runtime: runtime, policy: data}
+	r.Unlock()
+
+	return p, nil
+}
+
+// HandlePUEvent implements the PolicyResolver interface of Trireme. The
+// destroyed PUs are not refreshed anymore.
+func (r *Resolver) HandlePUEvent(contextID string, event events.Event) {
+
+	if event != events.EventDestroy {
+		return
+	}
+
+	r.Lock()
+	delete(r.pus, contextID)
+	r.Unlock()
+}
+
+// Refresh evaluates again the policies of the PUs and updates the policies
+// that changed with the updater. It returns the last error, after all the PUs
+// are refreshed.
+func (r *Resolver) Refresh(updater PolicyUpdater) error {
+
+	r.Lock()
+	contextIDs := make([]string, 0, len(r.pus))
+	for contextID := range r.pus {
+		contextIDs = append(contextIDs, contextID)
+	}
+	r.Unlock()
+
+	sort.Strings(contextIDs)
+
+	var lastErr error
+
+	for _, contextID := range contextIDs {
+
+		r.Lock()
+		pu, ok := r.pus[contextID]
+		r.Unlock()
+		if !ok {
+			continue
+		}
+
+		p, data, err := r.evaluate(contextID, pu.runtime)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if bytes.Equal(data, pu.policy) {
+			continue
+		}
+
+		if err := updater.UpdatePolicy(contextID, p); err != nil {
+			lastErr = fmt.Errorf("unable to update policy of pu %s: %s", contextID, err)
+			continue
+		}
+
+		r.Lock()
+		if _, ok := r.pus[contextID]; ok {
+			r.pus[contextID] = &resolvedPU{runtime: pu.runtime, policy: data}
+		}
+		r.Unlock()
+	}
+
+	return lastErr
+}
+
+// LoadModule creates or replaces a Rego module of the OPA server
+func (r *Resolver) LoadModule(id string, module string) error {
+
+	resp, err := r.do(http.MethodPut, r.server+"/v1/policies/"+url.PathEscape(id), "text/plain", strings.NewReader(module))
+	if err != nil {
+		return fmt.Errorf("unable to load module %s: %s", id, err)
+	}
+
+	return resp.Body.Close()
+}
+
+// DeleteModule deletes a Rego module of the OPA server
+func (r *Resolver) DeleteModule(id string) error {
+
+	resp, err := r.do(http.MethodDelete, r.server+"/v1/policies/"+url.PathEscape(id), "", nil)
+	if err != nil {
+		return fmt.Errorf("unable to delete module %s: %s", id, err)
+	}
+
+	return resp.Body.Close()
+}
+
+// evaluate returns the policy of a PU and its document
+func (r *Resolver) evaluate(contextID string, runtime policy.RuntimeReader) (*policy.PUPolicy, []byte, error) {
+
+	body, err := json.Marshal(map[string]interface{}{"input": newInput(contextID, runtime)})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := r.do(http.MethodPost, r.server+"/v1/data/"+r.decision, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to evaluate policy of pu %s: %s", contextID, err)
+	}
+	defer resp.Body.Close() // nolint
+
+	result := struct {
+		Result json.RawMessage `json:"result"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, fmt.Errorf("invalid decision for pu %s: %s", contextID, err)
+	}
+
+	if len(result.Result) == 0 {
+		return nil, nil, fmt.Errorf("decision %s is not defined for pu %s", r.decision, contextID)
+	}
+
+	p := policy.NewPUPolicyWithDefaults()
+	if err := p.UnmarshalJSON(result.Result); err != nil {
+		return nil, nil, fmt.Errorf("invalid policy for pu %s: %s", contextID, err)
+	}
+
+	return p, result.Result, nil
+}
+
+// do sends a request to the OPA server and returns its successful response
+func (r *Resolver) do(method string, u string, contentType string, body io.Reader) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		resp.Body.Close() // nolint
+		return nil, fmt.Errorf("unexpected status from opa server: %s", resp.Status)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,174 @@
+package opapolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	"github.com/aporeto-inc/trireme-lib/rpc/events"
+)
+
+// testServer emulates the decision of an OPA server that accepts the
+// traffic from the PUs with the same app label, or from the PUs of the
+// label of the loaded module
+type testServer struct {
+	modules map[string]string
+	inputs  []*Input
+	sync.Mutex
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/policies/app":
+		data, _ := ioutil.ReadAll(r.Body) // nolint
+		s.modules["app"] = string(data)
+		fmt.Fprint(w, "{}") // nolint
+
+	case r.Method == http.MethodDelete && r.URL.Path == "/v1/policies/app":
+		delete(s.modules, "app")
+		fmt.Fprint(w, "{}") // nolint
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/data/trireme/policy":
+		request := struct {
+			Input *Input `json:"input"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.inputs = append(s.inputs, request.Input)
+
+		app, ok := request.Input.Labels["app"]
+		if !ok {
+			fmt.Fprint(w, "{}") // nolint
+			return
+		}
+		if module, ok := s.modules["app"]; ok {
+			app = module
+		}
+
+		fmt.Fprintf(w, `{"result": {
+			"ManagementID": "opa",
+			"TriremeAction": 2,
+			"ReceiverRules": [{"Clause": [{"Key": "app", "Value": ["%s"], "Operator": "="}], "Policy": {"Action": 1, "PolicyID": "app"}}]
+		}}`, app) // nolint
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// testUpdater records the updated policies
+type testUpdater struct {
+	policies map[string]*policy.PUPolicy
+}
+
+func (u *testUpdater) UpdatePolicy(contextID string, p *policy.PUPolicy) error {
+	u.policies[contextID] = p
+	return nil
+}
+
+func testRuntime(tags map[string]string) policy.RuntimeReader {
+	return policy.NewPURuntime("pu", 1, "", policy.NewTagStoreFromMap(tags), policy.ExtendedMap{"bridge": "172.17.0.2"}, constants.ContainerPU, nil)
+}
+
+func TestResolvePolicy(t *testing.T) {
+
+	Convey("Given a resolver of an OPA server", t, func() {
+
+		s := &testServer{modules: map[string]string{}}
+		server := httptest.NewServer(s)
+		defer server.Close()
+
+		r := NewResolver(server.URL+"/", OptionToken("token"))
+
+		Convey("When I resolve the policy of a PU with an app label", func() {
+
+			p, err := r.ResolvePolicy("pu1", testRuntime(map[string]string{"app": "web"}))
+
+			Convey("Then I should get the policy of the decision", func() {
+				So(err, ShouldBeNil)
+				So(p.ManagementID(), ShouldEqual, "opa")
+				So(p.TriremeAction(), ShouldEqual, policy.Police)
+				So(p.ReceiverRules(), ShouldHaveLength, 1)
+				So(p.ReceiverRules()[0].Clause[0].Value, ShouldResemble, []string{"web"})
+			})
+
+			Convey("Then the input should be the runtime of the PU", func() {
+				So(s.inputs, ShouldHaveLength, 1)
+				So(s.inputs[0].ContextID, ShouldEqual, "pu1")
+				So(s.inputs[0].Name, ShouldEqual, "pu")
+				So(s.inputs[0].PUType, ShouldEqual, "container")
+				So(s.inputs[0].Tags, ShouldResemble, []string{"app=web"})
+				So(s.inputs[0].IPAddresses, ShouldResemble, map[string]string{"bridge": "172.17.0.2"})
+			})
+		})
+
+		Convey("When I resolve the policy of a PU without decision", func() {
+
+			_, err := r.ResolvePolicy("pu1", testRuntime(map[string]string{"role": "db"}))
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the token is not valid", func() {
+
+			r = NewResolver(server.URL, OptionToken("other"))
+			_, err := r.ResolvePolicy("pu1", testRuntime(map[string]string{"app": "web"}))
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I load a module and refresh the policies", func() {
+
+			_, err := r.ResolvePolicy("pu1", testRuntime(map[string]string{"app": "web"}))
+			So(err, ShouldBeNil)
+			_, err = r.ResolvePolicy("pu2", testRuntime(map[string]string{"app": "api"}))
+			So(err, ShouldBeNil)
+			_, err = r.ResolvePolicy("pu3", testRuntime(map[string]string{"app": "db"}))
+			So(err, ShouldBeNil)
+			r.HandlePUEvent("pu3", events.EventDestroy)
+
+			So(r.LoadModule("app", "web"), ShouldBeNil)
+
+			u := &testUpdater{policies: map[string]*policy.PUPolicy{}}
+			err = r.Refresh(u)
+
+			Convey("Then the policies that changed should be updated", func() {
+				So(err, ShouldBeNil)
+				So(u.policies, ShouldHaveLength, 1)
+				So(u.policies["pu2"].ReceiverRules()[0].Clause[0].Value, ShouldResemble, []string{"web"})
+			})
+
+			Convey("Then the policies should be updated again when the module is deleted", func() {
+				So(r.DeleteModule("app"), ShouldBeNil)
+
+				u = &testUpdater{policies: map[string]*policy.PUPolicy{}}
+				So(r.Refresh(u), ShouldBeNil)
+				So(u.policies, ShouldHaveLength, 1)
+				So(u.policies["pu2"].ReceiverRules()[0].Clause[0].Value, ShouldResemble, []string{"api"})
+			})
+		})
+	})
+}
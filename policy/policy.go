@@ -1,6 +1,10 @@
 package policy
 
-import "sync"
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
 
 // PUPolicy captures all policy information related ot the container
 type PUPolicy struct {
@@ -32,6 +36,15 @@ type PUPolicy struct {
 	excludedNetworks []string
 	//Proxied Services string format ip:port
 	proxiedServices *ProxiedServicesInfo
+	// userAuthorization is the validation of the user tokens of the proxied connections
+	userAuthorization *UserAuthorization
+	// identityClaims are the claims of the application added to the identity tokens
+	identityClaims map[string]string
+	// httpRules authorize the HTTP requests of the proxied connections
+	httpRules HTTPRuleList
+	// sniRules authorize the TLS connections to external services by their
+	// server names
+	sniRules SNIRuleList
 	sync.Mutex
 }
 
@@ -130,10 +143,142 @@ func (p *PUPolicy) Clone() *PUPolicy {
 		p.excludedNetworks,
 		p.proxiedServices,
 	)
+	np.userAuthorization = p.userAuthorization
+	np.identityClaims = copyClaims(p.identityClaims)
+	np.httpRules = p.httpRules.Copy()
+	np.sniRules = p.sniRules.Copy()
 
 	return np
 }
 
+// PUPolicyJSON is the JSON representation of PUPolicy. The ACLs, the tag
+// selectors and the proxied services have the JSON representation of their
+// types, and the actions of their flow policies are the values of ActionType
+// and ObserveActionType.
+type PUPolicyJSON struct {
+	// ManagementID is the identifier of the policy in the implementation
+	ManagementID string
+	// TriremeAction is 1 to allow all the traffic of the PU and 2 to police it
+	TriremeAction PUAction
+	// ApplicationACLs are the ACLs of the traffic from the PU to the network
+	ApplicationACLs IPRuleList
+	// NetworkACLs are the ACLs of the traffic from the network to the PU
+	NetworkACLs IPRuleList
+	// TransmitterRules are matched with the tags of the receivers
+	TransmitterRules TagSelectorList
+	// ReceiverRules are matched with the tags of the transmitters
+	ReceiverRules TagSelectorList
+	// Identity are the tags sent over the wire
+	Identity *TagStore
+	// Annotations are the tags used for accounting
+	Annotations *TagStore
+	// IPAddresses are the IP addresses of the PU by namespace
+	IPAddresses ExtendedMap
+	// TriremeNetworks are the networks where the authorization is enforced
+	TriremeNetworks []string
+	// ExcludedNetworks are the networks that are not policed
+	ExcludedNetworks []string
+	// ProxiedServices are the ip,port pairs of the proxied services
+	ProxiedServices *ProxiedServicesInfo
+	// UserAuthorization is the validation of the user tokens of the proxied
+	// connections, if any
+	UserAuthorization *UserAuthorization `json:",omitempty"`
+	// IdentityClaims are the claims of the application added to the identity
+	// tokens, if any
+	IdentityClaims map[string]string `json:",omitempty"`
+	// HTTPRules authorize the HTTP requests of the proxied connections, if
+	// any
+	HTTPRules HTTPRuleList `json:",omitempty"`
+	// SNIRules authorize the TLS connections to external services by their
+	// server names, if any
+	SNIRules SNIRuleList `json:",omitempty"`
+}
+
+// MarshalJSON Marshals this struct.
+func (p *PUPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.jsonView())
+}
+
+// jsonView returns the fields of the policy in the schema of PUPolicyJSON
+func (p *PUPolicy) jsonView() *PUPolicyJSON {
+	p.Lock()
+	defer p.Unlock()
+
+	return &PUPolicyJSON{
+		ManagementID:      p.managementID,
+		TriremeAction:     p.triremeAction,
+		ApplicationACLs:   p.applicationACLs,
+		NetworkACLs:       p.networkACLs,
+		TransmitterRules:  p.transmitterRules,
+		ReceiverRules:     p.receiverRules,
+		Identity:          p.identity,
+		Annotations:       p.annotations,
+		IPAddresses:       p.ips,
+		TriremeNetworks:   p.triremeNetworks,
+		ExcludedNetworks:  p.excludedNetworks,
+		ProxiedServices:   p.proxiedServices,
+		UserAuthorization: p.userAuthorization,
+		IdentityClaims:    p.identityClaims,
+		HTTPRules:         p.httpRules,
+		SNIRules:          p.sniRules,
+	}
+}
+
+// UnmarshalJSON Unmarshals this struct. The missing fields take the values of
+// NewPUPolicy, and the addresses, the networks and the ports are validated as
+// they are by BuildPUPolicy.
+func (p *PUPolicy) UnmarshalJSON(param []byte) error {
+	a := &PUPolicyJSON{}
+	if err := json.Unmarshal(param, &a); err != nil {
+		return err
+	}
+
+	np := NewPUPolicy(
+		a.ManagementID,
+		a.TriremeAction,
+		a.ApplicationACLs,
+		a.NetworkACLs,
+		a.TransmitterRules,
+		a.ReceiverRules,
+		a.Identity,
+		a.Annotations,
+		a.IPAddresses,
+		a.TriremeNetworks,
+		a.ExcludedNetworks,
+		a.ProxiedServices,
+	)
+	np.userAuthorization = a.UserAuthorization
+	np.identityClaims = a.IdentityClaims
+	np.httpRules = a.HTTPRules
+	np.sniRules = a.SNIRules
+
+	if err := np.validate(); err != nil {
+		return fmt.Errorf("invalid policy: %s", err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.managementID = np.managementID
+	p.triremeAction = np.triremeAction
+	p.applicationACLs = np.applicationACLs
+	p.networkACLs = np.networkACLs
+	p.transmitterRules = np.transmitterRules
+	p.receiverRules = np.receiverRules
+	p.identity = np.identity
+	p.annotations = np.annotations
+	p.ips = np.ips
+	p.triremeNetworks = np.triremeNetworks
+	p.excludedNetworks = np.excludedNetworks
+	p.proxiedServices = np.proxiedServices
+	p.userAuthorization = np.userAuthorization
+	p.identityClaims = np.identityClaims
+	p.httpRules = np.httpRules
+	p.sniRules = np.sniRules
+
+	return nil
+}
+
 // ManagementID returns the management ID
 func (p *PUPolicy) ManagementID() string {
 	p.Lock()
@@ -290,3 +435,97 @@ func (p *PUPolicy) UpdateExcludedNetworks(networks []string) {
 
 	copy(p.excludedNetworks, networks)
 }
+
+// UserAuthorization returns the validation of the user tokens of the proxied
+// connections. It is nil if the connections do not require a user token.
+func (p *PUPolicy) UserAuthorization() *UserAuthorization {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.userAuthorization
+}
+
+// SetUserAuthorization sets the validation of the user tokens of the proxied
+// connections.
+func (p *PUPolicy) SetUserAuthorization(authorization *UserAuthorization) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.userAuthorization = authorization
+}
+
+// IdentityClaims returns a copy of the claims of the application that are
+// added to the identity tokens of the PU
+func (p *PUPolicy) IdentityClaims() map[string]string {
+	p.Lock()
+	defer p.Unlock()
+
+	return copyClaims(p.identityClaims)
+}
+
+// SetIdentityClaims sets the claims of the application that are added to the
+// identity tokens of the PU, like the digest of its image. It returns an error
+// if the claims do not fit in the tokens.
+func (p *PUPolicy) SetIdentityClaims(claims map[string]string) error {
+
+	if err := ValidateIdentityClaims(claims); err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.identityClaims = copyClaims(claims)
+
+	return nil
+}
+
+// HTTPRules returns a copy of the rules that authorize the HTTP requests of
+// the proxied connections of the PU
+func (p *PUPolicy) HTTPRules() HTTPRuleList {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.httpRules.Copy()
+}
+
+// SetHTTPRules sets the rules that authorize the HTTP requests of the proxied
+// connections of the PU. The requests are not inspected if there are no rules.
+func (p *PUPolicy) SetHTTPRules(rules HTTPRuleList) error {
+
+	if err := ValidateHTTPRules(rules); err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.httpRules = rules.Copy()
+
+	return nil
+}
+
+// SNIRules returns a copy of the rules that authorize the TLS connections of
+// the PU to external services by their server names
+func (p *PUPolicy) SNIRules() SNIRuleList {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.sniRules.Copy()
+}
+
+// SetSNIRules sets the rules that authorize the TLS connections of the PU to
+// external services by their server names
+func (p *PUPolicy) SetSNIRules(rules SNIRuleList) error {
+
+	if err := ValidateSNIRules(rules); err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.sniRules = rules.Copy()
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package policy
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/aporeto-inc/trireme-lib/constants"
@@ -383,6 +385,66 @@ func TestAllLockedSetGet(t *testing.T) {
 
 }
 
+func TestUserAuthorization(t *testing.T) {
+	Convey("Given a policy", t, func() {
+		p := NewPUPolicyWithDefaults()
+
+		Convey("By default it should not require a user token", func() {
+			So(p.UserAuthorization(), ShouldBeNil)
+		})
+
+		Convey("When I set a user authorization", func() {
+			authorization := &UserAuthorization{
+				Issuer: "https://issuer",
+				Claims: []string{"email"},
+			}
+			p.SetUserAuthorization(authorization)
+
+			Convey("Then I should get it back", func() {
+				So(p.UserAuthorization(), ShouldEqual, authorization)
+			})
+
+			Convey("Then a clone of the policy should keep it", func() {
+				So(p.Clone().UserAuthorization(), ShouldEqual, authorization)
+			})
+		})
+	})
+}
+
+func TestIdentityClaims(t *testing.T) {
+	Convey("Given a policy", t, func() {
+		p := NewPUPolicyWithDefaults()
+
+		Convey("By default it should have no identity claims", func() {
+			So(p.IdentityClaims(), ShouldBeNil)
+		})
+
+		Convey("When I set identity claims", func() {
+			claims := map[string]string{"image": "sha256:1234", "build": "42"}
+			So(p.SetIdentityClaims(claims), ShouldBeNil)
+
+			Convey("Then I should get a copy of them back", func() {
+				So(p.IdentityClaims(), ShouldResemble, claims)
+				claims["build"] = "43"
+				So(p.IdentityClaims()["build"], ShouldEqual, "42")
+			})
+
+			Convey("Then a clone of the policy should keep them", func() {
+				So(p.Clone().IdentityClaims(), ShouldResemble, map[string]string{"image": "sha256:1234", "build": "42"})
+			})
+		})
+
+		Convey("When I set claims with an empty key, I should get an error", func() {
+			So(p.SetIdentityClaims(map[string]string{"": "value"}), ShouldNotBeNil)
+			So(p.IdentityClaims(), ShouldBeNil)
+		})
+
+		Convey("When I set claims that do not fit in the tokens, I should get an error", func() {
+			So(p.SetIdentityClaims(map[string]string{"big": strings.Repeat("a", MaxIdentityClaimsSize)}), ShouldNotBeNil)
+		})
+	})
+}
+
 func TestPUInfo(t *testing.T) {
 	Convey("Given I try to initiate a new container policy", t, func() {
 		puInfor := NewPUInfo("123", constants.ContainerPU)
@@ -396,3 +458,61 @@ func TestPUInfo(t *testing.T) {
 		})
 	})
 }
+
+func TestPUPolicyJSON(t *testing.T) {
+	Convey("Given a policy with all its fields", t, func() {
+		identity := NewTagStore()
+		identity.AppendKeyValue("app", "web")
+
+		p, err := BuildPUPolicy(
+			OptionManagementID("id1"),
+			OptionTriremeAction(Police),
+			OptionApplicationACLs(IPRuleList{
+				IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80",
+					Protocol: "TCP",
+					Policy:   &FlowPolicy{Action: Accept | Log, PolicyID: "policy1"},
+				},
+			}),
+			OptionReceiverRules(TagSelectorList{
+				TagSelector{
+					Clause: []KeyValueOperator{{Key: "app", Value: []string{"db"}, Operator: Equal}},
+					Policy: &FlowPolicy{Action: Accept},
+				},
+			}),
+			OptionIdentity(identity),
+			OptionIPAddresses(ExtendedMap{DefaultNamespace: "172.17.0.2"}),
+			OptionTriremeNetworks([]string{"172.17.0.0/16"}),
+			OptionExcludedNetworks([]string{}),
+			OptionProxiedServices(&ProxiedServicesInfo{PublicIPPortPair: []string{"10.0.0.1,80"}}),
+		)
+		So(err, ShouldBeNil)
+
+		Convey("When I marshal and unmarshal it, I should get the same policy", func() {
+			data, err := json.Marshal(p)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"ManagementID":"id1"`)
+			So(string(data), ShouldNotContainSubstring, "UserAuthorization")
+
+			np := &PUPolicy{}
+			So(json.Unmarshal(data, np), ShouldBeNil)
+			So(np, ShouldResemble, p)
+		})
+
+		Convey("When I unmarshal a policy without fields, I should get an empty policy", func() {
+			np := &PUPolicy{}
+			So(json.Unmarshal([]byte(`{"TriremeAction":2}`), np), ShouldBeNil)
+			So(np.TriremeAction(), ShouldEqual, Police)
+			So(np.ApplicationACLs(), ShouldNotBeNil)
+			So(np.Identity(), ShouldNotBeNil)
+			So(np.ProxiedServices(), ShouldNotBeNil)
+		})
+
+		Convey("When I unmarshal an invalid policy, I should get an error", func() {
+			np := &PUPolicy{}
+			So(json.Unmarshal([]byte(`{"NetworkACLs":[{"Address":"10.0.0.0/8","Port":"http","Protocol":"tcp","Policy":{"Action":1}}]}`), np), ShouldNotBeNil)
+			So(json.Unmarshal([]byte(`{"TriremeNetworks":"10.0.0.0/8"}`), np), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package policy
+
+// quarantinePolicyID is the policy ID of the rules of a quarantine policy
+const quarantinePolicyID = "quarantine"
+
+// NewQuarantinePUPolicy returns the policy of a PU whose policy could not be
+// set. The ACLs reject and log all the flows of the PU, and no tag rule
+// accepts a flow, so that the PU can keep running without any network access.
+func NewQuarantinePUPolicy(id string, ips ExtendedMap, triremeNetworks []string) *PUPolicy {
+
+	annotations := NewTagStore()
+	annotations.AppendKeyValue("@sys:quarantine", "true")
+
+	return NewPUPolicy(
+		id,
+		Police,
+		quarantineIPRules(),
+		quarantineIPRules(),
+		nil,
+		nil,
+		nil,
+		annotations,
+		ips,
+		triremeNetworks,
+		[]string{},
+		nil,
+	)
+}
+
+// quarantineIPRules returns the ACLs that reject the flows of all the
+// protocols
+func quarantineIPRules() IPRuleList {
+
+	list := IPRuleList{}
+
+	for _, protocol := range []string{"tcp", "udp", "all"} {
+		port := "1:65535"
+		if protocol == "all" {
+			port = ""
+		}
+
+		list = append(list, IPRule{
+			Address:  "0.0.0.0/0",
+			Port:     port,
+			Protocol: protocol,
+			Policy: &FlowPolicy{
+				Action:    Reject | Log,
+				PolicyID:  quarantinePolicyID,
+				ServiceID: quarantinePolicyID,
+			},
+		})
+	}
+
+	return list
+}
@@ -0,0 +1,39 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewQuarantinePUPolicy(t *testing.T) {
+	Convey("Given a quarantine policy", t, func() {
+		p := NewQuarantinePUPolicy("pu", ExtendedMap{"bridge": "172.17.0.2"}, []string{"0.0.0.0/0"})
+
+		Convey("It should police the PU", func() {
+			So(p.ManagementID(), ShouldEqual, "pu")
+			So(p.TriremeAction(), ShouldEqual, Police)
+			So(p.IPAddresses(), ShouldResemble, ExtendedMap{"bridge": "172.17.0.2"})
+			So(p.TriremeNetworks(), ShouldResemble, []string{"0.0.0.0/0"})
+			So(p.Annotations().GetSlice(), ShouldResemble, []string{"@sys:quarantine=true"})
+		})
+
+		Convey("It should reject and log all the flows", func() {
+			for _, acls := range []IPRuleList{p.ApplicationACLs(), p.NetworkACLs()} {
+				So(acls, ShouldHaveLength, 3)
+				for _, acl := range acls {
+					So(acl.Address, ShouldEqual, "0.0.0.0/0")
+					So(acl.Policy.Action, ShouldEqual, Reject|Log)
+					So(acl.Policy.PolicyID, ShouldEqual, "quarantine")
+				}
+				So(acls[2].Protocol, ShouldEqual, "all")
+				So(acls[2].Port, ShouldEqual, "")
+			}
+		})
+
+		Convey("It should not accept any identity", func() {
+			So(p.TransmitterRules(), ShouldBeEmpty)
+			So(p.ReceiverRules(), ShouldBeEmpty)
+		})
+	})
+}
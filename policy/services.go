@@ -0,0 +1,189 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/utils/portspec"
+)
+
+// The protocols of the services
+const (
+	serviceProtocolTCP uint8 = 6
+	serviceProtocolUDP uint8 = 17
+)
+
+// ParseService parses a service given as a port, a port range or the name of
+// a service, like 443, 8000:8080 or https. The service is a udp service if it
+// is prefixed with udp:, like udp:53 or udp:domain, and a tcp service otherwise.
+func ParseService(value string) (Service, error) {
+
+	service := Service{Protocol: serviceProtocolTCP}
+
+	switch {
+	case strings.HasPrefix(value, "udp:"):
+		service.Protocol = serviceProtocolUDP
+		value = strings.TrimPrefix(value, "udp:")
+	case strings.HasPrefix(value, "tcp:"):
+		value = strings.TrimPrefix(value, "tcp:")
+	}
+
+	if ports, err := portspec.NewPortSpecFromString(value, nil); err == nil {
+		service.Ports = ports
+		return service, nil
+	}
+
+	service.Name = value
+	if err := service.Resolve(); err != nil {
+		return Service{}, err
+	}
+
+	return service, nil
+}
+
+// Resolve sets the ports of a service from its deprecated port, or from its
+// name with the services database of the host. The protocol of a named service
+// defaults to tcp.
+func (s *Service) Resolve() error {
+
+	if s.Ports != nil {
+		return nil
+	}
+
+	if s.Port != 0 {
+		ports, err := portspec.NewPortSpec(s.Port, s.Port, nil)
+		if err != nil {
+			return err
+		}
+		s.Ports = ports
+		return nil
+	}
+
+	if s.Name == "" {
+		return errors.New("service without ports or name")
+	}
+
+	network := "tcp"
+	switch s.Protocol {
+	case 0:
+		s.Protocol = serviceProtocolTCP
+	case serviceProtocolTCP:
+	case serviceProtocolUDP:
+		network = "udp"
+	default:
+		return fmt.Errorf("service %s: unsupported protocol %d", s.Name, s.Protocol)
+	}
+
+	port, err := net.LookupPort(network, s.Name)
+	if err != nil {
+		return fmt.Errorf("unknown service %s: %s", s.Name, err)
+	}
+
+	ports, err := portspec.NewPortSpec(uint16(port), uint16(port), nil)
+	if err != nil {
+		return err
+	}
+	s.Ports = ports
+
+	return nil
+}
+
+// ConvertServicesToProtocolPortList converts an array of services to a port
+// list in which the udp ports are given as udp:port, like the ports of the
+// proxied services. The services that cannot be resolved are ignored.
+func ConvertServicesToProtocolPortList(services []Service) string {
+
+	ports := []string{}
+	for _, s := range services {
+		if err := s.Resolve(); err != nil {
+			continue
+		}
+
+		port := s.Ports.String()
+		if s.Protocol == serviceProtocolUDP {
+			port = "udp:" + port
+		}
+		ports = append(ports, port)
+	}
+
+	if len(ports) == 0 {
+		return "0"
+	}
+
+	return strings.Join(ports, ",")
+}
+
+// SplitProtocolPortList splits a port list of ConvertServicesToProtocolPortList
+// in its tcp and udp port lists. A list is empty if there are no ports for its
+// protocol.
+func SplitProtocolPortList(portlist string) (tcp string, udp string) {
+
+	tcpPorts := []string{}
+	udpPorts := []string{}
+
+	for _, port := range strings.Split(portlist, ",") {
+		if port == "" {
+			continue
+		}
+		if strings.HasPrefix(port, "udp:") {
+			udpPorts = append(udpPorts, strings.TrimPrefix(port, "udp:"))
+			continue
+		}
+		tcpPorts = append(tcpPorts, port)
+	}
+
+	return strings.Join(tcpPorts, ","), strings.Join(udpPorts, ",")
+}
+
+// servicePair returns the ip,port pair of a service of the proxied services
+func servicePair(ip string, service Service) (string, error) {
+
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("invalid ip address: %s", ip)
+	}
+
+	if err := service.Resolve(); err != nil {
+		return "", err
+	}
+
+	if service.Ports.IsMultiPort() {
+		return "", fmt.Errorf("port ranges are not supported by the proxied services: %s", service.Ports.String())
+	}
+
+	port := service.Ports.String()
+	if service.Protocol == serviceProtocolUDP {
+		port = "udp:" + port
+	}
+
+	return ip + "," + port, nil
+}
+
+// AddPublicService adds the public ip,port pair of a service, like a named
+// service, to the proxied services
+func (p *ProxiedServicesInfo) AddPublicService(ip string, service Service) error {
+
+	pair, err := servicePair(ip, service)
+	if err != nil {
+		return err
+	}
+
+	p.AddPublicIPPortPair(pair)
+
+	return nil
+}
+
+// AddPrivateService adds the private ip,port pair of a service, like a named
+// service, to the proxied services
+func (p *ProxiedServicesInfo) AddPrivateService(ip string, service Service) error {
+
+	pair, err := servicePair(ip, service)
+	if err != nil {
+		return err
+	}
+
+	p.AddPrivateIPPortPair(pair)
+
+	return nil
+}
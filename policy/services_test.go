@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseService(t *testing.T) {
+	Convey("Given services", t, func() {
+
+		Convey("If the service is a port range, I should get a tcp service", func() {
+			s, err := ParseService("8000:8080")
+			So(err, ShouldBeNil)
+			So(s.Protocol, ShouldEqual, 6)
+			So(s.Ports.String(), ShouldEqual, "8000:8080")
+		})
+
+		Convey("If the service is a name, I should get its port", func() {
+			s, err := ParseService("https")
+			So(err, ShouldBeNil)
+			So(s.Name, ShouldEqual, "https")
+			So(s.Ports.String(), ShouldEqual, "443")
+		})
+
+		Convey("If the service is a udp name, I should get a udp service", func() {
+			s, err := ParseService("udp:domain")
+			So(err, ShouldBeNil)
+			So(s.Protocol, ShouldEqual, 17)
+			So(s.Ports.String(), ShouldEqual, "53")
+		})
+
+		Convey("If the service is unknown, I should get an error", func() {
+			_, err := ParseService("no-such-service")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConvertServicesToProtocolPortList(t *testing.T) {
+	Convey("Given the services of a PU", t, func() {
+		services := []Service{
+			{Name: "https"},
+			{Port: 8080, Protocol: 6},
+			{Name: "domain", Protocol: 17},
+			{Name: "no-such-service"},
+		}
+
+		Convey("The names should be resolved in the port lists", func() {
+			So(ConvertServicesToPortList(services), ShouldEqual, "443,8080,53")
+			So(ConvertServicesToProtocolPortList(services), ShouldEqual, "443,8080,udp:53")
+		})
+
+		Convey("The services should not be changed", func() {
+			ConvertServicesToProtocolPortList(services)
+			So(services[0].Ports, ShouldBeNil)
+		})
+
+		Convey("I should be able to split the port list by protocol", func() {
+			tcp, udp := SplitProtocolPortList(ConvertServicesToProtocolPortList(services))
+			So(tcp, ShouldEqual, "443,8080")
+			So(udp, ShouldEqual, "53")
+		})
+
+		Convey("If there are no services, I should get the default port list", func() {
+			So(ConvertServicesToProtocolPortList(nil), ShouldEqual, "0")
+		})
+	})
+}
+
+func TestAddService(t *testing.T) {
+	Convey("Given proxied services", t, func() {
+		p := &ProxiedServicesInfo{}
+
+		Convey("I should be able to add named services", func() {
+			So(p.AddPublicService("10.0.0.1", Service{Name: "https"}), ShouldBeNil)
+			So(p.AddPrivateService("172.17.0.2", Service{Name: "domain", Protocol: 17}), ShouldBeNil)
+			So(p.PublicIPPortPair, ShouldResemble, []string{"10.0.0.1,443"})
+			So(p.PrivateIPPortPair, ShouldResemble, []string{"172.17.0.2,udp:53"})
+			So(p.HasTCPService("443"), ShouldBeTrue)
+			So(p.HasUDPServices(), ShouldBeTrue)
+		})
+
+		Convey("If the service is a port range, I should get an error", func() {
+			s, err := ParseService("80:90")
+			So(err, ShouldBeNil)
+			So(p.AddPublicService("10.0.0.1", s), ShouldNotBeNil)
+		})
+
+		Convey("If the address is invalid, I should get an error", func() {
+			So(p.AddPrivateService("invalid", Service{Name: "https"}), ShouldNotBeNil)
+			So(p.IsEmpty(), ShouldBeTrue)
+		})
+	})
+}
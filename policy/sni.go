@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aporeto-inc/trireme-lib/utils/portspec"
+)
+
+// SNIRule authorizes the TLS connections of a PU to external services by the
+// server name that the PU sends in the ClientHello, whatever the addresses of
+// the servers are. It applies to the TCP flows that are not accepted by the
+// application ACLs.
+type SNIRule struct {
+	// Port is the port of the servers, or a range of ports like 8000:8100
+	Port string
+	// ServerNames are the names of the servers. A name that starts with *.
+	// matches the subdomains of its domain, like *.amazonaws.com.
+	ServerNames []string
+	// Policy is the policy of the flows that match the rule
+	Policy *FlowPolicy
+}
+
+// SNIRuleList is a list of SNI rules
+type SNIRuleList []SNIRule
+
+// Copy returns a copy of the list
+func (l SNIRuleList) Copy() SNIRuleList {
+
+	if l == nil {
+		return nil
+	}
+
+	list := make(SNIRuleList, len(l))
+	for i, v := range l {
+		list[i] = v
+	}
+
+	return list
+}
+
+// HasPort returns true if a rule of the list applies to the port
+func (l SNIRuleList) HasPort(port uint16) bool {
+
+	for i := range l {
+		if l[i].matchPort(port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Search returns the first rule of the list that matches the port and the
+// server name, or nil if none does
+func (l SNIRuleList) Search(port uint16, serverName string) *SNIRule {
+
+	for i := range l {
+		if l[i].Match(port, serverName) {
+			return &l[i]
+		}
+	}
+
+	return nil
+}
+
+// Match returns true if the rule matches the port and the server name. The
+// names are not case sensitive.
+func (r *SNIRule) Match(port uint16, serverName string) bool {
+
+	if serverName == "" || !r.matchPort(port) {
+		return false
+	}
+
+	serverName = strings.TrimSuffix(serverName, ".")
+
+	for _, name := range r.ServerNames {
+		if strings.HasPrefix(name, "*.") {
+			domain := name[1:]
+			if len(serverName) > len(domain) && strings.EqualFold(serverName[len(serverName)-len(domain):], domain) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(name, serverName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks the port, the server names and the policy of the rule
+func (r *SNIRule) Validate() error {
+
+	if _, err := portspec.NewPortSpecFromString(r.Port, nil); err != nil {
+		return fmt.Errorf("invalid port %s: %s", r.Port, err)
+	}
+
+	if len(r.ServerNames) == 0 {
+		return errors.New("no server names")
+	}
+
+	for _, name := range r.ServerNames {
+		if name == "" || name == "*." || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			return fmt.Errorf("invalid server name %s", name)
+		}
+	}
+
+	if r.Policy == nil {
+		return errors.New("no policy")
+	}
+
+	return nil
+}
+
+// ValidateSNIRules checks the rules of a list
+func ValidateSNIRules(rules SNIRuleList) error {
+
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return fmt.Errorf("invalid sni rule %s: %s", strings.Join(rules[i].ServerNames, ","), err)
+		}
+	}
+
+	return nil
+}
+
+// matchPort returns true if the port is the port of the rule or in its range
+func (r *SNIRule) matchPort(port uint16) bool {
+
+	spec, err := portspec.NewPortSpecFromString(r.Port, nil)
+	if err != nil {
+		return false
+	}
+
+	min, max := spec.Range()
+
+	return port >= min && port <= max
+}
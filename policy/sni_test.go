@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSNIRules(t *testing.T) {
+	Convey("Given SNI rules", t, func() {
+		rules := SNIRuleList{
+			SNIRule{
+				Port:        "443",
+				ServerNames: []string{"*.amazonaws.com", "example.com"},
+				Policy:      &FlowPolicy{Action: Accept, PolicyID: "aws"},
+			},
+			SNIRule{
+				Port:        "8000:8100",
+				ServerNames: []string{"internal.example.com"},
+				Policy:      &FlowPolicy{Action: Reject, PolicyID: "internal"},
+			},
+		}
+
+		Convey("The server names should match the rules by port and name", func() {
+			So(rules.Search(443, "s3.us-east-1.amazonaws.com").Policy.PolicyID, ShouldEqual, "aws")
+			So(rules.Search(443, "EXAMPLE.com.").Policy.PolicyID, ShouldEqual, "aws")
+			So(rules.Search(8080, "internal.example.com").Policy.PolicyID, ShouldEqual, "internal")
+		})
+
+		Convey("The server names that do not match any rule should not be found", func() {
+			So(rules.Search(443, "amazonaws.com"), ShouldBeNil)
+			So(rules.Search(443, "evilamazonaws.com"), ShouldBeNil)
+			So(rules.Search(443, "www.example.com"), ShouldBeNil)
+			So(rules.Search(444, "s3.amazonaws.com"), ShouldBeNil)
+			So(rules.Search(443, ""), ShouldBeNil)
+		})
+
+		Convey("The rules should apply to their ports only", func() {
+			So(rules.HasPort(443), ShouldBeTrue)
+			So(rules.HasPort(8100), ShouldBeTrue)
+			So(rules.HasPort(80), ShouldBeFalse)
+			So(SNIRuleList(nil).HasPort(443), ShouldBeFalse)
+		})
+
+		Convey("A copy of the rules should be independent", func() {
+			c := rules.Copy()
+			c[0].Port = "80"
+			So(rules[0].Port, ShouldEqual, "443")
+			So(SNIRuleList(nil).Copy(), ShouldBeNil)
+		})
+
+		Convey("The rules should be valid", func() {
+			accept := &FlowPolicy{Action: Accept}
+			So(ValidateSNIRules(rules), ShouldBeNil)
+			So(ValidateSNIRules(SNIRuleList{SNIRule{Port: "https", ServerNames: []string{"a.com"}, Policy: accept}}), ShouldNotBeNil)
+			So(ValidateSNIRules(SNIRuleList{SNIRule{Port: "443", Policy: accept}}), ShouldNotBeNil)
+			So(ValidateSNIRules(SNIRuleList{SNIRule{Port: "443", ServerNames: []string{"a.*.com"}, Policy: accept}}), ShouldNotBeNil)
+			So(ValidateSNIRules(SNIRuleList{SNIRule{Port: "443", ServerNames: []string{"a.com"}}}), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a policy", t, func() {
+		p := NewPUPolicyWithDefaults()
+		rules := SNIRuleList{SNIRule{Port: "443", ServerNames: []string{"a.com"}, Policy: &FlowPolicy{Action: Accept}}}
+
+		Convey("By default it should have no SNI rules", func() {
+			So(p.SNIRules(), ShouldBeNil)
+		})
+
+		Convey("When I set SNI rules, a clone of the policy should keep them", func() {
+			So(p.SetSNIRules(rules), ShouldBeNil)
+			So(p.Clone().SNIRules(), ShouldResemble, rules)
+		})
+
+		Convey("When I set invalid SNI rules, I should get an error", func() {
+			So(p.SetSNIRules(SNIRuleList{SNIRule{Port: "443"}}), ShouldNotBeNil)
+			So(p.SNIRules(), ShouldBeNil)
+		})
+	})
+}
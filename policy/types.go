@@ -2,6 +2,9 @@ package policy
 
 import (
 	"errors"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/aporeto-inc/trireme-lib/utils/portspec"
 )
@@ -38,6 +41,15 @@ const (
 	KeyExists = "*"
 	// KeyNotExists means that the key doesnt exist in the incoming tags
 	KeyNotExists = "!*"
+	// Not means that the incoming tags have no value of the key in the set,
+	// including when the key doesnt exist
+	Not = "!"
+	// AnyOf means that the incoming tags have one of the values of the key in
+	// the set, as the equal operator
+	AnyOf = "in"
+	// AllOf means that the incoming tags have all the values of the key in the
+	// set
+	AllOf = "all"
 )
 
 // ActionType   is the action that can be applied to a flow.
@@ -68,6 +80,11 @@ func (f ActionType) Observed() bool {
 	return f&Observe > 0
 }
 
+// Marked returns if the action mask contains the Mark mask.
+func (f ActionType) Marked() bool {
+	return f&Mark > 0
+}
+
 // ActionString returns if the action if accepted of rejected as a long string.
 func (f ActionType) ActionString() string {
 	if f.Accepted() && !f.Rejected() {
@@ -107,6 +124,9 @@ const (
 	Log ActionType = 0x8
 	// Observe instructs the datapath to observe policy results
 	Observe ActionType = 0x10
+	// Mark instructs the datapath to set the DSCP and the fwmark of the policy
+	// on the packets of the flows accepted by an ACL
+	Mark ActionType = 0x20
 )
 
 // ObserveActionType is the action that can be applied to a flow for an observation rule.
@@ -157,6 +177,25 @@ type FlowPolicy struct {
 	Action        ActionType
 	ServiceID     string
 	PolicyID      string
+	// DSCP is the DSCP value or class, like 46 or EF, set on the packets of
+	// the flows with a Mark action. It is not set if empty.
+	DSCP string
+	// FwMark is the fwmark, with an optional mask like 0x100/0xff00, set on
+	// the packets of the flows with a Mark action. The mask leaves the other
+	// bits of the mark, like the ones of the enforcer, unchanged. It is not set
+	// if empty.
+	FwMark string
+	// NFLOGGroup is the NFLOG group of the flows logged by the rule, instead
+	// of the group of the supervisor. It must be a group the enforcer listens
+	// on.
+	NFLOGGroup int
+	// NFLOGRate is the rate, like 10/second, above which the flows of the rule
+	// are not logged, instead of the rate of the supervisor. All the flows are
+	// logged if it is unlimited.
+	NFLOGRate string
+	// NFLOGBurst is the number of flows logged above the NFLOGRate before the
+	// rate applies, instead of the burst of the supervisor
+	NFLOGBurst int
 }
 
 // LogPrefix is the prefix used in nf-log action. It must be less than
@@ -235,12 +274,41 @@ type IPRule struct {
 	Address  string
 	Port     string
 	Protocol string
+	// ICMPType and ICMPCode restrict an icmp rule to a message type and code.
+	// They are ignored for other protocols and match anything when empty.
+	ICMPType string
+	ICMPCode string
 	Policy   *FlowPolicy
+	// Priority orders the evaluation of the rules. The rules with a lower
+	// priority are evaluated first, and the first rule that matches a flow
+	// decides its action. The rules with the same priority are evaluated in
+	// the default order: observed rules first, then rejects, then accepts.
+	Priority int
 }
 
 // IPRuleList is a list of IP rules
 type IPRuleList []IPRule
 
+// Priorities returns the priorities of the rules in increasing order
+func (l IPRuleList) Priorities() []int {
+	priorities := make([]int, len(l))
+	for i, rule := range l {
+		priorities[i] = rule.Priority
+	}
+	return sortedPriorities(priorities)
+}
+
+// WithPriority returns the rules with the given priority in their order
+func (l IPRuleList) WithPriority(priority int) IPRuleList {
+	list := IPRuleList{}
+	for _, rule := range l {
+		if rule.Priority == priority {
+			list = append(list, rule)
+		}
+	}
+	return list
+}
+
 // Copy creates a clone of the IP rule list
 func (l IPRuleList) Copy() IPRuleList {
 	list := make(IPRuleList, len(l))
@@ -261,11 +329,47 @@ type KeyValueOperator struct {
 type TagSelector struct {
 	Clause []KeyValueOperator
 	Policy *FlowPolicy
+	// Priority orders the evaluation of the rules as the priority of the
+	// IPRule
+	Priority int
 }
 
 // TagSelectorList defines a list of TagSelectors
 type TagSelectorList []TagSelector
 
+// Priorities returns the priorities of the rules in increasing order
+func (t TagSelectorList) Priorities() []int {
+	priorities := make([]int, len(t))
+	for i, rule := range t {
+		priorities[i] = rule.Priority
+	}
+	return sortedPriorities(priorities)
+}
+
+// WithPriority returns the rules with the given priority in their order
+func (t TagSelectorList) WithPriority(priority int) TagSelectorList {
+	list := TagSelectorList{}
+	for _, rule := range t {
+		if rule.Priority == priority {
+			list = append(list, rule)
+		}
+	}
+	return list
+}
+
+// sortedPriorities sorts a list of priorities and removes the duplicates
+func sortedPriorities(priorities []int) []int {
+	sort.Ints(priorities)
+
+	unique := []int{}
+	for i, priority := range priorities {
+		if i == 0 || priority != priorities[i-1] {
+			unique = append(unique, priority)
+		}
+	}
+	return unique
+}
+
 // Copy  returns a copy of the TagSelectorList
 func (t TagSelectorList) Copy() TagSelectorList {
 	list := make(TagSelectorList, len(t))
@@ -305,13 +409,22 @@ type Service struct {
 
 	// Protocol is the protocol number
 	Protocol uint8
+
+	// Name is the symbolic name of the service, like https. The port of the
+	// service in the services database of the host is used if the ports are
+	// not set.
+	Name string `json:"Name,omitempty"`
 }
 
-// ConvertServicesToPortList converts an array of services to a port list
+// ConvertServicesToPortList converts an array of services to a port list. The
+// services that cannot be resolved are ignored.
 func ConvertServicesToPortList(services []Service) string {
 
 	portlist := ""
 	for _, s := range services {
+		if err := s.Resolve(); err != nil {
+			continue
+		}
 		portlist = portlist + s.Ports.String() + ","
 	}
 
@@ -335,22 +448,118 @@ type OptionsType struct {
 	// UserID is the user ID if it exists
 	UserID string
 
+	// GroupID is the group of the PU of the members of a POSIX group. It is
+	// ignored if the UserID is set.
+	GroupID string
+
 	// Services is the list of services of interest
 	Services []Service
 
 	// ProxyPort is the port on which the proxy listens
 	ProxyPort string
 
+	// TransparentProxy indicates that the connections of the PU are sent to
+	// the proxy with TPROXY instead of NAT. The proxy sees their original
+	// destinations and listens with IP_TRANSPARENT.
+	TransparentProxy bool
+
+	// AutoPort indicates that the listening ports of the PU are discovered
+	// instead of being declared as services
+	AutoPort bool
+
+	// UserToken is the OIDC token of the user of the PU. It is sent on the
+	// proxied connections of the PU.
+	UserToken string
+
 	// PolicyExtensions is policy resolution extensions
 	PolicyExtensions interface{}
 }
 
-// ProxiedServicesInfo holds the info for a proxied service.
+// UserAuthorization configures the validation of the OIDC tokens of the users
+// connecting to a PU through the proxy. Connections without a valid token are
+// rejected and the claims of valid tokens are added to the tags of the peer.
+type UserAuthorization struct {
+	// Issuer is the expected issuer of the tokens
+	Issuer string
+	// Audience is the expected audience of the tokens. It is not checked if empty.
+	Audience string
+	// PublicKeys are the PEM encoded RSA or ECDSA keys of the issuer
+	PublicKeys []string
+	// Claims are the claims added to the tags of the peer. Only the subject is
+	// added if empty.
+	Claims []string
+}
+
+// ProxiedServicesInfo holds the info for a proxied service. The port of a
+// pair is a tcp port unless it is given as udp:port.
 type ProxiedServicesInfo struct {
 	// PublicIPPortPair  is an array public ip,port  of load balancer or passthrough object per pu
 	PublicIPPortPair []string
 	// PrivateIPPortPair is an array of private ip,port of load balancer or passthrough object per pu
 	PrivateIPPortPair []string
+	// HealthCheck checks the private backends of the tcp services. The
+	// backends are not checked if it is nil.
+	HealthCheck *ServiceHealthCheck
+}
+
+// ServiceHealthCheck is the health check of the private backends of the
+// proxied services. A backend is healthy if it accepts tcp connections. The
+// backends that fail are removed from the proxy sets of the PU, so that the
+// clients are not redirected to them, and are added again when they recover.
+type ServiceHealthCheck struct {
+	// Interval is the time between two checks of a backend
+	Interval time.Duration
+	// Timeout is the timeout of a check
+	Timeout time.Duration
+	// UnhealthyThreshold is the number of consecutive failed checks after
+	// which a backend is removed
+	UnhealthyThreshold int
+	// HealthyThreshold is the number of consecutive successful checks after
+	// which a removed backend is added again
+	HealthyThreshold int
+}
+
+// HasUDPServices returns true if one of the proxied services is a udp service
+func (p *ProxiedServicesInfo) HasUDPServices() bool {
+
+	if p == nil {
+		return false
+	}
+
+	for _, pairs := range [][]string{p.PublicIPPortPair, p.PrivateIPPortPair} {
+		for _, pair := range pairs {
+			if strings.Contains(pair, ",udp:") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// HasTCPService returns true if one of the proxied services is a tcp service
+// on the port
+func (p *ProxiedServicesInfo) HasTCPService(port string) bool {
+
+	if p == nil {
+		return false
+	}
+
+	for _, pairs := range [][]string{p.PublicIPPortPair, p.PrivateIPPortPair} {
+		for _, pair := range pairs {
+			if parts := strings.SplitN(pair, ",", 2); len(parts) == 2 && parts[1] == port {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsEmpty returns true if there are no proxied services
+func (p *ProxiedServicesInfo) IsEmpty() bool {
+
+	return p == nil || len(p.PublicIPPortPair)+len(p.PrivateIPPortPair) == 0
 }
 
 // AddPublicIPPortPair add a ip port pair to proxied services
@@ -364,3 +573,11 @@ func (p *ProxiedServicesInfo) AddPrivateIPPortPair(ipportpair string) {
 	p.PrivateIPPortPair = append(p.PrivateIPPortPair, ipportpair)
 
 }
+
+// PURules is the state programmed in the datapath for a PU
+type PURules struct {
+	// Chains maps the chains of the PU to their rules
+	Chains map[string][]string
+	// IPSets maps the sets of the PU to their members
+	IPSets map[string][]string
+}
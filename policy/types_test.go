@@ -58,3 +58,75 @@ func TestEncodeDecodePrefix(t *testing.T) {
 		}
 	})
 }
+
+func TestMarked(t *testing.T) {
+	Convey("When an action has the mark mask, it should be marked", t, func() {
+		So((Accept | Mark).Marked(), ShouldBeTrue)
+		So((Accept | Mark).Accepted(), ShouldBeTrue)
+		So(Accept.Marked(), ShouldBeFalse)
+	})
+}
+
+func TestHasUDPServices(t *testing.T) {
+	Convey("Given proxied services", t, func() {
+		p := &ProxiedServicesInfo{}
+		p.AddPublicIPPortPair("10.0.0.1,80")
+		p.AddPrivateIPPortPair("172.17.0.2,8080")
+
+		Convey("If they are all tcp services, I should not find udp services", func() {
+			So(p.HasUDPServices(), ShouldBeFalse)
+		})
+
+		Convey("If one is a udp service, I should find udp services", func() {
+			p.AddPrivateIPPortPair("172.17.0.2,udp:53")
+			So(p.HasUDPServices(), ShouldBeTrue)
+		})
+
+		Convey("If there are no services, I should not find udp services", func() {
+			var empty *ProxiedServicesInfo
+			So(empty.HasUDPServices(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestHasTCPService(t *testing.T) {
+	Convey("Given proxied services", t, func() {
+		p := &ProxiedServicesInfo{}
+		p.AddPublicIPPortPair("10.0.0.1,80")
+		p.AddPrivateIPPortPair("172.17.0.2,udp:53")
+
+		Convey("I should find the tcp services by their port", func() {
+			So(p.IsEmpty(), ShouldBeFalse)
+			So(p.HasTCPService("80"), ShouldBeTrue)
+			So(p.HasTCPService("8080"), ShouldBeFalse)
+			So(p.HasTCPService("53"), ShouldBeFalse)
+		})
+
+		Convey("If there are no services, I should not find any service", func() {
+			var empty *ProxiedServicesInfo
+			So(empty.IsEmpty(), ShouldBeTrue)
+			So(empty.HasTCPService("80"), ShouldBeFalse)
+			So((&ProxiedServicesInfo{}).IsEmpty(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestPriorities(t *testing.T) {
+	Convey("Given rules with priorities", t, func() {
+		rules := IPRuleList{
+			IPRule{Address: "10.0.0.1", Priority: 2},
+			IPRule{Address: "10.0.0.2"},
+			IPRule{Address: "10.0.0.3", Priority: -1},
+			IPRule{Address: "10.0.0.4", Priority: 2},
+		}
+
+		Convey("I should get the priorities in increasing order", func() {
+			So(rules.Priorities(), ShouldResemble, []int{-1, 0, 2})
+		})
+
+		Convey("I should get the rules of a priority in their order", func() {
+			So(rules.WithPriority(2), ShouldResemble, IPRuleList{rules[0], rules[3]})
+			So(rules.WithPriority(1), ShouldBeEmpty)
+		})
+	})
+}
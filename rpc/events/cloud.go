@@ -0,0 +1,281 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+// CloudProvider is a cloud whose instance metadata is added to the tags of
+// the PUs
+type CloudProvider string
+
+const (
+	// CloudAuto detects the cloud of the instance
+	CloudAuto CloudProvider = ""
+	// CloudAWS is Amazon Web Services
+	CloudAWS CloudProvider = "aws"
+	// CloudGCP is Google Cloud Platform
+	CloudGCP CloudProvider = "gcp"
+	// CloudAzure is Microsoft Azure
+	CloudAzure CloudProvider = "azure"
+)
+
+// cloudMetadataTimeout is the timeout of the requests to the metadata services.
+// They are link local, and answer quickly when the instance runs in their cloud.
+const cloudMetadataTimeout = 2 * time.Second
+
+// The endpoints of the metadata services
+var (
+	awsMetadataURL   = "http://169.254.169.254/latest"
+	gcpMetadataURL   = "http://metadata.google.internal/computeMetadata/v1"
+	azureMetadataURL = "http://169.254.169.254/metadata"
+)
+
+// CloudMetadata is the metadata of a cloud instance
+type CloudMetadata struct {
+	Provider   CloudProvider
+	Region     string
+	Zone       string
+	InstanceID string
+	Tags       map[string]string
+}
+
+// AddTags adds the provider, region, zone and id of the instance, and the tags
+// of the instance prefixed with cloud-tag:, to tags
+func (m *CloudMetadata) AddTags(tags *policy.TagStore) {
+
+	tags.AppendKeyValue("@sys:cloud-provider", string(m.Provider))
+	tags.AppendKeyValue("@sys:cloud-region", m.Region)
+	tags.AppendKeyValue("@sys:cloud-instance", m.InstanceID)
+
+	if m.Zone != "" {
+		tags.AppendKeyValue("@sys:cloud-zone", m.Zone)
+	}
+
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		tags.AppendKeyValue("@sys:cloud-tag:"+k, m.Tags[k])
+	}
+}
+
+// CloudMetadataFromProvider returns the metadata of the instance from the
+// metadata service of its cloud. The clouds are tried in turn if the provider
+// is CloudAuto.
+func CloudMetadataFromProvider(provider CloudProvider) (*CloudMetadata, error) {
+
+	client := &http.Client{Timeout: cloudMetadataTimeout}
+
+	switch provider {
+	case CloudAWS:
+		return awsMetadata(client)
+	case CloudGCP:
+		return gcpMetadata(client)
+	case CloudAzure:
+		return azureMetadata(client)
+	case CloudAuto:
+		for _, fetch := range []func(*http.Client) (*CloudMetadata, error){awsMetadata, gcpMetadata, azureMetadata} {
+			if metadata, err := fetch(client); err == nil {
+				return metadata, nil
+			}
+		}
+		return nil, errors.New("unable to detect the cloud of the instance")
+	default:
+		return nil, fmt.Errorf("unknown cloud provider: %s", provider)
+	}
+}
+
+// NewCloudMetadataExtractor returns a metadata extractor that adds the metadata
+// of the cloud instance to the tags of the runtimes returned by extractor. The
+// metadata is read once, when the first PU starts. The runtimes are returned
+// without it if the metadata service cannot be reached, so that the PUs still
+// start.
+func NewCloudMetadataExtractor(provider CloudProvider, extractor EventMetadataExtractor) EventMetadataExtractor {
+
+	var metadata *CloudMetadata
+	var once sync.Once
+
+	return func(event *EventInfo) (*policy.PURuntime, error) {
+
+		runtime, err := extractor(event)
+		if err != nil {
+			return nil, err
+		}
+
+		once.Do(func() {
+			var merr error
+			if metadata, merr = CloudMetadataFromProvider(provider); merr != nil {
+				zap.L().Warn("Unable to get cloud instance metadata",
+					zap.String("provider", string(provider)),
+					zap.Error(merr),
+				)
+			}
+		})
+
+		if metadata == nil {
+			return runtime, nil
+		}
+
+		tags := runtime.Tags()
+		metadata.AddTags(tags)
+		runtime.SetTags(tags)
+
+		return runtime, nil
+	}
+}
+
+// cloudGet returns the body of a request to a metadata service
+func cloudGet(client *http.Client, method, url string, headers map[string]string) ([]byte, error) {
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// awsMetadata returns the metadata of an EC2 instance with a session token
+// (IMDSv2). The tags are only available if the instance allows their access
+// from the metadata service.
+func awsMetadata(client *http.Client) (*CloudMetadata, error) {
+
+	token, err := cloudGet(client, http.MethodPut, awsMetadataURL+"/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get aws metadata token: %s", err)
+	}
+
+	headers := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+	get := func(path string) (string, error) {
+		data, err := cloudGet(client, http.MethodGet, awsMetadataURL+"/meta-data/"+path, headers)
+		return strings.TrimSpace(string(data)), err
+	}
+
+	metadata := &CloudMetadata{Provider: CloudAWS, Tags: map[string]string{}}
+
+	if metadata.InstanceID, err = get("instance-id"); err != nil {
+		return nil, fmt.Errorf("unable to get aws instance id: %s", err)
+	}
+	if metadata.Region, err = get("placement/region"); err != nil {
+		return nil, fmt.Errorf("unable to get aws region: %s", err)
+	}
+	metadata.Zone, _ = get("placement/availability-zone") // nolint
+
+	keys, err := get("tags/instance")
+	if err != nil {
+		return metadata, nil
+	}
+
+	for _, key := range strings.Fields(keys) {
+		if value, err := get("tags/instance/" + key); err == nil {
+			metadata.Tags[key] = value
+		}
+	}
+
+	return metadata, nil
+}
+
+// gcpMetadata returns the metadata of a compute engine instance. Its network
+// tags are set to true.
+func gcpMetadata(client *http.Client) (*CloudMetadata, error) {
+
+	data, err := cloudGet(client, http.MethodGet, gcpMetadataURL+"/instance/?recursive=true", map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get gcp metadata: %s", err)
+	}
+
+	instance := struct {
+		ID   json.Number `json:"id"`
+		Zone string      `json:"zone"`
+		Tags []string    `json:"tags"`
+	}{}
+	if err := json.Unmarshal(data, &instance); err != nil {
+		return nil, fmt.Errorf("invalid gcp metadata: %s", err)
+	}
+
+	// The zone is projects/<project>/zones/<region>-<zone>
+	zone := instance.Zone[strings.LastIndex(instance.Zone, "/")+1:]
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+
+	metadata := &CloudMetadata{
+		Provider:   CloudGCP,
+		Region:     region,
+		Zone:       zone,
+		InstanceID: instance.ID.String(),
+		Tags:       map[string]string{},
+	}
+
+	for _, tag := range instance.Tags {
+		metadata.Tags[tag] = "true"
+	}
+
+	return metadata, nil
+}
+
+// azureMetadata returns the metadata of an Azure virtual machine
+func azureMetadata(client *http.Client) (*CloudMetadata, error) {
+
+	data, err := cloudGet(client, http.MethodGet, azureMetadataURL+"/instance/compute?api-version=2021-02-01", map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get azure metadata: %s", err)
+	}
+
+	compute := struct {
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+		VMID     string `json:"vmId"`
+		TagsList []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"tagsList"`
+	}{}
+	if err := json.Unmarshal(data, &compute); err != nil {
+		return nil, fmt.Errorf("invalid azure metadata: %s", err)
+	}
+
+	metadata := &CloudMetadata{
+		Provider:   CloudAzure,
+		Region:     compute.Location,
+		Zone:       compute.Zone,
+		InstanceID: compute.VMID,
+		Tags:       map[string]string{},
+	}
+
+	for _, tag := range compute.TagsList {
+		metadata.Tags[tag.Name] = tag.Value
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,163 @@
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testAWSMetadata serves the metadata of an EC2 instance to the requests with a token
+func testAWSMetadata(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path == "/latest/api/token" && r.Method == http.MethodPut {
+		fmt.Fprint(w, "token") // nolint
+		return
+	}
+
+	if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	values := map[string]string{
+		"/latest/meta-data/instance-id":                 "i-1234",
+		"/latest/meta-data/placement/region":            "us-west-2",
+		"/latest/meta-data/placement/availability-zone": "us-west-2a",
+		"/latest/meta-data/tags/instance":               "team\nenv",
+		"/latest/meta-data/tags/instance/team":          "payments",
+		"/latest/meta-data/tags/instance/env":           "prod",
+	}
+
+	value, ok := values[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, value) // nolint
+}
+
+func TestCloudMetadata(t *testing.T) {
+
+	Convey("Given the metadata services of the clouds", t, func() {
+
+		aws := httptest.NewServer(http.HandlerFunc(testAWSMetadata))
+		defer aws.Close()
+
+		gcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"id": 4520031799277581759, "zone": "projects/123/zones/us-central1-a", "tags": ["web"]}`) // nolint
+		}))
+		defer gcp.Close()
+
+		azure := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Metadata") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"location": "westeurope", "zone": "1", "vmId": "vm-1", "tagsList": [{"name": "env", "value": "dev"}]}`) // nolint
+		}))
+		defer azure.Close()
+
+		oldAWS, oldGCP, oldAzure := awsMetadataURL, gcpMetadataURL, azureMetadataURL
+		awsMetadataURL, gcpMetadataURL, azureMetadataURL = aws.URL+"/latest", gcp.URL+"/computeMetadata/v1", azure.URL+"/metadata"
+		defer func() {
+			awsMetadataURL, gcpMetadataURL, azureMetadataURL = oldAWS, oldGCP, oldAzure
+		}()
+
+		Convey("When I get the metadata of an EC2 instance", func() {
+			m, err := CloudMetadataFromProvider(CloudAWS)
+
+			Convey("Then I should get the instance and its tags", func() {
+				So(err, ShouldBeNil)
+				So(m, ShouldResemble, &CloudMetadata{
+					Provider:   CloudAWS,
+					Region:     "us-west-2",
+					Zone:       "us-west-2a",
+					InstanceID: "i-1234",
+					Tags:       map[string]string{"team": "payments", "env": "prod"},
+				})
+			})
+		})
+
+		Convey("When I get the metadata of a compute engine instance", func() {
+			m, err := CloudMetadataFromProvider(CloudGCP)
+
+			Convey("Then I should get the instance and its network tags", func() {
+				So(err, ShouldBeNil)
+				So(m, ShouldResemble, &CloudMetadata{
+					Provider:   CloudGCP,
+					Region:     "us-central1",
+					Zone:       "us-central1-a",
+					InstanceID: "4520031799277581759",
+					Tags:       map[string]string{"web": "true"},
+				})
+			})
+		})
+
+		Convey("When I detect the cloud of an Azure virtual machine", func() {
+			aws.Close()
+			gcp.Close()
+			m, err := CloudMetadataFromProvider(CloudAuto)
+
+			Convey("Then I should get the virtual machine and its tags", func() {
+				So(err, ShouldBeNil)
+				So(m, ShouldResemble, &CloudMetadata{
+					Provider:   CloudAzure,
+					Region:     "westeurope",
+					Zone:       "1",
+					InstanceID: "vm-1",
+					Tags:       map[string]string{"env": "dev"},
+				})
+			})
+		})
+
+		Convey("When I extract the metadata of host PUs", func() {
+			extractor := NewCloudMetadataExtractor(CloudAWS, func(event *EventInfo) (*policy.PURuntime, error) {
+				return policy.NewPURuntime(event.Name, 1, "", policy.NewTagStore(), nil, constants.LinuxProcessPU, nil), nil
+			})
+
+			runtime, err := extractor(&EventInfo{Name: "host"})
+
+			Convey("Then the tags of the instance should be added", func() {
+				So(err, ShouldBeNil)
+				So(runtime.Tags().GetSlice(), ShouldResemble, []string{
+					"@sys:cloud-provider=aws",
+					"@sys:cloud-region=us-west-2",
+					"@sys:cloud-instance=i-1234",
+					"@sys:cloud-zone=us-west-2a",
+					"@sys:cloud-tag:env=prod",
+					"@sys:cloud-tag:team=payments",
+				})
+			})
+
+			Convey("Then the tags should be added when the service is gone", func() {
+				aws.Close()
+				runtime, err := extractor(&EventInfo{Name: "service"})
+				So(err, ShouldBeNil)
+				So(runtime.Tags().GetSlice(), ShouldHaveLength, 6)
+			})
+		})
+
+		Convey("When the metadata service cannot be reached", func() {
+			aws.Close()
+			extractor := NewCloudMetadataExtractor(CloudAWS, func(event *EventInfo) (*policy.PURuntime, error) {
+				return policy.NewPURuntime(event.Name, 1, "", policy.NewTagStore(), nil, constants.LinuxProcessPU, nil), nil
+			})
+
+			runtime, err := extractor(&EventInfo{Name: "host"})
+
+			Convey("Then the PU should start without the tags", func() {
+				So(err, ShouldBeNil)
+				So(runtime.Tags().GetSlice(), ShouldBeEmpty)
+			})
+		})
+	})
+}
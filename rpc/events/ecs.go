@@ -0,0 +1,123 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const (
+	// ECSMetadataURIEnv is the environment variable with the URI of the task
+	// metadata endpoint (version 4) that the ECS agent sets in the containers
+	// of the tasks, on EC2 instances and on Fargate.
+	ECSMetadataURIEnv = "ECS_CONTAINER_METADATA_URI_V4"
+
+	// ecsMetadataTimeout is the timeout of the requests to the endpoint
+	ecsMetadataTimeout = 5 * time.Second
+)
+
+// ECSTaskMetadata is the metadata of an ECS task returned by the task metadata
+// endpoint
+type ECSTaskMetadata struct {
+	Cluster     string `json:"Cluster"`
+	TaskARN     string `json:"TaskARN"`
+	Family      string `json:"Family"`
+	Revision    string `json:"Revision"`
+	ServiceName string `json:"ServiceName"`
+	LaunchType  string `json:"LaunchType"`
+}
+
+// ECSTaskMetadataFromURI returns the metadata of the task of the container
+// whose metadata endpoint is uri
+func ECSTaskMetadataFromURI(uri string) (*ECSTaskMetadata, error) {
+
+	if uri == "" {
+		return nil, errors.New("empty ecs metadata uri")
+	}
+
+	client := &http.Client{Timeout: ecsMetadataTimeout}
+
+	resp, err := client.Get(strings.TrimSuffix(uri, "/") + "/task")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get ecs task metadata: %s", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to get ecs task metadata: %s", resp.Status)
+	}
+
+	metadata := &ECSTaskMetadata{}
+	if err := json.NewDecoder(resp.Body).Decode(metadata); err != nil {
+		return nil, fmt.Errorf("invalid ecs task metadata: %s", err)
+	}
+
+	return metadata, nil
+}
+
+// AddTags adds the cluster, family, revision, service and ARN of the task to
+// tags. The endpoint returns the ARN of the cluster on Fargate, and its name on
+// EC2 instances: the tag is always the name of the cluster. The service tag is
+// only added for the tasks that are started by a service.
+func (m *ECSTaskMetadata) AddTags(tags *policy.TagStore) {
+
+	cluster := m.Cluster
+	if i := strings.LastIndex(cluster, "/"); strings.HasPrefix(cluster, "arn:") && i >= 0 {
+		cluster = cluster[i+1:]
+	}
+
+	tags.AppendKeyValue("@sys:ecs-cluster", cluster)
+	tags.AppendKeyValue("@sys:ecs-family", m.Family)
+	tags.AppendKeyValue("@sys:ecs-revision", m.Revision)
+	tags.AppendKeyValue("@sys:ecs-task", m.TaskARN)
+
+	if m.ServiceName != "" {
+		tags.AppendKeyValue("@sys:ecs-service", m.ServiceName)
+	}
+}
+
+// NewECSMetadataExtractor returns a metadata extractor that adds the metadata of
+// an ECS task to the tags of the runtimes returned by extractor. It is used by
+// an enforcer running in the task, like on Fargate, whose PUs all belong to the
+// task. The metadata is read from the endpoint of uri, or from the endpoint
+// given by the environment if uri is empty. It is read once, since it does not
+// change during the life of the task.
+func NewECSMetadataExtractor(uri string, extractor EventMetadataExtractor) EventMetadataExtractor {
+
+	if uri == "" {
+		uri = os.Getenv(ECSMetadataURIEnv)
+	}
+
+	var metadata *ECSTaskMetadata
+	var lock sync.Mutex
+
+	return func(event *EventInfo) (*policy.PURuntime, error) {
+
+		runtime, err := extractor(event)
+		if err != nil {
+			return nil, err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if metadata == nil {
+			if metadata, err = ECSTaskMetadataFromURI(uri); err != nil {
+				return nil, err
+			}
+		}
+
+		tags := runtime.Tags()
+		metadata.AddTags(tags)
+		runtime.SetTags(tags)
+
+		return runtime, nil
+	}
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testTaskMetadata is the metadata of a task of a service on Fargate
+const testTaskMetadata = `{
+  "Cluster": "arn:aws:ecs:us-west-2:111122223333:cluster/shop",
+  "TaskARN": "arn:aws:ecs:us-west-2:111122223333:task/shop/1234",
+  "Family": "web",
+  "Revision": "3",
+  "ServiceName": "frontend",
+  "LaunchType": "FARGATE",
+  "DesiredStatus": "RUNNING"
+}`
+
+func TestECSMetadataExtractor(t *testing.T) {
+
+	Convey("Given a task metadata endpoint", t, func() {
+
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if r.URL.Path != "/v4/abc/task" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(testTaskMetadata)) // nolint
+		}))
+		defer server.Close()
+
+		base := func(event *EventInfo) (*policy.PURuntime, error) {
+			tags := policy.NewTagStore()
+			tags.AppendKeyValue("@usr:app", "web")
+			return policy.NewPURuntime(event.Name, 1, "", tags, nil, constants.LinuxProcessPU, nil), nil
+		}
+
+		Convey("When I extract the metadata of PUs", func() {
+			extractor := NewECSMetadataExtractor(server.URL+"/v4/abc/", base)
+
+			runtime, err := extractor(&EventInfo{Name: "web"})
+			So(err, ShouldBeNil)
+			_, err = extractor(&EventInfo{Name: "worker"})
+			So(err, ShouldBeNil)
+
+			Convey("Then the tags of the task should be added once read", func() {
+				So(runtime.Tags().GetSlice(), ShouldResemble, []string{
+					"@usr:app=web",
+					"@sys:ecs-cluster=shop",
+					"@sys:ecs-family=web",
+					"@sys:ecs-revision=3",
+					"@sys:ecs-task=arn:aws:ecs:us-west-2:111122223333:task/shop/1234",
+					"@sys:ecs-service=frontend",
+				})
+				So(requests, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the endpoint does not know the task", func() {
+			extractor := NewECSMetadataExtractor(server.URL+"/v4/def", base)
+
+			_, err := extractor(&EventInfo{Name: "web"})
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given the metadata of a task on an EC2 instance", t, func() {
+		metadata := &ECSTaskMetadata{Cluster: "default", TaskARN: "task", Family: "batch", Revision: "1"}
+
+		Convey("Then the cluster should be kept and there should be no service", func() {
+			tags := policy.NewTagStore()
+			metadata.AddTags(tags)
+			So(tags.GetSlice(), ShouldResemble, []string{"@sys:ecs-cluster=default", "@sys:ecs-family=batch", "@sys:ecs-revision=1", "@sys:ecs-task=task"})
+		})
+	})
+}
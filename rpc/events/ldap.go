@@ -0,0 +1,269 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme-lib/policy"
+)
+
+const (
+	// defaultLDAPTimeout is the timeout of a search in the directory
+	defaultLDAPTimeout = 5 * time.Second
+
+	// defaultLDAPCacheTTL is the time the groups of a user are cached
+	defaultLDAPCacheTTL = 5 * time.Minute
+)
+
+// GroupResolver returns the groups of a user in a directory
+type GroupResolver interface {
+	Groups(user string) ([]string, error)
+}
+
+// NewGroupMetadataExtractor returns a metadata extractor that adds the groups of
+// the user of the runtimes returned by extractor to their tags, as
+// @sys:group:<name>=true. The runtimes are returned without the groups if they
+// cannot be resolved, so that the PUs only get the access of their user.
+func NewGroupMetadataExtractor(resolver GroupResolver, extractor EventMetadataExtractor) EventMetadataExtractor {
+
+	return func(event *EventInfo) (*policy.PURuntime, error) {
+
+		runtime, err := extractor(event)
+		if err != nil {
+			return nil, err
+		}
+
+		user := runtime.Options().UserID
+		if user == "" {
+			return runtime, nil
+		}
+
+		groups, err := resolver.Groups(user)
+		if err != nil {
+			zap.L().Warn("Unable to resolve the groups of the user", zap.String("user", user), zap.Error(err))
+			return runtime, nil
+		}
+
+		tags := runtime.Tags()
+		for _, group := range groups {
+			tags.AppendKeyValue("@sys:group:"+group, "true")
+		}
+		runtime.SetTags(tags)
+
+		return runtime, nil
+	}
+}
+
+// LDAPConfig is the configuration of the resolution of the groups of the users
+// in an LDAP directory, like Active Directory
+type LDAPConfig struct {
+	// URI is the URI of the server, like ldaps://dc.example.com
+	URI string
+	// BindDN is the DN of the account of the searches. The searches are
+	// anonymous if it is empty.
+	BindDN string
+	// PasswordFile is the file with the password of the account
+	PasswordFile string
+	// BaseDN is the base of the searches
+	BaseDN string
+	// UserFilter is the filter of the entry of a user, with %s for the name of
+	// the user. It defaults to (sAMAccountName=%s) for Active Directory.
+	UserFilter string
+	// GroupAttribute is the attribute of the entries with the groups. It
+	// defaults to memberOf. The group is the value of the first RDN of the
+	// values that are DNs.
+	GroupAttribute string
+	// Command is the ldapsearch command of OpenLDAP, which must be installed
+	Command string
+	// Timeout is the timeout of a search
+	Timeout time.Duration
+	// CacheTTL is the time the groups of a user are cached
+	CacheTTL time.Duration
+}
+
+// ldapGroups are the cached groups of a user
+type ldapGroups struct {
+	groups  []string
+	expires time.Time
+}
+
+// ldapResolver resolves the groups of the users with ldapsearch
+type ldapResolver struct {
+	config *LDAPConfig
+	cache  map[string]*ldapGroups
+	sync.Mutex
+}
+
+// NewLDAPGroupResolver returns a resolver of the groups of the users in an LDAP
+// directory
+func NewLDAPGroupResolver(config *LDAPConfig) (GroupResolver, error) {
+
+	if config == nil || config.URI == "" || config.BaseDN == "" {
+		return nil, errors.New("ldap uri and base dn are required")
+	}
+
+	c := *config
+	if c.UserFilter == "" {
+		c.UserFilter = "(sAMAccountName=%s)"
+	}
+	if c.GroupAttribute == "" {
+		c.GroupAttribute = "memberOf"
+	}
+	if c.Command == "" {
+		c.Command = "ldapsearch"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultLDAPTimeout
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = defaultLDAPCacheTTL
+	}
+
+	path, err := exec.LookPath(c.Command)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find ldapsearch: %s", err)
+	}
+	c.Command = path
+
+	return &ldapResolver{
+		config: &c,
+		cache:  map[string]*ldapGroups{},
+	}, nil
+}
+
+// Groups implements the GroupResolver interface
+func (r *ldapResolver) Groups(user string) ([]string, error) {
+
+	r.Lock()
+	cached, ok := r.cache[user]
+	r.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.groups, nil
+	}
+
+	groups, err := r.search(user)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Lock()
+	r.cache[user] = &ldapGroups{groups: groups, expires: time.Now().Add(r.config.CacheTTL)}
+	r.Unlock()
+
+	return groups, nil
+}
+
+// search returns the groups of a user from the directory
+func (r *ldapResolver) search(user string) ([]string, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+	defer cancel()
+
+	args := []string{"-LLL", "-x", "-H", r.config.URI, "-b", r.config.BaseDN}
+	if r.config.BindDN != "" {
+		args = append(args, "-D", r.config.BindDN)
+	}
+	if r.config.PasswordFile != "" {
+		args = append(args, "-y", r.config.PasswordFile)
+	}
+	args = append(args, fmt.Sprintf(r.config.UserFilter, ldapEscape(user)), r.config.GroupAttribute)
+
+	cmd := exec.CommandContext(ctx, r.config.Command, args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to search ldap groups of %s: %s: %s", user, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return ldapAttributeValues(out, r.config.GroupAttribute), nil
+}
+
+// ldapEscape escapes the special characters of a value of a search filter
+func ldapEscape(value string) string {
+
+	replacer := strings.NewReplacer(
+		`\`, `\5c`,
+		`*`, `\2a`,
+		`(`, `\28`,
+		`)`, `\29`,
+		"\x00", `\00`,
+	)
+
+	return replacer.Replace(value)
+}
+
+// ldapAttributeValues returns the groups in the values of an attribute of the
+// LDIF output of ldapsearch
+func ldapAttributeValues(ldif []byte, attribute string) []string {
+
+	// The long lines are folded on lines starting with a space
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(ldif))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	groups := []string{}
+	seen := map[string]bool{}
+
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], attribute) {
+			continue
+		}
+
+		value := parts[1]
+		if strings.HasPrefix(value, ":") {
+			// The value is base64 encoded
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+
+		group := ldapGroupName(strings.TrimSpace(value))
+		if group != "" && !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// ldapGroupName returns the value of the first RDN of a DN, like db-admins for
+// CN=db-admins,OU=Groups,DC=example,DC=com, or the value if it is not a DN
+func ldapGroupName(value string) string {
+
+	rdn := value
+	if i := strings.Index(value, ","); i >= 0 {
+		rdn = value[:i]
+	}
+
+	parts := strings.SplitN(rdn, "=", 2)
+	if len(parts) != 2 {
+		return value
+	}
+
+	return strings.TrimSpace(parts[1])
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testLDIF is the output of a search of a user in Active Directory
+const testLDIF = `dn: CN=Alice,OU=Users,DC=example,DC=com
+memberOf: CN=db-admins,OU=Groups,DC=example,DC=com
+memberOf: CN=developers-with-a-long-name,OU=Groups,DC=exampl
+ e,DC=com
+memberOf:: Q049b3BzLE9VPUdyb3VwcyxEQz1leGFtcGxlLERDPWNvbQ==
+memberOf: CN=db-admins,OU=Groups,DC=example,DC=com
+
+`
+
+// testLDAPSearch logs the search filter, which is its next to last argument,
+// and prints the LDIF of the user
+const testLDAPSearch = `#!/bin/sh
+eval "filter=\${$(($#-1))}"
+echo "$filter" >> "$(dirname "$0")/log"
+cat <<'LDIF'
+` + testLDIF + `LDIF
+`
+
+func TestLDAPAttributeValues(t *testing.T) {
+
+	Convey("Given the LDIF of a user", t, func() {
+
+		Convey("Then I should get its groups once", func() {
+			So(ldapAttributeValues([]byte(testLDIF), "memberof"), ShouldResemble, []string{"db-admins", "developers-with-a-long-name", "ops"})
+		})
+
+		Convey("Then the values of posix groups should be kept", func() {
+			So(ldapAttributeValues([]byte("dn: cn=web,ou=groups\ncn: web\n"), "cn"), ShouldResemble, []string{"web"})
+		})
+	})
+
+	Convey("Given a user name with special characters", t, func() {
+
+		Convey("Then they should be escaped in the filter", func() {
+			So(ldapEscape("a*)(uid=*"), ShouldEqual, `a\2a\29\28uid=\2a`)
+		})
+	})
+}
+
+func TestLDAPGroupResolver(t *testing.T) {
+
+	Convey("Given an ldap directory", t, func() {
+
+		dir, err := ioutil.TempDir("", "ldap")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		command := filepath.Join(dir, "ldapsearch")
+		So(ioutil.WriteFile(command, []byte(testLDAPSearch), 0755), ShouldBeNil)
+
+		resolver, err := NewLDAPGroupResolver(&LDAPConfig{
+			URI:     "ldaps://dc.example.com",
+			BaseDN:  "DC=example,DC=com",
+			Command: command,
+		})
+		So(err, ShouldBeNil)
+
+		Convey("When I resolve the groups of a user twice", func() {
+			groups, err := resolver.Groups("alice")
+			So(err, ShouldBeNil)
+			_, err = resolver.Groups("alice")
+			So(err, ShouldBeNil)
+
+			Convey("Then I should get its groups from a single search", func() {
+				So(groups, ShouldResemble, []string{"db-admins", "developers-with-a-long-name", "ops"})
+
+				log, err := ioutil.ReadFile(filepath.Join(dir, "log"))
+				So(err, ShouldBeNil)
+				So(string(log), ShouldEqual, "(sAMAccountName=alice)\n")
+			})
+		})
+
+		Convey("When I extract the metadata of the PU of a user", func() {
+			extractor := NewGroupMetadataExtractor(resolver, func(event *EventInfo) (*policy.PURuntime, error) {
+				return policy.NewPURuntime(event.Name, 1, "", policy.NewTagStore(), nil, constants.UIDLoginPU, &policy.OptionsType{UserID: "alice"}), nil
+			})
+
+			runtime, err := extractor(&EventInfo{Name: "alice"})
+
+			Convey("Then its groups should be added to its tags", func() {
+				So(err, ShouldBeNil)
+				So(runtime.Tags().GetSlice(), ShouldResemble, []string{
+					"@sys:group:db-admins=true",
+					"@sys:group:developers-with-a-long-name=true",
+					"@sys:group:ops=true",
+				})
+			})
+		})
+	})
+
+	Convey("Given an incomplete configuration", t, func() {
+		_, err := NewLDAPGroupResolver(&LDAPConfig{URI: "ldap://localhost"})
+
+		Convey("Then I should get an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
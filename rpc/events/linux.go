@@ -33,8 +33,8 @@ func DefaultHostMetadataExtractor(event *EventInfo) (*policy.PURuntime, error) {
 
 	options := &policy.OptionsType{
 		CgroupName: event.PUID,
-		CgroupMark: strconv.FormatUint(cgnetcls.MarkVal(), 10),
 		Services:   event.Services,
+		AutoPort:   event.AutoPort,
 	}
 
 	runtimeIps := policy.ExtendedMap{"bridge": "0.0.0.0/0"}
@@ -44,7 +44,13 @@ func DefaultHostMetadataExtractor(event *EventInfo) (*policy.PURuntime, error) {
 		return nil, fmt.Errorf("invalid pid: %s %s", event.PID, err)
 	}
 
-	return policy.NewPURuntime(event.Name, runtimePID, "", runtimeTags, runtimeIps, constants.LinuxProcessPU, options), nil
+	mark, err := cgnetcls.AllocateMark()
+	if err != nil {
+		return nil, fmt.Errorf("unable to allocate mark: %s", err)
+	}
+	options.CgroupMark = strconv.FormatUint(mark, 10)
+
+	return policy.NewPURuntime(event.Name, runtimePID, event.NS, runtimeTags, runtimeIps, constants.LinuxProcessPU, options), nil
 }
 
 // SystemdEventMetadataExtractor is a systemd based metadata extractor
@@ -79,8 +85,7 @@ func SystemdEventMetadataExtractor(event *EventInfo) (*policy.PURuntime, error)
 
 	options := policy.OptionsType{}
 	options.Services = event.Services
-	options.UserID, _ = runtimeTags.Get("@usr:originaluser")
-	options.CgroupMark = strconv.FormatUint(cgnetcls.MarkVal(), 10)
+	options.UserID, _ = runtimeTags.Get("@usr:" + OriginalUserTag)
 
 	runtimeIps := policy.ExtendedMap{"bridge": "0.0.0.0/0"}
 
@@ -89,7 +94,13 @@ func SystemdEventMetadataExtractor(event *EventInfo) (*policy.PURuntime, error)
 		return nil, fmt.Errorf("invalid pid: %s %s", event.PID, err)
 	}
 
-	return policy.NewPURuntime(event.Name, runtimePID, "", runtimeTags, runtimeIps, constants.LinuxProcessPU, &options), nil
+	mark, err := cgnetcls.AllocateMark()
+	if err != nil {
+		return nil, fmt.Errorf("unable to allocate mark: %s", err)
+	}
+	options.CgroupMark = strconv.FormatUint(mark, 10)
+
+	return policy.NewPURuntime(event.Name, runtimePID, event.NS, runtimeTags, runtimeIps, constants.LinuxProcessPU, &options), nil
 }
 
 // ProcessInfo returns all metadata captured by a process
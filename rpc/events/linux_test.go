@@ -115,6 +115,27 @@ func TestDefaultHostMetadataExtractor(t *testing.T) {
 				So(pu, ShouldNotBeNil)
 				So(pu.Options().CgroupName, ShouldResemble, "Web")
 				So(pu.Options().Services, ShouldResemble, services)
+				So(pu.Options().UserID, ShouldEqual, "")
+			})
+		})
+
+		Convey("If port discovery and a network namespace are requested", func() {
+
+			event := &EventInfo{
+				Name:     "Web",
+				PID:      "1234",
+				PUID:     "Web",
+				NS:       "/var/run/netns/web",
+				Tags:     []string{"app=web", "originaluser=web"},
+				AutoPort: true,
+			}
+
+			pu, err := DefaultHostMetadataExtractor(event)
+			Convey("I should get the port discovery and the namespace path", func() {
+				So(err, ShouldBeNil)
+				So(pu.Options().AutoPort, ShouldBeTrue)
+				So(pu.Options().UserID, ShouldEqual, "")
+				So(pu.NSPath(), ShouldEqual, "/var/run/netns/web")
 			})
 		})
 
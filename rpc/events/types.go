@@ -27,7 +27,7 @@ type EventInfo struct {
 	// The PID is the PID on the system where this Processing Unit is running.
 	PID string
 
-	// The path for the Network Namespace.
+	// The path for the Network Namespace. It must be an absolute path when set.
 	NS string
 
 	// Cgroup is the path to the cgroup - used for deletes
@@ -39,6 +39,16 @@ type EventInfo struct {
 	// Services is a list of services of interest - for host control
 	Services []policy.Service
 
+	// AutoPort requests the discovery of the ports the PU listens on in addition
+	// to the declared services. Ports are discovered for the processes of the
+	// cgroup of the PU, so host services can not discover their ports. UID PUs
+	// always discover their ports.
+	AutoPort bool
+
+	// UserToken is the OIDC token of the user of a UID PU. It is sent on the
+	// connections of the PU that go through the proxy.
+	UserToken string
+
 	// HostService indicates that the request is for the root namespace
 	HostService bool
 
@@ -49,6 +59,9 @@ type EventInfo struct {
 	Root bool
 }
 
+// OriginalUserTag is the tag that carries the user of a PU
+const OriginalUserTag = "originaluser"
+
 // EventMetadataExtractor is a function used to extract a *policy.PURuntime from a given
 // EventInfo.
 type EventMetadataExtractor func(*EventInfo) (*policy.PURuntime, error)
@@ -77,6 +90,14 @@ const (
 
 	// EventResync instructs the processors to resync
 	EventResync Event = "resync"
+
+	// EventUpdate is the event generated when the runtime metadata of a PU
+	// changed and its policy must be resolved again.
+	EventUpdate Event = "update"
+
+	// EventQuarantine is the event generated when the policy of a PU could not
+	// be set and all its traffic must be dropped.
+	EventQuarantine Event = "quarantine"
 )
 
 // EventResponse encapsulate the error response if any.
@@ -2,6 +2,7 @@ package events
 
 import (
 	"fmt"
+	osuser "os/user"
 	"strconv"
 	"strings"
 
@@ -28,15 +29,34 @@ func UIDMetadataExtractor(event *EventInfo) (*policy.PURuntime, error) {
 		user = ""
 	}
 
+	// The PU of the members of a group is created when there is no user
+	group := ""
+	if user == "" {
+		group, _ = runtimeTags.Get("@usr:group")
+	}
+	if group != "" {
+		runtimeTags.AppendKeyValue("@sys:group", group)
+		if g, err := osuser.LookupGroup(group); err == nil {
+			runtimeTags.AppendKeyValue("@sys:gid:"+g.Gid, "true")
+		}
+	}
+
+	mark, err := cgnetcls.AllocateMark()
+	if err != nil {
+		return nil, fmt.Errorf("unable to allocate mark: %s", err)
+	}
+
 	// TODO: improve with additional information here.
 	options := &policy.OptionsType{
 		CgroupName: event.PUID,
-		CgroupMark: strconv.FormatUint(cgnetcls.MarkVal(), 10),
+		CgroupMark: strconv.FormatUint(mark, 10),
 		UserID:     user,
+		GroupID:    group,
 		Services:   event.Services,
+		UserToken:  event.UserToken,
 	}
 
 	runtimeIps := policy.ExtendedMap{"bridge": "0.0.0.0/0"}
 	runtimePID, _ := strconv.Atoi(event.PID)
-	return policy.NewPURuntime(event.Name, runtimePID, "", runtimeTags, runtimeIps, constants.UIDLoginPU, options), nil
+	return policy.NewPURuntime(event.Name, runtimePID, event.NS, runtimeTags, runtimeIps, constants.UIDLoginPU, options), nil
 }
@@ -0,0 +1,91 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// dialTimeout is the timeout of the connection to the external monitor
+const dialTimeout = 5 * time.Second
+
+// Client sends the lifecycle events of the PUs to the external monitor over
+// gRPC. The requests are sent with the current version of the API.
+type Client struct {
+	conn   *grpc.ClientConn
+	client ExternalMonitorClient
+}
+
+// NewClient connects to the external monitor at the Unix socket address
+func NewClient(address string) (*Client, error) {
+
+	conn, err := grpc.Dial(
+		address,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithTimeout(dialTimeout),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to external monitor: %s", err)
+	}
+
+	return &Client{
+		conn:   conn,
+		client: NewExternalMonitorClient(conn),
+	}, nil
+}
+
+// Register creates a PU with its metadata, or updates the metadata of an
+// existing PU. The policy of a started PU is resolved again.
+func (c *Client) Register(ctx context.Context, request *PURequest) error {
+
+	r := *request
+	r.Version = APIVersion
+
+	return result(c.client.Register(ctx, &r))
+}
+
+// Start activates the policy of a registered PU
+func (c *Client) Start(ctx context.Context, contextID string) error {
+
+	return result(c.client.Start(ctx, &PURequest{Version: APIVersion, ContextId: contextID}))
+}
+
+// Stop removes the policy of a PU. The PU can be started again.
+func (c *Client) Stop(ctx context.Context, contextID string) error {
+
+	return result(c.client.Stop(ctx, &PURequest{Version: APIVersion, ContextId: contextID}))
+}
+
+// Destroy removes a PU, and stops it if it is started
+func (c *Client) Destroy(ctx context.Context, contextID string) error {
+
+	return result(c.client.Destroy(ctx, &PURequest{Version: APIVersion, ContextId: contextID}))
+}
+
+// Close closes the connection to the external monitor
+func (c *Client) Close() error {
+
+	return c.conn.Close()
+}
+
+// result returns the error of a call or of its response
+func result(response *PUResponse, err error) error {
+
+	if err != nil {
+		return err
+	}
+
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	return nil
+}
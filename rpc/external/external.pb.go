@@ -0,0 +1,404 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: external.proto
+
+package external
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// PURequest is a lifecycle event of a PU. The metadata is only used by
+// Register.
+type PURequest struct {
+	// version is the version of the API of the client
+	Version int32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// context_id is the unique identifier of the PU, like the ID of the task in
+	// the scheduler
+	ContextId string `protobuf:"bytes,2,opt,name=context_id,json=contextId,proto3" json:"context_id,omitempty"`
+	// name is a user-friendly name for the PU. The context ID is used if it is
+	// empty.
+	Name string `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	// pid is the PID of a process in the network namespace of the PU
+	Pid int32 `protobuf:"varint,4,opt,name=pid,proto3" json:"pid,omitempty"`
+	// ns is the path of the network namespace of the PU. It must be an absolute
+	// path when set.
+	Ns string `protobuf:"bytes,5,opt,name=ns,proto3" json:"ns,omitempty"`
+	// tags are the metadata of the PU, like key=value
+	Tags []string `protobuf:"bytes,6,rep,name=tags,proto3" json:"tags,omitempty"`
+	// ips are the IP addresses of the PU by network
+	Ips                  map[string]string `protobuf:"bytes,7,rep,name=ips,proto3" json:"ips,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *PURequest) Reset()         { *m = PURequest{} }
+func (m *PURequest) String() string { return proto.CompactTextString(m) }
+func (*PURequest) ProtoMessage()    {}
+func (*PURequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2b7268f56e161ef5, []int{0}
+}
+
+func (m *PURequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PURequest.Unmarshal(m, b)
+}
+func (m *PURequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PURequest.Marshal(b, m, deterministic)
+}
+func (m *PURequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PURequest.Merge(m, src)
+}
+func (m *PURequest) XXX_Size() int {
+	return xxx_messageInfo_PURequest.Size(m)
+}
+func (m *PURequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PURequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PURequest proto.InternalMessageInfo
+
+func (m *PURequest) GetVersion() int32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *PURequest) GetContextId() string {
+	if m != nil {
+		return m.ContextId
+	}
+	return ""
+}
+
+func (m *PURequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PURequest) GetPid() int32 {
+	if m != nil {
+		return m.Pid
+	}
+	return 0
+}
+
+func (m *PURequest) GetNs() string {
+	if m != nil {
+		return m.Ns
+	}
+	return ""
+}
+
+func (m *PURequest) GetTags() []string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func (m *PURequest) GetIps() map[string]string {
+	if m != nil {
+		return m.Ips
+	}
+	return nil
+}
+
+// PUResponse is the response to a PURequest
+type PUResponse struct {
+	// version is the version of the API of the monitor
+	Version int32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// error is the reason of the failure of the request, if any
+	Error                string   `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PUResponse) Reset()         { *m = PUResponse{} }
+func (m *PUResponse) String() string { return proto.CompactTextString(m) }
+func (*PUResponse) ProtoMessage()    {}
+func (*PUResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_2b7268f56e161ef5, []int{1}
+}
+
+func (m *PUResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PUResponse.Unmarshal(m, b)
+}
+func (m *PUResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PUResponse.Marshal(b, m, deterministic)
+}
+func (m *PUResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PUResponse.Merge(m, src)
+}
+func (m *PUResponse) XXX_Size() int {
+	return xxx_messageInfo_PUResponse.Size(m)
+}
+func (m *PUResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PUResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PUResponse proto.InternalMessageInfo
+
+func (m *PUResponse) GetVersion() int32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *PUResponse) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*PURequest)(nil), "trireme.external.PURequest")
+	proto.RegisterMapType((map[string]string)(nil), "trireme.external.PURequest.IpsEntry")
+	proto.RegisterType((*PUResponse)(nil), "trireme.external.PUResponse")
+}
+
+func init() { proto.RegisterFile("external.proto", fileDescriptor_2b7268f56e161ef5) }
+
+var fileDescriptor_2b7268f56e161ef5 = []byte{
+	// 319 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x92, 0x31, 0x6b, 0x3a, 0x41,
+	0x10, 0xc5, 0xb9, 0x3b, 0x4f, 0xbd, 0xf9, 0x83, 0x7f, 0x59, 0x2c, 0x16, 0x93, 0xc0, 0x21, 0x29,
+	0xac, 0xae, 0x30, 0x20, 0x21, 0xa4, 0x89, 0x68, 0x61, 0x11, 0x08, 0x27, 0x69, 0xd2, 0x84, 0x4b,
+	0x1c, 0x64, 0x89, 0xee, 0x5e, 0x66, 0x47, 0xd1, 0x0f, 0x94, 0xaf, 0x19, 0xc2, 0x6e, 0xee, 0x52,
+	0x84, 0x60, 0x63, 0xf7, 0xe6, 0xcd, 0xce, 0x6f, 0xdf, 0x2c, 0x0b, 0x1d, 0xdc, 0x33, 0x92, 0x2e,
+	0xd6, 0x59, 0x49, 0x86, 0x8d, 0xe8, 0x32, 0x29, 0xc2, 0x0d, 0x66, 0xb5, 0x3f, 0xf8, 0x0c, 0x20,
+	0x79, 0x78, 0xcc, 0xf1, 0x7d, 0x8b, 0x96, 0x85, 0x84, 0xd6, 0x0e, 0xc9, 0x2a, 0xa3, 0x65, 0x90,
+	0x06, 0xc3, 0x38, 0xaf, 0x4b, 0x71, 0x01, 0xf0, 0x6a, 0x34, 0xe3, 0x9e, 0x9f, 0xd5, 0x52, 0x86,
+	0x69, 0x30, 0x4c, 0xf2, 0xa4, 0x72, 0xe6, 0x4b, 0x21, 0xa0, 0xa1, 0x8b, 0x0d, 0xca, 0xc8, 0x37,
+	0xbc, 0x16, 0x5d, 0x88, 0x4a, 0xb5, 0x94, 0x0d, 0x0f, 0x72, 0x52, 0x74, 0x20, 0xd4, 0x56, 0xc6,
+	0xfe, 0x4c, 0xa8, 0xad, 0x9b, 0xe2, 0x62, 0x65, 0x65, 0x33, 0x8d, 0xdc, 0x94, 0xd3, 0x62, 0x0c,
+	0x91, 0x2a, 0xad, 0x6c, 0xa5, 0xd1, 0xf0, 0xdf, 0xe8, 0x32, 0xfb, 0x1d, 0x38, 0xfb, 0x09, 0x9b,
+	0xcd, 0x4b, 0x3b, 0xd3, 0x4c, 0x87, 0xdc, 0x0d, 0xf4, 0xc7, 0xd0, 0xae, 0x0d, 0x77, 0xf3, 0x1b,
+	0x1e, 0xfc, 0x0a, 0x49, 0xee, 0xa4, 0xe8, 0x41, 0xbc, 0x2b, 0xd6, 0x5b, 0xac, 0x92, 0x7f, 0x17,
+	0x37, 0xe1, 0x75, 0x30, 0xb8, 0x05, 0x70, 0x48, 0x5b, 0x1a, 0x6d, 0xf1, 0xc8, 0x03, 0xf4, 0x20,
+	0x46, 0x22, 0x43, 0x35, 0xc1, 0x17, 0xa3, 0x8f, 0x10, 0xfe, 0xcf, 0xaa, 0x68, 0xf7, 0x46, 0x2b,
+	0x36, 0x24, 0x66, 0xd0, 0xce, 0x71, 0xa5, 0x2c, 0x23, 0x89, 0xb3, 0x23, 0x0b, 0xf4, 0xcf, 0xff,
+	0x6e, 0x56, 0x51, 0x26, 0x10, 0x2f, 0xb8, 0x20, 0x3e, 0x85, 0x71, 0x07, 0x8d, 0x05, 0x9b, 0xf2,
+	0x14, 0xc4, 0x14, 0x5a, 0x53, 0xb4, 0x4c, 0xe6, 0x70, 0x02, 0x65, 0x02, 0x4f, 0xed, 0xda, 0x7e,
+	0x69, 0xfa, 0xbf, 0x78, 0xf5, 0x35, 0x00, 0x34, 0xb6, 0xf4, 0x14, 0x9d, 0x02, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ExternalMonitorClient is the client API for ExternalMonitor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ExternalMonitorClient interface {
+	// Register creates a PU with its metadata, or updates the metadata of an
+	// existing PU. The policy of a started PU is resolved again.
+	Register(ctx context.Context, in *PURequest, opts ...grpc.CallOption) (*PUResponse, error)
+	// Start activates the policy of a registered PU
+	Start(ctx context.Context, in *PURequest, opts ...grpc.CallOption) (*PUResponse, error)
+	// Stop removes the policy of a PU. The PU can be started again.
+	Stop(ctx context.Context, in *PURequest, opts ...grpc.CallOption) (*PUResponse, error)
+	// Destroy removes a PU, and stops it if it is started
+	Destroy(ctx context.Context, in *PURequest, opts ...grpc.CallOption) (*PUResponse, error)
+}
+
+type externalMonitorClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewExternalMonitorClient(cc *grpc.ClientConn) ExternalMonitorClient {
+	return &externalMonitorClient{cc}
+}
+
+func (c *externalMonitorClient) Register(ctx context.Context, in *PURequest, opts ...grpc.CallOption) (*PUResponse, error) {
+	out := new(PUResponse)
+	err := c.cc.Invoke(ctx, "/trireme.external.ExternalMonitor/Register", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *externalMonitorClient) Start(ctx context.Context, in *PURequest, opts ...grpc.CallOption) (*PUResponse, error) {
+	out := new(PUResponse)
+	err := c.cc.Invoke(ctx, "/trireme.external.ExternalMonitor/Start", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *externalMonitorClient) Stop(ctx context.Context, in *PURequest, opts ...grpc.CallOption) (*PUResponse, error) {
+	out := new(PUResponse)
+	err := c.cc.Invoke(ctx, "/trireme.external.ExternalMonitor/Stop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *externalMonitorClient) Destroy(ctx context.Context, in *PURequest, opts ...grpc.CallOption) (*PUResponse, error) {
+	out := new(PUResponse)
+	err := c.cc.Invoke(ctx, "/trireme.external.ExternalMonitor/Destroy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExternalMonitorServer is the server API for ExternalMonitor service.
+type ExternalMonitorServer interface {
+	// Register creates a PU with its metadata, or updates the metadata of an
+	// existing PU. The policy of a started PU is resolved again.
+	Register(context.Context, *PURequest) (*PUResponse, error)
+	// Start activates the policy of a registered PU
+	Start(context.Context, *PURequest) (*PUResponse, error)
+	// Stop removes the policy of a PU. The PU can be started again.
+	Stop(context.Context, *PURequest) (*PUResponse, error)
+	// Destroy removes a PU, and stops it if it is started
+	Destroy(context.Context, *PURequest) (*PUResponse, error)
+}
+
+// UnimplementedExternalMonitorServer can be embedded to have forward compatible implementations.
+type UnimplementedExternalMonitorServer struct {
+}
+
+func (*UnimplementedExternalMonitorServer) Register(ctx context.Context, req *PURequest) (*PUResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (*UnimplementedExternalMonitorServer) Start(ctx context.Context, req *PURequest) (*PUResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Start not implemented")
+}
+func (*UnimplementedExternalMonitorServer) Stop(ctx context.Context, req *PURequest) (*PUResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+func (*UnimplementedExternalMonitorServer) Destroy(ctx context.Context, req *PURequest) (*PUResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Destroy not implemented")
+}
+
+func RegisterExternalMonitorServer(s *grpc.Server, srv ExternalMonitorServer) {
+	s.RegisterService(&_ExternalMonitor_serviceDesc, srv)
+}
+
+func _ExternalMonitor_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PURequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExternalMonitorServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/trireme.external.ExternalMonitor/Register",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExternalMonitorServer).Register(ctx, req.(*PURequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExternalMonitor_Start_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PURequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExternalMonitorServer).Start(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/trireme.external.ExternalMonitor/Start",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExternalMonitorServer).Start(ctx, req.(*PURequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExternalMonitor_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PURequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExternalMonitorServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/trireme.external.ExternalMonitor/Stop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExternalMonitorServer).Stop(ctx, req.(*PURequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ExternalMonitor_Destroy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PURequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExternalMonitorServer).Destroy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/trireme.external.ExternalMonitor/Destroy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExternalMonitorServer).Destroy(ctx, req.(*PURequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _ExternalMonitor_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "trireme.external.ExternalMonitor",
+	HandlerType: (*ExternalMonitorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _ExternalMonitor_Register_Handler,
+		},
+		{
+			MethodName: "Start",
+			Handler:    _ExternalMonitor_Start_Handler,
+		},
+		{
+			MethodName: "Stop",
+			Handler:    _ExternalMonitor_Stop_Handler,
+		},
+		{
+			MethodName: "Destroy",
+			Handler:    _ExternalMonitor_Destroy_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "external.proto",
+}
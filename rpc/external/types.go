@@ -0,0 +1,15 @@
+package external
+
+// The API of the external monitor is versioned. The monitor accepts the
+// requests of the versions from MinAPIVersion to APIVersion, and returns its
+// own version in every response.
+const (
+	// APIVersion is the current version of the API
+	APIVersion = 1
+
+	// MinAPIVersion is the oldest version of the API still supported
+	MinAPIVersion = 1
+)
+
+// DefaultAddress is the default Unix socket of the external monitor
+const DefaultAddress = "/var/run/trireme-external.sock"
@@ -20,6 +20,10 @@ type ProcessingUnitsHandler interface {
 	// CreatePURuntime is called when a monitor detects creation of a new ProcessingUnit.
 	CreatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error
 
+	// UpdatePURuntime is called when a monitor detects a change of the metadata of an
+	// existing ProcessingUnit. It must be followed by an update event.
+	UpdatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error
+
 	// HandlePUEvent is called by all monitors when a PU event is generated. The implementer
 	// is responsible to update all components by explicitly adding a new PU.
 	HandlePUEvent(contextID string, event events.Event) error
@@ -65,6 +69,9 @@ type Processor interface {
 	// Event processes a pause event
 	Pause(eventInfo *events.EventInfo) error
 
+	// Update processes a PU update event
+	Update(eventInfo *events.EventInfo) error
+
 	// ReSync resyncs all PUs handled by this processor
 	ReSync(EventInfo *events.EventInfo) error
 }
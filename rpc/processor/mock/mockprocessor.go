@@ -54,6 +54,20 @@ func (mr *MockProcessingUnitsHandlerMockRecorder) CreatePURuntime(contextID, run
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePURuntime", reflect.TypeOf((*MockProcessingUnitsHandler)(nil).CreatePURuntime), contextID, runtimeInfo)
 }
 
+// UpdatePURuntime mocks base method
+// nolint
+func (m *MockProcessingUnitsHandler) UpdatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+	ret := m.ctrl.Call(m, "UpdatePURuntime", contextID, runtimeInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePURuntime indicates an expected call of UpdatePURuntime
+// nolint
+func (mr *MockProcessingUnitsHandlerMockRecorder) UpdatePURuntime(contextID, runtimeInfo interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePURuntime", reflect.TypeOf((*MockProcessingUnitsHandler)(nil).UpdatePURuntime), contextID, runtimeInfo)
+}
+
 // HandlePUEvent mocks base method
 // nolint
 func (m *MockProcessingUnitsHandler) HandlePUEvent(contextID string, event events.Event) error {
@@ -218,6 +232,20 @@ func (mr *MockProcessorMockRecorder) Pause(eventInfo interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pause", reflect.TypeOf((*MockProcessor)(nil).Pause), eventInfo)
 }
 
+// Update mocks base method
+// nolint
+func (m *MockProcessor) Update(eventInfo *events.EventInfo) error {
+	ret := m.ctrl.Call(m, "Update", eventInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update
+// nolint
+func (mr *MockProcessorMockRecorder) Update(eventInfo interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockProcessor)(nil).Update), eventInfo)
+}
+
 // ReSync mocks base method
 // nolint
 func (m *MockProcessor) ReSync(EventInfo *events.EventInfo) error {
@@ -8,7 +8,9 @@ import (
 	"github.com/aporeto-inc/trireme-lib/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer/packetprocessor"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor"
 	"go.uber.org/zap"
 )
@@ -35,12 +37,22 @@ type config struct {
 	procMountPoint         string
 	externalIPcacheTimeout time.Duration
 	targetNetworks         []string
+	implementation         constants.ImplementationType
+	failMode               constants.FailMode
+	udpEnforcement         bool
+	netlinkIptables        bool
+	policyHistory          int
+	observeOnly            bool
+	tokenFormat            tokens.Format
+	envoyAuthorization     string
+	callTimeout            time.Duration
 }
 
 // Option is provided using functional arguments.
 type Option func(*config)
 
 // OptionCollector is an option to provide an external collector implementation.
+// The events can be sent to several collectors with a collector.Multiplexer.
 func OptionCollector(c collector.EventCollector) Option {
 	return func(cfg *config) {
 		cfg.collector = c
@@ -103,6 +115,87 @@ func OptionTargetNetworks(n []string) Option {
 	}
 }
 
+// OptionSupervisorImplementation is an option to select the packet filter
+// implementation of the supervisors. It defaults to iptables.
+func OptionSupervisorImplementation(i constants.ImplementationType) Option {
+	return func(cfg *config) {
+		cfg.implementation = i
+	}
+}
+
+// OptionFailMode is an option to select what happens to the traffic of the
+// PUs when their enforcer dies. It defaults to fail closed.
+func OptionFailMode(mode constants.FailMode) Option {
+	return func(cfg *config) {
+		cfg.failMode = mode
+	}
+}
+
+// OptionUDPEnforcement is an option to enforce the identity of the PUs on the
+// UDP flows with the target networks. The first datagrams of a flow carry the
+// identity tokens, so the peers must enforce the UDP flows as well. The
+// datagrams that cannot carry a token within the MTU are dropped. The UDP
+// flows are not enforced by default.
+func OptionUDPEnforcement() Option {
+	return func(cfg *config) {
+		cfg.udpEnforcement = true
+	}
+}
+
+// OptionNetlinkIptables is an option to program the rules of the linux
+// processes with nf_tables netlink messages instead of the iptables command,
+// on hosts where iptables uses nf_tables. The iptables command is used when
+// netlink is not available.
+func OptionNetlinkIptables() Option {
+	return func(cfg *config) {
+		cfg.netlinkIptables = true
+	}
+}
+
+// OptionPolicyHistory is an option to set how many versions of the policy of
+// every PU are kept for DiffPolicy and RollbackPolicy. It defaults to 5.
+func OptionPolicyHistory(n int) Option {
+	return func(cfg *config) {
+		cfg.policyHistory = n
+	}
+}
+
+// OptionObserveOnly is an option to enforce the policies of all the PUs in
+// observe only mode: the flows are reported with the verdict of the policy,
+// but none is dropped. It allows to roll a policy out safely.
+func OptionObserveOnly() Option {
+	return func(cfg *config) {
+		cfg.observeOnly = true
+	}
+}
+
+// OptionTokenFormat is an option to emit the identity tokens of the
+// handshakes in another format, like standard JWTs that can be validated by
+// API gateways. The tokens of all the formats are always accepted.
+func OptionTokenFormat(format tokens.Format) Option {
+	return func(cfg *config) {
+		cfg.tokenFormat = format
+	}
+}
+
+// OptionEnvoyAuthorization is an option to serve the ext_authz HTTP requests of
+// Envoy sidecars on the address, so that they enforce the policies of the
+// Linux processes. It requires the enforcement of Linux processes.
+func OptionEnvoyAuthorization(address string) Option {
+	return func(cfg *config) {
+		cfg.envoyAuthorization = address
+	}
+}
+
+// OptionCallTimeout is an option to set the time the supervisors and the
+// remote enforcers have to program or update the policy of a PU, so that a
+// hung remote enforcer or iptables command does not block the events.
+func OptionCallTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.callTimeout = timeout
+	}
+}
+
 // OptionProcMountPoint is an option to provide proc mount point.
 func OptionProcMountPoint(p string) Option {
 	return func(cfg *config) {
@@ -129,6 +222,8 @@ func New(serverID string, opts ...Option) Trireme {
 		validity:               time.Hour * 8760,
 		procMountPoint:         constants.DefaultProcMountPoint,
 		externalIPcacheTimeout: -1,
+		policyHistory:          5,
+		callTimeout:            rpcwrapper.DefaultCallTimeout,
 	}
 
 	for _, opt := range opts {
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"container/list"
 	"errors"
 	"fmt"
 	"sync"
@@ -13,6 +14,38 @@ import (
 // expires an item
 type ExpirationNotifier func(c DataStore, id interface{}, item interface{})
 
+// EvictionPolicy selects how a cache with a maximum number of entries makes
+// room for a new entry when it is full
+type EvictionPolicy int
+
+const (
+	// EvictOldest removes the entry that was added or updated the longest time
+	// ago. The expiration notifier of the cache is called for the entry.
+	EvictOldest EvictionPolicy = iota
+
+	// RejectNew keeps the entries of the cache and rejects the new ones
+	RejectNew
+)
+
+// Stats holds the counters of a cache since it was created
+type Stats struct {
+	// Entries is the number of entries of the cache
+	Entries int
+	// MaxEntries is the maximum number of entries, or 0 if it is not limited
+	MaxEntries int
+	// Hits and Misses count the lookups of the entries
+	Hits   uint64
+	Misses uint64
+	// Evictions is the number of entries removed to make room for new ones
+	Evictions uint64
+	// Rejections is the number of new entries rejected because the cache was
+	// full
+	Rejections uint64
+	// Expirations is the number of entries removed at the end of their
+	// lifetime
+	Expirations uint64
+}
+
 // DataStore is the interface to a datastore.
 type DataStore interface {
 	Add(u interface{}, value interface{}) (err error)
@@ -23,6 +56,7 @@ type DataStore interface {
 	RemoveWithDelay(u interface{}, duration time.Duration) (err error)
 	LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error)
 	SetTimeOut(u interface{}, timeout time.Duration) (err error)
+	KeyList() []interface{}
 	ToString() string
 }
 
@@ -35,6 +69,13 @@ type Cache struct {
 	sync.RWMutex
 	expirer ExpirationNotifier
 	max     int
+	// maxEntries and policy limit the number of entries if maxEntries is set
+	maxEntries int
+	policy     EvictionPolicy
+	// order holds the keys from the least to the most recently added or
+	// updated, for the eviction of the oldest entries
+	order *list.List
+	stats Stats
 }
 
 // entry is a single line in the datastore that includes the actual entry
@@ -44,6 +85,7 @@ type entry struct {
 	timestamp time.Time
 	timer     *time.Timer
 	expirer   ExpirationNotifier
+	element   *list.Element
 }
 
 // cacheRegistry keeps handles of all caches initialized through this library
@@ -103,6 +145,7 @@ func NewCacheWithExpirationNotifier(name string, lifetime time.Duration, expirer
 		data:     make(map[interface{}]entry),
 		lifetime: lifetime,
 		expirer:  expirer,
+		order:    list.New(),
 	}
 	c.max = len(c.data)
 	registry.Add(c)
@@ -123,6 +166,77 @@ func (c *Cache) ToString() string {
 	return fmt.Sprintf("%d/%d", c.max, len(c.data))
 }
 
+// SetLimit limits the number of entries of the cache. The policy selects how
+// the cache makes room for a new entry when it is full. The cache is not
+// limited if maxEntries is 0.
+func (c *Cache) SetLimit(maxEntries int, policy EvictionPolicy) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.maxEntries = maxEntries
+	c.policy = policy
+
+	if maxEntries <= 0 || policy != EvictOldest {
+		return
+	}
+
+	for len(c.data) > maxEntries {
+		c.evict(c.order.Front().Value)
+	}
+}
+
+// Stats returns the counters of the cache
+func (c *Cache) Stats() Stats {
+
+	c.Lock()
+	defer c.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.data)
+	stats.MaxEntries = c.maxEntries
+
+	return stats
+}
+
+// makeRoom makes room for a new entry if the cache is full. It returns false
+// if the entry is rejected. The cache must be locked.
+func (c *Cache) makeRoom() bool {
+
+	if c.maxEntries <= 0 || len(c.data) < c.maxEntries {
+		return true
+	}
+
+	if c.policy == RejectNew {
+		c.stats.Rejections++
+		return false
+	}
+
+	for len(c.data) >= c.maxEntries {
+		c.evict(c.order.Front().Value)
+	}
+
+	return true
+}
+
+// evict removes an entry to make room for a new one and notifies the
+// expiration. The cache must be locked.
+func (c *Cache) evict(u interface{}) {
+
+	val := c.data[u]
+	if val.timer != nil {
+		val.timer.Stop()
+	}
+
+	if val.expirer != nil {
+		val.expirer(c, u, val.value)
+	}
+
+	c.order.Remove(val.element)
+	delete(c.data, u)
+	c.stats.Evictions++
+}
+
 // Add stores an entry into the cache and updates the timestamp
 func (c *Cache) Add(u interface{}, value interface{}) (err error) {
 
@@ -142,11 +256,19 @@ func (c *Cache) Add(u interface{}, value interface{}) (err error) {
 
 	if _, ok := c.data[u]; !ok {
 
+		if !c.makeRoom() {
+			if timer != nil {
+				timer.Stop()
+			}
+			return errors.New("cannot add item: cache is full")
+		}
+
 		c.data[u] = entry{
 			value:     value,
 			timestamp: t,
 			timer:     timer,
 			expirer:   c.expirer,
+			element:   c.order.PushBack(u),
 		}
 		if len(c.data) > c.max {
 			c.max = len(c.data)
@@ -154,6 +276,10 @@ func (c *Cache) Add(u interface{}, value interface{}) (err error) {
 		return nil
 	}
 
+	if timer != nil {
+		timer.Stop()
+	}
+
 	return errors.New("item exists: use update")
 }
 
@@ -165,6 +291,8 @@ func (c *Cache) GetReset(u interface{}, duration time.Duration) (interface{}, er
 
 	if line, ok := c.data[u]; ok {
 
+		c.stats.Hits++
+
 		if c.lifetime != -1 && line.timer != nil {
 			if duration > 0 {
 				line.timer.Reset(duration)
@@ -176,6 +304,8 @@ func (c *Cache) GetReset(u interface{}, duration time.Duration) (interface{}, er
 		return line.value, nil
 	}
 
+	c.stats.Misses++
+
 	return nil, errors.New("cannot read item: not found")
 }
 
@@ -196,22 +326,29 @@ func (c *Cache) Update(u interface{}, value interface{}) (err error) {
 	c.Lock()
 	defer c.Unlock()
 
-	if _, ok := c.data[u]; ok {
+	if old, ok := c.data[u]; ok {
 
-		if c.data[u].timer != nil {
-			c.data[u].timer.Stop()
+		if old.timer != nil {
+			old.timer.Stop()
 		}
 
+		c.order.MoveToBack(old.element)
+
 		c.data[u] = entry{
 			value:     value,
 			timestamp: t,
 			timer:     timer,
 			expirer:   c.expirer,
+			element:   old.element,
 		}
 
 		return nil
 	}
 
+	if timer != nil {
+		timer.Stop()
+	}
+
 	return errors.New("cannot update item: not found")
 }
 
@@ -234,10 +371,23 @@ func (c *Cache) AddOrUpdate(u interface{}, value interface{}) (updated bool) {
 	c.Lock()
 	defer c.Unlock()
 
-	if _, updated = c.data[u]; updated {
-		if c.data[u].timer != nil {
-			c.data[u].timer.Stop()
+	var element *list.Element
+
+	old, updated := c.data[u]
+	if updated {
+		if old.timer != nil {
+			old.timer.Stop()
+		}
+		element = old.element
+		c.order.MoveToBack(element)
+	} else {
+		if !c.makeRoom() {
+			if timer != nil {
+				timer.Stop()
+			}
+			return false
 		}
+		element = c.order.PushBack(u)
 	}
 
 	c.data[u] = entry{
@@ -245,6 +395,7 @@ func (c *Cache) AddOrUpdate(u interface{}, value interface{}) (updated bool) {
 		timestamp: t,
 		timer:     timer,
 		expirer:   c.expirer,
+		element:   element,
 	}
 	if len(c.data) > c.max {
 		c.max = len(c.data)
@@ -274,9 +425,12 @@ func (c *Cache) Get(u interface{}) (i interface{}, err error) {
 	defer c.Unlock()
 
 	if _, ok := c.data[u]; !ok {
+		c.stats.Misses++
 		return nil, errors.New("not found")
 	}
 
+	c.stats.Hits++
+
 	return c.data[u].value, nil
 }
 
@@ -296,10 +450,14 @@ func (c *Cache) removeNotify(u interface{}, notify bool) (err error) {
 		val.timer.Stop()
 	}
 
-	if notify && val.expirer != nil {
-		val.expirer(c, u, val.value)
+	if notify {
+		c.stats.Expirations++
+		if val.expirer != nil {
+			val.expirer(c, u, val.value)
+		}
 	}
 
+	c.order.Remove(val.element)
 	delete(c.data, u)
 
 	return nil
@@ -343,12 +501,27 @@ func (c *Cache) RemoveWithDelay(u interface{}, duration time.Duration) error {
 		timestamp: t,
 		timer:     timer,
 		expirer:   c.expirer,
+		element:   e.element,
 	}
 
 	return nil
 
 }
 
+// KeyList returns the keys of the elements in the cache
+func (c *Cache) KeyList() []interface{} {
+
+	c.Lock()
+	defer c.Unlock()
+
+	list := make([]interface{}, 0, len(c.data))
+	for k := range c.data {
+		list = append(list, k)
+	}
+
+	return list
+}
+
 // SizeOf returns the number of elements in the cache
 func (c *Cache) SizeOf() int {
 
@@ -384,6 +557,8 @@ func (c *Cache) LockedModify(u interface{}, add func(a, b interface{}) interface
 		e.timer.Stop()
 	}
 
+	c.order.MoveToBack(e.element)
+
 	e.value = add(e.value, increment)
 	e.timer = timer
 	e.timestamp = t
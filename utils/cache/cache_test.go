@@ -180,6 +180,27 @@ func TestLockedModify(t *testing.T) {
 	})
 }
 
+func TestKeyList(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given a new cache", t, func() {
+		c := NewCache("cache")
+
+		Convey("When the cache is empty, I should get no keys", func() {
+			So(c.KeyList(), ShouldBeEmpty)
+		})
+
+		Convey("When I add elements, I should get their keys", func() {
+			So(c.Add("key1", 1), ShouldBeNil)
+			So(c.Add("key2", 2), ShouldBeNil)
+			So(c.KeyList(), ShouldHaveLength, 2)
+			So(c.KeyList(), ShouldContain, "key1")
+			So(c.KeyList(), ShouldContain, "key2")
+		})
+	})
+}
+
 func TestTimerExpirationWithUpdate(t *testing.T) {
 
 	t.Parallel()
@@ -378,3 +399,90 @@ func TestRemoveWithDelay(t *testing.T) {
 
 	})
 }
+
+func TestLimit(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given a cache limited to two entries that evicts the oldest", t, func() {
+
+		evicted := []interface{}{}
+		c := NewCacheWithExpirationNotifier("limited", time.Hour, func(c DataStore, id interface{}, item interface{}) {
+			evicted = append(evicted, id)
+		})
+		c.SetLimit(2, EvictOldest)
+
+		So(c.Add("a", 1), ShouldBeNil)
+		So(c.Add("b", 2), ShouldBeNil)
+
+		Convey("When I add a third entry, the oldest entry should be evicted", func() {
+			So(c.Add("c", 3), ShouldBeNil)
+
+			_, err := c.Get("a")
+			So(err, ShouldNotBeNil)
+			So(evicted, ShouldResemble, []interface{}{"a"})
+			So(c.SizeOf(), ShouldEqual, 2)
+
+			stats := c.Stats()
+			So(stats.Entries, ShouldEqual, 2)
+			So(stats.MaxEntries, ShouldEqual, 2)
+			So(stats.Evictions, ShouldEqual, 1)
+			So(stats.Misses, ShouldEqual, 1)
+		})
+
+		Convey("When I update the oldest entry before I add a third one, the other entry should be evicted", func() {
+			So(c.Update("a", 10), ShouldBeNil)
+			So(c.AddOrUpdate("c", 3), ShouldBeFalse)
+
+			value, err := c.Get("a")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 10)
+			So(evicted, ShouldResemble, []interface{}{"b"})
+			So(c.Stats().Hits, ShouldEqual, 1)
+		})
+
+		Convey("When I lower the limit, the oldest entries should be evicted", func() {
+			c.SetLimit(1, EvictOldest)
+
+			So(c.SizeOf(), ShouldEqual, 1)
+			So(evicted, ShouldResemble, []interface{}{"a"})
+		})
+	})
+
+	Convey("Given a cache limited to one entry that rejects the new entries", t, func() {
+
+		c := NewCache("rejecting")
+		c.SetLimit(1, RejectNew)
+		So(c.Add("a", 1), ShouldBeNil)
+
+		Convey("When I add another entry, it should be rejected", func() {
+			So(c.Add("b", 2), ShouldNotBeNil)
+			c.AddOrUpdate("c", 3)
+
+			_, err := c.Get("c")
+			So(err, ShouldNotBeNil)
+			So(c.Stats().Rejections, ShouldEqual, 2)
+		})
+
+		Convey("When I update the entry, it should be updated", func() {
+			c.AddOrUpdate("a", 10)
+
+			value, err := c.Get("a")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 10)
+		})
+	})
+
+	Convey("Given a cache with a short lifetime, its expirations should be counted", t, func() {
+
+		c := NewCacheWithExpiration("expiring", 10*time.Millisecond)
+		So(c.Add("a", 1), ShouldBeNil)
+
+		time.Sleep(50 * time.Millisecond)
+
+		stats := c.Stats()
+		So(stats.Entries, ShouldEqual, 0)
+		So(stats.Expirations, ShouldEqual, 1)
+		So(stats.Evictions, ShouldEqual, 0)
+	})
+}
@@ -12,7 +12,6 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"syscall"
 
 	"github.com/kardianos/osext"
@@ -245,11 +244,6 @@ func NewCgroupNetController(releasePath string) Cgroupnetcls {
 	return controller
 }
 
-// MarkVal returns a new Mark Value
-func MarkVal() uint64 {
-	return atomic.AddUint64(&markval, 1)
-}
-
 // ListCgroupProcesses returns lists of  processes in the cgroup
 func ListCgroupProcesses(cgroupname string) ([]string, error) {
 
@@ -48,11 +48,6 @@ func NewDockerCgroupNetController() Cgroupnetcls {
 	return &netCls{}
 }
 
-// MarkVal returns a new Mark
-func MarkVal() uint64 {
-	return 0
-}
-
 // ListCgroupProcesses lists the processes of the cgroup
 func ListCgroupProcesses(cgroupname string) ([]string, error) {
 	return []string{}, nil
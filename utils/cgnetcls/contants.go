@@ -16,4 +16,6 @@ const (
 	notifyOnReleaseFile  = "/notify_on_release"
 	//Initialmarkval is the start of mark values we assign to cgroup
 	Initialmarkval = 100
+	//Maxmarkval is the last mark value we assign to cgroup. Higher marks are used by the datapath
+	Maxmarkval = 0x1000
 )
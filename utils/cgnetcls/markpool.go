@@ -0,0 +1,183 @@
+package cgnetcls
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// MarkStats are the allocation metrics of the cgroup marks
+type MarkStats struct {
+	// InUse is the number of marks assigned to cgroups
+	InUse int
+	// Available is the number of marks that can still be assigned
+	Available int
+	// Released is the number of marks returned to the pool
+	Released uint64
+	// Exhausted is the number of allocations that failed because all the marks were in use
+	Exhausted uint64
+}
+
+// markPool allocates the marks of the cgroups. Released marks are reused in the
+// order they were released, so that a mark is reused as late as possible. The
+// marks that are reserved are skipped.
+type markPool struct {
+	first     uint64
+	last      uint64
+	next      uint64
+	free      []uint64
+	inUse     map[uint64]bool
+	released  uint64
+	exhausted uint64
+	sync.Mutex
+}
+
+var marks = newMarkPool(Initialmarkval+1, Maxmarkval)
+
+// newMarkPool creates a pool with the marks from first to last
+func newMarkPool(first, last uint64) *markPool {
+
+	return &markPool{
+		first: first,
+		last:  last,
+		next:  first,
+		free:  []uint64{},
+		inUse: map[uint64]bool{},
+	}
+}
+
+// allocate returns an unused mark
+func (p *markPool) allocate() (uint64, error) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	var mark uint64
+
+	// Skip the marks that were reserved before they were allocated
+	for p.next <= p.last && p.inUse[p.next] {
+		p.next++
+	}
+
+	switch {
+	case len(p.free) > 0:
+		mark = p.free[0]
+		p.free = p.free[1:]
+	case p.next <= p.last:
+		mark = p.next
+		p.next++
+	default:
+		p.exhausted++
+		return 0, fmt.Errorf("all the %d marks are in use", p.last-p.first+1)
+	}
+
+	p.inUse[mark] = true
+
+	return mark, nil
+}
+
+// reserve marks a given mark as in use, so that it is not allocated
+func (p *markPool) reserve(mark uint64) error {
+
+	p.Lock()
+	defer p.Unlock()
+
+	if mark < p.first || mark > p.last {
+		return fmt.Errorf("mark %d is out of the range %d-%d", mark, p.first, p.last)
+	}
+
+	if p.inUse[mark] {
+		return fmt.Errorf("mark %d is in use", mark)
+	}
+
+	for i, free := range p.free {
+		if free == mark {
+			p.free = append(p.free[:i], p.free[i+1:]...)
+			break
+		}
+	}
+
+	p.inUse[mark] = true
+
+	return nil
+}
+
+// release returns a mark to the pool
+func (p *markPool) release(mark uint64) error {
+
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.inUse[mark] {
+		return fmt.Errorf("mark %d is not in use", mark)
+	}
+
+	delete(p.inUse, mark)
+	p.free = append(p.free, mark)
+	p.released++
+
+	return nil
+}
+
+// stats returns the allocation metrics of the pool
+func (p *markPool) stats() MarkStats {
+
+	p.Lock()
+	defer p.Unlock()
+
+	return MarkStats{
+		InUse:     len(p.inUse),
+		Available: int(p.last-p.first+1) - len(p.inUse),
+		Released:  p.released,
+		Exhausted: p.exhausted,
+	}
+}
+
+// AllocateMark returns a mark that is not assigned to any cgroup. It returns
+// an error if all the marks are in use.
+func AllocateMark() (uint64, error) {
+
+	mark, err := marks.allocate()
+	if err != nil {
+		stats := marks.stats()
+		zap.L().Warn("Cgroup marks exhausted",
+			zap.Int("inUse", stats.InUse),
+			zap.Uint64("exhausted", stats.Exhausted),
+		)
+		return 0, err
+	}
+
+	return mark, nil
+}
+
+// ReserveMark assigns a given mark, like a mark that was assigned before a
+// restart. It returns an error if the mark is already in use.
+func ReserveMark(mark uint64) error {
+
+	return marks.reserve(mark)
+}
+
+// ReleaseMark returns the mark of a destroyed cgroup to the pool
+func ReleaseMark(mark uint64) error {
+
+	return marks.release(mark)
+}
+
+// GetMarkStats returns the allocation metrics of the cgroup marks
+func GetMarkStats() MarkStats {
+
+	return marks.stats()
+}
+
+// MarkVal returns a new Mark Value. It returns 0 if all the marks are in use.
+// Deprecated: use AllocateMark.
+func MarkVal() uint64 {
+
+	mark, err := AllocateMark()
+	if err != nil {
+		return 0
+	}
+
+	return mark
+}
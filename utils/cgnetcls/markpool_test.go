@@ -0,0 +1,112 @@
+package cgnetcls
+
+import "testing"
+
+func TestMarkPoolAllocate(t *testing.T) {
+
+	p := newMarkPool(10, 12)
+
+	for expected := uint64(10); expected <= 12; expected++ {
+		mark, err := p.allocate()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if mark != expected {
+			t.Errorf("expected mark %d, got %d", expected, mark)
+		}
+	}
+
+	if _, err := p.allocate(); err == nil {
+		t.Error("expected an error when all the marks are in use")
+	}
+
+	stats := p.stats()
+	if stats.InUse != 3 || stats.Available != 0 || stats.Exhausted != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMarkPoolRelease(t *testing.T) {
+
+	p := newMarkPool(10, 12)
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.allocate(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if err := p.release(11); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := p.release(11); err == nil {
+		t.Error("expected an error when releasing a mark that is not in use")
+	}
+
+	if err := p.release(10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Released marks are reused in the order they were released
+	for _, expected := range []uint64{11, 10} {
+		mark, err := p.allocate()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if mark != expected {
+			t.Errorf("expected mark %d, got %d", expected, mark)
+		}
+	}
+
+	stats := p.stats()
+	if stats.InUse != 3 || stats.Released != 2 || stats.Exhausted != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMarkPoolReserve(t *testing.T) {
+
+	p := newMarkPool(10, 13)
+
+	if err := p.reserve(11); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := p.reserve(11); err == nil {
+		t.Error("expected an error when reserving a mark that is in use")
+	}
+
+	if err := p.reserve(20); err == nil {
+		t.Error("expected an error when reserving a mark out of the range")
+	}
+
+	// Reserved marks are skipped
+	for _, expected := range []uint64{10, 12, 13} {
+		mark, err := p.allocate()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if mark != expected {
+			t.Errorf("expected mark %d, got %d", expected, mark)
+		}
+	}
+
+	if err := p.release(12); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// A released mark is removed from the free marks when it is reserved
+	if err := p.reserve(12); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := p.allocate(); err == nil {
+		t.Error("expected an error when all the marks are in use")
+	}
+
+	stats := p.stats()
+	if stats.InUse != 4 || stats.Available != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
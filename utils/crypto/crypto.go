@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/hmac"
@@ -15,6 +16,7 @@ import (
 	"fmt"
 
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ed25519"
 )
 
 // ComputeHmac256 computes the HMAC256 of the message
@@ -122,6 +124,43 @@ func LoadEllipticCurveKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
 	return key, nil
 }
 
+// LoadPrivateKey parses a private key of PKI secrets. The key is an ECDSA key
+// on the P-256 or the P-384 curve, in SEC 1 or PKCS #8 form, or an Ed25519 key
+// in PKCS #8 form. The Ed25519 keys are only parsed by the x509 package of Go
+// 1.13 or later, which returns the keys of golang.org/x/crypto/ed25519.
+func LoadPrivateKey(keyPEM []byte) (interface{}, error) {
+	block, _ := pem.Decode(keyPEM)
+
+	if block == nil {
+		return nil, fmt.Errorf("unable to parse pem block: %s", string(keyPEM))
+	}
+
+	var key interface{}
+	var err error
+
+	if block.Type == "EC PRIVATE KEY" {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() && k.Curve != elliptic.P384() {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", key)
+	}
+
+	return key, nil
+}
+
 // LoadAndVerifyCertificate parses, validates, and creates a certificate structure from a PEM buffer
 // It must be provided with the a CertPool
 func LoadAndVerifyCertificate(certPEM []byte, roots *x509.CertPool) (*x509.Certificate, error) {
@@ -167,6 +206,85 @@ func LoadAndVerifyECSecrets(keyPEM, certPEM, caCertPEM []byte) (key *ecdsa.Priva
 
 }
 
+// LoadAndVerifySecrets loads the key, the certificate and the CA of PKI
+// secrets like LoadAndVerifyECSecrets, with the keys of LoadPrivateKey. It
+// returns an error if the key is not the one of the certificate.
+func LoadAndVerifySecrets(keyPEM, certPEM, caCertPEM []byte) (key interface{}, cert *x509.Certificate, rootCertPool *x509.CertPool, err error) {
+
+	key, err = LoadPrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	rootCertPool = LoadRootCertificates(caCertPEM)
+	if rootCertPool == nil {
+		return nil, nil, nil, errors.New("unable to load root certificate pool")
+	}
+
+	cert, err = LoadAndVerifyCertificate(certPEM, rootCertPool)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if !matchingKeys(key, cert.PublicKey) {
+		return nil, nil, nil, errors.New("private key does not match the certificate")
+	}
+
+	return key, cert, rootCertPool, nil
+}
+
+// LoadAndVerifySigner loads the certificate and the CA of PKI secrets whose
+// private key is only available as a signer, like a key in an HSM. It returns
+// an error if the key of the signer is not the one of the certificate.
+func LoadAndVerifySigner(signer crypto.Signer, certPEM, caCertPEM []byte) (cert *x509.Certificate, rootCertPool *x509.CertPool, err error) {
+
+	if signer == nil {
+		return nil, nil, errors.New("signer can not be nil")
+	}
+
+	rootCertPool = LoadRootCertificates(caCertPEM)
+	if rootCertPool == nil {
+		return nil, nil, errors.New("unable to load root certificate pool")
+	}
+
+	cert, err = LoadAndVerifyCertificate(certPEM, rootCertPool)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !matchingKeys(signer, cert.PublicKey) {
+		return nil, nil, errors.New("signer does not match the certificate")
+	}
+
+	return cert, rootCertPool, nil
+}
+
+// matchingKeys returns true if the public key is the one of the private key
+func matchingKeys(privateKey, publicKey interface{}) bool {
+
+	switch k := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		pub, ok := publicKey.(*ecdsa.PublicKey)
+		return ok && pub.Curve == k.Curve && pub.X.Cmp(k.X) == 0 && pub.Y.Cmp(k.Y) == 0
+	case ed25519.PrivateKey:
+		pub, ok := publicKey.(ed25519.PublicKey)
+		return ok && bytes.Equal(pub, k.Public().(ed25519.PublicKey))
+	case crypto.Signer:
+		switch pub := k.Public().(type) {
+		case *ecdsa.PublicKey:
+			other, ok := publicKey.(*ecdsa.PublicKey)
+			return ok && pub.Curve == other.Curve && pub.X.Cmp(other.X) == 0 && pub.Y.Cmp(other.Y) == 0
+		case ed25519.PublicKey:
+			other, ok := publicKey.(ed25519.PublicKey)
+			return ok && bytes.Equal(pub, other)
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+}
+
 // LoadCertificate loads a certificate from a PEM file without verifying
 // Should only be used for loading a root CA certificate. It will only read
 // the first certificate
@@ -186,3 +304,29 @@ func LoadCertificate(certPEM []byte) (*x509.Certificate, error) {
 
 	return cert, nil
 }
+
+// LoadCertificates loads all the certificates of a PEM buffer without
+// verifying them, like the certificates of the authorities of a bundle
+func LoadCertificates(certPEM []byte) ([]*x509.Certificate, error) {
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates in pem: %s", string(certPEM))
+	}
+
+	return certs, nil
+}
@@ -1,10 +1,18 @@
 package crypto
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"math/big"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ed25519"
 )
 
 // TestComputeVerifyHMAC tests the compute and verify of HMAC functions
@@ -393,3 +401,77 @@ IG7Nv+YlTVp5qA==
 		})
 	})
 }
+
+// testKeyAndCert returns the PEMs of a key and of a self signed certificate of
+// the key
+func testKeyAndCert(key interface{}, public interface{}, keyType string) ([]byte, []byte) {
+
+	var keyDER []byte
+	if keyType == "EC PRIVATE KEY" {
+		keyDER, _ = x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey)) // nolint
+	} else {
+		keyDER, _ = x509.MarshalPKCS8PrivateKey(key) // nolint
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, _ := x509.CreateCertificate(rand.Reader, template, template, public, key) // nolint
+
+	return pem.EncodeToMemory(&pem.Block{Type: keyType, Bytes: keyDER}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+}
+
+func TestLoadAndVerifySecrets(t *testing.T) {
+	Convey("Given P-256, P-384 and Ed25519 keys", t, func() {
+		p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // nolint
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader) // nolint
+		edPublic, ed, _ := ed25519.GenerateKey(rand.Reader)        // nolint
+
+		Convey("I should be able to load them with their certificates", func() {
+			for _, k := range []struct {
+				key     interface{}
+				public  interface{}
+				keyType string
+			}{
+				{p256, &p256.PublicKey, "EC PRIVATE KEY"},
+				{p256, &p256.PublicKey, "PRIVATE KEY"},
+				{p384, &p384.PublicKey, "EC PRIVATE KEY"},
+				{ed, edPublic, "PRIVATE KEY"},
+			} {
+				keyPEM, certPEM := testKeyAndCert(k.key, k.public, k.keyType)
+
+				key, cert, pool, err := LoadAndVerifySecrets(keyPEM, certPEM, certPEM)
+				So(err, ShouldBeNil)
+				So(key, ShouldResemble, k.key)
+				So(cert.PublicKey, ShouldResemble, k.public)
+				So(pool, ShouldNotBeNil)
+			}
+		})
+
+		Convey("I should get an error if the key is not the one of the certificate", func() {
+			keyPEM, _ := testKeyAndCert(p256, &p256.PublicKey, "EC PRIVATE KEY")
+			_, certPEM := testKeyAndCert(p384, &p384.PublicKey, "EC PRIVATE KEY")
+
+			_, _, _, err := LoadAndVerifySecrets(keyPEM, certPEM, certPEM)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a P-521 key, I should get an error", t, func() {
+		p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader) // nolint
+		keyPEM, _ := testKeyAndCert(p521, &p521.PublicKey, "EC PRIVATE KEY")
+
+		_, err := LoadPrivateKey(keyPEM)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given an invalid PEM block, I should get an error", t, func() {
+		_, err := LoadPrivateKey([]byte("key"))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -1,7 +1,11 @@
 package trireme
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/aporeto-inc/trireme-lib/internal/supervisor/proxy"
 
@@ -12,10 +16,13 @@ import (
 
 	"github.com/aporeto-inc/trireme-lib/collector"
 	"github.com/aporeto-inc/trireme-lib/constants"
+	"github.com/aporeto-inc/trireme-lib/diagnostics"
 	"github.com/aporeto-inc/trireme-lib/enforcer/constants"
 	"github.com/aporeto-inc/trireme-lib/enforcer/policyenforcer"
 	"github.com/aporeto-inc/trireme-lib/enforcer/proxy"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/fqconfig"
 	"github.com/aporeto-inc/trireme-lib/enforcer/utils/secrets"
+	"github.com/aporeto-inc/trireme-lib/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme-lib/internal/monitor"
 	"github.com/aporeto-inc/trireme-lib/internal/supervisor"
 	"github.com/aporeto-inc/trireme-lib/policy"
@@ -24,16 +31,25 @@ import (
 	"github.com/aporeto-inc/trireme-lib/utils/cache"
 )
 
+// puPolicy is a version of the policy enforced for a PU. The versions of a PU
+// are cached from the oldest to the current one.
+type puPolicy struct {
+	policy  *policy.PUPolicy
+	version int
+}
+
 // trireme contains references to all the different components involved.
 type trireme struct {
 	config               *config
 	cache                cache.DataStore
+	policies             cache.DataStore
 	supervisors          map[constants.ModeType]supervisor.Supervisor
 	enforcers            map[constants.ModeType]policyenforcer.Enforcer
 	puTypeToEnforcerType map[constants.PUType]constants.ModeType
 	port                 allocator.Allocator
 	rpchdl               rpcwrapper.RPCClient
 	monitors             monitor.Monitor
+	secretsLock          sync.RWMutex
 }
 
 func (t *trireme) newEnforcers() error {
@@ -72,21 +88,64 @@ func (t *trireme) newEnforcers() error {
 		)
 	}
 
+	if t.config.tokenFormat != tokens.CompactFormat {
+		for mode, e := range t.enforcers {
+			formatter, ok := e.(policyenforcer.TokenFormatter)
+			if !ok {
+				return fmt.Errorf("enforcer %d does not support the token format", mode)
+			}
+			if err := formatter.SetTokenFormat(t.config.tokenFormat); err != nil {
+				return err
+			}
+		}
+	}
+
+	for mode, e := range t.enforcers {
+		if setter, ok := e.(policyenforcer.CallTimeoutSetter); ok {
+			if err := setter.SetCallTimeout(t.config.callTimeout); err != nil {
+				return fmt.Errorf("enforcer %d: %s", mode, err)
+			}
+		}
+	}
+
+	if t.config.envoyAuthorization != "" {
+		authorizer, ok := t.enforcers[constants.LocalServer].(policyenforcer.EnvoyAuthorizer)
+		if !ok {
+			return errors.New("envoy authorization requires the enforcement of linux processes")
+		}
+		if err := authorizer.SetEnvoyAuthorization(t.config.envoyAuthorization); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (t *trireme) newSupervisors() error {
 
 	if t.config.linuxProcess {
+		opts := []supervisor.Option{}
+		if t.config.udpEnforcement {
+			opts = append(opts, supervisor.OptionUDPEnforcement())
+		}
+		if t.config.netlinkIptables {
+			opts = append(opts, supervisor.OptionNetlinkIptables())
+		}
+
 		sup, err := supervisor.NewSupervisor(
 			t.config.collector,
 			t.enforcers[constants.LocalServer],
 			constants.LocalServer,
+			t.config.implementation,
 			t.config.targetNetworks,
+			opts...,
 		)
 		if err != nil {
 			return fmt.Errorf("Could Not create process supervisor :: received error %v", err)
 		}
+
+		sup.SetFailMode(t.config.failMode)
+
 		t.supervisors[constants.LocalServer] = sup
 	}
 
@@ -100,6 +159,14 @@ func (t *trireme) newSupervisors() error {
 			zap.L().Error("Unable to create proxy Supervisor:: Returned Error ", zap.Error(err))
 			return nil
 		}
+		s.SetFailMode(t.config.failMode)
+		s.SetUDPEnforcement(t.config.udpEnforcement)
+		if err := s.SetCallTimeout(t.config.callTimeout); err != nil {
+			return fmt.Errorf("Could Not create proxy supervisor :: received error %v", err)
+		}
+		if t.config.implementation == constants.IPSets {
+			s.SetCaptureMethod(rpcwrapper.IPSets)
+		}
 		t.supervisors[constants.RemoteContainer] = s
 	}
 
@@ -114,6 +181,7 @@ func newTrireme(c *config) Trireme {
 	t := &trireme{
 		config:               c,
 		cache:                cache.NewCache("TriremeCache"),
+		policies:             cache.NewCache("TriremePolicyCache"),
 		port:                 allocator.New(5000, 100),
 		rpchdl:               rpcwrapper.NewRPCWrapper(),
 		enforcers:            map[constants.ModeType]policyenforcer.Enforcer{},
@@ -153,11 +221,25 @@ func newTrireme(c *config) Trireme {
 }
 
 // Start starts the supervisor and the enforcer. It will also start to handling requests
-// For new PU Creation and Policy Updates.
-func (t *trireme) Start() error {
+// For new PU Creation and Policy Updates. It gives up when the context is done,
+// and the components that were not started yet are not started.
+func (t *trireme) Start(ctx context.Context) error {
+
+	if err := wait(ctx, func() error { return t.start(ctx) }); err != nil {
+		return fmt.Errorf("unable to start trireme: %s", err)
+	}
+
+	return nil
+}
+
+// start starts the components one after the other until the context is done
+func (t *trireme) start(ctx context.Context) error {
 
 	// Start all the supervisors.
 	for _, s := range t.supervisors {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err := s.Start(); err != nil {
 			zap.L().Error("Error when starting the supervisor", zap.Error(err))
 			return fmt.Errorf("Error while starting supervisor %v", err)
@@ -166,12 +248,18 @@ func (t *trireme) Start() error {
 
 	// Start all the enforcers.
 	for _, e := range t.enforcers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err := e.Start(); err != nil {
 			return fmt.Errorf("unable to start the enforcer: %s", err)
 		}
 	}
 
 	// Start monitors.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if err := t.monitors.Start(); err != nil {
 		return fmt.Errorf("unable to start monitors: %s", err)
 	}
@@ -180,8 +268,19 @@ func (t *trireme) Start() error {
 }
 
 // Stop stops the supervisor and enforcer. It also stops handling new request
-// for PU Creation/Update and Policy Updates
-func (t *trireme) Stop() error {
+// for PU Creation/Update and Policy Updates. It does not wait for the
+// components after the context is done, but they are still stopped.
+func (t *trireme) Stop(ctx context.Context) error {
+
+	if err := wait(ctx, t.stop); err != nil {
+		return fmt.Errorf("unable to stop trireme: %s", err)
+	}
+
+	return nil
+}
+
+// stop stops all the components
+func (t *trireme) stop() error {
 
 	for _, s := range t.supervisors {
 		if err := s.Stop(); err != nil {
@@ -202,6 +301,29 @@ func (t *trireme) Stop() error {
 	return nil
 }
 
+// wait runs a function in its own goroutine and waits for its result until the
+// context is done
+func wait(ctx context.Context, f func() error) error {
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// callContext returns the context of a call to a supervisor, which ends with
+// the call timeout
+func (t *trireme) callContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), t.config.callTimeout)
+}
+
 // UpdatePolicy updates a policy for an already activated PU. The PU is identified by the contextID
 func (t *trireme) UpdatePolicy(contextID string, newPolicy *policy.PUPolicy) error {
 
@@ -229,6 +351,26 @@ func (t *trireme) CreatePURuntime(contextID string, runtimeInfo *policy.PURuntim
 	return nil
 }
 
+// UpdatePURuntime implements processor.ProcessingUnitsHandler. The tags and the
+// IP addresses of the cached runtime are refreshed and the policy is resolved
+// again on the next update event.
+func (t *trireme) UpdatePURuntime(contextID string, runtimeInfo *policy.PURuntime) error {
+
+	runtimeReader, err := t.PURuntime(contextID)
+	if err != nil {
+		return fmt.Errorf("pu %s does not exist", contextID)
+	}
+
+	runtime := runtimeReader.(*policy.PURuntime)
+	runtime.GlobalLock.Lock()
+	defer runtime.GlobalLock.Unlock()
+
+	runtime.SetTags(runtimeInfo.Tags())
+	runtime.SetIPAddresses(runtimeInfo.IPAddresses())
+
+	return nil
+}
+
 // HandlePUEvent implements processor.ProcessingUnitsHandler
 func (t *trireme) HandlePUEvent(contextID string, event events.Event) error {
 
@@ -240,6 +382,10 @@ func (t *trireme) HandlePUEvent(contextID string, event events.Event) error {
 		return t.doHandleCreate(contextID)
 	case events.EventStop:
 		return t.doHandleDelete(contextID)
+	case events.EventUpdate:
+		return t.doHandleUpdate(contextID)
+	case events.EventQuarantine:
+		return t.doHandleQuarantine(contextID)
 	default:
 		return nil
 	}
@@ -345,17 +491,40 @@ func (t *trireme) doHandleCreate(contextID string) error {
 		return nil
 	}
 
-	if err := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Enforce(contextID, containerInfo); err != nil {
+	if err := t.setupPU(contextID, containerInfo); err != nil {
+		return err
+	}
+
+	t.config.collector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		IPAddress: runtimeInfo.IPAddresses(),
+		Tags:      containerInfo.Policy.Annotations(),
+		Event:     collector.ContainerStart,
+	})
+
+	return nil
+}
+
+// setupPU enforces and supervises the policy of a new PU, and records it. It
+// must be called with the lock of the runtime held.
+func (t *trireme) setupPU(contextID string, containerInfo *policy.PUInfo) error {
+
+	enforcedInfo := t.enforcedPUInfo(contextID, containerInfo)
+
+	if err := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Enforce(contextID, enforcedInfo); err != nil {
 		t.config.collector.CollectContainerEvent(&collector.ContainerRecord{
 			ContextID: contextID,
-			IPAddress: runtimeInfo.IPAddresses(),
-			Tags:      policyInfo.Annotations(),
+			IPAddress: containerInfo.Runtime.IPAddresses(),
+			Tags:      containerInfo.Policy.Annotations(),
 			Event:     collector.ContainerFailed,
 		})
 		return fmt.Errorf("unable to setup enforcer: %s", err)
 	}
 
-	if err := t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Supervise(contextID, containerInfo); err != nil {
+	ctx, cancel := t.callContext()
+	defer cancel()
+
+	if err := t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Supervise(ctx, contextID, enforcedInfo); err != nil {
 		if werr := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Unenforce(contextID); werr != nil {
 			zap.L().Warn("Failed to clean up state after failures",
 				zap.String("contextID", contextID),
@@ -365,19 +534,60 @@ func (t *trireme) doHandleCreate(contextID string) error {
 
 		t.config.collector.CollectContainerEvent(&collector.ContainerRecord{
 			ContextID: contextID,
-			IPAddress: runtimeInfo.IPAddresses(),
-			Tags:      policyInfo.Annotations(),
+			IPAddress: containerInfo.Runtime.IPAddresses(),
+			Tags:      containerInfo.Policy.Annotations(),
 			Event:     collector.ContainerFailed,
 		})
 
 		return fmt.Errorf("unable to setup supervisor: %s", err)
 	}
 
+	t.recordPolicy(contextID, containerInfo.Policy)
+
+	return nil
+}
+
+// doHandleQuarantine enforces the quarantine policy of a PU whose policy could
+// not be set: all its flows are rejected, and the PU keeps running. The policy
+// of a PU that is already enforced is updated in place.
+func (t *trireme) doHandleQuarantine(contextID string) error {
+
+	runtimeReader, err := t.PURuntime(contextID)
+	if err != nil {
+		return fmt.Errorf("quarantine failed: runtime for context id %s not found", contextID)
+	}
+
+	runtime := runtimeReader.(*policy.PURuntime)
+	// Serialize operations
+	runtime.GlobalLock.Lock()
+	defer runtime.GlobalLock.Unlock()
+
+	policyInfo := policy.NewQuarantinePUPolicy(contextID, runtime.IPAddresses(), t.config.targetNetworks)
+
+	if len(t.policyVersions(contextID)) > 0 {
+		if err := t.updatePolicy(contextID, runtime, policyInfo); err != nil {
+			return fmt.Errorf("quarantine failed: %s", err)
+		}
+	} else {
+		containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, policyInfo, runtime)
+		if containerInfo.Runtime.Options().ProxyPort == "" {
+			newOptions := containerInfo.Runtime.Options()
+			newOptions.ProxyPort = t.port.Allocate()
+			containerInfo.Runtime.SetOptions(newOptions)
+		}
+
+		addTransmitterLabel(contextID, containerInfo)
+
+		if err := t.setupPU(contextID, containerInfo); err != nil {
+			return fmt.Errorf("quarantine failed: %s", err)
+		}
+	}
+
 	t.config.collector.CollectContainerEvent(&collector.ContainerRecord{
 		ContextID: contextID,
-		IPAddress: runtimeInfo.IPAddresses(),
-		Tags:      containerInfo.Policy.Annotations(),
-		Event:     collector.ContainerStart,
+		IPAddress: runtime.IPAddresses(),
+		Tags:      policyInfo.Annotations(),
+		Event:     collector.ContainerQuarantined,
 	})
 
 	return nil
@@ -402,7 +612,10 @@ func (t *trireme) doHandleDelete(contextID string) error {
 	runtime.GlobalLock.Lock()
 	defer runtime.GlobalLock.Unlock()
 
-	errS := t.supervisors[t.puTypeToEnforcerType[runtime.PUType()]].Unsupervise(contextID)
+	ctx, cancel := t.callContext()
+	defer cancel()
+
+	errS := t.supervisors[t.puTypeToEnforcerType[runtime.PUType()]].Unsupervise(ctx, contextID)
 	errE := t.enforcers[t.puTypeToEnforcerType[runtime.PUType()]].Unenforce(contextID)
 	port := runtime.Options().ProxyPort
 	zap.L().Debug("Releasing Port", zap.String("Port", port))
@@ -413,6 +626,7 @@ func (t *trireme) doHandleDelete(contextID string) error {
 			zap.Error(err),
 		)
 	}
+	t.policies.Remove(contextID) // nolint
 
 	if errS != nil || errE != nil {
 		t.config.collector.CollectContainerEvent(&collector.ContainerRecord{
@@ -451,6 +665,38 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		zap.L().Error("PU Already Deleted do nothing", zap.String("contextID", contextID))
 		return err
 	}
+
+	return t.updatePolicy(contextID, runtime, newPolicy)
+}
+
+// doHandleUpdate resolves the policy of a running PU again after a change of
+// its runtime and updates the enforcer and the supervisor in place.
+func (t *trireme) doHandleUpdate(contextID string) error {
+
+	runtimeReader, err := t.PURuntime(contextID)
+	if err != nil {
+		return fmt.Errorf("update failed: runtime for context id %s not found", contextID)
+	}
+
+	runtime := runtimeReader.(*policy.PURuntime)
+	// Serialize operations
+	runtime.GlobalLock.Lock()
+	defer runtime.GlobalLock.Unlock()
+
+	policyInfo, err := t.config.resolver.ResolvePolicy(contextID, runtime)
+	if err != nil || policyInfo == nil {
+		return fmt.Errorf("policy error for %s: %s", contextID, err)
+	}
+
+	t.mergeRuntimeAndPolicy(runtime, policyInfo)
+
+	return t.updatePolicy(contextID, runtime, policyInfo)
+}
+
+// updatePolicy applies a new policy to a running PU. It must be called with
+// the lock of the runtime held.
+func (t *trireme) updatePolicy(contextID string, runtime *policy.PURuntime, newPolicy *policy.PUPolicy) (err error) {
+
 	containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, newPolicy, runtime)
 
 	addTransmitterLabel(contextID, containerInfo)
@@ -459,7 +705,9 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		return nil
 	}
 
-	if err = t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Enforce(contextID, containerInfo); err != nil {
+	enforcedInfo := t.enforcedPUInfo(contextID, containerInfo)
+
+	if err = t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Enforce(contextID, enforcedInfo); err != nil {
 		//We lost communication with the remote and killed it lets restart it here by feeding a create event in the request channel
 		zap.L().Warn("Re-initializing enforcers - connection lost")
 		if containerInfo.Runtime.PUType() == constants.ContainerPU {
@@ -471,7 +719,10 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 					return err
 				}
 
-				if lerr := t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Unsupervise(contextID); lerr != nil {
+				ctx, cancel := t.callContext()
+				lerr := t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Unsupervise(ctx, contextID)
+				cancel()
+				if lerr != nil {
 					return err
 				}
 
@@ -487,7 +738,10 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		return fmt.Errorf("enforcer failed to update policy for pu %s: %s", contextID, err)
 	}
 
-	if err = t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Supervise(contextID, containerInfo); err != nil {
+	ctx, cancel := t.callContext()
+	defer cancel()
+
+	if err = t.supervisors[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Supervise(ctx, contextID, enforcedInfo); err != nil {
 		if werr := t.enforcers[t.puTypeToEnforcerType[containerInfo.Runtime.PUType()]].Unenforce(contextID); werr != nil {
 			zap.L().Warn("Failed to clean up after enforcerments failures",
 				zap.String("contextID", contextID),
@@ -497,6 +751,8 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		return fmt.Errorf("supervisor failed to update policy for pu %s: %s", contextID, err)
 	}
 
+	t.recordPolicy(contextID, containerInfo.Policy)
+
 	t.config.collector.CollectContainerEvent(&collector.ContainerRecord{
 		ContextID: contextID,
 		IPAddress: runtime.IPAddresses(),
@@ -507,6 +763,169 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 	return nil
 }
 
+// enforcedPUInfo returns the PU information given to the enforcer and the
+// supervisor. In observe only mode, the policy is replaced by its observe only
+// version, and the policy of the PU is recorded as it was resolved.
+func (t *trireme) enforcedPUInfo(contextID string, containerInfo *policy.PUInfo) *policy.PUInfo {
+
+	if !t.config.observeOnly {
+		return containerInfo
+	}
+
+	return policy.PUInfoFromPolicyAndRuntime(contextID, containerInfo.Policy.ObserveOnly(), containerInfo.Runtime)
+}
+
+// recordPolicy records the policy enforced for a PU with its next version and
+// drops the versions beyond the configured history. It must be called with the
+// lock of the runtime held.
+func (t *trireme) recordPolicy(contextID string, p *policy.PUPolicy) {
+
+	versions := t.policyVersions(contextID)
+
+	version := 1
+	if len(versions) > 0 {
+		version = versions[len(versions)-1].version + 1
+	}
+
+	versions = append(versions, &puPolicy{
+		policy:  p,
+		version: version,
+	})
+
+	if t.config.policyHistory > 0 && len(versions) > t.config.policyHistory {
+		versions = versions[len(versions)-t.config.policyHistory:]
+	}
+
+	t.policies.AddOrUpdate(contextID, versions)
+}
+
+// policyVersions returns the cached versions of the policy of a PU
+func (t *trireme) policyVersions(contextID string) []*puPolicy {
+
+	data, err := t.policies.Get(contextID)
+	if err != nil {
+		return nil
+	}
+
+	versions := data.([]*puPolicy)
+
+	return append([]*puPolicy{}, versions...)
+}
+
+// policyVersion returns a cached version of the policy of a PU
+func (t *trireme) policyVersion(contextID string, version int) (*puPolicy, error) {
+
+	for _, v := range t.policyVersions(contextID) {
+		if v.version == version {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("version %d of the policy of pu %s is not available", version, contextID)
+}
+
+// ListPUs returns the state of the PUs that are enforced
+func (t *trireme) ListPUs() []*PUState {
+
+	states := []*PUState{}
+
+	for _, key := range t.policies.KeyList() {
+		state, err := t.GetPUPolicy(key.(string))
+		if err != nil {
+			// The PU was deleted in the meantime
+			continue
+		}
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ContextID < states[j].ContextID
+	})
+
+	return states
+}
+
+// GetPUPolicy returns the state of an enforced PU with its policy
+func (t *trireme) GetPUPolicy(contextID string) (*PUState, error) {
+
+	versions := t.policyVersions(contextID)
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("pu %s is not enforced", contextID)
+	}
+	enforced := versions[len(versions)-1]
+
+	runtimeReader, err := t.PURuntime(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("pu %s is not enforced", contextID)
+	}
+
+	return &PUState{
+		ContextID:     contextID,
+		Runtime:       runtimeReader.(*policy.PURuntime).Clone(),
+		Policy:        enforced.policy.Clone(),
+		PolicyVersion: enforced.version,
+	}, nil
+}
+
+// DiffPolicy returns the changes from the version v1 of the policy of a PU to
+// the version v2
+func (t *trireme) DiffPolicy(contextID string, v1, v2 int) (policy.PUPolicyDiff, error) {
+
+	old, err := t.policyVersion(contextID, v1)
+	if err != nil {
+		return nil, err
+	}
+
+	new, err := t.policyVersion(contextID, v2)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy.DiffPUPolicy(old.policy, new.policy), nil
+}
+
+// RollbackPolicy enforces again the version of the policy of a PU before the
+// current one. The policy is enforced as a new version and the current one is
+// dropped, so that successive rollbacks go back through the history.
+func (t *trireme) RollbackPolicy(contextID string) error {
+
+	runtimeReader, err := t.PURuntime(contextID)
+	if err != nil {
+		return fmt.Errorf("policy rollback failed: runtime for context id %s not found", contextID)
+	}
+
+	runtime := runtimeReader.(*policy.PURuntime)
+	// Serialize operations
+	runtime.GlobalLock.Lock()
+	defer runtime.GlobalLock.Unlock()
+
+	versions := t.policyVersions(contextID)
+	if len(versions) < 2 {
+		return fmt.Errorf("policy rollback failed: no previous policy for pu %s", contextID)
+	}
+
+	current := versions[len(versions)-1]
+	previous := versions[len(versions)-2]
+
+	if err := t.updatePolicy(contextID, runtime, previous.policy.Clone()); err != nil {
+		return fmt.Errorf("policy rollback failed: %s", err)
+	}
+
+	// The new version replaces both the current and the previous ones
+	kept := []*puPolicy{}
+	for _, v := range t.policyVersions(contextID) {
+		if v.version != current.version && v.version != previous.version {
+			kept = append(kept, v)
+		}
+	}
+
+	if len(kept) > 0 && kept[len(kept)-1].version > current.version {
+		t.policies.AddOrUpdate(contextID, kept)
+	}
+
+	return nil
+}
+
 // Supervisor returns the Trireme supervisor for the given PU Type
 func (t *trireme) Supervisor(kind constants.PUType) supervisor.Supervisor {
 
@@ -516,7 +935,106 @@ func (t *trireme) Supervisor(kind constants.PUType) supervisor.Supervisor {
 	return nil
 }
 
+// Diagnose runs the self checks of the installation with the configured secrets
+func (t *trireme) Diagnose() *diagnostics.Report {
+
+	t.secretsLock.RLock()
+	secret := t.config.secret
+	t.secretsLock.RUnlock()
+
+	return diagnostics.Run(t.config.serverID, secret)
+}
+
+// GetStats returns the counters of the netfilter queues of the enforcers and of
+// the packets they dropped for each PU. The counters of the remote enforcers
+// are the last ones they reported.
+func (t *trireme) GetStats() []*collector.QueueStatsRecord {
+
+	records := []*collector.QueueStatsRecord{}
+	for _, e := range t.enforcers {
+		if reporter, ok := e.(policyenforcer.StatsReporter); ok {
+			records = append(records, reporter.GetStats()...)
+		}
+	}
+
+	return records
+}
+
+// UpdateFilterQueue resizes or rebalances the netfilter queues of the
+// enforcement of linux processes. The queues of the enforcer are started again
+// with the new configuration before the rules of the supervisor send packets to
+// them. The remote enforcers keep their queues.
+func (t *trireme) UpdateFilterQueue(fq *fqconfig.FilterQueue) error {
+
+	if fq == nil {
+		return errors.New("filter queue cannot be nil")
+	}
+
+	e, ok := t.enforcers[constants.LocalServer].(policyenforcer.FilterQueueUpdater)
+	if !ok {
+		return errors.New("the filter queues can only be updated for the enforcement of linux processes")
+	}
+
+	s, ok := t.supervisors[constants.LocalServer].(supervisor.FilterQueueUpdater)
+	if !ok {
+		return errors.New("the supervisor of linux processes cannot update the filter queues")
+	}
+
+	if err := e.UpdateFilterQueue(fq); err != nil {
+		return err
+	}
+
+	return s.UpdateFilterQueue(fq)
+}
+
+// SetLogForwarding sets the level of the logs the remote enforcer of a PU
+// forwards to the controller, like debug to troubleshoot the PU. The logs are
+// not forwarded if the level is empty.
+func (t *trireme) SetLogForwarding(contextID string, level string) error {
+
+	forwarder, ok := t.enforcers[constants.RemoteContainer].(policyenforcer.LogForwarder)
+	if !ok {
+		return errors.New("the logs can only be forwarded by remote enforcers")
+	}
+
+	return forwarder.SetLogForwarding(contextID, level)
+}
+
+// SetLogLevel sets the level of the logs of the remote enforcer of a PU, like
+// debug to troubleshoot the PU, and info to lower it again. The remote
+// enforcer is shared by the PUs of the network namespace of the PU.
+func (t *trireme) SetLogLevel(contextID string, level string) error {
+
+	setter, ok := t.enforcers[constants.RemoteContainer].(policyenforcer.LogLevelSetter)
+	if !ok {
+		return errors.New("the log level can only be set for remote enforcers")
+	}
+
+	return setter.SetLogLevel(contextID, level)
+}
+
+// SetDebugListener starts or stops the debug listener of the remote enforcer of
+// a PU. It is an HTTP server on a unix socket next to the RPC socket of the
+// remote enforcer, like /var/run/pu1-debug.sock, only accessible to root.
+func (t *trireme) SetDebugListener(contextID string, enable bool) error {
+
+	listener, ok := t.enforcers[constants.RemoteContainer].(policyenforcer.DebugListener)
+	if !ok {
+		return errors.New("the debug listener is only available for remote enforcers")
+	}
+
+	return listener.SetDebugListener(contextID, enable)
+}
+
+// UpdateSecrets updates the secrets of the enforcers. The revoked tokens and
+// certificates of the current secrets are also revoked by the new secrets.
 func (t *trireme) UpdateSecrets(secrets secrets.Secrets) error {
+
+	t.secretsLock.Lock()
+	carryRevocations(t.config.secret, secrets)
+	t.config.secret = secrets
+	t.secretsLock.Unlock()
+
 	for _, enforcer := range t.enforcers {
 		if err := enforcer.UpdateSecrets(secrets); err != nil {
 			zap.L().Error("unable to update secrets", zap.Error(err))
@@ -525,6 +1043,37 @@ func (t *trireme) UpdateSecrets(secrets secrets.Secrets) error {
 	return nil
 }
 
+// RevokeToken rejects the token of a compromised enforcer in all the enforcers.
+// Remote enforcers will get the revocation with the next policy push.
+func (t *trireme) RevokeToken(token []byte) error {
+
+	t.secretsLock.RLock()
+	defer t.secretsLock.RUnlock()
+
+	revoker, ok := t.config.secret.(secrets.Revoker)
+	if !ok {
+		return errors.New("secrets do not support revocations")
+	}
+
+	return revoker.Revocations().RevokeToken(token)
+}
+
+// carryRevocations keeps the revocations of the current secrets in the new ones
+func carryRevocations(current, next secrets.Secrets) {
+
+	from, ok := current.(secrets.Revoker)
+	if !ok {
+		return
+	}
+
+	to, ok := next.(secrets.Revoker)
+	if !ok || from == to {
+		return
+	}
+
+	to.SetRevocations(from.Revocations())
+}
+
 // Supervisors returns a slice of all initialized supervisors.
 func Supervisors(t Trireme) []supervisor.Supervisor {
 